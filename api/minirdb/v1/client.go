@@ -0,0 +1,306 @@
+// Package minirdbv1 は minirdb.proto の Database サービスの gRPC のクライアントとサーバーです。
+// モジュールは標準ライブラリだけに依存するので、メッセージの型と protobuf の符号化は protoc で生成せずに
+// 手で書いています（arrow の FlatBuffers と同じです）。通信は net/http の HTTP/2 で行い、圧縮には
+// 対応しません。ほかの言語の gRPC のクライアントとサーバーとも、minirdb.proto から生成したコードで
+// やり取りできます。
+//
+//	c := minirdbv1.NewClient("localhost:9090", minirdbv1.Options{User: "app", Password: pw})
+//	defer c.Close()
+//	resp, err := c.Execute(ctx, &minirdbv1.ExecuteRequest{SQL: "DELETE FROM logs"})
+//
+// サーバーは DatabaseServer を実装して NewHandler に渡します。minirdb serve の -grpc-listen は
+// internal/server の実装を使います。
+package minirdbv1
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Options はクライアントの設定です。ゼロ値は既定の設定です。
+type Options struct {
+	// User と Password は要求ごとに Basic 認証（authorization のメタデータ）で送るユーザーの名前と
+	// パスワードです。User が空なら送りません。
+	User     string
+	Password string
+	// TLSConfig は接続を暗号化する TLS の設定です。nil なら暗号化せずに HTTP/2 で接続します。
+	TLSConfig *tls.Config
+}
+
+// Client は Database サービスのクライアントです。1つの HTTP/2 の接続で要求を多重化するので、
+// 複数のゴルーチンから使えます。
+type Client struct {
+	base string // "http://host:port" か "https://host:port"
+	opts Options
+	hc   *http.Client
+}
+
+// NewClient はアドレス addr（host:port）のサーバーのクライアントを返します。接続は最初の要求で
+// 開きます。
+func NewClient(addr string, opts Options) *Client {
+	protocols := new(http.Protocols)
+	scheme := "http"
+	if opts.TLSConfig != nil {
+		protocols.SetHTTP2(true)
+		scheme = "https"
+	} else {
+		protocols.SetUnencryptedHTTP2(true)
+	}
+	tr := &http.Transport{TLSClientConfig: opts.TLSConfig, Protocols: protocols}
+	return &Client{base: scheme + "://" + addr, opts: opts, hc: &http.Client{Transport: tr}}
+}
+
+// Close は開いている接続を閉じます。
+func (c *Client) Close() error {
+	c.hc.CloseIdleConnections()
+	return nil
+}
+
+// Execute は行を返さない文を実行し、変更した行の数を返します。
+func (c *Client) Execute(ctx context.Context, req *ExecuteRequest) (*ExecuteResponse, error) {
+	resp := &ExecuteResponse{}
+	return resp, c.unary(ctx, "Execute", req, resp)
+}
+
+// Query は問い合わせを実行します。応答は返した QueryStream の Recv で読みます。
+func (c *Client) Query(ctx context.Context, req *QueryRequest) (*QueryStream, error) {
+	s, err := c.stream(ctx, "Query", req)
+	if err != nil {
+		return nil, err
+	}
+	return &QueryStream{s}, nil
+}
+
+// Begin はサーバーのセッションでトランザクションを開始し、セッションの ID を返します。
+func (c *Client) Begin(ctx context.Context, req *BeginRequest) (*BeginResponse, error) {
+	resp := &BeginResponse{}
+	return resp, c.unary(ctx, "Begin", req, resp)
+}
+
+// Commit はセッションのトランザクションをコミットし、セッションを閉じます。
+func (c *Client) Commit(ctx context.Context, req *EndRequest) (*EndResponse, error) {
+	resp := &EndResponse{}
+	return resp, c.unary(ctx, "Commit", req, resp)
+}
+
+// Rollback はセッションのトランザクションをロールバックし、セッションを閉じます。
+func (c *Client) Rollback(ctx context.Context, req *EndRequest) (*EndResponse, error) {
+	resp := &EndResponse{}
+	return resp, c.unary(ctx, "Rollback", req, resp)
+}
+
+// Backup はサーバーのデータベースを、サーバーのバックアップのディレクトリの中のファイル req.Name に
+// 複製します。進み具合は返した
+// BackupStream の Recv で読みます。
+func (c *Client) Backup(ctx context.Context, req *BackupRequest) (*BackupStream, error) {
+	s, err := c.stream(ctx, "Backup", req)
+	if err != nil {
+		return nil, err
+	}
+	return &BackupStream{s}, nil
+}
+
+// Stats はデータベースを開いてからの統計を返します。
+func (c *Client) Stats(ctx context.Context, req *StatsRequest) (*StatsResponse, error) {
+	resp := &StatsResponse{}
+	return resp, c.unary(ctx, "Stats", req, resp)
+}
+
+// QueryStream は Query の応答を読みます。
+type QueryStream struct{ s *clientStream }
+
+// Recv は次の応答を返します。最初の応答は列の情報で、すべて読み終えると io.EOF を返します。
+func (s *QueryStream) Recv() (*QueryResponse, error) {
+	m := &QueryResponse{}
+	if err := s.s.recv(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Close は読み終えていない応答を捨てて要求を終えます。
+func (s *QueryStream) Close() error { return s.s.close() }
+
+// BackupStream は Backup の進み具合を読みます。
+type BackupStream struct{ s *clientStream }
+
+// Recv は次の進み具合を返します。複製を終えると io.EOF を返します。
+func (s *BackupStream) Recv() (*BackupProgress, error) {
+	m := &BackupProgress{}
+	if err := s.s.recv(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Close は進み具合を読むのをやめて要求を終えます。サーバーは複製を取り消します。
+func (s *BackupStream) Close() error { return s.s.close() }
+
+// unary は応答が1つのメソッドを呼び出します。
+func (c *Client) unary(ctx context.Context, method string, req, resp message) error {
+	s, err := c.stream(ctx, method, req)
+	if err != nil {
+		return err
+	}
+	defer s.close()
+	if err := s.recv(resp); err != nil {
+		if err == io.EOF {
+			return &Error{Code: Internal, Message: "minirdbv1: missing response message"}
+		}
+		return err
+	}
+	if err := s.recv(resp); err != io.EOF {
+		if err == nil {
+			return &Error{Code: Internal, Message: "minirdbv1: too many response messages"}
+		}
+		return err
+	}
+	return nil
+}
+
+// stream はメソッドを呼び出し、応答を読むストリームを返します。
+func (c *Client) stream(ctx context.Context, method string, req message) (*clientStream, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	hr, err := http.NewRequestWithContext(ctx, http.MethodPost, c.base+"/"+ServiceName+"/"+method, bytes.NewReader(frame(req)))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	hr.Header.Set("Content-Type", "application/grpc")
+	hr.Header.Set("Te", "trailers")
+	if deadline, ok := ctx.Deadline(); ok {
+		hr.Header.Set("Grpc-Timeout", formatTimeout(time.Until(deadline)))
+	}
+	if c.opts.User != "" {
+		hr.SetBasicAuth(c.opts.User, c.opts.Password)
+	}
+	resp, err := c.hc.Do(hr)
+	if err != nil {
+		cancel()
+		if ctx.Err() != nil {
+			return nil, contextError(ctx)
+		}
+		return nil, &Error{Code: Unavailable, Message: err.Error()}
+	}
+	s := &clientStream{ctx: ctx, resp: resp, cancel: cancel}
+	if err := s.checkHeader(); err != nil {
+		s.close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// clientStream は1つの要求の応答を読みます。
+type clientStream struct {
+	ctx    context.Context
+	resp   *http.Response
+	cancel context.CancelFunc
+	err    error // 読み終えたか失敗した後に返すエラー
+}
+
+// checkHeader は応答のヘッダーを調べます。HTTP の誤りと、メッセージのない応答（Trailers-Only）の
+// 状態はエラーにします。
+func (s *clientStream) checkHeader() error {
+	h := s.resp.Header
+	if s.resp.StatusCode != http.StatusOK {
+		return &Error{Code: httpCode(s.resp.StatusCode), Message: fmt.Sprintf("minirdbv1: unexpected HTTP status %s", s.resp.Status)}
+	}
+	if ct := h.Get("Content-Type"); !strings.HasPrefix(ct, "application/grpc") {
+		return &Error{Code: Unknown, Message: fmt.Sprintf("minirdbv1: unexpected content type %q", ct)}
+	}
+	if h.Get("Grpc-Status") != "" {
+		return status(h)
+	}
+	return nil
+}
+
+// recv は次のメッセージを m に読みます。応答を読み終えたら状態を調べ、OK なら io.EOF を返します。
+func (s *clientStream) recv(m message) error {
+	if s.err != nil {
+		return s.err
+	}
+	err := readMessage(s.resp.Body, m)
+	switch {
+	case err == nil:
+		return nil
+	case err == io.EOF:
+		s.err = status(s.resp.Trailer)
+		if s.err == nil {
+			s.err = io.EOF
+		}
+	case s.ctx.Err() != nil:
+		s.err = contextError(s.ctx)
+	default:
+		var e *Error
+		if !errors.As(err, &e) {
+			e = &Error{Code: Internal, Message: err.Error()}
+		}
+		s.err = e
+	}
+	s.close()
+	return s.err
+}
+
+func (s *clientStream) close() error {
+	s.cancel()
+	if s.err == nil {
+		s.err = &Error{Code: Canceled, Message: "minirdbv1: stream closed"}
+	}
+	return s.resp.Body.Close()
+}
+
+// status はトレーラー（か Trailers-Only のヘッダー）の状態を返します。OK なら nil です。
+func status(h http.Header) error {
+	v := h.Get("Grpc-Status")
+	if v == "" {
+		return &Error{Code: Internal, Message: "minirdbv1: missing grpc-status"}
+	}
+	code, err := strconv.ParseUint(v, 10, 32)
+	if err != nil {
+		return &Error{Code: Unknown, Message: fmt.Sprintf("minirdbv1: invalid grpc-status %q", v)}
+	}
+	if code == uint64(OK) {
+		return nil
+	}
+	e := &Error{Code: Code(code), Message: decodeMessage(h.Get("Grpc-Message"))}
+	if d := h.Get("Grpc-Status-Details-Bin"); d != "" {
+		if b, err := decodeDetails(d); err == nil {
+			e.Reason = statusReason(b)
+		}
+	}
+	return e
+}
+
+// httpCode は gRPC の応答でない HTTP の状態コードに対応する状態コードを返します。
+func httpCode(status int) Code {
+	switch status {
+	case http.StatusBadRequest:
+		return Internal
+	case http.StatusUnauthorized:
+		return Unauthenticated
+	case http.StatusForbidden:
+		return PermissionDenied
+	case http.StatusNotFound:
+		return Unimplemented
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return Unavailable
+	default:
+		return Unknown
+	}
+}
+
+// contextError は取り消された ctx の状態を返します。
+func contextError(ctx context.Context) error {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return &Error{Code: DeadlineExceeded, Message: ctx.Err().Error()}
+	}
+	return &Error{Code: Canceled, Message: ctx.Err().Error()}
+}
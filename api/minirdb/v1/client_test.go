@@ -0,0 +1,232 @@
+package minirdbv1
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testServer は要求を記録して決まった応答を返す DatabaseServer です。
+type testServer struct {
+	mu      sync.Mutex
+	execute *ExecuteRequest
+	header  http.Header
+	waited  chan string // "wait" の Execute が取り消されたときの grpc-timeout
+}
+
+func (s *testServer) Execute(ctx context.Context, req *ExecuteRequest) (*ExecuteResponse, error) {
+	if req.SQL == "wait" {
+		<-ctx.Done()
+		s.waited <- IncomingHeader(ctx).Get("Grpc-Timeout")
+		return nil, ctx.Err()
+	}
+	s.mu.Lock()
+	s.execute, s.header = req, IncomingHeader(ctx)
+	s.mu.Unlock()
+	switch req.SQL {
+	case "unique":
+		return nil, &Error{Code: FailedPrecondition, Message: "UNIQUE constraint failed: 100% ☃\n", Reason: "unique"}
+	case "internal":
+		return nil, errors.New("disk on fire")
+	}
+	return &ExecuteResponse{RowsAffected: int64(len(req.Params))}, nil
+}
+
+// Query は列の情報に続けて、SQL の長さと同じ数の行を1行ずつ送ります。
+func (s *testServer) Query(ctx context.Context, req *QueryRequest, send func(*QueryResponse) error) error {
+	if err := send(&QueryResponse{Columns: []*Column{{Name: "n", Type: "INT"}, {Name: "v"}}}); err != nil {
+		return err
+	}
+	for i := range len(req.SQL) {
+		row := &Row{Values: []*Value{{Kind: ValueInt, Int: int64(i)}, {}}}
+		if err := send(&QueryResponse{Rows: []*Row{row}}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *testServer) Begin(ctx context.Context, req *BeginRequest) (*BeginResponse, error) {
+	if !req.ReadOnly {
+		return nil, Errorf(PermissionDenied, "read-only server")
+	}
+	return &BeginResponse{Session: "s1"}, nil
+}
+
+func (s *testServer) Commit(ctx context.Context, req *EndRequest) (*EndResponse, error) {
+	if req.Session != "s1" {
+		return nil, Errorf(NotFound, "no such session: %s", req.Session)
+	}
+	return &EndResponse{}, nil
+}
+
+func (s *testServer) Rollback(ctx context.Context, req *EndRequest) (*EndResponse, error) {
+	return s.Commit(ctx, req)
+}
+
+func (s *testServer) Backup(ctx context.Context, req *BackupRequest, send func(*BackupProgress) error) error {
+	for copied := int64(0); copied < 10; copied += int64(req.PagesPerStep) {
+		if err := send(&BackupProgress{Copied: copied + int64(req.PagesPerStep), Total: 10}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *testServer) Stats(ctx context.Context, req *StatsRequest) (*StatsResponse, error) {
+	return &StatsResponse{PageSize: 4096, CacheHits: 7}, nil
+}
+
+// startServer は srv を HTTP/2 の httptest のサーバーで提供し、そのクライアントを返します。
+// tls が false なら暗号化しない HTTP/2（h2c）で提供します。
+func startServer(t *testing.T, srv DatabaseServer, tls bool) *Client {
+	t.Helper()
+	ts := httptest.NewUnstartedServer(NewHandler(srv))
+	opts := Options{User: "app", Password: "secret"}
+	if tls {
+		ts.EnableHTTP2 = true
+		ts.StartTLS()
+		pool := x509.NewCertPool()
+		pool.AddCert(ts.Certificate())
+		opts.TLSConfig = ts.Client().Transport.(*http.Transport).TLSClientConfig.Clone()
+		opts.TLSConfig.RootCAs = pool
+	} else {
+		ts.Config.Protocols = new(http.Protocols)
+		ts.Config.Protocols.SetUnencryptedHTTP2(true)
+		ts.Start()
+	}
+	c := NewClient(strings.TrimPrefix(strings.TrimPrefix(ts.URL, "https://"), "http://"), opts)
+	t.Cleanup(func() {
+		c.Close()
+		ts.Close()
+	})
+	return c
+}
+
+// errorOf は err を *Error にします。
+func errorOf(t *testing.T, err error) *Error {
+	t.Helper()
+	var e *Error
+	if !errors.As(err, &e) {
+		t.Fatalf("err = %v, want *Error", err)
+	}
+	return e
+}
+
+// TestClientServer は、クライアントが HTTP/2 の httptest のサーバーの NewHandler を呼び出し、要求、応答、
+// ストリーム、状態、メタデータ、期限がそのまま伝わることを確かめます。
+func TestClientServer(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		tls  bool
+	}{{"h2", true}, {"h2c", false}} {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := &testServer{waited: make(chan string, 1)}
+			c := startServer(t, srv, tc.tls)
+			ctx := context.Background()
+
+			req := &ExecuteRequest{SQL: "INSERT", Session: "s1", Params: []*Value{
+				{}, {Kind: ValueInt, Int: -5}, {Kind: ValueReal, Real: 0.5}, {Kind: ValueText, Text: "テキスト"},
+				{Kind: ValueBlob, Blob: []byte{0}}, {Kind: ValueBoolean},
+				{Kind: ValueTimestamp, Timestamp: time.Date(2026, 1, 2, 3, 4, 5, 6, time.UTC)},
+			}}
+			resp, err := c.Execute(ctx, req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			srv.mu.Lock()
+			got, header := srv.execute, srv.header
+			srv.mu.Unlock()
+			if resp.RowsAffected != 7 || !reflect.DeepEqual(got, req) {
+				t.Errorf("Execute: server got %+v and answered %d, want %+v and 7", got, resp.RowsAffected, req)
+			}
+			if r := (&http.Request{Header: header}); r.Header.Get("Authorization") == "" {
+				t.Error("Execute: server got no authorization metadata")
+			} else if user, password, _ := r.BasicAuth(); user != "app" || password != "secret" {
+				t.Errorf("Execute: server got user %q and password %q", user, password)
+			}
+
+			qs, err := c.Query(ctx, &QueryRequest{SQL: "abc"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var msgs []*QueryResponse
+			for {
+				m, err := qs.Recv()
+				if err == io.EOF {
+					break
+				} else if err != nil {
+					t.Fatal(err)
+				}
+				msgs = append(msgs, m)
+			}
+			if len(msgs) != 4 || len(msgs[0].Columns) != 2 || msgs[3].Rows[0].Values[0].Int != 2 {
+				t.Errorf("Query: got %d messages %+v, want the columns and 3 rows", len(msgs), msgs)
+			}
+
+			b, err := c.Begin(ctx, &BeginRequest{ReadOnly: true, Isolation: IsolationSnapshot})
+			if err != nil || b.Session != "s1" {
+				t.Fatalf("Begin = %+v, %v", b, err)
+			}
+			if _, err := c.Commit(ctx, &EndRequest{Session: b.Session}); err != nil {
+				t.Errorf("Commit: %v", err)
+			}
+			if _, err := c.Rollback(ctx, &EndRequest{Session: "s2"}); errorOf(t, err).Code != NotFound {
+				t.Errorf("Rollback of an unknown session: %v, want NotFound", err)
+			}
+			if _, err := c.Begin(ctx, &BeginRequest{}); errorOf(t, err).Code != PermissionDenied {
+				t.Errorf("Begin: %v, want PermissionDenied", err)
+			}
+
+			bs, err := c.Backup(ctx, &BackupRequest{Name: "b.db", PagesPerStep: 4})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var last *BackupProgress
+			for {
+				p, err := bs.Recv()
+				if err == io.EOF {
+					break
+				} else if err != nil {
+					t.Fatal(err)
+				}
+				last = p
+			}
+			if last == nil || last.Copied != 12 || last.Total != 10 {
+				t.Errorf("Backup: last progress = %+v", last)
+			}
+
+			st, err := c.Stats(ctx, &StatsRequest{})
+			if err != nil || st.PageSize != 4096 || st.CacheHits != 7 {
+				t.Errorf("Stats = %+v, %v", st, err)
+			}
+
+			_, err = c.Execute(ctx, &ExecuteRequest{SQL: "unique"})
+			if e := errorOf(t, err); *e != (Error{Code: FailedPrecondition, Message: "UNIQUE constraint failed: 100% ☃\n", Reason: "unique"}) {
+				t.Errorf("Execute error = %+v", e)
+			}
+			_, err = c.Execute(ctx, &ExecuteRequest{SQL: "internal"})
+			if e := errorOf(t, err); e.Code != Internal || e.Message != "disk on fire" {
+				t.Errorf("Execute error = %+v, want Internal", e)
+			}
+
+			dctx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+			defer cancel()
+			_, err = c.Execute(dctx, &ExecuteRequest{SQL: "wait"})
+			if e := errorOf(t, err); e.Code != DeadlineExceeded {
+				t.Errorf("Execute past the deadline = %+v, want DeadlineExceeded", e)
+			}
+			if timeout := <-srv.waited; timeout == "" {
+				t.Error("Execute with a deadline: server got no grpc-timeout")
+			}
+		})
+	}
+}
@@ -0,0 +1,519 @@
+package minirdbv1
+
+import (
+	"fmt"
+	"time"
+)
+
+// ValueKind は Value にどの値を設定したか（minirdb.proto の oneof kind）です。
+type ValueKind int
+
+const (
+	ValueNull ValueKind = iota // 何も設定していない（NULL）
+	ValueInt
+	ValueReal
+	ValueText
+	ValueBlob
+	ValueBoolean
+	ValueTimestamp
+)
+
+// Value は文の引数と結果の列の値です。Kind のフィールドだけが意味を持ちます。
+type Value struct {
+	Kind      ValueKind
+	Int       int64
+	Real      float64
+	Text      string
+	Blob      []byte
+	Boolean   bool
+	Timestamp time.Time
+}
+
+// ValueOf は Go の値 x（int64 などの整数、float64、string、[]byte、bool、time.Time、nil）を
+// Value にします。
+func ValueOf(x any) (*Value, error) {
+	switch x := x.(type) {
+	case nil:
+		return &Value{}, nil
+	case int:
+		return &Value{Kind: ValueInt, Int: int64(x)}, nil
+	case int32:
+		return &Value{Kind: ValueInt, Int: int64(x)}, nil
+	case int64:
+		return &Value{Kind: ValueInt, Int: x}, nil
+	case float32:
+		return &Value{Kind: ValueReal, Real: float64(x)}, nil
+	case float64:
+		return &Value{Kind: ValueReal, Real: x}, nil
+	case string:
+		return &Value{Kind: ValueText, Text: x}, nil
+	case []byte:
+		return &Value{Kind: ValueBlob, Blob: x}, nil
+	case bool:
+		return &Value{Kind: ValueBoolean, Boolean: x}, nil
+	case time.Time:
+		return &Value{Kind: ValueTimestamp, Timestamp: x}, nil
+	default:
+		return nil, fmt.Errorf("minirdbv1: unsupported value type %T", x)
+	}
+}
+
+// Go は値を Go の値（int64, float64, string, []byte, bool, time.Time、NULL なら nil）にして返します。
+func (v *Value) Go() any {
+	if v == nil {
+		return nil
+	}
+	switch v.Kind {
+	case ValueInt:
+		return v.Int
+	case ValueReal:
+		return v.Real
+	case ValueText:
+		return v.Text
+	case ValueBlob:
+		return v.Blob
+	case ValueBoolean:
+		return v.Boolean
+	case ValueTimestamp:
+		return v.Timestamp
+	default:
+		return nil
+	}
+}
+
+func (v *Value) Marshal() []byte {
+	if v == nil {
+		return nil
+	}
+	var b []byte
+	switch v.Kind {
+	case ValueInt:
+		b = appendVarint(b, 1, uint64(v.Int))
+	case ValueReal:
+		b = appendDouble(b, 2, v.Real)
+	case ValueText:
+		b = appendBytes(b, 3, []byte(v.Text))
+	case ValueBlob:
+		b = appendBytes(b, 4, v.Blob)
+	case ValueBoolean:
+		var x uint64
+		if v.Boolean {
+			x = 1
+		}
+		b = appendVarint(b, 5, x)
+	case ValueTimestamp:
+		b = appendBytes(b, 6, marshalTimestamp(v.Timestamp))
+	}
+	return b
+}
+
+func (v *Value) Unmarshal(b []byte) error {
+	*v = Value{}
+	return readFields(b, func(f field) (err error) {
+		var x uint64
+		switch f.num {
+		case 1:
+			x, err = f.varint()
+			*v = Value{Kind: ValueInt, Int: int64(x)}
+		case 2:
+			*v = Value{Kind: ValueReal}
+			v.Real, err = f.double()
+		case 3:
+			*v = Value{Kind: ValueText}
+			v.Text, err = f.text()
+		case 4:
+			*v = Value{Kind: ValueBlob}
+			v.Blob, err = f.bytes()
+			v.Blob = append([]byte{}, v.Blob...)
+		case 5:
+			x, err = f.varint()
+			*v = Value{Kind: ValueBoolean, Boolean: x != 0}
+		case 6:
+			var ts []byte
+			ts, err = f.bytes()
+			if err == nil {
+				*v = Value{Kind: ValueTimestamp}
+				v.Timestamp, err = unmarshalTimestamp(ts)
+			}
+		}
+		return err
+	})
+}
+
+// marshalTimestamp は t を google.protobuf.Timestamp（seconds = 1, nanos = 2）にします。
+func marshalTimestamp(t time.Time) []byte {
+	b := appendInt(nil, 1, t.Unix())
+	return appendInt(b, 2, int64(t.Nanosecond()))
+}
+
+// unmarshalTimestamp は google.protobuf.Timestamp を UTC の時刻にします。
+func unmarshalTimestamp(b []byte) (time.Time, error) {
+	var sec, nsec int64
+	err := readFields(b, func(f field) (err error) {
+		var x uint64
+		switch f.num {
+		case 1:
+			x, err = f.varint()
+			sec = int64(x)
+		case 2:
+			x, err = f.varint()
+			nsec = int64(int32(x))
+		}
+		return err
+	})
+	if err != nil {
+		return time.Time{}, err
+	}
+	if nsec < 0 || nsec >= 1e9 {
+		return time.Time{}, fmt.Errorf("minirdbv1: invalid timestamp nanos: %d", nsec)
+	}
+	return time.Unix(sec, nsec).UTC(), nil
+}
+
+// appendValues は繰り返しの Value のフィールドを書きます。nil の要素は NULL です。
+func appendValues(b []byte, num int, vs []*Value) []byte {
+	for _, v := range vs {
+		b = appendBytes(b, num, v.Marshal())
+	}
+	return b
+}
+
+// readValue は Value のフィールドを読んで vs に加えます。
+func readValue(f field, vs []*Value) ([]*Value, error) {
+	v := &Value{}
+	if err := f.message(v); err != nil {
+		return vs, err
+	}
+	return append(vs, v), nil
+}
+
+// ExecuteRequest は Execute の要求です。
+type ExecuteRequest struct {
+	SQL    string
+	Params []*Value
+	// Session は Begin が返したセッションの ID です。空なら文ごとに自動コミットします。
+	Session string
+}
+
+func (m *ExecuteRequest) Marshal() []byte {
+	b := appendString(nil, 1, m.SQL)
+	b = appendValues(b, 2, m.Params)
+	return appendString(b, 3, m.Session)
+}
+
+func (m *ExecuteRequest) Unmarshal(b []byte) error {
+	*m = ExecuteRequest{}
+	return readFields(b, func(f field) (err error) {
+		switch f.num {
+		case 1:
+			m.SQL, err = f.text()
+		case 2:
+			m.Params, err = readValue(f, m.Params)
+		case 3:
+			m.Session, err = f.text()
+		}
+		return err
+	})
+}
+
+// ExecuteResponse は Execute の応答です。
+type ExecuteResponse struct {
+	RowsAffected int64
+}
+
+func (m *ExecuteResponse) Marshal() []byte { return appendInt(nil, 1, m.RowsAffected) }
+
+func (m *ExecuteResponse) Unmarshal(b []byte) error {
+	*m = ExecuteResponse{}
+	return readFields(b, func(f field) (err error) {
+		if f.num == 1 {
+			var x uint64
+			x, err = f.varint()
+			m.RowsAffected = int64(x)
+		}
+		return err
+	})
+}
+
+// QueryRequest は Query の要求です。
+type QueryRequest struct {
+	SQL     string
+	Params  []*Value
+	Session string // Begin が返したセッションの ID（ExecuteRequest.Session）
+}
+
+func (m *QueryRequest) Marshal() []byte {
+	return (*ExecuteRequest)(m).Marshal()
+}
+
+func (m *QueryRequest) Unmarshal(b []byte) error {
+	return (*ExecuteRequest)(m).Unmarshal(b)
+}
+
+// Column は結果の列の情報です。
+type Column struct {
+	Name string
+	// Type は列の SQL の型（INT、TEXT など）です。型の決まらない式の列なら空です。
+	Type string
+}
+
+func (m *Column) Marshal() []byte {
+	return appendString(appendString(nil, 1, m.Name), 2, m.Type)
+}
+
+func (m *Column) Unmarshal(b []byte) error {
+	*m = Column{}
+	return readFields(b, func(f field) (err error) {
+		switch f.num {
+		case 1:
+			m.Name, err = f.text()
+		case 2:
+			m.Type, err = f.text()
+		}
+		return err
+	})
+}
+
+// Row は結果の1行です。
+type Row struct {
+	Values []*Value
+}
+
+func (m *Row) Marshal() []byte { return appendValues(nil, 1, m.Values) }
+
+func (m *Row) Unmarshal(b []byte) error {
+	*m = Row{}
+	return readFields(b, func(f field) (err error) {
+		if f.num == 1 {
+			m.Values, err = readValue(f, m.Values)
+		}
+		return err
+	})
+}
+
+// QueryResponse は Query が送る応答です。最初の応答だけが Columns を持ち、以降は Rows を持ちます。
+type QueryResponse struct {
+	Columns []*Column
+	Rows    []*Row
+}
+
+func (m *QueryResponse) Marshal() []byte {
+	var b []byte
+	for _, c := range m.Columns {
+		b = appendBytes(b, 1, c.Marshal())
+	}
+	for _, r := range m.Rows {
+		b = appendBytes(b, 2, r.Marshal())
+	}
+	return b
+}
+
+func (m *QueryResponse) Unmarshal(b []byte) error {
+	*m = QueryResponse{}
+	return readFields(b, func(f field) error {
+		switch f.num {
+		case 1:
+			c := &Column{}
+			m.Columns = append(m.Columns, c)
+			return f.message(c)
+		case 2:
+			r := &Row{}
+			m.Rows = append(m.Rows, r)
+			return f.message(r)
+		}
+		return nil
+	})
+}
+
+// Isolation は rdbms.Isolation と同じ分離レベルです。
+type Isolation int32
+
+const (
+	IsolationLocking      Isolation = 0
+	IsolationSnapshot     Isolation = 1
+	IsolationSerializable Isolation = 2
+	IsolationOptimistic   Isolation = 3
+)
+
+// BeginRequest は Begin の要求です。
+type BeginRequest struct {
+	Isolation Isolation
+	ReadOnly  bool
+}
+
+func (m *BeginRequest) Marshal() []byte {
+	return appendBool(appendInt(nil, 1, int64(m.Isolation)), 2, m.ReadOnly)
+}
+
+func (m *BeginRequest) Unmarshal(b []byte) error {
+	*m = BeginRequest{}
+	return readFields(b, func(f field) (err error) {
+		var x uint64
+		switch f.num {
+		case 1:
+			x, err = f.varint()
+			m.Isolation = Isolation(int32(x))
+		case 2:
+			x, err = f.varint()
+			m.ReadOnly = x != 0
+		}
+		return err
+	})
+}
+
+// BeginResponse は Begin の応答です。
+type BeginResponse struct {
+	Session string
+}
+
+func (m *BeginResponse) Marshal() []byte { return appendString(nil, 1, m.Session) }
+
+func (m *BeginResponse) Unmarshal(b []byte) error {
+	*m = BeginResponse{}
+	return readFields(b, func(f field) (err error) {
+		if f.num == 1 {
+			m.Session, err = f.text()
+		}
+		return err
+	})
+}
+
+// EndRequest は Commit と Rollback の要求です。
+type EndRequest struct {
+	Session string
+}
+
+func (m *EndRequest) Marshal() []byte { return (*BeginResponse)(m).Marshal() }
+
+func (m *EndRequest) Unmarshal(b []byte) error { return (*BeginResponse)(m).Unmarshal(b) }
+
+// EndResponse は Commit と Rollback の応答です。
+type EndResponse struct{}
+
+func (m *EndResponse) Marshal() []byte { return nil }
+
+func (m *EndResponse) Unmarshal(b []byte) error {
+	return readFields(b, func(field) error { return nil })
+}
+
+// BackupRequest は Backup の要求です。
+type BackupRequest struct {
+	// Name は複製を書くデータベースのファイル名です。サーバーはこれをサーバーに設定したバックアップの
+	// ディレクトリの中に作ります。ディレクトリを含む名前、絶対パスと ".." は受け付けません。
+	Name string
+	// PagesPerStep は1ステップで複製するページの数です。0 ならすべてを一度に複製します。
+	PagesPerStep int32
+}
+
+func (m *BackupRequest) Marshal() []byte {
+	return appendInt(appendString(nil, 1, m.Name), 2, int64(m.PagesPerStep))
+}
+
+func (m *BackupRequest) Unmarshal(b []byte) error {
+	*m = BackupRequest{}
+	return readFields(b, func(f field) (err error) {
+		switch f.num {
+		case 1:
+			m.Name, err = f.text()
+		case 2:
+			var x uint64
+			x, err = f.varint()
+			m.PagesPerStep = int32(x)
+		}
+		return err
+	})
+}
+
+// BackupProgress は Backup が1ステップごとに送る進み具合です。
+type BackupProgress struct {
+	Copied int64 // 複製したページの数
+	Total  int64 // 全体のページの数
+}
+
+func (m *BackupProgress) Marshal() []byte {
+	return appendInt(appendInt(nil, 1, m.Copied), 2, m.Total)
+}
+
+func (m *BackupProgress) Unmarshal(b []byte) error {
+	*m = BackupProgress{}
+	return readFields(b, func(f field) (err error) {
+		var x uint64
+		switch f.num {
+		case 1:
+			x, err = f.varint()
+			m.Copied = int64(x)
+		case 2:
+			x, err = f.varint()
+			m.Total = int64(x)
+		}
+		return err
+	})
+}
+
+// StatsRequest は Stats の要求です。
+type StatsRequest struct{}
+
+func (m *StatsRequest) Marshal() []byte { return nil }
+
+func (m *StatsRequest) Unmarshal(b []byte) error {
+	return readFields(b, func(field) error { return nil })
+}
+
+// StatsResponse は Stats の応答で、rdbms.Stats と同じ統計です。
+type StatsResponse struct {
+	PageSize           int32
+	PageReads          uint64
+	CachedReads        uint64
+	CacheHits          uint64
+	PageWrites         uint64
+	FileWrites         uint64
+	WALBytes           uint64
+	ActiveTransactions int32
+	Commits            uint64
+	Rollbacks          uint64
+	LocksGranted       int32
+	LocksWaiting       int32
+	LockWaits          uint64
+	Deadlocks          uint64
+	LockTimeouts       uint64
+}
+
+// fields は統計のフィールドを番号の順に返します。int32 のフィールドは *int32 です。
+func (m *StatsResponse) fields() []any {
+	return []any{
+		&m.PageSize, &m.PageReads, &m.CachedReads, &m.PageWrites, &m.FileWrites, &m.WALBytes,
+		&m.ActiveTransactions, &m.Commits, &m.Rollbacks, &m.LocksGranted, &m.LocksWaiting,
+		&m.LockWaits, &m.Deadlocks, &m.LockTimeouts, &m.CacheHits,
+	}
+}
+
+func (m *StatsResponse) Marshal() []byte {
+	var b []byte
+	for i, p := range m.fields() {
+		switch p := p.(type) {
+		case *int32:
+			b = appendInt(b, i+1, int64(*p))
+		case *uint64:
+			b = appendUint(b, i+1, *p)
+		}
+	}
+	return b
+}
+
+func (m *StatsResponse) Unmarshal(b []byte) error {
+	*m = StatsResponse{}
+	fields := m.fields()
+	return readFields(b, func(f field) error {
+		if f.num > len(fields) {
+			return nil
+		}
+		x, err := f.varint()
+		switch p := fields[f.num-1].(type) {
+		case *int32:
+			*p = int32(x)
+		case *uint64:
+			*p = x
+		}
+		return err
+	})
+}
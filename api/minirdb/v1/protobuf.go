@@ -0,0 +1,165 @@
+package minirdbv1
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// Protocol Buffers
+//
+// メッセージは Protocol Buffers のバイナリ形式で読み書きする。ここでは minirdb.proto のメッセージに
+// 必要な分（varint、64ビット固定長、長さ付きのフィールド）だけを扱う。proto3 の規則どおり、
+// 既定値（0、空文字列、false）のフィールドは書かず、oneof の値だけは既定値でも書く。
+// 読むときは知らない番号のフィールドを読み飛ばすので、定義にフィールドを足しても古い側で読める。
+
+// wireType はフィールドの値の形式です。
+type wireType uint8
+
+const (
+	wireVarint  wireType = 0
+	wireFixed64 wireType = 1
+	wireBytes   wireType = 2
+	wireFixed32 wireType = 5
+)
+
+// errProto はメッセージのバイト列が壊れている場合のエラーです。
+var errProto = errors.New("minirdbv1: invalid protobuf message")
+
+// message は Protocol Buffers の形式で読み書きできるメッセージです。
+type message interface {
+	Marshal() []byte
+	Unmarshal(b []byte) error
+}
+
+func appendTag(b []byte, num int, typ wireType) []byte {
+	return binary.AppendUvarint(b, uint64(num)<<3|uint64(typ))
+}
+
+// appendVarint は varint のフィールドを、値が 0 でも書きます。
+func appendVarint(b []byte, num int, v uint64) []byte {
+	return binary.AppendUvarint(appendTag(b, num, wireVarint), v)
+}
+
+// appendBytes は長さ付きのフィールドを、空でも書きます。
+func appendBytes(b []byte, num int, v []byte) []byte {
+	b = binary.AppendUvarint(appendTag(b, num, wireBytes), uint64(len(v)))
+	return append(b, v...)
+}
+
+// appendDouble は double のフィールドを、値が 0 でも書きます。
+func appendDouble(b []byte, num int, v float64) []byte {
+	return binary.LittleEndian.AppendUint64(appendTag(b, num, wireFixed64), math.Float64bits(v))
+}
+
+// appendInt は int32 か int64 のフィールドを、0 でなければ書きます。負の値は10バイトの varint です。
+func appendInt(b []byte, num int, v int64) []byte {
+	if v == 0 {
+		return b
+	}
+	return appendVarint(b, num, uint64(v))
+}
+
+// appendUint は uint64 のフィールドを、0 でなければ書きます。
+func appendUint(b []byte, num int, v uint64) []byte {
+	if v == 0 {
+		return b
+	}
+	return appendVarint(b, num, v)
+}
+
+// appendBool は bool のフィールドを、true なら書きます。
+func appendBool(b []byte, num int, v bool) []byte {
+	if !v {
+		return b
+	}
+	return appendVarint(b, num, 1)
+}
+
+// appendString は string のフィールドを、空でなければ書きます。
+func appendString(b []byte, num int, v string) []byte {
+	if v == "" {
+		return b
+	}
+	return appendBytes(b, num, []byte(v))
+}
+
+// field はメッセージから読んだ1つのフィールドです。varint と固定長の値は v に、
+// 長さ付きの値は b に入ります。
+type field struct {
+	num int
+	typ wireType
+	v   uint64
+	b   []byte
+}
+
+// readFields は b のフィールドを順に fn に渡します。
+func readFields(b []byte, fn func(f field) error) error {
+	for len(b) > 0 {
+		key, n := binary.Uvarint(b)
+		if n <= 0 || key>>3 == 0 || key>>3 > math.MaxInt32 {
+			return errProto
+		}
+		b = b[n:]
+		f := field{num: int(key >> 3), typ: wireType(key & 7)}
+		switch f.typ {
+		case wireVarint:
+			f.v, n = binary.Uvarint(b)
+			if n <= 0 {
+				return errProto
+			}
+			b = b[n:]
+		case wireFixed64:
+			if len(b) < 8 {
+				return errProto
+			}
+			f.v, b = binary.LittleEndian.Uint64(b), b[8:]
+		case wireFixed32:
+			if len(b) < 4 {
+				return errProto
+			}
+			f.v, b = uint64(binary.LittleEndian.Uint32(b)), b[4:]
+		case wireBytes:
+			size, n := binary.Uvarint(b)
+			if n <= 0 || size > uint64(len(b)-n) {
+				return errProto
+			}
+			f.b, b = b[n:n+int(size)], b[n+int(size):]
+		default:
+			return errProto // グループ（3, 4）は proto3 にはない
+		}
+		if err := fn(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// want はフィールド f の形式が typ であることを確かめます。
+func (f field) want(typ wireType) error {
+	if f.typ != typ {
+		return fmt.Errorf("minirdbv1: field %d has wire type %d, want %d", f.num, f.typ, typ)
+	}
+	return nil
+}
+
+// varint は varint のフィールドの値を返します。
+func (f field) varint() (uint64, error) { return f.v, f.want(wireVarint) }
+
+// bytes は長さ付きのフィールドの値を返します。値は読んでいるバイト列を指します。
+func (f field) bytes() ([]byte, error) { return f.b, f.want(wireBytes) }
+
+// text は string のフィールドの値を返します。
+func (f field) text() (string, error) { return string(f.b), f.want(wireBytes) }
+
+// double は double のフィールドの値を返します。
+func (f field) double() (float64, error) { return math.Float64frombits(f.v), f.want(wireFixed64) }
+
+// message は埋め込んだメッセージのフィールドを m に読みます。
+func (f field) message(m message) error {
+	if err := f.want(wireBytes); err != nil {
+		return err
+	}
+	return m.Unmarshal(f.b)
+}
@@ -0,0 +1,278 @@
+package minirdbv1
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ServiceName は Database サービスの完全な名前です。メソッドのパスは "/" + ServiceName + "/Execute" などです。
+const ServiceName = "minirdb.v1.Database"
+
+// maxMessage は1つのメッセージの大きさの上限です。
+const maxMessage = 16 << 20
+
+// DatabaseServer は Database サービスの実装です。NewHandler に渡すと gRPC の要求で呼び出されます。
+// エラーは *Error ならその状態で、ほかのエラーなら Internal で応答します。ctx は要求のコンテキストで、
+// クライアントが取り消すか grpc-timeout を過ぎると取り消され、IncomingHeader で要求のヘッダーを
+// 読めます。
+type DatabaseServer interface {
+	Execute(ctx context.Context, req *ExecuteRequest) (*ExecuteResponse, error)
+	// Query は応答を send で送ります。send がエラーを返したらクライアントは読んでいないので、
+	// そのエラーを返して終えます。
+	Query(ctx context.Context, req *QueryRequest, send func(*QueryResponse) error) error
+	Begin(ctx context.Context, req *BeginRequest) (*BeginResponse, error)
+	Commit(ctx context.Context, req *EndRequest) (*EndResponse, error)
+	Rollback(ctx context.Context, req *EndRequest) (*EndResponse, error)
+	// Backup は進み具合を send で送ります。
+	Backup(ctx context.Context, req *BackupRequest, send func(*BackupProgress) error) error
+	Stats(ctx context.Context, req *StatsRequest) (*StatsResponse, error)
+}
+
+// gRPC のサーバー
+//
+// NewHandler は HTTP/2 の上の gRPC のプロトコルで srv を呼び出す http.Handler を返す。メッセージは
+// 1バイトの圧縮の印（常に 0。圧縮には対応しない）と4バイトの長さを前に付けて送り、状態は
+// トレーラーの grpc-status、grpc-message、grpc-status-details-bin で返す。HTTP/2 で受け付けるのは
+// http.Server の役目で、暗号化しないなら http.Server.Protocols で UnencryptedHTTP2 を有効にする。
+
+type headerKey struct{}
+
+// IncomingHeader は DatabaseServer のメソッドに渡した ctx から、要求の HTTP のヘッダー（gRPC の
+// メタデータ）を返します。ほかのコンテキストなら nil です。
+func IncomingHeader(ctx context.Context) http.Header {
+	h, _ := ctx.Value(headerKey{}).(http.Header)
+	return h
+}
+
+// NewHandler は gRPC の要求で srv を呼び出すハンドラを返します。
+func NewHandler(srv DatabaseServer) http.Handler {
+	return &handler{srv: srv}
+}
+
+type handler struct {
+	srv DatabaseServer
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "gRPC requires POST", http.StatusMethodNotAllowed)
+		return
+	}
+	if r.ProtoMajor != 2 {
+		http.Error(w, "gRPC requires HTTP/2", http.StatusHTTPVersionNotSupported)
+		return
+	}
+	if ct := r.Header.Get("Content-Type"); ct != "application/grpc" && !strings.HasPrefix(ct, "application/grpc+proto") &&
+		!strings.HasPrefix(ct, "application/grpc;") {
+		http.Error(w, "unsupported content type: "+ct, http.StatusUnsupportedMediaType)
+		return
+	}
+	w.Header().Set("Content-Type", "application/grpc")
+	w.Header().Set("Grpc-Accept-Encoding", "identity")
+	w.Header().Add("Trailer", "Grpc-Status")
+	w.Header().Add("Trailer", "Grpc-Message")
+	w.Header().Add("Trailer", "Grpc-Status-Details-Bin")
+
+	ctx := context.WithValue(r.Context(), headerKey{}, r.Header)
+	if s := r.Header.Get("Grpc-Timeout"); s != "" {
+		d, err := parseTimeout(s)
+		if err != nil {
+			finish(w, Errorf(InvalidArgument, "%v", err))
+			return
+		}
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d)
+		defer cancel()
+	}
+	if enc := r.Header.Get("Grpc-Encoding"); enc != "" && enc != "identity" {
+		finish(w, Errorf(Unimplemented, "unsupported grpc-encoding: %s", enc))
+		return
+	}
+	method, ok := strings.CutPrefix(r.URL.Path, "/"+ServiceName+"/")
+	if !ok {
+		finish(w, Errorf(Unimplemented, "unknown service: %s", r.URL.Path))
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	finish(w, h.call(ctx, w, r.Body, method))
+}
+
+// call はメソッド method を呼び出し、応答を w に書きます。
+func (h *handler) call(ctx context.Context, w http.ResponseWriter, body io.Reader, method string) error {
+	switch method {
+	case "Execute":
+		return unary(ctx, w, body, h.srv.Execute)
+	case "Query":
+		return stream(ctx, w, body, h.srv.Query)
+	case "Begin":
+		return unary(ctx, w, body, h.srv.Begin)
+	case "Commit":
+		return unary(ctx, w, body, h.srv.Commit)
+	case "Rollback":
+		return unary(ctx, w, body, h.srv.Rollback)
+	case "Backup":
+		return stream(ctx, w, body, h.srv.Backup)
+	case "Stats":
+		return unary(ctx, w, body, h.srv.Stats)
+	default:
+		return Errorf(Unimplemented, "unknown method: %s", method)
+	}
+}
+
+// unary は要求のメッセージを1つ読んで fn を呼び、応答を1つ書きます。
+func unary[Req, Resp any, PReq interface {
+	*Req
+	message
+}, PResp interface {
+	*Resp
+	message
+}](ctx context.Context, w http.ResponseWriter, body io.Reader, fn func(context.Context, PReq) (PResp, error)) error {
+	req := PReq(new(Req))
+	if err := readRequest(body, req); err != nil {
+		return err
+	}
+	resp, err := fn(ctx, req)
+	if err != nil {
+		return err
+	}
+	return writeMessage(w, resp)
+}
+
+// stream は要求のメッセージを1つ読んで fn を呼び、fn が送る応答を書きます。
+func stream[Req, Resp any, PReq interface {
+	*Req
+	message
+}, PResp interface {
+	*Resp
+	message
+}](ctx context.Context, w http.ResponseWriter, body io.Reader, fn func(context.Context, PReq, func(PResp) error) error) error {
+	req := PReq(new(Req))
+	if err := readRequest(body, req); err != nil {
+		return err
+	}
+	return fn(ctx, req, func(resp PResp) error { return writeMessage(w, resp) })
+}
+
+// readRequest は要求の本体からメッセージを1つ読みます。
+func readRequest(body io.Reader, m message) error {
+	switch err := readMessage(body, m); {
+	case err == io.EOF:
+		return Errorf(InvalidArgument, "missing request message")
+	case err != nil:
+		var e *Error
+		if errors.As(err, &e) {
+			return e
+		}
+		return Errorf(InvalidArgument, "reading request: %v", err)
+	}
+	return nil
+}
+
+// writeMessage はメッセージを1つ書いて送ります。
+func writeMessage(w http.ResponseWriter, m message) error {
+	if _, err := w.Write(frame(m)); err != nil {
+		return err
+	}
+	return http.NewResponseController(w).Flush()
+}
+
+// frame はメッセージに圧縮の印と長さを付けます。
+func frame(m message) []byte {
+	b := m.Marshal()
+	out := make([]byte, 5, 5+len(b))
+	binary.BigEndian.PutUint32(out[1:], uint32(len(b)))
+	return append(out, b...)
+}
+
+// readMessage は r から圧縮の印と長さの付いたメッセージを1つ読みます。メッセージの前で
+// r が終われば io.EOF を返します。
+func readMessage(r io.Reader, m message) error {
+	var hdr [5]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return errors.New("minirdbv1: truncated message header")
+		}
+		return err
+	}
+	if hdr[0] != 0 {
+		return Errorf(Unimplemented, "compressed messages are not supported")
+	}
+	n := binary.BigEndian.Uint32(hdr[1:])
+	if n > maxMessage {
+		return Errorf(ResourceExhausted, "message of %d bytes exceeds the limit of %d", n, maxMessage)
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	return m.Unmarshal(b)
+}
+
+// finish は err の状態をトレーラーに書きます。err が nil なら OK です。
+func finish(w http.ResponseWriter, err error) {
+	e := &Error{Code: OK}
+	if err != nil && !errors.As(err, &e) {
+		e = &Error{Code: Internal, Message: err.Error()}
+		if errors.Is(err, context.Canceled) {
+			e.Code = Canceled
+		} else if errors.Is(err, context.DeadlineExceeded) {
+			e.Code = DeadlineExceeded
+		}
+	}
+	h := w.Header()
+	h.Set("Grpc-Status", strconv.FormatUint(uint64(e.Code), 10))
+	if e.Message != "" {
+		h.Set("Grpc-Message", encodeMessage(e.Message))
+	}
+	if e.Reason != "" {
+		h.Set("Grpc-Status-Details-Bin", encodeDetails(marshalStatus(e)))
+	}
+}
+
+// timeoutUnits は grpc-timeout の単位です。
+var timeoutUnits = map[byte]time.Duration{
+	'H': time.Hour, 'M': time.Minute, 'S': time.Second,
+	'm': time.Millisecond, 'u': time.Microsecond, 'n': time.Nanosecond,
+}
+
+// parseTimeout は grpc-timeout の値（8桁までの数と単位）を読みます。
+func parseTimeout(s string) (time.Duration, error) {
+	if len(s) < 2 || len(s) > 9 {
+		return 0, fmt.Errorf("invalid grpc-timeout: %q", s)
+	}
+	unit, ok := timeoutUnits[s[len(s)-1]]
+	n, err := strconv.ParseInt(s[:len(s)-1], 10, 64)
+	if !ok || err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid grpc-timeout: %q", s)
+	}
+	if n > int64(time.Duration(1<<63-1)/unit) {
+		return time.Duration(1<<63 - 1), nil
+	}
+	return time.Duration(n) * unit, nil
+}
+
+// formatTimeout は d を grpc-timeout の値にします。
+func formatTimeout(d time.Duration) string {
+	if d <= 0 {
+		return "1n"
+	}
+	for _, u := range []struct {
+		c byte
+		d time.Duration
+	}{{'n', time.Nanosecond}, {'u', time.Microsecond}, {'m', time.Millisecond}, {'S', time.Second}, {'M', time.Minute}} {
+		if n := (d + u.d - 1) / u.d; n < 1e8 {
+			return strconv.FormatInt(int64(n), 10) + string(u.c)
+		}
+	}
+	return strconv.FormatInt(int64(min((d+time.Hour-1)/time.Hour, 1e8-1)), 10) + "H"
+}
@@ -0,0 +1,161 @@
+package minirdbv1
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Code は gRPC の状態コードです。
+type Code uint32
+
+const (
+	OK                 Code = 0
+	Canceled           Code = 1
+	Unknown            Code = 2
+	InvalidArgument    Code = 3
+	DeadlineExceeded   Code = 4
+	NotFound           Code = 5
+	AlreadyExists      Code = 6
+	PermissionDenied   Code = 7
+	ResourceExhausted  Code = 8
+	FailedPrecondition Code = 9
+	Aborted            Code = 10
+	OutOfRange         Code = 11
+	Unimplemented      Code = 12
+	Internal           Code = 13
+	Unavailable        Code = 14
+	DataLoss           Code = 15
+	Unauthenticated    Code = 16
+)
+
+var codeNames = [...]string{
+	"OK", "CANCELLED", "UNKNOWN", "INVALID_ARGUMENT", "DEADLINE_EXCEEDED", "NOT_FOUND", "ALREADY_EXISTS",
+	"PERMISSION_DENIED", "RESOURCE_EXHAUSTED", "FAILED_PRECONDITION", "ABORTED", "OUT_OF_RANGE",
+	"UNIMPLEMENTED", "INTERNAL", "UNAVAILABLE", "DATA_LOSS", "UNAUTHENTICATED",
+}
+
+func (c Code) String() string {
+	if int(c) < len(codeNames) {
+		return codeNames[c]
+	}
+	return "CODE(" + strconv.FormatUint(uint64(c), 10) + ")"
+}
+
+// Error は失敗した RPC の gRPC の状態です。サーバーのメソッドが返すと、その状態で応答します。
+// Error でないエラーは Internal にします。
+//
+//	var e *minirdbv1.Error
+//	if errors.As(err, &e) && e.Reason == "unique" {
+//		// 重複していた
+//	}
+type Error struct {
+	Code    Code
+	Message string
+	// Reason は状態の詳細（google.rpc.ErrorInfo）の reason で、internal/wire の Error のコード
+	// （unique、locked など）です。詳細がなければ空です。
+	Reason string
+}
+
+func (e *Error) Error() string { return e.Message }
+
+// Errorf は状態コード code と書式から作ったメッセージの Error を返します。
+func Errorf(code Code, format string, args ...any) *Error {
+	return &Error{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// 状態の詳細
+//
+// Reason は grpc-status-details-bin のトレーラーに google.rpc.Status として入れる。details には
+// google.protobuf.Any に包んだ google.rpc.ErrorInfo を1つだけ置き、domain は errorDomain にする。
+
+const (
+	errorInfoType = "type.googleapis.com/google.rpc.ErrorInfo"
+	errorDomain   = "minirdb"
+)
+
+// marshalStatus は e を google.rpc.Status（code = 1, message = 2, details = 3）にします。
+func marshalStatus(e *Error) []byte {
+	b := appendInt(nil, 1, int64(e.Code))
+	b = appendString(b, 2, e.Message)
+	if e.Reason != "" {
+		info := appendString(appendString(nil, 1, e.Reason), 2, errorDomain)
+		anyMsg := appendBytes(appendString(nil, 1, errorInfoType), 2, info)
+		b = appendBytes(b, 3, anyMsg)
+	}
+	return b
+}
+
+// statusReason は google.rpc.Status の details から ErrorInfo の reason を探します。
+func statusReason(b []byte) string {
+	var reason string
+	readFields(b, func(f field) error {
+		if f.num != 3 || f.typ != wireBytes {
+			return nil
+		}
+		var typeURL string
+		var value []byte
+		readFields(f.b, func(f field) error {
+			switch f.num {
+			case 1:
+				typeURL, _ = f.text()
+			case 2:
+				value, _ = f.bytes()
+			}
+			return nil
+		})
+		if typeURL != errorInfoType {
+			return nil
+		}
+		return readFields(value, func(f field) (err error) {
+			if f.num == 1 {
+				reason, err = f.text()
+			}
+			return err
+		})
+	})
+	return reason
+}
+
+// encodeDetails と decodeDetails は grpc-status-details-bin の値（パディングのない base64）を
+// 読み書きします。読むときはパディングがあってもかまいません。
+func encodeDetails(b []byte) string { return base64.RawStdEncoding.EncodeToString(b) }
+
+func decodeDetails(s string) ([]byte, error) {
+	return base64.RawStdEncoding.DecodeString(strings.TrimRight(s, "="))
+}
+
+// encodeMessage は grpc-message に入れるために、表示できる ASCII 以外と '%' をパーセント
+// エンコードします。
+func encodeMessage(msg string) string {
+	var b strings.Builder
+	for i := 0; i < len(msg); i++ {
+		c := msg[i]
+		if c < 0x20 || c > 0x7e || c == '%' {
+			fmt.Fprintf(&b, "%%%02X", c)
+		} else {
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// decodeMessage は grpc-message のパーセントエンコードを戻します。壊れた部分はそのまま残します。
+func decodeMessage(s string) string {
+	if !strings.Contains(s, "%") {
+		return s
+	}
+	var b []byte
+	for i := 0; i < len(s); i++ {
+		if s[i] == '%' && i+2 < len(s) {
+			if v, err := strconv.ParseUint(s[i+1:i+3], 16, 8); err == nil {
+				b = append(b, byte(v))
+				i += 2
+				continue
+			}
+		}
+		b = append(b, s[i])
+	}
+	return string(b)
+}
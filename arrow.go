@@ -0,0 +1,183 @@
+package rdbms
+
+import (
+	"fmt"
+	"io"
+	"iter"
+
+	"github.com/k-sml/go-rdbms/arrow"
+	"github.com/k-sml/go-rdbms/internal/types"
+)
+
+// defaultArrowBatch は ArrowBatches と WriteArrow の size が0以下の場合の、1つのバッチの行の数です。
+const defaultArrowBatch = 1024
+
+// arrowTypes は SQL の型ごとの Arrow の型です。
+var arrowTypes = map[types.Type]arrow.Type{
+	types.Int:       arrow.Int32,
+	types.BigInt:    arrow.Int64,
+	types.Real:      arrow.Float64,
+	types.Text:      arrow.String,
+	types.Blob:      arrow.Binary,
+	types.Boolean:   arrow.Boolean,
+	types.Timestamp: arrow.Timestamp,
+}
+
+// ArrowBatches は残りの行を size 行ずつの Arrow のレコードバッチにして繰り返すイテレータを返します。
+// size が0以下なら1024行ずつにします。列の Arrow の型は列の SQL の型で決まり（INT は Int32、
+// BIGINT は Int64、REAL は Float64、TEXT は String、BLOB は Binary、BOOLEAN は Boolean、
+// TIMESTAMP は Timestamp）、型の決まらない式の列は最初のバッチの値から決めます（数値だけなら
+// Int64 か Float64、型が混ざっていれば String、すべて NULL なら String）。後のバッチの値が
+// その型に変換できなければエラーになります。
+//
+// 返したバッチは次のバッチを読んだ後も使えます。読んでいる途中でエラーになると、最後にそのエラーを
+// 1回だけ返して終わります。繰り返しを終えるか break で抜けると Rows を閉じます。
+func (r *Rows) ArrowBatches(size int) iter.Seq2[*arrow.Record, error] {
+	return func(yield func(*arrow.Record, error) bool) {
+		defer r.Close()
+		b := newArrowBatcher(r, size)
+		for {
+			rec, err := b.next()
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+			if rec == nil || !yield(rec, nil) {
+				return
+			}
+		}
+	}
+}
+
+// WriteArrow は残りの行を ArrowBatches と同じ size 行ずつのレコードバッチにして、Arrow IPC の
+// ストリーム形式で w に書きます。結果が空でもスキーマと終わりの印を書きます。途中でエラーになると、
+// 終わりの印を書かずにそのエラーを返します。書き終えると Rows を閉じます。
+func (r *Rows) WriteArrow(w io.Writer, size int) error {
+	defer r.Close()
+	b := newArrowBatcher(r, size)
+	rec, err := b.next()
+	if err != nil {
+		return err
+	}
+	aw := arrow.NewWriter(w, b.schema())
+	for rec != nil {
+		if err := aw.Write(rec); err != nil {
+			return err
+		}
+		if rec, err = b.next(); err != nil {
+			return err
+		}
+	}
+	return aw.Close()
+}
+
+// arrowBatcher は結果の行を Arrow のレコードバッチにまとめます。
+type arrowBatcher struct {
+	rows *Rows
+	size int
+	typs []types.Type // 列の SQL の型。型の決まらない式の列は、最初のバッチを読むまで Null
+	b    *arrow.Builder
+	vals []any
+}
+
+func newArrowBatcher(r *Rows, size int) *arrowBatcher {
+	if size <= 0 {
+		size = defaultArrowBatch
+	}
+	return &arrowBatcher{rows: r, size: size, typs: append([]types.Type(nil), r.rows.ColumnTypes()...)}
+}
+
+// next は次のバッチを返します。行が残っていなければ nil を返します。
+func (b *arrowBatcher) next() (*arrow.Record, error) {
+	if b.b == nil {
+		// 型の決まらない列の型を決めるために、最初のバッチの行を取っておく
+		var rows [][]types.Value
+		for len(rows) < b.size && b.rows.Next() {
+			row := make([]types.Value, 0, len(b.typs))
+			for _, v := range b.rows.rows.Values() {
+				if v.Type() == types.Blob {
+					v = types.NewBlob(v.Blob()) // 次の Next で書き換わる
+				}
+				row = append(row, v)
+			}
+			rows = append(rows, row)
+		}
+		if err := b.rows.Err(); err != nil {
+			return nil, err
+		}
+		b.resolve(rows)
+		for _, row := range rows {
+			if err := b.append(row); err != nil {
+				return nil, err
+			}
+		}
+	} else {
+		for b.b.Len() < b.size && b.rows.Next() {
+			if err := b.append(b.rows.rows.Values()); err != nil {
+				return nil, err
+			}
+		}
+		if err := b.rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+	if b.b.Len() == 0 {
+		return nil, nil
+	}
+	return b.b.NewRecord(), nil
+}
+
+// resolve は最初のバッチの行 rows から型の決まらない列の型を決め、Builder を作ります。
+func (b *arrowBatcher) resolve(rows [][]types.Value) {
+	for i, t := range b.typs {
+		if t != types.Null {
+			continue
+		}
+		ok := true
+		for _, row := range rows {
+			if ok && !row[i].IsNull() {
+				t, ok = types.Common(t, row[i].Type())
+			}
+		}
+		if !ok || t == types.Null {
+			t = types.Text
+		}
+		b.typs[i] = t
+	}
+	b.b = arrow.NewBuilder(b.schema())
+	b.vals = make([]any, len(b.typs))
+}
+
+// schema は結果のスキーマを返します。
+func (b *arrowBatcher) schema() *arrow.Schema {
+	if b.b != nil {
+		return b.b.Schema()
+	}
+	s := &arrow.Schema{Fields: make([]arrow.Field, len(b.typs))}
+	for i, name := range b.rows.Columns() {
+		s.Fields[i] = arrow.Field{Name: name, Type: arrowTypes[b.typs[i]], Nullable: true}
+	}
+	return s
+}
+
+// append は行 row を Builder に加えます。
+func (b *arrowBatcher) append(row []types.Value) error {
+	for i, v := range row {
+		t := b.typs[i]
+		if v.Type() != t && !v.IsNull() {
+			var err error
+			if v, err = types.Cast(v, t); err != nil {
+				return fmt.Errorf("column %d (%s): %w", i, b.rows.Columns()[i], err)
+			}
+		}
+		switch {
+		case v.IsNull():
+			b.vals[i] = nil
+		case t == types.Int:
+			b.vals[i] = int32(v.Int())
+		default:
+			b.vals[i] = v.Go()
+		}
+	}
+	return b.b.Append(b.vals)
+}
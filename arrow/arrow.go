@@ -0,0 +1,188 @@
+// Package arrow は問い合わせの結果を Apache Arrow の列形式のレコードバッチとして扱うための型と、
+// Arrow IPC のストリーム形式の読み書きを提供します。分析のコードが結果を行ごとに読まずに、
+// 列ごとのバッファとして使えるようにするためのものです。
+//
+//	rows, err := db.Query("SELECT id, price FROM items")
+//	if err != nil {
+//		return err
+//	}
+//	var sum float64
+//	for rec, err := range rows.ArrowBatches(4096) {
+//		if err != nil {
+//			return err
+//		}
+//		prices := rec.Columns[1]
+//		for i := range rec.NumRows {
+//			if !prices.IsNull(i) {
+//				sum += prices.Float64(i)
+//			}
+//		}
+//	}
+//
+// Schema、Record、Column はこのパッケージの型で、Apache Arrow の Go の実装（arrow-go）の型では
+// ありません。arrow-go と値をやりとりするには、IPC のストリームを介すか、Column のバッファ
+// （Validity、Offsets、Values）を自分で arrow-go の配列に包みます。バッファは Arrow の列形式の
+// 仕様どおりのバイト列（リトルエンディアン）ですが、仕様が勧める 64 バイトの境界に揃うとは限りません。
+// 結果の行からバッファを作るときには値を複製します。Column.Value は値を1つずつ any に入れて返すので、
+// 多くの値を読むときは Int64 や Float64 などの型ごとのメソッドを使ってください。
+//
+// NewWriter はレコードバッチを Arrow IPC のストリーム形式で書き、NewReader はそれを読みます。
+// HTTP の API の /query に Accept: application/vnd.apache.arrow.stream を付けると、結果をこの形式で
+// 返します。扱える型は Type の定数だけで、辞書、入れ子の型、圧縮には対応しません。
+package arrow
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"time"
+)
+
+// MIMEType は Arrow IPC のストリーム形式のメディアタイプです。
+const MIMEType = "application/vnd.apache.arrow.stream"
+
+// Type は列の Arrow の型です。
+type Type uint8
+
+const (
+	Null      Type = iota // すべての値が NULL の列
+	Boolean               // 真偽値（ビットマップ）
+	Int32                 // 32ビット符号付き整数
+	Int64                 // 64ビット符号付き整数
+	Float64               // 64ビット浮動小数点数
+	String                // UTF-8 文字列（Utf8）
+	Binary                // バイト列
+	Timestamp             // 日時（UTC、Unix エポックからのマイクロ秒）
+)
+
+// String は型名を返します。
+func (t Type) String() string {
+	switch t {
+	case Null:
+		return "null"
+	case Boolean:
+		return "bool"
+	case Int32:
+		return "int32"
+	case Int64:
+		return "int64"
+	case Float64:
+		return "float64"
+	case String:
+		return "utf8"
+	case Binary:
+		return "binary"
+	case Timestamp:
+		return "timestamp[us, tz=UTC]"
+	}
+	return fmt.Sprintf("Type(%d)", t)
+}
+
+// width は固定長の型の値1つのバイト数を返します。可変長の型と Boolean、Null は 0 です。
+func (t Type) width() int {
+	switch t {
+	case Int32:
+		return 4
+	case Int64, Float64, Timestamp:
+		return 8
+	}
+	return 0
+}
+
+// Field は列の名前と型です。
+type Field struct {
+	Name     string
+	Type     Type
+	Nullable bool
+}
+
+// Schema はレコードバッチの列の並びです。
+type Schema struct {
+	Fields []Field
+}
+
+// Record は列ごとに値を並べたレコードバッチです。Columns は Schema.Fields と同じ順に並び、
+// どの列も NumRows 個の値を持ちます。
+type Record struct {
+	Schema  *Schema
+	Columns []*Column
+	NumRows int
+}
+
+// Column はレコードバッチの1つの列の値です。バッファは Arrow の列形式と同じ配置です。
+type Column struct {
+	Type      Type
+	Len       int
+	NullCount int
+	// Validity は値が NULL でないビットを下位のビットから並べたビットマップです。NULL がなければ nil です。
+	Validity []byte
+	// Offsets は String と Binary の列の値の境目で、Len+1 個の int32 を並べたものです。i 番目の値は
+	// Values の i 番目と i+1 番目の境目の間です。
+	Offsets []byte
+	// Values は固定長の型なら値をそのまま並べたもの、Boolean ならビットマップ、String と Binary なら
+	// 値をつなげたバイト列です。
+	Values []byte
+}
+
+// IsNull は i 番目の値が NULL かを返します。
+func (c *Column) IsNull(i int) bool {
+	if c.Type == Null {
+		return true
+	}
+	return c.Validity != nil && !bit(c.Validity, i)
+}
+
+// Bool は Boolean の列の i 番目の値を返します。
+func (c *Column) Bool(i int) bool { return bit(c.Values, i) }
+
+// Int32 は Int32 の列の i 番目の値を返します。
+func (c *Column) Int32(i int) int32 { return int32(binary.LittleEndian.Uint32(c.Values[4*i:])) }
+
+// Int64 は Int64 と Timestamp の列の i 番目の値を返します。
+func (c *Column) Int64(i int) int64 { return int64(binary.LittleEndian.Uint64(c.Values[8*i:])) }
+
+// Float64 は Float64 の列の i 番目の値を返します。
+func (c *Column) Float64(i int) float64 {
+	return math.Float64frombits(binary.LittleEndian.Uint64(c.Values[8*i:]))
+}
+
+// Bytes は String と Binary の列の i 番目の値を返します。Values の一部なので、書き換えないでください。
+func (c *Column) Bytes(i int) []byte {
+	start := binary.LittleEndian.Uint32(c.Offsets[4*i:])
+	end := binary.LittleEndian.Uint32(c.Offsets[4*i+4:])
+	return c.Values[start:end:end]
+}
+
+// String は String の列の i 番目の値を返します。
+func (c *Column) String(i int) string { return string(c.Bytes(i)) }
+
+// Time は Timestamp の列の i 番目の値を返します。
+func (c *Column) Time(i int) time.Time { return time.UnixMicro(c.Int64(i)).UTC() }
+
+// Value は i 番目の値を Go の値（bool, int32, int64, float64, string, []byte, time.Time、
+// NULL なら nil）にして返します。値を any に入れるので、多くの場合は割り当てが起きます。
+func (c *Column) Value(i int) any {
+	if c.IsNull(i) {
+		return nil
+	}
+	switch c.Type {
+	case Boolean:
+		return c.Bool(i)
+	case Int32:
+		return c.Int32(i)
+	case Int64:
+		return c.Int64(i)
+	case Float64:
+		return c.Float64(i)
+	case String:
+		return c.String(i)
+	case Binary:
+		return c.Bytes(i)
+	case Timestamp:
+		return c.Time(i)
+	}
+	return nil
+}
+
+// bit はビットマップ b の i 番目のビットを返します。
+func bit(b []byte, i int) bool { return b[i/8]&(1<<(i%8)) != 0 }
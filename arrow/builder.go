@@ -0,0 +1,163 @@
+package arrow
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"time"
+)
+
+// Builder は行を1つずつ加えてレコードバッチを作ります。
+type Builder struct {
+	schema *Schema
+	cols   []columnBuilder
+	n      int
+}
+
+// columnBuilder は1つの列のバッファを作ります。
+type columnBuilder struct {
+	validity []byte
+	offsets  []byte
+	values   []byte
+	nulls    int
+}
+
+// NewBuilder は schema の列のレコードバッチを作る Builder を返します。
+func NewBuilder(schema *Schema) *Builder {
+	return &Builder{schema: schema, cols: make([]columnBuilder, len(schema.Fields))}
+}
+
+// Schema は作るレコードバッチのスキーマを返します。
+func (b *Builder) Schema() *Schema { return b.schema }
+
+// Len は加えた行の数を返します。
+func (b *Builder) Len() int { return b.n }
+
+// Append は行 row を加えます。値は列の型に合わせて、Boolean なら bool、Int32 なら int32、Int64 なら
+// int64、Float64 なら float64、String なら string、Binary なら []byte、Timestamp なら time.Time か、
+// NULL なら nil でなければなりません。合わない値があればエラーを返し、行を加えません。
+func (b *Builder) Append(row []any) error {
+	if len(row) != len(b.cols) {
+		return fmt.Errorf("row has %d values, want %d", len(row), len(b.cols))
+	}
+	for i, v := range row {
+		f := b.schema.Fields[i]
+		if !fits(f.Type, v) {
+			return fmt.Errorf("column %d (%s): cannot append %T to %s", i, f.Name, v, f.Type)
+		}
+		if v == nil && !f.Nullable {
+			return fmt.Errorf("column %d (%s): NULL in a non-nullable column", i, f.Name)
+		}
+		if n := len(b.cols[i].values) + size(v); n > math.MaxInt32 {
+			return fmt.Errorf("column %d (%s): more than %d bytes in a batch", i, f.Name, math.MaxInt32)
+		}
+	}
+	for i, v := range row {
+		b.cols[i].append(b.schema.Fields[i].Type, b.n, v)
+	}
+	b.n++
+	return nil
+}
+
+// fits は v を型 t の列に加えられるかを返します。
+func fits(t Type, v any) bool {
+	if v == nil {
+		return true
+	}
+	switch v.(type) {
+	case bool:
+		return t == Boolean
+	case int32:
+		return t == Int32
+	case int64:
+		return t == Int64
+	case float64:
+		return t == Float64
+	case string:
+		return t == String
+	case []byte:
+		return t == Binary
+	case time.Time:
+		return t == Timestamp
+	}
+	return false
+}
+
+// size は可変長の値 v のバイト数を返します。
+func size(v any) int {
+	switch v := v.(type) {
+	case string:
+		return len(v)
+	case []byte:
+		return len(v)
+	}
+	return 0
+}
+
+// append は n 番目の値 v を加えます。v は fits で確かめた値です。
+func (c *columnBuilder) append(t Type, n int, v any) {
+	if t == Null {
+		c.nulls++
+		return
+	}
+	if n%8 == 0 {
+		c.validity = append(c.validity, 0)
+		if t == Boolean {
+			c.values = append(c.values, 0)
+		}
+	}
+	if t == String || t == Binary {
+		if n == 0 {
+			c.offsets = binary.LittleEndian.AppendUint32(c.offsets, 0)
+		}
+		switch v := v.(type) {
+		case string:
+			c.values = append(c.values, v...)
+		case []byte:
+			c.values = append(c.values, v...)
+		}
+		c.offsets = binary.LittleEndian.AppendUint32(c.offsets, uint32(len(c.values)))
+	}
+	if v == nil {
+		c.nulls++
+		c.values = append(c.values, make([]byte, t.width())...)
+		return
+	}
+	c.validity[n/8] |= 1 << (n % 8)
+	switch v := v.(type) {
+	case bool:
+		if v {
+			c.values[n/8] |= 1 << (n % 8)
+		}
+	case int32:
+		c.values = binary.LittleEndian.AppendUint32(c.values, uint32(v))
+	case int64:
+		c.values = binary.LittleEndian.AppendUint64(c.values, uint64(v))
+	case float64:
+		c.values = binary.LittleEndian.AppendUint64(c.values, math.Float64bits(v))
+	case time.Time:
+		c.values = binary.LittleEndian.AppendUint64(c.values, uint64(v.UnixMicro()))
+	}
+}
+
+// NewRecord はこれまでに加えた行のレコードバッチを返し、Builder を空にします。返したバッチの
+// バッファはその後の Append で書き換えないので、いつまでも使えます。
+func (b *Builder) NewRecord() *Record {
+	rec := &Record{Schema: b.schema, Columns: make([]*Column, len(b.cols)), NumRows: b.n}
+	for i := range b.cols {
+		c := &b.cols[i]
+		col := &Column{Type: b.schema.Fields[i].Type, Len: b.n, NullCount: c.nulls, Offsets: c.offsets, Values: c.values}
+		if col.Type == Null {
+			col.NullCount = b.n
+		} else if c.nulls > 0 {
+			col.Validity = c.validity
+		}
+		if (col.Type == String || col.Type == Binary) && b.n == 0 {
+			col.Offsets = make([]byte, 4)
+		}
+		rec.Columns[i] = col
+		*c = columnBuilder{}
+	}
+	b.n = 0
+	return rec
+}
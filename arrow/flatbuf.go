@@ -0,0 +1,257 @@
+package arrow
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// FlatBuffers
+//
+// Arrow IPC のメッセージのメタデータは FlatBuffers で表す。ここでは Arrow のメッセージに必要な
+// 分だけを読み書きする。書くときは親のテーブルを先に、参照する文字列やベクタやテーブルをその後に
+// 置く（参照は符号なしのオフセットで自分より後ろしか指せないので、子は親より後ろになければならない）。
+// vtable はテーブルの直前に置く。スカラーはバッファの先頭からの位置をその大きさにそろえる。
+
+var le = binary.LittleEndian
+
+// errFlatBuffer はメタデータの FlatBuffers が壊れている場合のエラーです。
+var errFlatBuffer = errors.New("arrow: invalid flatbuffer metadata")
+
+// fbTable は書くテーブルです。添字がフィールドの番号で、nil のフィールドは書きません。
+// 値は bool、uint8、int16、int32、int64、string、fbTable、[]fbTable、fbStructs のどれかです。
+type fbTable []any
+
+// fbStructs は大きさが16バイトの構造体（Arrow の FieldNode と Buffer）のベクタです。
+type fbStructs []byte
+
+// fbEncode は root を根のテーブルとする FlatBuffers のバッファを返します。長さは8の倍数です。
+func fbEncode(root fbTable) []byte {
+	e := &fbEncoder{buf: make([]byte, 4)}
+	pos := e.table(root)
+	le.PutUint32(e.buf, uint32(pos))
+	e.pad(8)
+	return e.buf
+}
+
+type fbEncoder struct {
+	buf []byte
+}
+
+// pad はバッファの長さを n の倍数にします。
+func (e *fbEncoder) pad(n int) {
+	for len(e.buf)%n != 0 {
+		e.buf = append(e.buf, 0)
+	}
+}
+
+// table はテーブル t を書き、その位置を返します。
+func (e *fbEncoder) table(t fbTable) int {
+	e.pad(2)
+	vt := len(e.buf)
+	e.buf = append(e.buf, make([]byte, 4+2*len(t))...)
+	e.pad(8)
+	pos := len(e.buf)
+	e.buf = le.AppendUint32(e.buf, uint32(pos-vt))
+	type ref struct {
+		at int
+		v  any
+	}
+	var refs []ref
+	for i, v := range t {
+		size := 4 // 参照
+		switch v.(type) {
+		case nil:
+			continue
+		case bool, uint8:
+			size = 1
+		case int16:
+			size = 2
+		case int64:
+			size = 8
+		}
+		e.pad(size)
+		at := len(e.buf)
+		switch v := v.(type) {
+		case bool:
+			if v {
+				e.buf = append(e.buf, 1)
+			} else {
+				e.buf = append(e.buf, 0)
+			}
+		case uint8:
+			e.buf = append(e.buf, v)
+		case int16:
+			e.buf = le.AppendUint16(e.buf, uint16(v))
+		case int32:
+			e.buf = le.AppendUint32(e.buf, uint32(v))
+		case int64:
+			e.buf = le.AppendUint64(e.buf, uint64(v))
+		default:
+			e.buf = append(e.buf, 0, 0, 0, 0)
+			refs = append(refs, ref{at, v})
+		}
+		le.PutUint16(e.buf[vt+4+2*i:], uint16(at-pos))
+	}
+	le.PutUint16(e.buf[vt:], uint16(4+2*len(t)))
+	le.PutUint16(e.buf[vt+2:], uint16(len(e.buf)-pos))
+	for _, r := range refs {
+		pos := e.object(r.v) // e.buf を伸ばすので、書く前に呼ぶ
+		le.PutUint32(e.buf[r.at:], uint32(pos-r.at))
+	}
+	return pos
+}
+
+// object は文字列かベクタかテーブル v を書き、その位置を返します。
+func (e *fbEncoder) object(v any) int {
+	e.pad(4)
+	switch v := v.(type) {
+	case string:
+		pos := len(e.buf)
+		e.buf = le.AppendUint32(e.buf, uint32(len(v)))
+		e.buf = append(e.buf, v...)
+		e.buf = append(e.buf, 0)
+		return pos
+	case fbTable:
+		return e.table(v)
+	case []fbTable:
+		pos := len(e.buf)
+		e.buf = le.AppendUint32(e.buf, uint32(len(v)))
+		e.buf = append(e.buf, make([]byte, 4*len(v))...)
+		for i, t := range v {
+			at := pos + 4 + 4*i
+			pos := e.table(t)
+			le.PutUint32(e.buf[at:], uint32(pos-at))
+		}
+		return pos
+	case fbStructs:
+		if len(e.buf)%8 == 0 {
+			e.buf = append(e.buf, 0, 0, 0, 0) // 要素を8バイトの境界にそろえる
+		}
+		pos := len(e.buf)
+		e.buf = le.AppendUint32(e.buf, uint32(len(v)/16))
+		e.buf = append(e.buf, v...)
+		return pos
+	}
+	panic("unexpected flatbuffer value")
+}
+
+// fbDecoder は FlatBuffers のバッファを読みます。範囲の外を読もうとすると err を設定し、
+// それ以降はゼロ値を返します。
+type fbDecoder struct {
+	buf []byte
+	err error
+}
+
+// check は off から n バイトがバッファの中にあるかを返します。
+func (d *fbDecoder) check(off, n int) bool {
+	if d.err != nil {
+		return false
+	}
+	if off < 0 || n < 0 || off > len(d.buf)-n {
+		d.err = errFlatBuffer
+		return false
+	}
+	return true
+}
+
+func (d *fbDecoder) u8(off int) uint8 {
+	if !d.check(off, 1) {
+		return 0
+	}
+	return d.buf[off]
+}
+
+func (d *fbDecoder) u16(off int) uint16 {
+	if !d.check(off, 2) {
+		return 0
+	}
+	return le.Uint16(d.buf[off:])
+}
+
+func (d *fbDecoder) u32(off int) uint32 {
+	if !d.check(off, 4) {
+		return 0
+	}
+	return le.Uint32(d.buf[off:])
+}
+
+func (d *fbDecoder) u64(off int) uint64 {
+	if !d.check(off, 8) {
+		return 0
+	}
+	return le.Uint64(d.buf[off:])
+}
+
+// ref は off にある参照が指す位置を返します。
+func (d *fbDecoder) ref(off int) int { return off + int(d.u32(off)) }
+
+// root は根のテーブルの位置を返します。
+func (d *fbDecoder) root() int { return d.ref(0) }
+
+// field はテーブル t のフィールド i の位置を返します。フィールドがなければ -1 です。
+func (d *fbDecoder) field(t, i int) int {
+	vt := t - int(int32(d.u32(t)))
+	if n := int(d.u16(vt)); 4+2*i+2 > n {
+		return -1
+	}
+	off := int(d.u16(vt + 4 + 2*i))
+	if off == 0 || d.err != nil {
+		return -1
+	}
+	return t + off
+}
+
+// integer はテーブル t の大きさ size バイトの整数のフィールド i を返します。なければ 0 です。
+func (d *fbDecoder) integer(t, i, size int) int64 {
+	off := d.field(t, i)
+	if off < 0 {
+		return 0
+	}
+	switch size {
+	case 1:
+		return int64(int8(d.u8(off)))
+	case 2:
+		return int64(int16(d.u16(off)))
+	case 4:
+		return int64(int32(d.u32(off)))
+	}
+	return int64(d.u64(off))
+}
+
+// table はテーブル t のテーブルのフィールド i の位置を返します。なければ -1 です。
+func (d *fbDecoder) table(t, i int) int {
+	off := d.field(t, i)
+	if off < 0 {
+		return -1
+	}
+	return d.ref(off)
+}
+
+// str はテーブル t の文字列のフィールド i を返します。
+func (d *fbDecoder) str(t, i int) string {
+	off := d.field(t, i)
+	if off < 0 {
+		return ""
+	}
+	s := d.ref(off)
+	n := int(d.u32(s))
+	if !d.check(s+4, n) {
+		return ""
+	}
+	return string(d.buf[s+4 : s+4+n])
+}
+
+// vector はテーブル t のベクタのフィールド i の要素の先頭の位置と、要素の数を返します。
+// 要素は1つ elem バイトです。
+func (d *fbDecoder) vector(t, i, elem int) (int, int) {
+	off := d.field(t, i)
+	if off < 0 {
+		return 0, 0
+	}
+	v := d.ref(off)
+	n := int(d.u32(v))
+	if !d.check(v+4, n*elem) {
+		return 0, 0
+	}
+	return v + 4, n
+}
@@ -0,0 +1,431 @@
+package arrow
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+// Arrow IPC のストリーム形式
+//
+// ストリームはスキーマのメッセージ、レコードバッチのメッセージを0個以上、終わりの印
+// （0xFFFFFFFF と長さ0）の順に並べたものである。メッセージは 0xFFFFFFFF、メタデータの長さ
+// （int32）、メタデータ（Message テーブルの FlatBuffers）、本体の順に並ぶ。本体はレコードバッチの
+// バッファを8バイトの境界にそろえて並べたもので、メタデータの Buffer が本体の中の位置と長さを表す。
+// メタデータのバージョンは V5 で書き、V4 と V5 を読む。
+
+// メッセージのメタデータの値です。
+const (
+	metadataV4 = 3
+	metadataV5 = 4
+
+	headerSchema      = 1
+	headerRecordBatch = 3
+)
+
+// Arrow の Type ユニオンの型の番号です。
+const (
+	typeNull          = 1
+	typeInt           = 2
+	typeFloatingPoint = 3
+	typeBinary        = 4
+	typeUtf8          = 5
+	typeBool          = 6
+	typeTimestamp     = 10
+)
+
+const (
+	continuation  = 0xFFFFFFFF
+	precisionDbl  = 2 // FloatingPoint の DOUBLE
+	unitMicro     = 2 // Timestamp の MICROSECOND
+	maxMetadata   = 64 << 20
+	bufferAlign   = 8
+	timestampZone = "UTC"
+)
+
+// Writer はレコードバッチを Arrow IPC のストリーム形式で書きます。
+type Writer struct {
+	w      io.Writer
+	schema *Schema
+	begun  bool
+	err    error
+}
+
+// NewWriter はスキーマが schema のレコードバッチを w に書く Writer を返します。スキーマは最初の
+// Write か Close のときに書きます。
+func NewWriter(w io.Writer, schema *Schema) *Writer {
+	return &Writer{w: w, schema: schema}
+}
+
+// Write はレコードバッチ rec を書きます。rec の列は Writer のスキーマと同じ型でなければなりません。
+func (w *Writer) Write(rec *Record) error {
+	if err := w.begin(); err != nil {
+		return err
+	}
+	if len(rec.Columns) != len(w.schema.Fields) {
+		return fmt.Errorf("arrow: record has %d columns, want %d", len(rec.Columns), len(w.schema.Fields))
+	}
+	var nodes, bufs fbStructs
+	var body []byte
+	addBuffer := func(b []byte) {
+		bufs = le.AppendUint64(bufs, uint64(len(body)))
+		bufs = le.AppendUint64(bufs, uint64(len(b)))
+		body = append(body, b...)
+		for len(body)%bufferAlign != 0 {
+			body = append(body, 0)
+		}
+	}
+	for i, col := range rec.Columns {
+		if col.Type != w.schema.Fields[i].Type || col.Len != rec.NumRows {
+			return fmt.Errorf("arrow: column %d (%s) does not match the schema", i, w.schema.Fields[i].Name)
+		}
+		nodes = le.AppendUint64(nodes, uint64(col.Len))
+		nodes = le.AppendUint64(nodes, uint64(col.NullCount))
+		if col.Type == Null {
+			continue // Null の列はバッファを持たない
+		}
+		if col.NullCount > 0 {
+			addBuffer(col.Validity)
+		} else {
+			addBuffer(nil)
+		}
+		if col.Type == String || col.Type == Binary {
+			addBuffer(col.Offsets)
+		}
+		addBuffer(col.Values)
+	}
+	header := fbTable{int64(rec.NumRows), nodes, bufs}
+	return w.message(headerRecordBatch, header, body)
+}
+
+// Close はまだ書いていなければスキーマを書き、ストリームの終わりの印を書きます。w は閉じません。
+func (w *Writer) Close() error {
+	if err := w.begin(); err != nil {
+		return err
+	}
+	_, err := w.w.Write(le.AppendUint32(le.AppendUint32(nil, continuation), 0))
+	if err != nil {
+		w.err = err
+	}
+	return err
+}
+
+// begin は最初の呼び出しでスキーマのメッセージを書きます。
+func (w *Writer) begin() error {
+	if w.err != nil || w.begun {
+		return w.err
+	}
+	w.begun = true
+	fields := make([]fbTable, len(w.schema.Fields))
+	for i, f := range w.schema.Fields {
+		id, typ, err := encodeType(f.Type)
+		if err != nil {
+			w.err = err
+			return err
+		}
+		fields[i] = fbTable{f.Name, f.Nullable, id, typ, nil, []fbTable{}}
+	}
+	return w.message(headerSchema, fbTable{int16(0), fields}, nil)
+}
+
+// encodeType は型 t の Type ユニオンの番号とテーブルを返します。
+func encodeType(t Type) (uint8, fbTable, error) {
+	switch t {
+	case Null:
+		return typeNull, fbTable{}, nil
+	case Boolean:
+		return typeBool, fbTable{}, nil
+	case Int32:
+		return typeInt, fbTable{int32(32), true}, nil
+	case Int64:
+		return typeInt, fbTable{int32(64), true}, nil
+	case Float64:
+		return typeFloatingPoint, fbTable{int16(precisionDbl)}, nil
+	case String:
+		return typeUtf8, fbTable{}, nil
+	case Binary:
+		return typeBinary, fbTable{}, nil
+	case Timestamp:
+		return typeTimestamp, fbTable{int16(unitMicro), timestampZone}, nil
+	}
+	return 0, nil, fmt.Errorf("arrow: unknown type %s", t)
+}
+
+// message は種類が headerType のメッセージを書きます。
+func (w *Writer) message(headerType uint8, header fbTable, body []byte) error {
+	meta := fbEncode(fbTable{int16(metadataV5), headerType, header, int64(len(body))})
+	buf := make([]byte, 0, 8+len(meta)+len(body))
+	buf = le.AppendUint32(buf, continuation)
+	buf = le.AppendUint32(buf, uint32(len(meta)))
+	buf = append(buf, meta...)
+	buf = append(buf, body...)
+	if _, err := w.w.Write(buf); err != nil {
+		w.err = err
+		return err
+	}
+	return nil
+}
+
+// Reader は Arrow IPC のストリーム形式のレコードバッチを読みます。
+type Reader struct {
+	r      io.Reader
+	schema *Schema
+	err    error
+}
+
+// NewReader は r からストリームのスキーマを読み、レコードバッチを読む Reader を返します。
+func NewReader(r io.Reader) (*Reader, error) {
+	rd := &Reader{r: r}
+	d, header, typ, _, err := rd.message()
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return nil, err
+	}
+	if typ != headerSchema {
+		return nil, errors.New("arrow: stream does not start with a schema")
+	}
+	if rd.schema, err = decodeSchema(d, header); err != nil {
+		return nil, err
+	}
+	return rd, nil
+}
+
+// Schema はストリームのスキーマを返します。
+func (r *Reader) Schema() *Schema { return r.schema }
+
+// Next は次のレコードバッチを返します。終わりの印まで読むと io.EOF を返します。終わりの印の前に
+// ストリームが終われば io.ErrUnexpectedEOF を返します。レコードバッチのバッファはメッセージの
+// 本体を複製せずに指します。
+func (r *Reader) Next() (*Record, error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+	d, header, typ, body, err := r.message()
+	if err == nil && typ != headerRecordBatch {
+		err = fmt.Errorf("arrow: unsupported message type %d", typ)
+	}
+	var rec *Record
+	if err == nil {
+		rec, err = r.decodeRecord(d, header, body)
+	}
+	if err != nil {
+		r.err = err
+		return nil, err
+	}
+	return rec, nil
+}
+
+// message は次のメッセージを読み、メタデータ、ヘッダのテーブルの位置、ヘッダの種類、本体を返します。
+// 終わりの印を読むと io.EOF を返します。
+func (r *Reader) message() (*fbDecoder, int, uint8, []byte, error) {
+	var prefix [4]byte
+	if _, err := io.ReadFull(r.r, prefix[:]); err != nil {
+		return nil, 0, 0, nil, unexpectedEOF(err)
+	}
+	// 継続の印のない古い形式では、最初の4バイトがメタデータの長さ
+	n := le.Uint32(prefix[:])
+	if n == continuation {
+		if _, err := io.ReadFull(r.r, prefix[:]); err != nil {
+			return nil, 0, 0, nil, unexpectedEOF(err)
+		}
+		n = le.Uint32(prefix[:])
+	}
+	if n == 0 {
+		return nil, 0, 0, nil, io.EOF
+	}
+	if n > maxMetadata {
+		return nil, 0, 0, nil, fmt.Errorf("arrow: message metadata of %d bytes is too large", n)
+	}
+	meta := make([]byte, n)
+	if _, err := io.ReadFull(r.r, meta); err != nil {
+		return nil, 0, 0, nil, unexpectedEOF(err)
+	}
+	d := &fbDecoder{buf: meta}
+	msg := d.root()
+	version := d.integer(msg, 0, 2)
+	typ := uint8(d.integer(msg, 1, 1))
+	header := d.table(msg, 2)
+	bodyLen := d.integer(msg, 3, 8)
+	if d.err != nil {
+		return nil, 0, 0, nil, d.err
+	}
+	if version < metadataV4 {
+		return nil, 0, 0, nil, fmt.Errorf("arrow: unsupported metadata version %d", version)
+	}
+	if header < 0 || bodyLen < 0 {
+		return nil, 0, 0, nil, errFlatBuffer
+	}
+	// 本体の長さは信用せずに、読めた分だけ確保する
+	var body bytes.Buffer
+	if m, err := body.ReadFrom(io.LimitReader(r.r, bodyLen)); err != nil {
+		return nil, 0, 0, nil, err
+	} else if m < bodyLen {
+		return nil, 0, 0, nil, io.ErrUnexpectedEOF
+	}
+	return d, header, typ, body.Bytes(), nil
+}
+
+// unexpectedEOF はメッセージの途中で終わった場合の io.EOF を io.ErrUnexpectedEOF にします。
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// decodeSchema は Schema テーブル t を読みます。
+func decodeSchema(d *fbDecoder, t int) (*Schema, error) {
+	if d.integer(t, 0, 2) != 0 {
+		return nil, errors.New("arrow: big-endian streams are not supported")
+	}
+	start, n := d.vector(t, 1, 4)
+	schema := &Schema{Fields: make([]Field, 0, n)}
+	for i := range n {
+		f := d.ref(start + 4*i)
+		field := Field{Name: d.str(f, 0), Nullable: d.integer(f, 1, 1) != 0}
+		id := d.integer(f, 2, 1)
+		typ := d.table(f, 3)
+		if d.table(f, 4) >= 0 {
+			return nil, fmt.Errorf("arrow: column %s: dictionary encoding is not supported", field.Name)
+		}
+		if _, children := d.vector(f, 5, 4); children > 0 {
+			return nil, fmt.Errorf("arrow: column %s: nested types are not supported", field.Name)
+		}
+		var ok bool
+		switch id {
+		case typeNull:
+			field.Type, ok = Null, true
+		case typeBool:
+			field.Type, ok = Boolean, true
+		case typeInt:
+			signed := d.integer(typ, 1, 1) != 0
+			switch d.integer(typ, 0, 4) {
+			case 32:
+				field.Type, ok = Int32, signed
+			case 64:
+				field.Type, ok = Int64, signed
+			}
+		case typeFloatingPoint:
+			field.Type, ok = Float64, d.integer(typ, 0, 2) == precisionDbl
+		case typeUtf8:
+			field.Type, ok = String, true
+		case typeBinary:
+			field.Type, ok = Binary, true
+		case typeTimestamp:
+			field.Type, ok = Timestamp, d.integer(typ, 0, 2) == unitMicro
+		}
+		if d.err != nil {
+			return nil, d.err
+		}
+		if !ok {
+			return nil, fmt.Errorf("arrow: column %s: unsupported type (type id %d)", field.Name, id)
+		}
+		schema.Fields = append(schema.Fields, field)
+	}
+	if d.err != nil {
+		return nil, d.err
+	}
+	return schema, nil
+}
+
+// decodeRecord は RecordBatch テーブル t と本体 body からレコードバッチを作ります。
+func (r *Reader) decodeRecord(d *fbDecoder, t int, body []byte) (*Record, error) {
+	length := d.integer(t, 0, 8)
+	nodes, nnodes := d.vector(t, 1, 16)
+	bufs, nbufs := d.vector(t, 2, 16)
+	if d.table(t, 3) >= 0 {
+		return nil, errors.New("arrow: compressed record batches are not supported")
+	}
+	if d.err != nil {
+		return nil, d.err
+	}
+	if length < 0 || length > math.MaxInt32 {
+		return nil, fmt.Errorf("arrow: record batch of %d rows is not supported", length)
+	}
+	if nnodes != len(r.schema.Fields) {
+		return nil, fmt.Errorf("arrow: record batch has %d columns, want %d", nnodes, len(r.schema.Fields))
+	}
+	n := int(length)
+	rec := &Record{Schema: r.schema, Columns: make([]*Column, nnodes), NumRows: n}
+	next := 0
+	buffer := func() ([]byte, error) {
+		if next >= nbufs {
+			return nil, errors.New("arrow: record batch has too few buffers")
+		}
+		off := int64(d.u64(bufs + 16*next))
+		size := int64(d.u64(bufs + 16*next + 8))
+		next++
+		if off < 0 || size < 0 || off > int64(len(body)) || size > int64(len(body))-off {
+			return nil, errors.New("arrow: buffer is out of the message body")
+		}
+		return body[off : off+size : off+size], nil
+	}
+	for i, f := range r.schema.Fields {
+		col := &Column{
+			Type:      f.Type,
+			Len:       int(d.u64(nodes + 16*i)),
+			NullCount: int(d.u64(nodes + 16*i + 8)),
+		}
+		if col.Len != n || col.NullCount < 0 || col.NullCount > n {
+			return nil, fmt.Errorf("arrow: column %s has an invalid length", f.Name)
+		}
+		rec.Columns[i] = col
+		if f.Type == Null {
+			col.NullCount = n
+			continue
+		}
+		validity, err := buffer()
+		if err != nil {
+			return nil, err
+		}
+		if col.NullCount > 0 {
+			if len(validity) < (n+7)/8 {
+				return nil, fmt.Errorf("arrow: column %s has a short validity bitmap", f.Name)
+			}
+			col.Validity = validity
+		}
+		if f.Type == String || f.Type == Binary {
+			if col.Offsets, err = buffer(); err != nil {
+				return nil, err
+			}
+		}
+		if col.Values, err = buffer(); err != nil {
+			return nil, err
+		}
+		if err := checkColumn(col); err != nil {
+			return nil, fmt.Errorf("arrow: column %s: %w", f.Name, err)
+		}
+	}
+	return rec, d.err
+}
+
+// checkColumn は列のバッファが値の数に足りるかを確かめます。
+func checkColumn(c *Column) error {
+	switch c.Type {
+	case Boolean:
+		if len(c.Values) < (c.Len+7)/8 {
+			return errors.New("short values buffer")
+		}
+	case String, Binary:
+		if len(c.Offsets) < 4*(c.Len+1) {
+			return errors.New("short offsets buffer")
+		}
+		prev := le.Uint32(c.Offsets)
+		for i := 1; i <= c.Len; i++ {
+			off := le.Uint32(c.Offsets[4*i:])
+			if off < prev || int64(off) > int64(len(c.Values)) {
+				return errors.New("invalid offsets")
+			}
+			prev = off
+		}
+	default:
+		if len(c.Values) < c.Len*c.Type.width() {
+			return errors.New("short values buffer")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,244 @@
+package arrow
+
+import (
+	"bytes"
+	"flag"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var update = flag.Bool("update", false, "rewrite testdata/alltypes.arrows with the output of Writer")
+
+// allTypes は testdata の2つのストリームのスキーマです。
+var allTypes = &Schema{Fields: []Field{
+	{Name: "null", Type: Null, Nullable: true},
+	{Name: "bool", Type: Boolean, Nullable: true},
+	{Name: "int32", Type: Int32, Nullable: true},
+	{Name: "int64", Type: Int64, Nullable: true},
+	{Name: "float64", Type: Float64, Nullable: true},
+	{Name: "utf8", Type: String, Nullable: true},
+	{Name: "binary", Type: Binary, Nullable: true},
+	{Name: "timestamp", Type: Timestamp, Nullable: true},
+}}
+
+// allTypesBatches は testdata の2つのストリームのレコードバッチの行です。1つ目は NULL を含んで
+// ビットマップが2バイトにまたがり、2つ目は空で、3つ目は Null の列のほかに NULL がありません。
+// testdata/arrow-go.arrows を作り直すときは、同じ行を Apache Arrow の Go の実装で書いてください。
+var allTypesBatches = [][][]any{
+	{
+		{nil, true, int32(math.MinInt32), int64(math.MinInt64), 0.0, "", []byte{}, time.UnixMicro(0).UTC()},
+		{nil, false, nil, int64(-1), -0.5, "héllo", []byte{0x00, 0xff}, time.Date(1969, 12, 31, 23, 59, 59, 999999000, time.UTC)},
+		{nil, nil, int32(-1), nil, math.Inf(1), "日本語", nil, time.Date(2024, 2, 29, 12, 34, 56, 789012000, time.UTC)},
+		{nil, true, int32(0), int64(0), nil, nil, []byte("abc"), nil},
+		{nil, false, int32(42), int64(1) << 40, 1e300, "a", []byte{1}, time.Date(1900, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{nil, nil, nil, nil, nil, nil, nil, nil},
+		{nil, true, int32(7), int64(-42), math.Inf(-1), "tab\there", []byte{0, 0, 0}, time.Date(9999, 12, 31, 23, 59, 59, 999999000, time.UTC)},
+		{nil, false, int32(-7), int64(7), 3.25, "", nil, time.UnixMicro(1).UTC()},
+		{nil, true, nil, int64(math.MaxInt64), math.SmallestNonzeroFloat64, "last but one", []byte("x"), nil},
+		{nil, false, int32(math.MaxInt32), int64(99), -1e-300, "🙂", []byte{}, time.UnixMicro(-1).UTC()},
+	},
+	{},
+	{
+		{nil, true, int32(1), int64(2), 3.5, "x", []byte("y"), time.UnixMicro(1700000000000000).UTC()},
+		{nil, false, int32(-1), int64(-2), -3.5, "", []byte{}, time.UnixMicro(0).UTC()},
+	},
+}
+
+// typeValues は型ごとの、NULL でない値の例です。
+var typeValues = map[Type][]any{
+	Null:      {},
+	Boolean:   {true, false, true, true, false, false, true, false, true},
+	Int32:     {int32(0), int32(1), int32(-1), int32(math.MinInt32), int32(math.MaxInt32), int32(1 << 20)},
+	Int64:     {int64(0), int64(1), int64(-1), int64(math.MinInt64), int64(math.MaxInt64), int64(1) << 40},
+	Float64:   {0.0, math.Copysign(0, -1), 1.5, -2.25, math.MaxFloat64, math.SmallestNonzeroFloat64, math.Inf(1), math.Inf(-1)},
+	String:    {"", "a", "héllo", "日本語", "🙂", "a\x00b", string(make([]byte, 1000))},
+	Binary:    {[]byte{}, []byte{0}, []byte{0xff, 0xfe}, []byte("abc"), make([]byte, 1000)},
+	Timestamp: {time.UnixMicro(0).UTC(), time.UnixMicro(-1).UTC(), time.Date(1, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(9999, 12, 31, 23, 59, 59, 999999000, time.UTC)},
+}
+
+// TestRoundTrip は型ごとに、Writer で書いたストリームを Reader で読むと同じ値に戻ることを
+// 確かめます。NULL を含むバッチ、空のバッチ、NULL だけのバッチ、NULL のないバッチを書きます。
+func TestRoundTrip(t *testing.T) {
+	for typ := Null; typ <= Timestamp; typ++ {
+		t.Run(typ.String(), func(t *testing.T) {
+			values := typeValues[typ]
+			var withNulls, allNull []any
+			for i, v := range values {
+				withNulls = append(withNulls, v)
+				if i%3 == 1 {
+					withNulls = append(withNulls, nil)
+				}
+			}
+			for range 9 {
+				allNull = append(allNull, nil)
+			}
+			if typ == Null {
+				values = nil // Null の列には NULL しか入らない
+			}
+			schema := &Schema{Fields: []Field{{Name: "v", Type: typ, Nullable: true}}}
+			var batches [][][]any
+			for _, vs := range [][]any{withNulls, nil, allNull, values} {
+				var rows [][]any
+				for _, v := range vs {
+					rows = append(rows, []any{v})
+				}
+				batches = append(batches, rows)
+			}
+			got := readAll(t, bytes.NewReader(writeAll(t, schema, batches)))
+			checkStream(t, got, schema, batches)
+		})
+	}
+}
+
+// TestRoundTripAllTypes はすべての型の列を並べたストリームを書いて読みます。
+func TestRoundTripAllTypes(t *testing.T) {
+	got := readAll(t, bytes.NewReader(writeAll(t, allTypes, allTypesBatches)))
+	checkStream(t, got, allTypes, allTypesBatches)
+}
+
+// TestEmptyStream はレコードバッチのないストリームを書いて読みます。
+func TestEmptyStream(t *testing.T) {
+	got := readAll(t, bytes.NewReader(writeAll(t, allTypes, nil)))
+	checkStream(t, got, allTypes, nil)
+}
+
+// TestGoldenWrite は Writer の出力が testdata/alltypes.arrows と1バイトも違わないことを確かめます。
+// このファイルは Writer で書いたもので（go test -update で書き直します）、書き直したときに
+// Apache Arrow の Go の実装（github.com/apache/arrow/go/arrow v0.0.0-20211112161151-bc219186db40 の
+// ipc.Reader）で読んで、同じ値になることを確かめてあります。PyArrow など、ほかの実装では確かめていません。
+func TestGoldenWrite(t *testing.T) {
+	path := filepath.Join("testdata", "alltypes.arrows")
+	got := writeAll(t, allTypes, allTypesBatches)
+	if *update {
+		if err := os.WriteFile(path, got, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("Writer output differs from %s (%d bytes, want %d)", path, len(got), len(want))
+	}
+}
+
+// TestGoldenRead は Apache Arrow の Go の実装（github.com/apache/arrow/go/arrow
+// v0.0.0-20211112161151-bc219186db40 の ipc.Writer）が allTypesBatches を書いた testdata/arrow-go.arrows を
+// 読みます。
+func TestGoldenRead(t *testing.T) {
+	f, err := os.Open(filepath.Join("testdata", "arrow-go.arrows"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	checkStream(t, readAll(t, f), allTypes, allTypesBatches)
+}
+
+// writeAll は batches の行をレコードバッチにして、ストリームに書きます。
+func writeAll(t *testing.T, schema *Schema, batches [][][]any) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w := NewWriter(&buf, schema)
+	b := NewBuilder(schema)
+	for _, rows := range batches {
+		for _, row := range rows {
+			if err := b.Append(row); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := w.Write(b.NewRecord()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// stream は読んだストリームのスキーマとレコードバッチです。
+type stream struct {
+	schema  *Schema
+	records []*Record
+}
+
+// readAll はストリームを終わりの印まで読みます。
+func readAll(t *testing.T, r io.Reader) stream {
+	t.Helper()
+	rd, err := NewReader(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := stream{schema: rd.Schema()}
+	for {
+		rec, err := rd.Next()
+		if err == io.EOF {
+			return s
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.records = append(s.records, rec)
+	}
+}
+
+// checkStream は読んだストリームが schema と batches の行に一致するかを確かめます。
+func checkStream(t *testing.T, got stream, schema *Schema, batches [][][]any) {
+	t.Helper()
+	if len(got.schema.Fields) != len(schema.Fields) {
+		t.Fatalf("schema has %d fields, want %d", len(got.schema.Fields), len(schema.Fields))
+	}
+	for i, f := range got.schema.Fields {
+		if f != schema.Fields[i] {
+			t.Errorf("field %d = %+v, want %+v", i, f, schema.Fields[i])
+		}
+	}
+	if len(got.records) != len(batches) {
+		t.Fatalf("read %d record batches, want %d", len(got.records), len(batches))
+	}
+	for bi, rec := range got.records {
+		rows := batches[bi]
+		if rec.NumRows != len(rows) {
+			t.Errorf("batch %d has %d rows, want %d", bi, rec.NumRows, len(rows))
+			continue
+		}
+		for ci, col := range rec.Columns {
+			nulls := 0
+			for ri, row := range rows {
+				if row[ci] == nil {
+					nulls++
+				}
+				if v := col.Value(ri); !equal(v, row[ci]) {
+					t.Errorf("batch %d, column %s, row %d = %#v, want %#v", bi, schema.Fields[ci].Name, ri, v, row[ci])
+				}
+			}
+			if col.Len != len(rows) || col.NullCount != nulls {
+				t.Errorf("batch %d, column %s: Len = %d, NullCount = %d, want %d and %d",
+					bi, schema.Fields[ci].Name, col.Len, col.NullCount, len(rows), nulls)
+			}
+		}
+	}
+}
+
+// equal は Column.Value が返した値 got が want と同じかを返します。浮動小数点数はビットで比べます。
+func equal(got, want any) bool {
+	switch want := want.(type) {
+	case nil:
+		return got == nil
+	case []byte:
+		g, ok := got.([]byte)
+		return ok && bytes.Equal(g, want)
+	case time.Time:
+		g, ok := got.(time.Time)
+		return ok && g.Equal(want) && g.Location() == time.UTC
+	case float64:
+		g, ok := got.(float64)
+		return ok && math.Float64bits(g) == math.Float64bits(want)
+	}
+	return got == want
+}
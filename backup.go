@@ -0,0 +1,28 @@
+package rdbms
+
+import "context"
+
+// Backup はほかのトランザクションを止めずに、データベースの一貫したスナップショットを dst に
+// 書き写します。dst の内容はすべて置き換えられます。dst は開いている別のデータベースで、ファイルでも
+// Memory でもかまいませんが、同じページサイズでなければなりません。
+//
+// ページは pagesPerStep ページずつ写し（0 以下ならすべてを一度に）、1ステップごとに progress
+// （nil でなければ）に写したページの数と全体のページの数を渡します。写している間も db は読み書きを
+// 続けられ、写した内容は Backup を始めた時点のデータベースと同じになります。dst には最後に
+// 1回のコミットで反映するので、途中で失敗すれば dst は元のままです。
+//
+//	// ファイルのデータベースをメモリ上に読み込む
+//	mem, err := rdbms.Open(rdbms.Memory, rdbms.Options{})
+//	...
+//	err = db.Backup(mem, 100, func(copied, total int64) {
+//		log.Printf("backup: %d/%d pages", copied, total)
+//	})
+func (db *DB) Backup(dst *DB, pagesPerStep int, progress func(copied, total int64)) error {
+	return db.BackupContext(context.Background(), dst, pagesPerStep, progress)
+}
+
+// BackupContext は Backup と同じですが、ステップごとに ctx が取り消されていないかを確かめ、
+// 取り消されていれば dst を元のままにして ctx のエラーを返します。
+func (db *DB) BackupContext(ctx context.Context, dst *DB, pagesPerStep int, progress func(copied, total int64)) error {
+	return db.db.BackupTo(ctx, dst.db, pagesPerStep, progress)
+}
@@ -0,0 +1,52 @@
+package rdbms
+
+import (
+	"context"
+	"math/rand/v2"
+	"time"
+)
+
+// BusyHandler はロックを取れなかったときに、もう一度要求するかを決める関数です。n は同じ要求について
+// 何回目の呼び出しか（1 から）で、true を返すともう一度要求します。要求し直す前に待つのは
+// BusyHandler の役目で、ctx が取り消されたら待つのをやめて false を返してください。
+//
+// Options.BusyHandler を設定すると、TxOptions で LockTimeout と NoWait を指定しないトランザクションは、
+// ロックを取れなかったときにすぐ ErrLocked の種類のエラーを返す代わりに BusyHandler を呼び出します。
+// Options.BusyTimeout が 0 ならロックを待たずに呼び出すので、待つ時間は BusyHandler が決めます。
+// 0 より大きければ、その時間だけ待ってから呼び出します。
+//
+// DB.Exec と DB.ExecContext は、文を実行するトランザクションがデッドロックで中断された場合も
+// BusyHandler を呼び出し、true が返れば新しいトランザクションで文を実行し直します。
+// Begin で始めたトランザクションのデッドロックは、ロールバックされてそのままエラーになります。
+type BusyHandler func(ctx context.Context, n int) bool
+
+// Backoff は、最初は base、それから倍ずつ limit まで延ばした時間（それぞれ半分までの揺らぎを加えます）を
+// 待ってから要求し直し、attempts 回でやめる BusyHandler を返します。
+//
+//	db, err := rdbms.Open("app.db", rdbms.Options{
+//		Journal:     rdbms.JournalWAL,
+//		BusyHandler: rdbms.Backoff(10, time.Millisecond, 100*time.Millisecond),
+//	})
+func Backoff(attempts int, base, limit time.Duration) BusyHandler {
+	return func(ctx context.Context, n int) bool {
+		if n > attempts {
+			return false
+		}
+		d := base
+		for i := 1; i < n && d < limit; i++ {
+			d *= 2
+		}
+		d = min(d, limit)
+		if d > 0 {
+			d += rand.N(d/2 + 1)
+		}
+		t := time.NewTimer(d)
+		defer t.Stop()
+		select {
+		case <-t.C:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
@@ -0,0 +1,224 @@
+// Package client は minirdb serve のサーバーに internal/wire のプロトコルで接続するクライアントです。
+// 埋め込みの rdbms パッケージと同じ形の API で、ネットワーク越しにデータベースを使えます。
+//
+//	db, err := client.Open("db.example.com:5544", client.Options{User: "app", Password: pw})
+//	if err != nil {
+//		return err
+//	}
+//	defer db.Close()
+//	if _, err := db.ExecContext(ctx, "INSERT INTO users VALUES (?, ?)", 1, "alice"); err != nil {
+//		return err
+//	}
+//	for row, err := range db.Rows(ctx, "SELECT id, name FROM users") {
+//		if err != nil {
+//			return err
+//		}
+//		var id int64
+//		var name string
+//		if err := row.Scan(&id, &name); err != nil {
+//			return err
+//		}
+//	}
+//
+// DB は接続をプールし、文を実行するたびに空いている接続を使います。トランザクションは接続ごとに
+// 分かれるので、BEGIN から COMMIT までは Conn か Tx で1つの接続を使い続けます。
+//
+// 取り消しとタイムアウトは ctx で指定します。ctx が取り消されると、その接続の読み書きを止めて
+// 接続を閉じます。プロトコルには実行中の文を取り消す要求がないので、サーバーは応答を書こうとする
+// まで気づかず、取り消した文が最後まで実行されることもあります。
+package client
+
+import (
+	"cmp"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"iter"
+	"sync"
+	"time"
+)
+
+// Options はクライアントの設定です。ゼロ値は既定の設定です。
+type Options struct {
+	// User と Password はログインするユーザーの名前とパスワードです。
+	User     string
+	Password string
+	// TLSConfig は接続を暗号化する TLS の設定です。ServerName を空にすると、アドレスのホスト名を
+	// 使います。nil なら暗号化しません。
+	TLSConfig *tls.Config
+	// DialTimeout はサーバーに TCP で接続するのを待つ時間の上限です。0 なら上限はありません。
+	DialTimeout time.Duration
+	// MaxConns は同時に使う接続の数の上限です。上限に達すると、文の実行と DB.Conn は接続が空くまで
+	// 待ちます。0 なら上限はありません。
+	MaxConns int
+	// MaxIdleConns は使い終えた接続を閉じずに取っておく数です。0 なら 2 です。
+	MaxIdleConns int
+	// MaxCachedStmts は Prepare で準備した文を、接続ごとにサーバーに準備しておく数の上限です。
+	// 上限に達すると準備した文をすべて閉じて、使うときに準備し直します。0 なら 256 です。
+	MaxCachedStmts int
+}
+
+const (
+	defaultMaxIdleConns   = 2
+	defaultMaxCachedStmts = 256
+)
+
+// DB はサーバーの接続のプールです。複数のゴルーチンから使えます。
+type DB struct {
+	addr string
+	opts Options
+	sem  chan struct{} // 使用中の接続の数を MaxConns までに抑える（nil なら上限なし）
+
+	mu     sync.Mutex
+	idle   []*conn // 使い終えた接続
+	closed bool
+}
+
+// Open はアドレス addr のサーバーに接続し、ログインできることを確かめてから DB を返します。
+func Open(addr string, opts Options) (*DB, error) {
+	if opts.MaxConns < 0 {
+		return nil, fmt.Errorf("invalid max conns: %d", opts.MaxConns)
+	}
+	opts.MaxIdleConns = cmp.Or(opts.MaxIdleConns, defaultMaxIdleConns)
+	opts.MaxCachedStmts = cmp.Or(opts.MaxCachedStmts, defaultMaxCachedStmts)
+	db := &DB{addr: addr, opts: opts}
+	if opts.MaxConns > 0 {
+		db.sem = make(chan struct{}, opts.MaxConns)
+	}
+	c, err := db.get(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	db.put(c)
+	return db, nil
+}
+
+// Close は空いている接続を閉じ、それ以降の DB の使用をエラーにします。使用中の接続は、Rows や
+// Conn を閉じたときに閉じます。
+func (db *DB) Close() error {
+	db.mu.Lock()
+	db.closed = true
+	idle := db.idle
+	db.idle = nil
+	db.mu.Unlock()
+	for _, c := range idle {
+		c.close()
+	}
+	return nil
+}
+
+// get は空いている接続を取り出すか、新しく接続します。
+func (db *DB) get(ctx context.Context) (*conn, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, context.Cause(ctx)
+	}
+	if db.sem != nil {
+		select {
+		case db.sem <- struct{}{}:
+		case <-ctx.Done():
+			return nil, context.Cause(ctx)
+		}
+	}
+	db.mu.Lock()
+	if db.closed {
+		db.mu.Unlock()
+		db.release()
+		return nil, ErrClosed
+	}
+	if n := len(db.idle); n > 0 {
+		c := db.idle[n-1]
+		db.idle = db.idle[:n-1]
+		db.mu.Unlock()
+		return c, nil
+	}
+	db.mu.Unlock()
+	c, err := dial(ctx, db.addr, &db.opts)
+	if err != nil {
+		db.release()
+		return nil, err
+	}
+	return c, nil
+}
+
+// put は使い終えた接続 c をプールに返します。使えなくなった接続と、トランザクションの途中の接続は
+// 閉じます。
+func (db *DB) put(c *conn) {
+	defer db.release()
+	if !c.bad && !c.inTx {
+		db.mu.Lock()
+		if !db.closed && len(db.idle) < db.opts.MaxIdleConns {
+			db.idle = append(db.idle, c)
+			db.mu.Unlock()
+			return
+		}
+		db.mu.Unlock()
+	}
+	c.close()
+}
+
+func (db *DB) release() {
+	if db.sem != nil {
+		<-db.sem
+	}
+}
+
+// Exec は結果の行を返さない SQL 文を実行し、変更した行の数を返します。
+func (db *DB) Exec(sql string, args ...any) (int64, error) {
+	return db.ExecContext(context.Background(), sql, args...)
+}
+
+// ExecContext は空いている接続で SQL 文を実行し、変更した行の数を返します。文はそれぞれ
+// サーバーで自動的にコミットします。BEGIN は使わずに、Conn か BeginTx を使ってください
+// （BEGIN したままの接続はプールに返さずに閉じるので、トランザクションはロールバックします）。
+func (db *DB) ExecContext(ctx context.Context, sql string, args ...any) (int64, error) {
+	return db.exec(ctx, sql, false, args)
+}
+
+// exec は空いている接続で文を実行します。stmt なら接続ごとに準備した文で実行します。
+func (db *DB) exec(ctx context.Context, sql string, stmt bool, args []any) (int64, error) {
+	c, err := db.get(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer db.put(c)
+	return c.exec(ctx, sql, stmt, args)
+}
+
+// Query は問い合わせを実行し、結果の行を返します。
+func (db *DB) Query(sql string, args ...any) (*Rows, error) {
+	return db.QueryContext(context.Background(), sql, args...)
+}
+
+// QueryContext は空いている接続で問い合わせを実行し、結果の行を返します。Rows を閉じるまで
+// 接続を使い続けるので、読み終えたら必ず閉じてください。
+func (db *DB) QueryContext(ctx context.Context, sql string, args ...any) (*Rows, error) {
+	return db.query(ctx, sql, false, args)
+}
+
+func (db *DB) query(ctx context.Context, sql string, stmt bool, args []any) (*Rows, error) {
+	c, err := db.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := c.query(ctx, sql, stmt, args, db.put)
+	if err != nil {
+		db.put(c)
+		return nil, err
+	}
+	return rows, nil
+}
+
+// Rows は QueryContext で問い合わせを実行し、結果の行を繰り返すイテレータを返します（rows.go）。
+func (db *DB) Rows(ctx context.Context, sql string, args ...any) iter.Seq2[Row, error] {
+	return queryRows(func() (*Rows, error) { return db.QueryContext(ctx, sql, args...) })
+}
+
+// Conn は1つの接続を取り出して、セッションとして使えるようにします。Options.MaxConns の数の接続が
+// 使われていれば、どれかが空くか ctx が取り消されるまで待ちます。
+func (db *DB) Conn(ctx context.Context) (*Conn, error) {
+	c, err := db.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &Conn{db: db, c: c}, nil
+}
@@ -0,0 +1,384 @@
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"iter"
+	"net"
+	"time"
+
+	"github.com/k-sml/go-rdbms/internal/wire"
+)
+
+// conn はサーバーへの1つの接続です。要求を1つ送るたびに、その応答を最後まで読んでから次の要求を
+// 送ります。
+type conn struct {
+	nc       net.Conn
+	r        *wire.Reader
+	w        *wire.Writer
+	buf      []byte               // 送るメッセージの本体
+	stmts    map[string]*prepared // SQL ごとにサーバーで準備した文
+	maxStmts int
+	closing  int  // 応答を読んでいない CloseStmt の数
+	inTx     bool // BEGIN で始めたトランザクションの中
+	bad      bool // 読み書きに失敗したので、もう使えない
+}
+
+// prepared はサーバーで準備した文です。
+type prepared struct {
+	id          uint32
+	nparams     int
+	returnsRows bool
+}
+
+// dial は addr のサーバーに接続してログインします。
+func dial(ctx context.Context, addr string, opts *Options) (*conn, error) {
+	d := net.Dialer{Timeout: opts.DialTimeout}
+	nc, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if cfg := opts.TLSConfig; cfg != nil {
+		if cfg.ServerName == "" {
+			host, _, err := net.SplitHostPort(addr)
+			if err != nil {
+				host = addr
+			}
+			cfg = cfg.Clone()
+			cfg.ServerName = host
+		}
+		tc := tls.Client(nc, cfg)
+		if err := tc.HandshakeContext(ctx); err != nil {
+			nc.Close()
+			return nil, err
+		}
+		nc = tc
+	}
+	c := &conn{nc: nc, r: wire.NewReader(nc), w: wire.NewWriter(nc), stmts: make(map[string]*prepared), maxStmts: opts.MaxCachedStmts}
+	stop := c.watch(ctx)
+	err = c.startup(opts.User, opts.Password)
+	if cerr := stop(); cerr != nil && err != nil {
+		err = cerr
+	}
+	if err != nil {
+		nc.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// startup は Startup を送ってログインします。
+func (c *conn) startup(user, password string) error {
+	b := wire.AppendUint16(c.buf[:0], wire.Version)
+	b = wire.AppendString(b, user)
+	c.buf = wire.AppendString(b, password)
+	if err := c.send(wire.Startup, c.buf); err != nil {
+		return err
+	}
+	typ, d, err := c.read()
+	if err != nil {
+		return err
+	}
+	switch typ {
+	case wire.AuthOK:
+		return nil
+	case wire.Error:
+		return c.serverError(d)
+	}
+	return c.unexpected(typ)
+}
+
+func (c *conn) close() error {
+	if !c.bad {
+		c.w.Write(wire.Terminate, nil)
+		c.w.Flush()
+	}
+	return c.nc.Close()
+}
+
+// watch は ctx が取り消されたら接続の読み書きを止めるようにします。戻り値の関数は見張りをやめ、
+// 読み書きを止めていれば接続を使えないものにして ctx のエラーを返します。
+func (c *conn) watch(ctx context.Context) func() error {
+	if ctx.Done() == nil {
+		return func() error { return nil }
+	}
+	stop := context.AfterFunc(ctx, func() { c.nc.SetDeadline(time.Unix(1, 0)) })
+	return func() error {
+		if stop() {
+			return nil
+		}
+		c.bad = true
+		return context.Cause(ctx)
+	}
+}
+
+// send は種類 typ のメッセージを送ります。
+func (c *conn) send(typ byte, body []byte) error {
+	if err := c.w.Write(typ, body); err != nil {
+		c.bad = true
+		return err
+	}
+	if err := c.w.Flush(); err != nil {
+		c.bad = true
+		return err
+	}
+	return nil
+}
+
+// read は次の応答を読みます。先に送った CloseStmt の応答は読み飛ばします。
+func (c *conn) read() (byte, *wire.Body, error) {
+	for {
+		typ, body, err := c.r.Read()
+		if err != nil {
+			c.bad = true
+			return 0, nil, err
+		}
+		if c.closing > 0 {
+			c.closing--
+			if typ != wire.Complete {
+				return 0, nil, c.unexpected(typ)
+			}
+			continue
+		}
+		return typ, wire.NewBody(body), nil
+	}
+}
+
+// serverError は Error の本体 d をエラーにします。
+func (c *conn) serverError(d *wire.Body) error {
+	e := &Error{Code: d.String(), Message: d.String()}
+	if err := d.Err(); err != nil {
+		c.bad = true
+		return err
+	}
+	return e
+}
+
+// unexpected はプロトコルの誤りのエラーを返し、接続を使えないものにします。
+func (c *conn) unexpected(typ byte) error {
+	c.bad = true
+	return fmt.Errorf("client: unexpected message %q", typ)
+}
+
+// prepare は sql をサーバーで準備します。準備した文は接続を閉じるまで使い回します。
+func (c *conn) prepare(sql string) (*prepared, error) {
+	if p, ok := c.stmts[sql]; ok {
+		return p, nil
+	}
+	if len(c.stmts) >= c.maxStmts {
+		// 準備した文をすべて閉じる。応答は次の要求の応答の前に読む
+		for _, p := range c.stmts {
+			if err := c.w.Write(wire.CloseStmt, wire.AppendUint32(c.buf[:0], p.id)); err != nil {
+				c.bad = true
+				return nil, err
+			}
+			c.closing++
+		}
+		clear(c.stmts)
+	}
+	c.buf = wire.AppendString(c.buf[:0], sql)
+	if err := c.send(wire.Prepare, c.buf); err != nil {
+		return nil, err
+	}
+	typ, d, err := c.read()
+	if err != nil {
+		return nil, err
+	}
+	switch typ {
+	case wire.Prepared:
+		p := &prepared{id: d.Uint32(), nparams: int(d.Uint16()), returnsRows: d.Bool()}
+		if err := d.Err(); err != nil {
+			c.bad = true
+			return nil, err
+		}
+		c.stmts[sql] = p
+		return p, nil
+	case wire.Error:
+		return nil, c.serverError(d)
+	}
+	return nil, c.unexpected(typ)
+}
+
+// start は sql を args で実行する要求を送ります。stmt なら準備した文として実行します。
+func (c *conn) start(sql string, stmt bool, args []any) error {
+	typ := wire.Query
+	b := c.buf[:0]
+	if stmt {
+		p, err := c.prepare(sql)
+		if err != nil {
+			return err
+		}
+		typ = wire.Execute
+		b = wire.AppendUint32(b, p.id)
+	} else {
+		b = wire.AppendString(b, sql)
+	}
+	b, err := wire.AppendValues(b, args)
+	if err != nil {
+		return err
+	}
+	c.buf = b
+	return c.send(typ, b)
+}
+
+// exec は文を実行し、変更した行の数を返します。結果の行は読み捨てます。
+func (c *conn) exec(ctx context.Context, sql string, stmt bool, args []any) (int64, error) {
+	stop := c.watch(ctx)
+	n, err := c.execStmt(sql, stmt, args)
+	if cerr := stop(); cerr != nil && err != nil {
+		err = cerr
+	}
+	return n, err
+}
+
+func (c *conn) execStmt(sql string, stmt bool, args []any) (int64, error) {
+	if err := c.start(sql, stmt, args); err != nil {
+		return 0, err
+	}
+	for {
+		typ, d, err := c.read()
+		if err != nil {
+			return 0, err
+		}
+		switch typ {
+		case wire.RowDescription, wire.DataRow:
+		case wire.Complete:
+			return c.complete(d)
+		case wire.Error:
+			return 0, c.serverError(d)
+		default:
+			return 0, c.unexpected(typ)
+		}
+	}
+}
+
+// complete は Complete の本体 d を読み、変更した行の数を返します。
+func (c *conn) complete(d *wire.Body) (int64, error) {
+	n := d.Int64()
+	inTx := d.Bool()
+	if err := d.Err(); err != nil {
+		c.bad = true
+		return 0, err
+	}
+	c.inTx = inTx
+	return n, nil
+}
+
+// query は問い合わせを実行し、結果の行を返します。Rows を閉じると release を呼びます。ctx は
+// Rows を閉じるまで見張ります。
+func (c *conn) query(ctx context.Context, sql string, stmt bool, args []any, release func(*conn)) (*Rows, error) {
+	stop := c.watch(ctx)
+	r := &Rows{c: c, stop: stop, release: release}
+	err := c.start(sql, stmt, args)
+	if err == nil {
+		err = r.start()
+	}
+	if err != nil {
+		if cerr := stop(); cerr != nil {
+			err = cerr
+		}
+		return nil, err
+	}
+	return r, nil
+}
+
+// Conn は1つの接続を使うセッションです。SQL の BEGIN で始めたトランザクションは、COMMIT か
+// ROLLBACK するまでこの接続で続きます。1つの Conn は1つのゴルーチンから使い、使い終えたら
+// Close で DB に返します。
+//
+//	conn, err := db.Conn(ctx)
+//	if err != nil {
+//		return err
+//	}
+//	defer conn.Close()
+//	conn.ExecContext(ctx, "BEGIN")
+//	conn.ExecContext(ctx, "UPDATE accounts SET balance = balance - ? WHERE id = ?", 100, 1)
+//	conn.ExecContext(ctx, "UPDATE accounts SET balance = balance + ? WHERE id = ?", 100, 2)
+//	_, err = conn.ExecContext(ctx, "COMMIT")
+type Conn struct {
+	db     *DB
+	c      *conn
+	rows   *Rows // 閉じていない結果
+	closed bool
+}
+
+// ready は文を実行できるかを調べます。
+func (cn *Conn) ready() error {
+	switch {
+	case cn.closed:
+		return ErrConnDone
+	case cn.rows != nil:
+		return ErrBusy
+	case cn.c.bad:
+		return ErrBadConn
+	}
+	return nil
+}
+
+// InTx は BEGIN で始めたトランザクションが実行中かを返します。
+func (cn *Conn) InTx() bool { return cn.c.inTx }
+
+// Exec は結果の行を返さない SQL 文を実行し、変更した行の数を返します。
+func (cn *Conn) Exec(sql string, args ...any) (int64, error) {
+	return cn.ExecContext(context.Background(), sql, args...)
+}
+
+// ExecContext は SQL 文を実行し、変更した行の数を返します。BEGIN から COMMIT までの文は、
+// セッションのトランザクションの中で実行します。
+func (cn *Conn) ExecContext(ctx context.Context, sql string, args ...any) (int64, error) {
+	return cn.exec(ctx, sql, false, args)
+}
+
+func (cn *Conn) exec(ctx context.Context, sql string, stmt bool, args []any) (int64, error) {
+	if err := cn.ready(); err != nil {
+		return 0, err
+	}
+	return cn.c.exec(ctx, sql, stmt, args)
+}
+
+// Query は問い合わせを実行し、結果の行を返します。
+func (cn *Conn) Query(sql string, args ...any) (*Rows, error) {
+	return cn.QueryContext(context.Background(), sql, args...)
+}
+
+// QueryContext は問い合わせを実行し、結果の行を返します。Rows を閉じるまで、この Conn で次の文は
+// 実行できません（ErrBusy）。
+func (cn *Conn) QueryContext(ctx context.Context, sql string, args ...any) (*Rows, error) {
+	return cn.query(ctx, sql, false, args)
+}
+
+func (cn *Conn) query(ctx context.Context, sql string, stmt bool, args []any) (*Rows, error) {
+	if err := cn.ready(); err != nil {
+		return nil, err
+	}
+	rows, err := cn.c.query(ctx, sql, stmt, args, func(*conn) { cn.rows = nil })
+	if err != nil {
+		return nil, err
+	}
+	cn.rows = rows
+	return rows, nil
+}
+
+// Rows は QueryContext で問い合わせを実行し、結果の行を繰り返すイテレータを返します（rows.go）。
+func (cn *Conn) Rows(ctx context.Context, sql string, args ...any) iter.Seq2[Row, error] {
+	return queryRows(func() (*Rows, error) { return cn.QueryContext(ctx, sql, args...) })
+}
+
+// Close は閉じていない結果を閉じ、実行中のトランザクションをロールバックしてから接続を DB に
+// 返します。何度呼んでもかまいません。
+func (cn *Conn) Close() error {
+	if cn.closed {
+		return nil
+	}
+	if cn.rows != nil {
+		cn.rows.Close()
+	}
+	cn.closed = true
+	var err error
+	if cn.c.inTx && !cn.c.bad {
+		_, err = cn.c.exec(context.Background(), "ROLLBACK", false, nil)
+	}
+	cn.db.put(cn.c)
+	return err
+}
@@ -0,0 +1,56 @@
+package client
+
+import (
+	"errors"
+
+	"github.com/k-sml/go-rdbms/internal/wire"
+)
+
+var (
+	// ErrClosed は Close した DB を使おうとした場合のエラーです。
+	ErrClosed = errors.New("client: database is closed")
+	// ErrConnDone は Close した Conn を使おうとした場合のエラーです。
+	ErrConnDone = errors.New("client: connection has already been closed")
+	// ErrTxDone はコミットかロールバックを終えたトランザクションを使おうとした場合のエラーです。
+	ErrTxDone = errors.New("client: transaction has already been committed or rolled back")
+	// ErrBadConn は使えなくなった Conn で文を実行しようとした場合のエラーです。読み書きに失敗したか、
+	// ctx の取り消しで読み書きを止めた接続は使えなくなります。
+	ErrBadConn = errors.New("client: connection is broken")
+	// ErrBusy は結果を読み終えていない Rows がある Conn で、次の文を実行しようとした場合のエラーです。
+	ErrBusy = errors.New("client: connection is busy with unread rows")
+)
+
+// Error のコード。サーバーが返したエラーの種類を表します。
+const (
+	CodeError         = wire.CodeError
+	CodeProtocol      = wire.CodeProtocol
+	CodeAuth          = wire.CodeAuth
+	CodeSyntax        = wire.CodeSyntax
+	CodeInvalid       = wire.CodeInvalid
+	CodeConstraint    = wire.CodeConstraint
+	CodeUnique        = wire.CodeUnique
+	CodeNotNull       = wire.CodeNotNull
+	CodeForeignKey    = wire.CodeForeignKey
+	CodeLocked        = wire.CodeLocked
+	CodeDeadlock      = wire.CodeDeadlock
+	CodeSerialization = wire.CodeSerialization
+	CodeReadOnly      = wire.CodeReadOnly
+	CodeCorrupt       = wire.CodeCorrupt
+	CodeCanceled      = wire.CodeCanceled
+	CodeTxDone        = wire.CodeTxDone
+	CodeConnDone      = wire.CodeConnDone
+	CodeUnknownStmt   = wire.CodeUnknownStmt
+)
+
+// Error はサーバーが返したエラーです。サーバーが Error を返しても接続はそのまま使えます。
+//
+//	var e *client.Error
+//	if errors.As(err, &e) && e.Code == client.CodeUnique {
+//		// 重複していた
+//	}
+type Error struct {
+	Code    string // CodeUnique など
+	Message string
+}
+
+func (e *Error) Error() string { return e.Message }
@@ -0,0 +1,213 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"iter"
+	"reflect"
+
+	"github.com/k-sml/go-rdbms/internal/scan"
+	"github.com/k-sml/go-rdbms/internal/types"
+	"github.com/k-sml/go-rdbms/internal/wire"
+)
+
+// ColumnType は結果の列の情報です（rdbms.ColumnType と同じです）。
+type ColumnType struct {
+	Name string
+	// DatabaseTypeName は列の SQL の型（INT、TEXT など）です。型の決まらない式の列なら空です。
+	DatabaseTypeName string
+	// ScanType は列の値を Values で受け取るときの Go の型です。型の決まらない式の列なら any です。
+	ScanType reflect.Type
+}
+
+// Rows は問い合わせの結果です。Next で1行ずつ進め、読み終えたら Close します。行はサーバーが
+// 実行しながら送るので、結果をすべてメモリに持つことはありません。
+type Rows struct {
+	c       *conn
+	stop    func() error // ctx の見張りをやめる
+	release func(*conn)  // 閉じたときに接続を返す
+	cols    []ColumnType
+	row     []types.Value
+	vals    []any
+	err     error
+	done    bool // 応答を最後まで読んだ
+	closed  bool
+}
+
+// start は問い合わせの応答の最初のメッセージを読みます。行を返さない文なら、結果は空です。
+func (r *Rows) start() error {
+	c := r.c
+	typ, d, err := c.read()
+	if err != nil {
+		return err
+	}
+	switch typ {
+	case wire.RowDescription:
+		n := int(d.Uint16())
+		r.cols = make([]ColumnType, n)
+		for i := range r.cols {
+			name, tname := d.String(), d.String()
+			r.cols[i] = ColumnType{Name: name, ScanType: reflect.TypeFor[any]()}
+			if t, err := types.Parse(tname); err == nil {
+				if st, ok := scan.GoType(t); ok {
+					r.cols[i].DatabaseTypeName, r.cols[i].ScanType = tname, st
+				}
+			}
+		}
+		if err := d.Err(); err != nil {
+			c.bad = true
+			return err
+		}
+		return nil
+	case wire.Complete:
+		r.done = true
+		_, err := c.complete(d)
+		return err
+	case wire.Error:
+		return c.serverError(d)
+	}
+	return c.unexpected(typ)
+}
+
+// Columns は結果の列の名前を返します。
+func (r *Rows) Columns() []string {
+	names := make([]string, len(r.cols))
+	for i, col := range r.cols {
+		names[i] = col.Name
+	}
+	return names
+}
+
+// ColumnTypes は結果の列の情報を返します。
+func (r *Rows) ColumnTypes() []ColumnType { return r.cols }
+
+// Next は次の行に進みます。行がなくなるかエラーになると、結果を閉じて false を返します。
+func (r *Rows) Next() bool {
+	r.row = nil
+	if r.closed || r.done {
+		r.Close()
+		return false
+	}
+	if r.next() {
+		return true
+	}
+	r.Close()
+	return false
+}
+
+// next は次の応答を読み、行なら true を返します。
+func (r *Rows) next() bool {
+	c := r.c
+	typ, d, err := c.read()
+	if err != nil {
+		r.err, r.done = err, true
+		return false
+	}
+	switch typ {
+	case wire.DataRow:
+		r.row = d.Row()
+		if err := d.Err(); err != nil {
+			c.bad = true
+			r.err, r.done = err, true
+			return false
+		}
+		return true
+	case wire.Complete:
+		_, r.err = c.complete(d)
+	case wire.Error:
+		r.err = c.serverError(d)
+	default:
+		r.err = c.unexpected(typ)
+	}
+	r.done = true
+	return false
+}
+
+// Values は現在の行の値を、列の順に Go の値（int64, float64, string, []byte, bool,
+// time.Time、NULL なら nil）にして返します。スライスは次の Next の呼び出しまで有効です。
+func (r *Rows) Values() []any {
+	r.vals = r.vals[:0]
+	for _, v := range r.row {
+		r.vals = append(r.vals, v.Go())
+	}
+	return r.vals
+}
+
+// Scan は現在の行の値を、列の順に dest の指す変数に書き込みます。書き込み先と値の変換は
+// rdbms.Rows.Scan と同じです。
+func (r *Rows) Scan(dest ...any) error {
+	if r.row == nil {
+		return errors.New("Scan called without a successful Next")
+	}
+	if len(dest) != len(r.row) {
+		return fmt.Errorf("expected %d destination arguments in Scan, not %d", len(r.row), len(dest))
+	}
+	for i, v := range r.row {
+		if err := scan.Value(dest[i], v); err != nil {
+			return fmt.Errorf("scan column %d (%s): %w", i, r.cols[i].Name, err)
+		}
+	}
+	return nil
+}
+
+// Err は読んでいる途中で起きたエラーを返します。
+func (r *Rows) Err() error { return r.err }
+
+// Close は結果を閉じます。読んでいない行があれば読み捨ててから、接続を返します。何度呼んでも
+// かまいません。
+func (r *Rows) Close() error {
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+	for !r.done {
+		r.next()
+	}
+	if err := r.stop(); err != nil && r.err != nil {
+		r.err = err
+	}
+	r.release(r.c)
+	return nil
+}
+
+// Row は Rows.All などで繰り返す結果の1行です。次の行に進むまで有効です。
+type Row struct {
+	rows *Rows
+}
+
+// Columns は結果の列の名前を返します。
+func (r Row) Columns() []string { return r.rows.Columns() }
+
+// Values は行の値を Rows.Values と同じ Go の値にして返します。
+func (r Row) Values() []any { return r.rows.Values() }
+
+// Scan は行の値を Rows.Scan と同じように dest に読み込みます。
+func (r Row) Scan(dest ...any) error { return r.rows.Scan(dest...) }
+
+// All は残りの行を range で繰り返すイテレータを返します。読んでいる途中でエラーになると、
+// 最後にそのエラーを1回だけ返して終わります。繰り返しを終えるか break で抜けると Rows を閉じます。
+func (r *Rows) All() iter.Seq2[Row, error] {
+	return func(yield func(Row, error) bool) {
+		defer r.Close()
+		for r.Next() {
+			if !yield(Row{rows: r}, nil) {
+				return
+			}
+		}
+		if err := r.Err(); err != nil {
+			yield(Row{}, err)
+		}
+	}
+}
+
+// queryRows は query で始めた問い合わせの行を繰り返すイテレータを返します。
+func queryRows(query func() (*Rows, error)) iter.Seq2[Row, error] {
+	return func(yield func(Row, error) bool) {
+		rows, err := query()
+		if err != nil {
+			yield(Row{}, err)
+			return
+		}
+		rows.All()(yield)
+	}
+}
@@ -0,0 +1,104 @@
+package client
+
+import "context"
+
+// Stmt は Prepare で準備した文です。準備するときにサーバーで SQL を解析するので、構文の誤りは
+// Prepare がエラーにします。DB.Prepare で準備した文は複数のゴルーチンから使えます。
+//
+// 文は接続ごとにサーバーで準備し、その接続を閉じるまで使い回します（Options.MaxCachedStmts）。
+// 同じ SQL の Stmt をいくつ作っても、1つの接続で準備するのは1回だけです。Stmt を閉じる必要は
+// ありません。
+type Stmt struct {
+	db          *DB   // DB.Prepare で準備した文なら、空いている接続で実行する
+	conn        *Conn // Conn.Prepare で準備した文なら、その接続で実行する
+	sql         string
+	nparams     int
+	returnsRows bool
+}
+
+// Prepare は SQL 文を準備します。
+func (db *DB) Prepare(sql string) (*Stmt, error) {
+	return db.PrepareContext(context.Background(), sql)
+}
+
+// PrepareContext は空いている接続で SQL 文を準備し、どの接続でも実行できる Stmt を返します。
+func (db *DB) PrepareContext(ctx context.Context, sql string) (*Stmt, error) {
+	c, err := db.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer db.put(c)
+	s, err := prepare(ctx, c, sql)
+	if err != nil {
+		return nil, err
+	}
+	s.db = db
+	return s, nil
+}
+
+// Prepare は SQL 文を準備します。
+func (cn *Conn) Prepare(sql string) (*Stmt, error) {
+	return cn.PrepareContext(context.Background(), sql)
+}
+
+// PrepareContext は SQL 文を準備し、このセッションで実行する Stmt を返します。
+func (cn *Conn) PrepareContext(ctx context.Context, sql string) (*Stmt, error) {
+	if err := cn.ready(); err != nil {
+		return nil, err
+	}
+	s, err := prepare(ctx, cn.c, sql)
+	if err != nil {
+		return nil, err
+	}
+	s.conn = cn
+	return s, nil
+}
+
+// prepare は接続 c で sql を準備します。
+func prepare(ctx context.Context, c *conn, sql string) (*Stmt, error) {
+	stop := c.watch(ctx)
+	p, err := c.prepare(sql)
+	if cerr := stop(); cerr != nil && err != nil {
+		err = cerr
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &Stmt{sql: sql, nparams: p.nparams, returnsRows: p.returnsRows}, nil
+}
+
+// SQL は文の SQL を返します。
+func (s *Stmt) SQL() string { return s.sql }
+
+// NumParams は文の引数（? と $1 など）の数を返します。
+func (s *Stmt) NumParams() int { return s.nparams }
+
+// ReturnsRows は文が結果の行を返す文（SELECT、EXPLAIN、PRAGMA）かを返します。そうなら
+// QueryContext で、そうでなければ ExecContext で実行します。
+func (s *Stmt) ReturnsRows() bool { return s.returnsRows }
+
+// Exec は文を args で実行し、変更した行の数を返します。
+func (s *Stmt) Exec(args ...any) (int64, error) {
+	return s.ExecContext(context.Background(), args...)
+}
+
+// ExecContext は文を args で実行し、変更した行の数を返します。
+func (s *Stmt) ExecContext(ctx context.Context, args ...any) (int64, error) {
+	if s.conn != nil {
+		return s.conn.exec(ctx, s.sql, true, args)
+	}
+	return s.db.exec(ctx, s.sql, true, args)
+}
+
+// Query は文を args で実行し、結果の行を返します。
+func (s *Stmt) Query(args ...any) (*Rows, error) {
+	return s.QueryContext(context.Background(), args...)
+}
+
+// QueryContext は文を args で実行し、結果の行を返します。
+func (s *Stmt) QueryContext(ctx context.Context, args ...any) (*Rows, error) {
+	if s.conn != nil {
+		return s.conn.query(ctx, s.sql, true, args)
+	}
+	return s.db.query(ctx, s.sql, true, args)
+}
@@ -0,0 +1,78 @@
+package client
+
+import (
+	"context"
+	"iter"
+)
+
+// Tx は1つの接続で BEGIN から COMMIT までを実行するトランザクションです。1つのゴルーチンから
+// 使い、Commit か Rollback で終えます。終えると接続を DB に返します。
+type Tx struct {
+	conn *Conn
+}
+
+// BeginTx は空いている接続を取り出して BEGIN を実行します。トランザクションの設定（分離レベルと
+// 読み取り専用）はサーバーのセッションの設定に従います。
+func (db *DB) BeginTx(ctx context.Context) (*Tx, error) {
+	cn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := cn.ExecContext(ctx, "BEGIN"); err != nil {
+		cn.Close()
+		return nil, err
+	}
+	return &Tx{conn: cn}, nil
+}
+
+// Exec はトランザクションの中で SQL 文を実行し、変更した行の数を返します。
+func (tx *Tx) Exec(sql string, args ...any) (int64, error) {
+	return tx.ExecContext(context.Background(), sql, args...)
+}
+
+// ExecContext はトランザクションの中で SQL 文を実行し、変更した行の数を返します。
+func (tx *Tx) ExecContext(ctx context.Context, sql string, args ...any) (int64, error) {
+	if tx.conn.closed {
+		return 0, ErrTxDone
+	}
+	return tx.conn.ExecContext(ctx, sql, args...)
+}
+
+// Query はトランザクションの中で問い合わせを実行し、結果の行を返します。
+func (tx *Tx) Query(sql string, args ...any) (*Rows, error) {
+	return tx.QueryContext(context.Background(), sql, args...)
+}
+
+// QueryContext はトランザクションの中で問い合わせを実行し、結果の行を返します。Rows を閉じるまで
+// 次の文は実行できません。
+func (tx *Tx) QueryContext(ctx context.Context, sql string, args ...any) (*Rows, error) {
+	if tx.conn.closed {
+		return nil, ErrTxDone
+	}
+	return tx.conn.QueryContext(ctx, sql, args...)
+}
+
+// Rows は QueryContext で問い合わせを実行し、結果の行を繰り返すイテレータを返します（rows.go）。
+func (tx *Tx) Rows(ctx context.Context, sql string, args ...any) iter.Seq2[Row, error] {
+	return queryRows(func() (*Rows, error) { return tx.QueryContext(ctx, sql, args...) })
+}
+
+// Commit はトランザクションをコミットします。
+func (tx *Tx) Commit() error { return tx.end("COMMIT") }
+
+// Rollback はトランザクションをロールバックします。
+func (tx *Tx) Rollback() error { return tx.end("ROLLBACK") }
+
+// end は sql でトランザクションを終え、接続を返します。
+func (tx *Tx) end(sql string) error {
+	cn := tx.conn
+	if cn.closed {
+		return ErrTxDone
+	}
+	if cn.rows != nil {
+		cn.rows.Close()
+	}
+	_, err := cn.ExecContext(context.Background(), sql)
+	cn.Close()
+	return err
+}
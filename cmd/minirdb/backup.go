@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/k-sml/go-rdbms/internal/engine"
+	"github.com/k-sml/go-rdbms/internal/wal"
+)
+
+// runBackup はデータベースのスナップショットを dest に書きます。書き終えるまでは一時ファイルに
+// 書き、最後に名前を変えるので、途中で失敗しても dest に中途半端なファイルは残りません。
+func runBackup(args []string) {
+	if len(args) != 2 {
+		log.Fatalf("Usage: minirdb backup <dbfile> <dest>")
+	}
+	db, err := openDB(args[0], engine.Options{})
+	if err != nil {
+		log.Fatalf("Error opening database file: %v", err)
+	}
+	defer db.Close()
+	var pages int64
+	err = writeFileAtomic(args[1], func(w io.Writer) error {
+		var err error
+		pages, err = db.Backup(w)
+		return err
+	})
+	if err != nil {
+		log.Fatalf("Error writing backup: %v", err)
+	}
+	fmt.Printf("backed up %d pages to %s\n", pages, args[1])
+}
+
+// runRestore はバックアップ backup でデータベースファイル dbfile を置き換えます。先にバックアップの
+// 整合性を検査し、問題があれば何も変えません。置き換える前に dbfile のWALを消すので、古いWALが
+// 復元したファイルに書き戻されることはありません。dbfile をほかのプロセスが開いていてはいけません。
+func runRestore(args []string) {
+	if len(args) != 2 {
+		log.Fatalf("Usage: minirdb restore <backup> <dbfile>")
+	}
+	src, dbfile := args[0], args[1]
+	db, err := openDB(src, engine.Options{ReadOnly: true})
+	if err != nil {
+		log.Fatalf("Error opening backup: %v", err)
+	}
+	problems, err := db.CheckIntegrity()
+	db.Close()
+	if err != nil {
+		log.Fatalf("Error checking backup: %v", err)
+	}
+	if len(problems) > 0 {
+		for _, p := range problems {
+			fmt.Fprintln(os.Stderr, p)
+		}
+		log.Fatalf("backup %s is damaged (%d problems); %s is left unchanged", src, len(problems), dbfile)
+	}
+
+	f, err := os.Open(src)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+	var size int64
+	err = writeFileAtomic(dbfile, func(w io.Writer) error {
+		var err error
+		size, err = io.Copy(w, f)
+		return err
+	}, wal.Path(dbfile))
+	if err != nil {
+		log.Fatalf("Error restoring database: %v", err)
+	}
+	fmt.Printf("restored %s from %s (%d bytes)\n", dbfile, src, size)
+}
+
+// writeFileAtomic は write で書いた内容で path を置き換えます。内容は同じディレクトリの
+// 一時ファイルに書いてディスクに同期し、remove のファイルを消してから名前を変えます。
+func writeFileAtomic(path string, write func(io.Writer) error, remove ...string) error {
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(f)
+	err = write(bw)
+	if err == nil {
+		err = bw.Flush()
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	for _, name := range remove {
+		if err == nil {
+			if rerr := os.Remove(name); rerr != nil && !errors.Is(rerr, os.ErrNotExist) {
+				err = rerr
+			}
+		}
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
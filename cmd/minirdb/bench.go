@@ -0,0 +1,87 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/k-sml/go-rdbms/internal/bench"
+	"github.com/k-sml/go-rdbms/internal/pager"
+)
+
+// runBench は定型のワークロードで性能を測り、ワークロードごとのスループットと、操作にかかった
+// 時間のパーセンタイルを表にして表示します。-db を指定しなければ一時ディレクトリに
+// データベースを作り、終わったら消します。
+func runBench(args []string) {
+	fs := flag.NewFlagSet("bench", flag.ExitOnError)
+	path := fs.String("db", "", "database file to create (default: a temporary file that is removed afterwards)")
+	workloads := fs.String("workloads", strings.Join(bench.Workloads, ","), "comma-separated workloads to run")
+	rows := fs.Int("rows", 10000, "rows to load into the table")
+	ops := fs.Int("ops", 0, "operations per lookup, range and mixed workload (0 means -rows)")
+	concurrency := fs.Int("c", 1, "number of concurrent workers")
+	batch := fs.Int("batch", 100, "rows per transaction in the insert workload")
+	rangeSize := fs.Int("range", 100, "rows read by each range scan")
+	writes := fs.Float64("writes", 0.2, "fraction of updates in the mixed workload")
+	journal := fs.String("journal", "wal", "journal mode: wal, shadow or none")
+	seed := fs.Int64("seed", 1, "random seed")
+	fs.Parse(args)
+	if fs.NArg() > 0 {
+		log.Fatalf("Usage: minirdb bench [flags]")
+	}
+
+	cfg := bench.Config{
+		Path:        *path,
+		Workloads:   strings.Split(*workloads, ","),
+		Seed:        *seed,
+		Rows:        *rows,
+		Ops:         *ops,
+		Concurrency: *concurrency,
+		Batch:       *batch,
+		RangeSize:   *rangeSize,
+		WriteRatio:  *writes,
+	}
+	switch *journal {
+	case "wal":
+		cfg.Journal = pager.JournalWAL
+	case "shadow":
+		cfg.Journal = pager.JournalShadow
+	case "none":
+		cfg.Journal = pager.JournalNone
+	default:
+		log.Fatalf("unknown journal mode: %s", *journal)
+	}
+	if cfg.Path == "" {
+		dir, err := os.MkdirTemp("", "minirdb-bench")
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		cfg.Path = filepath.Join(dir, "bench.db")
+	}
+
+	results, err := bench.Run(cfg)
+	if err != nil {
+		log.Fatalf("Error running benchmark: %v", err)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "workload\tops\trows\tfailed\telapsed\tops/s\trows/s\tp50\tp95\tp99\tmax\t")
+	for _, r := range results {
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%s\t%.0f\t%.0f\t%s\t%s\t%s\t%s\t\n",
+			r.Workload, r.Ops, r.Rows, r.Failed, r.Elapsed.Round(time.Millisecond), r.OpsPerSec(), r.RowsPerSec(),
+			latency(r.Percentile(50)), latency(r.Percentile(95)), latency(r.Percentile(99)), latency(r.Percentile(100)))
+	}
+	w.Flush()
+}
+
+// latency は操作にかかった時間を、表で読みやすい桁に丸めます。
+func latency(d time.Duration) string {
+	if d >= time.Millisecond {
+		return d.Round(10 * time.Microsecond).String()
+	}
+	return d.Round(time.Microsecond).String()
+}
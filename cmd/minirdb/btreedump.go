@@ -0,0 +1,258 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/k-sml/go-rdbms/internal/btree"
+	"github.com/k-sml/go-rdbms/internal/catalog"
+	"github.com/k-sml/go-rdbms/internal/sql/ast"
+	"github.com/k-sml/go-rdbms/internal/storage"
+	"github.com/k-sml/go-rdbms/internal/types"
+)
+
+// runBTreeDump はインデックスの B+ 木を根から1段ずつ表示します。-dot を指定すると、
+// 同じ木を Graphviz の dot 言語で書きます。
+func runBTreeDump(args []string) {
+	fs := flag.NewFlagSet("btree-dump", flag.ExitOnError)
+	dot := fs.Bool("dot", false, "emit the tree as a Graphviz digraph")
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		log.Fatalf("Usage: minirdb btree-dump [-dot] <dbfile> <index>")
+	}
+	p, err := openPages(fs.Arg(0))
+	if err != nil {
+		log.Fatalf("Error opening database file: %v", err)
+	}
+	defer p.Close()
+	cat, err := catalog.Open(p)
+	if err != nil {
+		log.Fatalf("Error reading catalog: %v", err)
+	}
+	ix, ok := cat.Index(fs.Arg(1))
+	if !ok {
+		log.Fatalf("no such index: %s", fs.Arg(1))
+	}
+	t, ok := cat.Table(ix.Table)
+	if !ok {
+		log.Fatalf("table %s of index %s does not exist", ix.Table, ix.Name)
+	}
+	levels, err := readLevels(p, ix.Root)
+	if err != nil {
+		log.Fatalf("Error reading index %s: %v", ix.Name, err)
+	}
+	d := &treeDump{ix: ix, pageSize: p.PageSize(), levels: levels}
+	for _, col := range ix.Columns {
+		i, _ := t.Column(col)
+		d.types = append(d.types, t.Columns[i].Type)
+	}
+	w := bufio.NewWriter(os.Stdout)
+	if *dot {
+		d.writeDot(w)
+	} else {
+		d.writeLevels(w)
+	}
+	if err := w.Flush(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// treeNode は木の1つのノードです。
+type treeNode struct {
+	id int64
+	*btree.Node
+}
+
+// children はノードの子を左から順に返します。
+func (n treeNode) children() []int64 {
+	if n.Leaf {
+		return nil
+	}
+	return append([]int64{n.Next}, n.Children...)
+}
+
+// readLevels は根 root から木を幅優先でたどり、段ごとのノードを返します。
+// 同じページに2度着いたら、木が壊れているのでエラーにします。
+func readLevels(pg storage.Pages, root int64) ([][]treeNode, error) {
+	var levels [][]treeNode
+	seen := make(map[int64]bool)
+	for ids := []int64{root}; len(ids) > 0; {
+		var level []treeNode
+		var next []int64
+		for _, id := range ids {
+			if seen[id] {
+				return nil, fmt.Errorf("page %d is reached twice", id)
+			}
+			seen[id] = true
+			buf, err := pg.ReadPage(id)
+			if err != nil {
+				return nil, err
+			}
+			n, err := btree.DecodeNode(buf)
+			if err != nil {
+				return nil, fmt.Errorf("page %d: %w", id, err)
+			}
+			level = append(level, treeNode{id, n})
+			next = append(next, treeNode{id, n}.children()...)
+		}
+		levels = append(levels, level)
+		ids = next
+	}
+	return levels, nil
+}
+
+// treeDump は表示する木です。
+type treeDump struct {
+	ix       *catalog.Index
+	types    []types.Type // インデックスの列の型
+	pageSize int
+	levels   [][]treeNode
+}
+
+// writeLevels は木を段ごとに w に書きます。内部ノードは子と区切りのキーを交互に並べ、
+// 葉は最初と最後のキーと右隣の葉を書きます。最後に葉のつながりを左からたどって書きます。
+func (d *treeDump) writeLevels(w io.Writer) {
+	pages := 0
+	for _, level := range d.levels {
+		pages += len(level)
+	}
+	fmt.Fprintf(w, "index %s on %s (%s): root page %d, height %d, %d pages\n",
+		d.ix.Name, d.ix.Table, strings.Join(d.ix.Columns, ", "), d.ix.Root, len(d.levels), pages)
+	for depth, level := range d.levels {
+		kind := "internal"
+		if level[0].Leaf {
+			kind = "leaf"
+		}
+		fmt.Fprintf(w, "\nlevel %d (%s, %d pages)\n", depth, kind, len(level))
+		for _, n := range level {
+			fmt.Fprintf(w, "  page %d (%d keys, %d%% full): ", n.id, len(n.Keys), d.fill(n))
+			if n.Leaf {
+				if len(n.Keys) > 0 {
+					fmt.Fprintf(w, "%s .. %s", d.key(n.Keys[0]), d.key(n.Keys[len(n.Keys)-1]))
+				}
+				fmt.Fprintf(w, " -> %d\n", n.Next)
+				continue
+			}
+			for i, child := range n.children() {
+				if i > 0 {
+					fmt.Fprintf(w, " | %s | ", d.key(n.Keys[i-1]))
+				}
+				fmt.Fprint(w, child)
+			}
+			fmt.Fprintln(w)
+		}
+	}
+	leaves := d.levels[len(d.levels)-1]
+	byID := make(map[int64]treeNode, len(leaves))
+	for _, n := range leaves {
+		byID[n.id] = n
+	}
+	var chain []string
+	keys := 0
+	for id := leaves[0].id; id != 0; {
+		n, ok := byID[id]
+		if !ok {
+			chain = append(chain, fmt.Sprintf("%d (not a leaf of this tree)", id))
+			break
+		}
+		delete(byID, id)
+		chain = append(chain, fmt.Sprint(id))
+		keys += len(n.Keys)
+		id = n.Next
+	}
+	fmt.Fprintf(w, "\nleaf chain: %s (%d keys)\n", strings.Join(chain, " -> "), keys)
+	if len(byID) > 0 {
+		fmt.Fprintf(w, "leaves not on the chain: %d\n", len(byID))
+	}
+}
+
+// writeDot は木を Graphviz の dot 言語で w に書きます。内部ノードは子へのポートと区切りの
+// キーを並べたレコードにし、葉のつながりは破線でつなぎます。
+func (d *treeDump) writeDot(w io.Writer) {
+	fmt.Fprintf(w, "digraph %q {\n", d.ix.Name)
+	fmt.Fprintln(w, "  node [shape=record, fontname=\"monospace\"];")
+	for _, level := range d.levels {
+		var ids []string
+		for _, n := range level {
+			ids = append(ids, fmt.Sprintf("p%d", n.id))
+			head := fmt.Sprintf("page %d (%d%%)", n.id, d.fill(n))
+			if n.Leaf {
+				label := fmt.Sprintf("%d keys", len(n.Keys))
+				if len(n.Keys) > 0 {
+					label += `\n` + dotEscape(d.key(n.Keys[0])) + `\n..\n` + dotEscape(d.key(n.Keys[len(n.Keys)-1]))
+				}
+				fmt.Fprintf(w, "  p%d [label=\"{%s|%s}\"];\n", n.id, dotEscape(head), label)
+				continue
+			}
+			var fields []string
+			for i := range n.children() {
+				if i > 0 {
+					fields = append(fields, dotEscape(d.key(n.Keys[i-1])))
+				}
+				fields = append(fields, fmt.Sprintf("<c%d>", i))
+			}
+			fmt.Fprintf(w, "  p%d [label=\"{%s|{%s}}\"];\n", n.id, dotEscape(head), strings.Join(fields, "|"))
+			for i, child := range n.children() {
+				fmt.Fprintf(w, "  p%d:c%d -> p%d;\n", n.id, i, child)
+			}
+		}
+		fmt.Fprintf(w, "  { rank=same; %s; }\n", strings.Join(ids, "; "))
+	}
+	for _, n := range d.levels[len(d.levels)-1] {
+		if n.Next != 0 {
+			fmt.Fprintf(w, "  p%d -> p%d [style=dashed, constraint=false];\n", n.id, n.Next)
+		}
+	}
+	fmt.Fprintln(w, "}")
+}
+
+// fill はノードが使っているページの割合を百分率で返します。
+func (d *treeDump) fill(n treeNode) int {
+	return n.Size * 100 / d.pageSize
+}
+
+// key はインデックスのキーを、列の値と行の位置の形にします。値をデコードできなければ
+// 16進数で書きます。
+func (d *treeDump) key(k []byte) string {
+	const ridSize = 12
+	if len(k) < ridSize {
+		return fmt.Sprintf("x'%x'", k)
+	}
+	var vals []string
+	off := 0
+	for _, typ := range d.types {
+		v, n, err := types.DecodeKey(k[off:len(k)-ridSize], typ)
+		if err != nil {
+			return fmt.Sprintf("x'%x'", k)
+		}
+		vals = append(vals, ast.FormatValue(v))
+		off += n
+	}
+	if off != len(k)-ridSize {
+		return fmt.Sprintf("x'%x'", k)
+	}
+	rid := k[len(k)-ridSize:]
+	s := strings.Join(vals, ", ")
+	if len(vals) != 1 {
+		s = "(" + s + ")"
+	}
+	return fmt.Sprintf("%s@(%d,%d)", s, binary.BigEndian.Uint64(rid), binary.BigEndian.Uint32(rid[8:]))
+}
+
+// dotEscape は dot のレコードのラベルで特別な意味を持つ文字の前に \ を付けます。
+func dotEscape(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if strings.ContainsRune(`\{}|<>"`, r) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/k-sml/go-rdbms/internal/engine"
+	"github.com/k-sml/go-rdbms/internal/pager"
+)
+
+// runCheck はデータベースファイルの整合性を検査し、見つかった問題を1行に1つ表示します。
+// 問題がなければ ok と表示します。問題が見つかったときの終了コードは 1 です。
+func runCheck(args []string) int {
+	if len(args) != 1 {
+		log.Fatalf("Usage: minirdb check <dbfile>")
+	}
+	db, err := openDB(args[0], engine.Options{ReadOnly: true})
+	if err != nil {
+		log.Fatalf("Error opening database file: %v", err)
+	}
+	defer db.Close()
+	problems, err := db.CheckIntegrity()
+	if err != nil {
+		log.Fatalf("Error checking database: %v", err)
+	}
+	if len(problems) == 0 {
+		fmt.Println("ok")
+		return 0
+	}
+	for _, p := range problems {
+		fmt.Println(p)
+	}
+	return 1
+}
+
+// openDB は既存のデータベースファイルを開きます。ファイルがなければ作らずにエラーを返し、
+// シャドウページングのファイルはその形式で開きます。
+func openDB(path string, opts engine.Options) (*engine.DB, error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, err
+	}
+	db, err := engine.Open(path, opts)
+	if errors.Is(err, pager.ErrJournalMismatch) {
+		opts.Journal = pager.JournalShadow
+		db, err = engine.Open(path, opts)
+	}
+	return db, err
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"unicode"
+
+	"github.com/k-sml/go-rdbms/internal/catalog"
+	"github.com/k-sml/go-rdbms/internal/sql/lexer"
+)
+
+// completions は Tab で補完する語 word の候補を返します。候補はカタログにあるテーブル、ビュー、
+// 列の名前と SQL のキーワードで、大文字と小文字を区別せずに word で始まるものです。
+// word が テーブル名.列名 の形なら、そのテーブルの列の名前だけを候補にします。
+// 行の始めの . で始まる語はメタコマンドの名前で補完します。
+func (sh *shell) completions(line []rune, word string) []string {
+	if word == "" {
+		return nil
+	}
+	if strings.HasPrefix(word, ".") && strings.TrimSpace(string(line)) == word {
+		var out []string
+		for _, c := range metaCommands {
+			if strings.HasPrefix(c.name, word) {
+				out = append(out, c.name)
+			}
+		}
+		return out
+	}
+	var names []string
+	err := sh.withCatalog(func(cat *catalog.Catalog) {
+		if table, _, ok := strings.Cut(word, "."); ok {
+			if t, ok := cat.Table(table); ok {
+				for _, c := range t.Columns {
+					names = append(names, table+"."+c.Name)
+				}
+			}
+			if v, ok := cat.View(table); ok {
+				for _, c := range v.Columns {
+					names = append(names, table+"."+c)
+				}
+			}
+			return
+		}
+		for _, t := range cat.Tables() {
+			names = append(names, t.Name)
+			for _, c := range t.Columns {
+				names = append(names, c.Name)
+			}
+		}
+		for _, v := range cat.Views() {
+			names = append(names, v.Name)
+		}
+	})
+	if err != nil {
+		return nil
+	}
+	if !strings.Contains(word, ".") {
+		// キーワードは入力に合わせて、小文字で書き始めたら小文字で補う
+		lower := unicode.IsLower([]rune(word)[0])
+		for _, k := range lexer.Keywords() {
+			if lower {
+				k = strings.ToLower(k)
+			}
+			names = append(names, k)
+		}
+	}
+	var out []string
+	for _, n := range names {
+		if strings.HasPrefix(strings.ToLower(n), strings.ToLower(word)) {
+			out = append(out, n)
+		}
+	}
+	slices.Sort(out)
+	return slices.Compact(out)
+}
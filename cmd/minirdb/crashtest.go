@@ -0,0 +1,66 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/k-sml/go-rdbms/internal/crashtest"
+	"github.com/k-sml/go-rdbms/internal/pager"
+)
+
+// runCrashTest は電源断を模擬したクラッシュリカバリ試験を実行します。-workload で
+// ページイメージ（pages）、論理レコード（heap）、SQL の文（sql）のどれを書き込むかを選びます。
+// 違反が見つかった場合は終了コード 1 で終了します。
+func runCrashTest(args []string) {
+	fs := flag.NewFlagSet("crashtest", flag.ExitOnError)
+	iterations := fs.Int("n", 200, "number of crash/recover iterations")
+	seed := fs.Int64("seed", 1, "random seed")
+	pages := fs.Int("pages", 16, "number of pages touched by the workload")
+	commits := fs.Int("commits", 20, "commits per iteration")
+	checkpoint := fs.Int("checkpoint", 5, "checkpoint every N commits (0 disables)")
+	journal := fs.String("journal", "wal", "journal mode: wal, shadow or none")
+	workload := fs.String("workload", "pages", "workload: pages (page images), heap (logical WAL records) or sql (statements on a table)")
+	fs.Parse(args)
+
+	cfg := crashtest.Config{
+		Seed:            *seed,
+		Iterations:      *iterations,
+		Pages:           *pages,
+		Commits:         *commits,
+		CheckpointEvery: *checkpoint,
+	}
+	switch *journal {
+	case "wal":
+		cfg.Journal = pager.JournalWAL
+	case "shadow":
+		cfg.Journal = pager.JournalShadow
+	case "none":
+		cfg.Journal = pager.JournalNone
+	default:
+		log.Fatalf("unknown journal mode: %s", *journal)
+	}
+	switch *workload {
+	case "pages":
+		cfg.Workload = crashtest.WorkloadPages
+	case "heap":
+		cfg.Workload = crashtest.WorkloadHeap
+	case "sql":
+		cfg.Workload = crashtest.WorkloadSQL
+	default:
+		log.Fatalf("unknown workload: %s", *workload)
+	}
+
+	rep, err := crashtest.Run(cfg)
+	if err != nil {
+		log.Fatalf("Error running crash test: %v", err)
+	}
+	for _, f := range rep.Failures {
+		fmt.Println("FAIL:", f)
+	}
+	fmt.Printf("%d iterations, %d failures\n", rep.Iterations, len(rep.Failures))
+	if len(rep.Failures) > 0 {
+		os.Exit(1)
+	}
+}
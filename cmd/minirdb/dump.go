@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/k-sml/go-rdbms/internal/catalog"
+	"github.com/k-sml/go-rdbms/internal/engine"
+	"github.com/k-sml/go-rdbms/internal/sql/ast"
+	"github.com/k-sml/go-rdbms/internal/sql/lexer"
+)
+
+// dump はデータベースの内容を、実行すると同じ内容のデータベースを作る SQL にして表示します。
+// パターンを指定すると、名前がパターンに一致するテーブルとビューだけを表示します。
+func (sh *shell) dump(args []string) error {
+	if len(args) > 1 {
+		return errors.New("usage: .dump ?PATTERN?")
+	}
+	match := func(name string) bool { return len(args) == 0 || matchPattern(args[0], name) }
+	return sh.withTx(func(tx *engine.Tx) error {
+		w := bufio.NewWriter(sh.out)
+		err := dumpSQL(w, tx, match)
+		if ferr := w.Flush(); err == nil {
+			err = ferr
+		}
+		return err
+	})
+}
+
+// dumpSQL は tx から見えるテーブルのうち match が true を返すものについて、CREATE TABLE 文と
+// すべての行の INSERT 文を w に書き、続けてインデックスとビューを作る文を書きます。
+// 全体を BEGIN と COMMIT で囲むので、途中で失敗しても読み込み先に中途半端な内容は残りません。
+//
+// 外部キーで参照されるテーブルは、参照するテーブルより先に書きます。インデックスは、行を
+// 挿入し終えてから作る方が速いので、最後に作ります。
+func dumpSQL(w io.Writer, tx *engine.Tx, match func(name string) bool) error {
+	cat, err := tx.Catalog()
+	if err != nil {
+		return err
+	}
+	var tables []*catalog.Table
+	for _, t := range dependencyOrder(cat.Tables()) {
+		if match(t.Name) && !t.Temp { // 一時テーブルはセッションと一緒に消える
+			tables = append(tables, t)
+		}
+	}
+	fmt.Fprintln(w, "BEGIN;")
+	for _, t := range tables {
+		fmt.Fprintln(w, createTableSQL(t)+";")
+		if err := dumpRows(w, tx, t); err != nil {
+			return fmt.Errorf("dump table %s: %w", t.Name, err)
+		}
+	}
+	for _, t := range tables {
+		for _, ix := range cat.Indexes(t.Name) {
+			if cat.IsPrimaryKey(ix) {
+				continue // CREATE TABLE が作る
+			}
+			fmt.Fprintln(w, createIndexSQL(ix)+";")
+		}
+	}
+	for _, v := range cat.Views() {
+		if match(v.Name) {
+			fmt.Fprintln(w, createViewSQL(v)+";")
+		}
+	}
+	_, err = fmt.Fprintln(w, "COMMIT;")
+	return err
+}
+
+// dumpRows はテーブル t のすべての行を INSERT 文にして w に書きます。
+func dumpRows(w io.Writer, tx *engine.Tx, t *catalog.Table) error {
+	name := lexer.QuoteIdent(t.Name)
+	rows, err := tx.Query("SELECT * FROM " + name)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	var b strings.Builder
+	for rows.Next() {
+		b.Reset()
+		b.WriteString("INSERT INTO " + name + " VALUES (")
+		for i, v := range rows.Values() {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			b.WriteString(ast.FormatValue(v))
+		}
+		b.WriteString(");\n")
+		if _, err := io.WriteString(w, b.String()); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// dependencyOrder は、外部キーで参照されるテーブルが参照するテーブルより前に来るように
+// tables を並べ替えます。それ以外は元の順序のままです。
+func dependencyOrder(tables []*catalog.Table) []*catalog.Table {
+	byName := make(map[string]*catalog.Table, len(tables))
+	for _, t := range tables {
+		byName[strings.ToLower(t.Name)] = t
+	}
+	out := make([]*catalog.Table, 0, len(tables))
+	done := make(map[*catalog.Table]bool, len(tables))
+	var visit func(t *catalog.Table)
+	visit = func(t *catalog.Table) {
+		if done[t] {
+			return
+		}
+		done[t] = true
+		for _, fk := range t.ForeignKeys {
+			if ref, ok := byName[strings.ToLower(fk.RefTable)]; ok {
+				visit(ref)
+			}
+		}
+		out = append(out, t)
+	}
+	for _, t := range tables {
+		visit(t)
+	}
+	return out
+}
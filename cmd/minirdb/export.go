@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/k-sml/go-rdbms/internal/engine"
+	"github.com/k-sml/go-rdbms/internal/sql/lexer"
+	"github.com/k-sml/go-rdbms/internal/txn"
+)
+
+// runExport はテーブルのすべての行か問い合わせの結果を、CSV か JSON Lines（1行に1つの
+// オブジェクト）にして書き出します。行は読みながら1行ずつ書くので、結果をメモリに
+// ためません。-out を指定すると一時ファイルに書いてから名前を変え、指定しなければ
+// 標準出力に書きます。フラグはデータベースファイル名の前にも後にも書けます。
+func runExport(args []string) {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	table := fs.String("table", "", "table to export")
+	query := fs.String("query", "", "SELECT statement whose result to export")
+	format := fs.String("format", "csv", "output format: csv or jsonl")
+	out := fs.String("out", "", "output file (default: standard output)")
+	header := fs.Bool("header", true, "write the column names as the first CSV record")
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		log.Fatalf("Usage: minirdb export (--table T | --query SQL) [--format csv|jsonl] [--out FILE] <dbfile>")
+	}
+	dbfile := fs.Arg(0)
+	fs.Parse(fs.Args()[1:])
+	if fs.NArg() > 0 {
+		log.Fatalf("unexpected argument: %s", fs.Arg(0))
+	}
+	if (*table == "") == (*query == "") {
+		log.Fatalf("specify exactly one of --table and --query")
+	}
+	sql := *query
+	if *table != "" {
+		sql = "SELECT * FROM " + lexer.QuoteIdent(*table)
+	}
+	var write func(w io.Writer, rows *engine.Rows) (int64, error)
+	switch *format {
+	case "csv":
+		write = func(w io.Writer, rows *engine.Rows) (int64, error) { return exportCSV(w, rows, *header) }
+	case "jsonl":
+		write = exportJSONLines
+	default:
+		log.Fatalf("unknown format: %s (want csv or jsonl)", *format)
+	}
+
+	db, err := openDB(dbfile, engine.Options{ReadOnly: true})
+	if err != nil {
+		log.Fatalf("Error opening database file: %v", err)
+	}
+	defer db.Close()
+	tx, err := db.Begin(txn.Options{ReadOnly: true})
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer tx.Rollback()
+	rows, err := tx.Query(sql)
+	if err != nil {
+		log.Fatalf("Error running query: %v", err)
+	}
+	defer rows.Close()
+
+	var n int64
+	if *out == "" {
+		n, err = write(os.Stdout, rows)
+	} else {
+		err = writeFileAtomic(*out, func(w io.Writer) error {
+			var err error
+			n, err = write(w, rows)
+			return err
+		})
+	}
+	if err != nil {
+		log.Fatalf("Error exporting rows: %v", err)
+	}
+	if *out != "" {
+		fmt.Fprintf(os.Stderr, "exported %d rows to %s\n", n, *out)
+	}
+}
+
+// exportCSV は rows の行を CSV にして w に書き、書いた行の数を返します。
+func exportCSV(w io.Writer, rows *engine.Rows, header bool) (int64, error) {
+	cw := csv.NewWriter(w)
+	if header {
+		cw.Write(rows.Columns())
+	}
+	record := make([]string, len(rows.Columns()))
+	var n int64
+	for rows.Next() {
+		if err := cw.Write(csvRecord(record, rows.Values())); err != nil {
+			return n, err
+		}
+		n++
+	}
+	cw.Flush()
+	return n, errors.Join(rows.Err(), cw.Error())
+}
+
+// exportJSONLines は rows の行を、1行に1つの JSON のオブジェクトにして w に書き、書いた行の数を返します。
+func exportJSONLines(w io.Writer, rows *engine.Rows) (int64, error) {
+	keys, err := jsonKeys(rows.Columns())
+	if err != nil {
+		return 0, err
+	}
+	var b strings.Builder
+	var n int64
+	for rows.Next() {
+		b.Reset()
+		if err := appendJSONRow(&b, keys, rows.Values()); err != nil {
+			return n, err
+		}
+		b.WriteString("\n")
+		if _, err := io.WriteString(w, b.String()); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, rows.Err()
+}
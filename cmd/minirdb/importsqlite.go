@@ -0,0 +1,449 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/k-sml/go-rdbms/internal/catalog"
+	"github.com/k-sml/go-rdbms/internal/engine"
+	"github.com/k-sml/go-rdbms/internal/sqlite"
+	"github.com/k-sml/go-rdbms/internal/txn"
+	"github.com/k-sml/go-rdbms/internal/types"
+)
+
+// runImportSQLite は SQLite のデータベースファイルのテーブルと行を、minirdb のデータベースファイルに
+// 作り直します。dbfile がなければ作ります。フラグはファイル名の前にも後にも書けます。
+//
+// 列の型は SQLite の型の親和性の規則で決めます（INT を含めば BIGINT、CHAR・CLOB・TEXT なら TEXT、
+// BLOB なら BLOB、REAL・FLOA・DOUB なら REAL）。それ以外の NUMERIC の親和性の列は、BOOL を含めば
+// BOOLEAN、DATE か TIME を含めば TIMESTAMP、値がすべて整数なら BIGINT、そうでなければ REAL にします。
+// 型を書いていない列は値から決めます。SQLite は列の型に合わない値も格納できるので、そうした値が
+// ある列は TEXT（TEXT にできない値があれば BLOB）にして、そのことを表示します。
+//
+// 主キーは CREATE TABLE が作る一意のインデックスで保ちます。UNIQUE 制約には一意のインデックスを作り、
+// CREATE INDEX のインデックスも作り直します（式のインデックスと部分インデックスは除く）。
+// INTEGER PRIMARY KEY の列は、SQLite と同じように値を省略すると番号を割り当てる AUTOINCREMENT の
+// 列にします。ビューは SQL をそのまま実行して
+// 作り、できなければ飛ばします。生成列は、値を格納する STORED の列だけを通常の列として移します。
+// 外部キー、CHECK 制約、トリガー、仮想テーブルは移しません。
+//
+// テーブルはまとめて1つのトランザクションで作り、行は -batch 行ごとにコミットするので、途中で
+// 失敗するとそれまでに入れた行は残ります。同じ名前のテーブルがすでにあればエラーにします。
+func runImportSQLite(args []string) {
+	fs := flag.NewFlagSet("import-sqlite", flag.ExitOnError)
+	only := fs.String("tables", "", "comma-separated tables to import (default: all tables)")
+	batch := fs.Int("batch", 10000, "rows to insert per transaction")
+	fs.Parse(args)
+	if fs.NArg() < 2 {
+		log.Fatalf("Usage: minirdb import-sqlite [--tables T1,T2] [--batch N] <sqlite-file> <dbfile>")
+	}
+	src, dbfile := fs.Arg(0), fs.Arg(1)
+	fs.Parse(fs.Args()[2:])
+	if fs.NArg() > 0 {
+		log.Fatalf("unexpected argument: %s", fs.Arg(0))
+	}
+
+	sq, err := sqlite.Open(src)
+	if err != nil {
+		log.Fatalf("Error opening SQLite database: %v", err)
+	}
+	defer sq.Close()
+	objs, err := sq.Schema()
+	if err != nil {
+		log.Fatalf("Error reading SQLite schema: %v", err)
+	}
+	tables, err := sqliteTables(sq, objs, *only)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var db *engine.DB
+	if _, err := os.Stat(dbfile); err == nil {
+		db, err = openDB(dbfile, engine.Options{})
+	} else {
+		db, err = engine.Open(dbfile, engine.Options{})
+	}
+	if err != nil {
+		log.Fatalf("Error opening database file: %v", err)
+	}
+	defer db.Close()
+
+	err = inTx(db, func(tx *engine.Tx) error {
+		for _, it := range tables {
+			if err := tx.CreateTable(it.schema()); err != nil {
+				return fmt.Errorf("create table %s: %w", it.t.Name, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		log.Fatal(err)
+	}
+	var total int64
+	for _, it := range tables {
+		n, err := it.load(sq, db, max(*batch, 1))
+		if err != nil {
+			db.Close()
+			log.Fatalf("Error importing table %s after %d rows: %v", it.t.Name, n, err)
+		}
+		fmt.Printf("%s: %d rows\n", it.t.Name, n)
+		total += n
+	}
+	indexes := createSQLiteIndexes(db, tables, objs)
+	views := 0
+	for _, o := range objs {
+		switch {
+		case o.Type == "view" && *only == "":
+			err := inTx(db, func(tx *engine.Tx) error {
+				_, err := tx.Exec(o.SQL)
+				return err
+			})
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "skipped view %s: %v\n", o.Name, err)
+				continue
+			}
+			views++
+		case o.Type == "trigger" && *only == "":
+			fmt.Fprintf(os.Stderr, "skipped trigger %s: triggers are not imported\n", o.Name)
+		}
+	}
+	fmt.Printf("imported %d tables (%d rows), %d indexes and %d views from %s\n", len(tables), total, indexes, views, src)
+}
+
+// sqliteTable は読み込む SQLite のテーブルと、列ごとに決めた minirdb の型です。
+// 移さない列（値を格納しない生成列）の型は types.Null です。
+type sqliteTable struct {
+	t     *sqlite.Table
+	types []types.Type
+}
+
+// sqliteTables は objs から読み込むテーブルを選び、列の型を決めます。only が空でなければ、
+// カンマで区切ったその名前のテーブルだけを選びます。
+func sqliteTables(sq *sqlite.File, objs []sqlite.Object, only string) ([]*sqliteTable, error) {
+	var names []string
+	for _, name := range strings.Split(only, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, strings.ToLower(name))
+		}
+	}
+	var tables []*sqliteTable
+	for _, o := range objs {
+		if o.Type != "table" || strings.HasPrefix(strings.ToLower(o.Name), "sqlite_") {
+			continue
+		}
+		if len(names) > 0 && !slices.Contains(names, strings.ToLower(o.Name)) {
+			continue
+		}
+		names = slices.DeleteFunc(names, func(n string) bool { return n == strings.ToLower(o.Name) })
+		t, err := sqlite.ParseTable(o)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "skipped table %s: %v\n", o.Name, err)
+			continue
+		}
+		it := &sqliteTable{t: t}
+		if err := it.inferTypes(sq); err != nil {
+			return nil, fmt.Errorf("Error reading table %s: %w", t.Name, err)
+		}
+		tables = append(tables, it)
+	}
+	if len(names) > 0 {
+		return nil, fmt.Errorf("no such table in the SQLite database: %s", strings.Join(names, ", "))
+	}
+	return tables, nil
+}
+
+// inferTypes はテーブルの行を1度読んで、列ごとの型を決めます。
+func (it *sqliteTable) inferTypes(sq *sqlite.File) error {
+	cols := it.t.Columns
+	it.types = make([]types.Type, len(cols))
+	// 列ごとに、見た値の種類と、宣言した型から決めた型に変換できない値があったか
+	seen := make([]struct{ ints, reals, texts, blobs, badUTF8 bool }, len(cols))
+	failed := make([]bool, len(cols))
+	want := make([]types.Type, len(cols))
+	for i := range cols {
+		want[i] = declaredType(&cols[i])
+	}
+	err := sq.Rows(it.t, func(row []any) error {
+		for i, v := range row {
+			s := &seen[i]
+			switch v := v.(type) {
+			case int64:
+				s.ints = true
+			case float64:
+				s.reals = true
+			case string:
+				s.texts = true
+			case []byte:
+				s.blobs = true
+				s.badUTF8 = s.badUTF8 || !utf8.Valid(v)
+			}
+			if want[i] != types.Null && !failed[i] {
+				_, err := sqliteValue(v, want[i])
+				failed[i] = err != nil
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for i := range cols {
+		if cols[i].Virtual {
+			continue
+		}
+		s := seen[i]
+		t := want[i]
+		switch {
+		case t == types.Null && cols[i].Affinity() == sqlite.AffinityNumeric:
+			// NUMERIC や DECIMAL は、整数しかなければ BIGINT にする
+			t = types.Real
+			if !s.reals && !s.texts && !s.blobs {
+				t = types.BigInt
+			}
+			failed[i] = s.texts || s.blobs
+		case t == types.Null:
+			// 型を書いていない列は値から決める
+			switch {
+			case s.blobs && !s.ints && !s.reals && !s.texts:
+				t = types.Blob
+			case s.texts || s.blobs:
+				t = types.Text
+			case s.reals:
+				t = types.Real
+			case s.ints:
+				t = types.BigInt
+			default:
+				t = types.Text
+			}
+		}
+		if failed[i] {
+			fallback := types.Text
+			switch {
+			case s.badUTF8:
+				fallback = types.Blob
+			case !s.texts && !s.blobs:
+				fallback = types.Real // 整数や真偽値の列に整数でない数がある
+			}
+			fmt.Fprintf(os.Stderr, "column %s.%s declared %q holds values that are not %s; importing it as %s\n",
+				it.t.Name, cols[i].Name, cols[i].Type, t, fallback)
+			t = fallback
+		}
+		if t == types.Text && s.badUTF8 {
+			t = types.Blob
+		}
+		if cols[i].PrimaryKey && t != want[i] && want[i] != types.Null {
+			return fmt.Errorf("primary key column %s holds values that are not %s", cols[i].Name, want[i])
+		}
+		it.types[i] = t
+	}
+	return nil
+}
+
+// declaredType は SQLite の列 c の宣言した型から minirdb の型を決めます。値を見ないと決められない
+// 列（型を書いていない列と、NUMERIC の親和性の数の列）には types.Null を返します。
+func declaredType(c *sqlite.Column) types.Type {
+	switch c.Affinity() {
+	case sqlite.AffinityInteger:
+		return types.BigInt
+	case sqlite.AffinityText:
+		return types.Text
+	case sqlite.AffinityReal:
+		return types.Real
+	case sqlite.AffinityBlob:
+		if c.Type == "" {
+			return types.Null
+		}
+		return types.Blob
+	}
+	t := strings.ToUpper(c.Type)
+	switch {
+	case strings.Contains(t, "BOOL"):
+		return types.Boolean
+	case strings.Contains(t, "DATE"), strings.Contains(t, "TIME"):
+		return types.Timestamp
+	}
+	return types.Null
+}
+
+// schema はテーブルを作る定義を返します。
+func (it *sqliteTable) schema() engine.Schema {
+	s := engine.Schema{Name: it.t.Name}
+	for i, c := range it.t.Columns {
+		if it.types[i] == types.Null {
+			continue
+		}
+		col := catalog.Column{Name: c.Name, Type: it.types[i], NotNull: c.NotNull, PrimaryKey: c.PrimaryKey,
+			AutoIncrement: c.RowID || c.AutoIncrement}
+		if c.Default != nil {
+			if v, err := sqliteValue(c.Default, it.types[i]); err == nil {
+				col.Default = v
+			}
+		}
+		s.Columns = append(s.Columns, col)
+	}
+	return s
+}
+
+// load はテーブルの行を batch 行ごとのトランザクションで挿入し、挿入した行の数を返します。
+func (it *sqliteTable) load(sq *sqlite.File, db *engine.DB, batch int) (int64, error) {
+	var n int64
+	tx, err := db.Begin(txn.Options{})
+	if err != nil {
+		return 0, err
+	}
+	defer func() { tx.Rollback() }()
+	var vals []types.Value
+	err = sq.Rows(it.t, func(row []any) error {
+		vals = vals[:0]
+		for i, v := range row {
+			if it.types[i] == types.Null {
+				continue
+			}
+			x, err := sqliteValue(v, it.types[i])
+			if err != nil {
+				return fmt.Errorf("column %s: %w", it.t.Columns[i].Name, err)
+			}
+			vals = append(vals, x)
+		}
+		if _, err := tx.Insert(it.t.Name, vals); err != nil {
+			return err
+		}
+		if n++; n%int64(batch) == 0 {
+			if err := tx.Commit(); err != nil {
+				return err
+			}
+			next, err := db.Begin(txn.Options{})
+			if err != nil {
+				return err
+			}
+			tx = next
+		}
+		return nil
+	})
+	if err != nil {
+		return n - n%int64(batch), err
+	}
+	return n, tx.Commit()
+}
+
+// inTx は fn を1つのトランザクションで実行してコミットします。
+func inTx(db *engine.DB, fn func(tx *engine.Tx) error) error {
+	tx, err := db.Begin(txn.Options{})
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// createSQLiteIndexes はテーブルの UNIQUE 制約の一意のインデックスと、objs の CREATE INDEX の
+// インデックスを作り、作った数を返します。作れなかったインデックスは表示して飛ばします。
+func createSQLiteIndexes(db *engine.DB, tables []*sqliteTable, objs []sqlite.Object) int {
+	imported := make(map[string]bool)
+	var ixs []*sqlite.Index
+	for _, it := range tables {
+		imported[strings.ToLower(it.t.Name)] = true
+		for _, cols := range it.t.Unique {
+			ixs = append(ixs, &sqlite.Index{Name: it.t.Name + "_" + strings.Join(cols, "_") + "_key", Table: it.t.Name,
+				Columns: cols, Unique: true})
+		}
+	}
+	for _, o := range objs {
+		if o.Type != "index" || o.SQL == "" || !imported[strings.ToLower(o.Table)] {
+			continue // SQL のないインデックスは主キーと UNIQUE 制約のもの
+		}
+		ix, err := sqlite.ParseIndex(o.SQL)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "skipped index %s: %v\n", o.Name, err)
+			continue
+		}
+		ixs = append(ixs, ix)
+	}
+	n := 0
+	for _, ix := range ixs {
+		err := inTx(db, func(tx *engine.Tx) error { return tx.CreateIndex(ix.Name, ix.Table, ix.Columns, ix.Unique) })
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "skipped index %s: %v\n", ix.Name, err)
+			continue
+		}
+		n++
+	}
+	return n
+}
+
+// sqliteValue は SQLite の値 v（nil、int64、float64、string、[]byte）を型 t の値にします。
+// 整数の列に整数でない値を入れるような、値が変わってしまう変換はエラーにします。
+func sqliteValue(v any, t types.Type) (types.Value, error) {
+	var x types.Value
+	switch v := v.(type) {
+	case nil:
+		return types.NullValue(), nil
+	case int64:
+		x = types.NewBigInt(v)
+	case float64:
+		if t == types.Timestamp {
+			return julianDay(v)
+		}
+		x = types.NewReal(v)
+	case string:
+		x = types.NewText(v)
+	case []byte:
+		switch t {
+		case types.Blob:
+			return types.NewBlob(v), nil
+		case types.Text:
+			if !utf8.Valid(v) {
+				return types.Value{}, errors.New("BLOB value is not valid UTF-8 text")
+			}
+			return types.NewText(string(v)), nil
+		}
+		return types.Value{}, fmt.Errorf("cannot store BLOB value in %s column", t)
+	default:
+		return types.Value{}, fmt.Errorf("unsupported SQLite value %T", v)
+	}
+	if c, err := types.Coerce(x, t); err == nil {
+		return c, nil
+	}
+	switch {
+	case t == types.Blob:
+		// SQLite の BLOB の列の数や文字列は、文字列のバイト列にする
+		s, err := types.Cast(x, types.Text)
+		if err != nil {
+			return types.Value{}, err
+		}
+		return types.NewBlob([]byte(s.Text())), nil
+	case x.Type() == types.Text && t.IsInteger():
+		i, err := strconv.ParseInt(strings.TrimSpace(x.Text()), 10, 64)
+		if err != nil {
+			return types.Value{}, fmt.Errorf("invalid %s value: %q", t, x.Text())
+		}
+		return types.NewBigInt(i), nil
+	case x.Type() == types.Text, t == types.Text,
+		x.Type() == types.BigInt && (t == types.Boolean || t == types.Timestamp):
+		// 文字列から数や真偽値に、数から文字列に、整数から真偽値や UNIX 時間の日時にする
+		return types.Cast(x, t)
+	}
+	return types.Value{}, fmt.Errorf("cannot store %s value in %s column", x.Type(), t)
+}
+
+// julianDay は SQLite の julianday() のユリウス日 jd を日時にします。
+func julianDay(jd float64) (types.Value, error) {
+	us := (jd - 2440587.5) * 86400e6 // UNIX 時間のマイクロ秒
+	if math.IsNaN(us) || us < math.MinInt64/2 || us > math.MaxInt64/2 {
+		return types.Value{}, fmt.Errorf("invalid julian day %v", jd)
+	}
+	return types.NewTimestamp(time.UnixMicro(int64(math.Round(us))).UTC()), nil
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/k-sml/go-rdbms/internal/engine"
+	"github.com/k-sml/go-rdbms/internal/sqlite"
+	"github.com/k-sml/go-rdbms/internal/txn"
+	"github.com/k-sml/go-rdbms/internal/types"
+)
+
+// TestSQLiteValue は、SQLite の値を列の型に変換し、値が変わってしまう変換を拒否することを確かめます。
+func TestSQLiteValue(t *testing.T) {
+	tests := []struct {
+		v    any
+		typ  types.Type
+		want string // 結果の値（String の形）。空ならエラーになる
+	}{
+		{nil, types.BigInt, "NULL"},
+		{int64(42), types.BigInt, "42"},
+		{" 42 ", types.BigInt, "42"},
+		{"4x", types.BigInt, ""},
+		{1.5, types.BigInt, ""},
+		{int64(2), types.Real, "2"},
+		{"2.5", types.Real, "2.5"},
+		{int64(7), types.Text, "7"},
+		{[]byte("abc"), types.Text, "abc"},
+		{[]byte{0xff}, types.Text, ""},
+		{[]byte{0xff}, types.BigInt, ""},
+		{int64(12), types.Blob, "x'3132'"},
+		{int64(1), types.Boolean, "TRUE"},
+		{"2024-02-29 12:34:56", types.Timestamp, "2024-02-29 12:34:56"},
+		{int64(0), types.Timestamp, "1970-01-01 00:00:00"},
+		{2440588.0, types.Timestamp, "1970-01-01 12:00:00"},
+	}
+	for _, tt := range tests {
+		v, err := sqliteValue(tt.v, tt.typ)
+		switch {
+		case tt.want == "" && err == nil:
+			t.Errorf("sqliteValue(%#v, %s) = %v, want an error", tt.v, tt.typ, v)
+		case tt.want != "" && err != nil:
+			t.Errorf("sqliteValue(%#v, %s): %v", tt.v, tt.typ, err)
+		case tt.want != "" && v.String() != tt.want:
+			t.Errorf("sqliteValue(%#v, %s) = %v, want %s", tt.v, tt.typ, v, tt.want)
+		}
+	}
+}
+
+// TestImportSQLite は internal/sqlite の testdata/rollback.db を取り込み、列の型、行、
+// インデックスを確かめます。
+func TestImportSQLite(t *testing.T) {
+	sq, err := sqlite.Open("../../internal/sqlite/testdata/rollback.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sq.Close()
+	objs, err := sq.Schema()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sqliteTables(sq, objs, "pairs, nope"); err == nil {
+		t.Error("sqliteTables accepted a missing table")
+	}
+	tables, err := sqliteTables(sq, objs, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantTypes := map[string][]types.Type{
+		"items": {types.BigInt, types.Text, types.Real, types.Blob, types.Text, types.BigInt},
+		"pairs": {types.Text, types.BigInt, types.Text},
+		"gen":   {types.BigInt, types.Null, types.BigInt}, // 値を格納しない生成列は移さない
+	}
+	if len(tables) != len(wantTypes) {
+		t.Fatalf("sqliteTables returned %d tables, want %d", len(tables), len(wantTypes))
+	}
+	for _, it := range tables {
+		if !reflect.DeepEqual(it.types, wantTypes[it.t.Name]) {
+			t.Errorf("table %s has types %v, want %v", it.t.Name, it.types, wantTypes[it.t.Name])
+		}
+	}
+
+	db, err := engine.Open(filepath.Join(t.TempDir(), "test.db"), engine.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	err = inTx(db, func(tx *engine.Tx) error {
+		for _, it := range tables {
+			if err := tx.CreateTable(it.schema()); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, it := range tables {
+		if _, err := it.load(sq, db, 100); err != nil {
+			t.Fatalf("load %s: %v", it.t.Name, err)
+		}
+	}
+	if n := createSQLiteIndexes(db, tables, objs); n != 1 {
+		t.Errorf("created %d indexes, want 1 (items_name)", n)
+	}
+
+	// INTEGER PRIMARY KEY は値を省略すると番号を割り当てる
+	if _, err := db.Exec("INSERT INTO items (name) VALUES ('next')"); err != nil {
+		t.Fatal(err)
+	}
+
+	tx, err := db.Begin(txn.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	tests := []struct {
+		sql  string
+		want string
+	}{
+		{"SELECT id, qty FROM items WHERE id = 1 OR id >= 300", "1 5 300 5 301 9 302 5"},
+		{"SELECT name, price, data FROM items WHERE id = 2", "item2 1 x'00ff'"},
+		{"SELECT id FROM items WHERE note = '" + strings.Repeat("a", 2000) + "'", "7"},
+		{"SELECT id FROM items WHERE name = 'next'", "302"},
+		{"SELECT a, c FROM pairs ORDER BY b", "y first x second"},
+		{"SELECT x, z FROM gen", "3 4"},
+	}
+	for _, tt := range tests {
+		rows, err := tx.Query(tt.sql)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.sql, err)
+		}
+		got := ""
+		for rows.Next() {
+			for _, v := range rows.Values() {
+				if got != "" {
+					got += " "
+				}
+				got += v.String()
+			}
+		}
+		if err := rows.Err(); err != nil {
+			t.Fatalf("%s: %v", tt.sql, err)
+		}
+		rows.Close()
+		if got != tt.want {
+			t.Errorf("%s = %q, want %q", tt.sql, got, tt.want)
+		}
+	}
+}
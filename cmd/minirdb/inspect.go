@@ -0,0 +1,203 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/k-sml/go-rdbms/internal/btree"
+	"github.com/k-sml/go-rdbms/internal/pager"
+	"github.com/k-sml/go-rdbms/internal/storage"
+)
+
+// runInspect はデータベースファイルの1つのページのヘッダをデコードして表示し、続けてページの
+// 内容を16進数で表示します。
+func runInspect(args []string) {
+	if len(args) != 2 {
+		log.Fatalf("Usage: minirdb inspect <dbfile> <pageID>")
+	}
+	id, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil || id < 0 {
+		log.Fatalf("invalid page ID: %s", args[1])
+	}
+	p, err := openPages(args[0])
+	if err != nil {
+		log.Fatalf("Error opening database file: %v", err)
+	}
+	defer p.Close()
+	h, err := storage.ReadHeader(p)
+	if err != nil {
+		log.Fatalf("Error reading file header: %v", err)
+	}
+	if id >= max(h.NumPages, 1) {
+		log.Fatalf("page %d is beyond the end of the database (%d pages)", id, h.NumPages)
+	}
+	buf, err := p.ReadPage(id)
+	if err != nil {
+		log.Fatalf("Error reading page %d: %v", id, err)
+	}
+	fmt.Printf("page %d of %d (page size %d)\n", id, h.NumPages, len(buf))
+	describePage(os.Stdout, id, buf)
+	fmt.Println()
+	hexDump(os.Stdout, buf)
+}
+
+// openPages はデータベースファイルを読むだけのページャーで開きます。ページサイズはファイルヘッダの
+// 値を使い、シャドウページングのファイルはその形式で開きます。
+func openPages(path string) (*pager.Pager, error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, err
+	}
+	open := func(pageSize int) (*pager.Pager, error) {
+		opts := pager.Options{PageSize: pageSize, ReadOnly: true}
+		p, err := pager.OpenWithOptions(path, opts)
+		if errors.Is(err, pager.ErrJournalMismatch) {
+			opts.Journal = pager.JournalShadow
+			p, err = pager.OpenWithOptions(path, opts)
+		}
+		return p, err
+	}
+	p, err := open(storage.DefaultPageSize)
+	if err != nil {
+		return nil, err
+	}
+	buf, err := p.ReadPage(0)
+	if err != nil {
+		p.Close()
+		return nil, err
+	}
+	h, ok, err := storage.ReadFileHeader(buf)
+	if err != nil || !ok {
+		p.Close()
+		return nil, storage.ErrNotDatabase
+	}
+	if int(h.PageSize) == p.PageSize() || h.PageSize == 0 {
+		return p, nil
+	}
+	p.Close()
+	return open(int(h.PageSize))
+}
+
+// describePage はページの種類を判定し、種類ごとのヘッダの内容を w に書きます。
+func describePage(w io.Writer, id int64, buf []byte) {
+	switch {
+	case id == 0:
+		h, _, err := storage.ReadFileHeader(buf)
+		if err != nil {
+			fmt.Fprintf(w, "type: file header (unreadable: %v)\n", err)
+			return
+		}
+		fmt.Fprintln(w, "type: file header")
+		fmt.Fprintf(w, "  version: %d\n  page size: %d\n  next xid: %d\n  pages: %d\n  free list head: %d\n  schema version: %d\n",
+			h.Version, h.PageSize, h.NextXID, h.NumPages, h.FreeHead, h.SchemaVersion)
+	case storage.IsFreePage(buf):
+		fmt.Fprintln(w, "type: free page")
+		fmt.Fprintf(w, "  next free page: %d\n", storage.NextFreePage(buf))
+	case storage.IsDirPage(buf):
+		pages, next := storage.ReadDirPage(buf)
+		fmt.Fprintln(w, "type: heap directory")
+		fmt.Fprintf(w, "  entries: %d\n  next directory page: %d\n  data pages: %s\n", len(pages), next, joinIDs(pages))
+	case btree.IsNodePage(buf):
+		n, err := btree.DecodeNode(buf)
+		if err != nil {
+			fmt.Fprintf(w, "type: btree node (%v)\n", err)
+			return
+		}
+		if n.Leaf {
+			fmt.Fprintln(w, "type: btree leaf")
+			fmt.Fprintf(w, "  entries: %d\n  next leaf: %d\n", len(n.Keys), n.Next)
+		} else {
+			fmt.Fprintln(w, "type: btree internal node")
+			fmt.Fprintf(w, "  entries: %d\n  leftmost child: %d\n  children: %s\n", len(n.Keys), n.Next, joinIDs(n.Children))
+		}
+		fmt.Fprintf(w, "  used: %d bytes\n  free space: %d bytes\n", n.Size, len(buf)-n.Size)
+	case isZero(buf):
+		fmt.Fprintln(w, "type: unused (all zero)")
+	case storage.IsColumnPage(buf):
+		cp, err := storage.NewColumnPage(buf)
+		if err != nil {
+			fmt.Fprintf(w, "type: columnar heap data page (%v)\n", err)
+			return
+		}
+		live := 0
+		for i := 0; i < cp.NumSlots(); i++ {
+			if _, ok := cp.Get(i); ok {
+				live++
+			}
+		}
+		fmt.Fprintln(w, "type: columnar heap data page")
+		fmt.Fprintf(w, "  slots: %d (%d live, %d deleted)\n  columns: %d\n  free space: %d bytes\n",
+			cp.NumSlots(), live, cp.NumSlots()-live, cp.NumColumns(), cp.FreeSpace())
+	case storage.LooksLikeHeapPage(buf):
+		hp, _ := storage.NewHeapPage(buf)
+		live := 0
+		for i := 0; i < hp.NumSlots(); i++ {
+			if _, n, _ := hp.Slot(i); n > 0 {
+				live++
+			}
+		}
+		fmt.Fprintln(w, "type: heap data page")
+		fmt.Fprintf(w, "  slots: %d (%d live, %d deleted)\n  free space: %d bytes\n",
+			hp.NumSlots(), live, hp.NumSlots()-live, hp.FreeSpace())
+		for i := 0; i < hp.NumSlots(); i++ {
+			off, n, _ := hp.Slot(i)
+			if n == 0 {
+				fmt.Fprintf(w, "  slot %d: deleted\n", i)
+			} else {
+				fmt.Fprintf(w, "  slot %d: offset %d, length %d\n", i, off, n)
+			}
+		}
+	default:
+		fmt.Fprintln(w, "type: unknown")
+	}
+}
+
+// hexDump はページの内容を、1行に16バイトずつ16進数と文字で w に書きます。
+// 前の行と同じ内容の行が続くところは * の1行にまとめます。
+func hexDump(w io.Writer, buf []byte) {
+	var prev []byte
+	skipping := false
+	for off := 0; off < len(buf); off += 16 {
+		line := buf[off:min(off+16, len(buf))]
+		if prev != nil && string(line) == string(prev) {
+			if !skipping {
+				fmt.Fprintln(w, "*")
+				skipping = true
+			}
+			continue
+		}
+		prev, skipping = line, false
+		var text strings.Builder
+		for _, c := range line {
+			if c < 0x20 || c > 0x7e {
+				c = '.'
+			}
+			text.WriteByte(c)
+		}
+		fmt.Fprintf(w, "%08x  %-47s  |%s|\n", off, fmt.Sprintf("% x", line), text.String())
+	}
+	fmt.Fprintf(w, "%08x\n", len(buf))
+}
+
+// joinIDs はページIDを , で区切って並べます。
+func joinIDs(ids []int64) string {
+	s := make([]string, len(ids))
+	for i, id := range ids {
+		s[i] = strconv.FormatInt(id, 10)
+	}
+	return strings.Join(s, ", ")
+}
+
+// isZero は buf がすべてゼロかを返します。
+func isZero(buf []byte) bool {
+	for _, b := range buf {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode"
+)
+
+// 行の編集
+//
+// 端末から読むときは、端末を1文字ずつ読める状態にして、readline と同じようなキー操作で行を
+// 編集できるようにする。←→ と Ctrl-B/Ctrl-F でカーソルを動かし、Home/End と Ctrl-A/Ctrl-E で
+// 行の先頭と末尾に移る。Ctrl-K はカーソルから行末まで、Ctrl-U は行頭からカーソルまで、Ctrl-W は
+// カーソルの前の語を消す。↑↓ と Ctrl-P/Ctrl-N で履歴をたどり、Tab で語を補完する。
+// Ctrl-C は入力中の行を捨て、空の行での Ctrl-D は入力の終わりになる。
+//
+// 入力した行は履歴のファイルに1行ずつ追記し、次に起動したときに読み込む。
+
+// errInterrupt は Ctrl-C で入力中の行を捨てたことを表します。
+var errInterrupt = errors.New("interrupted")
+
+// maxHistory は履歴として覚えておく行の数の上限です。
+const maxHistory = 1000
+
+// lineEditor は端末から1行を編集しながら読みます。
+type lineEditor struct {
+	fd  uintptr
+	in  *bufio.Reader
+	out io.Writer
+
+	history  []string
+	histFile string // 履歴を追記するファイル。空なら保存しない
+
+	// complete は行 line のカーソルの前の語 word の補完の候補を返します。
+	complete func(line []rune, word string) []string
+}
+
+// newLineEditor は端末 in から読む lineEditor を作り、histFile から履歴を読み込みます。
+func newLineEditor(in *os.File, out io.Writer, histFile string) *lineEditor {
+	e := &lineEditor{fd: in.Fd(), in: bufio.NewReader(in), out: out, histFile: histFile}
+	if histFile != "" {
+		if data, err := os.ReadFile(histFile); err == nil {
+			for _, line := range strings.Split(string(data), "\n") {
+				if line != "" {
+					e.history = append(e.history, line)
+				}
+			}
+		}
+	}
+	if n := len(e.history); n > maxHistory {
+		e.history = e.history[n-maxHistory:]
+	}
+	return e
+}
+
+// historyPath は履歴のファイル ~/.minirdb_history のパスを返します。ホームディレクトリが
+// 分からなければ空です。
+func historyPath() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return home + string(os.PathSeparator) + ".minirdb_history"
+}
+
+// addHistory は行 line を履歴に加え、履歴のファイルに追記します。空の行と、直前と同じ行は加えません。
+func (e *lineEditor) addHistory(line string) {
+	if strings.TrimSpace(line) == "" || len(e.history) > 0 && e.history[len(e.history)-1] == line {
+		return
+	}
+	e.history = append(e.history, line)
+	if len(e.history) > maxHistory {
+		e.history = e.history[1:]
+	}
+	if e.histFile == "" {
+		return
+	}
+	f, err := os.OpenFile(e.histFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return
+	}
+	fmt.Fprintln(f, line)
+	f.Close()
+}
+
+// lineState は編集中の行です。
+type lineState struct {
+	e      *lineEditor
+	prompt string
+	buf    []rune
+	pos    int // カーソルの位置（buf の添字）
+}
+
+// readLine は prompt を表示して1行を読みます。入力の終わりでは io.EOF、Ctrl-C では errInterrupt を返します。
+func (e *lineEditor) readLine(prompt string) (string, error) {
+	st, err := makeRaw(e.fd)
+	if err != nil {
+		return "", err
+	}
+	defer restore(e.fd, st)
+
+	s := &lineState{e: e, prompt: prompt}
+	hist := len(e.history) // 表示している履歴の位置。len(e.history) なら入力中の行
+	saved := ""            // 履歴をたどる前に入力していた行
+	lastTab := false
+	s.refresh()
+	for {
+		r, _, err := e.in.ReadRune()
+		if err != nil {
+			return "", err
+		}
+		tab := false
+		switch r {
+		case '\r', '\n':
+			fmt.Fprint(e.out, "\r\n")
+			return string(s.buf), nil
+		case 3: // Ctrl-C
+			fmt.Fprint(e.out, "^C\r\n")
+			return "", errInterrupt
+		case 4: // Ctrl-D
+			if len(s.buf) == 0 {
+				fmt.Fprint(e.out, "\r\n")
+				return "", io.EOF
+			}
+			s.delete(s.pos, s.pos+1)
+		case 1: // Ctrl-A
+			s.pos = 0
+		case 5: // Ctrl-E
+			s.pos = len(s.buf)
+		case 2: // Ctrl-B
+			s.pos = max(s.pos-1, 0)
+		case 6: // Ctrl-F
+			s.pos = min(s.pos+1, len(s.buf))
+		case 127, 8: // Backspace
+			if s.pos > 0 {
+				s.delete(s.pos-1, s.pos)
+			}
+		case 11: // Ctrl-K
+			s.delete(s.pos, len(s.buf))
+		case 21: // Ctrl-U
+			s.delete(0, s.pos)
+		case 23: // Ctrl-W
+			i := s.pos
+			for i > 0 && unicode.IsSpace(s.buf[i-1]) {
+				i--
+			}
+			for i > 0 && !unicode.IsSpace(s.buf[i-1]) {
+				i--
+			}
+			s.delete(i, s.pos)
+		case 12: // Ctrl-L
+			fmt.Fprint(e.out, "\x1b[H\x1b[2J")
+		case 16, 14: // Ctrl-P, Ctrl-N
+			hist, saved = s.browse(hist, saved, r == 16)
+		case '\t':
+			tab = true
+			s.completeWord(lastTab)
+		case 27: // ESC で始まるキーの列
+			switch s.escape() {
+			case "[A", "OA":
+				hist, saved = s.browse(hist, saved, true)
+			case "[B", "OB":
+				hist, saved = s.browse(hist, saved, false)
+			case "[C", "OC":
+				s.pos = min(s.pos+1, len(s.buf))
+			case "[D", "OD":
+				s.pos = max(s.pos-1, 0)
+			case "[H", "OH", "[1~", "[7~":
+				s.pos = 0
+			case "[F", "OF", "[4~", "[8~":
+				s.pos = len(s.buf)
+			case "[3~":
+				s.delete(s.pos, s.pos+1)
+			}
+		default:
+			if unicode.IsPrint(r) {
+				s.buf = append(s.buf[:s.pos], append([]rune{r}, s.buf[s.pos:]...)...)
+				s.pos++
+			}
+		}
+		lastTab = tab
+		s.refresh()
+	}
+}
+
+// escape は ESC に続くキーの列を読みます。
+func (s *lineState) escape() string {
+	var b strings.Builder
+	r, _, err := s.e.in.ReadRune()
+	if err != nil || r != '[' && r != 'O' {
+		return ""
+	}
+	b.WriteRune(r)
+	for {
+		r, _, err := s.e.in.ReadRune()
+		if err != nil {
+			return ""
+		}
+		b.WriteRune(r)
+		if r >= 0x40 && r <= 0x7e && !(r == '[' && b.Len() == 1) {
+			return b.String()
+		}
+	}
+}
+
+// delete は buf[i:j] を消してカーソルを i に置きます。
+func (s *lineState) delete(i, j int) {
+	if j > len(s.buf) || i >= j {
+		return
+	}
+	s.buf = append(s.buf[:i], s.buf[j:]...)
+	s.pos = i
+}
+
+// browse は履歴を1つ前（back）または1つ後にたどり、その行を編集中の行にします。
+func (s *lineState) browse(hist int, saved string, back bool) (int, string) {
+	h := s.e.history
+	if hist == len(h) {
+		saved = string(s.buf)
+	}
+	switch {
+	case back && hist > 0:
+		hist--
+	case !back && hist < len(h):
+		hist++
+	default:
+		return hist, saved
+	}
+	line := saved
+	if hist < len(h) {
+		line = h[hist]
+	}
+	s.buf, s.pos = []rune(line), len([]rune(line))
+	return hist, saved
+}
+
+// completeWord はカーソルの前の語を補完します。候補が1つならそれにし、複数なら共通の先頭の部分まで
+// 補います。補う部分がないまま Tab を続けて押すと（again）、候補を一覧にして表示します。
+func (s *lineState) completeWord(again bool) {
+	if s.e.complete == nil {
+		return
+	}
+	start := s.pos
+	for start > 0 && isWordRune(s.buf[start-1]) {
+		start--
+	}
+	word := string(s.buf[start:s.pos])
+	cands := s.e.complete(s.buf, word)
+	if len(cands) == 0 {
+		return
+	}
+	prefix := cands[0]
+	for _, c := range cands[1:] {
+		prefix = commonPrefix(prefix, c)
+	}
+	if len(cands) == 1 {
+		prefix += " "
+	}
+	if len([]rune(prefix)) > len([]rune(word)) {
+		rest := []rune(prefix)
+		s.buf = append(s.buf[:start], append(rest, s.buf[s.pos:]...)...)
+		s.pos = start + len(rest)
+		return
+	}
+	if again {
+		fmt.Fprint(s.e.out, "\r\n"+strings.Join(cands, "  ")+"\r\n")
+	}
+}
+
+// isWordRune は r が補完する語（テーブル名.列名 を含む）の文字かを返します。
+func isWordRune(r rune) bool {
+	return r == '_' || r == '.' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// commonPrefix は a と b の先頭の共通の部分を、大文字と小文字を区別せずに求めて a の表記で返します。
+func commonPrefix(a, b string) string {
+	ar, br := []rune(a), []rune(b)
+	n := 0
+	for n < len(ar) && n < len(br) && unicode.ToLower(ar[n]) == unicode.ToLower(br[n]) {
+		n++
+	}
+	return string(ar[:n])
+}
+
+// refresh は行を表示し直し、カーソルを編集している位置に置きます。
+func (s *lineState) refresh() {
+	tail := displayWidth(string(s.buf[s.pos:]))
+	out := "\r" + s.prompt + string(s.buf) + "\x1b[K"
+	if tail > 0 {
+		out += fmt.Sprintf("\x1b[%dD", tail)
+	}
+	fmt.Fprint(s.e.out, out)
+}
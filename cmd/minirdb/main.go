@@ -1,10 +1,14 @@
 package main
 
 import (
+	"errors"
+	"flag"
 	"fmt"
 	"log"
 	"os"
+	"strings"
 
+	"github.com/k-sml/go-rdbms/internal/engine"
 	"github.com/k-sml/go-rdbms/internal/pager"
 )
 
@@ -13,36 +17,127 @@ import (
 func main() {
 	// コマンドライン引数の数をチェック（データベースファイル名が必要）
 	if len(os.Args) < 2 {
-		log.Fatalf("Usage: minirdb <dbfile>")
-		os.Exit(1)
+		log.Fatalf("Usage: minirdb [--readonly] [--journal mode] [--parallel n] [--batch-size n] <dbfile> [-c SQL] | minirdb crashtest [flags] | minirdb wal-dump [flags] <dbfile> | minirdb inspect <dbfile> <pageID> | minirdb check <dbfile> | minirdb btree-dump [-dot] <dbfile> <index> | minirdb vacuum <dbfile> | minirdb backup <dbfile> <dest> | minirdb restore <backup> <dbfile> | minirdb bench [flags] | minirdb stress [flags] | minirdb export [flags] <dbfile> | minirdb import-sqlite [flags] <sqlite-file> <dbfile> | minirdb serve [--listen addr] [--pg-listen addr] [--http-listen addr] [--grpc-listen addr] [--tls-cert file --tls-key file] [--follow addr] [flags] <dbfile>")
 	}
-	// コマンドライン引数からデータベースファイル名を取得
-	dbfile := os.Args[1]
+	// サブコマンドの処理
+	switch os.Args[1] {
+	case "crashtest":
+		runCrashTest(os.Args[2:])
+		return
+	case "wal-dump":
+		runWALDump(os.Args[2:])
+		return
+	case "inspect":
+		runInspect(os.Args[2:])
+		return
+	case "check":
+		os.Exit(runCheck(os.Args[2:]))
+	case "btree-dump":
+		runBTreeDump(os.Args[2:])
+		return
+	case "vacuum":
+		runVacuum(os.Args[2:])
+		return
+	case "backup":
+		runBackup(os.Args[2:])
+		return
+	case "restore":
+		runRestore(os.Args[2:])
+		return
+	case "bench":
+		runBench(os.Args[2:])
+		return
+	case "stress":
+		os.Exit(runStress(os.Args[2:]))
+	case "export":
+		runExport(os.Args[2:])
+		return
+	case "import-sqlite":
+		runImportSQLite(os.Args[2:])
+		return
+	case "serve":
+		runServe(os.Args[2:])
+		return
+	}
+	os.Exit(runShell(os.Args[1:]))
+}
 
-	// ページサイズ4096バイトでデータベースファイルを開く
-	p, err := pager.Open(dbfile, 4096)
-	if err != nil {
-		log.Fatalf("Error opening database file: %v", err)
+// runShell は引数で指定したデータベースファイルを開いてシェルを実行し、終了コードを返します。
+// フラグはデータベースファイル名の前にも後にも書けます。
+// -c を指定するとその SQL 文を、標準入力が端末でなければ標準入力から読んだスクリプトを実行し、
+// エラーになった文で実行をやめて 1 を返します。--readonly を指定するとファイルを読むだけで開き、
+// データベースを変更する文は実行せずにエラーにします。--journal は serve と同じくジャーナルモードで、
+// 指定しなければ wal で開き、シャドウページングのファイルならそのモードで開き直します
+// （:memory: は none で開きます）。
+// --parallel と --batch-size は問い合わせの並列度とバッチの行の数です（engine.Options）。
+func runShell(args []string) int {
+	fs := flag.NewFlagSet("minirdb", flag.ExitOnError)
+	command := fs.String("c", "", "execute the SQL statements or meta-command and exit")
+	readOnly := fs.Bool("readonly", false, "open the database read-only and reject statements that modify it")
+	journal := fs.String("journal", "wal", "journal mode: wal, shadow or none")
+	parallel := fs.Int("parallel", 0, "maximum number of goroutines that scan one table (1 or less disables parallel scans)")
+	batchSize := fs.Int("batch-size", 0, "rows processed together by query operators (0 means 1024, 1 means one row at a time)")
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "Usage: minirdb [--readonly] [--journal mode] [--parallel n] [--batch-size n] <dbfile> [-c SQL]")
+		return 2
 	}
-	// 関数終了時にページャーを確実にクローズ
-	defer p.Close()
+	// コマンドライン引数からデータベースファイル名を取得し、後に続くフラグを読む
+	dbfile := fs.Arg(0)
+	fs.Parse(fs.Args()[1:])
+	if fs.NArg() > 0 {
+		fmt.Fprintf(os.Stderr, "unexpected argument: %s\n", fs.Arg(0))
+		return 2
+	}
+	commandSet, journalSet := false, false
+	fs.Visit(func(f *flag.Flag) {
+		commandSet = commandSet || f.Name == "c"
+		journalSet = journalSet || f.Name == "journal"
+	})
 
-	// ページ0を読み込み
-	buf, err := p.ReadPage(0)
-	if err != nil {
-		log.Fatalf("Error reading page: %v", err)
+	opts := engine.Options{ReadOnly: *readOnly, Parallel: *parallel, BatchSize: *batchSize}
+	switch *journal {
+	case "wal":
+		opts.Journal = pager.JournalWAL
+	case "shadow":
+		opts.Journal = pager.JournalShadow
+	case "none":
+		opts.Journal = pager.JournalNone
+	default:
+		fmt.Fprintf(os.Stderr, "unknown journal mode: %s\n", *journal)
+		return 2
 	}
-	// ページ0の先頭4バイトにマジックナンバー "MRDB" を書き込み
-	copy(buf[:4], []byte{'M', 'R', 'D', 'B'})
-	// 変更されたページをデータベースファイルに書き込み
-	if err := p.WritePage(0, buf); err != nil {
-		log.Fatalf("Error writing page: %v", err)
+	if dbfile == engine.MemoryPath && !journalSet {
+		opts.Journal = pager.JournalNone // メモリ上のデータベースはジャーナルを使わない
 	}
-	// メモリ上の変更をディスクにフラッシュ
-	if err := p.Flush(); err != nil {
-		log.Fatalf("Error flushing page: %v", err)
+	db, err := engine.Open(dbfile, opts)
+	if errors.Is(err, pager.ErrJournalMismatch) && !journalSet {
+		opts.Journal = pager.JournalShadow
+		db, err = engine.Open(dbfile, opts)
 	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error opening database file: %v\n", err)
+		return 1
+	}
+	// 関数終了時にデータベースを確実にクローズ
+	defer db.Close()
 
-	// 処理完了のメッセージを出力
-	fmt.Println("OK: wrote magic to page0")
+	sh := &shell{db: db, session: db.NewSession(), out: os.Stdout, err: os.Stderr, readOnly: *readOnly}
+	defer sh.close()
+	if commandSet {
+		if sh.run(strings.NewReader(*command), false) != nil {
+			return 1
+		}
+		return 0
+	}
+	// 端末から読むときだけ、入力を促す記号を表示する
+	interactive := false
+	if fi, err := os.Stdin.Stat(); err == nil && fi.Mode()&os.ModeCharDevice != 0 {
+		interactive = true
+		fmt.Println(`Enter SQL statements terminated with ";"`)
+	}
+	if sh.run(os.Stdin, interactive) != nil {
+		return 1
+	}
+	return 0
 }
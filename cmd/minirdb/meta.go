@@ -0,0 +1,251 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/k-sml/go-rdbms/internal/catalog"
+)
+
+// メタコマンド
+//
+// . で始まる行は SQL 文ではなくシェルへの指示で、sqlite3 のシェルと同じ名前を使う。
+// 行の終わりまでが1つのコマンドで、; は要らない。
+
+// errQuit は .quit でシェルを終えることを表します。
+var errQuit = errors.New("quit")
+
+// metaCommand はメタコマンドです。
+type metaCommand struct {
+	name  string
+	args  string // 引数の書き方
+	help  string
+	run   func(sh *shell, args []string) error
+	alias bool // .help に表示しない別名
+}
+
+var metaCommands []metaCommand
+
+func init() {
+	metaCommands = []metaCommand{
+		{name: ".dump", args: "?PATTERN?", help: "Render database content as SQL", run: (*shell).dump},
+		{name: ".exit", run: (*shell).quit, alias: true},
+		{name: ".headers", args: "on|off", help: "Turn display of column names on or off", run: (*shell).headers},
+		{name: ".help", help: "Show this message", run: (*shell).help},
+		{name: ".mode", args: "?MODE?", help: "Set output mode: table, csv, json or line", run: (*shell).setMode},
+		{name: ".quit", help: "Exit this program", run: (*shell).quit},
+		{name: ".schema", args: "?PATTERN?", help: "Show the CREATE statements matching PATTERN", run: (*shell).schema},
+		{name: ".stats", help: "Show I/O, transaction and lock statistics for this session", run: (*shell).stats},
+		{name: ".tables", args: "?PATTERN?", help: "List names of tables and views matching PATTERN", run: (*shell).tables},
+		{name: ".timer", args: "on|off", help: "Turn per-statement timing and page read counts on or off", run: (*shell).setTimer},
+	}
+}
+
+// isMeta は行 line がメタコマンドかを返します。
+func isMeta(line string) bool {
+	return strings.HasPrefix(strings.TrimSpace(line), ".")
+}
+
+// meta はメタコマンドの行 line を実行します。
+func (sh *shell) meta(line string) error {
+	args := strings.Fields(line)
+	for _, c := range metaCommands {
+		if c.name == args[0] {
+			return c.run(sh, args[1:])
+		}
+	}
+	return fmt.Errorf("unknown command: %s (enter \".help\" for usage hints)", args[0])
+}
+
+// quit はシェルを終えます。
+func (sh *shell) quit([]string) error { return errQuit }
+
+// help はメタコマンドの一覧を表示します。
+func (sh *shell) help([]string) error {
+	for _, c := range metaCommands {
+		if !c.alias {
+			fmt.Fprintf(sh.out, "%-20s %s\n", strings.TrimSpace(c.name+" "+c.args), c.help)
+		}
+	}
+	return nil
+}
+
+// headers は表と CSV に列の名前を表示するかを切り替えます。
+func (sh *shell) headers(args []string) error {
+	if len(args) == 1 {
+		switch strings.ToLower(args[0]) {
+		case "on":
+			sh.noHeaders = false
+			return nil
+		case "off":
+			sh.noHeaders = true
+			return nil
+		}
+	}
+	return errors.New("usage: .headers on|off")
+}
+
+// setMode は問い合わせの結果の表示のしかたを変えます。引数がなければ今の表示のしかたを表示します。
+func (sh *shell) setMode(args []string) error {
+	switch len(args) {
+	case 0:
+		fmt.Fprintln(sh.out, "current output mode:", sh.mode)
+		return nil
+	case 1:
+		if m, ok := parseMode(args[0]); ok {
+			sh.mode = m
+			return nil
+		}
+	}
+	return errors.New("usage: .mode table|csv|json|line")
+}
+
+// schema はテーブル、インデックス、ビューを作る文を表示します。パターンを指定すると、
+// 名前がパターンに一致するテーブルとそのインデックス、ビューだけを表示します。
+func (sh *shell) schema(args []string) error {
+	if len(args) > 1 {
+		return errors.New("usage: .schema ?PATTERN?")
+	}
+	return sh.withCatalog(func(cat *catalog.Catalog) {
+		for _, t := range cat.Tables() {
+			if len(args) > 0 && !matchPattern(args[0], t.Name) {
+				continue
+			}
+			fmt.Fprintln(sh.out, createTableSQL(t)+";")
+			for _, ix := range cat.Indexes(t.Name) {
+				if cat.IsPrimaryKey(ix) {
+					continue // CREATE TABLE が作る
+				}
+				fmt.Fprintln(sh.out, createIndexSQL(ix)+";")
+			}
+		}
+		for _, v := range cat.Views() {
+			if len(args) == 0 || matchPattern(args[0], v.Name) {
+				fmt.Fprintln(sh.out, createViewSQL(v)+";")
+			}
+		}
+	})
+}
+
+// stats はデータベースを開いてからのページの読み書き、トランザクション、ロックの統計と、
+// 実行中のトランザクションを表示します。
+func (sh *shell) stats(args []string) error {
+	if len(args) > 0 {
+		return errors.New("usage: .stats")
+	}
+	st := sh.db.Stats()
+	memory := st.Txn.CachedReads + st.Pager.PendingReads + st.Pager.CacheHits
+	ratio := 0.0
+	if st.Txn.PageReads > 0 {
+		ratio = float64(memory) * 100 / float64(st.Txn.PageReads)
+	}
+	w := sh.out
+	fmt.Fprintf(w, "%-20s %d\n", "page size:", st.PageSize)
+	fmt.Fprintf(w, "%-20s %d (%d from memory, %.1f%% hit ratio)\n", "pages read:", st.Txn.PageReads, memory, ratio)
+	fmt.Fprintf(w, "%-20s %d (%d written to the file)\n", "pages written:", st.Pager.Writes, st.Pager.FileWrites)
+	fmt.Fprintf(w, "%-20s %d\n", "commits flushed:", st.Pager.Commits)
+	fmt.Fprintf(w, "%-20s %d\n", "WAL bytes written:", st.Pager.WALBytes)
+	fmt.Fprintf(w, "%-20s %d active, %d committed, %d rolled back\n", "transactions:",
+		st.Txn.Active, st.Txn.Commits, st.Txn.Rollbacks)
+	fmt.Fprintf(w, "%-20s %d granted on %d resources, %d waiting\n", "locks:",
+		st.Locks.Granted, st.Locks.Resources, st.Locks.Waiting)
+	fmt.Fprintf(w, "%-20s %d (%d deadlocks, %d timeouts)\n", "lock waits:",
+		st.Locks.Waits, st.Locks.Deadlocks, st.Locks.Timeouts)
+	for _, tx := range sh.db.Transactions().Active() {
+		mode := "read-write"
+		if tx.ReadOnly {
+			mode = "read-only"
+		}
+		fmt.Fprintf(w, "  tx %d: %s, %s, running %s, %d locks", tx.ID, tx.Isolation, mode,
+			tx.Age().Round(time.Millisecond), len(tx.Locks))
+		if tx.Waiting != nil {
+			fmt.Fprintf(w, ", waiting for %s", tx.Waiting.Resource)
+		}
+		fmt.Fprintln(w)
+	}
+	return nil
+}
+
+// tables はテーブルとビューの名前を、端末の幅に収まるように何列かに並べて表示します。
+func (sh *shell) tables(args []string) error {
+	if len(args) > 1 {
+		return errors.New("usage: .tables ?PATTERN?")
+	}
+	var names []string
+	err := sh.withCatalog(func(cat *catalog.Catalog) {
+		for _, t := range cat.Tables() {
+			names = append(names, t.Name)
+		}
+		for _, v := range cat.Views() {
+			names = append(names, v.Name)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	names = slices.DeleteFunc(names, func(n string) bool { return len(args) > 0 && !matchPattern(args[0], n) })
+	slices.Sort(names)
+	width := 0
+	for _, n := range names {
+		width = max(width, displayWidth(n)+2)
+	}
+	perLine := max(1, 80/max(width, 1))
+	for i, n := range names {
+		if i%perLine == perLine-1 || i == len(names)-1 {
+			fmt.Fprintln(sh.out, n)
+		} else {
+			fmt.Fprint(sh.out, n+strings.Repeat(" ", width-displayWidth(n)))
+		}
+	}
+	return nil
+}
+
+// setTimer は文ごとにかかった時間、行の数、読んだページの数を表示するかを切り替えます。
+func (sh *shell) setTimer(args []string) error {
+	if len(args) == 1 {
+		switch strings.ToLower(args[0]) {
+		case "on":
+			sh.timer = true
+			return nil
+		case "off":
+			sh.timer = false
+			return nil
+		}
+	}
+	return errors.New("usage: .timer on|off")
+}
+
+// matchPattern は名前 s が LIKE のパターン pattern に一致するかを、大文字と小文字を区別せずに返します。
+// % は任意の文字列、_ は任意の1文字に一致します。
+func matchPattern(pattern, s string) bool {
+	pattern, s = strings.ToLower(pattern), strings.ToLower(s)
+	for pattern != "" {
+		switch pattern[0] {
+		case '%':
+			for i := range s {
+				if matchPattern(pattern[1:], s[i:]) {
+					return true
+				}
+			}
+			return matchPattern(pattern[1:], "")
+		case '_':
+			if s == "" {
+				return false
+			}
+			_, n := utf8.DecodeRuneInString(s)
+			pattern, s = pattern[1:], s[n:]
+		default:
+			r, n := utf8.DecodeRuneInString(pattern)
+			c, m := utf8.DecodeRuneInString(s)
+			if s == "" || r != c {
+				return false
+			}
+			pattern, s = pattern[n:], s[m:]
+		}
+	}
+	return s == ""
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/k-sml/go-rdbms/internal/types"
+)
+
+// outputMode は問い合わせの結果の表示のしかたです。
+type outputMode int
+
+const (
+	modeTable outputMode = iota // 罫線で囲んだ表
+	modeCSV                     // RFC 4180 の CSV
+	modeJSON                    // 行ごとのオブジェクトの配列
+	modeLine                    // 1行に1つの列の「名前 = 値」
+)
+
+var modeNames = [...]string{modeTable: "table", modeCSV: "csv", modeJSON: "json", modeLine: "line"}
+
+func (m outputMode) String() string { return modeNames[m] }
+
+// parseMode は表示のしかたの名前を outputMode にします。
+func parseMode(s string) (outputMode, bool) {
+	for m, name := range modeNames {
+		if strings.EqualFold(s, name) {
+			return outputMode(m), true
+		}
+	}
+	return 0, false
+}
+
+// printResult は問い合わせの結果を mode で表示します。headers が false なら、表と CSV に
+// 列の名前の行を付けません。
+func printResult(w io.Writer, mode outputMode, headers bool, cols []string, rows [][]types.Value) error {
+	switch mode {
+	case modeCSV:
+		return printCSV(w, headers, cols, rows)
+	case modeJSON:
+		return printJSON(w, cols, rows)
+	case modeLine:
+		printLines(w, cols, rows)
+	default:
+		printTable(w, headers, cols, rows)
+	}
+	return nil
+}
+
+// printTable は問い合わせの結果を罫線で囲んだ表にして表示します。数は右に、それ以外は左にそろえます。
+func printTable(w io.Writer, headers bool, cols []string, rows [][]types.Value) {
+	if !headers && len(rows) == 0 {
+		return
+	}
+	widths := make([]int, len(cols))
+	for i, c := range cols {
+		if headers {
+			widths[i] = displayWidth(c)
+		}
+	}
+	cells := make([][]string, len(rows))
+	for r, row := range rows {
+		cells[r] = make([]string, len(row))
+		for i, v := range row {
+			cells[r][i] = cellText(v)
+			widths[i] = max(widths[i], displayWidth(cells[r][i]))
+		}
+	}
+	var rule strings.Builder
+	for _, n := range widths {
+		rule.WriteString("+" + strings.Repeat("-", n+2))
+	}
+	rule.WriteString("+\n")
+
+	line := func(texts []string, right func(i int) bool) {
+		var b strings.Builder
+		for i, s := range texts {
+			pad := strings.Repeat(" ", widths[i]-displayWidth(s))
+			if right(i) {
+				fmt.Fprintf(&b, "| %s%s ", pad, s)
+			} else {
+				fmt.Fprintf(&b, "| %s%s ", s, pad)
+			}
+		}
+		b.WriteString("|\n")
+		io.WriteString(w, b.String())
+	}
+	io.WriteString(w, rule.String())
+	if headers {
+		line(cols, func(int) bool { return false })
+		io.WriteString(w, rule.String())
+	}
+	for r, row := range rows {
+		line(cells[r], func(i int) bool { return row[i].Type().IsNumeric() })
+	}
+	if len(rows) > 0 {
+		io.WriteString(w, rule.String())
+	}
+}
+
+// printCSV は問い合わせの結果を CSV にして表示します。NULL は空のフィールドになります。
+func printCSV(w io.Writer, headers bool, cols []string, rows [][]types.Value) error {
+	cw := csv.NewWriter(w)
+	if headers {
+		cw.Write(cols)
+	}
+	record := make([]string, len(cols))
+	for _, row := range rows {
+		cw.Write(csvRecord(record, row))
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// csvRecord は1行の値を CSV のフィールドにして record に入れ、record を返します。
+// NULL は空のフィールドになります。
+func csvRecord(record []string, row []types.Value) []string {
+	for i, v := range row {
+		record[i] = ""
+		if !v.IsNull() {
+			record[i] = v.String()
+		}
+	}
+	return record
+}
+
+// printJSON は問い合わせの結果を、列の名前をキーにしたオブジェクトの配列にして表示します。
+// キーは列の順に並べます。
+func printJSON(w io.Writer, cols []string, rows [][]types.Value) error {
+	keys, err := jsonKeys(cols)
+	if err != nil {
+		return err
+	}
+	var b strings.Builder
+	b.WriteString("[")
+	for r, row := range rows {
+		if r > 0 {
+			b.WriteString(",\n")
+		}
+		if err := appendJSONRow(&b, keys, row); err != nil {
+			return err
+		}
+	}
+	b.WriteString("]\n")
+	_, err = io.WriteString(w, b.String())
+	return err
+}
+
+// jsonKeys は列の名前を JSON の文字列にします。
+func jsonKeys(cols []string) ([]string, error) {
+	keys := make([]string, len(cols))
+	for i, c := range cols {
+		k, err := json.Marshal(c)
+		if err != nil {
+			return nil, err
+		}
+		keys[i] = string(k)
+	}
+	return keys, nil
+}
+
+// appendJSONRow は1行を、keys をキーにしたオブジェクトにして b に書きます。キーは列の順に並べます。
+func appendJSONRow(b *strings.Builder, keys []string, row []types.Value) error {
+	b.WriteString("{")
+	for i, v := range row {
+		if i > 0 {
+			b.WriteString(",")
+		}
+		b.WriteString(keys[i] + ":")
+		if err := appendJSON(b, v); err != nil {
+			return err
+		}
+	}
+	b.WriteString("}")
+	return nil
+}
+
+// appendJSON は値 v を JSON の値にして b に書きます。NaN と無限大は JSON で書けないので null にします。
+// BLOB は16進数の文字列にします。
+func appendJSON(b *strings.Builder, v types.Value) error {
+	switch v.Type() {
+	case types.Null:
+		b.WriteString("null")
+	case types.Int, types.BigInt:
+		b.WriteString(strconv.FormatInt(v.Int(), 10))
+	case types.Real:
+		if f := v.Real(); math.IsNaN(f) || math.IsInf(f, 0) {
+			b.WriteString("null")
+		} else {
+			b.WriteString(strconv.FormatFloat(f, 'g', -1, 64))
+		}
+	case types.Boolean:
+		b.WriteString(strconv.FormatBool(v.Bool()))
+	case types.Blob:
+		b.WriteString(`"` + hex.EncodeToString(v.Blob()) + `"`)
+	default:
+		s, err := json.Marshal(v.String())
+		if err != nil {
+			return err
+		}
+		b.Write(s)
+	}
+	return nil
+}
+
+// printLines は問い合わせの結果を、1行に1つの列の「名前 = 値」にして表示します。
+// 名前は右にそろえ、行と行の間は空行で区切ります。
+func printLines(w io.Writer, cols []string, rows [][]types.Value) {
+	width := 0
+	for _, c := range cols {
+		width = max(width, displayWidth(c))
+	}
+	var b strings.Builder
+	for r, row := range rows {
+		b.Reset()
+		if r > 0 {
+			b.WriteString("\n")
+		}
+		for i, v := range row {
+			fmt.Fprintf(&b, "%s%s = %s\n", strings.Repeat(" ", width-displayWidth(cols[i])), cols[i], v)
+		}
+		io.WriteString(w, b.String())
+	}
+}
+
+// cellText は表に表示する値の文字列です。改行とタブは空白にします。
+func cellText(v types.Value) string {
+	return strings.NewReplacer("\r\n", " ", "\n", " ", "\t", " ").Replace(v.String())
+}
+
+// displayWidth は端末に表示したときの文字列 s の幅です。全角の文字は2文字分に数えます。
+func displayWidth(s string) int {
+	n := 0
+	for _, r := range s {
+		if isWide(r) {
+			n += 2
+		} else {
+			n++
+		}
+	}
+	return n
+}
+
+// isWide は r が全角の文字かを返します。
+func isWide(r rune) bool {
+	if r >= 0xff61 && r <= 0xff9f { // 半角カナ
+		return false
+	}
+	return unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul) ||
+		r >= 0x3000 && r <= 0x303f || // CJK の記号と句読点
+		r >= 0xff01 && r <= 0xff60 || r >= 0xffe0 && r <= 0xffe6 // 全角の英数字と記号
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/k-sml/go-rdbms/internal/catalog"
+	"github.com/k-sml/go-rdbms/internal/sql/ast"
+	"github.com/k-sml/go-rdbms/internal/sql/lexer"
+)
+
+// createTableSQL はテーブル t を作る CREATE TABLE 文をカタログの定義から組み立てます。
+// 主キーが複数の列からなるときは表制約の PRIMARY KEY (...) にします。
+func createTableSQL(t *catalog.Table) string {
+	var pk []string
+	for _, c := range t.Columns {
+		if c.PrimaryKey {
+			pk = append(pk, c.Name)
+		}
+	}
+	var defs []string
+	for _, c := range t.Columns {
+		s := lexer.QuoteIdent(c.Name) + " " + c.Type.String()
+		if c.PrimaryKey && len(pk) == 1 {
+			s += " PRIMARY KEY"
+		}
+		if c.AutoIncrement {
+			s += " AUTOINCREMENT"
+		}
+		if c.NotNull {
+			s += " NOT NULL"
+		}
+		if !c.Default.IsNull() {
+			s += " DEFAULT " + ast.FormatValue(c.Default)
+		}
+		defs = append(defs, s)
+	}
+	if len(pk) > 1 {
+		defs = append(defs, "PRIMARY KEY ("+identList(pk)+")")
+	}
+	for _, fk := range t.ForeignKeys {
+		s := fmt.Sprintf("FOREIGN KEY (%s) REFERENCES %s (%s)",
+			identList(fk.Columns), lexer.QuoteIdent(fk.RefTable), identList(fk.RefColumns))
+		if fk.OnDelete != catalog.Restrict {
+			s += " ON DELETE " + fk.OnDelete.String()
+		}
+		if fk.OnUpdate != catalog.Restrict {
+			s += " ON UPDATE " + fk.OnUpdate.String()
+		}
+		if fk.Deferred {
+			s += " DEFERRABLE INITIALLY DEFERRED"
+		}
+		defs = append(defs, s)
+	}
+	s := "CREATE TABLE "
+	if t.Temp {
+		s = "CREATE TEMP TABLE "
+	}
+	s += lexer.QuoteIdent(t.Name) + " (\n  " + strings.Join(defs, ",\n  ") + "\n)"
+	if opts := storageOptions(t.Options); opts != "" {
+		s += " WITH (" + opts + ")"
+	}
+	return s
+}
+
+// storageOptions はテーブルの格納方法のうち、既定と異なるものを WITH (...) の中の形にします。
+func storageOptions(o catalog.StorageOptions) string {
+	var opts []string
+	if o.FillFactor != 0 && o.FillFactor != 100 {
+		opts = append(opts, fmt.Sprintf("fill_factor = %d", o.FillFactor))
+	}
+	if o.AppendOnly {
+		opts = append(opts, "append_only = TRUE")
+	}
+	if o.Layout != catalog.RowLayout {
+		opts = append(opts, "layout = '"+o.Layout.String()+"'")
+	}
+	if o.Compression != catalog.NoCompression {
+		opts = append(opts, "compression = '"+o.Compression.String()+"'")
+	}
+	return strings.Join(opts, ", ")
+}
+
+// createIndexSQL はインデックス ix を作る CREATE INDEX 文を返します。
+func createIndexSQL(ix *catalog.Index) string {
+	unique := ""
+	if ix.Unique {
+		unique = "UNIQUE "
+	}
+	return fmt.Sprintf("CREATE %sINDEX %s ON %s (%s)",
+		unique, lexer.QuoteIdent(ix.Name), lexer.QuoteIdent(ix.Table), identList(ix.Columns))
+}
+
+// createViewSQL はビュー v を作る CREATE VIEW 文を返します。
+func createViewSQL(v *catalog.View) string {
+	s := "CREATE VIEW " + lexer.QuoteIdent(v.Name)
+	if len(v.Columns) > 0 {
+		s += " (" + identList(v.Columns) + ")"
+	}
+	return s + " AS " + v.Query
+}
+
+// identList は名前を識別子として書ける形にして , で区切って並べます。
+func identList(names []string) string {
+	quoted := make([]string, len(names))
+	for i, n := range names {
+		quoted[i] = lexer.QuoteIdent(n)
+	}
+	return strings.Join(quoted, ", ")
+}
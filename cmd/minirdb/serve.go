@@ -0,0 +1,250 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/k-sml/go-rdbms"
+	"github.com/k-sml/go-rdbms/internal/server"
+	"github.com/k-sml/go-rdbms/metrics"
+)
+
+// runServe はデータベースファイルを開き、クライアントの接続を受け付けるサーバーとして動きます。
+// -pg-listen を指定すると、PostgreSQL のプロトコルの接続（psql や pgx など）もそのアドレスで
+// 受け付けます。-http-listen を指定すると、SQL 文を JSON で受け取る HTTP の API（/query、/healthz、
+// /metrics）もそのアドレスで提供します。-grpc-listen を指定すると、api/minirdb/v1 の gRPC の API も
+// そのアドレスで提供します。SIGINT か SIGTERM を受け取ると、接続を閉じてデータベースを
+// 閉じてから終わります。
+//
+// -parallel と -batch-size は問い合わせの並列度とバッチの行の数です（rdbms.Options）。
+//
+// クライアントはログインしなければなりません。データベースに CREATE USER で作ったユーザーがいれば、
+// そのユーザー名とパスワードで認証し、GRANT READ だけのユーザーの接続は読み取り専用にします。
+// ユーザーがいなければ、-user と -password（または環境変数 MINIRDB_PASSWORD）に一致するクライアント
+// だけが接続できます（最初のユーザーを作るのに使います）。どちらもなければ、-no-auth を指定しない
+// 限り起動しません。
+//
+// -tls-cert と -tls-key を指定すると、どのプロトコルの接続も TLS で暗号化します。さらに
+// -tls-client-ca を指定すると、その CA が署名した証明書を持つクライアントだけが接続できます
+// （相互 TLS）。
+//
+// -follow を指定すると、そのアドレスのサーバー（リーダー）のストリーミングレプリケーションの
+// フォロワーとして動きます。リーダーのデータベース全体を受け取ってから、リーダーのコミットを
+// 受け取り続けて適用し、クライアントには読み取りだけを許可します。リーダーには -follow-user と
+// -follow-password（または環境変数 MINIRDB_FOLLOW_PASSWORD）でログインし、-follow-tls-ca を
+// 指定すると TLS で接続します。リーダーが止まったら、HTTP の API の POST /promote で昇格すると
+// 書き込みを受け付けるようになります。
+//
+// -subscribe を指定すると、その URL（ほかのサーバーの HTTP の API の GET /publication?table=...）から
+// テーブルの行の変更を受け取り続けて、自分のデータベースに適用します（論理レプリケーション）。
+// フォロワーと違ってデータベースは書き込めるままです。公開する側には -subscribe-user と
+// -subscribe-password（または環境変数 MINIRDB_SUBSCRIBE_PASSWORD）の Basic 認証でログインし、
+// https の URL なら -subscribe-tls-ca で公開する側の証明書を検証します。
+func runServe(args []string) {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	listen := fs.String("listen", ":5544", "TCP address to listen on")
+	pgListen := fs.String("pg-listen", "", "TCP address to accept PostgreSQL protocol connections on (e.g. :5432)")
+	httpListen := fs.String("http-listen", "", "TCP address to serve the HTTP/JSON API on (e.g. :8080)")
+	grpcListen := fs.String("grpc-listen", "", "TCP address to serve the gRPC API on (e.g. :9090)")
+	user := fs.String("user", "minirdb", "user name that clients must send when -password is set")
+	password := fs.String("password", os.Getenv("MINIRDB_PASSWORD"), "password that clients must send while the database has no users (default: $MINIRDB_PASSWORD)")
+	noAuth := fs.Bool("no-auth", false, "accept any client while the database has no users and -password is not set")
+	readOnly := fs.Bool("readonly", false, "open the database read-only")
+	journal := fs.String("journal", "wal", "journal mode: wal, shadow or none")
+	maxConns := fs.Int("max-conns", 0, "maximum number of concurrent sessions (0 means no limit)")
+	parallel := fs.Int("parallel", 0, "maximum number of goroutines that scan one table (1 or less disables parallel scans)")
+	batchSize := fs.Int("batch-size", 0, "rows processed together by query operators (0 means 1024, 1 means one row at a time)")
+	tlsCert := fs.String("tls-cert", "", "PEM certificate file to serve TLS with (requires -tls-key)")
+	tlsKey := fs.String("tls-key", "", "PEM private key file for -tls-cert")
+	tlsClientCA := fs.String("tls-client-ca", "", "PEM CA certificates that client certificates must be signed by (mutual TLS)")
+	follow := fs.String("follow", "", "address of a leader server to replicate from (runs as a read-only follower)")
+	followUser := fs.String("follow-user", "minirdb", "user name to log in to the leader with")
+	followPassword := fs.String("follow-password", os.Getenv("MINIRDB_FOLLOW_PASSWORD"), "password to log in to the leader with (default: $MINIRDB_FOLLOW_PASSWORD)")
+	followCA := fs.String("follow-tls-ca", "", "PEM CA certificates to verify the leader with (connects to the leader over TLS)")
+	subscribe := fs.String("subscribe", "", "URL of another server's GET /publication endpoint to apply table changes from")
+	subscribeUser := fs.String("subscribe-user", "minirdb", "user name to authenticate to the publisher with (empty disables authentication)")
+	subscribePassword := fs.String("subscribe-password", os.Getenv("MINIRDB_SUBSCRIBE_PASSWORD"), "password to authenticate to the publisher with (default: $MINIRDB_SUBSCRIBE_PASSWORD)")
+	subscribeCA := fs.String("subscribe-tls-ca", "", "PEM CA certificates to verify an https publisher with")
+	grpcSessionTimeout := fs.Duration("grpc-session-timeout", 0, "how long a gRPC transaction session may stay unused before it is rolled back (0 means 5m)")
+	backupDir := fs.String("backup-dir", "", "directory that the gRPC Backup call writes backups to (empty disables it)")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		log.Fatalf("Usage: minirdb serve [--listen addr] [--pg-listen addr] [--http-listen addr] [--grpc-listen addr] [--tls-cert file --tls-key file] [--follow addr] [--subscribe url] [flags] <dbfile>")
+	}
+
+	tlsConfig, err := loadTLS(*tlsCert, *tlsKey, *tlsClientCA)
+	if err != nil {
+		log.Fatalf("Error loading TLS configuration: %v", err)
+	}
+	var followTLS *tls.Config
+	if *followCA != "" {
+		pool, err := loadCertPool(*followCA)
+		if err != nil {
+			log.Fatalf("Error loading TLS configuration: %v", err)
+		}
+		followTLS = &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+	}
+	var subscribeTLS *tls.Config
+	if *subscribeCA != "" {
+		pool, err := loadCertPool(*subscribeCA)
+		if err != nil {
+			log.Fatalf("Error loading TLS configuration: %v", err)
+		}
+		subscribeTLS = &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+	}
+	if *follow != "" && *subscribe != "" {
+		log.Fatalf("-follow and -subscribe cannot be used together")
+	}
+
+	logger := slog.New(slog.NewTextHandler(os.Stderr, nil))
+	prom := metrics.NewPrometheus()
+	opts := rdbms.Options{
+		ReadOnly: *readOnly, Follower: *follow != "", MaxActiveConns: *maxConns, Parallel: *parallel, BatchSize: *batchSize,
+		Logger: logger, Metrics: prom,
+	}
+	switch *journal {
+	case "wal":
+		opts.Journal = rdbms.JournalWAL
+	case "shadow":
+		opts.Journal = rdbms.JournalShadow
+	case "none":
+		opts.Journal = rdbms.JournalNone
+	default:
+		log.Fatalf("unknown journal mode: %s", *journal)
+	}
+	db, err := rdbms.Open(fs.Arg(0), opts)
+	if err != nil {
+		log.Fatalf("Error opening database file: %v", err)
+	}
+	defer db.Close()
+
+	// フォロワーのユーザーはリーダーから受け取るので、まだないこともある
+	if *follow == "" {
+		if has, err := db.HasUsers(context.Background()); err != nil {
+			db.Close()
+			log.Fatalf("Error reading users: %v", err)
+		} else if !has && *password == "" && !*noAuth {
+			db.Close()
+			log.Fatalf("No users are defined: create one with CREATE USER and GRANT, set -password, or pass -no-auth")
+		}
+	}
+	// ユーザーは実行中に作られることもあるので、接続のたびに調べる
+	auth := func(u, p string) (bool, error) {
+		has, err := db.HasUsers(context.Background())
+		switch {
+		case err != nil:
+			return false, err
+		case has:
+			return db.Authenticate(context.Background(), u, p)
+		case *password != "":
+			// 一致しない位置から時間でパスワードを推測されないように、一定時間で比べる
+			ok := subtle.ConstantTimeCompare([]byte(u), []byte(*user)) & subtle.ConstantTimeCompare([]byte(p), []byte(*password))
+			if ok != 1 {
+				return false, errors.New("invalid user name or password")
+			}
+		case !*noAuth:
+			return false, errors.New("no users are defined")
+		}
+		return false, nil
+	}
+	srv := server.New(db, server.Options{
+		Auth: auth, Logger: logger, Metrics: prom, TLSConfig: tlsConfig,
+		BackupDir: *backupDir, GRPCSessionTimeout: *grpcSessionTimeout,
+	})
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sig
+		srv.Close()
+	}()
+	errc := make(chan error, 4)
+	go func() { errc <- srv.ListenAndServe(*listen) }()
+	logger.Info("listening", "addr", *listen, "db", fs.Arg(0), "tls", tlsConfig != nil)
+	if *follow != "" {
+		go func() {
+			err := srv.Follow(*follow, server.FollowOptions{User: *followUser, Password: *followPassword, TLSConfig: followTLS})
+			if err != nil && !errors.Is(err, server.ErrServerClosed) {
+				logger.Error("replication stopped", "err", err)
+			}
+		}()
+	}
+	if *subscribe != "" {
+		go func() {
+			err := srv.Subscribe(*subscribe, server.SubscribeOptions{User: *subscribeUser, Password: *subscribePassword, TLSConfig: subscribeTLS})
+			if err != nil && !errors.Is(err, server.ErrServerClosed) {
+				logger.Error("subscription stopped", "err", err)
+			}
+		}()
+	}
+	if *pgListen != "" {
+		go func() { errc <- srv.ListenAndServePostgres(*pgListen) }()
+		logger.Info("listening", "addr", *pgListen, "protocol", "postgres")
+	}
+	if *httpListen != "" {
+		go func() { errc <- srv.ListenAndServeHTTP(*httpListen) }()
+		logger.Info("listening", "addr", *httpListen, "protocol", "http")
+	}
+	if *grpcListen != "" {
+		go func() { errc <- srv.ListenAndServeGRPC(*grpcListen) }()
+		logger.Info("listening", "addr", *grpcListen, "protocol", "grpc")
+	}
+	// どれかが止まったら、ほかも止める
+	err = <-errc
+	srv.Close()
+	if !errors.Is(err, server.ErrServerClosed) {
+		db.Close()
+		log.Fatalf("Error serving: %v", err)
+	}
+	logger.Info("server stopped")
+}
+
+// loadTLS はサーバーの証明書と鍵のファイルから TLS の設定を作ります。clientCA を指定すれば、
+// クライアントにその CA が署名した証明書を求めます。証明書を指定しなければ nil を返します。
+func loadTLS(certFile, keyFile, clientCA string) (*tls.Config, error) {
+	if certFile == "" && keyFile == "" {
+		if clientCA != "" {
+			return nil, errors.New("-tls-client-ca requires -tls-cert and -tls-key")
+		}
+		return nil, nil
+	}
+	if certFile == "" || keyFile == "" {
+		return nil, errors.New("-tls-cert and -tls-key must be set together")
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+	if clientCA != "" {
+		pool, err := loadCertPool(clientCA)
+		if err != nil {
+			return nil, err
+		}
+		cfg.ClientCAs = pool
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return cfg, nil
+}
+
+// loadCertPool は PEM の CA 証明書のファイルを読みます。
+func loadCertPool(file string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("%s: no certificates found", file)
+	}
+	return pool, nil
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/k-sml/go-rdbms/internal/catalog"
+	"github.com/k-sml/go-rdbms/internal/engine"
+	"github.com/k-sml/go-rdbms/internal/sql/ast"
+	"github.com/k-sml/go-rdbms/internal/sql/lexer"
+	"github.com/k-sml/go-rdbms/internal/sql/parser"
+	"github.com/k-sml/go-rdbms/internal/txn"
+)
+
+// shell は SQL 文を読んで実行し、結果を表示する対話的なシェルです。
+// BEGIN から COMMIT または ROLLBACK までは、入力の行をまたいで1つのトランザクションで実行します。
+type shell struct {
+	db      *engine.DB
+	session *engine.Session // 一時テーブルを持つセッション
+	out     io.Writer
+	err     io.Writer
+	tx      *engine.Tx // BEGIN で始めたトランザクション
+
+	mode      outputMode // .mode で選んだ結果の表示のしかた
+	noHeaders bool       // .headers off で列の名前を表示しない
+	timer     bool       // .timer on で文ごとにかかった時間を表示する
+	readOnly  bool       // --readonly でデータベースを変更する文を拒否する
+}
+
+// run は in から SQL 文を読み、; で終わるごとに実行します。. で始まる行はメタコマンドとして実行します。
+//
+// prompt が true なら対話的に使うものとして、入力を促す記号を表示し、エラーは表示して次の入力に
+// 進みます。in が端末なら、行を編集しながら読めるようにし、入力した行を履歴に残します。
+// prompt が false ならスクリプトとして、最初のエラーを表示したところで実行をやめてそのエラーを返します。
+// 入力の終わりで BEGIN から始めたトランザクションが終わっていないのもエラーです。
+func (sh *shell) run(in io.Reader, prompt bool) error {
+	read := scanLines(in, sh.out, prompt)
+	var ed *lineEditor
+	if f, ok := in.(*os.File); ok && prompt {
+		if st, err := makeRaw(f.Fd()); err == nil {
+			restore(f.Fd(), st)
+			ed = newLineEditor(f, sh.out, historyPath())
+			ed.complete = sh.completions
+			read = ed.readLine
+		}
+	}
+	// fail はエラーを表示し、スクリプトなら実行をやめるかを返す
+	fail := func(err error) bool {
+		fmt.Fprintln(sh.err, "Error:", err)
+		return !prompt
+	}
+	var buf strings.Builder
+	// next は次に読む行の入力の中の位置、start は buf の先頭の行の位置
+	next := lexer.Pos{Line: 1, Col: 1}
+	var start lexer.Pos
+	for {
+		p := "minirdb> "
+		if buf.Len() > 0 {
+			p = "    ...> "
+		}
+		line, err := read(p)
+		if errors.Is(err, errInterrupt) {
+			buf.Reset()
+			continue
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			fail(err)
+			return err
+		}
+		if ed != nil {
+			ed.addHistory(line)
+		}
+		pos := next
+		next = lexer.Pos{Offset: next.Offset + len(line) + 1, Line: next.Line + 1, Col: 1}
+		if buf.Len() == 0 && isMeta(line) {
+			if err := sh.meta(line); errors.Is(err, errQuit) {
+				return nil
+			} else if err != nil && fail(err) {
+				return err
+			}
+			continue
+		}
+		if buf.Len() == 0 {
+			start = pos
+		}
+		buf.WriteString(line)
+		buf.WriteByte('\n')
+		if !complete(buf.String()) {
+			continue
+		}
+		if err := sh.exec(buf.String(), start); err != nil && fail(err) {
+			return err
+		}
+		buf.Reset()
+	}
+	if strings.TrimSpace(buf.String()) != "" {
+		if err := sh.exec(buf.String(), start); err != nil && fail(err) {
+			return err
+		}
+	}
+	if prompt {
+		if ed == nil {
+			fmt.Fprintln(sh.out)
+		}
+		return nil
+	}
+	if sh.tx != nil {
+		err := errors.New("transaction started by BEGIN is not committed at the end of the input")
+		fail(err)
+		return err
+	}
+	return nil
+}
+
+// scanLines は in から1行ずつ読む関数を返します。show が true なら、読む前に入力を促す記号を out に表示します。
+func scanLines(in io.Reader, out io.Writer, show bool) func(prompt string) (string, error) {
+	sc := bufio.NewScanner(in)
+	sc.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	return func(prompt string) (string, error) {
+		if show {
+			fmt.Fprint(out, prompt)
+		}
+		if !sc.Scan() {
+			if err := sc.Err(); err != nil {
+				return "", err
+			}
+			return "", io.EOF
+		}
+		return sc.Text(), nil
+	}
+}
+
+// complete は src が ; で終わる文を含み、文字列やコメントの途中で終わっていないかを返します。
+func complete(src string) bool {
+	toks, err := lexer.Tokenize(src)
+	if err != nil {
+		// 閉じていない文字列やコメントは、続きの行を待つ
+		return strings.HasSuffix(strings.TrimSpace(src), ";")
+	}
+	n := len(toks) - 1 // 最後は EOF
+	return n > 0 && toks[n-1].Kind == lexer.Op && toks[n-1].Text == ";"
+}
+
+// exec は SQL 文を並べた src を順に実行し、結果を表示します。エラーになった文で実行をやめます。
+// src は入力の位置 start から始まる部分で、エラーの位置は入力全体の中の位置にします。
+func (sh *shell) exec(src string, start lexer.Pos) error {
+	stmts, err := lexer.Split(src)
+	if err != nil {
+		return engine.ScriptError(start, err)
+	}
+	for _, st := range stmts {
+		if err := sh.execStmt(st.SQL); err != nil {
+			pos := st.Pos.Within(start)
+			if rb, ok := err.(*rolledBack); ok {
+				return &rolledBack{engine.ScriptError(pos, rb.err)}
+			}
+			return engine.ScriptError(pos, err)
+		}
+	}
+	return nil
+}
+
+// rolledBack は BEGIN で始めたトランザクションの中の文のエラーで、そのためにトランザクションを
+// ロールバックしたことを表します。
+type rolledBack struct{ err error }
+
+func (e *rolledBack) Error() string { return e.err.Error() + " (transaction rolled back)" }
+
+func (e *rolledBack) Unwrap() error { return e.err }
+
+// execStmt は1つの SQL 文を実行します。トランザクションの中の文がエラーになったら、
+// 途中までの変更が残らないようにトランザクションをロールバックします。
+func (sh *shell) execStmt(sql string) error {
+	stmt, err := parser.Parse(sql)
+	if err != nil {
+		return err
+	}
+	if sh.readOnly && !readsOnly(stmt) {
+		return errors.New("cannot modify the database in read-only mode (--readonly)")
+	}
+	switch stmt.(type) {
+	case *ast.Begin:
+		if sh.tx != nil {
+			return errors.New("cannot start a transaction within a transaction")
+		}
+		sh.tx, err = sh.session.BeginTx(context.Background(), txn.Options{ReadOnly: sh.readOnly})
+		return err
+	case *ast.Commit, *ast.Rollback:
+		if sh.tx == nil {
+			return errors.New("no transaction is active")
+		}
+		tx := sh.tx
+		sh.tx = nil
+		if _, ok := stmt.(*ast.Commit); ok {
+			return tx.Commit()
+		}
+		return tx.Rollback()
+	}
+	start, reads := time.Now(), sh.db.Stats().Txn.PageReads
+	var results []engine.Result
+	if sh.tx == nil {
+		results, err = sh.session.ExecScript(sql)
+	} else if results, err = sh.tx.ExecScript(sql); err != nil {
+		sh.tx.Rollback()
+		sh.tx = nil
+		err = &rolledBack{err}
+	}
+	for _, r := range results {
+		if r.Columns != nil {
+			if perr := printResult(sh.out, sh.mode, !sh.noHeaders, r.Columns, r.Rows); err == nil {
+				err = perr
+			}
+		}
+	}
+	if _, ok := stmt.(*ast.Vacuum); ok && err == nil {
+		// VACUUM は取り除いたページの数を変更した行の数として返す
+		for _, r := range results {
+			fmt.Fprintf(sh.out, "%d pages reclaimed\n", r.RowsAffected)
+		}
+	}
+	if sh.timer && err == nil {
+		sh.printTimer(results, time.Since(start), sh.db.Stats().Txn.PageReads-reads)
+	}
+	return err
+}
+
+// readsOnly は文がデータベースを変更しない文（問い合わせとトランザクションの制御）かを返します。
+func readsOnly(stmt ast.Stmt) bool {
+	switch stmt.(type) {
+	case *ast.Select, *ast.Explain, *ast.Pragma, *ast.Begin, *ast.Commit, *ast.Rollback:
+		return true
+	}
+	return false
+}
+
+// printTimer は .timer on のときに、文の実行にかかった時間、返した行または変更した行の数、
+// 読んだページの数を表示します。
+func (sh *shell) printTimer(results []engine.Result, elapsed time.Duration, reads uint64) {
+	var rows, changed int64
+	query := false
+	for _, r := range results {
+		if r.Columns != nil {
+			query = true
+			rows += int64(len(r.Rows))
+		}
+		changed += r.RowsAffected
+	}
+	count := fmt.Sprintf("%d rows changed", changed)
+	if query {
+		count = fmt.Sprintf("%d rows returned", rows)
+	}
+	fmt.Fprintf(sh.out, "Run Time: %s, %s, %d pages read\n", elapsed.Round(time.Microsecond), count, reads)
+}
+
+// close は終わっていないトランザクションをロールバックし、一時テーブルを削除します。
+func (sh *shell) close() {
+	if sh.tx != nil {
+		sh.tx.Rollback()
+		sh.tx = nil
+	}
+	sh.session.Close()
+}
+
+// withTx は fn にトランザクションを渡します。BEGIN で始めたトランザクションがあればそれを、
+// なければ読むだけのトランザクションを渡します。
+func (sh *shell) withTx(fn func(tx *engine.Tx) error) error {
+	tx := sh.tx
+	if tx == nil {
+		var err error
+		if tx, err = sh.session.BeginTx(context.Background(), txn.Options{ReadOnly: true}); err != nil {
+			return err
+		}
+		defer tx.Rollback()
+	}
+	return fn(tx)
+}
+
+// withCatalog は fn に、withTx のトランザクションから見えるカタログを渡します。
+func (sh *shell) withCatalog(fn func(cat *catalog.Catalog)) error {
+	return sh.withTx(func(tx *engine.Tx) error {
+		cat, err := tx.Catalog()
+		if err != nil {
+			return err
+		}
+		fn(cat)
+		return nil
+	})
+}
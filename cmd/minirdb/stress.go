@@ -0,0 +1,85 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/k-sml/go-rdbms/internal/pager"
+	"github.com/k-sml/go-rdbms/internal/stress"
+	"github.com/k-sml/go-rdbms/internal/txn"
+)
+
+// runStress は並行に送金するトランザクションで負荷試験を実行し、不変条件の違反を表示します。
+// 違反が見つかったときの終了コードは 1 です。-db を指定しなければ一時ディレクトリに
+// データベースを作り、終わったら消します。
+func runStress(args []string) int {
+	fs := flag.NewFlagSet("stress", flag.ExitOnError)
+	path := fs.String("db", "", "database file to create (default: a temporary file that is removed afterwards)")
+	workers := fs.Int("n", 8, "number of concurrent goroutines")
+	duration := fs.Duration("duration", 5*time.Second, "how long to run the workload")
+	accounts := fs.Int("accounts", 50, "number of accounts")
+	isolation := fs.String("isolation", "all", "isolation levels for transfers: all, or a comma-separated list of locking, snapshot, serializable, optimistic")
+	journal := fs.String("journal", "wal", "journal mode: wal, shadow or none")
+	seed := fs.Int64("seed", 1, "random seed")
+	fs.Parse(args)
+	if fs.NArg() > 0 {
+		log.Fatalf("Usage: minirdb stress [flags]")
+	}
+
+	cfg := stress.Config{
+		Path:     *path,
+		Seed:     *seed,
+		Workers:  *workers,
+		Duration: *duration,
+		Accounts: *accounts,
+	}
+	switch *journal {
+	case "wal":
+		cfg.Journal = pager.JournalWAL
+	case "shadow":
+		cfg.Journal = pager.JournalShadow
+	case "none":
+		cfg.Journal = pager.JournalNone
+	default:
+		log.Fatalf("unknown journal mode: %s", *journal)
+	}
+	if *isolation != "all" {
+		levels := map[string]txn.Isolation{
+			"locking": txn.Locking, "snapshot": txn.Snapshot, "serializable": txn.Serializable, "optimistic": txn.Optimistic,
+		}
+		for _, name := range strings.Split(*isolation, ",") {
+			iso, ok := levels[name]
+			if !ok {
+				log.Fatalf("unknown isolation level: %s", name)
+			}
+			cfg.Isolations = append(cfg.Isolations, iso)
+		}
+	}
+	if cfg.Path == "" {
+		dir, err := os.MkdirTemp("", "minirdb-stress")
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		cfg.Path = filepath.Join(dir, "stress.db")
+	}
+
+	rep, err := stress.Run(cfg)
+	if err != nil {
+		log.Fatalf("Error running stress test: %v", err)
+	}
+	for _, f := range rep.Failures {
+		fmt.Println("FAIL:", f)
+	}
+	fmt.Printf("%d transfers committed, %d rolled back, %d conflicts, %d audits, %d failures\n",
+		rep.Transfers, rep.Aborted, rep.Conflicts, rep.Audits, len(rep.Failures))
+	if len(rep.Failures) > 0 {
+		return 1
+	}
+	return 0
+}
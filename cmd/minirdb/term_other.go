@@ -0,0 +1,15 @@
+//go:build !(linux || darwin || freebsd)
+
+package main
+
+import "errors"
+
+// termState は端末の設定です。
+type termState struct{}
+
+// makeRaw は、この OS では端末の設定を変えられないのでエラーを返します。入力は行ごとに読みます。
+func makeRaw(uintptr) (*termState, error) {
+	return nil, errors.New("line editing is not supported on this platform")
+}
+
+func restore(uintptr, *termState) error { return nil }
@@ -0,0 +1,40 @@
+//go:build linux || darwin || freebsd
+
+package main
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// termState は端末の設定です。
+type termState struct{ termios syscall.Termios }
+
+// makeRaw は端末 fd を1文字ずつ読め、入力を表示しない状態にして、元の設定を返します。
+func makeRaw(fd uintptr) (*termState, error) {
+	var old termState
+	if err := ioctl(fd, ioctlGetTermios, &old.termios); err != nil {
+		return nil, err
+	}
+	raw := old.termios
+	raw.Iflag &^= syscall.ICRNL | syscall.IXON | syscall.ISTRIP | syscall.INLCR | syscall.IGNCR
+	raw.Lflag &^= syscall.ECHO | syscall.ICANON | syscall.ISIG | syscall.IEXTEN
+	raw.Cc[syscall.VMIN] = 1
+	raw.Cc[syscall.VTIME] = 0
+	if err := ioctl(fd, ioctlSetTermios, &raw); err != nil {
+		return nil, err
+	}
+	return &old, nil
+}
+
+// restore は端末 fd の設定を st に戻します。
+func restore(fd uintptr, st *termState) error {
+	return ioctl(fd, ioctlSetTermios, &st.termios)
+}
+
+func ioctl(fd, req uintptr, t *syscall.Termios) error {
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, req, uintptr(unsafe.Pointer(t))); errno != 0 {
+		return errno
+	}
+	return nil
+}
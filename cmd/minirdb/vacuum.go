@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/k-sml/go-rdbms/internal/engine"
+)
+
+// runVacuum はデータベースファイルに VACUUM を実行し、取り除いたページとファイルの大きさの
+// 変化を表示します。
+func runVacuum(args []string) {
+	if len(args) != 1 {
+		log.Fatalf("Usage: minirdb vacuum <dbfile>")
+	}
+	before, err := os.Stat(args[0])
+	if err != nil {
+		log.Fatalf("Error opening database file: %v", err)
+	}
+	db, err := openDB(args[0], engine.Options{})
+	if err != nil {
+		log.Fatalf("Error opening database file: %v", err)
+	}
+	defer db.Close()
+	st, err := db.Vacuum()
+	if err != nil {
+		log.Fatalf("Error vacuuming database: %v", err)
+	}
+	after, err := os.Stat(args[0])
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("pages: %d -> %d (%d free pages remain)\n", st.PagesBefore, st.PagesAfter, st.FreePages)
+	fmt.Printf("file size: %d -> %d bytes (%d bytes reclaimed)\n", before.Size(), after.Size(), max(0, before.Size()-after.Size()))
+}
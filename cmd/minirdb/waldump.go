@@ -0,0 +1,66 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/k-sml/go-rdbms/internal/wal"
+)
+
+// runWALDump はデータベースファイルに対応するWALのレコードを一覧表示します。
+// -tx、-page、-type にはカンマで区切って複数の値を書け、-from と -to で LSN の範囲を
+// 絞れます。フラグはデータベースファイル名の前にも後にも書けます。
+func runWALDump(args []string) {
+	fs := flag.NewFlagSet("wal-dump", flag.ExitOnError)
+	txs := fs.String("tx", "", "show only records of these transaction IDs (comma-separated)")
+	pages := fs.String("page", "", "show only records of these pages, and the commits of their transactions (comma-separated)")
+	kinds := fs.String("type", "", "show only records of these types: page, commit, diff, insert, delete, update (comma-separated)")
+	from := fs.Uint64("from", 0, "show only records at or after this LSN")
+	to := fs.Uint64("to", 0, "show only records at or before this LSN (0: no limit)")
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		log.Fatalf("Usage: minirdb wal-dump [-tx IDs] [-page IDs] [-type TYPES] [-from LSN] [-to LSN] <dbfile>")
+	}
+	dbfile := fs.Arg(0)
+	fs.Parse(fs.Args()[1:])
+	if fs.NArg() > 0 {
+		log.Fatalf("unexpected argument: %s", fs.Arg(0))
+	}
+
+	f := wal.Filter{FromLSN: *from, ToLSN: *to}
+	for _, s := range splitList(*txs) {
+		id, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			log.Fatalf("invalid transaction ID: %s", s)
+		}
+		f.TxIDs = append(f.TxIDs, id)
+	}
+	for _, s := range splitList(*pages) {
+		id, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || id < 0 {
+			log.Fatalf("invalid page ID: %s", s)
+		}
+		f.Pages = append(f.Pages, id)
+	}
+	for _, s := range splitList(*kinds) {
+		t, ok := wal.ParseRecordType(s)
+		if !ok {
+			log.Fatalf("unknown record type: %s", s)
+		}
+		f.Types = append(f.Types, t)
+	}
+	if err := wal.Dump(os.Stdout, wal.Path(dbfile), f); err != nil {
+		log.Fatalf("Error dumping wal: %v", err)
+	}
+}
+
+// splitList はカンマで区切った値の並びを分けます。空の文字列なら nil を返します。
+func splitList(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, ",")
+}
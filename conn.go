@@ -0,0 +1,153 @@
+package rdbms
+
+import (
+	"context"
+	"errors"
+	"iter"
+
+	"github.com/k-sml/go-rdbms/internal/engine"
+)
+
+// Conn はデータベースへの1つのセッションです。SQL の BEGIN で始めたトランザクションと、
+// トランザクションの設定（SetTxOptions）、一時テーブル（CREATE TEMP TABLE）をセッションごとに
+// 持つので、ゴルーチンごとに Conn を取り出せば、1つの DB を共有してもトランザクションが混ざりません。
+// 一時テーブルはその Conn からだけ見え、Close で削除されます。1つの Conn は1つのゴルーチンから
+// 使い、使い終えたら Close で閉じます。
+//
+//	conn, err := db.Conn(ctx)
+//	if err != nil {
+//		return err
+//	}
+//	defer conn.Close()
+//	conn.ExecContext(ctx, "BEGIN")
+//	conn.ExecContext(ctx, "UPDATE accounts SET balance = balance - ? WHERE id = ?", 100, 1)
+//	conn.ExecContext(ctx, "UPDATE accounts SET balance = balance + ? WHERE id = ?", 100, 2)
+//	_, err = conn.ExecContext(ctx, "COMMIT")
+type Conn struct {
+	db      *DB
+	session *engine.Session // 一時テーブルを持つセッション
+	tx      *Tx             // BEGIN で始めたトランザクション（なければ nil）
+	opts    TxOptions       // BEGIN と、BEGIN の外の文のトランザクションの設定
+	closed  bool
+}
+
+// Conn は新しいセッションを作ります。Options.MaxActiveConns の数の Conn が使われていれば、どれかが
+// 閉じられるか ctx が取り消されるまで待ちます。
+func (db *DB) Conn(ctx context.Context) (*Conn, error) {
+	if db.conns != nil {
+		select {
+		case db.conns <- struct{}{}:
+		case <-ctx.Done():
+			return nil, context.Cause(ctx)
+		}
+	}
+	return &Conn{db: db, session: db.db.NewSession()}, nil
+}
+
+// SetTxOptions はセッションのトランザクションの設定を変えます。BEGIN で始めるトランザクションと、
+// BEGIN の外で実行する文のトランザクションに使います。実行中のトランザクションには影響しません。
+func (c *Conn) SetTxOptions(opts TxOptions) { c.opts = opts }
+
+// TxOptions はセッションのトランザクションの設定を返します。
+func (c *Conn) TxOptions() TxOptions { return c.opts }
+
+// InTx は BEGIN で始めたトランザクションが実行中かを返します。
+func (c *Conn) InTx() bool { return c.tx != nil }
+
+// Exec は結果の行を返さない SQL 文を実行し、変更した行の数を返します。
+func (c *Conn) Exec(sql string, args ...any) (int64, error) {
+	return c.ExecContext(context.Background(), sql, args...)
+}
+
+// ExecContext は結果の行を返さない SQL 文を実行し、変更した行の数を返します。
+//
+// BEGIN はセッションのトランザクションを始め、COMMIT と ROLLBACK はそれを終えます。BEGIN から
+// COMMIT までの文はそのトランザクションの中で実行します。文がエラーになった場合は、その文の変更だけを
+// 取り消すので（Tx.Exec）、トランザクションは続いていて、COMMIT はそれまでに成功した文の変更だけを
+// コミットします。BEGIN の外の文は、DB.Exec と同じくそれぞれ新しいトランザクションで実行して
+// コミットします。デッドロックで中断されたトランザクションはロールバックしてあるので、その後の文と
+// COMMIT は ErrTxDone を返します。
+//
+// ctx はこの呼び出しだけに使います。BEGIN に渡した ctx が後で取り消されても、トランザクションは
+// 続きます。
+func (c *Conn) ExecContext(ctx context.Context, sql string, args ...any) (int64, error) {
+	if c.closed {
+		return 0, ErrConnDone
+	}
+	s, err := c.db.db.Prepare(sql)
+	if err != nil {
+		return 0, err
+	}
+	switch s.Control() {
+	case engine.ControlBegin:
+		if c.tx != nil {
+			return 0, errors.New("cannot start a transaction within a transaction")
+		}
+		tx, err := c.session.BeginTx(context.WithoutCancel(ctx), c.opts.txn())
+		if err != nil {
+			return 0, err
+		}
+		c.tx = &Tx{tx: tx}
+		return 0, nil
+	case engine.ControlCommit, engine.ControlRollback:
+		if c.tx == nil {
+			return 0, errors.New("no transaction is active")
+		}
+		tx := c.tx
+		c.tx = nil
+		if s.Control() == engine.ControlCommit {
+			return 0, tx.Commit()
+		}
+		return 0, tx.Rollback()
+	}
+	if c.tx != nil {
+		return c.tx.ExecContext(ctx, sql, args...)
+	}
+	return c.session.ExecOptions(ctx, c.opts.txn(), sql, args...)
+}
+
+// Query は問い合わせを実行し、結果の行を返します。
+func (c *Conn) Query(sql string, args ...any) (*Rows, error) {
+	return c.QueryContext(context.Background(), sql, args...)
+}
+
+// QueryContext は問い合わせを実行し、結果の行を返します。BEGIN から COMMIT までは
+// セッションのトランザクションの中で実行するので、それまでの変更が見えます。BEGIN の外では
+// DB.QueryContext と同じく読み取り専用の新しいトランザクションで実行し、分離レベルとロックの待ち方は
+// セッションの設定（SetTxOptions）に従います。
+func (c *Conn) QueryContext(ctx context.Context, sql string, args ...any) (*Rows, error) {
+	if c.closed {
+		return nil, ErrConnDone
+	}
+	if c.tx != nil {
+		return c.tx.QueryContext(ctx, sql, args...)
+	}
+	opts := c.opts.txn()
+	opts.ReadOnly = true
+	return query(ctx, c.session.BeginTx, opts, sql, args)
+}
+
+// Rows は QueryContext で問い合わせを実行し、結果の行を繰り返すイテレータを返します（iter.go）。
+func (c *Conn) Rows(ctx context.Context, sql string, args ...any) iter.Seq2[Row, error] {
+	return queryRows(func() (*Rows, error) { return c.QueryContext(ctx, sql, args...) })
+}
+
+// Close は実行中のトランザクションをロールバックし、一時テーブルを削除して、Options.MaxActiveConns の
+// 数に空きを作ります。
+// 何度呼んでもかまいません。
+func (c *Conn) Close() error {
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	var err error
+	if c.tx != nil {
+		err = c.tx.Rollback()
+		c.tx = nil
+	}
+	err = errors.Join(err, c.session.Close())
+	if c.db.conns != nil {
+		<-c.db.conns
+	}
+	return err
+}
@@ -0,0 +1,72 @@
+package rdbms
+
+import (
+	"errors"
+
+	"github.com/k-sml/go-rdbms/internal/dberr"
+	"github.com/k-sml/go-rdbms/internal/engine"
+	"github.com/k-sml/go-rdbms/internal/lock"
+	"github.com/k-sml/go-rdbms/internal/pager"
+	"github.com/k-sml/go-rdbms/internal/txn"
+)
+
+// エラーの種類
+//
+// メソッドが返すエラーは文字列で見分けずに、errors.Is でここにある値と比べるか、
+// errors.As で *ConstraintError として取り出して調べる。1つのエラーが複数の種類に
+// 一致することがある（一意性の制約の違反は ErrConstraint、ErrUnique、ErrDuplicateKey に一致する）。
+
+var (
+	// ErrPageFull はページに空きがなくてレコードを入れられない場合のエラーです。
+	ErrPageFull = dberr.ErrPageFull
+	// ErrPageNotFound はファイルの範囲の外のページや、割り当てていないページを使おうとした場合のエラーです。
+	ErrPageNotFound = dberr.ErrPageNotFound
+	// ErrCorrupt はファイルの内容が壊れていて読めない場合のエラーです。
+	ErrCorrupt = dberr.ErrCorrupt
+	// ErrDuplicateKey はインデックスに同じキーがすでにある場合のエラーです。
+	ErrDuplicateKey = dberr.ErrDuplicateKey
+	// ErrLocked はロックを取れなかった場合のエラーです。TxOptions の NoWait や LockTimeout で
+	// 待つのをあきらめた場合と、デッドロックになった場合がこの種類になります。
+	ErrLocked = dberr.ErrLocked
+	// ErrConstraint は制約に違反した場合のエラーです。
+	ErrConstraint = dberr.ErrConstraint
+
+	// ErrUnique は一意のインデックスに同じ値を書き込もうとした場合のエラーです。
+	ErrUnique = engine.ErrUnique
+	// ErrNotNull は NOT NULL の列に NULL を書き込もうとした場合のエラーです。
+	ErrNotNull = engine.ErrNotNull
+	// ErrForeignKey は外部キーの制約に違反した場合のエラーです。
+	ErrForeignKey = engine.ErrForeignKey
+
+	// ErrLockNotAvailable は TxOptions の NoWait でロックをすぐに取れなかった場合や、LockTimeout か
+	// Options.BusyTimeout の時間を過ぎてもロックを取れなかった場合のエラーです。ErrLocked にも一致します。
+	ErrLockNotAvailable = lock.ErrLockNotAvailable
+	// ErrDeadlock はロックを待つとデッドロックになる場合のエラーです。トランザクションは
+	// ロールバックされています。
+	ErrDeadlock = lock.ErrDeadlock
+	// ErrSerialization は並行するトランザクションと競合してコミットできない場合のエラーです。
+	// トランザクションはロールバックされているので、最初からやり直してください。
+	ErrSerialization = txn.ErrSerialization
+	// ErrTxDone はコミットかロールバックを終えたトランザクションを使おうとした場合のエラーです。
+	ErrTxDone = txn.ErrTxDone
+	// ErrReadOnly は読み取り専用で開いたデータベースか、読み取り専用のトランザクションで
+	// 書き込もうとした場合のエラーです。
+	ErrReadOnly = pager.ErrReadOnly
+	// ErrConnDone は Close した Conn を使おうとした場合のエラーです。
+	ErrConnDone = errors.New("connection has already been closed")
+
+	// ErrAuth は Authenticate に渡したユーザー名かパスワードが違う場合のエラーです。
+	ErrAuth = engine.ErrAuth
+	// ErrNoAccess は Authenticate に渡したユーザーに何も許可していない場合のエラーです。
+	ErrNoAccess = engine.ErrNoAccess
+
+	// ErrNotFollower はフォロワーとして開いていないデータベースで Follow や Promote を呼んだ場合の
+	// エラーです。
+	ErrNotFollower = engine.ErrNotFollower
+	// ErrNotLeader は JournalWAL で書き込めるデータベース以外で Replicate を呼んだ場合のエラーです。
+	ErrNotLeader = pager.ErrNotLeader
+)
+
+// ConstraintError は行が制約に違反した場合のエラーです。Err は ErrUnique、ErrNotNull、
+// ErrForeignKey のどれかで、Table と Columns は違反した制約を持つテーブルと列です。
+type ConstraintError = dberr.ConstraintError
@@ -0,0 +1,256 @@
+package rdbms
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/k-sml/go-rdbms/internal/exec"
+	"github.com/k-sml/go-rdbms/internal/types"
+)
+
+// ユーザー定義関数
+//
+// RegisterFunc と RegisterAggregate は Go の関数を SQL から呼び出せるようにする。関数の引数と
+// 結果の Go の型が SQL の関数の宣言になり、文を準備するときに引数の型を検査し、呼び出すときに
+// 値を宣言した型に変換する。使える Go の型と SQL の型の対応は次のとおり。
+//
+//	int64, int  BIGINT      int32      INT        float64  REAL
+//	string      TEXT        []byte     BLOB       bool     BOOLEAN
+//	time.Time   TIMESTAMP   any        どの型でもよい（値は Rows.Values と同じ Go の値）
+//
+// any 以外の型の引数に NULL を渡すと、関数は呼ばれずに結果は NULL になる（集約関数ではその行を
+// 飛ばす）。any の引数には NULL が nil として渡る。結果が any なら、返した値の Go の型から
+// SQL の型を決める。
+
+// goTypes は関数の引数と結果に使える Go の型の、SQL の型です。
+var goTypes = map[reflect.Type]types.Type{
+	reflect.TypeFor[int64]():     types.BigInt,
+	reflect.TypeFor[int]():       types.BigInt,
+	reflect.TypeFor[int32]():     types.Int,
+	reflect.TypeFor[float64]():   types.Real,
+	reflect.TypeFor[string]():    types.Text,
+	reflect.TypeFor[[]byte]():    types.Blob,
+	reflect.TypeFor[bool]():      types.Boolean,
+	reflect.TypeFor[time.Time](): types.Timestamp,
+	reflect.TypeFor[any]():       types.Null,
+}
+
+var errorType = reflect.TypeFor[error]()
+
+// RegisterFunc は Go の関数 fn を、SQL から name で呼び出せる関数としてこのデータベースに
+// 登録します。同じ名前の関数があれば置き換え、組み込みの関数より優先します。
+//
+// fn の引数と結果の型は上の表のものでなければなりません。fn は結果を1つ返すか、結果と error を
+// 返します。可変長引数の関数も登録できます。fn は複数のゴルーチンから同時に呼ばれることがあります。
+//
+//	err := db.RegisterFunc("myhash", func(s string) int64 {
+//		h := fnv.New64a()
+//		h.Write([]byte(s))
+//		return int64(h.Sum64())
+//	})
+//	...
+//	rows, err := db.Query("SELECT name, myhash(name) FROM users")
+func (db *DB) RegisterFunc(name string, fn any) error {
+	v := reflect.ValueOf(fn)
+	if v.Kind() != reflect.Func || v.IsNil() {
+		return fmt.Errorf("function %s: %T is not a function", name, fn)
+	}
+	sig, err := newSignature(v.Type(), 0)
+	if err != nil {
+		return fmt.Errorf("function %s: %w", name, err)
+	}
+	if err := sig.results(v.Type()); err != nil {
+		return fmt.Errorf("function %s: %w", name, err)
+	}
+	return db.db.RegisterFunc(&exec.Func{
+		Name:    name,
+		MinArgs: sig.minArgs(),
+		MaxArgs: sig.maxArgs(),
+		Args:    sig.decl,
+		Type:    sig.resultType,
+		Call: func(args []types.Value) (types.Value, error) {
+			in, ok := sig.in(args)
+			if !ok {
+				return types.NullValue(), nil
+			}
+			return sig.out(v.Call(in))
+		},
+	})
+}
+
+// RegisterAggregate は集約関数を、SQL から name で呼び出せるようにこのデータベースに登録します。
+// 同じ名前の関数があれば置き換え、組み込みの集約関数（COUNT、SUM、AVG、MIN、MAX）より優先します。
+// 集約関数は結果の列、HAVING、ORDER BY の中で呼び出せ、GROUP BY のグループごと（GROUP BY が
+// なければすべての行で1つ）に値を計算します。
+//
+// newState は引数のない関数で、グループごとに呼ばれて集約の状態を返します。状態の型は
+// Step と Result のメソッドを持ちます。Step はグループの行ごとに集約関数の引数を受け取り、
+// 何も返さないか error を返します。Result はグループの結果を1つ返すか、結果と error を返します。
+// Step の引数と Result の結果の型は RegisterFunc と同じ表のものでなければなりません。
+//
+//	type product struct{ v float64 }
+//
+//	func (p *product) Step(x float64) { p.v *= x }
+//	func (p *product) Result() float64 { return p.v }
+//
+//	err := db.RegisterAggregate("product", func() *product { return &product{v: 1} })
+//	...
+//	rows, err := db.Query("SELECT category, product(rate) FROM items GROUP BY category")
+func (db *DB) RegisterAggregate(name string, newState any) error {
+	nv := reflect.ValueOf(newState)
+	if nv.Kind() != reflect.Func || nv.IsNil() || nv.Type().NumIn() != 0 || nv.Type().NumOut() != 1 {
+		return fmt.Errorf("aggregate %s: %T is not a function returning the aggregate state", name, newState)
+	}
+	st := nv.Type().Out(0)
+	step, ok := st.MethodByName("Step")
+	if !ok {
+		return fmt.Errorf("aggregate %s: %s has no Step method", name, st)
+	}
+	result, ok := st.MethodByName("Result")
+	if !ok {
+		return fmt.Errorf("aggregate %s: %s has no Result method", name, st)
+	}
+	// インターフェースでない型のメソッドは、1つ目の引数が受け手になる
+	recv := 1
+	if st.Kind() == reflect.Interface {
+		recv = 0
+	}
+	sig, err := newSignature(step.Type, recv)
+	if err != nil {
+		return fmt.Errorf("aggregate %s: Step: %w", name, err)
+	}
+	if n := step.Type.NumOut(); n > 1 || n == 1 && step.Type.Out(0) != errorType {
+		return fmt.Errorf("aggregate %s: Step must return nothing or an error", name)
+	}
+	if result.Type.NumIn() != recv {
+		return fmt.Errorf("aggregate %s: Result must take no arguments", name)
+	}
+	if err := sig.results(result.Type); err != nil {
+		return fmt.Errorf("aggregate %s: Result: %w", name, err)
+	}
+	return db.db.RegisterAggregate(&exec.Aggregate{
+		Name:    name,
+		MinArgs: sig.minArgs(),
+		MaxArgs: sig.maxArgs(),
+		Args:    sig.decl,
+		Type:    sig.resultType,
+		New: func() exec.AggregateState {
+			s := nv.Call(nil)[0]
+			return &aggState{sig: sig, step: s.MethodByName("Step"), result: s.MethodByName("Result")}
+		},
+	})
+}
+
+// aggState は RegisterAggregate の状態の値を exec.AggregateState にします。
+type aggState struct {
+	sig          *signature
+	step, result reflect.Value
+}
+
+func (a *aggState) Step(args []types.Value) error {
+	in, ok := a.sig.in(args)
+	if !ok {
+		return nil
+	}
+	if out := a.step.Call(in); len(out) == 1 && !out[0].IsNil() {
+		return out[0].Interface().(error)
+	}
+	return nil
+}
+
+func (a *aggState) Result() (types.Value, error) { return a.sig.out(a.result.Call(nil)) }
+
+// signature は Go の関数の引数と結果の型から決めた、SQL の関数の宣言です。
+type signature struct {
+	params   []reflect.Type // 引数の Go の型。可変長引数なら最後は要素の型
+	decl     []types.Type   // 引数の SQL の型
+	variadic bool
+	result   types.Type // 結果の SQL の型。any なら types.Null
+	err      bool       // 結果と error を返す
+}
+
+// newSignature は関数の型 ft の first 番目からの引数の宣言を作ります。
+func newSignature(ft reflect.Type, first int) (*signature, error) {
+	sig := &signature{variadic: ft.IsVariadic()}
+	for i := first; i < ft.NumIn(); i++ {
+		t := ft.In(i)
+		if sig.variadic && i == ft.NumIn()-1 {
+			t = t.Elem()
+		}
+		st, ok := goTypes[t]
+		if !ok {
+			return nil, fmt.Errorf("unsupported argument type %s", t)
+		}
+		sig.params = append(sig.params, t)
+		sig.decl = append(sig.decl, st)
+	}
+	return sig, nil
+}
+
+// results は関数の型 ft の結果から、結果の宣言を決めます。
+func (sig *signature) results(ft reflect.Type) error {
+	switch {
+	case ft.NumOut() == 2 && ft.Out(1) == errorType:
+		sig.err = true
+	case ft.NumOut() != 1:
+		return fmt.Errorf("must return a value, or a value and an error")
+	}
+	st, ok := goTypes[ft.Out(0)]
+	if !ok {
+		return fmt.Errorf("unsupported result type %s", ft.Out(0))
+	}
+	sig.result = st
+	return nil
+}
+
+func (sig *signature) minArgs() int {
+	if sig.variadic {
+		return len(sig.params) - 1
+	}
+	return len(sig.params)
+}
+
+func (sig *signature) maxArgs() int {
+	if sig.variadic {
+		return -1
+	}
+	return len(sig.params)
+}
+
+// resultType は exec.Func.Type です。
+func (sig *signature) resultType([]types.Type) types.Type { return sig.result }
+
+// in は SQL の引数の値を Go の関数の引数にします。any でない引数が NULL なら false を返します。
+// 値はすでに宣言した型に変換されています。
+func (sig *signature) in(args []types.Value) ([]reflect.Value, bool) {
+	in := make([]reflect.Value, len(args))
+	for i, a := range args {
+		t := sig.params[min(i, len(sig.params)-1)]
+		if a.IsNull() {
+			if t.Kind() != reflect.Interface {
+				return nil, false
+			}
+			in[i] = reflect.Zero(t)
+			continue
+		}
+		x := a.Go()
+		if b, ok := x.([]byte); ok {
+			x = bytes.Clone(b)
+		}
+		in[i] = reflect.ValueOf(x)
+		if t.Kind() != reflect.Interface {
+			in[i] = in[i].Convert(t)
+		}
+	}
+	return in, true
+}
+
+// out は Go の関数の結果を SQL の値にします。
+func (sig *signature) out(out []reflect.Value) (types.Value, error) {
+	if sig.err && !out[1].IsNil() {
+		return types.Value{}, out[1].Interface().(error)
+	}
+	return types.FromGo(out[0].Interface())
+}
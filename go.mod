@@ -1,3 +1,3 @@
 module github.com/k-sml/go-rdbms
 
-go 1.22.4
+go 1.24
@@ -0,0 +1,59 @@
+package rdbms
+
+import (
+	"context"
+	"time"
+
+	"github.com/k-sml/go-rdbms/internal/engine"
+)
+
+// StatementInfo は Hooks に渡す、実行する文の情報です。
+type StatementInfo struct {
+	SQL   string
+	Args  []any // 文の引数の値（Exec や Query に渡したもの）
+	Start time.Time
+
+	// 次のフィールドは After にだけ設定します。
+	Duration time.Duration
+	Rows     int64 // 返したか変更した行の数
+	Err      error
+}
+
+// Hooks は文を実行する前と後に呼ぶ関数です。監査のための記録や問い合わせのログ、文の実行の可否の
+// 判断に使えます。nil の関数は呼びません。関数は複数のゴルーチンから同時に呼ばれることがあります。
+//
+// 問い合わせの After は Rows を閉じたときに呼ぶので、Duration と Rows は結果を読み終えるまでの
+// 時間と読んだ行の数です。BEGIN、COMMIT、ROLLBACK ではどちらも呼びません。BusyHandler で
+// 文を実行し直すと、実行し直すたびに呼びます。
+//
+//	db, err := rdbms.Open("app.db", rdbms.Options{
+//		Hooks: rdbms.Hooks{
+//			After: func(ctx context.Context, s *rdbms.StatementInfo) {
+//				audit.Info("statement", "sql", s.SQL, "args", s.Args,
+//					"duration", s.Duration, "rows", s.Rows, "err", s.Err)
+//			},
+//		},
+//	})
+type Hooks struct {
+	// Before は文を実行する前に、文を実行する ctx で呼びます。エラーを返すと文を実行せず、
+	// そのエラーで After を呼んで、Exec や Query のエラーとして返します。
+	Before func(ctx context.Context, s *StatementInfo) error
+	// After は文の実行を終えた後に呼びます。
+	After func(ctx context.Context, s *StatementInfo)
+}
+
+// engine は h をエンジンの Hooks にします。
+func (h Hooks) engine() engine.Hooks {
+	var eh engine.Hooks
+	if h.Before != nil {
+		eh.Before = func(ctx context.Context, s *engine.StatementInfo) error {
+			return h.Before(ctx, (*StatementInfo)(s))
+		}
+	}
+	if h.After != nil {
+		eh.After = func(ctx context.Context, s *engine.StatementInfo) {
+			h.After(ctx, (*StatementInfo)(s))
+		}
+	}
+	return eh
+}
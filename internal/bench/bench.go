@@ -0,0 +1,265 @@
+// Package bench は minirdb の性能を測る定型のワークロードを提供します。
+// 一時的なデータベースに1つのテーブルを作り、行の一括挿入、主キーによる1行の検索、
+// 主キーの範囲の走査、読み書きの混在をそれぞれ決まった回数だけ実行して、
+// 1秒あたりの操作の数と1回の操作にかかった時間の分布を測ります。
+package bench
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/k-sml/go-rdbms/internal/engine"
+	"github.com/k-sml/go-rdbms/internal/lock"
+	"github.com/k-sml/go-rdbms/internal/pager"
+	"github.com/k-sml/go-rdbms/internal/txn"
+)
+
+// Workloads はワークロードの名前を実行する順に並べたものです。
+var Workloads = []string{"insert", "lookup", "range", "mixed"}
+
+// Config はベンチマークの設定です。ゼロ値のフィールドには既定値が使われます。
+type Config struct {
+	Path        string            // データベースファイル（存在してはいけない）
+	Journal     pager.JournalMode // コミットの永続化方式
+	Workloads   []string          // 実行するワークロード（nil ならすべて）
+	Seed        int64             // 乱数の種
+	Rows        int               // テーブルに入れる行の数（既定 10000）
+	Ops         int               // lookup、range、mixed で行う操作の数（既定 Rows）
+	Concurrency int               // 同時に操作するゴルーチンの数（既定 1）
+	Batch       int               // insert で1つのトランザクションに入れる行の数（既定 100）
+	RangeSize   int               // range で1回に読む行の数（既定 100）
+	WriteRatio  float64           // mixed の操作のうち更新の割合（既定 0.2）
+}
+
+func (c *Config) setDefaults() {
+	if c.Workloads == nil {
+		c.Workloads = Workloads
+	}
+	if c.Rows <= 0 {
+		c.Rows = 10000
+	}
+	if c.Ops <= 0 {
+		c.Ops = c.Rows
+	}
+	if c.Concurrency <= 0 {
+		c.Concurrency = 1
+	}
+	if c.Batch <= 0 {
+		c.Batch = 100
+	}
+	if c.RangeSize <= 0 {
+		c.RangeSize = 100
+	}
+	if c.WriteRatio <= 0 {
+		c.WriteRatio = 0.2
+	}
+}
+
+// Result は1つのワークロードの結果です。
+type Result struct {
+	Workload  string
+	Ops       int             // 終えた操作（トランザクション）の数
+	Rows      int             // 操作が挿入、更新、または読んだ行の数
+	Failed    int             // 直列化の失敗やデッドロックで中断した操作の数
+	Elapsed   time.Duration   // ワークロード全体にかかった時間
+	Latencies []time.Duration // 終えた操作ごとにかかった時間（短い順）
+}
+
+// OpsPerSec は1秒あたりに終えた操作の数を返します。
+func (r Result) OpsPerSec() float64 { return float64(r.Ops) / r.Elapsed.Seconds() }
+
+// RowsPerSec は1秒あたりに扱った行の数を返します。
+func (r Result) RowsPerSec() float64 { return float64(r.Rows) / r.Elapsed.Seconds() }
+
+// Percentile は操作にかかった時間の p パーセンタイル（0〜100）を返します。
+func (r Result) Percentile(p float64) time.Duration {
+	if len(r.Latencies) == 0 {
+		return 0
+	}
+	i := int(p / 100 * float64(len(r.Latencies)))
+	return r.Latencies[min(i, len(r.Latencies)-1)]
+}
+
+// Run は cfg.Path に新しいデータベースを作り、ワークロードを順に実行して結果を返します。
+// insert を選ばなかった場合も、ほかのワークロードのために時間を測らずに行を入れます。
+// データベースファイルは終わった後も残します。
+func Run(cfg Config) ([]Result, error) {
+	cfg.setDefaults()
+	for _, w := range cfg.Workloads {
+		if !slices.Contains(Workloads, w) {
+			return nil, fmt.Errorf("unknown workload: %s (want one of %s)", w, strings.Join(Workloads, ", "))
+		}
+	}
+	if _, err := os.Stat(cfg.Path); err == nil {
+		return nil, fmt.Errorf("%s already exists", cfg.Path)
+	}
+	db, err := engine.Open(cfg.Path, engine.Options{Journal: cfg.Journal})
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	if _, err := db.ExecScript("CREATE TABLE bench (id INT PRIMARY KEY, k INT NOT NULL, v TEXT)"); err != nil {
+		return nil, err
+	}
+	b := &bench{cfg: cfg, db: db}
+	var results []Result
+	insert, err := b.run("insert", (cfg.Rows+cfg.Batch-1)/cfg.Batch, b.insert)
+	if err != nil {
+		return nil, err
+	}
+	if slices.Contains(cfg.Workloads, "insert") {
+		results = append(results, insert)
+	}
+	// 検索で索引を使うように統計を取る
+	if _, err := db.Exec("ANALYZE"); err != nil {
+		return nil, err
+	}
+	ops := map[string]func(*rand.Rand, int) (int, error){"lookup": b.lookup, "range": b.scan, "mixed": b.mixed}
+	for _, w := range Workloads[1:] {
+		if !slices.Contains(cfg.Workloads, w) {
+			continue
+		}
+		r, err := b.run(w, cfg.Ops, ops[w])
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, r)
+	}
+	return results, nil
+}
+
+// bench は実行中のベンチマークです。
+type bench struct {
+	cfg Config
+	db  *engine.DB
+}
+
+// run は op を n 回、cfg.Concurrency 個のゴルーチンで分けて実行し、時間を測ります。op は
+// i 番目の操作を実行して扱った行の数を返します。直列化の失敗とデッドロックは数えるだけで、
+// ほかのエラーが起きたらワークロードをやめてそのエラーを返します。
+func (b *bench) run(name string, n int, op func(rng *rand.Rand, i int) (int, error)) (Result, error) {
+	res := Result{Workload: name}
+	var (
+		mu    sync.Mutex
+		first error
+		wg    sync.WaitGroup
+	)
+	start := time.Now()
+	for w := 0; w < b.cfg.Concurrency; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			rng := rand.New(rand.NewSource(b.cfg.Seed + int64(w)))
+			var lat []time.Duration
+			rows, failed := 0, 0
+			var err error
+			for i := w; i < n; i += b.cfg.Concurrency {
+				t := time.Now()
+				var k int
+				k, err = op(rng, i)
+				if isRetryable(err) {
+					failed, err = failed+1, nil
+					continue
+				}
+				if err != nil {
+					break
+				}
+				lat = append(lat, time.Since(t))
+				rows += k
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			res.Latencies = append(res.Latencies, lat...)
+			res.Rows += rows
+			res.Failed += failed
+			if err != nil && first == nil {
+				first = fmt.Errorf("%s: %w", name, err)
+			}
+		}(w)
+	}
+	wg.Wait()
+	res.Elapsed = time.Since(start)
+	res.Ops = len(res.Latencies)
+	slices.Sort(res.Latencies)
+	return res, first
+}
+
+// isRetryable は err がやり直せば成功しうる同時実行の競合かを返します。
+func isRetryable(err error) bool {
+	return errors.Is(err, txn.ErrSerialization) || errors.Is(err, lock.ErrDeadlock)
+}
+
+// insert は i 番目のバッチの行を1つのトランザクションで挿入します。
+func (b *bench) insert(rng *rand.Rand, i int) (int, error) {
+	s, err := b.db.Prepare("INSERT INTO bench VALUES (?, ?, ?)")
+	if err != nil {
+		return 0, err
+	}
+	tx, err := b.db.Begin(txn.Options{})
+	if err != nil {
+		return 0, err
+	}
+	lo, hi := i*b.cfg.Batch, min((i+1)*b.cfg.Batch, b.cfg.Rows)
+	for id := lo; id < hi; id++ {
+		if _, err := tx.ExecStmt(s, id, rng.Intn(b.cfg.Rows), payload(rng)); err != nil {
+			tx.Rollback()
+			return 0, err
+		}
+	}
+	return hi - lo, tx.Commit()
+}
+
+// lookup は主キーで選んだ1行を読みます。
+func (b *bench) lookup(rng *rand.Rand, _ int) (int, error) {
+	return b.query("SELECT k, v FROM bench WHERE id = ?", rng.Intn(b.cfg.Rows))
+}
+
+// scan は主キーの範囲の cfg.RangeSize 行を読みます。
+func (b *bench) scan(rng *rand.Rand, _ int) (int, error) {
+	lo := rng.Intn(max(1, b.cfg.Rows-b.cfg.RangeSize))
+	return b.query("SELECT id, k, v FROM bench WHERE id >= ? AND id < ?", lo, lo+b.cfg.RangeSize)
+}
+
+// mixed は cfg.WriteRatio の割合で1行を更新し、残りは1行を読みます。
+func (b *bench) mixed(rng *rand.Rand, i int) (int, error) {
+	if rng.Float64() >= b.cfg.WriteRatio {
+		return b.lookup(rng, i)
+	}
+	n, err := b.db.Exec("UPDATE bench SET k = k + 1, v = ? WHERE id = ?", payload(rng), rng.Intn(b.cfg.Rows))
+	return int(n), err
+}
+
+// query は読み取り専用のトランザクションで問い合わせを実行し、結果の行をすべて読みます。
+func (b *bench) query(sql string, args ...any) (int, error) {
+	tx, err := b.db.Begin(txn.Options{ReadOnly: true})
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	rows, err := tx.Query(sql, args...)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+	n := 0
+	for rows.Next() {
+		n++
+	}
+	return n, rows.Err()
+}
+
+// payload は行の v 列に入れる、長さがばらつく文字列を返します。
+func payload(rng *rand.Rand) string {
+	const letters = "abcdefghijklmnopqrstuvwxyz"
+	buf := make([]byte, 16+rng.Intn(48))
+	for i := range buf {
+		buf[i] = letters[rng.Intn(len(letters))]
+	}
+	return string(buf)
+}
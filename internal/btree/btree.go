@@ -0,0 +1,427 @@
+// Package btree はページ上の B+ 木を提供します。インデックスの格納に使います。
+//
+// キーと値はバイト列で、キーはバイト列としての順序（bytes.Compare）で並びます。
+// 同じキーは1つしか格納できないので、重複を許すインデックスではキーの末尾に
+// 行の位置を付けて一意にします。
+//
+// ノードのページのレイアウト:
+//
+//	[4:"BTRE"][u8:種類（1=葉, 2=内部）][u8:0][u16:エントリ数][i64:葉なら右隣の葉、内部ノードなら最も左の子]
+//	葉のエントリ    : [u16:キーの長さ][キー][u16:値の長さ][値]
+//	内部ノードのエントリ: [u16:キーの長さ][キー][i64:子（キー以上の値を持つ）]
+//
+// ルートのページは木の高さが変わっても同じページのままなので、カタログに記録した
+// ルートのページIDは変わりません。削除でノードが空になってもページは併合せず、
+// 葉のつながりを保ちます。
+package btree
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/k-sml/go-rdbms/internal/dberr"
+	"github.com/k-sml/go-rdbms/internal/storage"
+)
+
+var (
+	// ErrDuplicateKey は同じキーがすでにある場合に返されます。
+	ErrDuplicateKey = dberr.ErrDuplicateKey
+	// ErrKeyTooLarge はキーと値が大きすぎてノードに格納できない場合に返されます。
+	ErrKeyTooLarge = errors.New("index key too large")
+)
+
+var magic = [4]byte{'B', 'T', 'R', 'E'}
+
+const (
+	hdrSize  = 16
+	leafKind = 1
+	nodeKind = 2
+)
+
+// Tree はページ上の B+ 木です。
+type Tree struct {
+	pg   storage.Pages
+	root int64
+}
+
+// Create は空の木を作成します。
+func Create(pg storage.Pages) (*Tree, error) {
+	root, err := storage.AllocPage(pg)
+	if err != nil {
+		return nil, err
+	}
+	t := &Tree{pg: pg, root: root}
+	if err := t.write(root, &node{leaf: true}); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// Open は root をルートとする既存の木を開きます。
+func Open(pg storage.Pages, root int64) *Tree { return &Tree{pg: pg, root: root} }
+
+// Root はルートのページIDを返します。
+func (t *Tree) Root() int64 { return t.root }
+
+// node はデコードしたノードです。内部ノードでは children[i] が keys[i] 以上のキーを持つ子で、
+// next が keys[0] より小さいキーを持つ子です。
+type node struct {
+	leaf     bool
+	next     int64
+	keys     [][]byte
+	vals     [][]byte // 葉のみ
+	children []int64  // 内部ノードのみ
+}
+
+func (n *node) size() int {
+	s := hdrSize
+	for i, k := range n.keys {
+		s += 2 + len(k)
+		if n.leaf {
+			s += 2 + len(n.vals[i])
+		} else {
+			s += 8
+		}
+	}
+	return s
+}
+
+func (t *Tree) read(id int64) (*node, error) {
+	buf, err := t.pg.ReadPage(id)
+	if err != nil {
+		return nil, err
+	}
+	if [4]byte(buf[0:4]) != magic {
+		return nil, dberr.Mark(fmt.Errorf("page %d is not a btree node", id), dberr.ErrCorrupt)
+	}
+	n, ok := decode(buf)
+	if !ok {
+		return nil, dberr.Mark(fmt.Errorf("btree node %d is corrupt", id), dberr.ErrCorrupt)
+	}
+	return n, nil
+}
+
+// decode はノードのページ buf をデコードします。エントリがページに収まっていなければ false を返します。
+func decode(buf []byte) (*node, bool) {
+	n := &node{leaf: buf[4] == leafKind, next: int64(binary.LittleEndian.Uint64(buf[8:16]))}
+	count := int(binary.LittleEndian.Uint16(buf[6:8]))
+	off := hdrSize
+	field := func() ([]byte, bool) {
+		if off+2 > len(buf) {
+			return nil, false
+		}
+		l := int(binary.LittleEndian.Uint16(buf[off:]))
+		if off+2+l > len(buf) {
+			return nil, false
+		}
+		b := bytes.Clone(buf[off+2 : off+2+l])
+		off += 2 + l
+		return b, true
+	}
+	for i := 0; i < count; i++ {
+		k, ok := field()
+		if !ok {
+			return nil, false
+		}
+		n.keys = append(n.keys, k)
+		if n.leaf {
+			v, ok := field()
+			if !ok {
+				return nil, false
+			}
+			n.vals = append(n.vals, v)
+			continue
+		}
+		if off+8 > len(buf) {
+			return nil, false
+		}
+		n.children = append(n.children, int64(binary.LittleEndian.Uint64(buf[off:])))
+		off += 8
+	}
+	return n, true
+}
+
+// Node はノードのページの内容です。ページを調べるコマンドが使います。
+type Node struct {
+	Leaf     bool
+	Next     int64    // 葉なら右隣の葉、内部ノードなら最も左の子
+	Keys     [][]byte // キー
+	Values   [][]byte // 葉のエントリの値
+	Children []int64  // 内部ノードのエントリの子（キー以上の値を持つ）
+	Size     int      // ヘッダとエントリが使っているバイト数
+}
+
+// IsNodePage はページ buf が B+ 木のノードかを返します。
+func IsNodePage(buf []byte) bool { return len(buf) >= hdrSize && [4]byte(buf[0:4]) == magic }
+
+// DecodeNode はノードのページ buf をデコードします。
+func DecodeNode(buf []byte) (*Node, error) {
+	if !IsNodePage(buf) {
+		return nil, dberr.Mark(errors.New("page is not a btree node"), dberr.ErrCorrupt)
+	}
+	n, ok := decode(buf)
+	if !ok {
+		return nil, dberr.Mark(errors.New("btree node is corrupt"), dberr.ErrCorrupt)
+	}
+	return &Node{Leaf: n.leaf, Next: n.next, Keys: n.keys, Values: n.vals, Children: n.children, Size: n.size()}, nil
+}
+
+func (t *Tree) write(id int64, n *node) error {
+	buf := make([]byte, t.pg.PageSize())
+	copy(buf[0:4], magic[:])
+	buf[4] = nodeKind
+	if n.leaf {
+		buf[4] = leafKind
+	}
+	binary.LittleEndian.PutUint16(buf[6:8], uint16(len(n.keys)))
+	binary.LittleEndian.PutUint64(buf[8:16], uint64(n.next))
+	off := hdrSize
+	put := func(b []byte) {
+		binary.LittleEndian.PutUint16(buf[off:], uint16(len(b)))
+		off += 2 + copy(buf[off+2:], b)
+	}
+	for i, k := range n.keys {
+		put(k)
+		if n.leaf {
+			put(n.vals[i])
+		} else {
+			binary.LittleEndian.PutUint64(buf[off:], uint64(n.children[i]))
+			off += 8
+		}
+	}
+	return t.pg.WritePage(id, buf)
+}
+
+// search は n の中で key 以上の最初のキーの位置を返します。
+func (n *node) search(key []byte) int {
+	return sort.Search(len(n.keys), func(i int) bool { return bytes.Compare(n.keys[i], key) >= 0 })
+}
+
+// child は内部ノード n で key を持つ子を返します。
+func (n *node) child(key []byte) int64 {
+	i := sort.Search(len(n.keys), func(i int) bool { return bytes.Compare(n.keys[i], key) > 0 })
+	if i == 0 {
+		return n.next
+	}
+	return n.children[i-1]
+}
+
+// Insert は key と val を追加します。同じキーがすでにあれば ErrDuplicateKey を返します。
+func (t *Tree) Insert(key, val []byte) error {
+	if 4+len(key)+len(val) > (t.pg.PageSize()-hdrSize)/4 {
+		return fmt.Errorf("%w: %d bytes", ErrKeyTooLarge, len(key)+len(val))
+	}
+	sep, right, err := t.insert(t.root, key, val)
+	if err != nil || right == 0 {
+		return err
+	}
+	// ルートが分割されたので、ルートの内容を新しいページに移して木を1段高くする
+	n, err := t.read(t.root)
+	if err != nil {
+		return err
+	}
+	left, err := storage.AllocPage(t.pg)
+	if err != nil {
+		return err
+	}
+	if err := t.write(left, n); err != nil {
+		return err
+	}
+	return t.write(t.root, &node{next: left, keys: [][]byte{sep}, children: []int64{right}})
+}
+
+// insert は id を根とする部分木に追加します。ノードが分割された場合は、右のノードの
+// 最小のキーとページIDを返します。
+func (t *Tree) insert(id int64, key, val []byte) ([]byte, int64, error) {
+	n, err := t.read(id)
+	if err != nil {
+		return nil, 0, err
+	}
+	if n.leaf {
+		i := n.search(key)
+		if i < len(n.keys) && bytes.Equal(n.keys[i], key) {
+			return nil, 0, ErrDuplicateKey
+		}
+		n.keys = insertAt(n.keys, i, key)
+		n.vals = insertAt(n.vals, i, val)
+	} else {
+		sep, right, err := t.insert(n.child(key), key, val)
+		if err != nil || right == 0 {
+			return nil, 0, err
+		}
+		i := n.search(sep)
+		n.keys = insertAt(n.keys, i, sep)
+		n.children = insertAt(n.children, i, right)
+	}
+	if n.size() <= t.pg.PageSize() {
+		return nil, 0, t.write(id, n)
+	}
+	return t.split(id, n)
+}
+
+// split は大きくなりすぎたノード n をバイト数でおよそ半分に分け、右半分を新しいページに書きます。
+func (t *Tree) split(id int64, n *node) ([]byte, int64, error) {
+	half, acc, mid := n.size()/2, hdrSize, 0
+	for mid < len(n.keys)-1 && acc < half {
+		acc += 2 + len(n.keys[mid])
+		if n.leaf {
+			acc += 2 + len(n.vals[mid])
+		} else {
+			acc += 8
+		}
+		mid++
+	}
+	mid = max(mid, 1)
+	rid, err := storage.AllocPage(t.pg)
+	if err != nil {
+		return nil, 0, err
+	}
+	var r *node
+	var sep []byte
+	if n.leaf {
+		r = &node{leaf: true, next: n.next, keys: clip(n.keys[mid:]), vals: clip(n.vals[mid:])}
+		n.keys, n.vals, n.next = n.keys[:mid], n.vals[:mid], rid
+		sep = r.keys[0]
+	} else {
+		// 内部ノードでは中央のキーを親に上げる
+		sep = n.keys[mid]
+		r = &node{next: n.children[mid], keys: clip(n.keys[mid+1:]), children: clip(n.children[mid+1:])}
+		n.keys, n.children = n.keys[:mid], n.children[:mid]
+	}
+	if err := t.write(rid, r); err != nil {
+		return nil, 0, err
+	}
+	return sep, rid, t.write(id, n)
+}
+
+// Delete は key を削除します。キーがなければ false を返します。
+func (t *Tree) Delete(key []byte) (bool, error) {
+	id, n, err := t.leaf(key)
+	if err != nil {
+		return false, err
+	}
+	i := n.search(key)
+	if i >= len(n.keys) || !bytes.Equal(n.keys[i], key) {
+		return false, nil
+	}
+	n.keys = append(n.keys[:i], n.keys[i+1:]...)
+	n.vals = append(n.vals[:i], n.vals[i+1:]...)
+	return true, t.write(id, n)
+}
+
+// Get は key の値を返します。
+func (t *Tree) Get(key []byte) ([]byte, bool, error) {
+	_, n, err := t.leaf(key)
+	if err != nil {
+		return nil, false, err
+	}
+	i := n.search(key)
+	if i < len(n.keys) && bytes.Equal(n.keys[i], key) {
+		return n.vals[i], true, nil
+	}
+	return nil, false, nil
+}
+
+// leaf は key を持つ葉を返します。
+func (t *Tree) leaf(key []byte) (int64, *node, error) {
+	id := t.root
+	for depth := 0; ; depth++ {
+		if depth > 64 {
+			return 0, nil, dberr.Mark(fmt.Errorf("btree %d is too deep (loop?)", t.root), dberr.ErrCorrupt)
+		}
+		n, err := t.read(id)
+		if err != nil {
+			return 0, nil, err
+		}
+		if n.leaf {
+			return id, n, nil
+		}
+		id = n.child(key)
+	}
+}
+
+// Drop は木のすべてのページを解放します。
+func (t *Tree) Drop() error {
+	return t.walk(t.root, func(id int64, _ *node) error { return storage.FreePage(t.pg, id) })
+}
+
+// Clear はルート以外のページを解放して、木を空にします。
+func (t *Tree) Clear() error {
+	err := t.walk(t.root, func(id int64, _ *node) error {
+		if id == t.root {
+			return nil
+		}
+		return storage.FreePage(t.pg, id)
+	})
+	if err != nil {
+		return err
+	}
+	return t.write(t.root, &node{leaf: true})
+}
+
+// Pages は木のすべてのページのIDを返します。
+func (t *Tree) Pages() ([]int64, error) {
+	var ids []int64
+	err := t.walk(t.root, func(id int64, _ *node) error { ids = append(ids, id); return nil })
+	return ids, err
+}
+
+// walk は id を根とする部分木のノードを、子を先にして fn に渡します。
+func (t *Tree) walk(id int64, fn func(id int64, n *node) error) error {
+	n, err := t.read(id)
+	if err != nil {
+		return err
+	}
+	if !n.leaf {
+		for _, c := range append([]int64{n.next}, n.children...) {
+			if err := t.walk(c, fn); err != nil {
+				return err
+			}
+		}
+	}
+	return fn(id, n)
+}
+
+// Cursor は葉のエントリをキーの順にたどります。
+type Cursor struct {
+	t *Tree
+	n *node
+	i int
+}
+
+// Seek は key 以上の最初のエントリを指すカーソルを返します。key が nil なら先頭からです。
+func (t *Tree) Seek(key []byte) (*Cursor, error) {
+	_, n, err := t.leaf(key)
+	if err != nil {
+		return nil, err
+	}
+	return &Cursor{t: t, n: n, i: n.search(key)}, nil
+}
+
+// Next は次のエントリを返します。最後まで読むと ok が false になります。
+func (c *Cursor) Next() (key, val []byte, ok bool, err error) {
+	for c.i >= len(c.n.keys) {
+		if c.n.next == 0 {
+			return nil, nil, false, nil
+		}
+		if c.n, err = c.t.read(c.n.next); err != nil {
+			return nil, nil, false, err
+		}
+		c.i = 0
+	}
+	key, val = c.n.keys[c.i], c.n.vals[c.i]
+	c.i++
+	return key, val, true, nil
+}
+
+func insertAt[T any](s []T, i int, v T) []T {
+	s = append(s, v)
+	copy(s[i+1:], s[i:])
+	s[i] = v
+	return s
+}
+
+func clip[T any](s []T) []T { return append([]T(nil), s...) }
@@ -0,0 +1,220 @@
+package btree
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/rand"
+	"slices"
+	"testing"
+
+	"github.com/k-sml/go-rdbms/internal/dberr"
+	"github.com/k-sml/go-rdbms/internal/storage"
+)
+
+// memPages はページをメモリに置く storage.Pages です。
+type memPages map[int64][]byte
+
+const testPageSize = 256
+
+func (m memPages) ReadPage(id int64) ([]byte, error) {
+	buf := make([]byte, testPageSize)
+	copy(buf, m[id])
+	return buf, nil
+}
+
+func (m memPages) WritePage(id int64, buf []byte) error {
+	m[id] = slices.Clone(buf)
+	return nil
+}
+
+func (m memPages) PageSize() int { return testPageSize }
+
+// key は i 番目のキーを返します。
+func key(i int) []byte { return []byte(fmt.Sprintf("key%05d", i)) }
+
+// scan はカーソルで from 以上のキーを順にすべて読みます。
+func scan(t *testing.T, tr *Tree, from []byte) [][]byte {
+	t.Helper()
+	c, err := tr.Seek(from)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var keys [][]byte
+	for {
+		k, v, ok, err := c.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ok {
+			return keys
+		}
+		if !bytes.Equal(v, append([]byte("v"), k...)) {
+			t.Fatalf("key %s has value %s", k, v)
+		}
+		keys = append(keys, k)
+	}
+}
+
+// TestTree は、どの順に挿入しても木が分割されながらキーを順に保ち、
+// 削除したキーだけが読めなくなることを確かめます。
+func TestTree(t *testing.T) {
+	const n = 1000
+	tests := []struct {
+		name  string
+		order func() []int
+	}{
+		{"ascending", func() []int {
+			s := make([]int, n)
+			for i := range s {
+				s[i] = i
+			}
+			return s
+		}},
+		{"descending", func() []int {
+			s := make([]int, n)
+			for i := range s {
+				s[i] = n - 1 - i
+			}
+			return s
+		}},
+		{"random", func() []int { return rand.New(rand.NewSource(1)).Perm(n) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pg := memPages{}
+			tr, err := Create(pg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, i := range tt.order() {
+				if err := tr.Insert(key(i), append([]byte("v"), key(i)...)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := tr.Insert(key(7), nil); !errors.Is(err, ErrDuplicateKey) {
+				t.Errorf("inserting a duplicate key: err = %v, want ErrDuplicateKey", err)
+			}
+
+			// 開き直した木でも同じように読める
+			tr = Open(pg, tr.Root())
+			keys := scan(t, tr, nil)
+			if len(keys) != n || !slices.IsSortedFunc(keys, bytes.Compare) {
+				t.Fatalf("scanned %d keys, want %d in order", len(keys), n)
+			}
+			if pages, err := tr.Pages(); err != nil || len(pages) < 3 {
+				t.Errorf("Pages = %d pages, %v, want a tree of several pages", len(pages), err)
+			}
+			if got := scan(t, tr, []byte("key00500x")); len(got) != n-501 || !bytes.Equal(got[0], key(501)) {
+				t.Errorf("Seek(key00500x) starts at %s with %d keys, want key00501 with %d", got[0], len(got), n-501)
+			}
+
+			for i := 0; i < n; i += 2 {
+				if ok, err := tr.Delete(key(i)); err != nil || !ok {
+					t.Fatalf("Delete(%s) = %v, %v", key(i), ok, err)
+				}
+			}
+			if ok, err := tr.Delete(key(0)); err != nil || ok {
+				t.Errorf("deleting a missing key = %v, %v, want false", ok, err)
+			}
+			for _, i := range []int{0, 1, 500, 999} {
+				_, ok, err := tr.Get(key(i))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if ok != (i%2 == 1) {
+					t.Errorf("Get(%s) found = %v after deleting even keys", key(i), ok)
+				}
+			}
+			if keys := scan(t, tr, nil); len(keys) != n/2 {
+				t.Errorf("scanned %d keys after deleting, want %d", len(keys), n/2)
+			}
+		})
+	}
+}
+
+// TestKeyTooLarge は、ノードに収まらない大きさのキーを拒否することを確かめます。
+func TestKeyTooLarge(t *testing.T) {
+	tr, err := Create(memPages{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tr.Insert(make([]byte, testPageSize/2), nil); !errors.Is(err, ErrKeyTooLarge) {
+		t.Errorf("err = %v, want ErrKeyTooLarge", err)
+	}
+}
+
+// TestClear は、Clear と Drop がルート以外のページ、またはすべてのページを解放することを確かめます。
+func TestClear(t *testing.T) {
+	pg := memPages{}
+	tr, err := Create(pg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range 200 {
+		if err := tr.Insert(key(i), []byte("v")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tr.Clear(); err != nil {
+		t.Fatal(err)
+	}
+	if pages, err := tr.Pages(); err != nil || !slices.Equal(pages, []int64{tr.Root()}) {
+		t.Errorf("Pages after Clear = %v, %v, want only the root", pages, err)
+	}
+	if keys := scan(t, tr, nil); len(keys) != 0 {
+		t.Errorf("scanned %d keys after Clear", len(keys))
+	}
+	if err := tr.Insert(key(1), []byte("v")); err != nil {
+		t.Fatal(err)
+	}
+	if err := tr.Drop(); err != nil {
+		t.Fatal(err)
+	}
+	h, err := storage.ReadHeader(pg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.FreeHead == 0 {
+		t.Error("no pages were freed by Drop")
+	}
+}
+
+// TestDecodeNode は、DecodeNode が葉と内部ノードの内容を返し、ノードでないページを拒否することを確かめます。
+func TestDecodeNode(t *testing.T) {
+	pg := memPages{}
+	tr, err := Create(pg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range 100 {
+		if err := tr.Insert(key(i), []byte("v")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	root, err := DecodeNode(pg[tr.Root()])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if root.Leaf || len(root.Children) != len(root.Keys) || root.Next == 0 {
+		t.Fatalf("root = %+v, want an inner node", root)
+	}
+	leaf, err := DecodeNode(pg[root.Next])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !leaf.Leaf || !bytes.Equal(leaf.Keys[0], key(0)) || len(leaf.Values) != len(leaf.Keys) {
+		t.Errorf("leftmost leaf = %+v, want a leaf starting at key00000", leaf)
+	}
+	if leaf.Size <= hdrSize || leaf.Size > testPageSize {
+		t.Errorf("leaf size = %d", leaf.Size)
+	}
+
+	corrupt := slices.Clone(pg[root.Next])
+	corrupt[6] = 0xFF // エントリ数
+	for _, buf := range [][]byte{make([]byte, testPageSize), corrupt} {
+		if _, err := DecodeNode(buf); !errors.Is(err, dberr.ErrCorrupt) {
+			t.Errorf("DecodeNode of a bad page: err = %v, want ErrCorrupt", err)
+		}
+	}
+}
@@ -0,0 +1,372 @@
+package catalog
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/k-sml/go-rdbms/internal/storage"
+	"github.com/k-sml/go-rdbms/internal/tuple"
+	"github.com/k-sml/go-rdbms/internal/types"
+)
+
+// 列の追加・削除・名前の変更
+//
+// 行は値の数を先頭に持つので（tuple パッケージ）、列を末尾に追加しても既存の行は
+// 書き換えない。列を追加する前に書かれた行は値が足りず、読むときに Pad で既定値を補う。
+// 列を削除すると後ろの列の位置がずれるため、テーブルのすべての行を新しいヒープファイルに
+// 書き直し、古いヒープファイルのページを解放する。
+//
+// 変更はメモリ上の定義を複製してから行い、すべて成功したときに差し替える。
+
+// Pad は列を追加する前に書かれた、値の足りない行 row に列の既定値を補って返します。
+func (t *Table) Pad(row []types.Value) []types.Value {
+	for i := len(row); i < len(t.Columns); i++ {
+		row = append(row, t.Columns[i].Default)
+	}
+	return row
+}
+
+// userTable は名前が name のユーザーテーブルを返します。
+func (c *Catalog) userTable(name string) (*Table, error) {
+	t, ok := c.tables[key(name)]
+	if !ok || t.System {
+		return nil, fmt.Errorf("%w: %s", ErrTableNotFound, name)
+	}
+	return t, nil
+}
+
+// clone は t の定義を、列と外部キーの並びも含めて複製します。
+func (t *Table) clone() *Table {
+	nt := *t
+	nt.Columns = slices.Clone(t.Columns)
+	nt.ForeignKeys = slices.Clone(t.ForeignKeys)
+	for i, fk := range nt.ForeignKeys {
+		nt.ForeignKeys[i].Columns = slices.Clone(fk.Columns)
+		nt.ForeignKeys[i].RefColumns = slices.Clone(fk.RefColumns)
+	}
+	return &nt
+}
+
+// AddColumn はテーブルの末尾に列を追加します。既存の行の値は列の既定値になります。
+// 主キーの列は追加できず、NOT NULL の列には NULL でない既定値が必要です。
+func (c *Catalog) AddColumn(table string, col Column) error {
+	t, err := c.userTable(table)
+	if err != nil {
+		return err
+	}
+	if _, ok := t.Column(col.Name); ok {
+		return fmt.Errorf("duplicate column name: %s", col.Name)
+	}
+	if col.PrimaryKey {
+		return fmt.Errorf("cannot add a PRIMARY KEY column: %s", col.Name)
+	}
+	if err := checkColumn(&col); err != nil {
+		return err
+	}
+	if col.NotNull && col.Default.IsNull() {
+		return fmt.Errorf("cannot add a NOT NULL column without a default value: %s", col.Name)
+	}
+	nt := t.clone()
+	nt.Columns = append(nt.Columns, col)
+	return c.replaceColumns(t, nt)
+}
+
+// DropColumn はテーブルから列を削除し、すべての行を書き直します。
+// 主キーの列や、インデックス・外部キーで使われている列は削除できません。ビューの問い合わせは
+// 解析しないので、列を使うビューがないことは呼び出し側（engine.Tx.DropColumn）で確かめます。
+// 行の位置が変わるので、呼び出し側が行の位置を持っている場合は読み直してください。
+func (c *Catalog) DropColumn(table, column string) error {
+	t, err := c.userTable(table)
+	if err != nil {
+		return err
+	}
+	i, ok := t.Column(column)
+	if !ok {
+		return fmt.Errorf("no such column: %s.%s", t.Name, column)
+	}
+	col := t.Columns[i]
+	switch {
+	case len(t.Columns) == 1:
+		return fmt.Errorf("cannot drop the only column of %s", t.Name)
+	case col.PrimaryKey:
+		return fmt.Errorf("cannot drop PRIMARY KEY column %s.%s", t.Name, col.Name)
+	}
+	has := func(cols []string) bool {
+		return slices.ContainsFunc(cols, func(s string) bool { return strings.EqualFold(s, col.Name) })
+	}
+	for _, ix := range c.Indexes(t.Name) {
+		if has(ix.Columns) {
+			return fmt.Errorf("cannot drop column %s.%s: used by index %s", t.Name, col.Name, ix.Name)
+		}
+	}
+	for _, fk := range t.ForeignKeys {
+		if has(fk.Columns) {
+			return fmt.Errorf("cannot drop column %s.%s: used by a foreign key", t.Name, col.Name)
+		}
+	}
+	for _, ref := range c.Referencing(t.Name) {
+		if has(ref.ForeignKey.RefColumns) {
+			return fmt.Errorf("cannot drop column %s.%s: referenced by a foreign key of %s", t.Name, col.Name, ref.Table.Name)
+		}
+	}
+
+	nt := t.clone()
+	nt.Columns = slices.Delete(nt.Columns, i, i+1)
+	old := storage.OpenHeapFileWithOptions(c.pg, t.Root, t.HeapOptions())
+	hf, err := storage.CreateHeapFileWithOptions(c.pg, t.HeapOptions())
+	if err != nil {
+		return err
+	}
+	err = old.Scan(func(rid storage.RID, rec []byte) error {
+		row, err := tuple.Decode(rec)
+		if err != nil {
+			return fmt.Errorf("%s row %s: %w", t.Name, rid, err)
+		}
+		row = t.Pad(row)
+		_, err = hf.Insert(tuple.Encode(slices.Delete(row, i, i+1)))
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if err := old.Drop(); err != nil {
+		return err
+	}
+	nt.Root = hf.Root()
+	if err := c.replaceTableRow(nt); err != nil {
+		return err
+	}
+	// 列の統計情報は列の位置の順なので、集め直すまで使わない
+	if err := c.deleteStats(t); err != nil {
+		return err
+	}
+	return c.replaceColumns(t, nt)
+}
+
+// RenameColumn は列の名前を変更します。列を使っているインデックスと外部キー、
+// AUTOINCREMENT のシーケンスの名前も合わせて変更します。ビューの問い合わせは書き換えないので、
+// 列を使うビューがないことは呼び出し側（engine.Tx.RenameColumn）で確かめます。
+func (c *Catalog) RenameColumn(table, column, name string) error {
+	t, err := c.userTable(table)
+	if err != nil {
+		return err
+	}
+	i, ok := t.Column(column)
+	if !ok {
+		return fmt.Errorf("no such column: %s.%s", t.Name, column)
+	}
+	if j, ok := t.Column(name); ok && j != i {
+		return fmt.Errorf("duplicate column name: %s", name)
+	}
+	from := t.Columns[i].Name
+	rename := func(cols []string) {
+		for k, s := range cols {
+			if strings.EqualFold(s, from) {
+				cols[k] = name
+			}
+		}
+	}
+
+	nt := t.clone()
+	nt.Columns[i].Name = name
+	for _, fk := range nt.ForeignKeys {
+		rename(fk.Columns)
+		if strings.EqualFold(fk.RefTable, t.Name) {
+			rename(fk.RefColumns)
+		}
+	}
+	if nt.Columns[i].AutoIncrement {
+		if err := c.renameSequence(SequenceName(t.Name, from), SequenceName(t.Name, name)); err != nil {
+			return err
+		}
+	}
+	for _, ix := range c.Indexes(t.Name) {
+		nix := *ix
+		nix.Columns = slices.Clone(ix.Columns)
+		rename(nix.Columns)
+		if slices.Equal(nix.Columns, ix.Columns) {
+			continue
+		}
+		if err := c.replaceIndex(ix, &nix); err != nil {
+			return err
+		}
+	}
+	// 他のテーブルからの参照
+	for _, ref := range c.Referencing(t.Name) {
+		if ref.Table == t {
+			continue
+		}
+		nr := ref.Table.clone()
+		for _, fk := range nr.ForeignKeys {
+			if strings.EqualFold(fk.RefTable, t.Name) {
+				rename(fk.RefColumns)
+			}
+		}
+		if err := c.deleteForeignKeys(ref.Table); err != nil {
+			return err
+		}
+		if err := c.saveForeignKeys(nr); err != nil {
+			return err
+		}
+		c.tables[key(nr.Name)] = nr
+	}
+	if err := c.deleteForeignKeys(t); err != nil {
+		return err
+	}
+	if err := c.saveForeignKeys(nt); err != nil {
+		return err
+	}
+	return c.replaceColumns(t, nt)
+}
+
+// replaceColumns は t の列の定義を nt のものに書き換え、メモリ上の定義を nt に差し替えます。
+func (c *Catalog) replaceColumns(t, nt *Table) error {
+	if err := c.delete(columnsRoot, func(v []types.Value) bool { return v[0].Int() == t.ID }); err != nil {
+		return err
+	}
+	if err := c.saveColumns(nt); err != nil {
+		return err
+	}
+	if err := c.bump(); err != nil {
+		return err
+	}
+	c.tables[key(nt.Name)] = nt
+	return nil
+}
+
+// replaceTableRow は __tables にあるテーブルの行を nt の名前とルートで書き直します。
+func (c *Catalog) replaceTableRow(nt *Table) error {
+	if err := c.delete(tablesRoot, func(v []types.Value) bool { return v[0].Int() == nt.ID }); err != nil {
+		return err
+	}
+	return c.insert(tablesRoot, c.tableRow(nt)...)
+}
+
+// renameSequence はシーケンスの名前を変更します。
+func (c *Catalog) renameSequence(from, to string) error {
+	s, ok := c.seqs[key(from)]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrSequenceNotFound, from)
+	}
+	root, err := c.sequencesRoot()
+	if err != nil {
+		return err
+	}
+	if err := c.delete(root, func(v []types.Value) bool { return key(v[0].Text()) == key(from) }); err != nil {
+		return err
+	}
+	if err := c.insert(root, types.NewText(to), types.NewBigInt(s.Value)); err != nil {
+		return err
+	}
+	delete(c.seqs, key(from))
+	c.seqs[key(to)] = &Sequence{Name: to, Value: s.Value}
+	return nil
+}
+
+// SetTableRoot はテーブル name のヒープファイルを、ページ root から始まるものに差し替えます。
+// 新しいヒープファイルの内容と古いページの解放は呼び出し側で行います（VACUUM で使います）。
+func (c *Catalog) SetTableRoot(name string, root int64) error {
+	t, err := c.userTable(name)
+	if err != nil {
+		return err
+	}
+	nt := t.clone()
+	nt.Root = root
+	if err := c.replaceTableRow(nt); err != nil {
+		return err
+	}
+	if err := c.bump(); err != nil {
+		return err
+	}
+	c.tables[key(nt.Name)] = nt
+	return nil
+}
+
+// CompactSystemTables はシステムテーブルを、生きている行だけを詰めたヒープに書き直します
+// （VACUUM で使います）。行を読んでから古いページをすべて解放し、解放済みのページのリストを
+// 小さい順に並べ直してから書き直すので、ファイルの後ろの方にあったページは前の方に移ります。
+// __tables、__columns、__indexes はルートのページが決まっているのでルートはそのまま使い、
+// 必要になったときに作るシステムテーブルはルートも割り当て直して __tables の行を書き換えます。
+// 行の位置（RID）は変わります。
+func (c *Catalog) CompactSystemTables() error {
+	type rewrite struct {
+		t    *Table
+		recs [][]byte
+	}
+	var tables []rewrite
+	for _, t := range c.AllTables() {
+		if !t.System {
+			continue
+		}
+		var recs [][]byte
+		err := storage.OpenHeapFile(c.pg, t.Root).Scan(func(_ storage.RID, rec []byte) error {
+			recs = append(recs, rec)
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("%s: %w", t.Name, err)
+		}
+		tables = append(tables, rewrite{t, recs})
+	}
+	for _, rw := range tables {
+		hf := storage.OpenHeapFile(c.pg, rw.t.Root)
+		var err error
+		if slices.Contains(systemTables, rw.t) {
+			err = hf.Clear()
+		} else {
+			err = hf.Drop()
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", rw.t.Name, err)
+		}
+	}
+	if _, _, err := storage.CompactFreeList(c.pg); err != nil {
+		return err
+	}
+
+	var moved []*Table
+	for i, rw := range tables {
+		if slices.Contains(systemTables, rw.t) {
+			continue
+		}
+		hf, err := storage.CreateHeapFile(c.pg)
+		if err != nil {
+			return err
+		}
+		nt := rw.t.clone()
+		nt.Root = hf.Root()
+		tables[i].t = nt
+		moved = append(moved, nt)
+	}
+	for _, rw := range tables {
+		hf := storage.OpenHeapFile(c.pg, rw.t.Root)
+		for _, rec := range rw.recs {
+			if _, err := hf.Insert(rec); err != nil {
+				return fmt.Errorf("%s: %w", rw.t.Name, err)
+			}
+		}
+	}
+	for _, nt := range moved {
+		if err := c.replaceTableRow(nt); err != nil {
+			return err
+		}
+		c.tables[key(nt.Name)] = nt
+	}
+	return c.bump()
+}
+
+// SetIndexRoot はインデックス name の B+ 木を、ページ root を根とするものに差し替えます。
+// 新しい木の内容と古いページの解放は呼び出し側で行います（VACUUM で使います）。
+func (c *Catalog) SetIndexRoot(name string, root int64) error {
+	ix, ok := c.indexes[key(name)]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrIndexNotFound, name)
+	}
+	nix := *ix
+	nix.Root = root
+	if err := c.replaceIndex(ix, &nix); err != nil {
+		return err
+	}
+	return c.bump()
+}
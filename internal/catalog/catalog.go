@@ -0,0 +1,695 @@
+// Package catalog はテーブルや列、インデックスの定義（スキーマ）を管理します。
+//
+// 定義はデータベースファイル自身の中に、システムテーブルの行として保存します。
+// システムテーブルは次の3つで、ヒープファイルのルートは固定のページです。
+//
+//	__tables  (ページ1): id, name, root, fill_factor, append_only, layout, compression
+//	__columns (ページ2): table_id, position, name, type, not_null, primary_key, auto_increment, default
+//	__indexes (ページ3): id, name, table, columns, unique, root
+//
+// シーケンスは __sequences（name, value）に、外部キーは __foreign_keys に、
+// ビューは __views に、データベース全体の設定は __meta（name, value）に、ANALYZE で集めた
+// 統計情報は __statistics に、サーバーに接続するユーザーは __users（name, password, access）に
+// 保存します。
+// これらのシステムテーブルは最初に必要になったときに作られ、ルートは __tables の行として記録します。
+//
+// 後から列を追加したシステムテーブルでは、古い行の足りない値は NULL として読みます。
+//
+// 新しいファイルを開いたときは、ファイルヘッダとシステムテーブルを作成します（ブートストラップ）。
+package catalog
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/k-sml/go-rdbms/internal/dberr"
+	"github.com/k-sml/go-rdbms/internal/storage"
+	"github.com/k-sml/go-rdbms/internal/tuple"
+	"github.com/k-sml/go-rdbms/internal/types"
+)
+
+var (
+	// ErrTableExists は同じ名前のテーブルがすでにある場合に返されます。
+	ErrTableExists = errors.New("table already exists")
+	// ErrTableNotFound はテーブルが見つからない場合に返されます。
+	ErrTableNotFound = errors.New("no such table")
+	// ErrIndexExists は同じ名前のインデックスがすでにある場合に返されます。
+	ErrIndexExists = errors.New("index already exists")
+	// ErrIndexNotFound はインデックスが見つからない場合に返されます。
+	ErrIndexNotFound = errors.New("no such index")
+)
+
+// システムテーブルのヒープファイルのルートページ
+const (
+	tablesRoot  int64 = 1
+	columnsRoot int64 = 2
+	indexesRoot int64 = 3
+)
+
+// SystemPrefix はシステムテーブルの名前の接頭辞です。この接頭辞のテーブルは作成できません。
+const SystemPrefix = "__"
+
+// Column は列の定義です。
+type Column struct {
+	Name       string
+	Type       types.Type
+	NotNull    bool
+	PrimaryKey bool
+	// AutoIncrement は値を省略（NULL）して挿入したときにシーケンスから値を割り当てる列です。
+	// 整数型の主キーにだけ指定できます。
+	AutoIncrement bool
+	// Default は列の既定値です（ゼロ値は NULL）。ALTER TABLE ADD COLUMN より前に書かれた行では、
+	// 足りない列の値として読まれます。
+	Default types.Value
+}
+
+// Table はテーブルの定義です。
+type Table struct {
+	ID      int64
+	Name    string
+	Columns []Column
+	Root    int64 // 行を格納するヒープファイルのルートページ
+	System  bool  // システムテーブルか
+	Temp    bool  // セッションの一時テーブルか（temp.go）
+
+	ForeignKeys []ForeignKey
+	Options     StorageOptions
+
+	session int64 // 一時テーブルを持つセッションの番号
+}
+
+// Column は名前が name の列の位置を返します（大文字と小文字は区別しません）。
+func (t *Table) Column(name string) (int, bool) {
+	for i, c := range t.Columns {
+		if strings.EqualFold(c.Name, name) {
+			return i, true
+		}
+	}
+	return -1, false
+}
+
+// Index はインデックスの定義です。
+type Index struct {
+	ID      int64
+	Name    string
+	Table   string
+	Columns []string
+	Unique  bool
+	Root    int64 // インデックスのルートページ
+
+	session int64 // 一時テーブルのインデックスなら、テーブルを持つセッションの番号
+}
+
+// システムテーブルの定義
+var systemTables = []*Table{
+	{ID: -1, Name: "__tables", Root: tablesRoot, System: true, Columns: []Column{
+		{Name: "id", Type: types.BigInt}, {Name: "name", Type: types.Text}, {Name: "root", Type: types.BigInt},
+		{Name: "fill_factor", Type: types.BigInt}, {Name: "append_only", Type: types.Boolean},
+		{Name: "layout", Type: types.Text}, {Name: "compression", Type: types.Text},
+	}},
+	{ID: -2, Name: "__columns", Root: columnsRoot, System: true, Columns: []Column{
+		{Name: "table_id", Type: types.BigInt}, {Name: "position", Type: types.BigInt}, {Name: "name", Type: types.Text},
+		{Name: "type", Type: types.Text}, {Name: "not_null", Type: types.Boolean}, {Name: "primary_key", Type: types.Boolean},
+		{Name: "auto_increment", Type: types.Boolean}, {Name: "default", Type: types.Blob},
+	}},
+	{ID: -3, Name: "__indexes", Root: indexesRoot, System: true, Columns: []Column{
+		{Name: "id", Type: types.BigInt}, {Name: "name", Type: types.Text}, {Name: "table", Type: types.Text},
+		{Name: "columns", Type: types.Text}, {Name: "unique", Type: types.Boolean}, {Name: "root", Type: types.BigInt},
+	}},
+}
+
+// Catalog はデータベースのスキーマです。Open したときの定義をメモリに読み込み、
+// 変更はメモリ上の定義とシステムテーブルの両方に反映します。
+type Catalog struct {
+	pg      storage.Pages
+	tables  map[string]*Table // 小文字にした名前がキー
+	indexes map[string]*Index
+	views   map[string]*View
+	seqs    map[string]*Sequence
+	users   map[string]*User
+	meta    map[string]string
+	stats   map[int64]*TableStats // テーブルの ID がキー
+	nextID  int64
+	version uint64
+
+	session      int64             // 一時テーブルが見えるセッションの番号（temp.go）
+	others       map[string]*Table // ほかのセッションの一時テーブル（保存した名前を小文字にしたものがキー）
+	otherIndexes map[string]*Index // ほかのセッションの一時テーブルのインデックス
+}
+
+// Open は pg からスキーマを読み込みます。新しいファイルの場合はブートストラップします。
+func Open(pg storage.Pages) (*Catalog, error) {
+	return open(pg, 0)
+}
+
+// open はセッション session から見えるスキーマを pg から読み込みます。
+func open(pg storage.Pages, session int64) (*Catalog, error) {
+	h, err := storage.ReadHeader(pg)
+	if err != nil {
+		return nil, err
+	}
+	if h.NumPages == 0 {
+		if err := bootstrap(pg, h); err != nil {
+			return nil, err
+		}
+	} else if h.NumPages <= indexesRoot {
+		return nil, dberr.Mark(fmt.Errorf("catalog is corrupt: only %d pages", h.NumPages), dberr.ErrCorrupt)
+	}
+	c := &Catalog{
+		pg:      pg,
+		tables:  make(map[string]*Table),
+		indexes: make(map[string]*Index),
+		views:   make(map[string]*View),
+		seqs:    make(map[string]*Sequence),
+		users:   make(map[string]*User),
+		meta:    make(map[string]string),
+		stats:   make(map[int64]*TableStats),
+		nextID:  1,
+
+		session:      session,
+		others:       make(map[string]*Table),
+		otherIndexes: make(map[string]*Index),
+	}
+	if err := c.load(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// bootstrap はファイルヘッダとシステムテーブルを作成します。
+func bootstrap(pg storage.Pages, h storage.FileHeader) error {
+	h.NumPages = 1
+	if err := storage.WriteHeader(pg, h); err != nil {
+		return err
+	}
+	for _, t := range systemTables {
+		hf, err := storage.CreateHeapFile(pg)
+		if err != nil {
+			return err
+		}
+		if hf.Root() != t.Root {
+			return fmt.Errorf("bootstrap: %s was allocated at page %d, want %d", t.Name, hf.Root(), t.Root)
+		}
+	}
+	return nil
+}
+
+// load はシステムテーブルから定義を読み込みます。
+func (c *Catalog) load() error {
+	h, err := storage.ReadHeader(c.pg)
+	if err != nil {
+		return err
+	}
+	c.version = h.SchemaVersion
+	for _, t := range systemTables {
+		c.tables[key(t.Name)] = t
+	}
+
+	byID := make(map[int64]*Table)
+	err = c.scan(tablesRoot, func(_ storage.RID, v []types.Value) error {
+		opts, err := readOptions(v)
+		if err != nil {
+			return fmt.Errorf("table %s: %w", v[1].Text(), err)
+		}
+		t := &Table{ID: v[0].Int(), Name: v[1].Text(), Root: v[2].Int(), Options: opts}
+		for _, def := range lazyTables {
+			if strings.EqualFold(t.Name, def.Name) {
+				t.System, t.Columns = true, def.Columns
+			}
+		}
+		byID[t.ID] = t
+		c.nextID = max(c.nextID, t.ID+1)
+		if session, name, ok := parseTempName(t.Name); ok {
+			t.Temp, t.session = true, session
+			if !c.own(session) {
+				c.others[key(t.Name)] = t
+				return nil
+			}
+			t.Name = name
+		}
+		c.tables[key(t.Name)] = t
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	type col struct {
+		pos int64
+		Column
+	}
+	cols := make(map[int64][]col)
+	err = c.scan(columnsRoot, func(_ storage.RID, v []types.Value) error {
+		typ, err := types.Parse(v[3].Text())
+		if err != nil {
+			return err
+		}
+		var def types.Value
+		if !v[7].IsNull() {
+			if def, _, err = types.DecodeValue(v[7].Blob()); err != nil {
+				return fmt.Errorf("default of column %s: %w", v[2].Text(), err)
+			}
+		}
+		id := v[0].Int()
+		cols[id] = append(cols[id], col{v[1].Int(), Column{
+			Name: v[2].Text(), Type: typ, NotNull: v[4].Bool(), PrimaryKey: v[5].Bool(), AutoIncrement: v[6].Bool(),
+			Default: def,
+		}})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for id, cs := range cols {
+		t := byID[id]
+		if t == nil {
+			return dberr.Mark(fmt.Errorf("catalog is corrupt: columns for unknown table %d", id), dberr.ErrCorrupt)
+		}
+		slices.SortFunc(cs, func(a, b col) int { return int(a.pos - b.pos) })
+		for _, c := range cs {
+			t.Columns = append(t.Columns, c.Column)
+		}
+	}
+	err = c.scan(indexesRoot, func(_ storage.RID, v []types.Value) error {
+		ix := &Index{
+			ID: v[0].Int(), Name: v[1].Text(), Table: v[2].Text(),
+			Columns: strings.Split(v[3].Text(), ","), Unique: v[4].Bool(), Root: v[5].Int(),
+		}
+		c.nextID = max(c.nextID, ix.ID+1)
+		if session, table, ok := parseTempName(ix.Table); ok {
+			ix.session = session
+			if !c.own(session) {
+				c.otherIndexes[key(ix.Name)] = ix
+				return nil
+			}
+			ix.Table = table
+			if _, name, ok := parseTempName(ix.Name); ok {
+				ix.Name = name
+			}
+		}
+		c.indexes[key(ix.Name)] = ix
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if err := c.loadForeignKeys(byID); err != nil {
+		return err
+	}
+	if err := c.loadViews(); err != nil {
+		return err
+	}
+	if err := c.loadMeta(); err != nil {
+		return err
+	}
+	if err := c.loadStats(byID); err != nil {
+		return err
+	}
+	if err := c.loadSequences(); err != nil {
+		return err
+	}
+	return c.loadUsers()
+}
+
+// scan はシステムテーブルの行を読み、デコードして fn に渡します。
+func (c *Catalog) scan(root int64, fn func(storage.RID, []types.Value) error) error {
+	return storage.OpenHeapFile(c.pg, root).Scan(func(rid storage.RID, rec []byte) error {
+		v, err := tuple.Decode(rec)
+		if err != nil {
+			return fmt.Errorf("catalog row %s: %w", rid, err)
+		}
+		want := 0
+		for _, t := range c.tables {
+			if t.System && t.Root == root {
+				want = len(t.Columns)
+			}
+		}
+		if len(v) > want {
+			return fmt.Errorf("catalog row %s has %d values, want %d", rid, len(v), want)
+		}
+		for len(v) < want {
+			v = append(v, types.NullValue())
+		}
+		return fn(rid, v)
+	})
+}
+
+// Version はスキーマの版を返します。スキーマを変更するたびに増えるので、
+// 読み込んだカタログがまだ最新かどうかの判定に使えます。
+func (c *Catalog) Version() uint64 { return c.version }
+
+// Table は名前が name のテーブルを返します（大文字と小文字は区別しません）。
+func (c *Catalog) Table(name string) (*Table, bool) {
+	t, ok := c.tables[key(name)]
+	return t, ok
+}
+
+// Tables はユーザーが作成したテーブルを名前順に返します。このセッションの一時テーブルを含みます。
+func (c *Catalog) Tables() []*Table {
+	var out []*Table
+	for _, t := range c.tables {
+		if !t.System {
+			out = append(out, t)
+		}
+	}
+	slices.SortFunc(out, func(a, b *Table) int { return strings.Compare(a.Name, b.Name) })
+	return out
+}
+
+// AllTables はシステムテーブルと、ほかのセッションの一時テーブル（保存した名前）を含む
+// すべてのテーブルを名前順に返します。
+func (c *Catalog) AllTables() []*Table {
+	out := make([]*Table, 0, len(c.tables)+len(c.others))
+	for _, t := range c.tables {
+		out = append(out, t)
+	}
+	for _, t := range c.others {
+		out = append(out, t)
+	}
+	slices.SortFunc(out, func(a, b *Table) int { return strings.Compare(a.Name, b.Name) })
+	return out
+}
+
+// Index は名前が name のインデックスを返します。
+func (c *Catalog) Index(name string) (*Index, bool) {
+	ix, ok := c.indexes[key(name)]
+	return ix, ok
+}
+
+// Indexes はテーブル table のインデックスを名前順に返します。AllTables が返すほかのセッションの
+// 一時テーブルには、保存した名前でインデックスを返します。
+func (c *Catalog) Indexes(table string) []*Index {
+	var out []*Index
+	for _, ix := range c.indexes {
+		if strings.EqualFold(ix.Table, table) {
+			out = append(out, ix)
+		}
+	}
+	for _, ix := range c.otherIndexes {
+		if strings.EqualFold(ix.Table, table) {
+			out = append(out, ix)
+		}
+	}
+	slices.SortFunc(out, func(a, b *Index) int { return strings.Compare(a.Name, b.Name) })
+	return out
+}
+
+// PrimaryKeyIndex は主キーの一意性を保つために CREATE TABLE が作るインデックスの名前を返します。
+func PrimaryKeyIndex(table string) string {
+	return key(table) + "_pkey"
+}
+
+// IsPrimaryKey は ix がテーブルの主キーのインデックス（PrimaryKeyIndex）かを返します。
+func (c *Catalog) IsPrimaryKey(ix *Index) bool {
+	t, ok := c.tables[key(ix.Table)]
+	if !ok || !ix.Unique || key(ix.Name) != PrimaryKeyIndex(t.Name) {
+		return false
+	}
+	return slices.EqualFunc(ix.Columns, primaryKey(t), strings.EqualFold)
+}
+
+// CreateTable は def の名前、列、外部キーでテーブルを作成し、行を格納するヒープファイルを割り当てます。
+// def.Temp ならこのセッションの一時テーブルにします。
+func (c *Catalog) CreateTable(def Table) (*Table, error) {
+	name, cols := def.Name, def.Columns
+	if strings.HasPrefix(name, SystemPrefix) {
+		return nil, fmt.Errorf("table name %q is reserved", name)
+	}
+	if err := c.checkName(name); err != nil {
+		return nil, err
+	}
+	if def.Temp && c.session == 0 {
+		return nil, ErrNoSession
+	}
+	if !def.Temp {
+		if err := c.checkTempName(name); err != nil {
+			return nil, err
+		}
+	}
+	if len(cols) == 0 {
+		return nil, fmt.Errorf("table %s must have at least one column", name)
+	}
+	cols = slices.Clone(cols)
+	seen := make(map[string]bool)
+	for i, col := range cols {
+		if seen[key(col.Name)] {
+			return nil, fmt.Errorf("duplicate column name: %s", col.Name)
+		}
+		seen[key(col.Name)] = true
+		if err := checkColumn(&cols[i]); err != nil {
+			return nil, err
+		}
+		if def.Temp && col.AutoIncrement {
+			return nil, fmt.Errorf("AUTOINCREMENT is not allowed on a temporary table: %s", col.Name)
+		}
+	}
+	if err := def.Options.check(); err != nil {
+		return nil, err
+	}
+	fks, err := c.checkForeignKeys(&def)
+	if err != nil {
+		return nil, err
+	}
+
+	hf, err := storage.CreateHeapFile(c.pg)
+	if err != nil {
+		return nil, err
+	}
+	t := &Table{ID: c.nextID, Name: name, Columns: cols, ForeignKeys: fks, Root: hf.Root(), Options: def.Options}
+	if def.Temp {
+		t.Temp, t.session = true, c.session
+	}
+	c.nextID++
+	if err := c.insert(tablesRoot, c.tableRow(t)...); err != nil {
+		return nil, err
+	}
+	if err := c.saveColumns(t); err != nil {
+		return nil, err
+	}
+	for _, col := range t.Columns {
+		if col.AutoIncrement {
+			if _, err := c.CreateSequence(SequenceName(name, col.Name)); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := c.saveForeignKeys(t); err != nil {
+		return nil, err
+	}
+	if err := c.bump(); err != nil {
+		return nil, err
+	}
+	c.tables[key(name)] = t
+	return t, nil
+}
+
+// checkColumn は列の定義を検査し、既定値を列の型に変換します。
+func checkColumn(col *Column) error {
+	if col.AutoIncrement && (!col.PrimaryKey || !col.Type.IsInteger()) {
+		return fmt.Errorf("AUTOINCREMENT is only allowed on an integer PRIMARY KEY: %s", col.Name)
+	}
+	def, err := types.Coerce(col.Default, col.Type)
+	if err != nil {
+		return fmt.Errorf("default of column %s: %w", col.Name, err)
+	}
+	col.Default = def
+	return nil
+}
+
+// saveColumns はテーブル t の列の定義を __columns に追加します。
+func (c *Catalog) saveColumns(t *Table) error {
+	for i, col := range t.Columns {
+		def := types.NullValue()
+		if !col.Default.IsNull() {
+			def = types.NewBlob(types.AppendValue(nil, col.Default))
+		}
+		err := c.insert(columnsRoot, types.NewBigInt(t.ID), types.NewBigInt(int64(i)), types.NewText(col.Name),
+			types.NewText(col.Type.String()), types.NewBool(col.NotNull), types.NewBool(col.PrimaryKey),
+			types.NewBool(col.AutoIncrement), def)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DropTable はテーブルを削除し、ヒープファイルのページをすべて解放します。
+// テーブルのインデックスの定義も削除します（インデックスのページの解放は呼び出し側で行います）。
+func (c *Catalog) DropTable(name string) error {
+	t, ok := c.tables[key(name)]
+	if !ok || t.System {
+		return fmt.Errorf("%w: %s", ErrTableNotFound, name)
+	}
+	for _, ref := range c.Referencing(t.Name) {
+		if ref.Table != t {
+			return fmt.Errorf("table %s is referenced by a foreign key of %s", t.Name, ref.Table.Name)
+		}
+	}
+	if err := c.deleteForeignKeys(t); err != nil {
+		return err
+	}
+	if err := c.deleteStats(t); err != nil {
+		return err
+	}
+	for _, ix := range c.Indexes(t.Name) {
+		if err := c.DropIndex(ix.Name); err != nil {
+			return err
+		}
+	}
+	if err := c.delete(tablesRoot, func(v []types.Value) bool { return v[0].Int() == t.ID }); err != nil {
+		return err
+	}
+	if err := c.delete(columnsRoot, func(v []types.Value) bool { return v[0].Int() == t.ID }); err != nil {
+		return err
+	}
+	for _, col := range t.Columns {
+		if col.AutoIncrement {
+			if err := c.DropSequence(SequenceName(t.Name, col.Name)); err != nil {
+				return err
+			}
+		}
+	}
+	if err := storage.OpenHeapFile(c.pg, t.Root).Drop(); err != nil {
+		return err
+	}
+	if err := c.bump(); err != nil {
+		return err
+	}
+	delete(c.tables, key(name))
+	return nil
+}
+
+// CreateIndex はインデックスの定義を追加します。ix.Root は呼び出し側で割り当てておきます。
+func (c *Catalog) CreateIndex(ix *Index) error {
+	if _, ok := c.indexes[key(ix.Name)]; ok {
+		return fmt.Errorf("%w: %s", ErrIndexExists, ix.Name)
+	}
+	t, ok := c.tables[key(ix.Table)]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrTableNotFound, ix.Table)
+	}
+	if !t.Temp {
+		if err := c.checkTempIndexName(ix.Name); err != nil {
+			return err
+		}
+	}
+	for _, name := range ix.Columns {
+		if _, ok := t.Column(name); !ok {
+			return fmt.Errorf("no such column: %s.%s", t.Name, name)
+		}
+	}
+	ix.ID = c.nextID
+	ix.Table = t.Name
+	ix.session = t.session
+	if err := c.saveIndex(ix); err != nil {
+		return err
+	}
+	if err := c.bump(); err != nil {
+		return err
+	}
+	c.nextID++
+	c.indexes[key(ix.Name)] = ix
+	return nil
+}
+
+// saveIndex はインデックスの定義を __indexes に追加します。
+func (c *Catalog) saveIndex(ix *Index) error {
+	name, table := c.indexNames(ix)
+	return c.insert(indexesRoot, types.NewBigInt(ix.ID), types.NewText(name), types.NewText(table),
+		types.NewText(strings.Join(ix.Columns, ",")), types.NewBool(ix.Unique), types.NewBigInt(ix.Root))
+}
+
+// replaceIndex は __indexes にある ix の定義を nix に書き換え、メモリ上の定義を差し替えます。
+func (c *Catalog) replaceIndex(ix, nix *Index) error {
+	if err := c.delete(indexesRoot, func(v []types.Value) bool { return v[0].Int() == ix.ID }); err != nil {
+		return err
+	}
+	if err := c.saveIndex(nix); err != nil {
+		return err
+	}
+	delete(c.indexes, key(ix.Name))
+	c.indexes[key(nix.Name)] = nix
+	return nil
+}
+
+// DropIndex はインデックスの定義を削除します（ページの解放は呼び出し側で行います）。
+func (c *Catalog) DropIndex(name string) error {
+	ix, ok := c.indexes[key(name)]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrIndexNotFound, name)
+	}
+	if err := c.delete(indexesRoot, func(v []types.Value) bool { return v[0].Int() == ix.ID }); err != nil {
+		return err
+	}
+	if err := c.bump(); err != nil {
+		return err
+	}
+	delete(c.indexes, key(name))
+	return nil
+}
+
+// insert はシステムテーブルに1行追加します。
+func (c *Catalog) insert(root int64, vals ...types.Value) error {
+	_, err := storage.OpenHeapFile(c.pg, root).Insert(tuple.Encode(vals))
+	return err
+}
+
+// delete はシステムテーブルから match に一致する行を削除します。
+func (c *Catalog) delete(root int64, match func([]types.Value) bool) error {
+	var rids []storage.RID
+	err := c.scan(root, func(rid storage.RID, v []types.Value) error {
+		if match(v) {
+			rids = append(rids, rid)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	hf := storage.OpenHeapFile(c.pg, root)
+	for _, rid := range rids {
+		if err := hf.Delete(rid); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// bump はスキーマの版を1つ進めます。
+func (c *Catalog) bump() error {
+	h, err := storage.ReadHeader(c.pg)
+	if err != nil {
+		return err
+	}
+	h.SchemaVersion++
+	if err := storage.WriteHeader(c.pg, h); err != nil {
+		return err
+	}
+	c.version = h.SchemaVersion
+	return nil
+}
+
+func key(name string) string { return strings.ToLower(name) }
+
+// lazyTables は必要になったときに作られるシステムテーブルです。
+var lazyTables = []*Table{sequencesTable, foreignKeysTable, viewsTable, metaTable, statisticsTable, usersTable}
+
+// lazyRoot は必要になったときに作られるシステムテーブル def のルートページを返します。
+// まだなければ作成し、__tables に記録します。
+func (c *Catalog) lazyRoot(def *Table) (int64, error) {
+	if t, ok := c.tables[key(def.Name)]; ok {
+		return t.Root, nil
+	}
+	hf, err := storage.CreateHeapFile(c.pg)
+	if err != nil {
+		return 0, err
+	}
+	t := &Table{ID: c.nextID, Name: def.Name, Root: hf.Root(), System: true, Columns: def.Columns}
+	if err := c.insert(tablesRoot, c.tableRow(t)...); err != nil {
+		return 0, err
+	}
+	c.nextID++
+	c.tables[key(t.Name)] = t
+	return t.Root, nil
+}
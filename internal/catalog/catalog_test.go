@@ -0,0 +1,246 @@
+package catalog
+
+import (
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/k-sml/go-rdbms/internal/types"
+)
+
+// memPages はページをメモリに置く storage.Pages です。
+type memPages map[int64][]byte
+
+const testPageSize = 1024
+
+func (m memPages) ReadPage(id int64) ([]byte, error) {
+	buf := make([]byte, testPageSize)
+	copy(buf, m[id])
+	return buf, nil
+}
+
+func (m memPages) WritePage(id int64, buf []byte) error {
+	m[id] = slices.Clone(buf)
+	return nil
+}
+
+func (m memPages) PageSize() int { return testPageSize }
+
+// mustOpen は pg からカタログを開きます。
+func mustOpen(t *testing.T, pg memPages) *Catalog {
+	t.Helper()
+	c, err := Open(pg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// usersDef は試験に使うテーブルの定義です。
+func usersDef(name string) Table {
+	return Table{Name: name, Columns: []Column{
+		{Name: "id", Type: types.BigInt, NotNull: true, PrimaryKey: true},
+		{Name: "name", Type: types.Text},
+	}}
+}
+
+// TestPersist は、テーブルとインデックスの定義が開き直した後も残り、
+// 変更のたびにスキーマの版が進むことを確かめます。
+func TestPersist(t *testing.T) {
+	pg := memPages{}
+	c := mustOpen(t, pg)
+	v := c.Version()
+	for _, name := range []string{"users", "Orders", "tmp"} {
+		if _, err := c.CreateTable(usersDef(name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := c.CreateIndex(&Index{Name: "orders_name", Table: "orders", Columns: []string{"NAME"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.DropTable("tmp"); err != nil {
+		t.Fatal(err)
+	}
+	if c.Version() != v+5 {
+		t.Errorf("Version = %d after 5 changes, want %d", c.Version(), v+5)
+	}
+
+	c = mustOpen(t, pg)
+	if c.Version() != v+5 {
+		t.Errorf("Version = %d after reopening, want %d", c.Version(), v+5)
+	}
+	var names []string
+	for _, tb := range c.Tables() {
+		names = append(names, tb.Name)
+	}
+	if want := []string{"Orders", "users"}; !slices.Equal(names, want) {
+		t.Errorf("Tables = %v, want %v", names, want)
+	}
+	tb, ok := c.Table("ORDERS")
+	if !ok {
+		t.Fatal("table orders not found")
+	}
+	if len(tb.Columns) != 2 || tb.Columns[1].Name != "name" || tb.Columns[1].Type != types.Text || !tb.Columns[0].PrimaryKey {
+		t.Errorf("orders has columns %+v", tb.Columns)
+	}
+	ix, ok := c.Index("orders_name")
+	if !ok {
+		t.Fatal("index orders_name not found")
+	}
+	if ix.Table != "Orders" || !slices.Equal(ix.Columns, []string{"NAME"}) {
+		t.Errorf("index orders_name = %+v", ix)
+	}
+	if _, ok := c.Table("tmp"); ok {
+		t.Error("dropped table tmp still exists")
+	}
+}
+
+// TestCreateTableErrors は、作成できないテーブルの定義を拒否し、カタログを変えないことを確かめます。
+func TestCreateTableErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		def  func() Table
+		want error // nil なら何らかのエラー
+	}{
+		{"exists", func() Table { return usersDef("USERS") }, ErrTableExists},
+		{"reserved", func() Table { return usersDef("__x") }, nil},
+		{"no columns", func() Table { return Table{Name: "x"} }, nil},
+		{"duplicate column", func() Table {
+			d := usersDef("x")
+			d.Columns = append(d.Columns, Column{Name: "ID", Type: types.Int})
+			return d
+		}, nil},
+		{"autoincrement text", func() Table {
+			d := usersDef("x")
+			d.Columns[1].PrimaryKey, d.Columns[1].AutoIncrement = true, true
+			return d
+		}, nil},
+		{"fill factor", func() Table {
+			d := usersDef("x")
+			d.Options.FillFactor = 5
+			return d
+		}, nil},
+		{"compressed columnar", func() Table {
+			d := usersDef("x")
+			d.Options = StorageOptions{Layout: ColumnarLayout, Compression: Deflate}
+			return d
+		}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := mustOpen(t, memPages{})
+			if _, err := c.CreateTable(usersDef("users")); err != nil {
+				t.Fatal(err)
+			}
+			v := c.Version()
+			_, err := c.CreateTable(tt.def())
+			if err == nil || tt.want != nil && !errors.Is(err, tt.want) {
+				t.Fatalf("CreateTable = %v, want %v", err, tt.want)
+			}
+			if c.Version() != v || len(c.Tables()) != 1 {
+				t.Errorf("failed CreateTable changed the catalog")
+			}
+		})
+	}
+}
+
+// TestCreateIndexErrors は、作成できないインデックスの定義を拒否することを確かめます。
+func TestCreateIndexErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		ix   Index
+		want error
+	}{
+		{"exists", Index{Name: "USERS_NAME", Table: "users", Columns: []string{"id"}}, ErrIndexExists},
+		{"no table", Index{Name: "x", Table: "nope", Columns: []string{"id"}}, ErrTableNotFound},
+		{"no column", Index{Name: "x", Table: "users", Columns: []string{"nope"}}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := mustOpen(t, memPages{})
+			if _, err := c.CreateTable(usersDef("users")); err != nil {
+				t.Fatal(err)
+			}
+			if err := c.CreateIndex(&Index{Name: "users_name", Table: "users", Columns: []string{"name"}}); err != nil {
+				t.Fatal(err)
+			}
+			err := c.CreateIndex(&tt.ix)
+			if err == nil || tt.want != nil && !errors.Is(err, tt.want) {
+				t.Fatalf("CreateIndex = %v, want %v", err, tt.want)
+			}
+		})
+	}
+	c := mustOpen(t, memPages{})
+	if err := c.DropIndex("nope"); !errors.Is(err, ErrIndexNotFound) {
+		t.Errorf("DropIndex of a missing index = %v, want ErrIndexNotFound", err)
+	}
+	if err := c.DropTable("nope"); !errors.Is(err, ErrTableNotFound) {
+		t.Errorf("DropTable of a missing table = %v, want ErrTableNotFound", err)
+	}
+}
+
+// TestRename は、テーブルの名前を変えるとインデックスも付いてきて、開き直した後も残ることを確かめます。
+func TestRename(t *testing.T) {
+	pg := memPages{}
+	c := mustOpen(t, pg)
+	for _, name := range []string{"users", "other"} {
+		if _, err := c.CreateTable(usersDef(name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := c.CreateIndex(&Index{Name: "users_name", Table: "users", Columns: []string{"name"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.RenameTable("users", "other"); !errors.Is(err, ErrTableExists) {
+		t.Errorf("RenameTable to an existing name = %v, want ErrTableExists", err)
+	}
+	if err := c.RenameTable("users", "people"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.RenameIndex("users_name", "people_name"); err != nil {
+		t.Fatal(err)
+	}
+
+	c = mustOpen(t, pg)
+	if _, ok := c.Table("users"); ok {
+		t.Error("table users still exists after the rename")
+	}
+	if _, ok := c.Table("people"); !ok {
+		t.Error("table people not found after the rename")
+	}
+	ix, ok := c.Index("people_name")
+	if !ok || ix.Table != "people" {
+		t.Errorf("index people_name = %+v, %v, want one on people", ix, ok)
+	}
+	if len(c.Indexes("people")) != 1 {
+		t.Errorf("people has %d indexes, want 1", len(c.Indexes("people")))
+	}
+}
+
+// TestParseOptions は格納方法の名前の解釈を確かめます。
+func TestParseOptions(t *testing.T) {
+	layouts := map[string]Layout{"row": RowLayout, "COLUMNAR": ColumnarLayout}
+	for s, want := range layouts {
+		if got, err := ParseLayout(s); err != nil || got != want {
+			t.Errorf("ParseLayout(%q) = %v, %v, want %v", s, got, err, want)
+		}
+		if got, _ := ParseLayout(want.String()); got != want {
+			t.Errorf("ParseLayout(%v.String()) = %v", want, got)
+		}
+	}
+	compressions := map[string]Compression{"none": NoCompression, "Deflate": Deflate}
+	for s, want := range compressions {
+		if got, err := ParseCompression(s); err != nil || got != want {
+			t.Errorf("ParseCompression(%q) = %v, %v, want %v", s, got, err, want)
+		}
+		if got, _ := ParseCompression(want.String()); got != want {
+			t.Errorf("ParseCompression(%v.String()) = %v", want, got)
+		}
+	}
+	if _, err := ParseLayout("diagonal"); err == nil {
+		t.Error(`ParseLayout("diagonal") succeeded`)
+	}
+	if _, err := ParseCompression("zip"); err == nil {
+		t.Error(`ParseCompression("zip") succeeded`)
+	}
+}
@@ -0,0 +1,258 @@
+package catalog
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/k-sml/go-rdbms/internal/dberr"
+	"github.com/k-sml/go-rdbms/internal/storage"
+	"github.com/k-sml/go-rdbms/internal/types"
+)
+
+// Action は参照される行が削除・更新されたときに、参照している行をどうするかです。
+type Action uint8
+
+const (
+	// Restrict は参照している行があれば削除・更新をエラーにします。
+	Restrict Action = iota
+	// Cascade は参照している行も削除するか、新しいキーに合わせて更新します。
+	Cascade
+	// SetNull は参照している行の列を NULL にします。
+	SetNull
+)
+
+var actionNames = [...]string{Restrict: "RESTRICT", Cascade: "CASCADE", SetNull: "SET NULL"}
+
+func (a Action) String() string {
+	if int(a) < len(actionNames) {
+		return actionNames[a]
+	}
+	return fmt.Sprintf("Action(%d)", a)
+}
+
+// ParseAction は "CASCADE" や "SET NULL" などの名前を Action にします（大文字と小文字は区別しません）。
+// "NO ACTION" は Restrict として扱います。
+func ParseAction(s string) (Action, error) {
+	s = strings.ToUpper(strings.Join(strings.Fields(s), " "))
+	if s == "NO ACTION" {
+		return Restrict, nil
+	}
+	for a, name := range actionNames {
+		if s == name {
+			return Action(a), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown referential action: %q", s)
+}
+
+// ForeignKey は外部キー制約です。Columns の値の組は、NULL を含まない限り
+// RefTable のいずれかの行の RefColumns の値の組と一致しなければなりません。
+type ForeignKey struct {
+	Columns    []string
+	RefTable   string
+	RefColumns []string // 省略すると参照先の主キー
+	OnDelete   Action
+	OnUpdate   Action
+	// Deferred は制約の検査をコミットまで遅らせます。トランザクションの途中では
+	// 参照先のない行があってもよく、コミットの時点で満たされていればエラーになりません。
+	Deferred bool
+}
+
+// Reference はテーブルを参照している外部キーと、その外部キーを持つテーブルです。
+type Reference struct {
+	Table      *Table
+	ForeignKey ForeignKey
+}
+
+// foreignKeysTable は外部キーを保存するシステムテーブルの定義です。
+// 列の並びはカンマ区切りで保存します。
+var foreignKeysTable = &Table{Name: "__foreign_keys", System: true, Columns: []Column{
+	{Name: "table_id", Type: types.BigInt}, {Name: "position", Type: types.BigInt}, {Name: "columns", Type: types.Text},
+	{Name: "ref_table", Type: types.Text}, {Name: "ref_columns", Type: types.Text},
+	{Name: "on_delete", Type: types.Text}, {Name: "on_update", Type: types.Text}, {Name: "deferred", Type: types.Boolean},
+}}
+
+// Referencing は名前が table のテーブルを参照している外部キーを返します。
+// テーブル自身を参照する外部キーも含みます。
+func (c *Catalog) Referencing(table string) []Reference {
+	var out []Reference
+	for _, t := range c.tables {
+		for _, fk := range t.ForeignKeys {
+			if strings.EqualFold(fk.RefTable, table) {
+				out = append(out, Reference{Table: t, ForeignKey: fk})
+			}
+		}
+	}
+	slices.SortFunc(out, func(a, b Reference) int { return strings.Compare(a.Table.Name, b.Table.Name) })
+	return out
+}
+
+// checkForeignKeys は作成しようとしているテーブル def の外部キーを検査し、
+// 列名を定義どおりの綴りにそろえ、省略された参照先の列を補ったものを返します。
+//
+// 参照先の列は主キーか、一意インデックスの列でなければなりません（参照先の行は
+// そのインデックスで探すため。主キーのインデックスは CREATE TABLE が作ります）。
+func (c *Catalog) checkForeignKeys(def *Table) ([]ForeignKey, error) {
+	var out []ForeignKey
+	for _, fk := range def.ForeignKeys {
+		ref := def
+		if !strings.EqualFold(fk.RefTable, def.Name) {
+			t, ok := c.tables[key(fk.RefTable)]
+			if !ok || t.System {
+				return nil, fmt.Errorf("%w: %s", ErrTableNotFound, fk.RefTable)
+			}
+			ref = t
+		}
+		if def.Temp || ref.Temp {
+			return nil, fmt.Errorf("temporary tables cannot use foreign keys: %s references %s", def.Name, ref.Name)
+		}
+		fk.RefTable = ref.Name
+		if len(fk.RefColumns) == 0 {
+			fk.RefColumns = primaryKey(ref)
+			if len(fk.RefColumns) == 0 {
+				return nil, fmt.Errorf("foreign key references %s, which has no primary key", ref.Name)
+			}
+		}
+		if len(fk.Columns) == 0 || len(fk.Columns) != len(fk.RefColumns) {
+			return nil, fmt.Errorf("foreign key on %s has %d columns but references %d columns of %s",
+				def.Name, len(fk.Columns), len(fk.RefColumns), ref.Name)
+		}
+		fk.Columns, fk.RefColumns = slices.Clone(fk.Columns), slices.Clone(fk.RefColumns)
+		for i := range fk.Columns {
+			ci, ok := def.Column(fk.Columns[i])
+			if !ok {
+				return nil, fmt.Errorf("no such column: %s.%s", def.Name, fk.Columns[i])
+			}
+			ri, ok := ref.Column(fk.RefColumns[i])
+			if !ok {
+				return nil, fmt.Errorf("no such column: %s.%s", ref.Name, fk.RefColumns[i])
+			}
+			col, refCol := def.Columns[ci], ref.Columns[ri]
+			if _, ok := types.Common(col.Type, refCol.Type); !ok {
+				return nil, fmt.Errorf("foreign key column %s.%s (%s) cannot reference %s.%s (%s)",
+					def.Name, col.Name, col.Type, ref.Name, refCol.Name, refCol.Type)
+			}
+			if (fk.OnDelete == SetNull || fk.OnUpdate == SetNull) && (col.NotNull || col.PrimaryKey) {
+				return nil, fmt.Errorf("SET NULL action on NOT NULL column %s.%s", def.Name, col.Name)
+			}
+			fk.Columns[i], fk.RefColumns[i] = col.Name, refCol.Name
+		}
+		if !c.isKey(ref, fk.RefColumns) {
+			return nil, fmt.Errorf("foreign key references %s(%s), which is not a primary key or unique index",
+				ref.Name, strings.Join(fk.RefColumns, ", "))
+		}
+		out = append(out, fk)
+	}
+	return out, nil
+}
+
+// primaryKey は t の主キーの列名を返します。
+func primaryKey(t *Table) []string {
+	var out []string
+	for _, col := range t.Columns {
+		if col.PrimaryKey {
+			out = append(out, col.Name)
+		}
+	}
+	return out
+}
+
+// isKey は cols の組が t の主キーか、一意インデックスの列と一致するかを返します（順序は問いません）。
+func (c *Catalog) isKey(t *Table, cols []string) bool {
+	same := func(a []string) bool {
+		if len(a) != len(cols) {
+			return false
+		}
+		for _, name := range a {
+			if !slices.ContainsFunc(cols, func(s string) bool { return strings.EqualFold(s, name) }) {
+				return false
+			}
+		}
+		return true
+	}
+	if same(primaryKey(t)) {
+		return true
+	}
+	for _, ix := range c.Indexes(t.Name) {
+		if ix.Unique && same(ix.Columns) {
+			return true
+		}
+	}
+	return false
+}
+
+// loadForeignKeys は __foreign_keys から外部キーを読み込み、テーブルに設定します。
+func (c *Catalog) loadForeignKeys(byID map[int64]*Table) error {
+	ft, ok := c.tables[key(foreignKeysTable.Name)]
+	if !ok {
+		return nil
+	}
+	type fk struct {
+		pos int64
+		ForeignKey
+	}
+	fks := make(map[int64][]fk)
+	err := c.scan(ft.Root, func(_ storage.RID, v []types.Value) error {
+		onDelete, err := ParseAction(v[5].Text())
+		if err != nil {
+			return err
+		}
+		onUpdate, err := ParseAction(v[6].Text())
+		if err != nil {
+			return err
+		}
+		id := v[0].Int()
+		fks[id] = append(fks[id], fk{v[1].Int(), ForeignKey{
+			Columns: strings.Split(v[2].Text(), ","), RefTable: v[3].Text(), RefColumns: strings.Split(v[4].Text(), ","),
+			OnDelete: onDelete, OnUpdate: onUpdate, Deferred: v[7].Bool(),
+		}})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for id, list := range fks {
+		t := byID[id]
+		if t == nil {
+			return dberr.Mark(fmt.Errorf("catalog is corrupt: foreign keys for unknown table %d", id), dberr.ErrCorrupt)
+		}
+		slices.SortFunc(list, func(a, b fk) int { return int(a.pos - b.pos) })
+		for _, f := range list {
+			t.ForeignKeys = append(t.ForeignKeys, f.ForeignKey)
+		}
+	}
+	return nil
+}
+
+// saveForeignKeys はテーブル t の外部キーを __foreign_keys に追加します。
+func (c *Catalog) saveForeignKeys(t *Table) error {
+	if len(t.ForeignKeys) == 0 {
+		return nil
+	}
+	root, err := c.lazyRoot(foreignKeysTable)
+	if err != nil {
+		return err
+	}
+	for i, fk := range t.ForeignKeys {
+		err := c.insert(root, types.NewBigInt(t.ID), types.NewBigInt(int64(i)), types.NewText(strings.Join(fk.Columns, ",")),
+			types.NewText(fk.RefTable), types.NewText(strings.Join(fk.RefColumns, ",")),
+			types.NewText(fk.OnDelete.String()), types.NewText(fk.OnUpdate.String()), types.NewBool(fk.Deferred))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteForeignKeys はテーブル t の外部キーを __foreign_keys から削除します。
+func (c *Catalog) deleteForeignKeys(t *Table) error {
+	if len(t.ForeignKeys) == 0 {
+		return nil
+	}
+	root, err := c.lazyRoot(foreignKeysTable)
+	if err != nil {
+		return err
+	}
+	return c.delete(root, func(v []types.Value) bool { return v[0].Int() == t.ID })
+}
@@ -0,0 +1,65 @@
+package catalog
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/k-sml/go-rdbms/internal/dberr"
+	"github.com/k-sml/go-rdbms/internal/storage"
+	"github.com/k-sml/go-rdbms/internal/types"
+)
+
+// metaTable はデータベース全体の設定を名前と値の組で保存するシステムテーブルの定義です。
+var metaTable = &Table{Name: "__meta", System: true, Columns: []Column{
+	{Name: "name", Type: types.Text}, {Name: "value", Type: types.Text},
+}}
+
+// userVersionKey はアプリケーションが管理するスキーマの版を保存する名前です。
+const userVersionKey = "user_version"
+
+// loadMeta は __meta から設定を読み込みます。
+func (c *Catalog) loadMeta() error {
+	t, ok := c.tables[key(metaTable.Name)]
+	if !ok {
+		return nil
+	}
+	return c.scan(t.Root, func(_ storage.RID, v []types.Value) error {
+		c.meta[v[0].Text()] = v[1].Text()
+		return nil
+	})
+}
+
+// setMeta は設定 name の値を value にします。
+func (c *Catalog) setMeta(name, value string) error {
+	root, err := c.lazyRoot(metaTable)
+	if err != nil {
+		return err
+	}
+	if err := c.delete(root, func(v []types.Value) bool { return v[0].Text() == name }); err != nil {
+		return err
+	}
+	if err := c.insert(root, types.NewText(name), types.NewText(value)); err != nil {
+		return err
+	}
+	c.meta[name] = value
+	return nil
+}
+
+// UserVersion はアプリケーションが管理するスキーマの版を返します。設定していなければ 0 です。
+// Version と違い、DDL を実行しても変わりません。
+func (c *Catalog) UserVersion() (int64, error) {
+	s, ok := c.meta[userVersionKey]
+	if !ok {
+		return 0, nil
+	}
+	v, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, dberr.Mark(fmt.Errorf("catalog is corrupt: %s = %q", userVersionKey, s), dberr.ErrCorrupt)
+	}
+	return v, nil
+}
+
+// SetUserVersion はアプリケーションが管理するスキーマの版を v にします。
+func (c *Catalog) SetUserVersion(v int64) error {
+	return c.setMeta(userVersionKey, strconv.FormatInt(v, 10))
+}
@@ -0,0 +1,125 @@
+package catalog
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/k-sml/go-rdbms/internal/storage"
+	"github.com/k-sml/go-rdbms/internal/types"
+)
+
+// Layout はテーブルの行の並べ方です。
+type Layout uint8
+
+const (
+	// RowLayout は行ごとに値をまとめて格納します。
+	RowLayout Layout = iota
+	// ColumnarLayout はページの中で列ごとに値をまとめて格納します（storage.ColumnPage）。
+	// 一部の列だけを読む走査では、ほかの列の値をデコードせずに済みます。
+	ColumnarLayout
+)
+
+func (l Layout) String() string {
+	if l == ColumnarLayout {
+		return "columnar"
+	}
+	return "row"
+}
+
+// Compression はテーブルの行の圧縮方式です。
+type Compression uint8
+
+const (
+	// NoCompression は圧縮しません。
+	NoCompression Compression = iota
+	// Deflate は行ごとに DEFLATE で圧縮します。
+	Deflate
+)
+
+func (c Compression) String() string {
+	if c == Deflate {
+		return "deflate"
+	}
+	return "none"
+}
+
+// StorageOptions はテーブルの格納方法です。テーブルの作成時に決め、後から変更できません。
+// ゼロ値は既定の設定（フィルファクタ 100、行形式、圧縮なし）です。
+type StorageOptions struct {
+	FillFactor  int  // 挿入でページを埋める割合（10〜100、0 なら 100）
+	AppendOnly  bool // 行の削除と更新を禁止する
+	Layout      Layout
+	Compression Compression
+}
+
+// ParseLayout は "row" または "columnar" を Layout にします。
+func ParseLayout(s string) (Layout, error) {
+	switch strings.ToLower(s) {
+	case "row":
+		return RowLayout, nil
+	case "columnar":
+		return ColumnarLayout, nil
+	}
+	return 0, fmt.Errorf("unknown table layout: %q", s)
+}
+
+// ParseCompression は "none" または "deflate" を Compression にします。
+// 列形式のテーブルは圧縮できません（ColumnarLayout と Deflate は一緒に指定するとエラーになります）。
+func ParseCompression(s string) (Compression, error) {
+	switch strings.ToLower(s) {
+	case "none":
+		return NoCompression, nil
+	case "deflate":
+		return Deflate, nil
+	}
+	return 0, fmt.Errorf("unknown compression: %q", s)
+}
+
+// check は設定が正しいかを調べます。
+func (o StorageOptions) check() error {
+	if o.FillFactor != 0 && (o.FillFactor < 10 || o.FillFactor > 100) {
+		return fmt.Errorf("fill factor must be between 10 and 100: %d", o.FillFactor)
+	}
+	// 列形式のページは値を列ごとに並べてその場で書き換えるので、行ごとに圧縮したレコードは格納できない
+	if o.Layout == ColumnarLayout && o.Compression != NoCompression {
+		return fmt.Errorf("compression cannot be combined with the columnar layout")
+	}
+	return nil
+}
+
+// HeapOptions はテーブルのヒープファイルを開くときの設定を返します。
+func (t *Table) HeapOptions() storage.HeapOptions {
+	return storage.HeapOptions{
+		FillFactor: t.Options.FillFactor,
+		AppendOnly: t.Options.AppendOnly,
+		Compress:   t.Options.Compression == Deflate,
+		Columnar:   t.Options.Layout == ColumnarLayout,
+	}
+}
+
+// tableRow は __tables に保存するテーブル t の行を返します。
+func (c *Catalog) tableRow(t *Table) []types.Value {
+	o := t.Options
+	return []types.Value{
+		types.NewBigInt(t.ID), types.NewText(c.StoredName(t)), types.NewBigInt(t.Root),
+		types.NewBigInt(int64(o.FillFactor)), types.NewBool(o.AppendOnly),
+		types.NewText(o.Layout.String()), types.NewText(o.Compression.String()),
+	}
+}
+
+// readOptions は __tables の行から格納方法を読みます。列を追加する前の行の NULL は既定値として扱います。
+func readOptions(v []types.Value) (StorageOptions, error) {
+	o := StorageOptions{FillFactor: int(v[3].Int()), AppendOnly: v[4].Bool()}
+	var err error
+	if !v[5].IsNull() {
+		if o.Layout, err = ParseLayout(v[5].Text()); err != nil {
+			return o, err
+		}
+	}
+	if !v[6].IsNull() {
+		if o.Compression, err = ParseCompression(v[6].Text()); err != nil {
+			return o, err
+		}
+	}
+	return o, nil
+}
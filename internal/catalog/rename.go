@@ -0,0 +1,116 @@
+package catalog
+
+import (
+	"fmt"
+	"strings"
+)
+
+// RenameTable はテーブルの名前を変更します。ページはそのままで、定義の中の名前だけを書き換えます。
+// テーブルのインデックス、外部キー（他のテーブルからの参照も含む）、AUTOINCREMENT の
+// シーケンス、主キーのインデックスの名前も合わせて変更します。ビューの問い合わせは書き換えないので、
+// テーブルを使うビューがないことは呼び出し側（engine.Tx.RenameTable）で確かめます。
+func (c *Catalog) RenameTable(name, to string) error {
+	t, err := c.userTable(name)
+	if err != nil {
+		return err
+	}
+	if strings.HasPrefix(to, SystemPrefix) {
+		return fmt.Errorf("table name %q is reserved", to)
+	}
+	if !strings.EqualFold(name, to) {
+		if err := c.checkName(to); err != nil {
+			return err
+		}
+	}
+	if !t.Temp {
+		if err := c.checkTempName(to); err != nil {
+			return err
+		}
+	}
+
+	if ix, ok := c.indexes[key(PrimaryKeyIndex(t.Name))]; ok && c.IsPrimaryKey(ix) {
+		if other, ok := c.indexes[key(PrimaryKeyIndex(to))]; ok && other != ix {
+			return fmt.Errorf("%w: %s", ErrIndexExists, other.Name)
+		}
+	}
+
+	nt := t.clone()
+	nt.Name = to
+	for i, fk := range nt.ForeignKeys {
+		if strings.EqualFold(fk.RefTable, t.Name) {
+			nt.ForeignKeys[i].RefTable = to
+		}
+	}
+	for _, col := range t.Columns {
+		if col.AutoIncrement {
+			if err := c.renameSequence(SequenceName(t.Name, col.Name), SequenceName(to, col.Name)); err != nil {
+				return err
+			}
+		}
+	}
+	for _, ix := range c.Indexes(t.Name) {
+		nix := *ix
+		nix.Table = to
+		if c.IsPrimaryKey(ix) {
+			nix.Name = PrimaryKeyIndex(to)
+		}
+		if err := c.replaceIndex(ix, &nix); err != nil {
+			return err
+		}
+	}
+	for _, ref := range c.Referencing(t.Name) {
+		if ref.Table == t {
+			continue
+		}
+		nr := ref.Table.clone()
+		for i, fk := range nr.ForeignKeys {
+			if strings.EqualFold(fk.RefTable, t.Name) {
+				nr.ForeignKeys[i].RefTable = to
+			}
+		}
+		if err := c.deleteForeignKeys(ref.Table); err != nil {
+			return err
+		}
+		if err := c.saveForeignKeys(nr); err != nil {
+			return err
+		}
+		c.tables[key(nr.Name)] = nr
+	}
+	if err := c.deleteForeignKeys(t); err != nil {
+		return err
+	}
+	if err := c.saveForeignKeys(nt); err != nil {
+		return err
+	}
+	if err := c.replaceTableRow(nt); err != nil {
+		return err
+	}
+	if err := c.bump(); err != nil {
+		return err
+	}
+	delete(c.tables, key(t.Name))
+	c.tables[key(to)] = nt
+	return nil
+}
+
+// RenameIndex はインデックスの名前を変更します。インデックスのページはそのままです。
+func (c *Catalog) RenameIndex(name, to string) error {
+	ix, ok := c.indexes[key(name)]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrIndexNotFound, name)
+	}
+	if other, ok := c.indexes[key(to)]; ok && other != ix {
+		return fmt.Errorf("%w: %s", ErrIndexExists, to)
+	}
+	if !c.own(ix.session) {
+		if err := c.checkTempIndexName(to); err != nil {
+			return err
+		}
+	}
+	nix := *ix
+	nix.Name = to
+	if err := c.replaceIndex(ix, &nix); err != nil {
+		return err
+	}
+	return c.bump()
+}
@@ -0,0 +1,116 @@
+package catalog
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/k-sml/go-rdbms/internal/storage"
+	"github.com/k-sml/go-rdbms/internal/types"
+)
+
+var (
+	// ErrSequenceExists は同じ名前のシーケンスがすでにある場合に返されます。
+	ErrSequenceExists = errors.New("sequence already exists")
+	// ErrSequenceNotFound はシーケンスが見つからない場合に返されます。
+	ErrSequenceNotFound = errors.New("no such sequence")
+)
+
+// sequencesTable はシーケンスを保存するシステムテーブルの定義です。
+var sequencesTable = &Table{Name: "__sequences", System: true, Columns: []Column{
+	{Name: "name", Type: types.Text}, {Name: "value", Type: types.BigInt},
+}}
+
+// Sequence は整数の通し番号を払い出すシーケンスです。
+type Sequence struct {
+	Name string
+	// Value は払い出し済みとして記録された最大の値（high-water mark）です。
+	// 次に払い出す値はこれより大きくなります。
+	Value int64
+}
+
+// SequenceName は AUTOINCREMENT の列が使うシーケンスの名前を返します。
+func SequenceName(table, column string) string {
+	return key(table) + "_" + key(column) + "_seq"
+}
+
+// loadSequences は __sequences からシーケンスを読み込みます。
+func (c *Catalog) loadSequences() error {
+	t, ok := c.tables[key(sequencesTable.Name)]
+	if !ok {
+		return nil
+	}
+	return c.scan(t.Root, func(_ storage.RID, v []types.Value) error {
+		s := &Sequence{Name: v[0].Text(), Value: v[1].Int()}
+		c.seqs[key(s.Name)] = s
+		return nil
+	})
+}
+
+// sequencesRoot は __sequences のルートページを返します。まだなければ作成します。
+func (c *Catalog) sequencesRoot() (int64, error) { return c.lazyRoot(sequencesTable) }
+
+// Sequence は名前が name のシーケンスを返します。
+func (c *Catalog) Sequence(name string) (*Sequence, bool) {
+	s, ok := c.seqs[key(name)]
+	return s, ok
+}
+
+// CreateSequence は値が 0 のシーケンスを作成します（最初に払い出す値は 1 です）。
+func (c *Catalog) CreateSequence(name string) (*Sequence, error) {
+	if _, ok := c.seqs[key(name)]; ok {
+		return nil, fmt.Errorf("%w: %s", ErrSequenceExists, name)
+	}
+	root, err := c.sequencesRoot()
+	if err != nil {
+		return nil, err
+	}
+	if err := c.insert(root, types.NewText(name), types.NewBigInt(0)); err != nil {
+		return nil, err
+	}
+	if err := c.bump(); err != nil {
+		return nil, err
+	}
+	s := &Sequence{Name: name}
+	c.seqs[key(name)] = s
+	return s, nil
+}
+
+// DropSequence はシーケンスを削除します。
+func (c *Catalog) DropSequence(name string) error {
+	if _, ok := c.seqs[key(name)]; !ok {
+		return fmt.Errorf("%w: %s", ErrSequenceNotFound, name)
+	}
+	root, err := c.sequencesRoot()
+	if err != nil {
+		return err
+	}
+	if err := c.delete(root, func(v []types.Value) bool { return key(v[0].Text()) == key(name) }); err != nil {
+		return err
+	}
+	if err := c.bump(); err != nil {
+		return err
+	}
+	delete(c.seqs, key(name))
+	return nil
+}
+
+// SetSequence はシーケンスの high-water mark を value に更新します。
+// スキーマの変更ではないので、スキーマの版は変わりません。
+func (c *Catalog) SetSequence(name string, value int64) error {
+	s, ok := c.seqs[key(name)]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrSequenceNotFound, name)
+	}
+	root, err := c.sequencesRoot()
+	if err != nil {
+		return err
+	}
+	if err := c.delete(root, func(v []types.Value) bool { return key(v[0].Text()) == key(name) }); err != nil {
+		return err
+	}
+	if err := c.insert(root, types.NewText(s.Name), types.NewBigInt(value)); err != nil {
+		return err
+	}
+	s.Value = value
+	return nil
+}
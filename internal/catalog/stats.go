@@ -0,0 +1,123 @@
+package catalog
+
+import (
+	"fmt"
+
+	"github.com/k-sml/go-rdbms/internal/dberr"
+	"github.com/k-sml/go-rdbms/internal/storage"
+	"github.com/k-sml/go-rdbms/internal/tuple"
+	"github.com/k-sml/go-rdbms/internal/types"
+)
+
+// statisticsTable は ANALYZE で集めた統計情報を保存するシステムテーブルの定義です。
+// テーブルの列ごとに1行で、rows と pages はテーブルの値なので、どの列の行にも同じ値を入れます。
+// histogram はヒストグラムの境界の値を並べた行のエンコードです。
+var statisticsTable = &Table{Name: "__statistics", System: true, Columns: []Column{
+	{Name: "table_id", Type: types.BigInt}, {Name: "position", Type: types.BigInt},
+	{Name: "rows", Type: types.BigInt}, {Name: "pages", Type: types.BigInt},
+	{Name: "null_frac", Type: types.Real}, {Name: "distinct", Type: types.Real}, {Name: "histogram", Type: types.Blob},
+	{Name: "correlation", Type: types.Real},
+}}
+
+// TableStats はテーブルの統計情報です。実行計画を選ぶときに、読む行の数や費用を見積もるのに使います。
+type TableStats struct {
+	Rows    int64         // 行の数
+	Pages   int64         // ヒープファイルのデータページの数
+	Columns []ColumnStats // 列の順の統計情報。集めていなければ nil
+}
+
+// ColumnStats は列の値の統計情報です。
+type ColumnStats struct {
+	NullFrac float64 // 値が NULL の行の割合
+	Distinct float64 // NULL でない値の種類の数。わからなければ 0
+	// Histogram は NULL でない値を、同じ数の行を含む区間に分ける境界の値です（昇順）。
+	// 最初と最後は最小値と最大値で、区間は len(Histogram)-1 個あります。
+	Histogram []types.Value
+	// Correlation は行のファイルの中の順と値の順との相関係数（-1 から 1）です。1 に近いほど、
+	// 値の範囲の行が少ないページにまとまっています。集める前に保存した統計情報では 0 です。
+	Correlation float64
+}
+
+// Column は i 番目の列の統計情報を返します。集めていなければ nil です。
+func (s *TableStats) Column(i int) *ColumnStats {
+	if s == nil || i < 0 || i >= len(s.Columns) {
+		return nil
+	}
+	return &s.Columns[i]
+}
+
+// loadStats は __statistics から統計情報を読み込みます。
+func (c *Catalog) loadStats(byID map[int64]*Table) error {
+	t, ok := c.tables[key(statisticsTable.Name)]
+	if !ok {
+		return nil
+	}
+	return c.scan(t.Root, func(_ storage.RID, v []types.Value) error {
+		id, pos := v[0].Int(), v[1].Int()
+		t := byID[id]
+		if t == nil || pos < 0 || pos >= int64(len(t.Columns)) {
+			return dberr.Mark(fmt.Errorf("catalog is corrupt: statistics for unknown column %d of table %d", pos, id), dberr.ErrCorrupt)
+		}
+		hist, err := tuple.Decode(v[6].Blob())
+		if err != nil {
+			return fmt.Errorf("statistics of %s.%s: %w", t.Name, t.Columns[pos].Name, err)
+		}
+		s := c.stats[id]
+		if s == nil {
+			s = &TableStats{Rows: v[2].Int(), Pages: v[3].Int()}
+			c.stats[id] = s
+		}
+		// 行は列の順に並んでいるとは限らない
+		for int64(len(s.Columns)) <= pos {
+			s.Columns = append(s.Columns, ColumnStats{})
+		}
+		s.Columns[pos] = ColumnStats{NullFrac: v[4].Real(), Distinct: v[5].Real(), Histogram: hist, Correlation: v[7].Real()}
+		return nil
+	})
+}
+
+// Stats はテーブル t について ANALYZE で集めた統計情報を返します。集めていなければ nil です。
+func (c *Catalog) Stats(t *Table) *TableStats { return c.stats[t.ID] }
+
+// SetStats はテーブル t の統計情報を s にします。実行計画を作り直させるため、スキーマの版を進めます。
+func (c *Catalog) SetStats(t *Table, s *TableStats) error {
+	if t.System {
+		return fmt.Errorf("cannot analyze system table %s", t.Name)
+	}
+	root, err := c.lazyRoot(statisticsTable)
+	if err != nil {
+		return err
+	}
+	if err := c.delete(root, func(v []types.Value) bool { return v[0].Int() == t.ID }); err != nil {
+		return err
+	}
+	for i, cs := range s.Columns {
+		err := c.insert(root, types.NewBigInt(t.ID), types.NewBigInt(int64(i)), types.NewBigInt(s.Rows),
+			types.NewBigInt(s.Pages), types.NewReal(cs.NullFrac), types.NewReal(cs.Distinct),
+			types.NewBlob(tuple.Encode(cs.Histogram)), types.NewReal(cs.Correlation))
+		if err != nil {
+			return err
+		}
+	}
+	if err := c.bump(); err != nil {
+		return err
+	}
+	c.stats[t.ID] = s
+	return nil
+}
+
+// deleteStats はテーブル t の統計情報を削除します。
+func (c *Catalog) deleteStats(t *Table) error {
+	if _, ok := c.stats[t.ID]; !ok {
+		return nil
+	}
+	root, err := c.lazyRoot(statisticsTable)
+	if err != nil {
+		return err
+	}
+	if err := c.delete(root, func(v []types.Value) bool { return v[0].Int() == t.ID }); err != nil {
+		return err
+	}
+	delete(c.stats, t.ID)
+	return nil
+}
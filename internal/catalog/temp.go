@@ -0,0 +1,127 @@
+package catalog
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/k-sml/go-rdbms/internal/storage"
+)
+
+// 一時テーブル
+//
+// 一時テーブルは1つのセッションの中だけで見えるテーブルで、セッションを閉じると削除する。
+// 定義とページはふつうのテーブルと同じくデータベースファイルに置き、システムテーブルには
+// "__temp<セッションの番号>.<名前>" という名前で保存する（インデックスも同じ）。OpenSession で
+// セッションのカタログを読むと、そのセッションの一時テーブルは元の名前で見える。ほかのセッションの
+// 一時テーブルは AllTables にだけ保存した名前で現れ、名前では引けない。
+//
+// 一時テーブルの名前はほかのセッションの一時テーブルと同じでもよいが、ふつうのテーブル、ビュー、
+// インデックスの名前は、どのセッションの一時テーブルやそのインデックスとも同じにできない
+// （そのセッションが読み込んだときに名前がぶつかるため）。シーケンスと外部キーは名前で
+// 保存するので、一時テーブルには AUTOINCREMENT の列と外部キーを使えない。
+
+// tempPrefix は一時テーブルとそのインデックスを保存する名前の接頭辞です。
+const tempPrefix = SystemPrefix + "temp"
+
+// ErrNoSession はセッションの外で一時テーブルを作ろうとした場合に返されます。
+var ErrNoSession = errors.New("temporary tables can only be created in a session")
+
+// OpenSession はセッション session から見えるスキーマを pg から読み込みます。セッションの番号は
+// 1 以上で、0 なら Open と同じく一時テーブルを持たないカタログになります。
+func OpenSession(pg storage.Pages, session int64) (*Catalog, error) {
+	return open(pg, session)
+}
+
+// tempName はセッション session の一時テーブルかインデックスの name を保存する名前を返します。
+func tempName(session int64, name string) string {
+	return tempPrefix + strconv.FormatInt(session, 10) + "." + name
+}
+
+// parseTempName は保存した名前 stored がセッションの一時テーブルかインデックスの名前なら、
+// セッションの番号と元の名前を返します。
+func parseTempName(stored string) (int64, string, bool) {
+	rest, ok := strings.CutPrefix(key(stored), tempPrefix)
+	if !ok {
+		return 0, "", false
+	}
+	num, _, ok := strings.Cut(rest, ".")
+	if !ok {
+		return 0, "", false
+	}
+	session, err := strconv.ParseInt(num, 10, 64)
+	if err != nil || session <= 0 {
+		return 0, "", false
+	}
+	return session, stored[len(tempPrefix)+len(num)+1:], true
+}
+
+// own は session がこのカタログのセッションかを返します。
+func (c *Catalog) own(session int64) bool { return session != 0 && session == c.session }
+
+// StoredName はテーブル t をシステムテーブルに保存する名前を返します。このセッションの一時テーブルなら
+// 接頭辞を付けた名前で、ほかのテーブルは t.Name です。
+func (c *Catalog) StoredName(t *Table) string {
+	if c.own(t.session) {
+		return tempName(t.session, t.Name)
+	}
+	return t.Name
+}
+
+// indexNames はインデックス ix をシステムテーブルに保存する名前と、テーブルの名前を返します。
+func (c *Catalog) indexNames(ix *Index) (string, string) {
+	if c.own(ix.session) {
+		return tempName(ix.session, ix.Name), tempName(ix.session, ix.Table)
+	}
+	return ix.Name, ix.Table
+}
+
+// HasTemp はこのセッションの一時テーブルがあるかを返します。
+func (c *Catalog) HasTemp() bool {
+	if c.session == 0 {
+		return false
+	}
+	for _, t := range c.tables {
+		if t.Temp {
+			return true
+		}
+	}
+	return false
+}
+
+// TempSessions はほかのセッションの一時テーブルを持つセッションの番号を小さい順に返します。
+// 閉じずに終わったセッションの一時テーブルを見つけるのに使います。
+func (c *Catalog) TempSessions() []int64 {
+	var out []int64
+	for _, t := range c.others {
+		if !slices.Contains(out, t.session) {
+			out = append(out, t.session)
+		}
+	}
+	slices.Sort(out)
+	return out
+}
+
+// checkTempName は、name をふつうのテーブルやビューの名前にすると、ほかのセッションの一時テーブルと
+// ぶつかるかを調べます。
+func (c *Catalog) checkTempName(name string) error {
+	for k := range c.others {
+		if _, n, _ := parseTempName(k); n == key(name) {
+			return fmt.Errorf("%w: %s is used by a temporary table of another session", ErrTableExists, name)
+		}
+	}
+	return nil
+}
+
+// checkTempIndexName は、name をふつうのインデックスの名前にすると、ほかのセッションの一時テーブルの
+// インデックスとぶつかるかを調べます。
+func (c *Catalog) checkTempIndexName(name string) error {
+	for k := range c.otherIndexes {
+		if _, n, _ := parseTempName(k); n == key(name) {
+			return fmt.Errorf("%w: %s is used by a temporary table of another session", ErrIndexExists, name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,165 @@
+package catalog
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/k-sml/go-rdbms/internal/dberr"
+	"github.com/k-sml/go-rdbms/internal/storage"
+	"github.com/k-sml/go-rdbms/internal/types"
+)
+
+var (
+	// ErrUserExists は同じ名前のユーザーがすでにある場合に返されます。
+	ErrUserExists = errors.New("user already exists")
+	// ErrUserNotFound はユーザーが見つからない場合に返されます。
+	ErrUserNotFound = errors.New("no such user")
+)
+
+// usersTable はサーバーに接続するユーザーを保存するシステムテーブルの定義です。password は
+// パスワードそのものではなく、ソルトを付けたハッシュです。
+var usersTable = &Table{Name: "__users", System: true, Columns: []Column{
+	{Name: "name", Type: types.Text}, {Name: "password", Type: types.Text}, {Name: "access", Type: types.Text},
+}}
+
+// Hidden は SQL の文から読めないシステムテーブルかを返します。__users のパスワードのハッシュを
+// 読み取り専用のユーザーに見せないために使います。
+func Hidden(name string) bool { return key(name) == usersTable.Name }
+
+// Access はユーザーに許可したデータベースの操作です。
+type Access int
+
+const (
+	NoAccess    Access = iota // 接続できない
+	ReadAccess                // 読み取りだけ
+	WriteAccess               // 読み書き（ユーザーの管理を含む）
+)
+
+// accessNames は __users に保存する Access の名前です。
+var accessNames = []string{NoAccess: "none", ReadAccess: "read", WriteAccess: "write"}
+
+func (a Access) String() string {
+	if a >= 0 && int(a) < len(accessNames) {
+		return accessNames[a]
+	}
+	return fmt.Sprintf("Access(%d)", int(a))
+}
+
+// User はユーザーの定義です。
+type User struct {
+	Name     string
+	Password string // ソルトを付けたパスワードのハッシュ
+	Access   Access
+}
+
+// loadUsers は __users からユーザーを読み込みます。
+func (c *Catalog) loadUsers() error {
+	t, ok := c.tables[key(usersTable.Name)]
+	if !ok {
+		return nil
+	}
+	return c.scan(t.Root, func(_ storage.RID, v []types.Value) error {
+		a := slices.Index(accessNames, v[2].Text())
+		if a < 0 {
+			return dberr.Mark(fmt.Errorf("catalog is corrupt: user %s has access %q", v[0].Text(), v[2].Text()), dberr.ErrCorrupt)
+		}
+		u := &User{Name: v[0].Text(), Password: v[1].Text(), Access: Access(a)}
+		c.users[key(u.Name)] = u
+		return nil
+	})
+}
+
+// User は名前が name のユーザーを返します（大文字と小文字は区別しません）。
+func (c *Catalog) User(name string) (*User, bool) {
+	u, ok := c.users[key(name)]
+	return u, ok
+}
+
+// Users はすべてのユーザーを名前順に返します。
+func (c *Catalog) Users() []*User {
+	out := make([]*User, 0, len(c.users))
+	for _, u := range c.users {
+		out = append(out, u)
+	}
+	slices.SortFunc(out, func(a, b *User) int { return strings.Compare(a.Name, b.Name) })
+	return out
+}
+
+// CreateUser は何の操作も許可していないユーザーを作成します。password はハッシュです。
+// ユーザーはスキーマではないので、スキーマの版は変わりません。
+func (c *Catalog) CreateUser(name, password string) (*User, error) {
+	if _, ok := c.users[key(name)]; ok {
+		return nil, fmt.Errorf("%w: %s", ErrUserExists, name)
+	}
+	u := &User{Name: name, Password: password}
+	if err := c.writeUser(u); err != nil {
+		return nil, err
+	}
+	c.users[key(name)] = u
+	return u, nil
+}
+
+// SetUserPassword はユーザーのパスワードのハッシュを password にします。
+func (c *Catalog) SetUserPassword(name, password string) error {
+	u, ok := c.users[key(name)]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrUserNotFound, name)
+	}
+	nu := *u
+	nu.Password = password
+	return c.replaceUser(&nu)
+}
+
+// SetUserAccess はユーザーに許可する操作を a にします。
+func (c *Catalog) SetUserAccess(name string, a Access) error {
+	u, ok := c.users[key(name)]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrUserNotFound, name)
+	}
+	nu := *u
+	nu.Access = a
+	return c.replaceUser(&nu)
+}
+
+// DropUser はユーザーを削除します。
+func (c *Catalog) DropUser(name string) error {
+	if _, ok := c.users[key(name)]; !ok {
+		return fmt.Errorf("%w: %s", ErrUserNotFound, name)
+	}
+	root, err := c.lazyRoot(usersTable)
+	if err != nil {
+		return err
+	}
+	if err := c.delete(root, func(v []types.Value) bool { return key(v[0].Text()) == key(name) }); err != nil {
+		return err
+	}
+	delete(c.users, key(name))
+	return nil
+}
+
+// replaceUser は __users の u と同じ名前の行を u で置き換えます。
+func (c *Catalog) replaceUser(u *User) error {
+	root, err := c.lazyRoot(usersTable)
+	if err != nil {
+		return err
+	}
+	if err := c.delete(root, func(v []types.Value) bool { return key(v[0].Text()) == key(u.Name) }); err != nil {
+		return err
+	}
+	if err := c.writeUser(u); err != nil {
+		return err
+	}
+	c.users[key(u.Name)] = u
+	return nil
+}
+
+// writeUser は __users に u の行を追加します。
+func (c *Catalog) writeUser(u *User) error {
+	root, err := c.lazyRoot(usersTable)
+	if err != nil {
+		return err
+	}
+	return c.insert(root, types.NewText(u.Name), types.NewText(u.Password), types.NewText(u.Access.String()))
+}
@@ -0,0 +1,130 @@
+package catalog
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/k-sml/go-rdbms/internal/storage"
+	"github.com/k-sml/go-rdbms/internal/types"
+)
+
+// ErrViewNotFound はビューが見つからない場合に返されます。
+var ErrViewNotFound = errors.New("no such view")
+
+// viewsTable はビューを保存するシステムテーブルの定義です。列名はカンマ区切りで保存します。
+var viewsTable = &Table{Name: "__views", System: true, Columns: []Column{
+	{Name: "id", Type: types.BigInt}, {Name: "name", Type: types.Text},
+	{Name: "query", Type: types.Text}, {Name: "columns", Type: types.Text},
+}}
+
+// View は名前を付けた問い合わせです。テーブルと同じ名前空間にあり、
+// 問い合わせの中でビューの名前を使うと、計画を立てるときに Query に展開されます。
+type View struct {
+	ID      int64
+	Name    string
+	Query   string   // 定義の SELECT 文
+	Columns []string // 結果の列の名前（省略すると問い合わせの列名）
+}
+
+// loadViews は __views からビューを読み込みます。
+func (c *Catalog) loadViews() error {
+	t, ok := c.tables[key(viewsTable.Name)]
+	if !ok {
+		return nil
+	}
+	return c.scan(t.Root, func(_ storage.RID, v []types.Value) error {
+		vw := &View{ID: v[0].Int(), Name: v[1].Text(), Query: v[2].Text()}
+		if s := v[3].Text(); s != "" {
+			vw.Columns = strings.Split(s, ",")
+		}
+		c.views[key(vw.Name)] = vw
+		c.nextID = max(c.nextID, vw.ID+1)
+		return nil
+	})
+}
+
+// View は名前が name のビューを返します。
+func (c *Catalog) View(name string) (*View, bool) {
+	v, ok := c.views[key(name)]
+	return v, ok
+}
+
+// Views はビューを名前順に返します。
+func (c *Catalog) Views() []*View {
+	var out []*View
+	for _, v := range c.views {
+		out = append(out, v)
+	}
+	slices.SortFunc(out, func(a, b *View) int { return strings.Compare(a.Name, b.Name) })
+	return out
+}
+
+// CreateView はビューを作成します。問い合わせの検査は呼び出し側で行います。
+func (c *Catalog) CreateView(name, query string, columns []string) (*View, error) {
+	if strings.HasPrefix(name, SystemPrefix) {
+		return nil, fmt.Errorf("view name %q is reserved", name)
+	}
+	if err := c.checkName(name); err != nil {
+		return nil, err
+	}
+	if err := c.checkTempName(name); err != nil {
+		return nil, err
+	}
+	if strings.TrimSpace(query) == "" {
+		return nil, fmt.Errorf("view %s has an empty query", name)
+	}
+	for i, col := range columns {
+		if slices.ContainsFunc(columns[:i], func(s string) bool { return strings.EqualFold(s, col) }) {
+			return nil, fmt.Errorf("duplicate column name: %s", col)
+		}
+	}
+	root, err := c.lazyRoot(viewsTable)
+	if err != nil {
+		return nil, err
+	}
+	v := &View{ID: c.nextID, Name: name, Query: query, Columns: slices.Clone(columns)}
+	c.nextID++
+	err = c.insert(root, types.NewBigInt(v.ID), types.NewText(v.Name), types.NewText(v.Query),
+		types.NewText(strings.Join(v.Columns, ",")))
+	if err != nil {
+		return nil, err
+	}
+	if err := c.bump(); err != nil {
+		return nil, err
+	}
+	c.views[key(name)] = v
+	return v, nil
+}
+
+// DropView はビューを削除します。
+func (c *Catalog) DropView(name string) error {
+	v, ok := c.views[key(name)]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrViewNotFound, name)
+	}
+	root, err := c.lazyRoot(viewsTable)
+	if err != nil {
+		return err
+	}
+	if err := c.delete(root, func(r []types.Value) bool { return r[0].Int() == v.ID }); err != nil {
+		return err
+	}
+	if err := c.bump(); err != nil {
+		return err
+	}
+	delete(c.views, key(name))
+	return nil
+}
+
+// checkName は name がテーブルとビューの名前としてまだ使われていないかを調べます。
+func (c *Catalog) checkName(name string) error {
+	if _, ok := c.tables[key(name)]; ok {
+		return fmt.Errorf("%w: %s", ErrTableExists, name)
+	}
+	if _, ok := c.views[key(name)]; ok {
+		return fmt.Errorf("%w: view %s", ErrTableExists, name)
+	}
+	return nil
+}
@@ -0,0 +1,256 @@
+// Package cdc はWALのレコードを行単位の変更イベントに変換する
+// 論理デコードAPI（Change Data Capture）を提供します。
+// 外部システムはイベントをチャネルやJSONストリームとして購読できます。
+package cdc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"time"
+
+	"github.com/k-sml/go-rdbms/internal/storage"
+	"github.com/k-sml/go-rdbms/internal/wal"
+)
+
+// Op は変更操作の種類です。
+type Op string
+
+const (
+	OpInsert Op = "insert"
+	OpUpdate Op = "update"
+	OpDelete Op = "delete"
+)
+
+// Event は1行分の変更イベントです。
+// 行はヒープページ上の位置（PageID, Slot）で識別されます。
+type Event struct {
+	LSN    uint64 `json:"lsn"`             // 変更を確定させたコミットレコードのLSN
+	TxID   uint64 `json:"tx"`              // 変更を行ったトランザクション
+	Table  string `json:"table,omitempty"` // 行が属するテーブル（Tables で解決できた場合）
+	Op     Op     `json:"op"`              // 操作の種類
+	PageID int64  `json:"page"`            // 行のあるページ
+	Slot   int    `json:"slot"`            // ページ内のスロット番号
+	Old    []byte `json:"old,omitempty"`   // 変更前のタプル（insert では nil）
+	New    []byte `json:"new,omitempty"`   // 変更後のタプル（delete では nil）
+}
+
+// Decoder はWALレコードを順に受け取り、コミットごとに変更イベントを返します。
+// 論理レコードはそのまま変更イベントになります。物理レコード（ページイメージや差分）は
+// 行の操作を直接表さないため、Decoder はページごとに直前のイメージを覚えておき、
+// スロット単位の差分から変更を求めます。あるページを初めて見たときは空のページとの
+// 差分になるため、それ以前から存在した行は insert として報告されます。
+type Decoder struct {
+	// Tables はページIDから所属テーブル名を解決します。
+	// false を返したページは変更イベントの対象外になります。
+	// nil の場合はヒープページとして解釈できるすべてのページを対象にします。
+	Tables func(pageID int64) (string, bool)
+
+	pages   map[int64][]byte // ページごとの直前のイメージ
+	pending []Event          // COMMIT 待ちのイベント
+}
+
+// NewDecoder は新しい Decoder を作成します。
+func NewDecoder() *Decoder {
+	return &Decoder{pages: make(map[int64][]byte)}
+}
+
+// SetBase はページ pageID の直前のイメージを img にします。次にそのページのレコードを受け取ると、
+// img との差分を変更イベントにします。img が nil ならページのイメージを忘れ、次に受け取る
+// ページイメージは空のページとの差分になります。
+func (d *Decoder) SetBase(pageID int64, img []byte) {
+	if img == nil {
+		delete(d.pages, pageID)
+		return
+	}
+	d.pages[pageID] = append([]byte(nil), img...)
+}
+
+// Decode は1件のレコードを処理し、コミットレコードを受け取った時点で
+// そのコミットに含まれる変更イベントを返します。それ以外では nil を返します。
+func (d *Decoder) Decode(rec *wal.Record) []Event {
+	switch {
+	case wal.HasPage(rec.Type):
+		d.decodePage(rec)
+	case rec.Type == wal.RecCommit:
+		evs := d.pending
+		for i := range evs {
+			evs[i].LSN = rec.LSN
+		}
+		d.pending = nil
+		return evs
+	}
+	return nil
+}
+
+func (d *Decoder) decodePage(rec *wal.Record) {
+	table := ""
+	if d.Tables != nil {
+		name, ok := d.Tables(rec.PageID)
+		if !ok {
+			return
+		}
+		table = name
+	}
+	prev := d.pages[rec.PageID]
+	if prev == nil {
+		if rec.Type != wal.RecPageImage {
+			return // 基準となるイメージがないので追跡できない
+		}
+		prev = make([]byte, len(rec.Payload))
+	}
+	img := append([]byte(nil), prev...)
+	if err := wal.Apply(img, rec); err != nil || !storage.LooksLikeDataPage(img) {
+		delete(d.pages, rec.PageID)
+		return
+	}
+	d.pages[rec.PageID] = img
+
+	if wal.IsLogical(rec.Type) {
+		d.decodeLogical(rec, table, prev)
+		return
+	}
+
+	// OpenDataPage は未初期化のページを初期化するのでコピーに対して呼び出す
+	oldPage, err := storage.OpenDataPage(append([]byte(nil), prev...))
+	if err != nil {
+		return
+	}
+	newPage, err := storage.OpenDataPage(append([]byte(nil), img...))
+	if err != nil {
+		return
+	}
+	n := max(oldPage.NumSlots(), newPage.NumSlots())
+	for slot := 0; slot < n; slot++ {
+		oldRec, hadOld := oldPage.Get(slot)
+		newRec, hasNew := newPage.Get(slot)
+		ev := Event{TxID: rec.TxID, Table: table, PageID: rec.PageID, Slot: slot}
+		switch {
+		case !hadOld && hasNew:
+			ev.Op, ev.New = OpInsert, newRec
+		case hadOld && !hasNew:
+			ev.Op, ev.Old = OpDelete, oldRec
+		case hadOld && hasNew && !bytes.Equal(oldRec, newRec):
+			ev.Op, ev.Old, ev.New = OpUpdate, oldRec, newRec
+		default:
+			continue
+		}
+		d.pending = append(d.pending, ev)
+	}
+}
+
+// decodeLogical は論理レコードから変更イベントを作ります。
+// 変更前のタプルは直前のページイメージから取り出します。
+func (d *Decoder) decodeLogical(rec *wal.Record, table string, prev []byte) {
+	slot, tuple, err := wal.HeapOp(rec)
+	if err != nil {
+		return
+	}
+	ev := Event{TxID: rec.TxID, Table: table, PageID: rec.PageID, Slot: slot}
+	oldPage, err := storage.OpenDataPage(append([]byte(nil), prev...))
+	if err != nil {
+		return
+	}
+	switch rec.Type {
+	case wal.RecHeapInsert:
+		ev.Op, ev.New = OpInsert, append([]byte(nil), tuple...)
+	case wal.RecHeapDelete:
+		ev.Op = OpDelete
+		ev.Old, _ = oldPage.Get(slot)
+	case wal.RecHeapUpdate:
+		ev.Op, ev.New = OpUpdate, append([]byte(nil), tuple...)
+		ev.Old, _ = oldPage.Get(slot)
+	}
+	d.pending = append(d.pending, ev)
+}
+
+// Stream はWALファイルを追跡して変更イベントを配信する購読です。
+type Stream struct {
+	C   <-chan Event // 変更イベント。購読が終わると close される
+	err error
+}
+
+// Err は C が close された後に、購読を終了させたエラーを返します。
+// ctx のキャンセルで終了した場合は ctx のエラーを返します。
+func (s *Stream) Err() error { return s.err }
+
+// Subscribe はWALファイルを先頭から読み、以降は poll 間隔で追記を待ちながら
+// コミット済みの変更イベントを C に送り続けます。
+// チェックポイントでWALが空にされた場合は新しいWALを先頭から読み直します。
+func Subscribe(ctx context.Context, walPath string, d *Decoder, poll time.Duration) *Stream {
+	c := make(chan Event)
+	s := &Stream{C: c}
+	go func() {
+		defer close(c)
+		s.err = s.run(ctx, walPath, d, poll, c)
+	}()
+	return s
+}
+
+func (s *Stream) run(ctx context.Context, walPath string, d *Decoder, poll time.Duration, c chan<- Event) error {
+	var r *wal.Reader
+	defer func() {
+		if r != nil {
+			r.Close()
+		}
+	}()
+	for {
+		if r == nil {
+			var err error
+			r, err = wal.OpenReader(walPath)
+			if err != nil && !errors.Is(err, os.ErrNotExist) && !errors.Is(err, wal.ErrCorrupt) {
+				return err
+			}
+		}
+		if r != nil {
+			if err := drain(ctx, r, d, c); err != nil {
+				return err
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(poll):
+		}
+	}
+}
+
+// drain はリーダから読めるところまで読み、イベントを送ります。
+func drain(ctx context.Context, r *wal.Reader, d *Decoder, c chan<- Event) error {
+	for {
+		rec, err := r.Next()
+		if err == io.EOF {
+			reset, err := r.Reopen()
+			if err != nil || !reset {
+				return err
+			}
+			d.pending = nil // 前の世代の未コミット分は捨てる
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		for _, ev := range d.Decode(rec) {
+			select {
+			case c <- ev:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+}
+
+// WriteJSON は c から受け取ったイベントを1行1イベントのJSONとして w に書き出します。
+// c が close されるまで戻りません。
+func WriteJSON(w io.Writer, c <-chan Event) error {
+	enc := json.NewEncoder(w)
+	for ev := range c {
+		if err := enc.Encode(ev); err != nil {
+			return err
+		}
+	}
+	return nil
+}
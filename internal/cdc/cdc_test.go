@@ -0,0 +1,253 @@
+package cdc
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/k-sml/go-rdbms/internal/storage"
+	"github.com/k-sml/go-rdbms/internal/wal"
+)
+
+const testPageSize = 512
+
+// heapPage は base（nil なら空のページ）のコピーに fn で行を書き込んだページのイメージを返します。
+func heapPage(t *testing.T, base []byte, fn func(p *storage.HeapPage) error) []byte {
+	t.Helper()
+	buf := make([]byte, testPageSize)
+	copy(buf, base)
+	p, err := storage.NewHeapPage(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fn(p); err != nil {
+		t.Fatal(err)
+	}
+	return buf
+}
+
+func insert(recs ...string) func(p *storage.HeapPage) error {
+	return func(p *storage.HeapPage) error {
+		for _, rec := range recs {
+			if _, err := p.Insert([]byte(rec)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// rewrite はページ buf の行 old を同じ長さの new にその場で書き換えます。
+func rewrite(t *testing.T, buf []byte, old, new string) []byte {
+	t.Helper()
+	i := bytes.Index(buf, []byte(old))
+	if i < 0 || len(old) != len(new) {
+		t.Fatalf("cannot rewrite %q to %q", old, new)
+	}
+	buf = slices.Clone(buf)
+	copy(buf[i:], new)
+	return buf
+}
+
+func image(pageID int64, txID uint64, img []byte) *wal.Record {
+	return &wal.Record{Type: wal.RecPageImage, TxID: txID, PageID: pageID, Payload: img}
+}
+
+func diff(pageID int64, txID uint64, old, new []byte) *wal.Record {
+	return &wal.Record{Type: wal.RecPageDiff, TxID: txID, PageID: pageID, Payload: wal.EncodeDiff(old, new)}
+}
+
+func logical(typ wal.RecordType, pageID int64, txID uint64, slot int, tuple string) *wal.Record {
+	rec := wal.NewHeapRecord(typ, pageID, slot, []byte(tuple))
+	rec.TxID = txID
+	return rec
+}
+
+func commit(lsn, txID uint64) *wal.Record {
+	return &wal.Record{Type: wal.RecCommit, LSN: lsn, TxID: txID}
+}
+
+// format はイベントを "op page:slot old>new" の形にします。
+func format(evs []Event) []string {
+	var s []string
+	for _, ev := range evs {
+		s = append(s, fmt.Sprintf("%s %d:%d %s>%s", ev.Op, ev.PageID, ev.Slot, ev.Old, ev.New))
+	}
+	return s
+}
+
+// TestDecode は、ページイメージや差分のレコードでは直前のイメージとの差分から、論理レコードでは
+// レコードの操作から行の変更を求め、コミットのレコードでそのトランザクションのイベントをまとめて
+// 返すことを確かめます。
+func TestDecode(t *testing.T) {
+	p1 := heapPage(t, nil, insert("row-a", "row-b"))
+	p2 := heapPage(t, rewrite(t, p1, "row-a", "row-A"), func(p *storage.HeapPage) error {
+		if _, err := p.Insert([]byte("row-c")); err != nil {
+			return err
+		}
+		return p.Delete(1)
+	})
+	p3 := heapPage(t, p1, insert("row-d"))
+	notHeap := bytes.Repeat([]byte{0xFF}, testPageSize)
+
+	d := NewDecoder()
+	d.Tables = func(pageID int64) (string, bool) { return "t", pageID != 3 }
+	tests := []struct {
+		name string
+		recs []*wal.Record
+		want []string
+	}{
+		{"insert", []*wal.Record{image(1, 1, p1), commit(10, 1)}, []string{"insert 1:0 >row-a", "insert 1:1 >row-b"}},
+		{"update and delete", []*wal.Record{image(1, 2, p2), commit(20, 2)},
+			[]string{"update 1:0 row-a>row-A", "delete 1:1 row-b>", "insert 1:2 >row-c"}},
+		{"no change", []*wal.Record{image(1, 3, p2), commit(30, 3)}, nil},
+		{"other table", []*wal.Record{image(3, 4, p1), commit(40, 4)}, nil},
+		{"not a heap page", []*wal.Record{image(2, 5, notHeap), commit(50, 5)}, nil},
+		{"two pages", []*wal.Record{image(2, 6, p1), image(1, 6, p1), commit(60, 6)},
+			[]string{"insert 2:0 >row-a", "insert 2:1 >row-b", "update 1:0 row-A>row-a", "insert 1:1 >row-b", "delete 1:2 row-c>"}},
+		{"diff", []*wal.Record{diff(1, 7, p1, p3), commit(70, 7)}, []string{"insert 1:2 >row-d"}},
+		{"logical", []*wal.Record{
+			logical(wal.RecHeapUpdate, 1, 8, 0, "row-x"),
+			logical(wal.RecHeapDelete, 1, 8, 1, ""),
+			logical(wal.RecHeapInsert, 2, 8, 2, "row-y"),
+			commit(80, 8),
+		}, []string{"update 1:0 row-a>row-x", "delete 1:1 row-b>", "insert 2:2 >row-y"}},
+		// 直前のイメージのないページの差分や論理レコードは追跡できない
+		{"no base", []*wal.Record{diff(4, 9, p1, p3), logical(wal.RecHeapInsert, 5, 9, 0, "row-z"), commit(90, 9)}, nil},
+	}
+	for _, tt := range tests {
+		var got []Event
+		for i, rec := range tt.recs {
+			evs := d.Decode(rec)
+			if i < len(tt.recs)-1 && evs != nil {
+				t.Errorf("%s: record %d returned events before the commit", tt.name, i)
+			}
+			got = append(got, evs...)
+		}
+		if !slices.Equal(format(got), tt.want) {
+			t.Errorf("%s: events = %q, want %q", tt.name, format(got), tt.want)
+		}
+		c := tt.recs[len(tt.recs)-1]
+		for _, ev := range got {
+			if ev.LSN != c.LSN || ev.TxID != c.TxID || ev.Table != "t" {
+				t.Errorf("%s: event %+v, want LSN %d, tx %d and table t", tt.name, ev, c.LSN, c.TxID)
+			}
+		}
+	}
+}
+
+// TestSetBase は、SetBase で与えたイメージとの差分を変更イベントにすることを確かめます。
+func TestSetBase(t *testing.T) {
+	p1 := heapPage(t, nil, insert("row-a"))
+	p2 := heapPage(t, p1, insert("row-b"))
+	d := NewDecoder()
+	d.SetBase(1, p1)
+	d.Decode(image(1, 1, p2))
+	if got := format(d.Decode(commit(10, 1))); !slices.Equal(got, []string{"insert 1:1 >row-b"}) {
+		t.Errorf("events after SetBase = %q", got)
+	}
+	d.SetBase(1, nil)
+	d.Decode(image(1, 2, p2))
+	if got := format(d.Decode(commit(20, 2))); !slices.Equal(got, []string{"insert 1:0 >row-a", "insert 1:1 >row-b"}) {
+		t.Errorf("events after forgetting the base = %q", got)
+	}
+}
+
+// TestWriteJSON は、イベントを1行1イベントの JSON で書き出すことを確かめます。
+func TestWriteJSON(t *testing.T) {
+	c := make(chan Event, 2)
+	c <- Event{LSN: 10, TxID: 1, Table: "t", Op: OpInsert, PageID: 1, Slot: 0, New: []byte("a")}
+	c <- Event{LSN: 10, TxID: 1, Op: OpDelete, PageID: 1, Slot: 1, Old: []byte("b")}
+	close(c)
+	var buf bytes.Buffer
+	if err := WriteJSON(&buf, c); err != nil {
+		t.Fatal(err)
+	}
+	want := `{"lsn":10,"tx":1,"table":"t","op":"insert","page":1,"slot":0,"new":"YQ=="}
+{"lsn":10,"tx":1,"op":"delete","page":1,"slot":1,"old":"Yg=="}
+`
+	if buf.String() != want {
+		t.Errorf("WriteJSON wrote\n%s\nwant\n%s", buf.String(), want)
+	}
+}
+
+// TestSubscribe は、WAL ファイルへの追記とチェックポイントによる WAL の作り直しを追って、
+// コミットしたイベントだけを送ることを確かめます。
+func TestSubscribe(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db-wal")
+	l, err := wal.Open(path, testPageSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	appendRecs := func(recs ...*wal.Record) {
+		t.Helper()
+		for _, rec := range recs {
+			if _, err := l.Append(rec); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := l.Sync(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	recv := func(s *Stream, n int) []string {
+		t.Helper()
+		var evs []Event
+		for len(evs) < n {
+			select {
+			case ev, ok := <-s.C:
+				if !ok {
+					t.Fatalf("stream closed: %v", s.Err())
+				}
+				evs = append(evs, ev)
+			case <-time.After(5 * time.Second):
+				t.Fatalf("received %d events, want %d", len(evs), n)
+			}
+		}
+		return format(evs)
+	}
+
+	p1 := heapPage(t, nil, insert("a"))
+	p2 := heapPage(t, p1, insert("b"))
+	p3 := heapPage(t, p2, insert("c"))
+	appendRecs(image(1, 1, p1), commit(0, 1), image(1, 2, p2)) // 2 つ目はまだコミットしていない
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := Subscribe(ctx, path, NewDecoder(), time.Millisecond)
+	if got := recv(s, 1); strings.Join(got, ",") != "insert 1:0 >a" {
+		t.Errorf("first events = %q", got)
+	}
+	select {
+	case ev := <-s.C:
+		t.Errorf("received %+v before the commit", ev)
+	case <-time.After(50 * time.Millisecond):
+	}
+	appendRecs(commit(0, 2))
+	if got := recv(s, 1); strings.Join(got, ",") != "insert 1:1 >b" {
+		t.Errorf("events after the commit = %q", got)
+	}
+
+	// チェックポイントの後の新しい WAL を先頭から読む
+	if err := l.Reset(); err != nil {
+		t.Fatal(err)
+	}
+	appendRecs(image(1, 3, p3), commit(0, 3))
+	if got := recv(s, 1); strings.Join(got, ",") != "insert 1:2 >c" {
+		t.Errorf("events after the checkpoint = %q", got)
+	}
+
+	cancel()
+	for range s.C {
+	}
+	if err := s.Err(); !errors.Is(err, context.Canceled) {
+		t.Errorf("Err = %v, want context.Canceled", err)
+	}
+}
@@ -0,0 +1,296 @@
+// Package crashtest は電源断を模擬したクラッシュリカバリ試験の枠組みを提供します。
+// ワークロードを vfs.MemFS 上のページャーかデータベースに対して実行し、ランダムなバイト位置で
+// 書き込みを打ち切って電源断を起こした後、開き直して（リカバリして）
+// コミットの原子性と永続性が保たれているかを検証します。
+//
+// ワークロードは3種類あります。WorkloadPages はページ全体を WritePage で書き換え（WALには
+// ページイメージか差分の物理レコードが残る）、WorkloadHeap はヒープページへの挿入・更新・削除を
+// WritePageLogical で論理レコードとして記録し、WorkloadSQL はテーブルへの SQL の文を
+// トランザクションで実行します。
+package crashtest
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"math/rand"
+	"slices"
+
+	"github.com/k-sml/go-rdbms/internal/pager"
+	"github.com/k-sml/go-rdbms/internal/vfs"
+)
+
+const dbPath = "crash.db"
+
+// ページ内容のレイアウト
+// [u64:commit][i64:pageID][u32:crc][残りは commit と pageID から決まる疑似乱数]
+// crc はオフセット 20 以降のバイト列に対して計算する
+const pageHdrSize = 20
+
+// Workload は試験で実行するワークロードの種類です。
+type Workload int
+
+const (
+	WorkloadPages Workload = iota // ページ全体を WritePage で書き換える
+	WorkloadHeap                  // ヒープページの操作を WritePageLogical で論理レコードとして記録する
+	WorkloadSQL                   // テーブルへの SQL の文をトランザクションで実行する
+)
+
+// Config は試験の設定です。ゼロ値のフィールドには既定値が使われます。
+type Config struct {
+	Seed            int64             // 乱数の種
+	Iterations      int               // 電源断→リカバリ→検証を繰り返す回数（既定 100）
+	PageSize        int               // ページサイズ（既定 4096）
+	Pages           int               // ワークロードが触るページ数。WorkloadSQL では最初の口座の数（既定 16）
+	Commits         int               // 1回の試行で行うコミット数（既定 20）
+	PagesPerCommit  int               // 1コミットで書き換えるページ数か、行う操作の数の上限（既定 4）
+	CheckpointEvery int               // このコミット数ごとにチェックポイントする（0 で無効）
+	Journal         pager.JournalMode // 試験対象のジャーナルモード
+	Workload        Workload          // 実行するワークロード
+}
+
+func (c *Config) setDefaults() {
+	if c.Iterations <= 0 {
+		c.Iterations = 100
+	}
+	if c.PageSize <= 0 {
+		c.PageSize = 4096
+	}
+	if c.Pages <= 0 {
+		c.Pages = 16
+	}
+	if c.Commits <= 0 {
+		c.Commits = 20
+	}
+	if c.PagesPerCommit <= 0 {
+		c.PagesPerCommit = 4
+	}
+}
+
+// Failure は検証で見つかった不変条件の違反です。
+type Failure struct {
+	Iteration int    // 何回目の試行か
+	Cut       int64  // 電源断を起こした書き込みバイト位置
+	Msg       string // 違反の内容
+}
+
+func (f Failure) String() string {
+	return fmt.Sprintf("iteration %d (cut at byte %d): %s", f.Iteration, f.Cut, f.Msg)
+}
+
+// Report は試験結果です。
+type Report struct {
+	Iterations int       // 実行した試行の数
+	Failures   []Failure // 見つかった違反
+}
+
+// Run は試験を実行します。違反は Report.Failures に集められ、
+// 試験自体を続けられない場合だけエラーを返します。
+func Run(cfg Config) (*Report, error) {
+	cfg.setDefaults()
+	rep := &Report{}
+	for i := 0; i < cfg.Iterations; i++ {
+		f, err := runOnce(cfg, i)
+		if err != nil {
+			return rep, fmt.Errorf("iteration %d: %w", i, err)
+		}
+		rep.Iterations++
+		if f != nil {
+			rep.Failures = append(rep.Failures, *f)
+		}
+	}
+	return rep, nil
+}
+
+// workload は1回の試行で行う書き込みの計画です。
+type workload interface {
+	// run はワークロードを fs 上で実行し、正常に完了したコミット数を返します。
+	// 電源断が起きた場合は、その時点までに完了したコミット数を返します。
+	run(fs *vfs.MemFS) (int, error)
+	// check はリカバリした fs の状態を検証し、最初の done コミットまでの状態にも
+	// done+1 コミットまでの状態にも一致しなければ違反の内容を返します。
+	check(fs *vfs.MemFS, done int) string
+}
+
+func planWorkload(cfg Config, rng *rand.Rand) (workload, error) {
+	switch cfg.Workload {
+	case WorkloadPages:
+		return planPages(cfg, rng), nil
+	case WorkloadHeap:
+		return planHeap(cfg, rng), nil
+	case WorkloadSQL:
+		return planSQL(cfg, rng), nil
+	default:
+		return nil, fmt.Errorf("unknown workload: %d", cfg.Workload)
+	}
+}
+
+func runOnce(cfg Config, iter int) (*Failure, error) {
+	rng := rand.New(rand.NewSource(cfg.Seed + int64(iter)))
+	w, err := planWorkload(cfg, rng)
+	if err != nil {
+		return nil, err
+	}
+
+	// 一度最後まで実行して総書き込み量を測り、その範囲で電源断の位置を選ぶ
+	probe := vfs.NewMemFS()
+	if _, err := w.run(probe); err != nil {
+		return nil, err
+	}
+	cut := rng.Int63n(probe.Written() + 1)
+
+	fs := vfs.NewMemFS()
+	fs.CutAfter(cut)
+	done, err := w.run(fs)
+	if err != nil && !errors.Is(err, vfs.ErrPowerLoss) {
+		return nil, err
+	}
+	fs.Crash(rng)
+
+	// 再起動してリカバリし、完了したコミットまでの状態か、途中だったコミットまで含めた状態の
+	// どちらかに一致するかを調べる
+	if msg := w.check(fs, done); msg != "" {
+		return &Failure{Iteration: iter, Cut: cut, Msg: msg}, nil
+	}
+	return nil, nil
+}
+
+// pageWorkload はページ全体を書き換えるワークロードです。
+type pageWorkload struct {
+	cfg  Config
+	plan [][]int64 // コミットごとに書き換えるページ
+}
+
+func planPages(cfg Config, rng *rand.Rand) *pageWorkload {
+	w := &pageWorkload{cfg: cfg}
+	for k := 0; k < cfg.Commits; k++ {
+		n := 1 + rng.Intn(cfg.PagesPerCommit)
+		seen := make(map[int64]bool)
+		var ids []int64
+		for len(ids) < n {
+			id := int64(rng.Intn(cfg.Pages))
+			if !seen[id] {
+				seen[id] = true
+				ids = append(ids, id)
+			}
+		}
+		slices.Sort(ids)
+		w.plan = append(w.plan, ids)
+	}
+	return w
+}
+
+func (w *pageWorkload) run(fs *vfs.MemFS) (int, error) {
+	cfg := w.cfg
+	p, err := openPager(cfg, fs)
+	if err != nil {
+		return 0, err
+	}
+	defer p.Close()
+	for k, ids := range w.plan {
+		for _, id := range ids {
+			if err := p.WritePage(id, pageImage(cfg.PageSize, uint64(k+1), id)); err != nil {
+				return k, err
+			}
+		}
+		if err := p.Flush(); err != nil {
+			return k, err
+		}
+		if err := checkpoint(cfg, p.Checkpoint, k); err != nil {
+			return k + 1, err
+		}
+	}
+	return len(w.plan), nil
+}
+
+func (w *pageWorkload) check(fs *vfs.MemFS, done int) string {
+	cfg := w.cfg
+	p, err := openPager(cfg, fs)
+	if err != nil {
+		return fmt.Sprintf("recovery failed: %v", err)
+	}
+	defer p.Close()
+
+	got := make([]uint64, cfg.Pages)
+	for id := range got {
+		buf, err := p.ReadPage(int64(id))
+		if err != nil {
+			return fmt.Sprintf("read page %d: %v", id, err)
+		}
+		c, err := checkPage(buf, int64(id))
+		if err != nil {
+			return fmt.Sprintf("page %d: %v", id, err)
+		}
+		got[id] = c
+	}
+
+	if slices.Equal(got, w.expected(done)) {
+		return ""
+	}
+	if done < len(w.plan) && slices.Equal(got, w.expected(done+1)) {
+		return ""
+	}
+	return fmt.Sprintf("state after recovery %v matches neither commit %d %v nor commit %d", got, done, w.expected(done), done+1)
+}
+
+// expected は最初の n コミットを適用した後に各ページが持つべきコミット番号を返します。
+func (w *pageWorkload) expected(n int) []uint64 {
+	want := make([]uint64, w.cfg.Pages)
+	for k := 0; k < n && k < len(w.plan); k++ {
+		for _, id := range w.plan[k] {
+			want[id] = uint64(k + 1)
+		}
+	}
+	return want
+}
+
+// openPager は fs 上の試験用のデータベースファイルをページャーで開きます。
+func openPager(cfg Config, fs *vfs.MemFS) (*pager.Pager, error) {
+	return pager.OpenWithOptions(dbPath, pager.Options{PageSize: cfg.PageSize, Journal: cfg.Journal, FS: fs})
+}
+
+// checkpoint は k 番目（0 から数える）のコミットの後、設定に従って fn でチェックポイントします。
+func checkpoint(cfg Config, fn func() error, k int) error {
+	if cfg.CheckpointEvery > 0 && (k+1)%cfg.CheckpointEvery == 0 {
+		return fn()
+	}
+	return nil
+}
+
+// pageImage はコミット commit でページ id に書き込む内容を作ります。
+func pageImage(pageSize int, commit uint64, id int64) []byte {
+	buf := make([]byte, pageSize)
+	binary.LittleEndian.PutUint64(buf[0:8], commit)
+	binary.LittleEndian.PutUint64(buf[8:16], uint64(id))
+	x := commit*0x9E3779B97F4A7C15 ^ uint64(id+1)
+	for i := pageHdrSize; i < pageSize; i++ {
+		x ^= x << 13
+		x ^= x >> 7
+		x ^= x << 17
+		buf[i] = byte(x)
+	}
+	binary.LittleEndian.PutUint32(buf[16:20], crc32.ChecksumIEEE(buf[pageHdrSize:]))
+	return buf
+}
+
+// checkPage はページの内容を検証し、書き込んだコミット番号を返します。
+// 一度も書かれていないページは 0 を返します。
+func checkPage(buf []byte, id int64) (uint64, error) {
+	commit := binary.LittleEndian.Uint64(buf[0:8])
+	if commit == 0 {
+		for _, b := range buf {
+			if b != 0 {
+				return 0, errors.New("torn page: zero header with non-zero content")
+			}
+		}
+		return 0, nil
+	}
+	if got := int64(binary.LittleEndian.Uint64(buf[8:16])); got != id {
+		return 0, fmt.Errorf("page holds content of page %d", got)
+	}
+	if crc32.ChecksumIEEE(buf[pageHdrSize:]) != binary.LittleEndian.Uint32(buf[16:20]) {
+		return 0, fmt.Errorf("torn page: checksum mismatch (commit %d)", commit)
+	}
+	return commit, nil
+}
@@ -0,0 +1,82 @@
+package crashtest
+
+import (
+	"testing"
+
+	"github.com/k-sml/go-rdbms/internal/pager"
+)
+
+// TestRun は、ジャーナルを使うモードではどこで電源断が起きてもコミットの原子性と永続性が
+// 保たれ、ジャーナルを使わないモードでは試験が違反を見つけることを確かめます。
+func TestRun(t *testing.T) {
+	tests := []struct {
+		name     string
+		cfg      Config
+		failures bool // 違反が見つかるはずか
+	}{
+		{"wal", Config{Journal: pager.JournalWAL}, false},
+		{"wal with checkpoints", Config{Journal: pager.JournalWAL, CheckpointEvery: 3}, false},
+		{"shadow", Config{Journal: pager.JournalShadow}, false},
+		{"shadow with checkpoints", Config{Journal: pager.JournalShadow, CheckpointEvery: 3}, false},
+		{"heap wal", Config{Journal: pager.JournalWAL, Workload: WorkloadHeap, CheckpointEvery: 4}, false},
+		{"heap shadow", Config{Journal: pager.JournalShadow, Workload: WorkloadHeap}, false},
+		{"sql wal", Config{Journal: pager.JournalWAL, Workload: WorkloadSQL, CheckpointEvery: 4}, false},
+		{"sql shadow", Config{Journal: pager.JournalShadow, Workload: WorkloadSQL}, false},
+		{"no journal", Config{Journal: pager.JournalNone}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := tt.cfg
+			cfg.Seed, cfg.Iterations, cfg.PageSize, cfg.Pages, cfg.Commits = 7, 40, 512, 8, 8
+			rep, err := Run(cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rep.Iterations != cfg.Iterations {
+				t.Errorf("ran %d iterations, want %d", rep.Iterations, cfg.Iterations)
+			}
+			if tt.failures {
+				if len(rep.Failures) == 0 {
+					t.Error("found no failures, want some")
+				}
+				return
+			}
+			for _, f := range rep.Failures {
+				t.Error(f)
+			}
+		})
+	}
+}
+
+// TestCheckPage は、checkPage が pageImage で書いたページを受け入れ、途切れたページや
+// 別のページの内容を見つけることを確かめます。
+func TestCheckPage(t *testing.T) {
+	tests := []struct {
+		name   string
+		page   func() []byte
+		commit uint64
+		ok     bool
+	}{
+		{"written", func() []byte { return pageImage(256, 5, 3) }, 5, true},
+		{"never written", func() []byte { return make([]byte, 256) }, 0, true},
+		{"torn", func() []byte {
+			p := pageImage(256, 5, 3)
+			copy(p[128:], pageImage(256, 4, 3)[128:])
+			return p
+		}, 0, false},
+		{"zero header", func() []byte {
+			p := pageImage(256, 5, 3)
+			clear(p[:pageHdrSize])
+			return p
+		}, 0, false},
+		{"other page", func() []byte { return pageImage(256, 5, 4) }, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			commit, err := checkPage(tt.page(), 3)
+			if (err == nil) != tt.ok || commit != tt.commit {
+				t.Errorf("checkPage = %d, %v, want %d and ok=%v", commit, err, tt.commit, tt.ok)
+			}
+		})
+	}
+}
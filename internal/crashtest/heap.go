@@ -0,0 +1,146 @@
+package crashtest
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"slices"
+
+	"github.com/k-sml/go-rdbms/internal/storage"
+	"github.com/k-sml/go-rdbms/internal/vfs"
+	"github.com/k-sml/go-rdbms/internal/wal"
+)
+
+// heapWorkload はヒープページへの挿入・更新・削除を論理レコードとして記録するワークロードです。
+// 計画するときに同じ操作をメモリ上のページに行っておき、リカバリ後のページがそのバイト列と
+// 一致するかを調べます。
+type heapWorkload struct {
+	cfg    Config
+	plan   [][]heapOp // コミットごとの操作
+	images [][][]byte // images[n] は最初の n コミットを適用した後の各ページ
+}
+
+// heapOp はヒープページへの1つの操作です。
+type heapOp struct {
+	rec  *wal.Record // 論理レコード（PageID に対象のページ）
+	page []byte      // 操作を行った後のページ
+}
+
+func planHeap(cfg Config, rng *rand.Rand) *heapWorkload {
+	pages := make([][]byte, cfg.Pages)
+	for id := range pages {
+		pages[id] = make([]byte, cfg.PageSize)
+	}
+	w := &heapWorkload{cfg: cfg, images: [][][]byte{clonePages(pages)}}
+	for k := 0; k < cfg.Commits; k++ {
+		var ops []heapOp
+		for i := range 1 + rng.Intn(cfg.PagesPerCommit) {
+			id := rng.Intn(cfg.Pages)
+			if rec := heapStep(pages[id], int64(id), fmt.Appendf(nil, "commit %d op %d page %d", k+1, i, id), rng); rec != nil {
+				ops = append(ops, heapOp{rec: rec, page: bytes.Clone(pages[id])})
+			}
+		}
+		w.plan = append(w.plan, ops)
+		w.images = append(w.images, clonePages(pages))
+	}
+	return w
+}
+
+// heapStep はページ page にランダムに選んだ操作を行い、その論理レコードを返します。
+// 空きがなく消せるレコードもなければ何もせず nil を返します。
+func heapStep(page []byte, id int64, tuple []byte, rng *rand.Rand) *wal.Record {
+	hp, _ := storage.NewHeapPage(page) // page はページサイズなので失敗しない
+	var live []int
+	for slot := range hp.NumSlots() {
+		if _, ok := hp.Get(slot); ok {
+			live = append(live, slot)
+		}
+	}
+	// Update は削除してから挿入するので、挿入と同じだけの空きが要る
+	fits := hp.FreeSpace() >= len(tuple)+4
+	op := rng.Intn(3)
+	switch {
+	case len(live) == 0 && !fits:
+		return nil
+	case len(live) == 0 || op == 0 && fits:
+		slot, err := hp.Insert(tuple)
+		if err != nil {
+			return nil
+		}
+		return wal.NewHeapRecord(wal.RecHeapInsert, id, slot, tuple)
+	case op == 1 && fits:
+		slot := live[rng.Intn(len(live))]
+		if err := hp.Update(slot, tuple); err != nil {
+			return nil
+		}
+		return wal.NewHeapRecord(wal.RecHeapUpdate, id, slot, tuple)
+	default:
+		slot := live[rng.Intn(len(live))]
+		if err := hp.Delete(slot); err != nil {
+			return nil
+		}
+		return wal.NewHeapRecord(wal.RecHeapDelete, id, slot, nil)
+	}
+}
+
+func clonePages(pages [][]byte) [][]byte {
+	out := make([][]byte, len(pages))
+	for i, p := range pages {
+		out[i] = bytes.Clone(p)
+	}
+	return out
+}
+
+func (w *heapWorkload) run(fs *vfs.MemFS) (int, error) {
+	cfg := w.cfg
+	p, err := openPager(cfg, fs)
+	if err != nil {
+		return 0, err
+	}
+	defer p.Close()
+	for k, ops := range w.plan {
+		for _, op := range ops {
+			if err := p.WritePageLogical(op.rec.PageID, op.page, op.rec); err != nil {
+				return k, err
+			}
+		}
+		if err := p.Flush(); err != nil {
+			return k, err
+		}
+		if err := checkpoint(cfg, p.Checkpoint, k); err != nil {
+			return k + 1, err
+		}
+	}
+	return len(w.plan), nil
+}
+
+func (w *heapWorkload) check(fs *vfs.MemFS, done int) string {
+	p, err := openPager(w.cfg, fs)
+	if err != nil {
+		return fmt.Sprintf("recovery failed: %v", err)
+	}
+	defer p.Close()
+
+	got := make([][]byte, w.cfg.Pages)
+	for id := range got {
+		buf, err := p.ReadPage(int64(id))
+		if err != nil {
+			return fmt.Sprintf("read page %d: %v", id, err)
+		}
+		got[id] = buf
+	}
+	diff := -1
+	for id, want := range w.images[done] {
+		if !bytes.Equal(got[id], want) {
+			diff = id
+			break
+		}
+	}
+	if diff < 0 {
+		return ""
+	}
+	if done < len(w.plan) && slices.EqualFunc(got, w.images[done+1], bytes.Equal) {
+		return ""
+	}
+	return fmt.Sprintf("page %d after recovery matches neither commit %d nor commit %d", diff, done, done+1)
+}
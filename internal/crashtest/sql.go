@@ -0,0 +1,188 @@
+package crashtest
+
+import (
+	"fmt"
+	"maps"
+	"math/rand"
+	"slices"
+	"strings"
+
+	"github.com/k-sml/go-rdbms/internal/engine"
+	"github.com/k-sml/go-rdbms/internal/txn"
+	"github.com/k-sml/go-rdbms/internal/vfs"
+)
+
+// initialBalance は最初の口座の残高です。
+const initialBalance = 100
+
+// sqlWorkload は口座のテーブルに SQL の文を実行するワークロードです。最初のコミットでテーブルと
+// インデックスを作って口座を入れ、以降のコミットでは口座の間の振り替え、口座の追加、残高を
+// 移してからの削除を行います。どのコミットも残高の合計を変えないので、リカバリ後の口座は
+// 計画した状態のどれかと一致し、合計は常に同じでなければなりません。
+type sqlWorkload struct {
+	cfg    Config
+	plan   [][]string        // コミットごとに実行する文
+	states []map[int64]int64 // states[n] は最初の n コミットを実行した後の口座ごとの残高
+}
+
+func planSQL(cfg Config, rng *rand.Rand) *sqlWorkload {
+	w := &sqlWorkload{cfg: cfg, states: []map[int64]int64{{}}}
+	acct := make(map[int64]int64)
+	stmts := []string{
+		"CREATE TABLE accounts (id INT PRIMARY KEY, balance INT NOT NULL, note TEXT)",
+		"CREATE INDEX accounts_balance ON accounts (balance)",
+	}
+	for id := range int64(cfg.Pages) {
+		stmts = append(stmts, fmt.Sprintf("INSERT INTO accounts VALUES (%d, %d, 'opened')", id, initialBalance))
+		acct[id] = initialBalance
+	}
+	w.add(stmts, acct)
+	next := int64(cfg.Pages)
+	for k := 1; k < cfg.Commits; k++ {
+		stmts = nil
+		for range 1 + rng.Intn(cfg.PagesPerCommit) {
+			ids := sortedIDs(acct)
+			a, b := ids[rng.Intn(len(ids))], ids[rng.Intn(len(ids))]
+			switch op := rng.Intn(4); {
+			case op == 0:
+				// 行が長くなるように備考を埋め、ページの分割も起こす
+				note := strings.Repeat(fmt.Sprintf("commit %d ", k+1), 1+rng.Intn(20))
+				stmts = append(stmts, fmt.Sprintf("INSERT INTO accounts VALUES (%d, 0, '%s')", next, note))
+				acct[next] = 0
+				next++
+			case op == 1 && a != b && len(ids) > 2:
+				stmts = append(stmts,
+					fmt.Sprintf("UPDATE accounts SET balance = balance + %d WHERE id = %d", acct[a], b),
+					fmt.Sprintf("DELETE FROM accounts WHERE id = %d", a))
+				acct[b] += acct[a]
+				delete(acct, a)
+			case a != b:
+				amt := int64(1 + rng.Intn(50))
+				stmts = append(stmts,
+					fmt.Sprintf("UPDATE accounts SET balance = balance - %d, note = 'commit %d' WHERE id = %d", amt, k+1, a),
+					fmt.Sprintf("UPDATE accounts SET balance = balance + %d, note = 'commit %d' WHERE id = %d", amt, k+1, b))
+				acct[a] -= amt
+				acct[b] += amt
+			}
+		}
+		w.add(stmts, acct)
+	}
+	return w
+}
+
+// add はコミットで実行する文と、その後の口座の状態を計画に加えます。
+func (w *sqlWorkload) add(stmts []string, acct map[int64]int64) {
+	w.plan = append(w.plan, stmts)
+	w.states = append(w.states, maps.Clone(acct))
+}
+
+func sortedIDs(acct map[int64]int64) []int64 {
+	ids := make([]int64, 0, len(acct))
+	for id := range acct {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+	return ids
+}
+
+// openDB は fs 上の試験用のデータベースを開きます。
+func openDB(cfg Config, fs *vfs.MemFS) (*engine.DB, error) {
+	return engine.Open(dbPath, engine.Options{PageSize: cfg.PageSize, Journal: cfg.Journal, FS: fs})
+}
+
+func (w *sqlWorkload) run(fs *vfs.MemFS) (int, error) {
+	db, err := openDB(w.cfg, fs)
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+	for k, stmts := range w.plan {
+		if err := execAll(db, stmts); err != nil {
+			return k, err
+		}
+		if err := checkpoint(w.cfg, db.Checkpoint, k); err != nil {
+			return k + 1, err
+		}
+	}
+	return len(w.plan), nil
+}
+
+// execAll は stmts を1つのトランザクションで実行してコミットします。
+func execAll(db *engine.DB, stmts []string) error {
+	tx, err := db.Begin(txn.Options{})
+	if err != nil {
+		return err
+	}
+	for _, s := range stmts {
+		if _, err := tx.Exec(s); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("%s: %w", s, err)
+		}
+	}
+	return tx.Commit()
+}
+
+func (w *sqlWorkload) check(fs *vfs.MemFS, done int) string {
+	db, err := openDB(w.cfg, fs)
+	if err != nil {
+		return fmt.Sprintf("recovery failed: %v", err)
+	}
+	defer db.Close()
+
+	problems, err := db.CheckIntegrity()
+	if err != nil {
+		return fmt.Sprintf("integrity check: %v", err)
+	}
+	if len(problems) > 0 {
+		return fmt.Sprintf("integrity check: %s", problems[0])
+	}
+	got, err := accounts(db)
+	if err != nil {
+		return fmt.Sprintf("read accounts: %v", err)
+	}
+	if got != nil {
+		var sum int64
+		for _, b := range got {
+			sum += b
+		}
+		if want := int64(w.cfg.Pages) * initialBalance; sum != want {
+			return fmt.Sprintf("total balance after recovery is %d, want %d", sum, want)
+		}
+	} else {
+		got = map[int64]int64{}
+	}
+	if maps.Equal(got, w.states[done]) {
+		return ""
+	}
+	if done < len(w.plan) && maps.Equal(got, w.states[done+1]) {
+		return ""
+	}
+	return fmt.Sprintf("accounts after recovery %v match neither commit %d %v nor commit %d", got, done, w.states[done], done+1)
+}
+
+// accounts は口座ごとの残高を読みます。テーブルがまだなければ nil を返します。
+func accounts(db *engine.DB) (map[int64]int64, error) {
+	tx, err := db.Begin(txn.Options{ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	cat, err := tx.Catalog()
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := cat.Table("accounts"); !ok {
+		return nil, nil
+	}
+	rows, err := tx.Query("SELECT id, balance FROM accounts")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	got := make(map[int64]int64)
+	for rows.Next() {
+		v := rows.Values()
+		got[v[0].Int()] = v[1].Int()
+	}
+	return got, rows.Err()
+}
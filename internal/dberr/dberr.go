@@ -0,0 +1,69 @@
+// Package dberr はパッケージをまたいで使うエラーの種類を定義します。
+// 各パッケージは自分のエラーに Mark で種類を付けて返し、呼び出し側はメッセージの文字列を
+// 調べずに errors.Is や errors.As で種類を見分けます。
+package dberr
+
+import (
+	"errors"
+	"strings"
+)
+
+var (
+	// ErrPageFull はページに空きがなくてレコードを入れられない場合のエラーです。
+	ErrPageFull = errors.New("page is full")
+	// ErrPageNotFound はファイルの範囲の外のページや、割り当てていないページを使おうとした場合のエラーです。
+	ErrPageNotFound = errors.New("page not found")
+	// ErrCorrupt はファイルの内容が壊れていて読めない場合のエラーです。
+	ErrCorrupt = errors.New("database is corrupt")
+	// ErrDuplicateKey はインデックスに同じキーがすでにある場合のエラーです。一意性の制約の違反も
+	// この種類になります。
+	ErrDuplicateKey = errors.New("duplicate key")
+	// ErrLocked はロックを取れなかった場合のエラーです。待つ時間の上限を過ぎた場合と、
+	// デッドロックになった場合がこの種類になります。
+	ErrLocked = errors.New("could not obtain lock")
+	// ErrConstraint は制約に違反した場合のエラーです。詳しいことは ConstraintError で調べられます。
+	ErrConstraint = errors.New("constraint failed")
+)
+
+// Mark は err に種類 kinds を付けたエラーを返します。返すエラーのメッセージは err と同じで、
+// errors.Is は err とそれが包むエラーにも、kinds のどれにも一致します。
+func Mark(err error, kinds ...error) error {
+	return &marked{err: err, kinds: kinds}
+}
+
+// marked は Mark で種類を付けたエラーです。
+type marked struct {
+	err   error
+	kinds []error
+}
+
+func (e *marked) Error() string { return e.err.Error() }
+
+func (e *marked) Unwrap() []error { return append([]error{e.err}, e.kinds...) }
+
+// ConstraintError は行が制約に違反した場合のエラーです。errors.Is は ErrConstraint と、
+// Err（engine.ErrUnique などの制約ごとのエラー）に一致します。
+type ConstraintError struct {
+	Err     error    // 制約ごとのエラー
+	Table   string   // 違反した行のテーブル
+	Columns []string // 制約の列
+	Detail  string   // メッセージの後半（Err のメッセージの後に続ける）
+}
+
+func (e *ConstraintError) Error() string {
+	if e.Detail == "" {
+		return e.Err.Error()
+	}
+	return e.Err.Error() + ": " + e.Detail
+}
+
+func (e *ConstraintError) Unwrap() error { return e.Err }
+
+// Is は ConstraintError が ErrConstraint に一致するようにします。
+func (e *ConstraintError) Is(target error) bool { return target == ErrConstraint }
+
+// Kind は制約の種類（UNIQUE、NOT NULL、FOREIGN KEY）を返します。
+func (e *ConstraintError) Kind() string {
+	kind, _ := strings.CutSuffix(e.Err.Error(), " constraint failed")
+	return kind
+}
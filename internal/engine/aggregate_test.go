@@ -0,0 +1,87 @@
+package engine
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/k-sml/go-rdbms/internal/exec"
+	"github.com/k-sml/go-rdbms/internal/types"
+)
+
+// TestBuiltinAggregates は組み込みの集約関数の値を、GROUP BY のあるときとないとき、行がないとき、
+// NULL を含むときについて確かめます。
+func TestBuiltinAggregates(t *testing.T) {
+	db := openMemory(t)
+	script(t, db, `
+		CREATE TABLE t (g TEXT, i INT, r REAL, s TEXT);
+		CREATE TABLE e (i INT);
+		CREATE TABLE big (i BIGINT);
+		INSERT INTO big VALUES (9223372036854775807), (1);
+		INSERT INTO t VALUES ('a', 1, 1.5, 'x'), ('a', 2, NULL, 'z'), ('b', NULL, 2.5, 'y'), ('b', 4, 0.5, NULL);
+	`)
+	for _, tt := range []struct {
+		sql  string
+		want [][]string
+	}{
+		{"SELECT COUNT(*), COUNT(i), COUNT(s) FROM t", [][]string{{"4", "3", "3"}}},
+		{"SELECT SUM(i), SUM(r), AVG(i), MIN(i), MAX(i), MIN(s), MAX(s) FROM t",
+			[][]string{{"7", "4.5", "2.3333333333333335", "1", "4", "x", "z"}}},
+		{"SELECT g, COUNT(*), SUM(i) FROM t GROUP BY g ORDER BY g", [][]string{{"a", "2", "3"}, {"b", "2", "4"}}},
+		{"SELECT g FROM t GROUP BY g HAVING COUNT(i) = 2", [][]string{{"a"}}},
+		{"SELECT COUNT(*), COUNT(i), SUM(i), AVG(i), MIN(i), MAX(i) FROM e",
+			[][]string{{"0", "0", "NULL", "NULL", "NULL", "NULL"}}},
+		{"SELECT SUM(i + 0.5) FROM t", [][]string{{"8.5"}}},
+	} {
+		if got := script(t, db, tt.sql); !slices.EqualFunc(got, tt.want, slices.Equal) {
+			t.Errorf("%s = %v, want %v", tt.sql, got, tt.want)
+		}
+	}
+
+	for _, sql := range []string{
+		"SELECT SUM(s) FROM t",
+		"SELECT MAX(*) FROM t",
+		"SELECT i FROM t WHERE COUNT(*) > 1",
+		"SELECT SUM(COUNT(*)) FROM t",
+		"SELECT SUM(i) FROM big", // あふれる
+	} {
+		if _, err := db.ExecScript(sql); err == nil {
+			t.Errorf("%s succeeded, want an error", sql)
+		}
+	}
+}
+
+// productState は TestRegisteredAggregates の集約関数 PRODUCT の状態です。
+type productState struct{ v float64 }
+
+func (p *productState) Step(args []types.Value) error { p.v *= args[0].Real(); return nil }
+
+func (p *productState) Result() (types.Value, error) { return types.NewReal(p.v), nil }
+
+// TestRegisteredAggregates は、登録した集約関数が組み込みの集約関数と一緒に使え、組み込みと同じ名前で
+// 登録した集約関数はそのデータベースでだけ組み込みより優先することを確かめます。
+func TestRegisteredAggregates(t *testing.T) {
+	db := openMemory(t)
+	script(t, db, "CREATE TABLE t (x REAL); INSERT INTO t VALUES (2), (3), (4)")
+	product := func(name string) *exec.Aggregate {
+		return &exec.Aggregate{Name: name, MinArgs: 1, MaxArgs: 1, Strict: true, Args: []types.Type{types.Real},
+			New: func() exec.AggregateState { return &productState{v: 1} }}
+	}
+	if err := db.RegisterAggregate(product("product")); err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{{"3", "9", "24"}}
+	if got := script(t, db, "SELECT COUNT(*), SUM(x), product(x) FROM t"); !slices.EqualFunc(got, want, slices.Equal) {
+		t.Errorf("built-in and registered aggregates = %v, want %v", got, want)
+	}
+
+	if err := db.RegisterAggregate(product("sum")); err != nil {
+		t.Fatal(err)
+	}
+	if got := script(t, db, "SELECT SUM(x) FROM t"); len(got) != 1 || got[0][0] != "24" {
+		t.Errorf("SUM registered on the database = %v, want [[24]]", got)
+	}
+	other := openMemory(t)
+	if got := script(t, other, "CREATE TABLE t (x REAL); INSERT INTO t VALUES (2), (3); SELECT SUM(x) FROM t"); len(got) != 1 || got[0][0] != "5" {
+		t.Errorf("built-in SUM on another database = %v, want [[5]]", got)
+	}
+}
@@ -0,0 +1,138 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/k-sml/go-rdbms/internal/storage"
+	"github.com/k-sml/go-rdbms/internal/txn"
+)
+
+// オンラインバックアップ
+//
+// Backup は読み取り専用のトランザクションを1つ始め、そのスナップショットから見えるページを
+// 先頭から順に書き出す。読み取り専用のトランザクションはロックを取らないので、書き出している
+// 間もほかのトランザクションは読み書きを続けられ、書き出した内容はトランザクションを始めた
+// 時点のデータベースと同じになる。シャドウページングのファイルでも論理ページの順に書くので、
+// 出力はどのジャーナルモードでも開ける通常の形式のデータベースファイルになる。
+//
+// BackupTo は同じスナップショットのページを、開いている別の DB に書き写す。写し先では書き込みの
+// トランザクションを1つ始めて全ページを書き、最後にコミットするので、写し先のほかの利用者には
+// 写す前か写した後のどちらかが見え、途中で失敗すれば写し先は元のままである。写し先の
+// スキーマの版は、どちらの版よりも大きい値に進めるので、写し先で準備済みの文の実行計画は
+// 次の実行で作り直される。
+
+// Backup はほかのトランザクションを止めずにデータベースのスナップショットを w に書き、
+// 書いたページの数を返します。
+func (db *DB) Backup(w io.Writer) (int64, error) {
+	tx, err := db.Begin(txn.Options{ReadOnly: true})
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	return tx.Backup(w)
+}
+
+// Backup はトランザクションから見えるデータベースのすべてのページを、ファイルヘッダから順に
+// w に書き、書いたページの数を返します。
+func (tx *Tx) Backup(w io.Writer) (int64, error) {
+	h, err := storage.ReadHeader(tx.tx)
+	if err != nil {
+		return 0, err
+	}
+	for id := int64(0); id < h.NumPages; id++ {
+		buf, err := tx.tx.ReadPage(id)
+		if err != nil {
+			return id, err
+		}
+		if _, err := w.Write(buf); err != nil {
+			return id, err
+		}
+	}
+	return h.NumPages, nil
+}
+
+// BackupTo はほかのトランザクションを止めずに、データベースのスナップショットを dst に書き写します。
+// dst の内容はすべて置き換えられます。ページは pagesPerStep ページずつ写し（0 以下ならすべてを
+// 一度に）、1ステップごとに progress（nil でなければ）に写したページの数と全体のページの数を渡し、
+// ctx が取り消されていないかを確かめます。dst は db と同じページサイズでなければなりません。
+//
+// 写したページはコミットまで dst のトランザクションが持つので、写す間はデータベースの大きさと
+// 同じだけメモリを使います。写している間 dst はほかの書き込みを待たせます。
+func (db *DB) BackupTo(ctx context.Context, dst *DB, pagesPerStep int, progress func(copied, total int64)) error {
+	if dst == db {
+		return errors.New("cannot back up a database into itself")
+	}
+	if dst.pager.PageSize() != db.pager.PageSize() {
+		return fmt.Errorf("page size of the destination (%d) differs from the source (%d)",
+			dst.pager.PageSize(), db.pager.PageSize())
+	}
+	src, err := db.BeginTx(ctx, txn.Options{ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer src.Rollback()
+	out, err := dst.BeginTx(ctx, txn.Options{})
+	if err != nil {
+		return err
+	}
+	if err := out.copyFrom(ctx, src, pagesPerStep, progress); err != nil {
+		out.Rollback()
+		return err
+	}
+	if err := out.Commit(); err != nil {
+		return err
+	}
+	// 写す前の dst で予約したシーケンスの値は、写したシーケンスとは関係ない
+	dst.seqMu.Lock()
+	clear(dst.seqs)
+	dst.seqMu.Unlock()
+	return nil
+}
+
+// copyFrom は src から見えるすべてのページを tx に書きます。
+func (tx *Tx) copyFrom(ctx context.Context, src *Tx, pagesPerStep int, progress func(copied, total int64)) error {
+	if _, err := tx.tx.BeginWrite(); err != nil {
+		return err
+	}
+	h, err := storage.ReadHeader(src.tx)
+	if err != nil {
+		return err
+	}
+	cur, err := storage.ReadHeader(tx.tx)
+	if err != nil {
+		return err
+	}
+	if pagesPerStep <= 0 {
+		pagesPerStep = int(h.NumPages)
+	}
+	for id := int64(0); id < h.NumPages; id++ {
+		if id%int64(pagesPerStep) == 0 {
+			if progress != nil {
+				progress(id, h.NumPages)
+			}
+			if err := context.Cause(ctx); err != nil {
+				return err
+			}
+		}
+		buf, err := src.tx.ReadPage(id)
+		if err != nil {
+			return err
+		}
+		if err := tx.tx.WritePage(id, buf); err != nil {
+			return err
+		}
+	}
+	// 写した後のヘッダ。写す前の dst の実行計画を使い回さないように、スキーマの版を進める
+	h.SchemaVersion = max(h.SchemaVersion, cur.SchemaVersion) + 1
+	if err := storage.WriteHeader(tx.tx, h); err != nil {
+		return err
+	}
+	tx.tx.TruncateOnCommit()
+	if progress != nil {
+		progress(h.NumPages, h.NumPages)
+	}
+	return nil
+}
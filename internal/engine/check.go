@@ -0,0 +1,373 @@
+package engine
+
+import (
+	"bytes"
+	"cmp"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/k-sml/go-rdbms/internal/btree"
+	"github.com/k-sml/go-rdbms/internal/catalog"
+	"github.com/k-sml/go-rdbms/internal/lock"
+	"github.com/k-sml/go-rdbms/internal/storage"
+	"github.com/k-sml/go-rdbms/internal/txn"
+)
+
+// 整合性の検査
+//
+// CheckIntegrity はファイルの構造を端から端までたどり、矛盾を Problem として集める。
+// 調べるのは次のことである。
+//
+//   - 解放済みのページのリストが、範囲の中の解放済みのページだけをつないでいて、輪になっていないこと
+//   - カタログが読め、インデックスと外部キーが存在するテーブルと列を指していること
+//   - すべてのテーブル（システムテーブルを含む）のディレクトリページとデータページの形が正しく、
+//     行がデコードでき、NOT NULL の列に NULL がないこと
+//   - すべてのインデックスの B+ 木で、キーが順に並び、葉の深さがそろい、葉のつながりが
+//     キーの順になっていること。エントリがテーブルの行と1対1に対応し、一意のインデックスに
+//     同じ値の行がないこと
+//   - 1 からファイルの末尾までのどのページも、ちょうど1つのテーブル、インデックス、
+//     または解放済みのページのリストに属していること
+//
+// 検査はページを読むだけで、何も変更しない。
+
+// Problem は整合性の検査で見つかった問題です。
+type Problem struct {
+	Page   int64  // 問題のあるページ（0 ならページに結び付かない問題）
+	Slot   int    // 問題のある行のスロット（-1 なら行に結び付かない問題）
+	Object string // 問題のあるテーブルやインデックス（空ならファイル全体）
+	Msg    string
+}
+
+func (p Problem) String() string {
+	var b strings.Builder
+	if p.Page != 0 {
+		fmt.Fprintf(&b, "page %d", p.Page)
+		if p.Slot >= 0 {
+			fmt.Fprintf(&b, ", slot %d", p.Slot)
+		}
+		b.WriteString(": ")
+	}
+	if p.Object != "" {
+		b.WriteString(p.Object + ": ")
+	}
+	b.WriteString(p.Msg)
+	return b.String()
+}
+
+// CheckIntegrity は読み取り専用のトランザクションでデータベースの整合性を検査します。
+func (db *DB) CheckIntegrity() ([]Problem, error) {
+	tx, err := db.Begin(txn.Options{ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	return tx.CheckIntegrity()
+}
+
+// CheckIntegrity はトランザクションから見えるデータベースの整合性を検査し、見つかった問題を
+// 返します。問題がなければ nil です。エラーはページを読めなかった場合などに返し、
+// 構造の矛盾はエラーではなく Problem として返します。
+func (tx *Tx) CheckIntegrity() ([]Problem, error) {
+	h, err := storage.ReadHeader(tx.tx)
+	if err != nil {
+		return nil, err
+	}
+	c := &checker{tx: tx, numPages: h.NumPages, owner: make(map[int64]string)}
+	if err := c.freeList(h.FreeHead); err != nil {
+		return nil, err
+	}
+	cat, err := tx.Catalog()
+	if err != nil {
+		c.report(0, -1, "catalog", "cannot be read: %v", err)
+		c.log()
+		return c.problems, nil
+	}
+	for _, t := range cat.AllTables() {
+		if err := c.table(cat, t); err != nil {
+			return nil, err
+		}
+	}
+	for id := int64(1); id < c.numPages; id++ {
+		if _, ok := c.owner[id]; !ok {
+			c.report(id, -1, "", "page is not used by any table or index and is not on the free list")
+		}
+	}
+	c.log()
+	return c.problems, nil
+}
+
+// checker は整合性の検査の状態です。
+type checker struct {
+	tx       *Tx
+	numPages int64
+	owner    map[int64]string // ページの持ち主（テーブル、インデックス、解放済みのページのリスト）
+	problems []Problem
+}
+
+func (c *checker) report(page int64, slot int, object, format string, args ...any) {
+	c.problems = append(c.problems, Problem{Page: page, Slot: slot, Object: object, Msg: fmt.Sprintf(format, args...)})
+}
+
+// log は見つかった問題を記録します。
+func (c *checker) log() {
+	if len(c.problems) > 0 {
+		c.tx.db.logger.Error("integrity check found problems", "problems", len(c.problems), "first", c.problems[0].String())
+	}
+}
+
+// claim はページ id を object のものとして記録します。範囲の外のページや、すでにほかの持ち主が
+// いるページなら問題を記録して false を返します。
+func (c *checker) claim(id int64, object string) bool {
+	if id <= 0 || id >= c.numPages {
+		c.report(0, -1, object, "refers to page %d outside the file (%d pages)", id, c.numPages)
+		return false
+	}
+	if prev, ok := c.owner[id]; ok {
+		if prev == object {
+			c.report(id, -1, object, "page is reached twice")
+		} else {
+			c.report(id, -1, object, "page is also used by %s", prev)
+		}
+		return false
+	}
+	c.owner[id] = object
+	return true
+}
+
+// freeList は解放済みのページのリストをたどります。
+func (c *checker) freeList(head int64) error {
+	const object = "free list"
+	for id := head; id != 0; {
+		if !c.claim(id, object) {
+			return nil
+		}
+		buf, err := c.tx.tx.ReadPage(id)
+		if err != nil {
+			return err
+		}
+		if !storage.IsFreePage(buf) {
+			c.report(id, -1, object, "page on the free list is not marked free")
+			return nil
+		}
+		id = storage.NextFreePage(buf)
+	}
+	return nil
+}
+
+// table はテーブル t のヒープファイルと、t のインデックスを検査します。
+func (c *checker) table(cat *catalog.Catalog, t *catalog.Table) error {
+	object := "table " + t.Name
+	if !t.System {
+		if err := c.tx.lockTable(t.Name, lock.Shared); err != nil {
+			return err
+		}
+	}
+	for _, fk := range t.ForeignKeys {
+		if _, ok := cat.Table(fk.RefTable); !ok {
+			c.report(0, -1, object, "foreign key refers to missing table %s", fk.RefTable)
+		}
+	}
+	// 行の位置ごとに、インデックスにあるはずのキー
+	ixs := cat.Indexes(t.Name)
+	want := make([]map[string]storage.RID, len(ixs))
+	unique := make([]map[string]storage.RID, len(ixs))
+	for i, ix := range ixs {
+		want[i] = make(map[string]storage.RID)
+		unique[i] = make(map[string]storage.RID)
+		for _, col := range ix.Columns {
+			if _, ok := t.Column(col); !ok {
+				c.report(0, -1, "index "+ix.Name, "column %s does not exist in table %s", col, t.Name)
+				want[i] = nil
+			}
+		}
+	}
+
+	hf := c.tx.heap(t)
+	for dir := t.Root; dir != 0; {
+		if !c.claim(dir, object) {
+			break
+		}
+		buf, err := c.tx.tx.ReadPage(dir)
+		if err != nil {
+			return err
+		}
+		if !storage.IsDirPage(buf) {
+			c.report(dir, -1, object, "page is not a heap directory")
+			break
+		}
+		pages, next := storage.ReadDirPage(buf)
+		for _, id := range pages {
+			if !c.claim(id, object) {
+				continue
+			}
+			buf, err := c.tx.tx.ReadPage(id)
+			if err != nil {
+				return err
+			}
+			if !storage.LooksLikeDataPage(buf) {
+				c.report(id, -1, object, "page is not a valid heap page")
+				continue
+			}
+			hp, err := storage.OpenDataPage(buf)
+			if err != nil {
+				c.report(id, -1, object, "page cannot be read: %v", err)
+				continue
+			}
+			for slot := 0; slot < hp.NumSlots(); slot++ {
+				rid := storage.RID{PageID: id, Slot: slot}
+				rec, ok, err := hf.Get(rid)
+				if err != nil {
+					c.report(id, slot, object, "record cannot be read: %v", err)
+					continue
+				}
+				if !ok {
+					continue
+				}
+				row, err := decodeRow(t, rid, rec)
+				if err != nil {
+					c.report(id, slot, object, "row cannot be decoded: %v", err)
+					continue
+				}
+				for i, col := range t.Columns {
+					if col.NotNull && row[i].IsNull() {
+						c.report(id, slot, object, "column %s is NULL but declared NOT NULL", col.Name)
+					}
+				}
+				for i, ix := range ixs {
+					if want[i] == nil {
+						continue
+					}
+					want[i][string(indexKey(t, ix, row, rid))] = rid
+					if prefix, null := indexPrefix(t, ix, row); ix.Unique && !null {
+						if other, dup := unique[i][string(prefix)]; dup {
+							c.report(id, slot, "index "+ix.Name, "row has the same key as row %s in a unique index", other)
+						}
+						unique[i][string(prefix)] = rid
+					}
+				}
+			}
+		}
+		dir = next
+	}
+	for i, ix := range ixs {
+		if err := c.index(ix, want[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// index はインデックス ix の B+ 木を検査します。want が nil でなければ、葉のエントリが
+// want のキーとちょうど一致することも確かめます。
+func (c *checker) index(ix *catalog.Index, want map[string]storage.RID) error {
+	ic := &indexCheck{checker: c, object: "index " + ix.Name, leafDepth: -1}
+	if err := ic.node(ix.Root, nil, nil, 0); err != nil {
+		return err
+	}
+	// 葉のつながりは、木をたどった順の葉と同じでなければならない
+	for i, leaf := range ic.leaves {
+		next := int64(0)
+		if i+1 < len(ic.leaves) {
+			next = ic.leaves[i+1].id
+		}
+		if leaf.next != next {
+			c.report(leaf.id, -1, ic.object, "leaf links to page %d, want %d", leaf.next, next)
+		}
+	}
+	if want == nil {
+		return nil
+	}
+	for _, leaf := range ic.leaves {
+		for _, k := range leaf.keys {
+			if len(k) < ridSize {
+				c.report(leaf.id, -1, ic.object, "entry key is too short (%d bytes)", len(k))
+				continue
+			}
+			if _, ok := want[string(k)]; !ok {
+				rid := keyRID(k)
+				c.report(leaf.id, -1, ic.object, "entry for row %s does not match any row", rid)
+				continue
+			}
+			delete(want, string(k))
+		}
+	}
+	missing := make([]storage.RID, 0, len(want))
+	for _, rid := range want {
+		missing = append(missing, rid)
+	}
+	slices.SortFunc(missing, func(a, b storage.RID) int {
+		return cmp.Or(cmp.Compare(a.PageID, b.PageID), cmp.Compare(a.Slot, b.Slot))
+	})
+	for _, rid := range missing {
+		c.report(rid.PageID, rid.Slot, ic.object, "row is missing from the index")
+	}
+	return nil
+}
+
+// indexCheck は1つのインデックスの B+ 木の検査の状態です。
+type indexCheck struct {
+	*checker
+	object    string
+	leafDepth int // 葉の深さ（まだ葉に着いていなければ -1）
+	leaves    []checkedLeaf
+}
+
+// checkedLeaf は木をたどった順に集めた葉です。
+type checkedLeaf struct {
+	id   int64
+	next int64
+	keys [][]byte
+}
+
+// node はページ id を根とする部分木を検査します。キーはすべて lo 以上 hi 未満でなければなりません
+// （nil は制限なし）。
+func (ic *indexCheck) node(id int64, lo, hi []byte, depth int) error {
+	if !ic.claim(id, ic.object) {
+		return nil
+	}
+	buf, err := ic.tx.tx.ReadPage(id)
+	if err != nil {
+		return err
+	}
+	n, err := btree.DecodeNode(buf)
+	if err != nil {
+		ic.report(id, -1, ic.object, "%v", err)
+		return nil
+	}
+	for i, k := range n.Keys {
+		if i > 0 && bytes.Compare(n.Keys[i-1], k) >= 0 {
+			ic.report(id, -1, ic.object, "keys %d and %d are out of order", i-1, i)
+		}
+		if lo != nil && bytes.Compare(k, lo) < 0 || hi != nil && bytes.Compare(k, hi) >= 0 {
+			ic.report(id, -1, ic.object, "key %d is outside the range of its parent", i)
+		}
+	}
+	if n.Leaf {
+		if ic.leafDepth < 0 {
+			ic.leafDepth = depth
+		} else if depth != ic.leafDepth {
+			ic.report(id, -1, ic.object, "leaf is at depth %d, other leaves at depth %d", depth, ic.leafDepth)
+		}
+		ic.leaves = append(ic.leaves, checkedLeaf{id: id, next: n.Next, keys: n.Keys})
+		return nil
+	}
+	// 最も左の子はキー keys[0] より小さく、children[i] は keys[i] 以上 keys[i+1] 未満
+	upper := hi
+	if len(n.Keys) > 0 {
+		upper = n.Keys[0]
+	}
+	if err := ic.node(n.Next, lo, upper, depth+1); err != nil {
+		return err
+	}
+	for i, child := range n.Children {
+		upper := hi
+		if i+1 < len(n.Keys) {
+			upper = n.Keys[i+1]
+		}
+		if err := ic.node(child, n.Keys[i], upper, depth+1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package engine
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/k-sml/go-rdbms/internal/storage"
+	"github.com/k-sml/go-rdbms/internal/txn"
+)
+
+// TestColumnarLayout は、WITH (layout = columnar) のテーブルのデータページが列形式で、挿入・更新・削除・
+// 列の追加・VACUUM の後も、同じ操作をした行形式のテーブルと同じ結果を返すことを確かめます。
+func TestColumnarLayout(t *testing.T) {
+	db := openMemory(t)
+	for _, name := range []string{"r", "c"} {
+		with := ""
+		if name == "c" {
+			with = " WITH (layout = columnar, fill_factor = 90)"
+		}
+		script(t, db, fmt.Sprintf(`CREATE TABLE %[1]s (id INT PRIMARY KEY, s INT, v TEXT)%[2]s;
+			CREATE INDEX %[1]s_s ON %[1]s (s);
+			INSERT INTO %[1]s WITH RECURSIVE g(n) AS (SELECT 1 UNION ALL SELECT n + 1 FROM g WHERE n < 3000)
+				SELECT n, n %% 10, CASE WHEN n %% 7 = 0 THEN NULL ELSE 'value ' || n END FROM g;
+			UPDATE %[1]s SET v = v || ' updated' WHERE s = 3;
+			DELETE FROM %[1]s WHERE s = 5;
+			ALTER TABLE %[1]s ADD COLUMN w INT DEFAULT 7;
+			INSERT INTO %[1]s VALUES (5000, 5, 'new', 8)`, name, with))
+	}
+
+	tx, err := db.Begin(txn.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tbl, err := tx.table("c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids, err := tx.heap(tbl).Pages()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range ids {
+		buf, err := tx.tx.ReadPage(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !storage.IsColumnPage(buf) {
+			t.Fatalf("data page %d of the columnar table is not a columnar page", id)
+		}
+	}
+	tx.Rollback()
+
+	queries := []string{
+		"SELECT * FROM %s ORDER BY id",
+		"SELECT v FROM %s WHERE id = 1234",
+		"SELECT id, w FROM %s WHERE s = 5",
+		"SELECT id, s FROM %s WHERE v IS NULL ORDER BY id",
+	}
+	compare := func(when string) {
+		t.Helper()
+		for _, q := range queries {
+			want := script(t, db, fmt.Sprintf(q, "r"))
+			if len(want) == 0 {
+				t.Fatalf("%s on the row table returned no rows", q)
+			}
+			got := script(t, db, fmt.Sprintf(q, "c"))
+			if !slices.EqualFunc(got, want, slices.Equal) {
+				t.Errorf("%s: %s on the columnar table returned %d rows different from the row table", when, q, len(got))
+			}
+		}
+	}
+	compare("before VACUUM")
+
+	if _, err := db.Vacuum(); err != nil {
+		t.Fatal(err)
+	}
+	compare("after VACUUM")
+	problems, err := db.CheckIntegrity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range problems {
+		t.Errorf("integrity check: %v", p)
+	}
+
+	_, err = db.ExecScript("CREATE TABLE z (id INT) WITH (layout = columnar, compression = deflate)")
+	if err == nil || !strings.Contains(err.Error(), "columnar") {
+		t.Errorf("columnar layout with compression: err = %v, want an error", err)
+	}
+}
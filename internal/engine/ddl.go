@@ -0,0 +1,619 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/k-sml/go-rdbms/internal/catalog"
+	"github.com/k-sml/go-rdbms/internal/exec"
+	"github.com/k-sml/go-rdbms/internal/lock"
+	"github.com/k-sml/go-rdbms/internal/sql/ast"
+	"github.com/k-sml/go-rdbms/internal/sql/lexer"
+	"github.com/k-sml/go-rdbms/internal/sql/parser"
+	"github.com/k-sml/go-rdbms/internal/types"
+)
+
+// Schema はテーブルの定義です。
+type Schema struct {
+	Name        string
+	Columns     []catalog.Column
+	ForeignKeys []catalog.ForeignKey
+	Options     catalog.StorageOptions
+	Temp        bool       // セッションの一時テーブルにする（session.go）
+	Unique      [][]string // UNIQUE 制約の列。それぞれ一意インデックスにする
+}
+
+// CreateTable はテーブルを作成します。
+func (db *DB) CreateTable(s Schema) error {
+	return db.update(func(tx *Tx) error { return tx.CreateTable(s) })
+}
+
+// DropTable はテーブルを削除し、そのページをすべて解放します。
+func (db *DB) DropTable(name string) error {
+	return db.update(func(tx *Tx) error { return tx.DropTable(name) })
+}
+
+// RenameTable はテーブルの名前を変更します。
+func (db *DB) RenameTable(name, to string) error {
+	return db.update(func(tx *Tx) error { return tx.RenameTable(name, to) })
+}
+
+// RenameIndex はインデックスの名前を変更します。
+func (db *DB) RenameIndex(name, to string) error {
+	return db.update(func(tx *Tx) error { return tx.RenameIndex(name, to) })
+}
+
+// CreateView はビューを作成します。
+func (db *DB) CreateView(name, query string, columns []string) error {
+	return db.update(func(tx *Tx) error { return tx.CreateView(name, query, columns) })
+}
+
+// DropView はビューを削除します。
+func (db *DB) DropView(name string) error {
+	return db.update(func(tx *Tx) error { return tx.DropView(name) })
+}
+
+// AddColumn はテーブルに列を追加します。
+func (db *DB) AddColumn(table string, col catalog.Column) error {
+	return db.update(func(tx *Tx) error { return tx.AddColumn(table, col) })
+}
+
+// DropColumn はテーブルから列を削除します。
+func (db *DB) DropColumn(table, column string) error {
+	return db.update(func(tx *Tx) error { return tx.DropColumn(table, column) })
+}
+
+// RenameColumn は列の名前を変更します。
+func (db *DB) RenameColumn(table, column, name string) error {
+	return db.update(func(tx *Tx) error { return tx.RenameColumn(table, column, name) })
+}
+
+// CreateTable はトランザクションの中でテーブルを作成します。主キーがあれば、その列の一意インデックス
+// （catalog.PrimaryKeyIndex）も作成します。UNIQUE 制約は「テーブル名_列名_key」という名前の
+// 一意インデックスにします。このインデックスは CREATE UNIQUE INDEX で作ったものと同じで、
+// DROP INDEX で削除できます。
+func (tx *Tx) CreateTable(s Schema) error {
+	if err := tx.beginWrite(); err != nil {
+		return err
+	}
+	cat, err := tx.Catalog()
+	if err != nil {
+		return err
+	}
+	if err := tx.lockTable(s.Name, lock.Exclusive); err != nil {
+		return err
+	}
+	for _, fk := range s.ForeignKeys {
+		if !strings.EqualFold(fk.RefTable, s.Name) {
+			if err := tx.lockTable(fk.RefTable, lock.Shared); err != nil {
+				return err
+			}
+		}
+	}
+	t, err := cat.CreateTable(catalog.Table{
+		Name: s.Name, Columns: s.Columns, ForeignKeys: s.ForeignKeys, Options: s.Options, Temp: s.Temp,
+	})
+	if err != nil {
+		return err
+	}
+	// 主キーの一意性は一意インデックスで保つ
+	var pk []string
+	for _, col := range t.Columns {
+		if col.PrimaryKey {
+			pk = append(pk, col.Name)
+		}
+	}
+	if len(pk) > 0 {
+		if err := tx.CreateIndex(catalog.PrimaryKeyIndex(t.Name), t.Name, pk, true); err != nil {
+			return err
+		}
+	}
+	done := [][]string{pk}
+	for _, cols := range s.Unique {
+		// 主キーやほかの UNIQUE 制約と同じ列なら、インデックスを重ねて作らない
+		if slices.ContainsFunc(done, func(d []string) bool { return slices.EqualFunc(d, cols, strings.EqualFold) }) {
+			continue
+		}
+		done = append(done, cols)
+		if err := tx.CreateIndex(uniqueIndexName(cat, t.Name, cols), t.Name, cols, true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// uniqueIndexName は UNIQUE 制約の一意インデックスの名前を返します。同じ名前のインデックスが
+// あれば、後ろに番号を付けます。
+func uniqueIndexName(cat *catalog.Catalog, table string, cols []string) string {
+	base := table + "_" + strings.Join(cols, "_") + "_key"
+	name := base
+	for i := 1; ; i++ {
+		if _, ok := cat.Index(name); !ok {
+			return name
+		}
+		name = fmt.Sprintf("%s%d", base, i)
+	}
+}
+
+// DropTable はトランザクションの中でテーブルを削除します。
+func (tx *Tx) DropTable(name string) error {
+	if err := tx.beginWrite(); err != nil {
+		return err
+	}
+	cat, err := tx.Catalog()
+	if err != nil {
+		return err
+	}
+	if err := tx.lockTable(name, lock.Exclusive); err != nil {
+		return err
+	}
+	t, ok := cat.Table(name)
+	if !ok {
+		return cat.DropTable(name)
+	}
+	// 同じ名前で作り直したテーブルに、古いシーケンスの予約を使わせない
+	for _, col := range t.Columns {
+		if col.AutoIncrement {
+			tx.resetSeq(catalog.SequenceName(t.Name, col.Name))
+		}
+	}
+	ixs := cat.Indexes(t.Name)
+	if err := cat.DropTable(name); err != nil {
+		return err
+	}
+	for _, ix := range ixs {
+		if err := tx.tree(ix).Drop(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RenameTable はトランザクションの中でテーブルの名前を変更します。
+// 古い名前と新しい名前の両方を排他ロックします。テーブルを使うビューがあれば変更しません。
+func (tx *Tx) RenameTable(name, to string) error {
+	cat, err := tx.alter(name)
+	if err != nil {
+		return err
+	}
+	if err := tx.lockTable(to, lock.Exclusive); err != nil {
+		return err
+	}
+	if t, ok := cat.Table(name); ok {
+		nt := *t
+		nt.Name = to
+		if err := tx.checkViews(cat, alteredSource{source{tx}, &nt, t.Name}, "rename table "+t.Name); err != nil {
+			return err
+		}
+		for _, col := range t.Columns {
+			if col.AutoIncrement {
+				tx.resetSeq(catalog.SequenceName(t.Name, col.Name))
+			}
+		}
+	}
+	return cat.RenameTable(name, to)
+}
+
+// RenameIndex はトランザクションの中でインデックスの名前を変更します。主キーのインデックスの名前は
+// テーブルの名前から決まるので変更できません。
+func (tx *Tx) RenameIndex(name, to string) error {
+	if err := tx.beginWrite(); err != nil {
+		return err
+	}
+	cat, err := tx.Catalog()
+	if err != nil {
+		return err
+	}
+	if ix, ok := cat.Index(name); ok {
+		if cat.IsPrimaryKey(ix) {
+			return fmt.Errorf("cannot rename the PRIMARY KEY index %s", ix.Name)
+		}
+		if err := tx.lockTable(ix.Table, lock.Exclusive); err != nil {
+			return err
+		}
+	}
+	return cat.RenameIndex(name, to)
+}
+
+// CreateView はトランザクションの中でビューを作成します。ビューはテーブルと同じ名前空間にあるので、
+// テーブルと同じくビューの名前を排他ロックします。
+func (tx *Tx) CreateView(name, query string, columns []string) error {
+	cat, err := tx.alter(name)
+	if err != nil {
+		return err
+	}
+	_, err = cat.CreateView(name, query, columns)
+	return err
+}
+
+// DropView はトランザクションの中でビューを削除します。
+func (tx *Tx) DropView(name string) error {
+	cat, err := tx.alter(name)
+	if err != nil {
+		return err
+	}
+	return cat.DropView(name)
+}
+
+// AddColumn はトランザクションの中でテーブルに列を追加します。既存の行は書き換えず、
+// 読むときに列の既定値を補います。
+func (tx *Tx) AddColumn(table string, col catalog.Column) error {
+	cat, err := tx.alter(table)
+	if err != nil {
+		return err
+	}
+	return cat.AddColumn(table, col)
+}
+
+// DropColumn はトランザクションの中でテーブルから列を削除します。
+// テーブルのすべての行を書き直すので、行の位置（RID）は変わり、インデックスも作り直します。
+// 列を使うビューがあれば削除しません。
+func (tx *Tx) DropColumn(table, column string) error {
+	cat, err := tx.alter(table)
+	if err != nil {
+		return err
+	}
+	if t, ok := cat.Table(table); ok {
+		if i, ok := t.Column(column); ok {
+			nt := *t
+			nt.Columns = slices.Delete(slices.Clone(t.Columns), i, i+1)
+			if err := tx.checkViews(cat, alteredSource{source{tx}, &nt, ""}, "drop column "+t.Name+"."+t.Columns[i].Name); err != nil {
+				return err
+			}
+		}
+	}
+	if err := cat.DropColumn(table, column); err != nil {
+		return err
+	}
+	return tx.rebuildIndexes(table)
+}
+
+// RenameColumn はトランザクションの中で列の名前を変更します。列を使うビューがあれば変更しません。
+func (tx *Tx) RenameColumn(table, column, name string) error {
+	cat, err := tx.alter(table)
+	if err != nil {
+		return err
+	}
+	if t, ok := cat.Table(table); ok {
+		if i, ok := t.Column(column); ok {
+			nt := *t
+			nt.Columns = slices.Clone(t.Columns)
+			nt.Columns[i].Name = name
+			if err := tx.checkViews(cat, alteredSource{source{tx}, &nt, ""}, "rename column "+t.Name+"."+t.Columns[i].Name); err != nil {
+				return err
+			}
+			if t.Columns[i].AutoIncrement {
+				// シーケンスの名前が変わるので、古い名前の予約は使わない
+				tx.resetSeq(catalog.SequenceName(t.Name, t.Columns[i].Name))
+			}
+		}
+	}
+	return cat.RenameColumn(table, column, name)
+}
+
+// checkViews は、テーブルを変更した後の src に対してもビューがそのまま使えるかを調べます。ビューの
+// 問い合わせが src に対して解決できなくなるか、結果の列（* で読む列を含む）が変わるなら、change で
+// 表す変更を拒否します。ビューは問い合わせの文として保存しているので、書き換えはしません。
+func (tx *Tx) checkViews(cat *catalog.Catalog, src alteredSource, change string) error {
+	for _, v := range cat.Views() {
+		before, err := tx.viewColumns(v, source{tx})
+		if err != nil {
+			continue // もともと使えないビューは、この変更で壊れるわけではない
+		}
+		after, err := tx.viewColumns(v, src)
+		if err != nil || !slices.Equal(before, after) {
+			return fmt.Errorf("cannot %s: view %s depends on it", change, v.Name)
+		}
+	}
+	return nil
+}
+
+// viewColumns はビュー v の問い合わせを src に対して解決し、結果の列を返します。行は読みません。
+func (tx *Tx) viewColumns(v *catalog.View, src exec.Source) ([]exec.Column, error) {
+	stmt, err := parser.Parse("SELECT * FROM " + lexer.QuoteIdent(v.Name))
+	if err != nil {
+		return nil, err
+	}
+	if err := exec.Bind(src, stmt); err != nil {
+		return nil, err
+	}
+	op, err := exec.Plan(src, stmt.(*ast.Select), nil)
+	if err != nil {
+		return nil, err
+	}
+	return op.Columns(), nil
+}
+
+// alteredSource は、テーブル t.Name を変更した後の定義 t として見せる exec.Source です。
+// 変更前のインデックスと統計情報は t の列と合わないので見せません。テーブルの名前を変えるときは、
+// old に元の名前を入れて、そのテーブルがないように見せます。
+type alteredSource struct {
+	exec.Source
+	t   *catalog.Table
+	old string
+}
+
+func (s alteredSource) Table(name string) (*catalog.Table, error) {
+	switch {
+	case strings.EqualFold(name, s.t.Name):
+		return s.t, nil
+	case s.old != "" && strings.EqualFold(name, s.old):
+		return nil, fmt.Errorf("%w: %s", catalog.ErrTableNotFound, name)
+	}
+	return s.Source.Table(name)
+}
+
+func (s alteredSource) Indexes(table string) []*catalog.Index {
+	if strings.EqualFold(table, s.t.Name) {
+		return nil
+	}
+	return s.Source.Indexes(table)
+}
+
+func (s alteredSource) Stats(t *catalog.Table) *catalog.TableStats {
+	if t == s.t {
+		return nil
+	}
+	return s.Source.Stats(t)
+}
+
+// alter はテーブルの定義を変更するために、テーブルを排他ロックしてカタログを返します。
+func (tx *Tx) alter(table string) (*catalog.Catalog, error) {
+	if err := tx.beginWrite(); err != nil {
+		return nil, err
+	}
+	cat, err := tx.Catalog()
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.lockTable(table, lock.Exclusive); err != nil {
+		return nil, err
+	}
+	return cat, nil
+}
+
+// beginWrite はスキーマや行を変更する操作がページを読む前に書き込み権を取ります。
+// 新しく取ったときは、それまでに読んだカタログは古いかもしれないので捨てます。
+func (tx *Tx) beginWrite() error {
+	acquired, err := tx.tx.BeginWrite()
+	if acquired {
+		tx.cat = nil
+	}
+	return err
+}
+
+// lockTable はテーブルをロックします。テーブル名は大文字と小文字を区別しないので、
+// 小文字にした名前をロックの対象にします。セッションの一時テーブルは、ほかのセッションの
+// 同じ名前のテーブルを待たないように、保存した名前をロックします。
+func (tx *Tx) lockTable(name string, mode lock.Mode) error {
+	if tx.session != 0 {
+		if cat, err := tx.Catalog(); err == nil {
+			if t, ok := cat.Table(name); ok && t.Temp {
+				name = cat.StoredName(t)
+			}
+		}
+	}
+	return tx.tx.LockTable(strings.ToLower(name), mode)
+}
+
+// planDDL は SQL の DDL 文の実行計画を作ります。IF EXISTS と IF NOT EXISTS があれば、
+// 対象がない、またはすでにあることはエラーにしません。
+func (tx *Tx) planDDL(stmt ast.Stmt, src exec.Source) (func(*Tx) (int64, error), error) {
+	var run func(tx *Tx) error
+	switch s := stmt.(type) {
+	case *ast.CreateTable:
+		schema, err := tableSchema(s)
+		if err != nil {
+			return nil, err
+		}
+		run = func(tx *Tx) error {
+			return ignore(tx.CreateTable(schema), s.IfNotExists, catalog.ErrTableExists)
+		}
+	case *ast.DropTable:
+		run = func(tx *Tx) error { return ignore(tx.DropTable(s.Name), s.IfExists, catalog.ErrTableNotFound) }
+	case *ast.CreateIndex:
+		run = func(tx *Tx) error {
+			return ignore(tx.CreateIndex(s.Name, s.Table, s.Columns, s.Unique), s.IfNotExists, catalog.ErrIndexExists)
+		}
+	case *ast.DropIndex:
+		run = func(tx *Tx) error { return ignore(tx.DropIndex(s.Name), s.IfExists, catalog.ErrIndexNotFound) }
+	case *ast.CreateView:
+		// 作るときに問い合わせの名前と型を検査しておく
+		vs := &viewSource{Source: src}
+		if err := exec.Bind(vs, s.Query); err != nil {
+			return nil, err
+		}
+		if vs.temp != "" {
+			// ほかのセッションでは同じ名前が別のテーブルか、何も指さない
+			return nil, fmt.Errorf("view %s cannot use temporary table %s", s.Name, vs.temp)
+		}
+		run = func(tx *Tx) error { return tx.CreateView(s.Name, s.Text, s.Columns) }
+	case *ast.DropView:
+		run = func(tx *Tx) error { return ignore(tx.DropView(s.Name), s.IfExists, catalog.ErrViewNotFound) }
+	case *ast.AlterIndex:
+		run = func(tx *Tx) error { return tx.RenameIndex(s.Name, s.To) }
+	case *ast.AlterTable:
+		var err error
+		if run, err = alterTable(s); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, errors.New("unsupported statement")
+	}
+	return func(tx *Tx) (int64, error) { return 0, run(tx) }, nil
+}
+
+// ignore は err が target のエラーで、skip が true なら nil を返します。
+func ignore(err error, skip bool, target error) error {
+	if skip && errors.Is(err, target) {
+		return nil
+	}
+	return err
+}
+
+// alterTable は ALTER TABLE 文を実行する関数を返します。
+func alterTable(s *ast.AlterTable) (func(tx *Tx) error, error) {
+	switch a := s.Action.(type) {
+	case *ast.AddColumn:
+		if a.Column.References != nil {
+			return nil, errors.New("ALTER TABLE ADD COLUMN cannot add a foreign key")
+		}
+		if a.Column.Unique {
+			return nil, errors.New("ALTER TABLE ADD COLUMN cannot add a UNIQUE constraint; use CREATE UNIQUE INDEX")
+		}
+		col, err := columnOf(a.Column)
+		if err != nil {
+			return nil, err
+		}
+		return func(tx *Tx) error { return tx.AddColumn(s.Table, col) }, nil
+	case *ast.DropColumn:
+		return func(tx *Tx) error { return tx.DropColumn(s.Table, a.Name) }, nil
+	case *ast.RenameColumn:
+		return func(tx *Tx) error { return tx.RenameColumn(s.Table, a.Old, a.New) }, nil
+	case *ast.RenameTable:
+		return func(tx *Tx) error { return tx.RenameTable(s.Table, a.To) }, nil
+	}
+	return nil, fmt.Errorf("unsupported ALTER TABLE action %T", s.Action)
+}
+
+// tableSchema は CREATE TABLE 文のテーブルの定義を返します。
+func tableSchema(s *ast.CreateTable) (Schema, error) {
+	schema := Schema{Name: s.Name, Temp: s.Temporary}
+	for _, d := range s.Columns {
+		col, err := columnOf(d)
+		if err != nil {
+			return Schema{}, err
+		}
+		schema.Columns = append(schema.Columns, col)
+	}
+	for _, name := range s.PrimaryKey {
+		i := slices.IndexFunc(schema.Columns, func(c catalog.Column) bool { return strings.EqualFold(c.Name, name) })
+		if i < 0 {
+			return Schema{}, fmt.Errorf("table %s has no column named %s", s.Name, name)
+		}
+		schema.Columns[i].PrimaryKey = true
+	}
+	for _, d := range s.Columns {
+		if d.Unique {
+			schema.Unique = append(schema.Unique, []string{d.Name})
+		}
+	}
+	schema.Unique = append(schema.Unique, s.Unique...)
+	var defs []ast.ForeignKeyDef
+	for _, d := range s.Columns {
+		if d.References != nil {
+			fk := *d.References
+			fk.Columns = []string{d.Name}
+			defs = append(defs, fk)
+		}
+	}
+	for _, d := range append(defs, s.ForeignKeys...) {
+		fk, err := foreignKeyOf(d)
+		if err != nil {
+			return Schema{}, err
+		}
+		schema.ForeignKeys = append(schema.ForeignKeys, fk)
+	}
+	var err error
+	schema.Options, err = storageOptions(s.Options)
+	return schema, err
+}
+
+// columnOf は列の定義 d の列を返します。既定値の式は定数でなければなりません。
+func columnOf(d ast.ColumnDef) (catalog.Column, error) {
+	col := catalog.Column{Name: d.Name, Type: d.Type, NotNull: d.NotNull, PrimaryKey: d.PrimaryKey, AutoIncrement: d.AutoIncrement}
+	if d.Default != nil {
+		v, err := exec.EvalConst(d.Default)
+		if err != nil {
+			return col, fmt.Errorf("default of column %s: %w", d.Name, err)
+		}
+		col.Default = v
+	}
+	return col, nil
+}
+
+// foreignKeyOf は外部キー制約 d の外部キーを返します。
+func foreignKeyOf(d ast.ForeignKeyDef) (catalog.ForeignKey, error) {
+	fk := catalog.ForeignKey{Columns: d.Columns, RefTable: d.RefTable, RefColumns: d.RefColumns, Deferred: d.Deferred}
+	var err error
+	if d.OnDelete != "" {
+		if fk.OnDelete, err = catalog.ParseAction(d.OnDelete); err != nil {
+			return fk, err
+		}
+	}
+	if d.OnUpdate != "" {
+		if fk.OnUpdate, err = catalog.ParseAction(d.OnUpdate); err != nil {
+			return fk, err
+		}
+	}
+	return fk, nil
+}
+
+// storageOptions は WITH (...) の設定から、テーブルの格納方法を返します。設定の名前は fill_factor、
+// append_only、layout、compression です。
+//
+// layout = columnar と compression = deflate は一緒に指定できません（catalog.StorageOptions の
+// check がエラーにします）。列形式のページは値を列ごとに圧縮せずに並べ、挿入や削除でページの一部を
+// その場で書き換えるので、行ごとに DEFLATE で圧縮するレコードを格納できないためです。
+func storageOptions(opts []ast.Option) (catalog.StorageOptions, error) {
+	var o catalog.StorageOptions
+	for _, opt := range opts {
+		var v types.Value
+		if ref, ok := opt.Value.(*ast.ColumnRef); ok && ref.Table == "" {
+			// WITH (layout = row) のように識別子で書いた値
+			v = types.NewText(ref.Column)
+		} else {
+			var err error
+			if v, err = exec.EvalConst(opt.Value); err != nil {
+				return o, err
+			}
+		}
+		var err error
+		switch name := strings.ToLower(opt.Name); name {
+		case "fill_factor", "fillfactor":
+			if !v.Type().IsInteger() {
+				return o, fmt.Errorf("storage option %s must be an integer", name)
+			}
+			o.FillFactor = int(v.Int())
+		case "append_only":
+			if v.Type() != types.Boolean {
+				return o, fmt.Errorf("storage option %s must be a boolean", name)
+			}
+			o.AppendOnly = v.Bool()
+		case "layout":
+			o.Layout, err = catalog.ParseLayout(optionText(v))
+		case "compression":
+			o.Compression, err = catalog.ParseCompression(optionText(v))
+		default:
+			return o, fmt.Errorf("unknown storage option: %s", opt.Name)
+		}
+		if err != nil {
+			return o, err
+		}
+	}
+	return o, nil
+}
+
+// optionText は設定の値 v を文字列として返します。
+func optionText(v types.Value) string {
+	if v.Type() == types.Text {
+		return v.Text()
+	}
+	return ast.FormatValue(v)
+}
+
+// viewSource は CREATE VIEW の問い合わせが使う一時テーブルを調べる exec.Source です。
+type viewSource struct {
+	exec.Source
+	temp string // 問い合わせが使う一時テーブルの名前
+}
+
+func (s *viewSource) Table(name string) (*catalog.Table, error) {
+	t, err := s.Source.Table(name)
+	if err == nil && t.Temp && s.temp == "" {
+		s.temp = t.Name
+	}
+	return t, err
+}
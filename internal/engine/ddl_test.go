@@ -0,0 +1,142 @@
+package engine
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// openMemory はメモリ上のデータベースを開き、テストの終わりに閉じます。
+func openMemory(t *testing.T) *DB {
+	t.Helper()
+	db, err := Open(MemoryPath, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// script は src を実行し、最後の文の結果の行を値の文字列にして返します。
+func script(t *testing.T, db *DB, src string) [][]string {
+	t.Helper()
+	results, err := db.ExecScript(src)
+	if err != nil {
+		t.Fatalf("%s: %v", src, err)
+	}
+	var rows [][]string
+	for _, row := range results[len(results)-1].Rows {
+		var r []string
+		for _, v := range row {
+			r = append(r, v.String())
+		}
+		rows = append(rows, r)
+	}
+	return rows
+}
+
+// TestRenameTableWithView は、ビューが使うテーブルの名前を変えられず、ビューが使い続けられることを
+// 確かめます。ビューが使わないテーブルと、大文字と小文字だけを変える名前の変更はできます。
+func TestRenameTableWithView(t *testing.T) {
+	db := openMemory(t)
+	script(t, db, `
+		CREATE TABLE p (id INT PRIMARY KEY, v TEXT);
+		CREATE TABLE q (id INT PRIMARY KEY);
+		INSERT INTO p VALUES (1, 'a');
+		CREATE VIEW pv AS SELECT v FROM p;
+	`)
+
+	_, err := db.Exec("ALTER TABLE p RENAME TO p2")
+	if err == nil || !strings.Contains(err.Error(), "view pv depends on it") {
+		t.Fatalf("renaming a table used by a view: err = %v, want the view named", err)
+	}
+	if got := script(t, db, "SELECT * FROM pv"); len(got) != 1 || got[0][0] != "a" {
+		t.Errorf("SELECT * FROM pv = %v after the rejected rename, want [[a]]", got)
+	}
+
+	script(t, db, "ALTER TABLE q RENAME TO q2")
+	script(t, db, "ALTER TABLE p RENAME TO P")
+	if got := script(t, db, "SELECT * FROM pv"); len(got) != 1 || got[0][0] != "a" {
+		t.Errorf("SELECT * FROM pv = %v after changing the case of the name, want [[a]]", got)
+	}
+
+	script(t, db, "DROP VIEW pv; ALTER TABLE p RENAME TO p2")
+	if got := script(t, db, "SELECT v FROM p2"); len(got) != 1 || got[0][0] != "a" {
+		t.Errorf("SELECT v FROM p2 = %v, want [[a]]", got)
+	}
+}
+
+// TestRenameTableWithBrokenView は、もともと使えないビューはテーブルの名前の変更を妨げないことを
+// 確かめます。
+func TestRenameTableWithBrokenView(t *testing.T) {
+	db := openMemory(t)
+	script(t, db, `
+		CREATE TABLE p (id INT PRIMARY KEY);
+		CREATE TABLE gone (id INT PRIMARY KEY);
+		CREATE VIEW gv AS SELECT id FROM gone;
+		DROP TABLE gone;
+	`)
+	script(t, db, "ALTER TABLE p RENAME TO p2")
+}
+
+// TestAlterColumnWithView は、ビューが使う列を削除することも名前を変えることもできず、SELECT * の
+// ビューでは列の数が変わる削除もできないことを確かめます。ビューが使わない列は変えられます。
+func TestAlterColumnWithView(t *testing.T) {
+	db := openMemory(t)
+	script(t, db, `
+		CREATE TABLE p (id INT PRIMARY KEY, v TEXT, w INT, x INT);
+		CREATE TABLE s (id INT PRIMARY KEY, a INT, b INT);
+		INSERT INTO p VALUES (1, 'a', 2, 3);
+		CREATE VIEW pv AS SELECT v FROM p WHERE w > 0;
+		CREATE VIEW sv AS SELECT * FROM s;
+	`)
+	for _, tc := range []struct{ sql, want string }{
+		{"ALTER TABLE p DROP COLUMN v", "cannot drop column p.v: view pv depends on it"},
+		{"ALTER TABLE p DROP COLUMN w", "cannot drop column p.w: view pv depends on it"},
+		{"ALTER TABLE p RENAME COLUMN v TO v2", "cannot rename column p.v: view pv depends on it"},
+		{"ALTER TABLE s DROP COLUMN b", "cannot drop column s.b: view sv depends on it"},
+		{"ALTER TABLE s RENAME COLUMN a TO a2", "cannot rename column s.a: view sv depends on it"},
+	} {
+		if _, err := db.Exec(tc.sql); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: err = %v, want %q", tc.sql, err, tc.want)
+		}
+	}
+	if got := script(t, db, "SELECT * FROM pv"); len(got) != 1 || got[0][0] != "a" {
+		t.Errorf("SELECT * FROM pv = %v after the rejected changes, want [[a]]", got)
+	}
+
+	script(t, db, "ALTER TABLE p DROP COLUMN x; ALTER TABLE p RENAME COLUMN id TO pid")
+	if got := script(t, db, "SELECT * FROM pv"); len(got) != 1 || got[0][0] != "a" {
+		t.Errorf("SELECT * FROM pv = %v after changing unused columns, want [[a]]", got)
+	}
+}
+
+// TestCreateTableUnique は、列制約と表制約の UNIQUE が一意インデックスになり、重複する値の INSERT と
+// UPDATE を拒むことを確かめます。NULL は重複とみなさず、主キーと同じ列の UNIQUE はインデックスを
+// 重ねて作りません。
+func TestCreateTableUnique(t *testing.T) {
+	db := openMemory(t)
+	script(t, db, `
+		CREATE TABLE o (x INT);
+		CREATE INDEX t_a_key ON o (x);
+		CREATE TABLE t (id INT PRIMARY KEY UNIQUE, a TEXT UNIQUE, b INT, c INT, UNIQUE (b, c), UNIQUE (id));
+		INSERT INTO t VALUES (1, 'x', 1, 1), (2, NULL, 1, 2), (3, NULL, NULL, NULL), (4, NULL, NULL, NULL);
+	`)
+	want := [][]string{{"t_a_key1", "a"}, {"t_b_c_key", "b,c"}, {"t_pkey", "id"}}
+	if got := script(t, db, "SELECT name, columns FROM __schema.indexes WHERE table_name = 't' ORDER BY name"); !slices.EqualFunc(got, want, slices.Equal) {
+		t.Errorf("indexes = %v, want %v", got, want)
+	}
+	for _, sql := range []string{
+		"INSERT INTO t VALUES (5, 'x', 9, 9)",
+		"INSERT INTO t VALUES (5, 'y', 1, 2)",
+		"UPDATE t SET a = 'x' WHERE id = 2",
+	} {
+		if _, err := db.Exec(sql); !errors.Is(err, ErrUnique) {
+			t.Errorf("%s: err = %v, want %v", sql, err, ErrUnique)
+		}
+	}
+	if _, err := db.Exec("ALTER TABLE t ADD COLUMN d INT UNIQUE"); err == nil {
+		t.Errorf("ALTER TABLE ADD COLUMN with UNIQUE succeeded, want an error")
+	}
+}
@@ -0,0 +1,279 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/k-sml/go-rdbms/internal/catalog"
+	"github.com/k-sml/go-rdbms/internal/dberr"
+	"github.com/k-sml/go-rdbms/internal/lock"
+	"github.com/k-sml/go-rdbms/internal/storage"
+	"github.com/k-sml/go-rdbms/internal/tuple"
+	"github.com/k-sml/go-rdbms/internal/types"
+)
+
+// table はテーブルの定義を返します。情報スキーマの仮想テーブルも返しますが、catalog.Hidden の
+// システムテーブルは返しません。
+func (tx *Tx) table(name string) (*catalog.Table, error) {
+	cat, err := tx.Catalog()
+	if err != nil {
+		return nil, err
+	}
+	if t, ok := VirtualTable(name); ok {
+		return t, nil
+	}
+	t, ok := cat.Table(name)
+	if !ok || catalog.Hidden(name) {
+		return nil, fmt.Errorf("%w: %s", catalog.ErrTableNotFound, name)
+	}
+	return t, nil
+}
+
+// ErrRowNotFound は指定した位置に行がない場合に返されます。
+var ErrRowNotFound = errors.New("no such row")
+
+// ErrNotNull は NOT NULL の列に NULL を書き込もうとした場合に返されます。実際に返すエラーは
+// *dberr.ConstraintError です。
+var ErrNotNull = errors.New("NOT NULL constraint failed")
+
+// heap はテーブル t のヒープファイルを、テーブルの格納方法で開きます。
+func (tx *Tx) heap(t *catalog.Table) *storage.HeapFile {
+	return storage.OpenHeapFileWithOptions(tx.tx, t.Root, t.HeapOptions())
+}
+
+// writable は書き込み権を取ってから、行を変更するテーブルを返し、テーブルに IX ロックをかけます。
+func (tx *Tx) writable(name string) (*catalog.Table, error) {
+	if err := tx.beginWrite(); err != nil {
+		return nil, err
+	}
+	t, err := tx.table(name)
+	if err != nil {
+		return nil, err
+	}
+	if t.System {
+		return nil, fmt.Errorf("cannot modify system table %s", t.Name)
+	}
+	if err := tx.lockTable(t.Name, lock.IntentExclusive); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// prepareRow は row を書き込む値にします。値を列の型に変換し、AUTOINCREMENT の列の
+// NULL にはシーケンスの値を割り当て、NOT NULL 制約を検査します。
+func (tx *Tx) prepareRow(t *catalog.Table, row []types.Value) ([]types.Value, error) {
+	if len(row) != len(t.Columns) {
+		return nil, fmt.Errorf("table %s has %d columns but %d values were supplied", t.Name, len(t.Columns), len(row))
+	}
+	vals := make([]types.Value, len(row))
+	for i, col := range t.Columns {
+		v := row[i]
+		if col.AutoIncrement {
+			seq := catalog.SequenceName(t.Name, col.Name)
+			if v.IsNull() {
+				n, err := tx.NextVal(seq)
+				if err != nil {
+					return nil, err
+				}
+				v = types.NewBigInt(n)
+			}
+		}
+		v, err := types.Coerce(v, col.Type)
+		if err != nil {
+			return nil, fmt.Errorf("column %s: %w", col.Name, err)
+		}
+		if v.IsNull() && (col.NotNull || col.PrimaryKey) {
+			return nil, &dberr.ConstraintError{Err: ErrNotNull, Table: t.Name, Columns: []string{col.Name},
+				Detail: fmt.Sprintf("%s.%s", t.Name, col.Name)}
+		}
+		if col.AutoIncrement {
+			if err := tx.observeSeq(catalog.SequenceName(t.Name, col.Name), v.Int()); err != nil {
+				return nil, err
+			}
+		}
+		vals[i] = v
+	}
+	return vals, nil
+}
+
+// get は位置 rid の行を読みます。
+func (tx *Tx) get(t *catalog.Table, rid storage.RID) ([]types.Value, error) {
+	return tx.getColumns(t, rid, nil)
+}
+
+// getColumns は get と同じですが、cols が nil でなければ cols[i] が false の列の値を読みません。
+func (tx *Tx) getColumns(t *catalog.Table, rid storage.RID, cols []bool) ([]types.Value, error) {
+	rec, ok, err := tx.heap(t).Get(rid)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("%w: %s %s", ErrRowNotFound, t.Name, rid)
+	}
+	return decodeColumns(t, rid, rec, cols)
+}
+
+// decodeRow は行をデコードします。列を追加する前に書かれた行には既定値を補います。
+func decodeRow(t *catalog.Table, rid storage.RID, rec []byte) ([]types.Value, error) {
+	return decodeColumns(t, rid, rec, nil)
+}
+
+// decodeColumns は decodeRow と同じですが、cols が nil でなければ cols[i] が false の列の値を
+// 読まずに NULL にします。
+func decodeColumns(t *catalog.Table, rid storage.RID, rec []byte, cols []bool) ([]types.Value, error) {
+	var row []types.Value
+	var err error
+	if cols == nil {
+		row, err = tuple.Decode(rec)
+	} else {
+		row, err = tuple.DecodeColumns(rec, cols)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s row %s: %w", t.Name, rid, err)
+	}
+	if len(row) > len(t.Columns) {
+		return nil, fmt.Errorf("%s row %s has %d values, want %d", t.Name, rid, len(row), len(t.Columns))
+	}
+	return t.Pad(row), nil
+}
+
+// Insert はテーブルに1行挿入し、その位置を返します。row はテーブルの列の順に並べます。
+// 値は列の型に変換され、AUTOINCREMENT の列に NULL を渡すとシーケンスの値が割り当てられます。
+// テーブルのインデックスにも登録します。
+func (tx *Tx) Insert(table string, row []types.Value) (storage.RID, error) {
+	t, err := tx.writable(table)
+	if err != nil {
+		return storage.RID{}, err
+	}
+	vals, err := tx.prepareRow(t, row)
+	if err != nil {
+		return storage.RID{}, err
+	}
+	if err := tx.checkParents(t, vals, nil, nil); err != nil {
+		return storage.RID{}, err
+	}
+	ixs, err := tx.indexes(t)
+	if err != nil {
+		return storage.RID{}, err
+	}
+	for _, ix := range ixs {
+		if err := tx.checkUnique(t, ix, vals, nil); err != nil {
+			return storage.RID{}, err
+		}
+	}
+	rid, err := tx.heap(t).Insert(tuple.Encode(vals))
+	if err != nil {
+		return storage.RID{}, err
+	}
+	if err := tx.tx.LockRow(strings.ToLower(t.Name), rid, lock.Exclusive); err != nil {
+		return storage.RID{}, err
+	}
+	return rid, tx.insertIndexes(t, ixs, vals, rid)
+}
+
+// Delete は位置 rid の行を削除します。この行を参照している外部キーがあれば、
+// その ON DELETE の動作に従います。
+//
+// 参照している行を連鎖して変更している途中でエラーになった場合、それまでの変更は
+// 取り消されないので、トランザクションをロールバックしてください。
+func (tx *Tx) Delete(table string, rid storage.RID) error {
+	t, err := tx.writable(table)
+	if err != nil {
+		return err
+	}
+	if err := tx.tx.LockRow(strings.ToLower(t.Name), rid, lock.Exclusive); err != nil {
+		return err
+	}
+	old, err := tx.get(t, rid)
+	if err != nil {
+		return err
+	}
+	acts, err := tx.referencing(t, rid, old, nil)
+	if err != nil {
+		return err
+	}
+	if err := tx.heap(t).Delete(rid); err != nil {
+		return err
+	}
+	ixs, err := tx.indexes(t)
+	if err != nil {
+		return err
+	}
+	if err := tx.deleteIndexes(t, ixs, old, rid); err != nil {
+		return err
+	}
+	return tx.apply(acts)
+}
+
+// Update は位置 rid の行を row に置き換え、新しい位置を返します。参照しているキーが
+// 変わった場合は外部キーを検査し、この行を参照している外部キーの ON UPDATE の動作に従います。
+// エラーの扱いは Delete と同じです。
+func (tx *Tx) Update(table string, rid storage.RID, row []types.Value) (storage.RID, error) {
+	t, err := tx.writable(table)
+	if err != nil {
+		return storage.RID{}, err
+	}
+	if err := tx.tx.LockRow(strings.ToLower(t.Name), rid, lock.Exclusive); err != nil {
+		return storage.RID{}, err
+	}
+	old, err := tx.get(t, rid)
+	if err != nil {
+		return storage.RID{}, err
+	}
+	vals, err := tx.prepareRow(t, row)
+	if err != nil {
+		return storage.RID{}, err
+	}
+	if err := tx.checkParents(t, vals, old, &rid); err != nil {
+		return storage.RID{}, err
+	}
+	ixs, err := tx.indexes(t)
+	if err != nil {
+		return storage.RID{}, err
+	}
+	for _, ix := range ixs {
+		if err := tx.checkUnique(t, ix, vals, &rid); err != nil {
+			return storage.RID{}, err
+		}
+	}
+	acts, err := tx.referencing(t, rid, old, vals)
+	if err != nil {
+		return storage.RID{}, err
+	}
+	nrid, err := tx.heap(t).Update(rid, tuple.Encode(vals))
+	if err != nil {
+		return storage.RID{}, err
+	}
+	if err := tx.tx.LockRow(strings.ToLower(t.Name), nrid, lock.Exclusive); err != nil {
+		return storage.RID{}, err
+	}
+	if err := tx.deleteIndexes(t, ixs, old, rid); err != nil {
+		return storage.RID{}, err
+	}
+	if err := tx.insertIndexes(t, ixs, vals, nrid); err != nil {
+		return storage.RID{}, err
+	}
+	return nrid, tx.apply(acts)
+}
+
+// Scan はテーブルのすべての行を格納順に fn に渡します。
+func (tx *Tx) Scan(table string, fn func(rid storage.RID, row []types.Value) error) error {
+	t, err := tx.table(table)
+	if err != nil {
+		return err
+	}
+	if _, ok := VirtualTable(t.Name); ok {
+		return tx.scanVirtual(t.Name, fn)
+	}
+	if err := tx.lockTable(t.Name, lock.Shared); err != nil {
+		return err
+	}
+	return tx.heap(t).Scan(func(rid storage.RID, rec []byte) error {
+		row, err := decodeRow(t, rid, rec)
+		if err != nil {
+			return err
+		}
+		return fn(rid, row)
+	})
+}
@@ -0,0 +1,373 @@
+// Package engine はページャー、トランザクション、カタログを組み合わせて
+// 1つのデータベースとして扱えるようにします。
+package engine
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/k-sml/go-rdbms/internal/catalog"
+	"github.com/k-sml/go-rdbms/internal/exec"
+	"github.com/k-sml/go-rdbms/internal/lock"
+	"github.com/k-sml/go-rdbms/internal/pager"
+	"github.com/k-sml/go-rdbms/internal/txn"
+	"github.com/k-sml/go-rdbms/internal/vfs"
+	"github.com/k-sml/go-rdbms/metrics"
+)
+
+// Options はデータベースを開く際の設定です。
+type Options struct {
+	PageSize  int               // 各ページのサイズ（0 なら 4096）
+	Journal   pager.JournalMode // コミットの永続化方式
+	ReadOnly  bool              // 書き込みを拒否する
+	BatchSize int               // 問い合わせの演算子がまとめて処理する行の数（0 なら 1024、1 なら1行ずつ処理する）
+	Parallel  int               // 1つのテーブルを並列に読むゴルーチンの数の上限（1 以下なら並列に読まない）
+	CacheSize int               // メモリに置いておくページの数（0 なら 2000、負ならキャッシュしない）
+	Sync      pager.SyncMode    // コミットでファイルを同期する範囲
+	Follower  bool              // ストリーミングレプリケーションのフォロワーとして開く（replication.go）
+	FS        vfs.FS            // ファイルを開くファイルシステム（nil なら vfs.OS。クラッシュ試験では vfs.MemFS）
+
+	// BusyTimeout は LockTimeout と NoWait を指定しないトランザクションがロックを待つ時間の上限です。
+	// 0 なら無期限に待ちます。
+	BusyTimeout time.Duration
+	// BusyHandler は、LockTimeout と NoWait を指定しないトランザクションがロックを取れなかったときに、
+	// もう一度要求するかを決める関数です。BusyTimeout が 0 なら待たずにすぐ呼び出すので、待つ時間は
+	// BusyHandler が決めます。1つの文を実行するだけのトランザクション（DB.Exec など）が
+	// デッドロックで中断された場合も、BusyHandler が true を返せば新しいトランザクションで実行し直します。
+	BusyHandler txn.BusyHandler
+
+	// Logger はWALからの復旧、チェックポイント、VACUUM、遅い文、ファイルの破損を記録するロガーです。
+	// nil なら何も記録しません。
+	Logger *slog.Logger
+	// SlowQuery は遅い文として記録する実行時間です。0 なら記録しません。問い合わせの時間は
+	// 結果の行を読み終えるまでを数えます。
+	SlowQuery time.Duration
+	// Metrics はページの入出力、コミット、ロックの待機、文の実行時間などの計測値を送る先です。
+	// nil なら送りません。
+	Metrics metrics.Sink
+	// Hooks は文を実行する前と後に呼ぶ関数です（log.go）。
+	Hooks Hooks
+}
+
+// DB は開いているデータベースです。複数のゴルーチンから使えます。
+type DB struct {
+	pager *pager.Pager
+	txns  *txn.Manager
+
+	seqMu sync.Mutex
+	seqs  map[string]*seqRange // コミット済みの予約のうち、まだ払い出していない値（sequence.go）
+
+	stmts stmtCache // 準備した文（stmt.go）
+	exec  exec.Settings
+	funcs *exec.Funcs // 登録した関数（func.go）
+
+	logger    *slog.Logger // 記録するロガー（log.go）
+	slowQuery time.Duration
+	metrics   metrics.Sink // 計測値を送る先（log.go）
+	hooks     Hooks
+
+	busyTimeout time.Duration
+	busy        txn.BusyHandler
+
+	follower follower // フォロワーの状態（replication.go）
+
+	sessions atomic.Int64 // 最後に割り当てたセッションの番号（session.go）
+}
+
+// MemoryPath は Open に渡すとメモリ上のデータベースを作るパスです。データベースは Open のたびに
+// 新しく作られ、Close で捨てられます。ファイルと同じく SQL もトランザクションも使えますが、
+// WALもシャドウページングも使わず（JournalNone）、ページキャッシュも使いません。
+const MemoryPath = ":memory:"
+
+// defaultCacheSize は CacheSize を指定しなかった場合にメモリに置いておくページの数です。
+const defaultCacheSize = 2000
+
+// validate は設定の値と組み合わせを確かめます。
+func (opts *Options) validate() error {
+	switch {
+	case opts.PageSize < 0 || opts.PageSize%512 != 0:
+		return fmt.Errorf("invalid page size: %d", opts.PageSize)
+	case opts.Journal < pager.JournalNone || opts.Journal > pager.JournalShadow:
+		return fmt.Errorf("unknown journal mode: %d", opts.Journal)
+	case opts.Sync < pager.SyncFull || opts.Sync > pager.SyncOff:
+		return fmt.Errorf("unknown sync mode: %d", opts.Sync)
+	case opts.Sync == pager.SyncNormal && opts.Journal != pager.JournalWAL:
+		return errors.New("sync mode normal requires the wal journal")
+	case opts.ReadOnly && opts.Sync != pager.SyncFull:
+		return errors.New("sync mode cannot be set for a read-only database")
+	case opts.BatchSize < 0:
+		return fmt.Errorf("invalid batch size: %d", opts.BatchSize)
+	case opts.BusyTimeout < 0:
+		return fmt.Errorf("invalid busy timeout: %v", opts.BusyTimeout)
+	case opts.Follower && opts.Journal != pager.JournalWAL:
+		return errors.New("follower requires the wal journal")
+	case opts.Follower && opts.ReadOnly:
+		return errors.New("follower cannot be opened read-only")
+	}
+	return nil
+}
+
+// validateMemory はメモリ上のデータベースの設定を確かめます。
+func (opts *Options) validateMemory() error {
+	switch {
+	case opts.Journal != pager.JournalNone:
+		return errors.New("in-memory database does not use a journal")
+	case opts.ReadOnly:
+		return errors.New("in-memory database cannot be read-only")
+	case opts.FS != nil:
+		return errors.New("in-memory database cannot use a file system")
+	}
+	return nil
+}
+
+// Open はデータベースファイルを開きます。新しいファイルの場合はカタログを作成します。
+func Open(path string, opts Options) (*DB, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	fsys := opts.FS
+	if path == MemoryPath {
+		if err := opts.validateMemory(); err != nil {
+			return nil, err
+		}
+		fsys = vfs.NewMemory()
+		if opts.CacheSize == 0 {
+			opts.CacheSize = -1 // ファイルがメモリ上にあるので、キャッシュしても速くならない
+		}
+	}
+	if opts.PageSize == 0 {
+		opts.PageSize = 4096
+	}
+	if opts.BatchSize == 0 {
+		opts.BatchSize = 1024
+	}
+	if opts.CacheSize == 0 {
+		opts.CacheSize = defaultCacheSize
+	}
+	logger := cmp.Or(opts.Logger, discardLogger).With("db", path)
+	sink := cmp.Or(opts.Metrics, metrics.Discard)
+	p, err := pager.OpenWithOptions(path, pager.Options{
+		PageSize:  opts.PageSize,
+		Journal:   opts.Journal,
+		ReadOnly:  opts.ReadOnly,
+		Logger:    logger,
+		Metrics:   sink,
+		CacheSize: opts.CacheSize,
+		Sync:      opts.Sync,
+		Replica:   opts.Follower,
+		FS:        fsys,
+	})
+	if err != nil {
+		return nil, err
+	}
+	locks := lock.NewManager()
+	locks.SetMetrics(sink)
+	txns := txn.NewManager(p, locks)
+	txns.SetMetrics(sink)
+	funcs := exec.NewFuncs()
+	db := &DB{
+		pager: p,
+		txns:  txns,
+		seqs:  make(map[string]*seqRange),
+		exec:  exec.Settings{BatchSize: opts.BatchSize, Parallel: opts.Parallel, Funcs: funcs},
+		funcs: funcs,
+
+		logger:    logger,
+		slowQuery: opts.SlowQuery,
+		metrics:   sink,
+		hooks:     opts.Hooks,
+
+		busyTimeout: opts.BusyTimeout,
+		busy:        opts.BusyHandler,
+	}
+	if opts.Follower {
+		// カタログはリーダーから受け取る
+		err = db.openFollower(path)
+	} else {
+		err = db.init()
+	}
+	if err != nil {
+		p.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// init はカタログを読み込み、必要ならブートストラップしてコミットします。閉じずに終わった
+// セッションの一時テーブルがあれば削除します。
+func (db *DB) init() error {
+	tx, err := db.txns.Begin(txn.Options{})
+	if err != nil {
+		return err
+	}
+	cat, err := catalog.Open(tx)
+	if err != nil {
+		tx.Rollback()
+		if errors.Is(err, txn.ErrReadOnlyTx) || errors.Is(err, pager.ErrReadOnly) {
+			return errors.New("database is not initialized")
+		}
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	return db.dropOrphans(cat)
+}
+
+// Close はデータベースを閉じます。
+func (db *DB) Close() error {
+	return db.pager.Close()
+}
+
+// Checkpoint はWALを空にします。コミット済みのページはデータベースファイルに書き込み済みなので、
+// 失われる変更はありません。JournalWAL 以外では何もしません。
+func (db *DB) Checkpoint() error { return db.pager.Checkpoint() }
+
+// Transactions はトランザクションマネージャを返します（監視やフックの登録に使います）。
+func (db *DB) Transactions() *txn.Manager { return db.txns }
+
+// Stats はデータベースを開いてからの入出力、トランザクション、ロックの統計です。
+type Stats struct {
+	PageSize int
+	Pager    pager.Stats
+	Txn      txn.Stats
+	Locks    lock.Stats
+}
+
+// Stats はデータベースを開いてからの統計を返します。
+func (db *DB) Stats() Stats {
+	return Stats{
+		PageSize: db.pager.PageSize(),
+		Pager:    db.pager.Stats(),
+		Txn:      db.txns.Stats(),
+		Locks:    db.txns.Locks().Stats(),
+	}
+}
+
+// Tx はデータベースのトランザクションです。
+type Tx struct {
+	db  *DB
+	tx  *txn.Tx
+	cat *catalog.Catalog
+
+	seqs      map[string]*seqRange // このトランザクションで予約したシーケンスの値
+	resetSeqs map[string]bool      // high-water mark を明示的な値まで進めたシーケンス
+	deferred  map[string]bool      // コミット時に Deferred の外部キーを検査するテーブル
+
+	baseVersion uint64 // 最初に読んだカタログのスキーマの版
+	baseRead    bool   // baseVersion を読んだか
+
+	session int64 // 一時テーブルが見えるセッションの番号（0 ならセッションの外）
+}
+
+// Begin はトランザクションを開始します。
+func (db *DB) Begin(opts txn.Options) (*Tx, error) {
+	return db.BeginTx(context.Background(), opts)
+}
+
+// BeginTx は ctx を使うトランザクションを開始します。ctx が取り消されると、実行中の文は
+// ロックの待機や次のページの読み取りで止まり、それ以降の文とコミットは ctx のエラーで失敗します。
+// opts が LockTimeout と NoWait を指定しなければ、Options.BusyTimeout と Options.BusyHandler に従って
+// ロックを待ちます。
+func (db *DB) BeginTx(ctx context.Context, opts txn.Options) (*Tx, error) {
+	return db.beginSession(ctx, opts, 0)
+}
+
+// beginSession はセッション session のトランザクションを開始します。
+func (db *DB) beginSession(ctx context.Context, opts txn.Options, session int64) (*Tx, error) {
+	if opts.LockTimeout == 0 && !opts.NoWait {
+		opts.LockTimeout = db.busyTimeout
+		if opts.Busy == nil && db.busy != nil {
+			opts.Busy = db.busy
+			opts.NoWait = db.busyTimeout == 0 // 待つ時間は BusyHandler に任せる
+		}
+	}
+	tx, err := db.txns.BeginContext(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &Tx{db: db, tx: tx, session: session}, nil
+}
+
+// Catalog はトランザクションから見えるスキーマを返します。
+func (tx *Tx) Catalog() (*catalog.Catalog, error) {
+	if tx.cat == nil {
+		cat, err := catalog.OpenSession(tx.tx, tx.session)
+		if err != nil {
+			return nil, err
+		}
+		tx.cat = cat
+		if !tx.baseRead {
+			tx.baseVersion, tx.baseRead = cat.Version(), true
+		}
+	}
+	return tx.cat, nil
+}
+
+// schemaChanged はトランザクションがスキーマを変更したかもしれないかを返します。版は進むだけなので、
+// 最初に読んだときから版が変わっていなければ変更していません。
+func (tx *Tx) schemaChanged() bool {
+	return tx.cat != nil && tx.cat.Version() != tx.baseVersion
+}
+
+// Txn は下位のトランザクションを返します。
+func (tx *Tx) Txn() *txn.Tx { return tx.tx }
+
+// Commit はトランザクションをコミットします。Deferred の外部キーに違反している場合は
+// ロールバックしてエラーを返します。
+func (tx *Tx) Commit() error {
+	if err := tx.checkDeferred(); err != nil {
+		tx.tx.Rollback()
+		return err
+	}
+	if err := tx.tx.Commit(); err != nil {
+		return err
+	}
+	tx.db.mergeSeqs(tx)
+	return nil
+}
+
+// Rollback はトランザクションをロールバックします。
+func (tx *Tx) Rollback() error { return tx.tx.Rollback() }
+
+// update は新しいトランザクションで fn を実行し、エラーがなければコミットします。
+func (db *DB) update(fn func(tx *Tx) error) error {
+	return db.updateContext(context.Background(), txn.Options{}, fn)
+}
+
+// updateContext は ctx と opts を使う新しいトランザクションで fn を実行し、エラーがなければ
+// コミットします。デッドロックで中断された場合は、Options.BusyHandler が true を返す間、
+// 新しいトランザクションで実行し直します。
+func (db *DB) updateContext(ctx context.Context, opts txn.Options, fn func(tx *Tx) error) error {
+	return db.updateSession(ctx, opts, 0, fn)
+}
+
+// updateSession は updateContext と同じですが、セッション session のトランザクションで実行します。
+func (db *DB) updateSession(ctx context.Context, opts txn.Options, session int64, fn func(tx *Tx) error) error {
+	for n := 1; ; n++ {
+		err := db.updateOnce(ctx, opts, session, fn)
+		if db.busy == nil || !errors.Is(err, lock.ErrDeadlock) || !db.busy(ctx, n) {
+			return err
+		}
+	}
+}
+
+// updateOnce は ctx と opts を使うセッション session の新しいトランザクションで fn を1回実行し、
+// エラーがなければコミットします。
+func (db *DB) updateOnce(ctx context.Context, opts txn.Options, session int64, fn func(tx *Tx) error) error {
+	tx, err := db.beginSession(ctx, opts, session)
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,397 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/k-sml/go-rdbms/internal/catalog"
+	"github.com/k-sml/go-rdbms/internal/dberr"
+	"github.com/k-sml/go-rdbms/internal/exec"
+	"github.com/k-sml/go-rdbms/internal/storage"
+	"github.com/k-sml/go-rdbms/internal/tuple"
+	"github.com/k-sml/go-rdbms/internal/types"
+)
+
+// 外部キーの検査
+//
+// 行を挿入・更新するときは、その行の外部キーの値の組が参照先のテーブルにあるかを調べる
+// （値に NULL を含む場合は検査しない）。行を削除・更新するときは、その行を参照している
+// 行を探し、ON DELETE / ON UPDATE の動作に従ってエラーにするか、連鎖して削除・更新するか、
+// NULL にする。
+//
+// Deferred の外部キーは変更のたびには検査せず、検査が必要になったテーブルを覚えておき、
+// コミットの直前にそのテーブルのすべての行をまとめて検査する。
+//
+// 参照先の行は参照先の列（主キーか一意インデックス）のインデックスで探す。主キーには CREATE TABLE が
+// 一意インデックスを作るので、参照先には必ずインデックスがある。参照している行は、外部キーの列が
+// 先頭に並ぶインデックスがあればそれで探し、なければテーブルを走査する。
+
+// ErrForeignKey は外部キー制約に違反した場合に返されます。実際に返すエラーは *dberr.ConstraintError です。
+var ErrForeignKey = errors.New("FOREIGN KEY constraint failed")
+
+// errFound は find で最初の行が見つかったときに走査を打ち切るためのものです。
+var errFound = errors.New("found")
+
+// refAction は参照先の行の削除・更新に伴って、参照している行に行う変更です。
+type refAction struct {
+	child  *catalog.Table
+	fk     catalog.ForeignKey
+	oldKey []types.Value
+	newKey []types.Value // nil なら削除
+}
+
+// keyOf は row のうち cols の列の値を返します。NULL を含む場合は false を返します。
+func keyOf(t *catalog.Table, row []types.Value, cols []string) ([]types.Value, bool) {
+	key := make([]types.Value, len(cols))
+	for i, name := range cols {
+		ci, _ := t.Column(name)
+		if row[ci].IsNull() {
+			return nil, false
+		}
+		key[i] = row[ci]
+	}
+	return key, true
+}
+
+func equalKeys(a, b []types.Value) bool {
+	for i := range a {
+		if !types.Equal(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// find はテーブル t のうち cols の列の値が key と等しい行を fn に渡します。
+// skip の位置の行は除きます。fn が errFound を返すと走査をやめ、find は true を返します。
+// 先頭の列が cols のインデックスがあればそれで探し、なければテーブルを走査します。
+func (tx *Tx) find(t *catalog.Table, cols []string, key []types.Value, skip *storage.RID,
+	fn func(storage.RID, []types.Value) error) (bool, error) {
+	ixs, err := tx.indexes(t)
+	if err != nil {
+		return false, err
+	}
+	for _, ix := range ixs {
+		vals, ok := indexValues(t, ix, cols, key)
+		if !ok {
+			continue
+		}
+		if vals == nil {
+			return false, nil
+		}
+		return tx.findIndex(t, ix, exec.IndexRange{Lo: vals, Hi: vals}, cols, key, skip, fn)
+	}
+	err = tx.Scan(t.Name, func(rid storage.RID, row []types.Value) error {
+		if skip != nil && rid == *skip {
+			return nil
+		}
+		if k, ok := keyOf(t, row, cols); ok && equalKeys(k, key) {
+			return fn(rid, row)
+		}
+		return nil
+	})
+	if err == errFound {
+		return true, nil
+	}
+	return false, err
+}
+
+// indexValues はインデックス ix の先頭の列が cols の並べ替えなら、key をインデックスの列の順に並べ、
+// 列の型に変換して返します。変換すると値が変わる場合は等しい行がないので、nil と true を返します。
+func indexValues(t *catalog.Table, ix *catalog.Index, cols []string, key []types.Value) ([]types.Value, bool) {
+	if len(ix.Columns) < len(cols) {
+		return nil, false
+	}
+	vals := make([]types.Value, len(cols))
+	for i, name := range ix.Columns[:len(cols)] {
+		j := slices.IndexFunc(cols, func(s string) bool { return strings.EqualFold(s, name) })
+		if j < 0 {
+			return nil, false
+		}
+		ci, _ := t.Column(name)
+		v, err := types.Coerce(key[j], t.Columns[ci].Type)
+		if err != nil || !types.Equal(key[j], v) {
+			vals = nil
+			continue
+		}
+		if vals != nil {
+			vals[i] = v
+		}
+	}
+	return vals, true
+}
+
+// findIndex は find と同じですが、インデックス ix の範囲 r の行から探します。fn が行を書き換えても
+// インデックスを読み続けられるよう、一致する行をすべて読んでから fn に渡します。
+func (tx *Tx) findIndex(t *catalog.Table, ix *catalog.Index, r exec.IndexRange, cols []string, key []types.Value,
+	skip *storage.RID, fn func(storage.RID, []types.Value) error) (bool, error) {
+	c, err := tx.ScanIndex(ix, r)
+	if err != nil {
+		return false, err
+	}
+	var rids []storage.RID
+	var rows [][]types.Value
+	for {
+		rid, row, ok, err := c.Next()
+		if err != nil {
+			return false, err
+		}
+		if !ok {
+			break
+		}
+		if skip != nil && rid == *skip {
+			continue
+		}
+		if k, ok := keyOf(t, row, cols); ok && equalKeys(k, key) {
+			rids = append(rids, rid)
+			rows = append(rows, row)
+		}
+	}
+	for i, rid := range rids {
+		if err := fn(rid, rows[i]); err == errFound {
+			return true, nil
+		} else if err != nil {
+			return false, err
+		}
+	}
+	return false, nil
+}
+
+// exists は cols の列の値が key と等しい行が t にあるかを返します。
+func (tx *Tx) exists(t *catalog.Table, cols []string, key []types.Value, skip *storage.RID) (bool, error) {
+	return tx.find(t, cols, key, skip, func(storage.RID, []types.Value) error { return errFound })
+}
+
+// checkParents はテーブル t に書き込む行 row の外部キーの参照先があるかを調べます。
+// 更新の場合は old に更新前の行を、self に更新前の位置を渡します（値の変わらない外部キーは検査しません）。
+func (tx *Tx) checkParents(t *catalog.Table, row, old []types.Value, self *storage.RID) error {
+	if len(t.ForeignKeys) == 0 {
+		return nil
+	}
+	cat, err := tx.Catalog()
+	if err != nil {
+		return err
+	}
+	for _, fk := range t.ForeignKeys {
+		key, ok := keyOf(t, row, fk.Columns)
+		if !ok {
+			continue
+		}
+		if old != nil {
+			if prev, ok := keyOf(t, old, fk.Columns); ok && equalKeys(prev, key) {
+				continue
+			}
+		}
+		if fk.Deferred {
+			tx.deferCheck(t.Name)
+			continue
+		}
+		ref, found := cat.Table(fk.RefTable)
+		if !found {
+			return fmt.Errorf("%w: %s", catalog.ErrTableNotFound, fk.RefTable)
+		}
+		if strings.EqualFold(ref.Name, t.Name) {
+			// 自分自身を参照する行
+			if k, ok := keyOf(t, row, fk.RefColumns); ok && equalKeys(k, key) {
+				continue
+			}
+		}
+		found, err := tx.exists(ref, fk.RefColumns, key, self)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return &dberr.ConstraintError{Err: ErrForeignKey, Table: t.Name, Columns: fk.Columns,
+				Detail: fmt.Sprintf("%s(%s) references %s(%s)",
+					t.Name, strings.Join(fk.Columns, ", "), ref.Name, strings.Join(fk.RefColumns, ", "))}
+		}
+	}
+	return nil
+}
+
+// referencing は位置 rid にあるテーブル t の行 old を削除（row が nil）または row に更新するときに、
+// その行を参照している行に必要な変更を返します。RESTRICT の外部キーで参照している行があれば
+// エラーにします（Deferred ならコミット時の検査に回します）。
+func (tx *Tx) referencing(t *catalog.Table, rid storage.RID, old, row []types.Value) ([]refAction, error) {
+	cat, err := tx.Catalog()
+	if err != nil {
+		return nil, err
+	}
+	var acts []refAction
+	for _, ref := range cat.Referencing(t.Name) {
+		fk := ref.ForeignKey
+		oldKey, ok := keyOf(t, old, fk.RefColumns)
+		if !ok {
+			continue
+		}
+		action := fk.OnDelete
+		var newKey []types.Value
+		if row != nil {
+			action = fk.OnUpdate
+			newKey = make([]types.Value, len(fk.RefColumns))
+			for i, name := range fk.RefColumns {
+				ci, _ := t.Column(name)
+				newKey[i] = row[ci]
+			}
+			if equalKeys(oldKey, newKey) {
+				continue
+			}
+		}
+		var skip *storage.RID
+		if strings.EqualFold(ref.Table.Name, t.Name) {
+			skip = &rid // 自分自身を参照している行は、自分の変更を妨げない
+		}
+		found, err := tx.exists(ref.Table, fk.Columns, oldKey, skip)
+		if err != nil {
+			return nil, err
+		}
+		if !found {
+			continue
+		}
+		switch action {
+		case catalog.Restrict:
+			if fk.Deferred {
+				tx.deferCheck(ref.Table.Name)
+				continue
+			}
+			return nil, &dberr.ConstraintError{Err: ErrForeignKey, Table: ref.Table.Name, Columns: fk.Columns,
+				Detail: fmt.Sprintf("%s is referenced by %s(%s)", t.Name, ref.Table.Name, strings.Join(fk.Columns, ", "))}
+		case catalog.SetNull:
+			newKey = make([]types.Value, len(fk.Columns))
+			for i := range newKey {
+				newKey[i] = types.NullValue()
+			}
+		}
+		acts = append(acts, refAction{child: ref.Table, fk: fk, oldKey: oldKey, newKey: newKey})
+	}
+	return acts, nil
+}
+
+// apply は referencing が返した変更を、参照している行に行います。
+// 変更した行がさらに参照されていれば、同じ規則で連鎖します。
+func (tx *Tx) apply(acts []refAction) error {
+	for _, a := range acts {
+		var rids []storage.RID
+		_, err := tx.find(a.child, a.fk.Columns, a.oldKey, nil, func(rid storage.RID, _ []types.Value) error {
+			rids = append(rids, rid)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, rid := range rids {
+			if a.newKey == nil {
+				err = tx.Delete(a.child.Name, rid)
+			} else {
+				var row []types.Value
+				if row, err = tx.get(a.child, rid); err == nil {
+					for i, name := range a.fk.Columns {
+						ci, _ := a.child.Column(name)
+						row[ci] = a.newKey[i]
+					}
+					_, err = tx.Update(a.child.Name, rid, row)
+				}
+			}
+			// 自分自身を参照するテーブルでは、連鎖の途中ですでに変更した行がある
+			if err != nil && !errors.Is(err, ErrRowNotFound) {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// deferCheck はテーブルの Deferred の外部キーをコミット時に検査するよう記録します。
+func (tx *Tx) deferCheck(table string) {
+	if tx.deferred == nil {
+		tx.deferred = make(map[string]bool)
+	}
+	tx.deferred[strings.ToLower(table)] = true
+}
+
+// checkDeferred はコミットの前に、記録したテーブルの Deferred の外部キーを検査します。
+func (tx *Tx) checkDeferred() error {
+	if len(tx.deferred) == 0 {
+		return nil
+	}
+	cat, err := tx.Catalog()
+	if err != nil {
+		return err
+	}
+	for name := range tx.deferred {
+		t, ok := cat.Table(name)
+		if !ok {
+			continue // 削除されたテーブル
+		}
+		for _, fk := range t.ForeignKeys {
+			if !fk.Deferred {
+				continue
+			}
+			if err := tx.checkAll(cat, t, fk); err != nil {
+				return err
+			}
+		}
+	}
+	tx.deferred = nil
+	return nil
+}
+
+// checkAll はテーブル t のすべての行について外部キー fk の参照先があるかを調べます。
+// 参照先のキーを一度読んでおき、各行の値をその中から探します。
+func (tx *Tx) checkAll(cat *catalog.Catalog, t *catalog.Table, fk catalog.ForeignKey) error {
+	ref, ok := cat.Table(fk.RefTable)
+	if !ok {
+		return fmt.Errorf("%w: %s", catalog.ErrTableNotFound, fk.RefTable)
+	}
+	// 型の違う列どうし（INT と BIGINT など）も比べられるよう、共通の型にそろえてから符号化する
+	common := make([]types.Type, len(fk.Columns))
+	for i := range fk.Columns {
+		ci, _ := t.Column(fk.Columns[i])
+		ri, _ := ref.Column(fk.RefColumns[i])
+		common[i], _ = types.Common(t.Columns[ci].Type, ref.Columns[ri].Type)
+	}
+	encode := func(key []types.Value) (string, error) {
+		vals := make([]types.Value, len(key))
+		for i, v := range key {
+			c, err := types.Coerce(v, common[i])
+			if err != nil {
+				return "", err
+			}
+			vals[i] = c
+		}
+		return string(tuple.Encode(vals)), nil
+	}
+
+	keys := make(map[string]bool)
+	err := tx.Scan(ref.Name, func(_ storage.RID, row []types.Value) error {
+		if k, ok := keyOf(ref, row, fk.RefColumns); ok {
+			s, err := encode(k)
+			if err != nil {
+				return err
+			}
+			keys[s] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return tx.Scan(t.Name, func(rid storage.RID, row []types.Value) error {
+		k, ok := keyOf(t, row, fk.Columns)
+		if !ok {
+			return nil
+		}
+		s, err := encode(k)
+		if err != nil {
+			return err
+		}
+		if !keys[s] {
+			return &dberr.ConstraintError{Err: ErrForeignKey, Table: t.Name, Columns: fk.Columns,
+				Detail: fmt.Sprintf("%s row %s references a missing row of %s(%s)",
+					t.Name, rid, ref.Name, strings.Join(fk.RefColumns, ", "))}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,38 @@
+package engine
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/k-sml/go-rdbms/internal/pager"
+	"github.com/k-sml/go-rdbms/internal/vfs"
+)
+
+// TestOpenFS は、Options.FS のファイルシステムでデータベースを開き、電源断の後に開き直しても
+// コミットした行が残ることを確かめます。:memory: には FS を指定できません。
+func TestOpenFS(t *testing.T) {
+	for _, journal := range []pager.JournalMode{pager.JournalWAL, pager.JournalShadow} {
+		fs := vfs.NewMemFS()
+		opts := Options{Journal: journal, FS: fs}
+		db, err := Open("test.db", opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		script(t, db, "CREATE TABLE t (id INT PRIMARY KEY, v TEXT); INSERT INTO t VALUES (1, 'a'), (2, 'b')")
+
+		// 閉じずに電源を落とす
+		fs.Crash(rand.New(rand.NewSource(1)))
+		db, err = Open("test.db", opts)
+		if err != nil {
+			t.Fatalf("journal %v: reopening after a crash: %v", journal, err)
+		}
+		if got := script(t, db, "SELECT v FROM t ORDER BY id"); len(got) != 2 || got[0][0] != "a" || got[1][0] != "b" {
+			t.Errorf("journal %v: rows after a crash = %v, want [[a] [b]]", journal, got)
+		}
+		db.Close()
+	}
+
+	if _, err := Open(MemoryPath, Options{FS: vfs.NewMemFS()}); err == nil {
+		t.Errorf("opening :memory: with a file system succeeded, want an error")
+	}
+}
@@ -0,0 +1,29 @@
+package engine
+
+import (
+	"fmt"
+
+	"github.com/k-sml/go-rdbms/internal/exec"
+	"github.com/k-sml/go-rdbms/internal/sql/lexer"
+)
+
+// RegisterFunc は SQL から呼び出せる関数 f をこのデータベースに登録します。同じ名前の関数や
+// 集約関数があれば置き換え、組み込みの関数より優先します。f.Call は複数のゴルーチンから
+// 同時に呼ばれることがあります。
+func (db *DB) RegisterFunc(f *exec.Func) error {
+	if !lexer.IsIdent(f.Name) {
+		return fmt.Errorf("invalid function name: %q", f.Name)
+	}
+	db.funcs.Register(f)
+	return nil
+}
+
+// RegisterAggregate は SQL から呼び出せる集約関数 a をこのデータベースに登録します。同じ名前の
+// 関数や集約関数があれば置き換え、COUNT や SUM などの組み込みの集約関数より優先します。
+func (db *DB) RegisterAggregate(a *exec.Aggregate) error {
+	if !lexer.IsIdent(a.Name) {
+		return fmt.Errorf("invalid function name: %q", a.Name)
+	}
+	db.funcs.RegisterAggregate(a)
+	return nil
+}
@@ -0,0 +1,277 @@
+package engine
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/k-sml/go-rdbms/internal/btree"
+	"github.com/k-sml/go-rdbms/internal/catalog"
+	"github.com/k-sml/go-rdbms/internal/dberr"
+	"github.com/k-sml/go-rdbms/internal/exec"
+	"github.com/k-sml/go-rdbms/internal/lock"
+	"github.com/k-sml/go-rdbms/internal/storage"
+	"github.com/k-sml/go-rdbms/internal/types"
+)
+
+// インデックス
+//
+// インデックスは B+ 木で、キーは列の値のキーの形式（types.AppendKey）の後ろに行の位置
+// （ページIDとスロットをビッグエンディアンで 8 + 4 バイト）を付けたもの、値は空にする。
+// 位置を付けるので一意でないインデックスでもキーが重複せず、一意のインデックスでは
+// 列の値の部分が同じキーがほかにないことを書き込む前に確かめる。NULL を含むキーは
+// 一意性の検査の対象にしない。
+
+// ErrUnique は一意のインデックスに同じ値を書き込もうとした場合に返されます。実際に返すエラーは
+// *dberr.ConstraintError で、errors.Is は dberr.ErrDuplicateKey にも一致します。
+var ErrUnique = dberr.Mark(errors.New("UNIQUE constraint failed"), dberr.ErrDuplicateKey)
+
+const ridSize = 12
+
+// CreateIndex はインデックスを作成します。
+func (db *DB) CreateIndex(name, table string, columns []string, unique bool) error {
+	return db.update(func(tx *Tx) error { return tx.CreateIndex(name, table, columns, unique) })
+}
+
+// DropIndex はインデックスを削除し、そのページをすべて解放します。
+func (db *DB) DropIndex(name string) error {
+	return db.update(func(tx *Tx) error { return tx.DropIndex(name) })
+}
+
+// CreateIndex はトランザクションの中でインデックスを作成し、既存の行を登録します。
+func (tx *Tx) CreateIndex(name, table string, columns []string, unique bool) error {
+	cat, err := tx.alter(table)
+	if err != nil {
+		return err
+	}
+	t, ok := cat.Table(table)
+	if !ok || t.System {
+		return fmt.Errorf("%w: %s", catalog.ErrTableNotFound, table)
+	}
+	if _, ok := cat.Index(name); ok {
+		return fmt.Errorf("%w: %s", catalog.ErrIndexExists, name)
+	}
+	tree, err := btree.Create(tx.tx)
+	if err != nil {
+		return err
+	}
+	ix := &catalog.Index{Name: name, Table: t.Name, Columns: columns, Unique: unique, Root: tree.Root()}
+	if err := cat.CreateIndex(ix); err != nil {
+		return err
+	}
+	return tx.fillIndex(t, ix)
+}
+
+// fillIndex はテーブルのすべての行をインデックスに登録します。
+func (tx *Tx) fillIndex(t *catalog.Table, ix *catalog.Index) error {
+	return tx.heap(t).Scan(func(rid storage.RID, rec []byte) error {
+		row, err := decodeRow(t, rid, rec)
+		if err != nil {
+			return err
+		}
+		if err := tx.checkUnique(t, ix, row, nil); err != nil {
+			return err
+		}
+		return tx.tree(ix).Insert(indexKey(t, ix, row, rid), nil)
+	})
+}
+
+// DropIndex はトランザクションの中でインデックスを削除します。主キーのインデックスは削除できません。
+func (tx *Tx) DropIndex(name string) error {
+	if err := tx.beginWrite(); err != nil {
+		return err
+	}
+	cat, err := tx.Catalog()
+	if err != nil {
+		return err
+	}
+	ix, ok := cat.Index(name)
+	if !ok {
+		return fmt.Errorf("%w: %s", catalog.ErrIndexNotFound, name)
+	}
+	if cat.IsPrimaryKey(ix) {
+		return fmt.Errorf("cannot drop the PRIMARY KEY index %s", ix.Name)
+	}
+	if err := tx.lockTable(ix.Table, lock.Exclusive); err != nil {
+		return err
+	}
+	if err := cat.DropIndex(name); err != nil {
+		return err
+	}
+	return tx.tree(ix).Drop()
+}
+
+// rebuildIndexes はテーブルのインデックスを空にして、すべての行を登録し直します。
+// 行の位置が変わる操作（DropColumn）の後で使います。
+func (tx *Tx) rebuildIndexes(table string) error {
+	cat, err := tx.Catalog()
+	if err != nil {
+		return err
+	}
+	t, ok := cat.Table(table)
+	if !ok {
+		return fmt.Errorf("%w: %s", catalog.ErrTableNotFound, table)
+	}
+	for _, ix := range cat.Indexes(t.Name) {
+		if err := tx.tree(ix).Clear(); err != nil {
+			return err
+		}
+		if err := tx.fillIndex(t, ix); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (tx *Tx) tree(ix *catalog.Index) *btree.Tree { return btree.Open(tx.tx, ix.Root) }
+
+// indexPrefix は row のインデックスの列の値のキーを返します。NULL を含むかも返します。
+func indexPrefix(t *catalog.Table, ix *catalog.Index, row []types.Value) ([]byte, bool) {
+	var key []byte
+	null := false
+	for _, name := range ix.Columns {
+		i, _ := t.Column(name)
+		key = types.AppendKey(key, row[i])
+		null = null || row[i].IsNull()
+	}
+	return key, null
+}
+
+// indexKey は位置 rid の行 row のインデックスのキーを返します。
+func indexKey(t *catalog.Table, ix *catalog.Index, row []types.Value, rid storage.RID) []byte {
+	key, _ := indexPrefix(t, ix, row)
+	return appendRID(key, rid)
+}
+
+func appendRID(buf []byte, rid storage.RID) []byte {
+	buf = binary.BigEndian.AppendUint64(buf, uint64(rid.PageID))
+	return binary.BigEndian.AppendUint32(buf, uint32(rid.Slot))
+}
+
+// keyRID はインデックスのキーの末尾の行の位置を返します。
+func keyRID(key []byte) storage.RID {
+	b := key[len(key)-ridSize:]
+	return storage.RID{PageID: int64(binary.BigEndian.Uint64(b)), Slot: int(binary.BigEndian.Uint32(b[8:]))}
+}
+
+// checkUnique は一意のインデックス ix に row と同じ値の行がないかを調べます。
+// self は更新する行の位置で、その行自身は除きます。
+func (tx *Tx) checkUnique(t *catalog.Table, ix *catalog.Index, row []types.Value, self *storage.RID) error {
+	if !ix.Unique {
+		return nil
+	}
+	prefix, null := indexPrefix(t, ix, row)
+	if null {
+		return nil
+	}
+	c, err := tx.tree(ix).Seek(prefix)
+	if err != nil {
+		return err
+	}
+	for {
+		key, _, ok, err := c.Next()
+		if err != nil || !ok || !bytes.HasPrefix(key, prefix) {
+			return err
+		}
+		if self == nil || keyRID(key) != *self {
+			return &dberr.ConstraintError{Err: ErrUnique, Table: t.Name, Columns: ix.Columns,
+				Detail: fmt.Sprintf("%s.%s", t.Name, strings.Join(ix.Columns, ", "))}
+		}
+	}
+}
+
+// indexes はテーブル t のインデックスを返します。
+func (tx *Tx) indexes(t *catalog.Table) ([]*catalog.Index, error) {
+	cat, err := tx.Catalog()
+	if err != nil {
+		return nil, err
+	}
+	return cat.Indexes(t.Name), nil
+}
+
+// insertIndexes は位置 rid に書き込んだ行 row をすべてのインデックスに登録します。
+func (tx *Tx) insertIndexes(t *catalog.Table, ixs []*catalog.Index, row []types.Value, rid storage.RID) error {
+	for _, ix := range ixs {
+		if err := tx.tree(ix).Insert(indexKey(t, ix, row, rid), nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteIndexes は位置 rid の行 row をすべてのインデックスから取り除きます。
+func (tx *Tx) deleteIndexes(t *catalog.Table, ixs []*catalog.Index, row []types.Value, rid storage.RID) error {
+	for _, ix := range ixs {
+		if _, err := tx.tree(ix).Delete(indexKey(t, ix, row, rid)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// IndexCursor はインデックスの範囲の行を、インデックスの順に返します。
+type IndexCursor struct {
+	tx     *Tx
+	t      *catalog.Table
+	c      *btree.Cursor
+	lo, hi []byte
+	r      exec.IndexRange
+	cols   []bool // nil でなければ、読む列
+}
+
+// ScanIndex はインデックス ix の範囲 r の行を読むカーソルを返します。テーブルを共有ロックします。
+func (tx *Tx) ScanIndex(ix *catalog.Index, r exec.IndexRange) (*IndexCursor, error) {
+	return tx.scanIndex(ix, r, nil)
+}
+
+// scanIndex は ScanIndex と同じですが、cols が nil でなければ cols[i] が false の列の値を読まずに NULL にします。
+func (tx *Tx) scanIndex(ix *catalog.Index, r exec.IndexRange, cols []bool) (*IndexCursor, error) {
+	t, err := tx.table(ix.Table)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.lockTable(t.Name, lock.Shared); err != nil {
+		return nil, err
+	}
+	ic := &IndexCursor{tx: tx, t: t, r: r, cols: cols}
+	for _, v := range r.Lo {
+		ic.lo = types.AppendKey(ic.lo, v)
+	}
+	if r.Hi != nil {
+		ic.hi = []byte{}
+		for _, v := range r.Hi {
+			ic.hi = types.AppendKey(ic.hi, v)
+		}
+	}
+	if ic.c, err = tx.tree(ix).Seek(ic.lo); err != nil {
+		return nil, err
+	}
+	return ic, nil
+}
+
+// Next は次の行を返します。最後まで読むと ok が false になります。
+func (ic *IndexCursor) Next() (rid storage.RID, row []types.Value, ok bool, err error) {
+	for {
+		key, _, ok, err := ic.c.Next()
+		if err != nil || !ok {
+			return storage.RID{}, nil, false, err
+		}
+		if ic.r.LoOpen && bytes.HasPrefix(key, ic.lo) {
+			continue
+		}
+		if ic.hi != nil {
+			in := bytes.HasPrefix(key, ic.hi)
+			if in && ic.r.HiOpen || !in && bytes.Compare(key, ic.hi) > 0 {
+				return storage.RID{}, nil, false, nil
+			}
+		}
+		rid = keyRID(key)
+		row, err := ic.tx.getColumns(ic.t, rid, ic.cols)
+		if err != nil {
+			return rid, nil, false, err
+		}
+		return rid, row, true, nil
+	}
+}
@@ -0,0 +1,193 @@
+package engine
+
+import (
+	"strings"
+
+	"github.com/k-sml/go-rdbms/internal/catalog"
+	"github.com/k-sml/go-rdbms/internal/lock"
+	"github.com/k-sml/go-rdbms/internal/storage"
+	"github.com/k-sml/go-rdbms/internal/types"
+)
+
+// 情報スキーマ
+//
+// __schema.tables などの仮想テーブルは、読むたびにカタログ（stats と integrity_check は
+// テーブルの内容）から行を組み立てる。ページを持たないので書き込みはできない。
+// 通常のテーブルと同じく Scan で読め、定義は VirtualTable で得られる。
+
+// SchemaPrefix は情報スキーマの仮想テーブルの名前の接頭辞です。
+const SchemaPrefix = "__schema."
+
+// virtualTable は情報スキーマの仮想テーブルです。
+type virtualTable struct {
+	def  *catalog.Table
+	rows func(tx *Tx, cat *catalog.Catalog) ([][]types.Value, error)
+}
+
+func vcol(name string, typ types.Type) catalog.Column { return catalog.Column{Name: name, Type: typ} }
+
+var virtualTables = map[string]virtualTable{
+	"tables": {
+		def: &catalog.Table{Columns: []catalog.Column{
+			vcol("name", types.Text), vcol("type", types.Text), vcol("root", types.BigInt), vcol("sql", types.Text),
+		}},
+		rows: schemaTables,
+	},
+	"columns": {
+		def: &catalog.Table{Columns: []catalog.Column{
+			vcol("table_name", types.Text), vcol("position", types.BigInt), vcol("name", types.Text), vcol("type", types.Text),
+			vcol("not_null", types.Boolean), vcol("primary_key", types.Boolean), vcol("auto_increment", types.Boolean),
+			vcol("default", types.Text),
+		}},
+		rows: schemaColumns,
+	},
+	"indexes": {
+		def: &catalog.Table{Columns: []catalog.Column{
+			vcol("name", types.Text), vcol("table_name", types.Text), vcol("columns", types.Text),
+			vcol("unique", types.Boolean), vcol("root", types.BigInt),
+		}},
+		rows: schemaIndexes,
+	},
+	"stats": {
+		def: &catalog.Table{Columns: []catalog.Column{
+			vcol("table_name", types.Text), vcol("rows", types.BigInt), vcol("pages", types.BigInt), vcol("bytes", types.BigInt),
+		}},
+		rows: schemaStats,
+	},
+	"users": {
+		def:  &catalog.Table{Columns: []catalog.Column{vcol("name", types.Text), vcol("access", types.Text)}},
+		rows: schemaUsers,
+	},
+	"integrity_check": {
+		def:  &catalog.Table{Columns: []catalog.Column{vcol("integrity_check", types.Text)}},
+		rows: schemaIntegrityCheck,
+	},
+}
+
+// VirtualTable は情報スキーマの仮想テーブル name の定義を返します（大文字と小文字は区別しません）。
+// 定義は System なので、行を変更しようとするとエラーになります。
+func VirtualTable(name string) (*catalog.Table, bool) {
+	n := strings.ToLower(name)
+	if !strings.HasPrefix(n, SchemaPrefix) {
+		return nil, false
+	}
+	vt, ok := virtualTables[strings.TrimPrefix(n, SchemaPrefix)]
+	if !ok {
+		return nil, false
+	}
+	t := *vt.def
+	t.Name, t.System = n, true
+	return &t, true
+}
+
+// scanVirtual は仮想テーブルの行を fn に渡します。行の位置はページ 0 の連番です。
+func (tx *Tx) scanVirtual(name string, fn func(rid storage.RID, row []types.Value) error) error {
+	cat, err := tx.Catalog()
+	if err != nil {
+		return err
+	}
+	rows, err := virtualTables[strings.TrimPrefix(strings.ToLower(name), SchemaPrefix)].rows(tx, cat)
+	if err != nil {
+		return err
+	}
+	for i, row := range rows {
+		if err := fn(storage.RID{Slot: i}, row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func schemaTables(_ *Tx, cat *catalog.Catalog) ([][]types.Value, error) {
+	var rows [][]types.Value
+	for _, t := range cat.Tables() {
+		rows = append(rows, []types.Value{types.NewText(t.Name), types.NewText("table"), types.NewBigInt(t.Root), types.NullValue()})
+	}
+	for _, v := range cat.Views() {
+		rows = append(rows, []types.Value{types.NewText(v.Name), types.NewText("view"), types.NullValue(), types.NewText(v.Query)})
+	}
+	return rows, nil
+}
+
+func schemaColumns(_ *Tx, cat *catalog.Catalog) ([][]types.Value, error) {
+	var rows [][]types.Value
+	for _, t := range cat.Tables() {
+		for i, c := range t.Columns {
+			def := types.NullValue()
+			if !c.Default.IsNull() {
+				def = types.NewText(c.Default.String())
+			}
+			rows = append(rows, []types.Value{
+				types.NewText(t.Name), types.NewBigInt(int64(i)), types.NewText(c.Name), types.NewText(c.Type.String()),
+				types.NewBool(c.NotNull), types.NewBool(c.PrimaryKey), types.NewBool(c.AutoIncrement), def,
+			})
+		}
+	}
+	return rows, nil
+}
+
+func schemaIndexes(_ *Tx, cat *catalog.Catalog) ([][]types.Value, error) {
+	var rows [][]types.Value
+	for _, t := range cat.Tables() {
+		for _, ix := range cat.Indexes(t.Name) {
+			rows = append(rows, []types.Value{
+				types.NewText(ix.Name), types.NewText(ix.Table), types.NewText(strings.Join(ix.Columns, ",")),
+				types.NewBool(ix.Unique), types.NewBigInt(ix.Root),
+			})
+		}
+	}
+	return rows, nil
+}
+
+// schemaUsers はユーザーの名前と許可した操作を返します。パスワードのハッシュは返しません。
+func schemaUsers(_ *Tx, cat *catalog.Catalog) ([][]types.Value, error) {
+	var rows [][]types.Value
+	for _, u := range cat.Users() {
+		rows = append(rows, []types.Value{types.NewText(u.Name), types.NewText(u.Access.String())})
+	}
+	return rows, nil
+}
+
+// schemaStats はテーブルごとの行数と、ヒープファイルのページ数（ディレクトリページを含む）を数えます。
+func schemaStats(tx *Tx, cat *catalog.Catalog) ([][]types.Value, error) {
+	var rows [][]types.Value
+	for _, t := range cat.Tables() {
+		if err := tx.lockTable(t.Name, lock.Shared); err != nil {
+			return nil, err
+		}
+		hf := storage.OpenHeapFile(tx.tx, t.Root)
+		n := int64(0)
+		if err := hf.Scan(func(storage.RID, []byte) error { n++; return nil }); err != nil {
+			return nil, err
+		}
+		data, err := hf.Pages()
+		if err != nil {
+			return nil, err
+		}
+		dirs, err := hf.DirPages()
+		if err != nil {
+			return nil, err
+		}
+		pages := int64(len(data) + len(dirs))
+		rows = append(rows, []types.Value{
+			types.NewText(t.Name), types.NewBigInt(n), types.NewBigInt(pages), types.NewBigInt(pages * int64(tx.tx.PageSize())),
+		})
+	}
+	return rows, nil
+}
+
+// schemaIntegrityCheck は整合性の検査で見つかった問題を1行に1つ返します。問題がなければ ok の1行です。
+func schemaIntegrityCheck(tx *Tx, _ *catalog.Catalog) ([][]types.Value, error) {
+	problems, err := tx.CheckIntegrity()
+	if err != nil {
+		return nil, err
+	}
+	if len(problems) == 0 {
+		return [][]types.Value{{types.NewText("ok")}}, nil
+	}
+	rows := make([][]types.Value, len(problems))
+	for i, p := range problems {
+		rows[i] = []types.Value{types.NewText(p.String())}
+	}
+	return rows, nil
+}
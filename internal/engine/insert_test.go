@@ -0,0 +1,59 @@
+package engine
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/k-sml/go-rdbms/internal/txn"
+)
+
+// TestInsertAtomic は、途中の行で制約に違反した INSERT がそれまでの行を残さず、同じトランザクションの
+// 後の文とコミットに影響しないことを確かめます。インデックスにも失敗した文の行は残りません。
+func TestInsertAtomic(t *testing.T) {
+	db := openMemory(t)
+	script(t, db, `
+		CREATE TABLE p (id INT PRIMARY KEY);
+		CREATE TABLE c (id INT PRIMARY KEY, p INT NOT NULL REFERENCES p (id), v TEXT);
+		CREATE INDEX c_v ON c (v);
+		INSERT INTO p VALUES (1);
+		INSERT INTO c VALUES (1, 1, 'old');
+		CREATE TABLE src (id INT PRIMARY KEY, p INT);
+		INSERT INTO src VALUES (10, 1), (11, 1), (12, 2);
+	`)
+	for _, sql := range []string{
+		"INSERT INTO c VALUES (2, 1, 'new'), (1, 1, 'dup')",        // 主キーの重複
+		"INSERT INTO c VALUES (2, 1, 'new'), (3, 9, 'no parent')",  // 外部キーの違反
+		"INSERT INTO c VALUES (2, 1, 'new'), (3, NULL, 'null')",    // NOT NULL の違反
+		"INSERT INTO c (id, p, v) SELECT id, p, 'new' FROM src",    // 12 の親がない
+		"UPDATE c SET v = 'new'; INSERT INTO c VALUES (1, 1, 'x')", // 前の文は残る
+	} {
+		tx, err := db.Begin(txn.Options{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		results, err := tx.ExecScript(sql)
+		if err == nil {
+			t.Fatalf("%s succeeded, want an error", sql)
+		}
+		txExec(t, tx, "INSERT INTO c VALUES (5, 1, 'after')")
+		if err := tx.Commit(); err != nil {
+			t.Fatalf("%s: commit: %v", sql, err)
+		}
+
+		want := [][]string{{"1", "1", "old"}, {"5", "1", "after"}}
+		if len(results) > 0 {
+			want[0][2] = "new"
+		}
+		if got := script(t, db, "SELECT * FROM c ORDER BY id"); !slices.EqualFunc(got, want, slices.Equal) {
+			t.Errorf("%s: rows = %v, want %v", sql, got, want)
+		}
+		if got := script(t, db, "SELECT id FROM c WHERE v = 'new' AND id > 1"); len(got) != 0 {
+			t.Errorf("%s: index c_v still finds rows of the failed statement: %v", sql, got)
+		}
+		script(t, db, "DELETE FROM c WHERE id = 5; UPDATE c SET v = 'old'")
+	}
+
+	if n, err := db.Exec("INSERT INTO c VALUES (2, 1, 'new'), (1, 1, 'dup')"); err == nil || n != 0 {
+		t.Errorf("failed INSERT = %d, %v, want 0 rows and an error", n, err)
+	}
+}
@@ -0,0 +1,93 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"math"
+	"time"
+
+	"github.com/k-sml/go-rdbms/internal/dberr"
+	"github.com/k-sml/go-rdbms/metrics"
+)
+
+// 記録
+//
+// DB は Options.Logger に次の出来事を記録する。WALからの復旧とチェックポイントはページャーが記録する。
+//
+//   - 実行に SlowQuery 以上かかった文（Warn）
+//   - 文の実行中に見つかったファイルの破損（Error）
+//   - 整合性の検査で見つかった問題（Error）
+//   - VACUUM の結果（Info）
+//
+// Options.Metrics には、実行した文の数と時間を送る。ページの入出力、コミット、ロックの待機は
+// ページャーとトランザクションマネージャ、ロックマネージャが送る。
+//
+// Options.Hooks の関数は、エグゼキュータで実行する文ごとに、実行の前と後に呼ぶ。問い合わせの
+// After は結果を閉じたときに呼ぶ。BEGIN、COMMIT、ROLLBACK はセッションを管理する側
+// （ExecScript や rdbms.Conn）が扱うので、フックは呼ばない。ビジーハンドラがトランザクションを
+// やり直すと、やり直した文のフックも呼ぶ。
+
+// StatementInfo は Hooks に渡す、実行する文の情報です。
+type StatementInfo struct {
+	SQL   string
+	Args  []any // 文の引数の値
+	Start time.Time
+
+	// 次のフィールドは After にだけ設定します。
+	Duration time.Duration
+	Rows     int64 // 返したか変更した行の数
+	Err      error
+}
+
+// Hooks は文を実行する前と後に呼ぶ関数です。nil の関数は呼びません。関数は複数のゴルーチンから
+// 同時に呼ばれることがあります。
+type Hooks struct {
+	// Before は文を実行する前に呼びます。エラーを返すと文を実行せず、そのエラーで After を呼んで
+	// 文の実行のエラーとして返します。
+	Before func(ctx context.Context, s *StatementInfo) error
+	// After は文の実行を終えた後に呼びます。
+	After func(ctx context.Context, s *StatementInfo)
+}
+
+// discardLogger は Logger を指定しなかった場合に使う、何も出力しないロガーです。
+var discardLogger = slog.New(slog.NewTextHandler(io.Discard, &slog.HandlerOptions{Level: slog.Level(math.MaxInt)}))
+
+// startStatement は文 sql の実行を始め、Hooks.Before を呼びます。Before がエラーを返したら、
+// 文を実行せずに finishStatement を呼んでください。
+func (tx *Tx) startStatement(sql string, args []any) (*statement, error) {
+	s := &statement{ctx: tx.tx.Context(), info: StatementInfo{SQL: sql, Args: args, Start: time.Now()}}
+	if h := tx.db.hooks.Before; h != nil {
+		return s, h(s.ctx, &s.info)
+	}
+	return s, nil
+}
+
+// statement は実行している文です。
+type statement struct {
+	ctx  context.Context // 文を実行するコンテキスト。Hooks に渡す
+	info StatementInfo
+}
+
+// finishStatement は実行を終えた文 s の結果を記録し、計測値を送り、Hooks.After を呼びます。
+// rows は返したか変更した行の数です。
+func (db *DB) finishStatement(s *statement, rows int64, err error) {
+	sql := s.info.SQL
+	d := time.Since(s.info.Start)
+	if h := db.hooks.After; h != nil {
+		s.info.Duration, s.info.Rows, s.info.Err = d, rows, err
+		h(s.ctx, &s.info)
+	}
+	db.metrics.Add(metrics.Statements, 1)
+	db.metrics.Observe(metrics.StatementSeconds, d.Seconds())
+	if err != nil {
+		db.metrics.Add(metrics.StatementErrs, 1)
+	}
+	if err != nil && errors.Is(err, dberr.ErrCorrupt) {
+		db.logger.Error("corruption detected", "sql", sql, "err", err)
+	}
+	if db.slowQuery > 0 && d >= db.slowQuery {
+		db.logger.Warn("slow statement", "sql", sql, "duration", d, "rows", rows)
+	}
+}
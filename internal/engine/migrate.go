@@ -0,0 +1,93 @@
+package engine
+
+import (
+	"fmt"
+
+	"github.com/k-sml/go-rdbms/internal/lock"
+	"github.com/k-sml/go-rdbms/internal/txn"
+)
+
+// Migration はスキーマを1つ新しい版に進める手順です。
+type Migration func(tx *Tx) error
+
+// UserVersion はアプリケーションが管理するスキーマの版を返します。
+func (db *DB) UserVersion() (int64, error) {
+	tx, err := db.Begin(txn.Options{ReadOnly: true})
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	cat, err := tx.Catalog()
+	if err != nil {
+		return 0, err
+	}
+	return cat.UserVersion()
+}
+
+// SetUserVersion はトランザクションの中で、アプリケーションが管理するスキーマの版を v にします。
+func (tx *Tx) SetUserVersion(v int64) error {
+	if err := tx.tx.Lock(lock.Writer(), lock.Exclusive); err != nil {
+		return err
+	}
+	cat, err := tx.Catalog()
+	if err != nil {
+		return err
+	}
+	return cat.SetUserVersion(v)
+}
+
+// Migrate は steps[i] を版 i+1 に進める手順として、データベースの版より新しい手順を順に適用します。
+// 各手順は版の更新と同じトランザクションで実行するので、手順が失敗した場合は
+// その手順の変更は残らず、版は直前に成功した手順のものになります。
+//
+// 適用後の版を返します。データベースの版が steps より新しい場合はエラーになります。
+func (db *DB) Migrate(steps []Migration) (int64, error) {
+	for {
+		done, v, err := db.migrateStep(steps)
+		if err != nil || done {
+			return v, err
+		}
+	}
+}
+
+// migrateStep は次の手順を1つ適用します。適用する手順がなければ done を返します。
+func (db *DB) migrateStep(steps []Migration) (done bool, version int64, err error) {
+	tx, err := db.Begin(txn.Options{})
+	if err != nil {
+		return false, 0, err
+	}
+	// 同時に Migrate を呼んだ他の接続と同じ手順を適用しないよう、書き込み権を取ってから版を読む
+	if err := tx.tx.Lock(lock.Writer(), lock.Exclusive); err != nil {
+		tx.Rollback()
+		return false, 0, err
+	}
+	cat, err := tx.Catalog()
+	if err != nil {
+		tx.Rollback()
+		return false, 0, err
+	}
+	v, err := cat.UserVersion()
+	if err != nil {
+		tx.Rollback()
+		return false, 0, err
+	}
+	if v > int64(len(steps)) {
+		tx.Rollback()
+		return false, v, fmt.Errorf("database schema version %d is newer than the %d known migrations", v, len(steps))
+	}
+	if v == int64(len(steps)) {
+		return true, v, tx.Rollback()
+	}
+	if err := steps[v](tx); err != nil {
+		tx.Rollback()
+		return false, v, fmt.Errorf("migration %d: %w", v+1, err)
+	}
+	if err := tx.SetUserVersion(v + 1); err != nil {
+		tx.Rollback()
+		return false, v, err
+	}
+	if err := tx.Commit(); err != nil {
+		return false, v, fmt.Errorf("migration %d: %w", v+1, err)
+	}
+	return false, v + 1, nil
+}
@@ -0,0 +1,75 @@
+package engine
+
+import (
+	"maps"
+
+	"github.com/k-sml/go-rdbms/internal/txn"
+)
+
+// 入れ子のトランザクション
+//
+// 入れ子のトランザクションは txn.Nested のセーブポイントで、ページの書き込みを開始時点に戻せる。
+// Tx はページのほかに、読み込んだカタログ、予約したシーケンスの値、コミット時に検査する外部キーを
+// 持つので、入れ子のトランザクションはそれも開始時点の状態を取っておき、Rollback で戻す。
+// カタログはページから読み直せばよいので捨てるだけにする。一時テーブルもカタログのページにあるので、
+// 読み直すと開始時点のものに戻る。
+//
+// ExecStmt は文ごとに入れ子のトランザクションを開始し、文がエラーになればそれをロールバックする。
+// そのため、途中の行で一意性や外部キーの制約に違反した文も、それまでに書いた行を残さない。
+
+// Nested は Tx の中で開始した入れ子のトランザクションです。文の実行などの操作は外側の
+// トランザクションに対して行い、Commit で変更を外側に引き継ぐか、Rollback で開始してからの変更を
+// 取り消します。入れ子の中で取得したロックは、外側のトランザクションが終わるまで保持されます。
+type Nested struct {
+	*Tx
+	n *txn.Nested
+
+	seqs      map[string]seqRange // 開始時点の Tx.seqs
+	resetSeqs map[string]bool
+	deferred  map[string]bool
+}
+
+// Begin はトランザクションの中に入れ子のトランザクションを開始します。入れ子のトランザクションの
+// 中でさらに Begin を呼び出すこともできます。
+func (tx *Tx) Begin() (*Nested, error) {
+	n, err := tx.tx.Begin()
+	if err != nil {
+		return nil, err
+	}
+	nt := &Nested{Tx: tx, n: n, resetSeqs: maps.Clone(tx.resetSeqs), deferred: maps.Clone(tx.deferred)}
+	if tx.seqs != nil {
+		// 範囲は払い出すたびに書き換わるので、値で取っておく
+		nt.seqs = make(map[string]seqRange, len(tx.seqs))
+		for k, r := range tx.seqs {
+			nt.seqs[k] = *r
+		}
+	}
+	return nt, nil
+}
+
+// Commit は入れ子のトランザクションを終了し、変更を外側のトランザクションに引き継ぎます。
+// まだ終了していない内側の入れ子のトランザクションも一緒に終了します。
+func (nt *Nested) Commit() error { return nt.n.Commit() }
+
+// Done は入れ子のトランザクションか外側のトランザクションが終了しているかを返します。
+func (nt *Nested) Done() bool { return nt.n.Done() }
+
+// Rollback は入れ子のトランザクションを開始してからの変更を取り消します。
+// まだ終了していない内側の入れ子のトランザクションも一緒に取り消されます。
+func (nt *Nested) Rollback() error {
+	if err := nt.n.Rollback(); err != nil {
+		return err
+	}
+	tx := nt.Tx
+	tx.cat = nil
+	tx.seqs = nil
+	if nt.seqs != nil {
+		tx.seqs = make(map[string]*seqRange, len(nt.seqs))
+		for k, r := range nt.seqs {
+			tx.seqs[k] = &r
+		}
+	}
+	tx.resetSeqs = maps.Clone(nt.resetSeqs)
+	tx.deferred = maps.Clone(nt.deferred)
+	return nil
+}
@@ -0,0 +1,114 @@
+package engine
+
+import (
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/k-sml/go-rdbms/internal/catalog"
+	"github.com/k-sml/go-rdbms/internal/txn"
+)
+
+// txRows は tx で問い合わせ sql を実行し、結果の行を値の文字列にして返します。
+func txRows(t *testing.T, tx *Tx, sql string) [][]string {
+	t.Helper()
+	rows, err := tx.Query(sql)
+	if err != nil {
+		t.Fatalf("%s: %v", sql, err)
+	}
+	defer rows.Close()
+	var out [][]string
+	for rows.Next() {
+		var r []string
+		for _, v := range rows.Values() {
+			r = append(r, v.String())
+		}
+		out = append(out, r)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatalf("%s: %v", sql, err)
+	}
+	return out
+}
+
+// txExec は tx で sql を実行します。
+func txExec(t *testing.T, tx *Tx, sql string) {
+	t.Helper()
+	if _, err := tx.Exec(sql); err != nil {
+		t.Fatalf("%s: %v", sql, err)
+	}
+}
+
+// TestNestedRollback は、入れ子のトランザクションの中の挿入、DDL、AUTOINCREMENT のシーケンスの
+// 進みを Rollback で取り消すと、外側のトランザクションからは入れ子を始める前の状態が見え、
+// コミットした結果にも残らないことを確かめます。Commit した入れ子の変更は外側に引き継がれます。
+func TestNestedRollback(t *testing.T) {
+	db := openMemory(t)
+	script(t, db, "CREATE TABLE a (id INT PRIMARY KEY AUTOINCREMENT, v TEXT)")
+
+	tx, err := db.Begin(txn.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	txExec(t, tx, "INSERT INTO a (v) VALUES ('outer')")
+
+	nt, err := tx.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	txExec(t, nt.Tx, "INSERT INTO a (v) VALUES ('inner')")
+	txExec(t, nt.Tx, "INSERT INTO a VALUES (100, 'bump')")
+	txExec(t, nt.Tx, "CREATE TABLE n (id INT PRIMARY KEY)")
+	txExec(t, nt.Tx, "ALTER TABLE a ADD COLUMN w INT")
+	// 入れ子の中の入れ子は、外側の入れ子の Rollback で一緒に取り消される
+	inner, err := nt.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	txExec(t, inner.Tx, "INSERT INTO n VALUES (1)")
+	if err := inner.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if got := txRows(t, tx, "SELECT id, v, w FROM a ORDER BY id"); len(got) != 3 {
+		t.Fatalf("rows inside the nested transaction = %v, want 3 rows", got)
+	}
+	if err := nt.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+	if err := nt.Rollback(); !errors.Is(err, txn.ErrTxDone) {
+		t.Errorf("second Rollback: err = %v, want %v", err, txn.ErrTxDone)
+	}
+
+	if got, want := txRows(t, tx, "SELECT * FROM a"), [][]string{{"1", "outer"}}; !slices.EqualFunc(got, want, slices.Equal) {
+		t.Errorf("SELECT * FROM a after the rollback = %v, want %v", got, want)
+	}
+	if _, err := tx.Query("SELECT * FROM n"); !errors.Is(err, catalog.ErrTableNotFound) {
+		t.Errorf("table created in the rolled back transaction: err = %v, want %v", err, catalog.ErrTableNotFound)
+	}
+	// シーケンスは入れ子を始める前の予約の続きから払い出す
+	txExec(t, tx, "INSERT INTO a (v) VALUES ('after')")
+
+	kept, err := tx.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	txExec(t, kept.Tx, "INSERT INTO a (v) VALUES ('kept')")
+	if err := kept.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := [][]string{{"1", "outer"}, {"2", "after"}, {"3", "kept"}}
+	if got := script(t, db, "SELECT * FROM a ORDER BY id"); !slices.EqualFunc(got, want, slices.Equal) {
+		t.Errorf("committed rows = %v, want %v", got, want)
+	}
+	// コミットした high-water mark は入れ子の中で進めた 100 ではない
+	if got := script(t, db, "INSERT INTO a (v) VALUES ('next'); SELECT id FROM a WHERE v = 'next'"); len(got) != 1 || got[0][0] == "101" {
+		t.Errorf("id after the commit = %v, want a value below 100", got)
+	}
+	if _, err := db.ExecScript("SELECT * FROM n"); !errors.Is(err, catalog.ErrTableNotFound) {
+		t.Errorf("table created in the rolled back transaction after the commit: err = %v", err)
+	}
+}
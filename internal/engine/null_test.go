@@ -0,0 +1,227 @@
+package engine
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// 三値論理の試験
+//
+// NULL を UNKNOWN として扱う演算を、値の組み合わせを並べた表で確かめる。同じ式を、FROM のない
+// SELECT で定数から評価するのと、表の列に値を入れて行ごとに評価するのとの両方で確かめる。
+
+// truth は TRUE、FALSE、NULL の3つの値です。
+var truth = []string{"TRUE", "FALSE", "NULL"}
+
+// eval は式 expr を評価した値の文字列を返します。
+func eval(t *testing.T, db *DB, expr string) string {
+	t.Helper()
+	rows := script(t, db, "SELECT "+expr)
+	if len(rows) != 1 || len(rows[0]) != 1 {
+		t.Fatalf("SELECT %s returned %v", expr, rows)
+	}
+	return rows[0][0]
+}
+
+// checkExprs は式ごとに、定数として評価した値と、表 v の列 x、y、z に値を入れて評価した値が want に
+// 一致するかを確かめます。args は式の中の %[1]s、%[2]s、%[3]s に入れる値です。
+func checkExprs(t *testing.T, db *DB, format string, want map[[3]string]string, typ string) {
+	t.Helper()
+	for args, w := range want {
+		lit := fmt.Sprintf(format, args[0], args[1], args[2])
+		if got := eval(t, db, lit); got != w {
+			t.Errorf("SELECT %s = %s, want %s", lit, got, w)
+		}
+		script(t, db, "DELETE FROM v")
+		script(t, db, fmt.Sprintf("INSERT INTO v VALUES (CAST(%s AS %s), CAST(%s AS %s), CAST(%s AS %s))",
+			args[0], typ, args[1], typ, args[2], typ))
+		col := fmt.Sprintf(format, "x", "y", "z")
+		rows := script(t, db, "SELECT "+col+" FROM v")
+		if len(rows) != 1 || rows[0][0] != w {
+			t.Errorf("SELECT %s FROM v with x, y, z = %s, %s, %s: %v, want %s", col, args[0], args[1], args[2], rows, w)
+		}
+	}
+}
+
+// openNullTest は checkExprs が使う表 v を作ったデータベースを開きます。
+func openNullTest(t *testing.T, typ string) *DB {
+	t.Helper()
+	db := openMemory(t)
+	script(t, db, fmt.Sprintf("CREATE TABLE v (x %[1]s, y %[1]s, z %[1]s)", typ))
+	return db
+}
+
+// table2 は2つの真理値の組み合わせごとの結果の表 want（truth の順に並べたもの）を、checkExprs の
+// 形にします。
+func table2(want [3][3]string) map[[3]string]string {
+	m := make(map[[3]string]string)
+	for i, a := range truth {
+		for j, b := range truth {
+			m[[3]string{a, b, "NULL"}] = want[i][j]
+		}
+	}
+	return m
+}
+
+// TestNullLogic は NOT、AND、OR の真理値表を確かめます。
+func TestNullLogic(t *testing.T) {
+	db := openNullTest(t, "BOOLEAN")
+	checkExprs(t, db, "NOT %[1]s", map[[3]string]string{
+		{"TRUE", "NULL", "NULL"}:  "FALSE",
+		{"FALSE", "NULL", "NULL"}: "TRUE",
+		{"NULL", "NULL", "NULL"}:  "NULL",
+	}, "BOOLEAN")
+	checkExprs(t, db, "%[1]s AND %[2]s", table2([3][3]string{
+		{"TRUE", "FALSE", "NULL"},
+		{"FALSE", "FALSE", "FALSE"},
+		{"NULL", "FALSE", "NULL"},
+	}), "BOOLEAN")
+	checkExprs(t, db, "%[1]s OR %[2]s", table2([3][3]string{
+		{"TRUE", "TRUE", "TRUE"},
+		{"TRUE", "FALSE", "NULL"},
+		{"TRUE", "NULL", "NULL"},
+	}), "BOOLEAN")
+	// NOT (a AND b) と (NOT a) OR (NOT b) はどの組み合わせでも一致する
+	checkExprs(t, db, "NOT (%[1]s AND %[2]s)", table2([3][3]string{
+		{"FALSE", "TRUE", "NULL"},
+		{"TRUE", "TRUE", "TRUE"},
+		{"NULL", "TRUE", "NULL"},
+	}), "BOOLEAN")
+	checkExprs(t, db, "(NOT %[1]s) OR (NOT %[2]s)", table2([3][3]string{
+		{"FALSE", "TRUE", "NULL"},
+		{"TRUE", "TRUE", "TRUE"},
+		{"NULL", "TRUE", "NULL"},
+	}), "BOOLEAN")
+}
+
+// TestNullIs は IS NULL と IS NOT NULL が NULL を返さないことを確かめます。
+func TestNullIs(t *testing.T) {
+	db := openNullTest(t, "INT")
+	for _, c := range []struct{ value, isNull string }{
+		{"NULL", "TRUE"},
+		{"0", "FALSE"},
+		{"-1", "FALSE"},
+	} {
+		checkExprs(t, db, "%[1]s IS NULL", map[[3]string]string{{c.value, "NULL", "NULL"}: c.isNull}, "INT")
+		not := map[string]string{"TRUE": "FALSE", "FALSE": "TRUE"}[c.isNull]
+		checkExprs(t, db, "%[1]s IS NOT NULL", map[[3]string]string{{c.value, "NULL", "NULL"}: not}, "INT")
+	}
+	for expr, want := range map[string]string{
+		"(NULL + 1) IS NULL":         "TRUE",
+		"(NULL = NULL) IS NULL":      "TRUE",
+		"(NULL AND FALSE) IS NULL":   "FALSE",
+		"(NULL OR TRUE) IS NOT NULL": "TRUE",
+		"'' IS NULL":                 "FALSE",
+		"NOT (NULL IS NULL)":         "FALSE",
+	} {
+		if got := eval(t, db, expr); got != want {
+			t.Errorf("SELECT %s = %s, want %s", expr, got, want)
+		}
+	}
+}
+
+// TestNullComparison は比較の演算子のどちらかの辺が NULL なら NULL になることを確かめます。
+func TestNullComparison(t *testing.T) {
+	db := openNullTest(t, "INT")
+	for _, op := range []string{"=", "<>", "<", "<=", ">", ">="} {
+		checkExprs(t, db, "%[1]s "+strings.ReplaceAll(op, "%", "%%")+" %[2]s", map[[3]string]string{
+			{"NULL", "1", "NULL"}:    "NULL",
+			{"1", "NULL", "NULL"}:    "NULL",
+			{"NULL", "NULL", "NULL"}: "NULL",
+		}, "INT")
+	}
+	checkExprs(t, db, "%[1]s = %[2]s", map[[3]string]string{{"1", "1", "NULL"}: "TRUE", {"1", "2", "NULL"}: "FALSE"}, "INT")
+}
+
+// TestNullArithmetic は算術の演算子のどちらかの辺が NULL なら NULL になることを確かめます。
+func TestNullArithmetic(t *testing.T) {
+	db := openNullTest(t, "INT")
+	for _, op := range []string{"+", "-", "*", "/", "%"} {
+		checkExprs(t, db, "%[1]s "+strings.ReplaceAll(op, "%", "%%")+" %[2]s", map[[3]string]string{
+			{"NULL", "2", "NULL"}:    "NULL",
+			{"2", "NULL", "NULL"}:    "NULL",
+			{"NULL", "NULL", "NULL"}: "NULL",
+		}, "INT")
+	}
+	checkExprs(t, db, "-%[1]s", map[[3]string]string{{"NULL", "NULL", "NULL"}: "NULL"}, "INT")
+	checkExprs(t, db, "(%[1]s + 1) * %[2]s", map[[3]string]string{{"1", "NULL", "NULL"}: "NULL", {"1", "3", "NULL"}: "6"}, "INT")
+	if got := eval(t, db, "NULL || 'a'"); got != "NULL" {
+		t.Errorf("SELECT NULL || 'a' = %s, want NULL", got)
+	}
+}
+
+// TestNullInBetween は IN と BETWEEN が比較と AND、OR の組み合わせと同じ結果になることを確かめます。
+func TestNullInBetween(t *testing.T) {
+	db := openNullTest(t, "INT")
+	checkExprs(t, db, "%[1]s IN (%[2]s, %[3]s)", map[[3]string]string{
+		{"1", "1", "NULL"}:    "TRUE", // 一致すれば NULL があっても TRUE
+		{"1", "2", "NULL"}:    "NULL", // 一致せず NULL があれば NULL
+		{"1", "2", "3"}:       "FALSE",
+		{"NULL", "1", "2"}:    "NULL",
+		{"NULL", "NULL", "1"}: "NULL",
+	}, "INT")
+	checkExprs(t, db, "%[1]s NOT IN (%[2]s, %[3]s)", map[[3]string]string{
+		{"1", "1", "NULL"}: "FALSE",
+		{"1", "2", "NULL"}: "NULL",
+		{"1", "2", "3"}:    "TRUE",
+		{"NULL", "1", "2"}: "NULL",
+	}, "INT")
+	checkExprs(t, db, "%[1]s BETWEEN %[2]s AND %[3]s", map[[3]string]string{
+		{"2", "1", "NULL"}:       "NULL",  // TRUE AND NULL
+		{"0", "1", "NULL"}:       "FALSE", // FALSE AND NULL
+		{"2", "NULL", "1"}:       "FALSE", // NULL AND FALSE
+		{"0", "NULL", "1"}:       "NULL",  // NULL AND TRUE
+		{"NULL", "1", "2"}:       "NULL",
+		{"1", "1", "2"}:          "TRUE",
+		{"NULL", "NULL", "NULL"}: "NULL",
+	}, "INT")
+	checkExprs(t, db, "%[1]s NOT BETWEEN %[2]s AND %[3]s", map[[3]string]string{
+		{"2", "1", "NULL"}: "NULL",
+		{"0", "1", "NULL"}: "TRUE",
+		{"2", "NULL", "1"}: "TRUE",
+		{"1", "1", "2"}:    "FALSE",
+	}, "INT")
+}
+
+// TestNullWhere は WHERE が TRUE になる行だけを残し、FALSE と NULL（UNKNOWN）の行を除くことを
+// 確かめます。
+func TestNullWhere(t *testing.T) {
+	db := openMemory(t)
+	script(t, db, `
+		CREATE TABLE t (id INT PRIMARY KEY, a INT, b INT);
+		INSERT INTO t VALUES (1, 1, 1);
+		INSERT INTO t VALUES (2, 1, NULL);
+		INSERT INTO t VALUES (3, NULL, NULL);
+		INSERT INTO t VALUES (4, 2, 1);
+	`)
+	for where, want := range map[string][]string{
+		"a = b":               {"1"},
+		"NOT (a = b)":         {"4"},
+		"a <> b":              {"4"},
+		"a = b OR b IS NULL":  {"1", "2", "3"},
+		"a = b AND b IS NULL": nil,
+		"b IS NULL":           {"2", "3"},
+		"b IS NOT NULL":       {"1", "4"},
+		"a + b > 1":           {"1", "4"},
+		"NOT (a + b > 1)":     nil,
+		"a IN (2, b)":         {"1", "4"},
+		"NOT (a IN (2, b))":   nil,
+		"a NOT IN (2, NULL)":  nil,
+		"a BETWEEN b AND 2":   {"1", "4"},
+		"(a = b) IS NULL":     {"2", "3"},
+		"(a = b) IS NOT NULL": {"1", "4"},
+		"CASE WHEN a = b THEN FALSE ELSE TRUE END": {"2", "3", "4"},
+		"NULL":     nil,
+		"NOT NULL": nil,
+	} {
+		var got []string
+		for _, row := range script(t, db, "SELECT id FROM t WHERE "+where+" ORDER BY id") {
+			got = append(got, row[0])
+		}
+		if !slices.Equal(got, want) {
+			t.Errorf("WHERE %s: ids %s, want %s", where, strings.Join(got, ","), strings.Join(want, ","))
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package engine
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// explain は query の EXPLAIN の行を返します。
+func explain(t *testing.T, db *DB, query string) []string {
+	t.Helper()
+	var lines []string
+	for _, row := range script(t, db, "EXPLAIN "+query) {
+		lines = append(lines, row[0])
+	}
+	return lines
+}
+
+var estimateRows = regexp.MustCompile(`rows=(\d+)`)
+
+// estimate は EXPLAIN の行 lines のうち、name を含む最初の行の見積もりの行の数を返します。
+func estimate(t *testing.T, lines []string, name string) int {
+	t.Helper()
+	for _, line := range lines {
+		if strings.Contains(line, name) {
+			if m := estimateRows.FindStringSubmatch(line); m != nil {
+				n, _ := strconv.Atoi(m[1])
+				return n
+			}
+		}
+	}
+	t.Fatalf("no %q with an estimate in\n%s", name, strings.Join(lines, "\n"))
+	return 0
+}
+
+// fill は表 name に id が 1 から n まで、s が id を 10 で割った余りの行を入れます。
+func fill(t *testing.T, db *DB, name string, n int) {
+	t.Helper()
+	script(t, db, fmt.Sprintf(`CREATE TABLE %[1]s (id INT PRIMARY KEY, s INT);
+		INSERT INTO %[1]s WITH RECURSIVE g(n) AS (SELECT 1 UNION ALL SELECT n + 1 FROM g WHERE n < %[2]d)
+			SELECT n, n %% 10 FROM g`, name, n))
+}
+
+// TestExplainIndexRange は、インデックスの範囲で決まる条件の見積もりを、IndexScan の上の Filter で
+// もう一度掛けないことを確かめます。範囲に使わない条件は Filter で見積もりを減らします。
+func TestExplainIndexRange(t *testing.T) {
+	db := openMemory(t)
+	fill(t, db, "t", 10000)
+	script(t, db, "ANALYZE t")
+
+	lines := explain(t, db, "SELECT * FROM t WHERE id >= 100 AND id < 200")
+	scan := estimate(t, lines, "Index Scan using")
+	if scan < 80 || scan > 120 {
+		t.Errorf("Index Scan estimate = %d rows, want about 100\n%s", scan, strings.Join(lines, "\n"))
+	}
+	if filter := estimate(t, lines, "Filter  ("); filter != scan {
+		t.Errorf("Filter estimate = %d rows, want %d as the Index Scan\n%s", filter, scan, strings.Join(lines, "\n"))
+	}
+
+	lines = explain(t, db, "SELECT * FROM t WHERE id >= 100 AND id < 200 AND s = 3")
+	scan = estimate(t, lines, "Index Scan using")
+	if filter := estimate(t, lines, "Filter  ("); filter < scan/20 || filter > scan/5 {
+		t.Errorf("Filter estimate = %d rows over %d, want about a tenth\n%s", filter, scan, strings.Join(lines, "\n"))
+	}
+
+	lines = explain(t, db, "SELECT * FROM t WHERE id BETWEEN 100 AND 150")
+	if filter, scan := estimate(t, lines, "Filter  ("), estimate(t, lines, "Index Scan using"); filter != scan {
+		t.Errorf("Filter estimate = %d rows, want %d as the Index Scan\n%s", filter, scan, strings.Join(lines, "\n"))
+	}
+}
+
+// TestExplainHashJoinBuildSide は、INNER JOIN の HashJoin が行の少ないほうの入力でハッシュ表を作り、
+// どちらの側で作っても結果の列の順が変わらないことを確かめます。
+func TestExplainHashJoinBuildSide(t *testing.T) {
+	db := openMemory(t)
+	fill(t, db, "big", 5000)
+	fill(t, db, "small", 10)
+	script(t, db, "ANALYZE")
+
+	for _, q := range []string{
+		"SELECT * FROM small JOIN big ON big.s = small.id",
+		"SELECT * FROM big JOIN small ON big.s = small.id",
+	} {
+		lines := explain(t, db, q)
+		var scans []string
+		for _, line := range lines {
+			if strings.Contains(line, "Seq Scan on") {
+				scans = append(scans, strings.Fields(line)[4])
+			}
+		}
+		if estimate(t, lines, "Hash Join") == 0 || len(scans) != 2 || scans[1] != "small" {
+			t.Errorf("%s: want a Hash Join building on small\n%s", q, strings.Join(lines, "\n"))
+		}
+	}
+
+	rows := script(t, db, "SELECT * FROM small JOIN big ON big.s = small.id ORDER BY big.id LIMIT 1")
+	if got := strings.Join(rows[0], ","); got != "1,1,1,1" {
+		t.Errorf("small JOIN big: first row %s, want 1,1,1,1", got)
+	}
+	rows = script(t, db, "SELECT big.id, small.s FROM small JOIN big ON big.s = small.id WHERE big.id > 4990 ORDER BY big.id")
+	if len(rows) != 9 || strings.Join(rows[0], ",") != "4991,1" {
+		t.Errorf("small JOIN big with big.id > 4990 = %v, want 9 rows from 4991,1", rows)
+	}
+}
@@ -0,0 +1,564 @@
+package engine
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/k-sml/go-rdbms/internal/catalog"
+	"github.com/k-sml/go-rdbms/internal/cdc"
+	"github.com/k-sml/go-rdbms/internal/pager"
+	"github.com/k-sml/go-rdbms/internal/storage"
+	"github.com/k-sml/go-rdbms/internal/txn"
+	"github.com/k-sml/go-rdbms/internal/types"
+	"github.com/k-sml/go-rdbms/internal/wal"
+)
+
+// 論理レプリケーション
+//
+// Publish は選んだテーブルの行の変更を、JSON の行（1行に1つのメッセージ）で送り続ける。
+// ストリーミングレプリケーション（replication.go）と違ってページではなく行を送るので、受け取る側は
+// データベースの一部だけを持つことも、ほかのデータベースやシステムに書き込むこともできる。
+// 行は主キーで識別するので、公開するテーブルには主キーがなければならない（主キーの一意性は
+// CREATE TABLE が作る一意インデックスで保たれるので、同じ主キーの行が2つあることはない）。
+// ストリームは次のメッセージからなる。
+//
+//	{"type": "table", "table": "users", "columns": [{"name": "id", "type": "BIGINT", "primary_key": true, "not_null": true}, ...]}
+//	{"type": "row", "table": "users", "row": {"id": 1, "name": "alice"}}
+//	{"type": "ready", "lsn": 120}
+//	{"type": "commit", "lsn": 125, "tx": 42, "changes": [{"table": "users", "op": "update", "old": {...}, "new": {...}}, ...]}
+//
+// 最初に公開するテーブルごとに table で列の定義を送り、続けてスナップショットの行を row で送って
+// ready で終える（lsn はスナップショットに含まれる最後のWALのレコード）。その後は、公開する
+// テーブルの行を変更したコミットごとに commit を1つ送る。changes の op は insert（new だけ）、
+// update（old と new）、delete（old だけ）で、old と new は行のすべての列の値である。同じ
+// コミットの中では delete、update、insert の順に並べるので、主キーを入れ替えるコミットも先頭から
+// 順に適用できる。列の追加などでテーブルの定義が変わると、そのコミットの前に table をもう一度送る。
+// 値は HTTP の API と同じく JSON の数値、文字列、真偽値、null で表し、BLOB は base64、TIMESTAMP は
+// RFC 3339 の文字列、有限でない実数は "NaN" などの文字列にする。
+//
+// 変更はWALのレコードからではなく、コミットで書き換えたページの前後の内容（pager.Pager.ShipPages）を
+// cdc.Decoder でスロットごとの変更にしてから、主キーごとにまとめて作る。ページがどのテーブルの
+// ものかは、テーブルのヒープファイルのディレクトリページを追って決める。スナップショットを取るのと
+// 変更を受け取り始めるのは Replicate と同じく同時に行い、送るのが遅れると ErrReplicaLagging で
+// 終わる。スキーマが変わったコミットでは公開するテーブルの定義を読み直すが、そのときにはもう
+// 次のスキーマの変更がコミットされていると、そのコミットの時点の定義がわからないので
+// ストリームを終える（受け取る側は接続し直してスナップショットから受け取り直す）。
+//
+// Subscribe（subscription.go）はストリームを読んで、データベースのテーブルに適用する。
+
+// メッセージの種類
+const (
+	pubTableMsg  = "table"
+	pubRowMsg    = "row"
+	pubReadyMsg  = "ready"
+	pubCommitMsg = "commit"
+)
+
+// pubMessage は論理レプリケーションのストリームの1つのメッセージです。
+type pubMessage struct {
+	Type    string          `json:"type"`
+	Table   string          `json:"table,omitempty"`
+	Columns []pubColumn     `json:"columns,omitempty"`
+	Row     json.RawMessage `json:"row,omitempty"`
+	LSN     uint64          `json:"lsn,omitempty"`
+	TxID    uint64          `json:"tx,omitempty"`
+	Changes []pubChange     `json:"changes,omitempty"`
+}
+
+// pubColumn は table のメッセージの列の定義です。
+type pubColumn struct {
+	Name       string `json:"name"`
+	Type       string `json:"type"`
+	PrimaryKey bool   `json:"primary_key,omitempty"`
+	NotNull    bool   `json:"not_null,omitempty"`
+}
+
+// pubChange は commit のメッセージの1行の変更です。
+type pubChange struct {
+	Table string          `json:"table"`
+	Op    cdc.Op          `json:"op"`
+	Old   json.RawMessage `json:"old,omitempty"`
+	New   json.RawMessage `json:"new,omitempty"`
+}
+
+// errSchemaMoved は公開するテーブルの定義を読み直す前に、さらにスキーマが変わった場合に
+// Publish が返します。
+var errSchemaMoved = errors.New("schema changed again before the publication caught up")
+
+// pubCommit はコミットで書き換えたページです。
+type pubCommit struct {
+	txID, lsn uint64
+	pages     []pager.PageChange
+}
+
+// Publish は tables のテーブルの論理レプリケーションのストリームを w に書きます。ctx が取り消されるか、
+// w への書き込みに失敗するか、送るのが遅れすぎる（ErrReplicaLagging）まで戻りません。
+// JournalWAL で書き込めるデータベースでなければ pager.ErrNotLeader を返します。
+func (db *DB) Publish(ctx context.Context, w io.Writer, tables []string) error {
+	if len(tables) == 0 {
+		return errors.New("no tables to publish")
+	}
+	feed := make(chan pubCommit, replicaQueue)
+	lagging := make(chan struct{})
+	var once sync.Once
+	var lsn uint64
+	var stop func()
+	t, err := db.txns.BeginWith(txn.Options{ReadOnly: true}, func() error {
+		var err error
+		lsn, stop, err = db.pager.ShipPages(func(txID, lsn uint64, pages []pager.PageChange) {
+			select {
+			case feed <- pubCommit{txID: txID, lsn: lsn, pages: pages}:
+			default:
+				once.Do(func() { close(lagging) })
+			}
+		})
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	defer stop()
+
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	start := time.Now()
+	tx := &Tx{db: db, tx: t}
+	p, rows, err := db.publishSnapshot(ctx, tx, tables, enc)
+	tx.Rollback()
+	if err != nil {
+		return err
+	}
+	if err := enc.Encode(pubMessage{Type: pubReadyMsg, LSN: lsn - 1}); err != nil {
+		return err
+	}
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+	db.logger.Info("publication snapshot sent", "tables", len(p.tables), "rows", rows, "lsn", lsn-1,
+		"duration", time.Since(start))
+
+	for {
+		select {
+		case <-ctx.Done():
+			return context.Cause(ctx)
+		case <-lagging:
+			return ErrReplicaLagging
+		case c := <-feed:
+			msgs, err := p.commit(c)
+			if err != nil {
+				return err
+			}
+			for _, m := range msgs {
+				if err := enc.Encode(m); err != nil {
+					return err
+				}
+			}
+			if len(feed) == 0 { // 溜まっているメッセージはまとめて送る
+				if err := bw.Flush(); err != nil {
+					return err
+				}
+			}
+		}
+	}
+}
+
+// publication は公開するテーブルと、コミットを行の変更にする状態です。
+type publication struct {
+	db       *DB
+	tables   []*pubTable
+	byName   map[string]*pubTable // 小文字にした名前がキー
+	version  uint64               // 定義を読んだときのスキーマの版
+	dec      *cdc.Decoder
+	cur      string // dec に渡しているページが属するテーブル
+	pageSize int
+}
+
+// pubTable は公開するテーブルです。
+type pubTable struct {
+	t     *catalog.Table
+	key   []int            // 主キーの列の位置
+	root  int64            // dirs と pages を読んだヒープファイルのルート
+	dirs  map[int64][]byte // ヒープファイルのディレクトリページ
+	pages map[int64]bool   // ヒープファイルのデータページ
+
+	// 定義を読み直したコミットでは、コミット前の行を古い定義でデコードする
+	prev    *catalog.Table
+	prevKey []int
+}
+
+// publishSnapshot は tx から見える公開するテーブルの定義と行を enc に書き、以降のコミットを
+// 行の変更にする publication と、書いた行の数を返します。
+func (db *DB) publishSnapshot(ctx context.Context, tx *Tx, names []string, enc *json.Encoder) (*publication, int64, error) {
+	cat, err := tx.Catalog()
+	if err != nil {
+		return nil, 0, err
+	}
+	p := &publication{
+		db:       db,
+		byName:   make(map[string]*pubTable),
+		version:  cat.Version(),
+		dec:      cdc.NewDecoder(),
+		pageSize: db.pager.PageSize(),
+	}
+	p.dec.Tables = func(int64) (string, bool) { return p.cur, p.cur != "" }
+	for _, name := range names {
+		t, ok := cat.Table(name)
+		switch {
+		case !ok || catalog.Hidden(name):
+			return nil, 0, fmt.Errorf("%w: %s", catalog.ErrTableNotFound, name)
+		case t.System:
+			return nil, 0, fmt.Errorf("cannot publish system table %s", t.Name)
+		case p.byName[strings.ToLower(t.Name)] != nil:
+			continue
+		}
+		key := primaryKey(t)
+		if len(key) == 0 {
+			return nil, 0, fmt.Errorf("cannot publish table %s without a primary key", t.Name)
+		}
+		pt := &pubTable{t: t, key: key, prev: t, prevKey: key, root: t.Root}
+		pt.dirs, pt.pages, err = walkHeapDir(t.Root, tx.tx.ReadPage)
+		if err != nil {
+			return nil, 0, err
+		}
+		p.tables = append(p.tables, pt)
+		p.byName[strings.ToLower(t.Name)] = pt
+	}
+	for _, pt := range p.tables {
+		if err := enc.Encode(tableMessage(pt.t)); err != nil {
+			return nil, 0, err
+		}
+	}
+	var n int64
+	for _, pt := range p.tables {
+		h := storage.OpenHeapFileWithOptions(tx.tx, pt.t.Root, pt.t.HeapOptions())
+		err := h.Scan(func(rid storage.RID, rec []byte) error {
+			if n%1024 == 0 {
+				if err := context.Cause(ctx); err != nil {
+					return err
+				}
+			}
+			row, err := decodeRow(pt.t, rid, rec)
+			if err != nil {
+				return err
+			}
+			n++
+			return enc.Encode(pubMessage{Type: pubRowMsg, Table: pt.t.Name, Row: rowJSON(pt.t, row)})
+		})
+		if err != nil {
+			return nil, n, err
+		}
+	}
+	return p, n, nil
+}
+
+// commit はコミットで書き換えたページ c から、公開するテーブルの変更を送るメッセージを作ります。
+// 公開するテーブルの行を変更していなければ何も返しません。
+func (p *publication) commit(c pubCommit) ([]pubMessage, error) {
+	written := make(map[int64][]byte, len(c.pages))
+	for _, pg := range c.pages {
+		written[pg.ID] = pg.New
+	}
+	var msgs []pubMessage
+	if buf, ok := written[0]; ok {
+		h, ok, err := storage.ReadFileHeader(buf)
+		if err != nil {
+			return nil, err
+		}
+		if ok && h.SchemaVersion != p.version {
+			redefined, err := p.reload(h.SchemaVersion)
+			if err != nil {
+				return nil, err
+			}
+			for _, pt := range redefined {
+				msgs = append(msgs, tableMessage(pt.t))
+			}
+		}
+	}
+
+	// コミット後にテーブルに属するページを求める（変わらなければ nil）
+	next := make([]map[int64]bool, len(p.tables))
+	nextDirs := make([]map[int64][]byte, len(p.tables))
+	for i, pt := range p.tables {
+		if pt.t.Root == pt.root && !touchesAny(pt.dirs, written) {
+			continue
+		}
+		dirs, pages, err := walkHeapDir(pt.t.Root, func(id int64) ([]byte, error) {
+			if buf, ok := written[id]; ok {
+				return buf, nil
+			}
+			if buf, ok := pt.dirs[id]; ok {
+				return buf, nil
+			}
+			return nil, fmt.Errorf("publication lost track of directory page %d of table %s", id, pt.t.Name)
+		})
+		if err != nil {
+			return nil, err
+		}
+		next[i], nextDirs[i] = pages, dirs
+	}
+
+	// テーブルに加わったページは空のページから、外れたページは空のページへの変更とみなす
+	empty := make([]byte, p.pageSize)
+	for _, pg := range c.pages {
+		for i, pt := range p.tables {
+			before, after := pt.pages[pg.ID], pt.pages[pg.ID]
+			if next[i] != nil {
+				after = next[i][pg.ID]
+			}
+			if !before && !after {
+				continue
+			}
+			base, img := pg.Old, pg.New
+			if !before {
+				base = nil
+			}
+			if !after {
+				img = empty
+			}
+			p.cur = pt.t.Name
+			p.dec.SetBase(pg.ID, base)
+			p.dec.Decode(&wal.Record{Type: wal.RecPageImage, TxID: c.txID, PageID: pg.ID, Payload: img})
+			p.dec.SetBase(pg.ID, nil) // コミットごとに前後の内容を受け取るので覚えておかない
+		}
+	}
+	p.cur = ""
+	evs := p.dec.Decode(&wal.Record{Type: wal.RecCommit, LSN: c.lsn, TxID: c.txID})
+	for i, pt := range p.tables {
+		if next[i] != nil {
+			pt.pages, pt.dirs, pt.root = next[i], nextDirs[i], pt.t.Root
+		}
+	}
+
+	changes, err := p.changes(evs)
+	for _, pt := range p.tables {
+		pt.prev, pt.prevKey = pt.t, pt.key
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(changes) > 0 {
+		msgs = append(msgs, pubMessage{Type: pubCommitMsg, LSN: c.lsn, TxID: c.txID, Changes: changes})
+	}
+	return msgs, nil
+}
+
+// rowChange は1つのコミットでの1行（主キーの値）の変更前と変更後です。
+type rowChange struct {
+	pt       *pubTable
+	old, new []types.Value
+}
+
+// changes はスロットごとの変更イベントを主キーごとにまとめ、delete、update、insert の順に並べます。
+// 行が別のスロットに移っただけで値が変わらない変更は除きます。
+func (p *publication) changes(evs []cdc.Event) ([]pubChange, error) {
+	rows := make(map[string]*rowChange)
+	var order []string
+	add := func(pt *pubTable, key []byte) *rowChange {
+		k := pt.t.Name + "\x00" + string(key)
+		rc, ok := rows[k]
+		if !ok {
+			rc = &rowChange{pt: pt}
+			rows[k] = rc
+			order = append(order, k)
+		}
+		return rc
+	}
+	for _, ev := range evs {
+		pt := p.byName[strings.ToLower(ev.Table)]
+		rid := storage.RID{PageID: ev.PageID, Slot: ev.Slot}
+		if ev.Old != nil {
+			row, err := decodeStored(pt.prev, rid, ev.Old)
+			if err != nil {
+				return nil, err
+			}
+			add(pt, rowKey(row, pt.prevKey)).old = row
+		}
+		if ev.New != nil {
+			row, err := decodeStored(pt.t, rid, ev.New)
+			if err != nil {
+				return nil, err
+			}
+			add(pt, rowKey(row, pt.key)).new = row
+		}
+	}
+
+	var deletes, updates, inserts []pubChange
+	for _, k := range order {
+		rc := rows[k]
+		ch := pubChange{Table: rc.pt.t.Name}
+		if rc.old != nil {
+			ch.Old = rowJSON(rc.pt.prev, rc.old)
+		}
+		if rc.new != nil {
+			ch.New = rowJSON(rc.pt.t, rc.new)
+		}
+		switch {
+		case rc.new == nil:
+			ch.Op = cdc.OpDelete
+			deletes = append(deletes, ch)
+		case rc.old == nil:
+			ch.Op = cdc.OpInsert
+			inserts = append(inserts, ch)
+		case !bytes.Equal(ch.Old, ch.New):
+			ch.Op = cdc.OpUpdate
+			updates = append(updates, ch)
+		}
+	}
+	return slices.Concat(deletes, updates, inserts), nil
+}
+
+// reload はスキーマの版が version に変わったコミットで、公開するテーブルの定義を読み直し、
+// 列が変わったテーブルを返します。
+func (p *publication) reload(version uint64) ([]*pubTable, error) {
+	tx, err := p.db.Begin(txn.Options{ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	cat, err := tx.Catalog()
+	if err != nil {
+		return nil, err
+	}
+	if cat.Version() != version {
+		return nil, errSchemaMoved
+	}
+	var redefined []*pubTable
+	for _, pt := range p.tables {
+		t, ok := cat.Table(pt.t.Name)
+		if !ok {
+			return nil, fmt.Errorf("published table %s was dropped or renamed", pt.t.Name)
+		}
+		key := primaryKey(t)
+		if len(key) == 0 {
+			return nil, fmt.Errorf("published table %s no longer has a primary key", t.Name)
+		}
+		if !slices.EqualFunc(t.Columns, pt.t.Columns, sameColumn) {
+			redefined = append(redefined, pt)
+		}
+		pt.t, pt.key = t, key
+	}
+	p.version = version
+	return redefined, nil
+}
+
+// sameColumn は列 a と b をストリームで同じ定義として送るかを返します。
+func sameColumn(a, b catalog.Column) bool {
+	return a.Name == b.Name && a.Type == b.Type && a.PrimaryKey == b.PrimaryKey && a.NotNull == b.NotNull
+}
+
+// walkHeapDir はルートが root のヒープファイルのディレクトリページを read で読んで、
+// ディレクトリページの内容とデータページを返します。
+func walkHeapDir(root int64, read func(id int64) ([]byte, error)) (map[int64][]byte, map[int64]bool, error) {
+	dirs := make(map[int64][]byte)
+	pages := make(map[int64]bool)
+	for id := root; id != 0; {
+		if _, ok := dirs[id]; ok {
+			return nil, nil, fmt.Errorf("heap directory at page %d has a cycle", root)
+		}
+		buf, err := read(id)
+		if err != nil {
+			return nil, nil, err
+		}
+		if !storage.IsDirPage(buf) {
+			return nil, nil, fmt.Errorf("page %d is not a heap directory page", id)
+		}
+		dirs[id] = buf
+		ids, next := storage.ReadDirPage(buf)
+		for _, pid := range ids {
+			pages[pid] = true
+		}
+		id = next
+	}
+	return dirs, pages, nil
+}
+
+// touchesAny は written が dirs のページを含むかを返します。
+func touchesAny(dirs, written map[int64][]byte) bool {
+	for id := range dirs {
+		if _, ok := written[id]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// primaryKey はテーブル t の主キーの列の位置を返します。主キーの値は行ごとに異なります。
+func primaryKey(t *catalog.Table) []int {
+	var key []int
+	for i, c := range t.Columns {
+		if c.PrimaryKey {
+			key = append(key, i)
+		}
+	}
+	return key
+}
+
+// rowKey は行 row の主キーの値を比べられるバイト列にします。
+func rowKey(row []types.Value, key []int) []byte {
+	var b []byte
+	for _, i := range key {
+		b = types.AppendKey(b, row[i])
+	}
+	return b
+}
+
+// decodeStored はヒープページに格納された行 rec を、テーブル t の格納方法に従ってデコードします。
+func decodeStored(t *catalog.Table, rid storage.RID, rec []byte) ([]types.Value, error) {
+	rec, err := storage.OpenHeapFileWithOptions(nil, t.Root, t.HeapOptions()).DecodeRecord(rec)
+	if err != nil {
+		return nil, fmt.Errorf("%s row %s: %w", t.Name, rid, err)
+	}
+	return decodeRow(t, rid, rec)
+}
+
+// tableMessage はテーブル t の列の定義を送るメッセージを作ります。
+func tableMessage(t *catalog.Table) pubMessage {
+	cols := make([]pubColumn, len(t.Columns))
+	for i, c := range t.Columns {
+		cols[i] = pubColumn{Name: c.Name, Type: c.Type.String(), PrimaryKey: c.PrimaryKey, NotNull: c.NotNull}
+	}
+	return pubMessage{Type: pubTableMsg, Table: t.Name, Columns: cols}
+}
+
+// rowJSON は行 row を、列の名前をキーにした JSON のオブジェクトにします（列の順に並べる）。
+func rowJSON(t *catalog.Table, row []types.Value) json.RawMessage {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, c := range t.Columns {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		// 名前は文字列、値は数値、文字列、真偽値、[]byte（base64）、nil なので、変換に失敗しない
+		b, _ := json.Marshal(c.Name)
+		buf.Write(b)
+		buf.WriteByte(':')
+		b, _ = json.Marshal(jsonValue(row[i]))
+		buf.Write(b)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes()
+}
+
+// jsonValue は値 v を JSON にする Go の値にします。
+func jsonValue(v types.Value) any {
+	switch v.Type() {
+	case types.Real:
+		if f := v.Real(); math.IsInf(f, 0) || math.IsNaN(f) {
+			return strconv.FormatFloat(f, 'g', -1, 64)
+		}
+	case types.Timestamp:
+		return v.Time().Format(time.RFC3339Nano)
+	}
+	return v.Go()
+}
@@ -0,0 +1,253 @@
+package engine
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+
+	"github.com/k-sml/go-rdbms/internal/catalog"
+	"github.com/k-sml/go-rdbms/internal/exec"
+	"github.com/k-sml/go-rdbms/internal/lock"
+	"github.com/k-sml/go-rdbms/internal/storage"
+	"github.com/k-sml/go-rdbms/internal/tuple"
+	"github.com/k-sml/go-rdbms/internal/types"
+)
+
+// Rows は問い合わせの結果です。Next で1行ずつ進め、読み終えたら Close します。
+type Rows struct {
+	op       exec.Operator
+	release  func() // 閉じたときに実行計画を Stmt に返す
+	cols     []string
+	colTypes []types.Type
+	row      []types.Value
+	err      error
+	done     bool
+
+	tx  *Tx
+	ctx context.Context // QueryContext に渡したコンテキスト（nil なら取り消されない）
+
+	// 閉じたときに記録する文と、返した行の数（log.go）
+	db   *DB
+	stmt *statement
+	n    int64
+}
+
+// Query はトランザクションの中で SELECT 文を実行します。args は文の中の引数（? と $1 など）の
+// 値です。同じ SQL 文の実行計画は使い回します。
+func (tx *Tx) Query(sql string, args ...any) (*Rows, error) {
+	s, err := tx.db.Prepare(sql)
+	if err != nil {
+		return nil, err
+	}
+	return tx.QueryStmt(s, args...)
+}
+
+// QueryContext は Query と同じですが、ctx が取り消されると、実行計画を作るときと結果の行を
+// 読むときのロックの待機や次のページの読み取りで止まり、ctx のエラーを返します。
+func (tx *Tx) QueryContext(ctx context.Context, sql string, args ...any) (*Rows, error) {
+	restore := tx.tx.SetContext(ctx)
+	rows, err := tx.Query(sql, args...)
+	restore()
+	if err != nil {
+		return nil, err
+	}
+	rows.tx, rows.ctx = tx, ctx
+	return rows, nil
+}
+
+// Columns は結果の列の名前を返します。
+func (r *Rows) Columns() []string { return r.cols }
+
+// ColumnTypes は結果の列の型を返します。型が決まらない式の列は types.Null です。
+func (r *Rows) ColumnTypes() []types.Type { return r.colTypes }
+
+// Next は次の行に進みます。行がなくなるかエラーになると false を返します。
+func (r *Rows) Next() bool {
+	if r.done {
+		return false
+	}
+	if r.ctx != nil {
+		if err := context.Cause(r.ctx); err != nil {
+			r.err = err
+			r.Close()
+			return false
+		}
+		defer r.tx.tx.SetContext(r.ctx)()
+	}
+	row, ok, err := r.op.Next()
+	if err != nil || !ok {
+		r.err = err
+		r.Close()
+		return false
+	}
+	r.row = row
+	r.n++
+	return true
+}
+
+// Values は現在の行の値を返します。値は次の Next の呼び出しまで有効です。
+func (r *Rows) Values() []types.Value { return r.row }
+
+// Err は読んでいる途中で起きたエラーを返します。
+func (r *Rows) Err() error { return r.err }
+
+// Close は結果を閉じます。何度呼んでもかまいません。
+func (r *Rows) Close() error {
+	if r.done {
+		return nil
+	}
+	r.done = true
+	err := r.op.Close()
+	if r.release != nil {
+		r.release()
+	}
+	if r.db != nil {
+		r.db.finishStatement(r.stmt, r.n, cmp.Or(r.err, err))
+	}
+	return err
+}
+
+// source はトランザクションからテーブルを読む exec.Source です。
+type source struct{ tx *Tx }
+
+func (s source) Table(name string) (*catalog.Table, error) { return s.tx.table(name) }
+
+func (s source) View(name string) (*catalog.View, bool) {
+	cat, err := s.tx.Catalog()
+	if err != nil {
+		return nil, false
+	}
+	return cat.View(name)
+}
+
+func (s source) Indexes(table string) []*catalog.Index {
+	cat, err := s.tx.Catalog()
+	if err != nil {
+		return nil
+	}
+	return cat.Indexes(table)
+}
+
+func (s source) ScanTable(t *catalog.Table, cols []bool) (exec.RowIter, error) {
+	return s.tx.cursor(t.Name, cols)
+}
+
+func (s source) ScanParts(t *catalog.Table, cols []bool, n int) ([]exec.RowIter, error) {
+	tcs, err := s.tx.cursors(t.Name, cols, n)
+	if err != nil {
+		return nil, err
+	}
+	its := make([]exec.RowIter, len(tcs))
+	for i, tc := range tcs {
+		its[i] = tc
+	}
+	return its, nil
+}
+
+func (s source) ScanIndex(ix *catalog.Index, r exec.IndexRange, cols []bool) (exec.RowIter, error) {
+	return s.tx.scanIndex(ix, r, cols)
+}
+
+func (s source) Settings() exec.Settings { return s.tx.db.exec }
+
+// TableCursor はテーブルの行を格納順に1つずつ返します。
+type TableCursor struct {
+	t    *catalog.Table
+	c    *storage.HeapCursor
+	cols []bool          // nil でなければ、読む列
+	rows [][]types.Value // 仮想テーブルの行
+	i    int
+}
+
+// Cursor はテーブルのすべての行を読むカーソルを返します。テーブルを共有ロックします。
+// 情報スキーマの仮想テーブルは、この時点の内容を返します。
+func (tx *Tx) Cursor(table string) (*TableCursor, error) { return tx.cursor(table, nil) }
+
+// cursor は Cursor と同じですが、cols が nil でなければ cols[i] が false の列の値を読まずに NULL にします。
+func (tx *Tx) cursor(table string, cols []bool) (*TableCursor, error) {
+	cs, err := tx.cursors(table, cols, 1)
+	if err != nil {
+		return nil, err
+	}
+	return cs[0], nil
+}
+
+// cursors はテーブルのページを分け合って読む、最大 n 個のカーソルを返します。各カーソルは
+// 別々のゴルーチンから使えます。情報スキーマの仮想テーブルでは1つだけ返します。
+func (tx *Tx) cursors(table string, cols []bool, n int) ([]*TableCursor, error) {
+	t, err := tx.table(table)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := VirtualTable(t.Name); ok {
+		tc := &TableCursor{t: t}
+		err := tx.scanVirtual(t.Name, func(_ storage.RID, row []types.Value) error {
+			tc.rows = append(tc.rows, row)
+			return nil
+		})
+		return []*TableCursor{tc}, err
+	}
+	if err := tx.lockTable(t.Name, lock.Shared); err != nil {
+		return nil, err
+	}
+	hcs, err := tx.heap(t).Cursors(n)
+	if err != nil {
+		return nil, err
+	}
+	tcs := make([]*TableCursor, len(hcs))
+	for i, c := range hcs {
+		c.SetColumns(cols) // 列形式のテーブルでは、読まない列の値をデコードしない
+		tcs[i] = &TableCursor{t: t, c: c, cols: cols}
+	}
+	return tcs, nil
+}
+
+// Next は次の行を返します。最後まで読むと ok が false になります。
+func (tc *TableCursor) Next() (rid storage.RID, row []types.Value, ok bool, err error) {
+	if tc.c == nil {
+		if tc.i >= len(tc.rows) {
+			return storage.RID{}, nil, false, nil
+		}
+		tc.i++
+		return storage.RID{Slot: tc.i - 1}, tc.rows[tc.i-1], true, nil
+	}
+	rid, rec, ok, err := tc.c.Next()
+	if err != nil || !ok {
+		return rid, nil, false, err
+	}
+	row, err = decodeColumns(tc.t, rid, rec, tc.cols)
+	return rid, row, err == nil, err
+}
+
+// NextBatch は最大 max 行を読み、i 番目の行の列 c の値を vecs[c][i] に書きます。
+// 読んだ行の数を返し、最後まで読むと 0 を返します。
+func (tc *TableCursor) NextBatch(vecs [][]types.Value, max int) (int, error) {
+	n := 0
+	for ; n < max; n++ {
+		if tc.c == nil {
+			if tc.i >= len(tc.rows) {
+				break
+			}
+			for c, v := range tc.rows[tc.i] {
+				vecs[c][n] = v
+			}
+			tc.i++
+			continue
+		}
+		rid, rec, ok, err := tc.c.Next()
+		if err != nil {
+			return n, err
+		}
+		if !ok {
+			break
+		}
+		m, err := tuple.DecodeInto(rec, vecs, n, tc.cols)
+		if err != nil {
+			return n, fmt.Errorf("%s row %s: %w", tc.t.Name, rid, err)
+		}
+		for c := m; c < len(tc.t.Columns); c++ {
+			vecs[c][n] = tc.t.Columns[c].Default
+		}
+	}
+	return n, nil
+}
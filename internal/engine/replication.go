@@ -0,0 +1,372 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/k-sml/go-rdbms/internal/storage"
+	"github.com/k-sml/go-rdbms/internal/txn"
+	"github.com/k-sml/go-rdbms/internal/wal"
+	"github.com/k-sml/go-rdbms/internal/wire"
+)
+
+// ストリーミングレプリケーション
+//
+// リーダー（JournalWAL で書き込めるデータベース）は Replicate でフォロワーにストリームを送る。
+// ストリームは internal/wire のフレームで、まずデータベースのスナップショットを BaseBackup と
+// BasePage で送り、その後はコミットのたびにWALのレコードを WALRecord で送る。スナップショットを
+// 取るのと、以降のコミットのレコードを受け取り始めるのは、コミットを反映しない間に同時に行うので
+// （txn.Manager.BeginWith と pager.Pager.Ship）、スナップショットに含まれるコミットも含まれない
+// コミットもちょうど1回ずつ届く。差分や論理レコードは直前のページに適用するため、この対応が
+// ずれるとページが壊れる。
+//
+// レコードはリーダーのコミットを止めないようにキューに入れて送る。フォロワーが遅れてキューが
+// あふれたら、そのストリームは ErrReplicaLagging で終わり、フォロワーは接続し直して
+// スナップショットから受け取り直す。
+//
+// フォロワー（Options.Follower で開いたデータベース）は Follow でストリームを読み、スナップショットの
+// ページとコミットごとのレコードを、実行中の読み取りのスナップショットと排他して
+// （txn.Manager.Replay）データベースファイルに適用する。フォロワーは読み取りだけを受け付け、
+// Promote で昇格すると通常のデータベースとして書き込めるようになる。フォロワーは適用した位置を
+// ファイルに保存しないので、接続するたびにリーダーからデータベース全体を受け取る。
+//
+// スナップショットを受け取っている間はデータベースファイルの名前に -base を付けた印のファイルを置き、
+// 最後まで受け取ったら消す。印が残っているファイルは古いページと新しいページが混ざっているので、
+// 開き直しても次にスナップショットを受け取り終えるまで昇格できない。
+
+// replicaQueue はリーダーがフォロワーごとに送らずに溜めておけるコミットの数です。
+const replicaQueue = 4096
+
+// ErrReplicaLagging はフォロワーが遅れて、リーダーが送るレコードを溜めきれなくなった場合に
+// Replicate が返します。
+var ErrReplicaLagging = errors.New("follower fell too far behind the leader")
+
+// ErrNotFollower はフォロワーとして開いていないデータベースで Follow や Promote を呼んだ場合に
+// 返されます。
+var ErrNotFollower = errors.New("database is not a follower")
+
+// follower はフォロワーの状態です。
+type follower struct {
+	mu         sync.Mutex
+	enabled    bool          // フォロワーとして開いていて、まだ昇格していない
+	cancel     func()        // 実行中の Follow を止める（nil なら Follow していない）
+	done       chan struct{} // 実行中の Follow が終わると閉じる
+	consistent bool          // スナップショットを最後まで受け取った
+	marker     string        // スナップショットを受け取っている間に置く印のファイル
+}
+
+// openFollower はデータベースファイル path をフォロワーとして使えるようにします。
+func (db *DB) openFollower(path string) error {
+	f := &db.follower
+	f.enabled, f.marker = true, path+"-base"
+	_, err := os.Stat(f.marker)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		f.consistent = true
+	case err != nil:
+		return err
+	default:
+		db.logger.Warn("follower has an incomplete base backup", "marker", f.marker)
+	}
+	return nil
+}
+
+// setConsistent はスナップショットを最後まで受け取ったかを記録します。
+func (f *follower) setConsistent(ok bool) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.consistent = ok
+	if ok {
+		return os.Remove(f.marker)
+	}
+	return os.WriteFile(f.marker, nil, 0666)
+}
+
+// Replicate はフォロワーへのストリームを w に書きます。ctx が取り消されるか、w への書き込みに
+// 失敗するか、フォロワーが遅れすぎる（ErrReplicaLagging）まで戻りません。複数のフォロワーに
+// 同時に送れます。JournalWAL で書き込めるデータベースでなければ pager.ErrNotLeader を返します。
+func (db *DB) Replicate(ctx context.Context, w io.Writer) error {
+	feed := make(chan []*wal.Record, replicaQueue)
+	lagging := make(chan struct{})
+	var once sync.Once
+	var lsn uint64
+	var stop func()
+	tx, err := db.txns.BeginWith(txn.Options{ReadOnly: true}, func() error {
+		var err error
+		lsn, stop, err = db.pager.Ship(func(recs []*wal.Record) {
+			select {
+			case feed <- recs:
+			default:
+				once.Do(func() { close(lagging) })
+			}
+		})
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	defer stop()
+
+	ww := wire.NewWriter(w)
+	start := time.Now()
+	n, err := sendBase(ctx, ww, tx, lsn)
+	tx.Rollback()
+	if err != nil {
+		return err
+	}
+	db.logger.Info("replication base sent", "pages", n, "lsn", lsn, "duration", time.Since(start))
+
+	var buf []byte
+	for {
+		select {
+		case <-ctx.Done():
+			return context.Cause(ctx)
+		case <-lagging:
+			return ErrReplicaLagging
+		case recs := <-feed:
+			for _, rec := range recs {
+				b := wire.AppendInt64(buf[:0], int64(rec.LSN))
+				b = append(b, byte(rec.Type))
+				b = wire.AppendInt64(b, int64(rec.TxID))
+				b = wire.AppendInt64(b, rec.PageID)
+				buf = append(b, rec.Payload...)
+				if err := ww.Write(wire.WALRecord, buf); err != nil {
+					return err
+				}
+			}
+			if len(feed) == 0 { // 溜まっているレコードはまとめて送る
+				if err := ww.Flush(); err != nil {
+					return err
+				}
+			}
+		}
+	}
+}
+
+// sendBase はトランザクション tx から見えるすべてのページを w に書き、書いたページの数を返します。
+func sendBase(ctx context.Context, w *wire.Writer, tx *txn.Tx, lsn uint64) (int64, error) {
+	h, err := storage.ReadHeader(tx)
+	if err != nil {
+		return 0, err
+	}
+	b := wire.AppendUint32(nil, uint32(tx.PageSize()))
+	b = wire.AppendInt64(b, h.NumPages)
+	b = wire.AppendInt64(b, int64(lsn))
+	if err := w.Write(wire.BaseBackup, b); err != nil {
+		return 0, err
+	}
+	for id := int64(0); id < h.NumPages; id++ {
+		if id%1024 == 0 {
+			if err := context.Cause(ctx); err != nil {
+				return id, err
+			}
+		}
+		buf, err := tx.ReadPage(id)
+		if err != nil {
+			return id, err
+		}
+		b = append(wire.AppendInt64(b[:0], id), buf...)
+		if err := w.Write(wire.BasePage, b); err != nil {
+			return id, err
+		}
+	}
+	return h.NumPages, w.Flush()
+}
+
+// Follow は r からリーダーのストリームを読み、データベースに適用し続けます。ctx が取り消されるか、
+// r からの読み込みに失敗するまで戻りません。r が io.Closer なら、ctx が取り消されたときに閉じて
+// 読み込みを止めます。Promote で止めた場合は nil を返します。同時に実行できる Follow は1つだけです。
+// フォロワーとして開いていなければ ErrNotFollower を返します。
+//
+// ストリームの最初にリーダーのデータベース全体を受け取り、それまでのデータベースの内容を置き換えます。
+// 受け取っている間は読み取りを待たせ、途中で切れると、次の Follow でまた全体を受け取るまで
+// データベースは使えない状態です。
+func (db *DB) Follow(ctx context.Context, r io.Reader) error {
+	f := &db.follower
+	f.mu.Lock()
+	switch {
+	case !f.enabled:
+		f.mu.Unlock()
+		return ErrNotFollower
+	case f.cancel != nil:
+		f.mu.Unlock()
+		return errors.New("database is already following a leader")
+	}
+	ctx, cancel := context.WithCancelCause(ctx)
+	done := make(chan struct{})
+	f.cancel, f.done = func() { cancel(errPromoted) }, done
+	f.mu.Unlock()
+	defer func() {
+		cancel(nil)
+		f.mu.Lock()
+		f.cancel, f.done = nil, nil
+		f.mu.Unlock()
+		close(done)
+	}()
+	if c, ok := r.(io.Closer); ok {
+		defer context.AfterFunc(ctx, func() { c.Close() })()
+	}
+
+	err := db.follow(wire.NewReader(r))
+	if ctx.Err() != nil {
+		err = context.Cause(ctx)
+	}
+	if err == errPromoted {
+		return nil
+	}
+	return err
+}
+
+// errPromoted は Promote で止めた Follow のコンテキストの原因です。
+var errPromoted = errors.New("follower promoted")
+
+// follow はストリーム rd を読み終えるまで適用します。
+func (db *DB) follow(rd *wire.Reader) error {
+	typ, body, err := rd.Read()
+	if err != nil {
+		return err
+	}
+	d := wire.NewBody(body)
+	switch typ {
+	case wire.BaseBackup:
+	case wire.Error: // リーダーが Replicate を断った
+		code, msg := d.String(), d.String()
+		return fmt.Errorf("leader refused replication: %s (%s)", msg, code)
+	default:
+		return fmt.Errorf("expected a base backup, got %q", typ)
+	}
+	pageSize := int(d.Uint32())
+	numPages := d.Int64()
+	next := uint64(d.Int64()) // 次に受け取るレコードのLSN
+	if err := d.Err(); err != nil {
+		return err
+	}
+	if pageSize != db.pager.PageSize() {
+		return fmt.Errorf("page size of the leader (%d) differs from the follower (%d)", pageSize, db.pager.PageSize())
+	}
+
+	start := time.Now()
+	if err := db.follower.setConsistent(false); err != nil {
+		return err
+	}
+	err = db.txns.Replay(func(save func(ids ...int64) error) error {
+		for range numPages {
+			typ, body, err := rd.Read()
+			if err != nil {
+				return err
+			}
+			d := wire.NewBody(body)
+			id := d.Int64()
+			buf := d.Rest()
+			if err := d.Err(); err != nil {
+				return err
+			}
+			if typ != wire.BasePage {
+				return fmt.Errorf("expected a base page, got %q", typ)
+			}
+			if err := save(id); err != nil {
+				return err
+			}
+			rec := &wal.Record{Type: wal.RecPageImage, PageID: id, Payload: buf}
+			if err := db.pager.Apply([]*wal.Record{rec}, next-1); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err == nil {
+		// 印を消す前に、受け取ったページをディスクに載せる
+		err = db.pager.Sync()
+	}
+	if err == nil {
+		err = db.follower.setConsistent(true)
+	}
+	if err != nil {
+		return err
+	}
+	db.logger.Info("replication base received", "pages", numPages, "lsn", next, "duration", time.Since(start))
+
+	var batch []*wal.Record // コミット待ちのレコード
+	for {
+		typ, body, err := rd.Read()
+		if err != nil {
+			return err
+		}
+		if typ != wire.WALRecord {
+			return fmt.Errorf("expected a wal record, got %q", typ)
+		}
+		d := wire.NewBody(body)
+		rec := &wal.Record{
+			LSN:    uint64(d.Int64()),
+			Type:   wal.RecordType(d.Byte()),
+			TxID:   uint64(d.Int64()),
+			PageID: d.Int64(),
+		}
+		rec.Payload = slices.Clone(d.Rest())
+		if err := d.Err(); err != nil {
+			return err
+		}
+		if rec.LSN != next {
+			return fmt.Errorf("replication stream skipped from lsn %d to %d", next, rec.LSN)
+		}
+		next++
+		if rec.Type != wal.RecCommit {
+			batch = append(batch, rec)
+			continue
+		}
+		// コミット単位でまとめて適用する（途中のページだけが見えることはない）
+		err = db.txns.Replay(func(save func(ids ...int64) error) error {
+			ids := make([]int64, 0, len(batch))
+			for _, r := range batch {
+				ids = append(ids, r.PageID)
+			}
+			slices.Sort(ids)
+			if err := save(slices.Compact(ids)...); err != nil {
+				return err
+			}
+			return db.pager.Apply(batch, rec.LSN)
+		})
+		if err != nil {
+			return err
+		}
+		batch = batch[:0]
+	}
+}
+
+// Promote はフォロワーを昇格して、書き込みを受け付けるようにします。実行中の Follow は止めて、
+// それまでに受け取ったコミットまでを反映したデータベースになります。フォロワーとして開いて
+// いなければ ErrNotFollower を、スナップショットを最後まで受け取っていなければエラーを返します。
+func (db *DB) Promote() error {
+	f := &db.follower
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !f.enabled {
+		return ErrNotFollower
+	}
+	for f.cancel != nil {
+		cancel, done := f.cancel, f.done
+		f.mu.Unlock()
+		cancel()
+		<-done
+		f.mu.Lock()
+	}
+	if !f.enabled {
+		return ErrNotFollower
+	}
+	if !f.consistent {
+		return errors.New("follower has not received a complete base backup")
+	}
+	if err := db.pager.Promote(); err != nil {
+		return err
+	}
+	f.enabled = false
+	db.txns.ReloadIDs()
+	db.logger.Info("promoted to leader")
+	return db.init()
+}
@@ -0,0 +1,182 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/k-sml/go-rdbms/internal/exec"
+	"github.com/k-sml/go-rdbms/internal/sql/ast"
+	"github.com/k-sml/go-rdbms/internal/sql/lexer"
+	"github.com/k-sml/go-rdbms/internal/txn"
+	"github.com/k-sml/go-rdbms/internal/types"
+)
+
+// スクリプト
+//
+// ExecScript は ; で区切って並べた SQL 文を順に実行し、文ごとの結果を返す。スキーマを定義する
+// ファイルのように、CREATE TABLE や INSERT を並べたものをそのまま実行できる。文の区切りは
+// lexer.Split で見つけるので、文字列やコメントの中の ; では区切らない。
+//
+// DB.ExecScript は BEGIN から COMMIT までの文を1つのトランザクションで実行し、それ以外の文は
+// 1つずつ別のトランザクションで実行してコミットする。ROLLBACK は BEGIN からの変更を取り消す。
+// Tx.ExecScript はすべての文をそのトランザクションの中で実行するので、BEGIN、COMMIT、ROLLBACK は使えない。
+//
+// 文がエラーになると、そこで実行をやめ、それまでの文の結果とエラーを返す。DB.ExecScript は
+// BEGIN で始めたトランザクションをロールバックする。エラーの位置はスクリプト全体の中の位置である。
+
+// Result はスクリプトの1つの文の結果です。
+type Result struct {
+	SQL          string          // 文の SQL
+	Columns      []string        // SELECT と EXPLAIN の結果の列の名前。ほかの文では nil
+	Rows         [][]types.Value // SELECT と EXPLAIN の結果の行
+	RowsAffected int64           // INSERT、UPDATE、DELETE で変更した行の数。VACUUM では取り除いたページの数
+}
+
+// ExecScript は SQL 文を並べたスクリプト src を実行し、文ごとの結果を返します。
+func (db *DB) ExecScript(src string) ([]Result, error) {
+	return db.execScript(src, 0)
+}
+
+// ExecScript は DB.ExecScript と同じですが、セッションのトランザクションで実行します。
+func (s *Session) ExecScript(src string) ([]Result, error) {
+	return s.db.execScript(src, s.sessionID())
+}
+
+// execScript はセッション session のトランザクションでスクリプト src を実行します。
+func (db *DB) execScript(src string, session int64) ([]Result, error) {
+	stmts, err := lexer.Split(src)
+	if err != nil {
+		return nil, err
+	}
+	var results []Result
+	var tx *Tx // BEGIN で始めたトランザクション
+	fail := func(st lexer.Statement, err error) ([]Result, error) {
+		if tx != nil {
+			tx.Rollback()
+		}
+		return results, scriptError(st, err)
+	}
+	for _, st := range stmts {
+		s, err := db.Prepare(st.SQL)
+		if err != nil {
+			return fail(st, err)
+		}
+		r := Result{SQL: st.SQL}
+		switch s.stmt.(type) {
+		case *ast.Begin:
+			if tx != nil {
+				return fail(st, errors.New("cannot start a transaction within a transaction"))
+			}
+			if tx, err = db.beginSession(context.Background(), txn.Options{}, session); err != nil {
+				return fail(st, err)
+			}
+		case *ast.Commit, *ast.Rollback:
+			if tx == nil {
+				return fail(st, errors.New("no transaction is active"))
+			}
+			end := tx.Commit
+			if _, ok := s.stmt.(*ast.Rollback); ok {
+				end = tx.Rollback
+			}
+			tx = nil
+			if err := end(); err != nil {
+				return results, scriptError(st, err)
+			}
+		default:
+			if tx != nil {
+				r, err = tx.execScriptStmt(s)
+			} else {
+				err = db.updateSession(context.Background(), txn.Options{}, session, func(tx *Tx) error {
+					var err error
+					r, err = tx.execScriptStmt(s)
+					return err
+				})
+			}
+			if err != nil {
+				return fail(st, err)
+			}
+		}
+		results = append(results, r)
+	}
+	if tx != nil {
+		tx.Rollback()
+		return results, errors.New("transaction started by BEGIN is not committed at the end of the script")
+	}
+	return results, nil
+}
+
+// ExecScript はトランザクションの中で SQL 文を並べたスクリプト src を実行し、文ごとの結果を返します。
+//
+// 文がエラーになった場合、その文の変更は取り消しますが、それまでの文の変更は残ります。
+// 続けるか、トランザクションをロールバックしてください。
+func (tx *Tx) ExecScript(src string) ([]Result, error) {
+	stmts, err := lexer.Split(src)
+	if err != nil {
+		return nil, err
+	}
+	var results []Result
+	for _, st := range stmts {
+		s, err := tx.db.Prepare(st.SQL)
+		if err != nil {
+			return results, scriptError(st, err)
+		}
+		r, err := tx.execScriptStmt(s)
+		if err != nil {
+			return results, scriptError(st, err)
+		}
+		results = append(results, r)
+	}
+	return results, nil
+}
+
+// execScriptStmt はスクリプトの文 s を実行します。SELECT と EXPLAIN の結果の行はすべて読みます。
+func (tx *Tx) execScriptStmt(s *Stmt) (Result, error) {
+	r := Result{SQL: s.sql}
+	if !isQuery(s.stmt) {
+		var err error
+		r.RowsAffected, err = tx.ExecStmt(s)
+		return r, err
+	}
+	rows, err := tx.QueryStmt(s)
+	if err != nil {
+		return r, err
+	}
+	defer rows.Close()
+	r.Columns = rows.Columns()
+	for rows.Next() {
+		r.Rows = append(r.Rows, slices.Clone(rows.Values()))
+	}
+	return r, rows.Err()
+}
+
+// StatementError は位置を持たないエラーに、スクリプトの中で文が始まる位置を添えたものです。
+type StatementError struct {
+	Pos lexer.Pos
+	Err error
+}
+
+func (e *StatementError) Error() string { return fmt.Sprintf("statement at %s: %v", e.Pos, e.Err) }
+
+func (e *StatementError) Unwrap() error { return e.Err }
+
+// ScriptError はソースの中の位置 pos から始まる文（か文の並び）のエラー err を、位置がソース全体の
+// 中の位置になるようにします。位置を持たないエラーには、pos を添えます（*StatementError）。
+// 文を1つずつ実行する呼び出し側が、エラーの位置を入力全体の中の位置にするのに使います。
+func ScriptError(pos lexer.Pos, err error) error {
+	switch e := err.(type) {
+	case *lexer.Error:
+		return &lexer.Error{Pos: e.Pos.Within(pos), Msg: e.Msg}
+	case *exec.BindError:
+		if e.Pos.Line > 0 {
+			return &exec.BindError{Pos: e.Pos.Within(pos), Err: e.Err}
+		}
+	case *StatementError:
+		return &StatementError{Pos: e.Pos.Within(pos), Err: e.Err}
+	}
+	return &StatementError{Pos: pos, Err: err}
+}
+
+// scriptError はスクリプトの文 st のエラー err を、位置がスクリプト全体の中の位置になるようにします。
+func scriptError(st lexer.Statement, err error) error { return ScriptError(st.Pos, err) }
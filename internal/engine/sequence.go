@@ -0,0 +1,152 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/k-sml/go-rdbms/internal/catalog"
+	"github.com/k-sml/go-rdbms/internal/lock"
+)
+
+// シーケンスの払い出し
+//
+// 値を払い出すたびにカタログを書き換えるとすべての挿入が同じページを更新することになるため、
+// seqCacheSize 個ずつまとめて予約する。予約ではカタログの high-water mark を予約の上限まで
+// 進め、予約した範囲はまずそのトランザクションの中だけで使う。コミットされたら残りを
+// DB 全体のキャッシュに移し、他のトランザクションも使えるようにする。ロールバックされた場合は
+// high-water mark も元に戻るので、範囲は捨てる。
+//
+// キャッシュにある値はすべてコミット済みの high-water mark 以下なので、クラッシュしても
+// 同じ値が二度払い出されることはない（使われなかった値が欠番になるだけ）。
+
+// seqCacheSize は一度に予約するシーケンスの値の数です。
+const seqCacheSize = 32
+
+// ErrSequenceExhausted はシーケンスの値を使い切った場合に返されます。
+var ErrSequenceExhausted = errors.New("sequence exhausted")
+
+// seqRange は予約済みで、まだ払い出していない値の範囲 [next, limit] です。
+type seqRange struct {
+	next, limit int64
+}
+
+func (r *seqRange) take() (int64, bool) {
+	if r == nil || r.next > r.limit {
+		return 0, false
+	}
+	v := r.next
+	r.next++
+	return v, true
+}
+
+// skip は v 以下の値を払い出さないように範囲を詰めます。
+func (r *seqRange) skip(v int64) {
+	if r != nil && r.next <= v {
+		r.next = v + 1
+	}
+}
+
+// NextVal はシーケンス name の次の値を払い出します。
+func (tx *Tx) NextVal(name string) (int64, error) {
+	k := strings.ToLower(name)
+	if v, ok := tx.seqs[k].take(); ok {
+		return v, nil
+	}
+	if v, ok := tx.db.takeSeq(k); ok {
+		return v, nil
+	}
+
+	// 書き込み権を取ってから最新の high-water mark を読み直す
+	if err := tx.tx.Lock(lock.Writer(), lock.Exclusive); err != nil {
+		return 0, err
+	}
+	tx.cat = nil
+	cat, err := tx.Catalog()
+	if err != nil {
+		return 0, err
+	}
+	s, ok := cat.Sequence(k)
+	if !ok {
+		return 0, fmt.Errorf("%w: %s", catalog.ErrSequenceNotFound, name)
+	}
+	if s.Value > math.MaxInt64-seqCacheSize {
+		return 0, fmt.Errorf("%w: %s", ErrSequenceExhausted, name)
+	}
+	r := &seqRange{next: s.Value + 1, limit: s.Value + seqCacheSize}
+	if err := cat.SetSequence(k, r.limit); err != nil {
+		return 0, err
+	}
+	if tx.seqs == nil {
+		tx.seqs = make(map[string]*seqRange)
+	}
+	tx.seqs[k] = r
+	v, _ := r.take()
+	return v, nil
+}
+
+// observeSeq は AUTOINCREMENT の列に明示的に指定された値 v を記録します。
+// v が high-water mark を超えていれば high-water mark を v まで進め、
+// それより小さい予約済みの値は以後使わないようにします。v が予約済みの範囲の中にあれば、
+// 範囲の v までの値を捨てます。
+func (tx *Tx) observeSeq(name string, v int64) error {
+	k := strings.ToLower(name)
+	cat, err := tx.Catalog()
+	if err != nil {
+		return err
+	}
+	s, ok := cat.Sequence(k)
+	if !ok {
+		return fmt.Errorf("%w: %s", catalog.ErrSequenceNotFound, name)
+	}
+	if v <= s.Value {
+		tx.seqs[k].skip(v)
+		tx.db.skipSeq(k, v)
+		return nil
+	}
+	if err := cat.SetSequence(k, v); err != nil {
+		return err
+	}
+	tx.resetSeq(k)
+	return nil
+}
+
+// resetSeq はシーケンスのこれまでの予約を捨て、コミット時に DB 全体のキャッシュからも消すよう記録します。
+func (tx *Tx) resetSeq(name string) {
+	k := strings.ToLower(name)
+	delete(tx.seqs, k)
+	if tx.resetSeqs == nil {
+		tx.resetSeqs = make(map[string]bool)
+	}
+	tx.resetSeqs[k] = true
+}
+
+// takeSeq は DB 全体のキャッシュから値を払い出します。
+func (db *DB) takeSeq(k string) (int64, bool) {
+	db.seqMu.Lock()
+	defer db.seqMu.Unlock()
+	return db.seqs[k].take()
+}
+
+// skipSeq は DB 全体のキャッシュから v 以下の値を捨てます。明示的に指定された値と同じ値を
+// 他のトランザクションに払い出さないよう、コミットを待たずに詰めます。
+func (db *DB) skipSeq(k string, v int64) {
+	db.seqMu.Lock()
+	defer db.seqMu.Unlock()
+	db.seqs[k].skip(v)
+}
+
+// mergeSeqs はコミットしたトランザクションのシーケンスの状態を DB 全体のキャッシュに反映します。
+func (db *DB) mergeSeqs(tx *Tx) {
+	db.seqMu.Lock()
+	defer db.seqMu.Unlock()
+	for k := range tx.resetSeqs {
+		delete(db.seqs, k)
+	}
+	for k, r := range tx.seqs {
+		if r.next <= r.limit {
+			db.seqs[k] = r
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package engine
+
+import (
+	"slices"
+	"testing"
+)
+
+// TestAutoincrementAfterExplicitValue は、AUTOINCREMENT の列に予約済みの範囲の中の値を明示的に
+// 入れた後も、自動で払い出す値がその値と重ならないことを確かめます。範囲が DB 全体のキャッシュに
+// ある場合と、トランザクションの中にある場合の両方を確かめます。
+func TestAutoincrementAfterExplicitValue(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		begin, end string
+	}{
+		{"autocommit", "", ""},
+		{"transaction", "BEGIN;", "COMMIT;"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			db := openMemory(t)
+			script(t, db, "CREATE TABLE a (id INT PRIMARY KEY AUTOINCREMENT, v TEXT)")
+			script(t, db, tc.begin+`
+				INSERT INTO a (v) VALUES ('auto');
+				INSERT INTO a VALUES (3, 'explicit');
+				INSERT INTO a (v) VALUES ('auto');
+				INSERT INTO a (v) VALUES ('auto');
+				INSERT INTO a (v) VALUES ('auto');
+			`+tc.end)
+			var ids []string
+			for _, row := range script(t, db, "SELECT id FROM a ORDER BY id") {
+				ids = append(ids, row[0])
+			}
+			if want := []string{"1", "3", "4", "5", "6"}; !slices.Equal(ids, want) {
+				t.Errorf("ids = %v, want %v", ids, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,123 @@
+package engine
+
+import (
+	"context"
+	"sync"
+
+	"github.com/k-sml/go-rdbms/internal/catalog"
+	"github.com/k-sml/go-rdbms/internal/txn"
+)
+
+// セッション
+//
+// Session はセッションの一時テーブル（CREATE TEMP TABLE）を持つ。セッションで始めたトランザクションの
+// カタログには、そのセッションの一時テーブルが見える（catalog/temp.go）。セッションの番号は DB を
+// 開いてから数えるので、閉じずに終わったセッション（プロセスが止まった場合など）の一時テーブルは、
+// 次に書き込みのできる DB として開いたときに削除する。
+//
+// 一時テーブルのあるセッションで作った実行計画は、ほかのセッションで同じ名前が別のテーブルを
+// 指すので、取っておかない（stmt.go）。フォロワーでは一時テーブルを作れないので、フォロワーとして
+// 開いている間のセッションは一時テーブルを持たない。
+
+// Session は一時テーブルを持つセッションです。1つのセッションは1つのゴルーチンから使い、
+// 使い終えたら Close で一時テーブルを削除します。
+type Session struct {
+	db *DB
+	id int64
+
+	mu     sync.Mutex
+	closed bool
+}
+
+// NewSession は新しいセッションを返します。
+func (db *DB) NewSession() *Session {
+	return &Session{db: db, id: db.sessions.Add(1)}
+}
+
+// BeginTx は ctx と opts を使う、セッションのトランザクションを開始します（DB.BeginTx）。
+func (s *Session) BeginTx(ctx context.Context, opts txn.Options) (*Tx, error) {
+	return s.db.beginSession(ctx, opts, s.sessionID())
+}
+
+// ExecOptions は DB.ExecOptions と同じですが、セッションのトランザクションで実行します。
+func (s *Session) ExecOptions(ctx context.Context, opts txn.Options, sql string, args ...any) (int64, error) {
+	var n int64
+	err := s.db.updateSession(ctx, opts, s.sessionID(), func(tx *Tx) error {
+		var err error
+		n, err = tx.ExecContext(ctx, sql, args...)
+		return err
+	})
+	return n, err
+}
+
+// sessionID はトランザクションのカタログに使うセッションの番号を返します。フォロワーなら 0 です。
+func (s *Session) sessionID() int64 {
+	f := &s.db.follower
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.enabled {
+		return 0
+	}
+	return s.id
+}
+
+// Close はセッションの一時テーブルを削除します。何度呼んでもかまいません。
+func (s *Session) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	if s.sessionID() == 0 {
+		return nil
+	}
+	return s.db.dropTemps(s.id)
+}
+
+// dropTemps はセッション session の一時テーブルをすべて削除します。
+func (db *DB) dropTemps(session int64) error {
+	return db.updateSession(context.Background(), txn.Options{}, session, func(tx *Tx) error {
+		cat, err := tx.Catalog()
+		if err != nil {
+			return err
+		}
+		if !cat.HasTemp() {
+			return nil
+		}
+		for _, t := range cat.Tables() {
+			if t.Temp {
+				if err := tx.DropTable(t.Name); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// dropOrphans は閉じずに終わったセッションの一時テーブルを削除します。cat はセッションの外から
+// 読んだカタログです。書き込めないなら削除せず、新しいセッションの番号が残った一時テーブルと
+// 同じにならないようにだけします。
+func (db *DB) dropOrphans(cat *catalog.Catalog) error {
+	sessions := cat.TempSessions()
+	if len(sessions) == 0 {
+		return nil
+	}
+	for last := sessions[len(sessions)-1]; ; {
+		n := db.sessions.Load()
+		if n >= last || db.sessions.CompareAndSwap(n, last) {
+			break
+		}
+	}
+	if db.pager.ReadOnly() {
+		return nil
+	}
+	for _, id := range sessions {
+		if err := db.dropTemps(id); err != nil {
+			return err
+		}
+	}
+	db.logger.Info("dropped temporary tables of closed sessions", "sessions", len(sessions))
+	return nil
+}
@@ -0,0 +1,73 @@
+package engine
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/k-sml/go-rdbms/internal/catalog"
+)
+
+// sessionScript は src をセッション s で実行し、最後の文の結果の行を値の文字列にして返します。
+func sessionScript(t *testing.T, s *Session, src string) [][]string {
+	t.Helper()
+	results, err := s.ExecScript(src)
+	if err != nil {
+		t.Fatalf("%s: %v", src, err)
+	}
+	var rows [][]string
+	for _, row := range results[len(results)-1].Rows {
+		var r []string
+		for _, v := range row {
+			r = append(r, v.String())
+		}
+		rows = append(rows, r)
+	}
+	return rows
+}
+
+// TestSessionTempTables は、一時テーブルが作ったセッションにだけ見え、2つのセッションが同じ名前の
+// 一時テーブルを持て、Close で削除されることを確かめます。セッションの外では一時テーブルを作れず、
+// ほかのセッションの一時テーブルの名前は永続するテーブルに使えません。
+func TestSessionTempTables(t *testing.T) {
+	db := openMemory(t)
+	a, b := db.NewSession(), db.NewSession()
+	defer b.Close()
+	sessionScript(t, a, "CREATE TEMP TABLE tmp (id INT PRIMARY KEY, v TEXT); INSERT INTO tmp VALUES (1, 'a')")
+	sessionScript(t, b, "CREATE TEMPORARY TABLE tmp (id INT PRIMARY KEY, v TEXT); INSERT INTO tmp VALUES (1, 'b')")
+
+	if got := sessionScript(t, a, "SELECT v FROM tmp"); len(got) != 1 || got[0][0] != "a" {
+		t.Errorf("session a: SELECT v FROM tmp = %v, want [[a]]", got)
+	}
+	if got := sessionScript(t, b, "SELECT v FROM tmp"); len(got) != 1 || got[0][0] != "b" {
+		t.Errorf("session b: SELECT v FROM tmp = %v, want [[b]]", got)
+	}
+	if _, err := db.ExecScript("SELECT v FROM tmp"); !errors.Is(err, catalog.ErrTableNotFound) {
+		t.Errorf("SELECT from a temporary table outside its session: err = %v, want %v", err, catalog.ErrTableNotFound)
+	}
+	if _, err := db.ExecScript("CREATE TABLE tmp (id INT)"); !errors.Is(err, catalog.ErrTableExists) {
+		t.Errorf("CREATE TABLE with the name of a temporary table: err = %v, want %v", err, catalog.ErrTableExists)
+	}
+	if _, err := db.ExecScript("CREATE TEMP TABLE x (id INT)"); !errors.Is(err, catalog.ErrNoSession) {
+		t.Errorf("CREATE TEMP TABLE outside a session: err = %v, want %v", err, catalog.ErrNoSession)
+	}
+	if _, err := a.ExecScript("CREATE VIEW tv AS SELECT v FROM tmp"); err == nil || !strings.Contains(err.Error(), "temporary table") {
+		t.Errorf("CREATE VIEW over a temporary table: err = %v, want an error", err)
+	}
+
+	if err := a.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Close(); err != nil {
+		t.Errorf("second Close: %v", err)
+	}
+	if got := sessionScript(t, b, "SELECT v FROM tmp"); len(got) != 1 || got[0][0] != "b" {
+		t.Errorf("session b after a.Close: SELECT v FROM tmp = %v, want [[b]]", got)
+	}
+	c := db.NewSession()
+	defer c.Close()
+	if _, err := c.ExecScript("SELECT v FROM tmp"); !errors.Is(err, catalog.ErrTableNotFound) {
+		t.Errorf("SELECT from a closed session's temporary table: err = %v, want %v", err, catalog.ErrTableNotFound)
+	}
+	sessionScript(t, c, "CREATE TEMP TABLE tmp (id INT PRIMARY KEY)")
+}
@@ -0,0 +1,383 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/k-sml/go-rdbms/internal/catalog"
+	"github.com/k-sml/go-rdbms/internal/exec"
+	"github.com/k-sml/go-rdbms/internal/sql/ast"
+	"github.com/k-sml/go-rdbms/internal/sql/parser"
+	"github.com/k-sml/go-rdbms/internal/txn"
+	"github.com/k-sml/go-rdbms/internal/types"
+)
+
+// Exec は新しいトランザクションで SQL 文を実行し、エラーがなければコミットします。
+// args は文の中の引数（? と $1 など）の値です。
+func (db *DB) Exec(sql string, args ...any) (int64, error) {
+	return db.ExecContext(context.Background(), sql, args...)
+}
+
+// ExecContext は ctx を使う新しいトランザクションで SQL 文を実行し、エラーがなければコミットします。
+func (db *DB) ExecContext(ctx context.Context, sql string, args ...any) (int64, error) {
+	return db.ExecOptions(ctx, txn.Options{}, sql, args...)
+}
+
+// ExecOptions は ExecContext と同じですが、opts の設定でトランザクションを開始します。
+func (db *DB) ExecOptions(ctx context.Context, opts txn.Options, sql string, args ...any) (int64, error) {
+	var n int64
+	err := db.updateContext(ctx, opts, func(tx *Tx) error {
+		var err error
+		n, err = tx.ExecContext(ctx, sql, args...)
+		return err
+	})
+	return n, err
+}
+
+// Exec はトランザクションの中で結果の行を返さない SQL 文を実行し、変更した行の数を返します。
+// args は文の中の引数（? と $1 など）の値です。同じ SQL 文の実行計画は使い回します。
+//
+// 文がエラーになった場合は、その文の変更だけを取り消します（ExecStmt）。トランザクションは
+// そのまま続けられます。
+func (tx *Tx) Exec(sql string, args ...any) (int64, error) {
+	return tx.ExecContext(context.Background(), sql, args...)
+}
+
+// ExecContext は Exec と同じですが、ctx が取り消されるとロックの待機や次のページの読み取りで
+// 実行を止め、ctx のエラーを返します。
+func (tx *Tx) ExecContext(ctx context.Context, sql string, args ...any) (int64, error) {
+	defer tx.tx.SetContext(ctx)()
+	s, err := tx.db.Prepare(sql)
+	if err != nil {
+		return 0, err
+	}
+	return tx.ExecStmt(s, args...)
+}
+
+// planStmt は文の実行計画を作ります。名前や型の誤りは、実行計画を作る前に exec.Bind で見つけます。
+func (tx *Tx) planStmt(stmt ast.Stmt, nparams int) (*plan, error) {
+	cat, err := tx.Catalog()
+	if err != nil {
+		return nil, err
+	}
+	pl := &plan{src: &source{tx}, params: exec.NewParams(nparams), version: cat.Version(), funcs: tx.db.funcs.Version()}
+	if err := exec.Bind(pl.src, stmt); err != nil {
+		return nil, err
+	}
+	switch s := stmt.(type) {
+	case *ast.Select:
+		pl.query, err = exec.Plan(pl.src, s, pl.params)
+	case *ast.Explain:
+		sel, ok := s.Stmt.(*ast.Select)
+		if !ok {
+			return nil, errors.New("EXPLAIN supports only SELECT statements")
+		}
+		pl.query, err = exec.PlanExplain(pl.src, sel, s.Analyze, pl.params)
+	case *ast.Pragma:
+		pl.query, err = tx.planPragma(s, pl.src, pl.params)
+	case *ast.Vacuum:
+		// 変更した行の数の代わりに、ファイルから取り除いたページの数を返す
+		pl.run = func(tx *Tx) (int64, error) {
+			st, err := tx.Vacuum()
+			if err != nil {
+				return 0, err
+			}
+			return st.PagesBefore - st.PagesAfter, nil
+		}
+	case *ast.Analyze:
+		pl.run = func(tx *Tx) (int64, error) { return 0, tx.Analyze(s.Table) }
+	case *ast.Insert:
+		pl.run, err = tx.planInsert(s, pl.src, pl.params)
+	case *ast.Update:
+		pl.run, err = tx.planUpdate(s, pl.src, pl.params)
+	case *ast.Delete:
+		pl.run, err = tx.planDelete(s, pl.src, pl.params)
+	case *ast.CreateTable, *ast.DropTable, *ast.CreateIndex, *ast.DropIndex, *ast.CreateView, *ast.DropView,
+		*ast.AlterTable, *ast.AlterIndex:
+		pl.run, err = tx.planDDL(s, pl.src)
+	case *ast.CreateUser, *ast.AlterUser, *ast.DropUser, *ast.Grant, *ast.Revoke:
+		run := planUser(s)
+		pl.run = func(tx *Tx) (int64, error) { return 0, run(tx) }
+	case *ast.Begin, *ast.Commit, *ast.Rollback:
+		return nil, errors.New("BEGIN, COMMIT and ROLLBACK cannot be used inside a transaction")
+	default:
+		return nil, errors.New("unsupported statement")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return pl, nil
+}
+
+// pragmas は PRAGMA 文で読める情報の名前です。PRAGMA name は SELECT * FROM __schema.name と
+// 同じ行を返します。
+var pragmas = map[string]bool{"integrity_check": true}
+
+// planPragma は PRAGMA 文の実行計画を作ります。
+func (tx *Tx) planPragma(s *ast.Pragma, src exec.Source, params *exec.Params) (exec.Operator, error) {
+	name := strings.ToLower(s.Name)
+	if !pragmas[name] {
+		return nil, fmt.Errorf("unknown pragma: %s", s.Name)
+	}
+	stmt, err := parser.Parse("SELECT * FROM " + SchemaPrefix + name)
+	if err != nil {
+		return nil, err
+	}
+	if err := exec.Bind(src, stmt); err != nil {
+		return nil, err
+	}
+	return exec.Plan(src, stmt.(*ast.Select), params)
+}
+
+// insertBatch は INSERT ... SELECT で、問い合わせの結果の行をまとめて挿入する行の数です。
+const insertBatch = 256
+
+// planInsert は INSERT 文の実行計画を作ります。実行するときは、すべての行の値を計算して
+// 列の型に変換できることを確かめてから書き込みます。
+func (tx *Tx) planInsert(s *ast.Insert, src exec.Source, params *exec.Params) (func(*Tx) (int64, error), error) {
+	t, err := tx.writable(s.Table)
+	if err != nil {
+		return nil, err
+	}
+	pos, err := insertColumns(t, s.Columns)
+	if err != nil {
+		return nil, err
+	}
+	if s.Query != nil {
+		return tx.planInsertSelect(t, pos, s.Query, src, params)
+	}
+	exprs := make([][]exec.Expr, len(s.Rows))
+	for r, row := range s.Rows {
+		if len(row) != len(pos) {
+			return nil, fmt.Errorf("%d values for %d columns", len(row), len(pos))
+		}
+		exprs[r] = make([]exec.Expr, len(row))
+		for i, e := range row {
+			if exprs[r][i], err = exec.CompileQuery(src, e, nil, params); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return func(tx *Tx) (int64, error) {
+		t, err := tx.writable(t.Name)
+		if err != nil {
+			return 0, err
+		}
+		rows := make([][]types.Value, len(exprs))
+		vals := make([]types.Value, len(pos))
+		for r, fs := range exprs {
+			for i, f := range fs {
+				if vals[i], err = f(nil); err != nil {
+					return 0, err
+				}
+			}
+			if rows[r], err = insertRow(t, pos, vals); err != nil {
+				return 0, err
+			}
+		}
+		for _, row := range rows {
+			if _, err := tx.Insert(t.Name, row); err != nil {
+				return 0, err
+			}
+		}
+		return int64(len(rows)), nil
+	}, nil
+}
+
+// planInsertSelect は INSERT ... SELECT 文の実行計画を作ります。実行するときは、問い合わせの
+// 結果の行を insertBatch 行ずつ読み、列の型に変換できることを確かめてから挿入します。
+// 問い合わせが挿入先のテーブルを読むなら、挿入した行を読まないように、結果の行を
+// すべて読み終えてから挿入します。
+func (tx *Tx) planInsertSelect(t *catalog.Table, pos []int, q *ast.Select, src exec.Source, params *exec.Params) (func(*Tx) (int64, error), error) {
+	op, err := exec.Plan(src, q, params)
+	if err != nil {
+		return nil, err
+	}
+	if n := len(op.Columns()); n != len(pos) {
+		return nil, fmt.Errorf("%d values for %d columns", n, len(pos))
+	}
+	batch := insertBatch
+	if exec.Reads(src, q, t.Name) {
+		batch = 0
+	}
+	return func(tx *Tx) (int64, error) {
+		t, err := tx.writable(t.Name)
+		if err != nil {
+			return 0, err
+		}
+		var n int64
+		var rows [][]types.Value
+		flush := func() error {
+			for _, row := range rows {
+				if _, err := tx.Insert(t.Name, row); err != nil {
+					return err
+				}
+				n++
+			}
+			rows = rows[:0]
+			return nil
+		}
+		if err := op.Open(); err != nil {
+			op.Close()
+			return 0, err
+		}
+		for {
+			vals, ok, err := op.Next()
+			if err == nil && !ok {
+				break
+			}
+			var row []types.Value
+			if err == nil {
+				row, err = insertRow(t, pos, vals)
+			}
+			if err != nil {
+				op.Close()
+				return n, err
+			}
+			if rows = append(rows, row); len(rows) == batch {
+				if err := flush(); err != nil {
+					op.Close()
+					return n, err
+				}
+			}
+		}
+		if err := op.Close(); err != nil {
+			return n, err
+		}
+		return n, flush()
+	}, nil
+}
+
+// insertRow は INSERT の列 pos に書いた値 vals から、テーブル t の行を作ります。
+// 書かなかった列は既定値にします。値を列の型に変換できなければエラーです。
+func insertRow(t *catalog.Table, pos []int, vals []types.Value) ([]types.Value, error) {
+	row := make([]types.Value, len(t.Columns))
+	for i, col := range t.Columns {
+		row[i] = col.Default
+	}
+	for i, v := range vals {
+		col := t.Columns[pos[i]]
+		if _, err := types.Coerce(v, col.Type); err != nil {
+			return nil, fmt.Errorf("column %s: %w", col.Name, err)
+		}
+		row[pos[i]] = v
+	}
+	return row, nil
+}
+
+// planDelete は DELETE 文の実行計画を作ります。外部キーの ON DELETE CASCADE で先に消えた行は
+// 数えません。
+func (tx *Tx) planDelete(s *ast.Delete, src exec.Source, params *exec.Params) (func(*Tx) (int64, error), error) {
+	t, err := tx.writable(s.Table)
+	if err != nil {
+		return nil, err
+	}
+	targets, err := exec.PlanTargets(src, t, s.Where, params)
+	if err != nil {
+		return nil, err
+	}
+	return func(tx *Tx) (int64, error) {
+		if _, err := tx.writable(t.Name); err != nil {
+			return 0, err
+		}
+		tgs, err := targets.Collect()
+		if err != nil {
+			return 0, err
+		}
+		var n int64
+		for _, tg := range tgs {
+			err := tx.Delete(t.Name, tg.RID)
+			if errors.Is(err, ErrRowNotFound) {
+				continue
+			}
+			if err != nil {
+				return n, err
+			}
+			n++
+		}
+		return n, nil
+	}, nil
+}
+
+// insertColumns は INSERT の列の並び names がテーブルの何番目の列かを返します。
+// names が空ならテーブルのすべての列です。
+func insertColumns(t *catalog.Table, names []string) ([]int, error) {
+	if len(names) == 0 {
+		pos := make([]int, len(t.Columns))
+		for i := range pos {
+			pos[i] = i
+		}
+		return pos, nil
+	}
+	return setColumns(t, names)
+}
+
+// setColumns は列の名前 names がテーブルの何番目の列かを返します。
+// 存在しない列や、同じ列を2回書いた場合はエラーです。
+func setColumns(t *catalog.Table, names []string) ([]int, error) {
+	pos := make([]int, len(names))
+	seen := make(map[int]bool)
+	for i, name := range names {
+		j, ok := t.Column(name)
+		if !ok {
+			return nil, fmt.Errorf("table %s has no column named %s", t.Name, name)
+		}
+		if seen[j] {
+			return nil, fmt.Errorf("column %s is specified more than once", strings.ToLower(name))
+		}
+		seen[j] = true
+		pos[i] = j
+	}
+	return pos, nil
+}
+
+// planUpdate は UPDATE 文の実行計画を作ります。SET の式は変更前の行の値で計算します。
+func (tx *Tx) planUpdate(s *ast.Update, src exec.Source, params *exec.Params) (func(*Tx) (int64, error), error) {
+	t, err := tx.writable(s.Table)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, len(s.Set))
+	for i, a := range s.Set {
+		names[i] = a.Column
+	}
+	pos, err := setColumns(t, names)
+	if err != nil {
+		return nil, err
+	}
+	cols := exec.TableColumns(t, "")
+	exprs := make([]exec.Expr, len(s.Set))
+	for i, a := range s.Set {
+		if exprs[i], err = exec.CompileQuery(src, a.Value, cols, params); err != nil {
+			return nil, err
+		}
+	}
+	targets, err := exec.PlanTargets(src, t, s.Where, params)
+	if err != nil {
+		return nil, err
+	}
+	return func(tx *Tx) (int64, error) {
+		if _, err := tx.writable(t.Name); err != nil {
+			return 0, err
+		}
+		tgs, err := targets.Collect()
+		if err != nil {
+			return 0, err
+		}
+		row := make([]types.Value, len(t.Columns))
+		for _, tg := range tgs {
+			copy(row, tg.Row)
+			for i, e := range exprs {
+				if row[pos[i]], err = e(tg.Row); err != nil {
+					return 0, err
+				}
+			}
+			if _, err := tx.Update(t.Name, tg.RID, row); err != nil {
+				return 0, err
+			}
+		}
+		return int64(len(tgs)), nil
+	}, nil
+}
@@ -0,0 +1,248 @@
+package engine
+
+import (
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"slices"
+
+	"github.com/k-sml/go-rdbms/internal/catalog"
+	"github.com/k-sml/go-rdbms/internal/lock"
+	"github.com/k-sml/go-rdbms/internal/tuple"
+	"github.com/k-sml/go-rdbms/internal/types"
+)
+
+// 統計情報
+//
+// 実行計画を選ぶ exec は、テーブルの行の数と列の値の分布を Source.Stats で尋ねる。ANALYZE で
+// 集めた統計情報があれば、そのときの1ページあたりの行の数に今のデータページの数を掛けて行の数とし、
+// 列の統計情報はそのまま使う。なければ、データページの数に列の型から見積もった1ページあたりの
+// 行の数を掛けて求める。
+//
+// ANALYZE はテーブルをすべて読んで行を数え、そのうち最大 sampleRows 行を無作為に選んで
+// （reservoir sampling）、列ごとに NULL の割合、値の種類の数、ヒストグラムを求める。
+// 標本がテーブルの一部なら、値の種類の数は標本に1回だけ現れた値の数から推定する
+// （Haas と Stokes の推定量）。ヒストグラムは標本の値を並べて同じ数ずつに分ける境界の値で、
+// カタログの1行に収まるよう、境界が長すぎれば区間の数を減らす。相関係数は、標本の行を値の順に
+// 並べた順位と、ファイルの中の順に並べた順位との順位相関（Spearman）である。
+
+// pageOverhead と rowOverhead はヒープのページと行にかかるおおよその余分なバイト数です。
+const (
+	pageOverhead = 8 // ページのヘッダ
+	rowOverhead  = 6 // スロットと値の数
+)
+
+const (
+	sampleRows       = 30000 // ANALYZE が列の統計情報を求めるのに使う行の数
+	histogramBuckets = 100   // ヒストグラムの区間の数
+)
+
+func (s source) Stats(t *catalog.Table) *catalog.TableStats {
+	if t.System {
+		return nil
+	}
+	if err := s.tx.lockTable(t.Name, lock.Shared); err != nil {
+		return nil
+	}
+	pages, err := s.tx.heap(t).Pages()
+	if err != nil {
+		return nil
+	}
+	n := int64(len(pages))
+	cat, err := s.tx.Catalog()
+	if err != nil {
+		return nil
+	}
+	if st := cat.Stats(t); st != nil {
+		rows := st.Rows
+		if st.Pages > 0 {
+			rows = int64(math.Round(float64(st.Rows) / float64(st.Pages) * float64(n)))
+		}
+		return &catalog.TableStats{Rows: rows, Pages: n, Columns: st.Columns}
+	}
+	perPage := (s.tx.tx.PageSize() - pageOverhead) / rowWidth(t)
+	return &catalog.TableStats{Rows: n * int64(max(perPage, 1)), Pages: n}
+}
+
+// rowWidth はテーブルの1行を保存するのにかかるおおよそのバイト数です。
+func rowWidth(t *catalog.Table) int {
+	w := rowOverhead
+	for _, c := range t.Columns {
+		switch c.Type {
+		case types.Boolean:
+			w += 2
+		case types.Int:
+			w += 5
+		case types.Text, types.Blob:
+			w += 24 // 長さがわからないので、短い文字列を仮定する
+		default:
+			w += 9
+		}
+	}
+	return w
+}
+
+// Analyze は新しいトランザクションでテーブル table の統計情報を集め、エラーがなければコミットします。
+// table が空なら、すべてのテーブルの統計情報を集めます。
+func (db *DB) Analyze(table string) error {
+	return db.update(func(tx *Tx) error { return tx.Analyze(table) })
+}
+
+// Analyze はトランザクションの中でテーブル table の統計情報を集め、カタログに保存します。
+// table が空なら、すべてのテーブルの統計情報を集めます。
+func (tx *Tx) Analyze(table string) error {
+	if err := tx.tx.Lock(lock.Writer(), lock.Exclusive); err != nil {
+		return err
+	}
+	tx.cat = nil
+	cat, err := tx.Catalog()
+	if err != nil {
+		return err
+	}
+	tables := cat.Tables()
+	if table != "" {
+		t, err := tx.table(table)
+		if err != nil {
+			return err
+		}
+		if t.System {
+			return fmt.Errorf("cannot analyze system table %s", t.Name)
+		}
+		tables = []*catalog.Table{t}
+	}
+	for _, t := range tables {
+		s, err := tx.collectStats(t)
+		if err != nil {
+			return err
+		}
+		if err := cat.SetStats(t, s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// collectStats はテーブル t をすべて読んで統計情報を求めます。
+func (tx *Tx) collectStats(t *catalog.Table) (*catalog.TableStats, error) {
+	c, err := tx.Cursor(t.Name)
+	if err != nil {
+		return nil, err
+	}
+	pages, err := tx.heap(t).Pages()
+	if err != nil {
+		return nil, err
+	}
+	rng := rand.New(rand.NewPCG(uint64(t.ID), uint64(len(pages))))
+	var sample [][]types.Value
+	var order []int64 // 標本の行がファイルの中で何番目の行か
+	var n int64
+	for {
+		_, row, ok, err := c.Next()
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			break
+		}
+		n++
+		if len(sample) < sampleRows {
+			sample, order = append(sample, row), append(order, n)
+		} else if j := rng.Int64N(n); j < sampleRows {
+			sample[j], order[j] = row, n
+		}
+	}
+	s := &catalog.TableStats{Rows: n, Pages: int64(len(pages)), Columns: make([]catalog.ColumnStats, len(t.Columns))}
+	maxBytes := tx.tx.PageSize() / 4
+	for i := range t.Columns {
+		s.Columns[i] = columnStats(sample, order, i, n, maxBytes)
+	}
+	return s, nil
+}
+
+// columnStats は標本 sample の i 番目の列の統計情報を求めます。order は標本の行のファイルの中の順、
+// total はテーブルの行の数、maxBytes はヒストグラムの境界の値をエンコードしたときの大きさの上限です。
+func columnStats(sample [][]types.Value, order []int64, i int, total int64, maxBytes int) catalog.ColumnStats {
+	var cs catalog.ColumnStats
+	var vals []types.Value
+	var pos []int64
+	for j, row := range sample {
+		if !row[i].IsNull() {
+			vals, pos = append(vals, row[i]), append(pos, order[j])
+		}
+	}
+	if len(sample) == 0 {
+		return cs
+	}
+	cs.NullFrac = float64(len(sample)-len(vals)) / float64(len(sample))
+	if len(vals) == 0 {
+		return cs
+	}
+	cmp := func(a, b types.Value) int {
+		c, _ := types.Compare(a, b)
+		return c
+	}
+	cs.Correlation = correlation(vals, pos, cmp)
+	slices.SortFunc(vals, cmp)
+
+	// 値の種類の数 d と、標本に1回だけ現れた値の数 f1
+	d, f1 := 0, 0
+	for j := 0; j < len(vals); {
+		k := j + 1
+		for k < len(vals) && cmp(vals[j], vals[k]) == 0 {
+			k++
+		}
+		d++
+		if k-j == 1 {
+			f1++
+		}
+		j = k
+	}
+	sn, nn := float64(len(vals)), float64(total)*(1-cs.NullFrac)
+	cs.Distinct = float64(d)
+	if sn < nn {
+		est := sn * float64(d) / (sn - float64(f1) + float64(f1)*sn/nn)
+		cs.Distinct = math.Min(math.Max(est, float64(d)), nn)
+	}
+
+	for b := min(histogramBuckets, len(vals)-1); b >= 1; b /= 2 {
+		h := make([]types.Value, b+1)
+		for j := range h {
+			h[j] = vals[j*(len(vals)-1)/b]
+		}
+		if len(tuple.Encode(h)) <= maxBytes {
+			cs.Histogram = h
+			break
+		}
+	}
+	return cs
+}
+
+// correlation は値 vals の順位と、それぞれの値の行のファイルの中の位置 pos の順位との順位相関係数を
+// 求めます。同じ値はファイルの中の順に並べます。
+func correlation(vals []types.Value, pos []int64, cmp func(a, b types.Value) int) float64 {
+	n := len(vals)
+	if n < 2 {
+		return 1
+	}
+	byValue := make([]int, n)
+	for j := range byValue {
+		byValue[j] = j
+	}
+	slices.SortFunc(byValue, func(a, b int) int {
+		if c := cmp(vals[a], vals[b]); c != 0 {
+			return c
+		}
+		return int(pos[a] - pos[b])
+	})
+	sorted := slices.Clone(pos)
+	slices.Sort(sorted)
+	// どちらの順位も 0 から n-1 までを1回ずつ使うので、順位の差の2乗の和から求められる
+	var d2 float64
+	for rank, j := range byValue {
+		phys, _ := slices.BinarySearch(sorted, pos[j])
+		d := float64(rank - phys)
+		d2 += d * d
+	}
+	nf := float64(n)
+	return 1 - 6*d2/(nf*(nf*nf-1))
+}
@@ -0,0 +1,300 @@
+package engine
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/k-sml/go-rdbms/internal/exec"
+	"github.com/k-sml/go-rdbms/internal/sql/ast"
+	"github.com/k-sml/go-rdbms/internal/sql/lexer"
+	"github.com/k-sml/go-rdbms/internal/sql/parser"
+	"github.com/k-sml/go-rdbms/internal/types"
+)
+
+// 準備した文
+//
+// Prepare は SQL 文を解析して Stmt にする。Stmt を実行すると実行計画を作り、実行し終えたら
+// 取っておいて、次の実行では引数の値とトランザクションを差し替えて使い回す。実行計画は
+// 作ったときのスキーマの版と登録した関数の版を覚えておき、どちらかが変わっていたら捨てて作り直す。
+// 1つの実行計画を同時に2つの実行で使うことはできないので、実行中の実行計画は Stmt から取り出し、
+// 足りなければ新しく作る。
+//
+// DDL や ANALYZE はスキーマの版を進めるので、その前に作った実行計画は次に使うときに捨てられる。
+// ただし、コミットしていないスキーマの変更の後に作った実行計画は取っておかない。
+// ロールバックした変更の版と、別のトランザクションがコミットした変更の版が同じ番号になるためである。
+//
+// DB は SQL 文を正規化した文字列（lexer.Normalize）ごとに Stmt をキャッシュするので、Exec や Query に
+// 空白やコメント、キーワードの大文字と小文字だけが異なる文を渡しても、解析も実行計画の作成も
+// 一度で済む。値は文字列に埋め込まずに引数で渡すこと。
+
+// maxCachedStmts は DB がキャッシュする Stmt の数の上限です。
+const maxCachedStmts = 256
+
+// maxIdlePlans は1つの Stmt が取っておく実行計画の数の上限です。
+const maxIdlePlans = 4
+
+// plan は文の実行計画です。src のトランザクションと params の値を差し替えて、何度でも実行できます。
+type plan struct {
+	src     *source
+	params  *exec.Params
+	version uint64 // 実行計画を作ったときのスキーマの版
+	funcs   uint64 // 実行計画を作ったときの登録した関数の版
+	private bool   // 取っておけない実行計画（Tx.privatePlan）
+
+	query exec.Operator               // SELECT 文の結果の行を返す演算子
+	run   func(tx *Tx) (int64, error) // SELECT 以外の文を実行する関数
+}
+
+// Stmt は準備した文です。複数のゴルーチンから使えます。
+type Stmt struct {
+	db      *DB
+	sql     string
+	logSQL  string // Hooks とログに渡す SQL（パスワードを伏せたもの）
+	stmt    ast.Stmt
+	nparams int
+
+	mu    sync.Mutex
+	plans []*plan // 実行していない実行計画
+}
+
+// stmtCache は正規化した SQL 文の文字列ごとの Stmt です。
+type stmtCache struct {
+	mu    sync.Mutex
+	stmts map[string]*Stmt
+}
+
+// Prepare は SQL 文を解析して Stmt を返します。正規化すると同じ文字列になる Stmt がキャッシュに
+// あれば、それを返します。
+func (db *DB) Prepare(sql string) (*Stmt, error) {
+	key, err := lexer.Normalize(sql)
+	if err != nil {
+		return nil, err
+	}
+	c := &db.stmts
+	c.mu.Lock()
+	s, ok := c.stmts[key]
+	c.mu.Unlock()
+	if ok {
+		return s, nil
+	}
+	stmt, n, err := parser.ParseParams(sql)
+	if err != nil {
+		return nil, err
+	}
+	s = &Stmt{db: db, sql: sql, logSQL: redact(sql, stmt), stmt: stmt, nparams: n}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.stmts == nil || len(c.stmts) >= maxCachedStmts {
+		c.stmts = make(map[string]*Stmt)
+	}
+	if cached, ok := c.stmts[key]; ok {
+		return cached, nil
+	}
+	c.stmts[key] = s
+	return s, nil
+}
+
+// SQL は文の SQL を返します。
+func (s *Stmt) SQL() string { return s.sql }
+
+// NumParams は文の引数の数を返します。
+func (s *Stmt) NumParams() int { return s.nparams }
+
+// IsQuery は文が結果の行を返す文（SELECT、EXPLAIN、PRAGMA）かを返します。これらの文は Query で、
+// それ以外の文は Exec で実行します。
+func (s *Stmt) IsQuery() bool { return isQuery(s.stmt) }
+
+// ParamTypes はトランザクションから見えるスキーマで、準備した文 s の引数の型を推論します
+// （exec.ParamTypes）。推論できない引数の型は types.Null です。
+func (tx *Tx) ParamTypes(s *Stmt) ([]types.Type, error) {
+	return exec.ParamTypes(&source{tx}, s.stmt, s.nparams)
+}
+
+// Columns はトランザクションから見えるスキーマで、準備した文 s の結果の列を返します。結果の行を
+// 返さない文なら nil です。列を決めるために作った実行計画は、次の実行で使います。
+func (tx *Tx) Columns(s *Stmt) ([]exec.Column, error) {
+	if !isQuery(s.stmt) {
+		return nil, nil
+	}
+	pl, err := tx.planStmt(s.stmt, s.nparams)
+	if err != nil {
+		return nil, err
+	}
+	pl.private = tx.privatePlan()
+	defer s.release(pl)
+	return pl.query.Columns(), nil
+}
+
+// Control はトランザクションを制御する文の種類です。
+type Control int
+
+const (
+	NotControl      Control = iota // トランザクションを制御する文ではない
+	ControlBegin                   // BEGIN
+	ControlCommit                  // COMMIT
+	ControlRollback                // ROLLBACK
+)
+
+// Control は文が BEGIN、COMMIT、ROLLBACK のどれかならその種類を返します。これらの文は
+// トランザクションの中では実行できないので、セッションを管理する側が扱います。
+func (s *Stmt) Control() Control {
+	switch s.stmt.(type) {
+	case *ast.Begin:
+		return ControlBegin
+	case *ast.Commit:
+		return ControlCommit
+	case *ast.Rollback:
+		return ControlRollback
+	}
+	return NotControl
+}
+
+// Exec は新しいトランザクションで文を実行し、エラーがなければコミットします。
+func (s *Stmt) Exec(args ...any) (int64, error) {
+	var n int64
+	err := s.db.update(func(tx *Tx) error {
+		var err error
+		n, err = tx.ExecStmt(s, args...)
+		return err
+	})
+	return n, err
+}
+
+// ExecStmt はトランザクションの中で結果の行を返さない準備した文を実行し、変更した行の数を返します。
+// 文がエラーになった場合は、その文の変更だけを取り消して 0 を返します（nested.go）。
+// デッドロックでトランザクションごとロールバックされた場合を除き、トランザクションはそのまま
+// 続けられます。
+func (tx *Tx) ExecStmt(s *Stmt, args ...any) (n int64, err error) {
+	st, err := tx.startStatement(s.logSQL, args)
+	defer func() { tx.db.finishStatement(st, n, err) }()
+	if err != nil {
+		return 0, err
+	}
+	if isQuery(s.stmt) {
+		return 0, errors.New("use Query to run a SELECT statement")
+	}
+	if modifiesRows(s.stmt) {
+		// 変更する行を探す走査も、書き込み権を取ってから最新のページを読む
+		if err := tx.beginWrite(); err != nil {
+			return 0, err
+		}
+	}
+	pl, err := s.acquire(tx, args)
+	if err != nil {
+		return 0, err
+	}
+	defer s.release(pl)
+	nt, err := tx.Begin()
+	if err != nil {
+		return 0, err
+	}
+	if n, err = pl.run(tx); err != nil {
+		nt.Rollback() // デッドロックでトランザクションごとロールバックされていれば何もしない
+		return 0, err
+	}
+	return n, nt.Commit()
+}
+
+// QueryStmt はトランザクションの中で準備した SELECT 文を実行します。
+func (tx *Tx) QueryStmt(s *Stmt, args ...any) (*Rows, error) {
+	if !isQuery(s.stmt) {
+		return nil, errors.New("query is not a SELECT statement")
+	}
+	st, err := tx.startStatement(s.logSQL, args)
+	if err != nil {
+		tx.db.finishStatement(st, 0, err)
+		return nil, err
+	}
+	pl, err := s.acquire(tx, args)
+	if err != nil {
+		tx.db.finishStatement(st, 0, err)
+		return nil, err
+	}
+	op := pl.query
+	if err := op.Open(); err != nil {
+		op.Close()
+		s.release(pl)
+		tx.db.finishStatement(st, 0, err)
+		return nil, err
+	}
+	r := &Rows{op: op, release: func() { s.release(pl) }, db: tx.db, stmt: st}
+	for _, c := range op.Columns() {
+		r.cols = append(r.cols, c.Name)
+		r.colTypes = append(r.colTypes, c.Type)
+	}
+	return r, nil
+}
+
+// isQuery は文が結果の行を返す文（SELECT、EXPLAIN、PRAGMA）かを返します。
+func isQuery(stmt ast.Stmt) bool {
+	switch stmt.(type) {
+	case *ast.Select, *ast.Explain, *ast.Pragma:
+		return true
+	}
+	return false
+}
+
+// modifiesRows は文が行を変更する文（INSERT、UPDATE、DELETE）かを返します。
+func modifiesRows(stmt ast.Stmt) bool {
+	switch stmt.(type) {
+	case *ast.Insert, *ast.Update, *ast.Delete:
+		return true
+	}
+	return false
+}
+
+// acquire は tx で実行する実行計画を取り出し、引数の値を args にします。取っておいた
+// 実行計画がなければ作ります。
+func (s *Stmt) acquire(tx *Tx, args []any) (*plan, error) {
+	vals := make([]types.Value, len(args))
+	for i, a := range args {
+		v, err := types.FromGo(a)
+		if err != nil {
+			return nil, err
+		}
+		vals[i] = v
+	}
+	cat, err := tx.Catalog()
+	if err != nil {
+		return nil, err
+	}
+	private := tx.privatePlan()
+	var pl *plan
+	s.mu.Lock()
+	for len(s.plans) > 0 && pl == nil && !private {
+		pl = s.plans[len(s.plans)-1]
+		s.plans = s.plans[:len(s.plans)-1]
+		if pl.version != cat.Version() || pl.funcs != s.db.funcs.Version() {
+			pl = nil
+		}
+	}
+	s.mu.Unlock()
+	if pl == nil {
+		if pl, err = tx.planStmt(s.stmt, s.nparams); err != nil {
+			return nil, err
+		}
+		pl.private = private
+	}
+	pl.src.tx = tx
+	if err := pl.params.Bind(vals); err != nil {
+		return nil, err
+	}
+	return pl, nil
+}
+
+// privatePlan は tx で作った実行計画を取っておけないかを返します。コミットしていないスキーマの変更の
+// 後と、一時テーブルのあるセッションでは取っておきません（一時テーブルの名前は、ほかのセッションでは
+// 別のテーブルを指すかもしれない）。
+func (tx *Tx) privatePlan() bool {
+	return tx.schemaChanged() || tx.cat != nil && tx.cat.HasTemp()
+}
+
+// release は実行し終えた実行計画を取っておきます。
+func (s *Stmt) release(pl *plan) {
+	pl.src.tx = nil
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.plans) < maxIdlePlans && !pl.private {
+		s.plans = append(s.plans, pl)
+	}
+}
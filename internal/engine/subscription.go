@@ -0,0 +1,344 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/k-sml/go-rdbms/internal/catalog"
+	"github.com/k-sml/go-rdbms/internal/cdc"
+	"github.com/k-sml/go-rdbms/internal/sql/lexer"
+	"github.com/k-sml/go-rdbms/internal/txn"
+	"github.com/k-sml/go-rdbms/internal/types"
+)
+
+// Subscribe は r から Publish のストリームを読み、データベースに適用し続けます。ctx が取り消されるか、
+// r からの読み込みに失敗するまで戻りません。r が io.Closer なら、ctx が取り消されたときに閉じて
+// 読み込みを止めます。
+//
+// table を受け取ると、ないテーブルは作り（主キーには一意のインデックスも作る）、あるテーブルには
+// 足りない列を追加します。公開されていないテーブルと列はそのまま残します。スナップショットは
+// 公開されたテーブルの行をすべて置き換えて1つのトランザクションで、コミットはそれぞれ1つの
+// トランザクションで適用します。適用した位置は保存しないので、呼び出すたびにスナップショットから
+// 受け取り直します。
+func (db *DB) Subscribe(ctx context.Context, r io.Reader) error {
+	if c, ok := r.(io.Closer); ok {
+		defer context.AfterFunc(ctx, func() { c.Close() })()
+	}
+	err := db.subscribe(ctx, r)
+	if ctx.Err() != nil {
+		return context.Cause(ctx)
+	}
+	return err
+}
+
+// subTable は受け取っているテーブルの、公開する側での定義です。
+type subTable struct {
+	name string
+	cols []pubColumn
+	typs map[string]types.Type // 小文字にした列の名前がキー
+	key  []string              // 主キーの列の名前
+}
+
+// subscribe はストリーム r を読み終えるまで適用します。
+func (db *DB) subscribe(ctx context.Context, r io.Reader) error {
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
+	tables := make(map[string]*subTable)
+	var snap *Tx // スナップショットを適用しているトランザクション（ready の後は nil）
+	ready := false
+	defer func() {
+		if snap != nil {
+			snap.Rollback()
+		}
+	}()
+	start := time.Now()
+	var rows int64
+	for {
+		var m pubMessage
+		if err := dec.Decode(&m); err != nil {
+			if err == io.EOF {
+				return errors.New("publication stream ended")
+			}
+			return err
+		}
+		switch m.Type {
+		case pubTableMsg:
+			st, err := newSubTable(&m)
+			if err != nil {
+				return err
+			}
+			if ready {
+				err = db.updateContext(ctx, txn.Options{}, st.define)
+			} else {
+				if snap == nil {
+					if snap, err = db.BeginTx(ctx, txn.Options{}); err != nil {
+						return err
+					}
+				}
+				err = st.define(snap)
+				if err == nil {
+					_, err = snap.ExecContext(ctx, "DELETE FROM "+lexer.QuoteIdent(st.name))
+				}
+			}
+			if err != nil {
+				return fmt.Errorf("table %s: %w", st.name, err)
+			}
+			tables[strings.ToLower(st.name)] = st
+		case pubRowMsg:
+			st := tables[strings.ToLower(m.Table)]
+			switch {
+			case ready || snap == nil:
+				return errors.New("publication sent a snapshot row after the snapshot")
+			case st == nil:
+				return fmt.Errorf("publication sent a row of undefined table %s", m.Table)
+			}
+			if err := st.insert(ctx, snap, m.Row); err != nil {
+				return err
+			}
+			rows++
+		case pubReadyMsg:
+			if ready || snap == nil {
+				return errors.New("publication sent no snapshot")
+			}
+			err := snap.Commit()
+			snap = nil
+			if err != nil {
+				return err
+			}
+			ready = true
+			db.logger.Info("publication snapshot applied", "tables", len(tables), "rows", rows, "lsn", m.LSN,
+				"duration", time.Since(start))
+		case pubCommitMsg:
+			if !ready {
+				return errors.New("publication sent a commit before the snapshot")
+			}
+			err := db.updateContext(ctx, txn.Options{}, func(tx *Tx) error {
+				for _, ch := range m.Changes {
+					st := tables[strings.ToLower(ch.Table)]
+					if st == nil {
+						return fmt.Errorf("publication sent a change of undefined table %s", ch.Table)
+					}
+					if err := st.apply(ctx, tx, &ch); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+			if err != nil {
+				return fmt.Errorf("apply commit at lsn %d: %w", m.LSN, err)
+			}
+		default:
+			return fmt.Errorf("unknown publication message type %q", m.Type)
+		}
+	}
+}
+
+// newSubTable は table のメッセージ m からテーブルの定義を読みます。
+func newSubTable(m *pubMessage) (*subTable, error) {
+	st := &subTable{name: m.Table, cols: m.Columns, typs: make(map[string]types.Type)}
+	for _, c := range m.Columns {
+		t, err := types.Parse(c.Type)
+		if err != nil {
+			return nil, fmt.Errorf("table %s column %s: %w", m.Table, c.Name, err)
+		}
+		st.typs[strings.ToLower(c.Name)] = t
+		if c.PrimaryKey {
+			st.key = append(st.key, c.Name)
+		}
+	}
+	if len(st.key) == 0 {
+		return nil, fmt.Errorf("published table %s has no primary key", m.Table)
+	}
+	return st, nil
+}
+
+// define はトランザクション tx で、テーブルがなければ作り、あれば足りない列を追加します。
+func (st *subTable) define(tx *Tx) error {
+	cat, err := tx.Catalog()
+	if err != nil {
+		return err
+	}
+	t, ok := cat.Table(st.name)
+	if !ok {
+		cols := make([]catalog.Column, len(st.cols))
+		for i, c := range st.cols {
+			cols[i] = catalog.Column{Name: c.Name, Type: st.typs[strings.ToLower(c.Name)], NotNull: c.NotNull, PrimaryKey: c.PrimaryKey}
+		}
+		return tx.CreateTable(Schema{Name: st.name, Columns: cols})
+	}
+	for _, c := range st.cols {
+		if _, ok := t.Column(c.Name); !ok {
+			if err := tx.AddColumn(st.name, catalog.Column{Name: c.Name, Type: st.typs[strings.ToLower(c.Name)]}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// apply は1行の変更 ch をトランザクション tx で適用します。insert と update は主キーの行が
+// なければ挿入し、あれば置き換えるので、すでに適用した変更を受け取り直しても同じ結果になります。
+func (st *subTable) apply(ctx context.Context, tx *Tx, ch *pubChange) error {
+	switch ch.Op {
+	case cdc.OpInsert:
+		return st.upsert(ctx, tx, ch.New, ch.New)
+	case cdc.OpUpdate:
+		return st.upsert(ctx, tx, ch.Old, ch.New)
+	case cdc.OpDelete:
+		where, args, err := st.where(ch.Old)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, "DELETE FROM "+lexer.QuoteIdent(st.name)+where, args...)
+		return err
+	}
+	return fmt.Errorf("unknown change op %q", ch.Op)
+}
+
+// upsert は主キーの値が old の行を new に置き換え、行がなければ new を挿入します。
+func (st *subTable) upsert(ctx context.Context, tx *Tx, old, new json.RawMessage) error {
+	cols, vals, err := st.values(new)
+	if err != nil {
+		return err
+	}
+	where, keys, err := st.where(old)
+	if err != nil {
+		return err
+	}
+	set := make([]string, len(cols))
+	for i, c := range cols {
+		set[i] = c + " = ?"
+	}
+	n, err := tx.ExecContext(ctx, "UPDATE "+lexer.QuoteIdent(st.name)+" SET "+strings.Join(set, ", ")+where,
+		append(vals, keys...)...)
+	if err != nil || n > 0 {
+		return err
+	}
+	return st.insertValues(ctx, tx, cols, vals)
+}
+
+// insert は行 row を挿入します。
+func (st *subTable) insert(ctx context.Context, tx *Tx, row json.RawMessage) error {
+	cols, vals, err := st.values(row)
+	if err != nil {
+		return err
+	}
+	return st.insertValues(ctx, tx, cols, vals)
+}
+
+func (st *subTable) insertValues(ctx context.Context, tx *Tx, cols []string, vals []any) error {
+	marks := strings.Repeat(", ?", len(cols))[2:]
+	_, err := tx.ExecContext(ctx, "INSERT INTO "+lexer.QuoteIdent(st.name)+" ("+strings.Join(cols, ", ")+
+		") VALUES ("+marks+")", vals...)
+	return err
+}
+
+// where は行 row の主キーの値で行を選ぶ WHERE 句と引数を返します。
+func (st *subTable) where(row json.RawMessage) (string, []any, error) {
+	obj, err := decodeObject(row)
+	if err != nil {
+		return "", nil, err
+	}
+	conds := make([]string, len(st.key))
+	args := make([]any, len(st.key))
+	for i, name := range st.key {
+		x, ok := obj[name]
+		if !ok {
+			return "", nil, fmt.Errorf("table %s: change has no value for primary key column %s", st.name, name)
+		}
+		if args[i], err = st.value(name, x); err != nil {
+			return "", nil, err
+		}
+		conds[i] = lexer.QuoteIdent(name) + " = ?"
+	}
+	return " WHERE " + strings.Join(conds, " AND "), args, nil
+}
+
+// values は行 row の列の名前（SQL の識別子にしたもの）と値を、公開する側の列の順に返します。
+func (st *subTable) values(row json.RawMessage) ([]string, []any, error) {
+	obj, err := decodeObject(row)
+	if err != nil {
+		return nil, nil, err
+	}
+	cols := make([]string, 0, len(obj))
+	vals := make([]any, 0, len(obj))
+	for _, c := range st.cols {
+		x, ok := obj[c.Name]
+		if !ok {
+			continue
+		}
+		v, err := st.value(c.Name, x)
+		if err != nil {
+			return nil, nil, err
+		}
+		cols = append(cols, lexer.QuoteIdent(c.Name))
+		vals = append(vals, v)
+	}
+	if len(cols) == 0 {
+		return nil, nil, fmt.Errorf("table %s: row has no columns", st.name)
+	}
+	return cols, vals, nil
+}
+
+// value は列 col の JSON の値 x を、列の型の値にします。
+func (st *subTable) value(col string, x any) (types.Value, error) {
+	t, ok := st.typs[strings.ToLower(col)]
+	if !ok {
+		return types.Value{}, fmt.Errorf("table %s has no column %s", st.name, col)
+	}
+	v, err := valueFromJSON(x, t)
+	if err != nil {
+		return types.Value{}, fmt.Errorf("table %s column %s: %w", st.name, col, err)
+	}
+	return v, nil
+}
+
+// decodeObject は JSON のオブジェクト b を、数値を json.Number のまま読みます。
+func decodeObject(b json.RawMessage) (map[string]any, error) {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	var obj map[string]any
+	if err := dec.Decode(&obj); err != nil {
+		return nil, fmt.Errorf("invalid row: %w", err)
+	}
+	return obj, nil
+}
+
+// valueFromJSON は jsonValue で表した JSON の値 x を、型 t の値に戻します。
+func valueFromJSON(x any, t types.Type) (types.Value, error) {
+	switch x := x.(type) {
+	case nil:
+		return types.NullValue(), nil
+	case bool:
+		return types.Coerce(types.NewBool(x), t)
+	case json.Number:
+		if i, err := x.Int64(); err == nil {
+			return types.Coerce(types.NewBigInt(i), t)
+		}
+		f, err := x.Float64()
+		if err != nil {
+			return types.Value{}, err
+		}
+		return types.Coerce(types.NewReal(f), t)
+	case string:
+		switch t {
+		case types.Text:
+			return types.NewText(x), nil
+		case types.Blob:
+			b, err := base64.StdEncoding.DecodeString(x)
+			if err != nil {
+				return types.Value{}, err
+			}
+			return types.NewBlob(b), nil
+		}
+		return types.Cast(types.NewText(x), t)
+	}
+	return types.Value{}, fmt.Errorf("unsupported JSON value %T", x)
+}
@@ -0,0 +1,256 @@
+package engine
+
+import (
+	"context"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/k-sml/go-rdbms/internal/catalog"
+	"github.com/k-sml/go-rdbms/internal/lock"
+	"github.com/k-sml/go-rdbms/internal/sql/ast"
+	"github.com/k-sml/go-rdbms/internal/txn"
+)
+
+// ユーザー
+//
+// サーバーに接続するユーザーは CREATE USER で作り、GRANT で読み取り（READ）か読み書き（WRITE）を
+// 許可する。作ったばかりのユーザーは何も許可されていないので接続できない。ユーザーの管理も
+// 書き込みなので、読み書きを許可したユーザーだけができる。
+//
+// パスワードはそのまま保存せず、ユーザーごとのソルトを付けた PBKDF2-HMAC-SHA256 のハッシュを
+// "pbkdf2-sha256$回数$ソルト$ハッシュ"（ソルトとハッシュは base64）の形式で __users に保存する。
+// __users は SQL の文からは読めない。Hooks と遅い文のログには、パスワードを伏せた SQL を渡す。
+
+// ErrAuth はユーザー名かパスワードが違う場合のエラーです。
+var ErrAuth = errors.New("invalid user name or password")
+
+// ErrNoAccess は操作を許可していないユーザーが接続しようとした場合のエラーです。
+var ErrNoAccess = errors.New("permission denied for database")
+
+// passwordIterations は新しいパスワードのハッシュを計算する PBKDF2 の繰り返しの回数です。
+const passwordIterations = 100_000
+
+// passwordScheme はパスワードのハッシュの形式の名前です。
+const passwordScheme = "pbkdf2-sha256"
+
+// hashPassword は password にソルトを付けたハッシュを返します。
+func hashPassword(password string) (string, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key, err := pbkdf2.Key(sha256.New, password, salt, passwordIterations, sha256.Size)
+	if err != nil {
+		return "", err
+	}
+	enc := base64.RawStdEncoding
+	return fmt.Sprintf("%s$%d$%s$%s", passwordScheme, passwordIterations, enc.EncodeToString(salt), enc.EncodeToString(key)), nil
+}
+
+// checkPassword は password のハッシュが encoded と一致するかを返します。
+func checkPassword(encoded, password string) (bool, error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 4 || parts[0] != passwordScheme {
+		return false, fmt.Errorf("unknown password hash format")
+	}
+	iter, err := strconv.Atoi(parts[1])
+	if err != nil || iter <= 0 {
+		return false, fmt.Errorf("invalid password hash iterations %q", parts[1])
+	}
+	enc := base64.RawStdEncoding
+	salt, err := enc.DecodeString(parts[2])
+	if err != nil {
+		return false, fmt.Errorf("invalid password hash salt: %w", err)
+	}
+	want, err := enc.DecodeString(parts[3])
+	if err != nil {
+		return false, fmt.Errorf("invalid password hash: %w", err)
+	}
+	got, err := pbkdf2.Key(sha256.New, password, salt, iter, len(want))
+	if err != nil {
+		return false, fmt.Errorf("invalid password hash: %w", err)
+	}
+	return subtle.ConstantTimeCompare(got, want) == 1, nil
+}
+
+// Authenticate はユーザー名とパスワードを確かめ、ユーザーに許可した操作を返します。ユーザーが
+// いないかパスワードが違えば ErrAuth を、何も許可していなければ ErrNoAccess を返します。
+func (db *DB) Authenticate(ctx context.Context, user, password string) (catalog.Access, error) {
+	var u *catalog.User
+	err := db.viewContext(ctx, func(tx *Tx) error {
+		cat, err := tx.Catalog()
+		if err != nil {
+			return err
+		}
+		u, _ = cat.User(user)
+		return nil
+	})
+	if err != nil {
+		return catalog.NoAccess, err
+	}
+	if u == nil {
+		// ユーザーがいるかどうかを時間で推測されないように、ハッシュは計算する
+		hashPassword(password)
+		return catalog.NoAccess, ErrAuth
+	}
+	ok, err := checkPassword(u.Password, password)
+	if err != nil {
+		return catalog.NoAccess, fmt.Errorf("user %s: %w", u.Name, err)
+	}
+	if !ok {
+		return catalog.NoAccess, ErrAuth
+	}
+	if u.Access == catalog.NoAccess {
+		return catalog.NoAccess, fmt.Errorf("%w: user %s", ErrNoAccess, u.Name)
+	}
+	return u.Access, nil
+}
+
+// HasUsers は CREATE USER で作ったユーザーがいるかを返します。
+func (db *DB) HasUsers(ctx context.Context) (bool, error) {
+	var n int
+	err := db.viewContext(ctx, func(tx *Tx) error {
+		cat, err := tx.Catalog()
+		if err != nil {
+			return err
+		}
+		n = len(cat.Users())
+		return nil
+	})
+	return n > 0, err
+}
+
+// viewContext は ctx を使う読み取り専用のトランザクションで fn を実行します。
+func (db *DB) viewContext(ctx context.Context, fn func(tx *Tx) error) error {
+	tx, err := db.BeginTx(ctx, txn.Options{ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	return fn(tx)
+}
+
+// users はユーザーを変更するためにカタログを返します。ユーザーの変更どうしは __users の
+// テーブルのロックで順に行います。
+func (tx *Tx) users() (*catalog.Catalog, error) {
+	if err := tx.beginWrite(); err != nil {
+		return nil, err
+	}
+	cat, err := tx.Catalog()
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.lockTable("__users", lock.Exclusive); err != nil {
+		return nil, err
+	}
+	return cat, nil
+}
+
+// CreateUser はトランザクションの中でユーザーを作成します。
+func (tx *Tx) CreateUser(name, password string) error {
+	hash, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+	cat, err := tx.users()
+	if err != nil {
+		return err
+	}
+	_, err = cat.CreateUser(name, hash)
+	return err
+}
+
+// SetUserPassword はトランザクションの中でユーザーのパスワードを変えます。
+func (tx *Tx) SetUserPassword(name, password string) error {
+	hash, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+	cat, err := tx.users()
+	if err != nil {
+		return err
+	}
+	return cat.SetUserPassword(name, hash)
+}
+
+// DropUser はトランザクションの中でユーザーを削除します。
+func (tx *Tx) DropUser(name string) error {
+	cat, err := tx.users()
+	if err != nil {
+		return err
+	}
+	return cat.DropUser(name)
+}
+
+// Grant はトランザクションの中でユーザーに操作 a を許可します。すでに a より多くの操作を
+// 許可していれば何もしません。
+func (tx *Tx) Grant(name string, a catalog.Access) error {
+	cat, err := tx.users()
+	if err != nil {
+		return err
+	}
+	u, ok := cat.User(name)
+	if !ok {
+		return fmt.Errorf("%w: %s", catalog.ErrUserNotFound, name)
+	}
+	return cat.SetUserAccess(name, max(u.Access, a))
+}
+
+// Revoke はトランザクションの中でユーザーの操作 a の許可を取り消します。読み取りを取り消すと
+// 何も許可しなくなり、書き込みを取り消すと読み取りだけになります。
+func (tx *Tx) Revoke(name string, a catalog.Access) error {
+	cat, err := tx.users()
+	if err != nil {
+		return err
+	}
+	u, ok := cat.User(name)
+	if !ok {
+		return fmt.Errorf("%w: %s", catalog.ErrUserNotFound, name)
+	}
+	return cat.SetUserAccess(name, min(u.Access, a-1))
+}
+
+// planUser はユーザーを管理する文を実行する関数を返します。
+func planUser(stmt ast.Stmt) func(tx *Tx) error {
+	switch s := stmt.(type) {
+	case *ast.CreateUser:
+		return func(tx *Tx) error {
+			return ignore(tx.CreateUser(s.Name, s.Password), s.IfNotExists, catalog.ErrUserExists)
+		}
+	case *ast.AlterUser:
+		return func(tx *Tx) error { return tx.SetUserPassword(s.Name, s.Password) }
+	case *ast.DropUser:
+		return func(tx *Tx) error { return ignore(tx.DropUser(s.Name), s.IfExists, catalog.ErrUserNotFound) }
+	case *ast.Grant:
+		return func(tx *Tx) error { return tx.Grant(s.User, access(s.Write)) }
+	case *ast.Revoke:
+		return func(tx *Tx) error { return tx.Revoke(s.User, access(s.Write)) }
+	}
+	return nil
+}
+
+// access は GRANT と REVOKE の READ か WRITE の操作を返します。
+func access(write bool) catalog.Access {
+	if write {
+		return catalog.WriteAccess
+	}
+	return catalog.ReadAccess
+}
+
+// redact は Hooks とログに渡す、文 stmt の SQL sql からパスワードを伏せたものを返します。
+func redact(sql string, stmt ast.Stmt) string {
+	switch s := stmt.(type) {
+	case *ast.CreateUser:
+		return fmt.Sprintf("CREATE USER %s PASSWORD '********'", s.Name)
+	case *ast.AlterUser:
+		return fmt.Sprintf("ALTER USER %s PASSWORD '********'", s.Name)
+	}
+	return sql
+}
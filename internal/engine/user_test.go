@@ -0,0 +1,53 @@
+package engine
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestCheckPassword は、保存した形式のハッシュを RFC 7914 の PBKDF2-HMAC-SHA256 の試験ベクトルで
+// 確かめ、hashPassword のハッシュが同じパスワードにだけ一致することを確かめます。
+func TestCheckPassword(t *testing.T) {
+	vectors := []struct{ encoded, password string }{
+		{"pbkdf2-sha256$1$c2FsdA$VawEblbjCJ/sFpHCJUS2BflBhSFt3gRl5oudV8INrLxJypzM8Xm2RZkWZLOdd+8xfHG4RbHjC9UJESBB06GXgw", "passwd"},
+		{"pbkdf2-sha256$80000$TmFDbA$TdzY9guYviGDDO5e8icB+WQaRBjQTAQUrv8Ih2s0q1ah1CWhIlgzVJrbhBtRybMXaicr3ruh0HhHj2Kzl/M8jQ", "Password"},
+	}
+	for _, v := range vectors {
+		if ok, err := checkPassword(v.encoded, v.password); err != nil || !ok {
+			t.Errorf("checkPassword(%q, %q) = %v, %v, want true", v.encoded, v.password, ok, err)
+		}
+		if ok, err := checkPassword(v.encoded, v.password+"x"); err != nil || ok {
+			t.Errorf("checkPassword(%q, %q) = %v, %v, want false", v.encoded, v.password+"x", ok, err)
+		}
+	}
+
+	encoded, err := hashPassword("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(encoded, "pbkdf2-sha256$100000$") {
+		t.Errorf("hashPassword = %q, want the pbkdf2-sha256 format with %d iterations", encoded, passwordIterations)
+	}
+	if ok, err := checkPassword(encoded, "secret"); err != nil || !ok {
+		t.Errorf("checkPassword with the right password = %v, %v, want true", ok, err)
+	}
+	if ok, err := checkPassword(encoded, "Secret"); err != nil || ok {
+		t.Errorf("checkPassword with a wrong password = %v, %v, want false", ok, err)
+	}
+	if again, _ := hashPassword("secret"); again == encoded {
+		t.Errorf("hashPassword returned the same hash twice; the salt is not random")
+	}
+
+	for _, bad := range []string{
+		"",
+		"bcrypt$1$c2FsdA$VawE",
+		"pbkdf2-sha256$0$c2FsdA$VawE",
+		"pbkdf2-sha256$x$c2FsdA$VawE",
+		"pbkdf2-sha256$1$!!$VawE",
+		"pbkdf2-sha256$1$c2FsdA",
+	} {
+		if _, err := checkPassword(bad, "passwd"); err == nil {
+			t.Errorf("checkPassword(%q) succeeded, want an error", bad)
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package engine
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/k-sml/go-rdbms/internal/btree"
+	"github.com/k-sml/go-rdbms/internal/catalog"
+	"github.com/k-sml/go-rdbms/internal/lock"
+	"github.com/k-sml/go-rdbms/internal/storage"
+)
+
+// VACUUM
+//
+// 行を削除してもヒープのページは空きのあるまま残り、インデックスの B+ 木も分割でできた
+// 空きを抱えたままになる。VACUUM はテーブルを1つずつ、生きている行だけを新しいヒープに
+// 詰めて書き直し、インデックスを作り直す。古いページは新しいページを割り当てる前に解放し、
+// 解放済みのページのリストを小さい順に並べ直すので、書き直した内容はファイルの前の方に集まる。
+// 最後に末尾に続く解放済みのページをファイルから外し、コミットしたときにファイルを切り詰める。
+//
+// システムテーブルはユーザーのテーブルを書き直した後に、生きている行だけを詰めて書き直す。
+// ANALYZE などで後から書いたシステムテーブルのページはファイルの末尾の近くにあり、そのままでは
+// ファイルを切り詰められないためである。__tables などのルートのページは決まっているので動かさない。
+
+// VacuumStats は VACUUM の結果です。
+type VacuumStats struct {
+	PageSize    int
+	PagesBefore int64 // 実行前のファイルのページ数
+	PagesAfter  int64 // 実行後のファイルのページ数
+	FreePages   int64 // 実行後も残っている解放済みのページ（末尾に続いていないので外せなかったもの）
+}
+
+// Reclaimed はファイルから取り除いたバイト数を返します。
+func (s VacuumStats) Reclaimed() int64 { return (s.PagesBefore - s.PagesAfter) * int64(s.PageSize) }
+
+// Vacuum は新しいトランザクションで VACUUM を実行し、コミットします。
+func (db *DB) Vacuum() (VacuumStats, error) {
+	var st VacuumStats
+	err := db.update(func(tx *Tx) error {
+		var err error
+		st, err = tx.Vacuum()
+		return err
+	})
+	return st, err
+}
+
+// Vacuum はトランザクションの中ですべてのテーブルを書き直して、削除した行や B+ 木の空きが
+// 使っていたページを解放し、ファイルの末尾の空いたページを取り除きます。すべてのテーブルを
+// 排他ロックし、行の位置（RID）は変わります。ファイルはコミットしたときに切り詰めます。
+func (tx *Tx) Vacuum() (VacuumStats, error) {
+	if err := tx.beginWrite(); err != nil {
+		return VacuumStats{}, err
+	}
+	start := time.Now()
+	h, err := storage.ReadHeader(tx.tx)
+	if err != nil {
+		return VacuumStats{}, err
+	}
+	st := VacuumStats{PageSize: tx.tx.PageSize(), PagesBefore: h.NumPages}
+	cat, err := tx.Catalog()
+	if err != nil {
+		return st, err
+	}
+	// ファイルの後ろの方にあるテーブルほど後で書き直し、先に書き直したテーブルが空けた
+	// ページに移す
+	tables := cat.Tables()
+	last := make(map[string]int64, len(tables))
+	for _, t := range tables {
+		if err := tx.lockTable(t.Name, lock.Exclusive); err != nil {
+			return st, err
+		}
+		if last[t.Name], err = tx.lastPage(t); err != nil {
+			return st, err
+		}
+	}
+	slices.SortStableFunc(tables, func(a, b *catalog.Table) int { return cmp.Compare(last[a.Name], last[b.Name]) })
+	for _, t := range tables {
+		if err := tx.vacuumTable(t.Name); err != nil {
+			return st, fmt.Errorf("vacuum table %s: %w", t.Name, err)
+		}
+	}
+	if err := cat.CompactSystemTables(); err != nil {
+		return st, fmt.Errorf("vacuum system tables: %w", err)
+	}
+	_, free, err := storage.CompactFreeList(tx.tx)
+	if err != nil {
+		return st, err
+	}
+	if h, err = storage.ReadHeader(tx.tx); err != nil {
+		return st, err
+	}
+	st.PagesAfter, st.FreePages = h.NumPages, free
+	tx.tx.TruncateOnCommit()
+	tx.db.logger.Info("vacuum", "tables", len(tables), "pages_before", st.PagesBefore, "pages_after", st.PagesAfter,
+		"free_pages", st.FreePages, "reclaimed_bytes", st.Reclaimed(), "duration", time.Since(start))
+	return st, nil
+}
+
+// lastPage はテーブル t のヒープとインデックスが使っているページのうち、最も後ろのページを返します。
+func (tx *Tx) lastPage(t *catalog.Table) (int64, error) {
+	hf := tx.heap(t)
+	pages, err := hf.Pages()
+	if err != nil {
+		return 0, err
+	}
+	dirs, err := hf.DirPages()
+	if err != nil {
+		return 0, err
+	}
+	pages = append(pages, dirs...)
+	cat, err := tx.Catalog()
+	if err != nil {
+		return 0, err
+	}
+	for _, ix := range cat.Indexes(t.Name) {
+		ps, err := tx.tree(ix).Pages()
+		if err != nil {
+			return 0, err
+		}
+		pages = append(pages, ps...)
+	}
+	if len(pages) == 0 {
+		return 0, nil
+	}
+	return slices.Max(pages), nil
+}
+
+// vacuumTable はテーブルの生きている行を新しいヒープに詰めて書き直し、インデックスを作り直します。
+func (tx *Tx) vacuumTable(name string) error {
+	cat, err := tx.alter(name)
+	if err != nil {
+		return err
+	}
+	t, _ := cat.Table(name)
+	ixs := cat.Indexes(t.Name)
+	old := tx.heap(t)
+	var recs [][]byte
+	if err := old.Scan(func(_ storage.RID, rec []byte) error {
+		recs = append(recs, rec)
+		return nil
+	}); err != nil {
+		return err
+	}
+	// 古いページを先に解放して、書き直す内容が前の方のページに入るようにする
+	for _, ix := range ixs {
+		if err := tx.tree(ix).Drop(); err != nil {
+			return err
+		}
+	}
+	if err := old.Drop(); err != nil {
+		return err
+	}
+	if _, _, err := storage.CompactFreeList(tx.tx); err != nil {
+		return err
+	}
+
+	hf, err := storage.CreateHeapFileWithOptions(tx.tx, t.HeapOptions())
+	if err != nil {
+		return err
+	}
+	for _, rec := range recs {
+		if _, err := hf.Insert(rec); err != nil {
+			return err
+		}
+	}
+	if err := cat.SetTableRoot(t.Name, hf.Root()); err != nil {
+		return err
+	}
+	t, _ = cat.Table(name)
+	for _, ix := range ixs {
+		tree, err := btree.Create(tx.tx)
+		if err != nil {
+			return err
+		}
+		if err := cat.SetIndexRoot(ix.Name, tree.Root()); err != nil {
+			return err
+		}
+		nix, _ := cat.Index(ix.Name)
+		if err := tx.fillIndex(t, nix); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package engine
+
+import (
+	"testing"
+
+	"github.com/k-sml/go-rdbms/internal/storage"
+	"github.com/k-sml/go-rdbms/internal/txn"
+)
+
+// TestVacuumAfterAnalyze は、ANALYZE が書いた __statistics のページがファイルの末尾の近くにあっても、
+// VACUUM がシステムテーブルのページを前に移してファイルを縮めることを確かめます。
+func TestVacuumAfterAnalyze(t *testing.T) {
+	db := openMemory(t)
+	script(t, db, "CREATE TABLE t (id INT PRIMARY KEY, v TEXT, w INT); CREATE INDEX tw ON t (w)")
+	tx, err := db.Begin(txn.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range 5000 {
+		if _, err := tx.Exec("INSERT INTO t VALUES (?, 'xxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxx', ?)", i, i%97); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	script(t, db, "ANALYZE; DELETE FROM t WHERE id >= 100")
+
+	st, err := db.Vacuum()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.PagesAfter*5 > st.PagesBefore {
+		t.Errorf("VACUUM left %d of %d pages, want at most a fifth", st.PagesAfter, st.PagesBefore)
+	}
+	if st.FreePages > 2 {
+		t.Errorf("VACUUM left %d free pages, want at most 2", st.FreePages)
+	}
+
+	problems, err := db.CheckIntegrity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range problems {
+		t.Errorf("integrity check after VACUUM: %v", p)
+	}
+	if got := script(t, db, "SELECT id FROM t WHERE w = 5"); len(got) != 1 || got[0][0] != "5" {
+		t.Errorf("SELECT id FROM t WHERE w = 5 = %v after VACUUM, want [[5]]", got)
+	}
+	if got := script(t, db, "SELECT * FROM __statistics"); len(got) == 0 {
+		t.Errorf("__statistics = %v after VACUUM, want the rows written by ANALYZE", got)
+	}
+}
+
+// TestVacuumStmt は、VACUUM 文が変更した行の数としてファイルから取り除いたページの数を返すことを
+// 確かめます。
+func TestVacuumStmt(t *testing.T) {
+	db := openMemory(t)
+	script(t, db, "CREATE TABLE t (id INT PRIMARY KEY, v TEXT)")
+	for i := range 2000 {
+		if _, err := db.Exec("INSERT INTO t VALUES (?, 'xxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxx')", i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	script(t, db, "DELETE FROM t WHERE id >= 10")
+
+	pages := func() int64 {
+		t.Helper()
+		tx, err := db.Begin(txn.Options{ReadOnly: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer tx.Rollback()
+		h, err := storage.ReadHeader(tx.tx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return h.NumPages
+	}
+	before := pages()
+	n, err := db.Exec("VACUUM")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after := pages(); n <= 0 || n != before-after {
+		t.Errorf("VACUUM = %d, want the number of pages removed (%d -> %d)", n, before, after)
+	}
+	if n, err := db.Exec("VACUUM"); err != nil || n != 0 {
+		t.Errorf("second VACUUM = %d, %v, want 0 pages", n, err)
+	}
+}
@@ -0,0 +1,548 @@
+package exec
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/k-sml/go-rdbms/internal/sql/ast"
+	"github.com/k-sml/go-rdbms/internal/types"
+)
+
+// 集約
+//
+// 集約関数は組み込みの COUNT、SUM、AVG、MIN、MAX と、データベースごとの Funcs に登録したものを
+// 使える。組み込みの集約関数も関数と同じく RegisterAggregate で登録し、Funcs に同じ名前の関数や
+// 集約関数があればそちらを優先する。結果の列、HAVING、ORDER BY に
+// 集約関数の呼び出しがあるか、GROUP BY か HAVING があれば、SELECT 文は行を集約する。
+// FROM と WHERE の後に HashAggregate を置き、GROUP BY の式の値ごとのグループについて、
+// 集約関数の値を計算する。GROUP BY がなければ、すべての行を1つのグループにする。
+//
+// 集約した後の式（結果の列、HAVING、ORDER BY）は、GROUP BY の式と集約関数の呼び出しを
+// HashAggregate の結果の列の参照に書き換えてからコンパイルする（grouping.rewrite）。
+// GROUP BY にない列を集約関数の外で参照していれば、ここで誤りになる。
+// GROUP BY には、ORDER BY と同じく結果の列の番号と別名も書ける。
+
+// Aggregate は SQL から呼び出せる集約関数です。
+type Aggregate struct {
+	Name    string
+	MinArgs int
+	MaxArgs int          // 引数の最大の数。-1 なら上限なし
+	Args    []types.Type // 宣言した引数の型。Func.Args と同じです
+	Strict  bool         // 引数のいずれかが NULL の行は Step に渡さない
+	Star    bool         // COUNT(*) のように引数の代わりに * を書ける。Step には引数を渡さない
+
+	// Type は引数の型から結果の型を返します。決まらなければ types.Null を返します。
+	Type func(args []types.Type) types.Type
+	// New はグループごとの集約の状態を作ります。
+	New func() AggregateState
+}
+
+// AggregateState は1つのグループの集約の状態です。
+type AggregateState interface {
+	// Step はグループの1行の引数の値を受け取ります。
+	Step(args []types.Value) error
+	// Result はグループの集約の結果を返します。
+	Result() (types.Value, error)
+}
+
+var aggs = make(map[string]*Aggregate)
+
+// RegisterAggregate は組み込みの集約関数 a を登録します。同じ名前の集約関数があれば置き換えます。
+func RegisterAggregate(a *Aggregate) {
+	aggs[strings.ToUpper(a.Name)] = a
+}
+
+// LookupAggregate は name という名前の組み込みの集約関数を返します。
+func LookupAggregate(name string) (*Aggregate, bool) {
+	a, ok := aggs[strings.ToUpper(name)]
+	return a, ok
+}
+
+// checkAggregate は集約関数 a の呼び出し e の引数の数と型を検査します。
+func checkAggregate(e *ast.Call, a *Aggregate, cols []Column, fs *Funcs) error {
+	if e.Star && a.Star {
+		return nil
+	}
+	return checkCall(e, a.MinArgs, a.MaxArgs, a.Args, cols, fs)
+}
+
+// aggCall は HashAggregate が計算する集約関数の呼び出しです。
+type aggCall struct {
+	name string
+	agg  *Aggregate
+	args []Expr
+}
+
+// aggGroup は1つのグループのキーの値と集約の状態です。
+type aggGroup struct {
+	keys   []types.Value
+	states []AggregateState
+}
+
+// HashAggregate は行を keys の値でグループに分け、グループごとに集約関数の値を計算します。
+// グループはハッシュ表で覚え、最初に現れた順に返します。NULL のキーどうしは同じグループにします。
+// keys がなければ、行が1つもなくても1つのグループを返します。
+// 結果の行は keys の値、集約関数の値の順に並びます。
+type HashAggregate struct {
+	in    Operator
+	keys  []Expr
+	calls []aggCall
+	cols  []Column
+	rows  [][]types.Value
+	i     int
+}
+
+// NewHashAggregate は in の行を集約する HashAggregate を作ります。cols は結果の列です。
+func NewHashAggregate(in Operator, keys []Expr, calls []aggCall, cols []Column) *HashAggregate {
+	return &HashAggregate{in: in, keys: keys, calls: calls, cols: cols}
+}
+
+func (h *HashAggregate) Columns() []Column { return h.cols }
+
+func (h *HashAggregate) Open() error {
+	h.rows, h.i = h.rows[:0], 0
+	if err := h.in.Open(); err != nil {
+		return err
+	}
+	defer h.in.Close()
+	index := make(map[string]int)
+	var groups []*aggGroup
+	if len(h.keys) == 0 {
+		groups = append(groups, h.newGroup(nil))
+	}
+	var key []byte
+	keys := make([]types.Value, len(h.keys))
+	for {
+		row, ok, err := h.in.Next()
+		if err != nil {
+			return err
+		}
+		if !ok {
+			break
+		}
+		var g *aggGroup
+		if len(h.keys) == 0 {
+			g = groups[0]
+		} else {
+			key = key[:0]
+			for i, k := range h.keys {
+				if keys[i], err = k(row); err != nil {
+					return err
+				}
+				key = types.AppendKey(key, keys[i])
+			}
+			n, ok := index[string(key)]
+			if !ok {
+				n = len(groups)
+				index[string(key)] = n
+				groups = append(groups, h.newGroup(slices.Clone(keys)))
+			}
+			g = groups[n]
+		}
+		for i, c := range h.calls {
+			args, ok, err := c.eval(row)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				continue
+			}
+			if err := g.states[i].Step(args); err != nil {
+				return fmt.Errorf("%s: %w", c.name, err)
+			}
+		}
+	}
+	for _, g := range groups {
+		out := append(make([]types.Value, 0, len(h.cols)), g.keys...)
+		for i, s := range g.states {
+			v, err := s.Result()
+			if err != nil {
+				return fmt.Errorf("%s: %w", h.calls[i].name, err)
+			}
+			if t := h.cols[len(out)].Type; !v.IsNull() && t != types.Null && v.Type() != t {
+				if v, err = types.Coerce(v, t); err != nil {
+					return fmt.Errorf("%s: %w", h.calls[i].name, err)
+				}
+			}
+			out = append(out, v)
+		}
+		h.rows = append(h.rows, out)
+	}
+	return nil
+}
+
+// newGroup はキーの値が keys のグループを作ります。
+func (h *HashAggregate) newGroup(keys []types.Value) *aggGroup {
+	g := &aggGroup{keys: keys, states: make([]AggregateState, len(h.calls))}
+	for i, c := range h.calls {
+		g.states[i] = c.agg.New()
+	}
+	return g
+}
+
+// eval は行 row に対する集約関数の引数の値を返します。Strict な集約関数の引数に NULL が
+// あれば false を返します。
+func (c *aggCall) eval(row []types.Value) ([]types.Value, bool, error) {
+	args := make([]types.Value, len(c.args))
+	for i, a := range c.args {
+		v, err := a(row)
+		if err != nil {
+			return nil, false, err
+		}
+		if v.IsNull() && c.agg.Strict {
+			return nil, false, nil
+		}
+		args[i] = v
+	}
+	if err := coerceArgs(c.name, c.agg.Args, args); err != nil {
+		return nil, false, err
+	}
+	return args, true, nil
+}
+
+func (h *HashAggregate) Next() ([]types.Value, bool, error) {
+	if h.i >= len(h.rows) {
+		return nil, false, nil
+	}
+	h.i++
+	return h.rows[h.i-1], true, nil
+}
+
+func (h *HashAggregate) Close() error {
+	h.rows = nil
+	return nil
+}
+
+// grouping は集約する SELECT 文の、GROUP BY の式と集約関数の呼び出しです。
+type grouping struct {
+	in    []Column    // 集約する前の行の列
+	keys  []ast.Expr  // GROUP BY の式
+	calls []*ast.Call // 集約関数の呼び出し。同じ式の呼び出しは1つにまとめる
+	cols  []Column    // HashAggregate の結果の列。keys、calls の順に並ぶ
+	funcs *Funcs
+
+	byExpr   map[string]int // 式の文字列から cols の位置へ
+	byColumn map[int]int    // GROUP BY に書いた in の列の位置から cols の位置へ
+}
+
+// newGrouping は SELECT 文 s が行を集約するなら、その集約の仕方を返します。集約しなければ
+// nil を返します。exprs と cols は s の結果の列の式と列、in は FROM の列です。
+func newGrouping(s *ast.Select, exprs []ast.Expr, cols []Column, in []Column, fs *Funcs) (*grouping, error) {
+	g := &grouping{in: in, funcs: fs, byExpr: make(map[string]int), byColumn: make(map[int]int)}
+	var err error
+	collect := func(e ast.Expr) bool {
+		call, ok := e.(*ast.Call)
+		if !ok || err != nil {
+			return err == nil
+		}
+		if _, ok := fs.Aggregate(call.Name); !ok {
+			return true
+		}
+		for _, a := range call.Args {
+			if g.hasAggregate(a) {
+				err = bindErrorf(a, "aggregate function calls cannot be nested")
+				return false
+			}
+		}
+		g.calls = append(g.calls, call)
+		return false
+	}
+	for _, e := range exprs {
+		ast.WalkExpr(e, collect)
+	}
+	ast.WalkExpr(s.Having, collect)
+	for _, o := range s.OrderBy {
+		ast.WalkExpr(o.Expr, collect)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(g.calls) == 0 && len(s.GroupBy) == 0 && s.Having == nil {
+		return nil, nil
+	}
+
+	for _, e := range s.GroupBy {
+		k, err := orderExpr(e, exprs, cols, in)
+		if err != nil {
+			return nil, &BindError{Pos: e.Pos(), Err: err}
+		}
+		if g.hasAggregate(k) {
+			return nil, bindErrorf(e, "aggregate functions are not allowed in GROUP BY")
+		}
+		c := Column{Name: ast.FormatExpr(k), Type: TypeOf(k, in, fs)}
+		if ref, ok := k.(*ast.ColumnRef); ok {
+			if i, err := resolve(in, ref); err == nil {
+				if _, dup := g.byColumn[i]; dup {
+					continue
+				}
+				g.byColumn[i] = len(g.cols)
+				c.Table, c.Name = in[i].Table, in[i].Name
+			}
+		} else if _, dup := g.byExpr[c.Name]; dup {
+			continue
+		} else {
+			g.byExpr[c.Name] = len(g.cols)
+		}
+		g.keys = append(g.keys, k)
+		g.cols = append(g.cols, c)
+	}
+	calls := g.calls[:0]
+	for _, call := range g.calls {
+		name := ast.FormatExpr(call)
+		if _, dup := g.byExpr[name]; dup {
+			continue
+		}
+		g.byExpr[name] = len(g.cols)
+		g.cols = append(g.cols, Column{Name: name, Type: TypeOf(call, in, fs)})
+		calls = append(calls, call)
+	}
+	g.calls = calls
+	return g, nil
+}
+
+// hasAggregate は式 e に集約関数の呼び出しがあるかを返します。
+func (g *grouping) hasAggregate(e ast.Expr) bool {
+	found := false
+	ast.WalkExpr(e, func(e ast.Expr) bool {
+		if call, ok := e.(*ast.Call); ok {
+			if _, ok := g.funcs.Aggregate(call.Name); ok {
+				found = true
+			}
+		}
+		return !found
+	})
+	return found
+}
+
+// rewrite は集約する前の行に対する式 e を、HashAggregate の結果の行に対する式に書き換えます。
+// GROUP BY の式と集約関数の呼び出しは結果の列の参照にします。in の列のうち GROUP BY にないものを
+// 参照していれば誤りです。in にない列の参照（結果の列の別名や外側の問い合わせの列）はそのままにします。
+func (g *grouping) rewrite(e ast.Expr) (ast.Expr, error) {
+	if e == nil {
+		return nil, nil
+	}
+	if ref, ok := e.(*ast.ColumnRef); ok {
+		i, err := resolve(g.in, ref)
+		if err != nil {
+			return e, nil
+		}
+		n, ok := g.byColumn[i]
+		if !ok {
+			return nil, bindErrorf(ref, "column %s must appear in the GROUP BY clause or be used in an aggregate function", ast.FormatExpr(ref))
+		}
+		return g.ref(e, n), nil
+	}
+	if n, ok := g.byExpr[ast.FormatExpr(e)]; ok {
+		return g.ref(e, n), nil
+	}
+
+	var err error
+	sub := func(x ast.Expr) ast.Expr {
+		if err != nil {
+			return x
+		}
+		var y ast.Expr
+		y, err = g.rewrite(x)
+		return y
+	}
+	switch e := e.(type) {
+	case *ast.Unary:
+		c := *e
+		c.X = sub(e.X)
+		return &c, err
+	case *ast.Binary:
+		c := *e
+		c.L, c.R = sub(e.L), sub(e.R)
+		return &c, err
+	case *ast.IsNull:
+		c := *e
+		c.X = sub(e.X)
+		return &c, err
+	case *ast.Between:
+		c := *e
+		c.X, c.Lo, c.Hi = sub(e.X), sub(e.Lo), sub(e.Hi)
+		return &c, err
+	case *ast.InList:
+		c := *e
+		c.X = sub(e.X)
+		c.List = make([]ast.Expr, len(e.List))
+		for i, x := range e.List {
+			c.List[i] = sub(x)
+		}
+		return &c, err
+	case *ast.Like:
+		c := *e
+		c.X, c.Pattern, c.Escape = sub(e.X), sub(e.Pattern), sub(e.Escape)
+		return &c, err
+	case *ast.Call:
+		c := *e
+		c.Args = make([]ast.Expr, len(e.Args))
+		for i, x := range e.Args {
+			c.Args[i] = sub(x)
+		}
+		return &c, err
+	case *ast.Case:
+		c := *e
+		c.Operand = sub(e.Operand)
+		c.Whens = make([]*ast.When, len(e.Whens))
+		for i, w := range e.Whens {
+			c.Whens[i] = &ast.When{Cond: sub(w.Cond), Result: sub(w.Result)}
+		}
+		c.Else = sub(e.Else)
+		return &c, err
+	case *ast.Cast:
+		c := *e
+		c.X = sub(e.X)
+		return &c, err
+	case *ast.InSubquery:
+		c := *e
+		c.X = sub(e.X)
+		return &c, err
+	}
+	return e, nil
+}
+
+// ref は HashAggregate の結果の n 番目の列を参照する、式 e の位置の ColumnRef を返します。
+func (g *grouping) ref(e ast.Expr, n int) *ast.ColumnRef {
+	return &ast.ColumnRef{At: ast.At(e.Pos()), Table: g.cols[n].Table, Column: g.cols[n].Name}
+}
+
+// aggregate は op の行を g に従って集約する HashAggregate を作ります。
+func (p *planner) aggregate(op Operator, g *grouping) (Operator, error) {
+	keys := make([]Expr, len(g.keys))
+	for i, k := range g.keys {
+		var err error
+		if keys[i], err = p.compile(k, g.in); err != nil {
+			return nil, err
+		}
+	}
+	calls := make([]aggCall, len(g.calls))
+	for i, call := range g.calls {
+		agg, _ := p.funcs.Aggregate(call.Name)
+		if err := checkAggregate(call, agg, g.in, p.funcs); err != nil {
+			return nil, err
+		}
+		calls[i] = aggCall{name: call.Name, agg: agg, args: make([]Expr, len(call.Args))}
+		for j, a := range call.Args {
+			var err error
+			if calls[i].args[j], err = p.compile(a, g.in); err != nil {
+				return nil, err
+			}
+		}
+	}
+	h := NewHashAggregate(op, keys, calls, g.cols)
+	if len(g.keys) > 0 {
+		names := make([]string, len(g.keys))
+		for i, k := range g.keys {
+			names[i] = ast.FormatExpr(k)
+		}
+		p.note(h, "Group Key: %s", strings.Join(names, ", "))
+	}
+	return h, nil
+}
+
+// sumType は SUM の結果の型です。整数の合計は BIGINT、REAL を含めば REAL です。
+func sumType(args []types.Type) types.Type {
+	switch t := args[0]; {
+	case t.IsInteger():
+		return types.BigInt
+	case t == types.Real:
+		return types.Real
+	}
+	return types.Null
+}
+
+func init() {
+	for _, a := range []*Aggregate{
+		{Name: "COUNT", MinArgs: 1, MaxArgs: 1, Strict: true, Star: true, Type: returns(types.BigInt),
+			New: func() AggregateState { return new(countState) }},
+		{Name: "SUM", MinArgs: 1, MaxArgs: 1, Strict: true, Type: sumType,
+			New: func() AggregateState { return new(sumState) }},
+		{Name: "AVG", MinArgs: 1, MaxArgs: 1, Strict: true, Type: returns(types.Real),
+			New: func() AggregateState { return new(avgState) }},
+		{Name: "MIN", MinArgs: 1, MaxArgs: 1, Strict: true, Type: firstArg,
+			New: func() AggregateState { return &extremeState{sign: -1} }},
+		{Name: "MAX", MinArgs: 1, MaxArgs: 1, Strict: true, Type: firstArg,
+			New: func() AggregateState { return &extremeState{sign: 1} }},
+	} {
+		RegisterAggregate(a)
+	}
+}
+
+// countState は COUNT の状態です。COUNT(*) はすべての行を、COUNT(x) は x が NULL でない行を数えます。
+type countState struct{ n int64 }
+
+func (s *countState) Step([]types.Value) error { s.n++; return nil }
+
+func (s *countState) Result() (types.Value, error) { return types.NewBigInt(s.n), nil }
+
+// sumState は SUM の状態です。整数は BIGINT であふれを検査しながら足し、REAL の値があれば
+// REAL で足します。行がなければ NULL を返します。
+type sumState struct{ sum types.Value }
+
+func (s *sumState) Step(args []types.Value) error {
+	v := args[0]
+	switch {
+	case !v.Type().IsNumeric():
+		return fmt.Errorf("cannot apply to %s", v.Type())
+	case v.Type() == types.Int:
+		v = types.NewBigInt(v.Int())
+	}
+	if s.sum.IsNull() {
+		s.sum = v
+		return nil
+	}
+	var err error
+	s.sum, err = arith("+", s.sum, v)
+	return err
+}
+
+func (s *sumState) Result() (types.Value, error) { return s.sum, nil }
+
+// avgState は AVG の状態です。行がなければ NULL を返します。
+type avgState struct {
+	sum float64
+	n   int64
+}
+
+func (s *avgState) Step(args []types.Value) error {
+	v := args[0]
+	if !v.Type().IsNumeric() {
+		return fmt.Errorf("cannot apply to %s", v.Type())
+	}
+	s.sum += v.Real()
+	s.n++
+	return nil
+}
+
+func (s *avgState) Result() (types.Value, error) {
+	if s.n == 0 {
+		return types.NullValue(), nil
+	}
+	return types.NewReal(s.sum / float64(s.n)), nil
+}
+
+// extremeState は MIN（sign が -1）と MAX（sign が 1）の状態です。値は types.Compare で比べます。
+// 行がなければ NULL を返します。
+type extremeState struct {
+	sign int
+	v    types.Value
+}
+
+func (s *extremeState) Step(args []types.Value) error {
+	if s.v.IsNull() {
+		s.v = args[0]
+		return nil
+	}
+	c, err := types.Compare(args[0], s.v)
+	if err != nil {
+		return err
+	}
+	if c*s.sign > 0 {
+		s.v = args[0]
+	}
+	return nil
+}
+
+func (s *extremeState) Result() (types.Value, error) { return s.v, nil }
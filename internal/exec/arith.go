@@ -0,0 +1,137 @@
+package exec
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/k-sml/go-rdbms/internal/types"
+)
+
+var (
+	errOverflow     = errors.New("integer overflow")
+	errDivideByZero = errors.New("division by zero")
+)
+
+// arith は算術演算 op（+ - * / %）の結果を返します。両辺を types.Common の型にそろえ、
+// 整数どうしなら整数で、REAL を含めば REAL で計算します。整数の / は0の方向に切り捨てます。
+func arith(op string, a, b types.Value) (types.Value, error) {
+	if a.IsNull() || b.IsNull() {
+		return types.NullValue(), nil
+	}
+	if !a.Type().IsNumeric() || !b.Type().IsNumeric() {
+		return types.Value{}, fmt.Errorf("cannot apply %s to %s and %s", op, a.Type(), b.Type())
+	}
+	t, _ := types.Common(a.Type(), b.Type())
+	if t == types.Real {
+		x, y := a.Real(), b.Real()
+		switch op {
+		case "+":
+			return types.NewReal(x + y), nil
+		case "-":
+			return types.NewReal(x - y), nil
+		case "*":
+			return types.NewReal(x * y), nil
+		}
+		if y == 0 {
+			return types.Value{}, errDivideByZero
+		}
+		if op == "/" {
+			return types.NewReal(x / y), nil
+		}
+		return types.NewReal(math.Mod(x, y)), nil
+	}
+	r, err := intArith(op, a.Int(), b.Int())
+	if err != nil {
+		return types.Value{}, err
+	}
+	if t == types.Int {
+		if r < math.MinInt32 || r > math.MaxInt32 {
+			return types.Value{}, errOverflow
+		}
+		return types.NewInt(int32(r)), nil
+	}
+	return types.NewBigInt(r), nil
+}
+
+// intArith は64ビット整数の算術演算をあふれを検査しながら行います。
+func intArith(op string, x, y int64) (int64, error) {
+	switch op {
+	case "+":
+		r := x + y
+		if (x > 0 && y > 0 && r < 0) || (x < 0 && y < 0 && r >= 0) {
+			return 0, errOverflow
+		}
+		return r, nil
+	case "-":
+		r := x - y
+		if (x >= 0 && y < 0 && r < 0) || (x < 0 && y > 0 && r >= 0) {
+			return 0, errOverflow
+		}
+		return r, nil
+	case "*":
+		if x == 0 || y == 0 {
+			return 0, nil
+		}
+		r := x * y
+		if r/y != x || (x == -1 && y == math.MinInt64) || (y == -1 && x == math.MinInt64) {
+			return 0, errOverflow
+		}
+		return r, nil
+	}
+	if y == 0 {
+		return 0, errDivideByZero
+	}
+	if x == math.MinInt64 && y == -1 {
+		if op == "%" {
+			return 0, nil
+		}
+		return 0, errOverflow
+	}
+	if op == "/" {
+		return x / y, nil
+	}
+	return x % y, nil
+}
+
+// unary は単項演算 - または + の結果を返します。
+func unary(op string, v types.Value) (types.Value, error) {
+	if v.IsNull() {
+		return v, nil
+	}
+	if !v.Type().IsNumeric() {
+		return types.Value{}, fmt.Errorf("cannot apply unary %s to %s", op, v.Type())
+	}
+	if op == "+" {
+		return v, nil
+	}
+	switch v.Type() {
+	case types.Real:
+		return types.NewReal(-v.Real()), nil
+	case types.Int:
+		if v.Int() == math.MinInt32 {
+			return types.Value{}, errOverflow
+		}
+		return types.NewInt(int32(-v.Int())), nil
+	}
+	if v.Int() == math.MinInt64 {
+		return types.Value{}, errOverflow
+	}
+	return types.NewBigInt(-v.Int()), nil
+}
+
+// concat は a || b の結果を返します。両辺を TEXT に変換してつなぎます。
+func concat(a, b types.Value) (types.Value, error) {
+	if a.IsNull() || b.IsNull() {
+		return types.NullValue(), nil
+	}
+	x, err := types.Cast(a, types.Text)
+	if err != nil {
+		return x, err
+	}
+	y, err := types.Cast(b, types.Text)
+	if err != nil {
+		return y, err
+	}
+	return types.NewText(x.Text() + y.Text()), nil
+}
@@ -0,0 +1,405 @@
+package exec
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/k-sml/go-rdbms/internal/sql/ast"
+	"github.com/k-sml/go-rdbms/internal/types"
+)
+
+// バッチ実行
+//
+// SeqScan の上の Filter と Project は、行を1つずつではなく、最大 Settings.BatchSize 行の
+// バッチでまとめて受け渡す。バッチは列ごとの値の並び（ベクトル）と、条件を満たして残った
+// 行の番号の並び（選択ベクトル）からなる。Filter は条件を満たさない行を選択ベクトルから
+// 外すだけで値を写さず、Project は列の参照なら下のベクトルをそのまま使う。
+// 演算子の Next の呼び出しと式の関数の呼び出しがバッチごとになるので、単純な走査で
+// 行ごとにかかっていた呼び出しの手間が減る。
+//
+// 式はベクトルごとに評価する形（vecExpr）にもコンパイルする。列の参照、定数と引数、比較、
+// 算術演算、||、AND、OR、NOT、IS NULL はベクトルのまま計算し、それ以外の式は行を組み立てて
+// 1行ずつの Expr で評価する。AND と OR は、左の値で結果が決まらない行だけ右を評価するので、
+// 1行ずつ評価するときと同じく、評価しなくてよい右の式のエラーは起きない。
+//
+// バッチで読む演算子の上の演算子には、Next でバッチの行を1つずつ返す。LIMIT で少しの行しか
+// 読まないときに読みすぎないように、最初のバッチは小さくし、読むたびに大きさを倍にする。
+
+// firstBatch は SeqScan が最初に読むバッチの行の数です。
+const firstBatch = 64
+
+// batch は列ごとの値の並びで表した行の集まりです。
+type batch struct {
+	vecs [][]types.Value // vecs[c][i] は i 番目の行の列 c の値
+	n    int             // 行の数
+	sel  []int           // nil でなければ、残っている行の番号（昇順）。nil ならすべての行
+	all  []int           // 0, 1, 2, ...
+}
+
+func newBatch(ncols, size int) *batch {
+	b := &batch{vecs: make([][]types.Value, ncols), all: make([]int, size)}
+	for c := range b.vecs {
+		b.vecs[c] = make([]types.Value, size)
+	}
+	for i := range b.all {
+		b.all[i] = i
+	}
+	return b
+}
+
+// selected は残っている行の番号を返します。
+func (b *batch) selected() []int {
+	if b.sel != nil {
+		return b.sel
+	}
+	return b.all[:b.n]
+}
+
+// batchOperator はバッチで行を返せる演算子です。
+type batchOperator interface {
+	Operator
+	// nextBatch は次のバッチを返します。最後まで読むと ok が false になります。
+	// 返したバッチは次の nextBatch の呼び出しまで有効です。
+	nextBatch() (b *batch, ok bool, err error)
+}
+
+// toBatch は op がバッチで行を返せるなら、バッチで読むように準備して true を返します。
+func (p *planner) toBatch(op Operator) bool {
+	if p.batchSize <= 1 {
+		return false
+	}
+	switch o := op.(type) {
+	case *SeqScan:
+		o.batchSize = p.batchSize
+		return true
+	case *Filter:
+		return o.vcond != nil
+	case *Project:
+		return o.vexprs != nil
+	case *Gather:
+		return true
+	}
+	return false
+}
+
+// batchReader は nextBatch で読んだバッチの行を、Next で1つずつ返します。
+type batchReader struct {
+	b   *batch
+	sel []int
+	row []types.Value
+}
+
+func (r *batchReader) reset() { r.b, r.sel = nil, nil }
+
+func (r *batchReader) next(read func() (*batch, bool, error)) ([]types.Value, bool, error) {
+	for len(r.sel) == 0 {
+		b, ok, err := read()
+		if err != nil || !ok {
+			r.reset()
+			return nil, false, err
+		}
+		r.b, r.sel = b, b.selected()
+	}
+	i := r.sel[0]
+	r.sel = r.sel[1:]
+	if len(r.row) != len(r.b.vecs) {
+		r.row = make([]types.Value, len(r.b.vecs))
+	}
+	for c, vec := range r.b.vecs {
+		r.row[c] = vec[i]
+	}
+	return r.row, true, nil
+}
+
+func (s *SeqScan) nextBatch() (*batch, bool, error) {
+	if s.it == nil {
+		return nil, false, fmt.Errorf("scan of %s is not open", s.table.Name)
+	}
+	if s.batch == nil {
+		s.batch = newBatch(len(s.cols), s.batchSize)
+	}
+	b := s.batch
+	b.n, b.sel = 0, nil
+	if it, ok := s.it.(BatchIter); ok {
+		n, err := it.NextBatch(b.vecs, s.want)
+		if err != nil {
+			return nil, false, err
+		}
+		b.n = n
+		s.want = min(s.want*2, s.batchSize)
+		return b, n > 0, nil
+	}
+	for b.n < s.want {
+		_, row, ok, err := s.it.Next()
+		if err != nil {
+			return nil, false, err
+		}
+		if !ok {
+			break
+		}
+		for c, v := range row {
+			b.vecs[c][b.n] = v
+		}
+		b.n++
+	}
+	s.want = min(s.want*2, s.batchSize)
+	return b, b.n > 0, nil
+}
+
+func (f *Filter) nextBatch() (*batch, bool, error) {
+	in := f.in.(batchOperator)
+	for {
+		b, ok, err := in.nextBatch()
+		if err != nil || !ok {
+			return nil, false, err
+		}
+		sel := b.selected()
+		v, err := f.vcond(b, sel)
+		if err != nil {
+			return nil, false, err
+		}
+		keep := f.sel[:0]
+		for _, i := range sel {
+			if Truth(v[i]) {
+				keep = append(keep, i)
+			}
+		}
+		f.sel = keep
+		if len(keep) > 0 {
+			b.sel = keep
+			return b, true, nil
+		}
+	}
+}
+
+func (p *Project) nextBatch() (*batch, bool, error) {
+	b, ok, err := p.in.(batchOperator).nextBatch()
+	if err != nil || !ok {
+		return nil, false, err
+	}
+	sel := b.selected()
+	out := &p.out
+	if out.vecs == nil {
+		out.vecs = make([][]types.Value, len(p.vexprs))
+	}
+	out.n, out.sel, out.all = b.n, b.sel, b.all
+	for i, e := range p.vexprs {
+		if out.vecs[i], err = e(b, sel); err != nil {
+			return nil, false, err
+		}
+	}
+	return out, true, nil
+}
+
+func (in *instrumented) nextBatch() (*batch, bool, error) {
+	start := time.Now()
+	b, ok, err := in.Operator.(batchOperator).nextBatch()
+	in.elapsed += time.Since(start)
+	if ok {
+		in.rows += int64(len(b.selected()))
+	}
+	return b, ok, err
+}
+
+// vecExpr はベクトルごとに評価する式です。バッチ b の行のうち sel の行について値を計算し、
+// 行の番号を添え字とするベクトルを返します。sel 以外の行の値は決まっていません。
+// 返したベクトルは次の呼び出しまで有効です。
+type vecExpr func(b *batch, sel []int) ([]types.Value, error)
+
+// compileVec は式 e を、1行ずつ評価する形とベクトルごとに評価する形にコンパイルします。
+func (p *planner) compileVec(e ast.Expr, cols []Column) (Expr, vecExpr, error) {
+	f, err := p.compile(e, cols)
+	if err != nil {
+		return nil, nil, err
+	}
+	if hasSubquery(e) {
+		// 副問い合わせの実行計画を2度作らないように、式全体を1行ずつ評価する
+		return f, rowwise(f, len(cols)), nil
+	}
+	v, err := p.vec(e, cols, f)
+	return f, v, err
+}
+
+// vec は副問い合わせを含まない式 e をベクトルごとに評価する形にします。f は e を
+// 1行ずつ評価する形で、ベクトルのまま計算できない式に使います。
+func (p *planner) vec(e ast.Expr, cols []Column, f Expr) (vecExpr, error) {
+	sub := func(x ast.Expr) (vecExpr, error) {
+		f, err := p.compile(x, cols)
+		if err != nil {
+			return nil, err
+		}
+		return p.vec(x, cols, f)
+	}
+	switch e := e.(type) {
+	case *ast.ColumnRef:
+		i, err := resolve(cols, e)
+		if err != nil {
+			break // 外側の問い合わせの列
+		}
+		return func(b *batch, _ []int) ([]types.Value, error) { return b.vecs[i], nil }, nil
+	case *ast.Literal, *ast.Param:
+		return constVec(f), nil
+	case *ast.Unary:
+		if e.Op != "NOT" {
+			break
+		}
+		x, err := sub(e.X)
+		if err != nil {
+			return nil, err
+		}
+		return mapVec(x, func(v types.Value) types.Value { return not(v) }), nil
+	case *ast.IsNull:
+		x, err := sub(e.X)
+		if err != nil {
+			return nil, err
+		}
+		negate := e.Not
+		return mapVec(x, func(v types.Value) types.Value { return types.NewBool(v.IsNull() != negate) }), nil
+	case *ast.Binary:
+		var op func(a, b types.Value) (types.Value, error)
+		switch e.Op {
+		case "AND", "OR":
+		case "=", "<>", "<", "<=", ">", ">=":
+			cmp := e.Op
+			op = func(a, b types.Value) (types.Value, error) { return compare(cmp, a, b) }
+		case "+", "-", "*", "/", "%":
+			ar := e.Op
+			op = func(a, b types.Value) (types.Value, error) { return arith(ar, a, b) }
+		case "||":
+			op = concat
+		default:
+			return rowwise(f, len(cols)), nil
+		}
+		l, err := sub(e.L)
+		if err != nil {
+			return nil, err
+		}
+		r, err := sub(e.R)
+		if err != nil {
+			return nil, err
+		}
+		if op == nil {
+			return logicVec(l, r, e.Op == "AND"), nil
+		}
+		return binaryVec(l, r, op), nil
+	}
+	return rowwise(f, len(cols)), nil
+}
+
+// grow は長さが n 以上のベクトルを返します。
+func grow(v []types.Value, n int) []types.Value {
+	if len(v) < n {
+		return make([]types.Value, n)
+	}
+	return v
+}
+
+// constVec はどの行でも同じ値になる式（定数と引数）を、バッチごとに1度だけ評価します。
+func constVec(f Expr) vecExpr {
+	var out []types.Value
+	return func(b *batch, sel []int) ([]types.Value, error) {
+		v, err := f(nil)
+		if err != nil {
+			return nil, err
+		}
+		out = grow(out, b.n)
+		for _, i := range sel {
+			out[i] = v
+		}
+		return out, nil
+	}
+}
+
+// mapVec は x の値それぞれに fn を適用します。
+func mapVec(x vecExpr, fn func(types.Value) types.Value) vecExpr {
+	var out []types.Value
+	return func(b *batch, sel []int) ([]types.Value, error) {
+		xv, err := x(b, sel)
+		if err != nil {
+			return nil, err
+		}
+		out = grow(out, b.n)
+		for _, i := range sel {
+			out[i] = fn(xv[i])
+		}
+		return out, nil
+	}
+}
+
+// binaryVec は l と r の値の組それぞれに op を適用します。
+func binaryVec(l, r vecExpr, op func(a, b types.Value) (types.Value, error)) vecExpr {
+	var out []types.Value
+	return func(b *batch, sel []int) ([]types.Value, error) {
+		lv, err := l(b, sel)
+		if err != nil {
+			return nil, err
+		}
+		rv, err := r(b, sel)
+		if err != nil {
+			return nil, err
+		}
+		out = grow(out, b.n)
+		for _, i := range sel {
+			if out[i], err = op(lv[i], rv[i]); err != nil {
+				return nil, err
+			}
+		}
+		return out, nil
+	}
+}
+
+// logicVec は AND（and が true）または OR を評価します。右は、左の値で結果が決まらない行だけ評価します。
+func logicVec(l, r vecExpr, and bool) vecExpr {
+	var out []types.Value
+	var rest []int
+	return func(b *batch, sel []int) ([]types.Value, error) {
+		lv, err := l(b, sel)
+		if err != nil {
+			return nil, err
+		}
+		out = grow(out, b.n)
+		rest = rest[:0]
+		for _, i := range sel {
+			if a := lv[i]; !a.IsNull() && Truth(a) != and {
+				out[i] = types.NewBool(!and)
+			} else {
+				rest = append(rest, i)
+			}
+		}
+		if len(rest) == 0 {
+			return out, nil
+		}
+		rv, err := r(b, rest)
+		if err != nil {
+			return nil, err
+		}
+		for _, i := range rest {
+			if and {
+				out[i] = and3(lv[i], rv[i])
+			} else {
+				out[i] = or3(lv[i], rv[i])
+			}
+		}
+		return out, nil
+	}
+}
+
+// rowwise はバッチの行を1つずつ組み立てて f で評価します。
+func rowwise(f Expr, ncols int) vecExpr {
+	var out []types.Value
+	row := make([]types.Value, ncols)
+	return func(b *batch, sel []int) ([]types.Value, error) {
+		out = grow(out, b.n)
+		for _, i := range sel {
+			for c := range row {
+				row[c] = b.vecs[c][i]
+			}
+			v, err := f(row)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = v
+		}
+		return out, nil
+	}
+}
@@ -0,0 +1,683 @@
+package exec
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/k-sml/go-rdbms/internal/sql/ast"
+	"github.com/k-sml/go-rdbms/internal/sql/lexer"
+	"github.com/k-sml/go-rdbms/internal/sql/parser"
+	"github.com/k-sml/go-rdbms/internal/types"
+)
+
+// 名前の解決と型の検査
+//
+// Bind は実行計画を作る前に文を調べ、テーブルと列の参照をカタログに対して解決し、式の型を
+// 推論して演算の項の型を検査する。誤りは文の中の位置を付けた BindError で返すので、
+// 「column x does not exist in table t at line 3, column 8」のように、どこを直せばよいかが分かる。
+//
+// 列の名前の見え方は実行計画を作るときと同じにする。FROM の列、副問い合わせから見える外側の
+// 問い合わせの列、WITH 句の CTE、ビューの中からは外側のものが見えないこと、ORDER BY の列の番号と
+// 結果の列の別名、集合演算の結果の列の名前は、どれも planner と同じ規則で解決する。
+// 型の検査も compiler と同じ規則で、型の分からない項（NULL、引数、外側の列など）は検査しない。
+// このため Bind を通った文の実行計画を作るときに、名前や型の誤りが新たに見つかることはない。
+//
+// 集約関数は結果の列、HAVING、ORDER BY の中でだけ呼び出せる。集約する文では、GROUP BY にない
+// 列を集約関数の外で参照していないかも planner と同じ書き換え（aggregate.go）で検査する。
+//
+// LIMIT と OFFSET の値、INSERT と UPDATE の列の並びは、これまでどおり実行計画を作るときに検査する。
+
+// BindError は名前の解決や型の検査で見つかった誤りです。Pos は誤りのある文の中の位置です。
+type BindError struct {
+	Pos lexer.Pos
+	Err error
+}
+
+func (e *BindError) Error() string {
+	if e.Pos.Line == 0 {
+		return e.Err.Error()
+	}
+	return fmt.Sprintf("%s at %s", e.Err, e.Pos)
+}
+
+func (e *BindError) Unwrap() error { return e.Err }
+
+// bindErrorf は位置 n の BindError を作ります。
+func bindErrorf(n ast.Node, format string, args ...any) error {
+	return &BindError{Pos: n.Pos(), Err: fmt.Errorf(format, args...)}
+}
+
+// Bind は文 stmt のテーブルと列の参照を src のカタログに対して解決し、式の型を検査します。
+// SELECT、EXPLAIN、INSERT、UPDATE、DELETE 以外の文では何もしません。
+func Bind(src Source, stmt ast.Stmt) error {
+	b := &binder{src: src, funcs: src.Settings().Funcs}
+	return b.stmt(stmt)
+}
+
+// ParamTypes は Bind と同じように文 stmt を調べ、n 個の引数の型を引数を使う場所から推論して
+// 返します。列や型の分かる式と比べる引数はその型、INSERT の値と UPDATE の SET の引数は代入する列の
+// 型、関数の引数は宣言した型、CAST の引数は変換先の型、LIMIT と OFFSET は BIGINT になります。
+// 推論できない引数の型は types.Null です。
+func ParamTypes(src Source, stmt ast.Stmt, n int) ([]types.Type, error) {
+	b := &binder{src: src, funcs: src.Settings().Funcs, params: make([]types.Type, n)}
+	if err := b.stmt(stmt); err != nil {
+		return nil, err
+	}
+	return b.params, nil
+}
+
+// stmt は文 stmt を調べます。
+func (b *binder) stmt(stmt ast.Stmt) error {
+	switch s := stmt.(type) {
+	case *ast.Select:
+		_, err := b.selectStmt(s, nil)
+		return err
+	case *ast.Explain:
+		if sel, ok := s.Stmt.(*ast.Select); ok {
+			_, err := b.selectStmt(sel, nil)
+			return err
+		}
+	case *ast.Insert:
+		return b.insert(s)
+	case *ast.Update:
+		return b.update(s)
+	case *ast.Delete:
+		return b.delete(s)
+	}
+	return nil
+}
+
+type binder struct {
+	src   Source
+	funcs *Funcs
+	ctes  map[string]*bindCTE
+	depth int  // 展開しているビューの深さ
+	agg   bool // 集約関数を呼び出せる
+
+	params []types.Type // ParamTypes が推論する引数の型
+}
+
+// hint は e が引数で型をまだ推論していなければ、その型を t にします。
+func (b *binder) hint(e ast.Expr, t types.Type) {
+	if p, ok := e.(*ast.Param); ok && p.Index <= len(b.params) && b.params[p.Index-1] == types.Null {
+		b.params[p.Index-1] = t
+	}
+}
+
+// bindCTE は問い合わせから参照できる CTE の結果の列です。
+type bindCTE struct {
+	name string
+	cols []Column
+}
+
+// bindScope は式の中から見える列です。parent は副問い合わせの外側の問い合わせの列です。
+type bindScope struct {
+	cols   []Column
+	parent *bindScope
+}
+
+// selectStmt は SELECT 文 s を調べ、結果の列を返します。outer は s が副問い合わせなら
+// 外側の問い合わせの列です。
+func (b *binder) selectStmt(s *ast.Select, outer *bindScope) ([]Column, error) {
+	defer func(agg bool) { b.agg = agg }(b.agg)
+	b.agg = false
+	if s.With != nil {
+		saved := b.ctes
+		defer func() { b.ctes = saved }()
+		if err := b.with(s.With); err != nil {
+			return nil, err
+		}
+	}
+	if s.Compound != nil {
+		return b.compound(s, outer)
+	}
+	var in []Column
+	if s.From != nil {
+		var err error
+		if in, err = b.from(s.From, outer); err != nil {
+			return nil, err
+		}
+	}
+	sc := &bindScope{cols: in, parent: outer}
+	b.hint(s.Limit, types.BigInt)
+	b.hint(s.Offset, types.BigInt)
+	if s.Where != nil {
+		if err := b.expr(s.Where, sc); err != nil {
+			return nil, err
+		}
+	}
+
+	b.agg = true
+	var exprs []ast.Expr
+	var cols []Column
+	for _, item := range s.Columns {
+		if !item.Star {
+			if err := b.expr(item.Expr, sc); err != nil {
+				return nil, err
+			}
+			exprs = append(exprs, item.Expr)
+			cols = append(cols, outputColumn(item, in, b.funcs))
+			continue
+		}
+		n := len(exprs)
+		for _, c := range in {
+			if item.Table == "" || strings.EqualFold(c.Table, item.Table) {
+				exprs = append(exprs, &ast.ColumnRef{Table: c.Table, Column: c.Name})
+				cols = append(cols, c)
+			}
+		}
+		if len(exprs) == n && item.Table != "" {
+			return nil, bindErrorf(s, "missing FROM entry for table %s", item.Table)
+		}
+	}
+	b.agg = false
+	for _, e := range s.GroupBy {
+		k, err := orderExpr(e, exprs, cols, in)
+		if err != nil {
+			return nil, &BindError{Pos: e.Pos(), Err: err}
+		}
+		if err := b.expr(k, sc); err != nil {
+			return nil, err
+		}
+	}
+	b.agg = true
+	if s.Having != nil {
+		if err := b.expr(s.Having, sc); err != nil {
+			return nil, err
+		}
+	}
+	order := make([]ast.Expr, len(s.OrderBy))
+	for i, o := range s.OrderBy {
+		e, err := orderExpr(o.Expr, exprs, cols, in)
+		if err != nil {
+			return nil, &BindError{Pos: o.Expr.Pos(), Err: err}
+		}
+		if err := b.expr(e, sc); err != nil {
+			return nil, err
+		}
+		order[i] = e
+	}
+
+	g, err := newGrouping(s, exprs, cols, in, b.funcs)
+	if err != nil || g == nil {
+		return cols, err
+	}
+	for _, e := range slices.Concat(exprs, []ast.Expr{s.Having}, order) {
+		if _, err := g.rewrite(e); err != nil {
+			return nil, err
+		}
+	}
+	return cols, nil
+}
+
+// compound は集合演算の SELECT 文 s を調べ、結果の列を返します。
+func (b *binder) compound(s *ast.Select, outer *bindScope) ([]Column, error) {
+	c := s.Compound
+	lc, err := b.selectStmt(c.Left, outer)
+	if err != nil {
+		return nil, err
+	}
+	rc, err := b.selectStmt(c.Right, outer)
+	if err != nil {
+		return nil, err
+	}
+	if len(lc) != len(rc) {
+		return nil, bindErrorf(c.Right, "each %s query must have the same number of columns", c.Op)
+	}
+	cols := make([]Column, len(lc))
+	for i := range lc {
+		t := lc[i].Type
+		switch {
+		case t == types.Null:
+			t = rc[i].Type
+		case rc[i].Type != types.Null:
+			ct, ok := types.Common(t, rc[i].Type)
+			if !ok {
+				return nil, bindErrorf(c.Right, "%s types %s and %s cannot be matched", c.Op, t, rc[i].Type)
+			}
+			t = ct
+		}
+		cols[i] = Column{Name: lc[i].Name, Type: t}
+	}
+	sc := &bindScope{cols: cols, parent: outer}
+	for _, o := range s.OrderBy {
+		if lit, ok := o.Expr.(*ast.Literal); ok && lit.Value.Type().IsInteger() {
+			if n := lit.Value.Int(); n < 1 || n > int64(len(cols)) {
+				return nil, bindErrorf(lit, "ORDER BY term out of range: %d", n)
+			}
+			continue
+		}
+		if err := b.expr(o.Expr, sc); err != nil {
+			return nil, err
+		}
+	}
+	return cols, nil
+}
+
+// with は WITH 句の CTE を順に調べ、s の中から参照できるようにします。CTE の中からは、
+// 外側の問い合わせの列と、その CTE より後に定義した CTE は見えません。
+func (b *binder) with(w *ast.With) error {
+	env := maps.Clone(b.ctes)
+	if env == nil {
+		env = make(map[string]*bindCTE)
+	}
+	defined := make(map[string]bool)
+	for _, def := range w.CTEs {
+		name := strings.ToLower(def.Name)
+		if defined[name] {
+			return bindErrorf(def, "duplicate WITH table name: %s", def.Name)
+		}
+		defined[name] = true
+		b.ctes = env
+		cols, err := b.cteBody(def)
+		if err != nil {
+			return err
+		}
+		env = maps.Clone(env)
+		env[name] = &bindCTE{name: def.Name, cols: cols}
+	}
+	b.ctes = env
+	return nil
+}
+
+// cteBody は CTE def の問い合わせを調べ、CTE の列を返します。
+func (b *binder) cteBody(def *ast.CTE) ([]Column, error) {
+	in, err := b.selectStmt(def.Select, nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(def.Columns) > 0 && len(def.Columns) != len(in) {
+		return nil, bindErrorf(def, "WITH table %s has %d column names but the query returns %d columns", def.Name, len(def.Columns), len(in))
+	}
+	cols := make([]Column, len(in))
+	for i, col := range in {
+		cols[i] = Column{Table: def.Name, Name: col.Name, Type: col.Type}
+		if len(def.Columns) > 0 {
+			cols[i].Name = def.Columns[i]
+		}
+	}
+	if def.Recursive == nil {
+		return cols, nil
+	}
+	saved := b.ctes
+	b.ctes = maps.Clone(saved)
+	b.ctes[strings.ToLower(def.Name)] = &bindCTE{name: def.Name, cols: cols}
+	step, err := b.selectStmt(def.Recursive, nil)
+	b.ctes = saved
+	if err != nil {
+		return nil, err
+	}
+	if len(step) != len(cols) {
+		return nil, bindErrorf(def.Recursive, "recursive query of WITH table %s returns %d columns, expected %d", def.Name, len(step), len(cols))
+	}
+	return cols, nil
+}
+
+// from は FROM のテーブルを調べ、その列を返します。ON の条件の副問い合わせからは outer の列も見えます。
+func (b *binder) from(te ast.TableExpr, outer *bindScope) ([]Column, error) {
+	switch te := te.(type) {
+	case *ast.TableName:
+		return b.tableName(te)
+	case *ast.Join:
+		left, err := b.from(te.Left, outer)
+		if err != nil {
+			return nil, err
+		}
+		right, err := b.from(te.Right, outer)
+		if err != nil {
+			return nil, err
+		}
+		cols := append(append([]Column(nil), left...), right...)
+		if te.On != nil {
+			if err := b.expr(te.On, &bindScope{cols: cols, parent: outer}); err != nil {
+				return nil, err
+			}
+		}
+		return cols, nil
+	}
+	return nil, fmt.Errorf("unsupported table expression %T", te)
+}
+
+// tableName は CTE、ビュー、テーブルのいずれかの参照 tn を解決し、その列を返します。
+func (b *binder) tableName(tn *ast.TableName) ([]Column, error) {
+	alias := tn.Alias
+	if c, ok := b.ctes[strings.ToLower(tn.Name)]; ok {
+		if alias == "" {
+			alias = c.name
+		}
+		return renameTable(c.cols, alias), nil
+	}
+	if v, ok := b.src.View(tn.Name); ok {
+		if b.depth >= maxViewDepth {
+			return nil, bindErrorf(tn, "too many levels of views: %s", v.Name)
+		}
+		stmt, err := parser.Parse(v.Query)
+		if err != nil {
+			return nil, fmt.Errorf("view %s: %w", v.Name, err)
+		}
+		sel, ok := stmt.(*ast.Select)
+		if !ok {
+			return nil, bindErrorf(tn, "view %s is not a SELECT", v.Name)
+		}
+		// ビューの中からは、ビューを使う問い合わせの列や CTE は見えない
+		saved := b.ctes
+		b.ctes, b.depth = nil, b.depth+1
+		in, err := b.selectStmt(sel, nil)
+		b.ctes, b.depth = saved, b.depth-1
+		if err != nil {
+			return nil, fmt.Errorf("view %s: %w", v.Name, err)
+		}
+		if len(v.Columns) > 0 && len(v.Columns) != len(in) {
+			return nil, bindErrorf(tn, "view %s has %d column names but the query returns %d columns", v.Name, len(v.Columns), len(in))
+		}
+		if alias == "" {
+			alias = v.Name
+		}
+		cols := renameTable(in, alias)
+		for i := range v.Columns {
+			cols[i].Name = v.Columns[i]
+		}
+		return cols, nil
+	}
+	t, err := b.src.Table(tn.Name)
+	if err != nil {
+		return nil, &BindError{Pos: tn.Pos(), Err: err}
+	}
+	return TableColumns(t, alias), nil
+}
+
+// renameTable は列 in のテーブルの名前を table にした列を返します。
+func renameTable(in []Column, table string) []Column {
+	cols := make([]Column, len(in))
+	for i, c := range in {
+		cols[i] = Column{Table: table, Name: c.Name, Type: c.Type}
+	}
+	return cols
+}
+
+// insert は INSERT 文の値の式と問い合わせを調べます。
+func (b *binder) insert(s *ast.Insert) error {
+	t, err := b.src.Table(s.Table)
+	if err != nil {
+		return &BindError{Pos: s.Pos(), Err: err}
+	}
+	if s.Query != nil {
+		_, err := b.selectStmt(s.Query, nil)
+		return err
+	}
+	for _, row := range s.Rows {
+		for i, e := range row {
+			if err := b.expr(e, &bindScope{}); err != nil {
+				return err
+			}
+			switch {
+			case s.Columns == nil && i < len(t.Columns):
+				b.hint(e, t.Columns[i].Type)
+			case i < len(s.Columns):
+				if j, ok := t.Column(s.Columns[i]); ok {
+					b.hint(e, t.Columns[j].Type)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// update は UPDATE 文の SET の式と WHERE の条件を調べます。どちらも変更前の行の列を参照できます。
+func (b *binder) update(s *ast.Update) error {
+	t, err := b.src.Table(s.Table)
+	if err != nil {
+		return &BindError{Pos: s.Pos(), Err: err}
+	}
+	sc := &bindScope{cols: TableColumns(t, "")}
+	for _, a := range s.Set {
+		if err := b.expr(a.Value, sc); err != nil {
+			return err
+		}
+		if i, ok := t.Column(a.Column); ok {
+			b.hint(a.Value, t.Columns[i].Type)
+		}
+	}
+	if s.Where != nil {
+		return b.expr(s.Where, sc)
+	}
+	return nil
+}
+
+// delete は DELETE 文の WHERE の条件を調べます。
+func (b *binder) delete(s *ast.Delete) error {
+	t, err := b.src.Table(s.Table)
+	if err != nil {
+		return &BindError{Pos: s.Pos(), Err: err}
+	}
+	if s.Where != nil {
+		return b.expr(s.Where, &bindScope{cols: TableColumns(t, "")})
+	}
+	return nil
+}
+
+// expr は式 e の列の参照を sc の列に解決し、演算の項の型を検査します。
+func (b *binder) expr(e ast.Expr, sc *bindScope) error {
+	cols := sc.cols
+	switch e := e.(type) {
+	case *ast.ColumnRef:
+		return b.column(e, sc)
+	case *ast.Unary:
+		if err := b.expr(e.X, sc); err != nil {
+			return err
+		}
+		if t := TypeOf(e.X, cols, b.funcs); e.Op != "NOT" && t != types.Null && !t.IsNumeric() {
+			return bindErrorf(e, "cannot apply unary %s to %s", e.Op, t)
+		}
+	case *ast.Binary:
+		if err := b.exprs(sc, e.L, e.R); err != nil {
+			return err
+		}
+		lt, rt := TypeOf(e.L, cols, b.funcs), TypeOf(e.R, cols, b.funcs)
+		if e.Op != "AND" && e.Op != "OR" {
+			b.hint(e.L, rt)
+			b.hint(e.R, lt)
+		}
+		switch e.Op {
+		case "=", "<>", "<", "<=", ">", ">=":
+			if err := checkComparable(lt, rt); err != nil {
+				return &BindError{Pos: e.Pos(), Err: err}
+			}
+		case "+", "-", "*", "/", "%":
+			if lt != types.Null && !lt.IsNumeric() || rt != types.Null && !rt.IsNumeric() {
+				return bindErrorf(e, "cannot apply %s to %s and %s", e.Op, lt, rt)
+			}
+		}
+	case *ast.IsNull:
+		return b.expr(e.X, sc)
+	case *ast.Between:
+		if err := b.exprs(sc, e.X, e.Lo, e.Hi); err != nil {
+			return err
+		}
+		b.hints(e.X, cols, e.Lo, e.Hi)
+		return b.comparable(e.X, cols, e.Lo, e.Hi)
+	case *ast.InList:
+		if err := b.exprs(sc, append([]ast.Expr{e.X}, e.List...)...); err != nil {
+			return err
+		}
+		b.hints(e.X, cols, e.List...)
+		return b.comparable(e.X, cols, e.List...)
+	case *ast.Like:
+		for _, o := range []ast.Expr{e.X, e.Pattern, e.Escape} {
+			if o == nil {
+				continue
+			}
+			if err := b.expr(o, sc); err != nil {
+				return err
+			}
+			b.hint(o, types.Text)
+			if t := TypeOf(o, cols, b.funcs); t != types.Null && t != types.Text {
+				return bindErrorf(o, "cannot apply %s to %s", e.Op, t)
+			}
+		}
+	case *ast.Call:
+		return b.call(e, sc)
+	case *ast.Case:
+		return b.caseExpr(e, sc)
+	case *ast.Cast:
+		b.hint(e.X, e.Type)
+		return b.expr(e.X, sc)
+	case *ast.Subquery:
+		return b.subquery(e, e.Select, sc)
+	case *ast.InSubquery:
+		if err := b.expr(e.X, sc); err != nil {
+			return err
+		}
+		return b.subquery(e, e.Select, sc)
+	case *ast.Exists:
+		_, err := b.selectStmt(e.Select, sc)
+		return err
+	}
+	return nil
+}
+
+// exprs は式 es を順に調べます。
+func (b *binder) exprs(sc *bindScope, es ...ast.Expr) error {
+	for _, e := range es {
+		if err := b.expr(e, sc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// hints は x と others の式のうち、引数の型を比べる相手の式の型と推論します。
+func (b *binder) hints(x ast.Expr, cols []Column, others ...ast.Expr) {
+	xt := TypeOf(x, cols, b.funcs)
+	for _, o := range others {
+		b.hint(o, xt)
+		b.hint(x, TypeOf(o, cols, b.funcs))
+	}
+}
+
+// comparable は x と others の式の値を比較できるかを検査します。
+func (b *binder) comparable(x ast.Expr, cols []Column, others ...ast.Expr) error {
+	xt := TypeOf(x, cols, b.funcs)
+	for _, o := range others {
+		if err := checkComparable(xt, TypeOf(o, cols, b.funcs)); err != nil {
+			return &BindError{Pos: o.Pos(), Err: err}
+		}
+	}
+	return nil
+}
+
+// column は列の参照 ref を解決します。sc の列になければ、外側の問い合わせの列から探します。
+func (b *binder) column(ref *ast.ColumnRef, sc *bindScope) error {
+	for s := sc; s != nil; s = s.parent {
+		switch numMatches(s.cols, ref) {
+		case 0:
+			continue
+		case 1:
+			return nil
+		}
+		return bindErrorf(ref, "column reference %s is ambiguous", ast.FormatExpr(ref))
+	}
+	if ref.Table != "" {
+		for s := sc; s != nil; s = s.parent {
+			for _, c := range s.cols {
+				if strings.EqualFold(c.Table, ref.Table) {
+					return bindErrorf(ref, "column %s does not exist in table %s", ref.Column, ref.Table)
+				}
+			}
+		}
+		return bindErrorf(ref, "missing FROM entry for table %s", ref.Table)
+	}
+	// FROM のテーブルが1つなら、そのテーブルの名前を添える
+	table := ""
+	for _, c := range sc.cols {
+		if c.Table == "" || table != "" && !strings.EqualFold(c.Table, table) {
+			return bindErrorf(ref, "column %s does not exist", ref.Column)
+		}
+		table = c.Table
+	}
+	if table == "" {
+		return bindErrorf(ref, "column %s does not exist", ref.Column)
+	}
+	return bindErrorf(ref, "column %s does not exist in table %s", ref.Column, table)
+}
+
+// call は関数または集約関数の呼び出しを調べます。集約関数の引数の中では集約関数を呼び出せません。
+func (b *binder) call(e *ast.Call, sc *bindScope) error {
+	if a, ok := b.funcs.Aggregate(e.Name); ok {
+		if !b.agg {
+			return bindErrorf(e, "aggregate function %s is not allowed here", e.Name)
+		}
+		b.agg = false
+		defer func() { b.agg = true }()
+		if err := b.exprs(sc, e.Args...); err != nil {
+			return err
+		}
+		if err := checkAggregate(e, a, sc.cols, b.funcs); err != nil {
+			return &BindError{Pos: e.Pos(), Err: err}
+		}
+		b.hintArgs(e.Args, a.Args)
+		return nil
+	}
+	f, ok := b.funcs.Func(e.Name)
+	if !ok {
+		return bindErrorf(e, "no such function: %s", e.Name)
+	}
+	if err := b.exprs(sc, e.Args...); err != nil {
+		return err
+	}
+	if err := checkCall(e, f.MinArgs, f.MaxArgs, f.Args, sc.cols, b.funcs); err != nil {
+		return &BindError{Pos: e.Pos(), Err: err}
+	}
+	b.hintArgs(e.Args, f.Args)
+	return nil
+}
+
+// hintArgs は関数の引数 args の型を、宣言した型 decl と推論します。
+func (b *binder) hintArgs(args []ast.Expr, decl []types.Type) {
+	if len(decl) == 0 {
+		return
+	}
+	for i, a := range args {
+		b.hint(a, decl[min(i, len(decl)-1)])
+	}
+}
+
+// caseExpr は CASE 式を調べます。THEN と ELSE の式には共通の型が必要です。
+func (b *binder) caseExpr(e *ast.Case, sc *bindScope) error {
+	if _, err := caseType(e, sc.cols, b.funcs); err != nil {
+		return &BindError{Pos: e.Pos(), Err: err}
+	}
+	if e.Operand != nil {
+		if err := b.expr(e.Operand, sc); err != nil {
+			return err
+		}
+	}
+	for _, w := range e.Whens {
+		if err := b.exprs(sc, w.Cond, w.Result); err != nil {
+			return err
+		}
+		if e.Operand != nil {
+			if err := b.comparable(e.Operand, sc.cols, w.Cond); err != nil {
+				return err
+			}
+		}
+	}
+	if e.Else != nil {
+		return b.expr(e.Else, sc)
+	}
+	return nil
+}
+
+// subquery は値を1つ返す副問い合わせ s を調べます。n は副問い合わせの式です。
+func (b *binder) subquery(n ast.Node, s *ast.Select, sc *bindScope) error {
+	cols, err := b.selectStmt(s, sc)
+	if err != nil {
+		return err
+	}
+	if len(cols) != 1 {
+		return bindErrorf(n, "subquery returns %d columns, expected 1", len(cols))
+	}
+	return nil
+}
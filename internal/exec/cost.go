@@ -0,0 +1,307 @@
+package exec
+
+import (
+	"math"
+	"strings"
+
+	"github.com/k-sml/go-rdbms/internal/catalog"
+	"github.com/k-sml/go-rdbms/internal/types"
+)
+
+// 費用の見積もり
+//
+// 費用はページを順に読む手間を 1 とした単位で、PostgreSQL の既定の定数にならう。
+// テーブルの行の数とページの数は Source.Stats から、条件を満たす行の割合は列の統計情報から
+// 見積もる（selectivity.go）。実行計画を作るときは、テーブルをインデックスで読むか順に読むか、
+// 結合をどの方法で行うかを、この見積もりの費用が小さいほうに決める。
+//
+// Startup は最初の行を返すまでの費用、Total はすべての行を返すまでの費用である。
+// Sort やハッシュ表を作る結合は下の演算子を読み終えるまで行を返さないので Startup が大きく、
+// Limit はそれ以降の費用を読む行の割合だけ払う。
+
+const (
+	seqPageCost       = 1.0    // ページを順に読む費用
+	randomPageCost    = 4.0    // ページを飛び飛びに読む費用
+	cpuTupleCost      = 0.01   // 1行を処理する費用
+	cpuIndexTupleCost = 0.005  // インデックスの1項目を処理する費用
+	cpuOperatorCost   = 0.0025 // 式を1回評価する費用
+
+	defaultTableRows = 1000 // 統計情報のないテーブルの行の数
+	rowsPerPage      = 50   // 統計情報のないテーブルの1ページに入る行の数
+	workTableRows    = 100  // 再帰する CTE の1回の繰り返しで作る行の数
+	recursionDepth   = 10   // 再帰する CTE の繰り返しの回数
+
+	defaultSelectivity = 1.0 / 3 // 条件を満たす行の割合
+	eqSelectivity      = 0.005   // 等号の条件を満たす行の割合
+	semiSelectivity    = 0.5     // EXISTS などを満たす左の行の割合
+	groupSelectivity   = 0.1     // GROUP BY のグループの数の、集約する行の数に対する割合
+)
+
+// planCost は演算子の費用と行の数の見積もりです。
+type planCost struct {
+	Startup float64 // 最初の行を返すまでの費用
+	Total   float64 // すべての行を返すまでの費用
+	Rows    float64 // 返す行の数
+}
+
+// estimator は実行計画の費用を見積もります。
+type estimator struct {
+	src   Source
+	stats map[string]*catalog.TableStats // 小文字にしたテーブルの名前がキー
+	sel   map[Operator]float64           // Filter と結合の条件を満たす割合
+}
+
+func newEstimator(src Source) *estimator {
+	return &estimator{src: src, stats: make(map[string]*catalog.TableStats), sel: make(map[Operator]float64)}
+}
+
+// tableStats はテーブル t の統計情報を返します。Source が知らなければ、既定の大きさとします。
+func (e *estimator) tableStats(t *catalog.Table) *catalog.TableStats {
+	k := strings.ToLower(t.Name)
+	if s, ok := e.stats[k]; ok {
+		return s
+	}
+	s := e.src.Stats(t)
+	if s == nil {
+		s = &catalog.TableStats{Rows: defaultTableRows, Pages: defaultTableRows / rowsPerPage}
+	}
+	e.stats[k] = s
+	return s
+}
+
+// setSelectivity は op が下の行（結合では左右の行の組み合わせ）のうち sel の割合を返すことを覚えます。
+func (e *estimator) setSelectivity(op Operator, sel float64) { e.sel[op] = sel }
+
+func (e *estimator) selectivityOf(op Operator, def float64) float64 {
+	if s, ok := e.sel[op]; ok {
+		return s
+	}
+	return def
+}
+
+// cost は op の費用と行の数を見積もります。
+func (e *estimator) cost(op Operator) planCost {
+	switch o := op.(type) {
+	case *instrumented:
+		return e.cost(o.Operator)
+	case *Rename:
+		return e.cost(o.Operator)
+	case *SeqScan:
+		c := e.seqScanCost(o.table)
+		if o.workers > 0 {
+			// 並列に読むなら、ページと行をワーカーで等しく分ける
+			c.Total /= float64(o.workers)
+			c.Rows /= float64(o.workers)
+		}
+		return c
+	case *IndexScan:
+		return e.indexScanCost(o.table, o.index, e.indexSelectivity(o))
+	case *Filter:
+		in := e.cost(o.in)
+		sel := e.selectivityOf(o, defaultSelectivity)
+		return planCost{Startup: in.Startup, Total: in.Total + in.Rows*cpuOperatorCost, Rows: in.Rows * sel}
+	case *Project:
+		in := e.cost(o.in)
+		in.Total += in.Rows * cpuOperatorCost * float64(len(o.exprs))
+		return in
+	case *Limit:
+		in := e.cost(o.in)
+		rows := math.Max(in.Rows-float64(o.offset), 0)
+		if o.count >= 0 {
+			rows = math.Min(rows, float64(o.count))
+		}
+		total := in.Total
+		if in.Rows > 0 {
+			total = in.Startup + (in.Total-in.Startup)*math.Min((rows+float64(o.offset))/in.Rows, 1)
+		}
+		return planCost{Startup: in.Startup, Total: total, Rows: rows}
+	case *Sort:
+		return sortCost(e.cost(o.in), o.limit)
+	case *Distinct:
+		in := e.cost(o.in)
+		in.Total += in.Rows * cpuOperatorCost
+		return in
+	case *HashAggregate:
+		in := e.cost(o.in)
+		startup := in.Total + in.Rows*cpuOperatorCost*float64(len(o.keys)+len(o.calls))
+		rows := 1.0
+		if len(o.keys) > 0 {
+			rows = math.Max(math.Ceil(in.Rows*groupSelectivity), 1)
+		}
+		return planCost{Startup: startup, Total: startup + rows*cpuTupleCost, Rows: rows}
+	case *Values:
+		n := float64(len(o.rows))
+		return planCost{Total: n * cpuTupleCost, Rows: n}
+	case *NestedLoopJoin:
+		l, r := e.cost(o.left), e.cost(o.right)
+		sel := 1.0
+		if o.on != nil {
+			sel = e.selectivityOf(o, defaultSelectivity)
+		}
+		pairs := l.Rows * r.Rows
+		return joinCost(o.typ, l, r, r.Total, l.Total+pairs*cpuOperatorCost, pairs*sel)
+	case *HashJoin:
+		l, r := e.cost(o.left), e.cost(o.right)
+		build := r.Total + r.Rows*(cpuOperatorCost+cpuTupleCost) // ハッシュ表に入れる
+		probe := l.Total + l.Rows*cpuOperatorCost
+		return joinCost(o.typ, l, r, build, probe, l.Rows*r.Rows*e.selectivityOf(o, eqSelectivity))
+	case *MergeJoin:
+		l, r := sortCost(e.cost(o.sorted.in), -1), sortCost(e.cost(o.right), -1)
+		merge := l.Total - l.Startup + (l.Rows+r.Rows)*cpuOperatorCost
+		return joinCost(o.typ, l, r, l.Startup+r.Total, merge, l.Rows*r.Rows*e.selectivityOf(o, eqSelectivity))
+	case *IndexNestedLoopJoin:
+		l := e.cost(o.left)
+		keySel := e.prefixSelectivity(o.table, o.index, len(o.keys))
+		probe := e.indexScanCost(o.table, o.index, keySel)
+		r := planCost{Rows: float64(e.tableStats(o.table).Rows)}
+		rows := l.Rows * r.Rows * e.selectivityOf(o, keySel)
+		return joinCost(o.typ, l, r, 0, l.Total+l.Rows*probe.Total, rows)
+	case *SemiJoin:
+		l, r := e.cost(o.left), e.cost(o.right)
+		startup := r.Total + r.Rows*cpuOperatorCost
+		return planCost{Startup: startup, Total: startup + l.Total + l.Rows*cpuOperatorCost, Rows: l.Rows * semiSelectivity}
+	case *Append:
+		l, r := e.cost(o.left), e.cost(o.right)
+		return planCost{Startup: l.Startup, Total: l.Total + r.Total, Rows: l.Rows + r.Rows}
+	case *HashSetOp:
+		l, r := e.cost(o.left), e.cost(o.right)
+		startup := r.Total + r.Rows*cpuOperatorCost
+		rows := l.Rows
+		if o.op == Intersect {
+			rows = math.Min(l.Rows, r.Rows)
+		}
+		return planCost{Startup: startup, Total: startup + l.Total + l.Rows*cpuOperatorCost, Rows: rows}
+	case *Gather:
+		in := e.cost(o.workers[0])
+		rows := in.Rows * float64(len(o.workers))
+		return planCost{Startup: parallelSetupCost + in.Startup, Total: parallelSetupCost + in.Total + rows*parallelTupleCost, Rows: rows}
+	case *Materialize:
+		in := e.cost(o.buf.in)
+		return planCost{Startup: in.Total, Total: in.Total + in.Rows*cpuTupleCost, Rows: in.Rows}
+	case *RecursiveUnion:
+		a, s := e.cost(o.anchor), e.cost(o.step)
+		return planCost{Startup: a.Startup, Total: a.Total + recursionDepth*s.Total, Rows: a.Rows + recursionDepth*s.Rows}
+	case *WorkTableScan:
+		return planCost{Total: workTableRows * cpuTupleCost, Rows: workTableRows}
+	}
+	return planCost{}
+}
+
+// seqScanCost はテーブル t のすべてのページを順に読む費用です。
+func (e *estimator) seqScanCost(t *catalog.Table) planCost {
+	s := e.tableStats(t)
+	n := float64(s.Rows)
+	return planCost{Total: float64(s.Pages)*seqPageCost + n*cpuTupleCost, Rows: n}
+}
+
+// indexScanCost はテーブル t の sel の割合の行をインデックス ix で読む費用です。見つけた行ごとに
+// テーブルのページを読みます。PostgreSQL と同じく、行がページに散らばっていれば読むページの数を
+// Mackert と Lohman の式で見積もって飛び飛びに読む費用を払い、インデックスの先頭の列の相関係数が
+// 1 か -1 に近いほど、sel の割合のページを順に読む費用に近づけます。
+func (e *estimator) indexScanCost(t *catalog.Table, ix *catalog.Index, sel float64) planCost {
+	s := e.tableStats(t)
+	n := float64(s.Rows) * sel
+	pages, io := float64(s.Pages), 0.0
+	if n > 0 && pages > 0 {
+		maxIO := randomPageCost * math.Min(2*pages*n/(2*pages+n), pages)
+		minIO := randomPageCost + math.Max(math.Ceil(sel*pages)-1, 0)*seqPageCost
+		corr := 0.0
+		if i, ok := t.Column(ix.Columns[0]); ok {
+			if cs := s.Column(i); cs != nil {
+				corr = cs.Correlation
+			}
+		}
+		io = maxIO + corr*corr*(minIO-maxIO)
+	}
+	startup := randomPageCost // 木を根から葉まで降りる
+	return planCost{Startup: startup, Total: startup + n*(cpuIndexTupleCost+cpuTupleCost) + io, Rows: n}
+}
+
+// indexSelectivity は IndexScan が読む範囲に入る行の割合を見積もります。
+func (e *estimator) indexSelectivity(s *IndexScan) float64 {
+	b := s.bounds
+	if b == nil {
+		b = rangeBounds(s.r)
+	}
+	return e.boundsSelectivity(s.table, s.index, b)
+}
+
+// boundsSelectivity はテーブル t のインデックス ix の範囲 b に入る行の割合を見積もります。
+// 境界が準備した文の引数なら、値によらない割合を使います。
+func (e *estimator) boundsSelectivity(t *catalog.Table, ix *catalog.Index, b *indexBounds) float64 {
+	eq := 0
+	for eq < len(b.lo) && eq < len(b.hi) && b.lo[eq].param == b.hi[eq].param && types.Equal(b.lo[eq].v, b.hi[eq].v) {
+		eq++
+	}
+	sel := e.prefixSelectivity(t, ix, eq)
+	hasLo, hasHi := len(b.lo) > eq, len(b.hi) > eq
+	if !hasLo && !hasHi {
+		return sel
+	}
+	var lo, hi *types.Value
+	if hasLo && b.lo[eq].param == 0 {
+		lo = &b.lo[eq].v
+	}
+	if hasHi && b.hi[eq].param == 0 {
+		hi = &b.hi[eq].v
+	}
+	i, _ := t.Column(ix.Columns[eq])
+	s := columnInfo{e: e, table: t, i: i}.rangeSelectivity(lo, hi)
+	if hasLo && hasHi && (lo == nil || hi == nil) {
+		s = defaultSelectivity * defaultSelectivity // 両側のどちらかが引数
+	}
+	return math.Max(sel*s, 1/math.Max(float64(e.tableStats(t).Rows), 1))
+}
+
+// prefixSelectivity はインデックス ix の先頭の n 個の列が、それぞれある値と等しい行の割合を見積もります。
+func (e *estimator) prefixSelectivity(t *catalog.Table, ix *catalog.Index, n int) float64 {
+	rows := math.Max(float64(e.tableStats(t).Rows), 1)
+	if ix.Unique && n == len(ix.Columns) {
+		return 1 / rows
+	}
+	sel := 1.0
+	for _, name := range ix.Columns[:n] {
+		i, _ := t.Column(name)
+		sel *= columnInfo{e: e, table: t, i: i}.eqSelectivity()
+	}
+	return math.Max(sel, 1/rows)
+}
+
+// rangeBounds は値の決まった IndexRange を indexBounds にします。
+func rangeBounds(r IndexRange) *indexBounds {
+	b := &indexBounds{loOpen: r.LoOpen, hiOpen: r.HiOpen}
+	for _, v := range r.Lo {
+		b.lo = append(b.lo, bound{v: v})
+	}
+	for _, v := range r.Hi {
+		b.hi = append(b.hi, bound{v: v})
+	}
+	return b
+}
+
+// sortCost は in の行を並べ替える費用を見積もります。limit が負でなければ、上位 limit 行だけを保持します。
+func sortCost(in planCost, limit int64) planCost {
+	n := in.Rows
+	k := n
+	if limit >= 0 {
+		k = math.Min(n, float64(limit))
+	}
+	cmp := 0.0
+	if n > 1 && k > 1 {
+		cmp = 2 * cpuOperatorCost * n * math.Log2(k)
+	}
+	startup := in.Total + cmp
+	return planCost{Startup: startup, Total: startup + n*cpuTupleCost, Rows: n}
+}
+
+// joinCost は結合の見積もりを作ります。startup は最初の行を返すまでの費用、run はその後に
+// 左の行を読んで組み合わせる費用、rows は条件を満たす組み合わせの数です。
+func joinCost(typ JoinType, l, r planCost, startup, run, rows float64) planCost {
+	switch typ {
+	case LeftJoin:
+		rows = math.Max(rows, l.Rows)
+	case FullJoin:
+		rows = math.Max(rows, l.Rows+r.Rows)
+	}
+	return planCost{Startup: startup, Total: startup + run + rows*cpuTupleCost, Rows: rows}
+}
@@ -0,0 +1,328 @@
+package exec
+
+import (
+	"fmt"
+	"maps"
+	"strings"
+
+	"github.com/k-sml/go-rdbms/internal/sql/ast"
+	"github.com/k-sml/go-rdbms/internal/types"
+)
+
+// WITH 句
+//
+// WITH 句で名前を付けた問い合わせ（CTE）は、FROM でその名前を参照したところで実行計画を作る。
+// 参照が1つなら、ビューと同じように参照したところに問い合わせを埋め込む。2つ以上なら、
+// 最初に読まれたときに結果をメモリに保存し（Materialize）、どの参照もそれを読む。
+// AS MATERIALIZED と AS NOT MATERIALIZED で、どちらにするかを指定できる。
+//
+// WITH RECURSIVE の CTE は RecursiveUnion で実行する。UNION の前の SELECT の結果を
+// 作業用のテーブル（WorkTable）に置き、UNION の後の SELECT の中の自分自身への参照で
+// それを読んで次の行を作ることを、新しい行ができなくなるまで繰り返す。
+// UNION ALL でなければ、それまでに返した行と同じ行は捨てる。
+
+// cte は問い合わせから参照できる CTE です。
+type cte struct {
+	def    *ast.CTE
+	env    map[string]*cte // CTE の中から参照できる CTE
+	shared bool            // 結果を保存して参照の間で共有する
+	mat    *Materialize    // 共有する結果。最初の参照で作る
+	work   *WorkTable      // 再帰の中の自分自身への参照なら作業用のテーブル
+}
+
+// with は s の WITH 句の CTE を、s の中から参照できるようにします。
+func (p *planner) with(s *ast.Select) error {
+	env := maps.Clone(p.ctes)
+	if env == nil {
+		env = make(map[string]*cte)
+	}
+	defined := make(map[string]bool)
+	for _, def := range s.With.CTEs {
+		name := strings.ToLower(def.Name)
+		if defined[name] {
+			return fmt.Errorf("duplicate WITH table name: %s", def.Name)
+		}
+		defined[name] = true
+		c := &cte{def: def, env: maps.Clone(env)}
+		switch def.Materialize {
+		case ast.Materialized:
+			c.shared = true
+		case ast.MaterializeDefault:
+			n := references(s, def.Name)
+			if def.Recursive != nil {
+				n -= references(def.Recursive, def.Name)
+			}
+			c.shared = n > 1
+		}
+		env[name] = c
+	}
+	p.ctes = env
+	return nil
+}
+
+// references は s の中で name という名前のテーブルを参照している数を返します。
+func references(s *ast.Select, name string) int {
+	n := 0
+	ast.Subqueries(s, func(sub *ast.Select) {
+		ast.Tables(sub.From, func(tn *ast.TableName) {
+			if strings.EqualFold(tn.Name, name) {
+				n++
+			}
+		})
+	})
+	return n
+}
+
+// cte は FROM のテーブルの名前 name が CTE なら、それを返します。
+func (p *planner) cte(name string) (*cte, bool) {
+	c, ok := p.ctes[strings.ToLower(name)]
+	return c, ok
+}
+
+// cteScan は CTE c を読む演算子を返します。
+func (p *planner) cteScan(c *cte, alias string) (Operator, error) {
+	var op Operator
+	switch {
+	case c.work != nil:
+		op = NewWorkTableScan(c.work)
+	case c.mat != nil:
+		op = c.mat.Share()
+	default:
+		var err error
+		if op, err = p.cteBody(c); err != nil {
+			return nil, err
+		}
+		if c.shared {
+			c.mat = NewMaterialize(op, p.params)
+			op = c.mat
+		}
+	}
+	p.note(op, "CTE: %s", c.def.Name)
+	if alias == "" {
+		alias = c.def.Name
+	}
+	in := op.Columns()
+	cols := make([]Column, len(in))
+	for i, col := range in {
+		cols[i] = Column{Table: alias, Name: col.Name, Type: col.Type}
+	}
+	return NewRename(op, cols), nil
+}
+
+// cteBody は CTE c の問い合わせの実行計画を作ります。CTE の中からは、外側の問い合わせの列と、
+// c より後に定義した CTE は見えません。
+func (p *planner) cteBody(c *cte) (Operator, error) {
+	savedCTEs, savedScope := p.ctes, p.scope
+	p.ctes, p.scope = c.env, nil
+	defer func() { p.ctes, p.scope = savedCTEs, savedScope }()
+
+	op, err := p.selectStmt(c.def.Select)
+	if err != nil {
+		return nil, err
+	}
+	in := op.Columns()
+	if len(c.def.Columns) > 0 && len(c.def.Columns) != len(in) {
+		return nil, fmt.Errorf("WITH table %s has %d column names but the query returns %d columns", c.def.Name, len(c.def.Columns), len(in))
+	}
+	cols := make([]Column, len(in))
+	for i, col := range in {
+		cols[i] = Column{Table: c.def.Name, Name: col.Name, Type: col.Type}
+		if len(c.def.Columns) > 0 {
+			cols[i].Name = c.def.Columns[i]
+		}
+	}
+	op = NewRename(op, cols)
+	if c.def.Recursive == nil {
+		return op, nil
+	}
+
+	work := NewWorkTable(cols)
+	p.ctes = maps.Clone(c.env)
+	p.ctes[strings.ToLower(c.def.Name)] = &cte{def: c.def, work: work}
+	step, err := p.selectStmt(c.def.Recursive)
+	if err != nil {
+		return nil, err
+	}
+	if n := len(step.Columns()); n != len(cols) {
+		return nil, fmt.Errorf("recursive query of WITH table %s returns %d columns, expected %d", c.def.Name, n, len(cols))
+	}
+	return NewRecursiveUnion(op, step, work, c.def.UnionAll), nil
+}
+
+// Materialize は下の演算子の行を最初に Open したときにすべて読んでメモリに保存し、
+// それを返します。Share で作った Materialize は、保存した行を共有します。
+type Materialize struct {
+	buf *materialized
+	i   int
+}
+
+type materialized struct {
+	in     Operator
+	params *Params
+	rows   [][]types.Value
+	done   bool
+	gen    uint64 // 行を保存したときの params の世代
+}
+
+// NewMaterialize は in の行を保存する Materialize を作ります。params を Bind し直したら、
+// 次に Open したときに読み直します。params は nil でもかまいません。
+func NewMaterialize(in Operator, params *Params) *Materialize {
+	return &Materialize{buf: &materialized{in: in, params: params}}
+}
+
+// Share は m と同じ行を読む Materialize を作ります。
+func (m *Materialize) Share() *Materialize { return &Materialize{buf: m.buf} }
+
+func (m *Materialize) Columns() []Column { return m.buf.in.Columns() }
+
+func (m *Materialize) Open() error {
+	m.i = 0
+	return m.buf.fill()
+}
+
+func (b *materialized) fill() error {
+	if b.done && b.gen == b.params.generation() {
+		return nil
+	}
+	b.rows, b.done, b.gen = nil, false, b.params.generation()
+	if err := b.in.Open(); err != nil {
+		b.in.Close()
+		return err
+	}
+	for {
+		row, ok, err := b.in.Next()
+		if err != nil {
+			b.in.Close()
+			b.rows = nil
+			return err
+		}
+		if !ok {
+			break
+		}
+		b.rows = append(b.rows, append([]types.Value(nil), row...))
+	}
+	b.done = true
+	return b.in.Close()
+}
+
+func (m *Materialize) Next() ([]types.Value, bool, error) {
+	if m.i >= len(m.buf.rows) {
+		return nil, false, nil
+	}
+	m.i++
+	return m.buf.rows[m.i-1], true, nil
+}
+
+func (m *Materialize) Close() error { return nil }
+
+// WorkTable は再帰する CTE の作業用のテーブルです。RecursiveUnion が直前の繰り返しで
+// 作った行を置きます。
+type WorkTable struct {
+	cols []Column
+	rows [][]types.Value
+}
+
+// NewWorkTable は列が cols の空の WorkTable を作ります。
+func NewWorkTable(cols []Column) *WorkTable { return &WorkTable{cols: cols} }
+
+// WorkTableScan は WorkTable の行を読みます。
+type WorkTableScan struct {
+	t *WorkTable
+	i int
+}
+
+// NewWorkTableScan は t を読む WorkTableScan を作ります。
+func NewWorkTableScan(t *WorkTable) *WorkTableScan { return &WorkTableScan{t: t} }
+
+func (s *WorkTableScan) Columns() []Column { return s.t.cols }
+func (s *WorkTableScan) Close() error      { return nil }
+
+func (s *WorkTableScan) Open() error {
+	s.i = 0
+	return nil
+}
+
+func (s *WorkTableScan) Next() ([]types.Value, bool, error) {
+	if s.i >= len(s.t.rows) {
+		return nil, false, nil
+	}
+	s.i++
+	return s.t.rows[s.i-1], true, nil
+}
+
+// RecursiveUnion は再帰する CTE の行を返します。anchor の行を返した後、直前に返した行を
+// work に置いて step を実行することを、step が新しい行を返さなくなるまで繰り返します。
+// all が false なら、すでに返した行と同じ行は返しません。
+type RecursiveUnion struct {
+	anchor, step Operator
+	work         *WorkTable
+	all          bool
+
+	in   Operator // 読んでいる演算子。読み終えたら nil
+	next [][]types.Value
+	seen map[string]struct{}
+	key  []byte
+}
+
+// NewRecursiveUnion は RecursiveUnion を作ります。step は work を読む演算子です。
+func NewRecursiveUnion(anchor, step Operator, work *WorkTable, all bool) *RecursiveUnion {
+	return &RecursiveUnion{anchor: anchor, step: step, work: work, all: all}
+}
+
+func (r *RecursiveUnion) Columns() []Column { return r.anchor.Columns() }
+
+func (r *RecursiveUnion) Open() error {
+	r.work.rows, r.next = nil, nil
+	r.seen = make(map[string]struct{})
+	r.in = r.anchor
+	return r.anchor.Open()
+}
+
+func (r *RecursiveUnion) Next() ([]types.Value, bool, error) {
+	for r.in != nil {
+		row, ok, err := r.in.Next()
+		if err != nil {
+			return nil, false, err
+		}
+		if ok {
+			if !r.all {
+				r.key = r.key[:0]
+				for _, v := range row {
+					r.key = types.AppendKey(r.key, v)
+				}
+				if _, dup := r.seen[string(r.key)]; dup {
+					continue
+				}
+				r.seen[string(r.key)] = struct{}{}
+			}
+			row = append([]types.Value(nil), row...)
+			r.next = append(r.next, row)
+			return row, true, nil
+		}
+		in := r.in
+		r.in = nil
+		if err := in.Close(); err != nil {
+			return nil, false, err
+		}
+		if len(r.next) == 0 {
+			break
+		}
+		r.work.rows, r.next = r.next, nil
+		if err := r.step.Open(); err != nil {
+			r.step.Close()
+			return nil, false, err
+		}
+		r.in = r.step
+	}
+	return nil, false, nil
+}
+
+func (r *RecursiveUnion) Close() error {
+	r.work.rows, r.next, r.seen = nil, nil, nil
+	if r.in == nil {
+		return nil
+	}
+	in := r.in
+	r.in = nil
+	return in.Close()
+}
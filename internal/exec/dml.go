@@ -0,0 +1,100 @@
+package exec
+
+import (
+	"strings"
+
+	"github.com/k-sml/go-rdbms/internal/catalog"
+	"github.com/k-sml/go-rdbms/internal/sql/ast"
+	"github.com/k-sml/go-rdbms/internal/sql/parser"
+	"github.com/k-sml/go-rdbms/internal/storage"
+	"github.com/k-sml/go-rdbms/internal/types"
+)
+
+// Target は UPDATE や DELETE で変更する行です。
+type Target struct {
+	RID storage.RID
+	Row []types.Value
+}
+
+// TargetScan は UPDATE や DELETE で変更する行を集める実行計画です。
+type TargetScan struct {
+	scan rowScan
+	cond Expr
+}
+
+// PlanTargets はテーブル t の行のうち where を満たすものを集める実行計画を作ります。
+// where が nil ならすべての行です。SELECT と同じように、where で読む範囲を絞れる
+// インデックスがあれば使います。where の中の引数は params から読みます。
+func PlanTargets(src Source, t *catalog.Table, where ast.Expr, params *Params) (*TargetScan, error) {
+	p := newPlanner(src, params)
+	ts := &TargetScan{scan: p.scan(t, t.Name, where)}
+	if where != nil {
+		var err error
+		if ts.cond, err = p.compile(where, ts.scan.Columns()); err != nil {
+			return nil, err
+		}
+	}
+	return ts, nil
+}
+
+// Collect は変更する行を集めます。何度でも呼べます。
+//
+// 行を変更する前にすべて読み終えるので、変更した行がまだ読んでいない位置に移っても
+// 二度読むことはありません。
+func (ts *TargetScan) Collect() ([]Target, error) {
+	if err := ts.scan.Open(); err != nil {
+		return nil, err
+	}
+	defer ts.scan.Close()
+	var out []Target
+	for {
+		row, ok, err := ts.scan.Next()
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return out, nil
+		}
+		if ts.cond != nil {
+			v, err := ts.cond(row)
+			if err != nil {
+				return nil, err
+			}
+			if !Truth(v) {
+				continue
+			}
+		}
+		out = append(out, Target{RID: ts.scan.RID(), Row: append([]types.Value(nil), row...)})
+	}
+}
+
+// Reads は問い合わせ s がテーブル table を読むかを返します。副問い合わせ、CTE、ビューの
+// 問い合わせの中も調べます。同じ名前の CTE も、テーブルを読むものとみなします。
+//
+// INSERT ... SELECT で挿入先のテーブルを読むなら、挿入した行を問い合わせが読まないように、
+// 結果の行をすべて読んでから挿入します。
+func Reads(src Source, s *ast.Select, table string) bool {
+	return reads(src, s, table, 0)
+}
+
+func reads(src Source, s *ast.Select, table string, depth int) bool {
+	found := false
+	ast.Subqueries(s, func(sub *ast.Select) {
+		ast.Tables(sub.From, func(tn *ast.TableName) {
+			if found || strings.EqualFold(tn.Name, table) {
+				found = true
+				return
+			}
+			v, ok := src.View(tn.Name)
+			if !ok || depth >= maxViewDepth {
+				return
+			}
+			if stmt, err := parser.Parse(v.Query); err == nil {
+				if sel, ok := stmt.(*ast.Select); ok {
+					found = reads(src, sel, table, depth+1)
+				}
+			}
+		})
+	})
+	return found
+}
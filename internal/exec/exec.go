@@ -0,0 +1,113 @@
+// Package exec は SQL の問い合わせを実行します。
+//
+// 実行計画は演算子（Operator）の木で、各演算子は Open で読み始め、Next で1行ずつ
+// 返し、Close で後始末をします（ボルケーノ方式）。上の演算子が下の演算子の Next を
+// 呼んで行を引き出すので、LIMIT のように途中で止めれば下の演算子もそれ以上読みません。
+// テーブルを順に読む演算子とその上の条件や列の計算は、行をバッチにまとめて受け渡します。
+// 大きなテーブルを順に読んで条件で絞る部分は、複数のゴルーチンで分けて実行することもあります。
+//
+// テーブルとインデックスは Source を通して読みます。Source はトランザクションの
+// ロックや行のデコードを受け持つ engine パッケージが実装します。
+package exec
+
+import (
+	"github.com/k-sml/go-rdbms/internal/catalog"
+	"github.com/k-sml/go-rdbms/internal/storage"
+	"github.com/k-sml/go-rdbms/internal/types"
+)
+
+// Column は演算子が返す行の列です。
+type Column struct {
+	Table string     // 列を持つテーブルの名前（別名があれば別名）。式の列なら空
+	Name  string     // 列の名前
+	Type  types.Type // 列の型。式の列で型が決まらなければ types.Null
+}
+
+// Operator は実行計画の演算子です。
+type Operator interface {
+	// Columns は Next が返す行の列です。
+	Columns() []Column
+	// Open は行を読み始めます。Close の後でもう一度 Open すると、最初から読み直します。
+	Open() error
+	// Next は次の行を返します。最後まで読むと ok が false になります。
+	// 返した行は次の Next の呼び出しまで有効です。
+	Next() (row []types.Value, ok bool, err error)
+	// Close は読むのをやめ、使っていた資源を解放します。
+	Close() error
+}
+
+// RowIter はテーブルの行を1つずつ返すカーソルです。
+type RowIter interface {
+	Next() (rid storage.RID, row []types.Value, ok bool, err error)
+}
+
+// BatchIter は行をまとめて読めるカーソルです。SeqScan は、ScanTable が返した RowIter が
+// BatchIter も実装していれば、バッチで読むときにこちらを使います。
+type BatchIter interface {
+	// NextBatch は最大 max 行を読み、i 番目の行の列 c の値を vecs[c][i] に書きます。
+	// 読んだ行の数を返し、最後まで読むと 0 を返します。
+	NextBatch(vecs [][]types.Value, max int) (int, error)
+}
+
+// IndexRange はインデックスを読む範囲です。Lo と Hi はインデックスの先頭からの列の値で、
+// 列の型に変換しておきます。nil なら端まで読みます。
+type IndexRange struct {
+	Lo, Hi         []types.Value
+	LoOpen, HiOpen bool // 境界の値を含まない
+}
+
+// Source は実行器がテーブルを読む窓口です。
+type Source interface {
+	// Table はテーブル（情報スキーマの仮想テーブルを含む）の定義を返します。
+	Table(name string) (*catalog.Table, error)
+	// View はビューの定義を返します。
+	View(name string) (*catalog.View, bool)
+	// Indexes はテーブルのインデックスを返します。
+	Indexes(table string) []*catalog.Index
+	// ScanTable はテーブルのすべての行を格納順に読むカーソルを返します。cols が nil でなければ、
+	// cols[i] が false の列の値は読まずに NULL にします。
+	ScanTable(t *catalog.Table, cols []bool) (RowIter, error)
+	// ScanIndex はインデックスの範囲の行をインデックスの順に読むカーソルを返します。
+	// cols は ScanTable と同じです。
+	ScanIndex(ix *catalog.Index, r IndexRange, cols []bool) (RowIter, error)
+	// ScanParts はテーブルの行を分け合って読む、最大 n 個のカーソルを返します。各カーソルは
+	// 別々のゴルーチンから使え、すべてのカーソルを合わせるとすべての行を1回ずつ返します。
+	// cols は ScanTable と同じです。
+	ScanParts(t *catalog.Table, cols []bool, n int) ([]RowIter, error)
+	// Stats はテーブルの統計情報を返します。わからなければ nil です。
+	Stats(t *catalog.Table) *catalog.TableStats
+	// Settings は実行計画の作り方の設定を返します。
+	Settings() Settings
+}
+
+// Settings は実行計画の作り方の設定です。
+type Settings struct {
+	// BatchSize は、バッチで実行する演算子がまとめて処理する行の数の上限です（batch.go）。
+	// 1 以下なら、すべての演算子が1行ずつ実行します。
+	BatchSize int
+	// Parallel は、1つのテーブルを順に読む演算子を分けて並列に実行するゴルーチンの数の上限です
+	// （gather.go）。1 以下なら並列に実行しません。
+	Parallel int
+	// Funcs は SQL から呼び出せる、データベースに登録した関数です。nil なら組み込みの関数だけを
+	// 使えます。
+	Funcs *Funcs
+}
+
+// Collect は op のすべての行を読んで返します。
+func Collect(op Operator) ([][]types.Value, error) {
+	if err := op.Open(); err != nil {
+		return nil, err
+	}
+	defer op.Close()
+	var rows [][]types.Value
+	for {
+		row, ok, err := op.Next()
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return rows, nil
+		}
+		rows = append(rows, append([]types.Value(nil), row...))
+	}
+}
@@ -0,0 +1,471 @@
+package exec
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/k-sml/go-rdbms/internal/sql/ast"
+	"github.com/k-sml/go-rdbms/internal/types"
+)
+
+// 実行計画の表示
+//
+// EXPLAIN は SELECT 文の実行計画を作り、演算子の木を1行に1つずつ、下の演算子ほど深く字下げして
+// 表示する。各演算子には見積もった費用と行の数（cost.go）を添え、Filter の条件や結合の条件の
+// ように式の木からしかわからない説明は、実行計画を作るときに planNotes に書き留めておく。
+// 式の中の副問い合わせの実行計画は、本体の木の後に SubPlan として表示する。
+//
+// EXPLAIN ANALYZE は、すべての演算子を行の数と時間を数える instrumented で包んでから問い合わせを
+// 最後まで実行し、見積もりの横に実際の値を表示する。時間は下の演算子の時間を含み、loops は
+// 演算子を Open した回数（相関する副問い合わせや結合の右側では外側の行の数）である。
+
+// planNotes は EXPLAIN のために実行計画を作るときに書き留める説明です。
+type planNotes struct {
+	details  map[Operator][]string
+	subplans []*subquery
+}
+
+// note は EXPLAIN のときに op に添えて表示する説明を書き留めます。
+func (p *planner) note(op Operator, format string, args ...any) {
+	if p.notes == nil || op == nil {
+		return
+	}
+	p.notes.details[op] = append(p.notes.details[op], fmt.Sprintf(format, args...))
+}
+
+// noteSortKeys は Sort のキーを書き留めます。
+func (p *planner) noteSortKeys(s *Sort, order []ast.OrderItem) {
+	if p.notes == nil {
+		return
+	}
+	keys := make([]string, len(order))
+	for i, o := range order {
+		keys[i] = ast.FormatExpr(o.Expr)
+		if o.Desc {
+			keys[i] += " DESC"
+		}
+	}
+	p.note(s, "Sort Key: %s", strings.Join(keys, ", "))
+}
+
+// addSubplan は式の中の副問い合わせを書き留めます。
+func (n *planNotes) addSubplan(q *subquery) {
+	if n != nil {
+		n.subplans = append(n.subplans, q)
+	}
+}
+
+// mark は書き留めた副問い合わせの数を返します。使わなかった実行計画を reset で取り消すのに使います。
+func (n *planNotes) mark() int {
+	if n == nil {
+		return 0
+	}
+	return len(n.subplans)
+}
+
+// reset は mark の後に書き留めた副問い合わせを取り消します。
+func (n *planNotes) reset(mark int) {
+	if n != nil {
+		n.subplans = n.subplans[:mark]
+	}
+}
+
+// drop は from から to までに書き留めた副問い合わせを取り消します。
+func (n *planNotes) drop(from, to int) {
+	if n != nil {
+		n.subplans = append(n.subplans[:from], n.subplans[to:]...)
+	}
+}
+
+// Explain は EXPLAIN [ANALYZE] の結果を "QUERY PLAN" という1つの列の行として返します。
+type Explain struct {
+	root    Operator
+	notes   *planNotes
+	est     *estimator
+	analyze bool
+	out     *Values
+}
+
+// PlanExplain は SELECT 文 s の実行計画を作り、それを表示する Explain を返します。analyze なら、
+// Open するたびに問い合わせを実行して実際の行の数と時間も表示します。
+func PlanExplain(src Source, s *ast.Select, analyze bool, params *Params) (*Explain, error) {
+	notes := &planNotes{details: make(map[Operator][]string)}
+	p := newPlanner(src, params)
+	p.notes = notes
+	p.uses = newColumnUse()
+	p.uses.add(s)
+	root, err := p.selectStmt(s)
+	if err != nil {
+		return nil, err
+	}
+	e := &Explain{root: root, notes: notes, est: p.est, analyze: analyze}
+	if analyze {
+		instrument(&e.root)
+		for _, q := range notes.subplans {
+			instrument(&q.op)
+		}
+	}
+	e.out = NewValues([]Column{{Name: "QUERY PLAN", Type: types.Text}}, nil)
+	return e, nil
+}
+
+func (e *Explain) Columns() []Column { return e.out.Columns() }
+
+func (e *Explain) Open() error {
+	var elapsed time.Duration
+	if e.analyze {
+		visit(e.root, func(in *instrumented) { in.reset() })
+		for _, q := range e.notes.subplans {
+			visit(q.op, func(in *instrumented) { in.reset() })
+		}
+		start := time.Now()
+		if err := drain(e.root); err != nil {
+			return err
+		}
+		elapsed = time.Since(start)
+	}
+	w := &planWriter{notes: e.notes, est: e.est, analyze: e.analyze, shared: make(map[*materialized]bool)}
+	w.node(e.root, 0, false)
+	for i, q := range e.notes.subplans {
+		name := fmt.Sprintf("SubPlan %d", i+1)
+		if q.correlated() {
+			name += " (correlated)"
+		}
+		w.line(0, name)
+		w.node(q.op, 2, true)
+	}
+	if e.analyze {
+		w.line(0, fmt.Sprintf("Execution Time: %.3f ms", float64(elapsed)/float64(time.Millisecond)))
+	}
+	e.out.rows = w.rows
+	return e.out.Open()
+}
+
+func (e *Explain) Next() ([]types.Value, bool, error) { return e.out.Next() }
+func (e *Explain) Close() error                       { return e.out.Close() }
+
+// drain は op を開いてすべての行を読み捨てます。
+func drain(op Operator) error {
+	if err := op.Open(); err != nil {
+		op.Close()
+		return err
+	}
+	for {
+		_, ok, err := op.Next()
+		if err != nil {
+			op.Close()
+			return err
+		}
+		if !ok {
+			return op.Close()
+		}
+	}
+}
+
+// children は op の下の演算子を指すポインタを返します。instrument はこれを書き換えて演算子を包みます。
+func children(op Operator) []*Operator {
+	switch o := op.(type) {
+	case *Filter:
+		return []*Operator{&o.in}
+	case *Project:
+		return []*Operator{&o.in}
+	case *Limit:
+		return []*Operator{&o.in}
+	case *Sort:
+		return []*Operator{&o.in}
+	case *Distinct:
+		return []*Operator{&o.in}
+	case *HashAggregate:
+		return []*Operator{&o.in}
+	case *Rename:
+		return []*Operator{&o.Operator}
+	case *NestedLoopJoin:
+		return []*Operator{&o.left, &o.right}
+	case *HashJoin:
+		return []*Operator{&o.left, &o.right}
+	case *MergeJoin:
+		return []*Operator{&o.sorted.in, &o.right}
+	case *IndexNestedLoopJoin:
+		return []*Operator{&o.left}
+	case *SemiJoin:
+		return []*Operator{&o.left, &o.right}
+	case *Append:
+		return []*Operator{&o.left, &o.right}
+	case *HashSetOp:
+		return []*Operator{&o.left, &o.right}
+	case *Materialize:
+		return []*Operator{&o.buf.in}
+	case *RecursiveUnion:
+		return []*Operator{&o.anchor, &o.step}
+	case *Gather:
+		ops := make([]*Operator, len(o.workers))
+		for i := range o.workers {
+			ops[i] = &o.workers[i]
+		}
+		return ops
+	case *instrumented:
+		return children(o.Operator)
+	}
+	return nil
+}
+
+// instrumented は演算子が返した行の数と、かかった時間を数えます。
+type instrumented struct {
+	Operator
+	rows, loops int64
+	elapsed     time.Duration
+}
+
+// instrument は *op とその下のすべての演算子を instrumented で包みます。
+func instrument(op *Operator) {
+	if _, ok := (*op).(*instrumented); ok {
+		return // 共有する Materialize の下は、すでに包んである
+	}
+	for _, c := range children(*op) {
+		instrument(c)
+	}
+	*op = &instrumented{Operator: *op}
+}
+
+// visit は op の下の instrumented をすべて訪ねます。
+func visit(op Operator, fn func(*instrumented)) {
+	if in, ok := op.(*instrumented); ok {
+		fn(in)
+	}
+	for _, c := range children(op) {
+		visit(*c, fn)
+	}
+}
+
+func (in *instrumented) reset() { in.rows, in.loops, in.elapsed = 0, 0, 0 }
+
+func (in *instrumented) Open() error {
+	start := time.Now()
+	err := in.Operator.Open()
+	in.elapsed += time.Since(start)
+	in.loops++
+	return err
+}
+
+func (in *instrumented) Next() ([]types.Value, bool, error) {
+	start := time.Now()
+	row, ok, err := in.Operator.Next()
+	in.elapsed += time.Since(start)
+	if ok {
+		in.rows++
+	}
+	return row, ok, err
+}
+
+func (in *instrumented) Close() error {
+	start := time.Now()
+	err := in.Operator.Close()
+	in.elapsed += time.Since(start)
+	return err
+}
+
+// planWriter は演算子の木を表示する行を作ります。
+type planWriter struct {
+	notes   *planNotes
+	est     *estimator
+	analyze bool
+	shared  map[*materialized]bool // 下の木を表示した Materialize の保存先
+	rows    [][]types.Value
+}
+
+func (w *planWriter) line(indent int, s string) {
+	w.rows = append(w.rows, []types.Value{types.NewText(strings.Repeat(" ", indent) + s)})
+}
+
+// node は op とその下の木を indent だけ字下げして表示します。arrow なら先頭に "-> " を付けます。
+func (w *planWriter) node(op Operator, indent int, arrow bool) {
+	var stats *instrumented
+	var details []string
+	for {
+		if in, ok := op.(*instrumented); ok {
+			stats, op = in, in.Operator
+			continue
+		}
+		details = append(details, w.notes.details[op]...)
+		// Rename は列の名前を変えるだけなので、下の演算子の行として表示する
+		if r, ok := op.(*Rename); ok {
+			op = r.Operator
+			continue
+		}
+		break
+	}
+
+	head := describe(op)
+	est := w.est.cost(op)
+	if est.Rows > 0 && est.Rows < 1 {
+		est.Rows = 1 // 行があるかもしれないなら、0 行とは表示しない
+	}
+	head += fmt.Sprintf("  (cost=%.2f..%.2f rows=%.0f)", est.Startup, est.Total, est.Rows)
+	if w.analyze && stats != nil {
+		head += fmt.Sprintf(" (actual time=%.3f ms rows=%d loops=%d)",
+			float64(stats.elapsed)/float64(time.Millisecond), stats.rows, stats.loops)
+	}
+	body := indent + 2
+	if arrow {
+		head = "-> " + head
+		body = indent + 3
+	}
+	w.line(indent, head)
+	for _, d := range details {
+		w.line(body, d)
+	}
+	if is, ok := op.(*IndexScan); ok {
+		w.line(body, "Index Cond: "+indexCond(is))
+	}
+	if m, ok := op.(*Materialize); ok {
+		if w.shared[m.buf] {
+			w.line(body, "Rows shared with an earlier scan")
+			return
+		}
+		w.shared[m.buf] = true
+	}
+	kids := children(op)
+	if g, ok := op.(*Gather); ok {
+		// ワーカーの木はどれも同じ形なので、最初の木だけを表示する
+		if w.analyze {
+			g.mergeStats()
+		}
+		kids = kids[:1]
+	}
+	for _, c := range kids {
+		w.node(*c, body, true)
+	}
+}
+
+// describe は演算子の種類を表示する名前を返します。
+func describe(op Operator) string {
+	switch o := op.(type) {
+	case *SeqScan:
+		if o.workers > 0 {
+			return "Parallel Seq Scan on " + o.table.Name
+		}
+		return "Seq Scan on " + o.table.Name
+	case *IndexScan:
+		return fmt.Sprintf("Index Scan using %s on %s", o.index.Name, o.table.Name)
+	case *Filter:
+		return "Filter"
+	case *Project:
+		return "Project"
+	case *Limit:
+		if o.count < 0 {
+			return fmt.Sprintf("Limit (offset=%d)", o.offset)
+		}
+		return fmt.Sprintf("Limit (count=%d offset=%d)", o.count, o.offset)
+	case *Sort:
+		if o.limit >= 0 {
+			return fmt.Sprintf("Top-N Sort (limit=%d)", o.limit)
+		}
+		return "Sort"
+	case *Distinct:
+		return "Hash Distinct"
+	case *HashAggregate:
+		if len(o.keys) == 0 {
+			return "Aggregate"
+		}
+		return "Hash Aggregate"
+	case *Values:
+		return "Values"
+	case *NestedLoopJoin:
+		return joinName(o.typ, "Nested Loop")
+	case *HashJoin:
+		return joinName(o.typ, "Hash Join")
+	case *MergeJoin:
+		return joinName(o.typ, "Merge Join")
+	case *IndexNestedLoopJoin:
+		return joinName(o.typ, fmt.Sprintf("Index Nested Loop using %s on %s", o.index.Name, o.table.Name))
+	case *SemiJoin:
+		if o.anti {
+			return "Hash Anti Join"
+		}
+		return "Hash Semi Join"
+	case *Append:
+		return "Append"
+	case *HashSetOp:
+		name := "Intersect"
+		if o.op == Except {
+			name = "Except"
+		}
+		if o.all {
+			name += " All"
+		}
+		return "Hash " + name
+	case *Materialize:
+		return "Materialize"
+	case *RecursiveUnion:
+		if o.all {
+			return "Recursive Union All"
+		}
+		return "Recursive Union"
+	case *WorkTableScan:
+		return "Work Table Scan"
+	case *Gather:
+		return "Gather"
+	}
+	return fmt.Sprintf("%T", op)
+}
+
+// joinName は結合の種類を名前に付けます。
+func joinName(typ JoinType, name string) string {
+	switch typ {
+	case LeftJoin:
+		return name + " (Left)"
+	case FullJoin:
+		return name + " (Full)"
+	}
+	return name
+}
+
+// indexCond は IndexScan が読む範囲を、インデックスの列の条件として表します。
+func indexCond(s *IndexScan) string {
+	lo, hi := make([]string, len(s.r.Lo)), make([]string, len(s.r.Hi))
+	loOpen, hiOpen := s.r.LoOpen, s.r.HiOpen
+	for i, v := range s.r.Lo {
+		lo[i] = ast.FormatValue(v)
+	}
+	for i, v := range s.r.Hi {
+		hi[i] = ast.FormatValue(v)
+	}
+	if b := s.bounds; b != nil {
+		// 範囲が引数で決まるなら、値の代わりに引数を表示する
+		show := func(bs []bound) []string {
+			out := make([]string, len(bs))
+			for i, x := range bs {
+				out[i] = ast.FormatValue(x.v)
+				if x.param != 0 {
+					out[i] = fmt.Sprintf("$%d", x.param)
+				}
+			}
+			return out
+		}
+		lo, hi, loOpen, hiOpen = show(b.lo), show(b.hi), b.loOpen, b.hiOpen
+	}
+	var conds []string
+	i := 0
+	for ; i < len(lo) && i < len(hi) && lo[i] == hi[i] && (i < len(lo)-1 || i < len(hi)-1 || !loOpen && !hiOpen); i++ {
+		conds = append(conds, fmt.Sprintf("%s = %s", s.index.Columns[i], lo[i]))
+	}
+	if i < len(lo) {
+		op := ">="
+		if loOpen {
+			op = ">"
+		}
+		conds = append(conds, fmt.Sprintf("%s %s %s", s.index.Columns[i], op, lo[i]))
+	}
+	if i < len(hi) {
+		op := "<="
+		if hiOpen {
+			op = "<"
+		}
+		conds = append(conds, fmt.Sprintf("%s %s %s", s.index.Columns[i], op, hi[i]))
+	}
+	if len(conds) == 0 {
+		return "(all rows)"
+	}
+	return strings.Join(conds, " AND ")
+}
@@ -0,0 +1,550 @@
+package exec
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/k-sml/go-rdbms/internal/sql/ast"
+	"github.com/k-sml/go-rdbms/internal/types"
+)
+
+// 式の評価
+//
+// 式は Compile で Go の関数の組み合わせに変換してから評価する。列の参照はコンパイルの
+// ときに行の中の位置に解決し、型の分かる項どうしの演算は型を検査しておくので、
+// 行ごとの評価では名前の検索や型の組み合わせの誤りの検出を繰り返さない。
+//
+// 算術演算は両辺を types.Common の型にそろえて計算し、整数のあふれや0での除算は
+// エラーにする。|| は両辺を TEXT に変換してつなぐ。比較は数値どうしなら数値として、
+// TEXT と TIMESTAMP なら TEXT を TIMESTAMP に変換して行う。
+// 論理演算と IN 以外の演算は、いずれかの項が NULL なら結果は NULL になる。
+
+// Expr はコンパイルした式です。行を受け取って値を返します。
+type Expr func(row []types.Value) (types.Value, error)
+
+// Compile は式 e を、列が cols の行に対して評価できる形にします。
+// 列の参照はここで行の中の位置に解決し、演算の項の型を検査します。
+// 副問い合わせを含む式は CompileQuery でコンパイルします。
+func Compile(e ast.Expr, cols []Column) (Expr, error) {
+	return (&compiler{cols: cols}).compile(e)
+}
+
+// compiler は式をコンパイルします。
+type compiler struct {
+	p     *planner // 副問い合わせの実行計画を作る。nil なら副問い合わせは使えない
+	cols  []Column // 式を評価する行の列
+	outer *scope   // 副問い合わせの中なら、外側の問い合わせの列
+	funcs *Funcs   // データベースに登録した関数。nil なら組み込みの関数だけを使える
+}
+
+func (c *compiler) compile(e ast.Expr) (Expr, error) {
+	switch e := e.(type) {
+	case *ast.Literal:
+		v := e.Value
+		return func([]types.Value) (types.Value, error) { return v, nil }, nil
+	case *ast.ColumnRef:
+		return c.column(e)
+	case *ast.Param:
+		return c.param(e)
+	case *ast.Unary:
+		return c.unaryOp(e)
+	case *ast.Binary:
+		return c.binaryOp(e)
+	case *ast.IsNull:
+		x, err := c.compile(e.X)
+		if err != nil {
+			return nil, err
+		}
+		not := e.Not
+		return func(row []types.Value) (types.Value, error) {
+			v, err := x(row)
+			if err != nil {
+				return v, err
+			}
+			return types.NewBool(v.IsNull() != not), nil
+		}, nil
+	case *ast.Between:
+		return c.between(e)
+	case *ast.InList:
+		return c.in(e)
+	case *ast.Like:
+		return c.like(e)
+	case *ast.Subquery:
+		return c.scalar(e)
+	case *ast.InSubquery:
+		return c.inSubquery(e)
+	case *ast.Exists:
+		return c.exists(e)
+	case *ast.Call:
+		return c.call(e)
+	case *ast.Case:
+		return c.caseExpr(e)
+	case *ast.Cast:
+		x, err := c.compile(e.X)
+		if err != nil {
+			return nil, err
+		}
+		t := e.Type
+		return func(row []types.Value) (types.Value, error) {
+			v, err := x(row)
+			if err != nil {
+				return v, err
+			}
+			return types.Cast(v, t)
+		}, nil
+	}
+	return nil, fmt.Errorf("unsupported expression: %s", ast.FormatExpr(e))
+}
+
+func (c *compiler) unaryOp(e *ast.Unary) (Expr, error) {
+	x, err := c.compile(e.X)
+	if err != nil {
+		return nil, err
+	}
+	if e.Op == "NOT" {
+		return func(row []types.Value) (types.Value, error) {
+			v, err := x(row)
+			if err != nil {
+				return v, err
+			}
+			return not(v), nil
+		}, nil
+	}
+	if t := TypeOf(e.X, c.cols, c.funcs); t != types.Null && !t.IsNumeric() {
+		return nil, fmt.Errorf("cannot apply unary %s to %s", e.Op, t)
+	}
+	op := e.Op
+	return func(row []types.Value) (types.Value, error) {
+		v, err := x(row)
+		if err != nil {
+			return v, err
+		}
+		return unary(op, v)
+	}, nil
+}
+
+func (c *compiler) binaryOp(e *ast.Binary) (Expr, error) {
+	l, err := c.compile(e.L)
+	if err != nil {
+		return nil, err
+	}
+	r, err := c.compile(e.R)
+	if err != nil {
+		return nil, err
+	}
+	op := e.Op
+	var f func(a, b types.Value) (types.Value, error)
+	switch op {
+	case "AND", "OR":
+		// AND は左が偽なら、OR は左が真なら右を評価せずに結果が決まる
+		and := op == "AND"
+		return func(row []types.Value) (types.Value, error) {
+			a, err := l(row)
+			if err != nil {
+				return a, err
+			}
+			if !a.IsNull() && Truth(a) != and {
+				return types.NewBool(!and), nil
+			}
+			b, err := r(row)
+			if err != nil {
+				return b, err
+			}
+			if and {
+				return and3(a, b), nil
+			}
+			return or3(a, b), nil
+		}, nil
+	case "=", "<>", "<", "<=", ">", ">=":
+		if err := checkComparable(TypeOf(e.L, c.cols, c.funcs), TypeOf(e.R, c.cols, c.funcs)); err != nil {
+			return nil, err
+		}
+		f = func(a, b types.Value) (types.Value, error) { return compare(op, a, b) }
+	case "+", "-", "*", "/", "%":
+		lt, rt := TypeOf(e.L, c.cols, c.funcs), TypeOf(e.R, c.cols, c.funcs)
+		if lt != types.Null && !lt.IsNumeric() || rt != types.Null && !rt.IsNumeric() {
+			return nil, fmt.Errorf("cannot apply %s to %s and %s", op, lt, rt)
+		}
+		f = func(a, b types.Value) (types.Value, error) { return arith(op, a, b) }
+	case "||":
+		f = concat
+	default:
+		return nil, fmt.Errorf("unsupported operator: %s", op)
+	}
+	return func(row []types.Value) (types.Value, error) {
+		a, err := l(row)
+		if err != nil {
+			return a, err
+		}
+		b, err := r(row)
+		if err != nil {
+			return b, err
+		}
+		return f(a, b)
+	}, nil
+}
+
+// between は X BETWEEN Lo AND Hi を X >= Lo AND X <= Hi として評価します。
+// X が NULL でも、片方の比較が偽なら結果は偽です。
+func (c *compiler) between(e *ast.Between) (Expr, error) {
+	var fs [3]Expr
+	for i, x := range []ast.Expr{e.X, e.Lo, e.Hi} {
+		var err error
+		if fs[i], err = c.compile(x); err != nil {
+			return nil, err
+		}
+	}
+	xt := TypeOf(e.X, c.cols, c.funcs)
+	for _, b := range []ast.Expr{e.Lo, e.Hi} {
+		if err := checkComparable(xt, TypeOf(b, c.cols, c.funcs)); err != nil {
+			return nil, err
+		}
+	}
+	negate := e.Not
+	return func(row []types.Value) (types.Value, error) {
+		var vs [3]types.Value
+		for i, f := range fs {
+			v, err := f(row)
+			if err != nil {
+				return v, err
+			}
+			vs[i] = v
+		}
+		lo, err := compare(">=", vs[0], vs[1])
+		if err != nil {
+			return lo, err
+		}
+		hi, err := compare("<=", vs[0], vs[2])
+		if err != nil {
+			return hi, err
+		}
+		v := and3(lo, hi)
+		if negate {
+			v = not(v)
+		}
+		return v, nil
+	}, nil
+}
+
+// in は X IN (List...) を評価します。X と等しい値がリストにあれば真、
+// なければ偽ですが、X が NULL の場合やリストに NULL がある場合は偽の代わりに NULL です。
+func (c *compiler) in(e *ast.InList) (Expr, error) {
+	x, err := c.compile(e.X)
+	if err != nil {
+		return nil, err
+	}
+	xt := TypeOf(e.X, c.cols, c.funcs)
+	list := make([]Expr, len(e.List))
+	for i, item := range e.List {
+		if list[i], err = c.compile(item); err != nil {
+			return nil, err
+		}
+		if err := checkComparable(xt, TypeOf(item, c.cols, c.funcs)); err != nil {
+			return nil, err
+		}
+	}
+	negate := e.Not
+	return func(row []types.Value) (types.Value, error) {
+		v, err := x(row)
+		if err != nil || v.IsNull() {
+			return types.NullValue(), err
+		}
+		unknown := false
+		for _, f := range list {
+			w, err := f(row)
+			if err != nil {
+				return w, err
+			}
+			eq, err := compare("=", v, w)
+			if err != nil {
+				return eq, err
+			}
+			if eq.IsNull() {
+				unknown = true
+			} else if eq.Bool() {
+				return types.NewBool(!negate), nil
+			}
+		}
+		if unknown {
+			return types.NullValue(), nil
+		}
+		return types.NewBool(negate), nil
+	}, nil
+}
+
+// caseExpr は CASE 式をコンパイルします。条件が真になる、または Operand と等しくなる最初の
+// WHEN の結果を返し、どれもならなければ ELSE の結果を、ELSE がなければ NULL を返します。
+// 結果の値は、すべての THEN と ELSE の共通の型にそろえます。
+func (c *compiler) caseExpr(e *ast.Case) (Expr, error) {
+	t, err := caseType(e, c.cols, c.funcs)
+	if err != nil {
+		return nil, err
+	}
+	var operand Expr
+	if e.Operand != nil {
+		if operand, err = c.compile(e.Operand); err != nil {
+			return nil, err
+		}
+	}
+	ot := TypeOf(e.Operand, c.cols, c.funcs)
+	conds := make([]Expr, len(e.Whens))
+	results := make([]Expr, len(e.Whens))
+	for i, w := range e.Whens {
+		if conds[i], err = c.compile(w.Cond); err != nil {
+			return nil, err
+		}
+		if operand != nil {
+			if err := checkComparable(ot, TypeOf(w.Cond, c.cols, c.funcs)); err != nil {
+				return nil, err
+			}
+		}
+		if results[i], err = c.compile(w.Result); err != nil {
+			return nil, err
+		}
+	}
+	var els Expr
+	if e.Else != nil {
+		if els, err = c.compile(e.Else); err != nil {
+			return nil, err
+		}
+	}
+	result := func(f Expr, row []types.Value) (types.Value, error) {
+		v, err := f(row)
+		if err != nil || v.IsNull() || t == types.Null || v.Type() == t {
+			return v, err
+		}
+		return types.Coerce(v, t)
+	}
+	return func(row []types.Value) (types.Value, error) {
+		var x types.Value
+		if operand != nil {
+			var err error
+			if x, err = operand(row); err != nil {
+				return x, err
+			}
+		}
+		for i, cond := range conds {
+			v, err := cond(row)
+			if err != nil {
+				return v, err
+			}
+			if operand != nil {
+				if v, err = compare("=", x, v); err != nil {
+					return v, err
+				}
+			}
+			if Truth(v) {
+				return result(results[i], row)
+			}
+		}
+		if els == nil {
+			return types.NullValue(), nil
+		}
+		return result(els, row)
+	}, nil
+}
+
+// caseType は CASE 式の結果の型を、THEN と ELSE の式の共通の型として求めます。
+func caseType(e *ast.Case, cols []Column, fs *Funcs) (types.Type, error) {
+	results := make([]ast.Expr, 0, len(e.Whens)+1)
+	for _, w := range e.Whens {
+		results = append(results, w.Result)
+	}
+	if e.Else != nil {
+		results = append(results, e.Else)
+	}
+	t := types.Null
+	for _, r := range results {
+		rt := TypeOf(r, cols, fs)
+		ct, ok := types.Common(t, rt)
+		if !ok {
+			return types.Null, fmt.Errorf("CASE types %s and %s cannot be matched", t, rt)
+		}
+		t = ct
+	}
+	return t, nil
+}
+
+// resolve は列の参照 ref が cols の何番目の列かを返します。
+func resolve(cols []Column, ref *ast.ColumnRef) (int, error) {
+	found := -1
+	for i, c := range cols {
+		if !matches(c, ref) {
+			continue
+		}
+		if found >= 0 {
+			return -1, fmt.Errorf("ambiguous column name: %s", ast.FormatExpr(ref))
+		}
+		found = i
+	}
+	if found < 0 {
+		return -1, fmt.Errorf("no such column: %s", ast.FormatExpr(ref))
+	}
+	return found, nil
+}
+
+// numMatches は cols のうち列の参照 ref に当てはまる列の数を返します。
+func numMatches(cols []Column, ref *ast.ColumnRef) int {
+	n := 0
+	for _, c := range cols {
+		if matches(c, ref) {
+			n++
+		}
+	}
+	return n
+}
+
+func matches(c Column, ref *ast.ColumnRef) bool {
+	return strings.EqualFold(c.Name, ref.Column) && (ref.Table == "" || strings.EqualFold(c.Table, ref.Table))
+}
+
+// compare は比較演算 op の結果を返します。どちらかが NULL なら NULL です。
+func compare(op string, a, b types.Value) (types.Value, error) {
+	if a.IsNull() || b.IsNull() {
+		return types.NullValue(), nil
+	}
+	a, b, err := comparable(a, b)
+	if err != nil {
+		return types.Value{}, err
+	}
+	c, err := types.Compare(a, b)
+	if err != nil {
+		return types.Value{}, err
+	}
+	var r bool
+	switch op {
+	case "=":
+		r = c == 0
+	case "<>":
+		r = c != 0
+	case "<":
+		r = c < 0
+	case "<=":
+		r = c <= 0
+	case ">":
+		r = c > 0
+	case ">=":
+		r = c >= 0
+	}
+	return types.NewBool(r), nil
+}
+
+// comparable は TEXT と TIMESTAMP を比べるとき、TEXT の側を TIMESTAMP に変換します。
+func comparable(a, b types.Value) (types.Value, types.Value, error) {
+	var err error
+	switch {
+	case a.Type() == types.Timestamp && b.Type() == types.Text:
+		b, err = types.Coerce(b, types.Timestamp)
+	case a.Type() == types.Text && b.Type() == types.Timestamp:
+		a, err = types.Coerce(a, types.Timestamp)
+	}
+	return a, b, err
+}
+
+// checkComparable は型 a と b の値を比較できるかを検査します。types.Null は型が分からないことを表します。
+func checkComparable(a, b types.Type) error {
+	if a == types.Null || b == types.Null {
+		return nil
+	}
+	if _, ok := types.Common(a, b); ok {
+		return nil
+	}
+	if a == types.Text && b == types.Timestamp || a == types.Timestamp && b == types.Text {
+		return nil
+	}
+	return fmt.Errorf("cannot compare %s with %s", a, b)
+}
+
+// TypeOf は列が cols の行に対する式 e の値の型を返します。型が決まらなければ types.Null です。
+// 関数の結果の型は fs から引きます。
+func TypeOf(e ast.Expr, cols []Column, fs *Funcs) types.Type {
+	switch e := e.(type) {
+	case *ast.Literal:
+		return e.Value.Type()
+	case *ast.ColumnRef:
+		if i, err := resolve(cols, e); err == nil {
+			return cols[i].Type
+		}
+	case *ast.Unary:
+		if e.Op == "NOT" {
+			return types.Boolean
+		}
+		return TypeOf(e.X, cols, fs)
+	case *ast.Binary:
+		switch e.Op {
+		case "+", "-", "*", "/", "%":
+			t, _ := types.Common(TypeOf(e.L, cols, fs), TypeOf(e.R, cols, fs))
+			return t
+		case "||":
+			return types.Text
+		}
+		return types.Boolean
+	case *ast.IsNull, *ast.Between, *ast.InList, *ast.Like, *ast.InSubquery, *ast.Exists:
+		return types.Boolean
+	case *ast.Case:
+		t, _ := caseType(e, cols, fs)
+		return t
+	case *ast.Cast:
+		return e.Type
+	case *ast.Call:
+		return typeOfCall(e, cols, fs)
+	}
+	return types.Null
+}
+
+// 論理演算は SQL の3値論理に従う。NULL は「不明」で、NOT NULL は NULL、
+// AND は一方が偽なら偽、OR は一方が真なら真になり、それ以外で NULL を含めば NULL になる。
+// WHERE や ON の条件は Truth が真の行だけを通すので、不明の行は除かれる。
+
+// not は NOT v の結果を返します。
+func not(v types.Value) types.Value {
+	if v.IsNull() {
+		return v
+	}
+	return types.NewBool(!Truth(v))
+}
+
+// and3 は a AND b の結果を返します。
+func and3(a, b types.Value) types.Value {
+	switch {
+	case !a.IsNull() && !Truth(a), !b.IsNull() && !Truth(b):
+		return types.NewBool(false)
+	case a.IsNull() || b.IsNull():
+		return types.NullValue()
+	}
+	return types.NewBool(true)
+}
+
+// or3 は a OR b の結果を返します。
+func or3(a, b types.Value) types.Value {
+	switch {
+	case Truth(a) || Truth(b):
+		return types.NewBool(true)
+	case a.IsNull() || b.IsNull():
+		return types.NullValue()
+	}
+	return types.NewBool(false)
+}
+
+// Truth は値を条件として見たときに真かを返します。TRUE と 0 以外の数が真で、
+// NULL（不明）は偽として扱います。
+func Truth(v types.Value) bool {
+	switch v.Type() {
+	case types.Boolean:
+		return v.Bool()
+	case types.Int, types.BigInt:
+		return v.Int() != 0
+	case types.Real:
+		return v.Real() != 0
+	}
+	return false
+}
+
+// EvalConst は列を参照しない式 e を評価します。
+func EvalConst(e ast.Expr) (types.Value, error) {
+	f, err := Compile(e, nil)
+	if err != nil {
+		return types.Value{}, err
+	}
+	return f(nil)
+}
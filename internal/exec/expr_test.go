@@ -0,0 +1,78 @@
+package exec
+
+import (
+	"testing"
+
+	"github.com/k-sml/go-rdbms/internal/sql/parser"
+	"github.com/k-sml/go-rdbms/internal/types"
+)
+
+// TestCompile は、コンパイルした式を t(a INT, b TEXT, n INT) の行 (3, 'abc', NULL) に対して
+// 評価した結果を確かめます。
+func TestCompile(t *testing.T) {
+	cols := []Column{
+		{Table: "t", Name: "a", Type: types.Int},
+		{Table: "t", Name: "b", Type: types.Text},
+		{Table: "t", Name: "n", Type: types.Int},
+	}
+	row := []types.Value{types.NewInt(3), types.NewText("abc"), types.NullValue()}
+	tests := []struct {
+		expr string
+		want string // 結果の値（String の形）。空ならコンパイルか評価がエラーになる
+	}{
+		{"t.a", "3"},
+		{"b", "abc"},
+		{"a = 3", "TRUE"},
+		{"a <> 3", "FALSE"},
+		{"a < 4 AND b = 'abc'", "TRUE"},
+		{"a > 4 OR b >= 'abd'", "FALSE"},
+		{"NOT a = 3", "FALSE"},
+		{"nope = 1", ""},
+		{"a = 'x'", ""},
+
+		{"a + 2 * 3", "9"},
+		{"a / 2", "1"},
+		{"a % 2", "1"},
+		{"-a", "-3"},
+		{"a + 0.5", "3.5"},
+		{"b || 'd'", "abcd"},
+		{"CAST(a AS TEXT)", "3"},
+		{"a BETWEEN 1 AND 3", "TRUE"},
+		{"a IN (1, 2)", "FALSE"},
+		{"a / 0", ""},
+		{"9223372036854775807 + a", ""},
+
+		{"n = 1", "NULL"},
+		{"n = 1 AND a = 4", "FALSE"},
+		{"n = 1 OR a = 3", "TRUE"},
+		{"NOT n = 1", "NULL"},
+		{"a IN (1, n)", "NULL"},
+
+		{"b LIKE 'a%'", "TRUE"},
+		{"b GLOB '?b*'", "TRUE"},
+		{"b NOT LIKE '_'", "TRUE"},
+		{"b LIKE 'a' ESCAPE 'xy'", ""},
+
+		{"CASE WHEN a > 2 THEN 'big' ELSE 'small' END", "big"},
+		{"CASE a WHEN 1 THEN 'one' END", "NULL"},
+	}
+	for _, tt := range tests {
+		e, err := parser.ParseExpr(tt.expr)
+		if err != nil {
+			t.Fatalf("ParseExpr(%q): %v", tt.expr, err)
+		}
+		f, err := Compile(e, cols)
+		var v types.Value
+		if err == nil {
+			v, err = f(row)
+		}
+		switch {
+		case tt.want == "" && err == nil:
+			t.Errorf("%s = %v, want an error", tt.expr, v)
+		case tt.want != "" && err != nil:
+			t.Errorf("%s: %v", tt.expr, err)
+		case tt.want != "" && v.String() != tt.want:
+			t.Errorf("%s = %v, want %s", tt.expr, v, tt.want)
+		}
+	}
+}
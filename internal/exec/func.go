@@ -0,0 +1,587 @@
+package exec
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/k-sml/go-rdbms/internal/sql/ast"
+	"github.com/k-sml/go-rdbms/internal/types"
+)
+
+// 組み込み関数
+//
+// LENGTH(x) のような関数の呼び出しは、名前で Func を引いて実行する。関数は RegisterFunc で
+// 登録し、名前の大文字と小文字は区別しない。Strict な関数は、引数のいずれかが NULL なら
+// 呼ばずに NULL を返す。引数の数はコンパイルのときに検査する。
+//
+// アプリケーションが登録する関数は、データベースごとの Funcs に入れて Settings で渡す。
+// Funcs の関数は同じ名前の組み込みの関数より優先する。Args で引数の型を宣言した関数は、
+// 型の分かる引数をコンパイルのときに検査し、呼び出すときに値を宣言した型に変換する。
+//
+// 日時の関数は TIMESTAMP の値のほか、日時として解釈できる TEXT、Unix 時刻（秒）の整数、
+// 現在の日時を表す文字列 'now' を受け付ける。日時はすべて UTC で扱う。
+
+// Func は SQL から呼び出せる関数です。
+type Func struct {
+	Name    string
+	MinArgs int
+	MaxArgs int  // 引数の最大の数。-1 なら上限なし
+	Strict  bool // 引数のいずれかが NULL なら、Call を呼ばずに NULL を返す
+
+	// Args は宣言した引数の型です。nil なら引数の型を検査しません。MaxArgs が -1 なら、
+	// 最後の型を残りの引数にも使います。types.Null の引数はどの型の値でも受け付けます。
+	Args []types.Type
+
+	// Type は引数の型から結果の型を返します。決まらなければ types.Null を返します。
+	Type func(args []types.Type) types.Type
+	// Call は引数の値から結果を返します。
+	Call func(args []types.Value) (types.Value, error)
+}
+
+var funcs = make(map[string]*Func)
+
+// RegisterFunc は関数 f を登録します。同じ名前の関数があれば置き換えます。
+func RegisterFunc(f *Func) {
+	funcs[strings.ToUpper(f.Name)] = f
+}
+
+// LookupFunc は name という名前の関数を返します。
+func LookupFunc(name string) (*Func, bool) {
+	f, ok := funcs[strings.ToUpper(name)]
+	return f, ok
+}
+
+// Funcs はデータベースごとに登録した関数と集約関数です。同じ名前の組み込みの関数と集約関数より
+// 優先します。nil の *Funcs からは組み込みの関数と集約関数だけを引きます。
+type Funcs struct {
+	mu      sync.RWMutex
+	funcs   map[string]*Func
+	aggs    map[string]*Aggregate
+	version uint64
+}
+
+// NewFuncs は空の Funcs を作ります。
+func NewFuncs() *Funcs {
+	return &Funcs{funcs: make(map[string]*Func), aggs: make(map[string]*Aggregate)}
+}
+
+// Register は関数 f を登録します。同じ名前の関数や集約関数があれば置き換えます。
+func (fs *Funcs) Register(f *Func) {
+	name := strings.ToUpper(f.Name)
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	delete(fs.aggs, name)
+	fs.funcs[name] = f
+	fs.version++
+}
+
+// RegisterAggregate は集約関数 a を登録します。同じ名前の関数や集約関数があれば置き換えます。
+func (fs *Funcs) RegisterAggregate(a *Aggregate) {
+	name := strings.ToUpper(a.Name)
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	delete(fs.funcs, name)
+	fs.aggs[name] = a
+	fs.version++
+}
+
+// Version は関数を登録するたびに進む版を返します。実行計画はコンパイルした関数を持つので、
+// 版が変わったら作り直します。
+func (fs *Funcs) Version() uint64 {
+	if fs == nil {
+		return 0
+	}
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+	return fs.version
+}
+
+// Func は name という名前の関数を返します。name が集約関数なら false を返します。
+func (fs *Funcs) Func(name string) (*Func, bool) {
+	if fs != nil {
+		fs.mu.RLock()
+		f, ok := fs.funcs[strings.ToUpper(name)]
+		_, agg := fs.aggs[strings.ToUpper(name)]
+		fs.mu.RUnlock()
+		if ok || agg {
+			return f, ok
+		}
+	}
+	return LookupFunc(name)
+}
+
+// Aggregate は name という名前の集約関数を返します。name が関数なら false を返します。
+func (fs *Funcs) Aggregate(name string) (*Aggregate, bool) {
+	if fs != nil {
+		fs.mu.RLock()
+		a, ok := fs.aggs[strings.ToUpper(name)]
+		_, fn := fs.funcs[strings.ToUpper(name)]
+		fs.mu.RUnlock()
+		if ok || fn {
+			return a, ok
+		}
+	}
+	return LookupAggregate(name)
+}
+
+// checkCall は関数の呼び出し e の引数の数と型を検査します。
+func checkCall(e *ast.Call, minArgs, maxArgs int, decl []types.Type, cols []Column, fs *Funcs) error {
+	if e.Star {
+		return fmt.Errorf("%s(*) is not allowed", e.Name)
+	}
+	if len(e.Args) < minArgs || maxArgs >= 0 && len(e.Args) > maxArgs {
+		return fmt.Errorf("wrong number of arguments to function %s", e.Name)
+	}
+	if decl == nil {
+		return nil
+	}
+	for i, a := range e.Args {
+		want, got := argType(decl, i), TypeOf(a, cols, fs)
+		if !assignable(got, want) {
+			return fmt.Errorf("argument %d of function %s must be %s, not %s", i+1, e.Name, want, got)
+		}
+	}
+	return nil
+}
+
+// argType は宣言した引数の型 decl の i 番目（0 から）の型を返します。
+func argType(decl []types.Type, i int) types.Type {
+	if len(decl) == 0 {
+		return types.Null
+	}
+	return decl[min(i, len(decl)-1)]
+}
+
+// assignable は型 from の値を型 to の引数に渡せるかを返します。types.Null はどちらも
+// 型が分からないことを表し、検査しません。
+func assignable(from, to types.Type) bool {
+	switch {
+	case from == types.Null || to == types.Null || from == to:
+		return true
+	case from.IsNumeric() && to.IsNumeric():
+		return true
+	}
+	return from == types.Text && to == types.Timestamp
+}
+
+// coerceArgs は引数の値 vals を宣言した型 decl に変換します。
+func coerceArgs(name string, decl []types.Type, vals []types.Value) error {
+	if decl == nil {
+		return nil
+	}
+	for i, v := range vals {
+		t := argType(decl, i)
+		if t == types.Null || v.IsNull() || v.Type() == t {
+			continue
+		}
+		c, err := types.Coerce(v, t)
+		if err != nil {
+			return fmt.Errorf("argument %d of function %s: %w", i+1, name, err)
+		}
+		vals[i] = c
+	}
+	return nil
+}
+
+// call は関数の呼び出しをコンパイルします。
+func (c *compiler) call(e *ast.Call) (Expr, error) {
+	f, ok := c.funcs.Func(e.Name)
+	if !ok {
+		if _, ok := c.funcs.Aggregate(e.Name); ok {
+			return nil, fmt.Errorf("aggregate function %s is not allowed here", e.Name)
+		}
+		return nil, fmt.Errorf("no such function: %s", e.Name)
+	}
+	if err := checkCall(e, f.MinArgs, f.MaxArgs, f.Args, c.cols, c.funcs); err != nil {
+		return nil, err
+	}
+	args := make([]Expr, len(e.Args))
+	for i, a := range e.Args {
+		var err error
+		if args[i], err = c.compile(a); err != nil {
+			return nil, err
+		}
+	}
+	// 結果は Type の返す型にそろえる。COALESCE(1, 2.5) は 1 ではなく 1.0 を返す。
+	t := typeOfCall(e, c.cols, c.funcs)
+	return func(row []types.Value) (types.Value, error) {
+		vals := make([]types.Value, len(args))
+		for i, a := range args {
+			v, err := a(row)
+			if err != nil {
+				return v, err
+			}
+			if v.IsNull() && f.Strict {
+				return v, nil
+			}
+			vals[i] = v
+		}
+		if err := coerceArgs(e.Name, f.Args, vals); err != nil {
+			return types.Value{}, err
+		}
+		v, err := f.Call(vals)
+		if err != nil || v.IsNull() || t == types.Null || v.Type() == t {
+			return v, err
+		}
+		return types.Coerce(v, t)
+	}, nil
+}
+
+// typeOfCall は関数または集約関数の呼び出しの結果の型を返します。
+func typeOfCall(e *ast.Call, cols []Column, fs *Funcs) types.Type {
+	var typ func([]types.Type) types.Type
+	if f, ok := fs.Func(e.Name); ok {
+		typ = f.Type
+	} else if a, ok := fs.Aggregate(e.Name); ok {
+		typ = a.Type
+	}
+	if typ == nil {
+		return types.Null
+	}
+	args := make([]types.Type, len(e.Args))
+	for i, a := range e.Args {
+		args[i] = TypeOf(a, cols, fs)
+	}
+	return typ(args)
+}
+
+// returns は引数によらず型 t を返す Func.Type です。
+func returns(t types.Type) func([]types.Type) types.Type {
+	return func([]types.Type) types.Type { return t }
+}
+
+// firstArg は最初の引数の型を返す Func.Type です。
+func firstArg(args []types.Type) types.Type { return args[0] }
+
+// commonArgs はすべての引数の共通の型を返す Func.Type です。
+func commonArgs(args []types.Type) types.Type {
+	t := types.Null
+	for _, a := range args {
+		var ok bool
+		if t, ok = types.Common(t, a); !ok {
+			return types.Null
+		}
+	}
+	return t
+}
+
+func init() {
+	for _, f := range []*Func{
+		{Name: "LENGTH", MinArgs: 1, MaxArgs: 1, Strict: true, Type: returns(types.BigInt), Call: length},
+		{Name: "SUBSTR", MinArgs: 2, MaxArgs: 3, Strict: true, Type: firstArg, Call: substr},
+		{Name: "SUBSTRING", MinArgs: 2, MaxArgs: 3, Strict: true, Type: firstArg, Call: substr},
+		{Name: "UPPER", MinArgs: 1, MaxArgs: 1, Strict: true, Type: returns(types.Text), Call: textFunc(strings.ToUpper)},
+		{Name: "LOWER", MinArgs: 1, MaxArgs: 1, Strict: true, Type: returns(types.Text), Call: textFunc(strings.ToLower)},
+		{Name: "TRIM", MinArgs: 1, MaxArgs: 2, Strict: true, Type: returns(types.Text), Call: trimFunc(strings.Trim)},
+		{Name: "LTRIM", MinArgs: 1, MaxArgs: 2, Strict: true, Type: returns(types.Text), Call: trimFunc(strings.TrimLeft)},
+		{Name: "RTRIM", MinArgs: 1, MaxArgs: 2, Strict: true, Type: returns(types.Text), Call: trimFunc(strings.TrimRight)},
+		{Name: "ABS", MinArgs: 1, MaxArgs: 1, Strict: true, Type: firstArg, Call: abs},
+		{Name: "ROUND", MinArgs: 1, MaxArgs: 2, Strict: true, Type: firstArg, Call: round},
+		{Name: "COALESCE", MinArgs: 1, MaxArgs: -1, Type: commonArgs, Call: coalesce},
+		{Name: "IFNULL", MinArgs: 2, MaxArgs: 2, Type: commonArgs, Call: coalesce},
+		{Name: "NULLIF", MinArgs: 2, MaxArgs: 2, Type: firstArg, Call: nullif},
+		{Name: "NOW", MinArgs: 0, MaxArgs: 0, Type: returns(types.Timestamp), Call: now},
+		{Name: "DATE", MinArgs: 1, MaxArgs: 1, Strict: true, Type: returns(types.Text), Call: timeFunc("2006-01-02")},
+		{Name: "TIME", MinArgs: 1, MaxArgs: 1, Strict: true, Type: returns(types.Text), Call: timeFunc("15:04:05")},
+		{Name: "DATETIME", MinArgs: 1, MaxArgs: 1, Strict: true, Type: returns(types.Timestamp), Call: datetime},
+		{Name: "STRFTIME", MinArgs: 2, MaxArgs: 2, Strict: true, Type: returns(types.Text), Call: strftime},
+		{Name: "UNIXEPOCH", MinArgs: 1, MaxArgs: 1, Strict: true, Type: returns(types.BigInt), Call: unixepoch},
+	} {
+		RegisterFunc(f)
+	}
+}
+
+// length は文字列の文字の数、または BLOB のバイトの数を返します。
+func length(args []types.Value) (types.Value, error) {
+	switch v := args[0]; v.Type() {
+	case types.Text:
+		return types.NewBigInt(int64(utf8.RuneCountInString(v.Text()))), nil
+	case types.Blob:
+		return types.NewBigInt(int64(len(v.Blob()))), nil
+	default:
+		return types.NewBigInt(int64(utf8.RuneCountInString(v.String()))), nil
+	}
+}
+
+// substr は SUBSTR(x, start[, n]) で、x の start 番目（1 から）からの n 文字を返します。
+// start が負なら末尾から数えます。n が負なら start の前の |n| 文字を返します。
+// x が BLOB ならバイトの単位で切り出します。
+func substr(args []types.Value) (types.Value, error) {
+	x := args[0]
+	if x.Type() != types.Text && x.Type() != types.Blob {
+		return types.Value{}, fmt.Errorf("SUBSTR: cannot apply to %s", x.Type())
+	}
+	start, err := intArg("SUBSTR", args[1])
+	if err != nil {
+		return types.Value{}, err
+	}
+	var size int
+	var runes []rune
+	if x.Type() == types.Text {
+		runes = []rune(x.Text())
+		size = len(runes)
+	} else {
+		size = len(x.Blob())
+	}
+
+	// 切り出す範囲を、0 から始まる位置 from と長さ n で求める。計算は SQLite に合わせる。
+	from, n := start, int64(size)
+	neg := false
+	if len(args) == 3 {
+		if n, err = intArg("SUBSTR", args[2]); err != nil {
+			return types.Value{}, err
+		}
+		if n < 0 {
+			n, neg = -n, true
+		}
+	}
+	switch {
+	case from < 0:
+		from += int64(size)
+		if from < 0 {
+			n = max(n+from, 0)
+			from = 0
+		}
+	case from > 0:
+		from--
+	case n > 0:
+		n-- // 0 は1文字目の前を指す
+	}
+	if neg {
+		from -= n
+		if from < 0 {
+			n += from
+			from = 0
+		}
+	}
+	from = min(from, int64(size))
+	to := from + min(n, int64(size)-from)
+	if x.Type() == types.Text {
+		return types.NewText(string(runes[from:to])), nil
+	}
+	return types.NewBlob(x.Blob()[from:to]), nil
+}
+
+// intArg は関数 name の整数の引数 v の値を返します。
+func intArg(name string, v types.Value) (int64, error) {
+	switch {
+	case v.Type().IsInteger():
+		return v.Int(), nil
+	case v.Type() == types.Real && v.Real() == math.Trunc(v.Real()) && math.Abs(v.Real()) < 1<<53:
+		return int64(v.Real()), nil
+	}
+	return 0, fmt.Errorf("%s: expected an integer argument, got %s", name, v)
+}
+
+// textArg は文字列の引数 v の値を返します。文字列でなければ文字列に変換します。
+func textArg(v types.Value) string {
+	switch v.Type() {
+	case types.Text:
+		return v.Text()
+	case types.Blob:
+		return string(v.Blob())
+	}
+	return v.String()
+}
+
+// textFunc は文字列を f で変換する関数を返します。
+func textFunc(f func(string) string) func([]types.Value) (types.Value, error) {
+	return func(args []types.Value) (types.Value, error) {
+		return types.NewText(f(textArg(args[0]))), nil
+	}
+}
+
+// trimFunc は文字列の前後から文字を取り除く関数を返します。2つ目の引数がなければ空白を取り除きます。
+func trimFunc(f func(string, string) string) func([]types.Value) (types.Value, error) {
+	return func(args []types.Value) (types.Value, error) {
+		cutset := " "
+		if len(args) == 2 {
+			cutset = textArg(args[1])
+		}
+		return types.NewText(f(textArg(args[0]), cutset)), nil
+	}
+}
+
+// abs は数の絶対値を返します。
+func abs(args []types.Value) (types.Value, error) {
+	switch v := args[0]; v.Type() {
+	case types.Int:
+		if v.Int() < 0 {
+			if v.Int() == math.MinInt32 {
+				return types.Value{}, errOverflow
+			}
+			return types.NewInt(int32(-v.Int())), nil
+		}
+		return v, nil
+	case types.BigInt:
+		if v.Int() < 0 {
+			if v.Int() == math.MinInt64 {
+				return types.Value{}, errOverflow
+			}
+			return types.NewBigInt(-v.Int()), nil
+		}
+		return v, nil
+	case types.Real:
+		return types.NewReal(math.Abs(v.Real())), nil
+	default:
+		return types.Value{}, fmt.Errorf("ABS: cannot apply to %s", v.Type())
+	}
+}
+
+// round は ROUND(x[, digits]) で、x を小数点以下 digits 桁に四捨五入します。
+// 整数はそのまま返します。
+func round(args []types.Value) (types.Value, error) {
+	x := args[0]
+	switch {
+	case x.Type().IsInteger():
+		return x, nil
+	case x.Type() != types.Real:
+		return types.Value{}, fmt.Errorf("ROUND: cannot apply to %s", x.Type())
+	}
+	var digits int64
+	if len(args) == 2 {
+		var err error
+		if digits, err = intArg("ROUND", args[1]); err != nil {
+			return types.Value{}, err
+		}
+	}
+	f := x.Real()
+	if digits <= 0 {
+		return types.NewReal(math.Round(f)), nil
+	}
+	if digits > 15 || math.IsInf(f, 0) || math.IsNaN(f) {
+		return x, nil
+	}
+	// 10 進で表したときの桁で丸めるため、文字列を経由する。
+	r, _ := strconv.ParseFloat(strconv.FormatFloat(f, 'f', int(digits), 64), 64)
+	return types.NewReal(r), nil
+}
+
+// coalesce は NULL でない最初の引数を返します。すべて NULL なら NULL を返します。
+func coalesce(args []types.Value) (types.Value, error) {
+	for _, v := range args {
+		if !v.IsNull() {
+			return v, nil
+		}
+	}
+	return types.NullValue(), nil
+}
+
+// nullif は2つの引数が等しければ NULL を、そうでなければ最初の引数を返します。
+func nullif(args []types.Value) (types.Value, error) {
+	eq, err := compare("=", args[0], args[1])
+	if err != nil {
+		return types.Value{}, err
+	}
+	if Truth(eq) {
+		return types.NullValue(), nil
+	}
+	return args[0], nil
+}
+
+// now は現在の日時を返します。
+func now([]types.Value) (types.Value, error) {
+	return types.NewTimestamp(time.Now()), nil
+}
+
+// timeArg は日時の関数の引数 v を日時として解釈します。
+func timeArg(v types.Value) (time.Time, error) {
+	switch {
+	case v.Type() == types.Timestamp:
+		return v.Time(), nil
+	case v.Type().IsInteger():
+		return time.Unix(v.Int(), 0).UTC(), nil
+	case v.Type() == types.Text:
+		if strings.EqualFold(strings.TrimSpace(v.Text()), "now") {
+			return time.Now().UTC(), nil
+		}
+		t, err := types.Coerce(v, types.Timestamp)
+		if err != nil {
+			return time.Time{}, err
+		}
+		return t.Time(), nil
+	}
+	return time.Time{}, fmt.Errorf("cannot use %s value as a date", v.Type())
+}
+
+// timeFunc は日時を layout の形式の文字列にする関数を返します。
+func timeFunc(layout string) func([]types.Value) (types.Value, error) {
+	return func(args []types.Value) (types.Value, error) {
+		t, err := timeArg(args[0])
+		if err != nil {
+			return types.Value{}, err
+		}
+		return types.NewText(t.Format(layout)), nil
+	}
+}
+
+// datetime は引数を TIMESTAMP に変換します。
+func datetime(args []types.Value) (types.Value, error) {
+	t, err := timeArg(args[0])
+	if err != nil {
+		return types.Value{}, err
+	}
+	return types.NewTimestamp(t), nil
+}
+
+// unixepoch は日時を Unix 時刻（秒）で返します。
+func unixepoch(args []types.Value) (types.Value, error) {
+	t, err := timeArg(args[0])
+	if err != nil {
+		return types.Value{}, err
+	}
+	return types.NewBigInt(t.Unix()), nil
+}
+
+// strftime は STRFTIME(format, x) で、日時 x を format に従って文字列にします。
+// format では次の指定を使えます。
+//
+//	%Y 年  %m 月  %d 日  %H 時  %M 分  %S 秒  %f 秒（小数点以下 3 桁まで）
+//	%j 年の中の日（001-366）  %w 曜日（0 が日曜日）  %s Unix 時刻（秒）  %% %
+func strftime(args []types.Value) (types.Value, error) {
+	format := textArg(args[0])
+	t, err := timeArg(args[1])
+	if err != nil {
+		return types.Value{}, err
+	}
+	var b strings.Builder
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' || i+1 == len(format) {
+			b.WriteByte(format[i])
+			continue
+		}
+		i++
+		switch format[i] {
+		case 'Y':
+			fmt.Fprintf(&b, "%04d", t.Year())
+		case 'm':
+			fmt.Fprintf(&b, "%02d", int(t.Month()))
+		case 'd':
+			fmt.Fprintf(&b, "%02d", t.Day())
+		case 'H':
+			fmt.Fprintf(&b, "%02d", t.Hour())
+		case 'M':
+			fmt.Fprintf(&b, "%02d", t.Minute())
+		case 'S':
+			fmt.Fprintf(&b, "%02d", t.Second())
+		case 'f':
+			fmt.Fprintf(&b, "%02d.%03d", t.Second(), t.Nanosecond()/int(time.Millisecond))
+		case 'j':
+			fmt.Fprintf(&b, "%03d", t.YearDay())
+		case 'w':
+			fmt.Fprintf(&b, "%d", int(t.Weekday()))
+		case 's':
+			fmt.Fprintf(&b, "%d", t.Unix())
+		case '%':
+			b.WriteByte('%')
+		default:
+			return types.Value{}, fmt.Errorf("STRFTIME: unknown format %%%c", format[i])
+		}
+	}
+	return types.NewText(b.String()), nil
+}
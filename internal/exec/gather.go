@@ -0,0 +1,243 @@
+package exec
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/k-sml/go-rdbms/internal/sql/ast"
+	"github.com/k-sml/go-rdbms/internal/storage"
+	"github.com/k-sml/go-rdbms/internal/types"
+)
+
+// 並列の順次走査
+//
+// 大きなテーブルを順に読んで条件で絞る部分は、Settings.Parallel 個までのゴルーチン（ワーカー）に
+// 分けて実行する。ワーカーはそれぞれ同じ形の Filter と SeqScan の木を持ち、テーブルのデータページを
+// 分け合って読む（Source.ScanParts）。ページは手の空いたワーカーが1つずつ取るので、読む速さに
+// 差があっても仕事が偏らない。ワーカーは条件を満たした行をバッチに写して Gather に送り、
+// Gather はそれを届いた順に上の演算子へ返す。このため行の順番は格納順にならない。
+//
+// 並列に実行するのは、副問い合わせの中でなく、条件に副問い合わせを含まず、ワーカー1つあたり
+// parallelPages ページ以上を読むときで、さらに費用の見積もりが1つのゴルーチンで読むより小さい
+// ときに限る。ゴルーチンを始める費用と、ワーカーから Gather へ行を渡す費用を加えて比べる。
+// ワーカーは同じトランザクションでページを読むので、トランザクションのページの読み書きは
+// 複数のゴルーチンから呼び出せるようにしてある。
+//
+// EXPLAIN では最初のワーカーの木だけを表示し、見積もりはワーカー1つあたりの値である。
+// EXPLAIN ANALYZE の実際の行の数と loops はすべてのワーカーの合計、時間は最も長いワーカーの値である。
+
+const (
+	parallelPages     = 64   // ワーカー1つあたりに読むページの数の下限
+	parallelSetupCost = 100  // ワーカーのゴルーチンを始める費用
+	parallelTupleCost = 0.05 // ワーカーから Gather へ1行を渡す費用
+	gatherRows        = 256  // 1行ずつ返すワーカーが、まとめて Gather に送る行の数
+)
+
+// Gather はワーカーのゴルーチンが並列に実行した木の行を集めて返します。
+type Gather struct {
+	workers []Operator // ワーカーが実行する木。どれも Filter と SeqScan からなる同じ形
+	scans   []*SeqScan // workers[i] の下でテーブルの一部を読む SeqScan
+	vec     bool       // workers がバッチで行を返す
+	cols    []Column
+
+	run *gatherRun
+	rd  batchReader
+}
+
+// gatherRun は Open してから Close するまでのワーカーの状態です。
+type gatherRun struct {
+	ch   chan gathered // ワーカーが送るバッチ。すべてのワーカーが終わると閉じる
+	done chan struct{} // Close で閉じ、ワーカーに止まるよう知らせる
+	wg   sync.WaitGroup
+}
+
+// gathered はワーカーが送る行のバッチか、ワーカーで起きたエラーです。
+type gathered struct {
+	b   *batch
+	err error
+}
+
+// gather は Filter f と同じ行を、テーブルを並列に読んで返す Gather を作ります。並列に読めないか、
+// 読んでも費用の見積もりが小さくならなければ nil を返します。s は f の下の SeqScan です。
+func (p *planner) gather(s *SeqScan, f *Filter, where ast.Expr) (*Gather, error) {
+	if p.parallel <= 1 || p.scope != nil || s.table.System || hasSubquery(where) {
+		return nil, nil
+	}
+	n := min(p.parallel, int(p.est.tableStats(s.table).Pages/parallelPages))
+	if n < 2 {
+		return nil, nil
+	}
+	g := &Gather{cols: f.Columns()}
+	for range n {
+		ws := NewSeqScan(p.src, s.table, s.cols[0].Table)
+		ws.read, ws.workers = s.read, n
+		wf, err := p.newFilter(ws, where)
+		if err != nil {
+			return nil, err
+		}
+		g.workers = append(g.workers, wf)
+		g.scans = append(g.scans, ws)
+		g.vec = wf.vcond != nil
+	}
+	if p.est.cost(g).Total >= p.est.cost(f).Total {
+		return nil, nil
+	}
+	p.note(g, "Workers Planned: %d", n)
+	return g, nil
+}
+
+func (g *Gather) Columns() []Column { return g.cols }
+
+func (g *Gather) Open() error {
+	s := g.scans[0]
+	parts, err := s.src.ScanParts(s.table, s.read, len(g.scans))
+	if err != nil {
+		return err
+	}
+	for i, ws := range g.scans {
+		ws.part = noRows{}
+		if i < len(parts) {
+			ws.part = parts[i]
+		}
+	}
+	r := &gatherRun{ch: make(chan gathered, len(g.workers)), done: make(chan struct{})}
+	r.wg.Add(len(g.workers))
+	for _, w := range g.workers {
+		go g.work(r, w)
+	}
+	go func() {
+		r.wg.Wait()
+		close(r.ch)
+	}()
+	g.run = r
+	g.rd.reset()
+	return nil
+}
+
+// work はワーカーの木 w を最後まで実行し、行を r に送ります。
+func (g *Gather) work(r *gatherRun, w Operator) {
+	defer r.wg.Done()
+	err := g.feed(r, w)
+	if cerr := w.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		r.send(gathered{err: err})
+	}
+}
+
+func (g *Gather) feed(r *gatherRun, w Operator) error {
+	if err := w.Open(); err != nil {
+		return err
+	}
+	if g.vec {
+		in := w.(batchOperator)
+		for {
+			b, ok, err := in.nextBatch()
+			if err != nil || !ok {
+				return err
+			}
+			// ワーカーのバッチは次の nextBatch で書き換わるので、残った行を写して送る
+			sel := b.selected()
+			out := newBatch(len(g.cols), len(sel))
+			for c, vec := range b.vecs {
+				for j, i := range sel {
+					out.vecs[c][j] = vec[i]
+				}
+			}
+			out.n = len(sel)
+			if !r.send(gathered{b: out}) {
+				return nil
+			}
+		}
+	}
+	var out *batch
+	for {
+		row, ok, err := w.Next()
+		if err != nil {
+			return err
+		}
+		if ok {
+			if out == nil {
+				out = newBatch(len(g.cols), gatherRows)
+			}
+			for c, v := range row {
+				out.vecs[c][out.n] = v
+			}
+			out.n++
+		}
+		if out != nil && (out.n == gatherRows || !ok) {
+			if !r.send(gathered{b: out}) {
+				return nil
+			}
+			out = nil
+		}
+		if !ok {
+			return nil
+		}
+	}
+}
+
+// send は m を Gather に送ります。Gather が閉じられていれば送らずに false を返します。
+func (r *gatherRun) send(m gathered) bool {
+	select {
+	case r.ch <- m:
+		return true
+	case <-r.done:
+		return false
+	}
+}
+
+func (g *Gather) nextBatch() (*batch, bool, error) {
+	if g.run == nil {
+		return nil, false, fmt.Errorf("gather of %s is not open", g.scans[0].table.Name)
+	}
+	m, ok := <-g.run.ch
+	if !ok {
+		return nil, false, nil
+	}
+	if m.err != nil {
+		return nil, false, m.err
+	}
+	return m.b, true, nil
+}
+
+func (g *Gather) Next() ([]types.Value, bool, error) { return g.rd.next(g.nextBatch) }
+
+// Close はワーカーを止め、すべてのワーカーが終わるまで待ちます。
+func (g *Gather) Close() error {
+	if g.run == nil {
+		return nil
+	}
+	close(g.run.done)
+	g.run.wg.Wait()
+	g.run = nil
+	g.rd.reset()
+	return nil
+}
+
+// mergeStats は EXPLAIN ANALYZE のために、2つ目以降のワーカーの実際の行の数と時間を、
+// 最初のワーカーの木の instrumented に足し合わせます。
+func (g *Gather) mergeStats() {
+	var first []*instrumented
+	visit(g.workers[0], func(in *instrumented) { first = append(first, in) })
+	for _, w := range g.workers[1:] {
+		i := 0
+		visit(w, func(in *instrumented) {
+			if i < len(first) {
+				f := first[i]
+				f.rows += in.rows
+				f.loops += in.loops
+				f.elapsed = max(f.elapsed, in.elapsed)
+			}
+			i++
+		})
+	}
+}
+
+// noRows は行を1つも返さないカーソルです。
+type noRows struct{}
+
+func (noRows) Next() (storage.RID, []types.Value, bool, error) {
+	return storage.RID{}, nil, false, nil
+}
@@ -0,0 +1,385 @@
+package exec
+
+import (
+	"errors"
+	"hash/fnv"
+
+	"github.com/k-sml/go-rdbms/internal/types"
+)
+
+// ハッシュ結合
+//
+// 右の行を結合のキーでハッシュ表に入れ、左の行ごとに同じキーの行を引く。キーは両辺の式を
+// 共通の型にそろえてから types.AppendKey で作るので、= で等しい値は同じキーになる。
+// キーが一致した組み合わせにも ON の条件全体を評価するので、キーは一致の必要条件であればよい。
+//
+// 右の行がメモリの上限を超えたら grace ハッシュ結合に切り替える。右の残りの行と左のすべての行を、
+// キーのハッシュ値で hashPartitions 個の一時ファイルに分け、同じ番号の組ごとに結合する。
+// 1組の右の行がまだ上限を超える場合は、別のハッシュ関数でさらに分ける。
+//
+// InnerJoin では、行の数の見積もりが少ないほうの入力でハッシュ表を作る。左でハッシュ表を作るときは
+// 内部で左右を入れ替え、結果の行だけを元の左、右の列の順に並べる。EXPLAIN では、行を引く側、
+// ハッシュ表を作る側の順に下の演算子を表示する。
+
+const (
+	// hashJoinMemory はハッシュ結合がハッシュ表に使うメモリの上限（バイト）です。
+	hashJoinMemory = 16 << 20
+	// hashPartitions は一時ファイルに分けるときのパーティションの数です。
+	hashPartitions = 16
+	// maxPartitionDepth はパーティションをさらに分ける回数の上限です。同じキーの行が
+	// 多すぎて分けても小さくならない場合は、上限を超えてもメモリで結合します。
+	maxPartitionDepth = 3
+	// rowOverhead はハッシュ表の1行あたりの管理領域の見積もりです。
+	rowOverhead = 64
+)
+
+// HashJoin は等号の条件による結合をハッシュ表で行います。
+type HashJoin struct {
+	left, right Operator
+	lkeys       []Expr
+	rkeys       []Expr
+	ktypes      []types.Type // キーをそろえる型。types.Null ならそのまま
+	typ         JoinType
+	on          Expr
+	mem         int64
+	cols        []Column
+	nl, nr      int
+	swapped     bool // 元の左の入力でハッシュ表を作るなら true。left、right とキーは入れ替えてある
+
+	table   map[string][][]types.Value
+	size    int64
+	queue   []partition
+	cur     partition // 結合中のパーティション（メモリだけなら空）
+	nextRow func() ([]types.Value, bool, error)
+	outer   []types.Value
+	found   bool
+	matches [][]types.Value
+	i       int
+	key     []byte
+	row     []types.Value
+}
+
+// partition は一時ファイルに分けた左右の行の組です。
+type partition struct {
+	left, right *spillFile
+	depth       int
+}
+
+func (p partition) close() error {
+	var errs []error
+	for _, f := range []*spillFile{p.left, p.right} {
+		if f != nil {
+			errs = append(errs, f.Close())
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// NewHashJoin は HashJoin を作ります。lkeys[i] を左の行、rkeys[i] を右の行に対して評価した値が
+// すべて等しい組み合わせのうち、on が真になるものを返します。ktypes はキーをそろえる型です。
+// typ は InnerJoin か LeftJoin です。
+func NewHashJoin(left, right Operator, lkeys, rkeys []Expr, ktypes []types.Type, typ JoinType, on Expr) *HashJoin {
+	return &HashJoin{
+		left: left, right: right, lkeys: lkeys, rkeys: rkeys, ktypes: ktypes, typ: typ, on: on,
+		mem: hashJoinMemory, cols: JoinColumns(left, right),
+		nl: len(left.Columns()), nr: len(right.Columns()),
+	}
+}
+
+// buildLeft は j を、左の入力でハッシュ表を作り、右の行で引くように変えます。結果の行の列の順は
+// 変わりません。typ が InnerJoin のときだけ使えます。
+func (j *HashJoin) buildLeft() {
+	j.left, j.right = j.right, j.left
+	j.lkeys, j.rkeys = j.rkeys, j.lkeys
+	j.nl, j.nr = j.nr, j.nl
+	j.swapped = !j.swapped
+}
+
+func (j *HashJoin) Columns() []Column { return j.cols }
+
+func (j *HashJoin) Open() error {
+	if err := j.reset(); err != nil {
+		return err
+	}
+	if err := j.build(); err != nil {
+		return err
+	}
+	if err := j.left.Open(); err != nil {
+		return err
+	}
+	if len(j.queue) == 0 {
+		j.nextRow = j.left.Next
+		return nil
+	}
+	// 右が一時ファイルに分かれたので、左も同じように分ける
+	for {
+		row, ok, err := j.left.Next()
+		if err != nil {
+			return err
+		}
+		if !ok {
+			break
+		}
+		key, ok, err := j.makeKey(j.lkeys, row)
+		if err != nil {
+			return err
+		}
+		part := 0 // キーが NULL の行はどれとも一致しないので、どのパーティションでもよい
+		if ok {
+			part = partitionOf(key, 0)
+		} else if j.typ == InnerJoin {
+			continue
+		}
+		if err := j.queue[part].left.Write(row); err != nil {
+			return err
+		}
+	}
+	return j.nextPartition()
+}
+
+// build は右の行を読んでハッシュ表を作ります。メモリの上限を超えたら、
+// 残りの行はパーティションの一時ファイルに書きます。
+func (j *HashJoin) build() error {
+	if err := j.right.Open(); err != nil {
+		return err
+	}
+	defer j.right.Close()
+	for {
+		row, ok, err := j.right.Next()
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return nil
+		}
+		key, ok, err := j.makeKey(j.rkeys, row)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+		row = append([]types.Value(nil), row...)
+		if j.queue != nil {
+			if err := j.queue[partitionOf(key, 0)].right.Write(row); err != nil {
+				return err
+			}
+			continue
+		}
+		j.add(key, row)
+		if j.size > j.mem {
+			if j.queue, err = j.spill(0); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// spill はハッシュ表の行を depth 段目のパーティションに書き出し、ハッシュ表を空にします。
+func (j *HashJoin) spill(depth int) ([]partition, error) {
+	parts := make([]partition, hashPartitions)
+	for i := range parts {
+		l, err := newSpillFile()
+		if err != nil {
+			closeAll(parts)
+			return nil, err
+		}
+		r, err := newSpillFile()
+		if err != nil {
+			l.Close()
+			closeAll(parts)
+			return nil, err
+		}
+		parts[i] = partition{left: l, right: r, depth: depth}
+	}
+	for key, rows := range j.table {
+		p := parts[partitionOf([]byte(key), depth)]
+		for _, row := range rows {
+			if err := p.right.Write(row); err != nil {
+				closeAll(parts)
+				return nil, err
+			}
+		}
+	}
+	j.table, j.size = make(map[string][][]types.Value), 0
+	return parts, nil
+}
+
+// nextPartition は次のパーティションの右の行でハッシュ表を作り、左の行を読み始めます。
+// パーティションがなくなると、それ以上行を返さないようにします。
+func (j *HashJoin) nextPartition() error {
+	for {
+		if err := j.cur.close(); err != nil {
+			return err
+		}
+		j.cur = partition{}
+		j.table, j.size = make(map[string][][]types.Value), 0
+		if len(j.queue) == 0 {
+			j.nextRow = func() ([]types.Value, bool, error) { return nil, false, nil }
+			return nil
+		}
+		j.cur, j.queue = j.queue[0], j.queue[1:]
+		if err := j.cur.right.Rewind(); err != nil {
+			return err
+		}
+		if err := j.cur.left.Rewind(); err != nil {
+			return err
+		}
+		over := false
+		for {
+			row, ok, err := j.cur.right.Read()
+			if err != nil {
+				return err
+			}
+			if !ok {
+				break
+			}
+			key, _, err := j.makeKey(j.rkeys, row)
+			if err != nil {
+				return err
+			}
+			j.add(key, row)
+			if j.size > j.mem && j.cur.depth+1 < maxPartitionDepth {
+				over = true
+				break
+			}
+		}
+		if !over {
+			j.nextRow = j.cur.left.Read
+			return nil
+		}
+		if err := j.repartition(); err != nil {
+			return err
+		}
+	}
+}
+
+// repartition は結合中のパーティションを、1段深いハッシュ関数でさらに分けて待ち行列に加えます。
+func (j *HashJoin) repartition() error {
+	depth := j.cur.depth + 1
+	parts, err := j.spill(depth)
+	if err != nil {
+		return err
+	}
+	j.queue = append(j.queue, parts...)
+	move := func(from *spillFile, keys []Expr, side func(partition) *spillFile) error {
+		for {
+			row, ok, err := from.Read()
+			if err != nil || !ok {
+				return err
+			}
+			key, ok, err := j.makeKey(keys, row)
+			if err != nil {
+				return err
+			}
+			part := 0
+			if ok {
+				part = partitionOf(key, depth)
+			}
+			if err := side(parts[part]).Write(row); err != nil {
+				return err
+			}
+		}
+	}
+	if err := move(j.cur.right, j.rkeys, func(p partition) *spillFile { return p.right }); err != nil {
+		return err
+	}
+	return move(j.cur.left, j.lkeys, func(p partition) *spillFile { return p.left })
+}
+
+func (j *HashJoin) add(key []byte, row []types.Value) {
+	j.table[string(key)] = append(j.table[string(key)], row)
+	j.size += int64(len(key) + rowOverhead)
+	for _, v := range row {
+		j.size += int64(16 + len(v.Text()) + len(v.Blob()))
+	}
+}
+
+// makeKey は行 row のキーを作ります。キーに NULL がある場合や、共通の型に変換できない場合は
+// どの行とも一致しないので false を返します。
+func (j *HashJoin) makeKey(keys []Expr, row []types.Value) ([]byte, bool, error) {
+	var ok bool
+	var err error
+	j.key, ok, err = appendJoinKey(j.key[:0], keys, j.ktypes, row)
+	return j.key, ok, err
+}
+
+// partitionOf はキーを depth 段目のハッシュ関数でパーティションに振り分けます。
+func partitionOf(key []byte, depth int) int {
+	h := fnv.New64a()
+	h.Write([]byte{byte(depth)})
+	h.Write(key)
+	return int(h.Sum64() % hashPartitions)
+}
+
+func (j *HashJoin) Next() ([]types.Value, bool, error) {
+	for {
+		if j.i < len(j.matches) {
+			if j.swapped {
+				j.row = joinRow(j.row, j.matches[j.i], j.nr, j.outer, j.nl)
+			} else {
+				j.row = joinRow(j.row, j.outer, j.nl, j.matches[j.i], j.nr)
+			}
+			j.i++
+			v, err := j.on(j.row)
+			if err != nil {
+				return nil, false, err
+			}
+			if Truth(v) {
+				j.found = true
+				return j.row, true, nil
+			}
+			continue
+		}
+		if j.outer != nil {
+			outer := j.outer
+			j.outer = nil
+			if !j.found && j.typ == LeftJoin {
+				j.row = joinRow(j.row, outer, j.nl, nil, j.nr)
+				return j.row, true, nil
+			}
+		}
+		row, ok, err := j.nextRow()
+		if err != nil {
+			return nil, false, err
+		}
+		if !ok {
+			if j.cur.left == nil {
+				return nil, false, nil
+			}
+			if err := j.nextPartition(); err != nil {
+				return nil, false, err
+			}
+			continue
+		}
+		key, ok, err := j.makeKey(j.lkeys, row)
+		if err != nil {
+			return nil, false, err
+		}
+		j.outer, j.found, j.matches, j.i = row, false, nil, 0
+		if ok {
+			j.matches = j.table[string(key)]
+		}
+	}
+}
+
+// reset はハッシュ表と一時ファイルを捨てます。
+func (j *HashJoin) reset() error {
+	err := errors.Join(j.cur.close(), closeAll(j.queue))
+	j.cur, j.queue = partition{}, nil
+	j.table, j.size = make(map[string][][]types.Value), 0
+	j.matches, j.i, j.outer = nil, 0, nil
+	return err
+}
+
+func (j *HashJoin) Close() error {
+	return errors.Join(j.reset(), j.left.Close())
+}
+
+func closeAll(parts []partition) error {
+	var errs []error
+	for _, p := range parts {
+		errs = append(errs, p.close())
+	}
+	return errors.Join(errs...)
+}
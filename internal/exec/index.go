@@ -0,0 +1,247 @@
+package exec
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/k-sml/go-rdbms/internal/catalog"
+	"github.com/k-sml/go-rdbms/internal/sql/ast"
+	"github.com/k-sml/go-rdbms/internal/types"
+)
+
+// bounds は1つの列に対する条件から分かる値の範囲です。
+type bounds struct {
+	eq             *bound
+	lo, hi         *bound
+	loOpen, hiOpen bool
+
+	// eqCond、loCond、hiCond は eq、lo、hi を決めた条件です。範囲が条件をすべて表すとは
+	// 限らない（LIKE）ときは nil です。
+	eqCond, loCond, hiCond ast.Expr
+}
+
+// rangeCond は下限か上限を決めた条件 c が範囲だけで満たされるなら c を、そうでなければ nil を
+// 返します。BETWEEN は下限と上限の両方を決めたときだけ満たされます。
+func (b *bounds) rangeCond(c ast.Expr) ast.Expr {
+	if _, ok := c.(*ast.Between); ok && (b.loCond != c || b.hiCond != c) {
+		return nil
+	}
+	return c
+}
+
+// bound は範囲の境界の値です。param が0でなければ、値は準備した文の param 番目の引数で、
+// 実行するときに決まります。
+type bound struct {
+	v     types.Value
+	param int
+}
+
+// Conjuncts は AND でつながった条件を分けて返します。
+func Conjuncts(e ast.Expr) []ast.Expr {
+	if b, ok := e.(*ast.Binary); ok && b.Op == "AND" {
+		return append(Conjuncts(b.L), Conjuncts(b.R)...)
+	}
+	return []ast.Expr{e}
+}
+
+// flip は左右を入れ替えた比較演算子を返します。
+var flip = map[string]string{"=": "=", "<": ">", "<=": ">=", ">": "<", ">=": "<="}
+
+// columnBounds は where の条件のうち「列 演算子 定数」の形のものから、テーブル t の列ごとの
+// 値の範囲を集めます。定数は列の型に変換し、値が変わってしまうものは使いません。
+// 準備した文の引数も定数として扱います。「列 LIKE 定数」は、パターンの接頭辞から始まる
+// 範囲とみなします。
+func columnBounds(t *catalog.Table, alias string, where ast.Expr, params bool) map[int]*bounds {
+	out := make(map[int]*bounds)
+	get := func(i int) *bounds {
+		if out[i] == nil {
+			out[i] = &bounds{}
+		}
+		return out[i]
+	}
+	column := func(e ast.Expr) (int, bool) {
+		ref, ok := e.(*ast.ColumnRef)
+		if !ok || ref.Table != "" && !strings.EqualFold(ref.Table, alias) {
+			return -1, false
+		}
+		return t.Column(ref.Column)
+	}
+	constant := func(e ast.Expr, i int) (*bound, bool) {
+		if p, ok := e.(*ast.Param); ok && params {
+			return &bound{param: p.Index}, true
+		}
+		lit, ok := e.(*ast.Literal)
+		if !ok || lit.Value.IsNull() {
+			return nil, false
+		}
+		v, err := types.Coerce(lit.Value, t.Columns[i].Type)
+		if err != nil || !types.Equal(v, lit.Value) {
+			return nil, false
+		}
+		return &bound{v: v}, true
+	}
+	add := func(i int, op string, v *bound, cond ast.Expr) {
+		b := get(i)
+		switch op {
+		case "=":
+			b.eq, b.eqCond = v, cond
+		case ">", ">=":
+			b.lo, b.loOpen, b.loCond = v, op == ">", cond
+		case "<", "<=":
+			b.hi, b.hiOpen, b.hiCond = v, op == "<", cond
+		}
+	}
+	for _, c := range Conjuncts(where) {
+		switch c := c.(type) {
+		case *ast.Binary:
+			op, ok := flip[c.Op]
+			if !ok {
+				continue
+			}
+			if i, ok := column(c.L); ok {
+				if v, ok := constant(c.R, i); ok {
+					add(i, c.Op, v, c)
+				}
+			} else if i, ok := column(c.R); ok {
+				if v, ok := constant(c.L, i); ok {
+					add(i, op, v, c)
+				}
+			}
+		case *ast.Between:
+			if c.Not {
+				continue
+			}
+			if i, ok := column(c.X); ok {
+				lo, ok1 := constant(c.Lo, i)
+				hi, ok2 := constant(c.Hi, i)
+				if ok1 && ok2 {
+					add(i, ">=", lo, c)
+					add(i, "<=", hi, c)
+				}
+			}
+		case *ast.Like:
+			i, ok := column(c.X)
+			if !ok || t.Columns[i].Type != types.Text {
+				continue
+			}
+			if lo, hi, ok := likeBounds(c); ok {
+				add(i, ">=", &bound{v: lo}, nil)
+				if hi != nil {
+					add(i, "<", &bound{v: *hi}, nil)
+				}
+			}
+		}
+	}
+	return out
+}
+
+// chooseIndex は where の条件で読む範囲を絞れるインデックスのうち、読む費用の見積もりが
+// 最も小さいものを選びます。
+func (p *planner) chooseIndex(t *catalog.Table, alias string, where ast.Expr) (*catalog.Index, *indexBounds, bool) {
+	cb := columnBounds(t, alias, where, p.params != nil)
+	if len(cb) == 0 {
+		return nil, nil, false
+	}
+	var best *catalog.Index
+	var bestRange *indexBounds
+	var bestCost float64
+	for _, ix := range p.src.Indexes(t.Name) {
+		r := &indexBounds{}
+		score := 0
+		for _, name := range ix.Columns {
+			i, _ := t.Column(name)
+			b := cb[i]
+			if b == nil {
+				break
+			}
+			r.types = append(r.types, t.Columns[i].Type)
+			if b.eq != nil {
+				r.lo = append(r.lo, *b.eq)
+				r.hi = append(r.hi, *b.eq)
+				r.addCond(b.eqCond)
+				score += 2
+				continue
+			}
+			if b.lo == nil && b.hi == nil {
+				break
+			}
+			eq := r.lo
+			if b.lo != nil {
+				r.lo = append(append([]bound(nil), eq...), *b.lo)
+				r.loOpen = b.loOpen
+				r.addCond(b.rangeCond(b.loCond))
+			}
+			if b.hi != nil {
+				r.hi = append(append([]bound(nil), eq...), *b.hi)
+				r.hiOpen = b.hiOpen
+				r.addCond(b.rangeCond(b.hiCond))
+			}
+			score++
+			break
+		}
+		if score == 0 {
+			continue
+		}
+		cost := p.est.indexScanCost(t, ix, p.est.boundsSelectivity(t, ix, r)).Total
+		if best == nil || cost < bestCost {
+			best, bestRange, bestCost = ix, r, cost
+		}
+	}
+	return best, bestRange, best != nil
+}
+
+// indexBounds はインデックスを読む範囲の境界です。引数の境界を含まなければ、そのまま
+// IndexRange になります。
+type indexBounds struct {
+	lo, hi         []bound
+	loOpen, hiOpen bool
+	types          []types.Type // インデックスの先頭からの列の型
+	conds          []ast.Expr   // 範囲だけで満たされる条件
+}
+
+// addCond は範囲だけで満たされる条件に c を加えます。
+func (b *indexBounds) addCond(c ast.Expr) {
+	if c != nil && !slices.Contains(b.conds, c) {
+		b.conds = append(b.conds, c)
+	}
+}
+
+// hasParams は境界に準備した文の引数を含むかを返します。
+func (b *indexBounds) hasParams() bool {
+	for _, bs := range [][]bound{b.lo, b.hi} {
+		for _, x := range bs {
+			if x.param != 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// resolve は引数の値を params から読んで、読む範囲を決めます。引数の値を列の型に
+// 変えずに変換できなければ、その列からは範囲を絞りません。
+func (b *indexBounds) resolve(params *Params) IndexRange {
+	values := func(bs []bound) ([]types.Value, bool) {
+		var out []types.Value
+		for i, x := range bs {
+			v := x.v
+			if x.param != 0 {
+				p := params.value(x.param)
+				c, err := types.Coerce(p, b.types[i])
+				if err != nil || p.IsNull() || !types.Equal(c, p) {
+					return out, false
+				}
+				v = c
+			}
+			out = append(out, v)
+		}
+		return out, true
+	}
+	var r IndexRange
+	var ok bool
+	r.Lo, ok = values(b.lo)
+	r.LoOpen = ok && b.loOpen
+	r.Hi, ok = values(b.hi)
+	r.HiOpen = ok && b.hiOpen
+	return r
+}
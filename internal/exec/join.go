@@ -0,0 +1,422 @@
+package exec
+
+import (
+	"github.com/k-sml/go-rdbms/internal/catalog"
+	"github.com/k-sml/go-rdbms/internal/sql/ast"
+	"github.com/k-sml/go-rdbms/internal/types"
+)
+
+// JoinType は結合の種類です。RIGHT OUTER JOIN は左右を入れ替えた LeftJoin として実行します。
+type JoinType int
+
+const (
+	InnerJoin JoinType = iota // 条件を満たす組み合わせだけを返す
+	LeftJoin                  // 一致する右の行がない左の行も、右を NULL にして返す
+	FullJoin                  // LeftJoin に加えて、一致する左の行がない右の行も返す
+)
+
+// joinRow は左の行 l と右の行 r をつなげた行を buf に作ります。nil の側は NULL で埋めます。
+func joinRow(buf, l []types.Value, nl int, r []types.Value, nr int) []types.Value {
+	buf = buf[:0]
+	if l == nil {
+		for range nl {
+			buf = append(buf, types.NullValue())
+		}
+	} else {
+		buf = append(buf, l...)
+	}
+	if r == nil {
+		for range nr {
+			buf = append(buf, types.NullValue())
+		}
+	} else {
+		buf = append(buf, r...)
+	}
+	return buf
+}
+
+// NestedLoopJoin は左の各行と右のすべての行を組み合わせ、条件が真になるものを返します。
+// 右の行は Open のときにすべて読んでメモリに保持します。
+type NestedLoopJoin struct {
+	left, right Operator
+	typ         JoinType
+	on          Expr // nil なら直積
+	cols        []Column
+	nl, nr      int
+	inner       [][]types.Value
+	matched     []bool // FullJoin で、左のどれかの行と一致した右の行
+	outer       []types.Value
+	found       bool // outer と一致する右の行があった
+	i           int
+	leftDone    bool
+	row         []types.Value
+}
+
+// NewNestedLoopJoin は種類が typ の NestedLoopJoin を作ります。on は左右の列をつなげた行に対して評価します。
+func NewNestedLoopJoin(left, right Operator, typ JoinType, on Expr) *NestedLoopJoin {
+	return &NestedLoopJoin{
+		left: left, right: right, typ: typ, on: on, cols: JoinColumns(left, right),
+		nl: len(left.Columns()), nr: len(right.Columns()),
+	}
+}
+
+// JoinColumns は left と right をつなげた行の列を返します。
+func JoinColumns(left, right Operator) []Column {
+	return append(append([]Column(nil), left.Columns()...), right.Columns()...)
+}
+
+func (j *NestedLoopJoin) Columns() []Column { return j.cols }
+
+func (j *NestedLoopJoin) Open() error {
+	inner, err := Collect(j.right)
+	if err != nil {
+		return err
+	}
+	j.inner, j.outer, j.i, j.leftDone = inner, nil, 0, false
+	j.matched = nil
+	if j.typ == FullJoin {
+		j.matched = make([]bool, len(inner))
+	}
+	return j.left.Open()
+}
+
+func (j *NestedLoopJoin) Next() ([]types.Value, bool, error) {
+	for {
+		if j.leftDone {
+			// FullJoin では、最後にどの左の行とも一致しなかった右の行を返す
+			for ; j.i < len(j.matched); j.i++ {
+				if !j.matched[j.i] {
+					j.i++
+					j.row = joinRow(j.row, nil, j.nl, j.inner[j.i-1], j.nr)
+					return j.row, true, nil
+				}
+			}
+			return nil, false, nil
+		}
+		if j.outer == nil {
+			row, ok, err := j.left.Next()
+			if err != nil {
+				return nil, false, err
+			}
+			if !ok {
+				j.leftDone, j.i = true, 0
+				continue
+			}
+			j.outer, j.i, j.found = row, 0, false
+		}
+		if j.i >= len(j.inner) {
+			outer := j.outer
+			j.outer = nil
+			if !j.found && j.typ != InnerJoin {
+				j.row = joinRow(j.row, outer, j.nl, nil, j.nr)
+				return j.row, true, nil
+			}
+			continue
+		}
+		j.row = joinRow(j.row, j.outer, j.nl, j.inner[j.i], j.nr)
+		j.i++
+		if j.on != nil {
+			v, err := j.on(j.row)
+			if err != nil {
+				return nil, false, err
+			}
+			if !Truth(v) {
+				continue
+			}
+		}
+		j.found = true
+		if j.matched != nil {
+			j.matched[j.i-1] = true
+		}
+		return j.row, true, nil
+	}
+}
+
+func (j *NestedLoopJoin) Close() error {
+	j.inner, j.matched = nil, nil
+	return j.left.Close()
+}
+
+// IndexNestedLoopJoin は左の各行について、右のテーブルのインデックスを結合のキーで引き、
+// 一致する行とだけ組み合わせます。右のテーブルを毎回すべて読む NestedLoopJoin と違い、
+// 左の1行あたりインデックスの範囲1つ分しか読みません。
+type IndexNestedLoopJoin struct {
+	left   Operator
+	src    Source
+	table  *catalog.Table
+	index  *catalog.Index
+	keys   []Expr // 左の行からインデックスの先頭の列の値を計算する式
+	typ    JoinType
+	on     Expr
+	cols   []Column
+	read   []bool // nil でなければ、右のテーブルで値を読む列
+	nl, nr int
+	outer  []types.Value
+	found  bool
+	it     RowIter
+	row    []types.Value
+}
+
+// NewIndexNestedLoopJoin は IndexNestedLoopJoin を作ります。右はテーブル t を別名 alias で読み、
+// keys は左の行に対して評価してインデックス ix の先頭の列と等しい値を求めます。
+// on は左右の列をつなげた行に対して評価する結合の条件全体です。typ は InnerJoin か LeftJoin です。
+func NewIndexNestedLoopJoin(left Operator, src Source, t *catalog.Table, alias string, ix *catalog.Index, keys []Expr, typ JoinType, on Expr) *IndexNestedLoopJoin {
+	cols := append(append([]Column(nil), left.Columns()...), TableColumns(t, alias)...)
+	return &IndexNestedLoopJoin{
+		left: left, src: src, table: t, index: ix, keys: keys, typ: typ, on: on, cols: cols,
+		nl: len(left.Columns()), nr: len(t.Columns),
+	}
+}
+
+func (j *IndexNestedLoopJoin) Columns() []Column { return j.cols }
+
+// Table は右のテーブルを返します。
+func (j *IndexNestedLoopJoin) Table() *catalog.Table { return j.table }
+
+// Index は右のテーブルを引くインデックスを返します。
+func (j *IndexNestedLoopJoin) Index() *catalog.Index { return j.index }
+
+func (j *IndexNestedLoopJoin) Open() error {
+	j.outer, j.it, j.found = nil, nil, false
+	return j.left.Open()
+}
+
+func (j *IndexNestedLoopJoin) Next() ([]types.Value, bool, error) {
+	for {
+		if j.outer == nil {
+			row, ok, err := j.left.Next()
+			if err != nil || !ok {
+				return nil, false, err
+			}
+			r, ok, err := j.probe(row)
+			if err != nil {
+				return nil, false, err
+			}
+			j.outer, j.found, j.it = row, false, nil
+			if ok {
+				if j.it, err = j.src.ScanIndex(j.index, r, j.read); err != nil {
+					return nil, false, err
+				}
+			}
+		}
+		var inner []types.Value
+		if j.it != nil {
+			var ok bool
+			var err error
+			if _, inner, ok, err = j.it.Next(); err != nil {
+				return nil, false, err
+			}
+			if !ok {
+				inner, j.it = nil, nil
+			}
+		}
+		if inner == nil {
+			outer := j.outer
+			j.outer = nil
+			if !j.found && j.typ == LeftJoin {
+				j.row = joinRow(j.row, outer, j.nl, nil, j.nr)
+				return j.row, true, nil
+			}
+			continue
+		}
+		j.row = joinRow(j.row, j.outer, j.nl, inner, j.nr)
+		v, err := j.on(j.row)
+		if err != nil {
+			return nil, false, err
+		}
+		if Truth(v) {
+			j.found = true
+			return j.row, true, nil
+		}
+	}
+}
+
+// probe は左の行 row に対してインデックスを読む範囲を返します。キーに NULL がある場合や、
+// 列の型に変換すると値が変わる場合は一致する行がないので false を返します。
+func (j *IndexNestedLoopJoin) probe(row []types.Value) (IndexRange, bool, error) {
+	vals := make([]types.Value, len(j.keys))
+	for i, k := range j.keys {
+		v, err := k(row)
+		if err != nil {
+			return IndexRange{}, false, err
+		}
+		if v.IsNull() {
+			return IndexRange{}, false, nil
+		}
+		c, _ := j.table.Column(j.index.Columns[i])
+		w, err := types.Coerce(v, j.table.Columns[c].Type)
+		if err != nil || !types.Equal(v, w) {
+			return IndexRange{}, false, nil
+		}
+		vals[i] = w
+	}
+	return IndexRange{Lo: vals, Hi: vals}, true, nil
+}
+
+func (j *IndexNestedLoopJoin) Close() error {
+	j.it = nil
+	return j.left.Close()
+}
+
+// indexJoin は左の演算子 left と右のテーブル tn の結合に、右のテーブルのインデックスを
+// 使えるかを調べます。on の中の「右の列 = 左の列だけの式」の条件で先頭の列が決まる
+// インデックスがあれば、IndexNestedLoopJoin を返します。
+func (p *planner) indexJoin(left Operator, tn *ast.TableName, typ JoinType, on ast.Expr) (Operator, bool, error) {
+	if _, ok := p.cte(tn.Name); ok {
+		return nil, false, nil
+	}
+	if _, ok := p.src.View(tn.Name); ok {
+		return nil, false, nil
+	}
+	t, err := p.src.Table(tn.Name)
+	if err != nil {
+		return nil, false, err
+	}
+	if t.System {
+		return nil, false, nil
+	}
+	alias := tn.Alias
+	if alias == "" {
+		alias = t.Name
+	}
+	lcols, rcols := left.Columns(), TableColumns(t, alias)
+	cond, err := p.compile(on, append(append([]Column(nil), lcols...), rcols...))
+	if err != nil {
+		return nil, false, err
+	}
+
+	// 右の列の番号から、その列と等しい左の行の式へ
+	eq := make(map[int]ast.Expr)
+	right := func(e ast.Expr) (int, bool) {
+		ref, ok := e.(*ast.ColumnRef)
+		if !ok {
+			return -1, false
+		}
+		i, err := resolve(rcols, ref)
+		return i, err == nil
+	}
+	outer := func(e ast.Expr) bool {
+		_, err := Compile(e, lcols)
+		return err == nil
+	}
+	for _, c := range Conjuncts(on) {
+		b, ok := c.(*ast.Binary)
+		if !ok || b.Op != "=" {
+			continue
+		}
+		if i, ok := right(b.L); ok && outer(b.R) {
+			eq[i] = b.R
+		} else if i, ok := right(b.R); ok && outer(b.L) {
+			eq[i] = b.L
+		}
+	}
+	if len(eq) == 0 {
+		return nil, false, nil
+	}
+
+	var best *catalog.Index
+	var bestKeys []ast.Expr
+	for _, ix := range p.src.Indexes(t.Name) {
+		var keys []ast.Expr
+		for _, name := range ix.Columns {
+			i, _ := t.Column(name)
+			e, ok := eq[i]
+			if !ok {
+				break
+			}
+			keys = append(keys, e)
+		}
+		if len(keys) > len(bestKeys) || len(keys) == len(bestKeys) && len(keys) > 0 && ix.Unique && !best.Unique {
+			best, bestKeys = ix, keys
+		}
+	}
+	if best == nil {
+		return nil, false, nil
+	}
+	keys := make([]Expr, len(bestKeys))
+	for i, e := range bestKeys {
+		if keys[i], err = Compile(e, lcols); err != nil {
+			return nil, false, err
+		}
+	}
+	j := NewIndexNestedLoopJoin(left, p.src, t, alias, best, keys, typ, cond)
+	j.read = p.uses.columns(t, alias)
+	return j, true, nil
+}
+
+// joinKeyType は結合のキーの式 l と r をそろえる型を返します。
+func joinKeyType(l, r types.Type) types.Type {
+	if t, ok := types.Common(l, r); ok {
+		return t
+	}
+	if l == types.Timestamp || r == types.Timestamp {
+		return types.Timestamp
+	}
+	return types.Null
+}
+
+// joinKey は結合のキーの値 v を型 t にそろえます。t が types.Null ならそのままです。
+// v が NULL の場合や、t に変換すると値が変わる場合は、どの値とも等しくならないので false を返します。
+func joinKey(v types.Value, t types.Type) (types.Value, bool) {
+	if v.IsNull() {
+		return v, false
+	}
+	if t == types.Null {
+		return v, true
+	}
+	w, err := types.Coerce(v, t)
+	if err != nil || !types.Equal(v, w) {
+		return v, false
+	}
+	return w, true
+}
+
+// appendJoinKey は行 row に keys を評価した値を、ktypes の型にそろえて buf に加えます。
+// キーに NULL がある場合や、共通の型に変換できない場合はどの行とも一致しないので false を返します。
+func appendJoinKey(buf []byte, keys []Expr, ktypes []types.Type, row []types.Value) ([]byte, bool, error) {
+	for i, k := range keys {
+		v, err := k(row)
+		if err != nil {
+			return buf, false, err
+		}
+		v, ok := joinKey(v, ktypes[i])
+		if !ok {
+			return buf, false, nil
+		}
+		buf = types.AppendKey(buf, v)
+	}
+	return buf, true, nil
+}
+
+// equiKeys は on の中の「左の列の式 = 右の列の式」の条件を集め、HashJoin や MergeJoin の
+// キーにします。ktypes はキーをそろえる型です。
+func equiKeys(left, right Operator, on ast.Expr) (lkeys, rkeys []Expr, ktypes []types.Type) {
+	lcols, rcols := left.Columns(), right.Columns()
+	side := func(e ast.Expr, cols []Column) (Expr, bool) {
+		if _, err := Compile(e, nil); err == nil {
+			return nil, false // 列を参照しない式はキーにしない
+		}
+		f, err := Compile(e, cols)
+		return f, err == nil
+	}
+	for _, c := range Conjuncts(on) {
+		b, ok := c.(*ast.Binary)
+		if !ok || b.Op != "=" {
+			continue
+		}
+		l, r := b.L, b.R
+		lf, ok1 := side(l, lcols)
+		rf, ok2 := side(r, rcols)
+		if !ok1 || !ok2 {
+			l, r = r, l
+			if lf, ok1 = side(l, lcols); ok1 {
+				rf, ok2 = side(r, rcols)
+			}
+		}
+		if ok1 && ok2 {
+			lkeys, rkeys = append(lkeys, lf), append(rkeys, rf)
+			ktypes = append(ktypes, joinKeyType(TypeOf(l, lcols, nil), TypeOf(r, rcols, nil)))
+		}
+	}
+	return lkeys, rkeys, ktypes
+}
@@ -0,0 +1,279 @@
+package exec
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/k-sml/go-rdbms/internal/sql/ast"
+	"github.com/k-sml/go-rdbms/internal/types"
+)
+
+// パターンの照合
+//
+// LIKE では % が0文字以上の任意の文字列に、_ が任意の1文字に一致する。ESCAPE で指定した文字の
+// 次の文字は、% や _ でもその文字自身に一致する。GLOB では * と ? が % と _ にあたり、
+// [abc] や [a-z] はかっこの中のいずれかの文字に、[^abc] はそれ以外の文字に一致する。
+// どちらも大文字と小文字を区別する。
+//
+// パターンが定数で、先頭が % や * で始まらなければ、一致する文字列はその前の部分（接頭辞）で
+// 始まる。columnBounds はこれを接頭辞から始まる範囲の条件とみなし、インデックスで読む範囲を絞る。
+
+// patElem はパターンの1文字分の要素です。
+type patElem struct {
+	kind   patKind
+	r      rune      // patLiteral の文字
+	ranges [][2]rune // patClass の文字の範囲
+	negate bool      // patClass で範囲に含まれない文字に一致する
+}
+
+type patKind int
+
+const (
+	patLiteral patKind = iota // r に一致する
+	patOne                    // 任意の1文字に一致する
+	patAny                    // 0文字以上の任意の文字列に一致する
+	patClass                  // ranges のいずれかに含まれる1文字に一致する
+)
+
+// pattern はコンパイルしたパターンです。
+type pattern []patElem
+
+// likePattern は LIKE のパターン s をコンパイルします。escape が0でなければ ESCAPE の文字です。
+func likePattern(s string, escape rune) (pattern, error) {
+	var p pattern
+	for i := 0; i < len(s); {
+		r, n := utf8.DecodeRuneInString(s[i:])
+		i += n
+		switch {
+		case escape != 0 && r == escape:
+			if i == len(s) {
+				return nil, fmt.Errorf("LIKE pattern must not end with escape character")
+			}
+			r, n = utf8.DecodeRuneInString(s[i:])
+			i += n
+			p = append(p, patElem{kind: patLiteral, r: r})
+		case r == '%':
+			p = append(p, patElem{kind: patAny})
+		case r == '_':
+			p = append(p, patElem{kind: patOne})
+		default:
+			p = append(p, patElem{kind: patLiteral, r: r})
+		}
+	}
+	return p, nil
+}
+
+// globPattern は GLOB のパターン s をコンパイルします。
+func globPattern(s string) (pattern, error) {
+	var p pattern
+	for i := 0; i < len(s); {
+		r, n := utf8.DecodeRuneInString(s[i:])
+		i += n
+		switch r {
+		case '*':
+			p = append(p, patElem{kind: patAny})
+		case '?':
+			p = append(p, patElem{kind: patOne})
+		case '[':
+			e := patElem{kind: patClass}
+			if strings.HasPrefix(s[i:], "^") {
+				e.negate = true
+				i++
+			}
+			// ] がかっこの最初にあれば、閉じかっこではなく文字として扱う。
+			first := true
+			for {
+				if i >= len(s) {
+					return nil, fmt.Errorf("malformed GLOB pattern: %q", s)
+				}
+				lo, n := utf8.DecodeRuneInString(s[i:])
+				i += n
+				if lo == ']' && !first {
+					break
+				}
+				first = false
+				hi := lo
+				if strings.HasPrefix(s[i:], "-") && i+1 < len(s) && s[i+1] != ']' {
+					hi, n = utf8.DecodeRuneInString(s[i+1:])
+					i += 1 + n
+				}
+				e.ranges = append(e.ranges, [2]rune{lo, hi})
+			}
+			p = append(p, e)
+		default:
+			p = append(p, patElem{kind: patLiteral, r: r})
+		}
+	}
+	return p, nil
+}
+
+// matchRune は要素 e が1文字 r に一致するかを返します。e は patAny ではありません。
+func (e *patElem) matchRune(r rune) bool {
+	switch e.kind {
+	case patLiteral:
+		return e.r == r
+	case patClass:
+		for _, rg := range e.ranges {
+			if rg[0] <= r && r <= rg[1] {
+				return !e.negate
+			}
+		}
+		return e.negate
+	}
+	return true
+}
+
+// match は文字列 s 全体がパターンに一致するかを返します。最後に見た patAny の位置を覚えておき、
+// 一致しなくなったらそこに戻って patAny に1文字多く一致させてやり直します。
+func (p pattern) match(s string) bool {
+	pi, si := 0, 0
+	star, starSi := -1, 0
+	for si < len(s) {
+		if pi < len(p) && p[pi].kind == patAny {
+			star, starSi = pi, si
+			pi++
+			continue
+		}
+		r, n := utf8.DecodeRuneInString(s[si:])
+		if pi < len(p) && p[pi].matchRune(r) {
+			pi++
+			si += n
+			continue
+		}
+		if star < 0 {
+			return false
+		}
+		_, n = utf8.DecodeRuneInString(s[starSi:])
+		starSi += n
+		pi, si = star+1, starSi
+	}
+	for pi < len(p) && p[pi].kind == patAny {
+		pi++
+	}
+	return pi == len(p)
+}
+
+// prefix はパターンに一致する文字列がかならず始まる接頭辞を返します。
+func (p pattern) prefix() string {
+	var b strings.Builder
+	for _, e := range p {
+		if e.kind != patLiteral {
+			break
+		}
+		b.WriteRune(e.r)
+	}
+	return b.String()
+}
+
+// compilePattern は LIKE または GLOB のパターンの値をコンパイルします。
+func compilePattern(op string, pat, esc types.Value) (pattern, error) {
+	if op == "GLOB" {
+		return globPattern(pat.Text())
+	}
+	var escape rune
+	if !esc.IsNull() {
+		s := esc.Text()
+		if utf8.RuneCountInString(s) != 1 {
+			return nil, fmt.Errorf("ESCAPE expression must be a single character")
+		}
+		escape, _ = utf8.DecodeRuneInString(s)
+	}
+	return likePattern(pat.Text(), escape)
+}
+
+// like は X [NOT] LIKE/GLOB Pattern をコンパイルします。パターンが定数なら一度だけコンパイルし、
+// そうでなければ直前と異なるパターンの値が来たときにコンパイルし直します。
+func (c *compiler) like(e *ast.Like) (Expr, error) {
+	operands := []ast.Expr{e.X, e.Pattern}
+	if e.Escape != nil {
+		operands = append(operands, e.Escape)
+	}
+	fs := make([]Expr, len(operands))
+	for i, o := range operands {
+		if t := TypeOf(o, c.cols, c.funcs); t != types.Null && t != types.Text {
+			return nil, fmt.Errorf("cannot apply %s to %s", e.Op, t)
+		}
+		var err error
+		if fs[i], err = c.compile(o); err != nil {
+			return nil, err
+		}
+	}
+	op, not := e.Op, e.Not
+	var (
+		pat         pattern
+		lastPat     string
+		lastEsc     types.Value
+		compiled    bool
+		constantPat = isLiteral(e.Pattern) && (e.Escape == nil || isLiteral(e.Escape))
+	)
+	return func(row []types.Value) (types.Value, error) {
+		vals := make([]types.Value, 3)
+		for i, f := range fs {
+			v, err := f(row)
+			if err != nil {
+				return v, err
+			}
+			if v.IsNull() {
+				return v, nil
+			}
+			if v.Type() != types.Text {
+				return types.Value{}, fmt.Errorf("cannot apply %s to %s", op, v.Type())
+			}
+			vals[i] = v
+		}
+		if !compiled || !constantPat && (vals[1].Text() != lastPat || !types.Equal(vals[2], lastEsc)) {
+			var err error
+			if pat, err = compilePattern(op, vals[1], vals[2]); err != nil {
+				return types.Value{}, err
+			}
+			compiled, lastPat, lastEsc = true, vals[1].Text(), vals[2]
+		}
+		return types.NewBool(pat.match(vals[0].Text()) != not), nil
+	}, nil
+}
+
+func isLiteral(e ast.Expr) bool {
+	_, ok := e.(*ast.Literal)
+	return ok
+}
+
+// likeBounds は定数のパターンの LIKE または GLOB に一致する文字列の範囲を返します。
+// 一致する文字列は lo 以上で、hi があれば hi より小さくなります。パターンが % や * で
+// 始まるなど、範囲が決まらなければ ok は false です。
+func likeBounds(e *ast.Like) (lo types.Value, hi *types.Value, ok bool) {
+	if e.Not {
+		return lo, nil, false
+	}
+	pat, ok1 := e.Pattern.(*ast.Literal)
+	if !ok1 || pat.Value.Type() != types.Text {
+		return lo, nil, false
+	}
+	esc := types.NullValue()
+	if e.Escape != nil {
+		lit, ok := e.Escape.(*ast.Literal)
+		if !ok || lit.Value.Type() != types.Text {
+			return lo, nil, false
+		}
+		esc = lit.Value
+	}
+	p, err := compilePattern(e.Op, pat.Value, esc)
+	if err != nil {
+		return lo, nil, false
+	}
+	prefix := p.prefix()
+	if prefix == "" {
+		return lo, nil, false
+	}
+	lo = types.NewText(prefix)
+	// 接頭辞で始まる文字列は、接頭辞の最後の 0xff でないバイトを1増やした文字列より小さい。
+	b := []byte(prefix)
+	for i := len(b) - 1; i >= 0; i-- {
+		if b[i] != 0xff {
+			b[i]++
+			v := types.NewText(string(b[:i+1]))
+			return lo, &v, true
+		}
+	}
+	return lo, nil, true
+}
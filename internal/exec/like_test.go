@@ -0,0 +1,61 @@
+package exec
+
+import "testing"
+
+// TestPattern は LIKE と GLOB のパターンの照合と、インデックスの範囲に使う接頭辞を確かめます。
+func TestPattern(t *testing.T) {
+	tests := []struct {
+		glob    bool
+		pat     string
+		escape  rune
+		prefix  string
+		match   []string
+		nomatch []string
+	}{
+		{false, "abc", 0, "abc", []string{"abc"}, []string{"ab", "abcd", "ABC"}},
+		{false, "a%", 0, "a", []string{"a", "abc", "a%"}, []string{"", "ba"}},
+		{false, "%b%", 0, "", []string{"b", "abc", "bbb"}, []string{"", "ac"}},
+		{false, "a_c", 0, "a", []string{"abc", "aéc"}, []string{"ac", "abbc"}},
+		{false, "%a%b", 0, "", []string{"ab", "aab", "xaybab"}, []string{"aba", "ba"}},
+		{false, `10\%`, '\\', "10%", []string{"10%"}, []string{"100", "10"}},
+		{false, `a\_%`, '\\', "a_", []string{"a_", "a_b"}, []string{"ab"}},
+		{true, "a*", 0, "a", []string{"a", "abc"}, []string{"A", "ba"}},
+		{true, "?b", 0, "", []string{"ab", "éb"}, []string{"b", "abb"}},
+		{true, "[a-c]x", 0, "", []string{"ax", "cx"}, []string{"dx", "x"}},
+		{true, "[^a-c]x", 0, "", []string{"dx"}, []string{"ax"}},
+		{true, "[]]", 0, "", []string{"]"}, []string{"a"}},
+		{true, "%_", 0, "%_", []string{"%_"}, []string{"ab"}},
+	}
+	for _, tt := range tests {
+		var p pattern
+		var err error
+		if tt.glob {
+			p, err = globPattern(tt.pat)
+		} else {
+			p, err = likePattern(tt.pat, tt.escape)
+		}
+		if err != nil {
+			t.Fatalf("%q: %v", tt.pat, err)
+		}
+		if got := p.prefix(); got != tt.prefix {
+			t.Errorf("%q: prefix = %q, want %q", tt.pat, got, tt.prefix)
+		}
+		for _, s := range tt.match {
+			if !p.match(s) {
+				t.Errorf("%q does not match %q", tt.pat, s)
+			}
+		}
+		for _, s := range tt.nomatch {
+			if p.match(s) {
+				t.Errorf("%q matches %q", tt.pat, s)
+			}
+		}
+	}
+
+	if _, err := likePattern(`ab\`, '\\'); err == nil {
+		t.Error("LIKE pattern ending with the escape character was accepted")
+	}
+	if _, err := globPattern("[ab"); err == nil {
+		t.Error("GLOB pattern with an unclosed bracket was accepted")
+	}
+}
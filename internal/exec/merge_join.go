@@ -0,0 +1,208 @@
+package exec
+
+import (
+	"github.com/k-sml/go-rdbms/internal/types"
+)
+
+// MergeJoin は左右の行を結合のキーの順に並べ替え、同じキーの行どうしを組み合わせます。
+// 右の行は並べ替えてメモリに保持し、左の行は並べ替えた順に1行ずつ読みます。
+// 左のキーは昇順に進むので、右の行を探し始める位置も先へ進むだけです。
+type MergeJoin struct {
+	left, right  Operator
+	lkeys, rkeys []Expr // 型をそろえたキー（一致しえない値は NULL）
+	typ          JoinType
+	on           Expr
+	cols         []Column
+	nl, nr       int
+
+	sorted   *Sort
+	inner    []mergeRow
+	matched  []bool
+	lo       int // 右の行のうち、キーが現在の左の行のキー以上になる最初の位置
+	outer    []types.Value
+	found    bool
+	i, end   int
+	leftDone bool
+	row      []types.Value
+}
+
+type mergeRow struct {
+	row  []types.Value
+	keys []types.Value
+}
+
+// NewMergeJoin は MergeJoin を作ります。引数は NewHashJoin と同じで、typ には FullJoin も使えます。
+func NewMergeJoin(left, right Operator, lkeys, rkeys []Expr, ktypes []types.Type, typ JoinType, on Expr) *MergeJoin {
+	j := &MergeJoin{
+		left: left, right: right, typ: typ, on: on, cols: JoinColumns(left, right),
+		nl: len(left.Columns()), nr: len(right.Columns()),
+	}
+	j.lkeys = mergeKeys(lkeys, ktypes)
+	j.rkeys = mergeKeys(rkeys, ktypes)
+	sortKeys := make([]SortKey, len(j.lkeys))
+	for i, k := range j.lkeys {
+		sortKeys[i] = SortKey{Expr: k}
+	}
+	j.sorted = NewSort(left, sortKeys)
+	return j
+}
+
+// mergeKeys はキーの式を、値を ktypes の型にそろえる式にします。
+func mergeKeys(keys []Expr, ktypes []types.Type) []Expr {
+	out := make([]Expr, len(keys))
+	for i, k := range keys {
+		k, t := k, ktypes[i]
+		out[i] = func(row []types.Value) (types.Value, error) {
+			v, err := k(row)
+			if err != nil {
+				return v, err
+			}
+			if v, ok := joinKey(v, t); ok {
+				return v, nil
+			}
+			return types.NullValue(), nil
+		}
+	}
+	return out
+}
+
+func (j *MergeJoin) Columns() []Column { return j.cols }
+
+func (j *MergeJoin) Open() error {
+	sortKeys := make([]SortKey, len(j.rkeys))
+	for i, k := range j.rkeys {
+		sortKeys[i] = SortKey{Expr: k}
+	}
+	rows, err := Collect(NewSort(j.right, sortKeys))
+	if err != nil {
+		return err
+	}
+	j.inner = make([]mergeRow, len(rows))
+	for i, row := range rows {
+		if j.inner[i].keys, err = evalAll(j.rkeys, row); err != nil {
+			return err
+		}
+		j.inner[i].row = row
+	}
+	j.matched = nil
+	if j.typ == FullJoin {
+		j.matched = make([]bool, len(rows))
+	}
+	j.lo, j.outer, j.i, j.end, j.leftDone = 0, nil, 0, 0, false
+	return j.sorted.Open()
+}
+
+func evalAll(exprs []Expr, row []types.Value) ([]types.Value, error) {
+	vals := make([]types.Value, len(exprs))
+	for i, e := range exprs {
+		v, err := e(row)
+		if err != nil {
+			return nil, err
+		}
+		vals[i] = v
+	}
+	return vals, nil
+}
+
+// compareKeys はキーの組を比べます。NULL はどの値よりも小さいものとして扱います。
+func compareKeys(a, b []types.Value) (int, error) {
+	for i := range a {
+		c, err := types.Compare(a[i], b[i])
+		if err != nil || c != 0 {
+			return c, err
+		}
+	}
+	return 0, nil
+}
+
+func (j *MergeJoin) Next() ([]types.Value, bool, error) {
+	for {
+		if j.leftDone {
+			for ; j.i < len(j.matched); j.i++ {
+				if !j.matched[j.i] {
+					j.i++
+					j.row = joinRow(j.row, nil, j.nl, j.inner[j.i-1].row, j.nr)
+					return j.row, true, nil
+				}
+			}
+			return nil, false, nil
+		}
+		if j.outer == nil {
+			row, ok, err := j.sorted.Next()
+			if err != nil {
+				return nil, false, err
+			}
+			if !ok {
+				j.leftDone, j.i = true, 0
+				continue
+			}
+			if err := j.seek(row); err != nil {
+				return nil, false, err
+			}
+		}
+		if j.i >= j.end {
+			outer := j.outer
+			j.outer = nil
+			if !j.found && j.typ != InnerJoin {
+				j.row = joinRow(j.row, outer, j.nl, nil, j.nr)
+				return j.row, true, nil
+			}
+			continue
+		}
+		j.row = joinRow(j.row, j.outer, j.nl, j.inner[j.i].row, j.nr)
+		j.i++
+		v, err := j.on(j.row)
+		if err != nil {
+			return nil, false, err
+		}
+		if Truth(v) {
+			j.found = true
+			if j.matched != nil {
+				j.matched[j.i-1] = true
+			}
+			return j.row, true, nil
+		}
+	}
+}
+
+// seek は左の行 row と同じキーを持つ右の行の範囲 [i, end) を求めます。
+func (j *MergeJoin) seek(row []types.Value) error {
+	keys, err := evalAll(j.lkeys, row)
+	if err != nil {
+		return err
+	}
+	j.outer, j.found = row, false
+	j.i, j.end = 0, 0
+	for _, k := range keys {
+		if k.IsNull() {
+			return nil
+		}
+	}
+	for j.lo < len(j.inner) {
+		c, err := compareKeys(j.inner[j.lo].keys, keys)
+		if err != nil {
+			return err
+		}
+		if c >= 0 {
+			break
+		}
+		j.lo++
+	}
+	j.i, j.end = j.lo, j.lo
+	for j.end < len(j.inner) {
+		c, err := compareKeys(j.inner[j.end].keys, keys)
+		if err != nil {
+			return err
+		}
+		if c != 0 {
+			break
+		}
+		j.end++
+	}
+	return nil
+}
+
+func (j *MergeJoin) Close() error {
+	j.inner, j.matched = nil, nil
+	return j.sorted.Close()
+}
@@ -0,0 +1,317 @@
+package exec
+
+import (
+	"container/heap"
+	"sort"
+
+	"github.com/k-sml/go-rdbms/internal/types"
+)
+
+// Filter は条件が真になる行だけを返します。
+type Filter struct {
+	in   Operator
+	cond Expr
+
+	vcond vecExpr // nil でなければ、in からバッチで読んで条件を評価する（batch.go）
+	sel   []int
+	rd    batchReader
+}
+
+// NewFilter は in の行のうち cond が真になるものを返す Filter を作ります。
+func NewFilter(in Operator, cond Expr) *Filter { return &Filter{in: in, cond: cond} }
+
+func (f *Filter) Columns() []Column { return f.in.Columns() }
+func (f *Filter) Close() error      { return f.in.Close() }
+
+func (f *Filter) Open() error {
+	f.rd.reset()
+	return f.in.Open()
+}
+
+func (f *Filter) Next() ([]types.Value, bool, error) {
+	if f.vcond != nil {
+		return f.rd.next(f.nextBatch)
+	}
+	for {
+		row, ok, err := f.in.Next()
+		if err != nil || !ok {
+			return nil, false, err
+		}
+		v, err := f.cond(row)
+		if err != nil {
+			return nil, false, err
+		}
+		if Truth(v) {
+			return row, true, nil
+		}
+	}
+}
+
+// Project は行から式の値を計算して新しい行を作ります。
+type Project struct {
+	in    Operator
+	exprs []Expr
+	cols  []Column
+	row   []types.Value
+
+	vexprs []vecExpr // nil でなければ、in からバッチで読んで式を評価する（batch.go）
+	out    batch
+	rd     batchReader
+}
+
+// NewProject は in の行から exprs の値を計算する Project を作ります。cols は結果の列です。
+func NewProject(in Operator, exprs []Expr, cols []Column) *Project {
+	return &Project{in: in, exprs: exprs, cols: cols, row: make([]types.Value, len(exprs))}
+}
+
+func (p *Project) Columns() []Column { return p.cols }
+func (p *Project) Close() error      { return p.in.Close() }
+
+func (p *Project) Open() error {
+	p.rd.reset()
+	return p.in.Open()
+}
+
+func (p *Project) Next() ([]types.Value, bool, error) {
+	if p.vexprs != nil {
+		return p.rd.next(p.nextBatch)
+	}
+	row, ok, err := p.in.Next()
+	if err != nil || !ok {
+		return nil, false, err
+	}
+	for i, e := range p.exprs {
+		if p.row[i], err = e(row); err != nil {
+			return nil, false, err
+		}
+	}
+	return p.row, true, nil
+}
+
+// Limit は先頭の offset 行を読み飛ばし、その後の最大 count 行を返します。
+// count が負なら制限しません。
+type Limit struct {
+	in            Operator
+	count, offset int64
+	n             int64
+}
+
+// NewLimit は Limit を作ります。
+func NewLimit(in Operator, count, offset int64) *Limit {
+	return &Limit{in: in, count: count, offset: offset}
+}
+
+func (l *Limit) Columns() []Column { return l.in.Columns() }
+func (l *Limit) Close() error      { return l.in.Close() }
+
+func (l *Limit) Open() error {
+	l.n = 0
+	return l.in.Open()
+}
+
+func (l *Limit) Next() ([]types.Value, bool, error) {
+	for ; l.n < l.offset; l.n++ {
+		_, ok, err := l.in.Next()
+		if err != nil || !ok {
+			return nil, false, err
+		}
+	}
+	if l.count >= 0 && l.n >= l.offset+l.count {
+		return nil, false, nil
+	}
+	row, ok, err := l.in.Next()
+	if ok {
+		l.n++
+	}
+	return row, ok, err
+}
+
+// SortKey は並べ替えのキーです。
+type SortKey struct {
+	Expr Expr
+	Desc bool
+}
+
+// Sort はすべての行を読んでから、キーの順に並べ替えて返します。NULL は最も小さい値として並びます。
+// キーが等しい行は読んだ順のままです。
+type Sort struct {
+	in    Operator
+	keys  []SortKey
+	limit int64 // 負なら制限なし
+	rows  []sortRow
+	i     int
+	err   error
+}
+
+type sortRow struct {
+	row  []types.Value
+	keys []types.Value
+	seq  int // 読んだ順番
+}
+
+// NewSort は in の行を keys の順に並べ替える Sort を作ります。
+func NewSort(in Operator, keys []SortKey) *Sort { return &Sort{in: in, keys: keys, limit: -1} }
+
+// SetLimit は先頭の n 行しか読まれないことを Sort に伝えます。Sort は読みながら
+// 上位 n 行だけを保持するので、入力がどれだけ多くてもメモリは n 行分で済みます。
+func (s *Sort) SetLimit(n int64) { s.limit = n }
+
+func (s *Sort) Columns() []Column { return s.in.Columns() }
+
+func (s *Sort) Open() error {
+	s.rows, s.i, s.err = s.rows[:0], 0, nil
+	if err := s.in.Open(); err != nil {
+		return err
+	}
+	defer s.in.Close()
+	top := &sortHeap{s: s}
+	for seq := 0; ; seq++ {
+		row, ok, err := s.in.Next()
+		if err != nil {
+			return err
+		}
+		if !ok {
+			break
+		}
+		r := sortRow{keys: make([]types.Value, len(s.keys)), seq: seq}
+		for i, k := range s.keys {
+			if r.keys[i], err = k.Expr(row); err != nil {
+				return err
+			}
+		}
+		if s.limit >= 0 {
+			// 上位 limit 行を、最も後ろに並ぶ行が先頭に来るヒープで保持する
+			if int64(len(s.rows)) < s.limit {
+				r.row = append([]types.Value(nil), row...)
+				heap.Push(top, r)
+			} else if len(s.rows) > 0 && s.less(r, s.rows[0]) {
+				r.row = append(s.rows[0].row[:0], row...)
+				s.rows[0] = r
+				heap.Fix(top, 0)
+			}
+			continue
+		}
+		r.row = append([]types.Value(nil), row...)
+		s.rows = append(s.rows, r)
+	}
+	sort.Slice(s.rows, func(i, j int) bool { return s.less(s.rows[i], s.rows[j]) })
+	return s.err
+}
+
+// less は行 a が b より前に並ぶかを返します。比較できない値があれば s.err に記録します。
+func (s *Sort) less(a, b sortRow) bool {
+	for k, key := range s.keys {
+		c, err := types.Compare(a.keys[k], b.keys[k])
+		if err != nil && s.err == nil {
+			s.err = err
+		}
+		if c != 0 {
+			return (c < 0) != key.Desc
+		}
+	}
+	return a.seq < b.seq
+}
+
+func (s *Sort) Next() ([]types.Value, bool, error) {
+	if s.i >= len(s.rows) {
+		return nil, false, nil
+	}
+	s.i++
+	return s.rows[s.i-1].row, true, nil
+}
+
+func (s *Sort) Close() error {
+	s.rows = nil
+	return nil
+}
+
+// sortHeap は Sort の行を、最も後ろに並ぶ行が先頭に来るヒープとして扱います。
+type sortHeap struct{ s *Sort }
+
+func (h *sortHeap) Len() int           { return len(h.s.rows) }
+func (h *sortHeap) Less(i, j int) bool { return h.s.less(h.s.rows[j], h.s.rows[i]) }
+func (h *sortHeap) Swap(i, j int)      { h.s.rows[i], h.s.rows[j] = h.s.rows[j], h.s.rows[i] }
+func (h *sortHeap) Push(x any)         { h.s.rows = append(h.s.rows, x.(sortRow)) }
+
+func (h *sortHeap) Pop() any {
+	r := h.s.rows[len(h.s.rows)-1]
+	h.s.rows = h.s.rows[:len(h.s.rows)-1]
+	return r
+}
+
+// Distinct は重複した行を除き、それぞれの行を最初に現れたときに返します。
+// 返した行はハッシュ表で覚えておき、NULL どうしは等しいものとして扱います。
+type Distinct struct {
+	in   Operator
+	seen map[string]struct{}
+	key  []byte
+}
+
+// NewDistinct は in の行から重複を除く Distinct を作ります。
+func NewDistinct(in Operator) *Distinct { return &Distinct{in: in} }
+
+func (d *Distinct) Columns() []Column { return d.in.Columns() }
+
+func (d *Distinct) Open() error {
+	d.seen = make(map[string]struct{})
+	return d.in.Open()
+}
+
+func (d *Distinct) Next() ([]types.Value, bool, error) {
+	for {
+		row, ok, err := d.in.Next()
+		if err != nil || !ok {
+			return nil, false, err
+		}
+		d.key = d.key[:0]
+		for _, v := range row {
+			d.key = types.AppendKey(d.key, v)
+		}
+		if _, dup := d.seen[string(d.key)]; !dup {
+			d.seen[string(d.key)] = struct{}{}
+			return row, true, nil
+		}
+	}
+}
+
+func (d *Distinct) Close() error {
+	d.seen = nil
+	return d.in.Close()
+}
+
+// Values は決まった行を返します。FROM のない SELECT では列のない1行を返します。
+type Values struct {
+	cols []Column
+	rows [][]types.Value
+	i    int
+}
+
+// NewValues は rows を返す Values を作ります。
+func NewValues(cols []Column, rows [][]types.Value) *Values { return &Values{cols: cols, rows: rows} }
+
+func (v *Values) Columns() []Column { return v.cols }
+func (v *Values) Close() error      { return nil }
+
+func (v *Values) Open() error {
+	v.i = 0
+	return nil
+}
+
+func (v *Values) Next() ([]types.Value, bool, error) {
+	if v.i >= len(v.rows) {
+		return nil, false, nil
+	}
+	v.i++
+	return v.rows[v.i-1], true, nil
+}
+
+// Rename は下の演算子の行をそのまま返し、列の名前だけを付け替えます（ビューや別名に使います）。
+type Rename struct {
+	Operator
+	cols []Column
+}
+
+// NewRename は in の列を cols と呼ぶ Rename を作ります。
+func NewRename(in Operator, cols []Column) *Rename { return &Rename{Operator: in, cols: cols} }
+
+func (r *Rename) Columns() []Column { return r.cols }
@@ -0,0 +1,69 @@
+package exec
+
+import (
+	"fmt"
+
+	"github.com/k-sml/go-rdbms/internal/sql/ast"
+	"github.com/k-sml/go-rdbms/internal/types"
+)
+
+// 準備した文の引数
+//
+// ? や $1 の引数（ast.Param）は、Params から値を読む式にコンパイルする。実行計画は引数の値に
+// よらないので、Bind で値を入れ替えれば同じ実行計画を何度でも開き直して実行できる。
+// 外側の列を参照しない副問い合わせの値や、WITH の共有する結果のように最初に作って使い回すものは、
+// Bind するたびに作り直す。
+//
+// インデックスで読む範囲の境界が引数なら、IndexScan を開くときに値を列の型に変換して範囲を決める。
+// 値を変えずに変換できなければ、その列からは範囲を絞らずに読む（上の Filter が条件を検査する）。
+
+// Params は準備した文の引数の値です。
+type Params struct {
+	n    int
+	vals []types.Value
+	gen  uint64 // Bind した回数
+}
+
+// NewParams は n 個の引数を持つ Params を作ります。
+func NewParams(n int) *Params { return &Params{n: n} }
+
+// Len は引数の数を返します。
+func (p *Params) Len() int { return p.n }
+
+// Bind は引数の値を vals にします。vals の i 番目が $(i+1) の値です。
+func (p *Params) Bind(vals []types.Value) error {
+	if len(vals) != p.n {
+		return fmt.Errorf("statement has %d parameters but %d values were supplied", p.n, len(vals))
+	}
+	p.vals = append(p.vals[:0], vals...)
+	p.gen++
+	return nil
+}
+
+// value は i 番目（1 から）の引数の値を返します。
+func (p *Params) value(i int) types.Value { return p.vals[i-1] }
+
+// generation は Bind した回数を返します。p が nil なら 0 です。
+func (p *Params) generation() uint64 {
+	if p == nil {
+		return 0
+	}
+	return p.gen
+}
+
+// param は引数の参照をコンパイルします。
+func (c *compiler) param(e *ast.Param) (Expr, error) {
+	if c.p == nil || c.p.params == nil {
+		return nil, fmt.Errorf("parameters are not allowed here")
+	}
+	params, i := c.p.params, e.Index
+	if i > params.Len() {
+		return nil, fmt.Errorf("parameter $%d is out of range", i)
+	}
+	return func([]types.Value) (types.Value, error) {
+		if params.vals == nil {
+			return types.Value{}, fmt.Errorf("parameter $%d is not bound", i)
+		}
+		return params.value(i), nil
+	}, nil
+}
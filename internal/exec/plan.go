@@ -0,0 +1,621 @@
+package exec
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/k-sml/go-rdbms/internal/catalog"
+	"github.com/k-sml/go-rdbms/internal/sql/ast"
+	"github.com/k-sml/go-rdbms/internal/sql/parser"
+	"github.com/k-sml/go-rdbms/internal/storage"
+	"github.com/k-sml/go-rdbms/internal/types"
+)
+
+// 実行計画の作り方
+//
+// FROM のテーブルを結合し、WHERE の Filter、集約する文なら HashAggregate と HAVING の Filter
+// （aggregate.go）、ORDER BY の Sort、結果の列の Project、DISTINCT の Distinct、LIMIT の Limit の
+// 順に重ねる。
+// Limit は必要な行を返し終えると下の演算子を呼ばなくなり、ORDER BY と LIMIT があれば
+// Sort は上位の行だけを保持する。
+//
+// FROM が1つのテーブルだけなら、WHERE の中の「列 演算子 定数」の条件からインデックスで
+// 読む範囲を決められるかを調べ、使えるインデックスで読む費用の見積もりが SeqScan より小さければ
+// IndexScan にする（cost.go）。IndexScan の上にも WHERE の Filter は残すので、範囲は条件を
+// 満たす行を含んでいればよい。
+//
+// 結合は、右をメモリに読み込む NestedLoopJoin のほか、右がテーブルで ON の等号の条件から
+// 右のテーブルのインデックスの先頭の列が決まれば IndexNestedLoopJoin、ON に左右の列の式を
+// 等号で結ぶ条件があれば HashJoin と MergeJoin を候補にし、費用の見積もりが最も小さいものにする。
+// INNER JOIN の HashJoin は、行の数の見積もりが少ないほうでハッシュ表を作る。
+// FULL JOIN は NestedLoopJoin か MergeJoin でだけ実行できる。
+// RIGHT JOIN は左右を入れ替えた LEFT JOIN として実行する。
+//
+// 結合があれば、WHERE の条件はできるだけ結合の下に下ろす（pushdown.go）。
+
+// maxViewDepth はビューの中のビューを展開する深さの上限です。
+const maxViewDepth = 32
+
+// Plan は SELECT 文の実行計画を作ります。文の中の引数は params から読みます。
+// params が nil なら引数は使えません。
+func Plan(src Source, s *ast.Select, params *Params) (Operator, error) {
+	p := newPlanner(src, params)
+	p.uses = newColumnUse()
+	p.uses.add(s)
+	return p.selectStmt(s)
+}
+
+// CompileQuery は Compile と同じですが、式の中の副問い合わせを src のテーブルに対して実行し、
+// 引数を params から読みます。
+func CompileQuery(src Source, e ast.Expr, cols []Column, params *Params) (Expr, error) {
+	p := newPlanner(src, params)
+	return p.compile(e, cols)
+}
+
+func newPlanner(src Source, params *Params) *planner {
+	set := src.Settings()
+	return &planner{src: src, params: params, est: newEstimator(src), funcs: set.Funcs, batchSize: set.BatchSize, parallel: set.Parallel}
+}
+
+type planner struct {
+	src    Source
+	params *Params
+	depth  int
+	scope  *scope // 副問い合わせの実行計画を作っているときの外側の問い合わせの列
+	ctes   map[string]*cte
+	notes  *planNotes // EXPLAIN のときだけ、演算子に添えて表示する説明
+	est    *estimator
+	uses   *columnUse // SELECT 文のときだけ、問い合わせが参照する列
+	funcs  *Funcs     // データベースに登録した関数
+
+	batchSize int // バッチで実行するときのバッチの行の数の上限
+	parallel  int // テーブルを並列に読むゴルーチンの数の上限
+}
+
+// compile は実行計画の中の式をコンパイルします。副問い合わせの実行計画もここで作ります。
+func (p *planner) compile(e ast.Expr, cols []Column) (Expr, error) {
+	return (&compiler{p: p, cols: cols, outer: p.scope, funcs: p.funcs}).compile(e)
+}
+
+func (p *planner) selectStmt(s *ast.Select) (Operator, error) {
+	if s.With != nil {
+		saved := p.ctes
+		defer func() { p.ctes = saved }()
+		if err := p.with(s); err != nil {
+			return nil, err
+		}
+	}
+	if s.Compound != nil {
+		return p.compound(s)
+	}
+	var op Operator
+	var err error
+	where := s.Where
+	switch from := s.From.(type) {
+	case nil:
+		op = NewValues(nil, [][]types.Value{{}})
+	case *ast.TableName:
+		op, err = p.tableName(from, where)
+	default:
+		push := newPushdown(where)
+		if op, err = p.from(from, push); err == nil {
+			err = push.check(op.Columns())
+			where = push.rest()
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if where != nil {
+		if op, where, err = p.decorrelate(op, where); err != nil {
+			return nil, err
+		}
+		if where != nil {
+			if op, err = p.filter(op, where); err != nil {
+				return nil, err
+			}
+		}
+	}
+	in := op.Columns()
+	var exprs []ast.Expr
+	var cols []Column
+	for _, item := range s.Columns {
+		if !item.Star {
+			exprs = append(exprs, item.Expr)
+			cols = append(cols, outputColumn(item, in, p.funcs))
+			continue
+		}
+		n := len(exprs)
+		for _, c := range in {
+			if item.Table == "" || strings.EqualFold(c.Table, item.Table) {
+				exprs = append(exprs, &ast.ColumnRef{Table: c.Table, Column: c.Name})
+				cols = append(cols, c)
+			}
+		}
+		if len(exprs) == n && item.Table != "" {
+			return nil, fmt.Errorf("no such table: %s", item.Table)
+		}
+	}
+
+	order := make([]ast.Expr, len(s.OrderBy))
+	for i, o := range s.OrderBy {
+		order[i] = o.Expr
+	}
+	g, err := newGrouping(s, exprs, cols, in, p.funcs)
+	if err != nil {
+		return nil, err
+	}
+	if g != nil {
+		if op, err = p.aggregate(op, g); err != nil {
+			return nil, err
+		}
+		for i, e := range exprs {
+			if exprs[i], err = g.rewrite(e); err != nil {
+				return nil, err
+			}
+		}
+		for i, e := range order {
+			if order[i], err = g.rewrite(e); err != nil {
+				return nil, err
+			}
+		}
+		if s.Having != nil {
+			having, err := g.rewrite(s.Having)
+			if err != nil {
+				return nil, err
+			}
+			if op, err = p.filter(op, having); err != nil {
+				return nil, err
+			}
+		}
+		in = g.cols
+	}
+
+	count, offset, err := limit(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(s.OrderBy) > 0 {
+		keys := make([]SortKey, len(s.OrderBy))
+		for i, o := range s.OrderBy {
+			e, err := orderExpr(order[i], exprs, cols, in)
+			if err != nil {
+				return nil, err
+			}
+			if keys[i].Expr, err = p.compile(e, in); err != nil {
+				return nil, err
+			}
+			keys[i].Desc = o.Desc
+		}
+		sorter := NewSort(op, keys)
+		if count >= 0 && offset <= math.MaxInt64-count && !s.Distinct {
+			sorter.SetLimit(offset + count)
+		}
+		p.noteSortKeys(sorter, s.OrderBy)
+		op = sorter
+	}
+
+	compiled := make([]Expr, len(exprs))
+	var vexprs []vecExpr
+	if p.toBatch(op) {
+		vexprs = make([]vecExpr, len(exprs))
+	}
+	for i, e := range exprs {
+		if vexprs != nil {
+			compiled[i], vexprs[i], err = p.compileVec(e, in)
+		} else {
+			compiled[i], err = p.compile(e, in)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	proj := NewProject(op, compiled, cols)
+	proj.vexprs = vexprs
+	op = proj
+	if s.Distinct && !p.unique(s.From, exprs) {
+		op = NewDistinct(op)
+	}
+
+	if s.Limit != nil || s.Offset != nil {
+		op = NewLimit(op, count, offset)
+	}
+	return op, nil
+}
+
+// unique は FROM が1つのテーブルで、結果の列 exprs にそのテーブルの一意インデックスの
+// 列がすべて含まれ、それらの列が NOT NULL であるかを返します。そのときは結果の行に重複がないので、
+// DISTINCT を省けます。
+func (p *planner) unique(from ast.TableExpr, exprs []ast.Expr) bool {
+	tn, ok := from.(*ast.TableName)
+	if !ok {
+		return false
+	}
+	if _, ok := p.cte(tn.Name); ok {
+		return false
+	}
+	if _, ok := p.src.View(tn.Name); ok {
+		return false
+	}
+	t, err := p.src.Table(tn.Name)
+	if err != nil {
+		return false
+	}
+	cols := TableColumns(t, tn.Alias)
+	out := make(map[int]bool)
+	for _, e := range exprs {
+		if ref, ok := e.(*ast.ColumnRef); ok {
+			if i, err := resolve(cols, ref); err == nil {
+				out[i] = true
+			}
+		}
+	}
+	for _, ix := range p.src.Indexes(t.Name) {
+		if !ix.Unique {
+			continue
+		}
+		covered := true
+		for _, name := range ix.Columns {
+			i, ok := t.Column(name)
+			if !ok || !out[i] || !t.Columns[i].NotNull && !t.Columns[i].PrimaryKey {
+				covered = false
+				break
+			}
+		}
+		if covered {
+			return true
+		}
+	}
+	return false
+}
+
+// outputColumn は SELECT の項目 item の結果の列を返します。
+func outputColumn(item ast.SelectItem, in []Column, fs *Funcs) Column {
+	c := Column{Name: item.Alias, Type: TypeOf(item.Expr, in, fs)}
+	if e, ok := item.Expr.(*ast.ColumnRef); ok {
+		if i, err := resolve(in, e); err == nil {
+			c.Table = in[i].Table
+		}
+		if c.Name == "" {
+			c.Name = e.Column
+		}
+	}
+	if c.Name == "" {
+		c.Name = ast.FormatExpr(item.Expr)
+	}
+	return c
+}
+
+// orderExpr は ORDER BY の式を、Project の前の行に対する式にします。
+// 整数の定数は結果の列の番号（1 から）で、FROM の列にない名前は結果の列の別名として扱います。
+func orderExpr(e ast.Expr, exprs []ast.Expr, cols []Column, in []Column) (ast.Expr, error) {
+	switch x := e.(type) {
+	case *ast.Literal:
+		if x.Value.Type().IsInteger() {
+			n := x.Value.Int()
+			if n < 1 || n > int64(len(exprs)) {
+				return nil, fmt.Errorf("ORDER BY term out of range: %d", n)
+			}
+			return exprs[n-1], nil
+		}
+	case *ast.ColumnRef:
+		if _, err := resolve(in, x); err != nil && x.Table == "" {
+			for i, c := range cols {
+				if strings.EqualFold(c.Name, x.Column) {
+					return exprs[i], nil
+				}
+			}
+		}
+	}
+	return e, nil
+}
+
+// limit は LIMIT と OFFSET の値を返します。LIMIT がなければ count は -1 です。
+func limit(s *ast.Select) (count, offset int64, err error) {
+	count = -1
+	if s.Limit != nil {
+		if count, err = constInt(s.Limit, "LIMIT"); err != nil {
+			return 0, 0, err
+		}
+	}
+	if s.Offset != nil {
+		if offset, err = constInt(s.Offset, "OFFSET"); err != nil {
+			return 0, 0, err
+		}
+	}
+	return count, max(offset, 0), nil
+}
+
+// constInt は LIMIT や OFFSET の定数の式を整数として評価します。
+func constInt(e ast.Expr, clause string) (int64, error) {
+	v, err := EvalConst(e)
+	if err != nil {
+		return 0, err
+	}
+	if !v.Type().IsInteger() {
+		return 0, fmt.Errorf("%s must be an integer: %s", clause, ast.FormatExpr(e))
+	}
+	return v.Int(), nil
+}
+
+// filter は op の上に条件 where の Filter を重ねます。op がテーブルを順に読む SeqScan なら、
+// テーブルを並列に読んで条件で絞る Gather にすることがあります（gather.go）。
+func (p *planner) filter(op Operator, where ast.Expr) (Operator, error) {
+	f, err := p.newFilter(op, where)
+	if err != nil {
+		return nil, err
+	}
+	if s, ok := op.(*SeqScan); ok {
+		g, err := p.gather(s, f, where)
+		if err != nil {
+			return nil, err
+		}
+		if g != nil {
+			return g, nil
+		}
+	}
+	return f, nil
+}
+
+// newFilter は op の上に条件 where の Filter を重ねます。
+func (p *planner) newFilter(op Operator, where ast.Expr) (*Filter, error) {
+	var cond Expr
+	var vcond vecExpr
+	var err error
+	if p.toBatch(op) {
+		cond, vcond, err = p.compileVec(where, op.Columns())
+	} else {
+		cond, err = p.compile(where, op.Columns())
+	}
+	if err != nil {
+		return nil, err
+	}
+	f := NewFilter(op, cond)
+	f.vcond = vcond
+	p.est.setSelectivity(f, p.est.filterSelectivity(where, op))
+	p.note(f, "Filter: %s", ast.FormatExpr(where))
+	return f, nil
+}
+
+// from は FROM の結合を演算子にします。d が nil でなければ、d の条件のうち結合より下で
+// 評価できるものを取り出して、できるだけ下で評価します。
+func (p *planner) from(te ast.TableExpr, d *pushdown) (Operator, error) {
+	switch te := te.(type) {
+	case *ast.TableName:
+		op, _, err := p.leaf(te, d)
+		return op, err
+	case *ast.Join:
+		return p.join(te, d)
+	}
+	return nil, fmt.Errorf("unsupported table expression %T", te)
+}
+
+// leaf はテーブルの参照 tn を読む演算子を作り、d の条件のうちその列だけで評価できるものの
+// Filter を重ねます。取り出した条件も返します。
+func (p *planner) leaf(tn *ast.TableName, d *pushdown) (Operator, []ast.Expr, error) {
+	op, err := p.tableName(tn, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	conds := d.take(op.Columns())
+	if len(conds) == 0 {
+		return op, nil, nil
+	}
+	where := andAll(conds)
+	if s, ok := op.(*SeqScan); ok {
+		// 条件から、インデックスで読めるかを選び直す
+		op = p.scan(s.table, s.cols[0].Table, where)
+	}
+	op, err = p.filter(op, where)
+	return op, conds, err
+}
+
+// join は結合の演算子を選びます。RIGHT JOIN は左右を入れ替えた LEFT JOIN にし、
+// 結果の列の順番を元に戻します。d の条件は、結合の結果を変えない側にだけ下ろします。
+func (p *planner) join(te *ast.Join, d *pushdown) (Operator, error) {
+	typ := InnerJoin
+	switch te.Kind {
+	case ast.LeftJoin, ast.RightJoin:
+		typ = LeftJoin
+	case ast.FullJoin:
+		typ = FullJoin
+	}
+	lte, rte := te.Left, te.Right
+	if te.Kind == ast.RightJoin {
+		lte, rte = rte, lte
+	}
+	var ld, rd *pushdown
+	if typ != FullJoin {
+		ld = d
+	}
+	if typ == InnerJoin {
+		rd = d
+	}
+	left, err := p.from(lte, ld)
+	if err != nil {
+		return nil, err
+	}
+	op, err := p.joinOp(left, rte, typ, te.On, rd)
+	if err != nil {
+		return nil, err
+	}
+	if te.Kind == ast.RightJoin {
+		op = swapSides(op, len(left.Columns()))
+	}
+	return op, nil
+}
+
+// joinOp は left と rte を結合する演算子のうち、費用の見積もりが最も小さいものを返します。
+// d の条件のうち右の列だけで評価できるものは右に、左右の列で評価できるものは ON に加えます。
+func (p *planner) joinOp(left Operator, rte ast.TableExpr, typ JoinType, on ast.Expr, d *pushdown) (Operator, error) {
+	start := p.notes.mark()
+	var right Operator
+	var rconds []ast.Expr
+	var err error
+	if tn, ok := rte.(*ast.TableName); ok {
+		right, rconds, err = p.leaf(tn, d)
+	} else {
+		right, err = p.from(rte, d)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if conds := d.take(JoinColumns(left, right)); len(conds) > 0 {
+		if on != nil {
+			conds = append([]ast.Expr{on}, conds...)
+		}
+		on = andAll(conds)
+	}
+	if on == nil {
+		return NewNestedLoopJoin(left, right, typ, nil), nil
+	}
+	cond, err := p.compile(on, JoinColumns(left, right))
+	if err != nil {
+		return nil, err
+	}
+	nlj := NewNestedLoopJoin(left, right, typ, cond)
+	sel := p.est.selectivity(on, nlj)
+	candidates := []Operator{nlj}
+	if lkeys, rkeys, ktypes := equiKeys(left, right, on); len(lkeys) > 0 {
+		candidates = append(candidates, NewMergeJoin(left, right, lkeys, rkeys, ktypes, typ, cond))
+		if typ != FullJoin {
+			hj := NewHashJoin(left, right, lkeys, rkeys, ktypes, typ, cond)
+			if typ == InnerJoin && p.est.cost(left).Rows < p.est.cost(right).Rows {
+				hj.buildLeft()
+			}
+			candidates = append(candidates, hj)
+		}
+	}
+	// IndexNestedLoopJoin は右のテーブルを直接引くので、右に下ろした条件も ON で評価する。
+	// ON を別にコンパイルするので、選ばなかった側の副問い合わせを取り消す
+	end := p.notes.mark()
+	indexOn := andAll(append([]ast.Expr{on}, rconds...))
+	if tn, ok := rte.(*ast.TableName); ok && typ != FullJoin {
+		op, ok, err := p.indexJoin(left, tn, typ, indexOn)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			candidates = append(candidates, op)
+		}
+	}
+	var best Operator
+	var bestCost float64
+	for _, op := range candidates {
+		p.est.setSelectivity(op, sel)
+		if cost := p.est.cost(op).Total; best == nil || cost < bestCost {
+			best, bestCost = op, cost
+		}
+	}
+	if _, ok := best.(*IndexNestedLoopJoin); ok {
+		p.notes.drop(start, end)
+		on = indexOn
+	} else {
+		p.notes.reset(end)
+	}
+	p.note(best, "Join Cond: %s", ast.FormatExpr(on))
+	return best, nil
+}
+
+// swapSides は右の列、左の列の順に並んだ結合の行を、左の列、右の列の順に並べ替えます。
+// n は結合の左（元の右）の列の数です。
+func swapSides(op Operator, n int) Operator {
+	in := op.Columns()
+	cols := append(append([]Column(nil), in[n:]...), in[:n]...)
+	exprs := make([]Expr, len(in))
+	for i := range exprs {
+		k := (i + n) % len(in)
+		exprs[i] = func(row []types.Value) (types.Value, error) { return row[k], nil }
+	}
+	return NewProject(op, exprs, cols)
+}
+
+// tableName は CTE、ビュー、テーブルのいずれかを読む演算子を返します。where はインデックスを選ぶのに使います。
+func (p *planner) tableName(tn *ast.TableName, where ast.Expr) (Operator, error) {
+	if c, ok := p.cte(tn.Name); ok {
+		return p.cteScan(c, tn.Alias)
+	}
+	if v, ok := p.src.View(tn.Name); ok {
+		return p.view(v, tn.Alias)
+	}
+	t, err := p.src.Table(tn.Name)
+	if err != nil {
+		return nil, err
+	}
+	alias := tn.Alias
+	if alias == "" {
+		alias = t.Name
+	}
+	return p.scan(t, alias, where), nil
+}
+
+// rowScan は行の位置も返す演算子（SeqScan と IndexScan）です。
+type rowScan interface {
+	Operator
+	RID() storage.RID
+}
+
+// scan はテーブル t を読む演算子を返します。where で読む範囲を絞れるインデックスがあり、
+// それで読む費用の見積もりがすべての行を読むより小さければ IndexScan、そうでなければ SeqScan です。
+func (p *planner) scan(t *catalog.Table, alias string, where ast.Expr) rowScan {
+	if where != nil && !t.System {
+		ix, b, ok := p.chooseIndex(t, alias, where)
+		if ok && p.est.indexScanCost(t, ix, p.est.boundsSelectivity(t, ix, b)).Total < p.est.seqScanCost(t).Total {
+			var s *IndexScan
+			if b.hasParams() {
+				s = NewIndexScan(p.src, t, alias, ix, IndexRange{})
+				s.bounds, s.params = b, p.params
+			} else {
+				s = NewIndexScan(p.src, t, alias, ix, b.resolve(nil))
+			}
+			s.read = p.uses.columns(t, alias)
+			s.conds = b.conds
+			return s
+		}
+	}
+	s := NewSeqScan(p.src, t, alias)
+	s.read = p.uses.columns(t, alias)
+	return s
+}
+
+// view はビューの問い合わせの実行計画を作り、列の名前をビューの列にします。
+func (p *planner) view(v *catalog.View, alias string) (Operator, error) {
+	if p.depth >= maxViewDepth {
+		return nil, fmt.Errorf("too many levels of views: %s", v.Name)
+	}
+	stmt, err := parser.Parse(v.Query)
+	if err != nil {
+		return nil, fmt.Errorf("view %s: %w", v.Name, err)
+	}
+	sel, ok := stmt.(*ast.Select)
+	if !ok {
+		return nil, fmt.Errorf("view %s is not a SELECT", v.Name)
+	}
+	if p.uses != nil {
+		p.uses.add(sel)
+	}
+	// ビューの中からは、ビューを使う問い合わせの列や CTE、引数は見えない
+	savedScope, savedCTEs, savedParams := p.scope, p.ctes, p.params
+	p.depth, p.scope, p.ctes, p.params = p.depth+1, nil, nil, nil
+	op, err := p.selectStmt(sel)
+	p.depth, p.scope, p.ctes, p.params = p.depth-1, savedScope, savedCTEs, savedParams
+	if err != nil {
+		return nil, fmt.Errorf("view %s: %w", v.Name, err)
+	}
+	if alias == "" {
+		alias = v.Name
+	}
+	in := op.Columns()
+	if len(v.Columns) > 0 && len(v.Columns) != len(in) {
+		return nil, fmt.Errorf("view %s has %d column names but the query returns %d columns", v.Name, len(v.Columns), len(in))
+	}
+	cols := make([]Column, len(in))
+	for i, c := range in {
+		cols[i] = Column{Table: alias, Name: c.Name, Type: c.Type}
+		if len(v.Columns) > 0 {
+			cols[i].Name = v.Columns[i]
+		}
+	}
+	return NewRename(op, cols), nil
+}
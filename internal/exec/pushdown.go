@@ -0,0 +1,164 @@
+package exec
+
+import (
+	"strings"
+
+	"github.com/k-sml/go-rdbms/internal/catalog"
+	"github.com/k-sml/go-rdbms/internal/sql/ast"
+)
+
+// 条件の押し下げと列の刈り込み
+//
+// FROM に結合がある SELECT 文では、WHERE の AND でつながった条件のうち副問い合わせを含まない
+// ものを、評価に必要な列がそろう最も下の演算子まで下ろす。1つのテーブルの列だけを参照する条件は
+// そのテーブルを読む演算子のすぐ上の Filter にし、インデックスで読む範囲を決めるのにも使う。
+// 内部結合の両側の列を参照する条件は、その結合の ON の条件に加える。外部結合では、NULL で
+// 補う側（LEFT JOIN の右）に条件を下ろすと結果が変わるので、残る側にだけ下ろす。FULL JOIN の
+// 下には下ろさない。下ろせなかった条件は、これまでどおり結合の上の Filter で評価する。
+//
+// SELECT 文の実行計画では、テーブルを読む演算子に問い合わせが参照する列だけを読ませ、残りの列は
+// 値をデコードせずに NULL にする。参照する列は、文とその中の副問い合わせ、CTE、展開したビューの
+// 問い合わせに現れる列の参照をすべて集めたものである。テーブル名を付けずに参照した列はどの
+// テーブルの同じ名前の列も読むので、必要な列を読み落とすことはない。* があれば、その SELECT の
+// FROM のテーブルの列をすべて読む。
+
+// pushdown は WHERE の条件のうち、結合より下で評価できるものを運びます。
+type pushdown struct {
+	conds []ast.Expr // まだ下ろしていない条件
+	moved []ast.Expr // 下ろした条件
+}
+
+func newPushdown(where ast.Expr) *pushdown {
+	if where == nil {
+		return &pushdown{}
+	}
+	return &pushdown{conds: Conjuncts(where)}
+}
+
+// take は cols の列だけで評価できる条件を取り出します。
+func (d *pushdown) take(cols []Column) []ast.Expr {
+	if d == nil {
+		return nil
+	}
+	var out, rest []ast.Expr
+	for _, c := range d.conds {
+		if refersOnly(c, cols) {
+			out = append(out, c)
+		} else {
+			rest = append(rest, c)
+		}
+	}
+	d.conds = rest
+	d.moved = append(d.moved, out...)
+	return out
+}
+
+// rest は下ろせなかった条件を AND でつないで返します。
+func (d *pushdown) rest() ast.Expr { return andAll(d.conds) }
+
+// check は下ろした条件の列の参照が、FROM 全体の列 cols の中でも1つに決まるかを確かめます。
+// 下ろした先のテーブルの列だけを見ると、別のテーブルにもある列の名前の曖昧さを見落とすためです。
+func (d *pushdown) check(cols []Column) error {
+	var err error
+	for _, c := range d.moved {
+		ast.WalkExpr(c, func(e ast.Expr) bool {
+			if ref, ok := e.(*ast.ColumnRef); ok && err == nil {
+				_, err = resolve(cols, ref)
+			}
+			return err == nil
+		})
+	}
+	return err
+}
+
+// refersOnly は式 e が副問い合わせを含まず、列の参照がすべて cols の列であるかを返します。
+func refersOnly(e ast.Expr, cols []Column) bool {
+	if hasSubquery(e) {
+		return false
+	}
+	ok := true
+	ast.WalkExpr(e, func(x ast.Expr) bool {
+		if ref, isRef := x.(*ast.ColumnRef); isRef {
+			if _, err := resolve(cols, ref); err != nil {
+				ok = false
+			}
+		}
+		return ok
+	})
+	return ok
+}
+
+// hasSubquery は式 e が副問い合わせを含むかを返します。
+func hasSubquery(e ast.Expr) bool {
+	found := false
+	ast.WalkExpr(e, func(x ast.Expr) bool {
+		switch x.(type) {
+		case *ast.Subquery, *ast.Exists, *ast.InSubquery:
+			found = true
+		}
+		return !found
+	})
+	return found
+}
+
+// columnUse は問い合わせが参照する列です。名前はすべて小文字にします。
+type columnUse struct {
+	names     map[string]bool // テーブル名を付けずに参照した列
+	qualified map[string]bool // テーブル名を付けて参照した列。"テーブル.列" がキー
+	stars     map[string]bool // * で列をすべて参照したテーブル
+}
+
+func newColumnUse() *columnUse {
+	return &columnUse{names: make(map[string]bool), qualified: make(map[string]bool), stars: make(map[string]bool)}
+}
+
+// add は SELECT 文 s とその中の問い合わせが参照する列を加えます。
+func (u *columnUse) add(s *ast.Select) {
+	ast.Subqueries(s, func(s *ast.Select) {
+		for _, item := range s.Columns {
+			if !item.Star {
+				continue
+			}
+			if item.Table != "" {
+				u.stars[strings.ToLower(item.Table)] = true
+				continue
+			}
+			ast.Tables(s.From, func(tn *ast.TableName) {
+				name := tn.Alias
+				if name == "" {
+					name = tn.Name
+				}
+				u.stars[strings.ToLower(name)] = true
+			})
+		}
+		ast.WalkSelect(s, func(e ast.Expr) bool {
+			if ref, ok := e.(*ast.ColumnRef); ok {
+				if ref.Table == "" {
+					u.names[strings.ToLower(ref.Column)] = true
+				} else {
+					u.qualified[strings.ToLower(ref.Table+"."+ref.Column)] = true
+				}
+			}
+			return true
+		})
+	})
+}
+
+// columns はテーブル t を別名 alias で読むときに、値を読む列を返します。すべての列を読むなら nil です。
+func (u *columnUse) columns(t *catalog.Table, alias string) []bool {
+	alias = strings.ToLower(alias)
+	if u == nil || u.stars[alias] {
+		return nil
+	}
+	read := make([]bool, len(t.Columns))
+	all := true
+	for i, c := range t.Columns {
+		name := strings.ToLower(c.Name)
+		read[i] = u.names[name] || u.qualified[alias+"."+name]
+		all = all && read[i]
+	}
+	if all {
+		return nil
+	}
+	return read
+}
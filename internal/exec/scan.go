@@ -0,0 +1,136 @@
+package exec
+
+import (
+	"fmt"
+
+	"github.com/k-sml/go-rdbms/internal/catalog"
+	"github.com/k-sml/go-rdbms/internal/sql/ast"
+	"github.com/k-sml/go-rdbms/internal/storage"
+	"github.com/k-sml/go-rdbms/internal/types"
+)
+
+// SeqScan はテーブルのすべての行を格納順に返します。
+type SeqScan struct {
+	src   Source
+	table *catalog.Table
+	cols  []Column
+	read  []bool // nil でなければ、値を読む列
+	it    RowIter
+	rid   storage.RID
+
+	batchSize int    // 0 でなければ、バッチで読むときのバッチの行の数の上限（batch.go）
+	want      int    // 次に読むバッチの行の数
+	batch     *batch // 読んだバッチ
+
+	workers int     // 0 でなければ、Gather の下でテーブルの一部を読む（gather.go）。並列に読むゴルーチンの数
+	part    RowIter // Gather が Open の前に渡す、このスキャンが読むカーソル
+}
+
+// NewSeqScan はテーブル t を読む SeqScan を作ります。alias が空でなければ列のテーブル名にします。
+func NewSeqScan(src Source, t *catalog.Table, alias string) *SeqScan {
+	return &SeqScan{src: src, table: t, cols: TableColumns(t, alias)}
+}
+
+// TableColumns はテーブル t の列を返します。alias が空でなければ列のテーブル名にします。
+func TableColumns(t *catalog.Table, alias string) []Column {
+	if alias == "" {
+		alias = t.Name
+	}
+	cols := make([]Column, len(t.Columns))
+	for i, c := range t.Columns {
+		cols[i] = Column{Table: alias, Name: c.Name, Type: c.Type}
+	}
+	return cols
+}
+
+func (s *SeqScan) Columns() []Column { return s.cols }
+
+// Table は読むテーブルを返します。
+func (s *SeqScan) Table() *catalog.Table { return s.table }
+
+func (s *SeqScan) Open() error {
+	if s.workers > 0 {
+		s.it, s.part = s.part, nil
+		s.want = min(firstBatch, s.batchSize)
+		return nil
+	}
+	it, err := s.src.ScanTable(s.table, s.read)
+	s.it = it
+	s.want = min(firstBatch, s.batchSize)
+	return err
+}
+
+func (s *SeqScan) Next() ([]types.Value, bool, error) {
+	if s.it == nil {
+		return nil, false, fmt.Errorf("scan of %s is not open", s.table.Name)
+	}
+	rid, row, ok, err := s.it.Next()
+	s.rid = rid
+	return row, ok, err
+}
+
+// RID は最後に返した行の位置です。
+func (s *SeqScan) RID() storage.RID { return s.rid }
+
+func (s *SeqScan) Close() error {
+	s.it = nil
+	return nil
+}
+
+// IndexScan はインデックスの範囲の行をインデックスの順に返します。
+type IndexScan struct {
+	src   Source
+	table *catalog.Table
+	index *catalog.Index
+	r     IndexRange
+	cols  []Column
+	read  []bool // nil でなければ、値を読む列
+	it    RowIter
+	rid   storage.RID
+
+	bounds *indexBounds // 範囲が準備した文の引数で決まるなら、その境界
+	params *Params
+	conds  []ast.Expr // 範囲を決めた条件のうち、範囲だけで満たされるもの（上の Filter の見積もりで使う）
+}
+
+// NewIndexScan はテーブル t のインデックス ix の範囲 r を読む IndexScan を作ります。
+func NewIndexScan(src Source, t *catalog.Table, alias string, ix *catalog.Index, r IndexRange) *IndexScan {
+	return &IndexScan{src: src, table: t, index: ix, r: r, cols: TableColumns(t, alias)}
+}
+
+func (s *IndexScan) Columns() []Column { return s.cols }
+
+// Table は読むテーブルを返します。
+func (s *IndexScan) Table() *catalog.Table { return s.table }
+
+// Index は使うインデックスを返します。
+func (s *IndexScan) Index() *catalog.Index { return s.index }
+
+// Range は読む範囲を返します。範囲が準備した文の引数で決まるなら、Open したときに決まります。
+func (s *IndexScan) Range() IndexRange { return s.r }
+
+func (s *IndexScan) Open() error {
+	if s.bounds != nil {
+		s.r = s.bounds.resolve(s.params)
+	}
+	it, err := s.src.ScanIndex(s.index, s.r, s.read)
+	s.it = it
+	return err
+}
+
+func (s *IndexScan) Next() ([]types.Value, bool, error) {
+	if s.it == nil {
+		return nil, false, fmt.Errorf("scan of %s is not open", s.index.Name)
+	}
+	rid, row, ok, err := s.it.Next()
+	s.rid = rid
+	return row, ok, err
+}
+
+// RID は最後に返した行の位置です。
+func (s *IndexScan) RID() storage.RID { return s.rid }
+
+func (s *IndexScan) Close() error {
+	s.it = nil
+	return nil
+}
@@ -0,0 +1,325 @@
+package exec
+
+import (
+	"math"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/k-sml/go-rdbms/internal/catalog"
+	"github.com/k-sml/go-rdbms/internal/sql/ast"
+	"github.com/k-sml/go-rdbms/internal/types"
+)
+
+// 条件を満たす行の割合の見積もり
+//
+// 「列 演算子 定数」の形の条件は、列の統計情報から割合を求める。等号は値の種類の数の逆数、
+// 大小の比較はヒストグラムで定数より小さい値の割合、IS NULL は NULL の割合である。
+// 統計情報がなければ、一意インデックスの列の等号は1行とし、それ以外は条件の形ごとの既定の値を使う。
+// AND は割合の積、OR は和から積を引いたものとし、条件どうしは独立であるとみなす。
+//
+// 列の参照は、条件を評価する演算子の下の SeqScan、IndexScan、IndexNestedLoopJoin の右のテーブルの
+// 列までたどって、どのテーブルのどの列かを調べる。ビューや CTE の中の列まではたどらない。
+
+// selectivity は op の行のうち条件 cond を満たすものの割合を見積もります。
+func (e *estimator) selectivity(cond ast.Expr, op Operator) float64 {
+	return clamp01(e.clause(cond, op))
+}
+
+// filterSelectivity は op の上の Filter が条件 where で残す行の割合を見積もります。op が IndexScan
+// なら、読む範囲だけで満たされる条件は IndexScan の行の数の見積もりに含まれているので、除きます。
+func (e *estimator) filterSelectivity(where ast.Expr, op Operator) float64 {
+	s, ok := op.(*IndexScan)
+	if !ok || len(s.conds) == 0 {
+		return e.selectivity(where, op)
+	}
+	sel := 1.0
+	for _, c := range Conjuncts(where) {
+		if !slices.Contains(s.conds, c) {
+			sel *= e.clause(c, op)
+		}
+	}
+	return clamp01(sel)
+}
+
+func (e *estimator) clause(c ast.Expr, op Operator) float64 {
+	switch c := c.(type) {
+	case *ast.Binary:
+		switch c.Op {
+		case "AND":
+			return e.clause(c.L, op) * e.clause(c.R, op)
+		case "OR":
+			l, r := e.clause(c.L, op), e.clause(c.R, op)
+			return l + r - l*r
+		case "=", "<>", "<", "<=", ">", ">=":
+			return e.compare(c, op)
+		}
+	case *ast.Unary:
+		if c.Op == "NOT" {
+			return 1 - e.clause(c.X, op)
+		}
+	case *ast.IsNull:
+		s := eqSelectivity
+		if col, ok := e.column(op, c.X); ok {
+			s = col.nullFrac()
+		}
+		if c.Not {
+			return 1 - s
+		}
+		return s
+	case *ast.Between:
+		s := defaultSelectivity * defaultSelectivity
+		lo, hi := constant(c.Lo), constant(c.Hi)
+		if col, ok := e.column(op, c.X); ok && lo != nil && hi != nil {
+			s = col.rangeSelectivity(lo, hi)
+		}
+		if c.Not {
+			return 1 - s
+		}
+		return s
+	case *ast.InList:
+		s := defaultSelectivity
+		if col, ok := e.column(op, c.X); ok {
+			s = math.Min(float64(len(c.List))*col.eqSelectivity(), 1)
+		}
+		if c.Not {
+			return 1 - s
+		}
+		return s
+	case *ast.Like:
+		s := defaultSelectivity
+		if col, ok := e.column(op, c.X); ok {
+			if lo, hi, ok := likeBounds(c); ok {
+				s = col.rangeSelectivity(&lo, hi)
+			}
+		}
+		if c.Not {
+			return 1 - s
+		}
+		return s
+	case *ast.Literal:
+		if Truth(c.Value) {
+			return 1
+		}
+		return 0
+	case *ast.Exists, *ast.InSubquery:
+		return semiSelectivity
+	}
+	return defaultSelectivity
+}
+
+// compare は比較の条件を満たす割合を見積もります。
+func (e *estimator) compare(c *ast.Binary, op Operator) float64 {
+	l, lok := e.column(op, c.L)
+	r, rok := e.column(op, c.R)
+	switch {
+	case lok && rok:
+		if c.Op == "=" {
+			return math.Min(l.eqSelectivity(), r.eqSelectivity())
+		}
+		return defaultSelectivity
+	case rok:
+		l, c = r, &ast.Binary{Op: flip[c.Op], L: c.R, R: c.L}
+		if c.Op == "" {
+			c.Op = "<>"
+		}
+	case !lok:
+		if c.Op == "=" {
+			return eqSelectivity
+		}
+		return defaultSelectivity
+	}
+	if !isConstant(c.R) {
+		if c.Op == "=" {
+			return l.eqSelectivity()
+		}
+		return defaultSelectivity
+	}
+	switch c.Op {
+	case "=":
+		return l.eqSelectivity()
+	case "<>":
+		return 1 - l.eqSelectivity() - l.nullFrac()
+	}
+	// 準備した文の引数など、値のわからない定数とは大小を比べられない
+	v := constant(c.R)
+	if v == nil {
+		return defaultSelectivity
+	}
+	switch c.Op {
+	case "<", "<=":
+		return l.rangeSelectivity(nil, v)
+	}
+	return l.rangeSelectivity(v, nil)
+}
+
+// isConstant は e が定数か準備した文の引数かを返します。
+func isConstant(e ast.Expr) bool {
+	switch e := e.(type) {
+	case *ast.Literal, *ast.Param:
+		return true
+	case *ast.Unary:
+		return e.Op == "-" && isConstant(e.X)
+	}
+	return false
+}
+
+// constant は e が NULL でない定数ならその値を返します。
+func constant(e ast.Expr) *types.Value {
+	if lit, ok := e.(*ast.Literal); ok && !lit.Value.IsNull() {
+		return &lit.Value
+	}
+	return nil
+}
+
+// columnInfo は条件が参照するテーブルの列です。
+type columnInfo struct {
+	e     *estimator
+	table *catalog.Table
+	i     int
+}
+
+// column は式 x が op の下のテーブルの列の参照なら、その列を返します。
+func (e *estimator) column(op Operator, x ast.Expr) (columnInfo, bool) {
+	ref, ok := x.(*ast.ColumnRef)
+	if !ok {
+		return columnInfo{}, false
+	}
+	cols := op.Columns()
+	i, err := resolve(cols, ref)
+	if err != nil {
+		return columnInfo{}, false
+	}
+	t, j, ok := baseColumn(op, cols[i])
+	return columnInfo{e: e, table: t, i: j}, ok
+}
+
+// baseColumn は op の行の列 c が、どのテーブルの何番目の列かを返します。
+func baseColumn(op Operator, c Column) (*catalog.Table, int, bool) {
+	match := func(t *catalog.Table, cols []Column) (*catalog.Table, int, bool) {
+		for i, col := range cols {
+			if strings.EqualFold(col.Table, c.Table) && strings.EqualFold(col.Name, c.Name) {
+				return t, i, true
+			}
+		}
+		return nil, -1, false
+	}
+	switch o := op.(type) {
+	case *SeqScan:
+		return match(o.table, o.cols)
+	case *IndexScan:
+		return match(o.table, o.cols)
+	case *IndexNestedLoopJoin:
+		if t, i, ok := match(o.table, o.cols[o.nl:]); ok {
+			return t, i, true
+		}
+		return baseColumn(o.left, c)
+	case *Filter, *Gather, *NestedLoopJoin, *HashJoin, *MergeJoin, *SemiJoin, *instrumented:
+		for _, in := range children(op) {
+			if t, i, ok := baseColumn(*in, c); ok {
+				return t, i, true
+			}
+		}
+	}
+	return nil, -1, false
+}
+
+func (c columnInfo) stats() *catalog.ColumnStats { return c.e.tableStats(c.table).Column(c.i) }
+
+// nullFrac は列の値が NULL の行の割合です。
+func (c columnInfo) nullFrac() float64 {
+	if s := c.stats(); s != nil {
+		return s.NullFrac
+	}
+	if c.table.Columns[c.i].NotNull || c.table.Columns[c.i].PrimaryKey {
+		return 0
+	}
+	return eqSelectivity
+}
+
+// eqSelectivity は列の値がある1つの値と等しい行の割合です。
+func (c columnInfo) eqSelectivity() float64 {
+	if s := c.stats(); s != nil && s.Distinct > 0 {
+		return (1 - s.NullFrac) / s.Distinct
+	}
+	name := c.table.Columns[c.i].Name
+	for _, ix := range c.e.src.Indexes(c.table.Name) {
+		if ix.Unique && len(ix.Columns) == 1 && strings.EqualFold(ix.Columns[0], name) {
+			return 1 / math.Max(float64(c.e.tableStats(c.table).Rows), 1)
+		}
+	}
+	return eqSelectivity
+}
+
+// rangeSelectivity は列の値が lo 以上 hi 以下の行の割合です。lo や hi が nil なら、
+// その側には制限がないか、値がわかりません。
+func (c columnInfo) rangeSelectivity(lo, hi *types.Value) float64 {
+	s := c.stats()
+	if s == nil || len(s.Histogram) < 2 {
+		if lo != nil && hi != nil {
+			return defaultSelectivity * defaultSelectivity
+		}
+		return defaultSelectivity
+	}
+	t := c.table.Columns[c.i].Type
+	from, to := 0.0, 1.0
+	if lo != nil {
+		f, ok := histogramFraction(s.Histogram, *lo, t)
+		if !ok {
+			return defaultSelectivity
+		}
+		from = f
+	}
+	if hi != nil {
+		f, ok := histogramFraction(s.Histogram, *hi, t)
+		if !ok {
+			return defaultSelectivity
+		}
+		to = f
+	}
+	// 1つの値と等しい分は、少なくとも含む
+	return (1 - s.NullFrac) * math.Max(to-from, 1/math.Max(s.Distinct, 1))
+}
+
+// histogramFraction は列の NULL でない値のうち v より小さいものの割合を、ヒストグラム h から
+// 見積もります。v を列の型 t に変換できなければ ok は false です。
+func histogramFraction(h []types.Value, v types.Value, t types.Type) (float64, bool) {
+	v, err := types.Coerce(v, t)
+	if err != nil {
+		return 0, false
+	}
+	less := func(a, b types.Value) bool {
+		c, _ := types.Compare(a, b)
+		return c < 0
+	}
+	n := len(h) - 1
+	if !less(h[0], v) {
+		return 0, true
+	}
+	if !less(v, h[n]) {
+		return 1, true
+	}
+	// v を含む区間 [h[i], h[i+1]) を探し、区間の中では値が一様に分布しているとみなす
+	i := sort.Search(n, func(i int) bool { return less(v, h[i+1]) })
+	within := 0.5
+	lo, ok1 := number(h[i])
+	hi, ok2 := number(h[i+1])
+	x, ok3 := number(v)
+	if ok1 && ok2 && ok3 && hi > lo {
+		within = (x - lo) / (hi - lo)
+	}
+	return (float64(i) + within) / float64(n), true
+}
+
+// number は数値型と日時の値を数にします。
+func number(v types.Value) (float64, bool) {
+	switch {
+	case v.Type().IsNumeric():
+		return v.Real(), true
+	case v.Type() == types.Timestamp:
+		return float64(v.Int()), true
+	}
+	return 0, false
+}
+
+func clamp01(f float64) float64 { return math.Min(math.Max(f, 0), 1) }
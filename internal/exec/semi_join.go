@@ -0,0 +1,81 @@
+package exec
+
+import "github.com/k-sml/go-rdbms/internal/types"
+
+// SemiJoin は左の行のうち、キーが等しい右の行があるもの（anti なら、ないもの）を返します。
+// 右の行はキーだけをハッシュ表に入れ、左の行は右の行と組み合わせずにそのまま返します。
+type SemiJoin struct {
+	left, right Operator
+	lkeys       []Expr
+	rkeys       []Expr
+	ktypes      []types.Type
+	anti        bool
+
+	set map[string]struct{}
+	key []byte
+}
+
+// NewSemiJoin は SemiJoin を作ります。EXISTS や IN (SELECT ...) は anti を false に、
+// NOT EXISTS は true にします。キーに NULL がある左の行は、どの右の行とも一致しません。
+func NewSemiJoin(left, right Operator, lkeys, rkeys []Expr, ktypes []types.Type, anti bool) *SemiJoin {
+	return &SemiJoin{left: left, right: right, lkeys: lkeys, rkeys: rkeys, ktypes: ktypes, anti: anti}
+}
+
+func (j *SemiJoin) Columns() []Column { return j.left.Columns() }
+
+func (j *SemiJoin) Open() error {
+	j.set = make(map[string]struct{})
+	if err := j.right.Open(); err != nil {
+		j.right.Close()
+		return err
+	}
+	for {
+		row, ok, err := j.right.Next()
+		if err != nil {
+			j.right.Close()
+			return err
+		}
+		if !ok {
+			break
+		}
+		key, ok, err := appendJoinKey(j.key[:0], j.rkeys, j.ktypes, row)
+		if err != nil {
+			j.right.Close()
+			return err
+		}
+		j.key = key
+		if ok {
+			j.set[string(key)] = struct{}{}
+		}
+	}
+	if err := j.right.Close(); err != nil {
+		return err
+	}
+	return j.left.Open()
+}
+
+func (j *SemiJoin) Next() ([]types.Value, bool, error) {
+	for {
+		row, ok, err := j.left.Next()
+		if err != nil || !ok {
+			return nil, false, err
+		}
+		key, ok, err := appendJoinKey(j.key[:0], j.lkeys, j.ktypes, row)
+		if err != nil {
+			return nil, false, err
+		}
+		j.key = key
+		found := false
+		if ok {
+			_, found = j.set[string(key)]
+		}
+		if found != j.anti {
+			return row, true, nil
+		}
+	}
+}
+
+func (j *SemiJoin) Close() error {
+	j.set = nil
+	return j.left.Close()
+}
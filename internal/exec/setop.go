@@ -0,0 +1,261 @@
+package exec
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/k-sml/go-rdbms/internal/sql/ast"
+	"github.com/k-sml/go-rdbms/internal/types"
+)
+
+// 集合演算
+//
+// UNION ALL は左右の行を続けて返す Append に、UNION はその上に Distinct を重ねる。
+// INTERSECT と EXCEPT は HashSetOp で、右の行をハッシュ表で数えておき、左の行ごとに引く。
+// どれも NULL どうしは等しいものとして扱う。左右の値は、列ごとに両辺の共通の型にそろえてから比べる。
+// 結果の列の名前は左の SELECT の列の名前で、ORDER BY ではその名前か列の番号を使う。
+
+// compound は集合演算の SELECT 文の実行計画を作ります。
+func (p *planner) compound(s *ast.Select) (Operator, error) {
+	c := s.Compound
+	left, err := p.selectStmt(c.Left)
+	if err != nil {
+		return nil, err
+	}
+	right, err := p.selectStmt(c.Right)
+	if err != nil {
+		return nil, err
+	}
+	lc, rc := left.Columns(), right.Columns()
+	if len(lc) != len(rc) {
+		return nil, fmt.Errorf("each %s query must have the same number of columns", c.Op)
+	}
+	cols := make([]Column, len(lc))
+	for i := range lc {
+		t := lc[i].Type
+		switch {
+		case t == types.Null:
+			t = rc[i].Type
+		case rc[i].Type != types.Null:
+			ct, ok := types.Common(t, rc[i].Type)
+			if !ok {
+				return nil, fmt.Errorf("%s types %s and %s cannot be matched", c.Op, t, rc[i].Type)
+			}
+			t = ct
+		}
+		cols[i] = Column{Name: lc[i].Name, Type: t}
+	}
+	left, right = convertColumns(left, cols), convertColumns(right, cols)
+
+	var op Operator
+	switch c.Op {
+	case ast.Union:
+		op = NewAppend(left, right)
+		if !c.All {
+			op = NewDistinct(op)
+		}
+	case ast.Intersect:
+		op = NewHashSetOp(left, right, Intersect, c.All)
+	case ast.Except:
+		op = NewHashSetOp(left, right, Except, c.All)
+	}
+
+	count, offset, err := limit(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(s.OrderBy) > 0 {
+		keys := make([]SortKey, len(s.OrderBy))
+		for i, o := range s.OrderBy {
+			if keys[i].Expr, err = p.compoundOrder(o.Expr, cols); err != nil {
+				return nil, err
+			}
+			keys[i].Desc = o.Desc
+		}
+		sorter := NewSort(op, keys)
+		if count >= 0 && offset <= math.MaxInt64-count {
+			sorter.SetLimit(offset + count)
+		}
+		p.noteSortKeys(sorter, s.OrderBy)
+		op = sorter
+	}
+	if s.Limit != nil || s.Offset != nil {
+		op = NewLimit(op, count, offset)
+	}
+	return op, nil
+}
+
+// compoundOrder は集合演算の ORDER BY の式をコンパイルします。整数の定数は結果の列の番号（1 から）です。
+func (p *planner) compoundOrder(e ast.Expr, cols []Column) (Expr, error) {
+	if lit, ok := e.(*ast.Literal); ok && lit.Value.Type().IsInteger() {
+		n := lit.Value.Int()
+		if n < 1 || n > int64(len(cols)) {
+			return nil, fmt.Errorf("ORDER BY term out of range: %d", n)
+		}
+		return func(row []types.Value) (types.Value, error) { return row[n-1], nil }, nil
+	}
+	return p.compile(e, cols)
+}
+
+// convertColumns は op の行の値を列 cols の型に変換します。
+func convertColumns(op Operator, cols []Column) Operator {
+	in := op.Columns()
+	same := true
+	exprs := make([]Expr, len(in))
+	for i := range in {
+		t := cols[i].Type
+		if in[i].Type == t || t == types.Null {
+			exprs[i] = func(row []types.Value) (types.Value, error) { return row[i], nil }
+			continue
+		}
+		same = false
+		exprs[i] = func(row []types.Value) (types.Value, error) { return types.Coerce(row[i], t) }
+	}
+	if same {
+		return NewRename(op, cols)
+	}
+	return NewProject(op, exprs, cols)
+}
+
+// Append は左の行をすべて返した後、右の行を返します。
+type Append struct {
+	left, right Operator
+	cur         Operator // 読んでいる演算子。読み終えたら nil
+}
+
+// NewAppend は left と right の行を続けて返す Append を作ります。
+func NewAppend(left, right Operator) *Append { return &Append{left: left, right: right} }
+
+func (a *Append) Columns() []Column { return a.left.Columns() }
+
+func (a *Append) Open() error {
+	a.cur = a.left
+	return a.left.Open()
+}
+
+func (a *Append) Next() ([]types.Value, bool, error) {
+	for a.cur != nil {
+		row, ok, err := a.cur.Next()
+		if err != nil || ok {
+			return row, ok, err
+		}
+		cur := a.cur
+		a.cur = nil
+		if err := cur.Close(); err != nil {
+			return nil, false, err
+		}
+		if cur == a.left {
+			if err := a.right.Open(); err != nil {
+				a.right.Close()
+				return nil, false, err
+			}
+			a.cur = a.right
+		}
+	}
+	return nil, false, nil
+}
+
+func (a *Append) Close() error {
+	if a.cur == nil {
+		return nil
+	}
+	cur := a.cur
+	a.cur = nil
+	return cur.Close()
+}
+
+// SetOp は HashSetOp の集合演算の種類です。
+type SetOp int
+
+const (
+	Intersect SetOp = iota
+	Except
+)
+
+// HashSetOp は INTERSECT と EXCEPT を行います。右の行をそれぞれの数とともにハッシュ表に入れ、
+// 左の行を1つずつ引きます。all なら、同じ行を INTERSECT では左右の数の少ないほうだけ、
+// EXCEPT では左の数から右の数を引いただけ返します。all でなければ同じ行は1回だけ返します。
+type HashSetOp struct {
+	left, right Operator
+	op          SetOp
+	all         bool
+
+	counts map[string]int // 右の行の数。all でなければ、返した行は -1
+	key    []byte
+}
+
+// NewHashSetOp は left op [ALL] right を行う HashSetOp を作ります。
+func NewHashSetOp(left, right Operator, op SetOp, all bool) *HashSetOp {
+	return &HashSetOp{left: left, right: right, op: op, all: all}
+}
+
+func (h *HashSetOp) Columns() []Column { return h.left.Columns() }
+
+func (h *HashSetOp) Open() error {
+	h.counts = make(map[string]int)
+	if err := h.right.Open(); err != nil {
+		h.right.Close()
+		return err
+	}
+	for {
+		row, ok, err := h.right.Next()
+		if err != nil {
+			h.right.Close()
+			return err
+		}
+		if !ok {
+			break
+		}
+		h.counts[string(h.rowKey(row))]++
+	}
+	if err := h.right.Close(); err != nil {
+		return err
+	}
+	return h.left.Open()
+}
+
+func (h *HashSetOp) rowKey(row []types.Value) []byte {
+	h.key = h.key[:0]
+	for _, v := range row {
+		h.key = types.AppendKey(h.key, v)
+	}
+	return h.key
+}
+
+func (h *HashSetOp) Next() ([]types.Value, bool, error) {
+	for {
+		row, ok, err := h.left.Next()
+		if err != nil || !ok {
+			return nil, false, err
+		}
+		k := string(h.rowKey(row))
+		n := h.counts[k]
+		switch {
+		case h.op == Intersect && h.all:
+			if n > 0 {
+				h.counts[k] = n - 1
+				return row, true, nil
+			}
+		case h.op == Intersect:
+			if n > 0 {
+				h.counts[k] = -1
+				return row, true, nil
+			}
+		case h.all:
+			if n == 0 {
+				return row, true, nil
+			}
+			h.counts[k] = n - 1
+		default:
+			if n == 0 {
+				h.counts[k] = -1
+				return row, true, nil
+			}
+		}
+	}
+}
+
+func (h *HashSetOp) Close() error {
+	h.counts = nil
+	return h.left.Close()
+}
@@ -0,0 +1,77 @@
+package exec
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+
+	"github.com/k-sml/go-rdbms/internal/tuple"
+	"github.com/k-sml/go-rdbms/internal/types"
+)
+
+// spillFile は演算子がメモリに収まらない行を一時的に書き出すファイルです。
+// 行は書いた順に読み返します。各行は [uvarint:長さ][tuple.Encode の形式] です。
+type spillFile struct {
+	f   *os.File
+	w   *bufio.Writer
+	r   *bufio.Reader
+	buf []byte
+}
+
+func newSpillFile() (*spillFile, error) {
+	f, err := os.CreateTemp("", "rdbms-spill-*")
+	if err != nil {
+		return nil, err
+	}
+	return &spillFile{f: f, w: bufio.NewWriter(f)}, nil
+}
+
+// Write は行を書き足します。Rewind の後には呼べません。
+func (s *spillFile) Write(row []types.Value) error {
+	rec := tuple.Encode(row)
+	s.buf = binary.AppendUvarint(s.buf[:0], uint64(len(rec)))
+	if _, err := s.w.Write(s.buf); err != nil {
+		return err
+	}
+	_, err := s.w.Write(rec)
+	return err
+}
+
+// Rewind は書き終えたファイルを先頭から読めるようにします。
+func (s *spillFile) Rewind() error {
+	if err := s.w.Flush(); err != nil {
+		return err
+	}
+	if _, err := s.f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	s.r = bufio.NewReader(s.f)
+	return nil
+}
+
+// Read は次の行を返します。最後まで読むと ok が false になります。
+func (s *spillFile) Read() (row []types.Value, ok bool, err error) {
+	n, err := binary.ReadUvarint(s.r)
+	if err == io.EOF {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	if uint64(cap(s.buf)) < n {
+		s.buf = make([]byte, n)
+	}
+	rec := s.buf[:n]
+	if _, err := io.ReadFull(s.r, rec); err != nil {
+		return nil, false, err
+	}
+	row, err = tuple.Decode(rec)
+	return row, err == nil, err
+}
+
+// Close はファイルを閉じて削除します。
+func (s *spillFile) Close() error {
+	return errors.Join(s.f.Close(), os.Remove(s.f.Name()))
+}
@@ -0,0 +1,427 @@
+package exec
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/k-sml/go-rdbms/internal/sql/ast"
+	"github.com/k-sml/go-rdbms/internal/types"
+)
+
+// 副問い合わせ
+//
+// 式の中の副問い合わせは、式をコンパイルするときに planner で実行計画を作り、式の評価のたびに
+// 実行する。副問い合わせの中で自分の FROM にない列の名前は、外側の問い合わせの列として解決する
+// （相関副問い合わせ）。外側の列は scope を通して読み、scope には評価中の外側の行を置く。
+// 外側の列を参照しない副問い合わせの結果は、最初に評価したときのものを使い回す。
+//
+// WHERE の AND でつながった条件のうち EXISTS、NOT EXISTS、IN (SELECT ...) で、外側の行との
+// 関係が「副問い合わせの列の式 = 外側の列の式」の条件だけのものは、副問い合わせを行ごとに
+// 実行する代わりに SemiJoin にする。
+
+// scope は副問い合わせから見える外側の問い合わせの列です。
+type scope struct {
+	cols   []Column
+	row    []types.Value // 評価中の外側の行
+	parent *scope
+	refs   int // 内側から参照された列の数
+}
+
+// column は列の参照をコンパイルします。cols にない列は外側の問い合わせの列として探します。
+func (c *compiler) column(ref *ast.ColumnRef) (Expr, error) {
+	if c.outer == nil || numMatches(c.cols, ref) > 0 {
+		i, err := resolve(c.cols, ref)
+		if err != nil {
+			return nil, err
+		}
+		return func(row []types.Value) (types.Value, error) { return row[i], nil }, nil
+	}
+	// 参照した外側の行が変わると結果も変わるので、途中の問い合わせにも印を付ける
+	var visited []*scope
+	for s := c.outer; s != nil; s = s.parent {
+		visited = append(visited, s)
+		if numMatches(s.cols, ref) == 0 {
+			continue
+		}
+		i, err := resolve(s.cols, ref)
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range visited {
+			v.refs++
+		}
+		return func([]types.Value) (types.Value, error) { return s.row[i], nil }, nil
+	}
+	_, err := resolve(c.cols, ref)
+	return nil, err
+}
+
+// subquery は副問い合わせの実行計画です。
+type subquery struct {
+	op    Operator
+	scope *scope
+}
+
+// subquery は式の中の副問い合わせ s の実行計画を作ります。
+func (c *compiler) subquery(s *ast.Select) (*subquery, error) {
+	if c.p == nil {
+		return nil, errors.New("subqueries are not allowed here")
+	}
+	sc := &scope{cols: c.cols, parent: c.outer}
+	saved := c.p.scope
+	c.p.scope = sc
+	op, err := c.p.selectStmt(s)
+	c.p.scope = saved
+	if err != nil {
+		return nil, err
+	}
+	q := &subquery{op: op, scope: sc}
+	c.p.notes.addSubplan(q)
+	return q, nil
+}
+
+// correlated は副問い合わせが外側の列を参照するかを返します。
+func (q *subquery) correlated() bool { return q.scope.refs > 0 }
+
+// run は外側の行を row として副問い合わせを実行し、結果の行ごとに fn を呼びます。
+// fn が false を返すと、そこで読むのをやめます。
+func (q *subquery) run(row []types.Value, fn func([]types.Value) (bool, error)) (err error) {
+	q.scope.row = row
+	if err := q.op.Open(); err != nil {
+		q.op.Close()
+		return err
+	}
+	defer func() {
+		if cerr := q.op.Close(); err == nil {
+			err = cerr
+		}
+	}()
+	for {
+		r, ok, err := q.op.Next()
+		if err != nil || !ok {
+			return err
+		}
+		if more, err := fn(r); err != nil || !more {
+			return err
+		}
+	}
+}
+
+// cached は外側の列を参照しない副問い合わせについて、eval の最初の結果を使い回す式を返します。
+// 準備した文の引数を Bind し直したら、結果を作り直します。
+func cached(q *subquery, params *Params, eval Expr) Expr {
+	if q.correlated() {
+		return eval
+	}
+	var v *types.Value
+	var gen uint64
+	return func(row []types.Value) (types.Value, error) {
+		if v != nil && gen == params.generation() {
+			return *v, nil
+		}
+		w, err := eval(row)
+		if err != nil {
+			return w, err
+		}
+		v, gen = &w, params.generation()
+		return w, nil
+	}
+}
+
+// scalar は (SELECT ...) を、結果の1行目の値として評価します。行がなければ NULL で、
+// 2行以上あればエラーです。
+func (c *compiler) scalar(e *ast.Subquery) (Expr, error) {
+	q, err := c.subquery(e.Select)
+	if err != nil {
+		return nil, err
+	}
+	if n := len(q.op.Columns()); n != 1 {
+		return nil, fmt.Errorf("subquery returns %d columns, expected 1", n)
+	}
+	return cached(q, c.p.params, func(row []types.Value) (types.Value, error) {
+		v, n := types.NullValue(), 0
+		err := q.run(row, func(r []types.Value) (bool, error) {
+			v = r[0]
+			n++
+			return n < 2, nil
+		})
+		if err == nil && n > 1 {
+			err = errors.New("subquery returned more than one row")
+		}
+		return v, err
+	}), nil
+}
+
+// exists は EXISTS (SELECT ...) を、結果に行があるかとして評価します。
+func (c *compiler) exists(e *ast.Exists) (Expr, error) {
+	q, err := c.subquery(e.Select)
+	if err != nil {
+		return nil, err
+	}
+	return cached(q, c.p.params, func(row []types.Value) (types.Value, error) {
+		found := false
+		err := q.run(row, func([]types.Value) (bool, error) {
+			found = true
+			return false, nil
+		})
+		return types.NewBool(found), err
+	}), nil
+}
+
+// inSubquery は X IN (SELECT ...) を評価します。NULL の扱いは IN (List...) と同じで、
+// 副問い合わせの結果が空なら X が NULL でも偽です。
+func (c *compiler) inSubquery(e *ast.InSubquery) (Expr, error) {
+	x, err := c.compile(e.X)
+	if err != nil {
+		return nil, err
+	}
+	q, err := c.subquery(e.Select)
+	if err != nil {
+		return nil, err
+	}
+	cols := q.op.Columns()
+	if len(cols) != 1 {
+		return nil, fmt.Errorf("subquery returns %d columns, expected 1", len(cols))
+	}
+	if err := checkComparable(TypeOf(e.X, c.cols, c.funcs), cols[0].Type); err != nil {
+		return nil, err
+	}
+	var set *inSet
+	var gen uint64
+	negate, params := e.Not, c.p.params
+	return func(row []types.Value) (types.Value, error) {
+		v, err := x(row)
+		if err != nil {
+			return v, err
+		}
+		if set == nil || q.correlated() || gen != params.generation() {
+			gen = params.generation()
+			if set, err = readInSet(q, row); err != nil {
+				set = nil
+				return types.Value{}, err
+			}
+		}
+		r, err := set.contains(v)
+		if err != nil {
+			return r, err
+		}
+		if negate {
+			r = not(r)
+		}
+		return r, nil
+	}, nil
+}
+
+// inSet は IN の副問い合わせが返した値です。NULL 以外の値を昇順に並べて持ちます。
+type inSet struct {
+	vals    []types.Value
+	hasNull bool
+}
+
+func readInSet(q *subquery, row []types.Value) (*inSet, error) {
+	set := &inSet{}
+	err := q.run(row, func(r []types.Value) (bool, error) {
+		if r[0].IsNull() {
+			set.hasNull = true
+		} else {
+			set.vals = append(set.vals, r[0])
+		}
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(set.vals, func(i, j int) bool {
+		c, cerr := types.Compare(set.vals[i], set.vals[j])
+		if cerr != nil && err == nil {
+			err = cerr
+		}
+		return c < 0
+	})
+	return set, err
+}
+
+// contains は v IN set の結果を返します。
+func (s *inSet) contains(v types.Value) (types.Value, error) {
+	if len(s.vals) == 0 && !s.hasNull {
+		return types.NewBool(false), nil
+	}
+	if v.IsNull() {
+		return types.NullValue(), nil
+	}
+	var err error
+	i := sort.Search(len(s.vals), func(i int) bool {
+		r, cerr := compare(">=", s.vals[i], v)
+		if cerr != nil && err == nil {
+			err = cerr
+		}
+		return Truth(r)
+	})
+	if err != nil {
+		return types.Value{}, err
+	}
+	if i < len(s.vals) {
+		eq, err := compare("=", s.vals[i], v)
+		if err != nil || Truth(eq) {
+			return eq, err
+		}
+	}
+	if s.hasNull {
+		return types.NullValue(), nil
+	}
+	return types.NewBool(false), nil
+}
+
+// decorrelate は WHERE の条件 where のうち SemiJoin にできるものを op に結合し、
+// 残りの条件を返します。
+func (p *planner) decorrelate(op Operator, where ast.Expr) (Operator, ast.Expr, error) {
+	var rest []ast.Expr
+	for _, c := range Conjuncts(where) {
+		n := p.notes.mark()
+		j, ok, err := p.semiJoin(op, c)
+		if err != nil {
+			return nil, nil, err
+		}
+		if ok {
+			op = j
+			continue
+		}
+		p.notes.reset(n) // SemiJoin にしなかった副問い合わせの実行計画は表示しない
+		rest = append(rest, c)
+	}
+	return op, andAll(rest), nil
+}
+
+// semiJoin は条件 c が EXISTS、NOT EXISTS、IN (SELECT ...) のいずれかで、副問い合わせが
+// 外側の行を「副問い合わせの列の式 = 外側の列の式」の条件でだけ参照するなら、op の行のうち
+// c を満たすものを返す SemiJoin を作ります。NOT IN は、副問い合わせの結果に NULL があると
+// 結果が変わるので SemiJoin にしません。
+func (p *planner) semiJoin(op Operator, c ast.Expr) (Operator, bool, error) {
+	var sel *ast.Select
+	var x ast.Expr
+	anti := false
+	switch e := c.(type) {
+	case *ast.Exists:
+		sel = e.Select
+	case *ast.Unary:
+		ex, ok := e.X.(*ast.Exists)
+		if !ok || e.Op != "NOT" {
+			return nil, false, nil
+		}
+		sel, anti = ex.Select, true
+	case *ast.InSubquery:
+		if e.Not {
+			return nil, false, nil
+		}
+		sel, x = e.Select, e.X
+	default:
+		return nil, false, nil
+	}
+	if sel.From == nil || len(sel.GroupBy) > 0 || sel.Having != nil || sel.Limit != nil || sel.Offset != nil {
+		return nil, false, nil
+	}
+
+	// 副問い合わせの中の条件は外側の列を参照しないものだけにするので、外側の scope は見せない
+	saved := p.scope
+	p.scope = nil
+	defer func() { p.scope = saved }()
+
+	// 使えない形のときは、エラーも含めて副問い合わせとしてコンパイルするときに任せる
+	from, err := p.from(sel.From, nil)
+	if err != nil {
+		return nil, false, nil
+	}
+	outer, inner := op.Columns(), from.Columns()
+	compiles := func(e ast.Expr, cols []Column) bool {
+		n := p.notes.mark()
+		defer p.notes.reset(n)
+		_, err := p.compile(e, cols)
+		return err == nil
+	}
+	var conds, lkeys, rkeys []ast.Expr
+	if x != nil {
+		if len(sel.Columns) != 1 || sel.Columns[0].Star {
+			return nil, false, nil
+		}
+		item := sel.Columns[0].Expr
+		if !compiles(x, outer) || !compiles(item, inner) {
+			return nil, false, nil
+		}
+		lkeys, rkeys = append(lkeys, x), append(rkeys, item)
+	}
+	if sel.Where != nil {
+		for _, e := range Conjuncts(sel.Where) {
+			if compiles(e, inner) {
+				conds = append(conds, e)
+				continue
+			}
+			b, ok := e.(*ast.Binary)
+			if !ok || b.Op != "=" {
+				return nil, false, nil
+			}
+			switch {
+			case compiles(b.L, outer) && compiles(b.R, inner):
+				lkeys, rkeys = append(lkeys, b.L), append(rkeys, b.R)
+			case compiles(b.R, outer) && compiles(b.L, inner):
+				lkeys, rkeys = append(lkeys, b.R), append(rkeys, b.L)
+			default:
+				return nil, false, nil
+			}
+		}
+	}
+	if len(lkeys) == 0 {
+		return nil, false, nil // 外側の行によらないので、結果を使い回す副問い合わせのほうが速い
+	}
+
+	right := from
+	where := andAll(conds)
+	if tn, ok := sel.From.(*ast.TableName); ok && where != nil {
+		if right, err = p.tableName(tn, where); err != nil {
+			return nil, false, err
+		}
+	}
+	if where != nil {
+		if right, err = p.filter(right, where); err != nil {
+			return nil, false, err
+		}
+	}
+	lk, rk := make([]Expr, len(lkeys)), make([]Expr, len(rkeys))
+	ktypes := make([]types.Type, len(lkeys))
+	for i := range lkeys {
+		lt, rt := TypeOf(lkeys[i], outer, p.funcs), TypeOf(rkeys[i], inner, p.funcs)
+		if err := checkComparable(lt, rt); err != nil {
+			return nil, false, err
+		}
+		if lk[i], err = p.compile(lkeys[i], outer); err != nil {
+			return nil, false, err
+		}
+		if rk[i], err = p.compile(rkeys[i], inner); err != nil {
+			return nil, false, err
+		}
+		ktypes[i] = joinKeyType(lt, rt)
+	}
+	j := NewSemiJoin(op, right, lk, rk, ktypes, anti)
+	if p.notes != nil {
+		conds := make([]string, len(lkeys))
+		for i := range lkeys {
+			conds[i] = ast.FormatExpr(&ast.Binary{Op: "=", L: lkeys[i], R: rkeys[i]})
+		}
+		p.note(j, "Join Cond: %s", strings.Join(conds, " AND "))
+	}
+	return j, true, nil
+}
+
+// andAll は条件を AND でつなぎます。条件がなければ nil です。
+func andAll(conds []ast.Expr) ast.Expr {
+	var e ast.Expr
+	for _, c := range conds {
+		if e == nil {
+			e = c
+		} else {
+			e = &ast.Binary{Op: "AND", L: e, R: c}
+		}
+	}
+	return e
+}
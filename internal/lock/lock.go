@@ -0,0 +1,470 @@
+// Package lock はトランザクション間の排他制御を行うロックマネージャを提供します。
+// 行（RID）とテーブルに共有ロック・排他ロックをかけ、
+// トランザクションは二相ロック（2PL）に従ってコミットまでロックを保持します。
+// テーブルには意図ロック（IS/IX/SIX）もかけられ、行をロックするトランザクションは
+// 先にテーブルへ意図ロックをかけることで、テーブル全体のロックとの競合を
+// 行ロックを1つずつ調べずに判定できます。
+package lock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/k-sml/go-rdbms/internal/dberr"
+	"github.com/k-sml/go-rdbms/internal/storage"
+	"github.com/k-sml/go-rdbms/metrics"
+)
+
+// ErrDeadlock はロックを待つとデッドロックになる場合に返されます。
+// このエラーを受け取ったトランザクションはロールバックする必要があります。
+var ErrDeadlock = dberr.Mark(errors.New("deadlock detected"), dberr.ErrLocked)
+
+// ErrLockNotAvailable は待たずに取得できなかった場合や、待ち時間の上限を過ぎても
+// ロックを取得できなかった場合に返されます。
+var ErrLockNotAvailable = dberr.Mark(errors.New("could not obtain lock"), dberr.ErrLocked)
+
+// Mode はロックの種類です。
+type Mode int
+
+const (
+	Shared                Mode = iota + 1 // 共有ロック（読み取り）
+	Exclusive                             // 排他ロック（書き込み）
+	IntentShared                          // 意図共有ロック（配下の行を共有ロックする）
+	IntentExclusive                       // 意図排他ロック（配下の行を排他ロックする）
+	SharedIntentExclusive                 // 共有＋意図排他ロック（全体を読み、一部の行を書き換える）
+)
+
+// String はロックの種類の略称を返します。
+func (m Mode) String() string {
+	switch m {
+	case Shared:
+		return "S"
+	case Exclusive:
+		return "X"
+	case IntentShared:
+		return "IS"
+	case IntentExclusive:
+		return "IX"
+	case SharedIntentExclusive:
+		return "SIX"
+	default:
+		return fmt.Sprintf("Mode(%d)", int(m))
+	}
+}
+
+// compatibility はロックの両立表です。compatibility[held][want] が true なら、
+// あるトランザクションが held を保持していても別のトランザクションが want を取得できます。
+var compatibility = map[Mode]map[Mode]bool{
+	IntentShared:          {IntentShared: true, IntentExclusive: true, Shared: true, SharedIntentExclusive: true},
+	IntentExclusive:       {IntentShared: true, IntentExclusive: true},
+	Shared:                {IntentShared: true, Shared: true},
+	SharedIntentExclusive: {IntentShared: true},
+	Exclusive:             {},
+}
+
+// compatible は、あるトランザクションが held を保持しているときに
+// 別のトランザクションが want を取得できるかを返します。
+func compatible(held, want Mode) bool {
+	return compatibility[held][want]
+}
+
+// covers は held を保持していれば want を新たに取得する必要がないかを返します。
+func covers(held, want Mode) bool {
+	switch held {
+	case Exclusive:
+		return true
+	case SharedIntentExclusive:
+		return want != Exclusive
+	case Shared:
+		return want == Shared || want == IntentShared
+	case IntentExclusive:
+		return want == IntentExclusive || want == IntentShared
+	default:
+		return held == want
+	}
+}
+
+// join は held を保持しているトランザクションが want を要求したときに、
+// 両方を満たす最も弱いロックを返します（昇格後のロック）。
+func join(held, want Mode) Mode {
+	switch {
+	case covers(held, want):
+		return held
+	case covers(want, held):
+		return want
+	case held == Exclusive || want == Exclusive:
+		return Exclusive
+	default: // S と IX（またはその組み合わせ）
+		return SharedIntentExclusive
+	}
+}
+
+// Kind はロック対象の種類です。
+type Kind int
+
+const (
+	KindTable  Kind = iota + 1 // テーブル
+	KindRow                    // 行
+	KindWriter                 // データベースへの書き込み権（同時に1トランザクションだけが持つ）
+)
+
+// Resource はロックの対象です。比較可能なのでマップのキーに使えます。
+type Resource struct {
+	Kind  Kind
+	Table string      // 対象のテーブル（行の場合は行が属するテーブル）
+	RID   storage.RID // 対象の行（KindRow のみ）
+}
+
+// Table はテーブル全体を表すロック対象を返します。
+func Table(name string) Resource { return Resource{Kind: KindTable, Table: name} }
+
+// Row はテーブル内の1行を表すロック対象を返します。
+func Row(table string, rid storage.RID) Resource {
+	return Resource{Kind: KindRow, Table: table, RID: rid}
+}
+
+// Writer はデータベースへの書き込み権を表すロック対象を返します。
+// 書き込むトランザクションはこれを排他ロックで取得するため、書き込みは常に1つずつ行われます。
+func Writer() Resource { return Resource{Kind: KindWriter} }
+
+// String はロック対象を表示用の文字列にします。
+func (r Resource) String() string {
+	switch r.Kind {
+	case KindRow:
+		return r.Table + r.RID.String()
+	case KindWriter:
+		return "<writer>"
+	default:
+		return r.Table
+	}
+}
+
+// request はロック要求です。
+type request struct {
+	tx      uint64
+	mode    Mode
+	granted bool
+	ready   chan struct{} // 付与されたときに close される
+}
+
+// queue は1つのロック対象に対する要求の列です。
+// 付与済みの要求と待機中の要求を到着順に保持します。
+type queue struct {
+	reqs []*request
+}
+
+// Manager はロックマネージャです。複数のゴルーチンから安全に使えます。
+type Manager struct {
+	mu    sync.Mutex
+	table map[Resource]*queue
+	held  map[uint64]map[Resource]Mode // トランザクションごとの保持ロック
+
+	// 累計（Stats）
+	waits     uint64
+	deadlocks uint64
+	timeouts  uint64
+
+	metrics metrics.Sink // 累計と待った時間を送る先（SetMetrics）
+}
+
+// NewManager は新しいロックマネージャを作成します。
+func NewManager() *Manager {
+	return &Manager{
+		table:   make(map[Resource]*queue),
+		held:    make(map[uint64]map[Resource]Mode),
+		metrics: metrics.Discard,
+	}
+}
+
+// SetMetrics はロックの待機を送る先を s にします。ロックを要求する前に呼び出してください。
+func (m *Manager) SetMetrics(s metrics.Sink) { m.metrics = s }
+
+// Lock はトランザクション tx のために r を mode でロックします。
+// 競合するロックが解放されるまでブロックします。
+// すでにロックを持っている対象により強いロックを要求すると、両方を満たすロックに昇格します
+// （例えば S を持っていて IX を要求すると SIX になります）。
+func (m *Manager) Lock(tx uint64, r Resource, mode Mode) error {
+	return m.LockWait(tx, r, mode, -1)
+}
+
+// LockWait は Lock と同じですが、ロックを待つ時間の上限を wait で指定します。
+// wait が負なら無期限に待ち、0 ならすぐに取得できない場合に待たずに（NOWAIT）
+// ErrLockNotAvailable を返します。時間切れの場合も ErrLockNotAvailable を返し、
+// 要求は取り消されます（すでに保持しているロックはそのまま残ります）。
+func (m *Manager) LockWait(tx uint64, r Resource, mode Mode, wait time.Duration) error {
+	return m.LockContext(context.Background(), tx, r, mode, wait)
+}
+
+// LockContext は LockWait と同じですが、ctx が取り消されるか期限を過ぎたら待つのをやめ、
+// 要求を取り消して context.Cause(ctx) を返します。
+func (m *Manager) LockContext(ctx context.Context, tx uint64, r Resource, mode Mode, wait time.Duration) error {
+	if ctx.Err() != nil {
+		return context.Cause(ctx)
+	}
+	m.mu.Lock()
+	if cur, ok := m.held[tx][r]; ok {
+		if covers(cur, mode) {
+			m.mu.Unlock()
+			return nil
+		}
+		mode = join(cur, mode)
+	}
+	q := m.table[r]
+	if q == nil {
+		q = &queue{}
+		m.table[r] = q
+	}
+	req := m.enqueue(q, tx, mode)
+	m.grant(r, q)
+	if !req.granted && m.cycleFrom(tx) {
+		// 待つと循環待ちになる。要求したトランザクションを犠牲にする
+		m.dequeue(r, q, req)
+		m.deadlocks++
+		m.mu.Unlock()
+		m.metrics.Add(metrics.Deadlocks, 1)
+		return ErrDeadlock
+	}
+	if !req.granted && wait == 0 {
+		m.dequeue(r, q, req)
+		m.timeouts++
+		m.mu.Unlock()
+		m.metrics.Add(metrics.LockTimeouts, 1)
+		return ErrLockNotAvailable
+	}
+	if req.granted {
+		m.mu.Unlock()
+		return nil
+	}
+	m.waits++
+	m.mu.Unlock()
+	m.metrics.Add(metrics.LockWaits, 1)
+	start := time.Now()
+	defer func() { m.metrics.Observe(metrics.LockWaitSeconds, time.Since(start).Seconds()) }()
+
+	var timeout <-chan time.Time // wait が負なら nil のままで、時間切れにならない
+	if wait >= 0 {
+		t := time.NewTimer(wait)
+		defer t.Stop()
+		timeout = t.C
+	}
+	err := ErrLockNotAvailable
+	select {
+	case <-req.ready:
+		return nil
+	case <-timeout:
+	case <-ctx.Done():
+		err = context.Cause(ctx)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if req.granted { // 時間切れや取り消しと同時に付与された
+		return nil
+	}
+	m.dequeue(r, q, req)
+	if err == ErrLockNotAvailable {
+		m.timeouts++
+		m.metrics.Add(metrics.LockTimeouts, 1)
+	}
+	return err
+}
+
+// dequeue は待機中の要求をキューから取り除きます。
+func (m *Manager) dequeue(r Resource, q *queue, req *request) {
+	i := slices.Index(q.reqs, req)
+	q.reqs = slices.Delete(q.reqs, i, i+1)
+	if len(q.reqs) == 0 {
+		delete(m.table, r)
+		return
+	}
+	m.grant(r, q)
+}
+
+// waitsFor は待機中の各トランザクションが、どのトランザクションを待っているかを表す
+// グラフ（waits-for グラフ）を作ります。待機中の要求は、競合するロックを保持している
+// トランザクションと、キューで自分より前に並んでいる競合する要求のトランザクションを待ちます。
+func (m *Manager) waitsFor() map[uint64][]uint64 {
+	g := make(map[uint64][]uint64)
+	for _, q := range m.table {
+		for i, req := range q.reqs {
+			if req.granted {
+				continue
+			}
+			for j, other := range q.reqs {
+				if other.tx == req.tx || (!other.granted && j > i) {
+					continue
+				}
+				if !compatible(other.mode, req.mode) || !compatible(req.mode, other.mode) {
+					g[req.tx] = append(g[req.tx], other.tx)
+				}
+			}
+		}
+	}
+	return g
+}
+
+// cycleFrom は waits-for グラフに tx から tx へ戻る循環があるかを調べます。
+func (m *Manager) cycleFrom(tx uint64) bool {
+	g := m.waitsFor()
+	seen := make(map[uint64]bool)
+	var visit func(uint64) bool
+	visit = func(t uint64) bool {
+		for _, next := range g[t] {
+			if next == tx {
+				return true
+			}
+			if !seen[next] {
+				seen[next] = true
+				if visit(next) {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	return visit(tx)
+}
+
+// enqueue は要求をキューに追加します。昇格の場合は、元のロックを付与済みのまま残し、
+// 待機中の他の要求より先に付与されるよう、付与済みの要求の直後に入れます。
+func (m *Manager) enqueue(q *queue, tx uint64, mode Mode) *request {
+	req := &request{tx: tx, mode: mode, ready: make(chan struct{})}
+	if _, upgrade := m.held[tx]; upgrade && slices.ContainsFunc(q.reqs, func(o *request) bool { return o.tx == tx }) {
+		pos := 0
+		for pos < len(q.reqs) && q.reqs[pos].granted {
+			pos++
+		}
+		q.reqs = slices.Insert(q.reqs, pos, req)
+		return req
+	}
+	q.reqs = append(q.reqs, req)
+	return req
+}
+
+// grant は先頭から順に、付与できる要求にロックを付与します。
+// 公平性のため、付与できない要求があればそれより後ろの要求は待たせます。
+func (m *Manager) grant(r Resource, q *queue) {
+	// 昇格で要求を取り除くと q.reqs が詰まるので、添字で今の q.reqs をたどる
+	for i := 0; i < len(q.reqs); i++ {
+		req := q.reqs[i]
+		if req.granted {
+			continue
+		}
+		ok := true
+		for j, other := range q.reqs {
+			if j == i || !other.granted || other.tx == req.tx {
+				continue
+			}
+			if !compatible(other.mode, req.mode) {
+				ok = false
+				break
+			}
+		}
+		if !ok {
+			return
+		}
+		req.granted = true
+		// 昇格した場合は元のロックの要求を取り除く
+		q.reqs = slices.DeleteFunc(q.reqs, func(o *request) bool { return o.tx == req.tx && o != req })
+		i = slices.Index(q.reqs, req)
+		if m.held[req.tx] == nil {
+			m.held[req.tx] = make(map[Resource]Mode)
+		}
+		m.held[req.tx][r] = req.mode
+		close(req.ready)
+	}
+}
+
+// ReleaseAll はトランザクション tx が保持するすべてのロックを解放します。
+// 二相ロックの縮退フェーズにあたり、コミットまたはロールバック時に呼び出します。
+func (m *Manager) ReleaseAll(tx uint64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for r := range m.held[tx] {
+		q := m.table[r]
+		if q == nil {
+			continue
+		}
+		reqs := q.reqs[:0]
+		for _, req := range q.reqs {
+			if req.tx != tx {
+				reqs = append(reqs, req)
+			}
+		}
+		q.reqs = reqs
+		if len(q.reqs) == 0 {
+			delete(m.table, r)
+			continue
+		}
+		m.grant(r, q)
+	}
+	delete(m.held, tx)
+}
+
+// Held はトランザクション tx が保持しているロックの一覧を返します。
+func (m *Manager) Held(tx uint64) map[Resource]Mode {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	out := make(map[Resource]Mode, len(m.held[tx]))
+	for r, mode := range m.held[tx] {
+		out[r] = mode
+	}
+	return out
+}
+
+// Stats はロックマネージャの状態と、作成してからの累計です。
+type Stats struct {
+	Resources int    // ロックされているか、ロックを待たれている対象の数
+	Granted   int    // 付与されているロック
+	Waiting   int    // 待っている要求
+	Waits     uint64 // すぐに付与されずに待った要求の累計
+	Deadlocks uint64 // デッドロックで拒否した要求の累計
+	Timeouts  uint64 // NOWAIT や時間切れで拒否した要求の累計
+}
+
+// Stats はロックマネージャの状態を返します。
+func (m *Manager) Stats() Stats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	st := Stats{Resources: len(m.table), Waits: m.waits, Deadlocks: m.deadlocks, Timeouts: m.timeouts}
+	for _, q := range m.table {
+		for _, req := range q.reqs {
+			if req.granted {
+				st.Granted++
+			} else {
+				st.Waiting++
+			}
+		}
+	}
+	return st
+}
+
+// Wait はトランザクションが待っているロック要求です。
+type Wait struct {
+	Resource  Resource
+	Mode      Mode
+	BlockedBy []uint64 // 待っている相手のトランザクション
+}
+
+// Waiting はトランザクション tx がロックを待っていれば、その要求と待っている相手を返します。
+func (m *Manager) Waiting(tx uint64) (Wait, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for r, q := range m.table {
+		for _, req := range q.reqs {
+			if req.tx == tx && !req.granted {
+				by := m.waitsFor()[tx]
+				slices.Sort(by)
+				return Wait{Resource: r, Mode: req.mode, BlockedBy: slices.Compact(by)}, true
+			}
+		}
+	}
+	return Wait{}, false
+}
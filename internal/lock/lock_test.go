@@ -0,0 +1,321 @@
+package lock
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/k-sml/go-rdbms/internal/storage"
+)
+
+// outcome はロック要求の結果です。
+type outcome int
+
+const (
+	granted     outcome = iota // すぐに付与される
+	waits                      // 待たされる
+	deadlock                   // ErrDeadlock で拒否される
+	unavailable                // NOWAIT で ErrLockNotAvailable を返す
+)
+
+// step はシナリオの1つの操作です。r が空なら tx のロックをすべて解放し、wake のトランザクションが
+// 待っていたロックを得ることを確かめます。
+type step struct {
+	tx   uint64
+	r    string
+	mode Mode
+	want outcome
+	wake []uint64
+}
+
+// run は steps を順に実行します。待たされた要求は別のゴルーチンで待ち続けます。
+func run(t *testing.T, steps []step) *Manager {
+	t.Helper()
+	m := NewManager()
+	pending := map[uint64]chan error{}
+	for i, s := range steps {
+		if s.r == "" {
+			m.ReleaseAll(s.tx)
+			for _, tx := range s.wake {
+				if err := result(t, pending[tx]); err != nil {
+					t.Fatalf("step %d: tx %d woke up with %v", i, tx, err)
+				}
+				delete(pending, tx)
+			}
+			continue
+		}
+		if s.want == unavailable {
+			if err := m.LockWait(s.tx, Table(s.r), s.mode, 0); !errors.Is(err, ErrLockNotAvailable) {
+				t.Fatalf("step %d: NOWAIT %s lock on %s by tx %d = %v, want ErrLockNotAvailable", i, s.mode, s.r, s.tx, err)
+			}
+			continue
+		}
+		done := lockAsync(m, s.tx, Table(s.r), s.mode)
+		switch s.want {
+		case granted:
+			if err := result(t, done); err != nil {
+				t.Fatalf("step %d: %s lock on %s by tx %d = %v, want granted", i, s.mode, s.r, s.tx, err)
+			}
+		case deadlock:
+			if err := result(t, done); !errors.Is(err, ErrDeadlock) {
+				t.Fatalf("step %d: %s lock on %s by tx %d = %v, want ErrDeadlock", i, s.mode, s.r, s.tx, err)
+			}
+		case waits:
+			waitFor(t, m, s.tx)
+			pending[s.tx] = done
+		}
+	}
+	for tx, done := range pending {
+		select {
+		case err := <-done:
+			t.Errorf("tx %d got its lock (%v) without a release", tx, err)
+		default:
+		}
+	}
+	return m
+}
+
+// lockAsync は別のゴルーチンで Lock を呼び、その結果を送るチャネルを返します。
+func lockAsync(m *Manager, tx uint64, r Resource, mode Mode) chan error {
+	done := make(chan error, 1)
+	go func() { done <- m.Lock(tx, r, mode) }()
+	return done
+}
+
+// result は done から Lock の結果を受け取ります。
+func result(t *testing.T, done chan error) error {
+	t.Helper()
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(5 * time.Second):
+		t.Fatal("lock request did not finish")
+		return nil
+	}
+}
+
+// waitFor は tx の要求がキューで待つようになるまで待ちます。
+func waitFor(t *testing.T, m *Manager, tx uint64) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		m.mu.Lock()
+		for _, q := range m.table {
+			for _, req := range q.reqs {
+				if req.tx == tx && !req.granted {
+					m.mu.Unlock()
+					return
+				}
+			}
+		}
+		m.mu.Unlock()
+	}
+	t.Fatalf("tx %d is not waiting for a lock", tx)
+}
+
+// TestCompatible はロックの両立表を確かめます。
+func TestCompatible(t *testing.T) {
+	modes := []Mode{IntentShared, IntentExclusive, Shared, SharedIntentExclusive, Exclusive}
+	// want[i][j] は modes[i] を保持しているときに modes[j] を取得できるか
+	want := [][]bool{
+		{true, true, true, true, false},
+		{true, true, false, false, false},
+		{true, false, true, false, false},
+		{true, false, false, false, false},
+		{false, false, false, false, false},
+	}
+	for i, held := range modes {
+		for j, mode := range modes {
+			if got := compatible(held, mode); got != want[i][j] {
+				t.Errorf("compatible(%s, %s) = %v, want %v", held, mode, got, want[i][j])
+			}
+		}
+	}
+}
+
+// TestLock は、競合するロックが解放されるまで待ち、両立するロックは待たずに付与されることを確かめます。
+func TestLock(t *testing.T) {
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{"shared readers", []step{
+			{tx: 1, r: "a", mode: Shared},
+			{tx: 2, r: "a", mode: Shared},
+		}},
+		{"writer waits for reader", []step{
+			{tx: 1, r: "a", mode: Shared},
+			{tx: 2, r: "a", mode: Exclusive, want: waits},
+			{tx: 1, wake: []uint64{2}},
+		}},
+		{"reader waits for writer", []step{
+			{tx: 1, r: "a", mode: Exclusive},
+			{tx: 2, r: "a", mode: Shared, want: waits},
+			{tx: 3, r: "b", mode: Exclusive},
+			{tx: 1, wake: []uint64{2}},
+		}},
+		{"reader queues behind waiting writer", []step{
+			{tx: 1, r: "a", mode: Shared},
+			{tx: 2, r: "a", mode: Exclusive, want: waits},
+			{tx: 3, r: "a", mode: Shared, want: waits},
+			{tx: 1, wake: []uint64{2}},
+			{tx: 2, wake: []uint64{3}},
+		}},
+		{"intent locks", []step{
+			{tx: 1, r: "a", mode: IntentExclusive},
+			{tx: 2, r: "a", mode: IntentShared},
+			{tx: 3, r: "a", mode: IntentExclusive},
+			{tx: 4, r: "a", mode: Shared, want: waits},
+			{tx: 1},
+			{tx: 3, wake: []uint64{4}},
+		}},
+		{"nowait", []step{
+			{tx: 1, r: "a", mode: Exclusive},
+			{tx: 2, r: "a", mode: Shared, want: unavailable},
+			{tx: 2, r: "a", mode: Shared, want: waits},
+			{tx: 1, wake: []uint64{2}},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) { run(t, tt.steps) })
+	}
+}
+
+// TestUpgrade は、保持しているロックより強いロックを要求すると両方を満たすロックに昇格し、
+// 昇格の要求が先に待っている要求より先に付与されることを確かめます。
+func TestUpgrade(t *testing.T) {
+	tests := []struct {
+		name  string
+		steps []step
+		held  Mode // 最後に tx 1 が a に持つロック
+	}{
+		{"covered", []step{
+			{tx: 1, r: "a", mode: Exclusive},
+			{tx: 1, r: "a", mode: Shared},
+		}, Exclusive},
+		{"shared to exclusive", []step{
+			{tx: 1, r: "a", mode: Shared},
+			{tx: 1, r: "a", mode: Exclusive},
+		}, Exclusive},
+		{"waits for other readers", []step{
+			{tx: 1, r: "a", mode: Shared},
+			{tx: 2, r: "a", mode: Shared},
+			{tx: 1, r: "a", mode: Exclusive, want: waits},
+			{tx: 2, wake: []uint64{1}},
+		}, Exclusive},
+		{"goes before waiting writer", []step{
+			{tx: 1, r: "a", mode: Shared},
+			{tx: 2, r: "a", mode: Shared},
+			{tx: 3, r: "a", mode: Exclusive, want: waits},
+			{tx: 1, r: "a", mode: Exclusive, want: waits},
+			{tx: 2, wake: []uint64{1}},
+		}, Exclusive},
+		{"shared and intent exclusive", []step{
+			{tx: 1, r: "a", mode: Shared},
+			{tx: 1, r: "a", mode: IntentExclusive},
+			{tx: 2, r: "a", mode: IntentShared},
+			{tx: 3, r: "a", mode: IntentExclusive, want: waits},
+		}, SharedIntentExclusive},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := run(t, tt.steps)
+			if got := m.Held(1)[Table("a")]; got != tt.held {
+				t.Errorf("tx 1 holds %s on a, want %s", got, tt.held)
+			}
+		})
+	}
+}
+
+// TestDeadlock は、待つと循環待ちになる要求を ErrDeadlock で拒否し、拒否されたトランザクションが
+// 保持しているロックを解放すれば、相手が待っていたロックを得ることを確かめます。
+func TestDeadlock(t *testing.T) {
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{"two tables", []step{
+			{tx: 1, r: "a", mode: Exclusive},
+			{tx: 2, r: "b", mode: Exclusive},
+			{tx: 1, r: "b", mode: Exclusive, want: waits},
+			{tx: 2, r: "a", mode: Exclusive, want: deadlock},
+			{tx: 2, wake: []uint64{1}},
+		}},
+		{"upgrade", []step{
+			{tx: 1, r: "a", mode: Shared},
+			{tx: 2, r: "a", mode: Shared},
+			{tx: 1, r: "a", mode: Exclusive, want: waits},
+			{tx: 2, r: "a", mode: Exclusive, want: deadlock},
+			{tx: 2, wake: []uint64{1}},
+		}},
+		{"three transactions", []step{
+			{tx: 1, r: "a", mode: Exclusive},
+			{tx: 2, r: "b", mode: Exclusive},
+			{tx: 3, r: "c", mode: Exclusive},
+			{tx: 1, r: "b", mode: Shared, want: waits},
+			{tx: 2, r: "c", mode: Shared, want: waits},
+			{tx: 3, r: "a", mode: Shared, want: deadlock},
+			{tx: 3, wake: []uint64{2}},
+			{tx: 2, wake: []uint64{1}},
+		}},
+		{"behind a waiting request", []step{
+			{tx: 3, r: "b", mode: Exclusive},
+			{tx: 1, r: "a", mode: Shared},
+			{tx: 2, r: "a", mode: Exclusive, want: waits},
+			{tx: 3, r: "a", mode: Shared, want: waits}, // tx 1 とは両立するが、tx 2 の後ろで待つ
+			{tx: 1, r: "b", mode: Shared, want: deadlock},
+			{tx: 1, wake: []uint64{2}},
+			{tx: 2, wake: []uint64{3}},
+		}},
+		{"chain without cycle", []step{
+			{tx: 1, r: "a", mode: Exclusive},
+			{tx: 2, r: "a", mode: Exclusive, want: waits},
+			{tx: 3, r: "b", mode: Exclusive},
+			{tx: 1, r: "b", mode: Exclusive, want: waits},
+			{tx: 3, wake: []uint64{1}},
+			{tx: 1, wake: []uint64{2}},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) { run(t, tt.steps) })
+	}
+}
+
+// TestLockWait は、時間切れや取り消しで待つのをやめた要求がキューから取り除かれ、
+// すでに保持しているロックは残ることを確かめます。
+func TestLockWait(t *testing.T) {
+	r := Row("t", storage.RID{PageID: 3, Slot: 1})
+	cancelled, cancel := context.WithCancelCause(context.Background())
+	errStop := errors.New("stop")
+	cancel(errStop)
+	tests := []struct {
+		name string
+		lock func(m *Manager) error
+		want error
+	}{
+		{"nowait", func(m *Manager) error { return m.LockWait(2, r, Exclusive, 0) }, ErrLockNotAvailable},
+		{"timeout", func(m *Manager) error { return m.LockWait(2, r, Exclusive, 10*time.Millisecond) }, ErrLockNotAvailable},
+		{"cancelled", func(m *Manager) error { return m.LockContext(cancelled, 2, r, Exclusive, -1) }, errStop},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := NewManager()
+			for _, tx := range []uint64{1, 2} {
+				if err := m.Lock(tx, r, Shared); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := tt.lock(m); !errors.Is(err, tt.want) {
+				t.Fatalf("err = %v, want %v", err, tt.want)
+			}
+			if got := m.Held(2)[r]; got != Shared {
+				t.Errorf("tx 2 holds %s after giving up, want S", got)
+			}
+			// 取り消した要求は残っていないので、tx 1 はすぐに昇格できる
+			m.ReleaseAll(2)
+			if err := m.LockWait(1, r, Exclusive, 0); err != nil {
+				t.Errorf("upgrade after the request was withdrawn: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,66 @@
+package pager
+
+import "container/list"
+
+// pageCache はデータベースファイルから読んだページを、最近使った順に決まった数まで保持します。
+// 中身はいつもファイルの内容（JournalShadow では論理ページの内容）と同じで、ファイルに
+// 書き込むたびに更新します。nil のページキャッシュは何も保持しません。
+type pageCache struct {
+	max   int
+	pages map[int64]*list.Element // 値は *cachedPage
+	lru   list.List               // 先頭が最近使ったページ
+}
+
+type cachedPage struct {
+	id  int64
+	buf []byte
+}
+
+// newPageCache は max ページまで保持するページキャッシュを作成します。max が 0 以下なら nil を返します。
+func newPageCache(max int) *pageCache {
+	if max <= 0 {
+		return nil
+	}
+	return &pageCache{max: max, pages: make(map[int64]*list.Element)}
+}
+
+// get はページ id の内容の複製を返します。
+func (c *pageCache) get(id int64) ([]byte, bool) {
+	if c == nil {
+		return nil, false
+	}
+	e, ok := c.pages[id]
+	if !ok {
+		return nil, false
+	}
+	c.lru.MoveToFront(e)
+	return append([]byte(nil), e.Value.(*cachedPage).buf...), true
+}
+
+// put はページ id の内容として buf の複製を保持します。あふれたら最も長く使っていないページを捨てます。
+func (c *pageCache) put(id int64, buf []byte) {
+	if c == nil {
+		return
+	}
+	if e, ok := c.pages[id]; ok {
+		pg := e.Value.(*cachedPage)
+		pg.buf = append(pg.buf[:0], buf...)
+		c.lru.MoveToFront(e)
+		return
+	}
+	if c.lru.Len() >= c.max {
+		e := c.lru.Back()
+		delete(c.pages, e.Value.(*cachedPage).id)
+		c.lru.Remove(e)
+	}
+	c.pages[id] = c.lru.PushFront(&cachedPage{id: id, buf: append([]byte(nil), buf...)})
+}
+
+// clear はすべてのページを捨てます。
+func (c *pageCache) clear() {
+	if c == nil {
+		return
+	}
+	clear(c.pages)
+	c.lru.Init()
+}
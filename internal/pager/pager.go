@@ -4,41 +4,294 @@
 package pager
 
 import (
+	"cmp"
+	"errors"
 	"fmt"
 	"io"
+	"log/slog"
+	"math"
 	"os"
+	"slices"
 	"sync"
+	"time"
+
+	"github.com/k-sml/go-rdbms/internal/dberr"
+	"github.com/k-sml/go-rdbms/internal/vfs"
+	"github.com/k-sml/go-rdbms/internal/wal"
+	"github.com/k-sml/go-rdbms/metrics"
+)
+
+// ErrReadOnly は読み取り専用で開いたページャーに書き込もうとした場合に返されます。
+var ErrReadOnly = errors.New("database is read-only")
+
+// JournalMode はコミットをどのように永続化するかを表します。
+type JournalMode int
+
+const (
+	// JournalNone は WritePage の内容をそのままファイルに書き込みます（従来の動作）。
+	JournalNone JournalMode = iota
+	// JournalWAL は WritePage の内容を保留し、Flush 時にWALへ記録してから
+	// データベースファイルに反映します。
+	JournalWAL
+	// JournalShadow は別ファイルのログを使わず、変更したページを新しい位置に書いて
+	// ファイル先頭のルートポインタを切り替えることでコミットします（シャドウページング）。
+	// データベースファイルの形式が変わるため、作成時に選んだモードで開き続ける必要があります。
+	JournalShadow
 )
 
+// SyncMode はコミットのたびにファイルをどこまでディスクに同期するかを表します。
+type SyncMode int
+
+const (
+	// SyncFull はコミットのたびにWALとデータベースファイルを同期します（既定）。
+	SyncFull SyncMode = iota
+	// SyncNormal は JournalWAL でWALだけを同期し、データベースファイルの同期をチェックポイントまで
+	// 遅らせます。落ちてもWALから復旧できるので、コミットは失われません。JournalWAL 以外では
+	// SyncFull と同じです。
+	SyncNormal
+	// SyncOff は同期せずにOSに任せます。プロセスが落ちるだけなら失われませんが、OSが落ちたり
+	// 電源が切れたりすると、直前のコミットが失われたりファイルが壊れたりすることがあります。
+	SyncOff
+)
+
+// Options はページャーを開く際の設定です。
+type Options struct {
+	PageSize int         // 各ページのサイズ（バイト）
+	Journal  JournalMode // コミットの永続化方式
+	ReadOnly bool        // 書き込みを拒否する
+	// Replica はデータベースを継続リカバリモードで開きます。
+	// WALに追記されていくコミットを CatchUp / Follow で取り込み続け、
+	// 利用者からの書き込みは ErrReadOnly で拒否します。
+	Replica bool
+	// FS はファイルを開くためのファイルシステムです。nil の場合は vfs.OS を使います。
+	// クラッシュ試験では電源断を模擬する vfs.MemFS を渡します。
+	FS vfs.FS
+	// Logger はWALからの復旧やチェックポイントを記録するロガーです。nil の場合は何も記録しません。
+	Logger *slog.Logger
+	// Metrics はページの入出力を送る先です。nil の場合は送りません。
+	Metrics metrics.Sink
+	// CacheSize はデータベースファイルから読んだページをメモリに置いておく数です。
+	// 0 の場合はキャッシュせず、毎回ファイルから読みます。
+	CacheSize int
+	// Sync はコミットでファイルを同期する範囲です。
+	Sync SyncMode
+}
+
+// discardLogger は Logger を指定しなかった場合に使う、何も出力しないロガーです。
+var discardLogger = slog.New(slog.NewTextHandler(io.Discard, &slog.HandlerOptions{Level: slog.Level(math.MaxInt)}))
+
 // Pager はページベースのファイルI/O操作を管理します。
 // 固定サイズのページに分割されたファイルへのスレッドセーフなアクセスを提供します。
 type Pager struct {
-	f        *os.File   // 基となるファイルハンドル
+	f        vfs.File   // 基となるファイルハンドル
 	pageSize int        // 各ページのサイズ（バイト）
 	mu       sync.Mutex // スレッドセーフ操作のためのミューテックス
+
+	journal  JournalMode
+	readOnly bool
+	fs       vfs.FS
+	log      *wal.Log         // JournalWAL のときのWAL
+	pending  map[int64][]byte // Flush 待ちのページ（JournalWAL / JournalShadow）
+	batchID  uint64           // 次のコミットに割り当てるバッチID
+
+	// WALに記録する内容の選択（JournalWAL のみ）
+	logical map[int64][]*wal.Record // 論理レコードだけで書き込まれた保留ページの操作列
+	imaged  map[int64]bool          // 現在のWALにページイメージが記録済みのページ
+
+	replica *replica     // Replica モードの適用状態
+	shadow  *shadowState // JournalShadow の状態
+
+	ships     map[int]func([]*wal.Record)                // コミットしたレコードを渡す関数（ship.go）
+	pageShips map[int]func(uint64, uint64, []PageChange) // コミットしたページの前後の内容を渡す関数
+	shipID    int                                        // 次に登録する関数の番号
+
+	logger  *slog.Logger
+	metrics metrics.Sink
+	cache   *pageCache // ファイルから読んだページ（cache.go）
+	sync    SyncMode
+
+	stats Stats // 開いてからの入出力の回数（Stats）
 }
 
 // Open は指定されたファイルパスの新しいPagerインスタンスを作成します。
 // pageSizeは正の値で、512バイトの倍数である必要があります。
 // ファイルが開けない場合やpageSizeが無効な場合はエラーを返します。
 func Open(path string, pageSize int) (*Pager, error) {
-	if pageSize <= 0 || pageSize%512 != 0 {
-		return nil, fmt.Errorf("invalid page size: %d", pageSize)
+	return OpenWithOptions(path, Options{PageSize: pageSize})
+}
+
+// OpenWithOptions は設定を指定してページャーを開きます。
+// JournalWAL の場合は、前回の異常終了で反映されなかったコミットを
+// WALからデータベースファイルに書き戻してから返ります。それ以外のモードで書き込み用に
+// 開くときに、チェックポイントしていないWALが残っていれば ErrJournalMismatch を返します。
+func OpenWithOptions(path string, opts Options) (*Pager, error) {
+	if opts.PageSize <= 0 || opts.PageSize%512 != 0 {
+		return nil, fmt.Errorf("invalid page size: %d", opts.PageSize)
 	}
 
-	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0666)
+	flag := os.O_RDWR | os.O_CREATE
+	if opts.ReadOnly {
+		flag = os.O_RDONLY
+	}
+	fsys := opts.FS
+	if fsys == nil {
+		fsys = vfs.OS
+	}
+	f, err := fsys.OpenFile(path, flag, 0666)
 	if err != nil {
 		return nil, err
 	}
 
-	return &Pager{
+	p := &Pager{
 		f:        f,
-		pageSize: pageSize,
-	}, nil
+		fs:       fsys,
+		pageSize: opts.PageSize,
+		journal:  opts.Journal,
+		readOnly: opts.ReadOnly || opts.Replica,
+		logger:   cmp.Or(opts.Logger, discardLogger),
+		metrics:  cmp.Or(opts.Metrics, metrics.Discard),
+		cache:    newPageCache(opts.CacheSize),
+		sync:     opts.Sync,
+	}
+	isShadow, err := p.isShadowFile()
+	if err == nil && !isShadow && opts.Journal == JournalShadow {
+		// 初期化の途中で落ちたファイルは作り直せる
+		isShadow, err = p.isUnfinishedShadowInit()
+	}
+	if err == nil && opts.Journal != JournalWAL && !p.readOnly {
+		// WALを使わずに書いた変更は、次にWALで開いたときに残っていた古いページイメージで
+		// 上書きされてしまうので、チェックポイントしていないWALがあれば開かない
+		var pending bool
+		if pending, err = wal.HasCommitsFS(fsys, wal.Path(path)); err == nil && pending {
+			err = ErrJournalMismatch
+		}
+	}
+	switch {
+	case err != nil:
+	case isShadow != (opts.Journal == JournalShadow):
+		// 空のファイルだけは任意のモードで作成できる
+		if size, serr := f.Size(); serr != nil || size > 0 || isShadow {
+			err = ErrJournalMismatch
+		}
+	case opts.Journal == JournalShadow && opts.Replica:
+		err = errors.New("shadow paging does not support replica mode")
+	case opts.Journal == JournalShadow:
+		err = p.openShadow()
+	case opts.Replica:
+		err = p.openReplica(wal.Path(path))
+	case opts.Journal == JournalWAL && !opts.ReadOnly:
+		err = p.openWAL(wal.Path(path))
+	}
+	if p.shadow != nil {
+		p.pending = make(map[int64][]byte)
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return p, nil
+}
+
+// openWAL はWALを開き、コミット済みのページをデータベースファイルに書き戻します。
+// ページはコミット時にデータベースファイルへ書き込まれるため、ここで反映されるのは
+// WALの同期後、データベースファイルへの書き込み前に落ちた場合の差分だけですが、
+// ページイメージは冪等なので全件を書き戻して問題ありません。
+func (p *Pager) openWAL(path string) error {
+	p.imaged = make(map[int64]bool)
+	l, err := wal.OpenFS(p.fs, path, p.pageSize)
+	if err != nil {
+		return err
+	}
+	r, err := wal.OpenReaderFS(p.fs, path)
+	if err != nil {
+		l.Close()
+		return err
+	}
+	defer r.Close()
+	start := time.Now()
+	records, commits := 0, 0
+	for {
+		rec, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			l.Close()
+			return err
+		}
+		if wal.HasPage(rec.Type) {
+			if err := p.redo(rec); err != nil {
+				l.Close()
+				p.logger.Error("wal replay failed", "path", path, "lsn", rec.LSN, "page", rec.PageID, "err", err)
+				return err
+			}
+			p.imaged[rec.PageID] = true
+		}
+		if rec.Type == wal.RecCommit {
+			commits++
+		}
+		records++
+		p.batchID = rec.TxID
+	}
+	if err := p.f.Sync(); err != nil {
+		l.Close()
+		return err
+	}
+	if records > 0 {
+		p.logger.Info("wal replayed", "path", path, "records", records, "commits", commits,
+			"pages", len(p.imaged), "duration", time.Since(start))
+	}
+	if size, err := r.Size(); err == nil && size > r.Offset() {
+		// 最後のコミットを書いている途中で落ちた。コミットしていないので捨てる
+		p.logger.Warn("wal has an incomplete tail", "path", path, "offset", r.Offset(), "bytes", size-r.Offset())
+	}
+	p.log = l
+	p.pending = make(map[int64][]byte)
+	p.logical = make(map[int64][]*wal.Record)
+	p.batchID++
+	return nil
+}
+
+// redo はWALのレコードをデータベースファイル上のページに適用します。
+// 呼び出し側でミューテックスを保持している必要があります。
+func (p *Pager) redo(rec *wal.Record) error {
+	if rec.Type == wal.RecPageImage {
+		return p.writeAt(rec.PageID, rec.Payload)
+	}
+	buf, err := p.readAt(rec.PageID)
+	if err != nil {
+		return err
+	}
+	if err := wal.Apply(buf, rec); err != nil {
+		return fmt.Errorf("redo lsn %d: %w", rec.LSN, err)
+	}
+	return p.writeAt(rec.PageID, buf)
+}
+
+// readAt はデータベースファイルからページを読みます。ファイルの末尾より先は空のページを返します。
+func (p *Pager) readAt(pageID int64) ([]byte, error) {
+	buf := make([]byte, p.pageSize)
+	if _, err := p.f.ReadAt(buf, pageID*int64(p.pageSize)); err != nil && err != io.EOF {
+		return nil, err
+	}
+	return buf, nil
 }
 
 // Close は基となるファイルを閉じてリソースを解放します。
+// JournalWAL で Flush されていないページは破棄され、WALはチェックポイントして空にします。
 func (p *Pager) Close() error {
+	if p.replica != nil && p.replica.r != nil {
+		p.replica.r.Close()
+	}
+	if p.log != nil {
+		// ほかのジャーナルモードでも開けるように、WALを空にしてから閉じる
+		if err := p.Checkpoint(); err != nil {
+			p.log.Close()
+			p.f.Close()
+			return err
+		}
+		p.log.Close()
+	}
 	return p.f.Close()
 }
 
@@ -51,18 +304,40 @@ func (p *Pager) ReadPage(pageID int64) ([]byte, error) {
 	defer p.mu.Unlock()
 
 	if pageID < 0 {
-		return nil, fmt.Errorf("invalid page ID: %d", pageID)
+		return nil, dberr.Mark(fmt.Errorf("invalid page ID: %d", pageID), dberr.ErrPageNotFound)
+	}
+
+	p.stats.Reads++
+	if pg, ok := p.pending[pageID]; ok { // Flush 待ちのページがあればそれを返す
+		p.stats.PendingReads++
+		p.metrics.Add(metrics.PageCacheHits, 1)
+		return append([]byte(nil), pg...), nil
+	}
+	if buf, ok := p.cache.get(pageID); ok {
+		p.stats.CacheHits++
+		p.metrics.Add(metrics.PageCacheHits, 1)
+		return buf, nil
+	}
+	if p.shadow != nil { // シャドウページングでは論理ページを物理ページに読み替える
+		buf, err := p.shadowRead(pageID)
+		if err == nil {
+			p.cache.put(pageID, buf)
+		}
+		return buf, err
 	}
 
 	off := pageID * int64(p.pageSize) // オフセットは何文字目から読むか
 	buf := make([]byte, p.pageSize)   // ページサイズ分のバイトスライスを作成、このバッファにファイルから読み込んだデータを格納する
 
-	st, err := p.f.Stat() // ファイルサイズの確認
+	size, err := p.f.Size() // ファイルサイズの確認
 	if err != nil {
 		return nil, err
 	}
 	// 書き込みの際も最初にDBの様子を知るためにReadPageを呼び出す、その場合、これに引っかかることがある
-	if off >= st.Size() { // ファイルサイズよりオフセットが大きい場合、ファイルサイズを拡張する
+	if off >= size { // ファイルサイズよりオフセットが大きい場合、ファイルサイズを拡張する
+		if p.readOnly { // 読み取り専用では拡張せず空のページを返す
+			return buf, nil
+		}
 		if err := p.ensureSize(off + int64(p.pageSize)); err != nil {
 			return nil, err
 		}
@@ -72,6 +347,7 @@ func (p *Pager) ReadPage(pageID int64) ([]byte, error) {
 	if _, err := p.f.ReadAt(buf, off); err != nil && err != io.EOF { // ファイルからバッファに読み込み、EOFでない場合はエラーを返す
 		return nil, err
 	}
+	p.cache.put(pageID, buf)
 
 	return buf, nil
 }
@@ -83,10 +359,60 @@ func (p *Pager) WritePage(pageID int64, buf []byte) error {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
+	if p.readOnly {
+		return ErrReadOnly
+	}
 	if len(buf) != p.pageSize { // バッファサイズはページサイズと正確に一致する必要がある
 		return fmt.Errorf("invalid page size: %d", len(buf))
 	}
+	if pageID < 0 {
+		return dberr.Mark(fmt.Errorf("invalid page ID: %d", pageID), dberr.ErrPageNotFound)
+	}
 
+	p.stats.Writes++
+	if p.journal != JournalNone { // WAL・シャドウページングでは Flush まで保留する
+		p.pending[pageID] = append([]byte(nil), buf...)
+		delete(p.logical, pageID) // 論理レコードだけでは表せなくなった
+		return nil
+	}
+	return p.writeAt(pageID, buf)
+}
+
+// WritePageLogical は WritePage と同じくページを書き込みますが、JournalWAL では
+// ページのバイト列の代わりに変更内容を表す論理レコード op をWALに記録します。
+// buf は op を適用した後のページで、保留中のページ（なければ最後にコミットされたページ）に
+// op を wal.Apply すると buf と同じバイト列になるものでなければなりません。
+// 同じコミット内で WritePage でも書き込まれたページや、チェックポイント後に初めて
+// 記録するページは、物理レコード（ページイメージか差分）で記録されます。
+// JournalWAL 以外では WritePage と同じです。
+func (p *Pager) WritePageLogical(pageID int64, buf []byte, op *wal.Record) error {
+	if p.logical == nil {
+		return p.WritePage(pageID, buf)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(buf) != p.pageSize {
+		return fmt.Errorf("invalid page size: %d", len(buf))
+	}
+	if pageID < 0 {
+		return dberr.Mark(fmt.Errorf("invalid page ID: %d", pageID), dberr.ErrPageNotFound)
+	}
+	p.stats.Writes++
+	_, isPending := p.pending[pageID]
+	ops, isLogical := p.logical[pageID]
+	p.pending[pageID] = append([]byte(nil), buf...)
+	if isPending && !isLogical {
+		return nil // すでに物理レコードで記録することが決まっている
+	}
+	rec := &wal.Record{Type: op.Type, PageID: pageID, Payload: append([]byte(nil), op.Payload...)}
+	p.logical[pageID] = append(ops, rec)
+	return nil
+}
+
+// writeAt はページをデータベースファイルに直接書き込みます。
+// 呼び出し側でミューテックスを保持している必要があります。
+func (p *Pager) writeAt(pageID int64, buf []byte) error {
 	off := pageID * int64(p.pageSize) // 何文字目から書き込むか
 
 	if err := p.ensureSize(off + int64(p.pageSize)); err != nil {
@@ -96,6 +422,11 @@ func (p *Pager) WritePage(pageID int64, buf []byte) error {
 	if _, err := p.f.WriteAt(buf, off); err != nil {
 		return err
 	}
+	p.stats.FileWrites++
+	p.metrics.Add(metrics.PageWrites, 1)
+	if p.shadow == nil { // シャドウページングでは物理ページなので、コミットの最後に更新する
+		p.cache.put(pageID, buf)
+	}
 
 	return nil
 
@@ -103,13 +434,215 @@ func (p *Pager) WritePage(pageID int64, buf []byte) error {
 
 // Flush は保留中のすべての書き込みがディスクに書き込まれることを保証します。
 // 重要な操作の前にデータの永続性を確保するのに役立ちます。
+// JournalWAL では保留中のページをひとつのコミットとしてWALに記録し、
+// WALを同期してからデータベースファイルに書き込みます。
 func (p *Pager) Flush() error {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
+	return p.flush(p.batchID)
+}
+
+// Commit は Flush と同じですが、WALのレコードにトランザクションID txID を記録します。
+// トランザクションマネージャがコミット時に呼び出します。
+func (p *Pager) Commit(txID uint64) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.flush(txID)
+}
+
+func (p *Pager) flush(txID uint64) error {
+	if p.readOnly {
+		return nil
+	}
+	p.stats.Commits++
+	if len(p.pending) > 0 {
+		if p.shadow != nil {
+			return p.shadowCommit() // ヘッダの切り替えまでで同期は済んでいる
+		}
+		if err := p.commitPending(txID); err != nil {
+			return err
+		}
+	}
+	if p.sync == SyncOff || p.sync == SyncNormal && p.log != nil {
+		return nil // JournalWAL ではWALを同期済みなので、データベースファイルはチェックポイントで同期する
+	}
 	return p.f.Sync()
 }
 
+// Discard は Flush されていない保留中のページを破棄します。
+func (p *Pager) Discard() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	clear(p.pending)
+	clear(p.logical)
+}
+
+// commitPending は保留中のページをWALに記録し、データベースファイルに反映します。
+func (p *Pager) commitPending(txID uint64) error {
+	ids := make([]int64, 0, len(p.pending))
+	for id := range p.pending {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids) // ログの内容を決定的にするためページ順に並べる
+
+	start := p.log.Size()
+	var shipped []*wal.Record // 送出するレコード（ship.go）
+	var changes []PageChange  // 送出するページの前後の内容
+	for _, id := range ids {
+		recs, err := p.pageRecords(id)
+		if err != nil {
+			return err
+		}
+		if len(p.pageShips) > 0 {
+			old, err := p.readAt(id)
+			if err != nil {
+				return err
+			}
+			changes = append(changes, PageChange{ID: id, Old: old, New: p.pending[id]})
+		}
+		for _, rec := range recs {
+			rec.TxID = txID
+			if _, err := p.log.Append(rec); err != nil {
+				return err
+			}
+		}
+		if len(p.ships) > 0 {
+			shipped = append(shipped, recs...)
+		}
+	}
+	commit := &wal.Record{Type: wal.RecCommit, TxID: txID, PageID: -1}
+	if _, err := p.log.Append(commit); err != nil {
+		return err
+	}
+	if p.sync != SyncOff {
+		if err := p.log.Sync(); err != nil { // WALが先にディスクに載っていればここ以降で落ちても復旧できる
+			return err
+		}
+	}
+	if len(p.ships) > 0 {
+		p.ship(append(shipped, commit))
+	}
+	for _, fn := range p.pageShips {
+		fn(txID, commit.LSN, changes)
+	}
+	p.stats.WALBytes += uint64(p.log.Size() - start)
+	p.metrics.Add(metrics.WALBytes, float64(p.log.Size()-start))
+	p.batchID = max(p.batchID, txID) + 1
+
+	for _, id := range ids {
+		p.imaged[id] = true
+		if err := p.writeAt(id, p.pending[id]); err != nil {
+			return err
+		}
+	}
+	clear(p.pending)
+	clear(p.logical)
+	return nil
+}
+
+// pageRecords は保留中のページをWALに記録するためのレコードを選びます。
+//   - チェックポイント後に初めて記録するページ: ページイメージ
+//   - 論理レコードだけで書き込まれたページ: 論理レコード
+//   - それ以外: 最後にコミットされたページとの差分（イメージより小さい場合）
+func (p *Pager) pageRecords(id int64) ([]*wal.Record, error) {
+	buf := p.pending[id]
+	if !p.imaged[id] {
+		return []*wal.Record{{Type: wal.RecPageImage, PageID: id, Payload: buf}}, nil
+	}
+	if ops, ok := p.logical[id]; ok {
+		return ops, nil
+	}
+	old, err := p.readAt(id) // コミット済みのページはデータベースファイルにある
+	if err != nil {
+		return nil, err
+	}
+	if diff := wal.EncodeDiff(old, buf); len(diff) < len(buf) {
+		return []*wal.Record{{Type: wal.RecPageDiff, PageID: id, Payload: diff}}, nil
+	}
+	return []*wal.Record{{Type: wal.RecPageImage, PageID: id, Payload: buf}}, nil
+}
+
+// Checkpoint はデータベースファイルを同期し、WALを空にします。
+// コミット済みのページはすでにデータベースファイルに書き込まれているため、
+// WALを捨てても失われる変更はありません。レプリカがWALを読み終えてから呼び出してください。
+func (p *Pager) Checkpoint() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.log == nil {
+		return nil
+	}
+	start, size := time.Now(), p.log.Size()
+	if err := p.f.Sync(); err != nil {
+		return err
+	}
+	if err := p.log.Reset(); err != nil {
+		return err
+	}
+	pages := len(p.imaged)
+	clear(p.imaged) // 新しいWALでは各ページを再びイメージから記録する
+	p.logger.Info("wal checkpoint", "wal_bytes", size, "pages", pages, "duration", time.Since(start))
+	return nil
+}
+
+// Stats はページャーを開いてからの入出力の回数です。
+type Stats struct {
+	Reads        uint64 // ReadPage で読んだページ
+	PendingReads uint64 // そのうち、Flush 待ちのページから返したもの
+	CacheHits    uint64 // そのうち、ページキャッシュから返したもの（残りはファイルから読んだ）
+	Writes       uint64 // WritePage と WritePageLogical で書いたページ
+	FileWrites   uint64 // データベースファイルに書き込んだページ
+	Commits      uint64 // Flush と Commit の呼び出し
+	WALBytes     uint64 // WALに追記したバイト数
+}
+
+// Stats はページャーを開いてからの入出力の回数を返します。
+func (p *Pager) Stats() Stats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.stats
+}
+
+// Truncate はデータベースファイルを先頭の n ページの長さに切り詰めます。ファイルがそれより
+// 短ければ何もしません。JournalWAL では、切り詰めたページをリカバリでWALから書き戻さないように、
+// 先にチェックポイントと同じくWALを空にします。JournalShadow では論理ページと物理ページの
+// 位置が対応しないので何もしません。
+func (p *Pager) Truncate(n int64) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.readOnly {
+		return ErrReadOnly
+	}
+	if p.shadow != nil {
+		return nil
+	}
+	size, err := p.f.Size()
+	if err != nil || size <= n*int64(p.pageSize) {
+		return err
+	}
+	if p.log != nil {
+		if err := p.f.Sync(); err != nil {
+			return err
+		}
+		if err := p.log.Reset(); err != nil {
+			return err
+		}
+		clear(p.imaged)
+	}
+	if err := p.f.Truncate(n * int64(p.pageSize)); err != nil {
+		return err
+	}
+	p.cache.clear()
+	return p.f.Sync()
+}
+
+// ReadOnly はページャーが書き込みを拒否するかどうかを返します。
+func (p *Pager) ReadOnly() bool { return p.readOnly }
+
 // PageSize は各ページのサイズをバイトで返します。
 func (p *Pager) PageSize() int { return p.pageSize }
 
@@ -117,11 +650,11 @@ func (p *Pager) PageSize() int { return p.pageSize }
 // ファイルが短い場合、ゼロで拡張します。
 // これはReadPageとWritePageで使用される内部ヘルパーメソッドです。
 func (p *Pager) ensureSize(n int64) error {
-	st, err := p.f.Stat()
+	size, err := p.f.Size()
 	if err != nil {
 		return err
 	}
-	if st.Size() >= n {
+	if size >= n {
 		return nil
 	}
 	if err := p.f.Truncate(n); err != nil {
@@ -0,0 +1,115 @@
+package pager
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/k-sml/go-rdbms/internal/vfs"
+)
+
+const testPageSize = 512
+
+// page は先頭に s を書いたページを返します。
+func page(s string) []byte {
+	buf := make([]byte, testPageSize)
+	copy(buf, s)
+	return buf
+}
+
+// open は fsys の test.db を journal で開きます。
+func open(t *testing.T, fsys vfs.FS, journal JournalMode, readOnly bool) (*Pager, error) {
+	t.Helper()
+	return OpenWithOptions("test.db", Options{PageSize: testPageSize, Journal: journal, ReadOnly: readOnly, FS: fsys})
+}
+
+// write は p のページ 1 に s を書いてコミットします。
+func write(t *testing.T, p *Pager, s string) {
+	t.Helper()
+	if err := p.WritePage(1, page(s)); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Commit(1); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// check は p のページ 1 の先頭が s であることを確かめます。
+func check(t *testing.T, p *Pager, s string) {
+	t.Helper()
+	got, err := p.ReadPage(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, page(s)) {
+		t.Errorf("page 1 = %q, want %q", bytes.TrimRight(got, "\x00"), s)
+	}
+}
+
+// TestJournalSwitchAfterClose は JournalWAL で書いて閉じたファイルを JournalNone で書き換え、
+// もう一度 JournalWAL で開いても、古いWALで書き換えが消えないことを確かめます。
+func TestJournalSwitchAfterClose(t *testing.T) {
+	fsys := vfs.NewMemFS()
+	p, err := open(t, fsys, JournalWAL, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	write(t, p, "wal")
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	p, err = open(t, fsys, JournalNone, false)
+	if err != nil {
+		t.Fatalf("opening without WAL after a clean close: %v", err)
+	}
+	write(t, p, "none")
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	p, err = open(t, fsys, JournalWAL, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	check(t, p, "none")
+}
+
+// TestJournalMismatchWithPendingWAL は JournalWAL のまま閉じずに終わったファイルを、
+// ほかのモードで書き込み用に開けないことを確かめます。読み取り専用や JournalWAL では開けます。
+func TestJournalMismatchWithPendingWAL(t *testing.T) {
+	fsys := vfs.NewMemFS()
+	p, err := open(t, fsys, JournalWAL, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	write(t, p, "wal") // Close せずに終わる
+
+	for _, journal := range []JournalMode{JournalNone, JournalShadow} {
+		if _, err := open(t, fsys, journal, false); !errors.Is(err, ErrJournalMismatch) {
+			t.Errorf("opening with journal mode %d: err = %v, want ErrJournalMismatch", journal, err)
+		}
+	}
+
+	p, err = open(t, fsys, JournalNone, true)
+	if err != nil {
+		t.Fatalf("opening read-only: %v", err)
+	}
+	check(t, p, "wal")
+	p.Close()
+
+	p, err = open(t, fsys, JournalWAL, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(t, p, "wal")
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+	p, err = open(t, fsys, JournalNone, false)
+	if err != nil {
+		t.Fatalf("opening without WAL after recovery: %v", err)
+	}
+	p.Close()
+}
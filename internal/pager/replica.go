@@ -0,0 +1,196 @@
+package pager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/k-sml/go-rdbms/internal/wal"
+)
+
+// replica は継続リカバリモードでのWAL適用状態です。
+// プライマリ（唯一の書き込み側）がWALに追記していくコミットを順に読み取り、
+// 自分のデータベースファイルに書き戻していきます。
+type replica struct {
+	path    string        // 読み取るWALファイルのパス
+	r       *wal.Reader   // WALリーダ（WALがまだ無い場合は nil）
+	batch   []*wal.Record // COMMIT 待ちのページレコード
+	applied uint64        // 最後に適用したコミットレコードのLSN
+}
+
+// openReplica はレプリカの状態を初期化し、その時点でWALにあるコミットをすべて適用します。
+// プライマリのWALを共有している可能性があるため、WALファイルには一切書き込みません。
+func (p *Pager) openReplica(path string) error {
+	p.replica = &replica{path: path}
+	_, err := p.catchUp()
+	return err
+}
+
+// CatchUp は前回以降にWALへ追記されたコミットをデータベースファイルに適用し、
+// 適用したコミットの数を返します。Replica モード以外ではエラーを返します。
+func (p *Pager) CatchUp() (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.replica == nil {
+		return 0, errors.New("pager is not a replica")
+	}
+	return p.catchUp()
+}
+
+func (p *Pager) catchUp() (int, error) {
+	rp := p.replica
+	if rp.r == nil {
+		r, err := wal.OpenReaderFS(p.fs, rp.path)
+		if errors.Is(err, os.ErrNotExist) || errors.Is(err, wal.ErrCorrupt) {
+			return 0, nil // プライマリがまだWALを作っていない
+		}
+		if err != nil {
+			return 0, err
+		}
+		if r.PageSize() != p.pageSize {
+			r.Close()
+			return 0, errors.New("wal page size does not match replica")
+		}
+		rp.r = r
+	}
+
+	n := 0
+	for {
+		rec, err := rp.r.Next()
+		if err == io.EOF {
+			// 末尾まで読んだ。プライマリがチェックポイントでWALを空にしていれば先頭から読み直す
+			reset, err := rp.r.Reopen()
+			if err != nil {
+				return n, err
+			}
+			if !reset {
+				return n, nil
+			}
+			rp.batch = rp.batch[:0]
+			continue
+		}
+		if err != nil {
+			return n, err
+		}
+		switch {
+		case wal.HasPage(rec.Type):
+			rp.batch = append(rp.batch, rec)
+		case rec.Type == wal.RecCommit:
+			// コミット単位でまとめて適用する（途中のページだけが見えることはない）
+			for _, pr := range rp.batch {
+				if err := p.redo(pr); err != nil {
+					return n, err
+				}
+			}
+			rp.batch = rp.batch[:0]
+			rp.applied = rec.LSN
+			n++
+		}
+	}
+}
+
+// Follow は ctx がキャンセルされるまで、interval ごとに CatchUp を繰り返します。
+// 戻り値は ctx のエラーか、適用中に発生したエラーです。
+func (p *Pager) Follow(ctx context.Context, interval time.Duration) error {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		if _, err := p.CatchUp(); err != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+}
+
+// AppliedLSN はレプリカが最後に適用したコミットのLSNを返します。
+// Replica モード以外では 0 を返します。
+func (p *Pager) AppliedLSN() uint64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.replica == nil {
+		return 0
+	}
+	return p.replica.applied
+}
+
+// Apply はリーダーから受け取ったレコード recs をデータベースファイルに適用し、レプリカの位置
+// （AppliedLSN）を lsn にします。recs はコミット済みのひとまとまりで、ページを伴わないレコードは
+// 読み飛ばします。Replica モード以外ではエラーを返します。
+//
+// ファイルは同期しません（Sync）。プロセスが落ちても適用した内容は失われませんが、OSが落ちたり
+// 電源が切れたりした後の内容は保証しません。
+func (p *Pager) Apply(recs []*wal.Record, lsn uint64) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.replica == nil {
+		return errors.New("pager is not a replica")
+	}
+	for _, rec := range recs {
+		if !wal.HasPage(rec.Type) {
+			continue
+		}
+		if rec.PageID < 0 || rec.Type == wal.RecPageImage && len(rec.Payload) != p.pageSize {
+			return fmt.Errorf("invalid %s record for page %d", rec.Type, rec.PageID)
+		}
+		if err := p.redo(rec); err != nil {
+			return err
+		}
+	}
+	p.replica.applied = lsn
+	return nil
+}
+
+// Promote はレプリカを通常のデータベースに切り替え、書き込みを受け付けるようにします。
+// 以降は CatchUp も Apply も使えません。適用した内容はファイルを同期してから、自分のWALを
+// 空にして書き始めます（WALに残っている古いレコードを、次に開いたときに適用し直さないため）。
+// JournalWAL で開いたレプリカでなければエラーを返します。
+func (p *Pager) Promote() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	switch {
+	case p.replica == nil:
+		return errors.New("pager is not a replica")
+	case p.journal != JournalWAL:
+		return errors.New("promotion requires the wal journal")
+	}
+	if err := p.f.Sync(); err != nil {
+		return err
+	}
+	l, err := wal.OpenFS(p.fs, p.replica.path, p.pageSize)
+	if err != nil {
+		return err
+	}
+	if err := l.Reset(); err != nil {
+		l.Close()
+		return err
+	}
+	if p.replica.r != nil {
+		p.replica.r.Close()
+	}
+	p.replica = nil
+	p.log = l
+	p.pending = make(map[int64][]byte)
+	p.logical = make(map[int64][]*wal.Record)
+	p.imaged = make(map[int64]bool)
+	p.readOnly = false
+	p.logger.Info("replica promoted", "lsn", l.NextLSN())
+	return nil
+}
+
+// Sync はデータベースファイルをディスクに同期します。
+func (p *Pager) Sync() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.f.Sync()
+}
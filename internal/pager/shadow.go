@@ -0,0 +1,282 @@
+package pager
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+
+	"github.com/k-sml/go-rdbms/internal/dberr"
+)
+
+// シャドウページングの物理レイアウト
+//
+// 物理ページ0: シャドウヘッダ。2つのスロットを交互に使い、世代番号の大きい方が有効
+//   スロット（各 shadowSlotSize バイト）:
+//   [4B:magic "MSHD"][u64:generation][i64:tableRoot][i64:logicalPages][u32:crc]
+// ページテーブル: 論理ページ番号→物理ページ番号の対応をページの連鎖に格納する
+//   [i64:next][u32:count][count × i64:物理ページ番号（0 は未割り当て）]
+// その他の物理ページ: 論理ページの内容
+//
+// コミットでは変更されたページとページテーブルをすべて未使用の物理ページに書き、
+// 同期してからヘッダのスロットを切り替える。切り替えが終わるまで前の世代は
+// そのまま残っているので、どの時点で落ちても前の世代か新しい世代のどちらかが読める。
+
+const (
+	shadowSlotSize  = 256
+	shadowSlotBytes = 32 // crc を除いたスロットの内容
+	shadowTableHdr  = 12
+)
+
+var shadowMagic = [4]byte{'M', 'S', 'H', 'D'}
+
+// ErrJournalMismatch はデータベースファイルの形式と指定したジャーナルモードが合わない場合に返されます。
+var ErrJournalMismatch = errors.New("journal mode does not match database file")
+
+// shadowState はシャドウページングの状態です。
+type shadowState struct {
+	gen        uint64  // 有効なヘッダの世代番号
+	table      []int64 // 論理ページ番号→物理ページ番号
+	tablePages []int64 // 現在のページテーブルを格納している物理ページ
+	nPhys      int64   // ファイル中の物理ページ数
+}
+
+// isShadowFile はファイルがシャドウページング形式かどうかを、
+// いずれかのヘッダスロットにマジックナンバーがあるかで判定します。
+// 空のファイルは false を返します。
+func (p *Pager) isShadowFile() (bool, error) {
+	for i := 0; i < 2; i++ {
+		var m [4]byte
+		if _, err := p.f.ReadAt(m[:], int64(i)*shadowSlotSize); err != nil {
+			if err == io.EOF {
+				return false, nil
+			}
+			return false, err
+		}
+		if m == shadowMagic {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// isUnfinishedShadowInit は、ファイルがシャドウページングの初期化途中で
+// 途切れたもの（初期ヘッダの一部だけが書かれたもの）かどうかを判定します。
+func (p *Pager) isUnfinishedShadowInit() (bool, error) {
+	size, err := p.f.Size()
+	if err != nil || size > int64(p.pageSize) {
+		return false, err
+	}
+	buf := make([]byte, size)
+	if _, err := p.f.ReadAt(buf, 0); err != nil && err != io.EOF {
+		return false, err
+	}
+	// 初期ヘッダは世代1なのでスロット1に書かれる
+	init := shadowSlot(1, 0, 0)
+	for i, b := range buf {
+		j := i - shadowSlotSize
+		if b != 0 && (j < 0 || j >= len(init) || b != init[j]) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// openShadow はシャドウページングの状態を読み込みます。空のファイルは初期化します。
+func (p *Pager) openShadow() error {
+	size, err := p.f.Size()
+	if err != nil {
+		return err
+	}
+	st := &shadowState{}
+	p.shadow = st
+	unfinished, err := p.isUnfinishedShadowInit()
+	if err != nil {
+		return err
+	}
+	if size == 0 || unfinished {
+		if p.readOnly {
+			return nil
+		}
+		st.nPhys = 1
+		return p.writeShadowHeader(1, 0, 0)
+	}
+	st.nPhys = (size + int64(p.pageSize) - 1) / int64(p.pageSize)
+
+	hdr := make([]byte, p.pageSize)
+	if _, err := p.f.ReadAt(hdr, 0); err != nil && err != io.EOF {
+		return err
+	}
+	var root, logical int64
+	found := false
+	for i := 0; i < 2; i++ {
+		slot := hdr[i*shadowSlotSize : i*shadowSlotSize+shadowSlotBytes+4]
+		if [4]byte(slot[0:4]) != shadowMagic {
+			continue
+		}
+		if crc32.ChecksumIEEE(slot[:shadowSlotBytes]) != binary.LittleEndian.Uint32(slot[shadowSlotBytes:]) {
+			continue // 書き込み途中で途切れたスロット
+		}
+		gen := binary.LittleEndian.Uint64(slot[4:12])
+		if !found || gen > st.gen {
+			found = true
+			st.gen = gen
+			root = int64(binary.LittleEndian.Uint64(slot[12:20]))
+			logical = int64(binary.LittleEndian.Uint64(slot[20:28]))
+		}
+	}
+	if !found {
+		return dberr.Mark(errors.New("shadow header is corrupt"), dberr.ErrCorrupt)
+	}
+
+	// ページテーブルの連鎖を読む
+	buf := make([]byte, p.pageSize)
+	for phys := root; phys != 0 && int64(len(st.table)) < logical; {
+		if _, err := p.f.ReadAt(buf, phys*int64(p.pageSize)); err != nil && err != io.EOF {
+			return err
+		}
+		st.tablePages = append(st.tablePages, phys)
+		n := int(binary.LittleEndian.Uint32(buf[8:12]))
+		for i := 0; i < n; i++ {
+			off := shadowTableHdr + i*8
+			st.table = append(st.table, int64(binary.LittleEndian.Uint64(buf[off:off+8])))
+		}
+		phys = int64(binary.LittleEndian.Uint64(buf[0:8]))
+	}
+	if int64(len(st.table)) != logical {
+		return dberr.Mark(fmt.Errorf("shadow page table is truncated: %d of %d entries", len(st.table), logical), dberr.ErrCorrupt)
+	}
+	return nil
+}
+
+// writeShadowHeader は世代 gen のヘッダを対応するスロットに書き込み、同期します。
+func (p *Pager) writeShadowHeader(gen uint64, root, logical int64) error {
+	slot := shadowSlot(gen, root, logical)
+	if err := p.ensureSize(int64(p.pageSize)); err != nil {
+		return err
+	}
+	if _, err := p.f.WriteAt(slot, int64(gen%2)*shadowSlotSize); err != nil {
+		return err
+	}
+	if p.sync != SyncOff {
+		if err := p.f.Sync(); err != nil {
+			return err
+		}
+	}
+	p.shadow.gen = gen
+	return nil
+}
+
+// shadowSlot はヘッダスロットの内容を作ります。
+func shadowSlot(gen uint64, root, logical int64) []byte {
+	slot := make([]byte, shadowSlotBytes+4)
+	copy(slot[0:4], shadowMagic[:])
+	binary.LittleEndian.PutUint64(slot[4:12], gen)
+	binary.LittleEndian.PutUint64(slot[12:20], uint64(root))
+	binary.LittleEndian.PutUint64(slot[20:28], uint64(logical))
+	binary.LittleEndian.PutUint32(slot[shadowSlotBytes:], crc32.ChecksumIEEE(slot[:shadowSlotBytes]))
+	return slot
+}
+
+// shadowRead は論理ページを読みます。呼び出し側でミューテックスを保持している必要があります。
+func (p *Pager) shadowRead(pageID int64) ([]byte, error) {
+	buf := make([]byte, p.pageSize)
+	st := p.shadow
+	if pageID >= int64(len(st.table)) || st.table[pageID] == 0 {
+		return buf, nil // まだ書かれていないページは空
+	}
+	if _, err := p.f.ReadAt(buf, st.table[pageID]*int64(p.pageSize)); err != nil && err != io.EOF {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// shadowCommit は保留中のページを新しい物理ページに書き、ヘッダを切り替えてコミットします。
+func (p *Pager) shadowCommit() error {
+	st := p.shadow
+
+	// 現在の世代が使っている物理ページは上書きできない
+	used := make(map[int64]bool, len(st.table)+len(st.tablePages)+1)
+	used[0] = true
+	for _, phys := range st.table {
+		if phys != 0 {
+			used[phys] = true
+		}
+	}
+	for _, phys := range st.tablePages {
+		used[phys] = true
+	}
+	next := int64(1)
+	nPhys := st.nPhys
+	alloc := func() int64 {
+		for next < nPhys && used[next] {
+			next++
+		}
+		phys := next
+		if phys >= nPhys {
+			nPhys = phys + 1
+		}
+		used[phys] = true
+		next++
+		return phys
+	}
+
+	// 変更されたページを書く
+	table := append([]int64(nil), st.table...)
+	for id, buf := range p.pending {
+		for int64(len(table)) <= id {
+			table = append(table, 0)
+		}
+		phys := alloc()
+		if err := p.writeAt(phys, buf); err != nil {
+			return err
+		}
+		table[id] = phys
+	}
+
+	// 新しいページテーブルを書く（先に物理ページを確保して next で繋ぐ）
+	perPage := (p.pageSize - shadowTableHdr) / 8
+	var chunks [][]int64
+	for i := 0; i < len(table); i += perPage {
+		chunks = append(chunks, table[i:min(i+perPage, len(table))])
+	}
+	tablePages := make([]int64, len(chunks))
+	for i := range chunks {
+		tablePages[i] = alloc()
+	}
+	for i, chunk := range chunks {
+		buf := make([]byte, p.pageSize)
+		if i+1 < len(tablePages) {
+			binary.LittleEndian.PutUint64(buf[0:8], uint64(tablePages[i+1]))
+		}
+		binary.LittleEndian.PutUint32(buf[8:12], uint32(len(chunk)))
+		for j, phys := range chunk {
+			off := shadowTableHdr + j*8
+			binary.LittleEndian.PutUint64(buf[off:off+8], uint64(phys))
+		}
+		if err := p.writeAt(tablePages[i], buf); err != nil {
+			return err
+		}
+	}
+	var root int64
+	if len(tablePages) > 0 {
+		root = tablePages[0]
+	}
+
+	// データとページテーブルを永続化してからヘッダを切り替える
+	if p.sync != SyncOff {
+		if err := p.f.Sync(); err != nil {
+			return err
+		}
+	}
+	if err := p.writeShadowHeader(st.gen+1, root, int64(len(table))); err != nil {
+		return err
+	}
+	st.table, st.tablePages, st.nPhys = table, tablePages, nPhys
+	for id, buf := range p.pending {
+		p.cache.put(id, buf)
+	}
+	clear(p.pending)
+	return nil
+}
@@ -0,0 +1,86 @@
+package pager
+
+import (
+	"errors"
+
+	"github.com/k-sml/go-rdbms/internal/wal"
+)
+
+// WALの送出
+//
+// ストリーミングレプリケーションのリーダーは、コミットのたびにWALに記録したレコードを
+// フォロワーに送る。WALファイルを読み直すのではなく、commitPending がWALを同期した直後に
+// 同じレコードを登録した関数に渡すので、チェックポイントでWALを空にしても送り損ねることはない。
+// 関数はミューテックスを保持したまま呼ばれるので、ネットワークへの書き込みなどで待ってはならない
+// （受け取ったレコードをキューに入れ、別のゴルーチンで送る）。
+//
+// 論理レプリケーションでは、レコードの代わりに書き換えたページのコミット前後の内容を受け取る
+// （ShipPages）。差分や論理レコードは直前のページがなければ読めないが、前後の内容があれば
+// どのページも単独で行の変更に直せる。
+
+// ErrNotLeader はWALを書いていないページャーでレコードの送出を始めようとした場合に返されます。
+var ErrNotLeader = errors.New("replication requires a writable database with the wal journal")
+
+// Ship は以降のコミットのたびに、WALに記録したレコード（最後は RecCommit）を fn に渡すようにし、
+// fn が最初に受け取るレコードのLSNを返します。fn はミューテックスを保持したまま呼ばれるので、
+// 待たずに戻らなければなりません。レコードは書き換えてはいけません。stop を呼ぶと渡すのをやめます。
+// JournalWAL で書き込めるページャー以外では ErrNotLeader を返します。
+func (p *Pager) Ship(fn func(recs []*wal.Record)) (lsn uint64, stop func(), err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.log == nil || p.readOnly {
+		return 0, nil, ErrNotLeader
+	}
+	if p.ships == nil {
+		p.ships = make(map[int]func([]*wal.Record))
+	}
+	id := p.shipID
+	p.shipID++
+	p.ships[id] = fn
+	stop = func() {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		delete(p.ships, id)
+	}
+	return p.log.NextLSN(), stop, nil
+}
+
+// PageChange はコミットで書き換えたページの、コミットの前と後の内容です。
+type PageChange struct {
+	ID  int64
+	Old []byte // コミット前の内容（ファイルの末尾より後ろのページではすべてゼロ）
+	New []byte // コミット後の内容
+}
+
+// ShipPages は Ship と同じですが、コミットのたびにレコードの代わりに、書き換えたページの前後の内容を
+// ページIDの順に fn に渡します。lsn はコミットのレコード（RecCommit）のLSNです。ページの内容は
+// 書き換えてはいけません。
+func (p *Pager) ShipPages(fn func(txID, lsn uint64, pages []PageChange)) (lsn uint64, stop func(), err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.log == nil || p.readOnly {
+		return 0, nil, ErrNotLeader
+	}
+	if p.pageShips == nil {
+		p.pageShips = make(map[int]func(uint64, uint64, []PageChange))
+	}
+	id := p.shipID
+	p.shipID++
+	p.pageShips[id] = fn
+	stop = func() {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		delete(p.pageShips, id)
+	}
+	return p.log.NextLSN(), stop, nil
+}
+
+// ship はコミットしたレコードを登録された関数に渡します。
+// 呼び出し側でミューテックスを保持している必要があります。
+func (p *Pager) ship(recs []*wal.Record) {
+	for _, fn := range p.ships {
+		fn(recs)
+	}
+}
@@ -0,0 +1,134 @@
+// Package scan は結果の列の値を Go の変数に書き込みます。埋め込みの rdbms.Rows と、ネットワーク越しの
+// client.Rows の Scan が同じ規則で変換するために使います。規則は rdbms の「値の変換」のとおりです。
+package scan
+
+import (
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/k-sml/go-rdbms/internal/types"
+)
+
+// Scanner は列の値を自分で解釈する書き込み先の型が実装するインターフェースです（rdbms.Scanner と
+// 同じです）。
+type Scanner interface {
+	Scan(src any) error
+}
+
+// goTypes は列の型ごとの Values の Go の型です。
+var goTypes = map[types.Type]reflect.Type{
+	types.Int:       reflect.TypeFor[int64](),
+	types.BigInt:    reflect.TypeFor[int64](),
+	types.Real:      reflect.TypeFor[float64](),
+	types.Text:      reflect.TypeFor[string](),
+	types.Blob:      reflect.TypeFor[[]byte](),
+	types.Boolean:   reflect.TypeFor[bool](),
+	types.Timestamp: reflect.TypeFor[time.Time](),
+}
+
+// GoType は型 t の列の値を Values で受け取るときの Go の型を返します。型の決まらない列なら
+// false を返します。
+func GoType(t types.Type) (reflect.Type, bool) {
+	rt, ok := goTypes[t]
+	return rt, ok
+}
+
+// Value は値 v を dest の指す変数に書き込みます。
+func Value(dest any, v types.Value) error {
+	switch d := dest.(type) {
+	case Scanner:
+		return d.Scan(v.Go())
+	case *any:
+		if b, ok := v.Go().([]byte); ok {
+			*d = append([]byte(nil), b...)
+			return nil
+		}
+		*d = v.Go()
+		return nil
+	case *[]byte:
+		if v.IsNull() {
+			*d = nil
+			return nil
+		}
+	}
+	rv := reflect.ValueOf(dest)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() {
+		return fmt.Errorf("destination is not a non-nil pointer: %T", dest)
+	}
+	ev := rv.Elem()
+	if ev.Kind() == reflect.Pointer {
+		// ポインタへのポインタ。NULL なら nil にし、そうでなければ値を割り当てて書き込む
+		if v.IsNull() {
+			ev.Set(reflect.Zero(ev.Type()))
+			return nil
+		}
+		p := reflect.New(ev.Type().Elem())
+		if err := Value(p.Interface(), v); err != nil {
+			return err
+		}
+		ev.Set(p)
+		return nil
+	}
+	if v.IsNull() {
+		return fmt.Errorf("cannot scan NULL into %T", dest)
+	}
+	target, ok := sqlType(ev.Type())
+	if !ok {
+		return fmt.Errorf("unsupported destination type %T", dest)
+	}
+	conv := types.Coerce
+	if v.Type() == types.Text || target == types.Text {
+		conv = types.Cast
+	}
+	c, err := conv(v, target)
+	if err != nil {
+		return fmt.Errorf("cannot scan %s value %s into %T", v.Type(), v, dest)
+	}
+	switch ev.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if ev.OverflowInt(c.Int()) {
+			return fmt.Errorf("value %s out of range for %T", v, dest)
+		}
+		ev.SetInt(c.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if c.Int() < 0 || ev.OverflowUint(uint64(c.Int())) {
+			return fmt.Errorf("value %s out of range for %T", v, dest)
+		}
+		ev.SetUint(uint64(c.Int()))
+	case reflect.Float32, reflect.Float64:
+		ev.SetFloat(c.Real())
+	case reflect.String:
+		ev.SetString(c.Text())
+	case reflect.Bool:
+		ev.SetBool(c.Bool())
+	case reflect.Slice:
+		ev.SetBytes(append([]byte(nil), c.Blob()...))
+	default:
+		ev.Set(reflect.ValueOf(c.Time()))
+	}
+	return nil
+}
+
+// sqlType は Go の型 t の変数に書き込む前に、値を変換する SQL の型を返します。
+func sqlType(t reflect.Type) (types.Type, bool) {
+	if t == reflect.TypeFor[time.Time]() {
+		return types.Timestamp, true
+	}
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return types.BigInt, true
+	case reflect.Float32, reflect.Float64:
+		return types.Real, true
+	case reflect.String:
+		return types.Text, true
+	case reflect.Bool:
+		return types.Boolean, true
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return types.Blob, true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,96 @@
+package scan
+
+import (
+	"errors"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/k-sml/go-rdbms/internal/types"
+)
+
+// upper は Scanner を実装する書き込み先です。
+type upper string
+
+func (u *upper) Scan(src any) error {
+	s, ok := src.(string)
+	if !ok {
+		return errors.New("not a string")
+	}
+	*u = upper("<" + s + ">")
+	return nil
+}
+
+// fail は TestValue で書き込みがエラーになることを表します。
+type fail struct{}
+
+// TestValue は、列の値を Go の変数の型に合わせて書き込み、書き込めない値をエラーにすることを確かめます。
+func TestValue(t *testing.T) {
+	ts := time.Date(2024, 2, 29, 12, 34, 56, 0, time.UTC)
+	seven := int64(7)
+	tests := []struct {
+		v    types.Value
+		dest any // 書き込み先の変数へのポインタ
+		want any // 書き込んだ後の変数の値。fail{} ならエラーになる
+	}{
+		{types.NewInt(7), new(int), 7},
+		{types.NewBigInt(-7), new(int64), int64(-7)},
+		{types.NewBigInt(300), new(uint8), fail{}},
+		{types.NewBigInt(-1), new(uint), fail{}},
+		{types.NewBigInt(math.MaxInt32 + 1), new(int32), fail{}},
+		{types.NewReal(2), new(int), 2},
+		{types.NewReal(2.5), new(int), fail{}},
+		{types.NewInt(2), new(float32), float32(2)},
+		{types.NewText("42"), new(int), 42},
+		{types.NewText("x"), new(int), fail{}},
+		{types.NewInt(42), new(string), "42"},
+		{types.NewText("true"), new(bool), true},
+		{types.NewBool(true), new(bool), true},
+		{types.NewBlob([]byte{1, 2}), new([]byte), []byte{1, 2}},
+		{types.NullValue(), new([]byte), []byte(nil)},
+		{types.NewTimestamp(ts), new(time.Time), ts},
+		{types.NewText("2024-02-29 12:34:56"), new(time.Time), ts},
+		{types.NullValue(), new(int), fail{}},
+		{types.NullValue(), &[]*int64{&seven}[0], (*int64)(nil)},
+		{types.NewBigInt(7), new(*int64), &seven},
+		{types.NewBigInt(7), new(any), int64(7)},
+		{types.NewBlob([]byte{3}), new(any), []byte{3}},
+		{types.NullValue(), new(any), nil},
+		{types.NewText("a"), new(upper), upper("<a>")},
+		{types.NewInt(1), new(upper), fail{}},
+		{types.NewInt(1), new(struct{}), fail{}},
+		{types.NewInt(1), 0, fail{}},
+	}
+	for i, tt := range tests {
+		err := Value(tt.dest, tt.v)
+		if tt.want == (fail{}) {
+			if err == nil {
+				t.Errorf("%d: Value(%T, %v) succeeded, want an error", i, tt.dest, tt.v)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%d: Value(%T, %v): %v", i, tt.dest, tt.v, err)
+			continue
+		}
+		if got := reflect.ValueOf(tt.dest).Elem().Interface(); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%d: Value(%T, %v) wrote %#v, want %#v", i, tt.dest, tt.v, got, tt.want)
+		}
+	}
+}
+
+// TestGoType は列の型ごとの Go の型を確かめます。
+func TestGoType(t *testing.T) {
+	for typ, want := range map[types.Type]any{
+		types.Int: int64(0), types.Real: 0.0, types.Text: "", types.Blob: []byte(nil),
+		types.Boolean: false, types.Timestamp: time.Time{},
+	} {
+		if rt, ok := GoType(typ); !ok || rt != reflect.TypeOf(want) {
+			t.Errorf("GoType(%v) = %v, %v, want %T", typ, rt, ok, want)
+		}
+	}
+	if _, ok := GoType(types.Null); ok {
+		t.Error("GoType(NULL) has a Go type")
+	}
+}
@@ -0,0 +1,295 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+
+	"github.com/k-sml/go-rdbms"
+	"github.com/k-sml/go-rdbms/internal/exec"
+	"github.com/k-sml/go-rdbms/internal/sql/lexer"
+	"github.com/k-sml/go-rdbms/internal/wire"
+)
+
+// serverVersion は AuthOK で送るサーバーの名前と版です。
+const serverVersion = "minirdb/1"
+
+// session は1つの接続の状態です。
+type session struct {
+	s     *Server
+	nc    net.Conn
+	r     *wire.Reader
+	w     *wire.Writer
+	conn  *rdbms.Conn
+	stmts map[uint32]*rdbms.Stmt // Prepare で準備した文
+	next  uint32                 // 次に準備する文の ID
+	buf   []byte                 // 送るメッセージの本体
+
+	readOnly bool // 認証したユーザーに読み取りだけを許可している
+}
+
+// serveConn は接続 nc のクライアントを認証し、切断するまで要求を処理します。
+func (s *Server) serveConn(nc net.Conn) {
+	c := &session{s: s, nc: nc, r: wire.NewReader(nc), w: wire.NewWriter(nc), stmts: make(map[uint32]*rdbms.Stmt)}
+	logger := s.logger.With("remote", nc.RemoteAddr().String())
+	user, err := c.startup()
+	if err != nil {
+		if !disconnected(err) {
+			logger.Warn("connection rejected", "err", err)
+		}
+		return
+	}
+	logger = logger.With("user", user)
+	c.conn, err = s.db.Conn(s.ctx)
+	if err != nil {
+		c.error(err)
+		return
+	}
+	defer c.conn.Close()
+	c.conn.SetTxOptions(rdbms.TxOptions{ReadOnly: c.readOnly})
+	logger.Debug("connection opened")
+	err = c.loop()
+	if err != nil && !disconnected(err) {
+		logger.Warn("connection closed", "err", err)
+		return
+	}
+	logger.Debug("connection closed")
+}
+
+// disconnected は err がクライアントの切断か、Close で接続を閉じたことによるエラーかを返します。
+func disconnected(err error) bool {
+	return errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, net.ErrClosed)
+}
+
+// startup は Startup を読んでクライアントを認証し、ユーザー名を返します。認証に失敗すれば
+// Error を送ってエラーを返します。
+func (c *session) startup() (string, error) {
+	typ, body, err := c.r.Read()
+	if err != nil {
+		return "", err
+	}
+	if typ != wire.Startup {
+		err := fmt.Errorf("expected a startup message, got %q", typ)
+		c.sendError(wire.CodeProtocol, err.Error())
+		return "", err
+	}
+	d := wire.NewBody(body)
+	version := d.Uint16()
+	user, password := d.String(), d.String()
+	if err := d.Err(); err != nil {
+		c.sendError(wire.CodeProtocol, err.Error())
+		return "", err
+	}
+	if version != wire.Version {
+		err := fmt.Errorf("unsupported protocol version %d", version)
+		c.sendError(wire.CodeProtocol, err.Error())
+		return "", err
+	}
+	if c.s.opts.Auth != nil {
+		if c.readOnly, err = c.s.opts.Auth(user, password); err != nil {
+			c.sendError(wire.CodeAuth, "authentication failed")
+			return "", fmt.Errorf("user %s: %w", user, err)
+		}
+	}
+	if err := c.w.Write(wire.AuthOK, wire.AppendString(c.buf[:0], serverVersion)); err != nil {
+		return "", err
+	}
+	return user, c.w.Flush()
+}
+
+// loop は Terminate を受け取るか接続が切れるまで要求を処理します。
+func (c *session) loop() error {
+	for {
+		typ, body, err := c.r.Read()
+		if err != nil {
+			return err
+		}
+		d := wire.NewBody(body)
+		switch typ {
+		case wire.Query:
+			sql := d.String()
+			args := d.Values()
+			if d.Err() != nil {
+				return c.fail(d.Err())
+			}
+			st, err := c.conn.Prepare(sql)
+			if err != nil {
+				err = c.error(err)
+			} else {
+				err = c.run(st, args)
+			}
+			if err != nil {
+				return err
+			}
+		case wire.Prepare:
+			sql := d.String()
+			if d.Err() != nil {
+				return c.fail(d.Err())
+			}
+			if err := c.prepare(sql); err != nil {
+				return err
+			}
+		case wire.Execute:
+			id := d.Uint32()
+			args := d.Values()
+			if d.Err() != nil {
+				return c.fail(d.Err())
+			}
+			st, ok := c.stmts[id]
+			if !ok {
+				err = c.sendError(wire.CodeUnknownStmt, fmt.Sprintf("unknown statement %d", id))
+			} else {
+				err = c.run(st, args)
+			}
+			if err != nil {
+				return err
+			}
+		case wire.CloseStmt:
+			id := d.Uint32()
+			if d.Err() != nil {
+				return c.fail(d.Err())
+			}
+			delete(c.stmts, id)
+			if err := c.complete(0); err != nil {
+				return err
+			}
+		case wire.Replicate:
+			return c.replicate()
+		case wire.Terminate:
+			return nil
+		default:
+			return c.fail(fmt.Errorf("unexpected message %q", typ))
+		}
+		if err := c.w.Flush(); err != nil {
+			return err
+		}
+	}
+}
+
+// prepare は sql を準備して Prepared を送ります。
+func (c *session) prepare(sql string) error {
+	st, err := c.conn.Prepare(sql)
+	if err != nil {
+		return c.error(err)
+	}
+	id := c.next
+	c.next++
+	c.stmts[id] = st
+	b := wire.AppendUint32(c.buf[:0], id)
+	b = wire.AppendUint16(b, uint16(st.NumParams()))
+	b = wire.AppendBool(b, st.ReturnsRows())
+	c.buf = b
+	return c.w.Write(wire.Prepared, b)
+}
+
+// run は文 st を args で実行し、応答を送ります。文のエラーは Error で送り、接続への書き込みに
+// 失敗した場合だけエラーを返します。
+func (c *session) run(st *rdbms.Stmt, args []any) error {
+	if !st.ReturnsRows() {
+		n, err := st.ExecContext(c.s.ctx, args...)
+		if err != nil {
+			return c.error(err)
+		}
+		return c.complete(n)
+	}
+	rows, err := st.QueryContext(c.s.ctx, args...)
+	if err != nil {
+		return c.error(err)
+	}
+	defer rows.Close()
+	cols := rows.ColumnTypes()
+	b := wire.AppendUint16(c.buf[:0], uint16(len(cols)))
+	for _, col := range cols {
+		b = wire.AppendString(b, col.Name)
+		b = wire.AppendString(b, col.DatabaseTypeName)
+	}
+	if err := c.w.Write(wire.RowDescription, b); err != nil {
+		return err
+	}
+	// 行は読んだ順に送り、バッファがいっぱいになるたびにクライアントに届く
+	var n int64
+	for rows.Next() {
+		if b, err = wire.AppendValues(b[:0], rows.Values()); err != nil {
+			return c.error(err)
+		}
+		if err := c.w.Write(wire.DataRow, b); err != nil {
+			return err
+		}
+		n++
+	}
+	c.buf = b
+	if err := rows.Err(); err != nil {
+		return c.error(err)
+	}
+	return c.complete(n)
+}
+
+// complete は Complete を送ります。
+func (c *session) complete(n int64) error {
+	b := wire.AppendInt64(c.buf[:0], n)
+	b = wire.AppendBool(b, c.conn.InTx())
+	c.buf = b
+	return c.w.Write(wire.Complete, b)
+}
+
+// error は文のエラー err を Error で送ります。
+func (c *session) error(err error) error {
+	return c.sendError(code(err), err.Error())
+}
+
+func (c *session) sendError(code, msg string) error {
+	b := wire.AppendString(c.buf[:0], code)
+	b = wire.AppendString(b, msg)
+	c.buf = b
+	if err := c.w.Write(wire.Error, b); err != nil {
+		return err
+	}
+	return c.w.Flush()
+}
+
+// fail はプロトコルの誤りを Error で送り、接続を閉じるためのエラーを返します。
+func (c *session) fail(err error) error {
+	c.sendError(wire.CodeProtocol, err.Error())
+	return err
+}
+
+// codes は rdbms のエラーの種類ごとの Error のコードです。1つのエラーが複数の種類に一致する
+// ことがあるので、細かい種類から順に調べます。
+var codes = []struct {
+	err  error
+	code string
+}{
+	{rdbms.ErrUnique, wire.CodeUnique},
+	{rdbms.ErrNotNull, wire.CodeNotNull},
+	{rdbms.ErrForeignKey, wire.CodeForeignKey},
+	{rdbms.ErrConstraint, wire.CodeConstraint},
+	{rdbms.ErrDeadlock, wire.CodeDeadlock},
+	{rdbms.ErrLocked, wire.CodeLocked},
+	{rdbms.ErrSerialization, wire.CodeSerialization},
+	{rdbms.ErrReadOnly, wire.CodeReadOnly},
+	{rdbms.ErrCorrupt, wire.CodeCorrupt},
+	{rdbms.ErrTxDone, wire.CodeTxDone},
+	{rdbms.ErrConnDone, wire.CodeConnDone},
+	{context.Canceled, wire.CodeCanceled},
+	{context.DeadlineExceeded, wire.CodeCanceled},
+}
+
+// code は err の種類を表す Error のコードを返します。
+func code(err error) string {
+	var se *lexer.Error
+	var be *exec.BindError
+	switch {
+	case errors.As(err, &se):
+		return wire.CodeSyntax
+	case errors.As(err, &be):
+		return wire.CodeInvalid
+	}
+	for _, c := range codes {
+		if errors.Is(err, c.err) {
+			return c.code
+		}
+	}
+	return wire.CodeError
+}
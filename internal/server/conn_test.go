@@ -0,0 +1,75 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"path/filepath"
+	"testing"
+
+	"github.com/k-sml/go-rdbms"
+	"github.com/k-sml/go-rdbms/client"
+)
+
+// TestSessionFailedStatement は、BEGIN の中で途中の行で失敗した文が行を残さず、セッションの
+// トランザクションは続いて、COMMIT が成功した文の変更だけをコミットすることを確かめます。
+func TestSessionFailedStatement(t *testing.T) {
+	db, err := rdbms.Open(filepath.Join(t.TempDir(), "test.db"), rdbms.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := New(db, Options{})
+	defer s.Close()
+	go s.Serve(l)
+	c, err := client.Open(l.Addr().String(), client.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	if _, err := c.Exec("CREATE TABLE t (id INT PRIMARY KEY)"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Exec("INSERT INTO t VALUES (1)"); err != nil {
+		t.Fatal(err)
+	}
+	tx, err := c.BeginTx(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var e *client.Error
+	if _, err := tx.Exec("INSERT INTO t VALUES (2), (1)"); !errors.As(err, &e) || e.Code != client.CodeUnique {
+		t.Fatalf("duplicate INSERT in a transaction: err = %v, want %s", err, client.CodeUnique)
+	}
+	if _, err := tx.Exec("INSERT INTO t VALUES (3)"); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	rows, err := c.Query("SELECT id FROM t ORDER BY id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var got []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, id)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0] != 1 || got[1] != 3 {
+		t.Errorf("committed ids = %v, want [1 3]", got)
+	}
+}
@@ -0,0 +1,456 @@
+package server
+
+import (
+	"cmp"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/k-sml/go-rdbms"
+	minirdbv1 "github.com/k-sml/go-rdbms/api/minirdb/v1"
+	"github.com/k-sml/go-rdbms/internal/wire"
+)
+
+// gRPC の API
+//
+// ServeGRPC は api/minirdb/v1/minirdb.proto の Database サービスを gRPC（HTTP/2）で提供する。
+// Options.TLSConfig がなければ暗号化しない HTTP/2（h2c）で受け付ける。Options.Auth を指定すると、
+// 要求ごとに authorization のメタデータの Basic 認証を求め、失敗すれば UNAUTHENTICATED を返す。
+//
+// Begin はトランザクションを1つ持つセッションを作って、推測できない ID を返す。セッションは
+// Begin したユーザーだけが使え、Commit か Rollback で閉じる。要求が来ないまま
+// Options.GRPCSessionTimeout が過ぎたセッションは、クライアントが閉じずにいなくなったとみなして
+// ロールバックし、消す。1つのセッションの要求は同時に来ても1つずつ実行する。
+//
+// Backup は Options.BackupDir の中に複製を書く。クライアントが指定できるのはファイル名だけで、
+// ディレクトリを含む名前、絶対パスと ".." は拒否する。BackupDir がなければ Backup は使えない。
+//
+// エラーは minirdb.proto に書いた表のとおり、wire の Error のコードから状態コードを決め、コードを
+// 状態の詳細の reason に入れる。
+
+// queryBatch は Query が1つの応答にまとめる行の数です。
+const queryBatch = 256
+
+// defaultGRPCSessionTimeout は Options.GRPCSessionTimeout の既定値です。
+const defaultGRPCSessionTimeout = 5 * time.Minute
+
+// grpcSession は Begin で作ったセッションです。
+type grpcSession struct {
+	user  string
+	timer *time.Timer // 要求が来ないまま時間が過ぎると expireGRPCSession を呼ぶ
+
+	mu   sync.Mutex // 実行中の要求が持つ
+	tx   *rdbms.Tx  // 閉じたら nil
+	used time.Time  // 最後の要求を終えた時刻
+}
+
+// grpcCodes は Error のコードごとの gRPC の状態コードです。ないコードは Internal にします。
+var grpcCodes = map[string]minirdbv1.Code{
+	wire.CodeSyntax:        minirdbv1.InvalidArgument,
+	wire.CodeInvalid:       minirdbv1.InvalidArgument,
+	wire.CodeUnique:        minirdbv1.FailedPrecondition,
+	wire.CodeNotNull:       minirdbv1.FailedPrecondition,
+	wire.CodeForeignKey:    minirdbv1.FailedPrecondition,
+	wire.CodeConstraint:    minirdbv1.FailedPrecondition,
+	wire.CodeLocked:        minirdbv1.Aborted,
+	wire.CodeDeadlock:      minirdbv1.Aborted,
+	wire.CodeSerialization: minirdbv1.Aborted,
+	wire.CodeReadOnly:      minirdbv1.PermissionDenied,
+	wire.CodeCanceled:      minirdbv1.Canceled,
+	wire.CodeAuth:          minirdbv1.Unauthenticated,
+}
+
+// grpcError は err を gRPC の状態にします。
+func grpcError(err error) error {
+	var e *minirdbv1.Error
+	if errors.As(err, &e) {
+		return e
+	}
+	c := code(err)
+	st, ok := grpcCodes[c]
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		st = minirdbv1.DeadlineExceeded
+	case !ok:
+		st = minirdbv1.Internal
+	}
+	return &minirdbv1.Error{Code: st, Message: err.Error(), Reason: c}
+}
+
+// ListenAndServeGRPC は TCP のアドレス addr で接続を待ち、ServeGRPC を呼びます。
+func (s *Server) ListenAndServeGRPC(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.ServeGRPC(l)
+}
+
+// ServeGRPC は Serve と同じですが、l で gRPC の要求を受け付けて Database サービスで答えます。
+// Options.TLSConfig があれば TLS で、なければ暗号化しない HTTP/2 で受け付けます。
+func (s *Server) ServeGRPC(l net.Listener) error {
+	protocols := new(http.Protocols)
+	protocols.SetHTTP2(true)
+	if s.opts.TLSConfig == nil {
+		protocols.SetUnencryptedHTTP2(true)
+	}
+	hs := &http.Server{Handler: s.enter(minirdbv1.NewHandler(&grpcService{s})), Protocols: protocols}
+	return s.serveHTTP(l, hs)
+}
+
+// grpcService は Database サービスの実装です。
+type grpcService struct {
+	s *Server
+}
+
+// auth は Options.Auth を指定していれば、要求の Basic 認証のユーザーを確かめて、その名前と
+// 読み取りだけを許可しているかを返します。
+func (g *grpcService) auth(ctx context.Context) (user string, readOnly bool, err error) {
+	if g.s.opts.Auth == nil {
+		return "", false, nil
+	}
+	r := &http.Request{Header: minirdbv1.IncomingHeader(ctx)}
+	user, password, ok := r.BasicAuth()
+	if !ok {
+		return "", false, minirdbv1.Errorf(minirdbv1.Unauthenticated, "authentication required")
+	}
+	readOnly, err = g.s.opts.Auth(user, password)
+	if err != nil {
+		g.s.logger.Warn("gRPC request rejected", "user", user, "err", err)
+		return "", false, minirdbv1.Errorf(minirdbv1.Unauthenticated, "authentication failed")
+	}
+	return user, readOnly, nil
+}
+
+// session は user のセッション id を、実行中の要求として持って返します。終えたら mu を放します。
+func (g *grpcService) session(id, user string) (*grpcSession, error) {
+	g.s.mu.Lock()
+	ss := g.s.grpcSessions[id]
+	g.s.mu.Unlock()
+	if ss == nil || ss.user != user {
+		return nil, minirdbv1.Errorf(minirdbv1.NotFound, "no such session: %s", id)
+	}
+	ss.mu.Lock()
+	if ss.tx == nil {
+		ss.mu.Unlock()
+		return nil, minirdbv1.Errorf(minirdbv1.NotFound, "no such session: %s", id)
+	}
+	return ss, nil
+}
+
+// release は session で持ったセッションを放し、要求が来ないまま閉じるまでの時間を測り直します。
+func (g *grpcService) release(ss *grpcSession) {
+	ss.used = time.Now()
+	ss.timer.Reset(g.s.grpcSessionTimeout())
+	ss.mu.Unlock()
+}
+
+// grpcSessionTimeout は要求が来ないセッションを閉じるまでの時間を返します。
+func (s *Server) grpcSessionTimeout() time.Duration {
+	return cmp.Or(s.opts.GRPCSessionTimeout, defaultGRPCSessionTimeout)
+}
+
+// expireGRPCSession は要求が来ないまま時間が過ぎたセッション id をロールバックして消します。
+// 要求を実行中なら何もしません（要求を終えた release が時間を測り直します）。
+func (s *Server) expireGRPCSession(id string, ss *grpcSession) {
+	if !ss.mu.TryLock() {
+		return
+	}
+	defer ss.mu.Unlock()
+	if ss.tx == nil || time.Since(ss.used) < s.grpcSessionTimeout() {
+		return // 閉じたか、タイマーが切れるのと同時に要求を終えた
+	}
+	s.mu.Lock()
+	if s.grpcSessions[id] == ss {
+		delete(s.grpcSessions, id)
+	}
+	s.mu.Unlock()
+	ss.tx.Rollback()
+	ss.tx = nil
+	s.logger.Info("idle gRPC session rolled back", "user", ss.user)
+}
+
+// args は要求の引数を Go の値にします。
+func args(params []*minirdbv1.Value) []any {
+	out := make([]any, len(params))
+	for i, p := range params {
+		out[i] = p.Go()
+	}
+	return out
+}
+
+func (g *grpcService) Execute(ctx context.Context, req *minirdbv1.ExecuteRequest) (*minirdbv1.ExecuteResponse, error) {
+	user, readOnly, err := g.auth(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var n int64
+	if req.Session != "" {
+		var ss *grpcSession
+		if ss, err = g.session(req.Session, user); err != nil {
+			return nil, err
+		}
+		defer g.release(ss)
+		n, err = ss.tx.ExecContext(ctx, req.SQL, args(req.Params)...)
+	} else {
+		n, err = g.autocommit(ctx, readOnly, func(c *rdbms.Conn) (int64, error) {
+			return c.ExecContext(ctx, req.SQL, args(req.Params)...)
+		})
+	}
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return &minirdbv1.ExecuteResponse{RowsAffected: n}, nil
+}
+
+// autocommit はセッションのない要求を、新しい Conn の自動コミットで fn に実行させます。
+func (g *grpcService) autocommit(ctx context.Context, readOnly bool, fn func(c *rdbms.Conn) (int64, error)) (int64, error) {
+	conn, err := g.s.db.Conn(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	conn.SetTxOptions(rdbms.TxOptions{ReadOnly: readOnly})
+	return fn(conn)
+}
+
+func (g *grpcService) Query(ctx context.Context, req *minirdbv1.QueryRequest, send func(*minirdbv1.QueryResponse) error) error {
+	user, readOnly, err := g.auth(ctx)
+	if err != nil {
+		return err
+	}
+	var rows *rdbms.Rows
+	if req.Session != "" {
+		ss, err := g.session(req.Session, user)
+		if err != nil {
+			return err
+		}
+		defer g.release(ss)
+		rows, err = ss.tx.QueryContext(ctx, req.SQL, args(req.Params)...)
+		if err != nil {
+			return grpcError(err)
+		}
+	} else {
+		conn, err := g.s.db.Conn(ctx)
+		if err != nil {
+			return grpcError(err)
+		}
+		defer conn.Close()
+		conn.SetTxOptions(rdbms.TxOptions{ReadOnly: readOnly})
+		rows, err = conn.QueryContext(ctx, req.SQL, args(req.Params)...)
+		if err != nil {
+			return grpcError(err)
+		}
+	}
+	defer rows.Close()
+	return sendRows(rows, send)
+}
+
+// sendRows は rows の列の情報を送ってから、行を queryBatch 行ずつ送ります。
+func sendRows(rows *rdbms.Rows, send func(*minirdbv1.QueryResponse) error) error {
+	head := &minirdbv1.QueryResponse{}
+	for _, ct := range rows.ColumnTypes() {
+		head.Columns = append(head.Columns, &minirdbv1.Column{Name: ct.Name, Type: ct.DatabaseTypeName})
+	}
+	if err := send(head); err != nil {
+		return err
+	}
+	batch := &minirdbv1.QueryResponse{}
+	for rows.Next() {
+		row := &minirdbv1.Row{}
+		for _, v := range rows.Values() {
+			pv, err := minirdbv1.ValueOf(v)
+			if err != nil {
+				return grpcError(err)
+			}
+			if pv.Kind == minirdbv1.ValueBlob {
+				pv.Blob = append([]byte(nil), pv.Blob...) // Values の []byte は次の Next までしか使えない
+			}
+			row.Values = append(row.Values, pv)
+		}
+		batch.Rows = append(batch.Rows, row)
+		if len(batch.Rows) == queryBatch {
+			if err := send(batch); err != nil {
+				return err
+			}
+			batch = &minirdbv1.QueryResponse{}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return grpcError(err)
+	}
+	if len(batch.Rows) > 0 {
+		return send(batch)
+	}
+	return nil
+}
+
+// isolations は Isolation に対応する分離レベルです。
+var isolations = map[minirdbv1.Isolation]rdbms.Isolation{
+	minirdbv1.IsolationLocking:      rdbms.Locking,
+	minirdbv1.IsolationSnapshot:     rdbms.Snapshot,
+	minirdbv1.IsolationSerializable: rdbms.Serializable,
+	minirdbv1.IsolationOptimistic:   rdbms.Optimistic,
+}
+
+func (g *grpcService) Begin(ctx context.Context, req *minirdbv1.BeginRequest) (*minirdbv1.BeginResponse, error) {
+	user, readOnly, err := g.auth(ctx)
+	if err != nil {
+		return nil, err
+	}
+	iso, ok := isolations[req.Isolation]
+	if !ok {
+		return nil, minirdbv1.Errorf(minirdbv1.InvalidArgument, "unknown isolation level: %d", req.Isolation)
+	}
+	// トランザクションは要求より長く続くので、サーバーのコンテキストで始める
+	tx, err := g.s.db.BeginTx(g.s.ctx, rdbms.TxOptions{Isolation: iso, ReadOnly: req.ReadOnly || readOnly})
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	var b [16]byte
+	rand.Read(b[:])
+	id := hex.EncodeToString(b[:])
+	g.s.mu.Lock()
+	defer g.s.mu.Unlock()
+	if g.s.closed {
+		tx.Rollback()
+		return nil, minirdbv1.Errorf(minirdbv1.Unavailable, "%v", ErrServerClosed)
+	}
+	if g.s.grpcSessions == nil {
+		g.s.grpcSessions = make(map[string]*grpcSession)
+	}
+	ss := &grpcSession{user: user, tx: tx, used: time.Now()}
+	ss.timer = time.AfterFunc(g.s.grpcSessionTimeout(), func() { g.s.expireGRPCSession(id, ss) })
+	g.s.grpcSessions[id] = ss
+	return &minirdbv1.BeginResponse{Session: id}, nil
+}
+
+func (g *grpcService) Commit(ctx context.Context, req *minirdbv1.EndRequest) (*minirdbv1.EndResponse, error) {
+	return g.end(ctx, req.Session, (*rdbms.Tx).Commit)
+}
+
+func (g *grpcService) Rollback(ctx context.Context, req *minirdbv1.EndRequest) (*minirdbv1.EndResponse, error) {
+	return g.end(ctx, req.Session, (*rdbms.Tx).Rollback)
+}
+
+// end はセッション id のトランザクションを fn で終えて、セッションを閉じます。
+func (g *grpcService) end(ctx context.Context, id string, fn func(*rdbms.Tx) error) (*minirdbv1.EndResponse, error) {
+	user, _, err := g.auth(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ss, err := g.session(id, user)
+	if err != nil {
+		return nil, err
+	}
+	defer ss.mu.Unlock()
+	ss.timer.Stop()
+	g.s.mu.Lock()
+	delete(g.s.grpcSessions, id)
+	g.s.mu.Unlock()
+	tx := ss.tx
+	ss.tx = nil
+	if err := fn(tx); err != nil {
+		return nil, grpcError(err)
+	}
+	return &minirdbv1.EndResponse{}, nil
+}
+
+func (g *grpcService) Backup(ctx context.Context, req *minirdbv1.BackupRequest, send func(*minirdbv1.BackupProgress) error) error {
+	_, readOnly, err := g.auth(ctx)
+	if err != nil {
+		return err
+	}
+	switch {
+	case readOnly:
+		return minirdbv1.Errorf(minirdbv1.PermissionDenied, "backup requires write access")
+	case g.s.opts.BackupDir == "":
+		return minirdbv1.Errorf(minirdbv1.FailedPrecondition, "backups are disabled on this server")
+	case req.Name == "":
+		return minirdbv1.Errorf(minirdbv1.InvalidArgument, "missing backup file name")
+	case !backupName(req.Name):
+		return minirdbv1.Errorf(minirdbv1.InvalidArgument, "backup name must be a file name without a directory: %q", req.Name)
+	case req.PagesPerStep < 0:
+		return minirdbv1.Errorf(minirdbv1.InvalidArgument, "invalid pages per step: %d", req.PagesPerStep)
+	}
+	path := filepath.Join(g.s.opts.BackupDir, req.Name)
+	dst, err := rdbms.Open(path, rdbms.Options{PageSize: g.s.db.Stats().PageSize})
+	if err != nil {
+		return minirdbv1.Errorf(minirdbv1.InvalidArgument, "opening backup: %v", err)
+	}
+	defer dst.Close()
+	// 進み具合を送れなければ、クライアントは読んでいないので複製を取り消す
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	err = g.s.db.BackupContext(ctx, dst, int(req.PagesPerStep), func(copied, total int64) {
+		if err := send(&minirdbv1.BackupProgress{Copied: copied, Total: total}); err != nil {
+			cancel(err)
+		}
+	})
+	if err != nil {
+		if cause := context.Cause(ctx); cause != nil && !errors.Is(cause, context.Canceled) {
+			return cause
+		}
+		return grpcError(err)
+	}
+	if err := dst.Close(); err != nil {
+		return grpcError(fmt.Errorf("closing backup: %w", err))
+	}
+	g.s.logger.Info("backup written", "path", path)
+	return nil
+}
+
+// backupName は name が Backup の要求で使えるファイル名か、つまりディレクトリの区切りを含まず、
+// 絶対パスでも "." や ".." でもないかを返します。
+func backupName(name string) bool {
+	return filepath.IsLocal(name) && name != "." && !strings.ContainsAny(name, `/\`)
+}
+
+func (g *grpcService) Stats(ctx context.Context, _ *minirdbv1.StatsRequest) (*minirdbv1.StatsResponse, error) {
+	if _, _, err := g.auth(ctx); err != nil {
+		return nil, err
+	}
+	st := g.s.db.Stats()
+	return &minirdbv1.StatsResponse{
+		PageSize:           int32(st.PageSize),
+		PageReads:          st.PageReads,
+		CachedReads:        st.CachedReads,
+		CacheHits:          st.CacheHits,
+		PageWrites:         st.PageWrites,
+		FileWrites:         st.FileWrites,
+		WALBytes:           st.WALBytes,
+		ActiveTransactions: int32(st.ActiveTransactions),
+		Commits:            st.Commits,
+		Rollbacks:          st.Rollbacks,
+		LocksGranted:       int32(st.LocksGranted),
+		LocksWaiting:       int32(st.LocksWaiting),
+		LockWaits:          st.LockWaits,
+		Deadlocks:          st.Deadlocks,
+		LockTimeouts:       st.LockTimeouts,
+	}, nil
+}
+
+// closeGRPCSessions は Close で、閉じていないセッションのトランザクションをロールバックします。
+func (s *Server) closeGRPCSessions() {
+	s.mu.Lock()
+	sessions := s.grpcSessions
+	s.grpcSessions = nil
+	s.mu.Unlock()
+	for _, ss := range sessions {
+		ss.timer.Stop()
+		ss.mu.Lock()
+		if ss.tx != nil {
+			ss.tx.Rollback()
+			ss.tx = nil
+		}
+		ss.mu.Unlock()
+	}
+}
@@ -0,0 +1,173 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/k-sml/go-rdbms"
+	minirdbv1 "github.com/k-sml/go-rdbms/api/minirdb/v1"
+)
+
+// startGRPC はデータベースを開いて gRPC の API で提供し、そのクライアントを返します。
+// サーバーとデータベースはテストの終わりに閉じます。
+func startGRPC(t *testing.T, opts Options) *minirdbv1.Client {
+	t.Helper()
+	db, err := rdbms.Open(filepath.Join(t.TempDir(), "test.db"), rdbms.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := New(db, opts)
+	go s.ServeGRPC(l)
+	c := minirdbv1.NewClient(l.Addr().String(), minirdbv1.Options{})
+	t.Cleanup(func() {
+		c.Close()
+		s.Close()
+		db.Close()
+	})
+	return c
+}
+
+// backup は Backup を呼び、進み具合を最後まで読みます。
+func backup(c *minirdbv1.Client, name string) error {
+	bs, err := c.Backup(context.Background(), &minirdbv1.BackupRequest{Name: name})
+	if err != nil {
+		return err
+	}
+	defer bs.Close()
+	for {
+		if _, err := bs.Recv(); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
+
+// statusCode は err の gRPC の状態コードを返します。
+func statusCode(t *testing.T, err error) minirdbv1.Code {
+	t.Helper()
+	if err == nil {
+		return minirdbv1.OK
+	}
+	var e *minirdbv1.Error
+	if !errors.As(err, &e) {
+		t.Fatalf("err = %v, want a gRPC status", err)
+	}
+	return e.Code
+}
+
+// TestGRPCBackupName は、Backup がバックアップのディレクトリの中のファイル名だけを受け付け、
+// ディレクトリを設定していないサーバーでは使えないことを確かめます。
+func TestGRPCBackupName(t *testing.T) {
+	dir := t.TempDir()
+	c := startGRPC(t, Options{BackupDir: dir})
+	for _, name := range []string{"", ".", "..", "../b.db", "sub/b.db", `sub\b.db`, filepath.Join(dir, "b.db")} {
+		if got := statusCode(t, backup(c, name)); got != minirdbv1.InvalidArgument {
+			t.Errorf("Backup(%q) = %v, want InvalidArgument", name, got)
+		}
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("rejected backups wrote %d files", len(entries))
+	}
+	if err := backup(c, "b.db"); err != nil {
+		t.Fatalf("Backup(b.db): %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "b.db")); err != nil {
+		t.Errorf("backup file: %v", err)
+	}
+
+	c = startGRPC(t, Options{})
+	if got := statusCode(t, backup(c, "b.db")); got != minirdbv1.FailedPrecondition {
+		t.Errorf("Backup without a backup directory = %v, want FailedPrecondition", got)
+	}
+}
+
+// execute は c で sql を実行します。session が空でなければそのセッションで実行します。
+func execute(c *minirdbv1.Client, session, sql string) error {
+	_, err := c.Execute(context.Background(), &minirdbv1.ExecuteRequest{Session: session, SQL: sql})
+	return err
+}
+
+// TestGRPCSessionTimeout は、要求が来ないまま GRPCSessionTimeout が過ぎたセッションをロールバックして
+// 消し、要求が続くセッションは閉じないことを確かめます。
+func TestGRPCSessionTimeout(t *testing.T) {
+	const timeout = 100 * time.Millisecond
+	c := startGRPC(t, Options{GRPCSessionTimeout: timeout})
+	ctx := context.Background()
+	if err := execute(c, "", "CREATE TABLE t (id INT PRIMARY KEY)"); err != nil {
+		t.Fatal(err)
+	}
+
+	idle, err := c.Begin(ctx, &minirdbv1.BeginRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := execute(c, idle.Session, "INSERT INTO t VALUES (1)"); err != nil {
+		t.Fatal(err)
+	}
+	busy, err := c.Begin(ctx, &minirdbv1.BeginRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range 10 {
+		time.Sleep(timeout / 4)
+		if err := execute(c, busy.Session, fmt.Sprintf("INSERT INTO t VALUES (%d)", 100+i)); err != nil {
+			t.Fatalf("session in use: %v", err)
+		}
+	}
+
+	if _, err := c.Commit(ctx, &minirdbv1.EndRequest{Session: busy.Session}); err != nil {
+		t.Errorf("committing the session in use: %v", err)
+	}
+
+	if got := statusCode(t, execute(c, idle.Session, "INSERT INTO t VALUES (2)")); got != minirdbv1.NotFound {
+		t.Errorf("idle session after the timeout = %v, want NotFound", got)
+	}
+	// ロールバックしたので、id 1 の行はなく、ロックも残っていない
+	if err := execute(c, "", "INSERT INTO t VALUES (1)"); err != nil {
+		t.Errorf("inserting the rolled back row again: %v", err)
+	}
+}
+
+// TestGRPCFailedStatementInSession は、セッションのトランザクションの中で途中の行が失敗した文の変更が
+// 残らず、トランザクションを続けてコミットできることを確かめます。
+func TestGRPCFailedStatementInSession(t *testing.T) {
+	c := startGRPC(t, Options{})
+	ctx := context.Background()
+	for _, sql := range []string{"CREATE TABLE t (id INT PRIMARY KEY)", "INSERT INTO t VALUES (1)"} {
+		if err := execute(c, "", sql); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s, err := c.Begin(ctx, &minirdbv1.BeginRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := statusCode(t, execute(c, s.Session, "INSERT INTO t VALUES (2), (1)")); got != minirdbv1.FailedPrecondition {
+		t.Errorf("duplicate key in the second row = %v, want FailedPrecondition", got)
+	}
+	if err := execute(c, s.Session, "INSERT INTO t VALUES (3)"); err != nil {
+		t.Fatalf("statement after the failed one: %v", err)
+	}
+	if _, err := c.Commit(ctx, &minirdbv1.EndRequest{Session: s.Session}); err != nil {
+		t.Fatal(err)
+	}
+	// 失敗した文の1行目は挿入されていない
+	if err := execute(c, "", "INSERT INTO t VALUES (2)"); err != nil {
+		t.Errorf("inserting the first row of the failed statement again: %v", err)
+	}
+	if got := statusCode(t, execute(c, "", "INSERT INTO t VALUES (3)")); got != minirdbv1.FailedPrecondition {
+		t.Errorf("inserting the committed row again = %v, want FailedPrecondition", got)
+	}
+}
@@ -0,0 +1,489 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"mime"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/k-sml/go-rdbms"
+	"github.com/k-sml/go-rdbms/arrow"
+	"github.com/k-sml/go-rdbms/internal/types"
+	"github.com/k-sml/go-rdbms/internal/wire"
+)
+
+// HTTP の API
+//
+// ServeHTTPOn と Handler は、ドライバーを使わずにスクリプトやダッシュボードから問い合わせるための
+// HTTP の API を提供する。
+//
+//	POST /query    {"sql": "SELECT ...", "params": [1, "a"]}
+//	GET  /healthz  データベースに問い合わせられれば 200 {"status": "ok"}、できなければ 503
+//	GET  /metrics  Options.Metrics（Prometheus の形式の計測値）。nil なら 404
+//	POST /promote  レプリケーションのフォロワーを昇格する（rdbms.DB.Promote）。成功すれば 200 {"status": "promoted"}
+//	GET  /publication?table=users  テーブルの論理レプリケーションのストリーム（rdbms.DB.Publish）を送り続ける
+//
+// /query の応答は {"columns": [{"name": "id", "type": "INT"}, ...], "rows": [[1, "a"], ...],
+// "row_count": 1} で、行を返さない文なら {"rows_affected": 1} だけになる。行は読みながら書くので、
+// 途中で文が失敗した場合は 200 のまま最後に "error" を付ける。最初から失敗した場合は
+// {"error": {"code": "unique", "message": "..."}} を返す。code は wire の Error のコードと同じで、
+// HTTP の状態コードは httpStatus で決める。
+//
+// /query の要求に Accept: application/vnd.apache.arrow.stream を付けると、行を返す文の結果を
+// Arrow IPC のストリーム形式（rdbms.Rows.WriteArrow）で返す。本体の "batch_size" で1つの
+// レコードバッチの行の数を指定できる（既定は1024）。途中で文が失敗した場合は、ストリームの
+// 終わりの印を書かずに、トレーラーの Minirdb-Error に {"code": ..., "message": ...} を付ける。
+//
+// 値は JSON の数値、文字列、真偽値、null で表す。BLOB は base64 の文字列、TIMESTAMP は RFC 3339 の
+// 文字列、有限でない実数は "NaN" などの文字列にする。引数の文字列は、推論した引数の型が TEXT
+// 以外ならその型に変換する（BLOB なら base64 として読む）。
+//
+// 要求ごとに別のセッションで実行するので、BEGIN と COMMIT で複数の要求をまとめることはできない。
+// Options.Auth を指定すると /query と /metrics と /promote と /publication は Basic 認証を求める（/healthz は
+// 求めない）。/promote は読み取りだけを許可したユーザーには 403 を返す。
+// 要求ごとに認証するので、パスワードのハッシュを計算する時間が要求ごとにかかる。
+
+// maxQueryBody は /query の要求の本体の大きさの上限です。
+const maxQueryBody = 16 << 20
+
+// queryRequest は /query の要求の本体です。
+type queryRequest struct {
+	SQL       string `json:"sql"`
+	Params    []any  `json:"params"`
+	BatchSize int    `json:"batch_size"`
+}
+
+// errorTrailer は Arrow の形式の応答で、途中で失敗した文のエラーを返すトレーラーです。
+const errorTrailer = "Minirdb-Error"
+
+// httpError は失敗した要求の応答の error です。
+type httpError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// httpColumn は /query の応答の列です。
+type httpColumn struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+// httpStatuses は Error のコードごとの HTTP の状態コードです。ないコードは 500 にします。
+var httpStatuses = map[string]int{
+	wire.CodeAuth:          http.StatusUnauthorized,
+	wire.CodeSyntax:        http.StatusBadRequest,
+	wire.CodeInvalid:       http.StatusBadRequest,
+	wire.CodeProtocol:      http.StatusBadRequest,
+	wire.CodeConstraint:    http.StatusConflict,
+	wire.CodeUnique:        http.StatusConflict,
+	wire.CodeNotNull:       http.StatusConflict,
+	wire.CodeForeignKey:    http.StatusConflict,
+	wire.CodeLocked:        http.StatusConflict,
+	wire.CodeDeadlock:      http.StatusConflict,
+	wire.CodeSerialization: http.StatusConflict,
+	wire.CodeReadOnly:      http.StatusForbidden,
+	wire.CodeCanceled:      http.StatusServiceUnavailable,
+	wire.CodeConnDone:      http.StatusServiceUnavailable,
+}
+
+// httpStatus は Error のコード code に対応する HTTP の状態コードを返します。
+func httpStatus(code string) int {
+	if st, ok := httpStatuses[code]; ok {
+		return st
+	}
+	return http.StatusInternalServerError
+}
+
+// ListenAndServeHTTP は TCP のアドレス addr で接続を待ち、ServeHTTPOn を呼びます。
+func (s *Server) ListenAndServeHTTP(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.ServeHTTPOn(l)
+}
+
+// ServeHTTPOn は Serve と同じですが、l で HTTP の要求を受け付けて Handler で答えます。
+// Options.TLSConfig があれば HTTPS で受け付けます。
+func (s *Server) ServeHTTPOn(l net.Listener) error {
+	return s.serveHTTP(l, &http.Server{Handler: s.Handler()})
+}
+
+// serveHTTP は hs で l の HTTP の要求を受け付けます。hs は Close で閉じます。
+func (s *Server) serveHTTP(l net.Listener, hs *http.Server) error {
+	hs.BaseContext = func(net.Listener) context.Context { return s.ctx }
+	hs.ReadHeaderTimeout = 10 * time.Second
+	hs.ErrorLog = slog.NewLogLogger(s.logger.Handler(), slog.LevelWarn)
+	hs.TLSConfig = s.opts.TLSConfig
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		l.Close()
+		return ErrServerClosed
+	}
+	if s.https == nil {
+		s.https = make(map[*http.Server]struct{})
+	}
+	s.https[hs] = struct{}{}
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.https, hs)
+		s.mu.Unlock()
+	}()
+
+	var err error
+	if hs.TLSConfig != nil {
+		err = hs.ServeTLS(l, "", "") // 証明書は TLSConfig にある
+	} else {
+		err = hs.Serve(l)
+	}
+	if errors.Is(err, http.ErrServerClosed) {
+		return ErrServerClosed
+	}
+	return err
+}
+
+// Handler は HTTP の API のハンドラを返します。ほかの HTTP サーバーに組み込むときに使います。
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /query", s.auth(s.handleQuery))
+	mux.HandleFunc("GET /healthz", s.handleHealth)
+	mux.HandleFunc("GET /metrics", s.auth(s.handleMetrics))
+	mux.HandleFunc("POST /promote", s.auth(s.handlePromote))
+	mux.HandleFunc("GET /publication", s.auth(s.handlePublication))
+	return s.enter(mux)
+}
+
+// enter は要求を扱う間、Close が待つようにします。Close した後の要求には 503 を返します。
+func (s *Server) enter(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			writeError(w, http.StatusServiceUnavailable, wire.CodeConnDone, ErrServerClosed.Error())
+			return
+		}
+		s.wg.Add(1)
+		s.mu.Unlock()
+		defer s.wg.Done()
+		h.ServeHTTP(w, r)
+	})
+}
+
+// auth は Options.Auth を指定していれば、Basic 認証に成功した要求だけを h に渡します。h には
+// ユーザーに読み取りだけを許可しているかも渡します。
+func (s *Server) auth(h func(w http.ResponseWriter, r *http.Request, readOnly bool)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.opts.Auth == nil {
+			h(w, r, false)
+			return
+		}
+		user, password, ok := r.BasicAuth()
+		if !ok {
+			w.Header().Set("WWW-Authenticate", `Basic realm="minirdb"`)
+			writeError(w, http.StatusUnauthorized, wire.CodeAuth, "authentication required")
+			return
+		}
+		readOnly, err := s.opts.Auth(user, password)
+		if err != nil {
+			s.logger.Warn("request rejected", "remote", r.RemoteAddr, "user", user, "err", err)
+			w.Header().Set("WWW-Authenticate", `Basic realm="minirdb"`)
+			writeError(w, http.StatusUnauthorized, wire.CodeAuth, "authentication failed")
+			return
+		}
+		h(w, r, readOnly)
+	}
+}
+
+// handleHealth はデータベースに問い合わせられるかを返します。
+func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	rows, err := s.db.QueryContext(ctx, "SELECT 1")
+	if err == nil {
+		for rows.Next() {
+		}
+		err = rows.Err()
+		rows.Close()
+	}
+	if err != nil {
+		writeError(w, http.StatusServiceUnavailable, code(err), err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// handleMetrics は Options.Metrics の計測値を返します。
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request, _ bool) {
+	if s.opts.Metrics == nil {
+		http.NotFound(w, r)
+		return
+	}
+	s.opts.Metrics.ServeHTTP(w, r)
+}
+
+// handlePromote はレプリケーションのフォロワーのデータベースを昇格します。
+func (s *Server) handlePromote(w http.ResponseWriter, r *http.Request, readOnly bool) {
+	if readOnly {
+		writeError(w, http.StatusForbidden, wire.CodeReadOnly, "promotion requires write access")
+		return
+	}
+	if err := s.db.Promote(); err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, rdbms.ErrNotFollower) {
+			status = http.StatusConflict
+		}
+		writeError(w, status, code(err), err.Error())
+		return
+	}
+	s.logger.Info("database promoted", "remote", r.RemoteAddr)
+	writeJSON(w, http.StatusOK, map[string]string{"status": "promoted"})
+}
+
+// handleQuery は本体の SQL 文を1つ実行して、結果を JSON で返します。readOnly なら読み取り専用の
+// トランザクションで実行します。
+func (s *Server) handleQuery(w http.ResponseWriter, r *http.Request, readOnly bool) {
+	var req queryRequest
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxQueryBody))
+	dec.UseNumber()
+	if err := dec.Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, wire.CodeProtocol, fmt.Sprintf("invalid request body: %v", err))
+		return
+	}
+	if req.SQL == "" {
+		writeError(w, http.StatusBadRequest, wire.CodeProtocol, "missing sql")
+		return
+	}
+
+	conn, err := s.db.Conn(r.Context())
+	if err != nil {
+		writeStmtError(w, err)
+		return
+	}
+	defer conn.Close()
+	conn.SetTxOptions(rdbms.TxOptions{ReadOnly: readOnly})
+	st, err := conn.Prepare(req.SQL)
+	if err != nil {
+		writeStmtError(w, err)
+		return
+	}
+	args, err := httpParams(st, req.Params)
+	if err != nil {
+		writeStmtError(w, err)
+		return
+	}
+	if !st.ReturnsRows() {
+		n, err := st.ExecContext(r.Context(), args...)
+		if err != nil {
+			writeStmtError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]int64{"rows_affected": n})
+		return
+	}
+	rows, err := st.QueryContext(r.Context(), args...)
+	if err != nil {
+		writeStmtError(w, err)
+		return
+	}
+	defer rows.Close()
+	if acceptsArrow(r) {
+		writeArrow(w, rows, req.BatchSize)
+		return
+	}
+	writeRows(w, rows)
+}
+
+// acceptsArrow は要求の Accept に Arrow IPC のストリーム形式があるかを返します。
+func acceptsArrow(r *http.Request) bool {
+	for _, v := range r.Header.Values("Accept") {
+		for _, part := range strings.Split(v, ",") {
+			if mt, _, err := mime.ParseMediaType(part); err == nil && mt == arrow.MIMEType {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// writeArrow は結果の行を size 行ずつのレコードバッチにして、Arrow IPC のストリーム形式で書きます。
+func writeArrow(w http.ResponseWriter, rows *rdbms.Rows, size int) {
+	aw := &arrowWriter{w: w}
+	err := rows.WriteArrow(aw, size)
+	switch {
+	case err == nil:
+	case !aw.started:
+		writeStmtError(w, err) // 何も送っていなければ、エラーを応答にできる
+	default:
+		b, _ := json.Marshal(httpError{Code: code(err), Message: err.Error()})
+		w.Header().Set(errorTrailer, string(b))
+	}
+}
+
+// arrowWriter は最初に書くときに Arrow の形式の応答のヘッダを設定する http.ResponseWriter です。
+type arrowWriter struct {
+	w       http.ResponseWriter
+	started bool
+}
+
+func (aw *arrowWriter) Write(p []byte) (int, error) {
+	if !aw.started {
+		aw.w.Header().Set("Content-Type", arrow.MIMEType)
+		aw.w.Header().Set("Trailer", errorTrailer)
+		aw.started = true
+	}
+	return aw.w.Write(p)
+}
+
+// writeRows は結果の行を読みながら書きます。
+func writeRows(w http.ResponseWriter, rows *rdbms.Rows) {
+	cols := make([]httpColumn, 0, len(rows.ColumnTypes()))
+	for _, ct := range rows.ColumnTypes() {
+		cols = append(cols, httpColumn{Name: ct.Name, Type: ct.DatabaseTypeName})
+	}
+	b, err := json.Marshal(cols)
+	if err != nil {
+		writeStmtError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	buf := bytes.NewBuffer(nil)
+	buf.WriteString(`{"columns":`)
+	buf.Write(b)
+	buf.WriteString(`,"rows":[`)
+	var n int64
+	for rows.Next() {
+		if n > 0 {
+			buf.WriteByte(',')
+		}
+		appendRow(buf, rows.Values())
+		n++
+		// 溜まった行を送る。書き込みに失敗すればクライアントは切断しているので、残りの行は読まない
+		if buf.Len() >= 32<<10 {
+			if _, err := w.Write(buf.Bytes()); err != nil {
+				return
+			}
+			buf.Reset()
+		}
+	}
+	fmt.Fprintf(buf, `],"row_count":%d`, n)
+	if err := rows.Err(); err != nil {
+		b, _ := json.Marshal(httpError{Code: code(err), Message: err.Error()})
+		buf.WriteString(`,"error":`)
+		buf.Write(b)
+	}
+	buf.WriteString("}\n")
+	w.Write(buf.Bytes())
+}
+
+// appendRow は行の値を JSON の配列として buf に書きます。
+func appendRow(buf *bytes.Buffer, vals []any) {
+	buf.WriteByte('[')
+	for i, v := range vals {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		switch x := v.(type) {
+		case float64:
+			if math.IsInf(x, 0) || math.IsNaN(x) {
+				v = strconv.FormatFloat(x, 'g', -1, 64)
+			}
+		case time.Time:
+			v = x.UTC().Format(time.RFC3339Nano)
+		}
+		// 値は数値、文字列、真偽値、[]byte（base64）、nil なので、変換に失敗しない
+		b, _ := json.Marshal(v)
+		buf.Write(b)
+	}
+	buf.WriteByte(']')
+}
+
+// httpParams は /query の引数を文 st の引数の Go の値にします。
+func httpParams(st *rdbms.Stmt, params []any) ([]any, error) {
+	typs, err := st.ParamTypes()
+	if err != nil {
+		return nil, err
+	}
+	args := make([]any, len(params))
+	for i, p := range params {
+		t := types.Text
+		if i < len(typs) && typs[i] != "" {
+			if t, err = types.Parse(typs[i]); err != nil {
+				return nil, err
+			}
+		}
+		if args[i], err = httpParam(p, t); err != nil {
+			return nil, &paramError{n: i + 1, err: err}
+		}
+	}
+	return args, nil
+}
+
+// httpParam は JSON の値 p を、型 t の引数の Go の値にします。
+func httpParam(p any, t types.Type) (any, error) {
+	switch x := p.(type) {
+	case nil, bool:
+		return x, nil
+	case json.Number:
+		if i, err := x.Int64(); err == nil {
+			return i, nil
+		}
+		return x.Float64()
+	case string:
+		switch t {
+		case types.Text:
+			return x, nil
+		case types.Blob:
+			return base64.StdEncoding.DecodeString(x)
+		}
+		v, err := types.Cast(types.NewText(x), t)
+		if err != nil {
+			return nil, err
+		}
+		return v.Go(), nil
+	}
+	return nil, errors.New("arrays and objects are not supported")
+}
+
+// paramError は /query の引数を変換できなかった場合のエラーです。
+type paramError struct {
+	n   int
+	err error
+}
+
+func (e *paramError) Error() string { return fmt.Sprintf("param %d: %v", e.n, e.err) }
+
+func (e *paramError) Unwrap() error { return e.err }
+
+// writeStmtError は文のエラー err を返します。
+func writeStmtError(w http.ResponseWriter, err error) {
+	c := code(err)
+	if pe := (*paramError)(nil); errors.As(err, &pe) {
+		c = wire.CodeInvalid
+	}
+	writeError(w, httpStatus(c), c, err.Error())
+}
+
+// writeError は Error のコード code とメッセージ msg を状態コード status で返します。
+func writeError(w http.ResponseWriter, status int, code, msg string) {
+	writeJSON(w, status, map[string]httpError{"error": {Code: code, Message: msg}})
+}
+
+// writeJSON は v を JSON にして状態コード status で返します。
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
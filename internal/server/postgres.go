@@ -0,0 +1,1098 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/k-sml/go-rdbms"
+	"github.com/k-sml/go-rdbms/internal/sql/lexer"
+	"github.com/k-sml/go-rdbms/internal/types"
+	"github.com/k-sml/go-rdbms/internal/wire"
+)
+
+// PostgreSQL のプロトコル
+//
+// psql や pgx などの PostgreSQL のクライアントから接続できるように、フロントエンド/バックエンド
+// プロトコルの版 3.0 のうち次のものを扱う。
+//
+//   - 起動: Options.TLSConfig があれば SSL の要求を受けて（'S'）TLS で続け、SSL を要求しない
+//     接続は拒否する。なければ SSL の要求を断り（'N'）、平文のまま続ける。GSS の暗号化の要求は
+//     いつも断る。Options.Auth があれば平文のパスワードを要求する。取り消しの要求
+//     （CancelRequest）は実行中の文を取り消す。
+//   - 単純問い合わせ（Query）: セミコロンで区切った文を順に実行し、エラーになった文でやめる。
+//   - 拡張問い合わせ（Parse、Bind、Describe、Execute、Sync、Close、Flush）: エラーになると
+//     Sync まで要求を読み飛ばす。Execute の行の数の上限で止めた結果は、次の Execute で続きを送る。
+//
+// 列と引数の型は次の OID にする。型の決まらない列は text、推論できない引数は 0（未指定）にする。
+// 値はテキスト形式とバイナリ形式のどちらでも受け渡せる。
+//
+//	INT int4   BIGINT int8   REAL float8   TEXT text   BLOB bytea   BOOLEAN bool   TIMESTAMP timestamp
+//
+// pg_catalog などのシステムカタログはないので、psql の \d のようにカタログを読むコマンドは使えない。
+// トランザクションの中で文がエラーになっても、PostgreSQL と違ってトランザクションは続く
+// （ReadyForQuery の状態は 'T' のまま）。
+
+// PostgreSQL の型の OID
+const (
+	oidBool        = 16
+	oidBytea       = 17
+	oidInt8        = 20
+	oidInt2        = 21
+	oidInt4        = 23
+	oidText        = 25
+	oidFloat4      = 700
+	oidFloat8      = 701
+	oidUnknown     = 705
+	oidVarchar     = 1043
+	oidDate        = 1082
+	oidTimestamp   = 1114
+	oidTimestamptz = 1184
+	oidNumeric     = 1700
+)
+
+// pgOIDs は列の型ごとの OID です。
+var pgOIDs = map[string]uint32{
+	"INT":       oidInt4,
+	"BIGINT":    oidInt8,
+	"REAL":      oidFloat8,
+	"TEXT":      oidText,
+	"BLOB":      oidBytea,
+	"BOOLEAN":   oidBool,
+	"TIMESTAMP": oidTimestamp,
+}
+
+// pgSizes は OID ごとの値の大きさです。可変長の型は -1 です。
+var pgSizes = map[uint32]int16{oidBool: 1, oidInt4: 4, oidInt8: 8, oidFloat8: 8, oidTimestamp: 8}
+
+// pgBinarySizes は固定長の型の、バイナリ形式の値の大きさです。
+var pgBinarySizes = map[uint32]int{oidBool: 1, oidInt2: 2, oidInt4: 4, oidFloat4: 4, oidInt8: 8, oidFloat8: 8,
+	oidTimestamp: 8, oidTimestamptz: 8}
+
+// pgParamTypes は引数の OID ごとの、値を変換する先の型です。ない OID の値は文字列のまま渡します。
+var pgParamTypes = map[uint32]types.Type{
+	oidBool:        types.Boolean,
+	oidBytea:       types.Blob,
+	oidInt8:        types.BigInt,
+	oidInt2:        types.BigInt,
+	oidInt4:        types.BigInt,
+	oidFloat4:      types.Real,
+	oidFloat8:      types.Real,
+	oidNumeric:     types.Real,
+	oidDate:        types.Timestamp,
+	oidTimestamp:   types.Timestamp,
+	oidTimestamptz: types.Timestamp,
+}
+
+// SQLSTATE のコード
+var pgStates = map[string]string{
+	wire.CodeConstraint:    "23000", // integrity_constraint_violation
+	wire.CodeUnique:        "23505", // unique_violation
+	wire.CodeNotNull:       "23502", // not_null_violation
+	wire.CodeForeignKey:    "23503", // foreign_key_violation
+	wire.CodeLocked:        "55P03", // lock_not_available
+	wire.CodeDeadlock:      "40P01", // deadlock_detected
+	wire.CodeSerialization: "40001", // serialization_failure
+	wire.CodeReadOnly:      "25006", // read_only_sql_transaction
+	wire.CodeCorrupt:       "XX001", // data_corrupted
+	wire.CodeCanceled:      "57014", // query_canceled
+	wire.CodeTxDone:        "25000", // invalid_transaction_state
+	wire.CodeConnDone:      "08003", // connection_does_not_exist
+	wire.CodeProtocol:      "08P01", // protocol_violation
+	wire.CodeAuth:          "28P01", // invalid_password
+	wire.CodeSyntax:        "42601", // syntax_error
+	wire.CodeInvalid:       "42000", // syntax_error_or_access_rule_violation
+	wire.CodeUnknownStmt:   "26000", // invalid_sql_statement_name
+}
+
+// 起動の要求の番号
+const (
+	pgProtocol3   = 3<<16 | 0
+	pgSSLRequest  = 1234<<16 | 5679
+	pgGSSRequest  = 1234<<16 | 5680
+	pgCancelQuery = 1234<<16 | 5678
+)
+
+// pgEpoch は PostgreSQL の日時のバイナリ形式の基準の時刻です。
+var pgEpoch = time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// pgParameters は起動の後に ParameterStatus で知らせるサーバーの設定です。
+var pgParameters = [][2]string{
+	{"server_version", "16.0 (minirdb)"},
+	{"server_encoding", "UTF8"},
+	{"client_encoding", "UTF8"},
+	{"DateStyle", "ISO, MDY"},
+	{"IntervalStyle", "postgres"},
+	{"TimeZone", "UTC"},
+	{"integer_datetimes", "on"},
+	{"standard_conforming_strings", "on"},
+}
+
+// pgConn は PostgreSQL のプロトコルの1つの接続の状態です。
+type pgConn struct {
+	s    *Server
+	nc   net.Conn // TLS に切り替えた後は TLS の接続
+	r    *bufio.Reader
+	w    *bufio.Writer
+	conn *rdbms.Conn
+	buf  []byte // 送るメッセージ
+	body pgBody // 読んだメッセージの本体
+
+	pid, key uint32 // 取り消しの要求で接続を見分ける番号
+	stmts    map[string]*pgStmt
+	portals  map[string]*pgPortal
+	failed   bool // 拡張問い合わせがエラーになったので、Sync まで要求を読み飛ばす
+	readOnly bool // 認証したユーザーに読み取りだけを許可している
+
+	mu     sync.Mutex
+	cancel context.CancelFunc // 実行中の文を取り消す（実行していなければ nil）
+}
+
+// pgStmt は Parse で準備した文です。
+type pgStmt struct {
+	st     *rdbms.Stmt
+	params []uint32           // 引数の型の OID
+	cols   []rdbms.ColumnType // 結果の列（結果の行を返さない文なら nil）
+	tag    string             // CommandComplete の文の種類
+}
+
+// pgPortal は Bind で引数を与えた文です。
+type pgPortal struct {
+	stmt    *pgStmt
+	args    []any
+	formats []int16     // 結果の列ごとの形式（0 はテキスト、1 はバイナリ）
+	rows    *rdbms.Rows // Execute の行の数の上限で止めた結果
+
+	ctx    context.Context // 文を実行するコンテキスト
+	cancel context.CancelFunc
+}
+
+// servePostgres は PostgreSQL のクライアントの接続 nc を扱います。
+func (s *Server) servePostgres(nc net.Conn) {
+	c := &pgConn{s: s, nc: nc, r: bufio.NewReader(nc), w: bufio.NewWriterSize(nc, 64<<10),
+		stmts: make(map[string]*pgStmt), portals: make(map[string]*pgPortal)}
+	logger := s.logger.With("remote", nc.RemoteAddr().String(), "protocol", "postgres")
+	user, err := c.startup()
+	if err != nil {
+		if !disconnected(err) {
+			logger.Warn("connection rejected", "err", err)
+		}
+		return
+	}
+	if user == "" {
+		return // 取り消しの要求
+	}
+	logger = logger.With("user", user)
+	c.conn, err = s.db.Conn(s.ctx)
+	if err != nil {
+		c.error(err)
+		c.w.Flush()
+		return
+	}
+	defer c.conn.Close()
+	c.conn.SetTxOptions(rdbms.TxOptions{ReadOnly: c.readOnly})
+	s.register(c)
+	defer s.unregister(c)
+	logger.Debug("connection opened")
+	if err := c.loop(); err != nil && !disconnected(err) {
+		logger.Warn("connection closed", "err", err)
+		return
+	}
+	logger.Debug("connection closed")
+}
+
+// register は取り消しの要求で c を探せるようにします。
+func (s *Server) register(c *pgConn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.backends == nil {
+		s.backends = make(map[uint32]*pgConn)
+	}
+	s.backends[c.pid] = c
+}
+
+func (s *Server) unregister(c *pgConn) {
+	s.mu.Lock()
+	delete(s.backends, c.pid)
+	s.mu.Unlock()
+}
+
+// cancelQuery は番号が pid と key の接続で実行中の文を取り消します。
+func (s *Server) cancelQuery(pid, key uint32) {
+	s.mu.Lock()
+	c := s.backends[pid]
+	s.mu.Unlock()
+	if c == nil || c.key != key {
+		return
+	}
+	c.mu.Lock()
+	if c.cancel != nil {
+		c.cancel()
+	}
+	c.mu.Unlock()
+}
+
+// startup は起動の要求を読んでクライアントを認証し、ユーザー名を返します。取り消しの要求なら
+// 文を取り消して空のユーザー名を返します。
+func (c *pgConn) startup() (string, error) {
+	for {
+		b, err := c.readStartup()
+		if err != nil {
+			return "", err
+		}
+		d := pgBody{b: b}
+		switch code := d.int32(); code {
+		case pgSSLRequest:
+			if err := c.startTLS(); err != nil {
+				return "", err
+			}
+			continue
+		case pgGSSRequest:
+			if _, err := c.w.Write([]byte{'N'}); err != nil {
+				return "", err
+			}
+			if err := c.w.Flush(); err != nil {
+				return "", err
+			}
+			continue
+		case pgCancelQuery:
+			pid, key := d.int32(), d.int32()
+			if d.err == nil {
+				c.s.cancelQuery(pid, key)
+			}
+			return "", nil
+		case pgProtocol3:
+			if _, ok := c.nc.(*tls.Conn); !ok && c.s.opts.TLSConfig != nil {
+				c.fatal(wire.CodeAuth, "SSL connection is required")
+				return "", errors.New("client did not request SSL")
+			}
+		default:
+			err := fmt.Errorf("unsupported frontend protocol %d.%d", code>>16, code&0xffff)
+			c.fatal(wire.CodeProtocol, err.Error())
+			return "", err
+		}
+		params := make(map[string]string)
+		for {
+			k := d.string()
+			if k == "" || d.err != nil {
+				break
+			}
+			params[k] = d.string()
+		}
+		if d.err != nil {
+			c.fatal(wire.CodeProtocol, d.err.Error())
+			return "", d.err
+		}
+		user := params["user"]
+		if user == "" {
+			c.fatal(wire.CodeProtocol, "no user name specified")
+			return "", errors.New("no user name specified")
+		}
+		if err := c.authenticate(user); err != nil {
+			return "", err
+		}
+		return user, c.ready()
+	}
+}
+
+// startTLS は SSL の要求に答えます。Options.TLSConfig があれば 'S' を送って TLS のハンドシェイクを
+// し、以降は TLS の接続で読み書きします。なければ 'N' を送って平文のまま続けます。
+func (c *pgConn) startTLS() error {
+	cfg := c.s.opts.TLSConfig
+	_, secure := c.nc.(*tls.Conn)
+	if cfg == nil || secure {
+		if _, err := c.w.Write([]byte{'N'}); err != nil {
+			return err
+		}
+		return c.w.Flush()
+	}
+	if c.r.Buffered() > 0 {
+		// ハンドシェイクの前に送った平文は、中間者が差し込んだものかもしれない
+		return errors.New("unexpected data before the TLS handshake")
+	}
+	if _, err := c.w.Write([]byte{'S'}); err != nil {
+		return err
+	}
+	if err := c.w.Flush(); err != nil {
+		return err
+	}
+	tc := tls.Server(c.nc, cfg)
+	if err := tc.HandshakeContext(c.s.ctx); err != nil {
+		return err
+	}
+	c.nc = tc
+	c.r = bufio.NewReader(tc)
+	c.w = bufio.NewWriterSize(tc, 64<<10)
+	return nil
+}
+
+// authenticate は Options.Auth があれば平文のパスワードを要求して調べます。
+func (c *pgConn) authenticate(user string) error {
+	if auth := c.s.opts.Auth; auth != nil {
+		b := pgMsg(c.buf, 'R')
+		b = pgInt32(b, 3) // AuthenticationCleartextPassword
+		if err := c.send(b); err != nil {
+			return err
+		}
+		if err := c.w.Flush(); err != nil {
+			return err
+		}
+		typ, body, err := c.read()
+		if err != nil {
+			return err
+		}
+		if typ != 'p' {
+			c.fatal(wire.CodeProtocol, "expected a password message")
+			return fmt.Errorf("expected a password message, got %q", typ)
+		}
+		d := pgBody{b: body}
+		if c.readOnly, err = auth(user, d.string()); err != nil {
+			c.fatal(wire.CodeAuth, fmt.Sprintf("password authentication failed for user %q", user))
+			return fmt.Errorf("user %s: %w", user, err)
+		}
+	}
+	return c.send(pgInt32(pgMsg(c.buf, 'R'), 0)) // AuthenticationOk
+}
+
+// ready は認証の後に、サーバーの設定と取り消しの要求に使う番号を送ります。
+func (c *pgConn) ready() error {
+	for _, p := range pgParameters {
+		b := pgMsg(c.buf, 'S')
+		b = pgString(b, p[0])
+		c.buf = pgString(b, p[1])
+		if err := c.send(c.buf); err != nil {
+			return err
+		}
+	}
+	var k [8]byte
+	rand.Read(k[:])
+	c.pid = binary.BigEndian.Uint32(k[:4]) &^ (1 << 31)
+	c.key = binary.BigEndian.Uint32(k[4:])
+	b := pgMsg(c.buf, 'K')
+	b = pgInt32(b, c.pid)
+	if err := c.send(pgInt32(b, c.key)); err != nil {
+		return err
+	}
+	return c.readyForQuery()
+}
+
+// readyForQuery は ReadyForQuery を送ります。
+func (c *pgConn) readyForQuery() error {
+	status := byte('I')
+	if c.conn != nil && c.conn.InTx() {
+		status = 'T'
+	}
+	if err := c.send(append(pgMsg(c.buf, 'Z'), status)); err != nil {
+		return err
+	}
+	return c.w.Flush()
+}
+
+// loop は Terminate を受け取るか接続が切れるまで要求を処理します。
+func (c *pgConn) loop() error {
+	for {
+		typ, body, err := c.read()
+		if err != nil {
+			return err
+		}
+		if typ == 'X' {
+			return nil
+		}
+		if c.failed && typ != 'S' {
+			continue
+		}
+		c.body = pgBody{b: body}
+		switch typ {
+		case 'Q':
+			err = c.query()
+		case 'P':
+			err = c.parse()
+		case 'B':
+			err = c.bind()
+		case 'D':
+			err = c.describe()
+		case 'E':
+			err = c.execute()
+		case 'C':
+			err = c.close()
+		case 'H':
+			err = c.w.Flush()
+		case 'S':
+			c.failed = false
+			c.closePortal("")
+			err = c.readyForQuery()
+		default:
+			err = c.protocolError(fmt.Errorf("unexpected message %q", typ))
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// query は単純問い合わせの文を順に実行します。
+func (c *pgConn) query() error {
+	sql := c.body.string()
+	if c.body.err != nil {
+		return c.protocolError(c.body.err)
+	}
+	stmts, err := lexer.Split(sql)
+	if err != nil {
+		c.error(err)
+		return c.readyForQuery()
+	}
+	if len(stmts) == 0 {
+		if err := c.send(pgMsg(c.buf, 'I')); err != nil { // EmptyQueryResponse
+			return err
+		}
+		return c.readyForQuery()
+	}
+	for _, s := range stmts {
+		st, err := c.conn.Prepare(s.SQL)
+		if err != nil {
+			c.error(err)
+			break
+		}
+		p := &pgPortal{stmt: &pgStmt{st: st, tag: commandTag(s.SQL)}}
+		ok, err := c.run(p, 0, true)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			break
+		}
+	}
+	return c.readyForQuery()
+}
+
+// parse は文を準備します。
+func (c *pgConn) parse() error {
+	d := &c.body
+	name, sql := d.string(), d.string()
+	oids := make([]uint32, d.int16())
+	for i := range oids {
+		oids[i] = d.int32()
+	}
+	if d.err != nil {
+		return c.protocolError(d.err)
+	}
+	ps, err := c.prepare(sql, oids)
+	if err != nil {
+		return c.fail(err)
+	}
+	c.stmts[name] = ps
+	return c.send(pgMsg(c.buf, '1')) // ParseComplete
+}
+
+// prepare は sql を準備し、引数の型と結果の列を決めます。oids はクライアントが指定した引数の型で、
+// 0 の引数は推論した型にします。
+func (c *pgConn) prepare(sql string, oids []uint32) (*pgStmt, error) {
+	st, err := c.conn.Prepare(sql)
+	if err != nil {
+		return nil, err
+	}
+	ps := &pgStmt{st: st, params: make([]uint32, st.NumParams()), tag: commandTag(sql)}
+	names, err := st.ParamTypes()
+	if err != nil {
+		return nil, err
+	}
+	for i, name := range names {
+		if i < len(oids) && oids[i] != 0 {
+			ps.params[i] = oids[i]
+		} else {
+			ps.params[i] = pgOIDs[name]
+		}
+	}
+	if ps.cols, err = st.ColumnTypes(); err != nil {
+		return nil, err
+	}
+	return ps, nil
+}
+
+// bind は準備した文に引数を与えてポータルを作ります。
+func (c *pgConn) bind() error {
+	d := &c.body
+	portal, name := d.string(), d.string()
+	formats := make([]int16, d.int16())
+	for i := range formats {
+		formats[i] = int16(d.int16())
+	}
+	raw := make([][]byte, d.int16())
+	for i := range raw {
+		if n := int32(d.int32()); n >= 0 {
+			raw[i] = d.bytes(int(n))
+			if raw[i] == nil {
+				raw[i] = []byte{}
+			}
+		}
+	}
+	results := make([]int16, d.int16())
+	for i := range results {
+		results[i] = int16(d.int16())
+	}
+	if d.err != nil {
+		return c.protocolError(d.err)
+	}
+	ps, ok := c.stmts[name]
+	if !ok {
+		return c.failCode(wire.CodeUnknownStmt, fmt.Sprintf("prepared statement %q does not exist", name))
+	}
+	if len(raw) != len(ps.params) {
+		return c.fail(fmt.Errorf("bind message supplies %d parameters, but prepared statement %q requires %d", len(raw), name, len(ps.params)))
+	}
+	p := &pgPortal{stmt: ps, args: make([]any, len(raw)), formats: make([]int16, len(ps.cols))}
+	for i, b := range raw {
+		if b == nil {
+			continue
+		}
+		v, err := decodeParam(ps.params[i], format(formats, i), b)
+		if err != nil {
+			return c.fail(fmt.Errorf("parameter $%d: %w", i+1, err))
+		}
+		p.args[i] = v
+	}
+	for i := range p.formats {
+		p.formats[i] = format(results, i)
+	}
+	c.closePortal(portal)
+	c.portals[portal] = p
+	return c.send(pgMsg(c.buf, '2')) // BindComplete
+}
+
+// format は形式の並び fs の i 番目の形式を返します。1つだけなら全部に、なければテキスト形式を使います。
+func format(fs []int16, i int) int16 {
+	switch {
+	case len(fs) == 1:
+		return fs[0]
+	case i < len(fs):
+		return fs[i]
+	}
+	return 0
+}
+
+// describe は準備した文かポータルの、引数と結果の列を送ります。
+func (c *pgConn) describe() error {
+	d := &c.body
+	kind, name := d.byte(), d.string()
+	if d.err != nil {
+		return c.protocolError(d.err)
+	}
+	switch kind {
+	case 'S':
+		ps, ok := c.stmts[name]
+		if !ok {
+			return c.failCode(wire.CodeUnknownStmt, fmt.Sprintf("prepared statement %q does not exist", name))
+		}
+		b := pgInt16(pgMsg(c.buf, 't'), uint16(len(ps.params))) // ParameterDescription
+		for _, oid := range ps.params {
+			b = pgInt32(b, oid)
+		}
+		if err := c.send(b); err != nil {
+			return err
+		}
+		return c.rowDescription(ps.cols, nil)
+	case 'P':
+		p, ok := c.portals[name]
+		if !ok {
+			return c.failCode(wire.CodeUnknownStmt, fmt.Sprintf("portal %q does not exist", name))
+		}
+		return c.rowDescription(p.stmt.cols, p.formats)
+	}
+	return c.protocolError(fmt.Errorf("invalid describe target %q", kind))
+}
+
+// execute はポータルの文を実行します。
+func (c *pgConn) execute() error {
+	d := &c.body
+	name, max := d.string(), int64(d.int32())
+	if d.err != nil {
+		return c.protocolError(d.err)
+	}
+	p, ok := c.portals[name]
+	if !ok {
+		return c.failCode(wire.CodeUnknownStmt, fmt.Sprintf("portal %q does not exist", name))
+	}
+	ok, err := c.run(p, max, false)
+	if err == nil && !ok {
+		c.failed = true
+	}
+	return err
+}
+
+// close は準備した文かポータルを閉じます。
+func (c *pgConn) close() error {
+	d := &c.body
+	kind, name := d.byte(), d.string()
+	if d.err != nil {
+		return c.protocolError(d.err)
+	}
+	switch kind {
+	case 'S':
+		delete(c.stmts, name)
+	case 'P':
+		c.closePortal(name)
+	default:
+		return c.protocolError(fmt.Errorf("invalid close target %q", kind))
+	}
+	return c.send(pgMsg(c.buf, '3')) // CloseComplete
+}
+
+// closePortal はポータルと、その途中まで読んだ結果を閉じます。
+func (c *pgConn) closePortal(name string) {
+	if p, ok := c.portals[name]; ok {
+		if p.rows != nil {
+			p.rows.Close()
+			p.cancel()
+		}
+		delete(c.portals, name)
+	}
+}
+
+// run はポータルの文を実行し、結果を送ります。max が 0 でなければ max 行を送ったところで止め、
+// 次の呼び出しで続きを送ります。describe なら結果の行の前に RowDescription を送ります（単純問い合わせ）。
+// 文がエラーになれば ErrorResponse を送って false を返し、接続への書き込みに失敗した場合だけ
+// エラーを返します。
+func (c *pgConn) run(p *pgPortal, max int64, describe bool) (bool, error) {
+	// 結果を読み終えるまで、文は取り消しの要求と Close で取り消せる
+	if p.rows == nil {
+		p.ctx, p.cancel = context.WithCancel(c.s.ctx)
+	}
+	c.mu.Lock()
+	c.cancel = p.cancel
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		c.cancel = nil
+		c.mu.Unlock()
+		if p.rows == nil {
+			p.cancel()
+		}
+	}()
+
+	st := p.stmt.st
+	if !st.ReturnsRows() {
+		n, err := st.ExecContext(p.ctx, p.args...)
+		if err != nil {
+			c.error(err)
+			return false, nil
+		}
+		return true, c.commandComplete(p.stmt.tag, n)
+	}
+	if p.rows == nil {
+		rows, err := st.QueryContext(p.ctx, p.args...)
+		if err != nil {
+			c.error(err)
+			return false, nil
+		}
+		p.rows = rows
+		if describe {
+			if p.stmt.cols == nil {
+				p.stmt.cols = rows.ColumnTypes()
+				p.formats = make([]int16, len(p.stmt.cols))
+			}
+			if err := c.rowDescription(p.stmt.cols, p.formats); err != nil {
+				return false, err
+			}
+		}
+	}
+	rows := p.rows
+	var n int64
+	for max <= 0 || n < max {
+		if !rows.Next() {
+			break
+		}
+		if err := c.dataRow(p, rows.Values()); err != nil {
+			rows.Close()
+			p.rows = nil
+			return false, err
+		}
+		n++
+	}
+	if max > 0 && n >= max {
+		// 上限まで送ったので、残りは次の Execute で送る
+		return true, c.send(pgMsg(c.buf, 's')) // PortalSuspended
+	}
+	p.rows = nil
+	if err := rows.Err(); err != nil {
+		rows.Close()
+		c.error(err)
+		return false, nil
+	}
+	rows.Close()
+	return true, c.commandComplete("SELECT", n)
+}
+
+// rowDescription は結果の列 cols を RowDescription で送ります。cols が nil なら NoData を送ります。
+func (c *pgConn) rowDescription(cols []rdbms.ColumnType, formats []int16) error {
+	if cols == nil {
+		return c.send(pgMsg(c.buf, 'n'))
+	}
+	b := pgInt16(pgMsg(c.buf, 'T'), uint16(len(cols)))
+	for i, col := range cols {
+		oid := columnOID(col)
+		size, ok := pgSizes[oid]
+		if !ok {
+			size = -1
+		}
+		b = pgString(b, col.Name)
+		b = pgInt32(b, 0) // テーブルの OID
+		b = pgInt16(b, 0) // 列の番号
+		b = pgInt32(b, oid)
+		b = pgInt16(b, uint16(size))
+		b = pgInt32(b, math.MaxUint32) // 型の修飾子（-1）
+		b = pgInt16(b, uint16(format(formats, i)))
+	}
+	c.buf = b
+	return c.send(b)
+}
+
+// columnOID は列の型の OID を返します。型の決まらない列は text にします。
+func columnOID(col rdbms.ColumnType) uint32 {
+	if oid, ok := pgOIDs[col.DatabaseTypeName]; ok {
+		return oid
+	}
+	return oidText
+}
+
+// dataRow は行の値を DataRow で送ります。
+func (c *pgConn) dataRow(p *pgPortal, vals []any) error {
+	b := pgInt16(pgMsg(c.buf, 'D'), uint16(len(vals)))
+	for i, v := range vals {
+		if v == nil {
+			b = pgInt32(b, math.MaxUint32) // NULL（-1）
+			continue
+		}
+		at := len(b)
+		b = pgInt32(b, 0)
+		oid := uint32(oidText)
+		if i < len(p.stmt.cols) {
+			oid = columnOID(p.stmt.cols[i])
+		}
+		if format(p.formats, i) == 1 {
+			b = appendBinary(b, oid, v)
+		} else {
+			b = appendText(b, v)
+		}
+		binary.BigEndian.PutUint32(b[at:], uint32(len(b)-at-4))
+	}
+	c.buf = b
+	return c.send(b)
+}
+
+// commandComplete は文の種類 tag と行の数 n を CommandComplete で送ります。
+func (c *pgConn) commandComplete(tag string, n int64) error {
+	switch tag {
+	case "INSERT":
+		tag = fmt.Sprintf("INSERT 0 %d", n)
+	case "SELECT", "UPDATE", "DELETE":
+		tag = fmt.Sprintf("%s %d", tag, n)
+	}
+	c.buf = pgString(pgMsg(c.buf, 'C'), tag)
+	return c.send(c.buf)
+}
+
+// commandTag は SQL 文の先頭のキーワードから CommandComplete の文の種類を決めます。
+func commandTag(sql string) string {
+	l := lexer.New(sql)
+	var words []string
+	for len(words) < 3 {
+		tok, err := l.Next()
+		if err != nil || tok.Kind != lexer.Keyword && tok.Kind != lexer.Ident {
+			break
+		}
+		words = append(words, strings.ToUpper(tok.Text))
+	}
+	if len(words) == 0 {
+		return ""
+	}
+	switch words[0] {
+	case "CREATE", "DROP", "ALTER":
+		// CREATE UNIQUE INDEX は CREATE INDEX、CREATE TABLE IF ... は CREATE TABLE にする
+		for _, w := range words[1:] {
+			if w != "UNIQUE" {
+				return words[0] + " " + w
+			}
+		}
+	case "WITH", "EXPLAIN", "PRAGMA", "VALUES":
+		return "SELECT"
+	case "START":
+		return "BEGIN"
+	case "END":
+		return "COMMIT"
+	}
+	return words[0]
+}
+
+// error は文のエラー err を ErrorResponse で送ります。
+func (c *pgConn) error(err error) {
+	c.errorResponse("ERROR", code(err), err.Error())
+}
+
+// fail は拡張問い合わせのエラー err を送り、Sync まで要求を読み飛ばします。
+func (c *pgConn) fail(err error) error {
+	return c.failCode(code(err), err.Error())
+}
+
+func (c *pgConn) failCode(code, msg string) error {
+	c.failed = true
+	return c.errorResponse("ERROR", code, msg)
+}
+
+// protocolError はプロトコルの誤りを送り、接続を閉じるためのエラーを返します。
+func (c *pgConn) protocolError(err error) error {
+	c.fatal(wire.CodeProtocol, err.Error())
+	return err
+}
+
+// fatal は接続を閉じる前のエラーを送ります。
+func (c *pgConn) fatal(code, msg string) {
+	c.errorResponse("FATAL", code, msg)
+	c.w.Flush()
+}
+
+func (c *pgConn) errorResponse(severity, code, msg string) error {
+	state, ok := pgStates[code]
+	if !ok {
+		state = "XX000" // internal_error
+	}
+	b := pgMsg(c.buf, 'E')
+	b = pgString(append(b, 'S'), severity)
+	b = pgString(append(b, 'V'), severity)
+	b = pgString(append(b, 'C'), state)
+	b = pgString(append(b, 'M'), msg)
+	c.buf = append(b, 0)
+	return c.send(c.buf)
+}
+
+// readStartup は起動の要求を読みます。起動の要求には種類のバイトがありません。
+func (c *pgConn) readStartup() ([]byte, error) {
+	var hdr [4]byte
+	if _, err := io.ReadFull(c.r, hdr[:]); err != nil {
+		return nil, err
+	}
+	n := int(binary.BigEndian.Uint32(hdr[:]))
+	if n < 8 || n > 10000 {
+		return nil, fmt.Errorf("invalid startup packet length %d", n)
+	}
+	b := make([]byte, n-4)
+	_, err := io.ReadFull(c.r, b)
+	return b, err
+}
+
+// read はメッセージを読み、種類と本体を返します。
+func (c *pgConn) read() (byte, []byte, error) {
+	var hdr [5]byte
+	if _, err := io.ReadFull(c.r, hdr[:]); err != nil {
+		return 0, nil, err
+	}
+	n := int(binary.BigEndian.Uint32(hdr[1:]))
+	if n < 4 || n-4 > wire.MaxMessageSize {
+		return 0, nil, fmt.Errorf("invalid message length %d", n)
+	}
+	b := make([]byte, n-4)
+	if _, err := io.ReadFull(c.r, b); err != nil {
+		return 0, nil, err
+	}
+	return hdr[0], b, nil
+}
+
+// send は pgMsg で始めたメッセージ b の長さを埋めて書きます。
+func (c *pgConn) send(b []byte) error {
+	binary.BigEndian.PutUint32(b[1:5], uint32(len(b)-1))
+	c.buf = b
+	_, err := c.w.Write(b)
+	return err
+}
+
+// pgMsg は b を空にして、種類 typ のメッセージを始めます。長さは send で埋めます。
+func pgMsg(b []byte, typ byte) []byte { return append(b[:0], typ, 0, 0, 0, 0) }
+
+func pgString(b []byte, s string) []byte { return append(append(b, s...), 0) }
+
+func pgInt16(b []byte, v uint16) []byte { return binary.BigEndian.AppendUint16(b, v) }
+
+func pgInt32(b []byte, v uint32) []byte { return binary.BigEndian.AppendUint32(b, v) }
+
+// pgBody はメッセージの本体を先頭から読みます。読めなければ err を記録します。
+type pgBody struct {
+	b   []byte
+	err error
+}
+
+func (d *pgBody) next(n int) []byte {
+	if d.err != nil || n < 0 || len(d.b) < n {
+		d.err = wire.ErrMalformed
+		return nil
+	}
+	p := d.b[:n]
+	d.b = d.b[n:]
+	return p
+}
+
+func (d *pgBody) byte() byte {
+	if p := d.next(1); p != nil {
+		return p[0]
+	}
+	return 0
+}
+
+func (d *pgBody) int16() uint16 {
+	if p := d.next(2); p != nil {
+		return binary.BigEndian.Uint16(p)
+	}
+	return 0
+}
+
+func (d *pgBody) int32() uint32 {
+	if p := d.next(4); p != nil {
+		return binary.BigEndian.Uint32(p)
+	}
+	return 0
+}
+
+func (d *pgBody) bytes(n int) []byte { return d.next(n) }
+
+// string は 0 で終わる文字列を読みます。
+func (d *pgBody) string() string {
+	i := -1
+	if d.err == nil {
+		i = strings.IndexByte(string(d.b), 0)
+	}
+	if i < 0 {
+		d.err = wire.ErrMalformed
+		return ""
+	}
+	s := string(d.b[:i])
+	d.b = d.b[i+1:]
+	return s
+}
+
+// appendText は値 v をテキスト形式で追加します。
+func appendText(b []byte, v any) []byte {
+	switch x := v.(type) {
+	case int64:
+		return strconv.AppendInt(b, x, 10)
+	case float64:
+		switch {
+		case math.IsInf(x, 1):
+			return append(b, "Infinity"...)
+		case math.IsInf(x, -1):
+			return append(b, "-Infinity"...)
+		case math.IsNaN(x):
+			return append(b, "NaN"...)
+		}
+		return strconv.AppendFloat(b, x, 'g', -1, 64)
+	case string:
+		return append(b, x...)
+	case []byte:
+		b = append(b, `\x`...)
+		return hex.AppendEncode(b, x)
+	case bool:
+		if x {
+			return append(b, 't')
+		}
+		return append(b, 'f')
+	case time.Time:
+		return x.UTC().AppendFormat(b, types.TimestampLayout)
+	}
+	return fmt.Append(b, v)
+}
+
+// appendBinary は値 v を型 oid のバイナリ形式で追加します。値が型に合わなければテキスト形式で
+// 追加します（text と bytea のバイナリ形式は中身のバイト列そのものです）。
+func appendBinary(b []byte, oid uint32, v any) []byte {
+	switch x := v.(type) {
+	case int64:
+		switch oid {
+		case oidInt4:
+			return binary.BigEndian.AppendUint32(b, uint32(int32(x)))
+		case oidInt8:
+			return binary.BigEndian.AppendUint64(b, uint64(x))
+		case oidFloat8:
+			return binary.BigEndian.AppendUint64(b, math.Float64bits(float64(x)))
+		}
+	case float64:
+		if oid == oidFloat8 {
+			return binary.BigEndian.AppendUint64(b, math.Float64bits(x))
+		}
+	case bool:
+		if oid == oidBool && x {
+			return append(b, 1)
+		}
+		if oid == oidBool {
+			return append(b, 0)
+		}
+	case time.Time:
+		if oid == oidTimestamp {
+			return binary.BigEndian.AppendUint64(b, uint64(x.UnixMicro()-pgEpoch.UnixMicro()))
+		}
+	case []byte:
+		if oid == oidBytea {
+			return append(b, x...)
+		}
+	}
+	return appendText(b, v)
+}
+
+// decodeParam は型 oid、形式 format の引数の値を Go の値にします。
+func decodeParam(oid uint32, format int16, p []byte) (any, error) {
+	if format == 1 {
+		return decodeBinary(oid, p)
+	}
+	s := string(p)
+	t, ok := pgParamTypes[oid]
+	if !ok {
+		return s, nil
+	}
+	if t == types.Blob {
+		if h, ok := strings.CutPrefix(s, `\x`); ok {
+			return hex.DecodeString(h)
+		}
+		return []byte(s), nil
+	}
+	v, err := types.Cast(types.NewText(s), t)
+	if err != nil {
+		return nil, err
+	}
+	return v.Go(), nil
+}
+
+// decodeBinary はバイナリ形式の引数の値を Go の値にします。
+func decodeBinary(oid uint32, p []byte) (any, error) {
+	if size, ok := pgBinarySizes[oid]; ok && len(p) != size {
+		return nil, fmt.Errorf("invalid binary value of %d bytes for type %d", len(p), oid)
+	}
+	switch oid {
+	case oidBool:
+		return p[0] != 0, nil
+	case oidInt2:
+		return int64(int16(binary.BigEndian.Uint16(p))), nil
+	case oidInt4:
+		return int64(int32(binary.BigEndian.Uint32(p))), nil
+	case oidInt8:
+		return int64(binary.BigEndian.Uint64(p)), nil
+	case oidFloat4:
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(p))), nil
+	case oidFloat8:
+		return math.Float64frombits(binary.BigEndian.Uint64(p)), nil
+	case oidTimestamp, oidTimestamptz:
+		return time.UnixMicro(pgEpoch.UnixMicro() + int64(binary.BigEndian.Uint64(p))).UTC(), nil
+	case oidBytea:
+		return append([]byte{}, p...), nil
+	case 0, oidText, oidVarchar, oidUnknown:
+		return string(p), nil
+	}
+	return nil, fmt.Errorf("unsupported binary parameter type %d", oid)
+}
@@ -0,0 +1,137 @@
+package server
+
+import (
+	"cmp"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/k-sml/go-rdbms"
+	"github.com/k-sml/go-rdbms/internal/wire"
+)
+
+// 論理レプリケーション
+//
+// 公開する側のサーバーは HTTP の API の GET /publication?table=users&table=orders で、
+// rdbms.DB.Publish のストリームを1行に1つの JSON のメッセージ（application/x-ndjson）として
+// 送り続ける。読み取りだけを許可したユーザーにも送る。受け取る側のサーバーは Subscribe でその URL に
+// 接続し、rdbms.DB.Subscribe で自分のデータベースに適用する。接続が切れると接続し直し、
+// スナップショットから受け取り直す。ストリームは JSON なので、curl などで受け取ってほかのシステムに
+// 流すこともできる。
+
+// SubscribeOptions は Subscribe で公開する側のサーバーに接続する設定です。
+type SubscribeOptions struct {
+	// User と Password は公開する側の HTTP の API の Basic 認証のユーザーの名前とパスワードです。
+	// User が空なら認証しません。
+	User     string
+	Password string
+	// TLSConfig は https の URL に接続するときの TLS の設定です。nil なら既定の設定です。
+	TLSConfig *tls.Config
+	// Retry は接続が切れてから接続し直すまでの時間です。0 なら 1 秒です。
+	Retry time.Duration
+}
+
+// Subscribe は URL url（GET /publication の URL）からストリームを受け取り、サーバーのデータベースに
+// 適用し続けます。接続が切れると SubscribeOptions.Retry の後に接続し直します。Close すると
+// ErrServerClosed を返します。
+func (s *Server) Subscribe(url string, opts SubscribeOptions) error {
+	retry := cmp.Or(opts.Retry, defaultFollowRetry)
+	client := &http.Client{Transport: &http.Transport{
+		Proxy:           http.ProxyFromEnvironment,
+		TLSClientConfig: opts.TLSConfig,
+	}}
+	defer client.CloseIdleConnections()
+	logger := s.logger.With("publisher", url)
+	for {
+		err := s.subscribeOnce(client, url, &opts)
+		if s.ctx.Err() != nil {
+			return ErrServerClosed
+		}
+		logger.Warn("subscription interrupted", "err", err, "retry", retry)
+		select {
+		case <-s.ctx.Done():
+			return ErrServerClosed
+		case <-time.After(retry):
+		}
+	}
+}
+
+// subscribeOnce は url に1回接続し、接続が切れるまでストリームを適用します。
+func (s *Server) subscribeOnce(client *http.Client, url string, opts *SubscribeOptions) error {
+	req, err := http.NewRequestWithContext(s.ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	if opts.User != "" {
+		req.SetBasicAuth(opts.User, opts.Password)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 4<<10))
+		return fmt.Errorf("publisher returned %s: %s", resp.Status, strings.TrimSpace(string(b)))
+	}
+	s.logger.Info("subscribed", "publisher", url)
+	return s.db.Subscribe(s.ctx, resp.Body)
+}
+
+// handlePublication は ?table= のテーブルの論理レプリケーションのストリームを、接続が切れるか
+// Close するまで送ります。table はカンマで区切って複数指定することもできます。
+func (s *Server) handlePublication(w http.ResponseWriter, r *http.Request, _ bool) {
+	var tables []string
+	for _, v := range r.URL.Query()["table"] {
+		for _, name := range strings.Split(v, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				tables = append(tables, name)
+			}
+		}
+	}
+	if len(tables) == 0 {
+		writeError(w, http.StatusBadRequest, wire.CodeProtocol, "missing table")
+		return
+	}
+	logger := s.logger.With("remote", r.RemoteAddr, "tables", tables)
+	fw := &flushWriter{w: w, rc: http.NewResponseController(w)}
+	logger.Info("publication started")
+	err := s.db.Publish(r.Context(), fw, tables...)
+	switch {
+	case !fw.started:
+		// 何も送っていなければ、エラーを応答にできる
+		status := httpStatus(code(err))
+		if errors.Is(err, rdbms.ErrNotLeader) {
+			status = http.StatusConflict
+		}
+		writeError(w, status, code(err), err.Error())
+	case s.ctx.Err() != nil:
+	case r.Context().Err() != nil:
+		logger.Info("publication stopped: subscriber disconnected")
+	default:
+		logger.Warn("publication stopped", "err", err)
+	}
+}
+
+// flushWriter は書いたものをすぐにクライアントに送る http.ResponseWriter です。
+type flushWriter struct {
+	w       http.ResponseWriter
+	rc      *http.ResponseController
+	started bool // 応答のヘッダを送った
+}
+
+func (fw *flushWriter) Write(p []byte) (int, error) {
+	if !fw.started {
+		fw.w.Header().Set("Content-Type", "application/x-ndjson")
+		fw.started = true
+	}
+	n, err := fw.w.Write(p)
+	if err != nil {
+		return n, err
+	}
+	return n, fw.rc.Flush()
+}
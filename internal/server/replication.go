@@ -0,0 +1,162 @@
+package server
+
+import (
+	"cmp"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/k-sml/go-rdbms"
+	"github.com/k-sml/go-rdbms/internal/wire"
+)
+
+// ストリーミングレプリケーション
+//
+// フォロワーのサーバーは Follow でリーダーのサーバーに internal/wire のプロトコルで接続し、
+// ログインしてから Replicate を送る。リーダーはその接続で rdbms.DB.Replicate のストリームを
+// 送り続け、フォロワーは rdbms.DB.Follow で適用する。接続が切れるとフォロワーは接続し直し、
+// スナップショットから受け取り直す。フォロワーは読み取りだけを受け付け、HTTP の API の
+// POST /promote（http.go）か rdbms.DB.Promote で昇格すると Follow は終わる。
+
+// FollowOptions はフォロワーがリーダーに接続する設定です。
+type FollowOptions struct {
+	// User と Password はリーダーにログインするユーザーの名前とパスワードです。リーダーは
+	// 書き込みを許可したユーザーにだけストリームを送ります。
+	User     string
+	Password string
+	// TLSConfig はリーダーへの接続を暗号化する TLS の設定です。ServerName を空にすると、アドレスの
+	// ホスト名を使います。nil なら暗号化しません。
+	TLSConfig *tls.Config
+	// Retry は接続が切れてから接続し直すまでの時間です。0 なら 1 秒です。
+	Retry time.Duration
+}
+
+// defaultFollowRetry は FollowOptions.Retry を指定しなかった場合に接続し直すまでの時間です。
+const defaultFollowRetry = time.Second
+
+// Follow はアドレス addr のリーダーに接続してストリームを受け取り、サーバーのデータベースに
+// 適用し続けます。接続が切れると FollowOptions.Retry の後に接続し直します。データベースを昇格すると
+// nil を返し、Close すると ErrServerClosed を返します。データベースは rdbms.Options.Follower で
+// 開いていなければなりません。
+func (s *Server) Follow(addr string, opts FollowOptions) error {
+	retry := cmp.Or(opts.Retry, defaultFollowRetry)
+	logger := s.logger.With("leader", addr)
+	for {
+		err := s.followOnce(addr, &opts)
+		switch {
+		case s.ctx.Err() != nil:
+			return ErrServerClosed
+		case err == nil:
+			logger.Info("replication stopped: promoted")
+			return nil
+		case errors.Is(err, rdbms.ErrNotFollower):
+			return err
+		}
+		logger.Warn("replication interrupted", "err", err, "retry", retry)
+		select {
+		case <-s.ctx.Done():
+			return ErrServerClosed
+		case <-time.After(retry):
+		}
+	}
+}
+
+// followOnce はリーダーに1回接続し、接続が切れるまでストリームを適用します。
+func (s *Server) followOnce(addr string, opts *FollowOptions) error {
+	d := net.Dialer{Timeout: 10 * time.Second}
+	nc, err := d.DialContext(s.ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	if !s.track(nc) {
+		nc.Close()
+		return ErrServerClosed
+	}
+	defer s.wg.Done()
+	defer s.untrack(nc)
+	if cfg := opts.TLSConfig; cfg != nil {
+		if cfg.ServerName == "" {
+			host, _, err := net.SplitHostPort(addr)
+			if err != nil {
+				host = addr
+			}
+			cfg = cfg.Clone()
+			cfg.ServerName = host
+		}
+		tc := tls.Client(nc, cfg)
+		if err := tc.HandshakeContext(s.ctx); err != nil {
+			return err
+		}
+		nc = tc
+	}
+	if err := startReplication(nc, opts.User, opts.Password); err != nil {
+		return err
+	}
+	s.logger.Info("following leader", "leader", addr)
+	return s.db.Follow(s.ctx, nc)
+}
+
+// startReplication は nc でリーダーにログインし、Replicate を送ります。リーダーは Replicate を
+// 受け取るまで何も送らないので、ストリームは nc から読み始められます。
+func startReplication(nc net.Conn, user, password string) error {
+	w := wire.NewWriter(nc)
+	b := wire.AppendUint16(nil, wire.Version)
+	b = wire.AppendString(b, user)
+	b = wire.AppendString(b, password)
+	if err := w.Write(wire.Startup, b); err != nil {
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	// AuthOK の後にリーダーは何も送らないので、読みすぎることはない
+	typ, body, err := wire.NewReader(nc).Read()
+	if err != nil {
+		return err
+	}
+	d := wire.NewBody(body)
+	switch typ {
+	case wire.AuthOK:
+	case wire.Error:
+		code, msg := d.String(), d.String()
+		return fmt.Errorf("leader rejected login: %s (%s)", msg, code)
+	default:
+		return fmt.Errorf("unexpected message %q", typ)
+	}
+	if err := w.Write(wire.Replicate, nil); err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+// replicate はストリーミングレプリケーションのフォロワーに、接続が切れるまでストリームを送ります。
+// 読み取りだけを許可したユーザーには Error を送って接続を閉じます。
+func (c *session) replicate() error {
+	if c.readOnly {
+		c.sendError(wire.CodeAuth, "replication requires write access")
+		return errors.New("replication requires write access")
+	}
+	logger := c.s.logger.With("remote", c.nc.RemoteAddr().String())
+	ctx, cancel := context.WithCancel(c.s.ctx)
+	defer cancel()
+	go func() {
+		// フォロワーは何も送らないので、読めたら切断か誤り
+		c.r.Read()
+		cancel()
+	}()
+	// 送っている途中でも止められるように、取り消したら接続を閉じる
+	defer context.AfterFunc(ctx, func() { c.nc.Close() })()
+	logger.Info("replication started")
+	err := c.s.db.Replicate(ctx, c.nc)
+	switch {
+	case c.s.ctx.Err() != nil:
+	case ctx.Err() != nil:
+		logger.Info("replication stopped: follower disconnected")
+	default:
+		logger.Warn("replication stopped", "err", err)
+	}
+	return nil
+}
@@ -0,0 +1,205 @@
+// Package server はデータベースをネットワーク越しに使えるようにするサーバーです。クライアントとは
+// internal/wire のプロトコル（Serve）か、PostgreSQL のプロトコル（ServePostgres）でやり取りします。
+// ServeHTTPOn は SQL 文を JSON で受け取る HTTP の API を提供します（http.go）。
+// ServeGRPC は api/minirdb/v1 の gRPC の API を提供します（grpc.go）。
+// Options.TLSConfig を指定すると、どのプロトコルも TLS で暗号化します。
+// Follow はほかのサーバーのストリーミングレプリケーションのフォロワーとして動きます（replication.go）。
+// Subscribe はほかのサーバーが HTTP の API で公開するテーブルを受け取り続けます（publication.go）。
+// 接続ごとに rdbms.Conn のセッションを1つ使うので、BEGIN から COMMIT までのトランザクションは
+// 接続ごとに分かれます。
+package server
+
+import (
+	"cmp"
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/k-sml/go-rdbms"
+)
+
+// Options はサーバーの設定です。ゼロ値は既定の設定です。
+type Options struct {
+	// Auth はクライアントが送ったユーザー名とパスワードを調べる関数です。エラーを返すと接続を
+	// 拒否し、readOnly を返すとその接続のトランザクションをすべて読み取り専用にします
+	// （rdbms.DB.Authenticate を使えます）。nil なら誰でも接続できます。
+	Auth func(user, password string) (readOnly bool, err error)
+	// Logger は接続と認証の失敗などを記録するロガーです。nil なら何も記録しません。
+	Logger *slog.Logger
+	// Metrics は HTTP の API の /metrics で返す計測値のハンドラです（metrics.NewPrometheus を
+	// rdbms.Options.Metrics と共有します）。nil なら /metrics は 404 を返します。
+	Metrics http.Handler
+	// TLSConfig は接続を暗号化する TLS の設定です。ClientCAs と ClientAuth を設定すると、
+	// クライアントの証明書も確かめます（相互 TLS）。nil なら暗号化しません。
+	TLSConfig *tls.Config
+	// BackupDir は gRPC の Backup が複製を書くディレクトリです。要求ではこの中のファイル名だけを
+	// 指定できます。空なら Backup を受け付けません。
+	BackupDir string
+	// GRPCSessionTimeout は gRPC の Begin で作ったセッションに要求が来ないまま、セッションを閉じるまでの
+	// 時間です。過ぎるとトランザクションをロールバックしてセッションを消します。0 なら 5 分です。
+	GRPCSessionTimeout time.Duration
+}
+
+// ErrServerClosed は Close した後の Serve が返すエラーです。
+var ErrServerClosed = errors.New("server closed")
+
+// Server は接続を受け付けて、クライアントの要求を db で実行します。
+type Server struct {
+	db     *rdbms.DB
+	opts   Options
+	logger *slog.Logger
+
+	ctx    context.Context // Close で取り消す、実行中の文のコンテキスト
+	cancel context.CancelFunc
+
+	mu        sync.Mutex
+	closed    bool
+	listeners map[net.Listener]struct{}
+	conns     map[net.Conn]struct{}
+	wg        sync.WaitGroup     // 接続を扱っているゴルーチン
+	backends  map[uint32]*pgConn // PostgreSQL の接続（取り消しの要求で探す）
+	https     map[*http.Server]struct{}
+	// grpcSessions は gRPC の Begin で作ったセッション（grpc.go）です。
+	grpcSessions map[string]*grpcSession
+}
+
+// discardLogger は Logger を指定しなかった場合に使う、何も出力しないロガーです。
+var discardLogger = slog.New(slog.NewTextHandler(io.Discard, &slog.HandlerOptions{Level: slog.Level(math.MaxInt)}))
+
+// New は db を使うサーバーを作ります。接続を受け付けるには Serve を呼びます。
+func New(db *rdbms.DB, opts Options) *Server {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Server{
+		db:        db,
+		opts:      opts,
+		logger:    cmp.Or(opts.Logger, discardLogger),
+		ctx:       ctx,
+		cancel:    cancel,
+		listeners: make(map[net.Listener]struct{}),
+		conns:     make(map[net.Conn]struct{}),
+	}
+}
+
+// ListenAndServe は TCP のアドレス addr で接続を待ち、Serve を呼びます。
+func (s *Server) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// Serve は l で接続を受け付け、接続ごとにゴルーチンを起こして要求を処理します。Close するまで
+// 戻らず、Close した後は ErrServerClosed を返します。l は Serve が閉じます。Options.TLSConfig が
+// あれば、接続を受け付けるとすぐに TLS のハンドシェイクをします。
+func (s *Server) Serve(l net.Listener) error {
+	if s.opts.TLSConfig != nil {
+		l = tls.NewListener(l, s.opts.TLSConfig)
+	}
+	return s.serve(l, s.serveConn)
+}
+
+// ListenAndServePostgres は TCP のアドレス addr で接続を待ち、ServePostgres を呼びます。
+func (s *Server) ListenAndServePostgres(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.ServePostgres(l)
+}
+
+// ServePostgres は Serve と同じですが、クライアントとは PostgreSQL のプロトコルでやり取りします
+// （postgres.go）。TLS には PostgreSQL のクライアントが SSL を要求してから切り替えます。1つの Server で Serve と ServePostgres を同時に呼べます。
+func (s *Server) ServePostgres(l net.Listener) error { return s.serve(l, s.servePostgres) }
+
+// serve は l で接続を受け付け、接続ごとにゴルーチンで handle を呼びます。
+func (s *Server) serve(l net.Listener, handle func(net.Conn)) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		l.Close()
+		return ErrServerClosed
+	}
+	s.listeners[l] = struct{}{}
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.listeners, l)
+		s.mu.Unlock()
+		l.Close()
+	}()
+
+	for {
+		nc, err := l.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				return ErrServerClosed
+			}
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				continue
+			}
+			return err
+		}
+		if !s.track(nc) {
+			nc.Close()
+			return ErrServerClosed
+		}
+		go func() {
+			defer s.wg.Done()
+			defer s.untrack(nc)
+			handle(nc)
+		}()
+	}
+}
+
+// track は接続 nc を Close で閉じる接続に加えます。Close した後なら false を返します。
+func (s *Server) track(nc net.Conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return false
+	}
+	s.conns[nc] = struct{}{}
+	s.wg.Add(1)
+	return true
+}
+
+func (s *Server) untrack(nc net.Conn) {
+	s.mu.Lock()
+	delete(s.conns, nc)
+	s.mu.Unlock()
+	nc.Close()
+}
+
+// Close は接続の受け付けをやめ、実行中の文を取り消してすべての接続を閉じます。接続を扱っている
+// ゴルーチンが終わるまで待つので、戻った後に DB を閉じてかまいません。セッションの
+// トランザクションはロールバックします。
+func (s *Server) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	s.cancel()
+	for l := range s.listeners {
+		l.Close()
+	}
+	for nc := range s.conns {
+		nc.Close()
+	}
+	for hs := range s.https {
+		hs.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+	s.closeGRPCSessions()
+	return nil
+}
@@ -0,0 +1,532 @@
+// Package ast は SQL 文の構文木を定義します。
+//
+// 構文木は parser パッケージが作り、各ノードはソース中の位置を持ちます。
+// 名前の解決や型の検査はまだ行われていないので、存在しないテーブルや列を
+// 参照していてもそのまま表現します。
+package ast
+
+import (
+	"github.com/k-sml/go-rdbms/internal/sql/lexer"
+	"github.com/k-sml/go-rdbms/internal/types"
+)
+
+// Node は構文木のノードです。
+type Node interface {
+	Pos() lexer.Pos
+}
+
+// Stmt は文です。
+type Stmt interface {
+	Node
+	stmt()
+}
+
+// Expr は式です。
+type Expr interface {
+	Node
+	expr()
+}
+
+// At はノードの位置です。ノードに埋め込んで Pos を実装します。
+type At lexer.Pos
+
+// Pos はノードの位置を返します。
+func (a At) Pos() lexer.Pos { return lexer.Pos(a) }
+
+// ---- 文 ----
+
+// Select は SELECT 文です。Compound があれば UNION などの集合演算で、Distinct から Having までは
+// 使わず、ORDER BY と LIMIT は集合演算の結果に対するものです。
+type Select struct {
+	At
+	With     *With     // WITH 句がなければ nil
+	Compound *Compound // 集合演算でなければ nil
+	Distinct bool
+	Columns  []SelectItem
+	From     TableExpr // FROM がなければ nil
+	Where    Expr
+	GroupBy  []Expr
+	Having   Expr
+	OrderBy  []OrderItem
+	Limit    Expr
+	Offset   Expr
+}
+
+// Compound は2つの SELECT の結果の集合演算 Left Op [ALL] Right です。
+type Compound struct {
+	Op          SetOp
+	All         bool
+	Left, Right *Select
+}
+
+// SetOp は集合演算の種類です。
+type SetOp int
+
+const (
+	Union SetOp = iota
+	Intersect
+	Except
+)
+
+func (op SetOp) String() string {
+	switch op {
+	case Intersect:
+		return "INTERSECT"
+	case Except:
+		return "EXCEPT"
+	}
+	return "UNION"
+}
+
+// With は SELECT 文の前の WITH 句です。
+type With struct {
+	At
+	Recursive bool
+	CTEs      []*CTE
+}
+
+// CTE は WITH 句で名前を付けた問い合わせ（共通テーブル式）です。Recursive があれば、
+// 結果は Select UNION [ALL] Recursive で、Recursive の中では自分自身をテーブルとして参照できます。
+type CTE struct {
+	At
+	Name        string
+	Columns     []string // 列の名前。空なら Select の結果の列の名前
+	Materialize Materialize
+	Select      *Select
+	Recursive   *Select
+	UnionAll    bool
+}
+
+// Materialize は CTE の結果を一時的に保存するかの指定です。
+type Materialize int
+
+const (
+	MaterializeDefault Materialize = iota // 参照の数で決める
+	Materialized                          // AS MATERIALIZED
+	NotMaterialized                       // AS NOT MATERIALIZED
+)
+
+// SelectItem は SELECT の結果の列です。Star なら * または Table.* です。
+type SelectItem struct {
+	Expr  Expr
+	Alias string
+	Star  bool
+	Table string // Table.* のテーブル名
+}
+
+// OrderItem は ORDER BY の項目です。
+type OrderItem struct {
+	Expr Expr
+	Desc bool
+}
+
+// TableExpr は FROM に書くテーブルです。
+type TableExpr interface {
+	Node
+	tableExpr()
+}
+
+// TableName はテーブル（またはビュー）の参照です。
+type TableName struct {
+	At
+	Name  string
+	Alias string
+}
+
+// JoinKind は結合の種類です。
+type JoinKind int
+
+const (
+	CrossJoin JoinKind = iota // FROM a, b または CROSS JOIN
+	InnerJoin
+	LeftJoin  // LEFT [OUTER] JOIN
+	RightJoin // RIGHT [OUTER] JOIN
+	FullJoin  // FULL [OUTER] JOIN
+)
+
+// Join は2つのテーブルの結合です。
+type Join struct {
+	At
+	Kind        JoinKind
+	Left, Right TableExpr
+	On          Expr // CrossJoin なら nil
+}
+
+// Insert は INSERT 文です。VALUES の行か、SELECT 文の結果の行を挿入します。
+type Insert struct {
+	At
+	Table   string
+	Columns []string // 省略すると nil（すべての列）
+	Rows    [][]Expr // VALUES の行。Query があれば nil
+	Query   *Select  // INSERT ... SELECT の問い合わせ。VALUES なら nil
+}
+
+// Update は UPDATE 文です。
+type Update struct {
+	At
+	Table string
+	Set   []Assignment
+	Where Expr
+}
+
+// Assignment は UPDATE の SET の項目です。
+type Assignment struct {
+	Column string
+	Value  Expr
+}
+
+// Delete は DELETE 文です。
+type Delete struct {
+	At
+	Table string
+	Where Expr
+}
+
+// CreateTable は CREATE TABLE 文です。
+type CreateTable struct {
+	At
+	Name        string
+	IfNotExists bool
+	Temporary   bool // CREATE TEMP TABLE
+	Columns     []ColumnDef
+	PrimaryKey  []string   // 表制約の PRIMARY KEY (...)
+	Unique      [][]string // 表制約の UNIQUE (...)
+	ForeignKeys []ForeignKeyDef
+	Options     []Option // WITH (...)
+}
+
+// ColumnDef は列の定義です。
+type ColumnDef struct {
+	At
+	Name          string
+	Type          types.Type
+	NotNull       bool
+	PrimaryKey    bool
+	Unique        bool
+	AutoIncrement bool
+	Default       Expr
+	References    *ForeignKeyDef // 列制約の REFERENCES（Columns は空）
+}
+
+// ForeignKeyDef は外部キー制約です。OnDelete と OnUpdate は "CASCADE" や "SET NULL" などで、
+// 省略すると空です。
+type ForeignKeyDef struct {
+	At
+	Columns    []string
+	RefTable   string
+	RefColumns []string
+	OnDelete   string
+	OnUpdate   string
+	Deferred   bool
+}
+
+// Option は WITH (name = value) の格納方法の設定です。
+type Option struct {
+	Name  string
+	Value Expr
+}
+
+// DropTable は DROP TABLE 文です。
+type DropTable struct {
+	At
+	Name     string
+	IfExists bool
+}
+
+// CreateIndex は CREATE INDEX 文です。
+type CreateIndex struct {
+	At
+	Name        string
+	Table       string
+	Columns     []string
+	Unique      bool
+	IfNotExists bool
+}
+
+// DropIndex は DROP INDEX 文です。
+type DropIndex struct {
+	At
+	Name     string
+	IfExists bool
+}
+
+// CreateView は CREATE VIEW 文です。Text は問い合わせ部分のソースです。
+type CreateView struct {
+	At
+	Name    string
+	Columns []string
+	Query   *Select
+	Text    string
+}
+
+// DropView は DROP VIEW 文です。
+type DropView struct {
+	At
+	Name     string
+	IfExists bool
+}
+
+// AlterTable は ALTER TABLE 文です。
+type AlterTable struct {
+	At
+	Table  string
+	Action AlterAction
+}
+
+// AlterAction は ALTER TABLE の操作で、*AddColumn, *DropColumn, *RenameColumn, *RenameTable のいずれかです。
+type AlterAction interface {
+	alterAction()
+}
+
+// AddColumn は ADD [COLUMN] です。
+type AddColumn struct{ Column ColumnDef }
+
+// DropColumn は DROP [COLUMN] です。
+type DropColumn struct{ Name string }
+
+// RenameColumn は RENAME [COLUMN] old TO new です。
+type RenameColumn struct{ Old, New string }
+
+// RenameTable は RENAME TO new です。
+type RenameTable struct{ To string }
+
+// AlterIndex は ALTER INDEX name RENAME TO new 文です。
+type AlterIndex struct {
+	At
+	Name string
+	To   string
+}
+
+// Begin は BEGIN [TRANSACTION] 文です。
+type Begin struct{ At }
+
+// Commit は COMMIT 文です。
+type Commit struct{ At }
+
+// Rollback は ROLLBACK 文です。
+type Rollback struct{ At }
+
+// Explain は EXPLAIN [ANALYZE] 文です。Stmt の実行計画を表示し、Analyze なら実行もします。
+type Explain struct {
+	At
+	Analyze bool
+	Stmt    Stmt
+}
+
+// Analyze は ANALYZE [table] 文です。Table が空ならすべてのテーブルの統計情報を集めます。
+type Analyze struct {
+	At
+	Table string
+}
+
+// Pragma は PRAGMA name 文です。データベースについての情報を行として返します。
+type Pragma struct {
+	At
+	Name string
+}
+
+// Vacuum は VACUUM 文です。すべてのテーブルを書き直して空いたページをファイルから取り除きます。
+// 変更した行の数として、取り除いたページの数を返します。
+type Vacuum struct{ At }
+
+// CreateUser は CREATE USER name PASSWORD 'password' 文です。
+type CreateUser struct {
+	At
+	Name        string
+	Password    string
+	IfNotExists bool
+}
+
+// AlterUser は ALTER USER name PASSWORD 'password' 文です。
+type AlterUser struct {
+	At
+	Name     string
+	Password string
+}
+
+// DropUser は DROP USER 文です。
+type DropUser struct {
+	At
+	Name     string
+	IfExists bool
+}
+
+// Grant は GRANT {READ | WRITE} TO user 文です。WRITE は読み取りも許可します。
+type Grant struct {
+	At
+	User  string
+	Write bool
+}
+
+// Revoke は REVOKE {READ | WRITE} FROM user 文です。READ を取り消すと WRITE も取り消します。
+type Revoke struct {
+	At
+	User  string
+	Write bool
+}
+
+func (*Select) stmt()      {}
+func (*Insert) stmt()      {}
+func (*Update) stmt()      {}
+func (*Delete) stmt()      {}
+func (*CreateTable) stmt() {}
+func (*DropTable) stmt()   {}
+func (*CreateIndex) stmt() {}
+func (*DropIndex) stmt()   {}
+func (*CreateView) stmt()  {}
+func (*DropView) stmt()    {}
+func (*AlterTable) stmt()  {}
+func (*AlterIndex) stmt()  {}
+func (*Begin) stmt()       {}
+func (*Commit) stmt()      {}
+func (*Rollback) stmt()    {}
+func (*Explain) stmt()     {}
+func (*Analyze) stmt()     {}
+func (*Pragma) stmt()      {}
+func (*Vacuum) stmt()      {}
+func (*CreateUser) stmt()  {}
+func (*AlterUser) stmt()   {}
+func (*DropUser) stmt()    {}
+func (*Grant) stmt()       {}
+func (*Revoke) stmt()      {}
+
+func (*TableName) tableExpr() {}
+func (*Join) tableExpr()      {}
+
+func (*AddColumn) alterAction()    {}
+func (*DropColumn) alterAction()   {}
+func (*RenameColumn) alterAction() {}
+func (*RenameTable) alterAction()  {}
+
+// ---- 式 ----
+
+// Literal は定数です。
+type Literal struct {
+	At
+	Value types.Value
+}
+
+// ColumnRef は列の参照です。Table は修飾がなければ空です。
+type ColumnRef struct {
+	At
+	Table  string
+	Column string
+}
+
+// Param は準備した文の引数（? または $1 など）です。Index は 1 から始まる引数の番号です。
+type Param struct {
+	At
+	Index int
+}
+
+// Unary は単項演算です。Op は "-", "+", "NOT" のいずれかです。
+type Unary struct {
+	At
+	Op string
+	X  Expr
+}
+
+// Binary は二項演算です。Op は "+", "-", "*", "/", "%", "||", "=", "<>", "<", "<=", ">", ">=",
+// "AND", "OR" のいずれかです。
+type Binary struct {
+	At
+	Op   string
+	L, R Expr
+}
+
+// IsNull は X IS [NOT] NULL です。
+type IsNull struct {
+	At
+	X   Expr
+	Not bool
+}
+
+// Between は X [NOT] BETWEEN Lo AND Hi です。
+type Between struct {
+	At
+	X, Lo, Hi Expr
+	Not       bool
+}
+
+// InList は X [NOT] IN (List...) です。
+type InList struct {
+	At
+	X    Expr
+	List []Expr
+	Not  bool
+}
+
+// Like は X [NOT] LIKE Pattern [ESCAPE Escape] または X [NOT] GLOB Pattern です。
+// Op は "LIKE" か "GLOB" です。
+type Like struct {
+	At
+	Op         string
+	X, Pattern Expr
+	Escape     Expr // ESCAPE がなければ nil
+	Not        bool
+}
+
+// Call は関数の呼び出しです。Name は大文字です。COUNT(*) では Star が true です。
+type Call struct {
+	At
+	Name string
+	Args []Expr
+	Star bool
+}
+
+// Case は CASE [Operand] WHEN ... THEN ... [ELSE Else] END です。Operand がなければ
+// 各 WHEN の式を条件として、あれば Operand と等しいかで選びます。
+type Case struct {
+	At
+	Operand Expr // 単純 CASE の比較する式。検索 CASE なら nil
+	Whens   []*When
+	Else    Expr // ELSE がなければ nil
+}
+
+// When は CASE の WHEN Cond THEN Result です。
+type When struct {
+	Cond, Result Expr
+}
+
+// Cast は CAST(X AS Type) です。
+type Cast struct {
+	At
+	X    Expr
+	Type types.Type
+}
+
+// Subquery は値を1つ返す副問い合わせ (SELECT ...) です。
+type Subquery struct {
+	At
+	Select *Select
+}
+
+// InSubquery は X [NOT] IN (SELECT ...) です。
+type InSubquery struct {
+	At
+	X      Expr
+	Select *Select
+	Not    bool
+}
+
+// Exists は EXISTS (SELECT ...) です。NOT EXISTS は Unary の NOT で表します。
+type Exists struct {
+	At
+	Select *Select
+}
+
+func (*Literal) expr()    {}
+func (*ColumnRef) expr()  {}
+func (*Param) expr()      {}
+func (*Unary) expr()      {}
+func (*Binary) expr()     {}
+func (*IsNull) expr()     {}
+func (*Between) expr()    {}
+func (*InList) expr()     {}
+func (*Like) expr()       {}
+func (*Call) expr()       {}
+func (*Case) expr()       {}
+func (*Cast) expr()       {}
+func (*Subquery) expr()   {}
+func (*InSubquery) expr() {}
+func (*Exists) expr()     {}
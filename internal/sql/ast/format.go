@@ -0,0 +1,325 @@
+package ast
+
+import (
+	"encoding/hex"
+	"strconv"
+	"strings"
+
+	"github.com/k-sml/go-rdbms/internal/sql/lexer"
+	"github.com/k-sml/go-rdbms/internal/types"
+)
+
+// FormatExpr は式を SQL の文字列にします。結果の列の名前や実行計画の表示に使います。
+// 演算の順序が変わらないように、二項演算の項が演算である場合は括弧で囲みます。
+func FormatExpr(e Expr) string {
+	var b strings.Builder
+	formatExpr(&b, e)
+	return b.String()
+}
+
+// FormatValue は値を SQL の定数として書いた文字列にします。
+func FormatValue(v types.Value) string {
+	switch v.Type() {
+	case types.Null:
+		return "NULL"
+	case types.Text:
+		return "'" + strings.ReplaceAll(v.Text(), "'", "''") + "'"
+	case types.Blob:
+		return "x'" + hex.EncodeToString(v.Blob()) + "'"
+	case types.Boolean:
+		if v.Bool() {
+			return "TRUE"
+		}
+		return "FALSE"
+	case types.Timestamp:
+		return "'" + v.String() + "'"
+	case types.Real:
+		// 整数の定数と区別できるように、小数点か指数を必ず含める
+		s := v.String()
+		if !strings.ContainsAny(s, ".eIN") {
+			s += ".0"
+		}
+		return s
+	}
+	return v.String()
+}
+
+func formatExpr(b *strings.Builder, e Expr) {
+	switch e := e.(type) {
+	case *Literal:
+		b.WriteString(FormatValue(e.Value))
+	case *Param:
+		b.WriteString("$" + strconv.Itoa(e.Index))
+	case *ColumnRef:
+		if e.Table != "" {
+			b.WriteString(lexer.QuoteIdent(e.Table))
+			b.WriteByte('.')
+		}
+		b.WriteString(lexer.QuoteIdent(e.Column))
+	case *Unary:
+		b.WriteString(e.Op)
+		if e.Op == "NOT" {
+			b.WriteByte(' ')
+		}
+		operand(b, e.X)
+	case *Binary:
+		operand(b, e.L)
+		b.WriteString(" " + e.Op + " ")
+		operand(b, e.R)
+	case *IsNull:
+		operand(b, e.X)
+		if e.Not {
+			b.WriteString(" IS NOT NULL")
+		} else {
+			b.WriteString(" IS NULL")
+		}
+	case *Between:
+		operand(b, e.X)
+		if e.Not {
+			b.WriteString(" NOT")
+		}
+		b.WriteString(" BETWEEN ")
+		operand(b, e.Lo)
+		b.WriteString(" AND ")
+		operand(b, e.Hi)
+	case *InList:
+		operand(b, e.X)
+		if e.Not {
+			b.WriteString(" NOT")
+		}
+		b.WriteString(" IN (")
+		exprList(b, e.List)
+		b.WriteByte(')')
+	case *Like:
+		operand(b, e.X)
+		if e.Not {
+			b.WriteString(" NOT")
+		}
+		b.WriteString(" " + e.Op + " ")
+		operand(b, e.Pattern)
+		if e.Escape != nil {
+			b.WriteString(" ESCAPE ")
+			operand(b, e.Escape)
+		}
+	case *Call:
+		b.WriteString(e.Name + "(")
+		if e.Star {
+			b.WriteByte('*')
+		}
+		exprList(b, e.Args)
+		b.WriteByte(')')
+	case *Case:
+		b.WriteString("CASE")
+		if e.Operand != nil {
+			b.WriteByte(' ')
+			formatExpr(b, e.Operand)
+		}
+		for _, w := range e.Whens {
+			b.WriteString(" WHEN ")
+			formatExpr(b, w.Cond)
+			b.WriteString(" THEN ")
+			formatExpr(b, w.Result)
+		}
+		if e.Else != nil {
+			b.WriteString(" ELSE ")
+			formatExpr(b, e.Else)
+		}
+		b.WriteString(" END")
+	case *Cast:
+		b.WriteString("CAST(")
+		formatExpr(b, e.X)
+		b.WriteString(" AS " + e.Type.String() + ")")
+	case *Subquery:
+		b.WriteByte('(')
+		formatSelect(b, e.Select)
+		b.WriteByte(')')
+	case *InSubquery:
+		operand(b, e.X)
+		if e.Not {
+			b.WriteString(" NOT")
+		}
+		b.WriteString(" IN (")
+		formatSelect(b, e.Select)
+		b.WriteByte(')')
+	case *Exists:
+		b.WriteString("EXISTS (")
+		formatSelect(b, e.Select)
+		b.WriteByte(')')
+	}
+}
+
+// FormatSelect は SELECT 文を SQL の文字列にします。
+func FormatSelect(s *Select) string {
+	var b strings.Builder
+	formatSelect(&b, s)
+	return b.String()
+}
+
+func formatSelect(b *strings.Builder, s *Select) {
+	if s.With != nil {
+		formatWith(b, s.With)
+	}
+	if c := s.Compound; c != nil {
+		formatSelect(b, c.Left)
+		b.WriteString(" " + c.Op.String() + " ")
+		if c.All {
+			b.WriteString("ALL ")
+		}
+		formatSelect(b, c.Right)
+	} else {
+		formatCore(b, s)
+	}
+	for i, o := range s.OrderBy {
+		if i == 0 {
+			b.WriteString(" ORDER BY ")
+		} else {
+			b.WriteString(", ")
+		}
+		formatExpr(b, o.Expr)
+		if o.Desc {
+			b.WriteString(" DESC")
+		}
+	}
+	if s.Limit != nil {
+		b.WriteString(" LIMIT ")
+		formatExpr(b, s.Limit)
+	}
+	if s.Offset != nil {
+		b.WriteString(" OFFSET ")
+		formatExpr(b, s.Offset)
+	}
+}
+
+// formatCore は ORDER BY と LIMIT を除いた SELECT を書きます。
+func formatCore(b *strings.Builder, s *Select) {
+	b.WriteString("SELECT ")
+	if s.Distinct {
+		b.WriteString("DISTINCT ")
+	}
+	for i, item := range s.Columns {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		switch {
+		case item.Star && item.Table != "":
+			b.WriteString(lexer.QuoteIdent(item.Table) + ".*")
+		case item.Star:
+			b.WriteByte('*')
+		default:
+			formatExpr(b, item.Expr)
+			if item.Alias != "" {
+				b.WriteString(" AS " + lexer.QuoteIdent(item.Alias))
+			}
+		}
+	}
+	if s.From != nil {
+		b.WriteString(" FROM ")
+		formatFrom(b, s.From)
+	}
+	if s.Where != nil {
+		b.WriteString(" WHERE ")
+		formatExpr(b, s.Where)
+	}
+	if len(s.GroupBy) > 0 {
+		b.WriteString(" GROUP BY ")
+		exprList(b, s.GroupBy)
+	}
+	if s.Having != nil {
+		b.WriteString(" HAVING ")
+		formatExpr(b, s.Having)
+	}
+}
+
+func formatWith(b *strings.Builder, w *With) {
+	b.WriteString("WITH ")
+	if w.Recursive {
+		b.WriteString("RECURSIVE ")
+	}
+	for i, c := range w.CTEs {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		b.WriteString(lexer.QuoteIdent(c.Name))
+		if len(c.Columns) > 0 {
+			b.WriteByte('(')
+			for j, col := range c.Columns {
+				if j > 0 {
+					b.WriteString(", ")
+				}
+				b.WriteString(lexer.QuoteIdent(col))
+			}
+			b.WriteByte(')')
+		}
+		b.WriteString(" AS ")
+		switch c.Materialize {
+		case Materialized:
+			b.WriteString("MATERIALIZED ")
+		case NotMaterialized:
+			b.WriteString("NOT MATERIALIZED ")
+		}
+		b.WriteByte('(')
+		formatSelect(b, c.Select)
+		if c.Recursive != nil {
+			b.WriteString(" UNION ")
+			if c.UnionAll {
+				b.WriteString("ALL ")
+			}
+			formatSelect(b, c.Recursive)
+		}
+		b.WriteByte(')')
+	}
+	b.WriteByte(' ')
+}
+
+// joinKeywords は結合の種類ごとの SQL のキーワードです。
+var joinKeywords = map[JoinKind]string{
+	InnerJoin: " JOIN ", LeftJoin: " LEFT JOIN ", RightJoin: " RIGHT JOIN ", FullJoin: " FULL JOIN ",
+}
+
+func formatFrom(b *strings.Builder, te TableExpr) {
+	switch te := te.(type) {
+	case *TableName:
+		b.WriteString(lexer.QuoteIdent(te.Name))
+		if te.Alias != "" {
+			b.WriteString(" " + lexer.QuoteIdent(te.Alias))
+		}
+	case *Join:
+		formatFrom(b, te.Left)
+		if te.Kind == CrossJoin {
+			b.WriteString(", ")
+			formatFrom(b, te.Right)
+			return
+		}
+		b.WriteString(joinKeywords[te.Kind])
+		formatFrom(b, te.Right)
+		b.WriteString(" ON ")
+		formatExpr(b, te.On)
+	}
+}
+
+// operand は演算の項を書きます。項が演算か負の数なら括弧で囲みます。
+func operand(b *strings.Builder, e Expr) {
+	paren := false
+	switch e := e.(type) {
+	case *Unary, *Binary, *IsNull, *Between, *InList, *Like:
+		paren = true
+	case *Literal:
+		paren = e.Value.Type().IsNumeric() && strings.HasPrefix(e.Value.String(), "-")
+	}
+	if paren {
+		b.WriteByte('(')
+	}
+	formatExpr(b, e)
+	if paren {
+		b.WriteByte(')')
+	}
+}
+
+func exprList(b *strings.Builder, list []Expr) {
+	for i, e := range list {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		formatExpr(b, e)
+	}
+}
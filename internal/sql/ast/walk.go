@@ -0,0 +1,111 @@
+package ast
+
+// WalkExpr は式 e と、その中の式を外側から順に fn に渡します。fn が false を返すと、
+// その式の中の式は渡しません。副問い合わせの中の式はたどりません。
+func WalkExpr(e Expr, fn func(Expr) bool) {
+	if e == nil || !fn(e) {
+		return
+	}
+	switch e := e.(type) {
+	case *Unary:
+		WalkExpr(e.X, fn)
+	case *Binary:
+		WalkExpr(e.L, fn)
+		WalkExpr(e.R, fn)
+	case *IsNull:
+		WalkExpr(e.X, fn)
+	case *Between:
+		WalkExpr(e.X, fn)
+		WalkExpr(e.Lo, fn)
+		WalkExpr(e.Hi, fn)
+	case *InList:
+		WalkExpr(e.X, fn)
+		for _, x := range e.List {
+			WalkExpr(x, fn)
+		}
+	case *Like:
+		WalkExpr(e.X, fn)
+		WalkExpr(e.Pattern, fn)
+		WalkExpr(e.Escape, fn)
+	case *Call:
+		for _, x := range e.Args {
+			WalkExpr(x, fn)
+		}
+	case *Case:
+		WalkExpr(e.Operand, fn)
+		for _, w := range e.Whens {
+			WalkExpr(w.Cond, fn)
+			WalkExpr(w.Result, fn)
+		}
+		WalkExpr(e.Else, fn)
+	case *Cast:
+		WalkExpr(e.X, fn)
+	case *InSubquery:
+		WalkExpr(e.X, fn)
+	}
+}
+
+// Subqueries は s の中に書かれた SELECT 文を、s 自身も含めて外側から順に fn に渡します。
+// 集合演算の両辺、WITH 句の問い合わせ、式の中の副問い合わせをたどります。
+func Subqueries(s *Select, fn func(*Select)) {
+	fn(s)
+	if s.Compound != nil {
+		Subqueries(s.Compound.Left, fn)
+		Subqueries(s.Compound.Right, fn)
+	}
+	if s.With != nil {
+		for _, c := range s.With.CTEs {
+			Subqueries(c.Select, fn)
+			if c.Recursive != nil {
+				Subqueries(c.Recursive, fn)
+			}
+		}
+	}
+	WalkSelect(s, func(e Expr) bool {
+		switch e := e.(type) {
+		case *Subquery:
+			Subqueries(e.Select, fn)
+		case *InSubquery:
+			Subqueries(e.Select, fn)
+		case *Exists:
+			Subqueries(e.Select, fn)
+		}
+		return true
+	})
+}
+
+// WalkSelect は s に書かれた式（結果の列、ON、WHERE、GROUP BY、HAVING、ORDER BY）を WalkExpr で
+// たどります。集合演算の両辺、WITH 句の問い合わせ、副問い合わせの中はたどりません。
+func WalkSelect(s *Select, fn func(Expr) bool) {
+	for _, item := range s.Columns {
+		WalkExpr(item.Expr, fn)
+	}
+	walkFrom(s.From, fn)
+	WalkExpr(s.Where, fn)
+	for _, e := range s.GroupBy {
+		WalkExpr(e, fn)
+	}
+	WalkExpr(s.Having, fn)
+	for _, o := range s.OrderBy {
+		WalkExpr(o.Expr, fn)
+	}
+}
+
+func walkFrom(te TableExpr, fn func(Expr) bool) {
+	if j, ok := te.(*Join); ok {
+		walkFrom(j.Left, fn)
+		walkFrom(j.Right, fn)
+		WalkExpr(j.On, fn)
+	}
+}
+
+// Tables は FROM の te の中のテーブルの参照を左から順に fn に渡します。
+func Tables(te TableExpr, fn func(*TableName)) {
+	switch te := te.(type) {
+	case *TableName:
+		fn(te)
+	case *Join:
+		Tables(te.Left, fn)
+		Tables(te.Right, fn)
+	}
+}
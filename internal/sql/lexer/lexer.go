@@ -0,0 +1,421 @@
+// Package lexer は SQL 文をトークンに分割します。
+//
+// キーワードと識別子は大文字と小文字を区別しません。キーワードは大文字にそろえ、
+// 識別子は書かれたとおりに返します。"..." で囲んだ識別子はキーワードとしては扱いません。
+// 文字列は '...' で囲み、中では ' を2つ重ねて書きます。-- から行末までと /* ... */ はコメントです。
+package lexer
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Kind はトークンの種類です。
+type Kind int
+
+const (
+	EOF     Kind = iota
+	Ident        // 識別子
+	Keyword      // キーワード（Text は大文字）
+	Int          // 整数
+	Float        // 小数
+	String       // 文字列（Text は引用符を外した値）
+	Blob         // x'...' の16進数のバイト列（Text は16進数の部分）
+	Param        // ? または $1 のようなパラメータ
+	Op           // 演算子と記号
+)
+
+var kindNames = [...]string{
+	EOF: "end of input", Ident: "identifier", Keyword: "keyword", Int: "integer", Float: "number",
+	String: "string", Blob: "blob", Param: "parameter", Op: "operator",
+}
+
+func (k Kind) String() string {
+	if int(k) < len(kindNames) {
+		return kindNames[k]
+	}
+	return fmt.Sprintf("Kind(%d)", int(k))
+}
+
+// Pos はソース中の位置です。Line と Col は 1 から数えます（Col は文字単位）。
+type Pos struct {
+	Offset int
+	Line   int
+	Col    int
+}
+
+func (p Pos) String() string { return fmt.Sprintf("line %d, column %d", p.Line, p.Col) }
+
+// Token はトークンです。
+type Token struct {
+	Kind   Kind
+	Text   string
+	Pos    Pos
+	Quoted bool // "..." で囲んだ識別子か
+}
+
+// Is はトークンがキーワードまたは演算子 s かを返します。
+func (t Token) Is(s string) bool {
+	return (t.Kind == Keyword || t.Kind == Op) && t.Text == s
+}
+
+func (t Token) String() string {
+	switch t.Kind {
+	case EOF:
+		return "end of input"
+	case String:
+		return fmt.Sprintf("'%s'", strings.ReplaceAll(t.Text, "'", "''"))
+	case Ident:
+		if t.Quoted {
+			return fmt.Sprintf("%q", t.Text)
+		}
+	}
+	return t.Text
+}
+
+// Error は字句解析または構文解析のエラーです。
+type Error struct {
+	Pos Pos
+	Msg string
+}
+
+func (e *Error) Error() string { return fmt.Sprintf("syntax error at %s: %s", e.Pos, e.Msg) }
+
+// keywords は予約語です。これらは "..." で囲まない限り識別子として使えません。
+var keywords = map[string]bool{}
+
+func init() {
+	for _, k := range strings.Fields(`
+		ADD ALL ALTER ANALYZE AND AS ASC BEGIN BETWEEN BY CASCADE CASE CAST COLUMN COMMIT CONSTRAINT CREATE
+		CROSS DEFAULT DEFERRABLE DEFERRED DELETE DESC DISTINCT DROP ELSE END ESCAPE EXCEPT EXISTS EXPLAIN FALSE FOREIGN FROM
+		FULL GLOB GROUP HAVING IF IN INDEX INITIALLY INNER INSERT INTERSECT INTO IS JOIN KEY LEFT LIKE LIMIT NOT NULL
+		MATERIALIZED OFFSET ON OR ORDER OUTER PRAGMA PRIMARY RECURSIVE REFERENCES RENAME RESTRICT RIGHT ROLLBACK
+		SELECT SET TABLE THEN TO TRANSACTION TRUE UNION UNIQUE UPDATE VACUUM VALUES VIEW WHEN WHERE WITH`) {
+		keywords[k] = true
+	}
+}
+
+// IsKeyword は s が予約語かを返します（大文字と小文字は区別しません）。
+func IsKeyword(s string) bool { return keywords[strings.ToUpper(s)] }
+
+// Keywords はすべての予約語を辞書順に並べて返します。
+func Keywords() []string {
+	out := make([]string, 0, len(keywords))
+	for k := range keywords {
+		out = append(out, k)
+	}
+	slices.Sort(out)
+	return out
+}
+
+// 長いものから順に調べる演算子
+var ops = []string{"<>", "!=", "<=", ">=", "||", "==", "(", ")", ",", ";", ".", "*", "+", "-", "/", "%", "=", "<", ">"}
+
+// Lexer は SQL 文を先頭から順にトークンにします。
+type Lexer struct {
+	src  string
+	off  int
+	line int
+	col  int
+}
+
+// New は src を読む Lexer を作ります。
+func New(src string) *Lexer { return &Lexer{src: src, line: 1, col: 1} }
+
+// Tokenize は src のすべてのトークンを返します。最後のトークンは EOF です。
+func Tokenize(src string) ([]Token, error) {
+	l := New(src)
+	var out []Token
+	for {
+		t, err := l.Next()
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, t)
+		if t.Kind == EOF {
+			return out, nil
+		}
+	}
+}
+
+func (l *Lexer) pos() Pos { return Pos{Offset: l.off, Line: l.line, Col: l.col} }
+
+func (l *Lexer) peek(n int) byte {
+	if l.off+n < len(l.src) {
+		return l.src[l.off+n]
+	}
+	return 0
+}
+
+// advance は n バイト進めます。
+func (l *Lexer) advance(n int) {
+	for end := l.off + n; l.off < end; {
+		r, size := utf8.DecodeRuneInString(l.src[l.off:])
+		l.off += size
+		if r == '\n' {
+			l.line++
+			l.col = 1
+		} else {
+			l.col++
+		}
+	}
+}
+
+func (l *Lexer) errorf(p Pos, format string, args ...any) error {
+	return &Error{Pos: p, Msg: fmt.Sprintf(format, args...)}
+}
+
+// skip は空白とコメントを読み飛ばします。
+func (l *Lexer) skip() error {
+	for l.off < len(l.src) {
+		c := l.src[l.off]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f':
+			l.advance(1)
+		case c == '-' && l.peek(1) == '-':
+			for l.off < len(l.src) && l.src[l.off] != '\n' {
+				l.advance(1)
+			}
+		case c == '/' && l.peek(1) == '*':
+			start := l.pos()
+			end := strings.Index(l.src[l.off+2:], "*/")
+			if end < 0 {
+				return l.errorf(start, "unterminated comment")
+			}
+			l.advance(end + 4)
+		default:
+			return nil
+		}
+	}
+	return nil
+}
+
+// Next は次のトークンを返します。入力の終わりでは EOF を返し続けます。
+func (l *Lexer) Next() (Token, error) {
+	if err := l.skip(); err != nil {
+		return Token{}, err
+	}
+	p := l.pos()
+	if l.off >= len(l.src) {
+		return Token{Kind: EOF, Pos: p}, nil
+	}
+	c := l.src[l.off]
+	switch {
+	case (c == 'x' || c == 'X') && l.peek(1) == '\'':
+		l.advance(1)
+		s, err := l.quoted('\'')
+		if err != nil {
+			return Token{}, err
+		}
+		if len(s)%2 != 0 || strings.Trim(s, "0123456789abcdefABCDEF") != "" {
+			return Token{}, l.errorf(p, "malformed blob literal")
+		}
+		return Token{Kind: Blob, Text: s, Pos: p}, nil
+	case isIdentStart(c):
+		start := l.off
+		for l.off < len(l.src) && isIdentPart(l.src[l.off]) {
+			l.advance(1)
+		}
+		word := l.src[start:l.off]
+		if up := strings.ToUpper(word); keywords[up] {
+			return Token{Kind: Keyword, Text: up, Pos: p}, nil
+		}
+		return Token{Kind: Ident, Text: word, Pos: p}, nil
+	case c == '"' || c == '`':
+		s, err := l.quoted(c)
+		if err != nil {
+			return Token{}, err
+		}
+		if s == "" {
+			return Token{}, l.errorf(p, "empty identifier")
+		}
+		return Token{Kind: Ident, Text: s, Pos: p, Quoted: true}, nil
+	case c == '\'':
+		s, err := l.quoted(c)
+		if err != nil {
+			return Token{}, err
+		}
+		return Token{Kind: String, Text: s, Pos: p}, nil
+	case isDigit(c) || (c == '.' && isDigit(l.peek(1))):
+		return l.number(p)
+	case c == '?':
+		l.advance(1)
+		return Token{Kind: Param, Text: "?", Pos: p}, nil
+	case c == '$' && isDigit(l.peek(1)):
+		start := l.off
+		l.advance(1)
+		for l.off < len(l.src) && isDigit(l.src[l.off]) {
+			l.advance(1)
+		}
+		return Token{Kind: Param, Text: l.src[start:l.off], Pos: p}, nil
+	}
+	for _, op := range ops {
+		if strings.HasPrefix(l.src[l.off:], op) {
+			l.advance(len(op))
+			switch op {
+			case "!=":
+				op = "<>"
+			case "==":
+				op = "="
+			}
+			return Token{Kind: Op, Text: op, Pos: p}, nil
+		}
+	}
+	r, _ := utf8.DecodeRuneInString(l.src[l.off:])
+	return Token{}, l.errorf(p, "unexpected character %q", r)
+}
+
+// quoted は q で囲まれた部分を読み、q を2つ重ねたものを1つにして返します。
+func (l *Lexer) quoted(q byte) (string, error) {
+	p := l.pos()
+	l.advance(1)
+	var b strings.Builder
+	for {
+		i := strings.IndexByte(l.src[l.off:], q)
+		if i < 0 {
+			return "", l.errorf(p, "unterminated quoted string")
+		}
+		b.WriteString(l.src[l.off : l.off+i])
+		l.advance(i + 1)
+		if l.peek(0) != q {
+			return b.String(), nil
+		}
+		b.WriteByte(q)
+		l.advance(1)
+	}
+}
+
+// number は整数または小数（指数表記を含む）を読みます。
+func (l *Lexer) number(p Pos) (Token, error) {
+	start := l.off
+	kind := Int
+	for l.off < len(l.src) && isDigit(l.src[l.off]) {
+		l.advance(1)
+	}
+	if l.peek(0) == '.' {
+		kind = Float
+		l.advance(1)
+		for l.off < len(l.src) && isDigit(l.src[l.off]) {
+			l.advance(1)
+		}
+	}
+	if c := l.peek(0); c == 'e' || c == 'E' {
+		n := 1
+		if s := l.peek(1); s == '+' || s == '-' {
+			n++
+		}
+		if isDigit(l.peek(n)) {
+			kind = Float
+			l.advance(n)
+			for l.off < len(l.src) && isDigit(l.src[l.off]) {
+				l.advance(1)
+			}
+		}
+	}
+	if l.off < len(l.src) && isIdentStart(l.src[l.off]) {
+		return Token{}, l.errorf(p, "malformed number %q", l.src[start:l.off+1])
+	}
+	return Token{Kind: kind, Text: l.src[start:l.off], Pos: p}, nil
+}
+
+func isDigit(c byte) bool { return '0' <= c && c <= '9' }
+
+func isIdentStart(c byte) bool {
+	return c == '_' || ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || c >= utf8.RuneSelf
+}
+
+func isIdentPart(c byte) bool { return isIdentStart(c) || isDigit(c) }
+
+// IsIdent は s を "..." で囲まずに識別子として書けるかを返します。
+func IsIdent(s string) bool {
+	if s == "" || IsKeyword(s) || !isIdentStart(s[0]) {
+		return false
+	}
+	for _, r := range s {
+		if r != '_' && !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// QuoteIdent は s を SQL の識別子として書ける形にします。必要なら "..." で囲みます。
+func QuoteIdent(s string) string {
+	if IsIdent(s) {
+		return s
+	}
+	return `"` + strings.ReplaceAll(s, `"`, `""`) + `"`
+}
+
+// Normalize は SQL 文のトークンを1つの空白で区切って並べた文字列を返します。空白やコメントの違いと、
+// キーワードの大文字と小文字の違いしかない文は、同じ文字列になります。文字列、識別子、数は
+// 書かれた値のままなので、値の異なる文が同じ文字列になることはありません。
+func Normalize(src string) (string, error) {
+	l := New(src)
+	var b strings.Builder
+	for {
+		t, err := l.Next()
+		if err != nil {
+			return "", err
+		}
+		if t.Kind == EOF {
+			return b.String(), nil
+		}
+		if b.Len() > 0 {
+			b.WriteByte(' ')
+		}
+		switch t.Kind {
+		case Blob:
+			b.WriteString("X'" + t.Text + "'")
+		default:
+			b.WriteString(t.String())
+		}
+	}
+}
+
+// Statement は Split で分けた1つの文です。
+type Statement struct {
+	SQL string // 文の SQL。区切りの ; と、前後の空白とコメントは含まない
+	Pos Pos    // 分ける前のソースの中で文が始まる位置
+}
+
+// Split は ; で区切って並べた SQL 文を1つずつに分けます。文字列、"..." で囲んだ識別子、コメントの
+// 中の ; では区切りません。空白とコメントしかない文は除きます。
+func Split(src string) ([]Statement, error) {
+	l := New(src)
+	var out []Statement
+	var start Pos
+	end, empty := 0, true
+	for {
+		t, err := l.Next()
+		if err != nil {
+			return nil, err
+		}
+		if t.Kind == EOF || t.Kind == Op && t.Text == ";" {
+			if !empty {
+				out = append(out, Statement{SQL: src[start.Offset:end], Pos: start})
+			}
+			if t.Kind == EOF {
+				return out, nil
+			}
+			empty = true
+			continue
+		}
+		if empty {
+			start, empty = t.Pos, false
+		}
+		end = l.off
+	}
+}
+
+// Within は start から始まる文の中の位置 p を、その文を含むソース全体の中の位置にします。
+func (p Pos) Within(start Pos) Pos {
+	q := Pos{Offset: start.Offset + p.Offset, Line: start.Line + p.Line - 1, Col: p.Col}
+	if p.Line == 1 {
+		q.Col = start.Col + p.Col - 1
+	}
+	return q
+}
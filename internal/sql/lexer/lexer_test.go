@@ -0,0 +1,72 @@
+package lexer
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestTokenize はトークンの種類と値、位置を確かめます。
+func TestTokenize(t *testing.T) {
+	tests := []struct {
+		src  string
+		want []string // 種類:値
+	}{
+		{"select A from t", []string{"keyword:SELECT", "identifier:A", "keyword:FROM", "identifier:t"}},
+		{`"from" + "a""b"`, []string{"identifier:from", "operator:+", `identifier:a"b`}},
+		{"'it''s' x'00FF'", []string{"string:it's", "blob:00FF"}},
+		{"1 2.5 .5 1e3 1E-2", []string{"integer:1", "number:2.5", "number:.5", "number:1e3", "number:1E-2"}},
+		{"a<=b != c == d || e", []string{
+			"identifier:a", "operator:<=", "identifier:b", "operator:<>", "identifier:c",
+			"operator:=", "identifier:d", "operator:||", "identifier:e",
+		}},
+		{"a -- comment\n/* block\ncomment */ b", []string{"identifier:a", "identifier:b"}},
+		{"", nil},
+	}
+	for _, tt := range tests {
+		toks, err := Tokenize(tt.src)
+		if err != nil {
+			t.Errorf("Tokenize(%q): %v", tt.src, err)
+			continue
+		}
+		if toks[len(toks)-1].Kind != EOF {
+			t.Errorf("Tokenize(%q) does not end with EOF", tt.src)
+		}
+		var got []string
+		for _, tok := range toks[:len(toks)-1] {
+			got = append(got, tok.Kind.String()+":"+tok.Text)
+		}
+		if strings.Join(got, " ") != strings.Join(tt.want, " ") {
+			t.Errorf("Tokenize(%q) = %q, want %q", tt.src, got, tt.want)
+		}
+	}
+
+	toks, err := Tokenize("a\n  bé c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []Pos{{0, 1, 1}, {4, 2, 3}, {8, 2, 6}} {
+		if toks[i].Pos != want {
+			t.Errorf("token %d at %+v, want %+v", i, toks[i].Pos, want)
+		}
+	}
+}
+
+// TestTokenizeErrors は、字句の誤りを位置付きで知らせることを確かめます。
+func TestTokenizeErrors(t *testing.T) {
+	tests := []struct {
+		src, want string
+	}{
+		{"'abc", "line 1, column 1: unterminated"},
+		{"a /* b", "line 1, column 3: unterminated comment"},
+		{`""`, "empty identifier"},
+		{"x'0G'", "malformed blob"},
+		{"12abc", "malformed number"},
+		{"a\n #", "line 2, column 2: unexpected character"},
+	}
+	for _, tt := range tests {
+		_, err := Tokenize(tt.src)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("Tokenize(%q) = %v, want an error containing %q", tt.src, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,520 @@
+package parser
+
+import (
+	"strings"
+
+	"github.com/k-sml/go-rdbms/internal/sql/ast"
+	"github.com/k-sml/go-rdbms/internal/sql/lexer"
+)
+
+// create は CREATE TABLE, CREATE [UNIQUE] INDEX, CREATE VIEW, CREATE USER を読みます。
+func (p *Parser) create() (ast.Stmt, error) {
+	t := p.next()
+	switch {
+	case p.accept("TABLE"):
+		return p.createTable(t.Pos)
+	case p.isWord("TEMP") || p.isWord("TEMPORARY"):
+		if _, err := p.expect("TABLE"); err != nil {
+			return nil, err
+		}
+		s, err := p.createTable(t.Pos)
+		if err != nil {
+			return nil, err
+		}
+		s.Temporary = true
+		return s, nil
+	case p.tok().Is("UNIQUE") || p.tok().Is("INDEX"):
+		return p.createIndex(t.Pos)
+	case p.accept("VIEW"):
+		return p.createView(t.Pos)
+	case p.isWord("USER"):
+		return p.createUser(t.Pos)
+	}
+	return nil, p.unexpected("TABLE, TEMP TABLE, INDEX, VIEW or USER")
+}
+
+// ifNotExists は IF NOT EXISTS を読みます。
+func (p *Parser) ifNotExists() (bool, error) {
+	if !p.accept("IF") {
+		return false, nil
+	}
+	if _, err := p.expect("NOT"); err != nil {
+		return false, err
+	}
+	_, err := p.expect("EXISTS")
+	return err == nil, err
+}
+
+// ifExists は IF EXISTS を読みます。
+func (p *Parser) ifExists() (bool, error) {
+	if !p.accept("IF") {
+		return false, nil
+	}
+	_, err := p.expect("EXISTS")
+	return err == nil, err
+}
+
+func (p *Parser) createTable(pos lexer.Pos) (*ast.CreateTable, error) {
+	s := &ast.CreateTable{At: ast.At(pos)}
+	var err error
+	if s.IfNotExists, err = p.ifNotExists(); err != nil {
+		return nil, err
+	}
+	if s.Name, err = p.name(); err != nil {
+		return nil, err
+	}
+	if _, err := p.expect("("); err != nil {
+		return nil, err
+	}
+	for {
+		if err := p.tableElement(s); err != nil {
+			return nil, err
+		}
+		if !p.accept(",") {
+			break
+		}
+	}
+	if _, err := p.expect(")"); err != nil {
+		return nil, err
+	}
+	if p.accept("WITH") {
+		if s.Options, err = p.options(); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// tableElement は CREATE TABLE の括弧の中の、列の定義または表制約を1つ読みます。
+func (p *Parser) tableElement(s *ast.CreateTable) error {
+	if p.accept("CONSTRAINT") {
+		// 制約の名前は保存しない
+		if _, err := p.ident(); err != nil {
+			return err
+		}
+	}
+	t := p.tok()
+	switch {
+	case p.accept("PRIMARY"):
+		if _, err := p.expect("KEY"); err != nil {
+			return err
+		}
+		if s.PrimaryKey != nil {
+			return p.errorf(t.Pos, "multiple primary keys")
+		}
+		cols, err := p.identList()
+		if err != nil {
+			return err
+		}
+		s.PrimaryKey = cols
+		return nil
+	case p.accept("UNIQUE"):
+		cols, err := p.identList()
+		if err != nil {
+			return err
+		}
+		s.Unique = append(s.Unique, cols)
+		return nil
+	case p.accept("FOREIGN"):
+		if _, err := p.expect("KEY"); err != nil {
+			return err
+		}
+		cols, err := p.identList()
+		if err != nil {
+			return err
+		}
+		fk, err := p.references(t.Pos)
+		if err != nil {
+			return err
+		}
+		fk.Columns = cols
+		s.ForeignKeys = append(s.ForeignKeys, *fk)
+		return nil
+	}
+	col, err := p.columnDef()
+	if err != nil {
+		return err
+	}
+	s.Columns = append(s.Columns, col)
+	return nil
+}
+
+// columnDef は「名前 型 列制約...」を読みます。
+func (p *Parser) columnDef() (ast.ColumnDef, error) {
+	pos := p.tok().Pos
+	name, err := p.ident()
+	if err != nil {
+		return ast.ColumnDef{}, err
+	}
+	typ, err := p.typeName()
+	if err != nil {
+		return ast.ColumnDef{}, err
+	}
+	c := ast.ColumnDef{At: ast.At(pos), Name: name, Type: typ}
+	for {
+		t := p.tok()
+		switch {
+		case p.accept("PRIMARY"):
+			if _, err := p.expect("KEY"); err != nil {
+				return c, err
+			}
+			c.PrimaryKey = true
+		case p.accept("UNIQUE"):
+			c.Unique = true
+		case p.accept("NOT"):
+			if _, err := p.expect("NULL"); err != nil {
+				return c, err
+			}
+			c.NotNull = true
+		case p.accept("NULL"):
+		case p.accept("DEFAULT"):
+			if c.Default, err = p.unary(); err != nil {
+				return c, err
+			}
+		case p.tok().Is("REFERENCES"):
+			if c.References, err = p.references(t.Pos); err != nil {
+				return c, err
+			}
+		case t.Kind == lexer.Ident && strings.EqualFold(t.Text, "AUTOINCREMENT"):
+			p.next()
+			c.AutoIncrement = true
+		default:
+			return c, nil
+		}
+	}
+}
+
+// references は REFERENCES table [(cols)] [ON DELETE action] [ON UPDATE action]
+// [DEFERRABLE INITIALLY DEFERRED] を読みます。
+func (p *Parser) references(pos lexer.Pos) (*ast.ForeignKeyDef, error) {
+	if _, err := p.expect("REFERENCES"); err != nil {
+		return nil, err
+	}
+	fk := &ast.ForeignKeyDef{At: ast.At(pos)}
+	var err error
+	if fk.RefTable, err = p.name(); err != nil {
+		return nil, err
+	}
+	if p.tok().Is("(") {
+		if fk.RefColumns, err = p.identList(); err != nil {
+			return nil, err
+		}
+	}
+	for {
+		switch {
+		case p.accept("ON"):
+			on := p.tok()
+			if !p.accept("DELETE") && !p.accept("UPDATE") {
+				return nil, p.unexpected("DELETE or UPDATE")
+			}
+			action, err := p.action()
+			if err != nil {
+				return nil, err
+			}
+			if on.Text == "DELETE" {
+				fk.OnDelete = action
+			} else {
+				fk.OnUpdate = action
+			}
+		case p.accept("DEFERRABLE"):
+			if _, err := p.expect("INITIALLY"); err != nil {
+				return nil, err
+			}
+			if !p.accept("DEFERRED") && !p.isWord("IMMEDIATE") {
+				return nil, p.unexpected("DEFERRED or IMMEDIATE")
+			}
+			fk.Deferred = p.toks[p.i-1].Is("DEFERRED")
+		default:
+			return fk, nil
+		}
+	}
+}
+
+// action は外部キーの動作 CASCADE, SET NULL, RESTRICT, NO ACTION を読みます。
+func (p *Parser) action() (string, error) {
+	switch {
+	case p.accept("CASCADE"):
+		return "CASCADE", nil
+	case p.accept("RESTRICT"):
+		return "RESTRICT", nil
+	case p.accept("SET"):
+		if _, err := p.expect("NULL"); err != nil {
+			return "", err
+		}
+		return "SET NULL", nil
+	case p.isWord("NO"):
+		if !p.isWord("ACTION") {
+			return "", p.unexpected("ACTION")
+		}
+		return "NO ACTION", nil
+	}
+	return "", p.unexpected("CASCADE, SET NULL, RESTRICT or NO ACTION")
+}
+
+// isWord は現在のトークンが予約語でない語 w なら読み進めて true を返します。
+func (p *Parser) isWord(w string) bool {
+	if t := p.tok(); t.Kind == lexer.Ident && !t.Quoted && strings.EqualFold(t.Text, w) {
+		p.i++
+		return true
+	}
+	return false
+}
+
+// options は WITH に続く (name = value, ...) を読みます。
+func (p *Parser) options() ([]ast.Option, error) {
+	if _, err := p.expect("("); err != nil {
+		return nil, err
+	}
+	var out []ast.Option
+	for {
+		name, err := p.ident()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := p.expect("="); err != nil {
+			return nil, err
+		}
+		var v ast.Expr
+		if t := p.tok(); t.Kind == lexer.Ident {
+			// WITH (layout = row) のように値を識別子で書いてもよい
+			p.next()
+			v = &ast.ColumnRef{At: ast.At(t.Pos), Column: t.Text}
+		} else if v, err = p.unary(); err != nil {
+			return nil, err
+		}
+		out = append(out, ast.Option{Name: name, Value: v})
+		if !p.accept(",") {
+			break
+		}
+	}
+	if _, err := p.expect(")"); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (p *Parser) createIndex(pos lexer.Pos) (*ast.CreateIndex, error) {
+	s := &ast.CreateIndex{At: ast.At(pos), Unique: p.accept("UNIQUE")}
+	if _, err := p.expect("INDEX"); err != nil {
+		return nil, err
+	}
+	var err error
+	if s.IfNotExists, err = p.ifNotExists(); err != nil {
+		return nil, err
+	}
+	if s.Name, err = p.name(); err != nil {
+		return nil, err
+	}
+	if _, err := p.expect("ON"); err != nil {
+		return nil, err
+	}
+	if s.Table, err = p.name(); err != nil {
+		return nil, err
+	}
+	if s.Columns, err = p.identList(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (p *Parser) createView(pos lexer.Pos) (*ast.CreateView, error) {
+	s := &ast.CreateView{At: ast.At(pos)}
+	var err error
+	if s.Name, err = p.name(); err != nil {
+		return nil, err
+	}
+	if p.tok().Is("(") {
+		if s.Columns, err = p.identList(); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := p.expect("AS"); err != nil {
+		return nil, err
+	}
+	start := p.tok().Pos.Offset
+	if s.Query, err = p.selectStmt(); err != nil {
+		return nil, err
+	}
+	end := len(p.src)
+	if t := p.tok(); t.Kind != lexer.EOF {
+		end = t.Pos.Offset
+	}
+	s.Text = strings.TrimSpace(p.src[start:end])
+	return s, nil
+}
+
+// drop は DROP TABLE, DROP INDEX, DROP VIEW, DROP USER を読みます。
+func (p *Parser) drop() (ast.Stmt, error) {
+	t := p.next()
+	kind := p.tok()
+	user := p.isWord("USER")
+	if !user && !p.accept("TABLE") && !p.accept("INDEX") && !p.accept("VIEW") {
+		return nil, p.unexpected("TABLE, TEMP TABLE, INDEX, VIEW or USER")
+	}
+	ifExists, err := p.ifExists()
+	if err != nil {
+		return nil, err
+	}
+	name, err := p.name()
+	if err != nil {
+		return nil, err
+	}
+	at := ast.At(t.Pos)
+	if user {
+		return &ast.DropUser{At: at, Name: name, IfExists: ifExists}, nil
+	}
+	switch kind.Text {
+	case "TABLE":
+		return &ast.DropTable{At: at, Name: name, IfExists: ifExists}, nil
+	case "INDEX":
+		return &ast.DropIndex{At: at, Name: name, IfExists: ifExists}, nil
+	}
+	return &ast.DropView{At: at, Name: name, IfExists: ifExists}, nil
+}
+
+// alter は ALTER TABLE、ALTER INDEX、ALTER USER を読みます。
+func (p *Parser) alter() (ast.Stmt, error) {
+	t := p.next()
+	at := ast.At(t.Pos)
+	if p.isWord("USER") {
+		name, err := p.ident()
+		if err != nil {
+			return nil, err
+		}
+		password, err := p.password()
+		if err != nil {
+			return nil, err
+		}
+		return &ast.AlterUser{At: at, Name: name, Password: password}, nil
+	}
+	if p.accept("INDEX") {
+		name, err := p.name()
+		if err != nil {
+			return nil, err
+		}
+		to, err := p.renameTo()
+		if err != nil {
+			return nil, err
+		}
+		return &ast.AlterIndex{At: at, Name: name, To: to}, nil
+	}
+	if _, err := p.expect("TABLE"); err != nil {
+		return nil, err
+	}
+	name, err := p.name()
+	if err != nil {
+		return nil, err
+	}
+	s := &ast.AlterTable{At: at, Table: name}
+	switch {
+	case p.accept("ADD"):
+		p.accept("COLUMN")
+		col, err := p.columnDef()
+		if err != nil {
+			return nil, err
+		}
+		s.Action = &ast.AddColumn{Column: col}
+	case p.accept("DROP"):
+		p.accept("COLUMN")
+		col, err := p.ident()
+		if err != nil {
+			return nil, err
+		}
+		s.Action = &ast.DropColumn{Name: col}
+	case p.tok().Is("RENAME") && p.peek(1).Is("TO"):
+		to, err := p.renameTo()
+		if err != nil {
+			return nil, err
+		}
+		s.Action = &ast.RenameTable{To: to}
+	case p.accept("RENAME"):
+		p.accept("COLUMN")
+		old, err := p.ident()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := p.expect("TO"); err != nil {
+			return nil, err
+		}
+		nw, err := p.ident()
+		if err != nil {
+			return nil, err
+		}
+		s.Action = &ast.RenameColumn{Old: old, New: nw}
+	default:
+		return nil, p.unexpected("ADD, DROP or RENAME")
+	}
+	return s, nil
+}
+
+// renameTo は RENAME TO name を読みます。
+func (p *Parser) renameTo() (string, error) {
+	if _, err := p.expect("RENAME"); err != nil {
+		return "", err
+	}
+	if _, err := p.expect("TO"); err != nil {
+		return "", err
+	}
+	return p.name()
+}
+
+// createUser は CREATE USER に続く [IF NOT EXISTS] name PASSWORD 'password' を読みます。
+func (p *Parser) createUser(pos lexer.Pos) (*ast.CreateUser, error) {
+	s := &ast.CreateUser{At: ast.At(pos)}
+	var err error
+	if s.IfNotExists, err = p.ifNotExists(); err != nil {
+		return nil, err
+	}
+	if s.Name, err = p.ident(); err != nil {
+		return nil, err
+	}
+	if s.Password, err = p.password(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// password は PASSWORD 'password' を読みます。
+func (p *Parser) password() (string, error) {
+	if !p.isWord("PASSWORD") {
+		return "", p.unexpected("PASSWORD")
+	}
+	t := p.tok()
+	if t.Kind != lexer.String {
+		return "", p.unexpected("password string")
+	}
+	p.next()
+	if t.Text == "" {
+		return "", p.errorf(t.Pos, "password must not be empty")
+	}
+	return t.Text, nil
+}
+
+// grant は GRANT {READ | WRITE} TO user と REVOKE {READ | WRITE} FROM user を読みます。
+func (p *Parser) grant() (ast.Stmt, error) {
+	t := p.next()
+	revoke := strings.EqualFold(t.Text, "REVOKE")
+	var write bool
+	switch {
+	case p.isWord("READ"):
+	case p.isWord("WRITE"):
+		write = true
+	default:
+		return nil, p.unexpected("READ or WRITE")
+	}
+	if revoke {
+		if _, err := p.expect("FROM"); err != nil {
+			return nil, err
+		}
+	} else if _, err := p.expect("TO"); err != nil {
+		return nil, err
+	}
+	user, err := p.ident()
+	if err != nil {
+		return nil, err
+	}
+	if revoke {
+		return &ast.Revoke{At: ast.At(t.Pos), User: user, Write: write}, nil
+	}
+	return &ast.Grant{At: ast.At(t.Pos), User: user, Write: write}, nil
+}
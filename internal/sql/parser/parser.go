@@ -0,0 +1,1060 @@
+// Package parser は SQL 文を構文解析し、ast パッケージの構文木を作ります。
+//
+// 再帰下降で解析します。式の演算子の優先順位は低いものから順に
+// OR, AND, NOT, 比較（= <> < <= > >= IS BETWEEN IN）, + -, * / %, ||, 単項 - + です。
+package parser
+
+import (
+	"encoding/hex"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/k-sml/go-rdbms/internal/sql/ast"
+	"github.com/k-sml/go-rdbms/internal/sql/lexer"
+	"github.com/k-sml/go-rdbms/internal/types"
+)
+
+// unreserved はキーワードですが、識別子としても使える語です。
+var unreserved = map[string]bool{
+	"ADD": true, "ANALYZE": true, "ASC": true, "BEGIN": true, "CASCADE": true, "COLUMN": true, "COMMIT": true,
+	"DEFERRABLE": true, "DEFERRED": true, "DESC": true, "ESCAPE": true, "INITIALLY": true, "KEY": true,
+	"MATERIALIZED": true, "PRAGMA": true, "RECURSIVE": true, "RENAME": true, "RESTRICT": true, "ROLLBACK": true,
+	"TO": true, "TRANSACTION": true, "VACUUM": true, "VIEW": true,
+}
+
+// maxParams は引数の番号の上限です。
+const maxParams = 32766
+
+// Parser は1つの SQL 文を解析します。
+type Parser struct {
+	src  string
+	toks []lexer.Token
+	i    int
+
+	nparams int // 引数の番号の最大値
+}
+
+// Parse は src の1つの文を解析します。末尾の ; は省略できます。
+func Parse(src string) (ast.Stmt, error) {
+	p, err := newParser(src)
+	if err != nil {
+		return nil, err
+	}
+	s, err := p.statement()
+	if err != nil {
+		return nil, err
+	}
+	p.accept(";")
+	if p.tok().Kind != lexer.EOF {
+		return nil, p.unexpected("end of statement")
+	}
+	return s, nil
+}
+
+// ParseParams は Parse と同じですが、文の中の引数（? と $1 など）の数も返します。
+// ? はそれまでの引数の番号の最大値の次の番号になります。
+func ParseParams(src string) (ast.Stmt, int, error) {
+	p, err := newParser(src)
+	if err != nil {
+		return nil, 0, err
+	}
+	s, err := p.statement()
+	if err != nil {
+		return nil, 0, err
+	}
+	p.accept(";")
+	if p.tok().Kind != lexer.EOF {
+		return nil, 0, p.unexpected("end of statement")
+	}
+	return s, p.nparams, nil
+}
+
+// ParseExpr は src を1つの式として解析します。
+func ParseExpr(src string) (ast.Expr, error) {
+	p, err := newParser(src)
+	if err != nil {
+		return nil, err
+	}
+	e, err := p.expr()
+	if err != nil {
+		return nil, err
+	}
+	if p.tok().Kind != lexer.EOF {
+		return nil, p.unexpected("end of expression")
+	}
+	return e, nil
+}
+
+func newParser(src string) (*Parser, error) {
+	toks, err := lexer.Tokenize(src)
+	if err != nil {
+		return nil, err
+	}
+	return &Parser{src: src, toks: toks}, nil
+}
+
+// ---- トークンの操作 ----
+
+func (p *Parser) tok() lexer.Token { return p.toks[p.i] }
+
+func (p *Parser) peek(n int) lexer.Token {
+	if p.i+n < len(p.toks) {
+		return p.toks[p.i+n]
+	}
+	return p.toks[len(p.toks)-1]
+}
+
+func (p *Parser) next() lexer.Token {
+	t := p.toks[p.i]
+	if t.Kind != lexer.EOF {
+		p.i++
+	}
+	return t
+}
+
+// accept は現在のトークンがキーワードまたは演算子 s なら読み進めて true を返します。
+func (p *Parser) accept(s string) bool {
+	if p.tok().Is(s) {
+		p.i++
+		return true
+	}
+	return false
+}
+
+// expect は現在のトークンがキーワードまたは演算子 s であることを確かめて読み進めます。
+func (p *Parser) expect(s string) (lexer.Token, error) {
+	t := p.tok()
+	if !t.Is(s) {
+		return t, p.unexpected(s)
+	}
+	p.i++
+	return t, nil
+}
+
+func (p *Parser) errorf(pos lexer.Pos, format string, args ...any) error {
+	return &lexer.Error{Pos: pos, Msg: fmt.Sprintf(format, args...)}
+}
+
+// unexpected は want を期待していたところに別のトークンがあったことを報告します。
+func (p *Parser) unexpected(want string) error {
+	return p.errorf(p.tok().Pos, "expected %s, found %s", want, p.tok())
+}
+
+// ident は識別子を読みます。
+func (p *Parser) ident() (string, error) {
+	t := p.tok()
+	if t.Kind == lexer.Ident || (t.Kind == lexer.Keyword && unreserved[t.Text]) {
+		p.i++
+		if t.Kind == lexer.Keyword {
+			return strings.ToLower(t.Text), nil
+		}
+		return t.Text, nil
+	}
+	return "", p.unexpected("identifier")
+}
+
+// name はテーブルなどの名前を読みます。schema.name のようにドットで区切った名前はそのままつなげます。
+func (p *Parser) name() (string, error) {
+	n, err := p.ident()
+	if err != nil {
+		return "", err
+	}
+	for p.tok().Is(".") && p.peek(1).Kind != lexer.EOF && !p.peek(1).Is("*") {
+		p.next()
+		m, err := p.ident()
+		if err != nil {
+			return "", err
+		}
+		n += "." + m
+	}
+	return n, nil
+}
+
+// identList は ( a, b, ... ) を読みます。
+func (p *Parser) identList() ([]string, error) {
+	if _, err := p.expect("("); err != nil {
+		return nil, err
+	}
+	var out []string
+	for {
+		n, err := p.ident()
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, n)
+		if !p.accept(",") {
+			break
+		}
+	}
+	if _, err := p.expect(")"); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// ---- 文 ----
+
+func (p *Parser) statement() (ast.Stmt, error) {
+	t := p.tok()
+	switch {
+	case t.Is("SELECT") || t.Is("WITH"):
+		return p.selectStmt()
+	case t.Is("INSERT"):
+		return p.insert()
+	case t.Is("UPDATE"):
+		return p.update()
+	case t.Is("DELETE"):
+		return p.delete()
+	case t.Is("CREATE"):
+		return p.create()
+	case t.Is("DROP"):
+		return p.drop()
+	case t.Is("ALTER"):
+		return p.alter()
+	case t.Is("BEGIN"):
+		p.next()
+		p.accept("TRANSACTION")
+		return &ast.Begin{At: ast.At(t.Pos)}, nil
+	case t.Is("COMMIT"):
+		p.next()
+		p.accept("TRANSACTION")
+		return &ast.Commit{At: ast.At(t.Pos)}, nil
+	case t.Is("ROLLBACK"):
+		p.next()
+		p.accept("TRANSACTION")
+		return &ast.Rollback{At: ast.At(t.Pos)}, nil
+	case t.Is("EXPLAIN"):
+		p.next()
+		analyze := p.accept("ANALYZE")
+		stmt, err := p.statement()
+		if err != nil {
+			return nil, err
+		}
+		return &ast.Explain{At: ast.At(t.Pos), Analyze: analyze, Stmt: stmt}, nil
+	case t.Is("ANALYZE"):
+		p.next()
+		s := &ast.Analyze{At: ast.At(t.Pos)}
+		if n := p.tok(); n.Kind == lexer.Ident || n.Kind == lexer.Keyword && unreserved[n.Text] {
+			var err error
+			if s.Table, err = p.name(); err != nil {
+				return nil, err
+			}
+		}
+		return s, nil
+	case t.Is("PRAGMA"):
+		p.next()
+		name, err := p.ident()
+		if err != nil {
+			return nil, err
+		}
+		return &ast.Pragma{At: ast.At(t.Pos), Name: name}, nil
+	case t.Is("VACUUM"):
+		p.next()
+		return &ast.Vacuum{At: ast.At(t.Pos)}, nil
+	case t.Kind == lexer.Ident && !t.Quoted && (strings.EqualFold(t.Text, "GRANT") || strings.EqualFold(t.Text, "REVOKE")):
+		return p.grant()
+	}
+	return nil, p.unexpected("statement")
+}
+
+// setOps は集合演算のキーワードです。
+var setOps = map[string]ast.SetOp{"UNION": ast.Union, "INTERSECT": ast.Intersect, "EXCEPT": ast.Except}
+
+// selectStmt は SELECT 文を読みます。UNION などでつないだ SELECT は左から順に結合し、
+// ORDER BY と LIMIT は全体の結果に対するものとします。
+func (p *Parser) selectStmt() (*ast.Select, error) {
+	var with *ast.With
+	if p.tok().Is("WITH") {
+		var err error
+		if with, err = p.with(); err != nil {
+			return nil, err
+		}
+	}
+	s, err := p.selectCore()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		t := p.tok()
+		op, ok := setOps[t.Text]
+		if t.Kind != lexer.Keyword || !ok {
+			break
+		}
+		p.next()
+		all := p.accept("ALL")
+		if !all {
+			p.accept("DISTINCT")
+		}
+		right, err := p.selectCore()
+		if err != nil {
+			return nil, err
+		}
+		s = &ast.Select{At: ast.At(t.Pos), Compound: &ast.Compound{Op: op, All: all, Left: s, Right: right}}
+	}
+	s.With = with
+	if p.accept("ORDER") {
+		if _, err := p.expect("BY"); err != nil {
+			return nil, err
+		}
+		for {
+			e, err := p.expr()
+			if err != nil {
+				return nil, err
+			}
+			item := ast.OrderItem{Expr: e}
+			if p.accept("DESC") {
+				item.Desc = true
+			} else {
+				p.accept("ASC")
+			}
+			s.OrderBy = append(s.OrderBy, item)
+			if !p.accept(",") {
+				break
+			}
+		}
+	}
+	if p.accept("LIMIT") {
+		if s.Limit, err = p.expr(); err != nil {
+			return nil, err
+		}
+		if p.accept("OFFSET") {
+			if s.Offset, err = p.expr(); err != nil {
+				return nil, err
+			}
+		} else if p.accept(",") {
+			// LIMIT offset, count
+			s.Offset = s.Limit
+			if s.Limit, err = p.expr(); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return s, nil
+}
+
+// selectCore は ORDER BY と LIMIT を除いた1つの SELECT を読みます。
+func (p *Parser) selectCore() (*ast.Select, error) {
+	t, err := p.expect("SELECT")
+	if err != nil {
+		return nil, err
+	}
+	s := &ast.Select{At: ast.At(t.Pos)}
+	if p.accept("DISTINCT") {
+		s.Distinct = true
+	} else {
+		p.accept("ALL")
+	}
+	for {
+		item, err := p.selectItem()
+		if err != nil {
+			return nil, err
+		}
+		s.Columns = append(s.Columns, item)
+		if !p.accept(",") {
+			break
+		}
+	}
+	if p.accept("FROM") {
+		if s.From, err = p.from(); err != nil {
+			return nil, err
+		}
+	}
+	if p.accept("WHERE") {
+		if s.Where, err = p.expr(); err != nil {
+			return nil, err
+		}
+	}
+	if p.accept("GROUP") {
+		if _, err := p.expect("BY"); err != nil {
+			return nil, err
+		}
+		if s.GroupBy, err = p.exprList(); err != nil {
+			return nil, err
+		}
+	}
+	if p.accept("HAVING") {
+		if s.Having, err = p.expr(); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// with は WITH [RECURSIVE] name [(columns)] AS [[NOT] MATERIALIZED] (select), ... を読みます。
+// WITH RECURSIVE では、(select UNION [ALL] select) の最後の SELECT で自分自身を参照できます。
+func (p *Parser) with() (*ast.With, error) {
+	t := p.next()
+	w := &ast.With{At: ast.At(t.Pos), Recursive: p.accept("RECURSIVE")}
+	for {
+		c := &ast.CTE{At: ast.At(p.tok().Pos)}
+		var err error
+		if c.Name, err = p.ident(); err != nil {
+			return nil, err
+		}
+		if p.tok().Is("(") {
+			if c.Columns, err = p.identList(); err != nil {
+				return nil, err
+			}
+		}
+		if _, err := p.expect("AS"); err != nil {
+			return nil, err
+		}
+		if p.accept("NOT") {
+			if _, err := p.expect("MATERIALIZED"); err != nil {
+				return nil, err
+			}
+			c.Materialize = ast.NotMaterialized
+		} else if p.accept("MATERIALIZED") {
+			c.Materialize = ast.Materialized
+		}
+		if _, err := p.expect("("); err != nil {
+			return nil, err
+		}
+		if c.Select, err = p.selectStmt(); err != nil {
+			return nil, err
+		}
+		if u := c.Select.Compound; w.Recursive && u != nil && u.Op == ast.Union && c.Select.OrderBy == nil &&
+			c.Select.Limit == nil && refersTo(u.Right, c.Name) {
+			c.Select, c.Recursive, c.UnionAll = u.Left, u.Right, u.All
+		}
+		if _, err := p.expect(")"); err != nil {
+			return nil, err
+		}
+		w.CTEs = append(w.CTEs, c)
+		if !p.accept(",") {
+			return w, nil
+		}
+	}
+}
+
+// refersTo は s の中の FROM に name という名前のテーブルがあるかを返します。
+func refersTo(s *ast.Select, name string) bool {
+	found := false
+	ast.Subqueries(s, func(sub *ast.Select) {
+		ast.Tables(sub.From, func(tn *ast.TableName) {
+			found = found || strings.EqualFold(tn.Name, name)
+		})
+	})
+	return found
+}
+
+func (p *Parser) selectItem() (ast.SelectItem, error) {
+	if p.accept("*") {
+		return ast.SelectItem{Star: true}, nil
+	}
+	if t := p.tok(); (t.Kind == lexer.Ident || t.Kind == lexer.Keyword && unreserved[t.Text]) && p.peek(1).Is(".") && p.peek(2).Is("*") {
+		name, _ := p.ident()
+		p.next()
+		p.next()
+		return ast.SelectItem{Star: true, Table: name}, nil
+	}
+	e, err := p.expr()
+	if err != nil {
+		return ast.SelectItem{}, err
+	}
+	item := ast.SelectItem{Expr: e}
+	item.Alias, err = p.alias()
+	return item, err
+}
+
+// alias は [AS] 別名 を読みます。なければ空文字列を返します。
+func (p *Parser) alias() (string, error) {
+	if p.accept("AS") {
+		return p.ident()
+	}
+	if p.tok().Kind == lexer.Ident {
+		return p.ident()
+	}
+	return "", nil
+}
+
+func (p *Parser) from() (ast.TableExpr, error) {
+	left, err := p.tableName()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		t := p.tok()
+		switch {
+		case p.accept(","):
+			right, err := p.tableName()
+			if err != nil {
+				return nil, err
+			}
+			left = &ast.Join{At: ast.At(t.Pos), Kind: ast.CrossJoin, Left: left, Right: right}
+		case t.Is("JOIN") || t.Is("INNER") || t.Is("CROSS") || t.Is("LEFT") || t.Is("RIGHT") || t.Is("FULL"):
+			kind, err := p.joinKind()
+			if err != nil {
+				return nil, err
+			}
+			right, err := p.tableName()
+			if err != nil {
+				return nil, err
+			}
+			j := &ast.Join{At: ast.At(t.Pos), Kind: kind, Left: left, Right: right}
+			if kind != ast.CrossJoin {
+				if _, err := p.expect("ON"); err != nil {
+					return nil, err
+				}
+				if j.On, err = p.expr(); err != nil {
+					return nil, err
+				}
+			}
+			left = j
+		default:
+			return left, nil
+		}
+	}
+}
+
+// joinKind は JOIN までの結合の種類を読みます。
+func (p *Parser) joinKind() (ast.JoinKind, error) {
+	kind := ast.InnerJoin
+	switch {
+	case p.accept("INNER"):
+	case p.accept("CROSS"):
+		kind = ast.CrossJoin
+	case p.accept("LEFT"):
+		kind = ast.LeftJoin
+	case p.accept("RIGHT"):
+		kind = ast.RightJoin
+	case p.accept("FULL"):
+		kind = ast.FullJoin
+	}
+	if kind >= ast.LeftJoin {
+		p.accept("OUTER")
+	}
+	_, err := p.expect("JOIN")
+	return kind, err
+}
+
+func (p *Parser) tableName() (ast.TableExpr, error) {
+	pos := p.tok().Pos
+	name, err := p.name()
+	if err != nil {
+		return nil, err
+	}
+	alias, err := p.alias()
+	if err != nil {
+		return nil, err
+	}
+	return &ast.TableName{At: ast.At(pos), Name: name, Alias: alias}, nil
+}
+
+func (p *Parser) insert() (*ast.Insert, error) {
+	t := p.next()
+	if _, err := p.expect("INTO"); err != nil {
+		return nil, err
+	}
+	name, err := p.name()
+	if err != nil {
+		return nil, err
+	}
+	s := &ast.Insert{At: ast.At(t.Pos), Table: name}
+	if p.tok().Is("(") {
+		if s.Columns, err = p.identList(); err != nil {
+			return nil, err
+		}
+	}
+	if t := p.tok(); t.Is("SELECT") || t.Is("WITH") {
+		if s.Query, err = p.selectStmt(); err != nil {
+			return nil, err
+		}
+		return s, nil
+	}
+	if !p.accept("VALUES") {
+		return nil, p.unexpected("VALUES or SELECT")
+	}
+	for {
+		if _, err := p.expect("("); err != nil {
+			return nil, err
+		}
+		row, err := p.exprList()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := p.expect(")"); err != nil {
+			return nil, err
+		}
+		s.Rows = append(s.Rows, row)
+		if !p.accept(",") {
+			return s, nil
+		}
+	}
+}
+
+func (p *Parser) update() (*ast.Update, error) {
+	t := p.next()
+	name, err := p.name()
+	if err != nil {
+		return nil, err
+	}
+	s := &ast.Update{At: ast.At(t.Pos), Table: name}
+	if _, err := p.expect("SET"); err != nil {
+		return nil, err
+	}
+	for {
+		col, err := p.ident()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := p.expect("="); err != nil {
+			return nil, err
+		}
+		v, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		s.Set = append(s.Set, ast.Assignment{Column: col, Value: v})
+		if !p.accept(",") {
+			break
+		}
+	}
+	if p.accept("WHERE") {
+		if s.Where, err = p.expr(); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+func (p *Parser) delete() (*ast.Delete, error) {
+	t := p.next()
+	if _, err := p.expect("FROM"); err != nil {
+		return nil, err
+	}
+	name, err := p.name()
+	if err != nil {
+		return nil, err
+	}
+	s := &ast.Delete{At: ast.At(t.Pos), Table: name}
+	if p.accept("WHERE") {
+		if s.Where, err = p.expr(); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// ---- 式 ----
+
+func (p *Parser) exprList() ([]ast.Expr, error) {
+	var out []ast.Expr
+	for {
+		e, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, e)
+		if !p.accept(",") {
+			return out, nil
+		}
+	}
+}
+
+func (p *Parser) expr() (ast.Expr, error) { return p.or() }
+
+func (p *Parser) or() (ast.Expr, error) {
+	l, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	for p.tok().Is("OR") {
+		t := p.next()
+		r, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		l = &ast.Binary{At: ast.At(t.Pos), Op: "OR", L: l, R: r}
+	}
+	return l, nil
+}
+
+func (p *Parser) and() (ast.Expr, error) {
+	l, err := p.not()
+	if err != nil {
+		return nil, err
+	}
+	for p.tok().Is("AND") {
+		t := p.next()
+		r, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+		l = &ast.Binary{At: ast.At(t.Pos), Op: "AND", L: l, R: r}
+	}
+	return l, nil
+}
+
+func (p *Parser) not() (ast.Expr, error) {
+	if t := p.tok(); t.Is("NOT") {
+		p.next()
+		x, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+		return &ast.Unary{At: ast.At(t.Pos), Op: "NOT", X: x}, nil
+	}
+	return p.comparison()
+}
+
+func (p *Parser) comparison() (ast.Expr, error) {
+	l, err := p.additive()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		t := p.tok()
+		switch {
+		case t.Is("=") || t.Is("<>") || t.Is("<") || t.Is("<=") || t.Is(">") || t.Is(">="):
+			p.next()
+			r, err := p.additive()
+			if err != nil {
+				return nil, err
+			}
+			l = &ast.Binary{At: ast.At(t.Pos), Op: t.Text, L: l, R: r}
+		case t.Is("IS"):
+			p.next()
+			not := p.accept("NOT")
+			if _, err := p.expect("NULL"); err != nil {
+				return nil, err
+			}
+			l = &ast.IsNull{At: ast.At(t.Pos), X: l, Not: not}
+		case t.Is("BETWEEN") || t.Is("IN") || t.Is("LIKE") || t.Is("GLOB") ||
+			(t.Is("NOT") && (p.peek(1).Is("BETWEEN") || p.peek(1).Is("IN") || p.peek(1).Is("LIKE") || p.peek(1).Is("GLOB"))):
+			not := p.accept("NOT")
+			if l, err = p.postfix(l, not); err != nil {
+				return nil, err
+			}
+		default:
+			return l, nil
+		}
+	}
+}
+
+// postfix は [NOT] に続く BETWEEN、IN、LIKE または GLOB を読みます。
+func (p *Parser) postfix(x ast.Expr, not bool) (ast.Expr, error) {
+	t := p.next()
+	if t.Is("LIKE") || t.Is("GLOB") {
+		pat, err := p.additive()
+		if err != nil {
+			return nil, err
+		}
+		e := &ast.Like{At: ast.At(t.Pos), Op: t.Text, X: x, Pattern: pat, Not: not}
+		if t.Is("LIKE") && p.accept("ESCAPE") {
+			if e.Escape, err = p.additive(); err != nil {
+				return nil, err
+			}
+		}
+		return e, nil
+	}
+	if t.Is("BETWEEN") {
+		lo, err := p.additive()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := p.expect("AND"); err != nil {
+			return nil, err
+		}
+		hi, err := p.additive()
+		if err != nil {
+			return nil, err
+		}
+		return &ast.Between{At: ast.At(t.Pos), X: x, Lo: lo, Hi: hi, Not: not}, nil
+	}
+	if _, err := p.expect("("); err != nil {
+		return nil, err
+	}
+	if p.tok().Is("SELECT") || p.tok().Is("WITH") {
+		sel, err := p.selectStmt()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := p.expect(")"); err != nil {
+			return nil, err
+		}
+		return &ast.InSubquery{At: ast.At(t.Pos), X: x, Select: sel, Not: not}, nil
+	}
+	list, err := p.exprList()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := p.expect(")"); err != nil {
+		return nil, err
+	}
+	return &ast.InList{At: ast.At(t.Pos), X: x, List: list, Not: not}, nil
+}
+
+func (p *Parser) additive() (ast.Expr, error) {
+	l, err := p.multiplicative()
+	if err != nil {
+		return nil, err
+	}
+	for t := p.tok(); t.Is("+") || t.Is("-"); t = p.tok() {
+		p.next()
+		r, err := p.multiplicative()
+		if err != nil {
+			return nil, err
+		}
+		l = &ast.Binary{At: ast.At(t.Pos), Op: t.Text, L: l, R: r}
+	}
+	return l, nil
+}
+
+func (p *Parser) multiplicative() (ast.Expr, error) {
+	l, err := p.concat()
+	if err != nil {
+		return nil, err
+	}
+	for t := p.tok(); t.Is("*") || t.Is("/") || t.Is("%"); t = p.tok() {
+		p.next()
+		r, err := p.concat()
+		if err != nil {
+			return nil, err
+		}
+		l = &ast.Binary{At: ast.At(t.Pos), Op: t.Text, L: l, R: r}
+	}
+	return l, nil
+}
+
+func (p *Parser) concat() (ast.Expr, error) {
+	l, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	for t := p.tok(); t.Is("||"); t = p.tok() {
+		p.next()
+		r, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		l = &ast.Binary{At: ast.At(t.Pos), Op: "||", L: l, R: r}
+	}
+	return l, nil
+}
+
+func (p *Parser) unary() (ast.Expr, error) {
+	t := p.tok()
+	if t.Is("-") || t.Is("+") {
+		p.next()
+		// int64 の最小値は正の数の符号を反転しては表せないので、ここで読む
+		if n := p.tok(); t.Text == "-" && n.Kind == lexer.Int && n.Text == "9223372036854775808" {
+			p.next()
+			return &ast.Literal{At: ast.At(t.Pos), Value: types.NewBigInt(math.MinInt64)}, nil
+		}
+		x, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return &ast.Unary{At: ast.At(t.Pos), Op: t.Text, X: x}, nil
+	}
+	return p.primary()
+}
+
+func (p *Parser) primary() (ast.Expr, error) {
+	t := p.tok()
+	at := ast.At(t.Pos)
+	switch t.Kind {
+	case lexer.Int:
+		p.next()
+		n, err := strconv.ParseInt(t.Text, 10, 64)
+		if err != nil {
+			f, err := strconv.ParseFloat(t.Text, 64)
+			if err != nil {
+				return nil, p.errorf(t.Pos, "malformed number %s", t.Text)
+			}
+			return &ast.Literal{At: at, Value: types.NewReal(f)}, nil
+		}
+		return &ast.Literal{At: at, Value: types.NewBigInt(n)}, nil
+	case lexer.Float:
+		p.next()
+		f, err := strconv.ParseFloat(t.Text, 64)
+		if err != nil {
+			return nil, p.errorf(t.Pos, "malformed number %s", t.Text)
+		}
+		return &ast.Literal{At: at, Value: types.NewReal(f)}, nil
+	case lexer.String:
+		p.next()
+		return &ast.Literal{At: at, Value: types.NewText(t.Text)}, nil
+	case lexer.Param:
+		p.next()
+		n := p.nparams + 1
+		if t.Text != "?" {
+			var err error
+			if n, err = strconv.Atoi(t.Text[1:]); err != nil || n < 1 || n > maxParams {
+				return nil, p.errorf(t.Pos, "invalid parameter number %s", t.Text)
+			}
+		}
+		p.nparams = max(p.nparams, n)
+		return &ast.Param{At: at, Index: n}, nil
+	case lexer.Blob:
+		p.next()
+		b, _ := hex.DecodeString(t.Text)
+		return &ast.Literal{At: at, Value: types.NewBlob(b)}, nil
+	}
+	switch {
+	case t.Is("NULL"):
+		p.next()
+		return &ast.Literal{At: at, Value: types.NullValue()}, nil
+	case t.Is("TRUE") || t.Is("FALSE"):
+		p.next()
+		return &ast.Literal{At: at, Value: types.NewBool(t.Text == "TRUE")}, nil
+	case t.Is("(") && (p.peek(1).Is("SELECT") || p.peek(1).Is("WITH")):
+		p.next()
+		sel, err := p.selectStmt()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := p.expect(")"); err != nil {
+			return nil, err
+		}
+		return &ast.Subquery{At: at, Select: sel}, nil
+	case t.Is("EXISTS"):
+		p.next()
+		if _, err := p.expect("("); err != nil {
+			return nil, err
+		}
+		sel, err := p.selectStmt()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := p.expect(")"); err != nil {
+			return nil, err
+		}
+		return &ast.Exists{At: at, Select: sel}, nil
+	case t.Is("("):
+		p.next()
+		e, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := p.expect(")"); err != nil {
+			return nil, err
+		}
+		return e, nil
+	case t.Is("CAST"):
+		p.next()
+		if _, err := p.expect("("); err != nil {
+			return nil, err
+		}
+		x, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := p.expect("AS"); err != nil {
+			return nil, err
+		}
+		typ, err := p.typeName()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := p.expect(")"); err != nil {
+			return nil, err
+		}
+		return &ast.Cast{At: at, X: x, Type: typ}, nil
+	case t.Is("CASE"):
+		return p.caseExpr()
+	}
+	if t.Kind != lexer.Ident && !(t.Kind == lexer.Keyword && unreserved[t.Text]) {
+		return nil, p.unexpected("expression")
+	}
+	name, _ := p.ident()
+	if p.tok().Is("(") {
+		return p.call(at, name)
+	}
+	if p.accept(".") {
+		col, err := p.ident()
+		if err != nil {
+			return nil, err
+		}
+		return &ast.ColumnRef{At: at, Table: name, Column: col}, nil
+	}
+	return &ast.ColumnRef{At: at, Column: name}, nil
+}
+
+// call は関数呼び出しの引数を読みます。
+func (p *Parser) call(at ast.At, name string) (ast.Expr, error) {
+	p.next() // (
+	c := &ast.Call{At: at, Name: strings.ToUpper(name)}
+	switch {
+	case p.accept("*"):
+		c.Star = true
+	case p.tok().Is(")"):
+	default:
+		args, err := p.exprList()
+		if err != nil {
+			return nil, err
+		}
+		c.Args = args
+	}
+	if _, err := p.expect(")"); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// caseExpr は CASE [operand] WHEN cond THEN result ... [ELSE result] END を読みます。
+func (p *Parser) caseExpr() (ast.Expr, error) {
+	t := p.next()
+	e := &ast.Case{At: ast.At(t.Pos)}
+	var err error
+	if !p.tok().Is("WHEN") {
+		if e.Operand, err = p.expr(); err != nil {
+			return nil, err
+		}
+	}
+	for p.accept("WHEN") {
+		w := &ast.When{}
+		if w.Cond, err = p.expr(); err != nil {
+			return nil, err
+		}
+		if _, err := p.expect("THEN"); err != nil {
+			return nil, err
+		}
+		if w.Result, err = p.expr(); err != nil {
+			return nil, err
+		}
+		e.Whens = append(e.Whens, w)
+	}
+	if len(e.Whens) == 0 {
+		return nil, p.unexpected("WHEN")
+	}
+	if p.accept("ELSE") {
+		if e.Else, err = p.expr(); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := p.expect("END"); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// typeName は型名を読みます。DOUBLE PRECISION のような複数語の型名と、
+// VARCHAR(255) のような長さの指定（無視する）も受け付けます。
+func (p *Parser) typeName() (types.Type, error) {
+	t := p.tok()
+	var words []string
+	for p.tok().Kind == lexer.Ident {
+		words = append(words, p.next().Text)
+	}
+	if len(words) == 0 {
+		return types.Null, p.unexpected("type name")
+	}
+	typ, err := types.Parse(strings.Join(words, " "))
+	if err != nil {
+		return types.Null, p.errorf(t.Pos, "%v", err)
+	}
+	if p.accept("(") {
+		for !p.tok().Is(")") {
+			if p.tok().Kind != lexer.Int && !p.tok().Is(",") {
+				return types.Null, p.unexpected(")")
+			}
+			p.next()
+		}
+		p.next()
+	}
+	return typ, nil
+}
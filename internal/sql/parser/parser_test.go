@@ -0,0 +1,297 @@
+package parser
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/k-sml/go-rdbms/internal/sql/ast"
+	"github.com/k-sml/go-rdbms/internal/types"
+)
+
+// sexpr は式をすべての演算にかっこを付けた形にします。
+func sexpr(e ast.Expr) string {
+	switch e := e.(type) {
+	case *ast.Literal:
+		if e.Value.Type() == types.Text {
+			return "'" + e.Value.Text() + "'"
+		}
+		return e.Value.String()
+	case *ast.ColumnRef:
+		if e.Table != "" {
+			return e.Table + "." + e.Column
+		}
+		return e.Column
+	case *ast.Unary:
+		return "(" + e.Op + " " + sexpr(e.X) + ")"
+	case *ast.Binary:
+		return "(" + sexpr(e.L) + " " + e.Op + " " + sexpr(e.R) + ")"
+	case *ast.IsNull:
+		if e.Not {
+			return "(" + sexpr(e.X) + " IS NOT NULL)"
+		}
+		return "(" + sexpr(e.X) + " IS NULL)"
+	case *ast.Between:
+		return "(" + sexpr(e.X) + not(e.Not) + " BETWEEN " + sexpr(e.Lo) + " AND " + sexpr(e.Hi) + ")"
+	case *ast.InList:
+		return "(" + sexpr(e.X) + not(e.Not) + " IN (" + list(e.List) + "))"
+	case *ast.Call:
+		if e.Star {
+			return e.Name + "(*)"
+		}
+		return e.Name + "(" + list(e.Args) + ")"
+	case *ast.Cast:
+		return "CAST(" + sexpr(e.X) + " AS " + e.Type.String() + ")"
+	}
+	return fmt.Sprintf("%T", e)
+}
+
+func not(b bool) string {
+	if b {
+		return " NOT"
+	}
+	return ""
+}
+
+func list(es []ast.Expr) string {
+	s := make([]string, len(es))
+	for i, e := range es {
+		s[i] = sexpr(e)
+	}
+	return strings.Join(s, ", ")
+}
+
+// TestParseExpr は演算子の優先順位と結合の向き、定数の型を確かめます。
+func TestParseExpr(t *testing.T) {
+	tests := []struct {
+		src, want string
+	}{
+		{"1 + 2 * 3", "(1 + (2 * 3))"},
+		{"(1 + 2) * 3", "((1 + 2) * 3)"},
+		{"1 - 2 - 3", "((1 - 2) - 3)"},
+		{"-a * b", "((- a) * b)"},
+		{"a || b || c", "((a || b) || c)"},
+		{"a + b || c", "(a + (b || c))"},
+		{"a OR b AND NOT c = 1", "(a OR (b AND (NOT (c = 1))))"},
+		{"x = 1 AND y <> 'it''s'", "((x = 1) AND (y <> 'it's'))"},
+		{"t.a IS NOT NULL OR t.b IS NULL", "((t.a IS NOT NULL) OR (t.b IS NULL))"},
+		{"a NOT BETWEEN 1 AND 2 + 3", "(a NOT BETWEEN 1 AND (2 + 3))"},
+		{"a IN (1, 2) AND b NOT IN ('x')", "((a IN (1, 2)) AND (b NOT IN ('x')))"},
+		{"CAST(a AS BIGINT) > 1", "(CAST(a AS BIGINT) > 1)"},
+		{"count(*) + max(a, 1)", "(COUNT(*) + MAX(a, 1))"},
+		{"NULL IS NULL", "(NULL IS NULL)"},
+		{`"select" + 1`, "(select + 1)"},
+	}
+	for _, tt := range tests {
+		e, err := ParseExpr(tt.src)
+		if err != nil {
+			t.Errorf("ParseExpr(%q): %v", tt.src, err)
+			continue
+		}
+		if got := sexpr(e); got != tt.want {
+			t.Errorf("ParseExpr(%q) = %s, want %s", tt.src, got, tt.want)
+		}
+	}
+
+	literals := []struct {
+		src string
+		typ types.Type
+	}{
+		{"1", types.BigInt},
+		{"9223372036854775807", types.BigInt},
+		{"1.5", types.Real},
+		{"1e3", types.Real},
+		{"'x'", types.Text},
+		{"x'00ff'", types.Blob},
+		{"TRUE", types.Boolean},
+		{"NULL", types.Null},
+	}
+	for _, tt := range literals {
+		e, err := ParseExpr(tt.src)
+		if err != nil {
+			t.Errorf("ParseExpr(%q): %v", tt.src, err)
+			continue
+		}
+		if l, ok := e.(*ast.Literal); !ok || l.Value.Type() != tt.typ {
+			t.Errorf("ParseExpr(%q) = %s, want a %s literal", tt.src, sexpr(e), tt.typ)
+		}
+	}
+}
+
+// TestParse は、それぞれの文が正しい構文木になることを確かめます。
+func TestParse(t *testing.T) {
+	tests := []struct {
+		src string
+		ok  func(s ast.Stmt) bool
+	}{
+		{"SELECT a, t.*, b + 1 AS c FROM t WHERE a > 1 ORDER BY a DESC, b LIMIT 10 OFFSET 5;", func(s ast.Stmt) bool {
+			q := s.(*ast.Select)
+			return len(q.Columns) == 3 && q.Columns[1].Star && q.Columns[1].Table == "t" && q.Columns[2].Alias == "c" &&
+				q.Where != nil && len(q.OrderBy) == 2 && q.OrderBy[0].Desc && !q.OrderBy[1].Desc &&
+				sexpr(q.Limit) == "10" && sexpr(q.Offset) == "5"
+		}},
+		{"SELECT count(*) FROM t GROUP BY a, b HAVING count(*) > 1", func(s ast.Stmt) bool {
+			q := s.(*ast.Select)
+			return len(q.GroupBy) == 2 && sexpr(q.Having) == "(COUNT(*) > 1)"
+		}},
+		{"SELECT * FROM a x JOIN b ON x.id = b.id, c", func(s ast.Stmt) bool {
+			j, ok := s.(*ast.Select).From.(*ast.Join)
+			if !ok || j.Kind != ast.CrossJoin {
+				return false
+			}
+			inner, ok := j.Left.(*ast.Join)
+			return ok && inner.Kind == ast.InnerJoin && inner.Left.(*ast.TableName).Alias == "x" &&
+				sexpr(inner.On) == "(x.id = b.id)" && j.Right.(*ast.TableName).Name == "c"
+		}},
+		{"SELECT DISTINCT a FROM t", func(s ast.Stmt) bool { return s.(*ast.Select).Distinct }},
+		{"SELECT * FROM a LEFT OUTER JOIN b ON a.id = b.id", func(s ast.Stmt) bool {
+			j, ok := s.(*ast.Select).From.(*ast.Join)
+			return ok && j.Kind == ast.LeftJoin
+		}},
+		{"WITH RECURSIVE r (n) AS (SELECT 1 UNION ALL SELECT n + 1 FROM r) SELECT n FROM r", func(s ast.Stmt) bool {
+			w := s.(*ast.Select).With
+			return w != nil && w.Recursive && len(w.CTEs) == 1 && w.CTEs[0].Name == "r" &&
+				slices.Equal(w.CTEs[0].Columns, []string{"n"}) && w.CTEs[0].Recursive != nil && w.CTEs[0].UnionAll
+		}},
+		{"SELECT a FROM t UNION ALL SELECT b FROM u EXCEPT SELECT c FROM v", func(s ast.Stmt) bool {
+			c := s.(*ast.Select).Compound
+			return c != nil && c.Op == ast.Except && !c.All && c.Left.Compound != nil &&
+				c.Left.Compound.Op == ast.Union && c.Left.Compound.All
+		}},
+		{"INSERT INTO t (a, b) VALUES (1, 'x'), (2, NULL)", func(s ast.Stmt) bool {
+			ins := s.(*ast.Insert)
+			return ins.Table == "t" && slices.Equal(ins.Columns, []string{"a", "b"}) && len(ins.Rows) == 2
+		}},
+		{"INSERT INTO t SELECT * FROM u", func(s ast.Stmt) bool {
+			ins := s.(*ast.Insert)
+			return ins.Query != nil && ins.Rows == nil
+		}},
+		{"UPDATE t SET a = a + 1, b = 'y' WHERE a = 1", func(s ast.Stmt) bool {
+			u := s.(*ast.Update)
+			return len(u.Set) == 2 && u.Set[1].Column == "b" && sexpr(u.Where) == "(a = 1)"
+		}},
+		{"DELETE FROM t", func(s ast.Stmt) bool {
+			d := s.(*ast.Delete)
+			return d.Table == "t" && d.Where == nil
+		}},
+		{"CREATE TABLE IF NOT EXISTS t (id INTEGER PRIMARY KEY AUTOINCREMENT, name TEXT NOT NULL DEFAULT 'x', " +
+			"p BIGINT REFERENCES p (id) ON DELETE CASCADE, FOREIGN KEY (name) REFERENCES q (n) ON UPDATE SET NULL) WITH (fillfactor = 70)",
+			func(s ast.Stmt) bool {
+				ct := s.(*ast.CreateTable)
+				if !ct.IfNotExists || len(ct.Columns) != 3 || len(ct.ForeignKeys) != 1 || len(ct.Options) != 1 {
+					return false
+				}
+				id, name, p := ct.Columns[0], ct.Columns[1], ct.Columns[2]
+				return id.PrimaryKey && id.AutoIncrement && id.Type == types.Int && name.NotNull && sexpr(name.Default) == "'x'" &&
+					p.References != nil && p.References.RefTable == "p" && p.References.OnDelete == "CASCADE" &&
+					ct.ForeignKeys[0].OnUpdate == "SET NULL" && ct.Options[0].Name == "fillfactor"
+			}},
+		{"CREATE TABLE t (a INT, b TEXT UNIQUE, PRIMARY KEY (a), UNIQUE (a, b))", func(s ast.Stmt) bool {
+			ct := s.(*ast.CreateTable)
+			return slices.Equal(ct.PrimaryKey, []string{"a"}) && ct.Columns[1].Unique && !ct.Columns[0].Unique &&
+				len(ct.Unique) == 1 && slices.Equal(ct.Unique[0], []string{"a", "b"})
+		}},
+		{"CREATE TEMP TABLE t (a INT)", func(s ast.Stmt) bool { return s.(*ast.CreateTable).Temporary }},
+		{"DROP TABLE IF EXISTS t", func(s ast.Stmt) bool {
+			d := s.(*ast.DropTable)
+			return d.Name == "t" && d.IfExists
+		}},
+		{"CREATE UNIQUE INDEX i ON t (a, b)", func(s ast.Stmt) bool {
+			ci := s.(*ast.CreateIndex)
+			return ci.Unique && ci.Table == "t" && slices.Equal(ci.Columns, []string{"a", "b"})
+		}},
+		{"DROP INDEX i", func(s ast.Stmt) bool { return s.(*ast.DropIndex).Name == "i" }},
+		{"CREATE VIEW v (x) AS SELECT a FROM t", func(s ast.Stmt) bool {
+			v := s.(*ast.CreateView)
+			return v.Name == "v" && slices.Equal(v.Columns, []string{"x"}) && v.Query != nil && v.Text == "SELECT a FROM t"
+		}},
+		{"DROP VIEW v", func(s ast.Stmt) bool { return s.(*ast.DropView).Name == "v" }},
+		{"ALTER TABLE t ADD COLUMN c REAL", func(s ast.Stmt) bool {
+			a, ok := s.(*ast.AlterTable).Action.(*ast.AddColumn)
+			return ok && a.Column.Name == "c" && a.Column.Type == types.Real
+		}},
+		{"ALTER TABLE t DROP c", func(s ast.Stmt) bool {
+			a, ok := s.(*ast.AlterTable).Action.(*ast.DropColumn)
+			return ok && a.Name == "c"
+		}},
+		{"ALTER TABLE t RENAME COLUMN a TO b", func(s ast.Stmt) bool {
+			a, ok := s.(*ast.AlterTable).Action.(*ast.RenameColumn)
+			return ok && a.Old == "a" && a.New == "b"
+		}},
+		{"ALTER TABLE t RENAME TO u", func(s ast.Stmt) bool {
+			a, ok := s.(*ast.AlterTable).Action.(*ast.RenameTable)
+			return ok && a.To == "u"
+		}},
+		{"ALTER INDEX i RENAME TO j", func(s ast.Stmt) bool {
+			a := s.(*ast.AlterIndex)
+			return a.Name == "i" && a.To == "j"
+		}},
+		{"BEGIN TRANSACTION", func(s ast.Stmt) bool { _, ok := s.(*ast.Begin); return ok }},
+		{"COMMIT", func(s ast.Stmt) bool { _, ok := s.(*ast.Commit); return ok }},
+		{"ROLLBACK", func(s ast.Stmt) bool { _, ok := s.(*ast.Rollback); return ok }},
+	}
+	for _, tt := range tests {
+		s, err := Parse(tt.src)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", tt.src, err)
+			continue
+		}
+		if !tt.ok(s) {
+			t.Errorf("Parse(%q) = %#v", tt.src, s)
+		}
+	}
+}
+
+// TestParseErrors は、誤りのある文を拒否し、誤りの位置を知らせることを確かめます。
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		src, want string
+	}{
+		{"SELECT", "line 1, column 7"},
+		{"SELECT 1 FROM", "line 1, column 14"},
+		{"SELECT a FROM t\nWHERE", "line 2, column 6"},
+		{"SELECT 1 2", "end of statement"},
+		{"SELECT 'abc", "line 1, column 8"},
+		{"CREATE TABLE t ()", "line 1, column 17"},
+		{"CREATE TABLE t (a BOGUS)", "BOGUS"},
+		{"INSERT INTO t VALUES (1", "line 1, column 24"},
+		{"SELECT * FROM select", "line 1, column 15"},
+		{"DROP", "line 1, column 5"},
+	}
+	for _, tt := range tests {
+		_, err := Parse(tt.src)
+		if err == nil {
+			t.Errorf("Parse(%q) succeeded, want an error", tt.src)
+			continue
+		}
+		if !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("Parse(%q) = %v, want an error containing %q", tt.src, err, tt.want)
+		}
+	}
+	if _, err := ParseExpr("1 +"); err == nil {
+		t.Error(`ParseExpr("1 +") succeeded`)
+	}
+}
+
+// TestParseParams は、? と $N のパラメータの数を数えることを確かめます。
+func TestParseParams(t *testing.T) {
+	tests := []struct {
+		src string
+		n   int
+	}{
+		{"SELECT 1", 0},
+		{"SELECT ? + ? FROM t WHERE a = ?", 3},
+		{"SELECT $2, $1", 2},
+		{"SELECT $3, ?", 4}, // ? はそれまでの最大の番号の次
+	}
+	for _, tt := range tests {
+		_, n, err := ParseParams(tt.src)
+		if err != nil || n != tt.n {
+			t.Errorf("ParseParams(%q) = %d, %v, want %d", tt.src, n, err, tt.n)
+		}
+	}
+	if _, _, err := ParseParams("SELECT $0"); err == nil {
+		t.Error("parameter $0 was accepted")
+	}
+}
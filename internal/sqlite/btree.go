@@ -0,0 +1,242 @@
+package sqlite
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"unicode/utf16"
+)
+
+// B 木のページの種類です。
+const (
+	pageIndexInterior = 0x02
+	pageTableInterior = 0x05
+	pageIndexLeaf     = 0x0a
+	pageTableLeaf     = 0x0d
+)
+
+// walker は1つの B 木をたどります。壊れたファイルで無限にたどらないように、訪れたページを覚えます。
+type walker struct {
+	db    *File
+	index bool // インデックスの B 木（WITHOUT ROWID のテーブルを含む）か
+	seen  map[uint32]bool
+	fn    func(rowid int64, payload []byte) error
+}
+
+// walk は根が root の B 木のセルのペイロードをキーの順に fn に渡します。テーブルの B 木なら
+// rowid も渡します。
+func (db *File) walk(root uint32, index bool, fn func(rowid int64, payload []byte) error) error {
+	w := &walker{db: db, index: index, seen: make(map[uint32]bool), fn: fn}
+	return w.page(root)
+}
+
+func (w *walker) page(pgno uint32) error {
+	if w.seen[pgno] {
+		return fmt.Errorf("%w: page %d is referenced twice", ErrCorrupt, pgno)
+	}
+	w.seen[pgno] = true
+	buf, err := w.db.page(pgno)
+	if err != nil {
+		return err
+	}
+	hdr := 0
+	if pgno == 1 {
+		hdr = 100 // 1ページ目はファイルのヘッダの後から始まる
+	}
+	var interior bool
+	switch typ := buf[hdr]; {
+	case typ == pageTableLeaf && !w.index, typ == pageIndexLeaf && w.index:
+	case typ == pageTableInterior && !w.index, typ == pageIndexInterior && w.index:
+		interior = true
+	default:
+		return fmt.Errorf("%w: page %d has unexpected type %#x", ErrCorrupt, pgno, typ)
+	}
+	ncells := int(binary.BigEndian.Uint16(buf[hdr+3:]))
+	ptrs := hdr + 8
+	if interior {
+		ptrs = hdr + 12
+	}
+	if ptrs+2*ncells > w.db.usable {
+		return fmt.Errorf("%w: page %d has too many cells", ErrCorrupt, pgno)
+	}
+	for i := range ncells {
+		off := int(binary.BigEndian.Uint16(buf[ptrs+2*i:]))
+		if off < ptrs+2*ncells || off >= w.db.usable {
+			return fmt.Errorf("%w: page %d cell %d is out of range", ErrCorrupt, pgno, i)
+		}
+		cell := buf[off:w.db.usable]
+		if interior {
+			if len(cell) < 4 {
+				return fmt.Errorf("%w: page %d cell %d is truncated", ErrCorrupt, pgno, i)
+			}
+			if err := w.page(binary.BigEndian.Uint32(cell)); err != nil {
+				return err
+			}
+			if !w.index {
+				continue // テーブルの内部ノードのセルは rowid の区切りだけを持つ
+			}
+			cell = cell[4:]
+		}
+		rowid, payload, err := w.cell(cell)
+		if err != nil {
+			return fmt.Errorf("page %d cell %d: %w", pgno, i, err)
+		}
+		if err := w.fn(rowid, payload); err != nil {
+			return err
+		}
+	}
+	if interior {
+		return w.page(binary.BigEndian.Uint32(buf[hdr+8:]))
+	}
+	return nil
+}
+
+// cell はセル c の rowid（テーブルの B 木の場合）とペイロードを読みます。
+func (w *walker) cell(c []byte) (int64, []byte, error) {
+	size, n := varint(c)
+	if n == 0 {
+		return 0, nil, ErrCorrupt
+	}
+	c = c[n:]
+	var rowid int64
+	if !w.index {
+		r, n := varint(c)
+		if n == 0 {
+			return 0, nil, ErrCorrupt
+		}
+		rowid, c = int64(r), c[n:]
+	}
+	if size > uint64(w.db.nPages)*uint64(w.db.pageSize) {
+		return 0, nil, fmt.Errorf("%w: payload of %d bytes", ErrCorrupt, size)
+	}
+	payload, err := w.payload(c, int(size))
+	return rowid, payload, err
+}
+
+// payload はセルの大きさ size のペイロードを、ページに収まらない部分をオーバーフローページから
+// 読んでつなげて返します。c はセルのペイロードの先頭からです。
+func (w *walker) payload(c []byte, size int) ([]byte, error) {
+	u := w.db.usable
+	maxLocal := u - 35
+	if w.index {
+		maxLocal = (u-12)*64/255 - 23
+	}
+	if size <= maxLocal {
+		if len(c) < size {
+			return nil, ErrCorrupt
+		}
+		return c[:size], nil
+	}
+	minLocal := (u-12)*32/255 - 23
+	local := minLocal + (size-minLocal)%(u-4)
+	if local > maxLocal {
+		local = minLocal
+	}
+	if len(c) < local+4 {
+		return nil, ErrCorrupt
+	}
+	out := make([]byte, 0, size)
+	out = append(out, c[:local]...)
+	next := binary.BigEndian.Uint32(c[local:])
+	for len(out) < size {
+		if next == 0 || w.seen[next] {
+			return nil, fmt.Errorf("%w: broken overflow chain", ErrCorrupt)
+		}
+		w.seen[next] = true
+		pg, err := w.db.page(next)
+		if err != nil {
+			return nil, err
+		}
+		n := min(u-4, size-len(out))
+		out = append(out, pg[4:4+n]...)
+		next = binary.BigEndian.Uint32(pg)
+	}
+	return out, nil
+}
+
+// varint は SQLite の可変長整数を読み、値と読んだバイト数を返します。b が途中で終わっていれば
+// バイト数は 0 です。
+func varint(b []byte) (uint64, int) {
+	var v uint64
+	for i := 0; i < 8; i++ {
+		if i >= len(b) {
+			return 0, 0
+		}
+		v = v<<7 | uint64(b[i]&0x7f)
+		if b[i] < 0x80 {
+			return v, i + 1
+		}
+	}
+	if len(b) < 9 {
+		return 0, 0
+	}
+	return v<<8 | uint64(b[8]), 9
+}
+
+// intSizes はシリアル型 1〜6 の整数のバイト数です。
+var intSizes = [...]int{1: 1, 2: 2, 3: 3, 4: 4, 5: 6, 6: 8}
+
+// record はレコード b の値を読みます。値は nil、int64、float64、string、[]byte のどれかです。
+func (db *File) record(b []byte) ([]any, error) {
+	hs, n := varint(b)
+	if n == 0 || hs < uint64(n) || hs > uint64(len(b)) {
+		return nil, fmt.Errorf("%w: invalid record header", ErrCorrupt)
+	}
+	hdr, body := b[n:hs], b[hs:]
+	var vals []any
+	for len(hdr) > 0 {
+		st, n := varint(hdr)
+		if n == 0 {
+			return nil, fmt.Errorf("%w: invalid record header", ErrCorrupt)
+		}
+		hdr = hdr[n:]
+		size := 0
+		switch {
+		case st >= 1 && st <= 6:
+			size = intSizes[st]
+		case st == 7:
+			size = 8
+		case st == 10 || st == 11:
+			return nil, fmt.Errorf("%w: reserved serial type %d", ErrCorrupt, st)
+		case st >= 12:
+			size = int((st - 12) / 2)
+		}
+		if size > len(body) {
+			return nil, fmt.Errorf("%w: record is truncated", ErrCorrupt)
+		}
+		v := body[:size]
+		body = body[size:]
+		switch {
+		case st == 0:
+			vals = append(vals, nil)
+		case st <= 6:
+			var x int64
+			for _, c := range v {
+				x = x<<8 | int64(c)
+			}
+			shift := 64 - 8*size // 符号を広げる
+			vals = append(vals, x<<shift>>shift)
+		case st == 7:
+			vals = append(vals, math.Float64frombits(binary.BigEndian.Uint64(v)))
+		case st == 8 || st == 9:
+			vals = append(vals, int64(st-8))
+		case st%2 == 0:
+			vals = append(vals, append([]byte{}, v...))
+		default:
+			vals = append(vals, db.text(v))
+		}
+	}
+	return vals, nil
+}
+
+// text はデータベースの符号化のテキスト b を文字列にします。
+func (db *File) text(b []byte) string {
+	if db.utf16 == nil {
+		return string(b)
+	}
+	u := make([]uint16, len(b)/2)
+	for i := range u {
+		u[i] = db.utf16.Uint16(b[2*i:])
+	}
+	return string(utf16.Decode(u))
+}
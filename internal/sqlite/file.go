@@ -0,0 +1,195 @@
+// Package sqlite は SQLite 3 のデータベースファイルを読みます。SQLite から minirdb に移行する
+// ために、スキーマ（sqlite_schema）とテーブルの行を読むことだけを目的にしていて、書き込みや
+// SQL の実行はしません。WAL モードのデータベースは、-wal ファイルのコミットされたフレームも
+// 読みます。
+package sqlite
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+var (
+	// ErrNotSQLite は SQLite 3 のデータベースファイルではないファイルを開いた場合のエラーです。
+	ErrNotSQLite = errors.New("not a SQLite 3 database file")
+	// ErrCorrupt はファイルの構造が壊れている場合のエラーです。
+	ErrCorrupt = errors.New("malformed SQLite database file")
+)
+
+// magic はデータベースファイルの先頭の16バイトです。
+const magic = "SQLite format 3\x00"
+
+// File は読み込み用に開いた SQLite のデータベースファイルです。
+type File struct {
+	f        *os.File
+	pageSize int
+	usable   int    // ページのうち末尾の予約領域を除いた大きさ
+	nPages   uint32 // データベースのページ数
+	// utf16 はテキストの符号化が UTF-16 ならそのバイト順です。UTF-8 なら nil です。
+	utf16 binary.ByteOrder
+	// wal は -wal ファイルでコミットされたページの最新のイメージです。
+	wal map[uint32][]byte
+}
+
+// Open は path の SQLite のデータベースファイルを開きます。WAL モードで path-wal があれば、
+// コミットされたフレームのページをファイルのページより優先して読みます。
+func Open(path string) (*File, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	db, walMode, err := open(f)
+	if err == nil && walMode {
+		err = db.readWAL(path + "-wal")
+	}
+	if err == nil {
+		err = db.readHeader()
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// open はデータベースファイルのヘッダからページの大きさとページ数を読みます。WAL モードの
+// データベースなら walMode を返します。
+func open(f *os.File) (db *File, walMode bool, err error) {
+	var hdr [100]byte
+	if _, err := io.ReadFull(f, hdr[:]); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, false, ErrNotSQLite
+		}
+		return nil, false, err
+	}
+	if string(hdr[:16]) != magic {
+		return nil, false, ErrNotSQLite
+	}
+	ps := int(binary.BigEndian.Uint16(hdr[16:]))
+	if ps == 1 {
+		ps = 65536
+	}
+	if ps < 512 || ps > 65536 || ps&(ps-1) != 0 {
+		return nil, false, fmt.Errorf("%w: invalid page size %d", ErrCorrupt, ps)
+	}
+	db = &File{f: f, pageSize: ps}
+	st, err := f.Stat()
+	if err != nil {
+		return nil, false, err
+	}
+	db.nPages = uint32(st.Size() / int64(ps))
+	// ヘッダのページ数は、書いたバージョンの記録が変更カウンタと一致するときだけ正しい
+	if n := binary.BigEndian.Uint32(hdr[28:]); n != 0 && bytes.Equal(hdr[24:28], hdr[92:96]) {
+		db.nPages = n
+	}
+	return db, hdr[18] == 2, nil
+}
+
+// readHeader は1ページ目（WAL にあればそのイメージ）のヘッダから、ページの予約領域の大きさと
+// テキストの符号化を読みます。新しいデータベースでは、ファイルのヘッダにはまだ書かれていないことがあります。
+func (db *File) readHeader() error {
+	hdr, err := db.page(1)
+	if err != nil {
+		return err
+	}
+	db.usable = db.pageSize - int(hdr[20])
+	if db.usable < 480 {
+		return fmt.Errorf("%w: %d reserved bytes per page", ErrCorrupt, hdr[20])
+	}
+	switch enc := binary.BigEndian.Uint32(hdr[56:]); enc {
+	case 0, 1:
+	case 2:
+		db.utf16 = binary.LittleEndian
+	case 3:
+		db.utf16 = binary.BigEndian
+	default:
+		return fmt.Errorf("%w: unknown text encoding %d", ErrCorrupt, enc)
+	}
+	return nil
+}
+
+// Close はファイルを閉じます。
+func (db *File) Close() error { return db.f.Close() }
+
+// readWAL は -wal ファイル path のフレームのうち、最後のコミットまでのものを読みます。
+// SQLite と同じように、ヘッダやチェックサムが一致しないところから先は無視します。
+func (db *File) readWAL(path string) error {
+	db.wal = make(map[uint32][]byte)
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil || len(b) < 32 {
+		return err
+	}
+	var order binary.ByteOrder
+	switch binary.BigEndian.Uint32(b) {
+	case 0x377f0682:
+		order = binary.LittleEndian
+	case 0x377f0683:
+		order = binary.BigEndian
+	default:
+		return nil
+	}
+	if int(binary.BigEndian.Uint32(b[8:])) != db.pageSize {
+		return nil
+	}
+	s0, s1 := walChecksum(order, b[:24], 0, 0)
+	if s0 != binary.BigEndian.Uint32(b[24:]) || s1 != binary.BigEndian.Uint32(b[28:]) {
+		return nil
+	}
+	salt := b[16:24]
+	frames := make(map[uint32][]byte) // 最後のコミットより後のフレーム
+	for off := 32; off+24+db.pageSize <= len(b); off += 24 + db.pageSize {
+		fh, page := b[off:off+24], b[off+24:off+24+db.pageSize]
+		if !bytes.Equal(fh[8:16], salt) {
+			break
+		}
+		s0, s1 = walChecksum(order, fh[:8], s0, s1)
+		s0, s1 = walChecksum(order, page, s0, s1)
+		if s0 != binary.BigEndian.Uint32(fh[16:]) || s1 != binary.BigEndian.Uint32(fh[20:]) {
+			break
+		}
+		frames[binary.BigEndian.Uint32(fh)] = page
+		// コミットのフレームにはコミットした後のデータベースのページ数がある
+		if n := binary.BigEndian.Uint32(fh[4:]); n != 0 {
+			for pgno, p := range frames {
+				db.wal[pgno] = p
+			}
+			clear(frames)
+			db.nPages = n
+		}
+	}
+	return nil
+}
+
+// walChecksum は WAL のチェックサム (s0, s1) に b を加えたものを返します。
+func walChecksum(order binary.ByteOrder, b []byte, s0, s1 uint32) (uint32, uint32) {
+	for i := 0; i+8 <= len(b); i += 8 {
+		s0 += order.Uint32(b[i:]) + s1
+		s1 += order.Uint32(b[i+4:]) + s0
+	}
+	return s0, s1
+}
+
+// page はページ番号 pgno（1 から始まる）のページを読みます。
+func (db *File) page(pgno uint32) ([]byte, error) {
+	if pgno == 0 || pgno > db.nPages {
+		return nil, fmt.Errorf("%w: page %d out of range", ErrCorrupt, pgno)
+	}
+	if p, ok := db.wal[pgno]; ok {
+		return p, nil
+	}
+	buf := make([]byte, db.pageSize)
+	if _, err := db.f.ReadAt(buf, int64(pgno-1)*int64(db.pageSize)); err != nil {
+		if err == io.EOF {
+			return nil, fmt.Errorf("%w: page %d is past the end of the file", ErrCorrupt, pgno)
+		}
+		return nil, err
+	}
+	return buf, nil
+}
@@ -0,0 +1,245 @@
+package sqlite
+
+import (
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testdata のデータベースは SQLite 3.40 でページの大きさを 512 にして作りました。
+//
+//   - rollback.db: items（INTEGER PRIMARY KEY の 300 行。7 行目の note はオーバーフローページに
+//     またがる 2000 文字で、行を入れた後に ALTER TABLE ADD COLUMN qty INT DEFAULT 5 をしてから
+//     301 行目を入れた）、インデックス items_name、WITHOUT ROWID の pairs、VIRTUAL と STORED の
+//     生成列を持つ gen、ビュー cheap
+//   - utf16.db: PRAGMA encoding = 'UTF-16be' の t
+//   - wal.db: WAL モードの log。1 行目はデータベースファイルにあり、2 行目と 3 行目はそれぞれ
+//     1 つのトランザクションで wal.db-wal に書かれている
+
+// readTable はデータベース db のテーブル name の行をすべて読みます。
+func readTable(t *testing.T, db *File, name string) [][]any {
+	t.Helper()
+	objs, err := db.Schema()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, o := range objs {
+		if o.Type != "table" || o.Name != name {
+			continue
+		}
+		tbl, err := ParseTable(o)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var rows [][]any
+		if err := db.Rows(tbl, func(row []any) error {
+			rows = append(rows, row)
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+		return rows
+	}
+	t.Fatalf("no table %s", name)
+	return nil
+}
+
+func mustOpen(t *testing.T, path string) *File {
+	t.Helper()
+	db, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// TestRows は、スキーマと、複数のページにまたがるテーブル、オーバーフローページ、ALTER TABLE で
+// 足した列、WITHOUT ROWID のテーブル、生成列の行を読めることを確かめます。
+func TestRows(t *testing.T) {
+	db := mustOpen(t, "testdata/rollback.db")
+	objs, err := db.Schema()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, o := range objs {
+		got = append(got, o.Type+" "+o.Name+" "+o.Table)
+		if (o.RootPage == 0) != (o.Type == "view") {
+			t.Errorf("%s %s has root page %d", o.Type, o.Name, o.RootPage)
+		}
+	}
+	want := []string{"table items items", "index items_name items", "table pairs pairs", "table gen gen", "view cheap cheap"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Schema = %q, want %q", got, want)
+	}
+
+	items := readTable(t, db, "items")
+	if len(items) != 301 {
+		t.Fatalf("items has %d rows, want 301", len(items))
+	}
+	if want := []any{int64(1), "item1", 0.5, []byte{0x00, 0xff}, nil, int64(5)}; !reflect.DeepEqual(items[0], want) {
+		t.Errorf("items row 1 = %v, want %v", items[0], want)
+	}
+	if note, _ := items[6][4].(string); note != strings.Repeat("a", 2000) {
+		t.Errorf("items row 7 has a note of %d bytes, want 2000", len(note))
+	}
+	if want := []any{int64(301), "last", nil, nil, nil, int64(9)}; !reflect.DeepEqual(items[300], want) {
+		t.Errorf("items row 301 = %v, want %v", items[300], want)
+	}
+	for i, row := range items {
+		if row[0] != int64(i+1) {
+			t.Fatalf("items row %d has id %v", i+1, row[0])
+		}
+	}
+
+	tests := []struct {
+		table string
+		want  [][]any
+	}{
+		{"pairs", [][]any{{"y", int64(1), "first"}, {"x", int64(2), "second"}}},
+		{"gen", [][]any{{int64(3), nil, int64(4)}}},
+	}
+	for _, tt := range tests {
+		if got := readTable(t, db, tt.table); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s rows = %v, want %v", tt.table, got, tt.want)
+		}
+	}
+}
+
+// TestUTF16 は、UTF-16 のデータベースのテキストを UTF-8 にして読むことを確かめます。
+func TestUTF16(t *testing.T) {
+	db := mustOpen(t, "testdata/utf16.db")
+	want := [][]any{{"héllo"}, {"日本語"}}
+	if got := readTable(t, db, "t"); !reflect.DeepEqual(got, want) {
+		t.Errorf("rows = %v, want %v", got, want)
+	}
+}
+
+// TestWAL は、-wal ファイルのフレームを最後のコミットまで読み、途中で切れたフレームやチェックサムの
+// 合わないフレーム、コミットしていないフレームから先を無視することを確かめます。
+func TestWAL(t *testing.T) {
+	wal, err := os.ReadFile("testdata/wal.db-wal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	const frameSize = 24 + 512
+	if len(wal) != 32+2*frameSize {
+		t.Fatalf("testdata/wal.db-wal has %d bytes, want 2 frames", len(wal))
+	}
+	if last := wal[32+frameSize:]; binary.BigEndian.Uint32(wal) != 0x377f0682 || binary.BigEndian.Uint32(last[4:]) == 0 {
+		t.Fatal("testdata/wal.db-wal is not a little-endian WAL ending with a commit frame")
+	}
+
+	tests := []struct {
+		name   string
+		modify func(b []byte) []byte // nil なら -wal ファイルを置かない
+		want   []string
+	}{
+		{"no wal", nil, []string{"base"}},
+		{"committed", func(b []byte) []byte { return b }, []string{"base", "one", "two"}},
+		{"torn frame", func(b []byte) []byte { return b[:len(b)-100] }, []string{"base", "one"}},
+		{"bad checksum", func(b []byte) []byte {
+			b[len(b)-1] ^= 1
+			return b
+		}, []string{"base", "one"}},
+		{"bad salt", func(b []byte) []byte {
+			b[32+frameSize+8] ^= 1
+			return b
+		}, []string{"base", "one"}},
+		{"uncommitted", func(b []byte) []byte {
+			// 最後のフレームのコミットの印を消して、チェックサムを計算し直す
+			fh := b[32+frameSize:]
+			binary.BigEndian.PutUint32(fh[4:], 0)
+			prev := b[32 : 32+frameSize]
+			s0, s1 := walChecksum(binary.LittleEndian, fh[:8], binary.BigEndian.Uint32(prev[16:]), binary.BigEndian.Uint32(prev[20:]))
+			s0, s1 = walChecksum(binary.LittleEndian, fh[24:frameSize], s0, s1)
+			binary.BigEndian.PutUint32(fh[16:], s0)
+			binary.BigEndian.PutUint32(fh[20:], s1)
+			return b
+		}, []string{"base", "one"}},
+		{"bad header", func(b []byte) []byte {
+			b[12] ^= 1
+			return b
+		}, []string{"base"}},
+		{"empty", func(b []byte) []byte { return nil }, []string{"base"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			data, err := os.ReadFile("testdata/wal.db")
+			if err != nil {
+				t.Fatal(err)
+			}
+			path := filepath.Join(dir, "wal.db")
+			if err := os.WriteFile(path, data, 0o644); err != nil {
+				t.Fatal(err)
+			}
+			if tt.modify != nil {
+				if err := os.WriteFile(path+"-wal", tt.modify(append([]byte(nil), wal...)), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			var got []string
+			for _, row := range readTable(t, mustOpen(t, path), "log") {
+				got = append(got, row[1].(string))
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("rows = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestOpenErrors は、SQLite のデータベースファイルでないファイルと壊れたヘッダを拒否することを確かめます。
+func TestOpenErrors(t *testing.T) {
+	good, err := os.ReadFile("testdata/rollback.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	badPageSize := append([]byte(nil), good...)
+	binary.BigEndian.PutUint16(badPageSize[16:], 1000)
+	badEncoding := append([]byte(nil), good...)
+	binary.BigEndian.PutUint32(badEncoding[56:], 4)
+	badRoot := append([]byte(nil), good[:512]...)
+	badRoot[100] = 0 // 1ページ目の B 木のページの種類
+
+	tests := []struct {
+		name string
+		data []byte
+		want error
+	}{
+		{"empty", nil, ErrNotSQLite},
+		{"text", []byte(strings.Repeat("not a database\n", 10)), ErrNotSQLite},
+		{"page size", badPageSize, ErrCorrupt},
+		{"encoding", badEncoding, ErrCorrupt},
+	}
+	dir := t.TempDir()
+	for _, tt := range tests {
+		path := filepath.Join(dir, tt.name)
+		if err := os.WriteFile(path, tt.data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if db, err := Open(path); !errors.Is(err, tt.want) {
+			t.Errorf("%s: err = %v, want %v", tt.name, err, tt.want)
+			if err == nil {
+				db.Close()
+			}
+		}
+	}
+
+	path := filepath.Join(dir, "bad root")
+	if err := os.WriteFile(path, badRoot, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mustOpen(t, path).Schema(); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("Schema of a bad first page: err = %v, want ErrCorrupt", err)
+	}
+	if _, err := Open(filepath.Join(dir, "missing")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Open of a missing file: err = %v, want os.ErrNotExist", err)
+	}
+}
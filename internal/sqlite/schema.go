@@ -0,0 +1,677 @@
+package sqlite
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Object は sqlite_schema の1行（テーブル、インデックス、ビュー、トリガー）です。
+type Object struct {
+	Type     string // "table"、"index"、"view"、"trigger"
+	Name     string
+	Table    string // インデックスとトリガーの対象のテーブル
+	RootPage uint32 // テーブルとインデックスの B 木の根（ビューやトリガーは 0）
+	SQL      string // 作った文（自動で作ったインデックスなら空）
+}
+
+// Schema は sqlite_schema のオブジェクトを、ファイルに書かれている順に返します。
+func (db *File) Schema() ([]Object, error) {
+	var objs []Object
+	err := db.walk(1, false, func(_ int64, payload []byte) error {
+		rec, err := db.record(payload)
+		if err != nil {
+			return err
+		}
+		if len(rec) < 5 {
+			return fmt.Errorf("%w: sqlite_schema row has %d columns", ErrCorrupt, len(rec))
+		}
+		var o Object
+		o.Type, _ = rec[0].(string)
+		o.Name, _ = rec[1].(string)
+		o.Table, _ = rec[2].(string)
+		if root, ok := rec[3].(int64); ok && root > 0 && root <= int64(db.nPages) {
+			o.RootPage = uint32(root)
+		}
+		o.SQL, _ = rec[4].(string)
+		objs = append(objs, o)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("read sqlite_schema: %w", err)
+	}
+	return objs, nil
+}
+
+// ErrVirtualTable は仮想テーブル（CREATE VIRTUAL TABLE）を ParseTable に渡した場合のエラーです。
+// 仮想テーブルの行はモジュールが持つので、ファイルからは読めません。
+var ErrVirtualTable = errors.New("virtual tables cannot be read")
+
+// Table は CREATE TABLE 文から読んだテーブルの定義です。
+type Table struct {
+	Name         string
+	Columns      []Column
+	PrimaryKey   []string   // 主キーの列（表の制約で指定したものも含む）
+	Unique       [][]string // UNIQUE 制約ごとの列
+	WithoutRowID bool
+	root         uint32
+}
+
+// Column はテーブルの列の定義です。
+type Column struct {
+	Name          string
+	Type          string // 宣言した型（型を書いていなければ空）
+	NotNull       bool
+	PrimaryKey    bool
+	AutoIncrement bool
+	// Default は DEFAULT の定数（nil、int64、float64、string、[]byte）です。式の既定値は nil です。
+	// ALTER TABLE ADD COLUMN より前に書かれた行では、足りない列の値になります。
+	Default any
+	// Generated は GENERATED ALWAYS AS の列です。Virtual なら値を行に格納しないので、
+	// Rows では常に nil になります。
+	Generated bool
+	Virtual   bool
+	// RowID は rowid の別名の列（INTEGER PRIMARY KEY）です。値を省略して挿入すると、SQLite は
+	// それまでの最大の値より大きい値を割り当てます。
+	RowID  bool
+	unique bool // 列の制約の UNIQUE
+}
+
+// Affinity は列の型の親和性です。
+type Affinity int
+
+const (
+	AffinityNumeric Affinity = iota
+	AffinityInteger
+	AffinityText
+	AffinityBlob // BLOB か、型を書いていない列
+	AffinityReal
+)
+
+// Affinity は SQLite の規則で、宣言した型から列の型の親和性を決めます。
+func (c *Column) Affinity() Affinity {
+	t := strings.ToUpper(c.Type)
+	switch {
+	case strings.Contains(t, "INT"):
+		return AffinityInteger
+	case strings.Contains(t, "CHAR"), strings.Contains(t, "CLOB"), strings.Contains(t, "TEXT"):
+		return AffinityText
+	case t == "", strings.Contains(t, "BLOB"):
+		return AffinityBlob
+	case strings.Contains(t, "REAL"), strings.Contains(t, "FLOA"), strings.Contains(t, "DOUB"):
+		return AffinityReal
+	}
+	return AffinityNumeric
+}
+
+// ParseTable は sqlite_schema のテーブル o の CREATE TABLE 文を読みます。
+func ParseTable(o Object) (*Table, error) {
+	p, err := newParser(o.SQL)
+	if err != nil {
+		return nil, err
+	}
+	if !p.accept("CREATE") {
+		return nil, fmt.Errorf("table %s: not a CREATE TABLE statement", o.Name)
+	}
+	p.accept("TEMP", "TEMPORARY")
+	if p.accept("VIRTUAL") {
+		return nil, fmt.Errorf("table %s: %w", o.Name, ErrVirtualTable)
+	}
+	if !p.accept("TABLE") {
+		return nil, fmt.Errorf("table %s: not a CREATE TABLE statement", o.Name)
+	}
+	if p.accept("IF") {
+		p.accept("NOT")
+		p.accept("EXISTS")
+	}
+	p.qualifiedName()
+	defs, ok := p.group()
+	if !ok {
+		return nil, fmt.Errorf("table %s: missing column definitions", o.Name)
+	}
+	t := &Table{Name: o.Name, root: o.RootPage}
+	desc := false // 列の制約の PRIMARY KEY DESC
+	for _, def := range split(defs) {
+		if len(def) == 0 {
+			continue
+		}
+		if def[0].kind == tokWord && tableConstraints[strings.ToUpper(def[0].text)] {
+			t.tableConstraint(def)
+			continue
+		}
+		c, d := parseColumn(def)
+		desc = desc || d
+		if c.PrimaryKey {
+			t.PrimaryKey = append(t.PrimaryKey, c.Name)
+		}
+		if c.unique {
+			t.Unique = append(t.Unique, []string{c.Name})
+		}
+		t.Columns = append(t.Columns, c)
+	}
+	for p.i < len(p.toks) {
+		if p.accept("WITHOUT") && p.accept("ROWID") {
+			t.WithoutRowID = true
+			continue
+		}
+		p.i++
+	}
+	if len(t.Columns) == 0 {
+		return nil, fmt.Errorf("table %s has no columns", o.Name)
+	}
+	for i := range t.Columns {
+		c := &t.Columns[i]
+		c.PrimaryKey = c.PrimaryKey || contains(t.PrimaryKey, c.Name)
+	}
+	if !t.WithoutRowID && len(t.PrimaryKey) == 1 && !desc {
+		if i := t.column(t.PrimaryKey[0]); i >= 0 && strings.EqualFold(t.Columns[i].Type, "INTEGER") {
+			t.Columns[i].RowID = true
+		}
+	}
+	if t.WithoutRowID && len(t.PrimaryKey) == 0 {
+		return nil, fmt.Errorf("table %s: WITHOUT ROWID table has no primary key", o.Name)
+	}
+	return t, nil
+}
+
+// tableConstraints は表の制約を始めるキーワードです。
+var tableConstraints = map[string]bool{"CONSTRAINT": true, "PRIMARY": true, "UNIQUE": true, "CHECK": true, "FOREIGN": true}
+
+// tableConstraint は表の制約 def の PRIMARY KEY と UNIQUE を t に加えます。
+func (t *Table) tableConstraint(def []token) {
+	p := &parser{toks: def}
+	if p.accept("CONSTRAINT") {
+		p.i++
+	}
+	switch {
+	case p.accept("PRIMARY"):
+		p.accept("KEY")
+		if cols, ok := p.group(); ok {
+			t.PrimaryKey = append(t.PrimaryKey, indexedColumns(cols)...)
+		}
+	case p.accept("UNIQUE"):
+		if cols, ok := p.group(); ok {
+			t.Unique = append(t.Unique, indexedColumns(cols))
+		}
+	}
+}
+
+// column は名前が name の列の位置を返します。なければ -1 を返します。
+func (t *Table) column(name string) int {
+	for i, c := range t.Columns {
+		if strings.EqualFold(c.Name, name) {
+			return i
+		}
+	}
+	return -1
+}
+
+// columnKeywords は列の定義で型の名前の後に続く制約を始めるキーワードです。
+var columnKeywords = map[string]bool{
+	"CONSTRAINT": true, "PRIMARY": true, "NOT": true, "NULL": true, "UNIQUE": true, "CHECK": true,
+	"DEFAULT": true, "COLLATE": true, "REFERENCES": true, "GENERATED": true, "AS": true,
+}
+
+// parseColumn は列の定義 def を読みます。desc は PRIMARY KEY DESC と書いた列かです。
+func parseColumn(def []token) (c Column, desc bool) {
+	p := &parser{toks: def}
+	c.Name = p.next().text
+	var typ []string
+	for p.i < len(p.toks) {
+		tk := p.toks[p.i]
+		if tk.kind == tokPunct && tk.text == "(" && len(typ) > 0 {
+			g, _ := p.group()
+			typ[len(typ)-1] += "(" + joinTokens(g) + ")"
+			continue
+		}
+		if tk.kind != tokWord || columnKeywords[strings.ToUpper(tk.text)] || len(typ) > 0 && strings.HasSuffix(typ[len(typ)-1], ")") {
+			break
+		}
+		typ = append(typ, tk.text)
+		p.i++
+	}
+	c.Type = strings.Join(typ, " ")
+	for p.i < len(p.toks) {
+		switch {
+		case p.accept("PRIMARY"):
+			p.accept("KEY")
+			c.PrimaryKey = true
+			desc = p.accept("DESC")
+		case p.accept("AUTOINCREMENT"):
+			c.AutoIncrement = true
+		case p.accept("UNIQUE"):
+			c.unique = true
+		case p.accept("NOT"):
+			c.NotNull = p.accept("NULL") || c.NotNull
+		case p.accept("REFERENCES"):
+			p.skipForeignKey()
+		case p.accept("DEFAULT"):
+			c.Default = p.literal()
+		case p.accept("GENERATED"), p.accept("AS"):
+			c.Generated, c.Virtual = true, true
+		case p.accept("STORED"):
+			c.Virtual = false
+		case p.peek("("):
+			p.group() // CHECK や生成列の式
+		default:
+			p.i++
+		}
+	}
+	return c, desc
+}
+
+// skipForeignKey は列の制約の REFERENCES の後の外部キーの句を読み飛ばします。ON DELETE SET DEFAULT
+// などを列の制約と取り違えないように、次の列の制約の手前で止まります。
+func (p *parser) skipForeignKey() {
+	for p.i < len(p.toks) {
+		switch {
+		case p.accept("SET"):
+			p.i++ // NULL か DEFAULT
+		case p.peek("("):
+			p.group()
+		case p.toks[p.i].kind == tokWord && columnKeywords[strings.ToUpper(p.toks[p.i].text)] &&
+			!(strings.EqualFold(p.toks[p.i].text, "NOT") && p.i+1 < len(p.toks) && strings.EqualFold(p.toks[p.i+1].text, "DEFERRABLE")):
+			return
+		default:
+			p.i++
+		}
+	}
+}
+
+// Index は CREATE INDEX 文から読んだインデックスの定義です。
+type Index struct {
+	Name    string
+	Table   string
+	Columns []string
+	Unique  bool
+}
+
+// ParseIndex は CREATE INDEX 文 sql を読みます。式のインデックスと部分インデックスは
+// 列の一覧で表せないのでエラーにします。
+func ParseIndex(sql string) (*Index, error) {
+	p, err := newParser(sql)
+	if err != nil {
+		return nil, err
+	}
+	ix := &Index{}
+	if !p.accept("CREATE") {
+		return nil, errors.New("not a CREATE INDEX statement")
+	}
+	ix.Unique = p.accept("UNIQUE")
+	if !p.accept("INDEX") {
+		return nil, errors.New("not a CREATE INDEX statement")
+	}
+	if p.accept("IF") {
+		p.accept("NOT")
+		p.accept("EXISTS")
+	}
+	ix.Name = p.qualifiedName()
+	if !p.accept("ON") {
+		return nil, fmt.Errorf("index %s: missing ON", ix.Name)
+	}
+	ix.Table = p.qualifiedName()
+	cols, ok := p.group()
+	if !ok {
+		return nil, fmt.Errorf("index %s: missing columns", ix.Name)
+	}
+	for _, col := range split(cols) {
+		cp := &parser{toks: col}
+		name := cp.next()
+		if cp.accept("COLLATE") {
+			cp.i++
+		}
+		cp.accept("ASC", "DESC")
+		if !name.isName() || cp.i < len(col) {
+			return nil, fmt.Errorf("index %s is an expression index", ix.Name)
+		}
+		ix.Columns = append(ix.Columns, name.text)
+	}
+	if p.accept("WHERE") {
+		return nil, fmt.Errorf("index %s is a partial index", ix.Name)
+	}
+	return ix, nil
+}
+
+// Rows はテーブル t の行を、値を t.Columns の順に並べて fn に渡します。値は nil、int64、float64、
+// string、[]byte のどれかで、SQLite が格納したままの型です（列の宣言した型に合っているとは限りません）。
+func (db *File) Rows(t *Table, fn func(row []any) error) error {
+	// 行に格納される列の順。WITHOUT ROWID のテーブルでは主キーの列が先に来る
+	var order []int
+	if t.WithoutRowID {
+		for _, name := range t.PrimaryKey {
+			if i := t.column(name); i >= 0 && !containsInt(order, i) {
+				order = append(order, i)
+			}
+		}
+	}
+	for i, c := range t.Columns {
+		if !c.Virtual && !containsInt(order, i) {
+			order = append(order, i)
+		}
+	}
+	if t.root == 0 {
+		return fmt.Errorf("table %s has no b-tree", t.Name)
+	}
+	return db.walk(t.root, t.WithoutRowID, func(rowid int64, payload []byte) error {
+		rec, err := db.record(payload)
+		if err != nil {
+			return fmt.Errorf("table %s row %d: %w", t.Name, rowid, err)
+		}
+		row := make([]any, len(t.Columns))
+		for i, c := range t.Columns {
+			row[i] = c.Default
+			if c.Virtual {
+				row[i] = nil
+			}
+		}
+		for j, i := range order {
+			if j < len(rec) {
+				row[i] = rec[j]
+			}
+			if t.Columns[i].RowID {
+				row[i] = rowid
+			}
+		}
+		return fn(row)
+	})
+}
+
+func contains(names []string, name string) bool {
+	for _, n := range names {
+		if strings.EqualFold(n, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsInt(s []int, x int) bool {
+	for _, v := range s {
+		if v == x {
+			return true
+		}
+	}
+	return false
+}
+
+// 字句の種類です。
+const (
+	tokWord   = iota // キーワードか引用符のない識別子
+	tokQuoted        // "..."、[...]、`...` の識別子
+	tokString        // '...'
+	tokNumber
+	tokBlob // X'...'
+	tokPunct
+)
+
+// token は CREATE 文の字句です。text は引用符を外したものです。
+type token struct {
+	kind int
+	text string
+}
+
+// isName は tk が識別子に使える字句かを返します（SQLite は文字列も識別子として受け付けます）。
+func (tk token) isName() bool {
+	return tk.kind == tokWord || tk.kind == tokQuoted || tk.kind == tokString
+}
+
+// tokenize は SQL 文 sql を字句に分けます。コメントは読み飛ばします。
+func tokenize(sql string) ([]token, error) {
+	var toks []token
+	for i := 0; i < len(sql); {
+		c := sql[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f':
+			i++
+		case strings.HasPrefix(sql[i:], "--"):
+			if j := strings.IndexByte(sql[i:], '\n'); j >= 0 {
+				i += j + 1
+			} else {
+				i = len(sql)
+			}
+		case strings.HasPrefix(sql[i:], "/*"):
+			if j := strings.Index(sql[i+2:], "*/"); j >= 0 {
+				i += j + 4
+			} else {
+				i = len(sql)
+			}
+		case (c == 'x' || c == 'X') && i+1 < len(sql) && sql[i+1] == '\'':
+			s, n, err := quoted(sql[i+1:], '\'')
+			if err != nil {
+				return nil, err
+			}
+			toks = append(toks, token{tokBlob, s})
+			i += 1 + n
+		case c == '\'' || c == '"' || c == '`' || c == '[':
+			end, kind := c, tokQuoted
+			switch c {
+			case '[':
+				end = ']'
+			case '\'':
+				kind = tokString
+			}
+			s, n, err := quoted(sql[i:], end)
+			if err != nil {
+				return nil, err
+			}
+			toks = append(toks, token{kind, s})
+			i += n
+		case c >= '0' && c <= '9' || c == '.' && i+1 < len(sql) && sql[i+1] >= '0' && sql[i+1] <= '9':
+			j := i + 1
+			for j < len(sql) && (isWordByte(sql[j]) || sql[j] == '.' ||
+				(sql[j] == '+' || sql[j] == '-') && (sql[j-1] == 'e' || sql[j-1] == 'E')) {
+				j++
+			}
+			toks = append(toks, token{tokNumber, sql[i:j]})
+			i = j
+		case isWordByte(c):
+			j := i + 1
+			for j < len(sql) && isWordByte(sql[j]) {
+				j++
+			}
+			toks = append(toks, token{tokWord, sql[i:j]})
+			i = j
+		default:
+			toks = append(toks, token{tokPunct, sql[i : i+1]})
+			i++
+		}
+	}
+	return toks, nil
+}
+
+// quoted は引用符 s[0] で始まり end で終わる字句を読み、中身と読んだバイト数を返します。
+// end を2つ続けたものは1つの end にします。
+func quoted(s string, end byte) (string, int, error) {
+	var b strings.Builder
+	for i := 1; i < len(s); i++ {
+		if s[i] != end {
+			b.WriteByte(s[i])
+			continue
+		}
+		if end != ']' && i+1 < len(s) && s[i+1] == end {
+			b.WriteByte(end)
+			i++
+			continue
+		}
+		return b.String(), i + 1, nil
+	}
+	return "", 0, fmt.Errorf("unterminated quoted token: %.20s", s)
+}
+
+func isWordByte(c byte) bool {
+	return c == '_' || c == '$' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c >= 0x80
+}
+
+// parser は字句の列を前から読みます。
+type parser struct {
+	toks []token
+	i    int
+}
+
+func newParser(sql string) (*parser, error) {
+	toks, err := tokenize(sql)
+	if err != nil {
+		return nil, err
+	}
+	return &parser{toks: toks}, nil
+}
+
+// next は次の字句を読みます。もうなければ空の字句を返します。
+func (p *parser) next() token {
+	if p.i >= len(p.toks) {
+		return token{kind: tokPunct}
+	}
+	p.i++
+	return p.toks[p.i-1]
+}
+
+// accept は次の字句がキーワード kws のどれかなら読み進めて true を返します。
+func (p *parser) accept(kws ...string) bool {
+	if p.i >= len(p.toks) || p.toks[p.i].kind != tokWord {
+		return false
+	}
+	for _, kw := range kws {
+		if strings.EqualFold(p.toks[p.i].text, kw) {
+			p.i++
+			return true
+		}
+	}
+	return false
+}
+
+// peek は次の字句が記号 punct かを返します。
+func (p *parser) peek(punct string) bool {
+	return p.i < len(p.toks) && p.toks[p.i].kind == tokPunct && p.toks[p.i].text == punct
+}
+
+// qualifiedName は schema.name の形の名前を読み、name を返します。
+func (p *parser) qualifiedName() string {
+	name := p.next().text
+	for p.peek(".") {
+		p.i++
+		name = p.next().text
+	}
+	return name
+}
+
+// group は次の字句が "(" なら対応する ")" まで読み、その間の字句を返します。
+func (p *parser) group() ([]token, bool) {
+	if !p.peek("(") {
+		return nil, false
+	}
+	start, depth := p.i+1, 0
+	for ; p.i < len(p.toks); p.i++ {
+		if p.toks[p.i].kind != tokPunct {
+			continue
+		}
+		switch p.toks[p.i].text {
+		case "(":
+			depth++
+		case ")":
+			if depth--; depth == 0 {
+				p.i++
+				return p.toks[start : p.i-1], true
+			}
+		}
+	}
+	return p.toks[start:], true
+}
+
+// literal は DEFAULT の値を読みます。定数でなければ読み飛ばして nil を返します。
+func (p *parser) literal() any {
+	if g, ok := p.group(); ok {
+		// DEFAULT (0) のように括弧で囲んだ定数も受け付ける
+		sub := &parser{toks: g}
+		if v := sub.literal(); sub.i == len(g) {
+			return v
+		}
+		return nil
+	}
+	neg := false
+	if p.peek("-") || p.peek("+") {
+		neg = p.next().text == "-"
+	}
+	tk := p.next()
+	switch tk.kind {
+	case tokNumber:
+		if i, err := strconv.ParseInt(tk.text, 0, 64); err == nil {
+			if neg {
+				i = -i
+			}
+			return i
+		}
+		if f, err := strconv.ParseFloat(tk.text, 64); err == nil {
+			if neg {
+				f = -f
+			}
+			return f
+		}
+	case tokString:
+		return tk.text
+	case tokBlob:
+		b := make([]byte, len(tk.text)/2)
+		for i := range b {
+			x, err := strconv.ParseUint(tk.text[2*i:2*i+2], 16, 8)
+			if err != nil {
+				return nil
+			}
+			b[i] = byte(x)
+		}
+		return b
+	case tokWord:
+		switch strings.ToUpper(tk.text) {
+		case "TRUE":
+			return int64(1)
+		case "FALSE":
+			return int64(0)
+		}
+	}
+	return nil
+}
+
+// split は字句の列を、括弧の外のカンマで分けます。
+func split(toks []token) [][]token {
+	var parts [][]token
+	depth, start := 0, 0
+	for i, tk := range toks {
+		if tk.kind != tokPunct {
+			continue
+		}
+		switch tk.text {
+		case "(":
+			depth++
+		case ")":
+			depth--
+		case ",":
+			if depth == 0 {
+				parts = append(parts, toks[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(parts, toks[start:])
+}
+
+// indexedColumns は PRIMARY KEY (...) や UNIQUE (...) の中の列の名前を返します。
+func indexedColumns(toks []token) []string {
+	var cols []string
+	for _, part := range split(toks) {
+		if len(part) > 0 && part[0].isName() {
+			cols = append(cols, part[0].text)
+		}
+	}
+	return cols
+}
+
+// joinTokens は型の引数 (10, 2) の字句をつなげます。
+func joinTokens(toks []token) string {
+	var b strings.Builder
+	for _, tk := range toks {
+		b.WriteString(tk.text)
+		if tk.text == "," {
+			b.WriteByte(' ')
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,154 @@
+package sqlite
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestParseTable は、CREATE TABLE 文から列の型と制約、主キー、rowid の別名の列を読むことを確かめます。
+func TestParseTable(t *testing.T) {
+	tests := []struct {
+		sql  string
+		want Table
+	}{
+		{
+			"CREATE TABLE t (id INTEGER PRIMARY KEY, name VARCHAR(20) NOT NULL UNIQUE, price DECIMAL(10,2) DEFAULT -1.5, flag BOOLEAN DEFAULT TRUE)",
+			Table{Name: "t", Columns: []Column{
+				{Name: "id", Type: "INTEGER", PrimaryKey: true, RowID: true},
+				{Name: "name", Type: "VARCHAR(20)", NotNull: true, unique: true},
+				{Name: "price", Type: "DECIMAL(10, 2)", Default: -1.5},
+				{Name: "flag", Type: "BOOLEAN", Default: int64(1)},
+			}, PrimaryKey: []string{"id"}, Unique: [][]string{{"name"}}},
+		},
+		{
+			"CREATE TABLE IF NOT EXISTS main.\"odd \"\"name\"\"\" ([a b] TEXT, `c` UNSIGNED BIG INT DEFAULT (0), d, -- comment\n" +
+				"CONSTRAINT pk PRIMARY KEY (\"a b\", c DESC), UNIQUE (c, d), CHECK (c > 0))",
+			Table{Name: "odd \"name\"", Columns: []Column{
+				{Name: "a b", Type: "TEXT", PrimaryKey: true},
+				{Name: "c", Type: "UNSIGNED BIG INT", PrimaryKey: true, Default: int64(0)},
+				{Name: "d"},
+			}, PrimaryKey: []string{"a b", "c"}, Unique: [][]string{{"c", "d"}}},
+		},
+		{
+			"CREATE TABLE w (k TEXT PRIMARY KEY, v BLOB DEFAULT x'00ff') WITHOUT ROWID",
+			Table{Name: "w", Columns: []Column{
+				{Name: "k", Type: "TEXT", PrimaryKey: true},
+				{Name: "v", Type: "BLOB", Default: []byte{0x00, 0xff}},
+			}, PrimaryKey: []string{"k"}, WithoutRowID: true},
+		},
+		{
+			// INTEGER PRIMARY KEY DESC と INT PRIMARY KEY は rowid の別名にならない
+			"CREATE TABLE d (id INTEGER PRIMARY KEY DESC AUTOINCREMENT)",
+			Table{Name: "d", Columns: []Column{
+				{Name: "id", Type: "INTEGER", PrimaryKey: true, AutoIncrement: true},
+			}, PrimaryKey: []string{"id"}},
+		},
+		{
+			"CREATE TABLE i (id INT PRIMARY KEY)",
+			Table{Name: "i", Columns: []Column{{Name: "id", Type: "INT", PrimaryKey: true}}, PrimaryKey: []string{"id"}},
+		},
+		{
+			"CREATE TABLE g (a INT, b INT GENERATED ALWAYS AS (a + 1) VIRTUAL, c AS (a * 2) STORED, " +
+				"p INT REFERENCES parent (id) ON DELETE SET DEFAULT NOT DEFERRABLE NOT NULL DEFAULT 'x')",
+			Table{Name: "g", Columns: []Column{
+				{Name: "a", Type: "INT"},
+				{Name: "b", Type: "INT", Generated: true, Virtual: true},
+				{Name: "c", Generated: true},
+				{Name: "p", Type: "INT", NotNull: true, Default: "x"},
+			}},
+		},
+	}
+	for _, tt := range tests {
+		got, err := ParseTable(Object{Type: "table", Name: tt.want.Name, SQL: tt.sql})
+		if err != nil {
+			t.Errorf("ParseTable(%q): %v", tt.sql, err)
+			continue
+		}
+		if !reflect.DeepEqual(*got, tt.want) {
+			t.Errorf("ParseTable(%q) =\n%+v, want\n%+v", tt.sql, *got, tt.want)
+		}
+	}
+}
+
+// TestParseTableErrors は、読めない CREATE TABLE 文がエラーになることを確かめます。
+func TestParseTableErrors(t *testing.T) {
+	tests := []struct {
+		sql, want string
+	}{
+		{"CREATE VIRTUAL TABLE f USING fts5 (body)", ErrVirtualTable.Error()},
+		{"CREATE VIEW v AS SELECT 1", "not a CREATE TABLE statement"},
+		{"CREATE TABLE t AS SELECT 1", "missing column definitions"},
+		{"CREATE TABLE t (PRIMARY KEY (a))", "has no columns"},
+		{"CREATE TABLE t (a TEXT) WITHOUT ROWID", "has no primary key"},
+		{"CREATE TABLE t (a TEXT DEFAULT 'x)", "unterminated"},
+	}
+	for _, tt := range tests {
+		_, err := ParseTable(Object{Type: "table", Name: "t", SQL: tt.sql})
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("ParseTable(%q): err = %v, want an error containing %q", tt.sql, err, tt.want)
+		}
+	}
+	_, err := ParseTable(Object{Type: "table", Name: "f", SQL: tests[0].sql})
+	if !errors.Is(err, ErrVirtualTable) {
+		t.Errorf("ParseTable of a virtual table: err = %v, want ErrVirtualTable", err)
+	}
+}
+
+// TestAffinity は、宣言した型から SQLite の規則で型の親和性を決めることを確かめます。
+func TestAffinity(t *testing.T) {
+	tests := []struct {
+		typ  string
+		want Affinity
+	}{
+		{"INTEGER", AffinityInteger},
+		{"tinyint", AffinityInteger},
+		{"POINT", AffinityInteger}, // INT を含む
+		{"VARCHAR(255)", AffinityText},
+		{"CLOB", AffinityText},
+		{"BLOB", AffinityBlob},
+		{"", AffinityBlob},
+		{"DOUBLE PRECISION", AffinityReal},
+		{"FLOAT", AffinityReal},
+		{"DECIMAL(10, 2)", AffinityNumeric},
+		{"BOOLEAN", AffinityNumeric},
+		{"DATETIME", AffinityNumeric},
+	}
+	for _, tt := range tests {
+		c := Column{Type: tt.typ}
+		if got := c.Affinity(); got != tt.want {
+			t.Errorf("affinity of %q = %d, want %d", tt.typ, got, tt.want)
+		}
+	}
+}
+
+// TestParseIndex は、CREATE INDEX 文から列を読み、式のインデックスと部分インデックスを拒否することを確かめます。
+func TestParseIndex(t *testing.T) {
+	tests := []struct {
+		sql  string
+		want *Index // nil ならエラーになる
+	}{
+		{"CREATE INDEX ix ON t (a)", &Index{Name: "ix", Table: "t", Columns: []string{"a"}}},
+		{
+			"CREATE UNIQUE INDEX IF NOT EXISTS main.\"i x\" ON [t] (a COLLATE NOCASE DESC, \"b c\" ASC)",
+			&Index{Name: "i x", Table: "t", Columns: []string{"a", "b c"}, Unique: true},
+		},
+		{"CREATE INDEX ix ON t (lower(a))", nil},
+		{"CREATE INDEX ix ON t (a + 1)", nil},
+		{"CREATE INDEX ix ON t (a) WHERE a IS NOT NULL", nil},
+		{"CREATE INDEX ix t (a)", nil},
+		{"CREATE TABLE t (a)", nil},
+	}
+	for _, tt := range tests {
+		got, err := ParseIndex(tt.sql)
+		switch {
+		case tt.want == nil && err == nil:
+			t.Errorf("ParseIndex(%q) = %+v, want an error", tt.sql, got)
+		case tt.want != nil && err != nil:
+			t.Errorf("ParseIndex(%q): %v", tt.sql, err)
+		case tt.want != nil && !reflect.DeepEqual(got, tt.want):
+			t.Errorf("ParseIndex(%q) = %+v, want %+v", tt.sql, got, tt.want)
+		}
+	}
+}
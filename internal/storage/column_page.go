@@ -0,0 +1,316 @@
+package storage
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/k-sml/go-rdbms/internal/dberr"
+	"github.com/k-sml/go-rdbms/internal/tuple"
+	"github.com/k-sml/go-rdbms/internal/types"
+)
+
+// 列形式のヒープページ（PAX）
+//
+// 列形式（HeapOptions.Columnar）のヒープファイルでは、ページの中の行を列ごとにまとめて格納する。
+// 行はページをまたがないので、RID（ページとスロット）で行を指せることも、1ページずつ読み書き
+// することも行形式のページと同じ。走査では使う列の領域だけを読めばよく、ほかの列の値は
+// デコードせずに読み飛ばせる。レコードは tuple の形式でなければならない
+//
+// レイアウト:
+// [4:"HCOL"][u16:行の数][u16:列の数]
+// [列ごとに u16:領域の先頭 + u16:使っているバイト数 + u16:領域の大きさ]
+// [列 0 の領域][列 1 の領域]...[空き][u16 × 行の数: 行の値の数（ページの末尾から前に向かって並ぶ）]
+// 列 c の領域には、値が c 個より多い行の c 番目の値を、行の順に types.AppendValue の形式で並べ、
+// 残りは空きにしておく。値の数が少ない行は ALTER TABLE ADD COLUMN より前の行
+//
+// 挿入は各列の領域の空きに値を書き足し、行の値の数を1つ書くだけで済む。削除は行の値の数に
+// colDeleted の印を立てるだけで、値は領域に残る。どこかの列の領域が足りなくなったときだけ、
+// 削除した行の値を除いてページを並べ直し、空きを列の使っている大きさに比例して配り直す。
+// 削除した行のスロットは詰めないので、RID は変わらない
+
+var colMagic = [4]byte{'H', 'C', 'O', 'L'}
+
+const (
+	colHdrSize = 8      // マジック + 行の数 + 列の数
+	colDirSize = 6      // 列ごとの領域の先頭 + 使っているバイト数 + 大きさ
+	colDeleted = 0x8000 // 削除済みの行の値の数に立てる印（下位のビットは領域に残っている値の数）
+)
+
+// ColumnPage は列形式のヒープページ。挿入と削除は buf を部分的に書き換える
+type ColumnPage struct {
+	buf  []byte
+	rows [][][]byte // 行ごとの値の保存形式（nil なら削除済み）
+	cols int        // 列の領域の数
+	live int        // 生きている行の値のバイト数
+}
+
+// IsColumnPage はページが列形式のヒープページかを判定する
+func IsColumnPage(buf []byte) bool {
+	return len(buf) >= colHdrSize && [4]byte(buf[0:4]) == colMagic
+}
+
+// NewColumnPage はページ buf を列形式のヒープページとして読む
+// 初期化されていない（すべてゼロの）ページは空のページとして初期化する
+func NewColumnPage(buf []byte) (*ColumnPage, error) {
+	return openColumnPage(buf, nil)
+}
+
+// openColumnPage は NewColumnPage と同じだが、want が nil でなければ want[c] が false の列の値を読まない
+// 読まなかった値は Get で NULL になるので、返したページは書き換えに使わない
+func openColumnPage(buf []byte, want []bool) (*ColumnPage, error) {
+	if len(buf) < colHdrSize {
+		return nil, errors.New("page buffer too small")
+	}
+	p := &ColumnPage{buf: buf}
+	if !IsColumnPage(buf) {
+		for _, b := range buf {
+			if b != 0 {
+				return nil, dberr.Mark(errors.New("page is not a columnar heap page"), dberr.ErrCorrupt)
+			}
+		}
+		p.layout(nil)
+		return p, nil
+	}
+	if err := p.decode(want); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// region は列 c の領域の先頭、使っているバイト数、大きさを返す
+func (p *ColumnPage) region(c int) (start, used, size int) {
+	d := p.buf[colHdrSize+c*colDirSize:]
+	return int(binary.LittleEndian.Uint16(d[0:2])), int(binary.LittleEndian.Uint16(d[2:4])), int(binary.LittleEndian.Uint16(d[4:6]))
+}
+
+// setRegion は列 c の領域の先頭、使っているバイト数、大きさを書き込む
+func (p *ColumnPage) setRegion(c, start, used, size int) {
+	d := p.buf[colHdrSize+c*colDirSize:]
+	binary.LittleEndian.PutUint16(d[0:2], uint16(start))
+	binary.LittleEndian.PutUint16(d[2:4], uint16(used))
+	binary.LittleEndian.PutUint16(d[4:6], uint16(size))
+}
+
+// countAt は行 i の値の数を書く位置を返す
+func (p *ColumnPage) countAt(i int) int { return len(p.buf) - 2*(i+1) }
+
+// dirEnd は列の領域の表の直後の位置を返す
+func (p *ColumnPage) dirEnd() int { return colHdrSize + p.cols*colDirSize }
+
+// decode はページの内容を読む。want が nil でなければ want[c] が false の列の値は読まずに nil にする
+func (p *ColumnPage) decode(want []bool) error {
+	corrupt := func(format string, args ...any) error {
+		return dberr.Mark(fmt.Errorf("columnar heap page: "+format, args...), dberr.ErrCorrupt)
+	}
+	b := append([]byte(nil), p.buf...) // 値はコピーを指す（buf は並べ直すと上書きされる）
+	nrows := int(binary.LittleEndian.Uint16(b[4:6]))
+	p.cols = int(binary.LittleEndian.Uint16(b[6:8]))
+	end := len(b) - 2*nrows // 行の値の数の手前
+	if p.dirEnd() > end {
+		return corrupt("%d rows and %d columns do not fit in the page", nrows, p.cols)
+	}
+	counts := make([]int, nrows) // 領域に残っている値の数
+	p.rows = make([][][]byte, nrows)
+	for i := range p.rows {
+		n := int(binary.LittleEndian.Uint16(b[p.countAt(i):]))
+		counts[i] = n &^ colDeleted
+		if counts[i] > p.cols {
+			return corrupt("row %d has %d values, page has %d columns", i, counts[i], p.cols)
+		}
+		if n&colDeleted == 0 {
+			p.rows[i] = make([][]byte, counts[i])
+		}
+	}
+	prev := p.dirEnd()
+	for c := 0; c < p.cols; c++ {
+		start, used, size := p.region(c)
+		if start < prev || used > size || start+size > end {
+			return corrupt("column %d region [%d, %d) is out of place", c, start, start+size)
+		}
+		prev = start + size
+		all := want == nil
+		if !all && (c >= len(want) || !want[c]) {
+			continue
+		}
+		chunk := b[start : start+used]
+		for i, row := range p.rows {
+			if c >= counts[i] {
+				continue
+			}
+			size, err := types.SkipValue(chunk)
+			if err != nil {
+				return corrupt("column %d: %v", c, err)
+			}
+			if row != nil {
+				row[c] = chunk[:size:size]
+				if all {
+					p.live += size
+				}
+			}
+			chunk = chunk[size:]
+		}
+		if len(chunk) != 0 {
+			return corrupt("column %d has %d extra bytes", c, len(chunk))
+		}
+	}
+	return nil
+}
+
+// layout は生きている行と、nil でなければ新しい行 add の値でページを並べ直す。削除した行の値は除き、
+// 空きは列の領域に、使っているバイト数に比例して配る。入らなければ ErrPageFull を返す
+func (p *ColumnPage) layout(add [][]byte) error {
+	rows := p.rows
+	if add != nil {
+		rows = append(rows[:len(rows):len(rows)], add)
+	}
+	cols := 0
+	for _, row := range rows {
+		cols = max(cols, len(row))
+	}
+	used := make([]int, cols)
+	total := 0
+	for _, row := range rows {
+		for c, v := range row {
+			used[c] += len(v)
+			total += len(v)
+		}
+	}
+	free := len(p.buf) - colHdrSize - cols*colDirSize - 2*len(rows) - total
+	if free < 0 {
+		return ErrPageFull
+	}
+
+	clear(p.buf)
+	copy(p.buf, colMagic[:])
+	binary.LittleEndian.PutUint16(p.buf[4:6], uint16(len(rows)))
+	binary.LittleEndian.PutUint16(p.buf[6:8], uint16(cols))
+	p.rows, p.cols, p.live = rows, cols, total
+	// 行の値の数も増えていくので、その分も使っている大きさに比例して列の領域の後ろに残す。
+	// 空の列にも少し配れるよう、重みに1を足す
+	weight := total + cols + 2*(len(rows)+1)
+	start := p.dirEnd()
+	for c := range cols {
+		size := used[c] + free*(used[c]+1)/weight
+		off := start
+		for _, row := range rows {
+			if c < len(row) {
+				off += copy(p.buf[off:], row[c])
+			}
+		}
+		p.setRegion(c, start, used[c], size)
+		start += size
+	}
+	for i, row := range rows {
+		n := colDeleted
+		if row != nil {
+			n = len(row)
+		}
+		binary.LittleEndian.PutUint16(p.buf[p.countAt(i):], uint16(n))
+	}
+	return nil
+}
+
+// Insert はレコードを新しいスロットに挿入し、スロットの番号を返す
+// 各列の領域に空きがあれば値を書き足し、なければページを並べ直す
+func (p *ColumnPage) Insert(rec []byte) (int, error) {
+	vals, err := tuple.Split(rec)
+	if err != nil {
+		return -1, err
+	}
+	if p.roomFor(vals) < 0 {
+		return -1, ErrPageFull
+	}
+	if vals == nil {
+		vals = [][]byte{} // nil は削除済みの行を表す
+	}
+	for i, v := range vals {
+		vals[i] = append([]byte(nil), v...)
+	}
+	if !p.fits(vals) {
+		if err := p.layout(vals); err != nil {
+			return -1, err
+		}
+		return len(p.rows) - 1, nil
+	}
+	for c, v := range vals {
+		start, used, size := p.region(c)
+		copy(p.buf[start+used:], v)
+		p.setRegion(c, start, used+len(v), size)
+		p.live += len(v)
+	}
+	slot := len(p.rows)
+	p.rows = append(p.rows, vals)
+	binary.LittleEndian.PutUint16(p.buf[4:6], uint16(len(p.rows)))
+	binary.LittleEndian.PutUint16(p.buf[p.countAt(slot):], uint16(len(vals)))
+	return slot, nil
+}
+
+// fits は値 vals の行を、並べ直さずに列の領域の空きに書き足せるかを判定する
+func (p *ColumnPage) fits(vals [][]byte) bool {
+	if len(vals) > p.cols {
+		return false
+	}
+	end := p.dirEnd()
+	for c := range p.cols {
+		start, used, size := p.region(c)
+		if c < len(vals) && used+len(vals[c]) > size {
+			return false
+		}
+		end = start + size
+	}
+	// 行の値の数が最後の列の領域に食い込まないこと
+	return end <= p.countAt(len(p.rows))
+}
+
+// room はレコード rec を挿入した後に残る空きのバイト数を返す。入らなければ負になる
+func (p *ColumnPage) room(rec []byte) (int, error) {
+	vals, err := tuple.Split(rec)
+	if err != nil {
+		return 0, err
+	}
+	return p.roomFor(vals), nil
+}
+
+// roomFor は値 vals の行を挿入した後に残る空きのバイト数を返す
+// 削除した行の値が使っているバイトは、並べ直せば空くので空きに数える
+func (p *ColumnPage) roomFor(vals [][]byte) int {
+	need := 2 + colDirSize*max(len(vals)-p.cols, 0)
+	for _, v := range vals {
+		need += len(v)
+	}
+	return p.FreeSpace() - need
+}
+
+// Get はスロット slot の行を tuple の形式で返す。削除済みなら false を返す
+func (p *ColumnPage) Get(slot int) ([]byte, bool) {
+	if slot < 0 || slot >= len(p.rows) || p.rows[slot] == nil {
+		return nil, false
+	}
+	return tuple.Join(p.rows[slot]), true
+}
+
+// Delete はスロット slot の行を削除する。行の値の数に印を立てるだけで、スロットも値も詰めない
+func (p *ColumnPage) Delete(slot int) error {
+	if slot < 0 || slot >= len(p.rows) || p.rows[slot] == nil {
+		return errors.New("slot not found")
+	}
+	at := p.countAt(slot)
+	binary.LittleEndian.PutUint16(p.buf[at:], binary.LittleEndian.Uint16(p.buf[at:])|colDeleted)
+	for _, v := range p.rows[slot] {
+		p.live -= len(v)
+	}
+	p.rows[slot] = nil
+	return nil
+}
+
+// NumSlots はスロットの数を返す（削除済みのスロットも含む）
+func (p *ColumnPage) NumSlots() int { return len(p.rows) }
+
+// NumColumns はページの列の数を返す
+func (p *ColumnPage) NumColumns() int { return p.cols }
+
+// FreeSpace はページに残っている空きのバイト数を返す（削除した行の値が使っているバイトを含む）
+func (p *ColumnPage) FreeSpace() int {
+	return len(p.buf) - p.dirEnd() - 2*len(p.rows) - p.live
+}
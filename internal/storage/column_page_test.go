@@ -0,0 +1,153 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/k-sml/go-rdbms/internal/tuple"
+	"github.com/k-sml/go-rdbms/internal/types"
+)
+
+// changed は a と b で異なるバイトの数を返す
+func changed(a, b []byte) int {
+	n := 0
+	for i := range a {
+		if a[i] != b[i] {
+			n++
+		}
+	}
+	return n
+}
+
+// row は i 番目の行のレコードを返す。cols が 3 なら ADD COLUMN の後の行
+func row(i, cols int) []byte {
+	vals := []types.Value{types.NewInt(int32(i)), types.NewText(fmt.Sprintf("value %d", i))}
+	if cols == 3 {
+		vals = append(vals, types.NewInt(7))
+	}
+	return tuple.Encode(vals)
+}
+
+// TestColumnPageInPlace は、列の領域に空きがある間は挿入と削除がページの一部だけを書き換え、
+// 領域が足りなくなって並べ直した後も、スロットの番号と行が変わらないことを確かめる
+func TestColumnPageInPlace(t *testing.T) {
+	buf := make([]byte, 4096)
+	p, err := NewColumnPage(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[int][]byte{}
+	insert := func(rec []byte) (int, error) {
+		t.Helper()
+		slot, err := p.Insert(rec)
+		if err == nil {
+			want[slot] = rec
+		}
+		return slot, err
+	}
+	if _, err := insert(row(0, 2)); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 1; i < 20; i++ {
+		before := bytes.Clone(buf)
+		if _, err := insert(row(i, 2)); err != nil {
+			t.Fatal(err)
+		}
+		// 2つの値と、行の値の数・行の数・列の領域の表
+		if n := changed(before, buf); n > 40 {
+			t.Errorf("insert %d changed %d bytes, want at most 40", i, n)
+		}
+	}
+	before := bytes.Clone(buf)
+	if err := p.Delete(3); err != nil {
+		t.Fatal(err)
+	}
+	delete(want, 3)
+	if n := changed(before, buf); n > 1 {
+		t.Errorf("delete changed %d bytes, want 1", n)
+	}
+	if err := p.Delete(3); err == nil {
+		t.Error("second delete of slot 3 succeeded")
+	}
+
+	// 列が増えた行と、ページが一杯になるまでの行。途中で並べ直す
+	for i := 20; ; i++ {
+		if _, err := insert(row(i, 2+i%2)); errors.Is(err, ErrPageFull) {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for slot := range want {
+		if slot%2 == 0 {
+			if err := p.Delete(slot); err != nil {
+				t.Fatal(err)
+			}
+			delete(want, slot)
+		}
+	}
+	// 削除した行の値の分だけ挿入できる
+	for i := 1000; i < 1010; i++ {
+		if _, err := insert(row(i, 3)); err != nil {
+			t.Fatalf("insert after delete: %v", err)
+		}
+	}
+
+	for _, q := range []*ColumnPage{p, mustOpen(t, bytes.Clone(buf))} {
+		if q.NumColumns() != 3 {
+			t.Errorf("NumColumns = %d, want 3", q.NumColumns())
+		}
+		for slot := range q.NumSlots() {
+			got, ok := q.Get(slot)
+			if ok != (want[slot] != nil) || !bytes.Equal(got, want[slot]) {
+				t.Errorf("Get(%d) = %x, %v, want %x", slot, got, ok, want[slot])
+			}
+		}
+		if q.FreeSpace() != p.FreeSpace() {
+			t.Errorf("FreeSpace = %d after reopening, want %d", q.FreeSpace(), p.FreeSpace())
+		}
+	}
+}
+
+// mustOpen は buf を列形式のページとして読む
+func mustOpen(t *testing.T, buf []byte) *ColumnPage {
+	t.Helper()
+	p, err := NewColumnPage(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// TestColumnPageCorrupt は、壊れたページを読むとエラーになることを確かめる
+func TestColumnPageCorrupt(t *testing.T) {
+	good := make([]byte, 512)
+	p := mustOpen(t, good)
+	for i := range 5 {
+		if _, err := p.Insert(row(i, 2)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		name  string
+		patch func(b []byte)
+	}{
+		{"not columnar", func(b []byte) { b[0] = 'X' }},
+		{"too many rows", func(b []byte) { b[4], b[5] = 0xff, 0x7f }},
+		{"too many values", func(b []byte) { b[len(b)-2] = 9 }},
+		{"region out of page", func(b []byte) { b[colHdrSize+colDirSize+1] = 0xff }},
+		{"extra bytes", func(b []byte) { b[colHdrSize+2]++ }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := bytes.Clone(good)
+			tt.patch(b)
+			if _, err := NewColumnPage(b); err == nil {
+				t.Error("NewColumnPage succeeded")
+			}
+		})
+	}
+}
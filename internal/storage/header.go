@@ -0,0 +1,104 @@
+package storage
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// ファイルヘッダ（ページ0の先頭）
+// [4:"MRDB"][u16:version][u16:reserved][u32:pageSize][u64:nextXID]
+// [i64:numPages][i64:freeHead][u64:schemaVersion]
+//   version      : ヘッダの形式（0 はマジックだけが書かれた古いファイル）
+//   pageSize     : 作成時のページサイズ
+//   nextXID      : まだ割り当てていないトランザクションIDの下限
+//   numPages     : 割り当て済みのページ数（ページ0を含む。0 はまだ初期化されていないファイル）
+//   freeHead     : 解放されたページのリストの先頭（0 なら空）
+//   schemaVersion: スキーマを変更するたびに増える番号
+
+// FileMagic はデータベースファイルの先頭に書かれるマジックナンバーです。
+var FileMagic = [4]byte{'M', 'R', 'D', 'B'}
+
+const (
+	// FileHeaderVersion は現在のファイルヘッダの形式です。
+	FileHeaderVersion = 2
+	// FileHeaderSize はファイルヘッダのサイズ（バイト）です。
+	FileHeaderSize = 44
+)
+
+// ErrNotDatabase はページ0がデータベースのファイルヘッダでない場合に返されます。
+var ErrNotDatabase = errors.New("file is not a database")
+
+// FileHeader はページ0に保存するデータベース全体の情報です。
+type FileHeader struct {
+	Version       uint16
+	PageSize      uint32
+	NextXID       uint64
+	NumPages      int64
+	FreeHead      int64
+	SchemaVersion uint64
+}
+
+// ReadFileHeader はページ0のバイト列からファイルヘッダを読み取ります。
+// 何も書かれていない（すべて0の）ページは新しいデータベースとみなし、ok に false を返します。
+func ReadFileHeader(buf []byte) (h FileHeader, ok bool, err error) {
+	if len(buf) < FileHeaderSize {
+		return FileHeader{}, false, errors.New("page buffer too small")
+	}
+	if [4]byte(buf[0:4]) != FileMagic {
+		for _, b := range buf {
+			if b != 0 {
+				return FileHeader{}, false, ErrNotDatabase
+			}
+		}
+		return FileHeader{}, false, nil
+	}
+	h.Version = binary.LittleEndian.Uint16(buf[4:6])
+	h.PageSize = binary.LittleEndian.Uint32(buf[8:12])
+	h.NextXID = binary.LittleEndian.Uint64(buf[12:20])
+	if h.Version >= 2 {
+		h.NumPages = int64(binary.LittleEndian.Uint64(buf[20:28]))
+		h.FreeHead = int64(binary.LittleEndian.Uint64(buf[28:36]))
+		h.SchemaVersion = binary.LittleEndian.Uint64(buf[36:44])
+	}
+	return h, true, nil
+}
+
+// Encode はファイルヘッダを buf の先頭に書き込みます。ヘッダ以外の部分は変更しません。
+func (h FileHeader) Encode(buf []byte) {
+	copy(buf[0:4], FileMagic[:])
+	binary.LittleEndian.PutUint16(buf[4:6], h.Version)
+	binary.LittleEndian.PutUint16(buf[6:8], 0)
+	binary.LittleEndian.PutUint32(buf[8:12], h.PageSize)
+	binary.LittleEndian.PutUint64(buf[12:20], h.NextXID)
+	binary.LittleEndian.PutUint64(buf[20:28], uint64(h.NumPages))
+	binary.LittleEndian.PutUint64(buf[28:36], uint64(h.FreeHead))
+	binary.LittleEndian.PutUint64(buf[36:44], h.SchemaVersion)
+}
+
+// ReadHeader は pg のページ0からファイルヘッダを読み取ります。
+// まだヘッダが書かれていない場合は、現在の形式の空のヘッダを返します。
+func ReadHeader(pg Pages) (FileHeader, error) {
+	buf, err := pg.ReadPage(0)
+	if err != nil {
+		return FileHeader{}, err
+	}
+	h, ok, err := ReadFileHeader(buf)
+	if err != nil {
+		return FileHeader{}, err
+	}
+	if !ok {
+		h.PageSize = uint32(pg.PageSize())
+	}
+	h.Version = FileHeaderVersion
+	return h, nil
+}
+
+// WriteHeader は pg のページ0にファイルヘッダを書き込みます。
+func WriteHeader(pg Pages, h FileHeader) error {
+	buf, err := pg.ReadPage(0)
+	if err != nil {
+		return err
+	}
+	h.Encode(buf)
+	return pg.WritePage(0, buf)
+}
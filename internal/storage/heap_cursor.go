@@ -0,0 +1,111 @@
+package storage
+
+import (
+	"fmt"
+	"sync"
+)
+
+// HeapCursor はヒープファイルのレコードを格納順に1件ずつ返す
+// Scan と違って呼び出し側が読むペースを決められる（クエリの実行で使う）
+// ページ単位で読み込み、読み込んだページのレコードはコピーして保持する
+type HeapCursor struct {
+	h     *HeapFile
+	pages *pageQueue
+	recs  [][]byte
+	rids  []RID
+	i     int
+	want  []bool // nil でなければ、列形式のページで読む列
+}
+
+// pageQueue はまだ読んでいないデータページの一覧。Cursors のカーソルはこれを共有し、
+// 先に手の空いたカーソルが次のページを取る
+type pageQueue struct {
+	mu  sync.Mutex
+	ids []int64
+}
+
+// pop は次に読むページを取り出す。残っていなければ ok が false になる
+func (q *pageQueue) pop() (id int64, ok bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.ids) == 0 {
+		return 0, false
+	}
+	id = q.ids[0]
+	q.ids = q.ids[1:]
+	return id, true
+}
+
+// Cursor は先頭から読むカーソルを返す。データページの一覧はこの時点で決まる
+func (h *HeapFile) Cursor() (*HeapCursor, error) {
+	cs, err := h.Cursors(1)
+	if err != nil {
+		return nil, err
+	}
+	return cs[0], nil
+}
+
+// Cursors はヒープファイルのページを分け合って読む n 個のカーソルを返す
+// 各カーソルは別々のゴルーチンから使え、すべてのカーソルを合わせるとすべてのレコードを1回ずつ返す
+// どのカーソルがどのページを読むかは読む速さで決まるので、カーソルごとの順番は格納順だが飛び飛びになる
+// ページャーは並行して ReadPage を呼び出せる必要がある
+func (h *HeapFile) Cursors(n int) ([]*HeapCursor, error) {
+	ids, err := h.Pages()
+	if err != nil {
+		return nil, err
+	}
+	q := &pageQueue{ids: ids}
+	cs := make([]*HeapCursor, max(n, 1))
+	for i := range cs {
+		cs[i] = &HeapCursor{h: h, pages: q}
+	}
+	return cs, nil
+}
+
+// SetColumns は列形式のページで読む列を want[c] が true の列に限る。ほかの列の値は NULL として返す
+// 行形式のページではすべての列を読む。want が nil ならすべての列を読む
+func (c *HeapCursor) SetColumns(want []bool) { c.want = want }
+
+// Next は次のレコードを返す。最後まで読むと ok が false になる
+func (c *HeapCursor) Next() (rid RID, rec []byte, ok bool, err error) {
+	for c.i >= len(c.recs) {
+		id, ok := c.pages.pop()
+		if !ok {
+			return RID{}, nil, false, nil
+		}
+		if err := c.load(id); err != nil {
+			return RID{}, nil, false, err
+		}
+	}
+	rid, rec = c.rids[c.i], c.recs[c.i]
+	c.i++
+	if rec, err = c.h.decode(rec); err != nil {
+		return rid, nil, false, fmt.Errorf("record %s: %w", rid, err)
+	}
+	return rid, rec, true, nil
+}
+
+// load はページ id のレコードを読み込む
+func (c *HeapCursor) load(id int64) error {
+	buf, err := c.h.pg.ReadPage(id)
+	if err != nil {
+		return err
+	}
+	var hp DataPage
+	if c.h.opts.Columnar {
+		hp, err = openColumnPage(buf, c.want)
+	} else {
+		hp, err = NewHeapPage(buf)
+	}
+	if err != nil {
+		return err
+	}
+	c.recs, c.rids, c.i = c.recs[:0], c.rids[:0], 0
+	for slot := 0; slot < hp.NumSlots(); slot++ {
+		if rec, ok := hp.Get(slot); ok {
+			c.recs = append(c.recs, rec) // Get はコピーを返す
+			c.rids = append(c.rids, RID{PageID: id, Slot: slot})
+		}
+	}
+	return nil
+}
@@ -0,0 +1,400 @@
+package storage
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/k-sml/go-rdbms/internal/dberr"
+)
+
+// HeapFile は複数のヒープページをまとめた1つのテーブルの格納領域
+// どのページが属するかはディレクトリページの連鎖で管理する
+//
+// ディレクトリページのレイアウト:
+// [4:"HDIR"][u32:count][i64:次のディレクトリページ（0 なら末尾）][i64:ページID × count]
+// 最初のディレクトリページ（root）のIDがヒープファイルの識別子になる
+type HeapFile struct {
+	pg   Pages
+	root int64
+	opts HeapOptions
+}
+
+var dirMagic = [4]byte{'H', 'D', 'I', 'R'}
+
+const dirHdrSize = 16
+
+// ErrRecordTooLarge はレコードが1ページに収まらない場合に返される
+var ErrRecordTooLarge = errors.New("record too large for a page")
+
+// DataPage はヒープファイルのデータページ。行形式の HeapPage か列形式の ColumnPage
+type DataPage interface {
+	Insert(rec []byte) (int, error)
+	Get(slot int) ([]byte, bool)
+	Delete(slot int) error
+	NumSlots() int
+	FreeSpace() int
+}
+
+// OpenDataPage はデータページ buf を、書かれている形式（行形式か列形式か）に合わせて読む
+// ヒープファイルを通さずにページを読む場合（整合性検査や変更データキャプチャなど）に使う
+func OpenDataPage(buf []byte) (DataPage, error) {
+	if IsColumnPage(buf) {
+		return NewColumnPage(buf)
+	}
+	return NewHeapPage(buf)
+}
+
+// LooksLikeDataPage はバッファが行形式か列形式のデータページとして読めそうかを判定する
+func LooksLikeDataPage(buf []byte) bool {
+	return IsColumnPage(buf) || LooksLikeHeapPage(buf)
+}
+
+// dataPage は HeapFile が挿入先を選ぶのに使う、空きの計算を加えた DataPage
+type dataPage interface {
+	DataPage
+	// room はレコード rec を挿入した後に残る空きのバイト数を返す。入らなければ負になる
+	room(rec []byte) (int, error)
+}
+
+// page はデータページ buf をヒープファイルの形式で読む。未初期化のページはその形式で初期化する
+func (h *HeapFile) page(buf []byte) (dataPage, error) {
+	if h.opts.Columnar {
+		return NewColumnPage(buf)
+	}
+	return NewHeapPage(buf)
+}
+
+// CreateHeapFile は空のヒープファイルを作成する
+func CreateHeapFile(pg Pages) (*HeapFile, error) {
+	root, err := AllocPage(pg)
+	if err != nil {
+		return nil, err
+	}
+	if err := pg.WritePage(root, newDirPage(pg.PageSize())); err != nil {
+		return nil, err
+	}
+	return &HeapFile{pg: pg, root: root}, nil
+}
+
+// OpenHeapFile は root をディレクトリページとする既存のヒープファイルを開く
+func OpenHeapFile(pg Pages, root int64) *HeapFile {
+	return &HeapFile{pg: pg, root: root}
+}
+
+// Root はヒープファイルの最初のディレクトリページのIDを返す
+func (h *HeapFile) Root() int64 { return h.root }
+
+// Insert はレコードを挿入し、その位置を返す
+// 最後のページに空きがなければ（フィルファクタの分を残せなければ）新しいページを割り当てる
+func (h *HeapFile) Insert(rec []byte) (RID, error) {
+	if len(rec) == 0 {
+		return RID{}, errors.New("empty record")
+	}
+	rec, err := h.encode(rec)
+	if err != nil {
+		return RID{}, err
+	}
+	return h.insert(rec)
+}
+
+// insert は保存形式にしたレコードを挿入する
+func (h *HeapFile) insert(rec []byte) (RID, error) {
+	if big, err := h.tooLarge(rec); err != nil {
+		return RID{}, err
+	} else if big {
+		return RID{}, ErrRecordTooLarge
+	}
+	ids, err := h.Pages()
+	if err != nil {
+		return RID{}, err
+	}
+	if len(ids) > 0 {
+		last := ids[len(ids)-1]
+		rid, err := h.insertInto(last, rec, h.reserve())
+		if !errors.Is(err, ErrPageFull) {
+			return rid, err
+		}
+	}
+	id, err := AllocPage(h.pg)
+	if err != nil {
+		return RID{}, err
+	}
+	if err := h.addPage(id); err != nil {
+		return RID{}, err
+	}
+	return h.insertInto(id, rec, 0)
+}
+
+// tooLarge は保存形式にしたレコード rec が空のページにも入らないかを判定する
+func (h *HeapFile) tooLarge(rec []byte) (bool, error) {
+	if !h.opts.Columnar {
+		return len(rec)+hdrSize+slotSize > h.pg.PageSize(), nil
+	}
+	empty, err := NewColumnPage(make([]byte, h.pg.PageSize()))
+	if err != nil {
+		return false, err
+	}
+	room, err := empty.room(rec)
+	return room < 0, err
+}
+
+// insertInto はページ pageID にレコードを挿入する。挿入後に reserve バイト以上の空きが
+// 残らない場合は ErrPageFull を返す
+func (h *HeapFile) insertInto(pageID int64, rec []byte, reserve int) (RID, error) {
+	buf, err := h.pg.ReadPage(pageID)
+	if err != nil {
+		return RID{}, err
+	}
+	hp, err := h.page(buf)
+	if err != nil {
+		return RID{}, err
+	}
+	if room, err := hp.room(rec); err != nil {
+		return RID{}, err
+	} else if room < reserve {
+		return RID{}, ErrPageFull
+	}
+	slot, err := hp.Insert(rec)
+	if err != nil {
+		return RID{}, err
+	}
+	if err := h.pg.WritePage(pageID, buf); err != nil {
+		return RID{}, err
+	}
+	return RID{PageID: pageID, Slot: slot}, nil
+}
+
+// Get は rid のレコードを返す。削除済みなら false を返す
+func (h *HeapFile) Get(rid RID) ([]byte, bool, error) {
+	buf, err := h.pg.ReadPage(rid.PageID)
+	if err != nil {
+		return nil, false, err
+	}
+	hp, err := h.page(buf)
+	if err != nil {
+		return nil, false, err
+	}
+	rec, ok := hp.Get(rid.Slot)
+	if !ok {
+		return nil, false, nil
+	}
+	rec, err = h.decode(rec)
+	return rec, err == nil, err
+}
+
+// Delete は rid のレコードを削除する
+func (h *HeapFile) Delete(rid RID) error {
+	if h.opts.AppendOnly {
+		return ErrAppendOnly
+	}
+	return h.delete(rid)
+}
+
+func (h *HeapFile) delete(rid RID) error {
+	buf, err := h.pg.ReadPage(rid.PageID)
+	if err != nil {
+		return err
+	}
+	hp, err := h.page(buf)
+	if err != nil {
+		return err
+	}
+	if err := hp.Delete(rid.Slot); err != nil {
+		return fmt.Errorf("delete %s: %w", rid, err)
+	}
+	return h.pg.WritePage(rid.PageID, buf)
+}
+
+// Update は rid のレコードを rec に置き換え、新しい位置を返す
+// ヒープページの更新は削除と挿入なので、位置は変わることがある
+// 新しいレコードはできるだけ元のページに置く（フィルファクタで残した空きを使う）
+func (h *HeapFile) Update(rid RID, rec []byte) (RID, error) {
+	if h.opts.AppendOnly {
+		return RID{}, ErrAppendOnly
+	}
+	if len(rec) == 0 {
+		return RID{}, errors.New("empty record")
+	}
+	rec, err := h.encode(rec)
+	if err != nil {
+		return RID{}, err
+	}
+	if err := h.delete(rid); err != nil {
+		return RID{}, err
+	}
+	nrid, err := h.insertInto(rid.PageID, rec, 0)
+	if !errors.Is(err, ErrPageFull) {
+		return nrid, err
+	}
+	return h.insert(rec)
+}
+
+// Scan はすべてのレコードを格納順に fn に渡す。fn がエラーを返すとそこで止める
+func (h *HeapFile) Scan(fn func(rid RID, rec []byte) error) error {
+	ids, err := h.Pages()
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		buf, err := h.pg.ReadPage(id)
+		if err != nil {
+			return err
+		}
+		hp, err := h.page(buf)
+		if err != nil {
+			return err
+		}
+		for slot := 0; slot < hp.NumSlots(); slot++ {
+			rec, ok := hp.Get(slot)
+			if !ok {
+				continue
+			}
+			rid := RID{PageID: id, Slot: slot}
+			if rec, err = h.decode(rec); err != nil {
+				return fmt.Errorf("record %s: %w", rid, err)
+			}
+			if err := fn(rid, rec); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Pages はヒープファイルに属するデータページのIDを格納順に返す
+func (h *HeapFile) Pages() ([]int64, error) {
+	var ids []int64
+	err := h.walkDir(func(_ int64, buf []byte) error {
+		ids = append(ids, dirEntries(buf)...)
+		return nil
+	})
+	return ids, err
+}
+
+// DirPages はヒープファイルのディレクトリページのIDを返す
+func (h *HeapFile) DirPages() ([]int64, error) {
+	var ids []int64
+	err := h.walkDir(func(id int64, _ []byte) error {
+		ids = append(ids, id)
+		return nil
+	})
+	return ids, err
+}
+
+// Drop はヒープファイルのすべてのページ（ディレクトリページを含む）を解放する
+func (h *HeapFile) Drop() error {
+	data, err := h.Pages()
+	if err != nil {
+		return err
+	}
+	dirs, err := h.DirPages()
+	if err != nil {
+		return err
+	}
+	for _, id := range append(data, dirs...) {
+		if err := FreePage(h.pg, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Clear はヒープファイルのデータページと、最初のもの（root）以外のディレクトリページを解放し、
+// 空のヒープファイルにする。root のページIDは変わらない
+func (h *HeapFile) Clear() error {
+	data, err := h.Pages()
+	if err != nil {
+		return err
+	}
+	dirs, err := h.DirPages()
+	if err != nil {
+		return err
+	}
+	for _, id := range append(data, dirs[1:]...) {
+		if err := FreePage(h.pg, id); err != nil {
+			return err
+		}
+	}
+	return h.pg.WritePage(h.root, newDirPage(h.pg.PageSize()))
+}
+
+// walkDir はディレクトリページを先頭から順に fn に渡す
+func (h *HeapFile) walkDir(fn func(id int64, buf []byte) error) error {
+	seen := make(map[int64]bool)
+	for id := h.root; id != 0; {
+		if seen[id] {
+			return dberr.Mark(fmt.Errorf("heap directory loops at page %d", id), dberr.ErrCorrupt)
+		}
+		seen[id] = true
+		buf, err := h.pg.ReadPage(id)
+		if err != nil {
+			return err
+		}
+		if [4]byte(buf[0:4]) != dirMagic {
+			return dberr.Mark(fmt.Errorf("page %d is not a heap directory", id), dberr.ErrCorrupt)
+		}
+		if err := fn(id, buf); err != nil {
+			return err
+		}
+		id = int64(binary.LittleEndian.Uint64(buf[8:16]))
+	}
+	return nil
+}
+
+// addPage はデータページをディレクトリの末尾に追加する
+// 最後のディレクトリページが一杯なら新しいディレクトリページをつなぐ
+func (h *HeapFile) addPage(pageID int64) error {
+	var last int64
+	if err := h.walkDir(func(id int64, _ []byte) error { last = id; return nil }); err != nil {
+		return err
+	}
+	buf, err := h.pg.ReadPage(last)
+	if err != nil {
+		return err
+	}
+	n := int(binary.LittleEndian.Uint32(buf[4:8]))
+	if dirHdrSize+(n+1)*8 > len(buf) {
+		next, err := AllocPage(h.pg)
+		if err != nil {
+			return err
+		}
+		nb := newDirPage(len(buf))
+		binary.LittleEndian.PutUint32(nb[4:8], 1)
+		binary.LittleEndian.PutUint64(nb[dirHdrSize:], uint64(pageID))
+		if err := h.pg.WritePage(next, nb); err != nil {
+			return err
+		}
+		binary.LittleEndian.PutUint64(buf[8:16], uint64(next))
+		return h.pg.WritePage(last, buf)
+	}
+	binary.LittleEndian.PutUint64(buf[dirHdrSize+n*8:], uint64(pageID))
+	binary.LittleEndian.PutUint32(buf[4:8], uint32(n+1))
+	return h.pg.WritePage(last, buf)
+}
+
+func newDirPage(size int) []byte {
+	buf := make([]byte, size)
+	copy(buf[0:4], dirMagic[:])
+	return buf
+}
+
+// dirEntries はディレクトリページに記録されたページIDを返す
+func dirEntries(buf []byte) []int64 {
+	n := int(binary.LittleEndian.Uint32(buf[4:8]))
+	ids := make([]int64, 0, n)
+	for i := 0; i < n && dirHdrSize+(i+1)*8 <= len(buf); i++ {
+		ids = append(ids, int64(binary.LittleEndian.Uint64(buf[dirHdrSize+i*8:])))
+	}
+	return ids
+}
+
+// IsDirPage はページがヒープファイルのディレクトリページかを判定する
+func IsDirPage(buf []byte) bool {
+	return len(buf) >= dirHdrSize && [4]byte(buf[0:4]) == dirMagic
+}
+
+// ReadDirPage はディレクトリページ buf に記録されたデータページのIDと、次のディレクトリページのIDを返す
+func ReadDirPage(buf []byte) (pages []int64, next int64) {
+	return dirEntries(buf), int64(binary.LittleEndian.Uint64(buf[8:16]))
+}
@@ -0,0 +1,111 @@
+package storage
+
+import (
+	"bytes"
+	"compress/flate"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/k-sml/go-rdbms/internal/dberr"
+)
+
+// HeapOptions はヒープファイルの格納方法の設定
+// 設定はヒープファイル自身には記録しないので、開くたびに作成時と同じ設定を渡す
+type HeapOptions struct {
+	// FillFactor は挿入でページを埋める割合（%）。0 なら 100
+	// 残した空きは、同じページにあるレコードの更新に使う
+	FillFactor int
+	// AppendOnly は挿入だけを許し、削除と更新を ErrAppendOnly で拒否する
+	AppendOnly bool
+	// Compress はレコードを DEFLATE で圧縮して格納する
+	// 各レコードの先頭に、圧縮したかどうかを示す1バイトを付ける（小さくならなければ圧縮しない）
+	// Columnar と一緒に指定した場合は無視する
+	Compress bool
+	// Columnar はデータページを列形式（ColumnPage）にする。レコードは tuple の形式でなければならない
+	Columnar bool
+}
+
+// ErrAppendOnly は追記専用のヒープファイルでレコードを削除・更新しようとした場合に返される
+var ErrAppendOnly = errors.New("heap file is append-only")
+
+// 圧縮するヒープファイルのレコードの先頭バイト
+const (
+	recRaw      = 0
+	recDeflated = 1
+)
+
+// CreateHeapFileWithOptions は設定を指定して空のヒープファイルを作成する
+func CreateHeapFileWithOptions(pg Pages, opts HeapOptions) (*HeapFile, error) {
+	h, err := CreateHeapFile(pg)
+	if err != nil {
+		return nil, err
+	}
+	h.opts = opts
+	return h, nil
+}
+
+// OpenHeapFileWithOptions は設定を指定して既存のヒープファイルを開く
+func OpenHeapFileWithOptions(pg Pages, root int64, opts HeapOptions) *HeapFile {
+	return &HeapFile{pg: pg, root: root, opts: opts}
+}
+
+// reserve は挿入時にページに残す空きのバイト数を返す
+func (h *HeapFile) reserve() int {
+	if h.opts.FillFactor <= 0 || h.opts.FillFactor >= 100 {
+		return 0
+	}
+	return (h.pg.PageSize() - hdrSize) * (100 - h.opts.FillFactor) / 100
+}
+
+// compressed はレコードを圧縮して格納するかを返す。列形式のページは値を列ごとに並べるので圧縮しない
+func (h *HeapFile) compressed() bool { return h.opts.Compress && !h.opts.Columnar }
+
+// encode はレコードを保存形式にする
+func (h *HeapFile) encode(rec []byte) ([]byte, error) {
+	if !h.compressed() {
+		return rec, nil
+	}
+	var buf bytes.Buffer
+	buf.WriteByte(recDeflated)
+	w, err := flate.NewWriter(&buf, flate.DefaultCompression)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(rec); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	if buf.Len() >= len(rec)+1 {
+		return append([]byte{recRaw}, rec...), nil
+	}
+	return buf.Bytes(), nil
+}
+
+// decode は保存形式のレコードを元に戻す
+func (h *HeapFile) decode(rec []byte) ([]byte, error) {
+	if !h.compressed() {
+		return rec, nil
+	}
+	if len(rec) == 0 {
+		return nil, dberr.Mark(errors.New("empty compressed record"), dberr.ErrCorrupt)
+	}
+	switch rec[0] {
+	case recRaw:
+		return rec[1:], nil
+	case recDeflated:
+		out, err := io.ReadAll(flate.NewReader(bytes.NewReader(rec[1:])))
+		if err != nil {
+			return nil, fmt.Errorf("decompress record: %w", err)
+		}
+		return out, nil
+	default:
+		return nil, dberr.Mark(fmt.Errorf("unknown record encoding %d", rec[0]), dberr.ErrCorrupt)
+	}
+}
+
+// DecodeRecord はヒープページに格納されたレコード rec を、ヒープファイルの設定に従って元に戻す
+// ヒープファイルを通さずにページを読む場合（論理レプリケーションなど）に使う
+func (h *HeapFile) DecodeRecord(rec []byte) ([]byte, error) { return h.decode(rec) }
@@ -4,11 +4,16 @@ import (
 	"encoding/binary"
 	"errors"
 	"fmt"
+
+	"github.com/k-sml/go-rdbms/internal/dberr"
 )
 
 // ページサイズは Pager 側の値と一致させる想定。ここでは 4096 をデフォルトに。
 const DefaultPageSize = 4096
 
+// ErrPageFull はページに空きがなくレコードを挿入できない場合に返される
+var ErrPageFull = dberr.ErrPageFull
+
 // ヘッダレイアウト（先頭から固定長）
 // [u16:slotCount][u16:freeStart][u16:freeEnd][u16:flags]
 //   slotCount: スロット配列の要素数
@@ -57,7 +62,7 @@ func NewHeapPage(buf []byte) (*HeapPage, error) {
 func (p *HeapPage) Insert(rec []byte) (int, error) {
 	need := uint16(len(rec)) + slotSize // レコードサイズ + スロットエントリサイズ
 	if p.freeSpace() < need {
-		return -1, errors.New("page is full")
+		return -1, ErrPageFull
 	}
 	// データは末尾側から詰める
 	newEnd := p.freeEnd() - uint16(len(rec))
@@ -107,6 +112,78 @@ func (p *HeapPage) Update(slotID int, rec []byte) error {
 	return err
 }
 
+// HeapOp はヒープページに対する論理操作の種類（WALの論理レコードで使う）
+type HeapOp uint8
+
+const (
+	HeapInsert HeapOp = iota + 1 // レコードの挿入
+	HeapDelete                   // レコードの削除
+	HeapUpdate                   // レコードの更新
+)
+
+// Apply は論理操作をページに適用する（WALの再実行用）
+// Insert/Delete/Update は同じページ状態に対して決定的に動くので、
+// 書き込み時と同じ順序で適用すればバイト単位で同じページが得られる
+// slot は Insert では挿入されるはずのスロット、Delete/Update では対象のスロット
+func (p *HeapPage) Apply(op HeapOp, slot int, rec []byte) error {
+	switch op {
+	case HeapInsert:
+		got, err := p.Insert(rec)
+		if err != nil {
+			return err
+		}
+		if got != slot {
+			return fmt.Errorf("redo insert landed in slot %d, want %d", got, slot)
+		}
+		return nil
+	case HeapDelete:
+		return p.Delete(slot)
+	case HeapUpdate:
+		return p.Update(slot, rec)
+	default:
+		return fmt.Errorf("unknown heap op: %d", op)
+	}
+}
+
+// NumSlots はスロット配列の要素数を返す（削除済みスロットも含む）
+func (p *HeapPage) NumSlots() int { return int(p.slotCount()) }
+
+// Slot はスロット slotID のレコードの位置と長さを返す。長さが 0 なら削除済みのスロット
+func (p *HeapPage) Slot(slotID int) (off, length int, ok bool) {
+	o, l, ok := p.slot(slotID)
+	return int(o), int(l), ok
+}
+
+// LooksLikeHeapPage はバッファがヒープページとして矛盾のないヘッダを持つかを判定する
+// 未初期化（すべてゼロ）のページは空のヒープページとみなす
+func LooksLikeHeapPage(buf []byte) bool {
+	if len(buf) < hdrSize {
+		return false
+	}
+	p := &HeapPage{buf: buf}
+	if p.slotCount() == 0 && p.freeStart() == 0 && p.freeEnd() == 0 {
+		return true
+	}
+	if int(p.freeStart()) != hdrSize+int(p.slotCount())*slotSize || p.freeStart() > p.freeEnd() || int(p.freeEnd()) > len(buf) {
+		return false
+	}
+	for i := 0; i < int(p.slotCount()); i++ {
+		off, ln, _ := p.slot(i)
+		if ln > 0 && (off < p.freeEnd() || int(off)+int(ln) > len(buf)) {
+			return false
+		}
+	}
+	return true
+}
+
+// room はレコード rec をスロットとともに挿入した後に残る自由領域のバイト数を返す
+func (p *HeapPage) room(rec []byte) (int, error) {
+	return p.FreeSpace() - len(rec) - slotSize, nil
+}
+
+// FreeSpace はページに残っている自由領域のバイト数を返す（スロットエントリの分を含む）
+func (p *HeapPage) FreeSpace() int { return int(p.freeSpace()) }
+
 // freeSpace はページ内の利用可能な自由領域のサイズを返す
 // freeStart から freeEnd までの領域サイズを計算
 func (p *HeapPage) freeSpace() uint16 {
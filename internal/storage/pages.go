@@ -0,0 +1,132 @@
+package storage
+
+import (
+	"encoding/binary"
+	"fmt"
+	"slices"
+
+	"github.com/k-sml/go-rdbms/internal/dberr"
+)
+
+// Pages はページ単位の読み書きの窓口（pager.Pager や txn.Tx が満たす）
+type Pages interface {
+	ReadPage(pageID int64) ([]byte, error)
+	WritePage(pageID int64, buf []byte) error
+	PageSize() int
+}
+
+// 解放されたページは単方向リストにつなぐ
+// 解放済みページのレイアウト: [4:"FREE"][i64:次の解放済みページ（0 なら末尾）]
+var freeMagic = [4]byte{'F', 'R', 'E', 'E'}
+
+// AllocPage は新しいページを1つ割り当ててページIDを返す
+// 解放済みのページがあればそれを再利用し、なければファイルの末尾に追加する
+// 割り当てたページはゼロで初期化される
+func AllocPage(pg Pages) (int64, error) {
+	h, err := ReadHeader(pg)
+	if err != nil {
+		return 0, err
+	}
+	h.NumPages = max(h.NumPages, 1) // ページ0はヘッダ
+
+	var id int64
+	if h.FreeHead != 0 {
+		id = h.FreeHead
+		buf, err := pg.ReadPage(id)
+		if err != nil {
+			return 0, err
+		}
+		if [4]byte(buf[0:4]) != freeMagic {
+			return 0, dberr.Mark(fmt.Errorf("free list is corrupt at page %d", id), dberr.ErrCorrupt)
+		}
+		h.FreeHead = int64(binary.LittleEndian.Uint64(buf[4:12]))
+	} else {
+		id = h.NumPages
+		h.NumPages++
+	}
+	if err := WriteHeader(pg, h); err != nil {
+		return 0, err
+	}
+	if err := pg.WritePage(id, make([]byte, pg.PageSize())); err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+// FreePage はページを解放し、後で AllocPage が再利用できるようにする
+func FreePage(pg Pages, id int64) error {
+	if id <= 0 {
+		return dberr.Mark(fmt.Errorf("invalid page ID: %d", id), dberr.ErrPageNotFound)
+	}
+	h, err := ReadHeader(pg)
+	if err != nil {
+		return err
+	}
+	if id >= h.NumPages {
+		return dberr.Mark(fmt.Errorf("page %d is not allocated", id), dberr.ErrPageNotFound)
+	}
+	buf := make([]byte, pg.PageSize())
+	copy(buf[0:4], freeMagic[:])
+	binary.LittleEndian.PutUint64(buf[4:12], uint64(h.FreeHead))
+	if err := pg.WritePage(id, buf); err != nil {
+		return err
+	}
+	h.FreeHead = id
+	return WriteHeader(pg, h)
+}
+
+// IsFreePage はページが解放済みのページかを判定する
+func IsFreePage(buf []byte) bool {
+	return len(buf) >= 12 && [4]byte(buf[0:4]) == freeMagic
+}
+
+// NextFreePage は解放済みのページ buf が指す、リストの次の解放済みページのIDを返す（0 なら末尾）
+func NextFreePage(buf []byte) int64 {
+	return int64(binary.LittleEndian.Uint64(buf[4:12]))
+}
+
+// CompactFreeList は解放済みのページのリストをページIDの小さい順につなぎ直し、ファイルの末尾に
+// 続く解放済みのページをファイルから外す（ヘッダのページ数を減らす）
+// つなぎ直した後の AllocPage はファイルの前の方のページから再利用する
+// ファイルから外したページの数と、リストに残ったページの数を返す
+func CompactFreeList(pg Pages) (removed, remaining int64, err error) {
+	h, err := ReadHeader(pg)
+	if err != nil {
+		return 0, 0, err
+	}
+	var ids []int64
+	for id := h.FreeHead; id != 0; {
+		if id >= h.NumPages || int64(len(ids)) >= h.NumPages {
+			return 0, 0, dberr.Mark(fmt.Errorf("free list is corrupt at page %d", id), dberr.ErrCorrupt)
+		}
+		buf, err := pg.ReadPage(id)
+		if err != nil {
+			return 0, 0, err
+		}
+		if !IsFreePage(buf) {
+			return 0, 0, dberr.Mark(fmt.Errorf("free list is corrupt at page %d", id), dberr.ErrCorrupt)
+		}
+		ids = append(ids, id)
+		id = NextFreePage(buf)
+	}
+	slices.Sort(ids)
+	for len(ids) > 0 && ids[len(ids)-1] == h.NumPages-1 {
+		ids = ids[:len(ids)-1]
+		h.NumPages--
+		removed++
+	}
+	h.FreeHead = 0
+	for i := len(ids) - 1; i >= 0; i-- {
+		buf := make([]byte, pg.PageSize())
+		copy(buf[0:4], freeMagic[:])
+		binary.LittleEndian.PutUint64(buf[4:12], uint64(h.FreeHead))
+		if err := pg.WritePage(ids[i], buf); err != nil {
+			return 0, 0, err
+		}
+		h.FreeHead = ids[i]
+	}
+	if err := WriteHeader(pg, h); err != nil {
+		return 0, 0, err
+	}
+	return removed, int64(len(ids)), nil
+}
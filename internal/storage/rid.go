@@ -0,0 +1,12 @@
+package storage
+
+import "fmt"
+
+// RID はテーブル内の行の物理的な位置（ページIDとスロットID）を表す
+type RID struct {
+	PageID int64 // 行が格納されているページ
+	Slot   int   // ページ内のスロット番号
+}
+
+// String は "(page,slot)" 形式の文字列を返す
+func (r RID) String() string { return fmt.Sprintf("(%d,%d)", r.PageID, r.Slot) }
@@ -0,0 +1,362 @@
+// Package stress は並行に動くトランザクションでエンジンを揺さぶる負荷試験を提供します。
+// 口座のテーブルの間で残高を移す送金を、いくつものゴルーチンがさまざまな分離レベルで
+// 同時に実行し、途中と最後に不変条件が保たれているかを検証します。
+//
+// 検証する不変条件は次のとおりです。
+//
+//   - 実行中のどのスナップショットでも、残高の合計が最初の合計と等しい
+//   - 送金は履歴のテーブルに1行を書くので、履歴の行の数はコミットした送金の数と等しい
+//   - 口座ごとの残高が、最初の残高に履歴の入金と出金を足し引きしたものと等しい
+//   - ヒープとインデックスの整合性の検査で問題が見つからない
+//   - データベースを開き直しても、以上がすべて成り立つ
+package stress
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/k-sml/go-rdbms/internal/engine"
+	"github.com/k-sml/go-rdbms/internal/lock"
+	"github.com/k-sml/go-rdbms/internal/pager"
+	"github.com/k-sml/go-rdbms/internal/txn"
+)
+
+// Config は試験の設定です。ゼロ値のフィールドには既定値が使われます。
+type Config struct {
+	Path        string            // データベースファイル（存在してはいけない）
+	Journal     pager.JournalMode // コミットの永続化方式
+	Seed        int64             // 乱数の種
+	Workers     int               // 同時に操作するゴルーチンの数（既定 8）
+	Duration    time.Duration     // 操作を続ける時間（既定 5 秒）
+	Accounts    int               // 口座の数（既定 50）
+	Balance     int64             // 口座ごとの最初の残高（既定 1000）
+	Isolations  []txn.Isolation   // 送金に使う分離レベル（nil なら Locking、Snapshot、Serializable、Optimistic）
+	LockTimeout time.Duration     // ロックを待つ時間の上限（既定 1 秒）
+}
+
+func (c *Config) setDefaults() {
+	if c.Workers <= 0 {
+		c.Workers = 8
+	}
+	if c.Duration <= 0 {
+		c.Duration = 5 * time.Second
+	}
+	if c.Accounts < 2 {
+		c.Accounts = 50
+	}
+	if c.Balance <= 0 {
+		c.Balance = 1000
+	}
+	if c.Isolations == nil {
+		c.Isolations = []txn.Isolation{txn.Locking, txn.Snapshot, txn.Serializable, txn.Optimistic}
+	}
+	if c.LockTimeout <= 0 {
+		c.LockTimeout = time.Second
+	}
+}
+
+// Report は試験結果です。
+type Report struct {
+	Transfers uint64   // コミットした送金
+	Aborted   uint64   // 途中でロールバックした送金
+	Conflicts uint64   // 直列化の失敗、デッドロック、ロックの時間切れで中断した送金
+	Audits    uint64   // 残高の合計を確かめたスナップショット
+	Failures  []string // 見つかった不変条件の違反と、予期しないエラー
+}
+
+// Run は cfg.Path に新しいデータベースを作って試験を実行します。違反は Report.Failures に
+// 集められ、試験自体を続けられない場合だけエラーを返します。データベースファイルは
+// 終わった後も残します。
+func Run(cfg Config) (*Report, error) {
+	cfg.setDefaults()
+	if _, err := os.Stat(cfg.Path); err == nil {
+		return nil, fmt.Errorf("%s already exists", cfg.Path)
+	}
+	opts := engine.Options{Journal: cfg.Journal}
+	db, err := engine.Open(cfg.Path, opts)
+	if err != nil {
+		return nil, err
+	}
+	s := &stress{cfg: cfg, db: db, rep: &Report{}}
+	if err := s.setup(); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	var wg sync.WaitGroup
+	deadline := time.Now().Add(cfg.Duration)
+	for w := 0; w < cfg.Workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			rng := rand.New(rand.NewSource(cfg.Seed + int64(w)))
+			for time.Now().Before(deadline) {
+				if err := s.step(rng); err != nil {
+					s.fail("worker %d: %v", w, err)
+					return
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	s.rep.Transfers = s.transfers.Load()
+	s.rep.Aborted = s.aborted.Load()
+	s.rep.Conflicts = s.conflicts.Load()
+	s.rep.Audits = s.audits.Load()
+
+	s.verify("after the run")
+	if err := db.Close(); err != nil {
+		return nil, err
+	}
+	if s.db, err = engine.Open(cfg.Path, opts); err != nil {
+		s.fail("reopen: %v", err)
+		return s.rep, nil
+	}
+	defer s.db.Close()
+	s.verify("after reopening")
+	return s.rep, nil
+}
+
+// stress は実行中の試験です。
+type stress struct {
+	cfg Config
+	db  *engine.DB
+
+	nextID    atomic.Int64 // 履歴の行に振る番号
+	transfers atomic.Uint64
+	aborted   atomic.Uint64
+	conflicts atomic.Uint64
+	audits    atomic.Uint64
+
+	mu  sync.Mutex
+	rep *Report
+}
+
+func (s *stress) fail(format string, args ...any) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rep.Failures = append(s.rep.Failures, fmt.Sprintf(format, args...))
+}
+
+// setup はテーブルとインデックスを作り、口座に最初の残高を入れます。
+func (s *stress) setup() error {
+	_, err := s.db.ExecScript(`
+		CREATE TABLE accounts (id INT PRIMARY KEY, balance BIGINT NOT NULL);
+		CREATE TABLE history (id BIGINT PRIMARY KEY, src INT NOT NULL, dst INT NOT NULL, amount BIGINT NOT NULL);
+		CREATE INDEX history_src ON history (src);`)
+	if err != nil {
+		return err
+	}
+	tx, err := s.db.Begin(txn.Options{})
+	if err != nil {
+		return err
+	}
+	for id := 0; id < s.cfg.Accounts; id++ {
+		if _, err := tx.Exec("INSERT INTO accounts VALUES (?, ?)", id, s.cfg.Balance); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// step は操作を1つ無作為に選んで実行します。不変条件の違反は記録して続け、予期しない
+// エラーだけを返します。
+func (s *stress) step(rng *rand.Rand) error {
+	switch r := rng.Float64(); {
+	case r < 0.7:
+		return s.transfer(rng, false)
+	case r < 0.8:
+		return s.transfer(rng, true)
+	default:
+		return s.audit(rng)
+	}
+}
+
+// transfer は無作為に選んだ2つの口座の間で送金し、履歴に記録します。abort が true なら、
+// 送金元から引き落としたところでロールバックします。
+func (s *stress) transfer(rng *rand.Rand, abort bool) error {
+	iso := s.cfg.Isolations[rng.Intn(len(s.cfg.Isolations))]
+	src := rng.Intn(s.cfg.Accounts)
+	dst := (src + 1 + rng.Intn(s.cfg.Accounts-1)) % s.cfg.Accounts
+	amount := 1 + rng.Int63n(100)
+
+	tx, err := s.db.Begin(txn.Options{Isolation: iso, LockTimeout: s.cfg.LockTimeout})
+	if err != nil {
+		return err
+	}
+	// 残高を確かめてから引き落とす（読んだ行のロックを書き込みのために昇格させる）
+	_, err = queryRows(tx, "SELECT balance FROM accounts WHERE id = ?", src)
+	if err == nil {
+		err = s.exec(tx, "UPDATE accounts SET balance = balance - ? WHERE id = ?", amount, src)
+	}
+	if err == nil && abort {
+		s.aborted.Add(1)
+		return tx.Rollback()
+	}
+	if err == nil {
+		err = s.exec(tx, "UPDATE accounts SET balance = balance + ? WHERE id = ?", amount, dst)
+	}
+	if err == nil {
+		_, err = tx.Exec("INSERT INTO history VALUES (?, ?, ?, ?)", s.nextID.Add(1), src, dst, amount)
+	}
+	if err == nil {
+		err = tx.Commit()
+	} else {
+		tx.Rollback()
+	}
+	switch {
+	case err == nil:
+		s.transfers.Add(1)
+	case isConflict(err):
+		s.conflicts.Add(1)
+	default:
+		return fmt.Errorf("transfer (%s): %w", iso, err)
+	}
+	return nil
+}
+
+// isConflict は err が同時実行の競合でトランザクションを中断したことを表すかを返します。
+func isConflict(err error) bool {
+	return errors.Is(err, txn.ErrSerialization) || errors.Is(err, lock.ErrDeadlock) || errors.Is(err, lock.ErrLockNotAvailable)
+}
+
+// exec は1行だけを変更するはずの文を実行します。
+func (s *stress) exec(tx *engine.Tx, sql string, args ...any) error {
+	n, err := tx.Exec(sql, args...)
+	if err == nil && n != 1 {
+		s.fail("%q changed %d rows, want 1", sql, n)
+	}
+	return err
+}
+
+// audit は無作為に選んだ分離レベルのトランザクションで残高の合計を確かめます。読み取り専用の
+// トランザクションはスナップショットを読み、Locking ではテーブルを共有ロックして読みます。
+func (s *stress) audit(rng *rand.Rand) error {
+	opts := txn.Options{ReadOnly: true}
+	if i := rng.Intn(len(s.cfg.Isolations) + 1); i < len(s.cfg.Isolations) {
+		opts = txn.Options{Isolation: s.cfg.Isolations[i], LockTimeout: s.cfg.LockTimeout}
+	}
+	tx, err := s.db.Begin(opts)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	balances, err := readBalances(tx)
+	if isConflict(err) {
+		s.conflicts.Add(1)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("audit (%s): %w", opts.Isolation, err)
+	}
+	if sum, want := sumOf(balances), s.total(); sum != want {
+		s.fail("snapshot sees a total balance of %d, want %d", sum, want)
+	}
+	s.audits.Add(1)
+	return nil
+}
+
+// verify は実行を終えた後の不変条件を確かめます。
+func (s *stress) verify(when string) {
+	tx, err := s.db.Begin(txn.Options{ReadOnly: true})
+	if err != nil {
+		s.fail("%s: %v", when, err)
+		return
+	}
+	defer tx.Rollback()
+	balances, err := readBalances(tx)
+	if err != nil {
+		s.fail("%s: reading accounts: %v", when, err)
+		return
+	}
+	if len(balances) != s.cfg.Accounts {
+		s.fail("%s: %d accounts, want %d", when, len(balances), s.cfg.Accounts)
+	}
+	if sum, want := sumOf(balances), s.total(); sum != want {
+		s.fail("%s: total balance is %d, want %d", when, sum, want)
+	}
+
+	// 履歴から口座ごとの残高を計算し直す
+	want := make(map[int64]int64, s.cfg.Accounts)
+	for id := range balances {
+		want[id] = s.cfg.Balance
+	}
+	rows, err := tx.Query("SELECT src, dst, amount FROM history")
+	if err != nil {
+		s.fail("%s: reading history: %v", when, err)
+		return
+	}
+	var n uint64
+	for rows.Next() {
+		v := rows.Values()
+		want[v[0].Int()] -= v[2].Int()
+		want[v[1].Int()] += v[2].Int()
+		n++
+	}
+	if err := rows.Err(); err != nil {
+		s.fail("%s: reading history: %v", when, err)
+	}
+	rows.Close()
+	if n != s.rep.Transfers {
+		s.fail("%s: history has %d rows, but %d transfers committed", when, n, s.rep.Transfers)
+	}
+	for id, b := range balances {
+		if b != want[id] {
+			s.fail("%s: account %d has balance %d, history says %d", when, id, b, want[id])
+		}
+	}
+
+	problems, err := tx.CheckIntegrity()
+	if err != nil {
+		s.fail("%s: integrity check: %v", when, err)
+	}
+	for _, p := range problems {
+		s.fail("%s: integrity check: %s", when, p)
+	}
+}
+
+// total は残高の合計のあるべき値を返します。
+func (s *stress) total() int64 { return int64(s.cfg.Accounts) * s.cfg.Balance }
+
+// queryRows は問い合わせを実行し、結果の行の数を返します。
+func queryRows(tx *engine.Tx, sql string, args ...any) (int, error) {
+	rows, err := tx.Query(sql, args...)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+	n := 0
+	for rows.Next() {
+		n++
+	}
+	return n, rows.Err()
+}
+
+// readBalances は口座ごとの残高を読みます。
+func readBalances(tx *engine.Tx) (map[int64]int64, error) {
+	rows, err := tx.Query("SELECT id, balance FROM accounts")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	balances := make(map[int64]int64)
+	for rows.Next() {
+		v := rows.Values()
+		balances[v[0].Int()] = v[1].Int()
+	}
+	return balances, rows.Err()
+}
+
+func sumOf(balances map[int64]int64) int64 {
+	var sum int64
+	for _, b := range balances {
+		sum += b
+	}
+	return sum
+}
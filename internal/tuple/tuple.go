@@ -0,0 +1,133 @@
+// Package tuple は行（値の並び）とバイト列の相互変換を行います。
+//
+// エンコード形式:
+// [u16:値の数] に続いて、各値を types.AppendValue の保存形式で並べます。
+package tuple
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/k-sml/go-rdbms/internal/dberr"
+	"github.com/k-sml/go-rdbms/internal/types"
+)
+
+// ErrCorrupt はバイト列が正しいタプルでない場合に返されます。
+var ErrCorrupt = dberr.Mark(errors.New("corrupt tuple"), dberr.ErrCorrupt)
+
+// Encode は値の並びをバイト列にします。
+func Encode(vals []types.Value) []byte {
+	out := binary.LittleEndian.AppendUint16(nil, uint16(len(vals)))
+	for _, v := range vals {
+		out = types.AppendValue(out, v)
+	}
+	return out
+}
+
+// Decode はバイト列を値の並びに戻します。
+func Decode(b []byte) ([]types.Value, error) {
+	if len(b) < 2 {
+		return nil, ErrCorrupt
+	}
+	n := int(binary.LittleEndian.Uint16(b[0:2]))
+	b = b[2:]
+	vals := make([]types.Value, 0, n)
+	for i := 0; i < n; i++ {
+		v, size, err := types.DecodeValue(b)
+		if err != nil {
+			return nil, fmt.Errorf("%w: value %d: %v", ErrCorrupt, i, err)
+		}
+		vals = append(vals, v)
+		b = b[size:]
+	}
+	return vals, nil
+}
+
+// DecodeColumns は Decode と同じですが、want[i] が false の値は読み飛ばして NULL にします。
+// want より後ろの値も読み飛ばします。
+func DecodeColumns(b []byte, want []bool) ([]types.Value, error) {
+	if len(b) < 2 {
+		return nil, ErrCorrupt
+	}
+	n := int(binary.LittleEndian.Uint16(b[0:2]))
+	b = b[2:]
+	vals := make([]types.Value, n)
+	for i := 0; i < n; i++ {
+		var size int
+		var err error
+		if i < len(want) && want[i] {
+			vals[i], size, err = types.DecodeValue(b)
+		} else {
+			size, err = types.SkipValue(b)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: value %d: %v", ErrCorrupt, i, err)
+		}
+		b = b[size:]
+	}
+	return vals, nil
+}
+
+// DecodeInto は DecodeColumns と同じですが、j 番目の値を vecs[j][i] に書きます。want が nil なら
+// すべての値を読みます。値の数を返します。値の数が len(vecs) より多ければエラーです。
+func DecodeInto(b []byte, vecs [][]types.Value, i int, want []bool) (int, error) {
+	if len(b) < 2 {
+		return 0, ErrCorrupt
+	}
+	n := int(binary.LittleEndian.Uint16(b[0:2]))
+	if n > len(vecs) {
+		return n, fmt.Errorf("%w: %d values, want at most %d", ErrCorrupt, n, len(vecs))
+	}
+	b = b[2:]
+	for j := 0; j < n; j++ {
+		var size int
+		var err error
+		if want == nil || j < len(want) && want[j] {
+			vecs[j][i], size, err = types.DecodeValue(b)
+		} else {
+			vecs[j][i] = types.NullValue()
+			size, err = types.SkipValue(b)
+		}
+		if err != nil {
+			return n, fmt.Errorf("%w: value %d: %v", ErrCorrupt, j, err)
+		}
+		b = b[size:]
+	}
+	return n, nil
+}
+
+// Split はバイト列を値ごとの保存形式のバイト列に分けます。返すスライスは b を指します。
+func Split(b []byte) ([][]byte, error) {
+	if len(b) < 2 {
+		return nil, ErrCorrupt
+	}
+	n := int(binary.LittleEndian.Uint16(b[0:2]))
+	b = b[2:]
+	vals := make([][]byte, n)
+	for i := range vals {
+		size, err := types.SkipValue(b)
+		if err != nil {
+			return nil, fmt.Errorf("%w: value %d: %v", ErrCorrupt, i, err)
+		}
+		vals[i], b = b[:size:size], b[size:]
+	}
+	return vals, nil
+}
+
+// Join は Split で分けた値の保存形式を1つのバイト列に戻します。nil の値は NULL です。
+func Join(vals [][]byte) []byte {
+	size := 2
+	for _, v := range vals {
+		size += max(len(v), 1)
+	}
+	out := binary.LittleEndian.AppendUint16(make([]byte, 0, size), uint16(len(vals)))
+	for _, v := range vals {
+		if v == nil {
+			out = types.AppendValue(out, types.NullValue())
+		} else {
+			out = append(out, v...)
+		}
+	}
+	return out
+}
@@ -0,0 +1,167 @@
+package tuple
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/k-sml/go-rdbms/internal/dberr"
+	"github.com/k-sml/go-rdbms/internal/types"
+)
+
+// rows は試験に使う行です。
+var rows = [][]types.Value{
+	{},
+	{types.NullValue()},
+	{types.NewInt(-7), types.NewBigInt(math.MaxInt64), types.NewReal(2.5)},
+	{types.NewText(""), types.NewText("héllo"), types.NewBlob([]byte{0, 1, 2})},
+	{types.NewBool(true), types.NullValue(), types.NewBool(false), types.NewTimestamp(time.UnixMicro(1700000000123456))},
+}
+
+// equal は2つの行が同じ型の同じ値を並べたものかを返します。
+func equal(a, b []types.Value) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Type() != b[i].Type() || a[i].String() != b[i].String() {
+			return false
+		}
+	}
+	return true
+}
+
+// TestRoundTrip は、Encode した行を Decode すると元の行に戻ることを確かめます。
+func TestRoundTrip(t *testing.T) {
+	for _, row := range rows {
+		got, err := Decode(Encode(row))
+		if err != nil {
+			t.Fatalf("Decode(Encode(%v)): %v", row, err)
+		}
+		if !equal(got, row) {
+			t.Errorf("Decode(Encode(%v)) = %v", row, got)
+		}
+	}
+}
+
+// TestDecodeColumns は、DecodeColumns が want で選んだ値だけを読み、ほかの値を NULL にすることを
+// 確かめます。
+func TestDecodeColumns(t *testing.T) {
+	row := rows[3]
+	null := types.NullValue()
+	tests := []struct {
+		want []bool
+		out  []types.Value
+	}{
+		{nil, []types.Value{null, null, null}},
+		{[]bool{true, true, true}, row},
+		{[]bool{false, true}, []types.Value{null, row[1], null}},
+		{[]bool{true, false, true, true}, []types.Value{row[0], null, row[2]}},
+	}
+	for _, tt := range tests {
+		got, err := DecodeColumns(Encode(row), tt.want)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !equal(got, tt.out) {
+			t.Errorf("DecodeColumns(%v) = %v, want %v", tt.want, got, tt.out)
+		}
+	}
+}
+
+// TestDecodeInto は、DecodeInto が値を列ごとのベクトルの同じ位置に書くことを確かめます。
+func TestDecodeInto(t *testing.T) {
+	row := rows[2]
+	null := types.NullValue()
+	tests := []struct {
+		want []bool
+		out  []types.Value
+	}{
+		{nil, row},
+		{[]bool{false, false, true}, []types.Value{null, null, row[2]}},
+	}
+	for _, tt := range tests {
+		vecs := make([][]types.Value, 4)
+		for j := range vecs {
+			vecs[j] = make([]types.Value, 2)
+		}
+		n, err := DecodeInto(Encode(row), vecs, 1, tt.want)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != len(row) {
+			t.Errorf("DecodeInto(%v) = %d values, want %d", tt.want, n, len(row))
+		}
+		got := []types.Value{vecs[0][1], vecs[1][1], vecs[2][1]}
+		if !equal(got, tt.out) {
+			t.Errorf("DecodeInto(%v) wrote %v, want %v", tt.want, got, tt.out)
+		}
+	}
+	if _, err := DecodeInto(Encode(row), make([][]types.Value, 2), 0, nil); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("DecodeInto with too few vectors: err = %v, want ErrCorrupt", err)
+	}
+}
+
+// TestSplitJoin は、Split で値ごとに分けたバイト列を Join すると元に戻り、
+// nil の値は NULL になることを確かめます。
+func TestSplitJoin(t *testing.T) {
+	for _, row := range rows {
+		b := Encode(row)
+		vals, err := Split(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(vals) != len(row) {
+			t.Errorf("Split(%v) = %d values, want %d", row, len(vals), len(row))
+		}
+		if got := Join(vals); !bytes.Equal(got, b) {
+			t.Errorf("Join(Split(%v)) = %x, want %x", row, got, b)
+		}
+	}
+	vals, err := Split(Encode(rows[2]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	vals[1] = nil
+	got, err := Decode(Join(vals))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []types.Value{rows[2][0], types.NullValue(), rows[2][2]}; !equal(got, want) {
+		t.Errorf("Join with a nil value = %v, want %v", got, want)
+	}
+}
+
+// TestCorrupt は、壊れたバイト列を読むと ErrCorrupt（dberr.ErrCorrupt）を返すことを確かめます。
+func TestCorrupt(t *testing.T) {
+	full := Encode(rows[3])
+	tests := []struct {
+		name string
+		b    []byte
+	}{
+		{"empty", nil},
+		{"short count", []byte{1}},
+		{"missing value", []byte{2, 0, 0}},
+		{"truncated value", full[:len(full)-1]},
+	}
+	decoders := map[string]func(b []byte) error{
+		"Decode":        func(b []byte) error { _, err := Decode(b); return err },
+		"DecodeColumns": func(b []byte) error { _, err := DecodeColumns(b, []bool{true}); return err },
+		"Split":         func(b []byte) error { _, err := Split(b); return err },
+		"DecodeInto": func(b []byte) error {
+			vecs := [][]types.Value{make([]types.Value, 1), make([]types.Value, 1), make([]types.Value, 1)}
+			_, err := DecodeInto(b, vecs, 0, nil)
+			return err
+		},
+	}
+	for _, tt := range tests {
+		for name, decode := range decoders {
+			err := decode(tt.b)
+			if !errors.Is(err, ErrCorrupt) || !errors.Is(err, dberr.ErrCorrupt) {
+				t.Errorf("%s: %s: err = %v, want ErrCorrupt", tt.name, name, err)
+			}
+		}
+	}
+}
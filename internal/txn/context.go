@@ -0,0 +1,76 @@
+package txn
+
+import "context"
+
+// コンテキスト
+//
+// トランザクションは2つのコンテキストを見る。BeginContext に渡したコンテキストはトランザクション
+// 全体のもので、取り消されるとそれ以降の読み書きとコミットが失敗する。SetContext で設定する
+// コンテキストは1回の呼び出し（文の実行や結果の1行の読み取り）のもので、呼び出しが終わったら
+// 元に戻す。どちらかが取り消されると、ロックを待っているところと、次にページを読むところで
+// ctx.Err() を返すので、実行中の走査もそこで止まる。
+
+// BeginContext は Begin と同じですが、トランザクション全体で使うコンテキスト ctx を設定します。
+func (m *Manager) BeginContext(ctx context.Context, opts Options) (*Tx, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	tx, err := m.Begin(opts)
+	if err != nil {
+		return nil, err
+	}
+	tx.base = ctx
+	return tx, nil
+}
+
+// SetContext は1回の呼び出しで使うコンテキストを設定し、元に戻す関数を返します。
+// 元に戻す関数は呼び出しを終えたら必ず呼び出してください。
+func (tx *Tx) SetContext(ctx context.Context) (restore func()) {
+	prev := tx.call.Swap(&ctx)
+	return func() { tx.call.Store(prev) }
+}
+
+// Context は呼び出しのコンテキストを返します。呼び出しのコンテキストがないか
+// context.Background() なら、トランザクション全体のコンテキストを返し、それもなければ
+// context.Background() を返します。
+func (tx *Tx) Context() context.Context {
+	if p := tx.call.Load(); p != nil && *p != context.Background() {
+		return *p
+	}
+	if tx.base != nil {
+		return tx.base
+	}
+	return context.Background()
+}
+
+// ctxErr はトランザクション全体か呼び出しのコンテキストが取り消されていれば、そのエラーを返します。
+func (tx *Tx) ctxErr() error {
+	if tx.base != nil && tx.base.Err() != nil {
+		return context.Cause(tx.base)
+	}
+	if p := tx.call.Load(); p != nil && (*p).Err() != nil {
+		return context.Cause(*p)
+	}
+	return nil
+}
+
+// lockContext はロックの待機に使うコンテキストを返します。トランザクション全体と呼び出しの
+// コンテキストの両方があれば、どちらかが取り消されると取り消されるコンテキストを作ります。
+// 待ち終えたら stop を呼び出してください。
+func (tx *Tx) lockContext() (ctx context.Context, stop func()) {
+	base, call := tx.base, tx.call.Load()
+	switch {
+	case call == nil && base == nil:
+		return context.Background(), func() {}
+	case call == nil:
+		return base, func() {}
+	case base == nil || base.Done() == nil || base == *call:
+		return *call, func() {}
+	}
+	ctx, cancel := context.WithCancelCause(*call)
+	stopAfter := context.AfterFunc(base, func() { cancel(context.Cause(base)) })
+	return ctx, func() {
+		stopAfter()
+		cancel(nil)
+	}
+}
@@ -0,0 +1,46 @@
+package txn
+
+import "slices"
+
+// Hook はトランザクションの終了後に呼び出される関数です。
+// tables はトランザクションが書き込みのためにロックしたテーブルです
+// （LockTable や LockRow で排他系のロックを要求したもの。ロックを取らない分離レベルでも記録されます）。
+type Hook func(txID uint64, tables []string)
+
+// OnCommit はトランザクションのコミットが永続化された後に呼び出す関数を登録します。
+// フックはコミットしたゴルーチンで、ロックを解放した後に登録順に呼び出されます。
+func (m *Manager) OnCommit(h Hook) {
+	m.hookMu.Lock()
+	defer m.hookMu.Unlock()
+	m.onCommit = append(m.onCommit, h)
+}
+
+// OnRollback はトランザクションがロールバックされた後に呼び出す関数を登録します。
+// コミットに失敗した場合も呼び出されます。
+func (m *Manager) OnRollback(h Hook) {
+	m.hookMu.Lock()
+	defer m.hookMu.Unlock()
+	m.onRollback = append(m.onRollback, h)
+}
+
+// runHooks は終了したトランザクションのフックを呼び出します。
+func (m *Manager) runHooks(tx *Tx, committed bool) {
+	m.hookMu.Lock()
+	hooks := m.onRollback
+	if committed {
+		hooks = m.onCommit
+	}
+	hooks = slices.Clone(hooks)
+	m.hookMu.Unlock()
+
+	for _, h := range hooks {
+		h(tx.id, slices.Clone(tx.tables))
+	}
+}
+
+// touch は書き込み対象としてテーブルを記録します。
+func (tx *Tx) touch(table string) {
+	if !slices.Contains(tx.tables, table) {
+		tx.tables = append(tx.tables, table)
+	}
+}
@@ -0,0 +1,98 @@
+package txn
+
+import (
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/k-sml/go-rdbms/internal/lock"
+)
+
+// String は分離レベルの名前を返します。
+func (iso Isolation) String() string {
+	switch iso {
+	case Locking:
+		return "locking"
+	case Snapshot:
+		return "snapshot"
+	case Serializable:
+		return "serializable"
+	case Optimistic:
+		return "optimistic"
+	default:
+		return fmt.Sprintf("Isolation(%d)", int(iso))
+	}
+}
+
+// Info は実行中のトランザクションの状態です。長時間実行されているトランザクションや、
+// 他のトランザクションを止めているトランザクションを調べるために使います。
+type Info struct {
+	ID        uint64
+	Isolation Isolation
+	ReadOnly  bool
+	Start     time.Time
+	// SnapshotAge はスナップショットを取ってから他のトランザクションがコミットした回数です。
+	// 大きいほど、古いページを長く残させています（Snapshot と Serializable のみ意味を持ちます）。
+	SnapshotAge uint64
+	Locks       map[lock.Resource]lock.Mode // 保持しているロック
+	Waiting     *lock.Wait                  // 待っているロック（待っていなければ nil）
+}
+
+// Age は開始からの経過時間を返します。
+func (i Info) Age() time.Duration { return time.Since(i.Start) }
+
+// Active は実行中のトランザクションの一覧を、開始の古い順に返します。
+func (m *Manager) Active() []Info {
+	m.commitMu.RLock()
+	m.mu.Lock()
+	txs := make([]*Tx, 0, len(m.active))
+	for _, tx := range m.active {
+		txs = append(txs, tx)
+	}
+	seq := m.seq
+	m.mu.Unlock()
+	m.commitMu.RUnlock()
+
+	out := make([]Info, 0, len(txs))
+	for _, tx := range txs {
+		info := Info{
+			ID:          tx.id,
+			Isolation:   tx.iso,
+			ReadOnly:    tx.readOnly,
+			Start:       tx.start,
+			SnapshotAge: seq - tx.snapshot,
+			Locks:       m.locks.Held(tx.id),
+		}
+		if w, ok := m.locks.Waiting(tx.id); ok {
+			info.Waiting = &w
+		}
+		out = append(out, info)
+	}
+	slices.SortFunc(out, func(a, b Info) int { return a.Start.Compare(b.Start) })
+	return out
+}
+
+// Stats はトランザクションマネージャの状態と、作成してからの累計です。
+type Stats struct {
+	Active    int    // 実行中のトランザクション
+	Commits   uint64 // コミットしたトランザクション
+	Rollbacks uint64 // ロールバックしたトランザクション（コミットに失敗したものを含む）
+	PageReads uint64 // トランザクションが読んだページ
+	// CachedReads は PageReads のうち、ページャーを読まずにメモリ上のページ（自分が書き込んだ
+	// ページか、スナップショットのために残している古いページ）から返したものです。
+	CachedReads uint64
+}
+
+// Stats はトランザクションマネージャの状態を返します。
+func (m *Manager) Stats() Stats {
+	m.mu.Lock()
+	active := len(m.active)
+	m.mu.Unlock()
+	return Stats{
+		Active:      active,
+		Commits:     m.commits.Load(),
+		Rollbacks:   m.rollbacks.Load(),
+		PageReads:   m.pageReads.Load(),
+		CachedReads: m.cachedReads.Load(),
+	}
+}
@@ -0,0 +1,112 @@
+package txn
+
+import (
+	"slices"
+)
+
+// Nested はトランザクションの中で開始した入れ子のトランザクションです。
+// セーブポイントとして実装されており、Rollback は開始時点より後の書き込みだけを取り消し、
+// Commit はその書き込みを外側のトランザクションに引き継ぎます。書き込みが永続化されるのは
+// 一番外側のトランザクションがコミットしたときです。
+//
+// ReadPage や WritePage などの操作は外側のトランザクションに対して行われます。
+// 入れ子の中で取得したロックは、Rollback しても外側のトランザクションが終わるまで保持されます。
+type Nested struct {
+	*Tx
+	undo     map[int64][]byte // 開始してから初めて書き込んだページの、開始時点の内容（nil なら書き込んでいなかった）
+	truncate bool             // 開始時点の TruncateOnCommit
+	done     bool
+}
+
+// Begin はトランザクションの中に入れ子のトランザクションを開始します。
+func (tx *Tx) Begin() (*Nested, error) {
+	if tx.done {
+		return nil, ErrTxDone
+	}
+	n := &Nested{Tx: tx, undo: make(map[int64][]byte), truncate: tx.truncate}
+	tx.mu.Lock()
+	tx.savepoints = append(tx.savepoints, n)
+	tx.mu.Unlock()
+	return n, nil
+}
+
+// save は一番内側の入れ子のトランザクションに、書き込む前のページ pageID の内容を記録します。
+// WritePage はページを置き換えるだけで中身を書き換えないので、記録はスライスの参照で足ります。
+// tx.mu を書き込みでロックしてから呼び出します。
+func (tx *Tx) save(pageID int64) {
+	if len(tx.savepoints) == 0 {
+		return
+	}
+	n := tx.savepoints[len(tx.savepoints)-1]
+	if _, ok := n.undo[pageID]; !ok {
+		n.undo[pageID] = tx.pages[pageID]
+	}
+}
+
+// Commit は入れ子のトランザクションを終了し、書き込みを外側に引き継ぎます。
+// まだ終了していない内側の入れ子のトランザクションも一緒に終了します。
+func (n *Nested) Commit() error {
+	inner, err := n.pop()
+	if err != nil {
+		return err
+	}
+	// 外側の入れ子のトランザクションが取り消せるよう、開始時点の内容を引き継ぐ
+	tx := n.Tx
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+	if len(tx.savepoints) > 0 {
+		outer := tx.savepoints[len(tx.savepoints)-1]
+		for _, m := range inner {
+			for id, buf := range m.undo {
+				if _, ok := outer.undo[id]; !ok {
+					outer.undo[id] = buf
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// Rollback は入れ子のトランザクションを開始してからの書き込みを取り消します。
+// まだ終了していない内側の入れ子のトランザクションも一緒に取り消されます。
+func (n *Nested) Rollback() error {
+	inner, err := n.pop()
+	if err != nil {
+		return err
+	}
+	tx := n.Tx
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+	// 内側から順に戻し、最後に n の開始時点の内容にする
+	for i := len(inner) - 1; i >= 0; i-- {
+		for id, buf := range inner[i].undo {
+			if buf == nil {
+				delete(tx.pages, id)
+			} else {
+				tx.pages[id] = buf
+			}
+		}
+	}
+	tx.truncate = n.truncate
+	return nil
+}
+
+// Done は入れ子のトランザクションか外側のトランザクションが終了しているかを返します。
+func (n *Nested) Done() bool { return n.done || n.Tx.done }
+
+// pop は n とそれより内側の入れ子のトランザクションを終了し、外側から順に返します。
+func (n *Nested) pop() ([]*Nested, error) {
+	if n.done || n.Tx.done {
+		return nil, ErrTxDone
+	}
+	tx := n.Tx
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+	i := slices.Index(tx.savepoints, n)
+	inner := slices.Clone(tx.savepoints[i:])
+	for _, m := range inner {
+		m.done = true
+	}
+	tx.savepoints = tx.savepoints[:i]
+	return inner, nil
+}
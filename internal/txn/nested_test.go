@@ -0,0 +1,87 @@
+package txn
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/k-sml/go-rdbms/internal/vfs"
+)
+
+// TestNestedUndo は、入れ子のトランザクションの Rollback が開始時点のページに戻し、開始してから
+// 書き込んだページを取り除くことを確かめます。Commit した内側の入れ子の書き込みも、外側の入れ子の
+// Rollback で取り消されます。
+func TestNestedUndo(t *testing.T) {
+	m, p := openManager(t, vfs.NewMemFS())
+	defer p.Close()
+	tx, err := m.Begin(Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	write := func(id int64, c byte) {
+		t.Helper()
+		buf := make([]byte, tx.PageSize())
+		buf[0] = c
+		if err := tx.WritePage(id, buf); err != nil {
+			t.Fatal(err)
+		}
+	}
+	check := func(when string, want map[int64]byte) {
+		t.Helper()
+		if len(tx.pages) != len(want) {
+			t.Errorf("%s: %d pages written, want %d", when, len(tx.pages), len(want))
+		}
+		for id, c := range want {
+			if buf, ok := tx.pages[id]; !ok || buf[0] != c {
+				t.Errorf("%s: page %d = %q, want %q", when, id, buf[:min(len(buf), 1)], c)
+			}
+		}
+	}
+	begin := func() *Nested {
+		t.Helper()
+		n, err := tx.Begin()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+
+	write(1, 'a')
+	outer := begin()
+	write(1, 'b')
+	write(2, 'x')
+	inner := begin()
+	write(1, 'c')
+	write(3, 'y')
+	if err := inner.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	last := begin()
+	write(1, 'd')
+	if err := last.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+	check("after rolling back the last nested transaction", map[int64]byte{1: 'c', 2: 'x', 3: 'y'})
+
+	if err := outer.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+	check("after rolling back the outer nested transaction", map[int64]byte{1: 'a'})
+	for _, n := range []*Nested{outer, inner, last} {
+		if err := n.Rollback(); !errors.Is(err, ErrTxDone) {
+			t.Errorf("Rollback of a finished nested transaction: err = %v, want %v", err, ErrTxDone)
+		}
+	}
+
+	// 内側を残したまま外側を Commit すると、内側も終了する
+	outer = begin()
+	inner = begin()
+	write(2, 'z')
+	if err := outer.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if !inner.Done() {
+		t.Errorf("inner nested transaction is not done after committing the outer one")
+	}
+	check("after committing the outer nested transaction", map[int64]byte{1: 'a', 2: 'z'})
+}
@@ -0,0 +1,42 @@
+package txn
+
+// 楽観的並行性制御（Optimistic）
+//
+// 読み取りでは、読んだページとその時点でそのページを最後に書き換えたコミットの通し番号
+// （lastWrite）を記録する。コミット時には書き込み権と commitMu を取ったうえで、
+// 記録した番号が変わっていないかを検証する（後方検証）。書き込むだけで読んでいないページは、
+// 開始後に書き換えられていないかを調べる。検証に通れば、そのまま書き込みを反映する。
+
+// readOptimistic は最新のコミット済みページを読み、読んだ時点の版を記録します。
+func (m *Manager) readOptimistic(tx *Tx, pageID int64) ([]byte, error) {
+	m.commitMu.RLock()
+	defer m.commitMu.RUnlock()
+
+	buf, err := m.pager.ReadPage(pageID)
+	if err != nil {
+		return nil, err
+	}
+	tx.mu.Lock()
+	if _, ok := tx.seen[pageID]; !ok {
+		tx.seen[pageID] = m.lastWrite[pageID]
+	}
+	tx.mu.Unlock()
+	return buf, nil
+}
+
+// validate は Optimistic のトランザクション tx が読んだページと書き込むページ ids が、
+// 読んだ後（読んでいなければ開始後）に書き換えられていないかを検証します。
+// commitMu をロックした状態で呼び出します。
+func (m *Manager) validate(tx *Tx, ids []int64) error {
+	for id, v := range tx.seen {
+		if m.lastWrite[id] != v {
+			return ErrSerialization
+		}
+	}
+	for _, id := range ids {
+		if _, ok := tx.seen[id]; !ok && m.lastWrite[id] > tx.snapshot {
+			return ErrSerialization
+		}
+	}
+	return nil
+}
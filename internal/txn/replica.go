@@ -0,0 +1,43 @@
+package txn
+
+// レプリカへの適用
+//
+// ストリーミングレプリケーションのフォロワーでは、リーダーのコミットをトランザクションを通さずに
+// ページャーに書き込む。スナップショットで読んでいるトランザクションが途中のページを見たり、
+// 開始後のコミットを見たりしないように、適用はコミットと同じく commitMu を書き込みロックして行い、
+// 書き換える前のページを versions に残して、適用するたびにコミットの通し番号を進める。
+
+// Replay はトランザクションの外でページを書き換える fn を、コミットと同じように実行中の
+// トランザクションのスナップショットと排他して実行します。fn はページを書き換える前に、
+// 書き換えるページの ID を save に渡さなければなりません。fn が成功すると、1つのコミットとして
+// 通し番号を進めます。
+func (m *Manager) Replay(fn func(save func(ids ...int64) error) error) error {
+	m.commitMu.Lock()
+	defer m.commitMu.Unlock()
+
+	var written []int64
+	save := func(ids ...int64) error {
+		if err := m.saveVersions(ids); err != nil {
+			return err
+		}
+		written = append(written, ids...)
+		return nil
+	}
+	if err := fn(save); err != nil {
+		return err
+	}
+	m.seq++
+	for _, id := range written {
+		m.lastWrite[id] = m.seq
+	}
+	return nil
+}
+
+// ReloadIDs は次の Begin で、トランザクションIDの予約をファイルヘッダから読み直すようにします。
+// レプリカを昇格して書き込めるようにしたときに呼び出します（レプリカの予約はファイルに
+// 保存していないので、リーダーが使った ID を払い出さないように読み直す）。
+func (m *Manager) ReloadIDs() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.reserved = 0
+}
@@ -0,0 +1,201 @@
+package txn
+
+import (
+	"slices"
+
+	"github.com/k-sml/go-rdbms/metrics"
+)
+
+// スナップショット分離
+//
+// コミットには通し番号（seq）を振り、トランザクションは開始時点の seq をスナップショットとして
+// 覚える。スナップショットで読むトランザクションが実行中にページが書き換えられる場合、
+// コミット時に書き換え前のページを versions に残しておき、そのトランザクションには
+// 古い内容を返す。古いページは、それを必要とするトランザクションがなくなった時点で捨てる。
+//
+// 書き込みはページ単位で検査する。スナップショットより後に他のトランザクションが
+// 書き換えたページに書き込もうとすると、古い内容に基づく書き込みで相手の変更を
+// 上書きしてしまうため ErrSerialization で中断する（同じページの別の行でも競合になる）。
+//
+// SSI（Serializable）
+//
+// トランザクション T が読んだページを、T から見えない別のトランザクション U が
+// 書き換えた場合、T → U の rw 依存（T は U より前に実行されたことになる）がある。
+// Cahill らの方式に従い、rw 依存の入りと出の両方を持つトランザクション（pivot）が
+// できた時点で、直列化できない可能性があるとみなして中断する。
+// 依存はページ単位で追跡するため、実際には競合しない場合にも中断することがある。
+// 依存を追跡するのは Serializable のトランザクションどうしのみで、
+// 他の分離レベルのトランザクションが混ざる場合の直列化可能性は保証しない。
+
+// pageVersion はスナップショットのために残している古いページです。
+// until より前のスナップショットから見えます。
+type pageVersion struct {
+	until uint64
+	data  []byte
+}
+
+// readSnapshot はトランザクション tx のスナップショットから見えるページの内容を返します。
+func (m *Manager) readSnapshot(tx *Tx, pageID int64) ([]byte, error) {
+	m.commitMu.RLock()
+	defer m.commitMu.RUnlock()
+
+	if tx.iso == Serializable {
+		m.mu.Lock()
+		tx.reads[pageID] = true
+		if m.lastWrite[pageID] > tx.snapshot {
+			// スナップショットより新しい書き込みがある: tx → 書き込んだトランザクション
+			tx.outConf = true
+			for _, w := range m.tracked {
+				if w != tx && w.committed && w.commitSeq > tx.snapshot && slices.Contains(w.written, pageID) {
+					m.rwConflict(tx, w, tx)
+				}
+			}
+			m.checkPivot(tx, tx)
+		}
+		m.mu.Unlock()
+	}
+
+	for _, v := range m.versions[pageID] {
+		if v.until > tx.snapshot {
+			m.cachedReads.Add(1)
+			m.metrics.Add(metrics.PageCacheHits, 1)
+			return append([]byte(nil), v.data...), nil
+		}
+	}
+	return m.pager.ReadPage(pageID)
+}
+
+// checkConflicts はコミットしようとしている tx の書き込みが、並行するトランザクションと
+// 競合しないかを調べます。commitMu を書き込みロックした状態で呼び出します。
+func (m *Manager) checkConflicts(tx *Tx, ids []int64) error {
+	if tx.iso == Optimistic {
+		if err := m.validate(tx, ids); err != nil {
+			return err
+		}
+	}
+	if tx.snapshotReads() {
+		for _, id := range ids {
+			if m.lastWrite[id] > tx.snapshot {
+				return ErrSerialization
+			}
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	// tx が書き換えるページを読んだ並行トランザクション r には r → tx の依存ができる
+	for _, r := range m.tracked {
+		if r == tx || (r.committed && r.commitSeq < tx.snapshot) {
+			continue
+		}
+		if slices.ContainsFunc(ids, func(id int64) bool { return r.reads[id] }) {
+			m.rwConflict(r, tx, tx)
+		}
+	}
+	if tx.doomed {
+		return ErrSerialization
+	}
+	return nil
+}
+
+// rwConflict は reader → writer の rw 依存を記録し、pivot ができていれば中断する
+// トランザクションを決めます。acting は依存を見つけた（読み取り中またはコミット中の）
+// トランザクションです。m.mu を保持して呼び出します。
+func (m *Manager) rwConflict(reader, writer, acting *Tx) {
+	reader.outConf = true
+	if writer.iso == Serializable {
+		writer.inConf = true
+	}
+	m.checkPivot(reader, acting)
+	m.checkPivot(writer, acting)
+}
+
+// checkPivot は t が rw 依存の入りと出の両方を持っていれば、t が実行中なら t 自身を、
+// コミット済みなら代わりに acting を中断させます。中断は次のコミットで ErrSerialization になります。
+// acting が Serializable でなければ中断させません（直列化可能性を保証するのは Serializable の
+// トランザクションどうしだけです）。
+func (m *Manager) checkPivot(t, acting *Tx) {
+	if t.iso != Serializable || !t.inConf || !t.outConf {
+		return
+	}
+	switch {
+	case !t.committed:
+		t.doomed = true
+	case acting.iso == Serializable:
+		acting.doomed = true
+	}
+}
+
+// saveVersions はページ ids を書き換える前に、スナップショットで読んでいる実行中の
+// トランザクションのために現在の内容を残します。commitMu を書き込みロックした状態で呼び出します。
+func (m *Manager) saveVersions(ids []int64) error {
+	m.mu.Lock()
+	need := false
+	for _, a := range m.active {
+		if a.snapshotReads() {
+			need = true
+			break
+		}
+	}
+	m.mu.Unlock()
+	if !need {
+		return nil
+	}
+	for _, id := range ids {
+		buf, err := m.pager.ReadPage(id)
+		if err != nil {
+			return err
+		}
+		m.versions[id] = append(m.versions[id], pageVersion{until: m.seq + 1, data: buf})
+	}
+	return nil
+}
+
+// commitReadOnly は書き込みのないトランザクションのコミットを記録します。
+// 読み取りの依存は、コミット後も並行するトランザクションがある間は追跡を続けます。
+func (m *Manager) commitReadOnly(tx *Tx) error {
+	m.commitMu.RLock()
+	defer m.commitMu.RUnlock()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if tx.doomed {
+		return ErrSerialization
+	}
+	if tx.iso == Optimistic {
+		if err := m.validate(tx, nil); err != nil {
+			return err
+		}
+	}
+	tx.committed, tx.commitSeq = true, m.seq
+	return nil
+}
+
+// gc は実行中のどのトランザクションにも必要なくなった古いページと依存情報を捨てます。
+func (m *Manager) gc() {
+	m.commitMu.Lock()
+	defer m.commitMu.Unlock()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	oldest, snap := m.seq, m.seq // 実行中のトランザクションの最古のスナップショット
+	for _, a := range m.active {
+		oldest = min(oldest, a.snapshot)
+		if a.snapshotReads() {
+			snap = min(snap, a.snapshot)
+		}
+	}
+	for id, vs := range m.versions {
+		vs = slices.DeleteFunc(vs, func(v pageVersion) bool { return v.until <= snap })
+		if len(vs) == 0 {
+			delete(m.versions, id)
+		} else {
+			m.versions[id] = vs
+		}
+	}
+	m.tracked = slices.DeleteFunc(m.tracked, func(t *Tx) bool {
+		if _, running := m.active[t.id]; running {
+			return false
+		}
+		return !t.committed || len(m.active) == 0 || t.commitSeq < oldest
+	})
+}
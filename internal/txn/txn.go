@@ -0,0 +1,482 @@
+// Package txn はトランザクションを管理します。
+// トランザクションが書き込んだページはコミットまで自分だけが見える領域に保持し、
+// コミット時にまとめてページャーに渡して1つのコミットとして永続化します。
+// 行やテーブルへのロックは二相ロック（2PL）に従い、コミットかロールバックまで保持します。
+package txn
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/k-sml/go-rdbms/internal/dberr"
+	"github.com/k-sml/go-rdbms/internal/lock"
+	"github.com/k-sml/go-rdbms/internal/pager"
+	"github.com/k-sml/go-rdbms/internal/storage"
+	"github.com/k-sml/go-rdbms/metrics"
+)
+
+// ErrTxDone はコミットまたはロールバック済みのトランザクションを使おうとした場合に返されます。
+var ErrTxDone = errors.New("transaction has already been committed or rolled back")
+
+// ErrReadOnlyTx は読み取り専用のトランザクションで書き込もうとした場合に返されます。
+// errors.Is は pager.ErrReadOnly にも一致します。
+var ErrReadOnlyTx = dberr.Mark(errors.New("cannot write in a read-only transaction"), pager.ErrReadOnly)
+
+// ErrSerialization は並行するトランザクションとの競合により、直列化可能な順序で
+// 実行できなくなった場合に返されます。トランザクションはロールバックされるので、
+// アプリケーションは最初からやり直す必要があります。
+var ErrSerialization = errors.New("could not serialize access due to concurrent update")
+
+// Isolation はトランザクションの分離レベルです。
+type Isolation int
+
+const (
+	// Locking は既定の分離レベルです。読み取りは最新のコミット済みページを見て、
+	// 行やテーブルのロック（2PL）で他のトランザクションとの干渉を防ぎます。
+	Locking Isolation = iota
+	// Snapshot はスナップショット分離です。読み取りは開始時点のコミット済みの状態を見るため
+	// ロックを必要としません。開始後に他のトランザクションが書き換えたページに書き込むと、
+	// コミット時に ErrSerialization になります（先にコミットした方が勝つ）。
+	Snapshot
+	// Serializable は直列化可能なスナップショット分離（SSI）です。スナップショット分離に加えて
+	// 読み書きの依存関係（rw-antidependency）を追跡し、直列化できなくなる危険な構造が
+	// できた場合は ErrSerialization で中断します。
+	Serializable
+	// Optimistic は楽観的並行性制御（OCC）です。ロックを取らずに最新のコミット済みページを読み、
+	// 書き込みはコミットまで手元に溜めます。コミット時に、読んだページと書き込むページが
+	// その後に他のトランザクションに書き換えられていないかを検証し、書き換えられていれば
+	// ErrSerialization で中断します。競合の少ない組み込み用途向けです。
+	Optimistic
+)
+
+// snapshotReads はトランザクションが開始時点のスナップショットを読むかを返します。
+func (tx *Tx) snapshotReads() bool {
+	return tx.iso == Snapshot || tx.iso == Serializable
+}
+
+// BusyHandler はロックを取れなかったときに、もう一度要求するかを決める関数です。n は同じ要求について
+// 何回目の呼び出しか（1 から）です。要求し直す前に待つのは BusyHandler の役目で、ctx が取り消されたら
+// 待つのをやめて false を返してください。
+type BusyHandler func(ctx context.Context, n int) bool
+
+// Options はトランザクションを開始する際の設定です。
+type Options struct {
+	Isolation Isolation
+	// LockTimeout はロックを待つ時間の上限です。0 なら無期限に待ちます。
+	LockTimeout time.Duration
+	// NoWait を true にすると、ロックをすぐに取得できない場合に待たずに
+	// lock.ErrLockNotAvailable を返します。LockTimeout より優先されます。
+	NoWait bool
+	// ReadOnly を true にすると読み取り専用のトランザクションになります。開始時点の
+	// スナップショットを読むだけで、ロックを取らずWALにも何も書きません。
+	// Isolation が Locking または Optimistic の場合は Snapshot として扱います。
+	ReadOnly bool
+	// Busy は NoWait や LockTimeout でロックをあきらめたときに、もう一度要求するかを決める関数です。
+	// nil なら要求し直さずに lock.ErrLockNotAvailable を返します。
+	Busy BusyHandler
+}
+
+// Manager はトランザクションの開始と終了を管理します。
+type Manager struct {
+	pager *pager.Pager
+	locks *lock.Manager
+
+	mu       sync.Mutex
+	nextID   uint64         // 次に割り当てるトランザクションID
+	reserved uint64         // ファイルヘッダに保存済みの予約の上限（xid.go）
+	active   map[uint64]*Tx // 実行中のトランザクション
+
+	// スナップショット読み取りのための状態（snapshot.go）
+	commitMu  sync.RWMutex            // コミットの反映とスナップショット読み取りを排他する
+	seq       uint64                  // 最後のコミットの通し番号
+	lastWrite map[int64]uint64        // ページごとの最後に書き換えたコミットの通し番号
+	versions  map[int64][]pageVersion // スナップショットのために残している古いページ
+	tracked   []*Tx                   // SSI で依存関係を追跡しているトランザクション
+
+	hookMu     sync.Mutex // フックの登録（hooks.go）
+	onCommit   []Hook
+	onRollback []Hook
+
+	// 累計（monitor.go の Stats）
+	commits     atomic.Uint64
+	rollbacks   atomic.Uint64
+	pageReads   atomic.Uint64
+	cachedReads atomic.Uint64
+
+	metrics metrics.Sink // 累計とコミットにかかった時間を送る先（SetMetrics）
+}
+
+// NewManager はページャー p とロックマネージャ locks を使うトランザクションマネージャを作成します。
+func NewManager(p *pager.Pager, locks *lock.Manager) *Manager {
+	return &Manager{
+		pager:     p,
+		locks:     locks,
+		active:    make(map[uint64]*Tx),
+		lastWrite: make(map[int64]uint64),
+		versions:  make(map[int64][]pageVersion),
+		metrics:   metrics.Discard,
+	}
+}
+
+// SetMetrics はトランザクションの計測値を送る先を s にします。トランザクションを開始する前に
+// 呼び出してください。
+func (m *Manager) SetMetrics(s metrics.Sink) { m.metrics = s }
+
+// Locks はトランザクションが使うロックマネージャを返します。
+func (m *Manager) Locks() *lock.Manager { return m.locks }
+
+// Begin は新しいトランザクションを開始します。
+func (m *Manager) Begin(opts Options) (*Tx, error) { return m.begin(opts, nil) }
+
+// BeginWith は Begin と同じですが、トランザクションがスナップショットを取得するのと同時に fn を
+// 呼び出します。fn の実行中はコミットを反映しないので、fn から見たページャーの状態と
+// スナップショットは同じです。fn がエラーを返すと、トランザクションを開始せずにそのエラーを返します。
+func (m *Manager) BeginWith(opts Options, fn func() error) (*Tx, error) { return m.begin(opts, fn) }
+
+func (m *Manager) begin(opts Options, fn func() error) (*Tx, error) {
+	for {
+		if err := m.reserveIDs(); err != nil {
+			return nil, err
+		}
+		m.commitMu.RLock() // スナップショットの取得をコミットの反映と排他する
+		m.mu.Lock()
+		if m.nextID < m.reserved {
+			break
+		}
+		// 予約してから他の Begin が使い切った
+		m.mu.Unlock()
+		m.commitMu.RUnlock()
+	}
+	defer m.commitMu.RUnlock()
+	defer m.mu.Unlock()
+
+	if fn != nil {
+		if err := fn(); err != nil {
+			return nil, err
+		}
+	}
+	tx := &Tx{
+		m:        m,
+		id:       m.nextID,
+		iso:      opts.Isolation,
+		readOnly: opts.ReadOnly,
+		start:    time.Now(),
+		wait:     -1,
+		busy:     opts.Busy,
+		snapshot: m.seq,
+		pages:    make(map[int64][]byte),
+	}
+	if tx.readOnly && !tx.snapshotReads() {
+		tx.iso = Snapshot
+	}
+	switch {
+	case opts.NoWait:
+		tx.wait = 0
+	case opts.LockTimeout > 0:
+		tx.wait = opts.LockTimeout
+	}
+	switch tx.iso {
+	case Serializable:
+		tx.reads = make(map[int64]bool)
+		m.tracked = append(m.tracked, tx)
+	case Optimistic:
+		tx.seen = make(map[int64]uint64)
+	}
+	m.nextID++
+	m.active[tx.id] = tx
+	m.metrics.Set(metrics.ActiveTxns, float64(len(m.active)))
+	return tx, nil
+}
+
+// finish はトランザクションを実行中の一覧から外し、保持しているロックをすべて解放します。
+func (m *Manager) finish(tx *Tx) {
+	m.locks.ReleaseAll(tx.id)
+	m.mu.Lock()
+	delete(m.active, tx.id)
+	m.metrics.Set(metrics.ActiveTxns, float64(len(m.active)))
+	m.mu.Unlock()
+	m.gc()
+}
+
+// Tx は1つのトランザクションです。1つのゴルーチンから使うことを想定しています。
+type Tx struct {
+	m        *Manager
+	id       uint64
+	iso      Isolation
+	readOnly bool
+	start    time.Time
+	wait     time.Duration    // ロックを待つ時間の上限（負なら無期限）
+	busy     BusyHandler      // ロックをあきらめたときに要求し直すか（nil なら要求し直さない）
+	snapshot uint64           // 開始時点で最後だったコミットの通し番号
+	pages    map[int64][]byte // このトランザクションが書き込んだページ
+	writer   bool             // 書き込み権を取得済みか
+	done     bool
+
+	// コンテキスト（context.go）。call は並列に読む問い合わせのゴルーチンも読む
+	base context.Context                 // トランザクション全体のコンテキスト（nil なら取り消されない）
+	call atomic.Pointer[context.Context] // 呼び出しのコンテキスト（nil なら取り消されない）
+
+	// SSI の依存関係（Serializable のみ）
+	reads     map[int64]bool // 読み取ったページ（SIREAD ロックに相当）
+	inConf    bool           // 他のトランザクションから rw 依存を受けている
+	outConf   bool           // 他のトランザクションへの rw 依存を持っている
+	doomed    bool           // 危険な構造に関わったため、コミットできない
+	committed bool           // コミット済みか
+	commitSeq uint64         // コミットの通し番号（読み取りのみの場合はその時点の最新）
+	written   []int64        // コミットで書き換えたページ
+
+	tables     []string  // 書き込みのためにロックしたテーブル（hooks.go）
+	truncate   bool      // コミットした後にファイルを切り詰める（TruncateOnCommit）
+	savepoints []*Nested // 終了していない入れ子のトランザクション（nested.go）
+
+	seen map[int64]uint64 // OCC で読んだページと、その時点の最後の書き換え（Optimistic のみ）
+
+	// mu は pages、seen と savepoints を守ります。並列に読む問い合わせでは、複数のゴルーチンが同時に
+	// ReadPage を呼び出します。
+	mu sync.RWMutex
+}
+
+// ID はトランザクションIDを返します。
+func (tx *Tx) ID() uint64 { return tx.id }
+
+// Lock は r を mode でロックします。ロックはコミットかロールバックまで保持されます。
+// 待つとデッドロックになる場合、トランザクションはロールバックされ lock.ErrDeadlock を返します。
+// NoWait や LockTimeout で取得をあきらめた場合は lock.ErrLockNotAvailable を返しますが、
+// トランザクションは続けられます。待っている間にコンテキストが取り消された場合も同じで、
+// ctx.Err() を返します。Optimistic と読み取り専用のトランザクションはロックを取らないため
+// 何もしません。
+func (tx *Tx) Lock(r lock.Resource, mode lock.Mode) error {
+	if tx.done {
+		return ErrTxDone
+	}
+	if r.Kind != lock.KindWriter && mode != lock.Shared && mode != lock.IntentShared {
+		tx.touch(r.Table)
+	}
+	if tx.iso == Optimistic || tx.readOnly {
+		return nil
+	}
+	ctx, stop := tx.lockContext()
+	err := tx.lock(ctx, r, mode)
+	stop()
+	if errors.Is(err, lock.ErrDeadlock) {
+		tx.Rollback()
+	}
+	return err
+}
+
+// lock は r を mode でロックします。あきらめた場合は、BusyHandler が false を返すまで要求し直します。
+func (tx *Tx) lock(ctx context.Context, r lock.Resource, mode lock.Mode) error {
+	for n := 1; ; n++ {
+		err := tx.m.locks.LockContext(ctx, tx.id, r, mode, tx.wait)
+		if tx.busy == nil || err != lock.ErrLockNotAvailable || !tx.busy(ctx, n) {
+			return err
+		}
+	}
+}
+
+// LockTable はテーブル全体をロックします。
+func (tx *Tx) LockTable(table string, mode lock.Mode) error {
+	return tx.Lock(lock.Table(table), mode)
+}
+
+// LockRow はテーブルの1行をロックします。行をロックする前に、テーブルに意図ロック
+// （共有なら IS、排他なら IX）をかけます。
+func (tx *Tx) LockRow(table string, rid storage.RID, mode lock.Mode) error {
+	intent := lock.IntentShared
+	if mode == lock.Exclusive {
+		intent = lock.IntentExclusive
+	}
+	if err := tx.Lock(lock.Table(table), intent); err != nil {
+		return err
+	}
+	return tx.Lock(lock.Row(table, rid), mode)
+}
+
+// PageSize はページサイズを返します。
+func (tx *Tx) PageSize() int { return tx.m.pager.PageSize() }
+
+// ReadPage はページを読みます。このトランザクションが書き込んだページはその内容を返し、
+// それ以外はコミット済みの内容を返します。
+func (tx *Tx) ReadPage(pageID int64) ([]byte, error) {
+	if tx.done {
+		return nil, ErrTxDone
+	}
+	if err := tx.ctxErr(); err != nil {
+		return nil, err
+	}
+	tx.m.pageReads.Add(1)
+	tx.m.metrics.Add(metrics.PageReads, 1)
+	tx.mu.RLock()
+	buf, ok := tx.pages[pageID]
+	tx.mu.RUnlock()
+	if ok {
+		tx.m.cachedReads.Add(1)
+		tx.m.metrics.Add(metrics.PageCacheHits, 1)
+		return append([]byte(nil), buf...), nil
+	}
+	switch {
+	case tx.snapshotReads():
+		return tx.m.readSnapshot(tx, pageID)
+	case tx.iso == Optimistic:
+		return tx.m.readOptimistic(tx, pageID)
+	}
+	return tx.m.pager.ReadPage(pageID)
+}
+
+// WritePage はページを書き込みます。内容はコミットまで他のトランザクションからは見えません。
+// 最初の書き込みで書き込み権を取得するため、他の書き込み中のトランザクションが
+// 終わるまでブロックすることがあります。
+func (tx *Tx) WritePage(pageID int64, buf []byte) error {
+	if _, err := tx.BeginWrite(); err != nil {
+		return err
+	}
+	tx.mu.Lock()
+	tx.save(pageID)
+	tx.pages[pageID] = append([]byte(nil), buf...)
+	tx.mu.Unlock()
+	return nil
+}
+
+// BeginWrite は書き込み権を取得します。新しく取得したら true を返し、取得済みか、Optimistic で
+// 書き込み権をコミットまで取らない場合は false を返します。
+//
+// Locking の読み取りは最新のコミット済みページを見るので、書き込み権を待っている間に
+// ほかのトランザクションがコミットすると、それより前に読んだページは古くなります。読んだ内容を
+// もとにページを書き換えるなら、読む前に BeginWrite を呼び出さなければなりません。
+func (tx *Tx) BeginWrite() (bool, error) {
+	if tx.done {
+		return false, ErrTxDone
+	}
+	if tx.readOnly {
+		return false, ErrReadOnlyTx
+	}
+	if tx.m.pager.ReadOnly() {
+		return false, pager.ErrReadOnly
+	}
+	if tx.writer || tx.iso == Optimistic {
+		return false, nil
+	}
+	if err := tx.Lock(lock.Writer(), lock.Exclusive); err != nil {
+		return false, err
+	}
+	tx.writer = true
+	return true, nil
+}
+
+// Commit はトランザクションが書き込んだページを永続化し、ロックを解放します。
+// 永続化に失敗した場合、書き込みは破棄されロックは解放されます。
+// 最後に OnCommit（失敗した場合は OnRollback）で登録したフックを呼び出します。
+func (tx *Tx) Commit() error {
+	if tx.done {
+		return ErrTxDone
+	}
+	if tx.base != nil && tx.base.Err() != nil {
+		// トランザクション全体のコンテキストが取り消されたので、コミットせずに終える
+		tx.Rollback()
+		return context.Cause(tx.base)
+	}
+	tx.done = true
+	start := time.Now()
+	err := tx.commit()
+	if err == nil {
+		tx.m.commits.Add(1)
+		tx.m.metrics.Add(metrics.Commits, 1)
+		tx.m.metrics.Observe(metrics.CommitSeconds, time.Since(start).Seconds())
+	} else {
+		tx.m.rollbacks.Add(1)
+		tx.m.metrics.Add(metrics.Rollbacks, 1)
+	}
+	tx.m.finish(tx)
+	tx.m.runHooks(tx, err == nil)
+	return err
+}
+
+func (tx *Tx) commit() error {
+	if len(tx.pages) == 0 {
+		return tx.m.commitReadOnly(tx)
+	}
+	if tx.iso == Optimistic {
+		// 検証と書き込みの間に他の書き込みが入らないよう、ここで書き込み権を取る
+		ctx, stop := tx.lockContext()
+		err := tx.lock(ctx, lock.Writer(), lock.Exclusive)
+		stop()
+		if err != nil {
+			return err
+		}
+	}
+	return tx.m.commit(tx)
+}
+
+// commit はトランザクションの書き込みをページャーに渡して永続化します。
+// スナップショット分離の競合検査と、古いページの保存もここで行います。
+func (m *Manager) commit(tx *Tx) error {
+	m.commitMu.Lock()
+	defer m.commitMu.Unlock()
+
+	ids := make([]int64, 0, len(tx.pages))
+	for id := range tx.pages {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+
+	if err := m.checkConflicts(tx, ids); err != nil {
+		return err
+	}
+	if err := m.saveVersions(ids); err != nil {
+		return err
+	}
+	if buf, ok := tx.pages[0]; ok {
+		if err := m.mergeHeader(buf); err != nil {
+			return err
+		}
+	}
+
+	p := m.pager
+	for _, id := range ids {
+		if err := p.WritePage(id, tx.pages[id]); err != nil {
+			p.Discard()
+			return err
+		}
+	}
+	if err := p.Commit(tx.id); err != nil {
+		p.Discard()
+		return err
+	}
+	m.seq++
+	for _, id := range ids {
+		m.lastWrite[id] = m.seq
+	}
+	m.mu.Lock()
+	tx.committed, tx.commitSeq, tx.written = true, m.seq, ids
+	m.mu.Unlock()
+	if tx.truncate {
+		// コミットは永続化済みなので、切り詰めに失敗してもファイルが長いままになるだけ
+		if h, ok, err := storage.ReadFileHeader(tx.pages[0]); err == nil && ok {
+			p.Truncate(h.NumPages)
+		}
+	}
+	return nil
+}
+
+// TruncateOnCommit は、コミットしたときにファイルヘッダのページ数より後ろのページを
+// データベースファイルから切り詰めるようにします。切り詰めは書き込み権を持ったまま行うので、
+// ほかのトランザクションが末尾に追加したページを切り詰めることはありません。
+func (tx *Tx) TruncateOnCommit() { tx.truncate = true }
+
+// Rollback は書き込みを破棄し、ロックを解放します。
+func (tx *Tx) Rollback() error {
+	if tx.done {
+		return ErrTxDone
+	}
+	tx.done = true
+	tx.pages = nil
+	tx.m.rollbacks.Add(1)
+	tx.m.metrics.Add(metrics.Rollbacks, 1)
+	tx.m.finish(tx)
+	tx.m.runHooks(tx, false)
+	return nil
+}
@@ -0,0 +1,105 @@
+package txn
+
+import (
+	"errors"
+	"math"
+
+	"github.com/k-sml/go-rdbms/internal/storage"
+)
+
+// トランザクションIDの割り当て
+//
+// 再起動後も以前と同じIDを使わないように、割り当て済みの上限をファイルヘッダ（ページ0）の
+// NextXID に保存する。Begin のたびにヘッダを書くのは重いため、xidBatch 個ずつまとめて
+// 予約してから書き込み、予約した範囲はメモリ上で払い出す。クラッシュすると予約の残りは
+// 使われずに捨てられるが、IDが重複することはない。
+//
+// IDは64ビットなので、毎秒100万件のトランザクションでも使い切るまで50万年以上かかる。
+// 32ビットのIDのような周回（wraparound）は起きないため、古い行のIDを凍結（freeze）する
+// 処理は必要ない。万一使い切った場合は ErrXIDExhausted を返す。
+
+// xidBatch は一度に予約するトランザクションIDの数です。
+const xidBatch = 1024
+
+// ErrXIDExhausted はトランザクションIDを使い切った場合に返されます。
+var ErrXIDExhausted = errors.New("transaction ids exhausted")
+
+// reserveIDs は払い出せるトランザクションIDがなくなっていれば、次の範囲を予約して
+// ファイルヘッダに保存します。
+func (m *Manager) reserveIDs() error {
+	m.mu.Lock()
+	enough := m.nextID < m.reserved
+	m.mu.Unlock()
+	if enough {
+		return nil
+	}
+
+	// ヘッダの書き込みを他のトランザクションのコミットと混ぜないようにする
+	m.commitMu.Lock()
+	defer m.commitMu.Unlock()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.nextID < m.reserved {
+		return nil
+	}
+
+	buf, err := m.pager.ReadPage(0)
+	if err != nil {
+		return err
+	}
+	h, ok, err := storage.ReadFileHeader(buf)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		h = storage.FileHeader{PageSize: uint32(m.pager.PageSize())}
+	}
+	h.Version = storage.FileHeaderVersion
+	if m.reserved == 0 { // 開いてから（またはレプリカを昇格してから）最初の予約
+		m.nextID = max(h.NextXID, m.nextID, 1)
+	}
+	if m.nextID > math.MaxUint64-xidBatch {
+		return ErrXIDExhausted
+	}
+	if m.pager.ReadOnly() {
+		// 書き込まないトランザクションしかないので、IDを保存する必要はない
+		m.reserved = m.nextID + xidBatch
+		return nil
+	}
+	h.NextXID = m.nextID + xidBatch
+	h.Encode(buf)
+	if err := m.pager.WritePage(0, buf); err != nil {
+		m.pager.Discard()
+		return err
+	}
+	if err := m.pager.Commit(0); err != nil {
+		m.pager.Discard()
+		return err
+	}
+	m.reserved = h.NextXID
+	return nil
+}
+
+// mergeHeader はトランザクションが書き換えたページ0（ファイルヘッダ）に、
+// トランザクションの外で予約したトランザクションIDの上限を引き継ぎます。
+// ページの割り当てなどでヘッダを書き換えたトランザクションが、開始前のヘッダで
+// 予約を巻き戻さないようにするためです。commitMu をロックした状態で呼び出します。
+func (m *Manager) mergeHeader(buf []byte) error {
+	cur, err := m.pager.ReadPage(0)
+	if err != nil {
+		return err
+	}
+	ch, ok, err := storage.ReadFileHeader(cur)
+	if err != nil || !ok {
+		return err
+	}
+	h, ok, err := storage.ReadFileHeader(buf)
+	if err != nil || !ok {
+		return err
+	}
+	if ch.NextXID > h.NextXID {
+		h.NextXID = ch.NextXID
+		h.Encode(buf)
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package txn
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/k-sml/go-rdbms/internal/lock"
+	"github.com/k-sml/go-rdbms/internal/pager"
+	"github.com/k-sml/go-rdbms/internal/storage"
+	"github.com/k-sml/go-rdbms/internal/vfs"
+)
+
+// openManager は fsys の test.db を開き、トランザクションマネージャを作ります。
+func openManager(t *testing.T, fsys vfs.FS) (*Manager, *pager.Pager) {
+	t.Helper()
+	p, err := pager.OpenWithOptions("test.db", pager.Options{PageSize: 512, Journal: pager.JournalWAL, FS: fsys})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewManager(p, lock.NewManager()), p
+}
+
+// begin はトランザクションを開始してコミットし、そのIDを返します。
+func begin(t *testing.T, m *Manager) uint64 {
+	t.Helper()
+	tx, err := m.Begin(Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	return tx.ID()
+}
+
+// TestXIDAbove32Bits は、2^32 をまたいで払い出したトランザクションIDが増え続け、再び開いた後も
+// それより大きいIDから払い出すことを確かめます。32ビットに切り詰めると周回して小さくなる値です。
+func TestXIDAbove32Bits(t *testing.T) {
+	const start = 1<<32 - 3
+	fsys := vfs.NewMemFS()
+	m, p := openManager(t, fsys)
+	h, err := storage.ReadHeader(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.NextXID = start
+	if err := storage.WriteHeader(p, h); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Commit(0); err != nil {
+		t.Fatal(err)
+	}
+
+	var ids []uint64
+	for range 6 {
+		ids = append(ids, begin(t, m))
+	}
+	if ids[0] != start {
+		t.Fatalf("first id = %d, want %d", ids[0], start)
+	}
+	for i := 1; i < len(ids); i++ {
+		if ids[i] <= ids[i-1] {
+			t.Errorf("id %d = %d, not greater than the previous id %d", i, ids[i], ids[i-1])
+		}
+	}
+	if last := ids[len(ids)-1]; last < 1<<32 || uint32(last) >= uint32(ids[0]) {
+		t.Fatalf("last id = %d, want one that wraps around in 32 bits", last)
+	}
+
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+	m, p = openManager(t, fsys)
+	defer p.Close()
+	if id := begin(t, m); id <= ids[len(ids)-1] {
+		t.Errorf("id after reopening = %d, want more than %d", id, ids[len(ids)-1])
+	}
+}
+
+// TestLockOwnersAbove32Bits は、下位32ビットだけが同じ2つのトランザクションIDを、ロックマネージャが
+// 別のトランザクションとして扱うことを確かめます。
+func TestLockOwnersAbove32Bits(t *testing.T) {
+	locks := lock.NewManager()
+	r := lock.Table("t")
+	if err := locks.Lock(1<<32+1, r, lock.Exclusive); err != nil {
+		t.Fatal(err)
+	}
+	if err := locks.LockWait(1, r, lock.Exclusive, 0); !errors.Is(err, lock.ErrLockNotAvailable) {
+		t.Fatalf("locking a table held by tx 2^32+1 as tx 1: err = %v, want ErrLockNotAvailable", err)
+	}
+	locks.ReleaseAll(1<<32 + 1)
+	if err := locks.LockWait(1, r, lock.Exclusive, 0); err != nil {
+		t.Fatalf("locking the released table: %v", err)
+	}
+}
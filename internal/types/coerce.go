@@ -0,0 +1,168 @@
+package types
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 型の変換の規則
+//
+// Cast は CAST(x AS t) のような明示的な変換で、文字列と数値・日時の相互変換や、
+// 実数から整数への切り捨てを含むほとんどの組み合わせを許す。
+//
+// Coerce は列への代入（INSERT や UPDATE）のような暗黙の変換で、値を失わない変換だけを許す。
+// 整数は範囲に収まれば INT/BIGINT/REAL に、整数値の REAL は整数型に、TEXT は日時として
+// 解釈できれば TIMESTAMP に変換する。NULL はどの型にも代入できる（NOT NULL の検査は別に行う）。
+//
+// Common は二項演算の両辺をそろえる型を決める。INT と BIGINT は BIGINT に、
+// 整数と REAL は REAL にそろえる。
+
+// Coerce は v を型 t の列に代入できる値に暗黙に変換します。
+func Coerce(v Value, t Type) (Value, error) {
+	if v.IsNull() || v.typ == t {
+		return v, nil
+	}
+	switch {
+	case t.IsInteger() && v.typ.IsInteger():
+		return intValue(v.i, t)
+	case t.IsInteger() && v.typ == Real:
+		if v.f != math.Trunc(v.f) || math.IsInf(v.f, 0) || math.IsNaN(v.f) {
+			return Value{}, fmt.Errorf("cannot store non-integral value %s in %s column", v, t)
+		}
+		if v.f < -(1<<63) || v.f >= 1<<63 {
+			return Value{}, fmt.Errorf("value %s out of range for %s", v, t)
+		}
+		return intValue(int64(v.f), t)
+	case t == Real && v.typ.IsInteger():
+		return NewReal(float64(v.i)), nil
+	case t == Timestamp && v.typ == Text:
+		return parseTimestamp(v.s)
+	}
+	return Value{}, fmt.Errorf("cannot store %s value in %s column", v.typ, t)
+}
+
+// Cast は v を型 t に明示的に変換します。
+func Cast(v Value, t Type) (Value, error) {
+	if v.IsNull() || v.typ == t {
+		return v, nil
+	}
+	if c, err := Coerce(v, t); err == nil {
+		return c, nil
+	}
+	switch t {
+	case Int, BigInt:
+		switch v.typ {
+		case Real:
+			if math.IsNaN(v.f) || v.f < -(1<<63) || v.f >= 1<<63 {
+				return Value{}, fmt.Errorf("value %s out of range for %s", v, t)
+			}
+			return intValue(int64(v.f), t)
+		case Text:
+			s := strings.TrimSpace(v.s)
+			if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+				return intValue(i, t)
+			}
+			f, err := strconv.ParseFloat(s, 64)
+			if err != nil {
+				return Value{}, fmt.Errorf("invalid %s value: %q", t, v.s)
+			}
+			return Cast(NewReal(f), t)
+		case Boolean:
+			return intValue(v.i, t)
+		case Timestamp:
+			return intValue(v.Time().Unix(), t)
+		}
+	case Real:
+		switch v.typ {
+		case Text:
+			f, err := strconv.ParseFloat(strings.TrimSpace(v.s), 64)
+			if err != nil {
+				return Value{}, fmt.Errorf("invalid REAL value: %q", v.s)
+			}
+			return NewReal(f), nil
+		case Boolean:
+			return NewReal(float64(v.i)), nil
+		}
+	case Text:
+		if v.typ == Blob {
+			return NewText(string(v.b)), nil
+		}
+		return NewText(v.String()), nil
+	case Blob:
+		if v.typ == Text {
+			return NewBlob([]byte(v.s)), nil
+		}
+	case Boolean:
+		switch v.typ {
+		case Int, BigInt:
+			return NewBool(v.i != 0), nil
+		case Real:
+			return NewBool(v.f != 0), nil
+		case Text:
+			switch strings.ToLower(strings.TrimSpace(v.s)) {
+			case "true", "t", "yes", "1":
+				return NewBool(true), nil
+			case "false", "f", "no", "0":
+				return NewBool(false), nil
+			}
+			return Value{}, fmt.Errorf("invalid BOOLEAN value: %q", v.s)
+		}
+	case Timestamp:
+		if v.typ.IsInteger() {
+			return NewTimestamp(time.Unix(v.i, 0)), nil
+		}
+	}
+	return Value{}, fmt.Errorf("cannot cast %s to %s", v.typ, t)
+}
+
+// Common は二項演算で a と b の型をそろえる型を返します。そろえられない場合は false を返します。
+func Common(a, b Type) (Type, bool) {
+	switch {
+	case a == b:
+		return a, true
+	case a == Null:
+		return b, true
+	case b == Null:
+		return a, true
+	case a.IsInteger() && b.IsInteger():
+		return BigInt, true
+	case a.IsNumeric() && b.IsNumeric():
+		return Real, true
+	}
+	return Null, false
+}
+
+// intValue は i を整数型 t の値にします。INT の範囲を超える場合はエラーを返します。
+func intValue(i int64, t Type) (Value, error) {
+	if t == Int {
+		if i < math.MinInt32 || i > math.MaxInt32 {
+			return Value{}, fmt.Errorf("value %d out of range for INT", i)
+		}
+		return NewInt(int32(i)), nil
+	}
+	return NewBigInt(i), nil
+}
+
+// timestampLayouts は TEXT から TIMESTAMP へ変換するときに受け付ける形式です。
+var timestampLayouts = []string{
+	time.RFC3339Nano,
+	TimestampLayout,
+	"2006-01-02T15:04:05.999999",
+	"2006-01-02 15:04:05.999999Z07:00", // PostgreSQL の timestamptz
+	"2006-01-02 15:04:05.999999Z07",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+func parseTimestamp(s string) (Value, error) {
+	s = strings.TrimSpace(s)
+	for _, layout := range timestampLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return NewTimestamp(t), nil
+		}
+	}
+	return Value{}, fmt.Errorf("invalid TIMESTAMP value: %q", s)
+}
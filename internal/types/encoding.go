@@ -0,0 +1,123 @@
+package types
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+
+	"github.com/k-sml/go-rdbms/internal/dberr"
+)
+
+// 保存形式
+//
+// 値は [u8:型][データ] で表す。データは型ごとに次の通り（整数はリトルエンディアン）。
+//
+//	NULL     : なし
+//	INT      : i32
+//	BIGINT   : i64
+//	REAL     : f64 のビット列
+//	TEXT     : [u32:長さ][UTF-8]
+//	BLOB     : [u32:長さ][バイト列]
+//	BOOLEAN  : u8（0 または 1）
+//	TIMESTAMP: i64（Unix マイクロ秒）
+
+// ErrCorrupt はバイト列が正しい値の保存形式でない場合に返されます。
+var ErrCorrupt = dberr.Mark(errors.New("corrupt value encoding"), dberr.ErrCorrupt)
+
+// AppendValue は v の保存形式を buf に追加します。
+func AppendValue(buf []byte, v Value) []byte {
+	buf = append(buf, byte(v.typ))
+	switch v.typ {
+	case Int:
+		buf = binary.LittleEndian.AppendUint32(buf, uint32(int32(v.i)))
+	case BigInt, Timestamp:
+		buf = binary.LittleEndian.AppendUint64(buf, uint64(v.i))
+	case Real:
+		buf = binary.LittleEndian.AppendUint64(buf, math.Float64bits(v.f))
+	case Text:
+		buf = binary.LittleEndian.AppendUint32(buf, uint32(len(v.s)))
+		buf = append(buf, v.s...)
+	case Blob:
+		buf = binary.LittleEndian.AppendUint32(buf, uint32(len(v.b)))
+		buf = append(buf, v.b...)
+	case Boolean:
+		buf = append(buf, byte(v.i))
+	}
+	return buf
+}
+
+// DecodeValue は b の先頭の値を読み取り、値と読んだバイト数を返します。
+func DecodeValue(b []byte) (Value, int, error) {
+	if len(b) < 1 {
+		return Value{}, 0, ErrCorrupt
+	}
+	t := Type(b[0])
+	d := b[1:]
+	switch t {
+	case Null:
+		return Value{}, 1, nil
+	case Int:
+		if len(d) < 4 {
+			return Value{}, 0, ErrCorrupt
+		}
+		return NewInt(int32(binary.LittleEndian.Uint32(d))), 5, nil
+	case BigInt, Timestamp:
+		if len(d) < 8 {
+			return Value{}, 0, ErrCorrupt
+		}
+		return Value{typ: t, i: int64(binary.LittleEndian.Uint64(d))}, 9, nil
+	case Real:
+		if len(d) < 8 {
+			return Value{}, 0, ErrCorrupt
+		}
+		return NewReal(math.Float64frombits(binary.LittleEndian.Uint64(d))), 9, nil
+	case Text, Blob:
+		if len(d) < 4 {
+			return Value{}, 0, ErrCorrupt
+		}
+		n := int(binary.LittleEndian.Uint32(d))
+		if len(d) < 4+n {
+			return Value{}, 0, ErrCorrupt
+		}
+		if t == Text {
+			return NewText(string(d[4 : 4+n])), 5 + n, nil
+		}
+		return NewBlob(d[4 : 4+n]), 5 + n, nil
+	case Boolean:
+		if len(d) < 1 {
+			return Value{}, 0, ErrCorrupt
+		}
+		return NewBool(d[0] != 0), 2, nil
+	default:
+		return Value{}, 0, ErrCorrupt
+	}
+}
+
+// SkipValue は b の先頭の値を読み飛ばし、その値のバイト数を返します。値は作りません。
+func SkipValue(b []byte) (int, error) {
+	if len(b) < 1 {
+		return 0, ErrCorrupt
+	}
+	var n int
+	switch Type(b[0]) {
+	case Null:
+		return 1, nil
+	case Int:
+		n = 5
+	case BigInt, Timestamp, Real:
+		n = 9
+	case Boolean:
+		n = 2
+	case Text, Blob:
+		if len(b) < 5 {
+			return 0, ErrCorrupt
+		}
+		n = 5 + int(binary.LittleEndian.Uint32(b[1:]))
+	default:
+		return 0, ErrCorrupt
+	}
+	if len(b) < n {
+		return 0, ErrCorrupt
+	}
+	return n, nil
+}
@@ -0,0 +1,110 @@
+package types
+
+import (
+	"encoding/binary"
+	"math"
+)
+
+// キーの形式
+//
+// インデックスのキーは、バイト列として比較した順序が Compare の順序と一致するように
+// エンコードする。値は [u8:0（NULL）または 1][データ] で表し、データは型ごとに次の通り
+// （整数はビッグエンディアン）。複数の値をつなげても順序が保たれる。
+//
+//	INT, BIGINT, TIMESTAMP: 符号ビットを反転した i64
+//	REAL                  : 正の数は符号ビットを、負の数はすべてのビットを反転した f64 のビット列
+//	TEXT, BLOB            : 0x00 を 0x00 0xFF に置き換え、末尾に 0x00 0x00 を付けたもの
+//	BOOLEAN               : u8（0 または 1）
+//
+// 数値型の異なる値は同じ順序にならないので、キーにする値は列の型に変換しておく。
+
+// AppendKey は v のキーの形式を buf に追加します。
+func AppendKey(buf []byte, v Value) []byte {
+	if v.IsNull() {
+		return append(buf, 0)
+	}
+	buf = append(buf, 1)
+	switch v.typ {
+	case Int, BigInt, Timestamp:
+		return binary.BigEndian.AppendUint64(buf, uint64(v.i)^(1<<63))
+	case Real:
+		bits := math.Float64bits(v.f)
+		if v.f == 0 {
+			bits = 0 // -0 と +0 を同じキーにする
+		}
+		if bits&(1<<63) != 0 {
+			bits = ^bits
+		} else {
+			bits |= 1 << 63
+		}
+		return binary.BigEndian.AppendUint64(buf, bits)
+	case Text:
+		return appendKeyBytes(buf, []byte(v.s))
+	case Blob:
+		return appendKeyBytes(buf, v.b)
+	default: // Boolean
+		return append(buf, byte(v.i))
+	}
+}
+
+func appendKeyBytes(buf, b []byte) []byte {
+	for _, c := range b {
+		buf = append(buf, c)
+		if c == 0 {
+			buf = append(buf, 0xFF)
+		}
+	}
+	return append(buf, 0, 0)
+}
+
+// DecodeKey は AppendKey で作ったキー b の先頭の型 typ の値を読み取り、値と読んだバイト数を返します。
+func DecodeKey(b []byte, typ Type) (Value, int, error) {
+	if len(b) < 1 || b[0] > 1 {
+		return Value{}, 0, ErrCorrupt
+	}
+	if b[0] == 0 {
+		return Value{}, 1, nil
+	}
+	d := b[1:]
+	switch typ {
+	case Int, BigInt, Timestamp, Real:
+		if len(d) < 8 {
+			return Value{}, 0, ErrCorrupt
+		}
+		bits := binary.BigEndian.Uint64(d)
+		if typ != Real {
+			return Value{typ: typ, i: int64(bits ^ (1 << 63))}, 9, nil
+		}
+		if bits&(1<<63) != 0 {
+			bits &^= 1 << 63
+		} else {
+			bits = ^bits
+		}
+		return Value{typ: Real, f: math.Float64frombits(bits)}, 9, nil
+	case Text, Blob:
+		var s []byte
+		for i := 0; i+1 < len(d); i++ {
+			switch {
+			case d[i] != 0:
+				s = append(s, d[i])
+			case d[i+1] == 0xFF:
+				s = append(s, 0)
+				i++
+			case d[i+1] == 0:
+				if typ == Text {
+					return Value{typ: Text, s: string(s)}, i + 3, nil
+				}
+				return Value{typ: Blob, b: s}, i + 3, nil
+			default:
+				return Value{}, 0, ErrCorrupt
+			}
+		}
+		return Value{}, 0, ErrCorrupt
+	case Boolean:
+		if len(d) < 1 {
+			return Value{}, 0, ErrCorrupt
+		}
+		return Value{typ: Boolean, i: int64(d[0])}, 2, nil
+	}
+	return Value{}, 0, ErrCorrupt
+}
@@ -0,0 +1,78 @@
+// Package types は列の型と値を定義します。
+// 値の保存形式（エンコード）、比較の規則、型の変換（コアーション）の規則をここにまとめ、
+// タプルのエンコード、インデックス、式の評価で同じ規則を使います。
+package types
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Type は列や値の型です。
+type Type uint8
+
+const (
+	Null      Type = iota // NULL（値の型としてのみ使う）
+	Int                   // 32ビット整数
+	BigInt                // 64ビット整数
+	Real                  // 64ビット浮動小数点数
+	Text                  // UTF-8 文字列
+	Blob                  // バイト列
+	Boolean               // 真偽値
+	Timestamp             // 日時（UTC、マイクロ秒精度）
+)
+
+// String は型名を返します。
+func (t Type) String() string {
+	switch t {
+	case Null:
+		return "NULL"
+	case Int:
+		return "INT"
+	case BigInt:
+		return "BIGINT"
+	case Real:
+		return "REAL"
+	case Text:
+		return "TEXT"
+	case Blob:
+		return "BLOB"
+	case Boolean:
+		return "BOOLEAN"
+	case Timestamp:
+		return "TIMESTAMP"
+	default:
+		return fmt.Sprintf("Type(%d)", int(t))
+	}
+}
+
+// IsNumeric は t が数値型かを返します。
+func (t Type) IsNumeric() bool { return t == Int || t == BigInt || t == Real }
+
+// IsInteger は t が整数型かを返します。
+func (t Type) IsInteger() bool { return t == Int || t == BigInt }
+
+// aliases は型名とその別名です。
+var aliases = map[string]Type{
+	"INT": Int, "INTEGER": Int, "INT4": Int, "SMALLINT": Int,
+	"BIGINT": BigInt, "INT8": BigInt,
+	"REAL": Real, "FLOAT": Real, "DOUBLE": Real, "DOUBLE PRECISION": Real, "NUMERIC": Real, "DECIMAL": Real,
+	"TEXT": Text, "VARCHAR": Text, "CHAR": Text, "STRING": Text, "CLOB": Text,
+	"BLOB": Blob, "BYTEA": Blob, "BINARY": Blob, "VARBINARY": Blob,
+	"BOOLEAN": Boolean, "BOOL": Boolean,
+	"TIMESTAMP": Timestamp, "DATETIME": Timestamp,
+}
+
+// Parse は型名（大文字と小文字は区別しない）を型にします。
+// VARCHAR(255) のような長さの指定は無視します。
+func Parse(name string) (Type, error) {
+	n := strings.ToUpper(strings.TrimSpace(name))
+	if i := strings.IndexByte(n, '('); i >= 0 {
+		n = strings.TrimSpace(n[:i])
+	}
+	n = strings.Join(strings.Fields(n), " ")
+	if t, ok := aliases[n]; ok {
+		return t, nil
+	}
+	return Null, fmt.Errorf("unknown type: %s", name)
+}
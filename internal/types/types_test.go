@@ -0,0 +1,251 @@
+package types
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/k-sml/go-rdbms/internal/dberr"
+)
+
+// same は a と b が同じ型の同じ値かを返します。
+func same(a, b Value) bool {
+	return a.Type() == b.Type() && a.String() == b.String()
+}
+
+// TestParse は型名と別名、長さの指定を読むことを確かめます。
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name string
+		want Type // Null ならエラーになる
+	}{
+		{"int", Int},
+		{"INTEGER", Int},
+		{"bigint", BigInt},
+		{"double  precision", Real},
+		{"VARCHAR(255)", Text},
+		{" text ", Text},
+		{"bytea", Blob},
+		{"bool", Boolean},
+		{"DATETIME", Timestamp},
+		{"money", Null},
+		{"", Null},
+	}
+	for _, tt := range tests {
+		got, err := Parse(tt.name)
+		if tt.want == Null {
+			if err == nil {
+				t.Errorf("Parse(%q) = %v, want an error", tt.name, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("Parse(%q) = %v, %v, want %v", tt.name, got, err, tt.want)
+		}
+	}
+}
+
+// TestCompare は、数値型どうしを数として比べ、NULL を最も小さい値とし、型の合わない値の比較を
+// エラーにすることを確かめます。
+func TestCompare(t *testing.T) {
+	ts := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		a, b Value
+		want int
+		ok   bool
+	}{
+		{NewInt(1), NewBigInt(2), -1, true},
+		{NewBigInt(3), NewReal(2.5), 1, true},
+		{NewReal(2), NewInt(2), 0, true},
+		{NewReal(math.NaN()), NewReal(math.Inf(1)), 1, true},
+		{NullValue(), NewInt(math.MinInt32), -1, true},
+		{NullValue(), NullValue(), 0, true},
+		{NewText("a"), NewText("b"), -1, true},
+		{NewBlob([]byte{1}), NewBlob([]byte{0, 2}), 1, true},
+		{NewBool(false), NewBool(true), -1, true},
+		{NewTimestamp(ts), NewTimestamp(ts.Add(-time.Microsecond)), 1, true},
+		{NewText("1"), NewInt(1), 0, false},
+		{NewBool(true), NewInt(1), 0, false},
+	}
+	for _, tt := range tests {
+		got, err := Compare(tt.a, tt.b)
+		switch {
+		case !tt.ok && err == nil:
+			t.Errorf("Compare(%v, %v) = %d, want an error", tt.a, tt.b, got)
+		case tt.ok && (err != nil || got != tt.want):
+			t.Errorf("Compare(%v, %v) = %d, %v, want %d", tt.a, tt.b, got, err, tt.want)
+		}
+	}
+	if !Equal(NullValue(), NullValue()) || Equal(NewText("1"), NewInt(1)) {
+		t.Error("Equal does not treat NULLs as equal or compares TEXT with INT")
+	}
+}
+
+// TestConvert は、Coerce が値を失わない変換だけを許し、Cast がそれより多くの変換を許すことを確かめます。
+func TestConvert(t *testing.T) {
+	tests := []struct {
+		v      Value
+		t      Type
+		coerce string // 結果の値（String の形）。空ならエラーになる
+		cast   string
+	}{
+		{NullValue(), Int, "NULL", "NULL"},
+		{NewBigInt(7), Int, "7", "7"},
+		{NewBigInt(1 << 40), Int, "", ""},
+		{NewInt(7), Real, "7", "7"},
+		{NewReal(3), BigInt, "3", "3"},
+		{NewReal(3.7), BigInt, "", "3"},
+		{NewReal(math.Inf(1)), BigInt, "", ""},
+		{NewText(" 42 "), Int, "", "42"},
+		{NewText("4.5"), BigInt, "", "4"},
+		{NewText("x"), BigInt, "", ""},
+		{NewText("2.5"), Real, "", "2.5"},
+		{NewInt(42), Text, "", "42"},
+		{NewBlob([]byte("ab")), Text, "", "ab"},
+		{NewText("ab"), Blob, "", "x'6162'"},
+		{NewText("yes"), Boolean, "", "TRUE"},
+		{NewReal(0), Boolean, "", "FALSE"},
+		{NewText("maybe"), Boolean, "", ""},
+		{NewText("2024-01-02 03:04:05.5"), Timestamp, "2024-01-02 03:04:05.5", "2024-01-02 03:04:05.5"},
+		{NewText("2024-01-02T03:04:05+09:00"), Timestamp, "2024-01-01 18:04:05", "2024-01-01 18:04:05"},
+		{NewText("2024-01-02 03:04:05+09"), Timestamp, "2024-01-01 18:04:05", "2024-01-01 18:04:05"},
+		{NewText("2024-01-02"), Timestamp, "2024-01-02 00:00:00", "2024-01-02 00:00:00"},
+		{NewBigInt(86400), Timestamp, "", "1970-01-02 00:00:00"},
+		{NewTimestamp(time.Unix(60, 0)), BigInt, "", "60"},
+		{NewBool(true), Timestamp, "", ""},
+	}
+	for _, tt := range tests {
+		for _, c := range []struct {
+			name string
+			fn   func(Value, Type) (Value, error)
+			want string
+		}{{"Coerce", Coerce, tt.coerce}, {"Cast", Cast, tt.cast}} {
+			got, err := c.fn(tt.v, tt.t)
+			switch {
+			case c.want == "" && err == nil:
+				t.Errorf("%s(%v, %v) = %v, want an error", c.name, tt.v, tt.t, got)
+			case c.want != "" && err != nil:
+				t.Errorf("%s(%v, %v): %v", c.name, tt.v, tt.t, err)
+			case c.want != "" && (got.String() != c.want || !got.IsNull() && got.Type() != tt.t):
+				t.Errorf("%s(%v, %v) = %v (%v), want %s", c.name, tt.v, tt.t, got, got.Type(), c.want)
+			}
+		}
+	}
+
+	common := []struct {
+		a, b, want Type
+		ok         bool
+	}{
+		{Int, Int, Int, true},
+		{Int, BigInt, BigInt, true},
+		{BigInt, Real, Real, true},
+		{Null, Text, Text, true},
+		{Text, Int, Null, false},
+	}
+	for _, tt := range common {
+		if got, ok := Common(tt.a, tt.b); got != tt.want || ok != tt.ok {
+			t.Errorf("Common(%v, %v) = %v, %v, want %v, %v", tt.a, tt.b, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+// values は型ごとに小さい順に並べた値です。
+var values = [][]Value{
+	{NullValue(), NewInt(math.MinInt32), NewInt(-1), NewInt(0), NewInt(1), NewInt(math.MaxInt32)},
+	{NullValue(), NewBigInt(math.MinInt64), NewBigInt(-1), NewBigInt(0), NewBigInt(1 << 40), NewBigInt(math.MaxInt64)},
+	{NullValue(), NewReal(math.Inf(-1)), NewReal(-1.5), NewReal(-1e-300), NewReal(0), NewReal(1e-300), NewReal(2), NewReal(math.Inf(1))},
+	{NullValue(), NewText(""), NewText("\x00"), NewText("\x00\x00"), NewText("\x00a"), NewText("a"), NewText("a\x00"), NewText("ab"), NewText("é")},
+	{NullValue(), NewBlob(nil), NewBlob([]byte{0}), NewBlob([]byte{0, 0xFF}), NewBlob([]byte{1})},
+	{NullValue(), NewBool(false), NewBool(true)},
+	{NullValue(), NewTimestamp(time.Date(1900, 1, 1, 0, 0, 0, 0, time.UTC)), NewTimestamp(time.Unix(0, 0)), NewTimestamp(time.Date(2024, 2, 29, 12, 0, 0, 1000, time.UTC))},
+}
+
+// TestEncoding は値の保存形式を読み戻せることと、途中で切れたバイト列を ErrCorrupt にすることを確かめます。
+func TestEncoding(t *testing.T) {
+	var buf []byte
+	var all []Value
+	for _, vs := range values {
+		for _, v := range vs {
+			buf = AppendValue(buf, v)
+			all = append(all, v)
+		}
+	}
+	b := buf
+	for _, want := range all {
+		got, n, err := DecodeValue(b)
+		if err != nil {
+			t.Fatalf("DecodeValue(%v): %v", want, err)
+		}
+		if !same(got, want) {
+			t.Errorf("DecodeValue = %v (%v), want %v (%v)", got, got.Type(), want, want.Type())
+		}
+		if m, err := SkipValue(b); err != nil || m != n {
+			t.Errorf("SkipValue(%v) = %d, %v, want %d", want, m, err, n)
+		}
+		b = b[n:]
+	}
+	if len(b) != 0 {
+		t.Errorf("%d bytes left after decoding", len(b))
+	}
+
+	for _, v := range []Value{NewInt(1), NewBigInt(1), NewReal(1), NewText("abc"), NewBlob([]byte{1}), NewBool(true), NewTimestamp(time.Unix(0, 0))} {
+		enc := AppendValue(nil, v)
+		for n := range len(enc) {
+			if _, _, err := DecodeValue(enc[:n]); !errors.Is(err, ErrCorrupt) || !errors.Is(err, dberr.ErrCorrupt) {
+				t.Errorf("DecodeValue of %d bytes of %v: err = %v, want ErrCorrupt", n, v, err)
+			}
+			if _, err := SkipValue(enc[:n]); !errors.Is(err, ErrCorrupt) {
+				t.Errorf("SkipValue of %d bytes of %v: err = %v, want ErrCorrupt", n, v, err)
+			}
+		}
+	}
+	if _, _, err := DecodeValue([]byte{0xEE}); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("DecodeValue of an unknown type: err = %v, want ErrCorrupt", err)
+	}
+}
+
+// TestKey は、キーのバイト列の順序が値の順序と一致し、つなげたキーを読み戻せることを確かめます。
+func TestKey(t *testing.T) {
+	for _, vs := range values {
+		typ := vs[1].Type()
+		for i := 1; i < len(vs); i++ {
+			a, b := AppendKey(nil, vs[i-1]), AppendKey(nil, vs[i])
+			if bytes.Compare(a, b) >= 0 {
+				t.Errorf("key of %v (%x) is not less than key of %v (%x)", vs[i-1], a, vs[i], b)
+			}
+			// 2 列のキーでも 1 列目の順序が保たれる
+			if bytes.Compare(AppendKey(a, NewText("z")), AppendKey(b, NewText(""))) >= 0 {
+				t.Errorf("two-column key starting with %v is not less than one starting with %v", vs[i-1], vs[i])
+			}
+		}
+
+		var key []byte
+		for _, v := range vs {
+			key = AppendKey(key, v)
+		}
+		for _, want := range vs {
+			got, n, err := DecodeKey(key, typ)
+			if err != nil {
+				t.Fatalf("DecodeKey(%v): %v", want, err)
+			}
+			if !same(got, want) {
+				t.Errorf("DecodeKey = %v (%v), want %v (%v)", got, got.Type(), want, want.Type())
+			}
+			key = key[n:]
+		}
+		if len(key) != 0 {
+			t.Errorf("%v: %d bytes left after decoding", typ, len(key))
+		}
+	}
+
+	if !bytes.Equal(AppendKey(nil, NewReal(0)), AppendKey(nil, NewReal(math.Copysign(0, -1)))) {
+		t.Error("0 and -0 have different keys")
+	}
+	for _, bad := range [][]byte{nil, {2}, {1, 'a', 0}, {1, 'a', 0, 1, 0}} {
+		if _, _, err := DecodeKey(bad, Text); !errors.Is(err, ErrCorrupt) {
+			t.Errorf("DecodeKey(%x): err = %v, want ErrCorrupt", bad, err)
+		}
+	}
+}
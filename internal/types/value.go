@@ -0,0 +1,224 @@
+package types
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+)
+
+// Value は型付きの値です。ゼロ値は NULL です。
+type Value struct {
+	typ Type
+	i   int64 // Int, BigInt, Boolean（0/1）, Timestamp（Unix マイクロ秒）
+	f   float64
+	s   string
+	b   []byte
+}
+
+// NullValue は NULL を返します。
+func NullValue() Value { return Value{} }
+
+// NewInt は INT の値を返します。
+func NewInt(v int32) Value { return Value{typ: Int, i: int64(v)} }
+
+// NewBigInt は BIGINT の値を返します。
+func NewBigInt(v int64) Value { return Value{typ: BigInt, i: v} }
+
+// NewReal は REAL の値を返します。
+func NewReal(v float64) Value { return Value{typ: Real, f: v} }
+
+// NewText は TEXT の値を返します。
+func NewText(v string) Value { return Value{typ: Text, s: v} }
+
+// NewBlob は BLOB の値を返します。
+func NewBlob(v []byte) Value { return Value{typ: Blob, b: append([]byte{}, v...)} }
+
+// NewBool は BOOLEAN の値を返します。
+func NewBool(v bool) Value {
+	if v {
+		return Value{typ: Boolean, i: 1}
+	}
+	return Value{typ: Boolean}
+}
+
+// NewTimestamp は TIMESTAMP の値を返します。精度はマイクロ秒に切り捨てます。
+func NewTimestamp(v time.Time) Value { return Value{typ: Timestamp, i: v.UnixMicro()} }
+
+// Type は値の型を返します。NULL の場合は Null です。
+func (v Value) Type() Type { return v.typ }
+
+// IsNull は値が NULL かを返します。
+func (v Value) IsNull() bool { return v.typ == Null }
+
+// Int は整数型の値を返します。
+func (v Value) Int() int64 { return v.i }
+
+// Real は REAL の値を返します。整数型の値は実数に変換して返します。
+func (v Value) Real() float64 {
+	if v.typ.IsInteger() {
+		return float64(v.i)
+	}
+	return v.f
+}
+
+// Text は TEXT の値を返します。
+func (v Value) Text() string { return v.s }
+
+// Blob は BLOB の値を返します。
+func (v Value) Blob() []byte { return v.b }
+
+// Bool は BOOLEAN の値を返します。
+func (v Value) Bool() bool { return v.i != 0 }
+
+// Time は TIMESTAMP の値を返します。
+func (v Value) Time() time.Time { return time.UnixMicro(v.i).UTC() }
+
+// TimestampLayout は TIMESTAMP の文字列表現です。
+const TimestampLayout = "2006-01-02 15:04:05.999999"
+
+// String は値を表示用の文字列にします。NULL は "NULL" になります。
+func (v Value) String() string {
+	switch v.typ {
+	case Null:
+		return "NULL"
+	case Int, BigInt:
+		return strconv.FormatInt(v.i, 10)
+	case Real:
+		return strconv.FormatFloat(v.f, 'g', -1, 64)
+	case Text:
+		return v.s
+	case Blob:
+		return fmt.Sprintf("x'%x'", v.b)
+	case Boolean:
+		if v.Bool() {
+			return "TRUE"
+		}
+		return "FALSE"
+	case Timestamp:
+		return v.Time().Format(TimestampLayout)
+	default:
+		return fmt.Sprintf("Value(%d)", int(v.typ))
+	}
+}
+
+// Go は値を Go の値（nil, int64, float64, string, []byte, bool, time.Time）にします。
+func (v Value) Go() any {
+	switch v.typ {
+	case Int, BigInt:
+		return v.i
+	case Real:
+		return v.f
+	case Text:
+		return v.s
+	case Blob:
+		return v.b
+	case Boolean:
+		return v.Bool()
+	case Timestamp:
+		return v.Time()
+	default:
+		return nil
+	}
+}
+
+// FromGo は Go の値から型付きの値を作ります。整数は BIGINT になります。
+func FromGo(x any) (Value, error) {
+	switch x := x.(type) {
+	case nil:
+		return NullValue(), nil
+	case Value:
+		return x, nil
+	case int:
+		return NewBigInt(int64(x)), nil
+	case int32:
+		return NewInt(x), nil
+	case int64:
+		return NewBigInt(x), nil
+	case float64:
+		return NewReal(x), nil
+	case float32:
+		return NewReal(float64(x)), nil
+	case string:
+		return NewText(x), nil
+	case []byte:
+		return NewBlob(x), nil
+	case bool:
+		return NewBool(x), nil
+	case time.Time:
+		return NewTimestamp(x), nil
+	default:
+		return Value{}, fmt.Errorf("unsupported value type %T", x)
+	}
+}
+
+// 比較の規則
+//
+// 数値型（INT, BIGINT, REAL）どうしは数値として比較する。それ以外は同じ型どうしでのみ比較でき、
+// TEXT と BLOB はバイト順、BOOLEAN は FALSE < TRUE、TIMESTAMP は時刻順になる。
+// 並べ替えやインデックスのために、NULL は他のどの値よりも小さいものとして扱う
+// （WHERE などでの NULL の扱いは式の評価側で行う）。
+
+// Compare は a と b を比較し、a < b なら負、a == b なら 0、a > b なら正を返します。
+// 比較できない型の組み合わせの場合はエラーを返します。
+func Compare(a, b Value) (int, error) {
+	switch {
+	case a.IsNull() && b.IsNull():
+		return 0, nil
+	case a.IsNull():
+		return -1, nil
+	case b.IsNull():
+		return 1, nil
+	}
+	if a.typ.IsNumeric() && b.typ.IsNumeric() {
+		if a.typ.IsInteger() && b.typ.IsInteger() {
+			return cmp(a.i, b.i), nil
+		}
+		x, y := a.Real(), b.Real()
+		switch {
+		case x < y:
+			return -1, nil
+		case x > y:
+			return 1, nil
+		case x == y:
+			return 0, nil
+		default: // NaN は他の値より大きい
+			return cmp(boolInt(math.IsNaN(x)), boolInt(math.IsNaN(y))), nil
+		}
+	}
+	if a.typ != b.typ {
+		return 0, fmt.Errorf("cannot compare %s with %s", a.typ, b.typ)
+	}
+	switch a.typ {
+	case Text:
+		return cmp(a.s, b.s), nil
+	case Blob:
+		return bytes.Compare(a.b, b.b), nil
+	default: // Boolean, Timestamp
+		return cmp(a.i, b.i), nil
+	}
+}
+
+// Equal は a と b が等しいかを返します（NULL どうしは等しいとみなします）。
+func Equal(a, b Value) bool {
+	c, err := Compare(a, b)
+	return err == nil && c == 0
+}
+
+func cmp[T int64 | string](a, b T) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func boolInt(b bool) int64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,218 @@
+package vfs
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"sync"
+)
+
+// ErrPowerLoss は電源断の模擬後にファイル操作を行った場合に返されます。
+var ErrPowerLoss = errors.New("simulated power loss")
+
+// MemFS はメモリ上のファイルシステムです。
+// ファイルごとに「最後の Sync 時点の内容」と「それ以降の書き込み」を分けて保持し、
+// Crash で電源断後にディスクに残りうる状態を作り出せます。
+type MemFS struct {
+	mu      sync.Mutex
+	files   map[string]*memData
+	budget  int64 // 電源断までに書き込めるバイト数（負なら無制限）
+	written int64 // これまでに書き込まれたバイト数
+	down    bool  // 電源断が発生したか
+}
+
+// memData は1ファイル分の内容です。
+type memData struct {
+	durable []byte  // 最後の Sync 時点の内容
+	cur     []byte  // キャッシュ上の現在の内容
+	ops     []memOp // 最後の Sync 以降の操作
+}
+
+// memOp は Sync されていない操作です。
+type memOp struct {
+	truncate bool
+	off      int64
+	data     []byte
+}
+
+// NewMemFS は空の MemFS を作成します。
+func NewMemFS() *MemFS {
+	return &MemFS{files: make(map[string]*memData), budget: -1}
+}
+
+// CutAfter は、これから n バイト書き込んだ時点で電源断が起きるように設定します。
+// 境界をまたぐ書き込みは途中までしか反映されず、以降の操作は ErrPowerLoss を返します。
+// n が負の場合は電源断を起こしません。
+func (fs *MemFS) CutAfter(n int64) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.budget = n
+	fs.written = 0
+}
+
+// Written は CutAfter 以降に書き込まれたバイト数を返します。
+func (fs *MemFS) Written() int64 {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	return fs.written
+}
+
+// Crash は電源断を模擬します。各ファイルの Sync されていない書き込みは
+// rng に従ってそれぞれ残るか失われ、残る書き込みも任意のバイト位置で途切れることがあります。
+// 切り詰めはメタデータとして順序通りに反映されます。
+// Crash 後は以前に開いたファイルは使えなくなり、ファイルを開き直せば
+// ディスクに残った内容を読めます。
+func (fs *MemFS) Crash(rng *rand.Rand) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	for name, d := range fs.files {
+		img := d.durable
+		for _, op := range d.ops {
+			if op.truncate {
+				img = resize(img, op.off)
+				continue
+			}
+			switch rng.Intn(3) {
+			case 0: // 書き込みは失われた
+			case 1: // 書き込みは完全に残った
+				img = writeAt(img, op.data, op.off)
+			default: // 書き込みは途中で途切れた
+				img = writeAt(img, op.data[:rng.Intn(len(op.data)+1)], op.off)
+			}
+		}
+		fs.files[name] = &memData{durable: img, cur: append([]byte(nil), img...)}
+	}
+	fs.budget, fs.written, fs.down = -1, 0, false
+}
+
+// OpenFile はファイルを開きます。os.O_CREATE と os.O_TRUNC に対応します。
+func (fs *MemFS) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if fs.down {
+		return nil, ErrPowerLoss
+	}
+	d, ok := fs.files[name]
+	if !ok {
+		if flag&os.O_CREATE == 0 {
+			return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+		}
+		d = &memData{}
+		fs.files[name] = d
+	}
+	f := &memFile{fs: fs, d: d, name: name, readOnly: flag&(os.O_WRONLY|os.O_RDWR) == 0}
+	if flag&os.O_TRUNC != 0 && !f.readOnly {
+		// fs.mu を持っているので Truncate は呼ばずにここで切り詰める
+		d.cur = resize(d.cur, 0)
+		d.ops = append(d.ops, memOp{truncate: true})
+	}
+	return f, nil
+}
+
+// memFile は MemFS 上の開いているファイルです。
+type memFile struct {
+	fs       *MemFS
+	d        *memData
+	name     string
+	readOnly bool
+}
+
+// live は Crash 前に開かれたファイルでないことを確認します。呼び出し側で fs.mu を保持します。
+func (f *memFile) live() error {
+	if f.fs.down || f.fs.files[f.name] != f.d {
+		return ErrPowerLoss
+	}
+	return nil
+}
+
+func (f *memFile) ReadAt(b []byte, off int64) (int, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	if err := f.live(); err != nil {
+		return 0, err
+	}
+	if off >= int64(len(f.d.cur)) {
+		return 0, io.EOF
+	}
+	n := copy(b, f.d.cur[off:])
+	if n < len(b) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (f *memFile) WriteAt(b []byte, off int64) (int, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	if err := f.live(); err != nil {
+		return 0, err
+	}
+	if f.readOnly {
+		return 0, fmt.Errorf("write %s: file is read-only", f.name)
+	}
+	data := b
+	if f.fs.budget >= 0 && f.fs.written+int64(len(b)) > f.fs.budget {
+		data = b[:f.fs.budget-f.fs.written] // 電源断の直前までしか書けない
+		f.fs.down = true
+	}
+	f.fs.written += int64(len(data))
+	f.d.cur = writeAt(f.d.cur, data, off)
+	f.d.ops = append(f.d.ops, memOp{off: off, data: append([]byte(nil), data...)})
+	if f.fs.down {
+		return len(data), ErrPowerLoss
+	}
+	return len(b), nil
+}
+
+func (f *memFile) Truncate(size int64) error {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	if err := f.live(); err != nil {
+		return err
+	}
+	f.d.cur = resize(f.d.cur, size)
+	f.d.ops = append(f.d.ops, memOp{truncate: true, off: size})
+	return nil
+}
+
+func (f *memFile) Sync() error {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	if err := f.live(); err != nil {
+		return err
+	}
+	f.d.durable = append([]byte(nil), f.d.cur...)
+	f.d.ops = nil
+	return nil
+}
+
+func (f *memFile) Size() (int64, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	if err := f.live(); err != nil {
+		return 0, err
+	}
+	return int64(len(f.d.cur)), nil
+}
+
+func (f *memFile) Close() error { return nil }
+
+// writeAt は img の off の位置に data を書き込んだ結果を返します。必要に応じてゼロで拡張します。
+func writeAt(img, data []byte, off int64) []byte {
+	if end := off + int64(len(data)); end > int64(len(img)) {
+		img = resize(img, end)
+	} else {
+		img = append([]byte(nil), img...)
+	}
+	copy(img[off:], data)
+	return img
+}
+
+// resize は img を size バイトに切り詰めるかゼロで拡張したコピーを返します。
+func resize(img []byte, size int64) []byte {
+	out := make([]byte, size)
+	copy(out, img)
+	return out
+}
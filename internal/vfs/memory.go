@@ -0,0 +1,110 @@
+package vfs
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// Memory はメモリ上のファイルシステムです。MemFS と違って電源断を模擬しないので、Sync は何もせず、
+// 書き込みの履歴も残しません。ファイルの内容はその場で書き換えるので、大きなファイルでも
+// 書き込みの費用は書き込んだバイト数だけです。:memory: のデータベースが使います。
+type Memory struct {
+	mu    sync.Mutex
+	files map[string]*memoryData
+}
+
+// memoryData は1ファイル分の内容です。
+type memoryData struct {
+	mu   sync.RWMutex
+	data []byte
+}
+
+// NewMemory は空の Memory を作成します。
+func NewMemory() *Memory {
+	return &Memory{files: make(map[string]*memoryData)}
+}
+
+// OpenFile はファイルを開きます。os.O_CREATE と os.O_TRUNC に対応します。
+func (fs *Memory) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	d, ok := fs.files[name]
+	if !ok {
+		if flag&os.O_CREATE == 0 {
+			return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+		}
+		d = &memoryData{}
+		fs.files[name] = d
+	}
+	f := &memoryFile{d: d, name: name, readOnly: flag&(os.O_WRONLY|os.O_RDWR) == 0}
+	if flag&os.O_TRUNC != 0 && !f.readOnly {
+		f.Truncate(0)
+	}
+	return f, nil
+}
+
+// memoryFile は Memory 上の開いているファイルです。
+type memoryFile struct {
+	d        *memoryData
+	name     string
+	readOnly bool
+}
+
+func (f *memoryFile) ReadAt(b []byte, off int64) (int, error) {
+	f.d.mu.RLock()
+	defer f.d.mu.RUnlock()
+	if off >= int64(len(f.d.data)) {
+		return 0, io.EOF
+	}
+	n := copy(b, f.d.data[off:])
+	if n < len(b) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (f *memoryFile) WriteAt(b []byte, off int64) (int, error) {
+	if f.readOnly {
+		return 0, fmt.Errorf("write %s: file is read-only", f.name)
+	}
+	f.d.mu.Lock()
+	defer f.d.mu.Unlock()
+	if end := off + int64(len(b)); end > int64(len(f.d.data)) {
+		f.d.grow(end)
+	}
+	return copy(f.d.data[off:], b), nil
+}
+
+func (f *memoryFile) Truncate(size int64) error {
+	f.d.mu.Lock()
+	defer f.d.mu.Unlock()
+	if size <= int64(len(f.d.data)) {
+		clear(f.d.data[size:]) // 後で伸ばしたときにゼロに見えるように
+		f.d.data = f.d.data[:size]
+		return nil
+	}
+	f.d.grow(size)
+	return nil
+}
+
+// grow は内容をゼロで size バイトに伸ばします。呼び出し側で mu を保持します。
+func (d *memoryData) grow(size int64) {
+	if size > int64(cap(d.data)) {
+		data := make([]byte, len(d.data), max(size, 2*int64(cap(d.data))))
+		copy(data, d.data)
+		d.data = data
+	}
+	d.data = d.data[:size]
+}
+
+func (f *memoryFile) Sync() error { return nil }
+
+func (f *memoryFile) Size() (int64, error) {
+	f.d.mu.RLock()
+	defer f.d.mu.RUnlock()
+	return int64(len(f.d.data)), nil
+}
+
+func (f *memoryFile) Close() error { return nil }
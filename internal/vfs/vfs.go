@@ -0,0 +1,51 @@
+// Package vfs はページャーやWALが使うファイル操作を抽象化します。
+// 通常は OS のファイルをそのまま使いますが、テストやクラッシュ試験では
+// 電源断を模擬できるメモリ上のファイルシステム（MemFS）に差し替えられます。
+// :memory: のデータベースは、電源断を模擬しない軽いメモリ上のファイルシステム（Memory）を使います。
+package vfs
+
+import (
+	"io"
+	"os"
+)
+
+// File はデータベースが必要とする最小限のファイル操作です。
+type File interface {
+	io.ReaderAt
+	io.WriterAt
+	Truncate(size int64) error
+	Sync() error
+	Size() (int64, error)
+	Close() error
+}
+
+// FS はファイルを開く手段を提供します。
+type FS interface {
+	// OpenFile は os.OpenFile と同じフラグでファイルを開きます。
+	// ファイルが存在しない場合は os.ErrNotExist を包んだエラーを返します。
+	OpenFile(name string, flag int, perm os.FileMode) (File, error)
+}
+
+// OS は実際のファイルシステムです。
+var OS FS = osFS{}
+
+type osFS struct{}
+
+func (osFS) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	f, err := os.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return osFile{f}, nil
+}
+
+// osFile は *os.File に Size を足したものです。
+type osFile struct{ *os.File }
+
+func (f osFile) Size() (int64, error) {
+	st, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	return st.Size(), nil
+}
@@ -0,0 +1,205 @@
+package vfs
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestFS は、どの FS のファイルも読み書き、切り詰め、大きさの取得を同じように行うことを確かめます。
+func TestFS(t *testing.T) {
+	tests := []struct {
+		name string
+		fs   func(t *testing.T) (FS, string)
+	}{
+		{"OS", func(t *testing.T) (FS, string) { return OS, filepath.Join(t.TempDir(), "f") }},
+		{"MemFS", func(t *testing.T) (FS, string) { return NewMemFS(), "f" }},
+		{"Memory", func(t *testing.T) (FS, string) { return NewMemory(), "f" }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fsys, name := tt.fs(t)
+			if _, err := fsys.OpenFile(name, os.O_RDWR, 0); !errors.Is(err, os.ErrNotExist) {
+				t.Fatalf("opening a missing file: err = %v, want os.ErrNotExist", err)
+			}
+			f, err := fsys.OpenFile(name, os.O_RDWR|os.O_CREATE, 0666)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			if _, err := f.WriteAt([]byte("world"), 6); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := f.WriteAt([]byte("hello"), 0); err != nil {
+				t.Fatal(err)
+			}
+			check(t, f, "hello\x00world")
+
+			// 読み終わる前にファイルの終わりに来たら io.EOF
+			buf := make([]byte, 8)
+			if n, err := f.ReadAt(buf, 6); n != 5 || err != io.EOF {
+				t.Errorf("ReadAt past the end = %d, %v, want 5, io.EOF", n, err)
+			}
+
+			// 切り詰めてから伸ばした部分はゼロになる
+			if err := f.Truncate(3); err != nil {
+				t.Fatal(err)
+			}
+			if err := f.Truncate(5); err != nil {
+				t.Fatal(err)
+			}
+			check(t, f, "hel\x00\x00")
+			if err := f.Sync(); err != nil {
+				t.Fatal(err)
+			}
+
+			r, err := fsys.OpenFile(name, os.O_RDONLY, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer r.Close()
+			check(t, r, "hel\x00\x00")
+			if _, err := r.WriteAt([]byte("x"), 0); err == nil {
+				t.Error("writing to a read-only file succeeded")
+			}
+
+			g, err := fsys.OpenFile(name, os.O_RDWR|os.O_TRUNC, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer g.Close()
+			check(t, f, "")
+		})
+	}
+}
+
+// check は f の内容が want であることを確かめます。
+func check(t *testing.T, f File, want string) {
+	t.Helper()
+	size, err := f.Size()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make([]byte, size)
+	if _, err := f.ReadAt(got, 0); err != nil && err != io.EOF {
+		t.Fatal(err)
+	}
+	if string(got) != want {
+		t.Errorf("file = %q, want %q", got, want)
+	}
+}
+
+// TestCrash は、Crash の後も Sync した内容は残り、Sync していない書き込みは失われるか、
+// 残るか、途中で途切れるかのどれかになることを確かめます。
+func TestCrash(t *testing.T) {
+	tests := []struct {
+		name string
+		// ops は Sync した "aaaaaaaa" のファイルを Sync せずに書き換えます。
+		ops func(f File)
+		// ok は Crash の後のファイルの内容としてありうるかを返します。
+		ok func(got []byte) bool
+	}{
+		{"synced only", func(f File) {}, func(got []byte) bool { return string(got) == "aaaaaaaa" }},
+		{"overwrite", func(f File) { f.WriteAt([]byte("bbbb"), 2) }, func(got []byte) bool {
+			// 書き込みの先頭から途切れた位置までが残る
+			for n := range 5 {
+				if string(got) == "aa"+"bbbb"[:n]+"aaaa"[n:]+"aa" {
+					return true
+				}
+			}
+			return false
+		}},
+		{"append", func(f File) { f.WriteAt([]byte("cccc"), 8) }, func(got []byte) bool {
+			return bytes.HasPrefix(got, []byte("aaaaaaaa")) && bytes.HasPrefix([]byte("cccc"), got[8:])
+		}},
+		{"truncate", func(f File) { f.Truncate(4) }, func(got []byte) bool { return string(got) == "aaaa" }},
+		{"truncate and write", func(f File) {
+			f.Truncate(0)
+			f.WriteAt([]byte("dd"), 0)
+		}, func(got []byte) bool { return bytes.HasPrefix([]byte("dd"), got) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for seed := range int64(20) {
+				fs := NewMemFS()
+				f, err := fs.OpenFile("f", os.O_RDWR|os.O_CREATE, 0666)
+				if err != nil {
+					t.Fatal(err)
+				}
+				f.WriteAt([]byte("aaaaaaaa"), 0)
+				if err := f.Sync(); err != nil {
+					t.Fatal(err)
+				}
+				tt.ops(f)
+				fs.Crash(rand.New(rand.NewSource(seed)))
+
+				if _, err := f.Size(); !errors.Is(err, ErrPowerLoss) {
+					t.Fatalf("using a file opened before the crash: err = %v, want ErrPowerLoss", err)
+				}
+				g, err := fs.OpenFile("f", os.O_RDWR, 0)
+				if err != nil {
+					t.Fatal(err)
+				}
+				size, _ := g.Size()
+				got := make([]byte, size)
+				g.ReadAt(got, 0)
+				if !tt.ok(got) {
+					t.Fatalf("seed %d: file = %q after the crash", seed, got)
+				}
+			}
+		})
+	}
+}
+
+// TestCutAfter は、CutAfter で決めたバイト数を書いたところで書き込みが途切れ、
+// それ以降の操作が ErrPowerLoss になることを確かめます。
+func TestCutAfter(t *testing.T) {
+	tests := []struct {
+		budget int64
+		want   string // 途切れた後に残っている内容（Sync していないので Crash 前の見え方）
+	}{
+		{-1, "0123456789"},
+		{0, ""},
+		{4, "0123"},
+		{10, "0123456789"},
+	}
+	for _, tt := range tests {
+		fs := NewMemFS()
+		f, err := fs.OpenFile("f", os.O_RDWR|os.O_CREATE, 0666)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fs.CutAfter(tt.budget)
+		n, err := f.WriteAt([]byte("0123456789"), 0)
+		if cut := tt.budget >= 0 && tt.budget < 10; cut != errors.Is(err, ErrPowerLoss) || n != len(tt.want) {
+			t.Errorf("CutAfter(%d): WriteAt = %d, %v", tt.budget, n, err)
+		}
+		if fs.Written() != int64(len(tt.want)) {
+			t.Errorf("CutAfter(%d): Written = %d, want %d", tt.budget, fs.Written(), len(tt.want))
+		}
+		if tt.budget < 0 || tt.budget >= 10 {
+			check(t, f, tt.want)
+			continue
+		}
+		if err := f.Sync(); !errors.Is(err, ErrPowerLoss) {
+			t.Errorf("CutAfter(%d): Sync after the cut = %v, want ErrPowerLoss", tt.budget, err)
+		}
+		if _, err := fs.OpenFile("g", os.O_RDWR|os.O_CREATE, 0666); !errors.Is(err, ErrPowerLoss) {
+			t.Errorf("CutAfter(%d): OpenFile after the cut = %v, want ErrPowerLoss", tt.budget, err)
+		}
+
+		// Crash の後は開き直して使える
+		fs.Crash(rand.New(rand.NewSource(1)))
+		g, err := fs.OpenFile("f", os.O_RDWR, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := g.WriteAt([]byte("x"), 0); err != nil {
+			t.Errorf("CutAfter(%d): WriteAt after the crash = %v", tt.budget, err)
+		}
+	}
+}
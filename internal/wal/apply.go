@@ -0,0 +1,132 @@
+package wal
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/k-sml/go-rdbms/internal/storage"
+)
+
+// 物理レコードと論理レコード
+//
+// 物理レコード（RecPageImage, RecPageDiff）はページのバイト列そのものを記録し、
+// 論理レコード（RecHeapInsert など）は「どのスロットに何を挿入したか」のような
+// 操作とタプルだけを記録する。大きなページの小さな更新では論理レコードの方が
+// ずっと小さく、後で論理レプリケーションにも使える。
+//
+// 差分や論理レコードは直前のページ状態に対して適用するため、チェックポイント後に
+// 各ページを初めて記録するときは必ず RecPageImage を使う（書き込み途中で
+// 壊れたページを丸ごと復元できるようにするため）。
+
+const (
+	// RecPageDiff は直前のページイメージからの差分を保持するレコードです。
+	// ペイロード: 繰り返し [u16:offset][u16:length][length バイト]
+	RecPageDiff RecordType = 3
+	// RecHeapInsert はヒープページへのレコード挿入です。
+	// ペイロード（論理レコード共通）: [u16:slot][タプル]
+	RecHeapInsert RecordType = 4
+	// RecHeapDelete はヒープページのレコード削除です（タプルは空）。
+	RecHeapDelete RecordType = 5
+	// RecHeapUpdate はヒープページのレコード更新です。
+	RecHeapUpdate RecordType = 6
+)
+
+// heapOps は論理レコードの種別とヒープページ操作の対応です。
+var heapOps = map[RecordType]storage.HeapOp{
+	RecHeapInsert: storage.HeapInsert,
+	RecHeapDelete: storage.HeapDelete,
+	RecHeapUpdate: storage.HeapUpdate,
+}
+
+// diffGap より短い変更のない区間は、レコードを分けずに差分に含める
+const diffGap = 8
+
+// IsLogical は t が論理レコードかどうかを返します。
+func IsLogical(t RecordType) bool {
+	return t == RecHeapInsert || t == RecHeapDelete || t == RecHeapUpdate
+}
+
+// HasPage は t がページへの変更を伴うレコードかどうかを返します。
+func HasPage(t RecordType) bool {
+	return t == RecPageImage || t == RecPageDiff || IsLogical(t)
+}
+
+// NewHeapRecord はヒープページに対する論理レコードを作成します。
+func NewHeapRecord(t RecordType, pageID int64, slot int, tuple []byte) *Record {
+	payload := make([]byte, 2+len(tuple))
+	binary.LittleEndian.PutUint16(payload[0:2], uint16(slot))
+	copy(payload[2:], tuple)
+	return &Record{Type: t, PageID: pageID, Payload: payload}
+}
+
+// HeapOp は論理レコードのスロットとタプルを取り出します。
+func HeapOp(rec *Record) (slot int, tuple []byte, err error) {
+	if !IsLogical(rec.Type) || len(rec.Payload) < 2 {
+		return 0, nil, fmt.Errorf("not a heap record: %s", rec.Type)
+	}
+	return int(binary.LittleEndian.Uint16(rec.Payload[0:2])), rec.Payload[2:], nil
+}
+
+// EncodeDiff は old から new への差分ペイロードを作成します。
+// 2つのページは同じ長さでなければなりません。
+func EncodeDiff(old, new []byte) []byte {
+	var out []byte
+	for i := 0; i < len(new); i++ {
+		if old[i] == new[i] {
+			continue
+		}
+		// 変更区間の終わりを探す（diffGap 未満の一致区間は区間に含める）
+		start, last := i, i
+		for j := i + 1; j < len(new) && j-last <= diffGap; j++ {
+			if old[j] != new[j] {
+				last = j
+			}
+		}
+		var h [4]byte
+		binary.LittleEndian.PutUint16(h[0:2], uint16(start))
+		binary.LittleEndian.PutUint16(h[2:4], uint16(last+1-start))
+		out = append(out, h[:]...)
+		out = append(out, new[start:last+1]...)
+		i = last
+	}
+	return out
+}
+
+// Apply はページを伴うレコードを page に適用します（page は書き換えられます）。
+func Apply(page []byte, rec *Record) error {
+	switch rec.Type {
+	case RecPageImage:
+		if len(rec.Payload) != len(page) {
+			return fmt.Errorf("page image size %d does not match %d", len(rec.Payload), len(page))
+		}
+		copy(page, rec.Payload)
+		return nil
+	case RecPageDiff:
+		for d := rec.Payload; len(d) > 0; {
+			if len(d) < 4 {
+				return errors.New("truncated page diff")
+			}
+			off := int(binary.LittleEndian.Uint16(d[0:2]))
+			n := int(binary.LittleEndian.Uint16(d[2:4]))
+			if len(d) < 4+n || off+n > len(page) {
+				return errors.New("page diff out of range")
+			}
+			copy(page[off:off+n], d[4:4+n])
+			d = d[4+n:]
+		}
+		return nil
+	case RecHeapInsert, RecHeapDelete, RecHeapUpdate:
+		slot, tuple, err := HeapOp(rec)
+		if err != nil {
+			return err
+		}
+		hp, err := storage.NewHeapPage(page)
+		if err != nil {
+			return err
+		}
+		return hp.Apply(heapOps[rec.Type], slot, tuple)
+	default:
+		return fmt.Errorf("record %s does not modify a page", rec.Type)
+	}
+}
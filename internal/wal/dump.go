@@ -0,0 +1,170 @@
+package wal
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/k-sml/go-rdbms/internal/vfs"
+)
+
+// Describe はレコードのペイロードを1行に要約した文字列を返します。
+// リカバリの不具合を調べるときに、どのページに何が書かれたかを追うためのものです。
+func Describe(rec *Record) string {
+	switch {
+	case rec.Type == RecPageImage:
+		return fmt.Sprintf("image %dB crc=%08x", len(rec.Payload), crc32.ChecksumIEEE(rec.Payload))
+	case rec.Type == RecPageDiff:
+		var ranges []string
+		n := 0
+		for d := rec.Payload; len(d) >= 4; {
+			off := int(binary.LittleEndian.Uint16(d[0:2]))
+			ln := int(binary.LittleEndian.Uint16(d[2:4]))
+			ranges = append(ranges, fmt.Sprintf("%d+%d", off, ln))
+			n += ln
+			if len(d) < 4+ln {
+				break
+			}
+			d = d[4+ln:]
+		}
+		return fmt.Sprintf("diff %dB in %d ranges [%s]", n, len(ranges), strings.Join(ranges, " "))
+	case IsLogical(rec.Type):
+		slot, tuple, err := HeapOp(rec)
+		if err != nil {
+			return err.Error()
+		}
+		if rec.Type == RecHeapDelete {
+			return fmt.Sprintf("slot=%d", slot)
+		}
+		return fmt.Sprintf("slot=%d tuple=%dB %s", slot, len(tuple), preview(tuple))
+	default:
+		if len(rec.Payload) == 0 {
+			return ""
+		}
+		return fmt.Sprintf("%dB", len(rec.Payload))
+	}
+}
+
+// preview はタプルの先頭を表示用に整形します。
+func preview(b []byte) string {
+	const limit = 16
+	s := fmt.Sprintf("%x", b[:min(len(b), limit)])
+	if len(b) > limit {
+		s += "..."
+	}
+	return s
+}
+
+// Format はレコードを1行の文字列にします。
+func Format(rec *Record) string {
+	page := "-"
+	if rec.PageID >= 0 {
+		page = fmt.Sprint(rec.PageID)
+	}
+	line := fmt.Sprintf("%8d  %-7s tx=%-6d page=%-6s", rec.LSN, rec.Type, rec.TxID, page)
+	if d := Describe(rec); d != "" {
+		line += " " + d
+	}
+	return strings.TrimRight(line, " ")
+}
+
+// Filter は Dump で表示するレコードを選ぶ条件です。ゼロ値はすべてのレコードを選びます。
+// 条件を複数指定すると、すべてに当てはまるレコードだけを選びます。
+type Filter struct {
+	TxIDs   []uint64     // 空でなければ、これらのトランザクションのレコードだけを選ぶ
+	Pages   []int64      // 空でなければ、これらのページのレコードだけを選ぶ
+	Types   []RecordType // 空でなければ、これらの種類のレコードだけを選ぶ
+	FromLSN uint64       // この LSN より前のレコードを選ばない
+	ToLSN   uint64       // 0 でなければ、この LSN より後のレコードを選ばない
+}
+
+// filterState はレコードを順に読みながら Filter を当てはめる状態です。
+type filterState struct {
+	Filter
+	touched map[uint64]bool // Pages のページのレコードを書いたトランザクション
+}
+
+// match はレコード rec を表示するかを返します。Pages を指定したときの COMMIT のレコードは
+// ページを持たないので、それまでに Pages のページを書いたトランザクションのものだけを選び、
+// ページの変更が確定したかを追えるようにします。
+func (f *filterState) match(rec *Record) bool {
+	if rec.LSN < f.FromLSN || f.ToLSN != 0 && rec.LSN > f.ToLSN {
+		return false
+	}
+	if len(f.TxIDs) > 0 && !slices.Contains(f.TxIDs, rec.TxID) {
+		return false
+	}
+	if len(f.Types) > 0 && !slices.Contains(f.Types, rec.Type) {
+		return false
+	}
+	if len(f.Pages) > 0 {
+		if rec.Type == RecCommit {
+			return f.touched[rec.TxID]
+		}
+		if !slices.Contains(f.Pages, rec.PageID) {
+			return false
+		}
+		f.touched[rec.TxID] = true
+	}
+	return true
+}
+
+// ParseRecordType はレコードの種類の表示名（PAGE、COMMIT など。大文字と小文字は区別しない）を
+// RecordType にします。
+func ParseRecordType(name string) (RecordType, bool) {
+	for t := RecPageImage; t <= RecHeapUpdate; t++ {
+		if strings.EqualFold(t.String(), name) {
+			return t, true
+		}
+	}
+	return 0, false
+}
+
+// Dump はWALファイルのヘッダと、f に当てはまるレコードを w に書き出します。
+// 末尾の不完全なレコードがあれば、その位置を最後に報告します。
+func Dump(w io.Writer, path string, f Filter) error {
+	return DumpFS(w, vfs.OS, path, f)
+}
+
+// DumpFS は fsys 上のWALファイルを w に書き出します。
+func DumpFS(w io.Writer, fsys vfs.FS, path string, f Filter) error {
+	r, err := OpenReaderFS(fsys, path)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	fmt.Fprintf(w, "wal %s: page size %d, salt %08x, start lsn %d\n", path, r.PageSize(), r.Salt(), r.StartLSN())
+	fs := &filterState{Filter: f, touched: make(map[uint64]bool)}
+	n, shown := 0, 0
+	for {
+		rec, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		n++
+		if fs.match(rec) {
+			fmt.Fprintln(w, Format(rec))
+			shown++
+		}
+	}
+	size, err := r.f.Size()
+	if err != nil {
+		return err
+	}
+	if shown == n {
+		fmt.Fprintf(w, "%d records, %d bytes\n", n, r.Offset())
+	} else {
+		fmt.Fprintf(w, "%d of %d records shown, %d bytes\n", shown, n, r.Offset())
+	}
+	if size > r.Offset() {
+		fmt.Fprintf(w, "%d bytes of incomplete or invalid data at offset %d\n", size-r.Offset(), r.Offset())
+	}
+	return nil
+}
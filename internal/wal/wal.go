@@ -0,0 +1,412 @@
+// Package wal は先行書き込みログ（Write-Ahead Log）を提供します。
+// ページへの変更をコミット単位でログファイルに追記し、
+// クラッシュ後のリカバリやレプリカへの継続的な適用に利用します。
+package wal
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math/rand"
+	"os"
+	"sync"
+
+	"github.com/k-sml/go-rdbms/internal/dberr"
+	"github.com/k-sml/go-rdbms/internal/vfs"
+)
+
+// ファイルヘッダレイアウト（先頭から固定長）
+// [4B:magic "MWAL"][u16:version][u16:reserved][u32:pageSize][u32:salt][u64:startLSN]
+//   salt    : Reset のたびに変わる値。レコードのCRCに混ぜ込み、世代の違うレコードを区別する
+//   startLSN: このファイルの最初のレコードに割り当てられるLSN
+//
+// レコードレイアウト
+// [u32:crc][u32:bodyLen][body]
+// body = [u64:lsn][u8:type][u64:txID][i64:pageID][payload]
+
+const (
+	headerSize    = 24 // ファイルヘッダサイズ（バイト）
+	recHeaderSize = 8  // crc + bodyLen
+	bodyFixedSize = 25 // lsn + type + txID + pageID
+	version       = 1
+)
+
+var magic = [4]byte{'M', 'W', 'A', 'L'}
+
+// RecordType はログレコードの種類を表します。
+type RecordType uint8
+
+const (
+	// RecPageImage はページ全体の書き込み後イメージを保持するレコードです。
+	RecPageImage RecordType = 1
+	// RecCommit はそれまでのレコードをひとまとまりとして確定させるレコードです。
+	RecCommit RecordType = 2
+)
+
+// String はレコード種別の表示名を返します。
+func (t RecordType) String() string {
+	switch t {
+	case RecPageImage:
+		return "PAGE"
+	case RecCommit:
+		return "COMMIT"
+	case RecPageDiff:
+		return "DIFF"
+	case RecHeapInsert:
+		return "INSERT"
+	case RecHeapDelete:
+		return "DELETE"
+	case RecHeapUpdate:
+		return "UPDATE"
+	default:
+		return fmt.Sprintf("TYPE(%d)", uint8(t))
+	}
+}
+
+// Record は1件のログレコードです。
+type Record struct {
+	LSN     uint64     // ログシーケンス番号（Append 時に割り当てられる）
+	Type    RecordType // レコード種別
+	TxID    uint64     // レコードを書いたトランザクション（バッチ）のID
+	PageID  int64      // 対象ページ（ページを伴わないレコードでは -1）
+	Payload []byte     // レコード本体（ページイメージなど）
+}
+
+// ErrCorrupt はWALファイルのヘッダが壊れている場合に返されます。
+var ErrCorrupt = dberr.Mark(errors.New("wal file is corrupt"), dberr.ErrCorrupt)
+
+// Path はデータベースファイルに対応するWALファイルのパスを返します。
+func Path(dbPath string) string { return dbPath + "-wal" }
+
+// header はWALファイルヘッダの内容です。
+type header struct {
+	pageSize int
+	salt     uint32
+	startLSN uint64
+}
+
+func (h header) encode() []byte {
+	buf := make([]byte, headerSize)
+	copy(buf[0:4], magic[:])
+	binary.LittleEndian.PutUint16(buf[4:6], version)
+	binary.LittleEndian.PutUint32(buf[8:12], uint32(h.pageSize))
+	binary.LittleEndian.PutUint32(buf[12:16], h.salt)
+	binary.LittleEndian.PutUint64(buf[16:24], h.startLSN)
+	return buf
+}
+
+func decodeHeader(buf []byte) (header, error) {
+	if len(buf) < headerSize || [4]byte(buf[0:4]) != magic {
+		return header{}, ErrCorrupt
+	}
+	if v := binary.LittleEndian.Uint16(buf[4:6]); v != version {
+		return header{}, fmt.Errorf("unsupported wal version: %d", v)
+	}
+	return header{
+		pageSize: int(binary.LittleEndian.Uint32(buf[8:12])),
+		salt:     binary.LittleEndian.Uint32(buf[12:16]),
+		startLSN: binary.LittleEndian.Uint64(buf[16:24]),
+	}, nil
+}
+
+func readHeader(f vfs.File) (header, error) {
+	buf := make([]byte, headerSize)
+	if _, err := f.ReadAt(buf, 0); err != nil {
+		if err == io.EOF {
+			return header{}, ErrCorrupt
+		}
+		return header{}, err
+	}
+	return decodeHeader(buf)
+}
+
+// encodeRecord はレコードをファイル上の表現に変換します。
+// CRCにはsaltを混ぜ込み、Reset前の古いレコードを誤って読まないようにする。
+func encodeRecord(salt uint32, rec *Record) []byte {
+	bodyLen := bodyFixedSize + len(rec.Payload)
+	buf := make([]byte, recHeaderSize+bodyLen)
+	binary.LittleEndian.PutUint32(buf[4:8], uint32(bodyLen))
+	body := buf[recHeaderSize:]
+	binary.LittleEndian.PutUint64(body[0:8], rec.LSN)
+	body[8] = byte(rec.Type)
+	binary.LittleEndian.PutUint64(body[9:17], rec.TxID)
+	binary.LittleEndian.PutUint64(body[17:25], uint64(rec.PageID))
+	copy(body[bodyFixedSize:], rec.Payload)
+	binary.LittleEndian.PutUint32(buf[0:4], checksum(salt, body))
+	return buf
+}
+
+func checksum(salt uint32, body []byte) uint32 {
+	var s [4]byte
+	binary.LittleEndian.PutUint32(s[:], salt)
+	return crc32.Update(crc32.ChecksumIEEE(s[:]), crc32.IEEETable, body)
+}
+
+// Log は追記専用のWALファイルです。
+// 書き込みはすべて Append を通して行い、Sync で永続化します。
+type Log struct {
+	f       vfs.File
+	path    string
+	hdr     header
+	nextLSN uint64     // 次に割り当てるLSN
+	size    int64      // 有効なデータの末尾
+	mu      sync.Mutex // 追記操作を直列化する
+}
+
+// Open はWALファイルを開きます（存在しなければ作成します）。
+// 末尾にある壊れたレコードや、COMMIT で閉じられていないレコードは切り詰められるため、
+// Open 後のファイルにはコミット済みのレコードだけが残ります。
+func Open(path string, pageSize int) (*Log, error) {
+	return OpenFS(vfs.OS, path, pageSize)
+}
+
+// OpenFS は fsys 上のWALファイルを開きます。
+func OpenFS(fsys vfs.FS, path string, pageSize int) (*Log, error) {
+	f, err := fsys.OpenFile(path, os.O_RDWR|os.O_CREATE, 0666)
+	if err != nil {
+		return nil, err
+	}
+	l := &Log{f: f, path: path}
+	if err := l.init(pageSize); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *Log) init(pageSize int) error {
+	size, err := l.f.Size()
+	if err != nil {
+		return err
+	}
+	if size < headerSize {
+		// 新規ファイル（またはヘッダ書き込み途中で落ちたファイル）は作り直す
+		return l.reset(pageSize, 1)
+	}
+	hdr, err := readHeader(l.f)
+	if err != nil {
+		return err
+	}
+	if hdr.pageSize != pageSize {
+		return fmt.Errorf("wal page size %d does not match %d", hdr.pageSize, pageSize)
+	}
+	l.hdr = hdr
+
+	// 最後の COMMIT までを有効範囲とする
+	r := &Reader{f: l.f, hdr: hdr, off: headerSize}
+	end, next := int64(headerSize), hdr.startLSN
+	for {
+		rec, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if rec.Type == RecCommit {
+			end, next = r.Offset(), rec.LSN+1
+		}
+	}
+	if end < size {
+		if err := l.f.Truncate(end); err != nil {
+			return err
+		}
+	}
+	l.size, l.nextLSN = end, next
+	return nil
+}
+
+// reset はヘッダを書き直し、ファイルを空のログにします。
+func (l *Log) reset(pageSize int, startLSN uint64) error {
+	l.hdr = header{pageSize: pageSize, salt: rand.Uint32(), startLSN: startLSN}
+	if err := l.f.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := l.f.WriteAt(l.hdr.encode(), 0); err != nil {
+		return err
+	}
+	if err := l.f.Sync(); err != nil {
+		return err
+	}
+	l.size, l.nextLSN = headerSize, startLSN
+	return nil
+}
+
+// Append はレコードをログの末尾に追記し、割り当てたLSNを返します。
+// 永続化には Sync の呼び出しが必要です。
+func (l *Log) Append(rec *Record) (uint64, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	rec.LSN = l.nextLSN
+	buf := encodeRecord(l.hdr.salt, rec)
+	if _, err := l.f.WriteAt(buf, l.size); err != nil {
+		return 0, err
+	}
+	l.size += int64(len(buf))
+	l.nextLSN++
+	return rec.LSN, nil
+}
+
+// Sync は追記済みのレコードをディスクに書き込みます。
+func (l *Log) Sync() error {
+	return l.f.Sync()
+}
+
+// Reset はログを空にします。チェックポイントでデータベースファイルに
+// すべての変更が反映された後に呼び出します。LSNは引き継がれます。
+func (l *Log) Reset() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.reset(l.hdr.pageSize, l.nextLSN)
+}
+
+// NextLSN は次に割り当てられるLSNを返します。
+func (l *Log) NextLSN() uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.nextLSN
+}
+
+// Size はログファイルの有効なバイト数を返します。
+func (l *Log) Size() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.size
+}
+
+// Close はログファイルを閉じます。
+func (l *Log) Close() error {
+	return l.f.Close()
+}
+
+// Reader はWALファイルのレコードを先頭から順に読み出します。
+// 書き込み中のファイルを読むことを想定しており、末尾の不完全なレコードは
+// io.EOF として扱います（後で同じ位置から読み直せます）。
+type Reader struct {
+	f   vfs.File
+	hdr header
+	off int64 // 次に読むレコードの位置
+	own bool  // f を Close する責任があるか
+}
+
+// OpenReader はWALファイルを読み取り専用で開きます。
+func OpenReader(path string) (*Reader, error) {
+	return OpenReaderFS(vfs.OS, path)
+}
+
+// OpenReaderFS は fsys 上のWALファイルを読み取り専用で開きます。
+func OpenReaderFS(fsys vfs.FS, path string) (*Reader, error) {
+	f, err := fsys.OpenFile(path, os.O_RDONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+	hdr, err := readHeader(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &Reader{f: f, hdr: hdr, off: headerSize, own: true}, nil
+}
+
+// HasCommitsFS は fsys 上のWALファイルに、まだチェックポイントしていないコミット済みの
+// レコードがあるかどうかを返します。ファイルがないか、ヘッダを書き終えていなければ false です。
+func HasCommitsFS(fsys vfs.FS, path string) (bool, error) {
+	r, err := OpenReaderFS(fsys, path)
+	if errors.Is(err, os.ErrNotExist) || errors.Is(err, ErrCorrupt) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer r.Close()
+	for {
+		rec, err := r.Next()
+		if err == io.EOF {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		if rec.Type == RecCommit {
+			return true, nil
+		}
+	}
+}
+
+// Next は次のレコードを返します。読めるレコードがなければ io.EOF を返します。
+func (r *Reader) Next() (*Record, error) {
+	var rh [recHeaderSize]byte
+	if _, err := r.f.ReadAt(rh[:], r.off); err != nil {
+		if err == io.EOF {
+			return nil, io.EOF
+		}
+		return nil, err
+	}
+	crc := binary.LittleEndian.Uint32(rh[0:4])
+	bodyLen := int(binary.LittleEndian.Uint32(rh[4:8]))
+	if bodyLen < bodyFixedSize || bodyLen > bodyFixedSize+r.hdr.pageSize {
+		return nil, io.EOF
+	}
+	body := make([]byte, bodyLen)
+	if _, err := r.f.ReadAt(body, r.off+recHeaderSize); err != nil {
+		if err == io.EOF {
+			return nil, io.EOF
+		}
+		return nil, err
+	}
+	if checksum(r.hdr.salt, body) != crc {
+		return nil, io.EOF
+	}
+	r.off += int64(recHeaderSize + bodyLen)
+	return &Record{
+		LSN:     binary.LittleEndian.Uint64(body[0:8]),
+		Type:    RecordType(body[8]),
+		TxID:    binary.LittleEndian.Uint64(body[9:17]),
+		PageID:  int64(binary.LittleEndian.Uint64(body[17:25])),
+		Payload: body[bodyFixedSize:],
+	}, nil
+}
+
+// Offset は次に読むレコードのファイル内位置を返します。
+func (r *Reader) Offset() int64 { return r.off }
+
+// Salt は読み込み時点でのWALの世代を表す値を返します。
+func (r *Reader) Salt() uint32 { return r.hdr.salt }
+
+// Size はWALファイルの大きさを返します。Offset より大きければ、末尾に不完全か不正なレコードがあります。
+func (r *Reader) Size() (int64, error) { return r.f.Size() }
+
+// StartLSN はこのWALファイルの最初のレコードのLSNを返します。
+func (r *Reader) StartLSN() uint64 { return r.hdr.startLSN }
+
+// PageSize はWALに記録されたページサイズを返します。
+func (r *Reader) PageSize() int { return r.hdr.pageSize }
+
+// Reopen はヘッダを読み直し、ログが Reset されていれば先頭から読み直します。
+// ログが Reset されていた場合は true を返します。
+func (r *Reader) Reopen() (bool, error) {
+	hdr, err := readHeader(r.f)
+	if errors.Is(err, ErrCorrupt) {
+		// Reset の途中でヘッダがまだ書かれていない
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if hdr.salt == r.hdr.salt {
+		return false, nil
+	}
+	r.hdr, r.off = hdr, headerSize
+	return true, nil
+}
+
+// Close はリーダを閉じます。
+func (r *Reader) Close() error {
+	if !r.own {
+		return nil
+	}
+	return r.f.Close()
+}
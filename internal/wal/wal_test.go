@@ -0,0 +1,304 @@
+package wal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+const testPageSize = 64
+
+// appendTx は txID のページイメージのレコードを pages の数だけ追記し、COMMIT で閉じます。
+func appendTx(t *testing.T, l *Log, txID uint64, pages ...int64) {
+	t.Helper()
+	for _, id := range pages {
+		img := bytes.Repeat([]byte{byte(id)}, testPageSize)
+		if _, err := l.Append(&Record{Type: RecPageImage, TxID: txID, PageID: id, Payload: img}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := l.Append(&Record{Type: RecCommit, TxID: txID, PageID: -1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Sync(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// readAll は path のWALのレコードを先頭から順にすべて読みます。
+func readAll(t *testing.T, path string) []Record {
+	t.Helper()
+	r, err := OpenReader(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	var recs []Record
+	for {
+		rec, err := r.Next()
+		if err == io.EOF {
+			return recs
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		recs = append(recs, *rec)
+	}
+}
+
+// TestRecovery は、Open が末尾の壊れたレコードや COMMIT で閉じられていないレコードを切り詰め、
+// 最後の COMMIT までのレコードだけを残すことを確かめます。
+func TestRecovery(t *testing.T) {
+	tests := []struct {
+		name string
+		// damage は2つのトランザクションをコミットしたWALを壊します。first は最初の COMMIT の直後、
+		// second は2番目の COMMIT の直後の位置です。
+		damage func(t *testing.T, l *Log, f *os.File, first, second int64)
+		keep   int // 残るトランザクションの数
+	}{
+		{"intact", func(t *testing.T, l *Log, f *os.File, first, second int64) {}, 2},
+		{"uncommitted tail", func(t *testing.T, l *Log, f *os.File, first, second int64) {
+			if _, err := l.Append(&Record{Type: RecPageImage, TxID: 3, PageID: 9, Payload: make([]byte, testPageSize)}); err != nil {
+				t.Fatal(err)
+			}
+		}, 2},
+		{"torn record", func(t *testing.T, l *Log, f *os.File, first, second int64) {
+			appendTx(t, l, 3, 9)
+			if err := f.Truncate(l.Size() - 5); err != nil {
+				t.Fatal(err)
+			}
+		}, 2},
+		{"garbage", func(t *testing.T, l *Log, f *os.File, first, second int64) {
+			if _, err := f.WriteAt(bytes.Repeat([]byte{0xAB}, 100), second); err != nil {
+				t.Fatal(err)
+			}
+		}, 2},
+		{"bad crc in last tx", func(t *testing.T, l *Log, f *os.File, first, second int64) {
+			flip(t, f, first+recHeaderSize+bodyFixedSize)
+		}, 1},
+		{"bad crc in commit", func(t *testing.T, l *Log, f *os.File, first, second int64) {
+			flip(t, f, second-1)
+		}, 1},
+		{"bad crc in first tx", func(t *testing.T, l *Log, f *os.File, first, second int64) {
+			flip(t, f, headerSize+recHeaderSize)
+		}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "test.db-wal")
+			l, err := Open(path, testPageSize)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ends := []int64{headerSize}
+			appendTx(t, l, 1, 1, 2)
+			ends = append(ends, l.Size())
+			appendTx(t, l, 2, 3)
+			ends = append(ends, l.Size())
+			f, err := os.OpenFile(path, os.O_RDWR, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tt.damage(t, l, f, ends[1], ends[2])
+			f.Close()
+			l.Close()
+
+			l, err = Open(path, testPageSize)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer l.Close()
+			if got, want := l.Size(), ends[tt.keep]; got != want {
+				t.Errorf("Size = %d after reopening, want %d", got, want)
+			}
+			if fi, err := os.Stat(path); err != nil {
+				t.Fatal(err)
+			} else if fi.Size() != ends[tt.keep] {
+				t.Errorf("file size = %d after reopening, want %d", fi.Size(), ends[tt.keep])
+			}
+			recs := readAll(t, path)
+			want := []int{0, 3, 5}[tt.keep] // 1番目は2ページ + COMMIT、2番目は1ページ + COMMIT
+			if len(recs) != want {
+				t.Fatalf("read %d records, want %d", len(recs), want)
+			}
+			for i, rec := range recs {
+				if rec.LSN != uint64(i+1) {
+					t.Errorf("record %d has LSN %d, want %d", i, rec.LSN, i+1)
+				}
+			}
+			if got := l.NextLSN(); got != uint64(want+1) {
+				t.Errorf("NextLSN = %d, want %d", got, want+1)
+			}
+
+			// 切り詰めた後に追記したレコードも読める
+			appendTx(t, l, 4, 7)
+			if recs := readAll(t, path); len(recs) != want+2 || recs[want].PageID != 7 {
+				t.Errorf("read %d records after appending, want %d ending with page 7", len(recs), want+2)
+			}
+		})
+	}
+}
+
+// flip は f の off のバイトを反転します。
+func flip(t *testing.T, f *os.File, off int64) {
+	t.Helper()
+	b := make([]byte, 1)
+	if _, err := f.ReadAt(b, off); err != nil {
+		t.Fatal(err)
+	}
+	b[0] ^= 0xFF
+	if _, err := f.WriteAt(b, off); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestReset は、Reset したログでは古いレコードを読まず、LSN が引き継がれることを確かめます。
+func TestReset(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db-wal")
+	l, err := Open(path, testPageSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	appendTx(t, l, 1, 1, 2, 3)
+	r, err := OpenReader(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	for range 4 {
+		if _, err := r.Next(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := l.Reset(); err != nil {
+		t.Fatal(err)
+	}
+	if l.Size() != headerSize || l.NextLSN() != 5 {
+		t.Errorf("after Reset: Size = %d, NextLSN = %d, want %d, 5", l.Size(), l.NextLSN(), headerSize)
+	}
+	appendTx(t, l, 2, 4)
+	if reset, err := r.Reopen(); err != nil || !reset {
+		t.Fatalf("Reopen = %v, %v, want true", reset, err)
+	}
+	rec, err := r.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.LSN != 5 || rec.PageID != 4 {
+		t.Errorf("first record after Reset has LSN %d and page %d, want 5 and 4", rec.LSN, rec.PageID)
+	}
+	if recs := readAll(t, path); len(recs) != 2 {
+		t.Errorf("read %d records after Reset, want 2", len(recs))
+	}
+}
+
+// TestDiff は、EncodeDiff で作った差分を Apply で元のページに適用すると新しいページになり、
+// 近い変更はまとめ、離れた変更は別の区間にすることを確かめます。
+func TestDiff(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(p []byte)
+		ranges int
+	}{
+		{"none", func(p []byte) {}, 0},
+		{"one byte", func(p []byte) { p[10] = 1 }, 1},
+		{"first and last", func(p []byte) { p[0], p[testPageSize-1] = 1, 1 }, 2},
+		{"within gap", func(p []byte) { p[10], p[10+diffGap] = 1, 1 }, 1},
+		{"beyond gap", func(p []byte) { p[10], p[11+diffGap] = 1, 1 }, 2},
+		{"whole page", func(p []byte) {
+			for i := range p {
+				p[i] = byte(i) + 1
+			}
+		}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			old := make([]byte, testPageSize)
+			for i := range old {
+				old[i] = byte(i * 7)
+			}
+			new := bytes.Clone(old)
+			tt.change(new)
+			diff := EncodeDiff(old, new)
+			n := 0
+			for d := diff; len(d) > 0; n++ {
+				d = d[4+int(binary.LittleEndian.Uint16(d[2:4])):]
+			}
+			if n != tt.ranges {
+				t.Errorf("diff has %d ranges, want %d", n, tt.ranges)
+			}
+			page := bytes.Clone(old)
+			if err := Apply(page, &Record{Type: RecPageDiff, Payload: diff}); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(page, new) {
+				t.Errorf("applied diff = %x, want %x", page, new)
+			}
+		})
+	}
+
+	for _, payload := range [][]byte{
+		{0, 0},                            // 区間のヘッダが途中で切れている
+		{0, 0, 4, 0, 1, 2},                // データが途中で切れている
+		{testPageSize - 1, 0, 2, 0, 1, 2}, // ページの外
+	} {
+		if err := Apply(make([]byte, testPageSize), &Record{Type: RecPageDiff, Payload: payload}); err == nil {
+			t.Errorf("Apply(% x) succeeded, want an error", payload)
+		}
+	}
+}
+
+// TestFilter は、Filter に当てはまるレコードだけを選び、ページで選んだときは
+// そのページを書いたトランザクションの COMMIT も選ぶことを確かめます。
+func TestFilter(t *testing.T) {
+	recs := []Record{
+		{LSN: 1, Type: RecPageImage, TxID: 1, PageID: 1},
+		{LSN: 2, Type: RecPageDiff, TxID: 1, PageID: 2},
+		{LSN: 3, Type: RecCommit, TxID: 1, PageID: -1},
+		{LSN: 4, Type: RecHeapInsert, TxID: 2, PageID: 2},
+		{LSN: 5, Type: RecCommit, TxID: 2, PageID: -1},
+		{LSN: 6, Type: RecPageDiff, TxID: 3, PageID: 3},
+		{LSN: 7, Type: RecCommit, TxID: 3, PageID: -1},
+	}
+	tests := []struct {
+		name   string
+		filter Filter
+		want   []uint64
+	}{
+		{"all", Filter{}, []uint64{1, 2, 3, 4, 5, 6, 7}},
+		{"tx", Filter{TxIDs: []uint64{2, 3}}, []uint64{4, 5, 6, 7}},
+		{"page", Filter{Pages: []int64{2}}, []uint64{2, 3, 4, 5}},
+		{"type", Filter{Types: []RecordType{RecCommit}}, []uint64{3, 5, 7}},
+		{"lsn range", Filter{FromLSN: 3, ToLSN: 5}, []uint64{3, 4, 5}},
+		{"page and tx", Filter{Pages: []int64{2}, TxIDs: []uint64{2}}, []uint64{4, 5}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := &filterState{Filter: tt.filter, touched: make(map[uint64]bool)}
+			var got []uint64
+			for i := range recs {
+				if fs.match(&recs[i]) {
+					got = append(got, recs[i].LSN)
+				}
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("matched LSNs %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	for name, want := range map[string]RecordType{"page": RecPageImage, "COMMIT": RecCommit, "Diff": RecPageDiff} {
+		if got, ok := ParseRecordType(name); !ok || got != want {
+			t.Errorf("ParseRecordType(%q) = %v, %v, want %v", name, got, ok, want)
+		}
+	}
+	if _, ok := ParseRecordType("bogus"); ok {
+		t.Error(`ParseRecordType("bogus") succeeded`)
+	}
+}
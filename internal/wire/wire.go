@@ -0,0 +1,319 @@
+// Package wire は minirdb serve のクライアントとサーバーがやり取りするメッセージの形式を定義します。
+//
+// メッセージは [u8:種類][u32:長さ][本体] のフレームで送ります（整数はリトルエンディアン、長さは
+// 本体のバイト数）。本体の文字列は [u32:長さ][UTF-8]、値の並び（引数と行）は tuple.Encode の形式です。
+//
+// サーバーが TLS を使う設定なら、接続したらすぐに TLS のハンドシェイクをし、以下のメッセージは
+// すべて TLS の上で送ります。
+//
+// 接続したクライアントは最初に Startup を送り、サーバーは認証に成功すれば AuthOK を、失敗すれば
+// Error を返して接続を閉じます。その後は要求を1つ送るたびに、その応答を最後まで読んでから次の要求を
+// 送ります。Query と Execute の応答は、結果の行を返す文なら RowDescription と DataRow の並び、
+// 最後に Complete か Error です。行は実行しながら送るので、結果をすべてサーバーのメモリに
+// 持つことはありません。
+//
+//	クライアント                        サーバー
+//	Startup     [u16:版][ユーザー][パスワード]
+//	                                   AuthOK         [サーバーの版]
+//	Query       [SQL][引数]
+//	                                   RowDescription [u16:列の数]（[名前][型] が列の数だけ）
+//	                                   DataRow        [値の並び]
+//	                                   Complete       [i64:行の数][u8:トランザクション中か]
+//	Prepare     [SQL]
+//	                                   Prepared       [u32:文の ID][u16:引数の数][u8:行を返すか]
+//	Execute     [u32:文の ID][引数]
+//	                                   （Query と同じ）
+//	CloseStmt   [u32:文の ID]
+//	                                   Complete
+//	Replicate
+//	                                   BaseBackup     [u32:ページサイズ][i64:ページの数][i64:LSN]
+//	                                   BasePage       [i64:ページ ID][ページ]
+//	                                   WALRecord      [i64:LSN][u8:種類][i64:トランザクション ID][i64:ページ ID][内容]
+//	Terminate
+//
+// Replicate はストリーミングレプリケーションのフォロワーが送ります（書き込みを許可したユーザーに
+// 限ります）。サーバーは BaseBackup に続けてデータベースのスナップショットのページを BasePage で
+// 送り、その後はスナップショットより後にコミットしたWALのレコードを WALRecord で送り続けます。
+// BaseBackup の LSN は最初の WALRecord の LSN です。Replicate の後は接続を閉じるまでほかの要求は
+// 送れません。
+//
+// Error の本体は [コード][メッセージ] です。コードは rdbms のエラーの種類を表す文字列
+// （CodeUnique など）で、種類がなければ CodeError です。Error を返した後も接続は使えます。
+package wire
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/k-sml/go-rdbms/internal/tuple"
+	"github.com/k-sml/go-rdbms/internal/types"
+)
+
+// Version はプロトコルの版です。
+const Version = 1
+
+// MaxMessageSize はフレームの本体の大きさの上限です。
+const MaxMessageSize = 64 << 20
+
+// クライアントが送るメッセージの種類
+const (
+	Startup   byte = 'S'
+	Query     byte = 'Q'
+	Prepare   byte = 'P'
+	Execute   byte = 'E'
+	CloseStmt byte = 'C'
+	Replicate byte = 'L'
+	Terminate byte = 'X'
+)
+
+// サーバーが送るメッセージの種類
+const (
+	AuthOK         byte = 'R'
+	Prepared       byte = 'P'
+	RowDescription byte = 'T'
+	DataRow        byte = 'D'
+	Complete       byte = 'C'
+	Error          byte = 'E'
+	BaseBackup     byte = 'B'
+	BasePage       byte = 'G'
+	WALRecord      byte = 'W'
+)
+
+// Error のコード
+const (
+	CodeError         = "error"
+	CodeProtocol      = "protocol"      // メッセージの形式や順序の誤り
+	CodeAuth          = "auth"          // 認証の失敗
+	CodeSyntax        = "syntax"        // SQL の構文の誤り
+	CodeInvalid       = "invalid"       // 名前の解決や型の検査で見つかった誤り
+	CodeConstraint    = "constraint"    // 制約の違反（ほかの制約の種類に当たらないもの）
+	CodeUnique        = "unique"        // 一意性の制約の違反
+	CodeNotNull       = "not_null"      // NOT NULL の制約の違反
+	CodeForeignKey    = "foreign_key"   // 外部キーの制約の違反
+	CodeLocked        = "locked"        // ロックを取れなかった
+	CodeDeadlock      = "deadlock"      // デッドロック
+	CodeSerialization = "serialization" // 並行するトランザクションとの競合
+	CodeReadOnly      = "read_only"     // 読み取り専用のデータベースかトランザクションへの書き込み
+	CodeCorrupt       = "corrupt"       // ファイルの破損
+	CodeCanceled      = "canceled"      // 文の取り消し
+	CodeTxDone        = "tx_done"       // 終えたトランザクションの使用
+	CodeConnDone      = "conn_done"     // 閉じたセッションの使用
+	CodeUnknownStmt   = "unknown_stmt"  // 準備していない文の ID
+)
+
+// ErrTooLarge は本体が MaxMessageSize を超えるフレームを読んだ場合のエラーです。
+var ErrTooLarge = errors.New("wire: message too large")
+
+// ErrMalformed はメッセージの本体が形式に合わない場合のエラーです。
+var ErrMalformed = errors.New("wire: malformed message")
+
+// Reader はフレームを読みます。
+type Reader struct {
+	r   *bufio.Reader
+	buf []byte
+}
+
+// NewReader は r からフレームを読む Reader を返します。
+func NewReader(r io.Reader) *Reader {
+	return &Reader{r: bufio.NewReader(r)}
+}
+
+// Read は次のフレームを読み、種類と本体を返します。本体は次の Read の呼び出しまで有効です。
+func (r *Reader) Read() (byte, []byte, error) {
+	var hdr [5]byte
+	if _, err := io.ReadFull(r.r, hdr[:]); err != nil {
+		return 0, nil, err
+	}
+	n := binary.LittleEndian.Uint32(hdr[1:])
+	if n > MaxMessageSize {
+		return 0, nil, ErrTooLarge
+	}
+	if cap(r.buf) < int(n) {
+		r.buf = make([]byte, n)
+	}
+	r.buf = r.buf[:n]
+	if _, err := io.ReadFull(r.r, r.buf); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return 0, nil, err
+	}
+	return hdr[0], r.buf, nil
+}
+
+// Writer はフレームを書きます。書いたフレームは Flush を呼ぶか、バッファがいっぱいになるまで
+// 送りません。
+type Writer struct {
+	w *bufio.Writer
+}
+
+// NewWriter は w にフレームを書く Writer を返します。
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: bufio.NewWriterSize(w, 64<<10)}
+}
+
+// Write は種類 typ のフレームを書きます。本体は AppendString などで作ります。
+func (w *Writer) Write(typ byte, body []byte) error {
+	if len(body) > MaxMessageSize {
+		return ErrTooLarge
+	}
+	var hdr [5]byte
+	hdr[0] = typ
+	binary.LittleEndian.PutUint32(hdr[1:], uint32(len(body)))
+	if _, err := w.w.Write(hdr[:]); err != nil {
+		return err
+	}
+	_, err := w.w.Write(body)
+	return err
+}
+
+// Flush は書いたフレームを送ります。
+func (w *Writer) Flush() error { return w.w.Flush() }
+
+// AppendString は文字列 s を本体に追加します。
+func AppendString(b []byte, s string) []byte {
+	b = binary.LittleEndian.AppendUint32(b, uint32(len(s)))
+	return append(b, s...)
+}
+
+// AppendUint16 は u16 を本体に追加します。
+func AppendUint16(b []byte, v uint16) []byte { return binary.LittleEndian.AppendUint16(b, v) }
+
+// AppendUint32 は u32 を本体に追加します。
+func AppendUint32(b []byte, v uint32) []byte { return binary.LittleEndian.AppendUint32(b, v) }
+
+// AppendInt64 は i64 を本体に追加します。
+func AppendInt64(b []byte, v int64) []byte { return binary.LittleEndian.AppendUint64(b, uint64(v)) }
+
+// AppendBool は真偽値を u8 で本体に追加します。
+func AppendBool(b []byte, v bool) []byte {
+	if v {
+		return append(b, 1)
+	}
+	return append(b, 0)
+}
+
+// AppendValues は値の並びを本体に追加します。Go の値は types.FromGo で変換します。
+func AppendValues(b []byte, vals []any) ([]byte, error) {
+	row := make([]types.Value, len(vals))
+	for i, x := range vals {
+		v, err := types.FromGo(x)
+		if err != nil {
+			return nil, fmt.Errorf("value %d: %w", i+1, err)
+		}
+		row[i] = v
+	}
+	return append(b, tuple.Encode(row)...), nil
+}
+
+// Body は本体を先頭から読みます。読めなかった値はゼロ値にして Err に ErrMalformed を記録するので、
+// 読み終えてから Err を一度だけ調べれば足ります。
+type Body struct {
+	b   []byte
+	err error
+}
+
+// NewBody は本体 b を読む Body を返します。
+func NewBody(b []byte) *Body { return &Body{b: b} }
+
+// Err は読めなかった値があれば ErrMalformed を返します。
+func (d *Body) Err() error { return d.err }
+
+func (d *Body) next(n int) []byte {
+	if d.err != nil || len(d.b) < n {
+		d.err = ErrMalformed
+		return nil
+	}
+	p := d.b[:n]
+	d.b = d.b[n:]
+	return p
+}
+
+// String は文字列を読みます。
+func (d *Body) String() string {
+	p := d.next(4)
+	if p == nil {
+		return ""
+	}
+	return string(d.next(int(binary.LittleEndian.Uint32(p))))
+}
+
+// Uint16 は u16 を読みます。
+func (d *Body) Uint16() uint16 {
+	if p := d.next(2); p != nil {
+		return binary.LittleEndian.Uint16(p)
+	}
+	return 0
+}
+
+// Uint32 は u32 を読みます。
+func (d *Body) Uint32() uint32 {
+	if p := d.next(4); p != nil {
+		return binary.LittleEndian.Uint32(p)
+	}
+	return 0
+}
+
+// Int64 は i64 を読みます。
+func (d *Body) Int64() int64 {
+	if p := d.next(8); p != nil {
+		return int64(binary.LittleEndian.Uint64(p))
+	}
+	return 0
+}
+
+// Byte は u8 を読みます。
+func (d *Body) Byte() byte {
+	if p := d.next(1); p != nil {
+		return p[0]
+	}
+	return 0
+}
+
+// Rest は本体の残りのバイト列を読みます。バイト列は本体と同じく次の Read の呼び出しまで有効です。
+func (d *Body) Rest() []byte {
+	if d.err != nil {
+		return nil
+	}
+	p := d.b
+	d.b = nil
+	return p
+}
+
+// Bool は u8 の真偽値を読みます。
+func (d *Body) Bool() bool {
+	if p := d.next(1); p != nil {
+		return p[0] != 0
+	}
+	return false
+}
+
+// Values は本体の残りを値の並びとして読み、Go の値（Rows.Values と同じ）にして返します。
+func (d *Body) Values() []any {
+	row := d.Row()
+	if row == nil {
+		return nil
+	}
+	vals := make([]any, len(row))
+	for i, v := range row {
+		vals[i] = v.Go()
+	}
+	return vals
+}
+
+// Row は本体の残りを値の並びとして読みます。
+func (d *Body) Row() []types.Value {
+	if d.err != nil {
+		return nil
+	}
+	row, err := tuple.Decode(d.b)
+	if err != nil {
+		d.err = ErrMalformed
+		return nil
+	}
+	d.b = nil
+	return row
+}
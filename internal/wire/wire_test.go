@@ -0,0 +1,153 @@
+package wire
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+
+	"github.com/k-sml/go-rdbms/internal/types"
+)
+
+// TestFrames は、Writer が書いたフレームを Reader が同じ種類と本体で読めることを確かめます。
+func TestFrames(t *testing.T) {
+	frames := []struct {
+		typ  byte
+		body []byte
+	}{
+		{Startup, AppendString(AppendUint16(nil, Version), "alice")},
+		{Query, nil},
+		{DataRow, bytes.Repeat([]byte{7}, 100<<10)}, // Writer のバッファより大きい
+		{Terminate, []byte{}},
+	}
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	for _, f := range frames {
+		if err := w.Write(f.typ, f.body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+
+	r := NewReader(bytes.NewReader(data))
+	for i, f := range frames {
+		typ, body, err := r.Read()
+		if err != nil {
+			t.Fatalf("frame %d: %v", i, err)
+		}
+		if typ != f.typ || !bytes.Equal(body, f.body) {
+			t.Errorf("frame %d = %c with %d bytes, want %c with %d bytes", i, typ, len(body), f.typ, len(f.body))
+		}
+	}
+	if _, _, err := r.Read(); err != io.EOF {
+		t.Errorf("Read at the end: err = %v, want io.EOF", err)
+	}
+
+	// 本体の途中で切れたフレーム
+	r = NewReader(bytes.NewReader(data[:10]))
+	if _, _, err := r.Read(); err != io.ErrUnexpectedEOF {
+		t.Errorf("Read of a truncated frame: err = %v, want io.ErrUnexpectedEOF", err)
+	}
+
+	hdr := binary.LittleEndian.AppendUint32([]byte{Query}, MaxMessageSize+1)
+	if _, _, err := NewReader(bytes.NewReader(hdr)).Read(); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("Read of a too large frame: err = %v, want ErrTooLarge", err)
+	}
+}
+
+// TestBody は、Append 系の関数で作った本体を Body で読み戻せることを確かめます。
+func TestBody(t *testing.T) {
+	b := AppendString(nil, "héllo")
+	b = AppendString(b, "")
+	b = AppendUint16(b, 0xBEEF)
+	b = AppendUint32(b, 1<<31)
+	b = AppendInt64(b, -42)
+	b = AppendBool(b, true)
+	b = AppendBool(b, false)
+	b, err := AppendValues(b, []any{int64(1), "x", nil, 2.5, []byte{0, 1}, true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := NewBody(b)
+	got := []any{d.String(), d.String(), d.Uint16(), d.Uint32(), d.Int64(), d.Bool(), d.Bool(), d.Values()}
+	want := []any{"héllo", "", uint16(0xBEEF), uint32(1 << 31), int64(-42), true, false,
+		[]any{int64(1), "x", nil, 2.5, []byte{0, 1}, true}}
+	if err := d.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("read %v, want %v", got, want)
+	}
+
+	if _, err := AppendValues(nil, []any{struct{}{}}); err == nil {
+		t.Error("AppendValues accepted an unsupported Go value")
+	}
+}
+
+// TestBodyMalformed は、本体が足りなければゼロ値を返して Err に ErrMalformed を記録し、
+// その後の読み取りもゼロ値になることを確かめます。
+func TestBodyMalformed(t *testing.T) {
+	tests := []struct {
+		name string
+		body []byte
+		read func(d *Body) any
+	}{
+		{"string length", []byte{1, 0}, func(d *Body) any { return d.String() }},
+		{"string bytes", append(AppendUint32(nil, 5), "abc"...), func(d *Body) any { return d.String() }},
+		{"uint16", []byte{1}, func(d *Body) any { return d.Uint16() }},
+		{"uint32", []byte{1, 2, 3}, func(d *Body) any { return d.Uint32() }},
+		{"int64", make([]byte, 7), func(d *Body) any { return d.Int64() }},
+		{"bool", nil, func(d *Body) any { return d.Bool() }},
+		{"values", []byte{0xFF}, func(d *Body) any { return d.Values() }},
+	}
+	for _, tt := range tests {
+		d := NewBody(tt.body)
+		if got := tt.read(d); !reflect.ValueOf(got).IsZero() {
+			t.Errorf("%s: read %v, want the zero value", tt.name, got)
+		}
+		if v := d.Uint16(); v != 0 {
+			t.Errorf("%s: read %d after the error, want 0", tt.name, v)
+		}
+		if !errors.Is(d.Err(), ErrMalformed) {
+			t.Errorf("%s: Err = %v, want ErrMalformed", tt.name, d.Err())
+		}
+	}
+}
+
+// TestBodyRow は、Row が型付きの値を返し、Byte と Rest が残りをそのまま読むことを確かめます。
+func TestBodyRow(t *testing.T) {
+	row := []types.Value{types.NewInt(7), types.NewText("a"), types.NullValue()}
+	b, err := AppendValues([]byte{WALRecord}, []any{row[0], row[1], row[2]})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := NewBody(b)
+	if typ := d.Byte(); typ != WALRecord {
+		t.Errorf("Byte = %c, want %c", typ, WALRecord)
+	}
+	got := d.Row()
+	if d.Err() != nil || len(got) != len(row) {
+		t.Fatalf("Row = %v, %v", got, d.Err())
+	}
+	for i := range row {
+		if got[i].Type() != row[i].Type() || got[i].String() != row[i].String() {
+			t.Errorf("value %d = %v (%v), want %v (%v)", i, got[i], got[i].Type(), row[i], row[i].Type())
+		}
+	}
+
+	d = NewBody(b)
+	d.Byte()
+	if rest := d.Rest(); !bytes.Equal(rest, b[1:]) {
+		t.Errorf("Rest = %x, want %x", rest, b[1:])
+	}
+	if rest := d.Rest(); len(rest) != 0 {
+		t.Errorf("second Rest = %x, want nothing", rest)
+	}
+}
@@ -0,0 +1,70 @@
+package rdbms
+
+import (
+	"context"
+	"iter"
+)
+
+// Row は Rows.All などで繰り返す結果の1行です。次の行に進むまで有効です。
+type Row struct {
+	rows *Rows
+}
+
+// Columns は結果の列の名前を返します。
+func (r Row) Columns() []string { return r.rows.Columns() }
+
+// Values は行の値を Rows.Values と同じ Go の値にして返します。
+func (r Row) Values() []any { return r.rows.Values() }
+
+// Scan は行の値を Rows.Scan と同じように dest に読み込みます。
+func (r Row) Scan(dest ...any) error { return r.rows.Scan(dest...) }
+
+// All は残りの行を range で繰り返すイテレータを返します。読んでいる途中でエラーになると、
+// 最後にそのエラーを1回だけ返して終わります。繰り返しを終えるか break で抜けると Rows を閉じます。
+//
+//	for row, err := range rows.All() {
+//		if err != nil {
+//			return err
+//		}
+//		var id int64
+//		if err := row.Scan(&id); err != nil {
+//			return err
+//		}
+//	}
+func (r *Rows) All() iter.Seq2[Row, error] {
+	return func(yield func(Row, error) bool) {
+		defer r.Close()
+		for r.Next() {
+			if !yield(Row{rows: r}, nil) {
+				return
+			}
+		}
+		if err := r.Err(); err != nil {
+			yield(Row{}, err)
+		}
+	}
+}
+
+// Rows は QueryContext で問い合わせを実行し、結果の行を繰り返すイテレータを返します。
+// 問い合わせを始められなかった場合も、そのエラーを1回だけ返します。問い合わせは繰り返しを
+// 始めたときに実行するので、同じイテレータを繰り返すたびに実行し直します。
+func (db *DB) Rows(ctx context.Context, sql string, args ...any) iter.Seq2[Row, error] {
+	return queryRows(func() (*Rows, error) { return db.QueryContext(ctx, sql, args...) })
+}
+
+// Rows は DB.Rows と同じですが、トランザクションの中で問い合わせを実行します。
+func (tx *Tx) Rows(ctx context.Context, sql string, args ...any) iter.Seq2[Row, error] {
+	return queryRows(func() (*Rows, error) { return tx.QueryContext(ctx, sql, args...) })
+}
+
+// queryRows は query で始めた問い合わせの行を繰り返すイテレータを返します。
+func queryRows(query func() (*Rows, error)) iter.Seq2[Row, error] {
+	return func(yield func(Row, error) bool) {
+		rows, err := query()
+		if err != nil {
+			yield(Row{}, err)
+			return
+		}
+		rows.All()(yield)
+	}
+}
@@ -0,0 +1,101 @@
+// Package metrics はデータベースの動作を監視するための計測値の出力先を定義します。
+// データベースはページの入出力やコミット、ロックの待機、文の実行時間などを Sink に送ります。
+// Sink はアプリケーションの監視の仕組みに合わせて実装するか、Prometheus の形式で公開する
+// NewPrometheus を使います。
+//
+//	m := metrics.NewPrometheus()
+//	db, err := rdbms.Open("app.db", rdbms.Options{Metrics: m})
+//	...
+//	http.Handle("/metrics", m)
+package metrics
+
+// Sink は計測値の出力先です。名前は下の定数のどれかで、データベースは名前ごとに決まった種類の
+// メソッドだけを呼び出します。ページを読むたびに呼び出されることもあるので、複数のゴルーチンから
+// 同時に呼び出しても安全で、すぐに返らなければなりません。
+type Sink interface {
+	// Add はカウンタ name に delta（0 以上）を加えます。
+	Add(name string, delta float64)
+	// Set はゲージ name を value にします。
+	Set(name string, value float64)
+	// Observe はヒストグラム name に value を記録します。時間の単位は秒です。
+	Observe(name string, value float64)
+}
+
+// Discard は何も記録しない Sink です。
+var Discard Sink = discard{}
+
+type discard struct{}
+
+func (discard) Add(string, float64)     {}
+func (discard) Set(string, float64)     {}
+func (discard) Observe(string, float64) {}
+
+// データベースが送る計測値の名前です。
+const (
+	PageReads     = "rdbms_page_reads_total"       // トランザクションが読んだページ
+	PageCacheHits = "rdbms_page_cache_hits_total"  // そのうち、ファイルを読まずにメモリ上のページから返したもの
+	PageWrites    = "rdbms_page_writes_total"      // データベースファイルに書き込んだページ
+	WALBytes      = "rdbms_wal_bytes_total"        // WALに追記したバイト数
+	Commits       = "rdbms_commits_total"          // コミットしたトランザクション
+	Rollbacks     = "rdbms_rollbacks_total"        // ロールバックしたトランザクション（コミットに失敗したものを含む）
+	LockWaits     = "rdbms_lock_waits_total"       // すぐに付与されずに待ったロックの要求
+	Deadlocks     = "rdbms_deadlocks_total"        // デッドロックで拒否したロックの要求
+	LockTimeouts  = "rdbms_lock_timeouts_total"    // NOWAIT や時間切れで拒否したロックの要求
+	Statements    = "rdbms_statements_total"       // 実行した文
+	StatementErrs = "rdbms_statement_errors_total" // そのうち、エラーになったもの
+
+	ActiveTxns = "rdbms_active_transactions" // 実行中のトランザクション
+
+	LockWaitSeconds  = "rdbms_lock_wait_seconds"          // ロックを待った時間
+	CommitSeconds    = "rdbms_commit_duration_seconds"    // コミットにかかった時間
+	StatementSeconds = "rdbms_statement_duration_seconds" // 文の実行にかかった時間（問い合わせは結果を読み終えるまで）
+)
+
+// Kind は計測値の種類です。
+type Kind int
+
+const (
+	Counter Kind = iota
+	Gauge
+	Histogram
+)
+
+// String は Prometheus の TYPE に書く種類の名前を返します。
+func (k Kind) String() string {
+	switch k {
+	case Counter:
+		return "counter"
+	case Gauge:
+		return "gauge"
+	case Histogram:
+		return "histogram"
+	default:
+		return "untyped"
+	}
+}
+
+// Desc は計測値の説明です。
+type Desc struct {
+	Name string
+	Kind Kind
+	Help string
+}
+
+// All はデータベースが送る計測値の一覧です。
+var All = []Desc{
+	{PageReads, Counter, "Pages read by transactions."},
+	{PageCacheHits, Counter, "Page reads served from memory without reading the database file."},
+	{PageWrites, Counter, "Pages written to the database file."},
+	{WALBytes, Counter, "Bytes appended to the write-ahead log."},
+	{Commits, Counter, "Committed transactions."},
+	{Rollbacks, Counter, "Rolled back transactions, including failed commits."},
+	{LockWaits, Counter, "Lock requests that had to wait."},
+	{Deadlocks, Counter, "Lock requests rejected to break a deadlock."},
+	{LockTimeouts, Counter, "Lock requests rejected by NOWAIT or a lock timeout."},
+	{Statements, Counter, "Executed statements."},
+	{StatementErrs, Counter, "Statements that returned an error."},
+	{ActiveTxns, Gauge, "Transactions in progress."},
+	{LockWaitSeconds, Histogram, "Time spent waiting for locks."},
+	{CommitSeconds, Histogram, "Time spent committing transactions."},
+	{StatementSeconds, Histogram, "Time spent executing statements, including reading query results."},
+}
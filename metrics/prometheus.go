@@ -0,0 +1,167 @@
+package metrics
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+)
+
+// DefaultBuckets はヒストグラムの既定の区切りです（秒）。ページを1枚読む程度の短い時間から、
+// 長い問い合わせまでを数えられるようにしています。
+var DefaultBuckets = []float64{0.0001, 0.0005, 0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5, 10}
+
+// Prometheus は計測値をメモリ上に集計し、Prometheus のテキスト形式で公開する Sink です。
+// http.Handler を実装しているので、そのまま /metrics などに登録できます。
+// 1つのデータベースにつき1つ作ります。複数のデータベースで共有すると値が合算されます。
+type Prometheus struct {
+	buckets []float64
+
+	mu     sync.Mutex
+	values map[string]*value
+}
+
+// value は1つの計測値の集計です。
+type value struct {
+	kind   Kind
+	help   string
+	v      float64  // カウンタとゲージの値、ヒストグラムの合計
+	count  uint64   // ヒストグラムの記録の数
+	counts []uint64 // ヒストグラムの区切りごとの記録の数（累積ではない）
+}
+
+// NewPrometheus は DefaultBuckets を使う Prometheus を作成します。All の計測値は、
+// まだ記録がなくても 0 として公開します。
+func NewPrometheus() *Prometheus {
+	return NewPrometheusBuckets(DefaultBuckets)
+}
+
+// NewPrometheusBuckets はヒストグラムの区切り buckets（昇順）を指定して Prometheus を作成します。
+func NewPrometheusBuckets(buckets []float64) *Prometheus {
+	p := &Prometheus{buckets: slices.Clone(buckets), values: make(map[string]*value)}
+	for _, d := range All {
+		p.values[d.Name] = p.newValue(d.Kind, d.Help)
+	}
+	return p
+}
+
+func (p *Prometheus) newValue(kind Kind, help string) *value {
+	v := &value{kind: kind, help: help}
+	if kind == Histogram {
+		v.counts = make([]uint64, len(p.buckets))
+	}
+	return v
+}
+
+// get は name の集計を返します。All にない名前は、最初に呼び出されたメソッドの種類で作ります。
+func (p *Prometheus) get(name string, kind Kind) *value {
+	v, ok := p.values[name]
+	if !ok {
+		v = p.newValue(kind, "")
+		p.values[name] = v
+	}
+	return v
+}
+
+// Add はカウンタ name に delta を加えます。
+func (p *Prometheus) Add(name string, delta float64) {
+	p.mu.Lock()
+	p.get(name, Counter).v += delta
+	p.mu.Unlock()
+}
+
+// Set はゲージ name を value にします。
+func (p *Prometheus) Set(name string, value float64) {
+	p.mu.Lock()
+	p.get(name, Gauge).v = value
+	p.mu.Unlock()
+}
+
+// Observe はヒストグラム name に value を記録します。
+func (p *Prometheus) Observe(name string, value float64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	v := p.get(name, Histogram)
+	if v.kind != Histogram {
+		return
+	}
+	v.v += value
+	v.count++
+	if i, _ := slices.BinarySearch(p.buckets, value); i < len(p.buckets) {
+		v.counts[i]++
+	}
+}
+
+// WriteTo は計測値を名前の順に Prometheus のテキスト形式で w に書き込みます。
+func (p *Prometheus) WriteTo(w io.Writer) (int64, error) {
+	p.mu.Lock()
+	names := make([]string, 0, len(p.values))
+	for name := range p.values {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	vals := make([]value, len(names))
+	for i, name := range names {
+		vals[i] = *p.values[name]
+		vals[i].counts = slices.Clone(vals[i].counts)
+	}
+	p.mu.Unlock()
+
+	cw := &countWriter{w: w}
+	bw := bufio.NewWriter(cw)
+	for i, name := range names {
+		v := &vals[i]
+		if v.help != "" {
+			bw.WriteString("# HELP " + name + " " + v.help + "\n")
+		}
+		bw.WriteString("# TYPE " + name + " " + v.kind.String() + "\n")
+		if v.kind != Histogram {
+			bw.WriteString(name + " " + formatFloat(v.v) + "\n")
+			continue
+		}
+		var cum uint64
+		for j, le := range p.buckets {
+			cum += v.counts[j]
+			bw.WriteString(name + `_bucket{le="` + formatFloat(le) + `"} ` + strconv.FormatUint(cum, 10) + "\n")
+		}
+		bw.WriteString(name + `_bucket{le="+Inf"} ` + strconv.FormatUint(v.count, 10) + "\n")
+		bw.WriteString(name + "_sum " + formatFloat(v.v) + "\n")
+		bw.WriteString(name + "_count " + strconv.FormatUint(v.count, 10) + "\n")
+	}
+	err := bw.Flush()
+	return cw.n, err
+}
+
+// ServeHTTP は計測値を Prometheus のテキスト形式で返します。
+func (p *Prometheus) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	p.WriteTo(w)
+}
+
+// formatFloat は Prometheus のテキスト形式の数値を返します。
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	case math.IsNaN(f):
+		return "NaN"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// countWriter は書き込んだバイト数を数えます。
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countWriter) Write(b []byte) (int, error) {
+	n, err := c.w.Write(b)
+	c.n += int64(n)
+	return n, err
+}
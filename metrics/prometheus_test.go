@@ -0,0 +1,78 @@
+package metrics
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// TestPrometheus は、カウンタ、ゲージ、ヒストグラムを集計して Prometheus のテキスト形式で
+// 書き出すことを確かめます。
+func TestPrometheus(t *testing.T) {
+	p := NewPrometheusBuckets([]float64{0.1, 1})
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.Add(Commits, 1)
+		}()
+	}
+	wg.Wait()
+	p.Set(ActiveTxns, 3)
+	p.Set(ActiveTxns, 2)
+	for _, v := range []float64{0.05, 0.1, 0.5, 2} {
+		p.Observe(CommitSeconds, v)
+	}
+	p.Add("app_custom_total", 1.5)
+	p.Observe(Commits, 1) // カウンタへの Observe は無視する
+
+	var buf bytes.Buffer
+	n, err := p.WriteTo(&buf)
+	if err != nil || n != int64(buf.Len()) {
+		t.Fatalf("WriteTo = %d, %v, want %d bytes", n, err, buf.Len())
+	}
+	out := buf.String()
+	for _, want := range []string{
+		"# HELP rdbms_commits_total Committed transactions.\n# TYPE rdbms_commits_total counter\nrdbms_commits_total 10\n",
+		"# TYPE rdbms_active_transactions gauge\nrdbms_active_transactions 2\n",
+		`rdbms_commit_duration_seconds_bucket{le="0.1"} 2` + "\n" +
+			`rdbms_commit_duration_seconds_bucket{le="1"} 3` + "\n" +
+			`rdbms_commit_duration_seconds_bucket{le="+Inf"} 4` + "\n" +
+			"rdbms_commit_duration_seconds_sum 2.65\n" +
+			"rdbms_commit_duration_seconds_count 4\n",
+		"# TYPE app_custom_total counter\napp_custom_total 1.5\n",
+		"rdbms_page_reads_total 0\n", // 記録のない計測値も 0 として書く
+		`rdbms_lock_wait_seconds_bucket{le="+Inf"} 0` + "\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output does not contain\n%s\noutput:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "# HELP app_custom_total") {
+		t.Error("output has HELP for a metric without a description")
+	}
+	if i, j := strings.Index(out, "app_custom_total"), strings.Index(out, "rdbms_active_transactions"); i > j {
+		t.Error("metrics are not written in name order")
+	}
+
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	if rec.Body.String() != out {
+		t.Error("ServeHTTP wrote different output from WriteTo")
+	}
+}
+
+// TestKind は種類の名前を確かめます。
+func TestKind(t *testing.T) {
+	for k, want := range map[Kind]string{Counter: "counter", Gauge: "gauge", Histogram: "histogram", Kind(9): "untyped"} {
+		if got := k.String(); got != want {
+			t.Errorf("Kind(%d).String() = %q, want %q", int(k), got, want)
+		}
+	}
+}
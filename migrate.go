@@ -0,0 +1,34 @@
+package rdbms
+
+import "github.com/k-sml/go-rdbms/internal/engine"
+
+// Migration はスキーマを1つ新しい版に進める手順です。tx の中で CREATE TABLE などを実行します。
+// tx のコミットとロールバックは Migrate が行うので、手順の中では呼ばないでください。
+type Migration func(tx *Tx) error
+
+// Migrate は steps[i] を版 i+1 に進める手順として、データベースの版（UserVersion）より新しい手順を
+// 順に適用し、適用後の版を返します。各手順は版の更新と同じトランザクションで実行するので、手順が
+// エラーを返した場合はその手順の変更は残らず、版は直前に成功した手順のものになります。
+// データベースの版が steps より新しい場合はエラーになります。
+//
+//	version, err := db.Migrate([]rdbms.Migration{
+//		func(tx *rdbms.Tx) error {
+//			_, err := tx.Exec("CREATE TABLE users (id INT PRIMARY KEY, name TEXT)")
+//			return err
+//		},
+//		func(tx *rdbms.Tx) error {
+//			_, err := tx.Exec("ALTER TABLE users ADD COLUMN email TEXT")
+//			return err
+//		},
+//	})
+func (db *DB) Migrate(steps []Migration) (int64, error) {
+	es := make([]engine.Migration, len(steps))
+	for i, step := range steps {
+		es[i] = func(tx *engine.Tx) error { return step(&Tx{tx: tx}) }
+	}
+	return db.db.Migrate(es)
+}
+
+// UserVersion はアプリケーションが管理するスキーマの版を返します。Migrate で手順を適用するたびに
+// 1 つ増えます。新しいデータベースでは 0 です。
+func (db *DB) UserVersion() (int64, error) { return db.db.UserVersion() }
@@ -0,0 +1,86 @@
+package rdbms
+
+import (
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/k-sml/go-rdbms/internal/lock"
+	"github.com/k-sml/go-rdbms/internal/txn"
+)
+
+// TxInfo は実行中のトランザクションの状態です。長時間実行されているトランザクションや、
+// 他のトランザクションを止めているトランザクションを調べるために使います。
+type TxInfo struct {
+	ID        uint64 // Tx.ID と同じトランザクションID
+	Isolation Isolation
+	ReadOnly  bool
+	Start     time.Time
+	// SnapshotAge はスナップショットを取ってから他のトランザクションがコミットした回数です。
+	// 大きいほど、古いページを長く残させています（Snapshot と Serializable のみ意味を持ちます）。
+	SnapshotAge uint64
+	Locks       []LockInfo // 保持しているロック
+	Waiting     *LockWait  // 待っているロック（待っていなければ nil）
+}
+
+// Age は開始からの経過時間を返します。
+func (i TxInfo) Age() time.Duration { return time.Since(i.Start) }
+
+// LockInfo は保持しているロックです。
+type LockInfo struct {
+	// Resource はロックの対象です。テーブルなら名前、行なら "t(1,2)" のようにテーブルと行の位置、
+	// データベースへの書き込み権なら "<writer>" です。
+	Resource string
+	Mode     string // ロックの種類（S、X、IS、IX、SIX）
+}
+
+// LockWait は待っているロックの要求です。
+type LockWait struct {
+	LockInfo
+	BlockedBy []uint64 // 待っている相手のトランザクションのID
+}
+
+// Transactions は実行中のトランザクションの一覧を、開始の古い順に返します。
+func (db *DB) Transactions() []TxInfo {
+	active := db.db.Transactions().Active()
+	out := make([]TxInfo, 0, len(active))
+	for _, tx := range active {
+		info := TxInfo{
+			ID:          tx.ID,
+			ReadOnly:    tx.ReadOnly,
+			Start:       tx.Start,
+			SnapshotAge: tx.SnapshotAge,
+			Locks:       make([]LockInfo, 0, len(tx.Locks)),
+		}
+		for iso, t := range isolations {
+			if t == tx.Isolation {
+				info.Isolation = iso
+			}
+		}
+		for r, mode := range tx.Locks {
+			info.Locks = append(info.Locks, lockInfo(r, mode))
+		}
+		slices.SortFunc(info.Locks, func(a, b LockInfo) int { return strings.Compare(a.Resource, b.Resource) })
+		if w := tx.Waiting; w != nil {
+			info.Waiting = &LockWait{LockInfo: lockInfo(w.Resource, w.Mode), BlockedBy: w.BlockedBy}
+		}
+		out = append(out, info)
+	}
+	return out
+}
+
+func lockInfo(r lock.Resource, mode lock.Mode) LockInfo {
+	return LockInfo{Resource: r.String(), Mode: mode.String()}
+}
+
+// TxHook はトランザクションが終わった後に呼ぶ関数です。txID は Tx.ID と同じトランザクションID、
+// tables はトランザクションが書き込みのためにロックしたテーブルです。
+type TxHook func(txID uint64, tables []string)
+
+// OnCommit はトランザクションのコミットが永続化された後に呼ぶ関数を登録します。関数はコミットした
+// ゴルーチンで、ロックを解放した後に登録した順に呼びます。キャッシュの無効化や変更の通知に使えます。
+func (db *DB) OnCommit(h TxHook) { db.db.Transactions().OnCommit(txn.Hook(h)) }
+
+// OnRollback はトランザクションがロールバックされた後に呼ぶ関数を登録します。コミットに失敗した
+// 場合も呼びます。
+func (db *DB) OnRollback(h TxHook) { db.db.Transactions().OnRollback(txn.Hook(h)) }
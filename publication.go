@@ -0,0 +1,44 @@
+package rdbms
+
+import (
+	"context"
+	"io"
+)
+
+// 論理レプリケーション
+//
+// Publish は選んだテーブルの行の変更を、1行に1つの JSON のメッセージで書き続けます。Subscribe で
+// 読むと、別のデータベースに同じテーブルを作って変更を適用し続けます。ストリーミングレプリケーション
+// （Replicate と Follow）がデータベース全体をページのまま写すのに対して、こちらは一部のテーブルだけを
+// 行として写すので、受け取る側は自分のテーブルを持つ通常の書き込めるデータベースのままです。
+// メッセージの形式は internal/engine/publication.go にまとめてあり、ほかの言語やシステムからも
+// 読めます。
+//
+//	// 公開する側
+//	go db.Publish(ctx, w, "users", "orders")
+//
+//	// 受け取る側: 切れたら接続し直して、もう一度 Subscribe する
+//	err := replica.Subscribe(ctx, r)
+//
+// 公開するテーブルには主キーがなければなりません。受け取る側は行を主キーで探して変更するので、
+// 受け取る側で作ったテーブルには主キーの一意のインデックスも作ります。レプリケーションは
+// 非同期で、受け取る側は接続するたびに公開されたテーブルの行をすべて受け取り直します。
+// minirdb serve は HTTP の API の GET /publication で公開し、-subscribe で受け取ります。
+
+// Publish は tables のテーブルの行の変更のストリームを w に書きます。ctx が取り消されるか、
+// w への書き込みに失敗するまで戻りません。最初にテーブルの定義と行をすべて書き、その後は
+// テーブルの行を変更したコミットごとに変更を書きます。受け取る側が遅れて、送るコミットを
+// 溜めきれなくなった場合もエラーで終わります。JournalWAL で書き込めるデータベースでなければ
+// ErrNotLeader を返します。
+func (db *DB) Publish(ctx context.Context, w io.Writer, tables ...string) error {
+	return db.db.Publish(ctx, w, tables)
+}
+
+// Subscribe は r から Publish のストリームを読み、データベースに適用し続けます。ctx が取り消されるか、
+// r からの読み込みに失敗するまで戻りません。r が io.Closer なら、ctx が取り消されたときに閉じます。
+//
+// ないテーブルは作り、あるテーブルには足りない列を追加します。最初に受け取るテーブルの行で
+// テーブルの行をすべて置き換え、その後はコミットごとに1つのトランザクションで変更を適用します。
+func (db *DB) Subscribe(ctx context.Context, r io.Reader) error {
+	return db.db.Subscribe(ctx, r)
+}
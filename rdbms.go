@@ -0,0 +1,286 @@
+// Package rdbms はデータベースエンジンをアプリケーションに組み込んで使うための API です。
+// internal 以下のパッケージはモジュールの外から import できないので、アプリケーションは
+// このパッケージを通してデータベースを開き、SQL 文を実行します。
+//
+//	db, err := rdbms.Open("app.db", rdbms.Options{Journal: rdbms.JournalWAL})
+//	if err != nil {
+//		return err
+//	}
+//	defer db.Close()
+//	if _, err := db.Exec("INSERT INTO users VALUES (?, ?)", 1, "alice"); err != nil {
+//		return err
+//	}
+//	rows, err := db.Query("SELECT id, name FROM users WHERE id > ?", 0)
+//	if err != nil {
+//		return err
+//	}
+//	defer rows.Close()
+//	for rows.Next() {
+//		vals := rows.Values() // int64, float64, string, []byte, bool, time.Time または nil
+//		...
+//	}
+//	return rows.Err()
+//
+// 結果の行は range で繰り返すこともできます。繰り返しを終えるか break で抜けると結果は閉じます。
+//
+//	for row, err := range db.Rows(ctx, "SELECT id, name FROM users") {
+//		if err != nil {
+//			return err
+//		}
+//		var id int64
+//		var name string
+//		if err := row.Scan(&id, &name); err != nil {
+//			return err
+//		}
+//		...
+//	}
+//
+// 分析のために結果を列ごとにまとめて読むときは、Rows.ArrowBatches で Apache Arrow の形式の
+// レコードバッチ（arrow パッケージ）にするか、Rows.WriteArrow で Arrow IPC のストリーム形式で書きます。
+//
+// DB は複数のゴルーチンから使えます。SQL の BEGIN と COMMIT でトランザクションを区切るときは、
+// DB.Conn でゴルーチンごとにセッション（Conn）を取り出して使います。
+//
+// 文の引数には nil, int, int32, int64, float32, float64, string, []byte, bool, time.Time を渡せます。
+//
+// ExecContext、QueryContext、BeginTx は context.Context を受け取ります。コンテキストが取り消されると、
+// ロックの待機や実行中の走査を止めて、コンテキストのエラー（context.Canceled など）を返します。
+package rdbms
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/k-sml/go-rdbms/internal/engine"
+	"github.com/k-sml/go-rdbms/internal/pager"
+	"github.com/k-sml/go-rdbms/internal/txn"
+	"github.com/k-sml/go-rdbms/metrics"
+)
+
+// JournalMode はコミットをどのように永続化するかを表します。
+type JournalMode int
+
+const (
+	// JournalNone は変更したページをそのままファイルに書きます。書いている途中で
+	// プロセスが落ちると、ファイルが壊れることがあります。
+	JournalNone JournalMode = iota
+	// JournalWAL は変更したページを先にWAL（ファイル名に -wal を付けたファイル）に記録してから
+	// データベースファイルに書きます。開き直したときに、WALからコミット済みの変更を復元します。
+	JournalWAL
+	// JournalShadow は変更したページを新しい位置に書き、ファイルの先頭のルートポインタを
+	// 切り替えてコミットします（シャドウページング）。ファイルの形式が変わるので、
+	// 作ったときと同じモードで開き続ける必要があります。
+	JournalShadow
+)
+
+// SyncMode はコミットのたびにファイルをどこまでディスクに同期するかを表します。
+type SyncMode int
+
+const (
+	// SyncFull はコミットのたびにファイルを同期します。既定の設定です。
+	SyncFull SyncMode = iota
+	// SyncNormal は JournalWAL でだけ使えます。コミットではWALだけを同期し、データベースファイルは
+	// チェックポイントで同期します。落ちてもWALから復旧できるので、コミットは失われません。
+	SyncNormal
+	// SyncOff は同期せずにOSに任せます。OSが落ちたり電源が切れたりすると、直前のコミットが
+	// 失われたりファイルが壊れたりすることがあります。
+	SyncOff
+)
+
+// syncModes は SyncMode に対応するページャーの同期の範囲です。
+var syncModes = map[SyncMode]pager.SyncMode{
+	SyncFull:   pager.SyncFull,
+	SyncNormal: pager.SyncNormal,
+	SyncOff:    pager.SyncOff,
+}
+
+// Options はデータベースを開く際の設定です。ゼロ値は既定の設定です。組み合わせられない設定は
+// Open がエラーにします。
+type Options struct {
+	PageSize  int         // 新しいファイルのページのサイズ（0 なら 4096、512 の倍数）
+	Journal   JournalMode // コミットの永続化方式
+	Sync      SyncMode    // コミットでファイルを同期する範囲（ReadOnly では指定できない）
+	ReadOnly  bool        // 書き込みを拒否する
+	CacheSize int         // メモリに置いておくページの数（0 なら 2000、負ならキャッシュしない）
+	// BatchSize は問い合わせの演算子がまとめて処理する行の数です。0 なら 1024 で、1 なら1行ずつ
+	// 処理します。
+	BatchSize int
+	// Parallel は1つのテーブルを並列に読むゴルーチンの数（並列度）の上限です。大きなテーブルの走査を
+	// 分けて読み、Gather で1つにまとめます。1 以下なら並列に読みません。
+	Parallel int
+	// Follower はデータベースをストリーミングレプリケーションのフォロワーとして開きます
+	// （replication.go）。Journal は JournalWAL にし、ReadOnly は指定できません。
+	Follower bool
+
+	// BusyTimeout は、TxOptions で LockTimeout と NoWait を指定しないトランザクションが
+	// ロックを待つ時間の上限です。時間を過ぎると ErrLocked の種類のエラーを返します。
+	// 0 なら無期限に待ちます。
+	BusyTimeout time.Duration
+	// BusyHandler はロックを取れなかったときに、もう一度要求するかを決める関数です（busy.go）。
+	BusyHandler BusyHandler
+	// MaxActiveConns は同時に使える Conn の数の上限です。上限に達すると DB.Conn は Conn が閉じられるまで
+	// 待ちます。0 なら上限はありません。閉じた Conn を取っておいて使い回すことはしないので、
+	// 接続のプールではなく、同時に実行するセッションの数の制限です（conn.go）。
+	MaxActiveConns int
+
+	// Logger はWALからの復旧とチェックポイント、VACUUM、遅い文、ファイルの破損などの出来事を
+	// 記録するロガーです。nil なら何も記録しません。
+	Logger *slog.Logger
+	// SlowQuery は遅い文として Logger に記録する実行時間です。0 なら記録しません。
+	SlowQuery time.Duration
+	// Metrics はページの入出力、コミット、ロックの待機、文の実行時間などの計測値を送る先です。
+	// metrics.NewPrometheus を渡せば Prometheus の形式で公開できます。nil なら送りません。
+	Metrics metrics.Sink
+	// Hooks は文を実行する前と後に呼ぶ関数です（hook.go）。
+	Hooks Hooks
+}
+
+// DB は開いているデータベースです。複数のゴルーチンから使えます。
+type DB struct {
+	db    *engine.DB
+	conns chan struct{} // 使用中の Conn の数を MaxActiveConns までに抑える（nil なら上限なし）
+}
+
+// Memory は Open に渡すとメモリ上のデータベースを作るパスです。
+const Memory = engine.MemoryPath
+
+// Open はデータベースファイルを開きます。ファイルがなければ作ります。
+//
+// path が Memory（":memory:"）なら、ファイルを使わずにメモリ上にデータベースを作ります。SQL も
+// トランザクションもファイルと同じように使えますが、Close すると内容は捨てられ、Open するたびに
+// 別の空のデータベースになります。アプリケーションのテストを速く、ほかのテストと干渉せずに
+// 実行できます。Journal は JournalNone のままにし、ReadOnly は指定できません。
+func Open(path string, opts Options) (*DB, error) {
+	journal, ok := journalModes[opts.Journal]
+	if !ok {
+		return nil, fmt.Errorf("unknown journal mode: %d", opts.Journal)
+	}
+	sync, ok := syncModes[opts.Sync]
+	if !ok {
+		return nil, fmt.Errorf("unknown sync mode: %d", opts.Sync)
+	}
+	if opts.MaxActiveConns < 0 {
+		return nil, fmt.Errorf("invalid max active conns: %d", opts.MaxActiveConns)
+	}
+	db, err := engine.Open(path, engine.Options{
+		PageSize:    opts.PageSize,
+		Journal:     journal,
+		Sync:        sync,
+		ReadOnly:    opts.ReadOnly,
+		Follower:    opts.Follower,
+		CacheSize:   opts.CacheSize,
+		BatchSize:   opts.BatchSize,
+		Parallel:    opts.Parallel,
+		BusyTimeout: opts.BusyTimeout,
+		BusyHandler: txn.BusyHandler(opts.BusyHandler),
+		Logger:      opts.Logger,
+		SlowQuery:   opts.SlowQuery,
+		Metrics:     opts.Metrics,
+		Hooks:       opts.Hooks.engine(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	d := &DB{db: db}
+	if opts.MaxActiveConns > 0 {
+		d.conns = make(chan struct{}, opts.MaxActiveConns)
+	}
+	return d, nil
+}
+
+// journalModes は JournalMode に対応するページャーの永続化方式です。
+var journalModes = map[JournalMode]pager.JournalMode{
+	JournalNone:   pager.JournalNone,
+	JournalWAL:    pager.JournalWAL,
+	JournalShadow: pager.JournalShadow,
+}
+
+// Close はデータベースを閉じます。
+func (db *DB) Close() error { return db.db.Close() }
+
+// Checkpoint はWALを空にして、WALのファイルが大きくなり続けないようにします。コミット済みの変更は
+// データベースファイルに書き込み済みなので失われません。JournalWAL 以外では何もしません。
+func (db *DB) Checkpoint() error { return db.db.Checkpoint() }
+
+// Stats はデータベースを開いてからの入出力、トランザクション、ロックの統計です。
+type Stats struct {
+	PageSize           int
+	PageReads          uint64 // トランザクションが読んだページ
+	CachedReads        uint64 // そのうち、ファイルを読まずにメモリ上のページから返したもの
+	CacheHits          uint64 // ページャーがファイルを読まずにページキャッシュから返したページ
+	PageWrites         uint64 // 書き込んだページ
+	FileWrites         uint64 // データベースファイルに書き込んだページ
+	WALBytes           uint64 // WALに追記したバイト数
+	ActiveTransactions int    // 実行中のトランザクション
+	Commits            uint64 // コミットしたトランザクション
+	Rollbacks          uint64 // ロールバックしたトランザクション（コミットに失敗したものを含む）
+	LocksGranted       int    // 付与されているロック
+	LocksWaiting       int    // 待っているロックの要求
+	LockWaits          uint64 // すぐに付与されずに待ったロックの要求の累計
+	Deadlocks          uint64 // デッドロックで拒否したロックの要求の累計
+	LockTimeouts       uint64 // NOWAIT や時間切れで拒否したロックの要求の累計
+}
+
+// Stats はデータベースを開いてからの統計を返します。
+func (db *DB) Stats() Stats {
+	st := db.db.Stats()
+	return Stats{
+		PageSize:           st.PageSize,
+		PageReads:          st.Txn.PageReads,
+		CachedReads:        st.Txn.CachedReads,
+		CacheHits:          st.Pager.CacheHits,
+		PageWrites:         st.Pager.Writes,
+		FileWrites:         st.Pager.FileWrites,
+		WALBytes:           st.Pager.WALBytes,
+		ActiveTransactions: st.Txn.Active,
+		Commits:            st.Txn.Commits,
+		Rollbacks:          st.Txn.Rollbacks,
+		LocksGranted:       st.Locks.Granted,
+		LocksWaiting:       st.Locks.Waiting,
+		LockWaits:          st.Locks.Waits,
+		Deadlocks:          st.Locks.Deadlocks,
+		LockTimeouts:       st.Locks.Timeouts,
+	}
+}
+
+// Exec は新しいトランザクションで結果の行を返さない SQL 文を実行し、エラーがなければコミットします。
+// 変更した行の数を返します。args は文の中の引数（? と $1 など）の値です。
+func (db *DB) Exec(sql string, args ...any) (int64, error) {
+	return db.ExecContext(context.Background(), sql, args...)
+}
+
+// ExecContext は Exec と同じですが、ctx が取り消されるとロックの待機や走査を止めてロールバックし、
+// ctx のエラー（context.Canceled など）を返します。
+func (db *DB) ExecContext(ctx context.Context, sql string, args ...any) (int64, error) {
+	return db.db.ExecContext(ctx, sql, args...)
+}
+
+// Query は読み取り専用の新しいトランザクションで SELECT 文を実行します。トランザクションは
+// 実行を始めた時点のコミット済みの状態を読み、Rows を閉じたときに終わります。
+func (db *DB) Query(sql string, args ...any) (*Rows, error) {
+	return db.QueryContext(context.Background(), sql, args...)
+}
+
+// QueryContext は Query と同じですが、ctx が取り消されると結果の行を読むのを止め、
+// Rows.Err が ctx のエラーを返します。
+func (db *DB) QueryContext(ctx context.Context, sql string, args ...any) (*Rows, error) {
+	return query(ctx, db.db.BeginTx, txn.Options{ReadOnly: true}, sql, args)
+}
+
+// query は begin で opts のトランザクションを始めて問い合わせを実行します。トランザクションは
+// Rows を閉じたときに終わります。
+func query(ctx context.Context, begin func(context.Context, txn.Options) (*engine.Tx, error), opts txn.Options,
+	sql string, args []any) (*Rows, error) {
+	tx, err := begin(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := tx.QueryContext(ctx, sql, args...)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	return &Rows{rows: rows, tx: tx}, nil
+}
@@ -0,0 +1,54 @@
+package rdbms
+
+import (
+	"context"
+	"io"
+)
+
+// ストリーミングレプリケーション
+//
+// リーダー（JournalWAL で書き込めるデータベース）の Replicate が書くストリームを、フォロワー
+// （Options.Follower で開いたデータベース）の Follow で読むと、フォロワーはリーダーのコミットを
+// 順に適用し続けます。ストリームは最初にリーダーのデータベース全体のスナップショットを送り、
+// その後はコミットのたびにWALのレコードを送ります。フォロワーは読み取りだけを受け付けるので、
+// 読み取りの負荷を分けるのに使えます。リーダーが止まったときは、フォロワーを Promote で昇格して
+// 書き込みを受け付けるようにします。
+//
+//	// リーダー: 接続してきたフォロワーごとに
+//	go db.Replicate(ctx, conn)
+//
+//	// フォロワー
+//	replica, err := rdbms.Open("replica.db", rdbms.Options{Journal: rdbms.JournalWAL, Follower: true})
+//	...
+//	err = replica.Follow(ctx, conn) // 切れたら接続し直して、もう一度 Follow する
+//	...
+//	err = replica.Promote() // リーダーが止まったら
+//
+// レプリケーションは非同期です。リーダーはフォロワーに届くのを待たずにコミットを終えるので、
+// リーダーが落ちると、フォロワーに届いていない直前のコミットは昇格したフォロワーにはありません。
+// minirdb serve -follow は、この仕組みをサーバーどうしの接続で使います。
+
+// Replicate はフォロワーへのストリームを w に書きます。ctx が取り消されるか、w への書き込みに
+// 失敗するまで戻りません。フォロワーが遅れてリーダーが送るコミットを溜めきれなくなった場合も
+// エラーで終わるので、フォロワーは接続し直してスナップショットから受け取り直します。1つの DB から
+// 複数のフォロワーに同時に送れます。JournalWAL で書き込めるデータベースでなければ ErrNotLeader を
+// 返します。
+func (db *DB) Replicate(ctx context.Context, w io.Writer) error {
+	return db.db.Replicate(ctx, w)
+}
+
+// Follow は r からリーダーのストリームを読み、データベースに適用し続けます。ctx が取り消されるか、
+// r からの読み込みに失敗するまで戻りません。r が io.Closer（net.Conn など）なら、ctx が
+// 取り消されたときに閉じます。Promote で止めた場合は nil を返します。フォロワーとして開いて
+// いなければ ErrNotFollower を返します。
+//
+// 最初にリーダーのデータベース全体を受け取って内容を置き換えます。受け取っている間は読み取りを
+// 待たせます。
+func (db *DB) Follow(ctx context.Context, r io.Reader) error {
+	return db.db.Follow(ctx, r)
+}
+
+// Promote はフォロワーを昇格して、書き込みを受け付けるようにします。実行中の Follow は止め、
+// それまでに受け取ったコミットまでを反映したデータベースになります。昇格した後は、
+// JournalWAL で開いた通常のデータベースと同じです。
+func (db *DB) Promote() error { return db.db.Promote() }
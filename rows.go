@@ -0,0 +1,47 @@
+package rdbms
+
+import "github.com/k-sml/go-rdbms/internal/engine"
+
+// Rows は問い合わせの結果です。Next で1行ずつ進め、読み終えたら Close します。
+// 行は Next を呼ぶたびに読むので、結果をすべてメモリに持つことはありません。
+type Rows struct {
+	rows *engine.Rows
+	tx   *engine.Tx // DB.Query が始めたトランザクション（閉じたときに終える）
+	vals []any
+}
+
+// Columns は結果の列の名前を返します。
+func (r *Rows) Columns() []string { return r.rows.Columns() }
+
+// Next は次の行に進みます。行がなくなるかエラーになると false を返します。
+func (r *Rows) Next() bool {
+	if r.rows.Next() {
+		return true
+	}
+	r.Close()
+	return false
+}
+
+// Values は現在の行の値を、列の順に Go の値（int64, float64, string, []byte, bool,
+// time.Time、NULL なら nil）にして返します。スライスと []byte の値は次の Next の呼び出しまで有効です。
+func (r *Rows) Values() []any {
+	row := r.rows.Values()
+	r.vals = r.vals[:0]
+	for _, v := range row {
+		r.vals = append(r.vals, v.Go())
+	}
+	return r.vals
+}
+
+// Err は読んでいる途中で起きたエラーを返します。
+func (r *Rows) Err() error { return r.rows.Err() }
+
+// Close は結果を閉じます。何度呼んでもかまいません。
+func (r *Rows) Close() error {
+	err := r.rows.Close()
+	if r.tx != nil {
+		r.tx.Rollback()
+		r.tx = nil
+	}
+	return err
+}
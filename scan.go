@@ -0,0 +1,71 @@
+package rdbms
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+
+	"github.com/k-sml/go-rdbms/internal/scan"
+	"github.com/k-sml/go-rdbms/internal/types"
+)
+
+// Scanner は Rows.Scan の書き込み先として、列の値を自分で解釈する型が実装するインターフェースです。
+// src は Rows.Values と同じ Go の値（int64, float64, string, []byte, bool, time.Time または nil）です。
+// []byte の src は次の Next の呼び出しまでしか有効でないので、取っておくならコピーしてください。
+type Scanner interface {
+	Scan(src any) error
+}
+
+// ColumnType は結果の列の情報です。
+type ColumnType struct {
+	Name string
+	// DatabaseTypeName は列の SQL の型（INT、TEXT など）です。型の決まらない式の列なら空です。
+	DatabaseTypeName string
+	// ScanType は列の値を Values で受け取るときの Go の型です。型の決まらない式の列なら any です。
+	ScanType reflect.Type
+}
+
+// ColumnTypes は結果の列の情報を返します。
+func (r *Rows) ColumnTypes() []ColumnType {
+	return columnTypes(r.rows.Columns(), r.rows.ColumnTypes())
+}
+
+// columnTypes は名前が names で型が typs の列の情報を返します。
+func columnTypes(names []string, typs []types.Type) []ColumnType {
+	cts := make([]ColumnType, len(names))
+	for i, name := range names {
+		cts[i] = ColumnType{Name: name, ScanType: reflect.TypeFor[any]()}
+		if t, ok := scan.GoType(typs[i]); ok {
+			cts[i].DatabaseTypeName, cts[i].ScanType = typs[i].String(), t
+		}
+	}
+	return cts
+}
+
+// 値の変換
+//
+// Scan は列の値を書き込み先の Go の型に変換する（internal/scan。client.Rows.Scan も同じ規則を使う）。整数は整数型と浮動小数点数型に（範囲に
+// 収まらなければエラー）、整数値の実数は整数型に、数値、真偽値、日時は文字列に変換でき、
+// 文字列は SQL の CAST と同じ規則で数値、真偽値、日時に変換できる。NULL は *any、*[]byte、
+// ポインタへのポインタ（**int64 など。nil にする）、Scanner にだけ書き込める。
+
+// Scan は現在の行の値を、列の順に dest の指す変数に書き込みます。dest の数は列の数と同じでなければ
+// なりません。書き込み先には *int64 などの整数型、*float64、*string、*[]byte、*bool、*time.Time、
+// *any、それらのポインタへのポインタ（NULL なら nil にする）と Scanner を使えます。
+// *[]byte に書き込む値はコピーなので、次の Next の後も使えます。
+func (r *Rows) Scan(dest ...any) error {
+	row := r.rows.Values()
+	if row == nil {
+		return errors.New("Scan called without a successful Next")
+	}
+	if len(dest) != len(row) {
+		return fmt.Errorf("expected %d destination arguments in Scan, not %d", len(row), len(dest))
+	}
+	cols := r.rows.Columns()
+	for i, v := range row {
+		if err := scan.Value(dest[i], v); err != nil {
+			return fmt.Errorf("scan column %d (%s): %w", i, cols[i], err)
+		}
+	}
+	return nil
+}
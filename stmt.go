@@ -0,0 +1,106 @@
+package rdbms
+
+import (
+	"context"
+
+	"github.com/k-sml/go-rdbms/internal/engine"
+	"github.com/k-sml/go-rdbms/internal/exec"
+	"github.com/k-sml/go-rdbms/internal/txn"
+	"github.com/k-sml/go-rdbms/internal/types"
+)
+
+// Stmt は Conn.Prepare で準備した文です。準備するときに SQL を解析するので、構文の誤りは
+// Prepare がエラーにします。実行はセッションの中で行い、BEGIN から COMMIT までは
+// セッションのトランザクションの中で実行します。実行計画は DB が文ごとに使い回します。
+type Stmt struct {
+	conn *Conn
+	s    *engine.Stmt
+}
+
+// Prepare は SQL 文を解析して、このセッションで実行する Stmt を返します。
+func (c *Conn) Prepare(sql string) (*Stmt, error) {
+	if c.closed {
+		return nil, ErrConnDone
+	}
+	s, err := c.db.db.Prepare(sql)
+	if err != nil {
+		return nil, err
+	}
+	return &Stmt{conn: c, s: s}, nil
+}
+
+// SQL は文の SQL を返します。
+func (s *Stmt) SQL() string { return s.s.SQL() }
+
+// NumParams は文の引数（? と $1 など）の数を返します。
+func (s *Stmt) NumParams() int { return s.s.NumParams() }
+
+// ReturnsRows は文が結果の行を返す文（SELECT、EXPLAIN、PRAGMA）かを返します。そうなら
+// QueryContext で、そうでなければ ExecContext で実行します。
+func (s *Stmt) ReturnsRows() bool { return s.s.IsQuery() }
+
+// ParamTypes は文の引数の SQL の型の名前（BIGINT、TEXT など）を、引数を使う場所から推論して
+// 返します。列と比べる引数と、INSERT や UPDATE で列に代入する引数はその列の型になります。
+// 推論できない引数は空文字列です。
+func (s *Stmt) ParamTypes() ([]string, error) {
+	var typs []types.Type
+	err := s.describe(func(tx *engine.Tx) (err error) {
+		typs, err = tx.ParamTypes(s.s)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, len(typs))
+	for i, t := range typs {
+		if t != types.Null {
+			names[i] = t.String()
+		}
+	}
+	return names, nil
+}
+
+// ColumnTypes は文を実行せずに、結果の列の情報を返します。結果の行を返さない文なら nil です。
+func (s *Stmt) ColumnTypes() ([]ColumnType, error) {
+	var cols []exec.Column
+	err := s.describe(func(tx *engine.Tx) (err error) {
+		cols, err = tx.Columns(s.s)
+		return err
+	})
+	if err != nil || cols == nil {
+		return nil, err
+	}
+	names := make([]string, len(cols))
+	typs := make([]types.Type, len(cols))
+	for i, c := range cols {
+		names[i], typs[i] = c.Name, c.Type
+	}
+	return columnTypes(names, typs), nil
+}
+
+// describe はスキーマを読むトランザクションで fn を呼びます。BEGIN から COMMIT までは、
+// そのトランザクションで変えたスキーマを読みます。
+func (s *Stmt) describe(fn func(tx *engine.Tx) error) error {
+	if s.conn.closed {
+		return ErrConnDone
+	}
+	if s.conn.tx != nil {
+		return fn(s.conn.tx.tx)
+	}
+	tx, err := s.conn.session.BeginTx(context.Background(), txn.Options{ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	return fn(tx)
+}
+
+// ExecContext は Conn.ExecContext と同じように文を実行し、変更した行の数を返します。
+func (s *Stmt) ExecContext(ctx context.Context, args ...any) (int64, error) {
+	return s.conn.ExecContext(ctx, s.s.SQL(), args...)
+}
+
+// QueryContext は Conn.QueryContext と同じように問い合わせを実行し、結果の行を返します。
+func (s *Stmt) QueryContext(ctx context.Context, args ...any) (*Rows, error) {
+	return s.conn.QueryContext(ctx, s.s.SQL(), args...)
+}
@@ -0,0 +1,160 @@
+package rdbms
+
+import (
+	"context"
+	"time"
+
+	"github.com/k-sml/go-rdbms/internal/engine"
+	"github.com/k-sml/go-rdbms/internal/txn"
+)
+
+// Isolation はトランザクションの分離レベルです。
+type Isolation int
+
+const (
+	// Locking は既定の分離レベルです。最新のコミット済みの状態を読み、行やテーブルのロックで
+	// ほかのトランザクションとの干渉を防ぎます。
+	Locking Isolation = iota
+	// Snapshot はスナップショット分離です。開始した時点のコミット済みの状態を読み、
+	// 同じページをほかのトランザクションが先に書き換えていればコミットに失敗します。
+	Snapshot
+	// Serializable は直列化可能なスナップショット分離です。直列化できない実行になりそうなときは
+	// トランザクションを中断します。
+	Serializable
+	// Optimistic は楽観的並行性制御です。ロックを取らずに読み、コミットするときに
+	// 読んだページと書くページがほかのトランザクションに書き換えられていないかを確かめます。
+	Optimistic
+)
+
+// isolations は Isolation に対応する分離レベルです。
+var isolations = map[Isolation]txn.Isolation{
+	Locking:      txn.Locking,
+	Snapshot:     txn.Snapshot,
+	Serializable: txn.Serializable,
+	Optimistic:   txn.Optimistic,
+}
+
+// TxOptions はトランザクションを開始する際の設定です。ゼロ値は既定の設定です。
+type TxOptions struct {
+	Isolation   Isolation
+	ReadOnly    bool          // 読み取り専用のトランザクションにする
+	LockTimeout time.Duration // ロックを待つ時間の上限（0 なら Options.BusyTimeout）
+	NoWait      bool          // ロックをすぐに取れなければ待たずに ErrLockNotAvailable にする
+}
+
+// Tx はトランザクションです。1つのゴルーチンから使い、Commit か Rollback で終えます。
+type Tx struct {
+	tx     *engine.Tx
+	nested *engine.Nested // Begin で始めた入れ子のトランザクションなら、そのセーブポイント
+}
+
+// Begin はトランザクションを開始します。
+func (db *DB) Begin(opts TxOptions) (*Tx, error) {
+	return db.BeginTx(context.Background(), opts)
+}
+
+// BeginTx は ctx を使うトランザクションを開始します。ctx が取り消されると、実行中の文は
+// ロックの待機や走査を止め、それ以降の文と Commit は ctx のエラーで失敗します。
+// Commit が失敗したトランザクションはロールバックされています。
+func (db *DB) BeginTx(ctx context.Context, opts TxOptions) (*Tx, error) {
+	tx, err := db.db.BeginTx(ctx, opts.txn())
+	if err != nil {
+		return nil, err
+	}
+	return &Tx{tx: tx}, nil
+}
+
+// txn は opts に対応する下位のトランザクションの設定を返します。
+func (opts TxOptions) txn() txn.Options {
+	return txn.Options{
+		Isolation:   isolations[opts.Isolation],
+		ReadOnly:    opts.ReadOnly,
+		LockTimeout: opts.LockTimeout,
+		NoWait:      opts.NoWait,
+	}
+}
+
+// Exec はトランザクションの中で結果の行を返さない SQL 文を実行し、変更した行の数を返します。
+// 文がエラーになった場合は、その文の変更だけを取り消して 0 を返します。トランザクションは
+// 続けられ、それまでの文の変更は残ります。
+func (tx *Tx) Exec(sql string, args ...any) (int64, error) {
+	return tx.ExecContext(context.Background(), sql, args...)
+}
+
+// ExecContext は Exec と同じですが、ctx が取り消されるとロックの待機や走査を止め、
+// その文の変更を取り消して ctx のエラーを返します。
+func (tx *Tx) ExecContext(ctx context.Context, sql string, args ...any) (int64, error) {
+	if tx.nestedDone() {
+		return 0, ErrTxDone
+	}
+	return tx.tx.ExecContext(ctx, sql, args...)
+}
+
+// Query はトランザクションの中で SELECT 文を実行します。Rows を閉じてもトランザクションは続きます。
+func (tx *Tx) Query(sql string, args ...any) (*Rows, error) {
+	return tx.QueryContext(context.Background(), sql, args...)
+}
+
+// QueryContext は Query と同じですが、ctx が取り消されると結果の行を読むのを止め、
+// Rows.Err が ctx のエラーを返します。
+func (tx *Tx) QueryContext(ctx context.Context, sql string, args ...any) (*Rows, error) {
+	if tx.nestedDone() {
+		return nil, ErrTxDone
+	}
+	rows, err := tx.tx.QueryContext(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	return &Rows{rows: rows}, nil
+}
+
+// Begin はトランザクションの中に入れ子のトランザクションを開始します。入れ子のトランザクションは
+// セーブポイントで、文は外側と同じトランザクションの中で実行します。Rollback は Begin してからの
+// 変更（行、スキーマ、シーケンスの値）だけを取り消し、Commit は変更を外側のトランザクションに
+// 引き継ぎます。変更を永続化するのは一番外側のトランザクションの Commit です。入れ子の中で取った
+// ロックは、外側のトランザクションが終わるまで保持します。
+//
+//	inner, err := tx.Begin()
+//	if err != nil {
+//		return err
+//	}
+//	if _, err := inner.Exec("INSERT INTO audit VALUES (?)", msg); err != nil {
+//		inner.Rollback() // audit への挿入だけを取り消し、tx は続ける
+//	} else if err := inner.Commit(); err != nil {
+//		return err
+//	}
+func (tx *Tx) Begin() (*Tx, error) {
+	if tx.nestedDone() {
+		return nil, ErrTxDone
+	}
+	n, err := tx.tx.Begin()
+	if err != nil {
+		return nil, err
+	}
+	return &Tx{tx: tx.tx, nested: n}, nil
+}
+
+// nestedDone は入れ子のトランザクションを Commit か Rollback で終えたかを返します。外側の
+// トランザクションとして使っている engine.Tx はまだ続いているので、ここで確かめます。
+func (tx *Tx) nestedDone() bool { return tx.nested != nil && tx.nested.Done() }
+
+// Commit はトランザクションをコミットします。入れ子のトランザクションなら、変更を外側の
+// トランザクションに引き継ぎます。
+func (tx *Tx) Commit() error {
+	if tx.nested != nil {
+		return tx.nested.Commit()
+	}
+	return tx.tx.Commit()
+}
+
+// Rollback はトランザクションをロールバックします。入れ子のトランザクションなら、Begin してからの
+// 変更だけを取り消します。
+func (tx *Tx) Rollback() error {
+	if tx.nested != nil {
+		return tx.nested.Rollback()
+	}
+	return tx.tx.Rollback()
+}
+
+// ID はトランザクションIDを返します。DB.Transactions と OnCommit、OnRollback の関数に渡す ID と同じです。
+func (tx *Tx) ID() uint64 { return tx.tx.Txn().ID() }
@@ -0,0 +1,32 @@
+package rdbms
+
+import (
+	"context"
+
+	"github.com/k-sml/go-rdbms/internal/catalog"
+)
+
+// Authenticate はデータベースに CREATE USER で作ったユーザーのユーザー名とパスワードを確かめ、
+// GRANT で読み取りだけを許可したユーザーなら readOnly を true にして返します。サーバーが接続を
+// 受け付けるときに使います。ユーザーがいないかパスワードが違えば ErrAuth を、READ も WRITE も
+// 許可していなければ ErrNoAccess の種類のエラーを返します。
+//
+// ユーザーは SQL で管理します。パスワードはソルトを付けたハッシュで保存し、__schema.users で
+// ユーザーの名前と許可した操作（none、read、write）を読めます。
+//
+//	CREATE USER alice PASSWORD 'secret';
+//	GRANT READ TO alice;       -- 読み取りだけ
+//	GRANT WRITE TO alice;      -- 読み書き（ユーザーの管理を含む）
+//	REVOKE WRITE FROM alice;   -- 読み取りだけに戻す
+//	ALTER USER alice PASSWORD 'new secret';
+//	DROP USER alice;
+func (db *DB) Authenticate(ctx context.Context, user, password string) (readOnly bool, err error) {
+	a, err := db.db.Authenticate(ctx, user, password)
+	if err != nil {
+		return false, err
+	}
+	return a == catalog.ReadAccess, nil
+}
+
+// HasUsers はデータベースに CREATE USER で作ったユーザーがいるかを返します。
+func (db *DB) HasUsers(ctx context.Context) (bool, error) { return db.db.HasUsers(ctx) }